@@ -0,0 +1,123 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"sync/atomic"
+	"time"
+
+	"github.com/fep-fem/protocol"
+)
+
+// channelConnectTimeout bounds opening the channel and the broker's answer to
+// the heartbeat that identifies the agent on it
+const channelConnectTimeout = 15 * time.Second
+
+// runChannel holds a channel to the broker open until ctx is done, answering
+// the tool calls the broker pushes down it. The agent dials out, so the broker
+// reaches it behind NAT. A lost channel is reopened with backoff.
+func (a *Agent) runChannel(ctx context.Context, proxy protocol.ProxyFunc, config *tls.Config) {
+	policy := protocol.DefaultReconnectPolicy()
+	attempt := 0
+	for ctx.Err() == nil {
+		opened, err := a.serveChannel(ctx, proxy, config)
+		if opened {
+			attempt = 0
+		}
+		if ctx.Err() != nil {
+			return
+		}
+		attempt++
+		backoff := policy.Backoff(attempt)
+		log.Printf("Channel to broker lost: %v; reopening in %v", err, backoff.Round(time.Millisecond))
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(backoff):
+		}
+	}
+}
+
+// serveChannel opens a channel and answers calls on it until it closes,
+// reporting whether the broker accepted it
+func (a *Agent) serveChannel(ctx context.Context, proxy protocol.ProxyFunc, config *tls.Config) (bool, error) {
+	dialCtx, cancel := context.WithTimeout(ctx, channelConnectTimeout)
+	defer cancel()
+	channel, err := protocol.DialAgentChannel(dialCtx, a.BrokerURL, proxy, config)
+	if err != nil {
+		return false, err
+	}
+	defer channel.Close()
+	go func() {
+		<-ctx.Done()
+		channel.Close()
+	}()
+
+	// The broker knows the agent by the signed heartbeat opening the channel
+	heartbeat, err := a.newHeartbeat()
+	if err != nil {
+		return false, err
+	}
+	if err := channel.Send(heartbeat); err != nil {
+		return false, err
+	}
+	channel.SetReadDeadline(time.Now().Add(channelConnectTimeout))
+	answer, err := channel.ReadEnvelope()
+	if err != nil {
+		return false, err
+	}
+	if _, err := protocol.ParseResponse(mustMarshal(answer)); err != nil {
+		// Heartbeats re-register an agent the broker has forgotten
+		return false, fmt.Errorf("broker refused channel: %w", err)
+	}
+	channel.SetReadDeadline(time.Time{})
+	log.Printf("Channel to broker open; taking pushed tool calls")
+
+	for {
+		envelope, err := channel.ReadEnvelope()
+		if err != nil {
+			return true, err
+		}
+		switch envelope.Type {
+		case protocol.EnvelopeToolCall:
+			go a.answerPushedCall(channel, envelope)
+		case protocol.EnvelopeError:
+			var rejection *protocol.ErrorBody
+			if _, err := protocol.ParseResponse(mustMarshal(envelope)); errors.As(err, &rejection) {
+				log.Printf("Broker rejected an envelope sent on the channel: %v", rejection)
+			}
+		}
+	}
+}
+
+// answerPushedCall runs a call the broker pushed and sends its result back on
+// the channel
+func (a *Agent) answerPushedCall(channel *protocol.AgentChannel, envelope *protocol.Envelope) {
+	atomic.AddInt64(&a.inFlight, 1)
+	defer atomic.AddInt64(&a.inFlight, -1)
+
+	var call protocol.ToolCallEnvelope
+	if err := json.Unmarshal(mustMarshal(envelope), &call); err != nil {
+		log.Printf("Ignoring malformed pushed call: %v", err)
+		return
+	}
+	result, err := a.handleToolCall(&call)
+	if err == nil {
+		err = result.Sign(a.PrivKey)
+	}
+	if err == nil {
+		err = channel.Send(result)
+	}
+	if err != nil {
+		log.Printf("Failed to answer pushed call %s: %v", call.Body.RequestID, err)
+	}
+}
+
+func mustMarshal(v interface{}) []byte {
+	data, _ := json.Marshal(v)
+	return data
+}
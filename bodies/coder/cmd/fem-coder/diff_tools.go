@@ -0,0 +1,33 @@
+package main
+
+import (
+	"fmt"
+	"os/exec"
+
+	"github.com/fep-fem/protocol"
+)
+
+// handleGitDiff returns the uncommitted changes of a git work tree as a
+// protocol.DiffResult. Parameters: "path" of the work tree (default the
+// current directory), "staged" to diff the index instead, and "base" to diff
+// against a revision.
+func (a *Agent) handleGitDiff(params map[string]interface{}) (interface{}, error) {
+	path, _ := params["path"].(string)
+	if path == "" {
+		path = "."
+	}
+	args := []string{"-C", path, "diff", "--no-color", "--no-ext-diff", "-M"}
+	if staged, _ := params["staged"].(bool); staged {
+		args = append(args, "--cached")
+	}
+	if base, _ := params["base"].(string); base != "" {
+		args = append(args, base)
+	}
+	args = append(args, "--")
+
+	output, err := exec.Command("git", args...).CombinedOutput()
+	if err != nil {
+		return nil, fmt.Errorf("git diff failed: %w, output: %s", err, a.results.inline(output))
+	}
+	return protocol.ParseUnifiedDiff(string(output))
+}
@@ -2,27 +2,60 @@ package main
 
 import (
 	"bytes"
+	"context"
 	"crypto/ed25519"
 	"crypto/tls"
 	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
+	"io"
 	"log"
 	"net/http"
+	"os"
 	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"sync/atomic"
+	"syscall"
 	"time"
 
 	"github.com/fep-fem/protocol"
 )
 
 type Agent struct {
-	ID        string
-	BrokerURL string
-	PubKey    ed25519.PublicKey
-	PrivKey   ed25519.PrivateKey
-	client    *http.Client
-	mcpServer *http.Server
-	mcpPort   int
+	ID         string
+	BrokerURL  string
+	PubKey     ed25519.PublicKey
+	PrivKey    ed25519.PrivateKey
+	client     *http.Client
+	pins       *protocol.BrokerPins
+	mcpServer  *http.Server
+	mcpPort    int
+	instance   string // Replica ID when several fem-coders share the agent ID
+	startedAt  time.Time
+	inFlight   int64       // MCP requests currently being handled
+	registered atomic.Bool // Set while the broker knows this agent
+	results    *resultLimiter
+}
+
+// defaultPinFile keeps broker pins in the user's home directory
+func defaultPinFile() string {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "fem-known-brokers.json"
+	}
+	return filepath.Join(home, ".fem", "known_brokers.json")
+}
+
+// defaultKeyFile keeps the agent's signing key next to the broker pins, so the
+// agent keeps its identity across restarts
+func defaultKeyFile(agentID string) string {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return agentID + ".key"
+	}
+	return filepath.Join(home, ".fem", "keys", agentID+".key")
 }
 
 type ToolHandler func(params map[string]interface{}) (interface{}, error)
@@ -32,14 +65,86 @@ func main() {
 	brokerURL := flag.String("broker", "https://localhost:4433", "Broker URL to connect to")
 	agentID := flag.String("agent", "fem-coder-001", "Agent identifier")
 	mcpPort := flag.Int("mcp-port", 8080, "Port for MCP server to listen on")
+	pinFile := flag.String("pin-file", defaultPinFile(), "File recording pinned broker identity keys")
+	pinMode := flag.String("pin-mode", string(protocol.PinModeEnforce), "Action on broker key change: enforce or warn")
+	repinKey := flag.String("repin-broker", "", "Pin this base64 broker key before connecting (after a legitimate rotation)")
+	heartbeatInterval := flag.Duration("heartbeat-interval", 30*time.Second, "How often to send heartbeats to the broker (0 disables)")
+	keyFile := flag.String("key-file", "", "File holding the agent's signing key, created if missing (default ~/.fem/keys/<agent>.key)")
+	keyPassphraseFile := flag.String("key-passphrase-file", os.Getenv("FEM_KEY_PASSPHRASE_FILE"), "File holding the passphrase encrypting the signing key (FEM_KEY_PASSPHRASE if empty)")
+	instance := flag.String("instance", "", "Replica ID, for running several instances under one agent ID and key")
+	drainTimeout := flag.Duration("drain-timeout", time.Minute, "On SIGTERM, how long a replica waits for the broker to confirm it is drained (0 stops at once)")
+	mtls := flag.Bool("mtls", false, "Present a TLS client certificate bound to the signing key, for brokers run with --client-certs")
+	proxyURL := flag.String("proxy", os.Getenv("FEM_PROXY"), "HTTP or SOCKS5 proxy URL to reach the broker through, or \"direct\" (HTTPS_PROXY and NO_PROXY if empty)")
+	maxResultBytes := flag.Int("max-result-bytes", 256*1024, "Largest tool output returned inline; longer output keeps its head and tail and is stored in full as an artifact (0 disables)")
+	artifactDir := flag.String("artifact-dir", "", "Directory storing full outputs of truncated results (default ~/.fem/artifacts/<agent>)")
+	artifactRetention := flag.Duration("artifact-retention", 24*time.Hour, "How long full outputs are kept (0 keeps them)")
+	summarizer := flag.String("summarizer", "", "Shell command summarizing truncated output, given the full output on stdin")
+	summarizerTimeout := flag.Duration("summarizer-timeout", 30*time.Second, "How long the summarizer may run")
+	channel := flag.Bool("channel", true, "Hold a channel open to the broker so it can push tool calls, e.g. from behind NAT")
 	flag.Parse()
 
 	log.Printf("fem-coder starting - Agent ID: %s, Broker: %s, MCP Port: %d", *agentID, *brokerURL, *mcpPort)
 
-	// Generate key pair for this agent
-	pubKey, privKey, err := protocol.GenerateKeyPair()
+	// Load this agent's key pair, creating it on first start
+	if *keyFile == "" {
+		*keyFile = defaultKeyFile(*agentID)
+	}
+	passphrase, err := protocol.ReadPassphrase(*keyPassphraseFile)
+	if err != nil {
+		log.Fatalf("Failed to read key passphrase: %v", err)
+	}
+	if passphrase == nil {
+		log.Printf("No key passphrase set; the signing key is stored unencrypted")
+	}
+	privKey, created, err := protocol.LoadOrCreatePrivateKey(*keyFile, passphrase)
+	if err != nil {
+		log.Fatalf("Failed to load signing key: %v", err)
+	}
+	if created {
+		log.Printf("Created signing key %s", *keyFile)
+	}
+	pubKey := privKey.Public().(ed25519.PublicKey)
+
+	pins := protocol.NewBrokerPins(protocol.NewFilePinStore(*pinFile), protocol.PinMode(*pinMode))
+	if *repinKey != "" {
+		key, err := protocol.DecodePublicKey(*repinKey)
+		if err != nil {
+			log.Fatalf("Invalid broker key: %v", err)
+		}
+		if err := pins.Repin(*brokerURL, key); err != nil {
+			log.Fatalf("Failed to re-pin broker: %v", err)
+		}
+		log.Printf("Re-pinned broker %s", *brokerURL)
+	}
+
+	proxy, err := protocol.ParseProxy(*proxyURL)
 	if err != nil {
-		log.Fatalf("Failed to generate key pair: %v", err)
+		log.Fatalf("Invalid --proxy: %v", err)
+	}
+
+	tlsConfig := &tls.Config{
+		InsecureSkipVerify: true, // For demo with self-signed certs
+	}
+	if *mtls {
+		// A fresh certificate per handshake never outlives its validity
+		tlsConfig.GetClientCertificate = func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+			cert, err := protocol.NewIdentityCertificate(privKey, *agentID, 0)
+			return &cert, err
+		}
+	}
+
+	if *artifactDir == "" {
+		*artifactDir = defaultArtifactDir(*agentID)
+	}
+	results := &resultLimiter{
+		maxBytes:          *maxResultBytes,
+		dir:               *artifactDir,
+		retention:         *artifactRetention,
+		summarizerTimeout: *summarizerTimeout,
+		baseURL:           fmt.Sprintf("http://localhost:%d", *mcpPort),
+	}
+	if *summarizer != "" {
+		results.summarizer = commandSummarizer{command: *summarizer}
 	}
 
 	// Create agent
@@ -48,14 +153,14 @@ func main() {
 		BrokerURL: *brokerURL,
 		PubKey:    pubKey,
 		PrivKey:   privKey,
+		pins:      pins,
 		mcpPort:   *mcpPort,
+		instance:  *instance,
+		startedAt: time.Now(),
+		results:   results,
 		client: &http.Client{
-			Transport: &http.Transport{
-				TLSClientConfig: &tls.Config{
-					InsecureSkipVerify: true, // For demo with self-signed certs
-				},
-			},
-			Timeout: 10 * time.Second,
+			Transport: &http.Transport{TLSClientConfig: tlsConfig, Proxy: proxy},
+			Timeout:   10 * time.Second,
 		},
 	}
 
@@ -71,13 +176,37 @@ func main() {
 
 	log.Println("Registration successful. Agent is running with MCP endpoint.")
 
-	// Keep the agent running (in a real implementation, this would listen for incoming messages)
-	select {}
+	if *heartbeatInterval > 0 {
+		go agent.runHeartbeats(*heartbeatInterval)
+	}
+
+	channelCtx, stopChannel := context.WithCancel(context.Background())
+	if *channel {
+		go agent.runChannel(channelCtx, proxy, tlsConfig)
+	}
+
+	// Keep the agent running until it is told to stop. A replica drains
+	// first, so an upgrade does not fail calls routed to it.
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, syscall.SIGTERM, os.Interrupt)
+	<-stop
+	if agent.instance != "" && *drainTimeout > 0 {
+		if err := agent.drain(*drainTimeout); err != nil {
+			log.Printf("Stopping before the broker confirmed the drain: %v", err)
+		}
+	}
+	stopChannel()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	agent.mcpServer.Shutdown(ctx)
 }
 
 func (a *Agent) initializeAndStartMCPServer() error {
 	mux := http.NewServeMux()
 	mux.HandleFunc("/mcp", a.handleMCPRequest)
+	mux.HandleFunc("/livez", a.handleLivez)
+	mux.HandleFunc("/readyz", a.handleReadyz)
+	mux.HandleFunc(artifactPrefix, a.results.handleArtifact)
 
 	a.mcpServer = &http.Server{
 		Addr:    fmt.Sprintf(":%d", a.mcpPort),
@@ -93,12 +222,33 @@ func (a *Agent) initializeAndStartMCPServer() error {
 	return nil
 }
 
+// handleLivez reports that the agent process is serving requests
+func (a *Agent) handleLivez(w http.ResponseWriter, r *http.Request) {
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte("OK"))
+}
+
+// handleReadyz reports whether the agent is registered with its broker and
+// so reachable through discovery
+func (a *Agent) handleReadyz(w http.ResponseWriter, r *http.Request) {
+	if !a.registered.Load() {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		w.Write([]byte("NOT REGISTERED"))
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte("OK"))
+}
+
 func (a *Agent) handleMCPRequest(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
 		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 		return
 	}
 
+	atomic.AddInt64(&a.inFlight, 1)
+	defer atomic.AddInt64(&a.inFlight, -1)
+
 	var reqBody struct {
 		Method string `json:"method"`
 		Params struct {
@@ -121,6 +271,7 @@ func (a *Agent) handleMCPRequest(w http.ResponseWriter, r *http.Request) {
 	handlers := map[string]ToolHandler{
 		"code.execute": a.handleCodeOrShellExecution,
 		"shell.run":    a.handleCodeOrShellExecution,
+		"git.diff":     a.handleGitDiff,
 	}
 
 	handler, exists := handlers[reqBody.Params.Name]
@@ -166,23 +317,24 @@ func (a *Agent) handleCodeOrShellExecution(params map[string]interface{}) (inter
 		cmd := exec.Command("sh", "-c", command)
 		output, err := cmd.CombinedOutput()
 		if err != nil {
-			return nil, fmt.Errorf("execution failed: %w, output: %s", err, string(output))
+			return nil, fmt.Errorf("execution failed: %w, output: %s", err, a.results.inline(output))
 		}
-		return map[string]interface{}{"output": string(output)}, nil
+		return a.results.limit(output), nil
 	}
 	
 	cmd := exec.Command("sh", "-c", command)
 	output, err := cmd.CombinedOutput()
 	if err != nil {
-		return nil, fmt.Errorf("execution failed: %w, output: %s", err, string(output))
+		return nil, fmt.Errorf("execution failed: %w, output: %s", err, a.results.inline(output))
 	}
-	return map[string]interface{}{"output": string(output)}, nil
+	return a.results.limit(output), nil
 }
 
 func (a *Agent) registerWithBroker() error {
 	mcpTools := []protocol.MCPTool{
 		{Name: "code.execute", Description: "Executes a command and returns its output."},
 		{Name: "shell.run", Description: "Runs a shell command."},
+		{Name: "git.diff", Description: "Returns the changes in a git work tree as a structured diff.", ResultTypes: []string{protocol.ContentTypeDiff}},
 	}
 	
 	capabilities := make([]string, len(mcpTools))
@@ -204,6 +356,7 @@ func (a *Agent) registerWithBroker() error {
 				Agent: a.ID,
 				TS:    time.Now().UnixMilli(),
 				Nonce: fmt.Sprintf("%d", time.Now().UnixNano()),
+				Proto: protocol.ProtocolVersion,
 			},
 		},
 		Body: protocol.RegisterAgentBody{
@@ -212,6 +365,7 @@ func (a *Agent) registerWithBroker() error {
 			MCPEndpoint:     fmt.Sprintf("http://localhost:%d/mcp", a.mcpPort),
 			BodyDefinition:  bodyDef,
 			EnvironmentType: "local-dev",
+			InstanceID:      a.instance,
 		},
 	}
 
@@ -233,15 +387,155 @@ func (a *Agent) registerWithBroker() error {
 	}
 	defer resp.Body.Close()
 
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("failed to read registration response: %w", err)
+	}
 	if resp.StatusCode != http.StatusOK {
-		return fmt.Errorf("broker returned status %d", resp.StatusCode)
+		return brokerRejection(resp.StatusCode, respBody)
 	}
 
+	// Trust the broker's identity key on first use and verify it afterwards
+	if err := a.pins.VerifyResponse(a.BrokerURL, resp.Header, envelope.Nonce, respBody); err != nil {
+		return fmt.Errorf("broker identity check failed: %w", err)
+	}
+
+	a.registered.Store(true)
 	log.Printf("Registration successful - Agent %s registered with broker", a.ID)
 	return nil
 }
 
 // executeCode handles code execution tool calls
+// runHeartbeats reports liveness to the broker, registering again if the
+// broker has forgotten this agent
+func (a *Agent) runHeartbeats(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		err := a.sendHeartbeat()
+		if errors.Is(err, errNotRegistered) {
+			a.registered.Store(false)
+			log.Printf("Broker no longer knows agent %s, registering again", a.ID)
+			err = a.registerWithBroker()
+		}
+		if err != nil {
+			log.Printf("Heartbeat failed: %v", err)
+		}
+	}
+}
+
+// errNotRegistered is returned when the broker rejects a heartbeat from an unknown agent
+var errNotRegistered = errors.New("agent not registered with broker")
+
+// newHeartbeat builds a signed heartbeat reporting the agent's current load
+func (a *Agent) newHeartbeat() (*protocol.HeartbeatEnvelope, error) {
+	envelope, err := protocol.NewHeartbeat(a.ID).
+		Instance(a.instance).
+		InFlight(int(atomic.LoadInt64(&a.inFlight))).
+		Uptime(time.Since(a.startedAt)).
+		SignWith(a.PrivKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to build heartbeat: %w", err)
+	}
+	return envelope, nil
+}
+
+func (a *Agent) sendHeartbeat() error {
+	envelope, err := a.newHeartbeat()
+	if err != nil {
+		return err
+	}
+
+	data, err := json.Marshal(envelope)
+	if err != nil {
+		return fmt.Errorf("failed to marshal heartbeat: %w", err)
+	}
+
+	resp, err := a.client.Post(a.BrokerURL+"/", "application/json", bytes.NewReader(data))
+	if err != nil {
+		return fmt.Errorf("failed to send heartbeat: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusOK {
+		return nil
+	}
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("failed to read heartbeat response: %w", err)
+	}
+	return brokerRejection(resp.StatusCode, respBody)
+}
+
+// drain asks the broker to stop sending callers to this replica, then
+// reports its in-flight requests until the broker confirms none remain
+func (a *Agent) drain(timeout time.Duration) error {
+	log.Printf("Draining instance %s", a.instance)
+	deadline := time.Now().Add(timeout)
+	for {
+		drained, err := a.sendDrain()
+		if err != nil {
+			return err
+		}
+		if drained {
+			log.Printf("Instance %s drained", a.instance)
+			return nil
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("instance %s still draining after %v", a.instance, timeout)
+		}
+		time.Sleep(time.Second)
+		if err := a.sendHeartbeat(); err != nil {
+			return err
+		}
+	}
+}
+
+// sendDrain sends a drainInstance envelope and reports whether the broker
+// considers this replica drained
+func (a *Agent) sendDrain() (bool, error) {
+	envelope := protocol.NewTypedEnvelope(a.ID, protocol.DrainInstanceBody{InstanceID: a.instance})
+	if err := envelope.Sign(a.PrivKey); err != nil {
+		return false, fmt.Errorf("failed to sign drain: %w", err)
+	}
+	data, err := json.Marshal(envelope)
+	if err != nil {
+		return false, fmt.Errorf("failed to marshal drain: %w", err)
+	}
+
+	resp, err := a.client.Post(a.BrokerURL+"/", "application/json", bytes.NewReader(data))
+	if err != nil {
+		return false, fmt.Errorf("failed to send drain: %w", err)
+	}
+	defer resp.Body.Close()
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return false, fmt.Errorf("failed to read drain response: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return false, brokerRejection(resp.StatusCode, respBody)
+	}
+	ack, err := protocol.ParseResponse(respBody)
+	if err != nil {
+		return false, err
+	}
+	return ack.Status == "drained", nil
+}
+
+// brokerRejection turns a failed broker response into an error, mapping the
+// broker's error code where one was sent
+func brokerRejection(status int, body []byte) error {
+	var rejection *protocol.ErrorBody
+	if _, err := protocol.ParseResponse(body); errors.As(err, &rejection) {
+		if rejection.Code == protocol.CodeUnknownAgent {
+			return errNotRegistered
+		}
+		return fmt.Errorf("broker rejected request: %w", err)
+	}
+	return fmt.Errorf("broker returned status %d", status)
+}
+
 func (a *Agent) executeCode(command string, args []string) (string, error) {
 	log.Printf("Executing: %s %v", command, args)
 	
@@ -259,11 +553,16 @@ func (a *Agent) executeCode(command string, args []string) (string, error) {
 func (a *Agent) handleToolCall(envelope *protocol.ToolCallEnvelope) (*protocol.ToolResultEnvelope, error) {
 	toolName := envelope.Body.Tool
 	params := envelope.Body.Parameters
+	// Calls without a request ID are answered under their nonce
+	requestID := envelope.Body.RequestID
+	if requestID == "" {
+		requestID = envelope.Nonce
+	}
 	
 	log.Printf("Handling tool call: %s", toolName)
 	
 	var result interface{}
-	var execError string
+	var execError, contentType string
 	
 	switch toolName {
 	case "code.execute":
@@ -311,6 +610,14 @@ func (a *Agent) handleToolCall(envelope *protocol.ToolCallEnvelope) (*protocol.T
 			}
 		}
 		
+	case "git.diff":
+		diff, err := a.handleGitDiff(params)
+		if err != nil {
+			execError = err.Error()
+		} else {
+			result, contentType = diff, protocol.ContentTypeDiff
+		}
+
 	default:
 		execError = fmt.Sprintf("unknown tool: %s", toolName)
 	}
@@ -326,10 +633,11 @@ func (a *Agent) handleToolCall(envelope *protocol.ToolCallEnvelope) (*protocol.T
 			},
 		},
 		Body: protocol.ToolResultBody{
-			RequestID: envelope.Nonce, // Use the original request nonce as ID
-			Success:   execError == "",
-			Result:    result,
-			Error:     execError,
+			RequestID:   requestID,
+			Success:     execError == "",
+			Result:      result,
+			Error:       execError,
+			ContentType: contentType,
 		},
 	}
 	
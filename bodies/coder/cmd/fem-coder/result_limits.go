@@ -0,0 +1,191 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// artifactPrefix is the path full outputs are served under, next to /mcp
+const artifactPrefix = "/artifacts/"
+
+// Summarizer condenses an oversized tool output into a short summary that is
+// returned inline along with the truncated output
+type Summarizer interface {
+	Summarize(ctx context.Context, output []byte) (string, error)
+}
+
+// commandSummarizer pipes the output to a shell command and returns what the
+// command prints, e.g. a call to a local model
+type commandSummarizer struct {
+	command string
+}
+
+func (s commandSummarizer) Summarize(ctx context.Context, output []byte) (string, error) {
+	cmd := exec.CommandContext(ctx, "sh", "-c", s.command)
+	cmd.Stdin = bytes.NewReader(output)
+	summary, err := cmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("summarizer failed: %w", err)
+	}
+	return strings.TrimSpace(string(summary)), nil
+}
+
+// resultLimiter keeps the output returned inline within a size limit. An
+// oversized output is cut to its head and tail, and the full output is kept
+// as an artifact the caller can fetch.
+type resultLimiter struct {
+	maxBytes          int           // Largest output returned inline; 0 for no limit
+	dir               string        // Where full outputs are stored
+	retention         time.Duration // How long full outputs are kept
+	summarizer        Summarizer    // nil for no summaries
+	summarizerTimeout time.Duration
+	baseURL           string // Where the artifact store is served, e.g. "http://localhost:8080"
+}
+
+// limit returns the result of a tool whose output is output
+func (l *resultLimiter) limit(output []byte) map[string]interface{} {
+	if l.maxBytes <= 0 || len(output) <= l.maxBytes {
+		return map[string]interface{}{"output": string(output)}
+	}
+
+	result := map[string]interface{}{
+		"output":      truncateMiddle(output, l.maxBytes),
+		"truncated":   true,
+		"outputBytes": len(output),
+	}
+	if digest, err := l.store(output); err != nil {
+		log.Printf("Failed to keep full output: %v", err)
+	} else {
+		result["artifact"] = digest
+		result["artifactUrl"] = l.baseURL + artifactPrefix + digest
+	}
+	if l.summarizer != nil {
+		ctx, cancel := context.WithTimeout(context.Background(), l.summarizerTimeout)
+		defer cancel()
+		if summary, err := l.summarizer.Summarize(ctx, output); err != nil {
+			log.Printf("Failed to summarize output: %v", err)
+		} else if summary != "" {
+			result["summary"] = truncateMiddle([]byte(summary), l.maxBytes)
+		}
+	}
+	return result
+}
+
+// inline returns output cut to the limit, for error messages
+func (l *resultLimiter) inline(output []byte) string {
+	if l.maxBytes <= 0 {
+		return string(output)
+	}
+	return truncateMiddle(output, l.maxBytes)
+}
+
+// truncateMiddle keeps the head and tail of output within maxBytes, marking
+// what was left out. Cuts fall on UTF-8 character boundaries.
+func truncateMiddle(output []byte, maxBytes int) string {
+	if len(output) <= maxBytes {
+		return string(output)
+	}
+	head := maxBytes / 2
+	for head > 0 && !utf8.RuneStart(output[head]) {
+		head--
+	}
+	tail := len(output) - (maxBytes - head)
+	for tail < len(output) && !utf8.RuneStart(output[tail]) {
+		tail++
+	}
+	return fmt.Sprintf("%s\n... [%d bytes omitted] ...\n%s", output[:head], tail-head, output[tail:])
+}
+
+// store keeps the full output under its content digest and drops outputs
+// older than the retention
+func (l *resultLimiter) store(output []byte) (string, error) {
+	if err := os.MkdirAll(l.dir, 0700); err != nil {
+		return "", err
+	}
+	l.prune()
+
+	sum := sha256.Sum256(output)
+	name := hex.EncodeToString(sum[:])
+	path := filepath.Join(l.dir, name)
+	if _, err := os.Stat(path); err == nil {
+		// Same output already kept; keep it for another retention period
+		now := time.Now()
+		return "sha256:" + name, os.Chtimes(path, now, now)
+	}
+
+	tmp, err := os.CreateTemp(l.dir, name+".*.tmp")
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(output); err != nil {
+		tmp.Close()
+		return "", err
+	}
+	if err := tmp.Close(); err != nil {
+		return "", err
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return "", err
+	}
+	return "sha256:" + name, nil
+}
+
+// prune removes stored outputs older than the retention
+func (l *resultLimiter) prune() {
+	if l.retention <= 0 {
+		return
+	}
+	entries, err := os.ReadDir(l.dir)
+	if err != nil {
+		return
+	}
+	cutoff := time.Now().Add(-l.retention)
+	for _, entry := range entries {
+		info, err := entry.Info()
+		if err == nil && info.ModTime().Before(cutoff) {
+			os.Remove(filepath.Join(l.dir, entry.Name()))
+		}
+	}
+}
+
+// handleArtifact serves a full output by its digest, e.g.
+// GET /artifacts/sha256:<hex>
+func (l *resultLimiter) handleArtifact(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	name, ok := strings.CutPrefix(strings.TrimPrefix(r.URL.Path, artifactPrefix), "sha256:")
+	if _, err := hex.DecodeString(name); !ok || err != nil || len(name) != sha256.Size*2 {
+		http.Error(w, "Invalid artifact digest", http.StatusBadRequest)
+		return
+	}
+	data, err := os.ReadFile(filepath.Join(l.dir, name))
+	if err != nil {
+		http.Error(w, "Artifact not found", http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Write(data)
+}
+
+// defaultArtifactDir keeps full outputs next to the agent's keys and pins
+func defaultArtifactDir(agentID string) string {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return agentID + "-artifacts"
+	}
+	return filepath.Join(home, ".fem", "artifacts", agentID)
+}
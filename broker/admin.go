@@ -0,0 +1,104 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"net/http"
+	"strings"
+)
+
+// adminPathPrefix is the URL prefix for operator endpoints
+const adminPathPrefix = "/admin/"
+
+// SetAdminToken configures the bearer token required by the admin API.
+// An empty token disables the admin API.
+func (b *Broker) SetAdminToken(token string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.adminToken = token
+}
+
+// handleAdmin dispatches authenticated operator requests
+func (b *Broker) handleAdmin(w http.ResponseWriter, r *http.Request) {
+	if !b.authorizeAdmin(r) {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	endpoint := strings.TrimPrefix(r.URL.Path, adminPathPrefix)
+	if !b.checkAdminQuorum(w, r, endpoint) {
+		return
+	}
+
+	switch endpoint {
+	case "maintenance":
+		b.handleAdminMaintenance(w, r)
+	case "honeypots":
+		b.handleAdminHoneypots(w, r)
+	case "security-events":
+		b.handleAdminSecurityEvents(w, r)
+	case "quarantine":
+		b.handleAdminQuarantine(w, r)
+	case "workers":
+		b.handleAdminWorkers(w, r)
+	case "metrics":
+		b.handleAdminMetrics(w, r)
+	case "routes":
+		b.handleAdminRoutes(w, r)
+	case "exclusions":
+		b.handleAdminExclusions(w, r)
+	case "standbys":
+		b.handleAdminStandbys(w, r)
+	case "reservations":
+		b.handleAdminReservations(w, r)
+	case "budgets":
+		b.handleAdminBudgets(w, r)
+	case "approvals":
+		b.handleAdminApprovals(w, r)
+	case "requests":
+		b.handleAdminRequests(w, r)
+	case "checkpoints":
+		b.handleAdminCheckpoints(w, r)
+	case "artifacts":
+		b.handleAdminArtifacts(w, r)
+	case "health-weights":
+		b.handleAdminHealthWeights(w, r)
+	case "canaries":
+		b.handleAdminCanaries(w, r)
+	case "topics":
+		b.handleAdminTopics(w, r)
+	case "capabilities":
+		b.handleAdminCapabilities(w, r)
+	case "capability-revocations":
+		b.handleAdminCapabilityRevocations(w, r)
+	case "compliance-report":
+		b.handleAdminComplianceReport(w, r)
+	case "warmup":
+		b.handleAdminWarmup(w, r)
+	case "peers":
+		b.handleAdminPeers(w, r)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// authorizeAdmin checks the request's bearer token against the configured admin token
+func (b *Broker) authorizeAdmin(r *http.Request) bool {
+	b.mu.RLock()
+	token := b.adminToken
+	b.mu.RUnlock()
+
+	if token == "" {
+		return false
+	}
+
+	presented := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	return subtle.ConstantTimeCompare([]byte(presented), []byte(token)) == 1
+}
+
+// writeJSON writes a JSON response with the given status code
+func writeJSON(w http.ResponseWriter, status int, response interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(response)
+}
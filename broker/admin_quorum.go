@@ -0,0 +1,253 @@
+package main
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fep-fem/protocol"
+)
+
+// Operation classes the two-person rule can gate
+const (
+	AdminOpRevoke      = "revoke"       // Revoking agents trusted at least AdminQuorum.HighTrust
+	AdminOpPeerRemoval = "peer-removal" // Removing federation peers
+	AdminOpPolicy      = "policy"       // Changing policies through the admin API
+)
+
+// defaultHighTrust is the trust score from which revocations need a quorum
+// when the quorum sets none
+const defaultHighTrust = 0.8
+
+// maxAdminRequestSize bounds the admin request bodies read to check them
+// against an approval
+const maxAdminRequestSize = 1 << 20
+
+// policyEndpoints are the admin endpoints whose writes change policy
+var policyEndpoints = map[string]bool{
+	"routes":         true,
+	"exclusions":     true,
+	"standbys":       true,
+	"health-weights": true,
+	"topics":         true,
+	"budgets":        true,
+	"honeypots":      true,
+	"canaries":       true,
+	"capabilities":   true,
+}
+
+// AdminQuorum sets how many admins must sign off on destructive operations,
+// per operation class
+type AdminQuorum struct {
+	Admins    map[string]ed25519.PublicKey // Admin IDs and their pinned keys; only these keys' signatures count
+	Required  map[string]int               // Signatures needed per operation class; classes not listed need none
+	HighTrust float64                      // Revoking agents trusted at least this much is revoke-class; defaultHighTrust if 0
+}
+
+// SetAdminQuorum requires k-of-n admin signatures on the operation classes
+// the quorum lists; nil lifts the two-person rule. The admins' pinned keys
+// also sign the revocations reconciled from peers.
+func (b *Broker) SetAdminQuorum(quorum *AdminQuorum) {
+	b.mu.Lock()
+	b.adminQuorum = quorum
+	b.mu.Unlock()
+
+	var admins map[string]ed25519.PublicKey
+	if quorum != nil {
+		admins = quorum.Admins
+	}
+	b.federation.SetRevocationAuthorities(admins)
+}
+
+// adminSignaturePolicy returns the signatures an operation class needs, and
+// whether it needs any. Signatures are checked against the admins' pinned
+// keys, never the keys agents registered, so re-registering an admin's ID
+// does not make its holder an admin.
+func (b *Broker) adminSignaturePolicy(operation string) (protocol.SignaturePolicy, bool) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	quorum := b.adminQuorum
+	if quorum == nil || quorum.Required[operation] <= 0 {
+		return protocol.SignaturePolicy{}, false
+	}
+	return protocol.Threshold(quorum.Required[operation], quorum.Admins), true
+}
+
+// checkRevocationQuorum refuses revocations of high-trust agents that fewer
+// admins signed than the revoke class needs. On failure it writes the error
+// envelope itself.
+func (b *Broker) checkRevocationQuorum(w http.ResponseWriter, env *protocol.GenericEnvelope, target string) bool {
+	b.mu.RLock()
+	agent, known := b.agents[target]
+	quorum := b.adminQuorum
+	b.mu.RUnlock()
+	if !known || quorum == nil {
+		return true
+	}
+	highTrust := quorum.HighTrust
+	if highTrust <= 0 {
+		highTrust = defaultHighTrust
+	}
+	if agent.TrustScore < highTrust {
+		return true
+	}
+	policy, needed := b.adminSignaturePolicy(AdminOpRevoke)
+	if !needed {
+		return true
+	}
+	if err := env.VerifyPolicy(policy); err != nil {
+		b.refuseAdminOperation(AdminOpRevoke, env.Agent, fmt.Sprintf("revocation of %s: %v", target, err), nil)
+		b.reject(w, env, protocol.CodeForbidden, fmt.Sprintf("Revoking %s, trusted %.2f, needs %d admin signatures: %v", target, agent.TrustScore, policy.Required, err))
+		return false
+	}
+	log.Printf("Revocation of high-trust agent %s signed by %s", target, strings.Join(envelopeSigners(env.CommonHeaders), ", "))
+	return true
+}
+
+// checkAdminQuorum refuses admin API requests in a gated operation class
+// unless they carry an approval enough admins signed. On failure it writes
+// the error response itself.
+func (b *Broker) checkAdminQuorum(w http.ResponseWriter, r *http.Request, endpoint string) bool {
+	operation, gated := adminOperation(endpoint, r.Method)
+	if !gated {
+		return true
+	}
+	policy, needed := b.adminSignaturePolicy(operation)
+	if !needed {
+		return true
+	}
+
+	refuse := func(approver, reason string) bool {
+		b.refuseAdminOperation(operation, approver, fmt.Sprintf("%s %s: %s", r.Method, r.URL.Path, reason), requestSource(r))
+		http.Error(w, fmt.Sprintf("%s operations need %d admin signatures: %s", operation, policy.Required, reason), http.StatusForbidden)
+		return false
+	}
+	header := r.Header.Get(protocol.AdminApprovalHeader)
+	if header == "" {
+		return refuse("", "no "+protocol.AdminApprovalHeader+" header")
+	}
+	approval, err := protocol.DecodeAdminApproval(header)
+	if err != nil {
+		return refuse("", err.Error())
+	}
+	if approval.Body.Operation != operation {
+		return refuse(approval.Agent, fmt.Sprintf("approval is for a %s operation", approval.Body.Operation))
+	}
+	body, err := io.ReadAll(io.LimitReader(r.Body, maxAdminRequestSize))
+	if err != nil {
+		http.Error(w, "Failed to read request", http.StatusBadRequest)
+		return false
+	}
+	r.Body = io.NopCloser(bytes.NewReader(body))
+	if err := approval.Body.Authorizes(r.Method, r.URL.RequestURI(), body); err != nil {
+		return refuse(approval.Agent, err.Error())
+	}
+	// The skew window bounds how long an approval can be used, and each one
+	// is only used once within it
+	b.mu.RLock()
+	skew := b.skew
+	b.mu.RUnlock()
+	if err := skew.Check(approval.TS, time.Now()); err != nil {
+		return refuse(approval.Agent, err.Error())
+	}
+	if err := approval.VerifyPolicy(policy); err != nil {
+		return refuse(approval.Agent, err.Error())
+	}
+	if !b.usedApprovals.use(approval.Nonce, time.UnixMilli(approval.TS)) {
+		return refuse(approval.Agent, "approval was already used")
+	}
+
+	log.Printf("Admin %s operation %s %s approved by %s", operation, r.Method, r.URL.RequestURI(), strings.Join(envelopeSigners(approval.CommonHeaders), ", "))
+	return true
+}
+
+// adminOperation returns the operation class of an admin API request, and
+// whether it falls in one. Reads never do.
+func adminOperation(endpoint, method string) (string, bool) {
+	if method == http.MethodGet || method == http.MethodHead {
+		return "", false
+	}
+	switch {
+	case endpoint == "peers":
+		return AdminOpPeerRemoval, true
+	case policyEndpoints[endpoint]:
+		return AdminOpPolicy, true
+	}
+	return "", false
+}
+
+// refuseAdminOperation records a gated operation refused for lack of a quorum
+func (b *Broker) refuseAdminOperation(operation, agentID, detail string, source *TransportMetadata) {
+	b.securityEvents.Raise(SecurityEvent{
+		Type:    SecurityEventQuorumRefused,
+		AgentID: agentID,
+		Detail:  fmt.Sprintf("%s operation refused: %s", operation, detail),
+		Source:  source,
+	})
+}
+
+// envelopeSigners lists who signed an envelope, primary signer first
+func envelopeSigners(headers protocol.CommonHeaders) []string {
+	signers := []string{headers.Agent}
+	for _, sig := range headers.Sigs {
+		signers = append(signers, sig.Signer)
+	}
+	return signers
+}
+
+// approvalNonces remembers the approvals used, until the skew window would
+// refuse them anyway
+type approvalNonces struct {
+	used map[string]time.Time // Expiry by nonce
+	mu   sync.Mutex
+}
+
+func newApprovalNonces() *approvalNonces {
+	return &approvalNonces{used: make(map[string]time.Time)}
+}
+
+// use records a nonce, reporting false if it was already used
+func (n *approvalNonces) use(nonce string, signed time.Time) bool {
+	now := time.Now()
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	for used, expires := range n.used {
+		if now.After(expires) {
+			delete(n.used, used)
+		}
+	}
+	if _, used := n.used[nonce]; used {
+		return false
+	}
+	n.used[nonce] = signed.Add(2 * protocol.DefaultMaxEnvelopeAge)
+	return true
+}
+
+// parseAdminQuorum reads comma-separated class=k pairs, e.g. "revoke=2,policy=2"
+func parseAdminQuorum(spec string) (map[string]int, error) {
+	required := make(map[string]int)
+	for _, item := range parseList(spec) {
+		class, count, ok := strings.Cut(item, "=")
+		if !ok {
+			return nil, fmt.Errorf("expected class=signatures, got %q", item)
+		}
+		switch class {
+		case AdminOpRevoke, AdminOpPeerRemoval, AdminOpPolicy:
+		default:
+			return nil, fmt.Errorf("unknown operation class %q", class)
+		}
+		k, err := strconv.Atoi(count)
+		if err != nil || k < 1 {
+			return nil, fmt.Errorf("invalid signature count %q for %s", count, class)
+		}
+		required[class] = k
+	}
+	return required, nil
+}
@@ -0,0 +1,202 @@
+package main
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/fep-fem/protocol"
+)
+
+// quorumBroker needs two of the admins alice, bob and carol to sign off on
+// every operation class
+func quorumBroker(t *testing.T) (*Broker, map[string]ed25519.PrivateKey) {
+	t.Helper()
+	broker := NewBroker()
+	broker.SetAdminToken("secret")
+	keys := make(map[string]ed25519.PrivateKey)
+	var pins []string
+	for _, admin := range []string{"alice", "bob", "carol"} {
+		pubKey, privKey, _ := protocol.GenerateKeyPair()
+		registerDiscoveryClient(broker, admin, pubKey)
+		keys[admin] = privKey
+		pins = append(pins, admin+"="+protocol.EncodePublicKey(pubKey))
+	}
+	admins, err := parsePinnedKeys(strings.Join(pins, ", "))
+	if err != nil {
+		t.Fatalf("Failed to parse admins: %v", err)
+	}
+	required, err := parseAdminQuorum("revoke=2, peer-removal=2, policy=2")
+	if err != nil {
+		t.Fatalf("Failed to parse quorum: %v", err)
+	}
+	broker.SetAdminQuorum(&AdminQuorum{Admins: admins, Required: required})
+	return broker, keys
+}
+
+// adminRequest sends an admin API request approved by the given admins, the
+// first signing and the rest co-signing
+func adminRequest(broker *Broker, keys map[string]ed25519.PrivateKey, operation, method, path, body string, admins ...string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	req.Header.Set("Authorization", "Bearer secret")
+	if len(admins) > 0 {
+		approval, _ := protocol.NewAdminApproval(admins[0], operation, method, path, []byte(body))
+		approval.Sign(keys[admins[0]])
+		for _, admin := range admins[1:] {
+			approval.CoSign(admin, keys[admin])
+		}
+		header, _ := protocol.EncodeAdminApproval(approval)
+		req.Header.Set(protocol.AdminApprovalHeader, header)
+	}
+	resp := httptest.NewRecorder()
+	broker.ServeHTTP(resp, req)
+	return resp
+}
+
+func TestPolicyChangesNeedAdminQuorum(t *testing.T) {
+	broker, keys := quorumBroker(t)
+	decoy := `{"agentId":"backup-agent","tool":{"name":"secrets.dump"}}`
+
+	if resp := adminRequest(broker, keys, AdminOpPolicy, http.MethodPost, "/admin/honeypots", decoy); resp.Code != http.StatusForbidden {
+		t.Errorf("Policy changes without an approval should be refused, got %d", resp.Code)
+	}
+	if resp := adminRequest(broker, keys, AdminOpPolicy, http.MethodPost, "/admin/honeypots", decoy, "alice"); resp.Code != http.StatusForbidden {
+		t.Errorf("Policy changes one admin signed should be refused, got %d", resp.Code)
+	}
+	// The approval covers the exact body, not just the endpoint
+	req := httptest.NewRequest(http.MethodPost, "/admin/honeypots", strings.NewReader(`{"agentId":"other","tool":{"name":"x"}}`))
+	req.Header.Set("Authorization", "Bearer secret")
+	approval, _ := protocol.NewAdminApproval("alice", AdminOpPolicy, http.MethodPost, "/admin/honeypots", []byte(decoy))
+	approval.Sign(keys["alice"])
+	approval.CoSign("bob", keys["bob"])
+	header, _ := protocol.EncodeAdminApproval(approval)
+	req.Header.Set(protocol.AdminApprovalHeader, header)
+	resp := httptest.NewRecorder()
+	broker.ServeHTTP(resp, req)
+	if resp.Code != http.StatusForbidden {
+		t.Errorf("Approvals should not authorize other request bodies, got %d", resp.Code)
+	}
+	if refusals := broker.securityEvents.Recent(); len(refusals) != 3 || refusals[0].Type != SecurityEventQuorumRefused {
+		t.Errorf("Expected each refusal raised as a security event, got %+v", refusals)
+	}
+
+	// Approvals are used once
+	for i, want := range []int{http.StatusOK, http.StatusForbidden} {
+		req := httptest.NewRequest(http.MethodPost, "/admin/honeypots", strings.NewReader(decoy))
+		req.Header.Set("Authorization", "Bearer secret")
+		req.Header.Set(protocol.AdminApprovalHeader, header)
+		resp := httptest.NewRecorder()
+		broker.ServeHTTP(resp, req)
+		if resp.Code != want {
+			t.Fatalf("Request %d with a two-admin approval: expected %d, got %d %s", i+1, want, resp.Code, resp.Body.String())
+		}
+	}
+	if honeypots := broker.Honeypots(); len(honeypots) != 1 {
+		t.Error("Approved honeypot was not registered")
+	}
+
+	// Reads and operations outside the gated classes need no approval
+	if resp := adminRequest(broker, keys, "", http.MethodGet, "/admin/honeypots", ""); resp.Code != http.StatusOK {
+		t.Errorf("Reads should not need an approval, got %d", resp.Code)
+	}
+	if resp := adminRequest(broker, keys, "", http.MethodPost, "/admin/quarantine", `{"agentId":"rogue"}`); resp.Code != http.StatusOK {
+		t.Errorf("Quarantine should not wait for a quorum, got %d", resp.Code)
+	}
+}
+
+func TestPeerRemovalNeedsAdminQuorum(t *testing.T) {
+	broker, keys := quorumBroker(t)
+	broker.federation.federatedBrokers["broker-west"] = &FederatedBroker{ID: "broker-west", Status: BrokerStatusActive}
+
+	path := "/admin/peers?broker=broker-west"
+	if resp := adminRequest(broker, keys, AdminOpPeerRemoval, http.MethodDelete, path, "", "bob"); resp.Code != http.StatusForbidden {
+		t.Errorf("Peer removal one admin signed should be refused, got %d", resp.Code)
+	}
+	if resp := adminRequest(broker, keys, AdminOpPolicy, http.MethodDelete, path, "", "bob", "carol"); resp.Code != http.StatusForbidden {
+		t.Errorf("Approvals for another operation class should be refused, got %d", resp.Code)
+	}
+	if resp := adminRequest(broker, keys, AdminOpPeerRemoval, http.MethodDelete, path, "", "bob", "carol"); resp.Code != http.StatusOK {
+		t.Fatalf("Peer removal two admins signed should go through, got %d %s", resp.Code, resp.Body.String())
+	}
+
+	resp := adminRequest(broker, keys, "", http.MethodGet, "/admin/peers", "")
+	var peers []peerSummary
+	json.Unmarshal(resp.Body.Bytes(), &peers)
+	if len(peers) != 0 {
+		t.Errorf("Expected the peer removed, got %+v", peers)
+	}
+}
+
+func TestHighTrustRevocationNeedsAdminQuorum(t *testing.T) {
+	broker, keys := quorumBroker(t)
+	pubKey, _, _ := protocol.GenerateKeyPair()
+	registerDiscoveryClient(broker, "veteran", pubKey)
+	registerDiscoveryClient(broker, "newcomer", pubKey)
+	broker.agents["newcomer"].TrustScore = 0.3
+
+	revoke := func(target string, admins ...string) *httptest.ResponseRecorder {
+		envelope := protocol.NewTypedEnvelope(admins[0], protocol.RevokeBody{Target: target, Reason: "compromised"})
+		envelope.Sign(keys[admins[0]])
+		for _, admin := range admins[1:] {
+			envelope.CoSign(admin, keys[admin])
+		}
+		data, _ := json.Marshal(envelope)
+		resp := httptest.NewRecorder()
+		broker.ServeHTTP(resp, httptest.NewRequest(http.MethodPost, "/", bytes.NewReader(data)))
+		return resp
+	}
+
+	// Low-trust agents are revoked as before
+	if resp := revoke("newcomer", "alice"); resp.Code != http.StatusOK {
+		t.Errorf("Revoking a low-trust agent should not need a quorum, got %d", resp.Code)
+	}
+	if resp := revoke("veteran", "alice"); resp.Code == http.StatusOK {
+		t.Error("Revoking a high-trust agent with one signature should be refused")
+	}
+	if _, known := broker.agents["veteran"]; !known {
+		t.Fatal("Refused revocation still removed the agent")
+	}
+	if resp := revoke("veteran", "alice", "bob"); resp.Code != http.StatusOK {
+		t.Fatalf("Revocation two admins signed should go through, got %d %s", resp.Code, resp.Body.String())
+	}
+	if _, known := broker.agents["veteran"]; known {
+		t.Error("Approved revocation did not remove the agent")
+	}
+}
+
+func TestReRegisteredAdminsDoNotCountTowardsQuorum(t *testing.T) {
+	broker, _ := quorumBroker(t)
+	broker.federation.federatedBrokers["broker-west"] = &FederatedBroker{ID: "broker-west", Status: BrokerStatusActive}
+
+	// Even with their registrations moved to keys an attacker holds, bob and
+	// carol only count with the keys pinned for them
+	forged := make(map[string]ed25519.PrivateKey)
+	for _, admin := range []string{"bob", "carol"} {
+		pubKey, privKey, _ := protocol.GenerateKeyPair()
+		broker.mu.Lock()
+		broker.agents[admin].PubKey = protocol.EncodePublicKey(pubKey)
+		broker.mu.Unlock()
+		forged[admin] = privKey
+	}
+	path := "/admin/peers?broker=broker-west"
+	if resp := adminRequest(broker, forged, AdminOpPeerRemoval, http.MethodDelete, path, "", "bob", "carol"); resp.Code != http.StatusForbidden {
+		t.Errorf("Approvals signed with re-registered admin keys should be refused, got %d", resp.Code)
+	}
+}
+
+func TestParseAdminQuorum(t *testing.T) {
+	for _, spec := range []string{"revoke", "revoke=0", "deploy=2"} {
+		if _, err := parseAdminQuorum(spec); err == nil {
+			t.Errorf("Expected %q to be refused", spec)
+		}
+	}
+	for _, spec := range []string{"alice", "=key", "alice=not-a-key"} {
+		if _, err := parsePinnedKeys(spec); err == nil {
+			t.Errorf("Expected admins %q to be refused", spec)
+		}
+	}
+}
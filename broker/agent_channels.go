@@ -0,0 +1,302 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fep-fem/protocol"
+)
+
+// channelHelloTimeout bounds the wait for the heartbeat that identifies the
+// agent on a new channel
+const channelHelloTimeout = 10 * time.Second
+
+// agentChannel is a channel an agent holds open for pushed calls
+type agentChannel struct {
+	channelConn
+	agentID  string
+	instance string
+}
+
+// channelConn carries envelopes to an agent: an upgraded agent channel, or a
+// gRPC stream
+type channelConn interface {
+	WriteEnvelope(env *protocol.Envelope) error
+	SetCompression(policy *protocol.CompressionPolicy)
+	Close() error
+}
+
+// channelRegistry tracks the open channels, one per agent replica
+type channelRegistry struct {
+	channels map[string]map[string]*agentChannel // By agent ID, then instance ID
+	mu       sync.RWMutex
+}
+
+func newChannelRegistry() *channelRegistry {
+	return &channelRegistry{channels: make(map[string]map[string]*agentChannel)}
+}
+
+// add records a channel, closing any the same replica held before
+func (r *channelRegistry) add(channel *agentChannel) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.channels[channel.agentID] == nil {
+		r.channels[channel.agentID] = make(map[string]*agentChannel)
+	}
+	if previous, exists := r.channels[channel.agentID][channel.instance]; exists {
+		previous.Close()
+	}
+	r.channels[channel.agentID][channel.instance] = channel
+}
+
+// remove forgets a channel unless a newer one replaced it
+func (r *channelRegistry) remove(channel *agentChannel) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.channels[channel.agentID][channel.instance] != channel {
+		return
+	}
+	delete(r.channels[channel.agentID], channel.instance)
+	if len(r.channels[channel.agentID]) == 0 {
+		delete(r.channels, channel.agentID)
+	}
+}
+
+// connected reports whether an agent holds a channel open
+func (r *channelRegistry) connected(agentID string) bool {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return len(r.channels[agentID]) > 0
+}
+
+// instances returns an agent's open channels ordered by instance ID
+func (r *channelRegistry) instances(agentID string) []*agentChannel {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	channels := make([]*agentChannel, 0, len(r.channels[agentID]))
+	for _, channel := range r.channels[agentID] {
+		channels = append(channels, channel)
+	}
+	sort.Slice(channels, func(i, j int) bool { return channels[i].instance < channels[j].instance })
+	return channels
+}
+
+// handleAgentChannel serves a channel an agent opens to take pushed calls.
+// The agent's first envelope must be a heartbeat it signed; every envelope
+// it sends is then handled as if posted and answered on the channel.
+func (b *Broker) handleAgentChannel(w http.ResponseWriter, r *http.Request) {
+	// Agents reconnect to a peer while the broker is in maintenance
+	if b.InMaintenance() {
+		b.rejectForMaintenance(w, nil)
+		return
+	}
+	conn, err := protocol.AcceptAgentChannel(w, r)
+	if err != nil {
+		log.Printf("Refusing channel from %s: %v", r.RemoteAddr, err)
+		return
+	}
+	defer conn.Close()
+	if largest := b.SizeLimits().Largest(); largest > 0 {
+		conn.SetMaxEnvelopeSize(largest)
+	}
+
+	conn.SetReadDeadline(time.Now().Add(channelHelloTimeout))
+	hello, err := conn.ReadEnvelope()
+	if err != nil {
+		log.Printf("Channel from %s closed before its heartbeat: %v", r.RemoteAddr, err)
+		return
+	}
+	conn.SetReadDeadline(time.Time{})
+	channel, ok := b.openChannel(r, conn, hello)
+	if !ok {
+		return
+	}
+	defer b.channels.remove(channel)
+	log.Printf("Agent %s opened a channel from %s", channel.agentID, r.RemoteAddr)
+
+	for {
+		envelope, err := conn.ReadEnvelope()
+		switch {
+		case errors.Is(err, protocol.ErrEnvelopeTooLarge):
+			// The oversized envelope was skipped; the channel stays usable
+			b.answerOnChannel(channel, b.tooLargeResponse(b.SizeLimits().Largest()))
+			continue
+		case err != nil:
+			if !errors.Is(err, io.EOF) {
+				log.Printf("Channel of %s closed: %v", channel.agentID, err)
+			}
+			return
+		}
+		b.answerOnChannel(channel, b.serveOnChannel(r, envelope))
+	}
+}
+
+// openChannel authenticates the heartbeat opening a channel and registers the
+// channel for pushed calls. A refused heartbeat is answered before returning false.
+func (b *Broker) openChannel(r *http.Request, conn channelConn, hello *protocol.Envelope) (*agentChannel, bool) {
+	channel := &agentChannel{channelConn: conn, agentID: hello.Agent}
+	response := newChannelResponse()
+	if hello.Type != protocol.EnvelopeHeartbeat {
+		b.reject(response, nil, protocol.CodeInvalidEnvelope, "A channel must open with a heartbeat")
+		b.answerOnChannel(channel, response)
+		return nil, false
+	}
+	generic := &protocol.GenericEnvelope{BaseEnvelope: protocol.BaseEnvelope{Type: hello.Type, CommonHeaders: hello.CommonHeaders}, Body: hello.Body}
+	if !b.authenticateAgent(response, r, generic) {
+		b.answerOnChannel(channel, response)
+		return nil, false
+	}
+	var heartbeat protocol.HeartbeatBody
+	json.Unmarshal(hello.Body, &heartbeat)
+	channel.instance = heartbeat.InstanceID
+
+	// The heartbeat is acked as any other, then calls may be pushed
+	response = b.serveOnChannel(r, hello)
+	b.answerOnChannel(channel, response)
+	if response.status != http.StatusOK {
+		return nil, false
+	}
+	conn.SetCompression(b.compressionFor(channel.agentID))
+	b.channels.add(channel)
+	return channel, true
+}
+
+// serveOnChannel handles an envelope received on a channel as if it had been
+// posted in a request like the one that opened the channel
+func (b *Broker) serveOnChannel(r *http.Request, envelope *protocol.Envelope) *channelResponse {
+	response := newChannelResponse()
+	data, err := json.Marshal(envelope)
+	if err != nil {
+		b.reject(response, nil, protocol.CodeInvalidEnvelope, "Failed to read envelope")
+		return response
+	}
+	req := r.Clone(r.Context())
+	req.Method = http.MethodPost
+	req.URL.Path = "/"
+	req.Header = http.Header{"Content-Type": {"application/json"}}
+	req.Body = io.NopCloser(bytes.NewReader(data))
+	req.ContentLength = int64(len(data))
+	b.ServeHTTP(response, req)
+	return response
+}
+
+// answerOnChannel sends the answer to an envelope down its channel. Answers
+// carry no response signature header, so the broker signs the envelope itself.
+func (b *Broker) answerOnChannel(channel *agentChannel, response *channelResponse) {
+	answer, err := b.answerEnvelope(response)
+	if err != nil {
+		log.Printf("Failed to answer on channel of %s: %v", channel.agentID, err)
+		return
+	}
+	if err := channel.WriteEnvelope(answer); err != nil {
+		log.Printf("Failed to answer on channel of %s: %v", channel.agentID, err)
+		channel.Close()
+	}
+}
+
+// answerEnvelope returns the envelope a buffered response carries, signed by
+// the broker
+func (b *Broker) answerEnvelope(response *channelResponse) (*protocol.Envelope, error) {
+	data, err := protocol.InflateBody(response.body.Bytes())
+	if err != nil {
+		return nil, err
+	}
+	var answer protocol.Envelope
+	if err := json.Unmarshal(data, &answer); err != nil || answer.Type == "" {
+		// Not an envelope, e.g. the 503 sent when the identity key cannot sign
+		data, _ = json.Marshal(b.newError(nil, protocol.CodeInternal, strings.TrimSpace(response.body.String())))
+		json.Unmarshal(data, &answer)
+	}
+	if err := answer.Sign(b.IdentityKey()); err != nil {
+		return nil, fmt.Errorf("failed to sign answer: %w", err)
+	}
+	return &answer, nil
+}
+
+// tooLargeResponse builds the answer to an envelope the channel refused to read
+func (b *Broker) tooLargeResponse(limit int) *channelResponse {
+	response := newChannelResponse()
+	errBody := (&protocol.PayloadTooLargeError{Limit: limit}).ErrorBody()
+	rejection := b.newError(nil, errBody.Code, errBody.Message)
+	rejection.Body.Details = errBody.Details
+	writeError(response, rejection)
+	return response
+}
+
+// pushCall sends a call down the channel of a provider of its tool, returning
+// the agent it went to. It returns false if no provider holds a channel open,
+// leaving the call for providers to be sent some other way.
+func (b *Broker) pushCall(env *protocol.GenericEnvelope, tool string) (string, bool) {
+	var connected []string
+	for _, registered := range b.mcpRegistry.ListTools() {
+		if registered.Tool.Name == tool && b.channels.connected(registered.AgentID) {
+			connected = append(connected, registered.AgentID)
+		}
+	}
+	agentID, ok := b.federation.selectChannelAgent(tool, connected)
+	if !ok {
+		return "", false
+	}
+
+	call := &protocol.Envelope{Type: env.Type, CommonHeaders: env.CommonHeaders, Body: env.Body}
+	for _, channel := range b.channels.instances(agentID) {
+		if instance, exists := b.mcpRegistry.Instance(agentID, channel.instance); exists && instance.Draining() {
+			continue
+		}
+		if err := channel.WriteEnvelope(call); err != nil {
+			log.Printf("Failed to push call to %s: %v", agentID, err)
+			channel.Close()
+			continue
+		}
+		log.Printf("Pushed %s call %s to %s over its channel", tool, env.Nonce, agentID)
+		return agentID, true
+	}
+	return "", false
+}
+
+// selectChannelAgent picks the agent to push a call of toolName to among
+// agents holding a channel open. The open channel shows the agent is alive,
+// so health scores from probing endpoints, which cannot reach agents behind
+// NAT, are not consulted; excluded and preempted agents are still skipped.
+func (fm *FederationManager) selectChannelAgent(toolName string, agents []string) (string, bool) {
+	candidates := fm.exclusions.Filter(fm.filterPreempted(agents))
+	if len(candidates) == 0 {
+		return "", false
+	}
+	fm.metricsMutex.RLock()
+	defer fm.metricsMutex.RUnlock()
+	selected, err := fm.loadBalancer.SelectAgent(candidates, fm.agentMetrics, &RequestContext{ToolName: toolName}, fm.config.DefaultLoadBalanceMode)
+	return selected, err == nil
+}
+
+// channelResponse buffers the response to an envelope received on a channel
+type channelResponse struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+}
+
+func newChannelResponse() *channelResponse {
+	return &channelResponse{header: make(http.Header), status: http.StatusOK}
+}
+
+func (c *channelResponse) Header() http.Header {
+	return c.header
+}
+
+func (c *channelResponse) WriteHeader(status int) {
+	c.status = status
+}
+
+func (c *channelResponse) Write(data []byte) (int, error) {
+	return c.body.Write(data)
+}
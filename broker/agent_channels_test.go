@@ -0,0 +1,116 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/fep-fem/protocol"
+)
+
+// openChannel dials the broker's agent channel and sends hello on it,
+// returning the channel and the broker's answer
+func openChannel(t *testing.T, server *httptest.Server, hello interface{}) (*protocol.AgentChannel, *protocol.AckBody, error) {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	noProxy := func(*http.Request) (*url.URL, error) { return nil, nil }
+	channel, err := protocol.DialAgentChannel(ctx, server.URL, noProxy, &tls.Config{InsecureSkipVerify: true})
+	if err != nil {
+		t.Fatalf("Failed to open channel: %v", err)
+	}
+	if err := channel.Send(hello); err != nil {
+		t.Fatalf("Failed to send heartbeat: %v", err)
+	}
+	answer, err := readAnswer(channel)
+	return channel, answer, err
+}
+
+// readAnswer reads the ack or error answering the last envelope sent
+func readAnswer(channel *protocol.AgentChannel) (*protocol.AckBody, error) {
+	channel.SetReadDeadline(time.Now().Add(5 * time.Second))
+	envelope, err := channel.ReadEnvelope()
+	if err != nil {
+		return nil, err
+	}
+	return protocol.ParseResponse(mustJSON(envelope))
+}
+
+func TestCallsPushedOverAgentChannel(t *testing.T) {
+	broker := NewBroker()
+	server := httptest.NewTLSServer(broker)
+	defer server.Close()
+
+	pub, priv, _ := protocol.GenerateKeyPair()
+	register := protocol.NewTypedEnvelope("worker", protocol.RegisterAgentBody{
+		PubKey:         protocol.EncodePublicKey(pub),
+		MCPEndpoint:    "http://10.0.0.7:8080/mcp",
+		BodyDefinition: &protocol.BodyDefinition{MCPTools: []protocol.MCPTool{{Name: "build.run"}}},
+	})
+	register.Sign(priv)
+	sendForAck(t, broker, register)
+
+	hello, _ := protocol.NewHeartbeat("worker").SignWith(priv)
+	channel, ack, err := openChannel(t, server, hello)
+	if err != nil || ack.Ref != hello.Nonce {
+		t.Fatalf("Expected the heartbeat acked, got %+v, %v", ack, err)
+	}
+	defer channel.Close()
+
+	// The call reaches the agent on its channel rather than its MCP endpoint
+	call, _ := protocol.NewToolCall("caller").Tool("build.run").RequestID("build-1").Build()
+	if ack, _ := sendForAck(t, broker, call).Result.(map[string]interface{}); ack["agent"] != "worker" {
+		t.Errorf("Expected the call pushed to worker, got %+v", ack)
+	}
+	channel.SetReadDeadline(time.Now().Add(5 * time.Second))
+	pushed, err := channel.ReadEnvelope()
+	if err != nil || pushed.Type != protocol.EnvelopeToolCall || pushed.Nonce != call.Nonce {
+		t.Fatalf("Expected the caller's call, got %+v, %v", pushed, err)
+	}
+
+	// The agent answers on the same channel and the caller collects the result
+	result, _ := protocol.NewToolResult("worker", "build-1").Result("ok").SignWith(priv)
+	channel.Send(result)
+	if ack, err := readAnswer(channel); err != nil || ack.Status != "received" {
+		t.Fatalf("Expected the result acked, got %+v, %v", ack, err)
+	}
+	if _, held := broker.results.Take("caller", "build-1"); !held {
+		t.Error("Result sent over the channel should be held for the caller")
+	}
+
+	// Once the agent hangs up, calls are no longer pushed
+	channel.Close()
+	for deadline := time.Now().Add(5 * time.Second); broker.channels.connected("worker") && time.Now().Before(deadline); {
+		time.Sleep(10 * time.Millisecond)
+	}
+	call, _ = protocol.NewToolCall("caller").Tool("build.run").RequestID("build-2").Build()
+	if ack, _ := sendForAck(t, broker, call).Result.(map[string]interface{}); ack["agent"] != nil {
+		t.Errorf("Call should not be pushed to a closed channel, got %+v", ack)
+	}
+}
+
+func TestAgentChannelNeedsRegisteredAgent(t *testing.T) {
+	broker := NewBroker()
+	server := httptest.NewTLSServer(broker)
+	defer server.Close()
+
+	_, priv, _ := protocol.GenerateKeyPair()
+	hello, _ := protocol.NewHeartbeat("stranger").SignWith(priv)
+	channel, _, err := openChannel(t, server, hello)
+	defer channel.Close()
+	var rejection *protocol.ErrorBody
+	if !errors.As(err, &rejection) || rejection.Code != protocol.CodeUnknownAgent {
+		t.Fatalf("Expected unknown_agent, got %v", err)
+	}
+	if _, err := channel.ReadEnvelope(); err == nil {
+		t.Error("Broker should close a channel it refused")
+	}
+	if broker.channels.connected("stranger") {
+		t.Error("Refused channel should not take calls")
+	}
+}
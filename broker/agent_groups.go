@@ -0,0 +1,231 @@
+package main
+
+import (
+	"log"
+	"math"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/fep-fem/protocol"
+)
+
+// AgentInstance is one replica of a horizontally scaled agent. Replicas
+// register under the agent's ID with the same key and distinct instance IDs.
+type AgentInstance struct {
+	ID            string                 `json:"id"`
+	MCPEndpoint   string                 `json:"mcpEndpoint"`
+	LastHeartbeat time.Time              `json:"lastHeartbeat"`
+	LastStatus    protocol.HeartbeatBody `json:"lastStatus"`
+	DrainingSince time.Time              `json:"drainingSince"` // Zero unless the instance is draining
+
+	drained chan struct{} // Closed once the draining instance is drained
+}
+
+// instanceLoadTolerance is how close in load instances count as equally busy
+const instanceLoadTolerance = 0.1
+
+// maxDrainWait caps how long a drainInstance ack waits for the instance
+const maxDrainWait = 5 * time.Minute
+
+// Draining reports whether the instance was taken out of rotation
+func (i *AgentInstance) Draining() bool {
+	return !i.DrainingSince.IsZero()
+}
+
+// Drained reports whether a draining instance can be stopped: a heartbeat
+// sent since draining began reported no requests in flight
+func (i *AgentInstance) Drained() bool {
+	return i.Draining() && !i.LastHeartbeat.Before(i.DrainingSince) && i.LastStatus.InFlight == 0
+}
+
+// signalDrained wakes those waiting for the instance once it is drained
+func (i *AgentInstance) signalDrained() {
+	if i.drained == nil || !i.Drained() {
+		return
+	}
+	select {
+	case <-i.drained:
+	default:
+		close(i.drained)
+	}
+}
+
+// RegisterInstance registers one replica of agentID. The group's other
+// instances are kept; an instance registering again replaces its old entry.
+// The agent's tools and body come from the latest registration.
+func (r *MCPRegistry) RegisterInstance(agentID string, agent *MCPAgent, instance *AgentInstance) error {
+	return r.register(agentID, agent, instance)
+}
+
+// Instances returns copies of agentID's replicas, sorted by instance ID
+func (r *MCPRegistry) Instances(agentID string) []AgentInstance {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	agent, exists := r.agents[agentID]
+	if !exists {
+		return nil
+	}
+	instances := make([]AgentInstance, 0, len(agent.Instances))
+	for _, instance := range agent.Instances {
+		instances = append(instances, *instance)
+	}
+	sort.Slice(instances, func(i, j int) bool { return instances[i].ID < instances[j].ID })
+	return instances
+}
+
+// Instance returns a copy of one of agentID's replicas
+func (r *MCPRegistry) Instance(agentID, instanceID string) (AgentInstance, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	agent, exists := r.agents[agentID]
+	if !exists {
+		return AgentInstance{}, false
+	}
+	instance, exists := agent.Instances[instanceID]
+	if !exists {
+		return AgentInstance{}, false
+	}
+	return *instance, true
+}
+
+// DrainInstance takes one of agentID's replicas out of rotation, so
+// discovery stops sending callers to it while it finishes the requests it
+// has. The returned channel is closed once the instance is drained. It
+// returns false for agents or instances that are not registered.
+func (r *MCPRegistry) DrainInstance(agentID, instanceID string) (<-chan struct{}, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	agent, exists := r.agents[agentID]
+	if !exists {
+		return nil, false
+	}
+	instance, exists := agent.Instances[instanceID]
+	if !exists {
+		return nil, false
+	}
+	if !instance.Draining() {
+		instance.DrainingSince = time.Now()
+	}
+	if instance.drained == nil {
+		instance.drained = make(chan struct{})
+	}
+	instance.signalDrained()
+	return instance.drained, true
+}
+
+// selectInstanceLocked picks the instance of a group to send the next caller
+// to: the least loaded one, rotating among instances about equally busy.
+// Draining instances are skipped. It returns nil when no instance takes
+// callers.
+func (r *MCPRegistry) selectInstanceLocked(agent *MCPAgent) *AgentInstance {
+	minLoad := math.Inf(1)
+	for _, instance := range agent.Instances {
+		if !instance.Draining() {
+			minLoad = math.Min(minLoad, instance.LastStatus.Load)
+		}
+	}
+	var candidates []*AgentInstance
+	for _, instance := range agent.Instances {
+		if !instance.Draining() && instance.LastStatus.Load-minLoad < instanceLoadTolerance {
+			candidates = append(candidates, instance)
+		}
+	}
+	if len(candidates) == 0 {
+		return nil
+	}
+	sort.Slice(candidates, func(i, j int) bool { return candidates[i].ID < candidates[j].ID })
+	next := r.nextInstance.Add(1)
+	return candidates[next%uint64(len(candidates))]
+}
+
+// activeInstances counts the instances of a group that take callers
+func activeInstances(agent *MCPAgent) int {
+	active := 0
+	for _, instance := range agent.Instances {
+		if !instance.Draining() {
+			active++
+		}
+	}
+	return active
+}
+
+// aggregateStatus combines the latest heartbeats of a group's instances:
+// requests in flight add up, load is the mean and uptime the longest.
+func aggregateStatus(instances map[string]*AgentInstance) protocol.HeartbeatBody {
+	var status protocol.HeartbeatBody
+	if len(instances) == 0 {
+		return status
+	}
+	for _, instance := range instances {
+		status.Load += instance.LastStatus.Load
+		status.InFlight += instance.LastStatus.InFlight
+		status.GPUUtilization += instance.LastStatus.GPUUtilization
+		if instance.LastStatus.Uptime > status.Uptime {
+			status.Uptime = instance.LastStatus.Uptime
+		}
+	}
+	status.Load /= float64(len(instances))
+	status.GPUUtilization /= float64(len(instances))
+	return status
+}
+
+// instanceKeyMatches reports whether a replica registering for agentID
+// presents the key of the agent's other instances. The first instance sets
+// the key, and may change it when registering again.
+func (b *Broker) instanceKeyMatches(agentID string, body protocol.RegisterAgentBody) bool {
+	instances := b.mcpRegistry.Instances(agentID)
+	if len(instances) == 0 || (len(instances) == 1 && instances[0].ID == body.InstanceID) {
+		return true
+	}
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	existing, exists := b.agents[agentID]
+	return !exists || existing.PubKey == body.PubKey
+}
+
+// handleDrainInstance takes one of the sending agent's replicas out of
+// rotation ahead of stopping it. With a wait, the ack is held until the
+// instance is drained or the wait runs out; the result says whether the
+// instance can be stopped without failing calls.
+func (b *Broker) handleDrainInstance(w http.ResponseWriter, r *http.Request, env *protocol.GenericEnvelope) {
+	typed, err := protocol.ParseTyped[protocol.DrainInstanceBody](env)
+	if err != nil {
+		b.rejectInvalidBody(w, env, err)
+		return
+	}
+	body := typed.Body
+
+	drained, known := b.mcpRegistry.DrainInstance(env.Agent, body.InstanceID)
+	if !known {
+		b.reject(w, env, protocol.CodeUnknownAgent, "Instance not registered")
+		return
+	}
+	log.Printf("Draining instance %s of MCP agent %s", body.InstanceID, env.Agent)
+
+	if body.Wait > 0 {
+		wait := min(time.Duration(body.Wait)*time.Second, maxDrainWait)
+		timer := time.NewTimer(wait)
+		select {
+		case <-drained:
+		case <-timer.C:
+		case <-r.Context().Done():
+		}
+		timer.Stop()
+	}
+
+	// An instance collected while waiting no longer receives callers either
+	status := protocol.DrainStatusBody{InstanceID: body.InstanceID, Drained: true}
+	if instance, exists := b.mcpRegistry.Instance(env.Agent, body.InstanceID); exists {
+		status.InFlight = instance.LastStatus.InFlight
+		status.Drained = instance.Drained()
+	}
+	outcome := "draining"
+	if status.Drained {
+		outcome = "drained"
+	}
+	b.writeAck(w, env, outcome, status)
+}
@@ -0,0 +1,203 @@
+package main
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/fep-fem/protocol"
+)
+
+func TestReplicasShareAgentID(t *testing.T) {
+	broker := NewBroker()
+	pubKey, privKey, _ := protocol.GenerateKeyPair()
+	otherPub, otherKey, _ := protocol.GenerateKeyPair()
+
+	post := func(envelope interface{}) *httptest.ResponseRecorder {
+		data, _ := json.Marshal(envelope)
+		recorder := httptest.NewRecorder()
+		broker.ServeHTTP(recorder, httptest.NewRequest(http.MethodPost, "/", bytes.NewReader(data)))
+		return recorder
+	}
+	register := func(instance string, pub ed25519.PublicKey, priv ed25519.PrivateKey) *httptest.ResponseRecorder {
+		envelope := protocol.NewTypedEnvelope("math", protocol.RegisterAgentBody{
+			PubKey:         protocol.EncodePublicKey(pub),
+			MCPEndpoint:    "http://" + instance + ":8080/mcp",
+			BodyDefinition: &protocol.BodyDefinition{MCPTools: []protocol.MCPTool{{Name: "math.add"}}},
+			InstanceID:     instance,
+		})
+		envelope.Sign(priv)
+		return post(envelope)
+	}
+	heartbeat := func(instance string, load float64, inFlight int) *httptest.ResponseRecorder {
+		envelope, err := protocol.NewHeartbeat("math").Instance(instance).Load(load).InFlight(inFlight).SignWith(privKey)
+		if err != nil {
+			t.Fatalf("Failed to build heartbeat: %v", err)
+		}
+		return post(envelope)
+	}
+
+	for _, instance := range []string{"replica-a", "replica-b"} {
+		if resp := register(instance, pubKey, privKey); resp.Code != http.StatusOK {
+			t.Fatalf("Registration of %s should be accepted, got %d %s", instance, resp.Code, resp.Body.String())
+		}
+	}
+	if resp := register("replica-c", otherPub, otherKey); resp.Code != http.StatusForbidden {
+		t.Errorf("Replica with another key should be refused, got %d", resp.Code)
+	}
+	if instances := broker.mcpRegistry.Instances("math"); len(instances) != 2 {
+		t.Fatalf("Expected two instances, got %+v", instances)
+	}
+
+	// Equally loaded instances take turns answering discovery
+	endpoints := make(map[string]bool)
+	for i := 0; i < 4; i++ {
+		tools, _ := broker.mcpRegistry.DiscoverTools(protocol.ToolQuery{Capabilities: []string{"math.add"}})
+		if len(tools) != 1 || tools[0].Metadata.Instances != 2 {
+			t.Fatalf("Expected one agent with two instances, got %+v", tools)
+		}
+		endpoints[tools[0].MCPEndpoint] = true
+	}
+	if len(endpoints) != 2 {
+		t.Errorf("Discovery should spread callers over both instances, got %v", endpoints)
+	}
+
+	// Heartbeats are combined, and the least loaded instance is preferred
+	if resp := heartbeat("replica-a", 0.8, 6); resp.Code != http.StatusOK {
+		t.Fatalf("Heartbeat should be accepted, got %d %s", resp.Code, resp.Body.String())
+	}
+	heartbeat("replica-b", 0.2, 1)
+	agent, _ := broker.mcpRegistry.GetAgent("math")
+	if agent.LastStatus.InFlight != 7 || agent.LastStatus.Load != 0.5 {
+		t.Errorf("Expected combined status of 7 in flight at load 0.5, got %+v", agent.LastStatus)
+	}
+	broker.federation.metricsMutex.RLock()
+	loadScore := broker.federation.agentMetrics["math"].LoadScore
+	broker.federation.metricsMutex.RUnlock()
+	if loadScore != 0.5 {
+		t.Errorf("Routing metrics should see the combined load, got %v", loadScore)
+	}
+	tools, _ := broker.mcpRegistry.DiscoverTools(protocol.ToolQuery{Capabilities: []string{"math.add"}})
+	if tools[0].MCPEndpoint != "http://replica-b:8080/mcp" {
+		t.Errorf("Less loaded instance should be chosen, got %s", tools[0].MCPEndpoint)
+	}
+
+	if resp := heartbeat("replica-z", 0, 0); resp.Code != http.StatusNotFound {
+		t.Errorf("Heartbeat from an unknown instance should be 404, got %d", resp.Code)
+	}
+}
+
+func TestRegistryExpiresSilentInstances(t *testing.T) {
+	registry := NewMCPRegistry()
+	for _, id := range []string{"replica-a", "replica-b"} {
+		registry.RegisterInstance("math", &MCPAgent{
+			ID:            "math",
+			Tools:         []protocol.MCPTool{{Name: "math.add"}},
+			LastHeartbeat: time.Now(),
+		}, &AgentInstance{ID: id, MCPEndpoint: "http://" + id + ":8080/mcp", LastHeartbeat: time.Now()})
+	}
+	agent, _ := registry.GetAgent("math")
+	agent.Instances["replica-a"].LastHeartbeat = time.Now().Add(-time.Hour)
+
+	events := registry.RemoveStale(10 * time.Minute)
+	if len(events) != 1 || events[0].Type != RegistryInstanceExpired || events[0].Instance != "replica-a" {
+		t.Fatalf("Expected replica-a to expire, got %+v", events)
+	}
+	if instances := registry.Instances("math"); len(instances) != 1 || instances[0].ID != "replica-b" {
+		t.Errorf("Expected only replica-b to remain, got %+v", instances)
+	}
+	if registry.GetToolCount() != 1 {
+		t.Error("Tools of a group with live instances should stay indexed")
+	}
+}
+
+func TestDrainingInstanceLeavesRotation(t *testing.T) {
+	broker := NewBroker()
+	pubKey, privKey, _ := protocol.GenerateKeyPair()
+	post := func(envelope interface{}) *httptest.ResponseRecorder {
+		data, _ := json.Marshal(envelope)
+		recorder := httptest.NewRecorder()
+		broker.ServeHTTP(recorder, httptest.NewRequest(http.MethodPost, "/", bytes.NewReader(data)))
+		return recorder
+	}
+	for _, instance := range []string{"replica-a", "replica-b"} {
+		envelope := protocol.NewTypedEnvelope("math", protocol.RegisterAgentBody{
+			PubKey:         protocol.EncodePublicKey(pubKey),
+			MCPEndpoint:    "http://" + instance + ":8080/mcp",
+			BodyDefinition: &protocol.BodyDefinition{MCPTools: []protocol.MCPTool{{Name: "math.add"}}},
+			InstanceID:     instance,
+		})
+		envelope.Sign(privKey)
+		if resp := post(envelope); resp.Code != http.StatusOK {
+			t.Fatalf("Registration of %s should be accepted, got %d %s", instance, resp.Code, resp.Body.String())
+		}
+	}
+	heartbeat := func(instance string, inFlight int) map[string]interface{} {
+		envelope, _ := protocol.NewHeartbeat("math").Instance(instance).InFlight(inFlight).SignWith(privKey)
+		var ack protocol.AckEnvelope
+		json.Unmarshal(post(envelope).Body.Bytes(), &ack)
+		result, _ := ack.Body.Result.(map[string]interface{})
+		return result
+	}
+	drain := func(instance string, wait int64) (protocol.AckBody, protocol.DrainStatusBody) {
+		envelope := protocol.NewTypedEnvelope("math", protocol.DrainInstanceBody{InstanceID: instance, Wait: wait})
+		envelope.Sign(privKey)
+		resp := post(envelope)
+		if resp.Code != http.StatusOK {
+			t.Errorf("Drain should be accepted, got %d %s", resp.Code, resp.Body.String())
+		}
+		var ack protocol.AckEnvelope
+		json.Unmarshal(resp.Body.Bytes(), &ack)
+		var status protocol.DrainStatusBody
+		data, _ := json.Marshal(ack.Body.Result)
+		json.Unmarshal(data, &status)
+		return ack.Body, status
+	}
+
+	heartbeat("replica-a", 3)
+	ack, status := drain("replica-a", 0)
+	if ack.Status != "draining" || status.Drained || status.InFlight != 3 {
+		t.Fatalf("Instance with calls in flight should still be draining, got %s %+v", ack.Status, status)
+	}
+
+	// Callers are only sent to the other instance
+	for i := 0; i < 4; i++ {
+		tools, _ := broker.mcpRegistry.DiscoverTools(protocol.ToolQuery{Capabilities: []string{"math.add"}})
+		if len(tools) != 1 || tools[0].MCPEndpoint != "http://replica-b:8080/mcp" || tools[0].Metadata.Instances != 1 {
+			t.Fatalf("Discovery should skip the draining instance, got %+v", tools)
+		}
+	}
+	if result := heartbeat("replica-a", 1); result["draining"] != true || result["drained"] != false {
+		t.Errorf("Heartbeat ack should tell the instance it is draining, got %v", result)
+	}
+
+	// A waiting drain is confirmed once the instance reports nothing in flight
+	done := make(chan protocol.AckBody)
+	go func() {
+		ack, _ := drain("replica-a", 5)
+		done <- ack
+	}()
+	time.Sleep(50 * time.Millisecond)
+	heartbeat("replica-a", 0)
+	select {
+	case ack := <-done:
+		if ack.Status != "drained" {
+			t.Errorf("Expected the instance to be drained, got %s", ack.Status)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Drain should be confirmed once the instance is idle")
+	}
+
+	// With every instance draining the agent is no longer discovered
+	drain("replica-b", 0)
+	if result := heartbeat("replica-b", 0); result["drained"] != true {
+		t.Errorf("Idle instance should be drained after its next heartbeat, got %v", result)
+	}
+	if tools, _ := broker.mcpRegistry.DiscoverTools(protocol.ToolQuery{Capabilities: []string{"math.add"}}); len(tools) != 0 {
+		t.Errorf("Agent with only draining instances should not be discovered, got %+v", tools)
+	}
+}
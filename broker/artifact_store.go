@@ -0,0 +1,111 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Artifact describes a blob held by the broker. The blob itself is only
+// handed out by digest.
+type Artifact struct {
+	Digest  string    `json:"digest"`
+	Size    int       `json:"size"`
+	Refs    int       `json:"refs"` // Holders that stored the same content
+	Created time.Time `json:"created"`
+
+	data []byte
+}
+
+// ArtifactStore keeps blobs agents hand to the broker, such as checkpoint
+// state, addressed by the SHA-256 digest of their content. Storing the same
+// content twice keeps one copy until both holders release it.
+type ArtifactStore struct {
+	artifacts map[string]*Artifact
+	mu        sync.RWMutex
+}
+
+// NewArtifactStore creates an empty store
+func NewArtifactStore() *ArtifactStore {
+	return &ArtifactStore{artifacts: make(map[string]*Artifact)}
+}
+
+// artifactDigest returns the address of a blob, "sha256:<hex>"
+func artifactDigest(data []byte) string {
+	sum := sha256.Sum256(data)
+	return "sha256:" + hex.EncodeToString(sum[:])
+}
+
+// Put stores a copy of data and returns its digest
+func (s *ArtifactStore) Put(data []byte) string {
+	digest := artifactDigest(data)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if artifact, exists := s.artifacts[digest]; exists {
+		artifact.Refs++
+		return digest
+	}
+	s.artifacts[digest] = &Artifact{
+		Digest:  digest,
+		Size:    len(data),
+		Refs:    1,
+		Created: time.Now(),
+		data:    append([]byte(nil), data...),
+	}
+	return digest
+}
+
+// Get returns the content stored under digest
+func (s *ArtifactStore) Get(digest string) ([]byte, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	artifact, exists := s.artifacts[digest]
+	if !exists {
+		return nil, false
+	}
+	return artifact.data, true
+}
+
+// Release drops one holder of digest, deleting the content once none is left
+func (s *ArtifactStore) Release(digest string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	artifact, exists := s.artifacts[digest]
+	if !exists {
+		return
+	}
+	if artifact.Refs--; artifact.Refs <= 0 {
+		delete(s.artifacts, digest)
+	}
+}
+
+// List describes the stored artifacts, oldest first
+func (s *ArtifactStore) List() []Artifact {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	list := make([]Artifact, 0, len(s.artifacts))
+	for _, artifact := range s.artifacts {
+		entry := *artifact
+		entry.data = nil
+		list = append(list, entry)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if !list[i].Created.Equal(list[j].Created) {
+			return list[i].Created.Before(list[j].Created)
+		}
+		return list[i].Digest < list[j].Digest
+	})
+	return list
+}
+
+// handleAdminArtifacts lists the stored artifacts
+func (b *Broker) handleAdminArtifacts(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	writeJSON(w, http.StatusOK, b.artifacts.List())
+}
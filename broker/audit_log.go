@@ -0,0 +1,186 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/fep-fem/protocol"
+)
+
+// maxAuditRecords bounds the number of audit records kept in memory
+const maxAuditRecords = 100000
+
+// AuditCategory classifies an audit record for compliance reporting
+type AuditCategory string
+
+const (
+	AuditAccess     AuditCategory = "access"     // A tool call the broker accepted
+	AuditDenial     AuditCategory = "denial"     // An envelope refused by authentication or policy
+	AuditRevocation AuditCategory = "revocation" // An agent, broker or capability token revoked
+	AuditViolation  AuditCategory = "violation"  // A data handling constraint was enforced
+)
+
+// deniedCodes are the rejections recorded as policy denials
+var deniedCodes = map[protocol.ErrorCode]bool{
+	protocol.CodeInvalidSignature: true,
+	protocol.CodeUnauthorized:     true,
+	protocol.CodeCapabilityDenied: true,
+	protocol.CodeForbidden:        true,
+	protocol.CodeQuarantined:      true,
+}
+
+// AuditRecord is one access or policy decision kept for compliance reports
+type AuditRecord struct {
+	Time       time.Time             `json:"time"`
+	Category   AuditCategory         `json:"category"`
+	AgentID    string                `json:"agentId,omitempty"`
+	KeyID      string                `json:"kid,omitempty"`    // Key the envelope was signed with, from its kid header
+	Tenant     string                `json:"tenant,omitempty"` // Namespace of the agent, the first dot-separated segment of its ID
+	Envelope   protocol.EnvelopeType `json:"envelope,omitempty"`
+	Tool       string                `json:"tool,omitempty"`
+	Outcome    string                `json:"outcome"` // "allowed", "revoked", "redacted" or the rejection's error code
+	Constraint string                `json:"constraint,omitempty"`
+	Detail     string                `json:"detail,omitempty"`
+}
+
+// AuditLog keeps recent audit records in the order they were made
+type AuditLog struct {
+	records []AuditRecord
+	mu      sync.RWMutex
+}
+
+// NewAuditLog creates an empty audit log
+func NewAuditLog() *AuditLog {
+	return &AuditLog{}
+}
+
+// Record appends a record, filling in its time and tenant
+func (l *AuditLog) Record(record AuditRecord) {
+	if record.Time.IsZero() {
+		record.Time = time.Now()
+	}
+	if record.Tenant == "" && record.AgentID != "" {
+		record.Tenant = agentNamespace(record.AgentID)
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.records = append(l.records, record)
+	if len(l.records) > maxAuditRecords {
+		l.records = l.records[len(l.records)-maxAuditRecords:]
+	}
+}
+
+// Query returns the records made in [since, until), oldest first. A non-empty
+// tenant keeps only that tenant's records.
+func (l *AuditLog) Query(since, until time.Time, tenant string) []AuditRecord {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	var records []AuditRecord
+	for _, record := range l.records {
+		if record.Time.Before(since) || !record.Time.Before(until) {
+			continue
+		}
+		if tenant != "" && record.Tenant != tenant {
+			continue
+		}
+		records = append(records, record)
+	}
+	return records
+}
+
+// AuditRecorder is middleware recording tool calls, policy denials, agent
+// revocations and constraint violations in the broker's audit log. Register it
+// first so its AfterDispatch sees rejections from every later middleware.
+type AuditRecorder struct {
+	log *AuditLog
+}
+
+// NewAuditRecorder creates the recorder and subscribes it to the broker's
+// security events, which report capability revocations and PII redactions
+func NewAuditRecorder(broker *Broker) *AuditRecorder {
+	recorder := &AuditRecorder{log: broker.audit}
+	broker.securityEvents.OnEvent(recorder.recordSecurityEvent)
+	return recorder
+}
+
+// BeforeDispatch does nothing; outcomes are only known after dispatch
+func (r *AuditRecorder) BeforeDispatch(ctx *EnvelopeContext) error {
+	return nil
+}
+
+// AfterDispatch records the envelope's outcome if it matters for compliance
+func (r *AuditRecorder) AfterDispatch(ctx *EnvelopeContext, result *EnvelopeResult) {
+	env := ctx.Envelope
+	var body struct {
+		Tool   string `json:"tool"`
+		Target string `json:"target"`
+	}
+	json.Unmarshal(env.Body, &body)
+
+	record := AuditRecord{AgentID: env.Agent, KeyID: env.KID, Envelope: env.Type, Tool: body.Tool}
+	if rejection := rejectionBody(result); rejection != nil {
+		record.Outcome = string(rejection.Code)
+		record.Detail = rejection.Message
+		record.Constraint, _ = rejection.Details["constraint"].(string)
+		switch {
+		case record.Constraint != "" || rejection.Code == protocol.CodePolicyViolation:
+			record.Category = AuditViolation
+		case deniedCodes[rejection.Code]:
+			record.Category = AuditDenial
+		default:
+			return
+		}
+		r.log.Record(record)
+		return
+	}
+	if result.Status != http.StatusOK {
+		return
+	}
+
+	switch env.Type {
+	case protocol.EnvelopeToolCall:
+		record.Category = AuditAccess
+		record.Outcome = "allowed"
+	case protocol.EnvelopeRevoke:
+		record.Category = AuditRevocation
+		record.Outcome = "revoked"
+		record.Detail = "revoked " + body.Target
+	default:
+		return
+	}
+	r.log.Record(record)
+}
+
+// recordSecurityEvent records the security events that are compliance
+// relevant and never surface as a rejected envelope
+func (r *AuditRecorder) recordSecurityEvent(event SecurityEvent) {
+	record := AuditRecord{Time: event.Time, AgentID: event.AgentID, Detail: event.Detail}
+	switch event.Type {
+	case SecurityEventCapabilityRevoked:
+		record.Category = AuditRevocation
+		record.Envelope = protocol.EnvelopeRevokeCapability
+		record.Outcome = "revoked"
+	case SecurityEventPIIRedacted:
+		record.Category = AuditViolation
+		record.Outcome = "redacted"
+		record.Constraint = PIIConstraint
+	default:
+		return
+	}
+	r.log.Record(record)
+}
+
+// rejectionBody decodes the error envelope in a failed response
+func rejectionBody(result *EnvelopeResult) *protocol.ErrorBody {
+	if result.Status < http.StatusBadRequest {
+		return nil
+	}
+	var rejection protocol.ErrorEnvelope
+	if err := json.Unmarshal(result.Body, &rejection); err != nil || rejection.Body.Code == "" {
+		return nil
+	}
+	return &rejection.Body
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+
+	"github.com/fep-fem/protocol"
+)
+
+// discardResponseWriter gives a batch item's signedResponseWriter headers to
+// set; the item's response is collected from its buffer, never written out
+type discardResponseWriter struct {
+	header http.Header
+}
+
+func (d *discardResponseWriter) Header() http.Header            { return d.header }
+func (d *discardResponseWriter) Write(data []byte) (int, error) { return len(data), nil }
+func (d *discardResponseWriter) WriteHeader(int)                {}
+
+// handleBatch processes each envelope of a batch in order, exactly as if it
+// had been posted on its own, and reports every outcome. The batch is
+// acknowledged even when some of its envelopes are rejected.
+func (b *Broker) handleBatch(w http.ResponseWriter, r *http.Request, env *protocol.GenericEnvelope) {
+	typed, err := protocol.ParseTyped[protocol.BatchBody](env)
+	if err != nil {
+		b.rejectInvalidBody(w, env, err)
+		return
+	}
+
+	result := protocol.BatchResultBody{Results: make([]protocol.BatchItemResult, len(typed.Body.Envelopes))}
+	for i, raw := range typed.Body.Envelopes {
+		item := b.processBatchItem(r, raw)
+		item.Index = i
+		if item.Succeeded() {
+			result.Succeeded++
+		} else {
+			result.Failed++
+		}
+		result.Results[i] = item
+	}
+
+	log.Printf("Batch from %s: %d processed, %d rejected", env.Agent, result.Succeeded, result.Failed)
+	b.writeAck(w, env, "processed", result)
+}
+
+// processBatchItem runs one envelope of a batch through the checks and
+// middleware a posted envelope gets. Items run inline on the batch's worker,
+// so a full pool cannot deadlock a batch waiting on its own items.
+func (b *Broker) processBatchItem(r *http.Request, raw json.RawMessage) protocol.BatchItemResult {
+	w := newSignedResponseWriter(&discardResponseWriter{header: make(http.Header)})
+	item := protocol.BatchItemResult{}
+
+	envelope, err := protocol.ParseEnvelope(raw)
+	switch {
+	case err != nil:
+		b.rejectMalformed(w, err)
+	case envelope.Type == protocol.EnvelopeBatch:
+		b.reject(w, envelope, protocol.CodeInvalidBody, "Batches cannot be nested")
+	case b.checkClockSkew(w, envelope):
+		log.Printf("Received %s envelope from %s in batch", envelope.Type, envelope.Agent)
+		b.processEnvelope(w, r, envelope)
+	}
+	if envelope != nil {
+		item.Type = envelope.Type
+		item.Nonce = envelope.Nonce
+	}
+
+	item.Status = w.status
+	if w.body.Len() > 0 {
+		item.Response = append(json.RawMessage(nil), w.body.Bytes()...)
+	}
+	return item
+}
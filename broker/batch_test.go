@@ -0,0 +1,98 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/fep-fem/protocol"
+)
+
+func TestBrokerProcessesBatchItemsInOrder(t *testing.T) {
+	broker := NewBroker()
+	_, privKey, _ := protocol.GenerateKeyPair()
+
+	emit := func(topic string) *protocol.TypedEnvelope[protocol.EmitEventBody] {
+		envelope := protocol.NewTypedEnvelope("ci-runner", protocol.EmitEventBody{Event: topic})
+		envelope.Sign(privKey)
+		return envelope
+	}
+	started := emit("ci.build.started")
+	nested, _ := protocol.NewBatch("ci-runner").Add(emit("ci.nested")).Build()
+	batch, err := protocol.NewBatch("ci-runner").
+		Add(started).
+		Add(emit("ci..broken")).
+		Add(map[string]interface{}{"type": "emitEvent", "agent": "ci-runner"}).
+		Add(nested).
+		Add(emit("ci.build.finished")).
+		SignWith(privKey)
+	if err != nil {
+		t.Fatalf("Failed to build batch: %v", err)
+	}
+	data, _ := json.Marshal(batch)
+	recorder := httptest.NewRecorder()
+	broker.ServeHTTP(recorder, httptest.NewRequest(http.MethodPost, "/", bytes.NewReader(data)))
+
+	var result protocol.BatchResultBody
+	if err := readAck(t, recorder.Body).ResultAs(&result); err != nil {
+		t.Fatalf("Invalid batch result: %v", err)
+	}
+	if result.Succeeded != 2 || result.Failed != 3 || len(result.Results) != 5 {
+		t.Fatalf("Expected 2 processed and 3 rejected, got %+v", result)
+	}
+
+	wantCodes := []protocol.ErrorCode{"", protocol.CodeInvalidBody, protocol.CodeInvalidEnvelope, protocol.CodeInvalidBody, ""}
+	for i, item := range result.Results {
+		if item.Index != i {
+			t.Errorf("Result %d reports index %d", i, item.Index)
+		}
+		_, err := protocol.ParseResponse(item.Response)
+		var rejection *protocol.ErrorBody
+		switch {
+		case wantCodes[i] == "" && (err != nil || !item.Succeeded()):
+			t.Errorf("Item %d should be acknowledged, got %d %v", i, item.Status, err)
+		case wantCodes[i] != "" && (!errors.As(err, &rejection) || rejection.Code != wantCodes[i] || item.Succeeded()):
+			t.Errorf("Item %d should be rejected with %s, got %d %v", i, wantCodes[i], item.Status, err)
+		}
+	}
+	if first := result.Results[0]; first.Nonce != started.Nonce || first.Type != protocol.EnvelopeEmitEvent {
+		t.Errorf("Item should report its envelope's type and nonce, got %+v", first)
+	}
+
+	// Only the valid events were published
+	var published []string
+	for _, topic := range broker.events.Topics() {
+		published = append(published, topic.Topic)
+	}
+	if len(published) != 2 {
+		t.Errorf("Expected the two valid events to be published, got %v", published)
+	}
+}
+
+func TestMCPClientSendBatch(t *testing.T) {
+	broker := NewBroker()
+	server := httptest.NewTLSServer(broker)
+	defer server.Close()
+
+	pubKey, privKey, _ := protocol.GenerateKeyPair()
+	registerDiscoveryClient(broker, "caller", pubKey)
+	broker.mcpRegistry.RegisterAgent("caller", &MCPAgent{ID: "caller"})
+	client := NewMCPClient(MCPClientConfig{AgentID: "caller", BrokerURL: server.URL, PrivateKey: privKey, TLSInsecure: true})
+
+	first, _ := protocol.NewHeartbeat("caller").Load(0.2).SignWith(privKey)
+	second, _ := protocol.NewHeartbeat("caller").Load(0.4).SignWith(privKey)
+	result, err := client.SendBatch(first, second)
+	if err != nil {
+		t.Fatalf("Batch failed: %v", err)
+	}
+	if result.Succeeded != 2 || result.Failed != 0 {
+		t.Errorf("Expected both heartbeats to be processed, got %+v", result)
+	}
+
+	if _, err := client.SendBatch(); err == nil {
+		t.Error("Empty batch should be refused before it is sent")
+	}
+}
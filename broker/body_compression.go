@@ -0,0 +1,62 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+
+	"github.com/fep-fem/protocol"
+)
+
+// SetCompressionThreshold sets the smallest body compressed for agents that
+// negotiated compression at registration; 0 turns compression off for agents
+// registering from now on
+func (b *Broker) SetCompressionThreshold(minBytes int) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.compressionMinBytes = minBytes
+}
+
+// negotiateCompression picks the encoding for bodies exchanged with an agent
+// from the ones it offered, or returns nil to exchange them uncompressed
+func (b *Broker) negotiateCompression(offered []protocol.ContentEncoding) *protocol.CompressionPolicy {
+	b.mu.RLock()
+	minBytes := b.compressionMinBytes
+	b.mu.RUnlock()
+	if minBytes <= 0 {
+		return nil
+	}
+	return protocol.NegotiateCompression(offered, minBytes)
+}
+
+// compressionFor returns the policy negotiated with a registered agent
+func (b *Broker) compressionFor(agentID string) *protocol.CompressionPolicy {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	if agent, exists := b.agents[agentID]; exists {
+		return agent.Compression
+	}
+	return nil
+}
+
+// writeEnvelope sends a response envelope, compressing its body under the
+// policy negotiated with the agent it answers
+func (b *Broker) writeEnvelope(w http.ResponseWriter, status int, agentID string, envelope interface{}) {
+	policy := b.compressionFor(agentID)
+	if policy == nil {
+		writeJSON(w, status, envelope)
+		return
+	}
+	data, err := json.Marshal(envelope)
+	if err == nil {
+		data, err = protocol.CompressBody(data, policy)
+	}
+	if err != nil {
+		log.Printf("Failed to compress response to %s: %v", agentID, err)
+		writeJSON(w, status, envelope)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(append(data, '\n'))
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/fep-fem/protocol"
+)
+
+func TestCompressionNegotiatedAtRegistration(t *testing.T) {
+	broker := NewBroker()
+	broker.SetCompressionThreshold(1024)
+	pub, priv, _ := protocol.GenerateKeyPair()
+
+	register := protocol.NewTypedEnvelope("caller", protocol.RegisterAgentBody{
+		PubKey:      protocol.EncodePublicKey(pub),
+		Compression: []protocol.ContentEncoding{protocol.EncodingZstd, protocol.EncodingGzip},
+	})
+	register.Sign(priv)
+	ack := sendForAck(t, broker, register)
+	var result struct {
+		Compression *protocol.CompressionPolicy `json:"compression"`
+	}
+	data, _ := json.Marshal(ack.Result)
+	json.Unmarshal(data, &result)
+	if result.Compression == nil || result.Compression.Encoding != protocol.EncodingZstd || result.Compression.MinBytes != 1024 {
+		t.Fatalf("Expected the preferred zstd agreed, got %s", data)
+	}
+
+	// Without an offer, bodies stay uncompressed
+	plain := protocol.NewTypedEnvelope("plain", protocol.RegisterAgentBody{PubKey: protocol.EncodePublicKey(pub)})
+	plain.Sign(priv)
+	if ack := sendForAck(t, broker, plain); strings.Contains(string(mustJSON(ack.Result)), "compression") {
+		t.Errorf("No compression should be agreed without an offer, got %+v", ack.Result)
+	}
+}
+
+func TestLargeResultsCompressedBothWays(t *testing.T) {
+	broker := NewBroker()
+	broker.SetCompressionThreshold(1024)
+	pub, priv, _ := protocol.GenerateKeyPair()
+	register := protocol.NewTypedEnvelope("caller", protocol.RegisterAgentBody{
+		PubKey:      protocol.EncodePublicKey(pub),
+		Compression: []protocol.ContentEncoding{protocol.EncodingGzip},
+	})
+	register.Sign(priv)
+	sendForAck(t, broker, register)
+
+	responses := make(chan *httptest.ResponseRecorder, 1)
+	go func() {
+		call, _ := protocol.NewToolCall("caller").Tool("build.run").RequestID("build-1").
+			ResultDelivery(protocol.ResultDelivery{Mode: protocol.ResultDeliverySync, Timeout: 5}).SignWith(priv)
+		data, _ := json.Marshal(call)
+		resp := httptest.NewRecorder()
+		broker.ServeHTTP(resp, httptest.NewRequest(http.MethodPost, "/", bytes.NewReader(data)))
+		responses <- resp
+	}()
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		if _, waiting := broker.results.Caller("build-1"); waiting {
+			break
+		}
+	}
+
+	// The builder sends its log compressed; the broker inflates it before parsing
+	output := strings.Repeat("compiling package ok\n", 4096)
+	builderKey := registerProvider(broker, "builder", "build.run")
+	result, _ := protocol.NewToolResult("builder", "build-1").Result(map[string]interface{}{"output": output}).SignWith(builderKey)
+	data, _ := json.Marshal(result)
+	compressed, err := protocol.CompressBody(data, &protocol.CompressionPolicy{Encoding: protocol.EncodingGzip, MinBytes: 1024})
+	if err != nil || bytes.Equal(compressed, data) {
+		t.Fatalf("Failed to compress result: %v", err)
+	}
+	resp := httptest.NewRecorder()
+	broker.ServeHTTP(resp, httptest.NewRequest(http.MethodPost, "/", bytes.NewReader(compressed)))
+	if resp.Code != http.StatusOK {
+		t.Fatalf("Compressed result should be accepted, got %d %s", resp.Code, resp.Body.String())
+	}
+
+	// The caller agreed to gzip, so the ack carrying the result comes back compressed
+	reply := <-responses
+	if reply.Body.Len() >= len(output) || !strings.Contains(reply.Body.String(), `"zip":"gzip"`) {
+		t.Fatalf("Expected a compressed ack of %d bytes, got %d", len(output), reply.Body.Len())
+	}
+	ackEnv, err := protocol.ParseEnvelope(reply.Body.Bytes())
+	if err != nil {
+		t.Fatalf("Compressed ack should parse: %v", err)
+	}
+	typed, err := protocol.ParseTyped[protocol.AckBody](ackEnv)
+	if err != nil || typed.Body.Status != "completed" || !strings.Contains(string(mustJSON(typed.Body.Result)), "compiling package ok") {
+		t.Errorf("Expected the result in the inflated ack, got %v", err)
+	}
+}
+
+func TestZstdContentEncodingAccepted(t *testing.T) {
+	broker := NewBroker()
+	pub, priv, _ := protocol.GenerateKeyPair()
+	register := protocol.NewTypedEnvelope("caller", protocol.RegisterAgentBody{PubKey: protocol.EncodePublicKey(pub)})
+	register.Sign(priv)
+	data, err := protocol.EncodeEnvelope(register, protocol.EncodingZstd)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req := httptest.NewRequest(http.MethodPost, "/", bytes.NewReader(data))
+	req.Header.Set("Content-Encoding", string(protocol.EncodingZstd))
+	resp := httptest.NewRecorder()
+	broker.ServeHTTP(resp, req)
+	if resp.Code != http.StatusOK {
+		t.Fatalf("Expected a zstd-encoded envelope accepted, got %d %s", resp.Code, resp.Body.String())
+	}
+}
+
+func mustJSON(v interface{}) []byte {
+	data, _ := json.Marshal(v)
+	return data
+}
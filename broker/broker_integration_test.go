@@ -4,9 +4,12 @@ import (
 	"bytes"
 	"crypto/tls"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
 	"time"
 
@@ -99,14 +102,9 @@ func TestBrokerMCPIntegration(t *testing.T) {
 			t.Errorf("Expected status 200, got %d", resp.StatusCode)
 		}
 
-		var response map[string]interface{}
-		err = json.NewDecoder(resp.Body).Decode(&response)
-		if err != nil {
-			t.Fatalf("Failed to decode response: %v", err)
-		}
-
-		if response["status"] != "registered" {
-			t.Errorf("Expected status 'registered', got %v", response["status"])
+		response := readAck(t, resp.Body)
+		if response.Status != "registered" {
+			t.Errorf("Expected status 'registered', got %v", response.Status)
 		}
 
 		// Verify agent is registered in MCP registry
@@ -122,10 +120,11 @@ func TestBrokerMCPIntegration(t *testing.T) {
 
 	// Test 2: Discover tools
 	t.Run("DiscoverTools", func(t *testing.T) {
-		_, privKey, err := protocol.GenerateKeyPair()
+		pubKey, privKey, err := protocol.GenerateKeyPair()
 		if err != nil {
 			t.Fatalf("Failed to generate key pair: %v", err)
 		}
+		registerDiscoveryClient(broker, "discovery-client", pubKey)
 
 		envelope := &protocol.DiscoverToolsEnvelope{
 			BaseEnvelope: protocol.BaseEnvelope{
@@ -165,20 +164,16 @@ func TestBrokerMCPIntegration(t *testing.T) {
 			t.Errorf("Expected status 200, got %d", resp.StatusCode)
 		}
 
-		var response map[string]interface{}
-		err = json.NewDecoder(resp.Body).Decode(&response)
-		if err != nil {
-			t.Fatalf("Failed to decode response: %v", err)
-		}
-
-		if response["status"] != "success" {
-			t.Errorf("Expected status 'success', got %v", response["status"])
+		response := readAck(t, resp.Body)
+		if response.Status != "success" {
+			t.Errorf("Expected status 'success', got %v", response.Status)
 		}
 
-		tools, ok := response["tools"].([]interface{})
-		if !ok {
-			t.Fatal("Response should contain tools array")
+		var discovered protocol.ToolsDiscoveredBody
+		if err := response.ResultAs(&discovered); err != nil {
+			t.Fatalf("Response should contain a discovery result: %v", err)
 		}
+		tools := discovered.Tools
 
 		if len(tools) == 0 {
 			t.Error("Should find at least one tool matching math.*")
@@ -186,13 +181,12 @@ func TestBrokerMCPIntegration(t *testing.T) {
 
 		// Verify tool structure
 		if len(tools) > 0 {
-			tool := tools[0].(map[string]interface{})
-			if tool["agentId"] != "test-agent-001" {
-				t.Errorf("Expected agentId 'test-agent-001', got %v", tool["agentId"])
+			tool := tools[0]
+			if tool.AgentID != "test-agent-001" {
+				t.Errorf("Expected agentId 'test-agent-001', got %v", tool.AgentID)
 			}
 
-			mcpTools, ok := tool["mcpTools"].([]interface{})
-			if !ok || len(mcpTools) == 0 {
+			if len(tool.MCPTools) == 0 {
 				t.Error("Tool should have mcpTools")
 			}
 		}
@@ -271,14 +265,9 @@ func TestBrokerMCPIntegration(t *testing.T) {
 			t.Errorf("Expected status 200, got %d", resp.StatusCode)
 		}
 
-		var response map[string]interface{}
-		err = json.NewDecoder(resp.Body).Decode(&response)
-		if err != nil {
-			t.Fatalf("Failed to decode response: %v", err)
-		}
-
-		if response["status"] != "updated" {
-			t.Errorf("Expected status 'updated', got %v", response["status"])
+		response := readAck(t, resp.Body)
+		if response.Status != "updated" {
+			t.Errorf("Expected status 'updated', got %v", response.Status)
 		}
 
 		// Verify agent was updated in registry
@@ -365,14 +354,9 @@ func TestBrokerBackwardsCompatibility(t *testing.T) {
 			t.Errorf("Expected status 200, got %d", resp.StatusCode)
 		}
 
-		var response map[string]interface{}
-		err = json.NewDecoder(resp.Body).Decode(&response)
-		if err != nil {
-			t.Fatalf("Failed to decode response: %v", err)
-		}
-
-		if response["status"] != "registered" {
-			t.Errorf("Expected status 'registered', got %v", response["status"])
+		response := readAck(t, resp.Body)
+		if response.Status != "registered" {
+			t.Errorf("Expected status 'registered', got %v", response.Status)
 		}
 
 		// Verify agent is NOT in MCP registry (since no MCP endpoint)
@@ -513,7 +497,8 @@ func TestFullMCPFederationLoop(t *testing.T) {
 	}
 
 	// Test tool discovery
-	_, clientPrivKey, _ := protocol.GenerateKeyPair()
+	clientPubKey, clientPrivKey, _ := protocol.GenerateKeyPair()
+	registerDiscoveryClient(broker, "test-mcp-client", clientPubKey)
 	
 	discoverEnv := &protocol.DiscoverToolsEnvelope{
 		BaseEnvelope: protocol.BaseEnvelope{
@@ -539,22 +524,94 @@ func TestFullMCPFederationLoop(t *testing.T) {
 		t.Fatalf("Tool discovery failed: %v", err)
 	}
 	
-	var discoveryResponse map[string]interface{}
-	json.NewDecoder(resp.Body).Decode(&discoveryResponse)
+	discoveryResponse := readAck(t, resp.Body)
 	
-	if discoveryResponse["status"] != "success" {
-		t.Errorf("Discovery should succeed, got: %v", discoveryResponse["status"])
+	if discoveryResponse.Status != "success" {
+		t.Errorf("Discovery should succeed, got: %v", discoveryResponse.Status)
 	}
 	
-	tools, ok := discoveryResponse["tools"].([]interface{})
-	if !ok || len(tools) == 0 {
+	var discovered protocol.ToolsDiscoveredBody
+	discoveryResponse.ResultAs(&discovered)
+	if len(discovered.Tools) == 0 {
 		t.Fatal("Should discover at least one tool")
 	}
 	
-	discoveredTool := tools[0].(map[string]interface{})
-	if discoveredTool["agentId"] != agent1ID {
-		t.Errorf("Discovered tool from wrong agent. Expected %s, got %s", agent1ID, discoveredTool["agentId"])
+	discoveredTool := discovered.Tools[0]
+	if discoveredTool.AgentID != agent1ID {
+		t.Errorf("Discovered tool from wrong agent. Expected %s, got %s", agent1ID, discoveredTool.AgentID)
 	}
 	
 	t.Log("Successfully discovered agent's tool via the broker.")
-}
\ No newline at end of file
+}
+func TestBrokerRejectsInvalidBodies(t *testing.T) {
+	broker := NewBroker()
+	pubKey, privKey, _ := protocol.GenerateKeyPair()
+	registerDiscoveryClient(broker, "caller", pubKey)
+
+	post := func(envType protocol.EnvelopeType, body string) *httptest.ResponseRecorder {
+		envelope := protocol.NewEnvelope(envType, "caller")
+		envelope.Body = json.RawMessage(body)
+		envelope.Sign(privKey)
+		data, _ := json.Marshal(envelope)
+		recorder := httptest.NewRecorder()
+		broker.ServeHTTP(recorder, httptest.NewRequest(http.MethodPost, "/", bytes.NewReader(data)))
+		return recorder
+	}
+
+	resp := post(protocol.EnvelopeRegisterAgent, `{}`)
+	if resp.Code != http.StatusBadRequest || !strings.Contains(resp.Body.String(), "pubkey") {
+		t.Errorf("Zero-valued registration should be rejected naming the field, got %d %s", resp.Code, resp.Body.String())
+	}
+	var rejection *protocol.ErrorBody
+	if _, err := protocol.ParseResponse(resp.Body.Bytes()); !errors.As(err, &rejection) || rejection.Code != protocol.CodeInvalidBody {
+		t.Errorf("Expected an invalid_body error envelope, got %v", err)
+	}
+	if resp := post(protocol.EnvelopeToolCall, `{"requestId":"r1"}`); resp.Code != http.StatusBadRequest {
+		t.Errorf("Tool call without a tool should be rejected, got %d", resp.Code)
+	}
+	if resp := post(protocol.EnvelopeDiscoverTools, `{"query":{"capabilities":["*.add"]}}`); resp.Code != http.StatusBadRequest {
+		t.Errorf("Malformed capability pattern should be rejected, got %d", resp.Code)
+	}
+	if resp := post(protocol.EnvelopeToolCall, `{"tool":"math.add","requestId":"r1"}`); resp.Code != http.StatusOK {
+		t.Errorf("Valid tool call should be accepted, got %d", resp.Code)
+	}
+}
+
+func TestBrokerReportsSchemaViolations(t *testing.T) {
+	broker := NewBroker()
+	post := func(data string) *protocol.ErrorBody {
+		recorder := httptest.NewRecorder()
+		broker.ServeHTTP(recorder, httptest.NewRequest(http.MethodPost, "/", strings.NewReader(data)))
+		var rejection *protocol.ErrorBody
+		if _, err := protocol.ParseResponse(recorder.Body.Bytes()); !errors.As(err, &rejection) {
+			t.Fatalf("Expected an error envelope, got %v", err)
+		}
+		return rejection
+	}
+
+	ts := fmt.Sprint(time.Now().UnixMilli())
+	rejection := post(`{"type":"toolCall","agent":"caller","ts":` + ts + `,"nonce":"n1","body":{"tool":"math.add","requestId":7,"delivery":"eventually"}}`)
+	violations, _ := rejection.Details["violations"].([]interface{})
+	if rejection.Code != protocol.CodeInvalidBody || len(violations) != 2 {
+		t.Errorf("Expected invalid_body listing both fields, got %s %v", rejection.Code, rejection.Details)
+	}
+
+	rejection = post(`{"type":"toolCall","ts":` + ts + `,"nonce":"n2","body":{"tool":"math.add","requestId":"r1"}}`)
+	if rejection.Code != protocol.CodeInvalidEnvelope {
+		t.Errorf("Missing header should be an invalid envelope, got %s", rejection.Code)
+	}
+}
+
+// readAck decodes an ack envelope from a broker response
+func readAck(t *testing.T, r io.Reader) *protocol.AckBody {
+	t.Helper()
+	data, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatalf("Failed to read response: %v", err)
+	}
+	ack, err := protocol.ParseResponse(data)
+	if err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	return ack
+}
@@ -0,0 +1,338 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fep-fem/protocol"
+)
+
+// defaultApprovalTimeout is how long a parked call waits for a decision when
+// the policy sets no timeout
+const defaultApprovalTimeout = 10 * time.Minute
+
+// Topics the broker announces parked calls and decisions on
+const (
+	approvalPendingTopic = "broker.approval.pending"
+	approvalDecidedTopic = "broker.approval.decided"
+)
+
+// ApprovalPolicy sets who decides on calls to tools tagged dangerous and how
+// long the calls wait
+type ApprovalPolicy struct {
+	Approvers map[string]ed25519.PublicKey // Approver IDs and their pinned keys, which must sign approveCall envelopes
+	Webhooks  []string                     // URLs each parked call is posted to, signed as result webhooks are
+	Timeout   time.Duration                // Calls undecided this long are rejected; defaultApprovalTimeout if 0
+}
+
+// SetApprovalPolicy sets who approves calls to dangerous tools. With no policy
+// or no approvers, calls to dangerous tools are refused.
+func (b *Broker) SetApprovalPolicy(policy *ApprovalPolicy) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.approvalPolicy = policy
+}
+
+func (b *Broker) currentApprovalPolicy() *ApprovalPolicy {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	return b.approvalPolicy
+}
+
+// parkedCall is a call held until an approver decides on it
+type parkedCall struct {
+	env     *protocol.GenericEnvelope
+	pending protocol.PendingApprovalBody
+	timer   *time.Timer
+}
+
+// approvalQueue holds the calls waiting for approval
+type approvalQueue struct {
+	calls map[string]*parkedCall // By request ID
+	mu    sync.Mutex
+}
+
+func newApprovalQueue() *approvalQueue {
+	return &approvalQueue{calls: make(map[string]*parkedCall)}
+}
+
+// park holds a call, calling expire with its request ID once it expires undecided
+func (q *approvalQueue) park(call *parkedCall, expire func(string)) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	requestID := call.pending.RequestID
+	if previous, exists := q.calls[requestID]; exists {
+		previous.timer.Stop()
+	}
+	call.timer = time.AfterFunc(time.Until(time.UnixMilli(call.pending.Expires)), func() { expire(requestID) })
+	q.calls[requestID] = call
+}
+
+// caller returns the agent that made a parked call
+func (q *approvalQueue) caller(requestID string) (string, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	call, exists := q.calls[requestID]
+	if !exists {
+		return "", false
+	}
+	return call.pending.Caller, true
+}
+
+// take releases a parked call so it can be decided on
+func (q *approvalQueue) take(requestID string) (*parkedCall, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	call, exists := q.calls[requestID]
+	if exists {
+		call.timer.Stop()
+		delete(q.calls, requestID)
+	}
+	return call, exists
+}
+
+// pending lists the parked calls, soonest to expire first
+func (q *approvalQueue) pending() []protocol.PendingApprovalBody {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	calls := make([]protocol.PendingApprovalBody, 0, len(q.calls))
+	for _, call := range q.calls {
+		calls = append(calls, call.pending)
+	}
+	sort.Slice(calls, func(i, j int) bool {
+		if calls[i].Expires != calls[j].Expires {
+			return calls[i].Expires < calls[j].Expires
+		}
+		return calls[i].RequestID < calls[j].RequestID
+	})
+	return calls
+}
+
+// requiresApproval reports whether any provider tags a tool dangerous
+func (b *Broker) requiresApproval(tool string) bool {
+	for _, registered := range b.mcpRegistry.ListTools() {
+		if registered.Tool.Name == tool && registered.Tool.Dangerous {
+			return true
+		}
+	}
+	return false
+}
+
+// checkApprovers refuses calls to a dangerous tool when nobody could approve
+// them. On failure it writes the error envelope itself.
+func (b *Broker) checkApprovers(w http.ResponseWriter, env *protocol.GenericEnvelope, tool string) bool {
+	if policy := b.currentApprovalPolicy(); policy != nil && len(policy.Approvers) > 0 {
+		return true
+	}
+	b.reject(w, env, protocol.CodeForbidden, fmt.Sprintf("Calls to %s need approval, but no approvers are configured", tool))
+	return false
+}
+
+// parkCall holds a call to a dangerous tool until an approver decides on it
+// or the policy's timeout passes, and notifies the approvers
+func (b *Broker) parkCall(env *protocol.GenericEnvelope, body protocol.ToolCallBody) protocol.PendingApprovalBody {
+	timeout := defaultApprovalTimeout
+	if policy := b.currentApprovalPolicy(); policy != nil && policy.Timeout > 0 {
+		timeout = policy.Timeout
+	}
+	call := &parkedCall{
+		env: env,
+		pending: protocol.PendingApprovalBody{
+			RequestID:  body.RequestID,
+			Caller:     env.Agent,
+			Tool:       body.Tool,
+			Parameters: body.Parameters,
+			Expires:    time.Now().Add(timeout).UnixMilli(),
+		},
+	}
+	b.approvals.park(call, b.expireApproval)
+	log.Printf("Call %s to dangerous tool %s from %s awaits approval", body.RequestID, body.Tool, env.Agent)
+	b.notifyApprovers(call.pending)
+	return call.pending
+}
+
+// notifyApprovers announces a parked call on the pending topic and posts it to
+// the policy's webhooks
+func (b *Broker) notifyApprovers(pending protocol.PendingApprovalBody) {
+	payload := map[string]interface{}{
+		"requestId": pending.RequestID,
+		"caller":    pending.Caller,
+		"tool":      pending.Tool,
+		"expires":   pending.Expires,
+	}
+	if pending.Parameters != nil {
+		payload["parameters"] = pending.Parameters
+	}
+	b.events.publish("broker", approvalPendingTopic, payload)
+
+	policy := b.currentApprovalPolicy()
+	if policy == nil || len(policy.Webhooks) == 0 {
+		return
+	}
+	data, err := json.Marshal(pending)
+	if err != nil {
+		return
+	}
+	for _, url := range policy.Webhooks {
+		go func(url string) {
+			if err := b.postSigned(url, data); err != nil {
+				log.Printf("Failed to notify approval webhook %s of %s: %v", url, pending.RequestID, err)
+			}
+		}(url)
+	}
+}
+
+// handleApproveCall applies an approver's decision on a parked call: an
+// approved call is delivered as any other, a rejected one is answered for the
+// agent with an error
+func (b *Broker) handleApproveCall(w http.ResponseWriter, r *http.Request, env *protocol.GenericEnvelope) {
+	typed, err := protocol.ParseTyped[protocol.ApproveCallBody](env)
+	if err != nil {
+		b.rejectInvalidBody(w, env, err)
+		return
+	}
+	body := typed.Body
+
+	// Approvers are checked against the keys pinned for them, not the keys
+	// agents register, so registering an approver's ID grants nothing
+	var approverKey ed25519.PublicKey
+	if policy := b.currentApprovalPolicy(); policy != nil {
+		approverKey = policy.Approvers[env.Agent]
+	}
+	if approverKey == nil {
+		b.reject(w, env, protocol.CodeForbidden, fmt.Sprintf("Agent %s may not approve calls", env.Agent))
+		return
+	}
+	if err := env.Verify(approverKey); err != nil {
+		b.recordSignatureFailure(env.Agent, requestSource(r))
+		b.reject(w, env, protocol.CodeInvalidSignature, fmt.Sprintf("Invalid signature for approver %s: %v", env.Agent, err))
+		return
+	}
+	if caller, parked := b.approvals.caller(body.RequestID); parked && caller == env.Agent {
+		// Approvers cannot wave their own calls through
+		b.reject(w, env, protocol.CodeForbidden, "Approvers cannot decide on their own calls")
+		return
+	}
+	call, exists := b.approvals.take(body.RequestID)
+	if !exists {
+		b.reject(w, env, protocol.CodeInvalidBody, fmt.Sprintf("No call %s is awaiting approval", body.RequestID))
+		return
+	}
+	b.publishApprovalDecision(call.pending, env.Agent, body.Approved, body.Reason)
+
+	if !body.Approved {
+		reason := "call rejected by " + env.Agent
+		if body.Reason != "" {
+			reason += ": " + body.Reason
+		}
+		b.answerForAgent(call.pending.RequestID, reason)
+		log.Printf("Call %s to %s rejected by %s", call.pending.RequestID, call.pending.Tool, env.Agent)
+		b.writeAck(w, env, "rejected", call.pending)
+		return
+	}
+
+	log.Printf("Call %s to %s approved by %s", call.pending.RequestID, call.pending.Tool, env.Agent)
+	if pushedTo, pushed := b.pushCall(call.env, call.pending.Tool); pushed {
+		log.Printf("Approved call %s went to %s", call.pending.RequestID, pushedTo)
+		b.requests.Advance(call.pending.RequestID, RequestRouted, pushedTo, "approved by "+env.Agent)
+	} else {
+		b.requests.Advance(call.pending.RequestID, RequestReceived, "", "approved by "+env.Agent)
+	}
+	b.writeAck(w, env, "approved", call.pending)
+}
+
+// expireApproval rejects a call nobody decided on in time
+func (b *Broker) expireApproval(requestID string) {
+	call, exists := b.approvals.take(requestID)
+	if !exists {
+		return
+	}
+	log.Printf("Call %s to %s expired awaiting approval", requestID, call.pending.Tool)
+	b.publishApprovalDecision(call.pending, "", false, "approval timed out")
+	b.answerForAgent(requestID, "approval timed out")
+}
+
+// publishApprovalDecision announces the outcome of a parked call; the approver
+// is empty for calls that expired
+func (b *Broker) publishApprovalDecision(pending protocol.PendingApprovalBody, approver string, approved bool, reason string) {
+	payload := map[string]interface{}{
+		"requestId": pending.RequestID,
+		"caller":    pending.Caller,
+		"tool":      pending.Tool,
+		"approved":  approved,
+	}
+	if approver != "" {
+		payload["approver"] = approver
+	}
+	if reason != "" {
+		payload["reason"] = reason
+	}
+	b.events.publish("broker", approvalDecidedTopic, payload)
+}
+
+// answerForAgent answers a call no agent will answer, because it was not
+// approved or made no progress, with an error the broker signs, delivered as
+// the caller asked. The caller is not charged.
+func (b *Broker) answerForAgent(requestID, reason string) {
+	b.deadlines.finish(requestID)
+	b.requests.Finish(requestID, "", reason)
+	b.transcoders.take(requestID)
+	b.federation.Budgets().settle(requestID)
+
+	envelope, err := protocol.NewToolResult(b.federation.config.LocalBrokerID, requestID).Error(reason).SignWith(b.IdentityKey())
+	if err != nil {
+		log.Printf("Failed to answer unapproved call %s: %v", requestID, err)
+		return
+	}
+	raw, err := json.Marshal(envelope)
+	if err != nil {
+		return
+	}
+	if b.results.StoreResult(requestID, raw) {
+		b.pushResult(requestID, 0, true, raw)
+	}
+}
+
+// parseList reads a comma-separated list, skipping blank entries
+func parseList(s string) []string {
+	var items []string
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// parsePinnedKeys reads comma-separated id=pubkey pairs pinning keys to IDs
+func parsePinnedKeys(spec string) (map[string]ed25519.PublicKey, error) {
+	keys := make(map[string]ed25519.PublicKey)
+	for _, item := range parseList(spec) {
+		id, encoded, ok := strings.Cut(item, "=")
+		if !ok || id == "" {
+			return nil, fmt.Errorf("expected id=pubkey, got %q", item)
+		}
+		key, err := protocol.DecodePublicKey(encoded)
+		if err != nil {
+			return nil, fmt.Errorf("invalid key for %s: %w", id, err)
+		}
+		keys[id] = key
+	}
+	return keys, nil
+}
+
+// handleAdminApprovals lists the calls awaiting approval
+func (b *Broker) handleAdminApprovals(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	writeJSON(w, http.StatusOK, b.approvals.pending())
+}
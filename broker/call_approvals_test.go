@@ -0,0 +1,185 @@
+package main
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/fep-fem/protocol"
+)
+
+// approvalBroker runs a dangerous tool and knows the approver "reviewer"
+func approvalBroker(t *testing.T, policy ApprovalPolicy) (*Broker, ed25519.PrivateKey) {
+	t.Helper()
+	broker := NewBroker()
+	broker.mcpRegistry.RegisterAgent("dba", &MCPAgent{
+		ID:    "dba",
+		Tools: []protocol.MCPTool{{Name: "db.drop", Dangerous: true}, {Name: "db.query"}},
+	})
+	pubKey, privKey, _ := protocol.GenerateKeyPair()
+	registerDiscoveryClient(broker, "reviewer", pubKey)
+	policy.Approvers = map[string]ed25519.PublicKey{"reviewer": pubKey}
+	broker.SetApprovalPolicy(&policy)
+	return broker, privKey
+}
+
+// decide sends an approver's signed decision on a call
+func decide(broker *Broker, approver string, key ed25519.PrivateKey, body protocol.ApproveCallBody) *httptest.ResponseRecorder {
+	envelope := protocol.NewTypedEnvelope(approver, body)
+	envelope.Sign(key)
+	data, _ := json.Marshal(envelope)
+	resp := httptest.NewRecorder()
+	broker.ServeHTTP(resp, httptest.NewRequest(http.MethodPost, "/", bytes.NewReader(data)))
+	return resp
+}
+
+func TestDangerousCallsAwaitApproval(t *testing.T) {
+	notified := make(chan protocol.PendingApprovalBody, 1)
+	hook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var pending protocol.PendingApprovalBody
+		payload, _ := io.ReadAll(r.Body)
+		json.Unmarshal(payload, &pending)
+		notified <- pending
+	}))
+	defer hook.Close()
+	broker, key := approvalBroker(t, ApprovalPolicy{Webhooks: []string{hook.URL}, Timeout: time.Minute})
+	var events []TopicEvent
+	broker.events.Subscribe(SubscribeRequest{Subscriber: "ops", Pattern: "broker.approval.*"}, func(event TopicEvent) {
+		events = append(events, event)
+	})
+
+	call, _ := protocol.NewToolCall("caller").Tool("db.drop").RequestID("drop-1").Param("table", "users").Build()
+	ack := sendForAck(t, broker, call)
+	if ack.Status != "pending_approval" {
+		t.Fatalf("Expected the call parked, got %+v", ack)
+	}
+	select {
+	case pending := <-notified:
+		if pending.RequestID != "drop-1" || pending.Caller != "caller" || pending.Parameters["table"] != "users" {
+			t.Errorf("Unexpected approval webhook payload %+v", pending)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Approval webhook was not called")
+	}
+	if pending := broker.approvals.pending(); len(pending) != 1 || pending[0].Tool != "db.drop" {
+		t.Fatalf("Expected one call awaiting approval, got %+v", pending)
+	}
+
+	// Calls to tools not tagged dangerous go through
+	call, _ = protocol.NewToolCall("caller").Tool("db.query").RequestID("query-1").Build()
+	if ack := sendForAck(t, broker, call); ack.Status == "pending_approval" {
+		t.Error("Calls to safe tools should not wait for approval")
+	}
+
+	resp := decide(broker, "reviewer", key, protocol.ApproveCallBody{RequestID: "drop-1", Approved: true})
+	if resp.Code != http.StatusOK {
+		t.Fatalf("Approval should be accepted, got %d %s", resp.Code, resp.Body.String())
+	}
+	if pending := broker.approvals.pending(); len(pending) != 0 {
+		t.Errorf("Approved call should no longer be pending, got %+v", pending)
+	}
+	dbaPub, dbaKey, _ := protocol.GenerateKeyPair()
+	registerDiscoveryClient(broker, "dba", dbaPub)
+	result, _ := protocol.NewToolResult("dba", "drop-1").Result("dropped").SignWith(dbaKey)
+	postEnvelope(t, broker, result)
+	if env, body := takeResult(t, broker, "caller", "drop-1"); env.Agent != "dba" || !body.Success {
+		t.Errorf("Expected the agent's result delivered, got %s %+v", env.Agent, body)
+	}
+
+	if len(events) != 2 || events[0].Topic != approvalPendingTopic || events[1].Topic != approvalDecidedTopic {
+		t.Fatalf("Expected pending and decided events, got %+v", events)
+	}
+	if events[1].Payload["approver"] != "reviewer" || events[1].Payload["approved"] != true {
+		t.Errorf("Unexpected decision event %+v", events[1].Payload)
+	}
+}
+
+func TestUnapprovedCallsAnsweredWithErrors(t *testing.T) {
+	broker, key := approvalBroker(t, ApprovalPolicy{Timeout: 50 * time.Millisecond})
+
+	// A rejection fails the call with the approver's reason
+	call, _ := protocol.NewToolCall("caller").Tool("db.drop").RequestID("drop-1").Build()
+	sendForAck(t, broker, call)
+	resp := decide(broker, "reviewer", key, protocol.ApproveCallBody{RequestID: "drop-1", Reason: "production table"})
+	if resp.Code != http.StatusOK {
+		t.Fatalf("Rejection should be accepted, got %d %s", resp.Code, resp.Body.String())
+	}
+	env, body := takeResult(t, broker, "caller", "drop-1")
+	if env.Agent != broker.federation.config.LocalBrokerID || body.Success || !strings.Contains(body.Error, "production table") {
+		t.Errorf("Expected a broker-signed rejection, got %s %+v", env.Agent, body)
+	}
+
+	// Calls nobody decides on fail once the timeout passes
+	call, _ = protocol.NewToolCall("caller").Tool("db.drop").RequestID("drop-2").Build()
+	sendForAck(t, broker, call)
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		if _, results := broker.results.Pending(); results > 0 {
+			break
+		}
+	}
+	if _, body := takeResult(t, broker, "caller", "drop-2"); body.Success || body.Error != "approval timed out" {
+		t.Errorf("Expected the call to time out, got %+v", body)
+	}
+	if resp := decide(broker, "reviewer", key, protocol.ApproveCallBody{RequestID: "drop-2", Approved: true}); resp.Code == http.StatusOK {
+		t.Error("Expired calls should not be approvable")
+	}
+}
+
+func TestApprovalsNeedAnApprover(t *testing.T) {
+	broker, key := approvalBroker(t, ApprovalPolicy{})
+
+	call, _ := protocol.NewToolCall("reviewer").Tool("db.drop").RequestID("drop-1").Build()
+	sendForAck(t, broker, call)
+	if resp := decide(broker, "reviewer", key, protocol.ApproveCallBody{RequestID: "drop-1", Approved: true}); resp.Code != http.StatusForbidden {
+		t.Errorf("Approvers should not decide on their own calls, got %d", resp.Code)
+	}
+
+	pubKey, otherKey, _ := protocol.GenerateKeyPair()
+	registerDiscoveryClient(broker, "intern", pubKey)
+	if resp := decide(broker, "intern", otherKey, protocol.ApproveCallBody{RequestID: "drop-1", Approved: true}); resp.Code != http.StatusForbidden {
+		t.Errorf("Agents that are not approvers should be refused, got %d", resp.Code)
+	}
+	if resp := decide(broker, "reviewer", otherKey, protocol.ApproveCallBody{RequestID: "drop-1", Approved: true}); resp.Code == http.StatusOK {
+		t.Error("Decisions with bad signatures should be refused")
+	}
+	if pending := broker.approvals.pending(); len(pending) != 1 {
+		t.Errorf("Refused decisions should leave the call parked, got %+v", pending)
+	}
+
+	// Without approvers nobody could decide, so dangerous calls are refused
+	broker.SetApprovalPolicy(nil)
+	data, _ := json.Marshal(call)
+	resp := httptest.NewRecorder()
+	broker.ServeHTTP(resp, httptest.NewRequest(http.MethodPost, "/", bytes.NewReader(data)))
+	if resp.Code != http.StatusForbidden {
+		t.Errorf("Dangerous calls without approvers should be refused, got %d", resp.Code)
+	}
+}
+
+func TestApproverRegistrationCannotBeHijacked(t *testing.T) {
+	broker, key := approvalBroker(t, ApprovalPolicy{Timeout: time.Minute})
+	call, _ := protocol.NewToolCall("caller").Tool("db.drop").RequestID("drop-1").Build()
+	sendForAck(t, broker, call)
+
+	// Moving the approver's registration to another key does not move the
+	// right to approve with it
+	pubKey, hijacker, _ := protocol.GenerateKeyPair()
+	broker.mu.Lock()
+	broker.agents["reviewer"].PubKey = protocol.EncodePublicKey(pubKey)
+	broker.mu.Unlock()
+	if resp := decide(broker, "reviewer", hijacker, protocol.ApproveCallBody{RequestID: "drop-1", Approved: true}); resp.Code == http.StatusOK {
+		t.Error("Decisions signed with a re-registered approver key should be refused")
+	}
+	if pending := broker.approvals.pending(); len(pending) != 1 {
+		t.Fatalf("Refused decisions should leave the call parked, got %+v", pending)
+	}
+	if resp := decide(broker, "reviewer", key, protocol.ApproveCallBody{RequestID: "drop-1", Approved: true}); resp.Code != http.StatusOK {
+		t.Errorf("The pinned approver key should still decide, got %d %s", resp.Code, resp.Body.String())
+	}
+}
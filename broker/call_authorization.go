@@ -0,0 +1,193 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/fep-fem/protocol"
+)
+
+// callPermissionPrefix marks capability permissions that allow tool calls, e.g. "call:math.*"
+const callPermissionPrefix = "call:"
+
+// Reasons a tool call is denied, sent in the error details
+const (
+	CallDeniedMissing         = "missing"          // No capability token was presented
+	CallDeniedInvalid         = "invalid"          // The token does not verify
+	CallDeniedExpired         = "expired"          // The token has expired
+	CallDeniedRevoked         = "revoked"          // The token, or one it was delegated from, was revoked
+	CallDeniedSubjectMismatch = "subject_mismatch" // The token was issued to another agent
+	CallDeniedScopeMismatch   = "scope_mismatch"   // The token's scope is not the one the tool requires
+	CallDeniedNotGranted      = "not_granted"      // The token does not grant the permission the tool requires
+)
+
+// ToolPermission sets what a call to tools matching a pattern needs
+type ToolPermission struct {
+	Tool       string `json:"tool"`                 // Tool name pattern, e.g. "shell.*"
+	Permission string `json:"permission,omitempty"` // Permission the token must grant; "call:<tool>" if empty
+	Scope      string `json:"scope,omitempty"`      // Scope the token must carry; any if empty
+	Public     bool   `json:"public,omitempty"`     // Calls need no capability token
+}
+
+// ToolCallPolicy requires capability tokens on tool calls
+type ToolCallPolicy struct {
+	Capabilities *protocol.CapabilityManager
+	Permissions  []ToolPermission // The most specific matching pattern applies
+}
+
+// LoadToolPermissions reads a JSON array of tool permissions
+func LoadToolPermissions(path string) ([]ToolPermission, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read tool permissions: %w", err)
+	}
+	var permissions []ToolPermission
+	if err := json.Unmarshal(data, &permissions); err != nil {
+		return nil, fmt.Errorf("invalid tool permissions: %w", err)
+	}
+	for i, permission := range permissions {
+		if err := protocol.ValidateCapabilityPattern(permission.Tool); err != nil {
+			return nil, fmt.Errorf("tool permission %d: %w", i, err)
+		}
+		if strings.ContainsAny(permission.Permission, " \t\r\n") {
+			return nil, fmt.Errorf("tool permission %d: permission %q contains whitespace", i, permission.Permission)
+		}
+	}
+	return permissions, nil
+}
+
+// SetToolCallPolicy requires capability tokens on tool calls; nil disables the check
+func (b *Broker) SetToolCallPolicy(policy *ToolCallPolicy) {
+	if policy != nil && policy.Capabilities != nil {
+		policy.Capabilities.SetRevocationCheck(b.federation.CapabilityRevocations().IsRevoked)
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.callPolicy = policy
+}
+
+// requirement returns what a call to toolName needs. Exact patterns win over
+// wildcards, and longer wildcards over shorter ones.
+func (p *ToolCallPolicy) requirement(toolName string, registry *MCPRegistry) ToolPermission {
+	var match *ToolPermission
+	for i, permission := range p.Permissions {
+		if permission.Tool == toolName {
+			match = &p.Permissions[i]
+			break
+		}
+		if !registry.matchCapability(toolName, permission.Tool) {
+			continue
+		}
+		if match == nil || len(permission.Tool) > len(match.Tool) {
+			match = &p.Permissions[i]
+		}
+	}
+
+	required := ToolPermission{Tool: toolName}
+	if match != nil {
+		required = *match
+	}
+	if required.Permission == "" {
+		required.Permission = callPermissionPrefix + toolName
+	}
+	return required
+}
+
+// CallDenial is returned when a tool call's capability token does not
+// authorize it
+type CallDenial struct {
+	Tool     string
+	Required ToolPermission
+	Reason   string // One of the CallDenied constants
+	Err      error
+}
+
+func (e *CallDenial) Error() string {
+	return fmt.Sprintf("call to %s not permitted: %v", e.Tool, e.Err)
+}
+
+// ErrorBody converts the denial into the error sent to the caller, naming the
+// permission and scope it would need
+func (e *CallDenial) ErrorBody() *protocol.ErrorBody {
+	details := map[string]interface{}{
+		"tool":       e.Tool,
+		"permission": e.Required.Permission,
+		"reason":     e.Reason,
+	}
+	if e.Required.Scope != "" {
+		details["scope"] = e.Required.Scope
+	}
+	return &protocol.ErrorBody{
+		Code:    protocol.CodeCapabilityDenied,
+		Message: e.Error(),
+		Details: details,
+	}
+}
+
+// authorizeToolCall authenticates the caller and checks its capability token
+// against the permission the tool requires. Calls pass unchecked when no policy
+// is set or the tool is public. On failure it writes the error envelope itself.
+func (b *Broker) authorizeToolCall(w http.ResponseWriter, r *http.Request, env *protocol.GenericEnvelope, body *protocol.ToolCallBody) bool {
+	b.mu.RLock()
+	policy := b.callPolicy
+	b.mu.RUnlock()
+	if policy == nil || policy.Capabilities == nil {
+		return true
+	}
+
+	required := policy.requirement(body.Tool, b.mcpRegistry)
+	if required.Public {
+		return true
+	}
+	if !b.authenticateAgent(w, r, env) {
+		return false
+	}
+	if denial := checkCallCapability(policy.Capabilities, required, env.Agent, body); denial != nil {
+		b.rejectCallDenial(w, env, denial)
+		return false
+	}
+	return true
+}
+
+// checkCallCapability checks that the call's token was issued to caller and
+// grants the required permission and scope
+func checkCallCapability(cm *protocol.CapabilityManager, required ToolPermission, caller string, body *protocol.ToolCallBody) *CallDenial {
+	deny := func(reason string, err error) *CallDenial {
+		return &CallDenial{Tool: body.Tool, Required: required, Reason: reason, Err: err}
+	}
+	if body.Capability == "" {
+		return deny(CallDeniedMissing, fmt.Errorf("capability token required"))
+	}
+
+	capability, err := cm.ValidateCapability(body.Capability)
+	switch {
+	case errors.Is(err, protocol.ErrCapabilityExpired):
+		return deny(CallDeniedExpired, err)
+	case errors.Is(err, protocol.ErrCapabilityRevoked):
+		return deny(CallDeniedRevoked, err)
+	case err != nil:
+		return deny(CallDeniedInvalid, fmt.Errorf("invalid capability token: %w", err))
+	}
+	if capability.Subject != caller {
+		return deny(CallDeniedSubjectMismatch, fmt.Errorf("capability token was issued to %s", capability.Subject))
+	}
+	if required.Scope != "" && capability.Scope != required.Scope {
+		return deny(CallDeniedScopeMismatch, fmt.Errorf("capability token has scope %q, %q required", capability.Scope, required.Scope))
+	}
+	if !capability.CoversPermission(required.Permission) {
+		return deny(CallDeniedNotGranted, fmt.Errorf("%s not granted", required.Permission))
+	}
+	return nil
+}
+
+// rejectCallDenial answers a tool call its capability token does not authorize
+func (b *Broker) rejectCallDenial(w http.ResponseWriter, env *protocol.GenericEnvelope, denial *CallDenial) {
+	errBody := denial.ErrorBody()
+	rejection := b.newError(env, errBody.Code, errBody.Message)
+	rejection.Body.Details = errBody.Details
+	writeError(w, rejection)
+}
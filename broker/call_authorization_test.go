@@ -0,0 +1,115 @@
+package main
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/fep-fem/protocol"
+)
+
+func TestToolCallCapabilities(t *testing.T) {
+	broker := NewBroker()
+	capabilities := protocol.NewEd25519CapabilityManager(broker.IdentityKey())
+	broker.SetToolCallPolicy(&ToolCallPolicy{
+		Capabilities: capabilities,
+		Permissions: []ToolPermission{
+			{Tool: "shell.*", Permission: "call:shell.exec", Scope: "admin"},
+			{Tool: "status", Public: true},
+		},
+	})
+
+	pubKey, privKey, _ := protocol.GenerateKeyPair()
+	registerDiscoveryClient(broker, "client-1", pubKey)
+	call := func(tool, token string, key ed25519.PrivateKey) (int, map[string]interface{}) {
+		envelope, _ := protocol.NewToolCall("client-1").Tool(tool).RequestID("r-" + tool + token).Capability(token).SignWith(key)
+		data, _ := json.Marshal(envelope)
+		recorder := httptest.NewRecorder()
+		broker.ServeHTTP(recorder, httptest.NewRequest(http.MethodPost, "/", bytes.NewReader(data)))
+		var rejection protocol.ErrorEnvelope
+		json.Unmarshal(recorder.Body.Bytes(), &rejection)
+		return recorder.Code, rejection.Body.Details
+	}
+	token := func(scope, subject string, permissions []string, duration time.Duration) string {
+		token, _ := capabilities.CreateCapability(scope, "broker", subject, permissions, duration)
+		return token
+	}
+
+	mathToken := token("tools", "client-1", []string{"call:math.*"}, time.Hour)
+	if code, details := call("math.add", mathToken, privKey); code != http.StatusOK {
+		t.Fatalf("Expected a granted call to pass, got %d %v", code, details)
+	}
+	_, otherKey, _ := protocol.GenerateKeyPair()
+	if code, _ := call("status", "", otherKey); code != http.StatusOK {
+		t.Errorf("Public tools need no token or valid signature, got %d", code)
+	}
+	if code, _ := call("math.add", mathToken, otherKey); code != http.StatusUnauthorized {
+		t.Errorf("Expected an unsigned caller to be refused, got %d", code)
+	}
+
+	revokedToken := token("tools", "client-1", []string{"*"}, time.Hour)
+	revokedID, _ := protocol.CapabilityTokenID(revokedToken)
+	broker.federation.CapabilityRevocations().Revoke(RevokedCapability{TokenID: revokedID})
+
+	for _, tt := range []struct {
+		name, tool, token  string
+		reason, permission string
+	}{
+		{"missing", "math.add", "", CallDeniedMissing, "call:math.add"},
+		{"invalid", "math.add", "not-a-token", CallDeniedInvalid, "call:math.add"},
+		{"expired", "math.add", token("tools", "client-1", []string{"call:*"}, -time.Minute), CallDeniedExpired, "call:math.add"},
+		{"revoked", "math.add", revokedToken, CallDeniedRevoked, "call:math.add"},
+		{"other subject", "math.add", token("tools", "client-2", []string{"call:*"}, time.Hour), CallDeniedSubjectMismatch, "call:math.add"},
+		{"not granted", "math.sub.x", token("tools", "client-1", []string{"call:math.add"}, time.Hour), CallDeniedNotGranted, "call:math.sub.x"},
+		{"mapped permission", "shell.run", mathToken, CallDeniedScopeMismatch, "call:shell.exec"},
+		{"mapped scope", "shell.run", token("admin", "client-1", []string{"call:shell.run"}, time.Hour), CallDeniedNotGranted, "call:shell.exec"},
+	} {
+		code, details := call(tt.tool, tt.token, privKey)
+		if code != http.StatusForbidden || details["reason"] != tt.reason || details["permission"] != tt.permission || details["tool"] != tt.tool {
+			t.Errorf("%s: expected %s denial for %s, got %d %v", tt.name, tt.reason, tt.permission, code, details)
+		}
+	}
+
+	adminToken := token("admin", "client-1", []string{"call:shell.*"}, time.Hour)
+	if code, details := call("shell.run", adminToken, privKey); code != http.StatusOK {
+		t.Errorf("Expected the mapped permission and scope to pass, got %d %v", code, details)
+	}
+}
+
+func TestToolCallPolicyRequirement(t *testing.T) {
+	policy := &ToolCallPolicy{Permissions: []ToolPermission{
+		{Tool: "*", Scope: "tools"},
+		{Tool: "fs.*", Permission: "call:files"},
+		{Tool: "fs.write", Permission: "call:files.write"},
+	}}
+	registry := NewMCPRegistry()
+	for tool, want := range map[string]ToolPermission{
+		"math.add": {Tool: "*", Permission: "call:math.add", Scope: "tools"},
+		"fs.read":  {Tool: "fs.*", Permission: "call:files"},
+		"fs.write": {Tool: "fs.write", Permission: "call:files.write"},
+	} {
+		if got := policy.requirement(tool, registry); got != want {
+			t.Errorf("requirement(%q) = %+v, want %+v", tool, got, want)
+		}
+	}
+}
+
+func TestLoadToolPermissions(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "permissions.json")
+	os.WriteFile(path, []byte(`[{"tool":"shell.*","permission":"call:shell.exec","scope":"admin"},{"tool":"status","public":true}]`), 0o600)
+	permissions, err := LoadToolPermissions(path)
+	if err != nil || len(permissions) != 2 || !permissions[1].Public || permissions[0].Scope != "admin" {
+		t.Fatalf("Unexpected permissions: %+v %v", permissions, err)
+	}
+
+	os.WriteFile(path, []byte(`[{"tool":"shell.*.exec"}]`), 0o600)
+	if _, err := LoadToolPermissions(path); err == nil {
+		t.Error("Expected an invalid tool pattern to be rejected")
+	}
+}
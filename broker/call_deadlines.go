@@ -0,0 +1,134 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fep-fem/protocol"
+)
+
+// callDeadline is a call whose caller needs the result by a deadline
+type callDeadline struct {
+	acceptPartial bool
+	chunks        map[uint64]string // Data of the chunks streamed so far, by seq
+	timer         *time.Timer
+}
+
+// deadlineTracker watches the deadlines of calls until they are answered
+type deadlineTracker struct {
+	calls map[string]*callDeadline // By request ID
+	mu    sync.Mutex
+}
+
+func newDeadlineTracker() *deadlineTracker {
+	return &deadlineTracker{calls: make(map[string]*callDeadline)}
+}
+
+// watch calls expire with requestID once deadline passes, unless the call is
+// answered first
+func (t *deadlineTracker) watch(requestID string, deadline time.Time, acceptPartial bool, expire func(string)) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if previous, exists := t.calls[requestID]; exists {
+		previous.timer.Stop()
+	}
+	t.calls[requestID] = &callDeadline{
+		acceptPartial: acceptPartial,
+		chunks:        make(map[uint64]string),
+		timer:         time.AfterFunc(time.Until(deadline), func() { expire(requestID) }),
+	}
+}
+
+// recordChunk keeps the output of a streamed chunk for a partial result
+func (t *deadlineTracker) recordChunk(requestID string, seq uint64, data string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if call, exists := t.calls[requestID]; exists {
+		call.chunks[seq] = data
+	}
+}
+
+// finish stops watching an answered call
+func (t *deadlineTracker) finish(requestID string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if call, exists := t.calls[requestID]; exists {
+		call.timer.Stop()
+		delete(t.calls, requestID)
+	}
+}
+
+// take stops watching a call whose deadline passed and returns it
+func (t *deadlineTracker) take(requestID string) (*callDeadline, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	call, exists := t.calls[requestID]
+	delete(t.calls, requestID)
+	return call, exists
+}
+
+// partialOutput joins the data of the chunks streamed without gaps from the
+// start, returning it and the number of chunks it covers
+func (c *callDeadline) partialOutput() (string, int) {
+	var output strings.Builder
+	seq := uint64(1)
+	for ; ; seq++ {
+		data, exists := c.chunks[seq]
+		if !exists {
+			break
+		}
+		output.WriteString(data)
+	}
+	return output.String(), int(seq - 1)
+}
+
+// watchDeadline answers a call the broker has not seen answered by the
+// caller's deadline
+func (b *Broker) watchDeadline(body protocol.ToolCallBody) {
+	if body.Deadline == 0 {
+		return
+	}
+	b.deadlines.watch(body.RequestID, time.UnixMilli(body.Deadline), body.AcceptPartial, b.expireCall)
+}
+
+// expireCall answers a call whose deadline passed on the agent's behalf, with
+// the output streamed so far flagged partial if the caller accepts that, and
+// with an error otherwise. The broker signs the result; any later result or
+// chunk from the agent is dropped.
+func (b *Broker) expireCall(requestID string) {
+	call, exists := b.deadlines.take(requestID)
+	if !exists {
+		return
+	}
+	b.transcoders.take(requestID)
+	// A call still awaiting approval will not run now
+	b.approvals.take(requestID)
+
+	builder := protocol.NewToolResult(b.federation.config.LocalBrokerID, requestID)
+	output, chunks := call.partialOutput()
+	if call.acceptPartial {
+		builder.Result(output).Partial()
+		b.requests.Finish(requestID, "", "")
+	} else {
+		reason := fmt.Sprintf("deadline exceeded after %d streamed chunks", chunks)
+		builder.Error(reason)
+		b.requests.Finish(requestID, "", reason)
+	}
+	envelope, err := builder.SignWith(b.IdentityKey())
+	if err != nil {
+		log.Printf("Failed to answer %s at its deadline: %v", requestID, err)
+		return
+	}
+	raw, err := json.Marshal(envelope)
+	if err != nil {
+		return
+	}
+	if b.results.StoreResult(requestID, raw) {
+		log.Printf("Deadline of %s passed; answered with %d streamed chunks (partial: %t)", requestID, chunks, call.acceptPartial)
+		b.pushResult(requestID, 0, true, raw)
+	}
+}
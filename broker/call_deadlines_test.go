@@ -0,0 +1,87 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/fep-fem/protocol"
+)
+
+// callBy sends a toolCall due by deadline
+func callBy(t *testing.T, broker *Broker, requestID string, deadline time.Time, acceptPartial bool) {
+	t.Helper()
+	envelope, err := protocol.NewToolCall("caller").Tool("video.transcode").RequestID(requestID).Deadline(deadline, acceptPartial).Build()
+	if err != nil {
+		t.Fatalf("Failed to build call: %v", err)
+	}
+	sendForAck(t, broker, envelope)
+}
+
+// streamChunk sends a non-final chunk of the result of requestID
+func streamChunk(t *testing.T, broker *Broker, requestID string, seq uint64, data string) bool {
+	t.Helper()
+	registerTranscoder(broker)
+	chunk := protocol.NewTypedEnvelope("transcoder", protocol.ToolResultChunkBody{RequestID: requestID, Seq: seq, Data: data})
+	chunk.Sign(transcoderKey)
+	ack := sendForAck(t, broker, chunk)
+	result, _ := ack.Result.(map[string]interface{})
+	return result["held"] == true
+}
+
+// awaitFinalResult waits for the caller's held toolResult of requestID
+func awaitFinalResult(t *testing.T, broker *Broker, requestID string) *protocol.TypedEnvelope[protocol.ToolResultBody] {
+	t.Helper()
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		for _, delivered := range broker.results.Collect("caller", nil, 0) {
+			var envelope protocol.TypedEnvelope[protocol.ToolResultBody]
+			json.Unmarshal(delivered.Envelope, &envelope)
+			if delivered.RequestID == requestID && envelope.Type == protocol.EnvelopeToolResult {
+				return &envelope
+			}
+		}
+	}
+	t.Fatalf("No result for %s", requestID)
+	return nil
+}
+
+func TestDeadlineReturnsPartialResult(t *testing.T) {
+	broker := NewBroker()
+	callBy(t, broker, "late-1", time.Now().Add(200*time.Millisecond), true)
+	streamChunk(t, broker, "late-1", 1, "ab")
+	streamChunk(t, broker, "late-1", 2, "cd")
+
+	result := awaitFinalResult(t, broker, "late-1")
+	if !result.Body.Partial || result.Body.Result != "abcd" || result.Body.Error != "" {
+		t.Fatalf("Expected the streamed output as a partial result, got %+v", result.Body)
+	}
+	brokerKey := broker.IdentityKey().Public().(ed25519.PublicKey)
+	if err := result.Verify(brokerKey); err != nil {
+		t.Errorf("Partial result should be signed by the broker: %v", err)
+	}
+	if streamChunk(t, broker, "late-1", 3, "ef") {
+		t.Error("Chunks after the deadline should be dropped")
+	}
+}
+
+func TestDeadlineWithoutPartialFails(t *testing.T) {
+	broker := NewBroker()
+	callBy(t, broker, "late-2", time.Now().Add(100*time.Millisecond), false)
+	streamChunk(t, broker, "late-2", 1, "ab")
+
+	result := awaitFinalResult(t, broker, "late-2")
+	if result.Body.Partial || result.Body.Result != nil || result.Body.Error == "" {
+		t.Fatalf("Expected a deadline error, got %+v", result.Body)
+	}
+}
+
+func TestResultBeforeDeadlineStopsWatch(t *testing.T) {
+	broker := NewBroker()
+	callBy(t, broker, "late-3", time.Now().Add(time.Minute), true)
+	answer(t, broker, "late-3")
+
+	if _, watched := broker.deadlines.take("late-3"); watched {
+		t.Error("An answered call should no longer be watched")
+	}
+}
@@ -0,0 +1,249 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/fep-fem/protocol"
+)
+
+// canaryProbeName is the health probe under which canary results are scored
+const canaryProbeName = "canary"
+
+// defaultCanaryWeight makes a fully failing canary pull an otherwise perfect
+// agent below the unhealthy threshold, so functional failures exclude it from routing
+const defaultCanaryWeight = 1.0
+
+// CanaryCheck is an operator-defined synthetic tool call with a known answer
+type CanaryCheck struct {
+	Name      string                 `json:"name"`
+	AgentID   string                 `json:"agentId,omitempty"` // Empty runs the canary on every agent offering Tool
+	Tool      string                 `json:"tool"`
+	Arguments map[string]interface{} `json:"arguments,omitempty"`
+	Expect    interface{}            `json:"expect,omitempty"` // Expected result; nil accepts any successful call
+}
+
+// CanaryResult is the outcome of the latest run of a canary against one agent
+type CanaryResult struct {
+	Canary    string            `json:"canary"`
+	AgentID   string            `json:"agentId"`
+	Passed    bool              `json:"passed"`
+	Error     string            `json:"error,omitempty"`
+	Latency   protocol.Duration `json:"latency"`
+	CheckedAt time.Time         `json:"checkedAt"`
+}
+
+// CanaryRunner executes canaries as a health probe on every health check
+type CanaryRunner struct {
+	registry *MCPRegistry
+	canaries map[string]CanaryCheck
+	results  map[string]map[string]CanaryResult // canary -> agent -> result
+	client   *http.Client
+	mu       sync.RWMutex
+}
+
+// NewCanaryRunner creates a runner with no canaries configured
+func NewCanaryRunner(registry *MCPRegistry) *CanaryRunner {
+	return &CanaryRunner{
+		registry: registry,
+		canaries: make(map[string]CanaryCheck),
+		results:  make(map[string]map[string]CanaryResult),
+		client:   NewSyntheticToolCallProbe("", nil, nil).Client,
+	}
+}
+
+// SetCanary adds or replaces a canary
+func (c *CanaryRunner) SetCanary(canary CanaryCheck) error {
+	if canary.Name == "" || canary.Tool == "" {
+		return fmt.Errorf("canary needs a name and a tool")
+	}
+
+	// Normalize the expectation to its JSON form so it compares equal to decoded results
+	if canary.Expect != nil {
+		data, err := json.Marshal(canary.Expect)
+		if err != nil {
+			return fmt.Errorf("invalid canary expectation: %w", err)
+		}
+		var expect interface{}
+		json.Unmarshal(data, &expect)
+		canary.Expect = expect
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.canaries[canary.Name] = canary
+	delete(c.results, canary.Name)
+	return nil
+}
+
+// RemoveCanary deletes a canary and its results
+func (c *CanaryRunner) RemoveCanary(name string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	_, exists := c.canaries[name]
+	delete(c.canaries, name)
+	delete(c.results, name)
+	return exists
+}
+
+// Canaries lists configured canaries ordered by name
+func (c *CanaryRunner) Canaries() []CanaryCheck {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	canaries := make([]CanaryCheck, 0, len(c.canaries))
+	for _, canary := range c.canaries {
+		canaries = append(canaries, canary)
+	}
+	sort.Slice(canaries, func(i, j int) bool { return canaries[i].Name < canaries[j].Name })
+	return canaries
+}
+
+// Results lists the latest result of every canary on every agent
+func (c *CanaryRunner) Results() []CanaryResult {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	var results []CanaryResult
+	for _, byAgent := range c.results {
+		for _, result := range byAgent {
+			results = append(results, result)
+		}
+	}
+	sort.Slice(results, func(i, j int) bool {
+		if results[i].Canary != results[j].Canary {
+			return results[i].Canary < results[j].Canary
+		}
+		return results[i].AgentID < results[j].AgentID
+	})
+	return results
+}
+
+// Probe runs the canaries that apply to the agent and scores the fraction that passed
+func (c *CanaryRunner) Probe(agentID, endpoint string) (float64, error) {
+	canaries := c.canariesFor(agentID)
+	if len(canaries) == 0 {
+		return 0, ErrProbeNotApplicable
+	}
+
+	passed := 0
+	for _, canary := range canaries {
+		result := c.run(canary, agentID, endpoint)
+		if result.Passed {
+			passed++
+		}
+
+		c.mu.Lock()
+		if _, exists := c.results[canary.Name]; !exists {
+			c.results[canary.Name] = make(map[string]CanaryResult)
+		}
+		c.results[canary.Name][agentID] = result
+		c.mu.Unlock()
+	}
+	return float64(passed) / float64(len(canaries)), nil
+}
+
+// forgetAgent drops results for an agent that left the registry
+func (c *CanaryRunner) forgetAgent(agentID string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, byAgent := range c.results {
+		delete(byAgent, agentID)
+	}
+}
+
+// canariesFor selects canaries pinned to the agent or targeting a tool it offers
+func (c *CanaryRunner) canariesFor(agentID string) []CanaryCheck {
+	offered := make(map[string]bool)
+	if agent, exists := c.registry.GetAgent(agentID); exists {
+		for _, tool := range agent.Tools {
+			offered[tool.Name] = true
+		}
+	}
+
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	var applicable []CanaryCheck
+	for _, canary := range c.canaries {
+		if canary.AgentID == agentID || (canary.AgentID == "" && offered[canary.Tool]) {
+			applicable = append(applicable, canary)
+		}
+	}
+	return applicable
+}
+
+func (c *CanaryRunner) run(canary CanaryCheck, agentID, endpoint string) CanaryResult {
+	result := CanaryResult{Canary: canary.Name, AgentID: agentID, CheckedAt: time.Now()}
+
+	var mismatch interface{}
+	probe := &SyntheticToolCallProbe{
+		Tool:      canary.Tool,
+		Arguments: canary.Arguments,
+		Client:    c.client,
+		Validate: func(got interface{}) float64 {
+			if canary.Expect == nil || reflect.DeepEqual(got, canary.Expect) {
+				return 1
+			}
+			mismatch = got
+			return 0
+		},
+	}
+
+	start := time.Now()
+	score, err := probe.Probe(agentID, endpoint)
+	result.Latency = protocol.Duration(time.Since(start))
+
+	switch {
+	case err != nil:
+		result.Error = err.Error()
+	case score < 1:
+		result.Error = fmt.Sprintf("unexpected result %v", mismatch)
+	default:
+		result.Passed = true
+	}
+	return result
+}
+
+// Canaries returns the runner executing canary tool calls during health checks
+func (fm *FederationManager) Canaries() *CanaryRunner {
+	return fm.canaries
+}
+
+// handleAdminCanaries lists canaries and results (GET), adds or replaces one
+// (PUT/POST) and removes one (DELETE ?name=)
+func (b *Broker) handleAdminCanaries(w http.ResponseWriter, r *http.Request) {
+	canaries := b.federation.Canaries()
+	switch r.Method {
+	case http.MethodGet:
+		writeJSON(w, http.StatusOK, map[string]interface{}{
+			"canaries": canaries.Canaries(),
+			"results":  canaries.Results(),
+		})
+	case http.MethodPut, http.MethodPost:
+		var canary CanaryCheck
+		if err := json.NewDecoder(r.Body).Decode(&canary); err != nil {
+			http.Error(w, "Invalid canary", http.StatusBadRequest)
+			return
+		}
+		if err := canaries.SetCanary(canary); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]interface{}{"status": "saved", "name": canary.Name})
+	case http.MethodDelete:
+		name := r.URL.Query().Get("name")
+		if !canaries.RemoveCanary(name) {
+			http.Error(w, "Canary not found", http.StatusNotFound)
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]interface{}{"status": "deleted", "name": name})
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
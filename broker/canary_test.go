@@ -0,0 +1,86 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/fep-fem/protocol"
+)
+
+func TestCanaryExcludesFunctionallyBrokenAgent(t *testing.T) {
+	server := newProbeTestAgent(t)
+	registry := NewMCPRegistry()
+	registry.RegisterAgent("math-agent", &MCPAgent{
+		ID:          "math-agent",
+		MCPEndpoint: server.URL,
+		Tools:       []protocol.MCPTool{{Name: "math.add"}},
+	})
+	fm := NewFederationManager(registry, nil)
+
+	// Agents without an applicable canary are scored on the built-in checks alone
+	fm.healthChecker.checkSingleAgent(fm, "math-agent", server.URL)
+	if score := fm.agentMetrics["math-agent"].HealthScore; score != 1 {
+		t.Fatalf("Expected score 1 without canaries, got %f", score)
+	}
+
+	if err := fm.Canaries().SetCanary(CanaryCheck{
+		Name:      "add",
+		Tool:      "math.add",
+		Arguments: map[string]interface{}{"a": 1, "b": 1},
+		Expect:    2,
+	}); err != nil {
+		t.Fatalf("SetCanary failed: %v", err)
+	}
+	fm.healthChecker.checkSingleAgent(fm, "math-agent", server.URL)
+	results := fm.Canaries().Results()
+	if len(results) != 1 || !results[0].Passed || fm.Exclusions().IsExcluded("math-agent") {
+		t.Fatalf("Correct canary should pass, got %+v", results)
+	}
+
+	// A reachable agent returning wrong answers is taken out of routing
+	fm.Canaries().SetCanary(CanaryCheck{
+		Name:      "add",
+		Tool:      "math.add",
+		Arguments: map[string]interface{}{"a": 1, "b": 1},
+		Expect:    3,
+	})
+	fm.healthChecker.checkSingleAgent(fm, "math-agent", server.URL)
+	results = fm.Canaries().Results()
+	if len(results) != 1 || results[0].Passed || !strings.Contains(results[0].Error, "unexpected result") {
+		t.Fatalf("Wrong answer should fail the canary, got %+v", results)
+	}
+	if !fm.Exclusions().IsExcluded("math-agent") {
+		t.Error("Agent failing its canary should be excluded from routing")
+	}
+}
+
+func TestAdminCanariesAPI(t *testing.T) {
+	broker := NewBroker()
+	broker.SetAdminToken("secret")
+
+	admin := func(method, path, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer secret")
+		recorder := httptest.NewRecorder()
+		broker.ServeHTTP(recorder, req)
+		return recorder
+	}
+
+	if code := admin(http.MethodPut, "/admin/canaries", `{"name":"add","tool":"math.add","arguments":{"a":1,"b":1},"expect":2}`).Code; code != http.StatusOK {
+		t.Fatalf("Creating canary returned %d", code)
+	}
+	if code := admin(http.MethodPut, "/admin/canaries", `{"name":"no-tool"}`).Code; code != http.StatusBadRequest {
+		t.Errorf("Canary without a tool should be rejected, got %d", code)
+	}
+	if body := admin(http.MethodGet, "/admin/canaries", "").Body.String(); !strings.Contains(body, `"tool":"math.add"`) {
+		t.Errorf("Canary missing from listing: %s", body)
+	}
+	if code := admin(http.MethodDelete, "/admin/canaries?name=add", "").Code; code != http.StatusOK {
+		t.Errorf("Deleting canary returned %d", code)
+	}
+	if code := admin(http.MethodDelete, "/admin/canaries?name=add", "").Code; code != http.StatusNotFound {
+		t.Errorf("Deleting a missing canary should return 404, got %d", code)
+	}
+}
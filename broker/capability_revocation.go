@@ -0,0 +1,333 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/fep-fem/protocol"
+)
+
+// capabilityRevocationsPath is where peers pull the capability revocation list
+const capabilityRevocationsPath = "/federation/capability-revocations"
+
+// defaultCapabilityTTL is how long a capability the broker issues lasts when
+// the grant sets no lifetime
+const defaultCapabilityTTL = time.Hour
+
+// errNoCapabilityIssuer is returned when the broker has no capability manager
+var errNoCapabilityIssuer = errors.New("this broker does not issue capability tokens")
+
+// CapabilityGrant is a capability token the broker issued
+type CapabilityGrant struct {
+	Token     string                   `json:"token"`
+	TokenID   string                   `json:"jti"`
+	Expires   time.Time                `json:"expires"`
+	Timestamp *protocol.TimestampToken `json:"timestamp,omitempty"` // Time evidence over the token's SHA-256, if a timestamping authority is configured
+}
+
+// RevokedCapability is a capability token revoked before it expires
+type RevokedCapability struct {
+	TokenID   string    `json:"jti"`
+	Reason    string    `json:"reason,omitempty"`
+	RevokedBy string    `json:"revokedBy"` // Agent or operator that revoked the token
+	BrokerID  string    `json:"brokerId"`  // Broker that first accepted the revocation
+	RevokedAt time.Time `json:"revokedAt"`
+	Expires   time.Time `json:"expires,omitempty"` // When the token expires anyway; zero keeps the entry forever
+
+	Timestamp *protocol.TimestampToken `json:"timestamp,omitempty"` // Time evidence over the revokeCapability envelope, if any
+}
+
+// CapabilityRevocationList holds revoked capability token IDs until the
+// tokens would have expired anyway
+type CapabilityRevocationList struct {
+	mu      sync.RWMutex
+	entries map[string]RevokedCapability // By token ID
+}
+
+// NewCapabilityRevocationList creates an empty list
+func NewCapabilityRevocationList() *CapabilityRevocationList {
+	return &CapabilityRevocationList{entries: make(map[string]RevokedCapability)}
+}
+
+// Revoke adds an entry, reporting false if the token was already revoked
+func (l *CapabilityRevocationList) Revoke(entry RevokedCapability) bool {
+	if entry.RevokedAt.IsZero() {
+		entry.RevokedAt = time.Now()
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if _, exists := l.entries[entry.TokenID]; exists {
+		return false
+	}
+	l.entries[entry.TokenID] = entry
+	return true
+}
+
+// IsRevoked reports whether a token ID has been revoked
+func (l *CapabilityRevocationList) IsRevoked(tokenID string) bool {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	_, revoked := l.entries[tokenID]
+	return revoked
+}
+
+// Entries returns the revocations of tokens that have not expired yet, oldest first
+func (l *CapabilityRevocationList) Entries() []RevokedCapability {
+	now := time.Now()
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	entries := make([]RevokedCapability, 0, len(l.entries))
+	for tokenID, entry := range l.entries {
+		if !entry.Expires.IsZero() && now.After(entry.Expires) {
+			delete(l.entries, tokenID)
+			continue
+		}
+		entries = append(entries, entry)
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		if entries[i].RevokedAt.Equal(entries[j].RevokedAt) {
+			return entries[i].TokenID < entries[j].TokenID
+		}
+		return entries[i].RevokedAt.Before(entries[j].RevokedAt)
+	})
+	return entries
+}
+
+// Merge adds entries pulled from a peer, returning how many were new
+func (l *CapabilityRevocationList) Merge(entries []RevokedCapability) int {
+	now := time.Now()
+	added := 0
+	for _, entry := range entries {
+		if entry.TokenID == "" || (!entry.Expires.IsZero() && now.After(entry.Expires)) {
+			continue
+		}
+		if l.Revoke(entry) {
+			added++
+		}
+	}
+	return added
+}
+
+// CapabilityRevocations returns the broker's capability revocation list
+func (fm *FederationManager) CapabilityRevocations() *CapabilityRevocationList {
+	return fm.capabilityRevocations
+}
+
+// syncPeerCapabilityRevocations pulls the revocation lists of trusted peers.
+// A peer's revocations can only take access away, so they are merged as is.
+func (fm *FederationManager) syncPeerCapabilityRevocations() {
+	client := peerSyncClient()
+	for _, peer := range fm.syncablePeers() {
+		resp, err := client.Get(peer.Endpoint + capabilityRevocationsPath)
+		if err != nil {
+			log.Printf("Capability revocation sync with %s failed: %v", peer.ID, err)
+			continue
+		}
+
+		var entries []RevokedCapability
+		err = json.NewDecoder(resp.Body).Decode(&entries)
+		resp.Body.Close()
+		if err != nil {
+			log.Printf("Invalid capability revocation payload from %s: %v", peer.ID, err)
+			continue
+		}
+
+		if added := fm.capabilityRevocations.Merge(entries); added > 0 {
+			log.Printf("Merged %d capability revocations from %s", added, peer.ID)
+		}
+	}
+}
+
+// handleRevokeCapability revokes a capability token. The sender must present
+// the token and be its subject, or the agent that delegated it.
+func (b *Broker) handleRevokeCapability(w http.ResponseWriter, r *http.Request, env *protocol.GenericEnvelope) {
+	typed, err := protocol.ParseTyped[protocol.RevokeCapabilityBody](env)
+	if err != nil {
+		b.rejectInvalidBody(w, env, err)
+		return
+	}
+	body := typed.Body
+	if !b.authenticateAgent(w, r, env) {
+		return
+	}
+
+	b.mu.RLock()
+	cm := b.discoveryPolicy.Capabilities
+	b.mu.RUnlock()
+	if cm == nil {
+		b.reject(w, env, protocol.CodeForbidden, "This broker does not issue capability tokens")
+		return
+	}
+	if body.Capability == "" {
+		b.reject(w, env, protocol.CodeCapabilityDenied, "Revoking a capability requires presenting the token")
+		return
+	}
+	capability, err := cm.ValidateCapability(body.Capability)
+	if err != nil {
+		b.reject(w, env, protocol.CodeCapabilityDenied, fmt.Sprintf("Invalid capability token: %v", err))
+		return
+	}
+	if capability.ID != body.TokenID {
+		b.reject(w, env, protocol.CodeInvalidBody, fmt.Sprintf("Token has jti %s, not %s", capability.ID, body.TokenID))
+		return
+	}
+	if env.Agent != capability.Subject && env.Agent != capability.Issuer {
+		b.reject(w, env, protocol.CodeForbidden, fmt.Sprintf("Agent %s may not revoke a capability issued to %s", env.Agent, capability.Subject))
+		return
+	}
+
+	b.mu.RLock()
+	tsa := b.timestamps
+	b.mu.RUnlock()
+	if tsa != nil {
+		if err := env.TimestampEnvelope(tsa); err != nil {
+			log.Printf("Failed to timestamp revocation of capability %s: %v", body.TokenID, err)
+		}
+	}
+
+	entry := RevokedCapability{
+		TokenID:   body.TokenID,
+		Reason:    body.Reason,
+		RevokedBy: env.Agent,
+		BrokerID:  b.federation.config.LocalBrokerID,
+		Timestamp: env.Timestamp,
+	}
+	if capability.ExpiresAt != nil {
+		entry.Expires = capability.ExpiresAt.Time
+	}
+	b.federation.capabilityRevocations.Revoke(entry)
+	b.securityEvents.Raise(SecurityEvent{
+		Type:    SecurityEventCapabilityRevoked,
+		AgentID: env.Agent,
+		Detail:  fmt.Sprintf("revoked capability %s issued to %s", body.TokenID, capability.Subject),
+		Source:  requestSource(r),
+	})
+
+	result := map[string]interface{}{
+		"jti": body.TokenID,
+	}
+	if env.Timestamp != nil {
+		result["timestamp"] = env.Timestamp
+	}
+	b.writeAck(w, env, "revoked", result)
+}
+
+// IssueCapability issues a capability token to subject through the broker's
+// capability manager, timestamped when a timestamping authority is
+// configured. A holder key makes the token delegable by its holder.
+func (b *Broker) IssueCapability(scope, subject string, holderKey ed25519.PublicKey, permissions []string, ttl time.Duration) (*CapabilityGrant, error) {
+	b.mu.RLock()
+	cm := b.discoveryPolicy.Capabilities
+	tsa := b.timestamps
+	b.mu.RUnlock()
+	if cm == nil {
+		return nil, errNoCapabilityIssuer
+	}
+	if ttl <= 0 {
+		ttl = defaultCapabilityTTL
+	}
+
+	issuer := b.federation.config.LocalBrokerID
+	var token string
+	var err error
+	if holderKey != nil {
+		token, err = cm.CreateDelegableCapability(scope, issuer, subject, holderKey, permissions, ttl)
+	} else {
+		token, err = cm.CreateCapability(scope, issuer, subject, permissions, ttl)
+	}
+	if err != nil {
+		return nil, err
+	}
+	capability, err := cm.ValidateCapability(token)
+	if err != nil {
+		return nil, err
+	}
+
+	grant := &CapabilityGrant{Token: token, TokenID: capability.ID, Expires: capability.ExpiresAt.Time}
+	if tsa != nil {
+		if grant.Timestamp, err = protocol.TimestampCapability(token, tsa); err != nil {
+			log.Printf("Failed to timestamp capability %s: %v", grant.TokenID, err)
+		}
+	}
+	log.Printf("Issued capability %s to %s: %v", grant.TokenID, subject, permissions)
+	return grant, nil
+}
+
+// handleAdminCapabilities issues capability tokens
+func (b *Broker) handleAdminCapabilities(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var request struct {
+		Subject     string   `json:"subject"`
+		Scope       string   `json:"scope"`
+		Permissions []string `json:"permissions"`
+		TTL         int      `json:"ttl"`    // Seconds; defaultCapabilityTTL if 0
+		Holder      string   `json:"holder"` // Subject's public key, making the token delegable
+	}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil || request.Subject == "" || len(request.Permissions) == 0 {
+		http.Error(w, "Invalid capability request", http.StatusBadRequest)
+		return
+	}
+	var holderKey ed25519.PublicKey
+	if request.Holder != "" {
+		key, err := protocol.DecodePublicKey(request.Holder)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Invalid holder key: %v", err), http.StatusBadRequest)
+			return
+		}
+		holderKey = key
+	}
+
+	grant, err := b.IssueCapability(request.Scope, request.Subject, holderKey, request.Permissions, time.Duration(request.TTL)*time.Second)
+	if errors.Is(err, errNoCapabilityIssuer) {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to issue capability: %v", err), http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, http.StatusOK, grant)
+}
+
+// handleAdminCapabilityRevocations lists revoked capabilities, or revokes a
+// token by ID without presenting it
+func (b *Broker) handleAdminCapabilityRevocations(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		writeJSON(w, http.StatusOK, b.federation.capabilityRevocations.Entries())
+	case http.MethodPost:
+		var request struct {
+			TokenID string    `json:"jti"`
+			Reason  string    `json:"reason"`
+			Expires time.Time `json:"expires"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil || request.TokenID == "" {
+			http.Error(w, "Invalid revocation request", http.StatusBadRequest)
+			return
+		}
+		b.federation.capabilityRevocations.Revoke(RevokedCapability{
+			TokenID:   request.TokenID,
+			Reason:    request.Reason,
+			RevokedBy: "operator",
+			BrokerID:  b.federation.config.LocalBrokerID,
+			Expires:   request.Expires,
+		})
+		b.securityEvents.Raise(SecurityEvent{
+			Type:   SecurityEventCapabilityRevoked,
+			Detail: fmt.Sprintf("operator revoked capability %s", request.TokenID),
+		})
+		writeJSON(w, http.StatusOK, map[string]interface{}{"status": "revoked", "jti": request.TokenID})
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
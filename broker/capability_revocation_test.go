@@ -0,0 +1,158 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/fep-fem/protocol"
+)
+
+func TestRevokeCapabilityEnvelope(t *testing.T) {
+	broker := NewBroker()
+	capabilities := protocol.NewEd25519CapabilityManager(broker.IdentityKey())
+	policy := DefaultDiscoveryPolicy()
+	policy.Capabilities = capabilities
+	broker.SetDiscoveryPolicy(policy)
+
+	pubKey, privKey, _ := protocol.GenerateKeyPair()
+	otherPub, otherPriv, _ := protocol.GenerateKeyPair()
+	registerDiscoveryClient(broker, "client-1", pubKey)
+	registerDiscoveryClient(broker, "client-2", otherPub)
+
+	token, _ := capabilities.CreateCapability("discovery", "broker", "client-1", []string{"discover:*"}, time.Hour)
+	tokenID, _ := protocol.CapabilityTokenID(token)
+	revoke := func(agent string, key protocol.Signer) *httptest.ResponseRecorder {
+		envelope := protocol.NewTypedEnvelope(agent, protocol.RevokeCapabilityBody{TokenID: tokenID, Capability: token, Reason: "leaked"})
+		envelope.Sign(key)
+		data, _ := json.Marshal(envelope)
+		recorder := httptest.NewRecorder()
+		broker.ServeHTTP(recorder, httptest.NewRequest(http.MethodPost, "/", bytes.NewReader(data)))
+		return recorder
+	}
+
+	if resp := revoke("client-2", otherPriv); resp.Code != http.StatusForbidden {
+		t.Fatalf("Another agent should not revoke the token, got %d %s", resp.Code, resp.Body)
+	}
+	if _, err := discoveryScope(capabilities, token, "client-1"); err != nil {
+		t.Fatalf("Token should still be valid: %v", err)
+	}
+
+	if resp := revoke("client-1", privKey); resp.Code != http.StatusOK {
+		t.Fatalf("Subject should revoke its token, got %d %s", resp.Code, resp.Body)
+	}
+	if _, err := discoveryScope(capabilities, token, "client-1"); err == nil {
+		t.Error("Revoked token should be refused")
+	}
+	events := broker.securityEvents.Recent()
+	if len(events) != 1 || events[0].Type != SecurityEventCapabilityRevoked {
+		t.Errorf("Revocation should raise a security event, got %+v", events)
+	}
+
+	// Peers pull the list from the federation endpoint
+	recorder := httptest.NewRecorder()
+	broker.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, capabilityRevocationsPath, nil))
+	var entries []RevokedCapability
+	json.Unmarshal(recorder.Body.Bytes(), &entries)
+	if len(entries) != 1 || entries[0].TokenID != tokenID || entries[0].RevokedBy != "client-1" || entries[0].Expires.IsZero() {
+		t.Errorf("Unexpected revocation list: %+v", entries)
+	}
+}
+
+func TestCapabilityGrantsAndRevocationsTimestamped(t *testing.T) {
+	tsaPub, tsaPriv, _ := protocol.GenerateKeyPair()
+	tsa := protocol.NewEd25519TimestampVerifier("test-tsa", tsaPub)
+	broker := NewBroker()
+	broker.SetAdminToken("secret")
+	broker.SetTimestampAuthority(protocol.NewEd25519TimestampAuthority("test-tsa", tsaPriv))
+	capabilities := protocol.NewEd25519CapabilityManager(broker.IdentityKey())
+	policy := DefaultDiscoveryPolicy()
+	policy.Capabilities = capabilities
+	broker.SetDiscoveryPolicy(policy)
+	pubKey, privKey, _ := protocol.GenerateKeyPair()
+	registerDiscoveryClient(broker, "client-1", pubKey)
+
+	// Grants issued through the broker carry time evidence over the token
+	req := httptest.NewRequest(http.MethodPost, "/admin/capabilities", strings.NewReader(`{"subject":"client-1","scope":"discovery","permissions":["discover:*"]}`))
+	req.Header.Set("Authorization", "Bearer secret")
+	recorder := httptest.NewRecorder()
+	broker.ServeHTTP(recorder, req)
+	var grant CapabilityGrant
+	if err := json.Unmarshal(recorder.Body.Bytes(), &grant); err != nil || recorder.Code != http.StatusOK {
+		t.Fatalf("Grant failed: %d %s", recorder.Code, recorder.Body)
+	}
+	if _, err := discoveryScope(capabilities, grant.Token, "client-1"); err != nil {
+		t.Fatalf("Issued token should be valid: %v", err)
+	}
+	digest := sha256.Sum256([]byte(grant.Token))
+	if grant.Timestamp == nil || tsa.VerifyTimestamp(grant.Timestamp, digest[:]) != nil {
+		t.Fatalf("Expected a verifiable timestamp on the grant, got %+v", grant.Timestamp)
+	}
+
+	// So do revocations, in the ack and in the revocation list
+	envelope := protocol.NewTypedEnvelope("client-1", protocol.RevokeCapabilityBody{TokenID: grant.TokenID, Capability: grant.Token})
+	envelope.Sign(privKey)
+	var revoked struct {
+		Timestamp *protocol.TimestampToken `json:"timestamp"`
+	}
+	ack := sendForAck(t, broker, envelope)
+	if err := ack.ResultAs(&revoked); err != nil || revoked.Timestamp == nil {
+		t.Fatalf("Expected the revocation ack to carry a timestamp, got %+v %v", revoked, err)
+	}
+	data, _ := json.Marshal(envelope)
+	signed, _ := protocol.ParseEnvelope(data)
+	signed.Timestamp = revoked.Timestamp
+	if err := signed.VerifyTimestamp(tsa); err != nil {
+		t.Errorf("Revocation timestamp should verify over the envelope: %v", err)
+	}
+	if entries := broker.federation.capabilityRevocations.Entries(); len(entries) != 1 || entries[0].Timestamp == nil {
+		t.Errorf("Expected the timestamp kept with the revocation, got %+v", entries)
+	}
+}
+
+func TestCapabilityRevocationListMergeAndExpiry(t *testing.T) {
+	list := NewCapabilityRevocationList()
+	list.Revoke(RevokedCapability{TokenID: "local", Expires: time.Now().Add(time.Hour)})
+
+	added := list.Merge([]RevokedCapability{
+		{TokenID: "local", Reason: "duplicate"},
+		{TokenID: "peer", BrokerID: "peer-1", Expires: time.Now().Add(time.Hour)},
+		{TokenID: "stale", Expires: time.Now().Add(-time.Minute)},
+	})
+	if added != 1 || !list.IsRevoked("peer") || list.IsRevoked("stale") {
+		t.Errorf("Expected only the live peer entry to merge, added %d", added)
+	}
+
+	list.Revoke(RevokedCapability{TokenID: "expiring", Expires: time.Now().Add(-time.Second)})
+	for _, entry := range list.Entries() {
+		if entry.TokenID == "expiring" {
+			t.Error("Entries for expired tokens should be dropped")
+		}
+	}
+	if list.IsRevoked("expiring") {
+		t.Error("Expired entries should be pruned from the list")
+	}
+}
+
+func TestCapabilityRevocationsSurviveRestart(t *testing.T) {
+	broker := NewBroker()
+	broker.federation.CapabilityRevocations().Revoke(RevokedCapability{TokenID: "jti-1", Expires: time.Now().Add(time.Hour)})
+	path := t.TempDir() + "/state.json"
+	if err := broker.SaveState(path); err != nil {
+		t.Fatalf("Failed to save state: %v", err)
+	}
+
+	restarted := NewBroker()
+	if err := restarted.LoadState(path); err != nil {
+		t.Fatalf("Failed to load state: %v", err)
+	}
+	if !restarted.federation.CapabilityRevocations().IsRevoked("jti-1") {
+		t.Error("Revocations should be restored before the broker warms up")
+	}
+	<-restarted.WarmupDone()
+}
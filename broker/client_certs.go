@@ -0,0 +1,110 @@
+package main
+
+import (
+	"crypto/tls"
+	"fmt"
+
+	"github.com/fep-fem/protocol"
+)
+
+// ClientCertMode selects whether the broker asks TLS clients for identity certificates
+type ClientCertMode string
+
+const (
+	ClientCertsOff     ClientCertMode = ""        // One-way TLS
+	ClientCertsRequest ClientCertMode = "request" // Check certificates that clients present
+	ClientCertsRequire ClientCertMode = "require" // Refuse clients without a certificate
+)
+
+// ParseClientCertMode validates a --client-certs value
+func ParseClientCertMode(s string) (ClientCertMode, error) {
+	switch mode := ClientCertMode(s); mode {
+	case ClientCertsOff, ClientCertsRequest, ClientCertsRequire:
+		return mode, nil
+	default:
+		return "", fmt.Errorf("unknown client certificate mode %q: want request or require", s)
+	}
+}
+
+// configureClientCerts sets up config to accept identity certificates from
+// TLS clients. The handshake proves the client holds the certificate's key;
+// ClientCertBinding then checks that key signed the envelopes it sends.
+func configureClientCerts(config *tls.Config, mode ClientCertMode) {
+	switch mode {
+	case ClientCertsRequest:
+		config.ClientAuth = tls.RequestClientCert
+	case ClientCertsRequire:
+		config.ClientAuth = tls.RequireAnyClientCert
+	default:
+		return
+	}
+	config.VerifyPeerCertificate = protocol.VerifyIdentityCertificate
+}
+
+// ClientCertBinding is middleware refusing envelopes not signed by the key of
+// the connection's client certificate, so a TLS session bound to one agent
+// cannot carry another agent's envelopes. For a registered agent that key must
+// also be the agent's registered key. With require set, envelopes arriving
+// without an identity certificate are refused too.
+type ClientCertBinding struct {
+	broker  *Broker
+	require bool
+}
+
+// NewClientCertBinding creates the client certificate middleware
+func NewClientCertBinding(broker *Broker, mode ClientCertMode) *ClientCertBinding {
+	return &ClientCertBinding{broker: broker, require: mode == ClientCertsRequire}
+}
+
+// BeforeDispatch checks the envelope signature against the client certificate
+// key, and that key against the sender's registered key
+func (c *ClientCertBinding) BeforeDispatch(ctx *EnvelopeContext) error {
+	if ctx.Transport.ClientKey == "" {
+		if c.require {
+			return &protocol.ErrorBody{Code: protocol.CodeUnauthorized, Message: "A client certificate bound to the sender's key is required"}
+		}
+		return nil
+	}
+
+	publicKey, err := protocol.DecodePublicKey(ctx.Transport.ClientKey)
+	if err == nil {
+		err = ctx.Envelope.Verify(publicKey)
+	}
+	if err != nil {
+		return c.mismatch(ctx, fmt.Sprintf("%s envelope not signed by client certificate key %s: %v", ctx.Envelope.Type, ctx.Transport.ClientKey, err),
+			"Envelope is not signed by the key of the TLS client certificate")
+	}
+
+	// Once an agent is registered, its certificate must carry the agent's key,
+	// or its previous key during the rotation grace window
+	c.broker.mu.RLock()
+	agent, known := c.broker.agents[ctx.Envelope.Agent]
+	c.broker.mu.RUnlock()
+	if known {
+		if _, held := agent.keyByID(protocol.KeyID(publicKey)); !held {
+			return c.mismatch(ctx, fmt.Sprintf("client certificate key %s is not the registered key of %s", ctx.Transport.ClientKey, ctx.Envelope.Agent),
+				"The TLS client certificate key is not the sender's registered key")
+		}
+	}
+	return nil
+}
+
+// mismatch raises a security event for an envelope whose client certificate
+// does not bind it to its sender and returns the error refusing it
+func (c *ClientCertBinding) mismatch(ctx *EnvelopeContext, detail, message string) error {
+	source := ctx.Transport
+	c.broker.securityEvents.Raise(SecurityEvent{
+		Type:    SecurityEventClientKeyMismatch,
+		AgentID: ctx.Envelope.Agent,
+		Detail:  detail,
+		Source:  &source,
+	})
+	return &protocol.ErrorBody{
+		Code:    protocol.CodeUnauthorized,
+		Message: message,
+		Details: map[string]interface{}{"clientKey": ctx.Transport.ClientKey},
+	}
+}
+
+// AfterDispatch does nothing
+func (c *ClientCertBinding) AfterDispatch(ctx *EnvelopeContext, result *EnvelopeResult) {}
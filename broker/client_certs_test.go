@@ -0,0 +1,110 @@
+package main
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/tls"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/fep-fem/protocol"
+)
+
+func TestClientCertBindsEnvelopeSigner(t *testing.T) {
+	broker := NewBroker()
+	broker.Use("client-certs", NewClientCertBinding(broker, ClientCertsRequest))
+	server := httptest.NewUnstartedServer(broker)
+	server.TLS = &tls.Config{}
+	configureClientCerts(server.TLS, ClientCertsRequest)
+	server.StartTLS()
+	defer server.Close()
+
+	pubKey, privKey, _ := protocol.GenerateKeyPair()
+	registerDiscoveryClient(broker, "worker", pubKey)
+	broker.mcpRegistry.RegisterAgent("worker", &MCPAgent{ID: "worker"})
+	_, otherKey, _ := protocol.GenerateKeyPair()
+
+	heartbeat := func(signer ed25519.PrivateKey, certs ...tls.Certificate) int {
+		t.Helper()
+		envelope, err := protocol.NewHeartbeat("worker").SignWith(signer)
+		if err != nil {
+			t.Fatalf("Failed to build heartbeat: %v", err)
+		}
+		data, _ := json.Marshal(envelope)
+		transport := server.Client().Transport.(*http.Transport).Clone()
+		transport.TLSClientConfig.Certificates = certs
+		resp, err := (&http.Client{Transport: transport}).Post(server.URL, "application/json", bytes.NewReader(data))
+		if err != nil {
+			t.Fatalf("Request failed: %v", err)
+		}
+		defer resp.Body.Close()
+		io.Copy(io.Discard, resp.Body)
+		return resp.StatusCode
+	}
+
+	if code := heartbeat(privKey); code != http.StatusOK {
+		t.Errorf("Heartbeat without a client certificate should pass in request mode, got %d", code)
+	}
+	cert, _ := protocol.NewIdentityCertificate(privKey, "worker", 0)
+	if code := heartbeat(privKey, cert); code != http.StatusOK {
+		t.Errorf("Heartbeat signed by the certificate key should be accepted, got %d", code)
+	}
+
+	// Another agent's certificate cannot carry worker's envelopes
+	otherCert, _ := protocol.NewIdentityCertificate(otherKey, "intruder", 0)
+	if code := heartbeat(privKey, otherCert); code != http.StatusUnauthorized {
+		t.Errorf("Heartbeat over another key's certificate should be refused, got %d", code)
+	}
+	events := broker.securityEvents.Recent()
+	if len(events) != 1 || events[0].Type != SecurityEventClientKeyMismatch || events[0].AgentID != "worker" {
+		t.Errorf("Expected a client key mismatch event, got %+v", events)
+	}
+
+	// A certificate matching the signature still has to carry the registered key
+	if code := heartbeat(otherKey, otherCert); code != http.StatusUnauthorized {
+		t.Errorf("Heartbeat over a certificate for a key worker never registered should be refused, got %d", code)
+	}
+	if events := broker.securityEvents.Recent(); len(events) != 2 || events[1].Type != SecurityEventClientKeyMismatch {
+		t.Errorf("Expected a second client key mismatch event, got %+v", events)
+	}
+
+	// After a rotation the previous key's certificate binds during the grace window only
+	newPub, newKey, _ := protocol.GenerateKeyPair()
+	broker.mu.Lock()
+	broker.agents["worker"].PubKey = protocol.EncodePublicKey(newPub)
+	broker.agents["worker"].PreviousPubKey = protocol.EncodePublicKey(pubKey)
+	broker.agents["worker"].PreviousKeyExpires = time.Now().Add(time.Hour)
+	broker.mu.Unlock()
+	newCert, _ := protocol.NewIdentityCertificate(newKey, "worker", 0)
+	if code := heartbeat(newKey, newCert); code != http.StatusOK {
+		t.Errorf("Heartbeat over the new key's certificate should be accepted, got %d", code)
+	}
+	if code := heartbeat(privKey, cert); code != http.StatusOK {
+		t.Errorf("Heartbeat over the previous key's certificate should be accepted during the grace window, got %d", code)
+	}
+	broker.mu.Lock()
+	broker.agents["worker"].PreviousKeyExpires = time.Now().Add(-time.Second)
+	broker.mu.Unlock()
+	if code := heartbeat(privKey, cert); code != http.StatusUnauthorized {
+		t.Errorf("Heartbeat over the previous key's certificate should be refused after the grace window, got %d", code)
+	}
+}
+
+func TestClientCertRequired(t *testing.T) {
+	broker := NewBroker()
+	broker.Use("client-certs", NewClientCertBinding(broker, ClientCertsRequire))
+
+	// Envelopes reaching the broker without an identity certificate are refused
+	resp, _ := sendHeartbeat(t, broker, "worker")
+	if resp.Code != http.StatusUnauthorized {
+		t.Errorf("Heartbeat without a client certificate should be refused, got %d", resp.Code)
+	}
+
+	if _, err := ParseClientCertMode("optional"); err == nil {
+		t.Error("Unknown client certificate mode should be rejected")
+	}
+}
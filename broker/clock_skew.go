@@ -0,0 +1,36 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/fep-fem/protocol"
+)
+
+// SetSkewPolicy bounds how old or future-dated an envelope's ts may be
+func (b *Broker) SetSkewPolicy(policy protocol.SkewPolicy) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.skew = policy
+}
+
+// checkClockSkew rejects envelopes whose ts is outside the skew window,
+// reporting the broker's clock so the sender can correct its own
+func (b *Broker) checkClockSkew(w http.ResponseWriter, env *protocol.GenericEnvelope) bool {
+	b.mu.RLock()
+	policy := b.skew
+	b.mu.RUnlock()
+
+	err := policy.Check(env.TS, time.Now())
+	if err == nil {
+		return true
+	}
+	rejection := b.newError(env, protocol.CodeClockSkew, err.Error())
+	var skewErr *protocol.ClockSkewError
+	if errors.As(err, &skewErr) {
+		rejection.Body.Details = skewErr.Details()
+	}
+	writeError(w, rejection)
+	return false
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/fep-fem/protocol"
+)
+
+func TestBrokerRejectsSkewedEnvelopes(t *testing.T) {
+	broker := NewBroker()
+	send := func(ts time.Time) *httptest.ResponseRecorder {
+		envelope := protocol.NewTypedEnvelope("client", protocol.EmitEventBody{Event: "ci.build"})
+		envelope.TS = ts.UnixMilli()
+		data, _ := json.Marshal(envelope)
+		recorder := httptest.NewRecorder()
+		broker.ServeHTTP(recorder, httptest.NewRequest(http.MethodPost, "/", bytes.NewReader(data)))
+		return recorder
+	}
+
+	for name, ts := range map[string]time.Time{
+		"stale":        time.Now().Add(-10 * time.Minute),
+		"future-dated": time.Now().Add(10 * time.Minute),
+	} {
+		resp := send(ts)
+		_, err := protocol.ParseResponse(resp.Body.Bytes())
+		var rejection *protocol.ErrorBody
+		if resp.Code != http.StatusBadRequest || !errors.As(err, &rejection) || rejection.Code != protocol.CodeClockSkew {
+			t.Errorf("%s envelope should be rejected for clock skew, got %d %v", name, resp.Code, err)
+			continue
+		}
+		if _, ok := rejection.Details["serverTime"]; !ok {
+			t.Errorf("Rejection should report the broker's clock, got %v", rejection.Details)
+		}
+	}
+
+	if resp := send(time.Now()); resp.Code != http.StatusOK {
+		t.Errorf("Current envelope should be accepted, got %d %s", resp.Code, resp.Body.String())
+	}
+
+	broker.SetSkewPolicy(protocol.SkewPolicy{MaxFuture: time.Minute})
+	if resp := send(time.Now().Add(-24 * time.Hour)); resp.Code != http.StatusOK {
+		t.Errorf("Disabling the age limit should accept old envelopes, got %d", resp.Code)
+	}
+}
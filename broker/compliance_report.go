@@ -0,0 +1,312 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// defaultComplianceWindow is the period a report covers when no range is given
+const defaultComplianceWindow = 24 * time.Hour
+
+// ToolAccessSummary counts one agent's calls to one tool during a report period
+type ToolAccessSummary struct {
+	AgentID   string    `json:"agentId"`
+	Tenant    string    `json:"tenant,omitempty"`
+	Tool      string    `json:"tool"`
+	Calls     int       `json:"calls"`  // Calls the broker accepted
+	Denied    int       `json:"denied"` // Calls refused by authentication or policy
+	FirstCall time.Time `json:"firstCall"`
+	LastCall  time.Time `json:"lastCall"`
+}
+
+// ComplianceSummary counts the records in each section of a report
+type ComplianceSummary struct {
+	Calls       int `json:"calls"`
+	Denials     int `json:"denials"`
+	Revocations int `json:"revocations"`
+	Violations  int `json:"violations"`
+}
+
+// ComplianceReport summarizes the audit log for a period and, optionally, a tenant
+type ComplianceReport struct {
+	BrokerID    string              `json:"brokerId"`
+	GeneratedAt time.Time           `json:"generatedAt"`
+	Since       time.Time           `json:"since"`
+	Until       time.Time           `json:"until"`
+	Tenant      string              `json:"tenant,omitempty"`
+	Summary     ComplianceSummary   `json:"summary"`
+	ToolAccess  []ToolAccessSummary `json:"toolAccess"`
+	Denials     []AuditRecord       `json:"denials"`
+	Revocations []AuditRecord       `json:"revocations"`
+	Violations  []AuditRecord       `json:"violations"`
+}
+
+// ComplianceReport builds a report from the audit records made in [since, until)
+func (b *Broker) ComplianceReport(since, until time.Time, tenant string) *ComplianceReport {
+	report := &ComplianceReport{
+		BrokerID:    b.federation.config.LocalBrokerID,
+		GeneratedAt: time.Now(),
+		Since:       since,
+		Until:       until,
+		Tenant:      tenant,
+		ToolAccess:  []ToolAccessSummary{},
+		Denials:     []AuditRecord{},
+		Revocations: []AuditRecord{},
+		Violations:  []AuditRecord{},
+	}
+
+	access := make(map[[2]string]*ToolAccessSummary)
+	for _, record := range b.audit.Query(since, until, tenant) {
+		switch record.Category {
+		case AuditDenial:
+			report.Denials = append(report.Denials, record)
+		case AuditRevocation:
+			report.Revocations = append(report.Revocations, record)
+		case AuditViolation:
+			report.Violations = append(report.Violations, record)
+		}
+		if record.Tool == "" || (record.Category != AuditAccess && record.Category != AuditDenial) {
+			continue
+		}
+
+		key := [2]string{record.AgentID, record.Tool}
+		summary, exists := access[key]
+		if !exists {
+			summary = &ToolAccessSummary{AgentID: record.AgentID, Tenant: record.Tenant, Tool: record.Tool, FirstCall: record.Time}
+			access[key] = summary
+		}
+		if record.Category == AuditAccess {
+			summary.Calls++
+		} else {
+			summary.Denied++
+		}
+		summary.LastCall = record.Time
+	}
+
+	for _, summary := range access {
+		report.ToolAccess = append(report.ToolAccess, *summary)
+		report.Summary.Calls += summary.Calls
+	}
+	sort.Slice(report.ToolAccess, func(i, j int) bool {
+		a, b := report.ToolAccess[i], report.ToolAccess[j]
+		if a.AgentID != b.AgentID {
+			return a.AgentID < b.AgentID
+		}
+		return a.Tool < b.Tool
+	})
+	report.Summary.Denials = len(report.Denials)
+	report.Summary.Revocations = len(report.Revocations)
+	report.Summary.Violations = len(report.Violations)
+	return report
+}
+
+// complianceCSVHeader names the columns of a CSV report. Access rows carry the
+// call counts; denial, revocation and violation rows carry one audit record each.
+var complianceCSVHeader = []string{"section", "time", "agent", "tenant", "envelope", "tool", "outcome", "constraint", "detail", "calls", "denied"}
+
+// WriteCSV writes the report as CSV, one row per tool access summary and per event
+func (r *ComplianceReport) WriteCSV(w io.Writer) error {
+	out := csv.NewWriter(w)
+	out.Write(complianceCSVHeader)
+	for _, access := range r.ToolAccess {
+		out.Write([]string{
+			string(AuditAccess), access.LastCall.UTC().Format(time.RFC3339), access.AgentID, access.Tenant, "", access.Tool,
+			"", "", "", strconv.Itoa(access.Calls), strconv.Itoa(access.Denied),
+		})
+	}
+	for _, section := range r.sections() {
+		for _, record := range section.records {
+			out.Write([]string{
+				string(record.Category), record.Time.UTC().Format(time.RFC3339), record.AgentID, record.Tenant, string(record.Envelope),
+				record.Tool, record.Outcome, record.Constraint, record.Detail, "", "",
+			})
+		}
+	}
+	out.Flush()
+	return out.Error()
+}
+
+// WritePDF writes the report as a plain text PDF
+func (r *ComplianceReport) WritePDF(w io.Writer) error {
+	var lines []string
+	lines = append(lines,
+		"FEM Compliance Report",
+		"",
+		"Broker:    "+r.BrokerID,
+		"Period:    "+r.Since.UTC().Format(time.RFC3339)+" to "+r.Until.UTC().Format(time.RFC3339),
+		"Generated: "+r.GeneratedAt.UTC().Format(time.RFC3339),
+	)
+	if r.Tenant != "" {
+		lines = append(lines, "Tenant:    "+r.Tenant)
+	}
+	lines = append(lines,
+		"",
+		fmt.Sprintf("Tool calls: %d   Denials: %d   Revocations: %d   Violations: %d",
+			r.Summary.Calls, r.Summary.Denials, r.Summary.Revocations, r.Summary.Violations),
+		"",
+		"Tool Access",
+	)
+	if len(r.ToolAccess) == 0 {
+		lines = append(lines, "  none")
+	}
+	for _, access := range r.ToolAccess {
+		lines = append(lines, fmt.Sprintf("  %s -> %s: %d calls, %d denied (last %s)",
+			access.AgentID, access.Tool, access.Calls, access.Denied, access.LastCall.UTC().Format(time.RFC3339)))
+	}
+	for _, section := range r.sections() {
+		lines = append(lines, "", section.title)
+		if len(section.records) == 0 {
+			lines = append(lines, "  none")
+		}
+		for _, record := range section.records {
+			entry := []string{record.Time.UTC().Format(time.RFC3339), record.AgentID}
+			for _, field := range []string{record.Tool, record.Outcome, record.Constraint, record.Detail} {
+				if field != "" {
+					entry = append(entry, field)
+				}
+			}
+			lines = append(lines, "  "+strings.Join(entry, "  "))
+		}
+	}
+	return writeTextPDF(w, lines)
+}
+
+type complianceSection struct {
+	title   string
+	records []AuditRecord
+}
+
+func (r *ComplianceReport) sections() []complianceSection {
+	return []complianceSection{
+		{"Policy Denials", r.Denials},
+		{"Revocations", r.Revocations},
+		{"Constraint Violations", r.Violations},
+	}
+}
+
+// Write writes the report in the given format: json, csv or pdf
+func (r *ComplianceReport) Write(w io.Writer, format string) error {
+	switch format {
+	case "csv":
+		return r.WriteCSV(w)
+	case "pdf":
+		return r.WritePDF(w)
+	case "json":
+		return json.NewEncoder(w).Encode(r)
+	default:
+		return fmt.Errorf("unknown report format %q", format)
+	}
+}
+
+// complianceContentTypes maps report formats to their MIME types
+var complianceContentTypes = map[string]string{
+	"json": "application/json",
+	"csv":  "text/csv",
+	"pdf":  "application/pdf",
+}
+
+// handleAdminComplianceReport builds a report for the since, until and tenant
+// query parameters. The period defaults to the last 24 hours.
+func (b *Broker) handleAdminComplianceReport(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	query := r.URL.Query()
+	until := time.Now()
+	if value := query.Get("until"); value != "" {
+		parsed, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			http.Error(w, "Invalid until", http.StatusBadRequest)
+			return
+		}
+		until = parsed
+	}
+	since := until.Add(-defaultComplianceWindow)
+	if value := query.Get("since"); value != "" {
+		parsed, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			http.Error(w, "Invalid since", http.StatusBadRequest)
+			return
+		}
+		since = parsed
+	}
+	format := query.Get("format")
+	if format == "" {
+		format = "json"
+	}
+	contentType, known := complianceContentTypes[format]
+	if !known {
+		http.Error(w, "Invalid format", http.StatusBadRequest)
+		return
+	}
+
+	report := b.ComplianceReport(since, until, query.Get("tenant"))
+	w.Header().Set("Content-Type", contentType)
+	if format != "json" {
+		w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", complianceReportName(report, format)))
+	}
+	if err := report.Write(w, format); err != nil {
+		log.Printf("Failed to write compliance report: %v", err)
+	}
+}
+
+// complianceReportName names a report file after the period it covers
+func complianceReportName(report *ComplianceReport, format string) string {
+	name := "compliance-" + report.Until.UTC().Format("20060102T150405Z")
+	if report.Tenant != "" {
+		name += "-" + report.Tenant
+	}
+	return name + "." + format
+}
+
+// StartComplianceReports writes a report covering the previous interval to
+// dir every interval
+func (b *Broker) StartComplianceReports(dir string, interval time.Duration, format string) error {
+	if _, known := complianceContentTypes[format]; !known {
+		return fmt.Errorf("unknown report format %q", format)
+	}
+	if err := os.MkdirAll(dir, 0o750); err != nil {
+		return fmt.Errorf("failed to create report directory: %w", err)
+	}
+	if interval <= 0 {
+		return nil
+	}
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		since := time.Now()
+		for until := range ticker.C {
+			report := b.ComplianceReport(since, until, "")
+			if err := writeComplianceReport(filepath.Join(dir, complianceReportName(report, format)), report, format); err != nil {
+				log.Printf("Failed to write compliance report: %v", err)
+			}
+			since = until
+		}
+	}()
+	return nil
+}
+
+func writeComplianceReport(path string, report *ComplianceReport, format string) error {
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o640)
+	if err != nil {
+		return err
+	}
+	if err := report.Write(file, format); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
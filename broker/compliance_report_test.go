@@ -0,0 +1,155 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/fep-fem/protocol"
+)
+
+func rejectionResult(broker *Broker, code protocol.ErrorCode, details map[string]interface{}) *EnvelopeResult {
+	rejection := broker.newError(nil, code, "refused")
+	rejection.Body.Details = details
+	data, _ := json.Marshal(rejection)
+	return &EnvelopeResult{Status: code.HTTPStatus(), Body: data}
+}
+
+func TestAuditRecorderClassifiesOutcomes(t *testing.T) {
+	broker := NewBroker()
+	recorder := NewAuditRecorder(broker)
+	call := func(agent string) *EnvelopeContext {
+		return piiEnvelope(protocol.EnvelopeToolCall, agent, map[string]interface{}{"tool": "crm.lookup", "requestId": "r1"})
+	}
+
+	recorder.AfterDispatch(call("team-a.client"), &EnvelopeResult{Status: http.StatusOK})
+	recorder.AfterDispatch(call("team-a.client"), rejectionResult(broker, protocol.CodeCapabilityDenied, nil))
+	recorder.AfterDispatch(call("team-b.client"), rejectionResult(broker, protocol.CodePolicyViolation, map[string]interface{}{"constraint": ResidencyConstraint}))
+	recorder.AfterDispatch(call("team-b.client"), rejectionResult(broker, protocol.CodeInvalidBody, nil))
+	revoke := piiEnvelope(protocol.EnvelopeRevoke, "team-a.admin", map[string]interface{}{"target": "team-a.client"})
+	recorder.AfterDispatch(revoke, &EnvelopeResult{Status: http.StatusOK})
+	broker.securityEvents.Raise(SecurityEvent{Type: SecurityEventCapabilityRevoked, AgentID: "team-b.client", Detail: "revoked capability jti-1"})
+	broker.securityEvents.Raise(SecurityEvent{Type: SecurityEventPIIRedacted, AgentID: "team-b.client", Detail: "redacted email"})
+	broker.securityEvents.Raise(SecurityEvent{Type: SecurityEventHoneypotTriggered, AgentID: "team-b.client"})
+
+	records := broker.audit.Query(time.Time{}, time.Now().Add(time.Second), "")
+	var categories []string
+	for _, record := range records {
+		categories = append(categories, string(record.Category)+":"+record.Outcome)
+	}
+	want := "access:allowed denial:capability_denied violation:policy_violation revocation:revoked revocation:revoked violation:redacted"
+	if got := strings.Join(categories, " "); got != want {
+		t.Fatalf("Unexpected audit records:\n got %s\nwant %s", got, want)
+	}
+	if records[2].Constraint != ResidencyConstraint || records[5].Constraint != PIIConstraint {
+		t.Errorf("Violations should cite their constraint, got %q and %q", records[2].Constraint, records[5].Constraint)
+	}
+	if records[0].Tenant != "team-a" || records[0].Tool != "crm.lookup" {
+		t.Errorf("Expected the tenant and tool to be recorded, got %+v", records[0])
+	}
+}
+
+func TestComplianceReport(t *testing.T) {
+	broker := NewBroker()
+	start := time.Now().Add(-time.Hour)
+	for i, record := range []AuditRecord{
+		{Category: AuditAccess, AgentID: "team-a.client", Tool: "crm.lookup", Outcome: "allowed"},
+		{Category: AuditAccess, AgentID: "team-a.client", Tool: "crm.lookup", Outcome: "allowed"},
+		{Category: AuditDenial, AgentID: "team-a.client", Tool: "crm.lookup", Outcome: "forbidden"},
+		{Category: AuditDenial, AgentID: "team-a.client", Envelope: protocol.EnvelopeDiscoverTools, Outcome: "unauthorized"},
+		{Category: AuditViolation, AgentID: "team-b.client", Tool: "crm.lookup", Outcome: "policy_violation", Constraint: PIIConstraint},
+		{Category: AuditRevocation, Outcome: "revoked", Detail: "operator revoked capability jti-1"},
+	} {
+		record.Time = start.Add(time.Duration(i) * time.Minute)
+		broker.audit.Record(record)
+	}
+
+	report := broker.ComplianceReport(start, time.Now(), "team-a")
+	if report.Summary != (ComplianceSummary{Calls: 2, Denials: 2}) {
+		t.Errorf("Unexpected team-a summary: %+v", report.Summary)
+	}
+	if len(report.ToolAccess) != 1 || report.ToolAccess[0].Calls != 2 || report.ToolAccess[0].Denied != 1 {
+		t.Errorf("Unexpected tool access: %+v", report.ToolAccess)
+	}
+
+	report = broker.ComplianceReport(start.Add(4*time.Minute), time.Now(), "")
+	if report.Summary != (ComplianceSummary{Revocations: 1, Violations: 1}) {
+		t.Errorf("Time range should exclude earlier records, got %+v", report.Summary)
+	}
+
+	var out bytes.Buffer
+	if err := broker.ComplianceReport(start, time.Now(), "").WriteCSV(&out); err != nil {
+		t.Fatalf("Failed to write CSV: %v", err)
+	}
+	rows, err := csv.NewReader(&out).ReadAll()
+	if err != nil || len(rows) != 6 || rows[1][0] != "access" || rows[1][9] != "2" || rows[1][10] != "1" {
+		t.Errorf("Unexpected CSV rows: %v %v", rows, err)
+	}
+}
+
+func TestWriteTextPDF(t *testing.T) {
+	lines := []string{"Report (draft)", strings.Repeat("x", pdfLineWidth+10), "naïve"}
+	for i := 0; i < pdfLinesPerPage; i++ {
+		lines = append(lines, "filler")
+	}
+	var out bytes.Buffer
+	if err := writeTextPDF(&out, lines); err != nil {
+		t.Fatalf("Failed to write PDF: %v", err)
+	}
+
+	pdf := out.String()
+	if !strings.HasPrefix(pdf, "%PDF-1.4\n") || !strings.HasSuffix(pdf, "%%EOF\n") {
+		t.Fatal("Expected a complete PDF document")
+	}
+	for _, want := range []string{"(Report \\(draft\\)) '", "(    xxxxxxxxxx) '", "(na?ve) '", "/Count 2"} {
+		if !strings.Contains(pdf, want) {
+			t.Errorf("Expected PDF to contain %q", want)
+		}
+	}
+
+	// The cross-reference table points at each object
+	xref := pdf[strings.LastIndex(pdf, "\nxref\n"):]
+	if !strings.Contains(xref, "0000000009 00000 n") || !strings.HasPrefix(pdf[9:], "1 0 obj") {
+		t.Error("Expected the catalog at offset 9")
+	}
+}
+
+func TestAdminComplianceReport(t *testing.T) {
+	broker := NewBroker()
+	broker.SetAdminToken("secret")
+	broker.audit.Record(AuditRecord{Category: AuditAccess, AgentID: "team-a.client", Tool: "crm.lookup", Outcome: "allowed"})
+
+	get := func(query string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/admin/compliance-report?"+query, nil)
+		req.Header.Set("Authorization", "Bearer secret")
+		recorder := httptest.NewRecorder()
+		broker.ServeHTTP(recorder, req)
+		return recorder
+	}
+
+	resp := get("tenant=team-a&format=pdf")
+	if resp.Code != http.StatusOK || resp.Header().Get("Content-Type") != "application/pdf" ||
+		!strings.Contains(resp.Header().Get("Content-Disposition"), "-team-a.pdf") {
+		t.Errorf("Expected a PDF attachment, got %d %v", resp.Code, resp.Header())
+	}
+
+	var report ComplianceReport
+	json.Unmarshal(get("tenant=team-a").Body.Bytes(), &report)
+	if report.Summary.Calls != 1 || report.Tenant != "team-a" {
+		t.Errorf("Unexpected JSON report: %+v", report)
+	}
+	if resp := get("until=" + time.Now().Add(-time.Hour).Format(time.RFC3339)); resp.Code != http.StatusOK {
+		t.Errorf("Expected a report for an earlier period, got %d", resp.Code)
+	}
+
+	for _, query := range []string{"format=xlsx", "since=yesterday"} {
+		if resp := get(query); resp.Code != http.StatusBadRequest {
+			t.Errorf("Expected %s to be rejected, got %d", query, resp.Code)
+		}
+	}
+}
@@ -0,0 +1,363 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fep-fem/protocol"
+)
+
+// BudgetConstraint names the constraint cited in budget rejections
+const BudgetConstraint = "budget"
+
+// defaultBudgetPeriod is how long tenant spending accumulates before it resets
+const defaultBudgetPeriod = 30 * 24 * time.Hour
+
+// pendingChargeTTL bounds how long a call's charge waits for its result
+const pendingChargeTTL = 24 * time.Hour
+
+// BudgetExceeded is returned when every agent offering a tool costs more per
+// call than the calling tenant has left to spend
+type BudgetExceeded struct {
+	Tenant    string
+	Tool      string
+	Remaining float64 // Left of the tenant's budget this period
+	Cheapest  float64 // Lowest price of a call among the tool's agents
+}
+
+func (e *BudgetExceeded) Error() string {
+	return fmt.Sprintf("tenant %s has %g of its budget left but %s costs at least %g per call", e.Tenant, e.Remaining, e.Tool, e.Cheapest)
+}
+
+// ErrorBody converts the refusal into the error sent to the caller
+func (e *BudgetExceeded) ErrorBody() *protocol.ErrorBody {
+	return &protocol.ErrorBody{
+		Code:    protocol.CodeForbidden,
+		Message: e.Error(),
+		Details: map[string]interface{}{
+			"constraint": BudgetConstraint,
+			"tenant":     e.Tenant,
+			"tool":       e.Tool,
+			"remaining":  e.Remaining,
+			"cost":       e.Cheapest,
+		},
+	}
+}
+
+// TenantBudget is one tenant's spending in the current period
+type TenantBudget struct {
+	Tenant      string    `json:"tenant"`
+	Cap         float64   `json:"cap,omitempty"` // Zero if the tenant is not capped
+	Spent       float64   `json:"spent"`
+	PeriodStart time.Time `json:"periodStart"`
+}
+
+// pendingCharge is a call whose caller pays once an agent answers it
+type pendingCharge struct {
+	tenant string
+	tool   string
+	since  time.Time
+}
+
+// BudgetLedger tracks what each tenant spends on tool calls per period, and
+// the cap on it. A tenant is the namespace of its agents' IDs.
+type BudgetLedger struct {
+	caps        map[string]float64
+	spent       map[string]float64
+	pending     map[string]pendingCharge // By request ID
+	period      time.Duration
+	periodStart time.Time
+	mu          sync.Mutex
+}
+
+// NewBudgetLedger creates a ledger with no caps
+func NewBudgetLedger() *BudgetLedger {
+	return &BudgetLedger{
+		caps:        make(map[string]float64),
+		spent:       make(map[string]float64),
+		pending:     make(map[string]pendingCharge),
+		period:      defaultBudgetPeriod,
+		periodStart: time.Now(),
+	}
+}
+
+// Configure sets the per-tenant caps and how long spending accumulates
+// before it resets; a zero period keeps the current one
+func (l *BudgetLedger) Configure(caps map[string]float64, period time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.caps = make(map[string]float64, len(caps))
+	for tenant, budget := range caps {
+		l.caps[tenant] = budget
+	}
+	if period > 0 {
+		l.period = period
+	}
+}
+
+// Remaining returns what a tenant has left to spend this period, and whether
+// it is capped at all
+func (l *BudgetLedger) Remaining(tenant string) (float64, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.rollLocked(time.Now())
+	budget, capped := l.caps[tenant]
+	if !capped {
+		return 0, false
+	}
+	return max(budget-l.spent[tenant], 0), true
+}
+
+// Charge adds to what a tenant spent this period
+func (l *BudgetLedger) Charge(tenant string, amount float64) {
+	if amount <= 0 {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.rollLocked(time.Now())
+	l.spent[tenant] += amount
+}
+
+// Expect notes that a tenant pays for a call once an agent answers it
+func (l *BudgetLedger) Expect(requestID, tenant, tool string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := time.Now()
+	for id, charge := range l.pending {
+		if now.Sub(charge.since) > pendingChargeTTL {
+			delete(l.pending, id)
+		}
+	}
+	l.pending[requestID] = pendingCharge{tenant: tenant, tool: tool, since: now}
+}
+
+// settle removes the pending charge for a call, returning who pays for which tool
+func (l *BudgetLedger) settle(requestID string) (pendingCharge, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	charge, exists := l.pending[requestID]
+	delete(l.pending, requestID)
+	return charge, exists
+}
+
+// Budgets lists the spending of each capped tenant and each that spent
+// anything this period, by tenant
+func (l *BudgetLedger) Budgets() []TenantBudget {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.rollLocked(time.Now())
+	budgets := make(map[string]*TenantBudget)
+	for tenant, budget := range l.caps {
+		budgets[tenant] = &TenantBudget{Tenant: tenant, Cap: budget, PeriodStart: l.periodStart}
+	}
+	for tenant, spent := range l.spent {
+		if budgets[tenant] == nil {
+			budgets[tenant] = &TenantBudget{Tenant: tenant, PeriodStart: l.periodStart}
+		}
+		budgets[tenant].Spent = spent
+	}
+	list := make([]TenantBudget, 0, len(budgets))
+	for _, budget := range budgets {
+		list = append(list, *budget)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Tenant < list[j].Tenant })
+	return list
+}
+
+// rollLocked starts a new period, forgetting spending, once the current one is over
+func (l *BudgetLedger) rollLocked(now time.Time) {
+	if now.Sub(l.periodStart) < l.period {
+		return
+	}
+	for now.Sub(l.periodStart) >= l.period {
+		l.periodStart = l.periodStart.Add(l.period)
+	}
+	l.spent = make(map[string]float64)
+}
+
+// parseTenantBudgets parses comma-separated tenant=cap pairs
+func parseTenantBudgets(s string) (map[string]float64, error) {
+	caps := make(map[string]float64)
+	for _, pair := range strings.Split(s, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		tenant, value, ok := strings.Cut(pair, "=")
+		if !ok || tenant == "" {
+			return nil, fmt.Errorf("%q is not tenant=cap", pair)
+		}
+		budget, err := strconv.ParseFloat(value, 64)
+		if err != nil || !(budget >= 0) {
+			return nil, fmt.Errorf("budget of tenant %s must be a non-negative amount", tenant)
+		}
+		caps[tenant] = budget
+	}
+	return caps, nil
+}
+
+// toolCost returns the price of one call of an agent's tool. Tools that
+// declare no cost, as local agents usually do, are free.
+func (fm *FederationManager) toolCost(agentID, toolName string) float64 {
+	var cost *protocol.ToolCost
+	if agent, exists := fm.mcpRegistry.GetAgent(agentID); exists {
+		for _, tool := range agent.Tools {
+			if tool.Name == toolName {
+				cost = tool.Cost
+				break
+			}
+		}
+	} else if tool := fm.peerTool(agentID, toolName); tool != nil {
+		cost = tool.Cost
+	}
+	if cost == nil {
+		return 0
+	}
+	return cost.PerCall
+}
+
+// filterBudget keeps the agents whose price for a call fits in what the
+// requester's tenant has left. Uncapped tenants may use any agent.
+func (fm *FederationManager) filterBudget(agents []string, toolName string, context *RequestContext) ([]string, *BudgetExceeded) {
+	if len(agents) == 0 || context == nil || context.RequesterID == "" {
+		return agents, nil
+	}
+	tenant := agentNamespace(context.RequesterID)
+	remaining, capped := fm.budgets.Remaining(tenant)
+	if !capped {
+		return agents, nil
+	}
+	allowed := make([]string, 0, len(agents))
+	cheapest := -1.0
+	for _, agentID := range agents {
+		cost := fm.toolCost(agentID, toolName)
+		if cost <= remaining {
+			allowed = append(allowed, agentID)
+		} else if cheapest < 0 || cost < cheapest {
+			cheapest = cost
+		}
+	}
+	if len(allowed) == 0 {
+		return nil, &BudgetExceeded{Tenant: tenant, Tool: toolName, Remaining: remaining, Cheapest: cheapest}
+	}
+	return allowed, nil
+}
+
+// CheckBudget fails with a *BudgetExceeded if a tool is offered but the
+// requester's tenant cannot afford a call on any provider
+func (fm *FederationManager) CheckBudget(toolName, requesterID string) error {
+	if _, exceeded := fm.filterBudget(fm.toolProviders(toolName), toolName, &RequestContext{RequesterID: requesterID}); exceeded != nil {
+		return exceeded
+	}
+	return nil
+}
+
+// ExpectCharge notes that the requester pays for a call once an agent answers it
+func (fm *FederationManager) ExpectCharge(requestID, requesterID, toolName string) {
+	fm.budgets.Expect(requestID, agentNamespace(requesterID), toolName)
+}
+
+// SettleCharge charges the caller of a call for the answering agent's price,
+// returning the amount charged
+func (fm *FederationManager) SettleCharge(requestID, agentID string) float64 {
+	charge, exists := fm.budgets.settle(requestID)
+	if !exists {
+		return 0
+	}
+	cost := fm.toolCost(agentID, charge.tool)
+	fm.budgets.Charge(charge.tenant, cost)
+	return cost
+}
+
+// Budgets returns the ledger of tenant spending
+func (fm *FederationManager) Budgets() *BudgetLedger {
+	return fm.budgets
+}
+
+// CostAwareStrategy picks the cheapest agent whose average response time
+// meets the request's latency requirement, by the price of the requested
+// tool. If no agent is fast enough, the fastest is chosen regardless of cost.
+// Agents without response times yet count as fast enough.
+type CostAwareStrategy struct {
+	cost func(agentID, toolName string) float64
+}
+
+func (ca *CostAwareStrategy) SelectAgent(agents []string, metrics map[string]*AgentMetrics, context *RequestContext) (string, error) {
+	if len(agents) == 0 {
+		return "", fmt.Errorf("no agents available")
+	}
+
+	toolName := ""
+	var maxLatency time.Duration
+	if context != nil {
+		toolName = context.ToolName
+		maxLatency = context.LatencyRequirement
+	}
+
+	type agentCost struct {
+		agentID string
+		cost    float64
+		latency time.Duration
+		health  float64
+	}
+
+	candidates := make([]agentCost, 0, len(agents))
+	for _, agent := range agents {
+		candidate := agentCost{agentID: agent, health: 1.0}
+		if ca.cost != nil && toolName != "" {
+			candidate.cost = ca.cost(agent, toolName)
+		}
+		if metric, exists := metrics[agent]; exists {
+			candidate.latency = metric.AverageResponseTime
+			candidate.health = metric.HealthScore
+		}
+		candidates = append(candidates, candidate)
+	}
+
+	meets := func(c agentCost) bool { return maxLatency <= 0 || c.latency <= maxLatency }
+	sort.SliceStable(candidates, func(i, j int) bool {
+		a, b := candidates[i], candidates[j]
+		switch {
+		case meets(a) != meets(b):
+			return meets(a)
+		case meets(a) && a.cost != b.cost:
+			return a.cost < b.cost
+		case a.latency != b.latency:
+			return a.latency < b.latency
+		default:
+			return a.health > b.health
+		}
+	})
+
+	return candidates[0].agentID, nil
+}
+
+// checkBudget rejects a call the caller's tenant cannot afford on any
+// provider. It reports whether the call may proceed.
+func (b *Broker) checkBudget(w http.ResponseWriter, env *protocol.GenericEnvelope, tool string) bool {
+	var exceeded *BudgetExceeded
+	if err := b.federation.CheckBudget(tool, env.Agent); errors.As(err, &exceeded) {
+		errBody := exceeded.ErrorBody()
+		rejection := b.newError(env, errBody.Code, errBody.Message)
+		rejection.Body.Details = errBody.Details
+		writeError(w, rejection)
+		return false
+	}
+	return true
+}
+
+// handleAdminBudgets lists tenant spending in the current period
+func (b *Broker) handleAdminBudgets(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	writeJSON(w, http.StatusOK, b.federation.Budgets().Budgets())
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/fep-fem/protocol"
+)
+
+// costFederation offers a tool on a free but slow local agent and a priced,
+// fast cloud agent, plus a tool only the cloud agent has
+func costFederation() (*MCPRegistry, *FederationManager) {
+	registry := NewMCPRegistry()
+	fm := NewFederationManager(registry, nil)
+	fm.config.DefaultLoadBalanceMode = LoadBalanceCostAware
+	registry.RegisterAgent("local-agent", &MCPAgent{
+		ID:    "local-agent",
+		Tools: []protocol.MCPTool{{Name: "text.summarize"}},
+	})
+	registry.RegisterAgent("cloud-agent", &MCPAgent{
+		ID: "cloud-agent",
+		Tools: []protocol.MCPTool{
+			{Name: "text.summarize", Cost: &protocol.ToolCost{PerCall: 0.02}},
+			{Name: "vision.caption", Cost: &protocol.ToolCost{PerCall: 0.05}},
+		},
+	})
+	fm.agentMetrics["local-agent"] = &AgentMetrics{AgentID: "local-agent", HealthScore: 0.9, AverageResponseTime: 900 * time.Millisecond}
+	fm.agentMetrics["cloud-agent"] = &AgentMetrics{AgentID: "cloud-agent", HealthScore: 0.9, AverageResponseTime: 100 * time.Millisecond}
+	return registry, fm
+}
+
+func TestCostAwareRoutingWithinLatency(t *testing.T) {
+	_, fm := costFederation()
+
+	for _, tc := range []struct {
+		latency time.Duration
+		want    string
+	}{
+		{0, "local-agent"},
+		{time.Second, "local-agent"},
+		{500 * time.Millisecond, "cloud-agent"},
+		{50 * time.Millisecond, "cloud-agent"}, // No agent is fast enough; the fastest is chosen
+	} {
+		decision, err := fm.RouteToolInvocation("text.summarize", "", &RequestContext{LatencyRequirement: tc.latency})
+		if err != nil || decision.SelectedAgent != tc.want || decision.LoadBalanceMode != LoadBalanceCostAware {
+			t.Errorf("Within %v expected %s, got %+v %v", tc.latency, tc.want, decision, err)
+		}
+	}
+}
+
+func TestTenantBudgetCapsRouting(t *testing.T) {
+	_, fm := costFederation()
+	fm.Budgets().Configure(map[string]float64{"acme": 0.03}, time.Hour)
+	fast := func(requester string) *RequestContext {
+		return &RequestContext{RequesterID: requester, LatencyRequirement: 500 * time.Millisecond}
+	}
+
+	if decision, err := fm.RouteToolInvocation("text.summarize", "", fast("acme.client")); err != nil || decision.SelectedAgent != "cloud-agent" {
+		t.Fatalf("Budget covers the fast agent, got %+v %v", decision, err)
+	}
+	fm.ExpectCharge("req-1", "acme.client", "text.summarize")
+	if charged := fm.SettleCharge("req-1", "cloud-agent"); charged != 0.02 {
+		t.Fatalf("Expected the cloud price charged, got %v", charged)
+	}
+	if charged := fm.SettleCharge("req-1", "cloud-agent"); charged != 0 {
+		t.Errorf("A call is charged once, got %v more", charged)
+	}
+
+	// Past its budget the tenant falls back to the free agent, however slow
+	if decision, err := fm.RouteToolInvocation("text.summarize", "", fast("acme.client")); err != nil || decision.SelectedAgent != "local-agent" {
+		t.Errorf("Expected the free agent once over budget, got %+v %v", decision, err)
+	}
+	var exceeded *BudgetExceeded
+	if _, err := fm.RouteToolInvocation("vision.caption", "", fast("acme.client")); !errors.As(err, &exceeded) || exceeded.Cheapest != 0.05 {
+		t.Errorf("Expected a budget refusal, got %v", err)
+	}
+
+	// Other tenants are not capped
+	if decision, err := fm.RouteToolInvocation("vision.caption", "", fast("globex.client")); err != nil || decision.SelectedAgent != "cloud-agent" {
+		t.Errorf("Uncapped tenant should be routed, got %+v %v", decision, err)
+	}
+
+	budgets := fm.Budgets().Budgets()
+	if len(budgets) != 1 || budgets[0].Tenant != "acme" || budgets[0].Spent != 0.02 {
+		t.Errorf("Unexpected budgets %+v", budgets)
+	}
+}
+
+func TestToolCallRefusedOverBudget(t *testing.T) {
+	broker := NewBroker()
+	broker.mcpRegistry, broker.federation = costFederation()
+	broker.federation.Budgets().Configure(map[string]float64{"acme": 0.01}, time.Hour)
+
+	envelope, err := protocol.NewToolCall("acme.client").Tool("vision.caption").Build()
+	if err != nil {
+		t.Fatalf("Failed to build call: %v", err)
+	}
+	data, _ := json.Marshal(envelope)
+	resp := httptest.NewRecorder()
+	broker.ServeHTTP(resp, httptest.NewRequest(http.MethodPost, "/", bytes.NewReader(data)))
+
+	_, err = protocol.ParseResponse(resp.Body.Bytes())
+	var errBody *protocol.ErrorBody
+	if !errors.As(err, &errBody) || errBody.Code != protocol.CodeForbidden || errBody.Details["constraint"] != BudgetConstraint {
+		t.Fatalf("Expected a budget rejection, got %d %v", resp.Code, err)
+	}
+}
+
+func TestParseTenantBudgets(t *testing.T) {
+	budgets, err := parseTenantBudgets("acme=100, globex=2.5")
+	if err != nil || budgets["acme"] != 100 || budgets["globex"] != 2.5 {
+		t.Errorf("Unexpected budgets %v, %v", budgets, err)
+	}
+	for _, input := range []string{"acme", "=5", "acme=-1", "acme=lots"} {
+		if _, err := parseTenantBudgets(input); err == nil {
+			t.Errorf("parseTenantBudgets(%q) should fail", input)
+		}
+	}
+}
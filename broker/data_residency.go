@@ -0,0 +1,157 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/fep-fem/protocol"
+)
+
+// ResidencyConstraint names the constraint cited in data residency rejections
+const ResidencyConstraint = "dataResidency"
+
+// ResidencyViolation is returned when every agent that could take a call would
+// move its data out of the caller's data region
+type ResidencyViolation struct {
+	Tool       string
+	DataRegion string
+	Refused    map[string]string // Why each candidate agent cannot hold the data
+}
+
+func (e *ResidencyViolation) Error() string {
+	reasons := make([]string, 0, len(e.Refused))
+	for agentID, reason := range e.Refused {
+		reasons = append(reasons, agentID+": "+reason)
+	}
+	sort.Strings(reasons)
+	return fmt.Sprintf("no agent for %s keeps data in %s (%s)", e.Tool, e.DataRegion, strings.Join(reasons, "; "))
+}
+
+// ErrorBody converts the violation into the policy error sent to the caller
+func (e *ResidencyViolation) ErrorBody() *protocol.ErrorBody {
+	return &protocol.ErrorBody{
+		Code:    protocol.CodePolicyViolation,
+		Message: e.Error(),
+		Details: map[string]interface{}{
+			"constraint": ResidencyConstraint,
+			"tool":       e.Tool,
+			"dataRegion": e.DataRegion,
+			"refused":    e.Refused,
+		},
+	}
+}
+
+// regionWithin reports whether region lies inside boundary: "eu-de" is within
+// "eu", but "eu" is not within "eu-de"
+func regionWithin(region, boundary string) bool {
+	return region == boundary || strings.HasPrefix(region, boundary+"-")
+}
+
+// dataResidency returns the regions an agent keeps a tool's call data in and
+// where that comes from: the tool's declaration, the agent's, or failing both
+// the region GeoIP placed the agent in
+func (fm *FederationManager) dataResidency(agentID, toolName string) ([]string, string) {
+	if agent, exists := fm.mcpRegistry.GetAgent(agentID); exists {
+		for _, tool := range agent.Tools {
+			if tool.Name == toolName && len(tool.DataResidency) > 0 {
+				return tool.DataResidency, "tool"
+			}
+		}
+		if agent.BodyDefinition != nil && len(agent.BodyDefinition.DataResidency) > 0 {
+			return agent.BodyDefinition.DataResidency, "agent"
+		}
+	} else if tool := fm.peerTool(agentID, toolName); tool != nil && len(tool.DataResidency) > 0 {
+		return tool.DataResidency, "tool"
+	}
+
+	fm.metricsMutex.RLock()
+	defer fm.metricsMutex.RUnlock()
+	if metrics, exists := fm.agentMetrics[agentID]; exists && metrics.GeographicRegion != "" {
+		return []string{metrics.GeographicRegion}, "geoip"
+	}
+	return nil, ""
+}
+
+// peerTool returns a tool as a peer advertised it for one of its agents, or nil
+func (fm *FederationManager) peerTool(agentID, toolName string) *protocol.MCPTool {
+	fm.topologyMutex.RLock()
+	defer fm.topologyMutex.RUnlock()
+	for _, tools := range fm.peerCatalogs {
+		for _, tool := range tools {
+			if tool.AgentID != agentID {
+				continue
+			}
+			for _, mcpTool := range tool.MCPTools {
+				if mcpTool.Name == toolName {
+					found := mcpTool
+					return &found
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// filterResidency keeps the agents whose every residency region lies within
+// dataRegion. Agents that declare nothing and have no known location are
+// refused, since the broker cannot show the data stays put.
+func (fm *FederationManager) filterResidency(agents []string, toolName, dataRegion string) ([]string, *ResidencyViolation) {
+	if dataRegion == "" || len(agents) == 0 {
+		return agents, nil
+	}
+	allowed := make([]string, 0, len(agents))
+	refused := make(map[string]string)
+	for _, agentID := range agents {
+		regions, source := fm.dataResidency(agentID, toolName)
+		if len(regions) == 0 {
+			refused[agentID] = "declares no data residency"
+			continue
+		}
+		outside := ""
+		for _, region := range regions {
+			if !regionWithin(region, dataRegion) {
+				outside = region
+				break
+			}
+		}
+		if outside != "" {
+			refused[agentID] = fmt.Sprintf("keeps data in %s (%s)", outside, source)
+			continue
+		}
+		allowed = append(allowed, agentID)
+	}
+	if len(allowed) == 0 {
+		return nil, &ResidencyViolation{Tool: toolName, DataRegion: dataRegion, Refused: refused}
+	}
+	return allowed, nil
+}
+
+// toolProviders lists local and routable remote agents offering a tool
+func (fm *FederationManager) toolProviders(toolName string) []string {
+	agents := make([]string, 0)
+	for _, tool := range fm.mcpRegistry.ListTools() {
+		if tool.Tool.Name == toolName {
+			agents = append(agents, tool.AgentID)
+		}
+	}
+	return append(agents, fm.routableRemoteAgents(toolName)...)
+}
+
+// CheckResidency fails with a *ResidencyViolation if a tool is offered but no
+// provider keeps data inside dataRegion
+func (fm *FederationManager) CheckResidency(toolName, dataRegion string) error {
+	if _, violation := fm.filterResidency(fm.toolProviders(toolName), toolName, dataRegion); violation != nil {
+		return violation
+	}
+	return nil
+}
+
+// rejectResidency answers a call whose data would leave its region
+func (b *Broker) rejectResidency(w http.ResponseWriter, env *protocol.GenericEnvelope, violation *ResidencyViolation) {
+	errBody := violation.ErrorBody()
+	rejection := b.newError(env, errBody.Code, errBody.Message)
+	rejection.Body.Details = errBody.Details
+	writeError(w, rejection)
+}
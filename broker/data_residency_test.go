@@ -0,0 +1,112 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/fep-fem/protocol"
+)
+
+func TestRegionWithin(t *testing.T) {
+	for _, tt := range []struct {
+		region, boundary string
+		within           bool
+	}{
+		{"eu", "eu", true},
+		{"eu-de", "eu", true},
+		{"eu", "eu-de", false},
+		{"eurasia", "eu", false},
+		{"us-east", "eu", false},
+	} {
+		if got := regionWithin(tt.region, tt.boundary); got != tt.within {
+			t.Errorf("regionWithin(%q, %q) = %v, want %v", tt.region, tt.boundary, got, tt.within)
+		}
+	}
+}
+
+func residencyFederation() (*MCPRegistry, *FederationManager) {
+	registry := NewMCPRegistry()
+	fm := NewFederationManager(registry, nil)
+	agents := map[string]*MCPAgent{
+		"eu-agent": {BodyDefinition: &protocol.BodyDefinition{DataResidency: []string{"eu-de"}},
+			Tools: []protocol.MCPTool{{Name: "crm.lookup"}}},
+		// The tool's declaration overrides the agent's
+		"us-agent": {BodyDefinition: &protocol.BodyDefinition{DataResidency: []string{"eu"}},
+			Tools: []protocol.MCPTool{{Name: "crm.lookup", DataResidency: []string{"eu-fr", "us"}}}},
+		"geo-agent":     {Tools: []protocol.MCPTool{{Name: "crm.lookup"}}},
+		"unknown-agent": {Tools: []protocol.MCPTool{{Name: "crm.lookup"}}},
+	}
+	for agentID, agent := range agents {
+		agent.ID = agentID
+		agent.LastHeartbeat = time.Now()
+		registry.RegisterAgent(agentID, agent)
+		fm.agentMetrics[agentID] = &AgentMetrics{AgentID: agentID, HealthScore: 0.9}
+	}
+	fm.agentMetrics["geo-agent"].GeographicRegion = "ap-jp"
+	return registry, fm
+}
+
+func TestRoutingHonorsDataResidency(t *testing.T) {
+	_, fm := residencyFederation()
+
+	decision, err := fm.RouteToolInvocation("crm.lookup", "", &RequestContext{DataRegion: "eu"})
+	if err != nil || decision.SelectedAgent != "eu-agent" || len(decision.AlternativeAgents) != 1 {
+		t.Fatalf("Only eu-agent keeps data in eu, got %+v %v", decision, err)
+	}
+	decision, err = fm.RouteToolInvocation("crm.lookup", "", &RequestContext{DataRegion: "ap"})
+	if err != nil || decision.SelectedAgent != "geo-agent" {
+		t.Fatalf("geo-agent's GeoIP region should count as its residency, got %+v %v", decision, err)
+	}
+
+	_, err = fm.RouteToolInvocation("crm.lookup", "", &RequestContext{DataRegion: "eu-fr"})
+	var violation *ResidencyViolation
+	if !errors.As(err, &violation) {
+		t.Fatalf("Expected a residency violation, got %v", err)
+	}
+	if violation.Refused["us-agent"] != "keeps data in us (tool)" ||
+		violation.Refused["unknown-agent"] != "declares no data residency" || len(violation.Refused) != 4 {
+		t.Errorf("Violation should cite each refused agent: %v", violation.Refused)
+	}
+
+	// Operator routes fall back to agents that keep the data in region
+	fm.SetRoute(ToolRoute{ToolPattern: "crm.*", PrimaryAgents: []string{"us-agent"}, FallbackAgents: []string{"eu-agent"}, HealthThreshold: 0.5})
+	decision, err = fm.RouteToolInvocation("crm.lookup", "", &RequestContext{DataRegion: "eu"})
+	if err != nil || decision.SelectedAgent != "eu-agent" {
+		t.Errorf("Fallback agent in region should be selected, got %+v %v", decision, err)
+	}
+}
+
+func TestToolCallRefusedOutsideDataRegion(t *testing.T) {
+	broker := NewBroker()
+	broker.mcpRegistry, broker.federation = residencyFederation()
+
+	call := func(region string) *httptest.ResponseRecorder {
+		envelope, err := protocol.NewToolCall("client").Tool("crm.lookup").DataRegion(region).Build()
+		if err != nil {
+			t.Fatalf("Failed to build call: %v", err)
+		}
+		data, _ := json.Marshal(envelope)
+		recorder := httptest.NewRecorder()
+		broker.ServeHTTP(recorder, httptest.NewRequest(http.MethodPost, "/", bytes.NewReader(data)))
+		return recorder
+	}
+
+	if resp := call("eu"); resp.Code != http.StatusOK {
+		t.Errorf("Call with a provider in region should be accepted, got %d %s", resp.Code, resp.Body.String())
+	}
+
+	resp := call("sa")
+	_, err := protocol.ParseResponse(resp.Body.Bytes())
+	var errBody *protocol.ErrorBody
+	if resp.Code != http.StatusForbidden || !errors.As(err, &errBody) || errBody.Code != protocol.CodePolicyViolation {
+		t.Fatalf("Expected a policy violation, got %d %v", resp.Code, err)
+	}
+	if errBody.Details["constraint"] != ResidencyConstraint || errBody.Details["dataRegion"] != "sa" {
+		t.Errorf("Rejection should cite the constraint: %v", errBody.Details)
+	}
+}
@@ -0,0 +1,510 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/fep-fem/protocol"
+)
+
+// Agent IDs of the identities dev mode creates
+const (
+	devMathAgentID = "math-agent"
+	devCoderID     = "fem-coder"
+	devUserID      = "dev-user"
+)
+
+// devLogBodyLimit bounds how much of each envelope dev mode logs
+const devLogBodyLimit = 512
+
+// devMCPResponseLimit bounds the MCP responses dev mode reads from agents
+const devMCPResponseLimit = 1 << 20
+
+// devOptions are the flags of fem-broker dev up
+type devOptions struct {
+	listen    string
+	dir       string
+	coderBin  string
+	coderPort int
+}
+
+// runDev runs the dev subcommands and returns the exit code
+func runDev(args []string) int {
+	if len(args) == 0 || args[0] != "up" {
+		fmt.Fprintln(os.Stderr, "Usage: fem-broker dev up [flags]")
+		fmt.Fprintln(os.Stderr, "\nStarts a broker, a fem-coder and a sample math agent in one process, for trying FEM locally.")
+		return 2
+	}
+
+	var opts devOptions
+	flags := flag.NewFlagSet("dev up", flag.ContinueOnError)
+	flags.StringVar(&opts.listen, "listen", "127.0.0.1:4433", "Address the broker listens on")
+	flags.StringVar(&opts.dir, "dir", defaultDevDir(), "Directory holding the dev keys and pins, kept between runs")
+	flags.StringVar(&opts.coderBin, "coder-bin", "", "fem-coder binary to start (next to fem-broker or in PATH if empty)")
+	flags.IntVar(&opts.coderPort, "coder-port", 8081, "Port of fem-coder's MCP server")
+	if err := flags.Parse(args[1:]); err != nil {
+		return 2
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	if err := devUp(ctx, opts); err != nil {
+		log.Printf("dev up: %v", err)
+		return 1
+	}
+	return 0
+}
+
+// defaultDevDir keeps dev mode's state apart from real identities in ~/.fem/keys
+func defaultDevDir() string {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ".fem-dev"
+	}
+	return filepath.Join(home, ".fem", "dev")
+}
+
+// devUp runs the broker and the sample agents until ctx is done. Keys are
+// created in opts.dir on first use and the broker's key is pinned there, so
+// the agents and femctl trust the broker without a first-use prompt.
+func devUp(ctx context.Context, opts devOptions) error {
+	log.SetFlags(log.LstdFlags | log.Lmicroseconds)
+	keyDir := filepath.Join(opts.dir, "keys")
+	if err := os.MkdirAll(keyDir, 0o700); err != nil {
+		return err
+	}
+	brokerKey, _, err := protocol.LoadOrCreatePrivateKey(filepath.Join(opts.dir, "broker.key"), nil)
+	if err != nil {
+		return fmt.Errorf("failed to load broker key: %w", err)
+	}
+
+	listener, err := net.Listen("tcp", opts.listen)
+	if err != nil {
+		return err
+	}
+	brokerURL := "https://" + listener.Addr().String()
+	pinFile := filepath.Join(opts.dir, "known_brokers.json")
+	pins := protocol.NewBrokerPins(protocol.NewFilePinStore(pinFile), protocol.PinModeEnforce)
+	if err := pins.Repin(brokerURL, brokerKey.Public().(ed25519.PublicKey)); err != nil {
+		listener.Close()
+		return fmt.Errorf("failed to pin broker key: %w", err)
+	}
+
+	broker := newDevBroker(brokerKey)
+	cert, err := generateSelfSignedCert()
+	if err != nil {
+		listener.Close()
+		return fmt.Errorf("failed to generate certificate: %w", err)
+	}
+	server := &http.Server{
+		Handler:   broker,
+		TLSConfig: &tls.Config{Certificates: []tls.Certificate{cert}, MinVersion: tls.VersionTLS13},
+	}
+	go func() {
+		if err := server.ServeTLS(listener, "", ""); err != http.ErrServerClosed {
+			log.Printf("[dev] Broker stopped: %v", err)
+		}
+	}()
+	defer server.Close()
+	log.Printf("[dev] Broker listening on %s with key %s", brokerURL, protocol.EncodePublicKey(brokerKey.Public().(ed25519.PublicKey)))
+
+	math, err := startDevMathAgent(brokerURL, pins, filepath.Join(keyDir, devMathAgentID+".key"))
+	if err != nil {
+		return fmt.Errorf("failed to start %s: %w", devMathAgentID, err)
+	}
+	defer math.Close()
+
+	userKey, _, err := protocol.LoadOrCreatePrivateKey(filepath.Join(keyDir, devUserID+".key"), nil)
+	if err != nil {
+		return fmt.Errorf("failed to load %s key: %w", devUserID, err)
+	}
+	if err := registerDevAgent(brokerURL, pins, devUserID, userKey, protocol.RegisterAgentBody{Capabilities: []string{"tool.call"}}); err != nil {
+		return fmt.Errorf("failed to register %s: %w", devUserID, err)
+	}
+
+	coder, err := startDevCoder(ctx, opts, brokerURL, pinFile, keyDir)
+	if err != nil {
+		return err
+	}
+
+	printDevBanner(os.Stdout, brokerURL, opts.dir, pinFile, keyDir, coder != nil)
+	<-ctx.Done()
+	log.Printf("[dev] Shutting down")
+	if coder != nil {
+		coder.Wait()
+	}
+	shutdown, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	return server.Shutdown(shutdown)
+}
+
+// newDevBroker returns a broker that signs with key, answers discovery from
+// anyone and logs every envelope. Tool calls are forwarded to the provider's
+// MCP endpoint, so calls made with femctl get results without a router.
+func newDevBroker(key ed25519.PrivateKey) *Broker {
+	broker := NewBroker()
+	broker.SetIdentityKey(key)
+	policy := DefaultDiscoveryPolicy()
+	policy.AllowAnonymous = true
+	policy.WildcardLimit = 0
+	broker.SetDiscoveryPolicy(policy)
+	broker.Use("dev-log", devEnvelopeLogger())
+	broker.Use("dev-dispatch", newDevDispatcher(broker))
+	return broker
+}
+
+// devEnvelopeLogger logs each envelope and the broker's answer
+func devEnvelopeLogger() Middleware {
+	return &MiddlewareFuncs{
+		Before: func(ctx *EnvelopeContext) error {
+			log.Printf("[dev] --> %s from %s: %s", ctx.Envelope.Type, ctx.Envelope.Agent, truncateDevLog(ctx.Envelope.Body))
+			return nil
+		},
+		After: func(ctx *EnvelopeContext, result *EnvelopeResult) {
+			log.Printf("[dev] <-- %d for %s from %s: %s", result.Status, ctx.Envelope.Type, ctx.Envelope.Agent, truncateDevLog(result.Body))
+		},
+	}
+}
+
+func truncateDevLog(data []byte) string {
+	text := strings.TrimSpace(string(data))
+	if len(text) > devLogBodyLimit {
+		return text[:devLogBodyLimit] + "..."
+	}
+	return text
+}
+
+// newDevDispatcher forwards accepted tool calls to the MCP endpoint of the
+// agent providing the tool and holds its answer for the caller as a
+// toolResult. Calls awaiting approval are forwarded once approved. The
+// results are built by the broker and unsigned, so this is only fit for local
+// development.
+func newDevDispatcher(broker *Broker) Middleware {
+	client := &http.Client{Timeout: 30 * time.Second}
+	return &MiddlewareFuncs{
+		After: func(ctx *EnvelopeContext, result *EnvelopeResult) {
+			if result.Status != http.StatusOK || ctx.Envelope.Encrypted() {
+				return
+			}
+			ack, err := protocol.ParseResponse(result.Body)
+			if err != nil {
+				return
+			}
+			switch {
+			case ctx.Envelope.Type == protocol.EnvelopeToolCall && ack.Status != "pending_approval":
+				typed, err := protocol.ParseTyped[protocol.ToolCallBody](ctx.Envelope)
+				if err != nil {
+					return
+				}
+				go broker.dispatchDevCall(client, typed.Body)
+			case ctx.Envelope.Type == protocol.EnvelopeApproveCall && ack.Status == "approved":
+				var approved protocol.PendingApprovalBody
+				if data, err := json.Marshal(ack.Result); err != nil || json.Unmarshal(data, &approved) != nil {
+					return
+				}
+				go broker.dispatchDevCall(client, protocol.ToolCallBody{RequestID: approved.RequestID, Tool: approved.Tool, Parameters: approved.Parameters})
+			}
+		},
+	}
+}
+
+// dispatchDevCall calls the tool and stores the result
+func (b *Broker) dispatchDevCall(client *http.Client, call protocol.ToolCallBody) {
+	provider, endpoint, tool, found := b.findDevProvider(call.Tool)
+	builder := protocol.NewToolResult(provider, call.RequestID)
+	if !found {
+		builder.Error(fmt.Sprintf("no registered agent provides %s", call.Tool))
+	} else if value, err := callMCPTool(client, endpoint, tool, call.Parameters); err != nil {
+		builder.Error(err.Error())
+	} else {
+		builder.Result(value)
+	}
+
+	envelope, err := builder.Build()
+	if err != nil {
+		log.Printf("[dev] Failed to build result for %s: %v", call.RequestID, err)
+		return
+	}
+	raw, err := json.Marshal(envelope)
+	if err != nil {
+		log.Printf("[dev] Failed to store result for %s: %v", call.RequestID, err)
+		return
+	}
+	if b.results.StoreResult(call.RequestID, raw) {
+		log.Printf("[dev] Result of %s from %s held for the caller (success: %t)", call.Tool, provider, envelope.Body.Success)
+	}
+}
+
+// findDevProvider resolves an "agent/tool" or bare tool name to the agent
+// providing it and its MCP endpoint
+func (b *Broker) findDevProvider(name string) (agentID, endpoint, tool string, found bool) {
+	if agentID, tool, qualified := strings.Cut(name, "/"); qualified {
+		agent, exists := b.mcpRegistry.GetAgent(agentID)
+		if !exists || agent.MCPEndpoint == "" {
+			return "broker", "", tool, false
+		}
+		return agentID, agent.MCPEndpoint, tool, true
+	}
+	for _, registered := range b.mcpRegistry.ListTools() {
+		if registered.Tool.Name == name && registered.MCPEndpoint != "" {
+			return registered.AgentID, registered.MCPEndpoint, name, true
+		}
+	}
+	return "broker", "", name, false
+}
+
+// callMCPTool sends a JSON-RPC tools/call to an agent's MCP endpoint
+func callMCPTool(client *http.Client, endpoint, tool string, arguments map[string]interface{}) (interface{}, error) {
+	request, err := json.Marshal(map[string]interface{}{
+		"jsonrpc": "2.0",
+		"id":      1,
+		"method":  "tools/call",
+		"params": map[string]interface{}{
+			"name":      tool,
+			"arguments": arguments,
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+	resp, err := client.Post(endpoint, "application/json", bytes.NewReader(request))
+	if err != nil {
+		return nil, fmt.Errorf("failed to reach %s: %w", endpoint, err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, devMCPResponseLimit))
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s returned status %d: %s", endpoint, resp.StatusCode, strings.TrimSpace(string(body)))
+	}
+
+	var response struct {
+		Result interface{} `json:"result"`
+		Error  *struct {
+			Message string `json:"message"`
+		} `json:"error"`
+	}
+	if err := json.Unmarshal(body, &response); err != nil {
+		return nil, fmt.Errorf("invalid MCP response: %w", err)
+	}
+	if response.Error != nil {
+		return nil, errors.New(response.Error.Message)
+	}
+	return response.Result, nil
+}
+
+// registerDevAgent registers agentID with the broker, signed by key
+func registerDevAgent(brokerURL string, pins *protocol.BrokerPins, agentID string, key ed25519.PrivateKey, body protocol.RegisterAgentBody) error {
+	body.PubKey = protocol.EncodePublicKey(key.Public().(ed25519.PublicKey))
+	envelope := protocol.NewTypedEnvelope(agentID, body)
+	if err := envelope.Sign(key); err != nil {
+		return err
+	}
+	client := NewMCPClient(MCPClientConfig{
+		AgentID:     agentID,
+		BrokerURL:   brokerURL,
+		PrivateKey:  key,
+		TLSInsecure: true, // The dev broker's certificate is self-signed; its key is pinned
+		Pins:        pins,
+	})
+	_, err := client.sendRequest(envelope)
+	return err
+}
+
+// devMathTools are the tools of the sample math agent
+var devMathTools = map[string]func(a, b float64) (float64, error){
+	"math.add":      func(a, b float64) (float64, error) { return a + b, nil },
+	"math.subtract": func(a, b float64) (float64, error) { return a - b, nil },
+	"math.multiply": func(a, b float64) (float64, error) { return a * b, nil },
+	"math.divide": func(a, b float64) (float64, error) {
+		if b == 0 {
+			return 0, errors.New("division by zero")
+		}
+		return a / b, nil
+	},
+}
+
+// startDevMathAgent serves the math tools over MCP on a free local port and
+// registers them with the broker. Closing the returned server stops it.
+func startDevMathAgent(brokerURL string, pins *protocol.BrokerPins, keyFile string) (*http.Server, error) {
+	key, _, err := protocol.LoadOrCreatePrivateKey(keyFile, nil)
+	if err != nil {
+		return nil, err
+	}
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/mcp", handleDevMathCall)
+	server := &http.Server{Handler: mux}
+	go server.Serve(listener)
+
+	var tools []protocol.MCPTool
+	var capabilities []string
+	for _, name := range []string{"math.add", "math.subtract", "math.multiply", "math.divide"} {
+		tools = append(tools, protocol.MCPTool{
+			Name:        name,
+			Description: fmt.Sprintf("Applies %s to the numbers a and b", strings.TrimPrefix(name, "math.")),
+			InputSchema: map[string]interface{}{
+				"type": "object",
+				"properties": map[string]interface{}{
+					"a": map[string]interface{}{"type": "number"},
+					"b": map[string]interface{}{"type": "number"},
+				},
+				"required": []string{"a", "b"},
+			},
+		})
+		capabilities = append(capabilities, name)
+	}
+	endpoint := fmt.Sprintf("http://%s/mcp", listener.Addr())
+	err = registerDevAgent(brokerURL, pins, devMathAgentID, key, protocol.RegisterAgentBody{
+		Capabilities: capabilities,
+		MCPEndpoint:  endpoint,
+		BodyDefinition: &protocol.BodyDefinition{
+			Name:         "dev-math-body",
+			Environment:  "local-dev",
+			Capabilities: capabilities,
+			MCPTools:     tools,
+		},
+		EnvironmentType: "local-dev",
+	})
+	if err != nil {
+		server.Close()
+		return nil, err
+	}
+	log.Printf("[dev] %s serving MCP on %s", devMathAgentID, endpoint)
+	return server, nil
+}
+
+// handleDevMathCall answers a JSON-RPC tools/call for one of devMathTools
+func handleDevMathCall(w http.ResponseWriter, r *http.Request) {
+	var request struct {
+		ID     interface{} `json:"id"`
+		Method string      `json:"method"`
+		Params struct {
+			Name      string                 `json:"name"`
+			Arguments map[string]interface{} `json:"arguments"`
+		} `json:"params"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil || request.Method != "tools/call" {
+		http.Error(w, "Expected a JSON-RPC tools/call", http.StatusBadRequest)
+		return
+	}
+
+	response := map[string]interface{}{"jsonrpc": "2.0", "id": request.ID}
+	result, err := applyDevMathTool(request.Params.Name, request.Params.Arguments)
+	if err != nil {
+		response["error"] = map[string]interface{}{"code": -32602, "message": err.Error()}
+	} else {
+		response["result"] = result
+	}
+	writeJSON(w, http.StatusOK, response)
+}
+
+func applyDevMathTool(name string, arguments map[string]interface{}) (float64, error) {
+	apply, exists := devMathTools[name]
+	if !exists {
+		return 0, fmt.Errorf("unknown tool %s", name)
+	}
+	a, aOK := arguments["a"].(float64)
+	b, bOK := arguments["b"].(float64)
+	if !aOK || !bOK {
+		return 0, errors.New("parameters a and b must be numbers")
+	}
+	return apply(a, b)
+}
+
+// startDevCoder starts fem-coder as a child process, logging its output with
+// a prefix. It returns nil without an error when no fem-coder binary is found,
+// so dev mode still works from a checkout where only the broker is built.
+func startDevCoder(ctx context.Context, opts devOptions, brokerURL, pinFile, keyDir string) (*exec.Cmd, error) {
+	bin, err := findDevCoder(opts.coderBin)
+	if err != nil {
+		if opts.coderBin != "" {
+			return nil, err
+		}
+		log.Printf("[dev] fem-coder not found, starting without it (build it with make coder or pass --coder-bin)")
+		return nil, nil
+	}
+
+	cmd := exec.CommandContext(ctx, bin,
+		"--broker", brokerURL,
+		"--agent", devCoderID,
+		"--mcp-port", fmt.Sprint(opts.coderPort),
+		"--pin-file", pinFile,
+		"--key-file", filepath.Join(keyDir, devCoderID+".key"),
+	)
+	cmd.Cancel = func() error { return cmd.Process.Signal(os.Interrupt) }
+	cmd.WaitDelay = 5 * time.Second
+	cmd.Env = append(os.Environ(), "FEM_KEY_PASSPHRASE=", "FEM_KEY_PASSPHRASE_FILE=")
+	output, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	cmd.Stderr = cmd.Stdout
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("failed to start %s: %w", bin, err)
+	}
+	go func() {
+		scanner := bufio.NewScanner(output)
+		for scanner.Scan() {
+			log.Printf("[%s] %s", devCoderID, scanner.Text())
+		}
+	}()
+	log.Printf("[dev] Started %s (pid %d)", bin, cmd.Process.Pid)
+	return cmd, nil
+}
+
+// findDevCoder returns the fem-coder binary to run: bin if set, otherwise
+// fem-coder next to this executable or in PATH
+func findDevCoder(bin string) (string, error) {
+	if bin != "" {
+		return exec.LookPath(bin)
+	}
+	if self, err := os.Executable(); err == nil {
+		sibling := filepath.Join(filepath.Dir(self), "fem-coder")
+		if info, err := os.Stat(sibling); err == nil && !info.IsDir() {
+			return sibling, nil
+		}
+	}
+	return exec.LookPath("fem-coder")
+}
+
+// printDevBanner tells the user how to try the running network with femctl
+func printDevBanner(out io.Writer, brokerURL, dir, pinFile, keyDir string, coder bool) {
+	connection := fmt.Sprintf("--broker %s --pin-file %s --keystore %s --identity %s", brokerURL, pinFile, keyDir, devUserID)
+	fmt.Fprintf(out, "\nFEM dev network is up (state in %s)\n", dir)
+	fmt.Fprintf(out, "  broker:     %s\n", brokerURL)
+	fmt.Fprintf(out, "  agents:     %s (math.add, math.subtract, math.multiply, math.divide)\n", devMathAgentID)
+	if coder {
+		fmt.Fprintf(out, "              %s (code.execute, shell.run)\n", devCoderID)
+	}
+	fmt.Fprintf(out, "  you:        %s\n\nTry:\n", devUserID)
+	fmt.Fprintf(out, "  femctl discover %s\n", connection)
+	fmt.Fprintf(out, "  femctl call %s math.add --param a=1 --param b=2 --wait %s\n", devMathAgentID, connection)
+	if coder {
+		fmt.Fprintf(out, "  femctl call %s shell.run --param command='echo hello' --wait %s\n", devCoderID, connection)
+	}
+	fmt.Fprintln(out, "\nPress Ctrl-C to stop.")
+}
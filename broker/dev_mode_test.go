@@ -0,0 +1,71 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/fep-fem/protocol"
+)
+
+func TestDevModeForwardsToolCalls(t *testing.T) {
+	_, brokerKey, _ := protocol.GenerateKeyPair()
+	server := httptest.NewTLSServer(newDevBroker(brokerKey))
+	defer server.Close()
+
+	pins := protocol.NewBrokerPins(protocol.NewMemoryPinStore(), protocol.PinModeEnforce)
+	pins.Repin(server.URL, brokerKey.Public().(ed25519.PublicKey))
+	math, err := startDevMathAgent(server.URL, pins, filepath.Join(t.TempDir(), "math-agent.key"))
+	if err != nil {
+		t.Fatalf("Failed to start math agent: %v", err)
+	}
+	defer math.Close()
+
+	_, userKey, _ := protocol.GenerateKeyPair()
+	if err := registerDevAgent(server.URL, pins, devUserID, userKey, protocol.RegisterAgentBody{}); err != nil {
+		t.Fatalf("Failed to register caller: %v", err)
+	}
+	client := NewMCPClient(MCPClientConfig{AgentID: devUserID, BrokerURL: server.URL, PrivateKey: userKey, TLSInsecure: true, Pins: pins})
+
+	results := map[string]protocol.ToolResultBody{}
+	for _, call := range []struct {
+		tool string
+		b    float64
+	}{{"math.add", 2}, {"math.divide", 0}} {
+		if _, err := client.CallTool(devMathAgentID, call.tool, map[string]interface{}{"a": 6.0, "b": call.b}); err != nil {
+			t.Fatalf("Call to %s failed: %v", call.tool, err)
+		}
+	}
+	if _, err := client.CallTool("missing-agent", "math.add", nil); err != nil {
+		t.Fatalf("Call to missing agent failed: %v", err)
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for len(results) < 3 && time.Now().Before(deadline) {
+		delivered, err := client.CollectResults(0)
+		if err != nil {
+			t.Fatalf("Failed to collect results: %v", err)
+		}
+		for _, result := range delivered {
+			envelope, _ := protocol.ParseEnvelope(result.Envelope)
+			typed, err := protocol.ParseTyped[protocol.ToolResultBody](envelope)
+			if err != nil {
+				t.Fatalf("Invalid result envelope: %v", err)
+			}
+			results[envelope.Agent+" "+typed.Body.Error] = typed.Body
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+
+	if sum, ok := results["math-agent "]; !ok || sum.Result != 8.0 {
+		t.Errorf("Expected math.add to return 8, got %+v", results)
+	}
+	if _, ok := results["math-agent division by zero"]; !ok {
+		t.Errorf("Expected math.divide to report the tool's error, got %+v", results)
+	}
+	if _, ok := results["broker no registered agent provides missing-agent/math.add"]; !ok {
+		t.Errorf("Expected a call to an unknown agent to fail, got %+v", results)
+	}
+}
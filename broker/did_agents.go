@@ -0,0 +1,50 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/fep-fem/protocol"
+)
+
+// SetRequireDIDAgents makes the broker refuse registrations from agents whose
+// IDs are not did:key identifiers, so every agent ID is bound to its key
+func (b *Broker) SetRequireDIDAgents(require bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.requireDIDAgents = require
+}
+
+// checkDIDAgent refuses envelopes whose did:key agent header names a key
+// other than the one that signed them. Envelopes from other agents pass.
+func (b *Broker) checkDIDAgent(w http.ResponseWriter, r *http.Request, env *protocol.GenericEnvelope) bool {
+	if !protocol.IsDIDKey(env.Agent) {
+		return true
+	}
+	if err := env.VerifyDIDAgent(); err != nil {
+		b.recordSignatureFailure(env.Agent, requestSource(r))
+		b.reject(w, env, protocol.CodeInvalidSignature, fmt.Sprintf("Envelope is not signed by the key its agent DID names: %v", err))
+		return false
+	}
+	return true
+}
+
+// checkRegistrationKey refuses registrations whose pubkey differs from the
+// key a did:key agent ID names, and agents without one when they are required
+func (b *Broker) checkRegistrationKey(env *protocol.GenericEnvelope, body protocol.RegisterAgentBody) error {
+	if !protocol.IsDIDKey(env.Agent) {
+		b.mu.RLock()
+		require := b.requireDIDAgents
+		b.mu.RUnlock()
+		if require {
+			return fmt.Errorf("agent IDs must be did:key identifiers on this broker")
+		}
+		return nil
+	}
+
+	publicKey, err := protocol.DecodePublicKey(body.PubKey)
+	if err != nil {
+		return err
+	}
+	return protocol.VerifyDIDKey(env.Agent, publicKey)
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/fep-fem/protocol"
+)
+
+func TestDIDAgents(t *testing.T) {
+	broker := NewBroker()
+	publicKey, privateKey, _ := protocol.GenerateKeyPair()
+	otherPub, otherPriv, _ := protocol.GenerateKeyPair()
+	did := protocol.DIDKey(publicKey)
+
+	post := func(envelope interface{}) *httptest.ResponseRecorder {
+		data, _ := json.Marshal(envelope)
+		recorder := httptest.NewRecorder()
+		broker.ServeHTTP(recorder, httptest.NewRequest(http.MethodPost, "/", bytes.NewReader(data)))
+		return recorder
+	}
+	register := func(agent string, pubkey ed25519.PublicKey, key ed25519.PrivateKey) int {
+		envelope := protocol.NewTypedEnvelope(agent, protocol.RegisterAgentBody{PubKey: protocol.EncodePublicKey(pubkey), Capabilities: []string{"math.add"}})
+		envelope.Sign(key)
+		return post(envelope).Code
+	}
+
+	if status := register(did, otherPub, otherPriv); status != http.StatusUnauthorized {
+		t.Errorf("Registration signed by another key should be refused, got %d", status)
+	}
+	if status := register(did, otherPub, privateKey); status != http.StatusForbidden {
+		t.Errorf("Registration of another key under the DID should be refused, got %d", status)
+	}
+	if status := register(did, publicKey, privateKey); status != http.StatusOK {
+		t.Fatalf("DID registration failed: %d", status)
+	}
+
+	// Every envelope from the DID must be signed by its key
+	collect := protocol.NewTypedEnvelope(did, protocol.ResultAckBody{})
+	collect.Sign(otherPriv)
+	if resp := post(collect); resp.Code != http.StatusUnauthorized {
+		t.Errorf("Envelope signed by another key should be refused, got %d", resp.Code)
+	}
+	collect.Sign(privateKey)
+	if resp := post(collect); resp.Code != http.StatusOK {
+		t.Errorf("Envelope signed by the DID's key should be accepted, got %d %s", resp.Code, resp.Body)
+	}
+
+	_, newPriv, _ := protocol.GenerateKeyPair()
+	rotation, _ := protocol.NewKeyRotation(did, privateKey, newPriv, "")
+	if resp := post(rotation); resp.Code != http.StatusForbidden {
+		t.Errorf("A did:key agent should not rotate keys, got %d", resp.Code)
+	}
+
+	broker.SetRequireDIDAgents(true)
+	if status := register("calc-agent", otherPub, otherPriv); status != http.StatusForbidden {
+		t.Errorf("Agents without a DID should be refused when DIDs are required, got %d", status)
+	}
+	if status := register(did, publicKey, privateKey); status != http.StatusOK {
+		t.Errorf("DID registration should still succeed, got %d", status)
+	}
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sort"
+	"time"
+
+	"github.com/fep-fem/protocol"
+)
+
+// defaultDirectoryInterval republishes the descriptor several times within
+// protocol.DefaultDescriptorTTL
+const defaultDirectoryInterval = 3 * time.Minute
+
+// DirectoryConfig is how the broker lists itself with a directory server
+type DirectoryConfig struct {
+	Endpoint string        // URL peers reach the broker at
+	Interval time.Duration // Between republishing rounds, well within the directory's descriptor TTL
+	Peer     bool          // Register the brokers the directory lists as peers
+}
+
+// BrokerDescriptor returns the broker's signed registerBroker envelope as
+// published to directories: its endpoint, identity key, the tools it can
+// route to and a summary of its admission policy
+func (b *Broker) BrokerDescriptor(endpoint string) (*protocol.TypedEnvelope[protocol.RegisterBrokerBody], error) {
+	signer := b.IdentityKey()
+	publicKey, err := protocol.SignerPublicKey(signer)
+	if err != nil {
+		return nil, err
+	}
+	brokerID := b.federation.config.LocalBrokerID
+	descriptor := protocol.NewTypedEnvelope(brokerID, protocol.RegisterBrokerBody{
+		BrokerID:     brokerID,
+		Endpoint:     endpoint,
+		PubKey:       protocol.EncodePublicKey(publicKey),
+		Capabilities: b.advertisedCapabilities(),
+		Policy:       b.policySummary(),
+	})
+	if err := descriptor.Body.Validate(); err != nil {
+		return nil, err
+	}
+	if err := descriptor.Sign(signer); err != nil {
+		return nil, err
+	}
+	return descriptor, nil
+}
+
+// advertisedCapabilities returns the names of the tools registered locally
+func (b *Broker) advertisedCapabilities() []string {
+	seen := make(map[string]bool)
+	capabilities := []string{}
+	for _, registered := range b.mcpRegistry.ListTools() {
+		name := registered.Tool.Name
+		if seen[name] || protocol.ValidateCapabilityPattern(name) != nil {
+			continue
+		}
+		seen[name] = true
+		capabilities = append(capabilities, name)
+	}
+	sort.Strings(capabilities)
+	return capabilities
+}
+
+// policySummary describes what the broker asks of agents and peers
+func (b *Broker) policySummary() *protocol.BrokerPolicy {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	policy := &protocol.BrokerPolicy{
+		PeerTrustTier:      string(b.federation.config.DefaultPeerTrustTier),
+		AnonymousDiscovery: b.discoveryPolicy.AllowAnonymous,
+		CallCapabilities:   b.callPolicy != nil && b.callPolicy.Capabilities != nil,
+		MaxEnvelopeSize:    b.sizeLimits.Largest(),
+	}
+	if b.minProtocol != (protocol.Version{}) {
+		policy.MinProto = b.minProtocol.String()
+	}
+	return policy
+}
+
+// StartDirectorySync publishes the broker's descriptor to the directory every
+// interval, peering with the brokers listed there if configured to
+func (b *Broker) StartDirectorySync(client *protocol.DirectoryClient, config DirectoryConfig) {
+	go func() {
+		ticker := time.NewTicker(config.Interval)
+		defer ticker.Stop()
+
+		for {
+			if _, err := b.syncDirectory(context.Background(), client, config); err != nil {
+				log.Printf("Directory sync with %s failed: %v", client.URL, err)
+			}
+			<-ticker.C
+		}
+	}()
+}
+
+// syncDirectory publishes the broker's descriptor and, if configured to,
+// registers the brokers the directory lists as peers. It returns how many
+// new peers were registered; known peers are refreshed.
+func (b *Broker) syncDirectory(ctx context.Context, client *protocol.DirectoryClient, config DirectoryConfig) (int, error) {
+	descriptor, err := b.BrokerDescriptor(config.Endpoint)
+	if err != nil {
+		return 0, fmt.Errorf("failed to build descriptor: %w", err)
+	}
+	if err := client.Publish(ctx, descriptor); err != nil {
+		return 0, err
+	}
+	if !config.Peer {
+		return 0, nil
+	}
+
+	listed, err := client.Query(ctx, "")
+	if err != nil {
+		return 0, err
+	}
+	peered := 0
+	for _, env := range listed {
+		peer, err := b.peerFromDirectory(env)
+		if err != nil {
+			log.Printf("Not peering with %s from the directory: %v", env.Agent, err)
+			continue
+		}
+		if peer != nil {
+			peered++
+		}
+	}
+	return peered, nil
+}
+
+// peerFromDirectory registers the broker a listed descriptor describes as if
+// it had registered itself. New peers are placed in the default trust tier;
+// known peers keep theirs. It returns nil for the broker's own descriptor and
+// for peers already registered.
+func (b *Broker) peerFromDirectory(env *protocol.GenericEnvelope) (*FederatedBroker, error) {
+	body, err := protocol.VerifyBrokerDescriptor(env)
+	if err != nil {
+		return nil, err
+	}
+	if body.BrokerID == b.federation.config.LocalBrokerID {
+		return nil, nil
+	}
+	if _, err := b.negotiateVersion(env); err != nil {
+		return nil, err
+	}
+	// A peer refusing this broker's protocol version is of no use
+	if body.Policy != nil && body.Policy.MinProto != "" {
+		if newer, err := protocol.CompareVersions(body.Policy.MinProto, protocol.ProtocolVersion); err != nil || newer > 0 {
+			return nil, fmt.Errorf("peer requires protocol %s", body.Policy.MinProto)
+		}
+	}
+
+	tier, known := b.federation.peerTrustTier(body.BrokerID)
+	if !known {
+		tier = b.federation.config.DefaultPeerTrustTier
+	}
+	peer, err := b.federation.AddFederatedBroker(env, tier)
+	if err != nil {
+		return nil, err
+	}
+	if known {
+		return nil, nil
+	}
+	log.Printf("Peered with %s at %s from the directory (trust tier %s)", peer.ID, peer.Endpoint, peer.TrustTier)
+	b.trustPeerCapabilities(peer)
+	return peer, nil
+}
+
+// peerTrustTier returns the trust tier of a known peer broker
+func (fm *FederationManager) peerTrustTier(brokerID string) (PeerTrustTier, bool) {
+	fm.topologyMutex.RLock()
+	defer fm.topologyMutex.RUnlock()
+	peer, exists := fm.federatedBrokers[brokerID]
+	if !exists {
+		return "", false
+	}
+	return peer.TrustTier, true
+}
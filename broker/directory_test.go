@@ -0,0 +1,101 @@
+package main
+
+import (
+	"context"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/fep-fem/protocol"
+)
+
+// directoryBroker returns a broker with its own ID and a tool to advertise
+func directoryBroker(brokerID string) *Broker {
+	broker := NewBroker()
+	broker.federation.config.LocalBrokerID = brokerID
+	broker.mcpRegistry.RegisterAgent(brokerID+"-agent", &MCPAgent{
+		ID:    brokerID + "-agent",
+		Tools: []protocol.MCPTool{{Name: "math.add"}},
+	})
+	return broker
+}
+
+func TestBrokerDescriptor(t *testing.T) {
+	broker := directoryBroker("broker-a")
+	if err := broker.SetMinProtocolVersion("0.3"); err != nil {
+		t.Fatal(err)
+	}
+	descriptor, err := broker.BrokerDescriptor("https://a.example:4433")
+	if err != nil {
+		t.Fatalf("Failed to build descriptor: %v", err)
+	}
+	body := descriptor.Body
+	if body.BrokerID != "broker-a" || len(body.Capabilities) != 1 || body.Capabilities[0] != "math.add" {
+		t.Errorf("Unexpected descriptor %+v", body)
+	}
+	if body.Policy == nil || body.Policy.MinProto != "0.3.0" || body.Policy.PeerTrustTier != string(PeerTrustUntrusted) {
+		t.Errorf("Unexpected policy summary %+v", body.Policy)
+	}
+	publicKey, _ := protocol.SignerPublicKey(broker.IdentityKey())
+	if err := descriptor.Verify(publicKey); err != nil {
+		t.Errorf("Descriptor should be signed with the identity key: %v", err)
+	}
+}
+
+func TestDirectoryPeering(t *testing.T) {
+	server := httptest.NewServer(protocol.NewDirectory(time.Minute))
+	defer server.Close()
+	client := protocol.NewDirectoryClient(server.URL)
+	ctx := context.Background()
+
+	a, b := directoryBroker("broker-a"), directoryBroker("broker-b")
+	sync := func(broker *Broker, endpoint string) int {
+		t.Helper()
+		peered, err := broker.syncDirectory(ctx, client, DirectoryConfig{Endpoint: endpoint, Peer: true})
+		if err != nil {
+			t.Fatalf("Directory sync failed: %v", err)
+		}
+		return peered
+	}
+
+	if peered := sync(a, "https://a.example:4433"); peered != 0 {
+		t.Errorf("A broker should not peer with itself, got %d peers", peered)
+	}
+	if peered := sync(b, "https://b.example:4433"); peered != 1 {
+		t.Errorf("Expected broker-b to peer with broker-a, got %d", peered)
+	}
+	if peered := sync(a, "https://a.example:4433"); peered != 1 {
+		t.Errorf("Expected broker-a to peer with broker-b, got %d", peered)
+	}
+
+	peers := b.federation.ListFederatedBrokers()
+	if len(peers) != 1 || peers[0].ID != "broker-a" || !peers[0].IdentityVerified || peers[0].Endpoint != "https://a.example:4433" {
+		t.Fatalf("Unexpected peers %+v", peers)
+	}
+	if peers[0].TrustTier != PeerTrustUntrusted {
+		t.Errorf("Expected the default trust tier, got %s", peers[0].TrustTier)
+	}
+
+	// Known peers keep the tier an admin gave them
+	if err := b.federation.SetPeerTrustTier("broker-a", PeerTrustFull); err != nil {
+		t.Fatal(err)
+	}
+	if peered := sync(b, "https://b.example:4433"); peered != 0 {
+		t.Errorf("Known peers should not count as new, got %d", peered)
+	}
+	if tier, _ := b.federation.peerTrustTier("broker-a"); tier != PeerTrustFull {
+		t.Errorf("Expected the admin's trust tier kept, got %s", tier)
+	}
+
+	// Brokers that would refuse this one's protocol version are skipped
+	c := directoryBroker("broker-c")
+	if err := c.SetMinProtocolVersion("99.0"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.syncDirectory(ctx, client, DirectoryConfig{Endpoint: "https://c.example:4433"}); err != nil {
+		t.Fatalf("Publishing failed: %v", err)
+	}
+	if peered := sync(a, "https://a.example:4433"); peered != 0 {
+		t.Errorf("Expected broker-c to be skipped, got %d new peers", peered)
+	}
+}
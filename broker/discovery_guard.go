@@ -0,0 +1,253 @@
+package main
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fep-fem/protocol"
+)
+
+// discoveryPermissionPrefix marks capability permissions that scope discovery, e.g. "discover:math.*"
+const discoveryPermissionPrefix = "discover:"
+
+// DiscoveryPolicy controls who may enumerate the tool catalog and how much of it they see
+type DiscoveryPolicy struct {
+	AllowAnonymous bool                        // Accept discovery from unknown or unsigned callers
+	APITokens      map[string]string           // Bearer token -> caller ID, for callers that are not agents
+	Capabilities   *protocol.CapabilityManager // When set, callers must present a capability token scoping their results
+	WildcardLimit  int                         // Wildcard queries allowed per caller per window (0 = unlimited)
+	WildcardWindow time.Duration
+}
+
+// DefaultDiscoveryPolicy requires signed discovery and limits catalog enumeration
+func DefaultDiscoveryPolicy() *DiscoveryPolicy {
+	return &DiscoveryPolicy{
+		APITokens:      make(map[string]string),
+		WildcardLimit:  10,
+		WildcardWindow: time.Minute,
+	}
+}
+
+// SetDiscoveryPolicy replaces the broker's discovery policy
+func (b *Broker) SetDiscoveryPolicy(policy *DiscoveryPolicy) {
+	if policy.Capabilities != nil {
+		policy.Capabilities.SetRevocationCheck(b.federation.CapabilityRevocations().IsRevoked)
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.discoveryPolicy = policy
+	b.wildcardLimiter = newWildcardLimiter(policy.WildcardLimit, policy.WildcardWindow)
+}
+
+// discoveryGrant is the outcome of authorizing a discovery request
+type discoveryGrant struct {
+	Caller string
+	Scope  []string // Tool name patterns the caller may see; nil means unscoped
+}
+
+// authorizeDiscovery authenticates the caller, resolves its result scope and
+// applies the wildcard rate limit. On failure it writes the error envelope itself.
+func (b *Broker) authorizeDiscovery(w http.ResponseWriter, r *http.Request, env *protocol.GenericEnvelope, body *protocol.DiscoverToolsBody) (*discoveryGrant, bool) {
+	b.mu.RLock()
+	policy := b.discoveryPolicy
+	limiter := b.wildcardLimiter
+	b.mu.RUnlock()
+
+	caller, err := b.authenticateDiscovery(r, env, policy)
+	if err != nil {
+		b.reject(w, env, protocol.CodeUnauthorized, fmt.Sprintf("Discovery not authorized: %v", err))
+		return nil, false
+	}
+
+	grant := &discoveryGrant{Caller: caller}
+	if policy.Capabilities != nil {
+		scope, err := discoveryScope(policy.Capabilities, body.Capability, caller)
+		if err != nil {
+			b.reject(w, env, protocol.CodeCapabilityDenied, fmt.Sprintf("Discovery not permitted: %v", err))
+			return nil, false
+		}
+		grant.Scope = scope
+	}
+
+	if isWildcardQuery(body.Query) {
+		if allowed, retryAfter := limiter.Allow(caller); !allowed {
+			w.Header().Set("Retry-After", strconv.Itoa(int(retryAfter.Seconds())+1))
+			b.reject(w, env, protocol.CodeRateLimited, "Too many wildcard discovery requests")
+			return nil, false
+		}
+	}
+
+	return grant, true
+}
+
+// authenticateDiscovery identifies the caller by API token or by the signature of a registered agent
+func (b *Broker) authenticateDiscovery(r *http.Request, env *protocol.GenericEnvelope, policy *DiscoveryPolicy) (string, error) {
+	if header := r.Header.Get("Authorization"); strings.HasPrefix(header, "Bearer ") {
+		presented := []byte(strings.TrimPrefix(header, "Bearer "))
+		for token, caller := range policy.APITokens {
+			if subtle.ConstantTimeCompare(presented, []byte(token)) == 1 {
+				return caller, nil
+			}
+		}
+		return "", fmt.Errorf("unknown API token")
+	}
+
+	b.mu.RLock()
+	agent, known := b.agents[env.Agent]
+	b.mu.RUnlock()
+
+	if known && agent.PubKey != "" {
+		if err := agent.verifySignature(env); err != nil {
+			b.recordSignatureFailure(env.Agent, requestSource(r))
+			return "", fmt.Errorf("invalid signature for agent %s: %w", env.Agent, err)
+		}
+		return env.Agent, nil
+	}
+
+	if policy.AllowAnonymous {
+		host, _, err := net.SplitHostPort(r.RemoteAddr)
+		if err != nil {
+			host = r.RemoteAddr
+		}
+		return "anonymous@" + host, nil
+	}
+	return "", fmt.Errorf("agent %s is not registered", env.Agent)
+}
+
+// discoveryScope validates a capability token and returns the tool patterns it grants
+func discoveryScope(cm *protocol.CapabilityManager, token, caller string) ([]string, error) {
+	return capabilityScope(cm, token, caller, discoveryPermissionPrefix)
+}
+
+// capabilityScope validates a capability token issued to caller and returns
+// the patterns granted by its permissions with the given prefix
+func capabilityScope(cm *protocol.CapabilityManager, token, caller, prefix string) ([]string, error) {
+	if token == "" {
+		return nil, fmt.Errorf("capability token required")
+	}
+	capability, err := cm.ValidateCapability(token)
+	if err != nil {
+		return nil, fmt.Errorf("invalid capability token: %w", err)
+	}
+	if capability.Subject != caller {
+		return nil, fmt.Errorf("capability token was issued to %s", capability.Subject)
+	}
+
+	var scope []string
+	for _, permission := range capability.Permissions {
+		if permission == "*" {
+			scope = append(scope, "*")
+		} else if strings.HasPrefix(permission, prefix) {
+			scope = append(scope, strings.TrimPrefix(permission, prefix))
+		}
+	}
+	if len(scope) == 0 {
+		return nil, fmt.Errorf("capability token grants no %s permissions", strings.TrimSuffix(prefix, ":"))
+	}
+	return scope, nil
+}
+
+// trustPeerCapabilities accepts EdDSA capability tokens issued by a fully
+// trusted peer whose identity key was verified, so capabilities federate
+func (b *Broker) trustPeerCapabilities(peer *FederatedBroker) {
+	b.mu.RLock()
+	cm := b.discoveryPolicy.Capabilities
+	b.mu.RUnlock()
+	if cm == nil || cm.PublicKey() == nil || !peer.IdentityVerified || !peer.TrustTier.AllowsRouting() {
+		return
+	}
+	publicKey, err := protocol.DecodePublicKey(peer.PublicKey)
+	if err != nil {
+		return
+	}
+	cm.TrustIssuer(publicKey)
+}
+
+// scopeDiscoveredTools removes tools outside the caller's scope
+func (b *Broker) scopeDiscoveredTools(tools []protocol.DiscoveredTool, scope []string) []protocol.DiscoveredTool {
+	if scope == nil {
+		return tools
+	}
+
+	scoped := make([]protocol.DiscoveredTool, 0, len(tools))
+	for _, tool := range tools {
+		var visible []protocol.MCPTool
+		for _, mcpTool := range tool.MCPTools {
+			if b.federation.matchesAnyCapability(mcpTool.Name, scope) {
+				visible = append(visible, mcpTool)
+			}
+		}
+		if len(visible) == 0 {
+			continue
+		}
+		tool.MCPTools = visible
+		tool.Capabilities = b.mcpRegistry.extractCapabilities(visible)
+		scoped = append(scoped, tool)
+	}
+	return scoped
+}
+
+// isWildcardQuery reports whether a query enumerates the catalog rather than
+// looking up specific tools. Any pattern with a wildcard counts, since prefix
+// patterns such as "a*", "b*" list the catalog piece by piece.
+func isWildcardQuery(query protocol.ToolQuery) bool {
+	if len(query.Capabilities) == 0 {
+		return true
+	}
+	for _, pattern := range query.Capabilities {
+		if strings.Contains(pattern, "*") {
+			return true
+		}
+	}
+	return false
+}
+
+// wildcardLimiter is a per-caller sliding window limiter for enumeration-style queries
+type wildcardLimiter struct {
+	limit  int
+	window time.Duration
+	hits   map[string][]time.Time
+	mu     sync.Mutex
+}
+
+func newWildcardLimiter(limit int, window time.Duration) *wildcardLimiter {
+	return &wildcardLimiter{
+		limit:  limit,
+		window: window,
+		hits:   make(map[string][]time.Time),
+	}
+}
+
+// Allow records a query for the caller and reports whether it is within the limit.
+// When refused, it also returns how long until the oldest query leaves the window.
+func (l *wildcardLimiter) Allow(caller string) (bool, time.Duration) {
+	if l.limit <= 0 {
+		return true, 0
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	cutoff := now.Add(-l.window)
+	recent := l.hits[caller][:0]
+	for _, hit := range l.hits[caller] {
+		if hit.After(cutoff) {
+			recent = append(recent, hit)
+		}
+	}
+
+	if len(recent) >= l.limit {
+		l.hits[caller] = recent
+		return false, recent[0].Sub(cutoff)
+	}
+
+	l.hits[caller] = append(recent, now)
+	return true, 0
+}
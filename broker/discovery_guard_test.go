@@ -0,0 +1,200 @@
+package main
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/tls"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/fep-fem/protocol"
+)
+
+// registerDiscoveryClient makes a caller known to the broker so its signed discovery is accepted
+func registerDiscoveryClient(broker *Broker, agentID string, pubKey ed25519.PublicKey) {
+	broker.mu.Lock()
+	defer broker.mu.Unlock()
+	broker.agents[agentID] = &Agent{
+		ID:           agentID,
+		PubKey:       protocol.EncodePublicKey(pubKey),
+		RegisteredAt: time.Now(),
+		TrustScore:   1.0,
+	}
+}
+
+func TestDiscoveryRequiresKnownSigner(t *testing.T) {
+	broker := NewBroker()
+	server := httptest.NewTLSServer(broker)
+	defer server.Close()
+
+	client := &http.Client{
+		Transport: &http.Transport{TLSClientConfig: &tls.Config{InsecureSkipVerify: true}},
+	}
+
+	pubKey, privKey, _ := protocol.GenerateKeyPair()
+	_, otherKey, _ := protocol.GenerateKeyPair()
+
+	discover := func(agentID string, key ed25519.PrivateKey, capabilities []string, token string) int {
+		envelope := &protocol.DiscoverToolsEnvelope{
+			BaseEnvelope: protocol.BaseEnvelope{
+				Type: protocol.EnvelopeDiscoverTools,
+				CommonHeaders: protocol.CommonHeaders{
+					Agent: agentID,
+					TS:    time.Now().UnixMilli(),
+					Nonce: "discover",
+				},
+			},
+			Body: protocol.DiscoverToolsBody{Query: protocol.ToolQuery{Capabilities: capabilities}},
+		}
+		envelope.Sign(key)
+		data, _ := json.Marshal(envelope)
+
+		req, _ := http.NewRequest(http.MethodPost, server.URL+"/", bytes.NewReader(data))
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		resp, err := client.Do(req)
+		if err != nil {
+			t.Fatalf("Failed to send discovery: %v", err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	if status := discover("stranger", privKey, []string{"math.add"}, ""); status != http.StatusUnauthorized {
+		t.Errorf("Expected 401 for unregistered caller, got %d", status)
+	}
+
+	registerDiscoveryClient(broker, "client-1", pubKey)
+	if status := discover("client-1", privKey, []string{"math.add"}, ""); status != http.StatusOK {
+		t.Errorf("Expected 200 for registered caller, got %d", status)
+	}
+	if status := discover("client-1", otherKey, []string{"math.add"}, ""); status != http.StatusUnauthorized {
+		t.Errorf("Expected 401 for caller signing with the wrong key, got %d", status)
+	}
+
+	policy := DefaultDiscoveryPolicy()
+	policy.APITokens["dashboard-token"] = "dashboard"
+	policy.WildcardLimit = 2
+	broker.SetDiscoveryPolicy(policy)
+
+	if status := discover("anyone", otherKey, []string{"math.add"}, "dashboard-token"); status != http.StatusOK {
+		t.Errorf("Expected 200 for API token caller, got %d", status)
+	}
+	if status := discover("anyone", otherKey, []string{"math.add"}, "wrong-token"); status != http.StatusUnauthorized {
+		t.Errorf("Expected 401 for unknown API token, got %d", status)
+	}
+
+	// Wildcard enumeration is rate limited per caller; specific lookups are not
+	// Prefix patterns enumerate the catalog just the same
+	for _, pattern := range []string{"*", "m*"} {
+		if status := discover("client-1", privKey, []string{pattern}, ""); status != http.StatusOK {
+			t.Fatalf("Expected wildcard query %q to be allowed, got %d", pattern, status)
+		}
+	}
+	if status := discover("client-1", privKey, []string{"s*"}, ""); status != http.StatusTooManyRequests {
+		t.Errorf("Expected 429 for a prefix pattern after exceeding wildcard limit, got %d", status)
+	}
+	if status := discover("client-1", privKey, nil, ""); status != http.StatusTooManyRequests {
+		t.Errorf("Expected 429 after exceeding wildcard limit, got %d", status)
+	}
+	if status := discover("client-1", privKey, []string{"math.add"}, ""); status != http.StatusOK {
+		t.Errorf("Expected specific query to be unaffected by wildcard limit, got %d", status)
+	}
+	if status := discover("anyone", otherKey, []string{"*"}, "dashboard-token"); status != http.StatusOK {
+		t.Errorf("Expected wildcard limit to be per caller, got %d", status)
+	}
+}
+
+func TestDiscoveryScopedByCapability(t *testing.T) {
+	broker := NewBroker()
+	for _, agent := range []*MCPAgent{
+		{ID: "math-agent", Tools: []protocol.MCPTool{{Name: "math.add"}, {Name: "math.multiply"}}},
+		{ID: "shell-agent", Tools: []protocol.MCPTool{{Name: "shell.exec"}}},
+	} {
+		agent.LastHeartbeat = time.Now()
+		broker.mcpRegistry.RegisterAgent(agent.ID, agent)
+	}
+
+	capabilities := protocol.NewCapabilityManager([]byte("capability-key"))
+	policy := DefaultDiscoveryPolicy()
+	policy.Capabilities = capabilities
+	broker.SetDiscoveryPolicy(policy)
+
+	pubKey, privKey, _ := protocol.GenerateKeyPair()
+	registerDiscoveryClient(broker, "client-1", pubKey)
+
+	discover := func(token string) (int, []protocol.DiscoveredTool) {
+		envelope := &protocol.DiscoverToolsEnvelope{
+			BaseEnvelope: protocol.BaseEnvelope{
+				Type: protocol.EnvelopeDiscoverTools,
+				CommonHeaders: protocol.CommonHeaders{
+					Agent: "client-1",
+					TS:    time.Now().UnixMilli(),
+					Nonce: "scoped",
+				},
+			},
+			Body: protocol.DiscoverToolsBody{
+				Query:      protocol.ToolQuery{Capabilities: []string{"math.*", "shell.*"}},
+				Capability: token,
+			},
+		}
+		envelope.Sign(privKey)
+		data, _ := json.Marshal(envelope)
+		generic, _ := protocol.ParseEnvelope(data)
+
+		recorder := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodPost, "/", bytes.NewReader(data))
+		broker.handleDiscoverTools(recorder, req, generic)
+
+		var response protocol.ToolsDiscoveredBody
+		if recorder.Code == http.StatusOK {
+			readAck(t, recorder.Body).ResultAs(&response)
+		}
+		return recorder.Code, response.Tools
+	}
+
+	if status, _ := discover(""); status != http.StatusForbidden {
+		t.Errorf("Expected 403 without capability token, got %d", status)
+	}
+
+	otherToken, _ := capabilities.CreateCapability("discovery", "broker", "client-2", []string{"discover:*"}, time.Hour)
+	if status, _ := discover(otherToken); status != http.StatusForbidden {
+		t.Errorf("Expected 403 for token issued to another caller, got %d", status)
+	}
+
+	token, _ := capabilities.CreateCapability("discovery", "broker", "client-1", []string{"discover:math.add"}, time.Hour)
+	status, tools := discover(token)
+	if status != http.StatusOK {
+		t.Fatalf("Expected 200 with capability token, got %d", status)
+	}
+	if len(tools) != 1 || tools[0].AgentID != "math-agent" || len(tools[0].MCPTools) != 1 || tools[0].MCPTools[0].Name != "math.add" {
+		t.Errorf("Expected results scoped to math.add, got %+v", tools)
+	}
+}
+
+func TestFullyTrustedPeerCapabilitiesAccepted(t *testing.T) {
+	broker := NewBroker()
+	policy := DefaultDiscoveryPolicy()
+	policy.Capabilities = protocol.NewEd25519CapabilityManager(broker.IdentityKey())
+	broker.SetDiscoveryPolicy(policy)
+
+	peerKey, peerPriv, _ := protocol.GenerateKeyPair()
+	peerCapabilities := protocol.NewEd25519CapabilityManager(peerPriv)
+	token, _ := peerCapabilities.CreateCapability("discovery", "peer-broker", "client-1", []string{"discover:*"}, time.Hour)
+
+	peer := &FederatedBroker{ID: "peer-broker", PublicKey: protocol.EncodePublicKey(peerKey), TrustTier: PeerTrustDiscovery, IdentityVerified: true}
+	broker.trustPeerCapabilities(peer)
+	if _, err := discoveryScope(policy.Capabilities, token, "client-1"); err == nil {
+		t.Fatal("Expected tokens from a discovery-tier peer to be rejected")
+	}
+
+	peer.TrustTier = PeerTrustFull
+	broker.trustPeerCapabilities(peer)
+	if _, err := discoveryScope(policy.Capabilities, token, "client-1"); err != nil {
+		t.Errorf("Expected tokens from a fully trusted peer to be accepted: %v", err)
+	}
+}
@@ -0,0 +1,226 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/fep-fem/protocol"
+)
+
+// Event streams
+const (
+	eventStreamKeepalive = 15 * time.Second // Comment sent on idle streams so proxies keep them open
+	eventStreamBuffer    = 256              // Events queued for a slow client before its stream is closed
+	subscriptionMaxSize  = 64 << 10         // Largest subscribe envelope accepted for a stream
+)
+
+// handleEventStream streams the events and results a signed subscription
+// matches to a lightweight client over Server-Sent Events. The subscribe
+// envelope is POSTed as the body, or passed base64url-encoded in the
+// subscription query parameter since EventSource can only send GET. Each
+// message is named after the envelope type it carries: emitEvent envelopes as
+// their publishers signed them, or as the broker signed them for events it
+// raised, and toolResult and toolResultChunk envelopes as the answering agent
+// signed them.
+func (b *Broker) handleEventStream(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	var data []byte
+	var err error
+	switch r.Method {
+	case http.MethodGet:
+		data, err = protocol.DecodeSubscription(r.URL.Query().Get(protocol.SubscriptionParam))
+	case http.MethodPost:
+		data, err = io.ReadAll(io.LimitReader(r.Body, subscriptionMaxSize))
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if err != nil {
+		b.reject(w, nil, protocol.CodeInvalidEnvelope, err.Error())
+		return
+	}
+	// Clients reconnect to a peer while the broker is in maintenance
+	if b.InMaintenance() {
+		b.rejectForMaintenance(w, nil)
+		return
+	}
+
+	env, body, ok := b.authenticateSubscription(w, r, data)
+	if !ok {
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "Streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+
+	// Events are queued by the publisher and written here, so a slow client
+	// cannot hold up publishing; one that falls too far behind is dropped and
+	// resumes from its Last-Event-ID
+	events := make(chan TopicEvent, eventStreamBuffer)
+	overflow := make(chan struct{})
+	if body.Pattern != "" {
+		lastEventID := r.Header.Get("Last-Event-ID")
+		if lastEventID == "" {
+			lastEventID = r.URL.Query().Get("lastEventId")
+		}
+		afterSeq, _ := strconv.ParseUint(lastEventID, 10, 64)
+		var closeOnce sync.Once
+		sub, err := b.events.Subscribe(SubscribeRequest{
+			Subscriber: env.Agent,
+			Pattern:    body.Pattern,
+			Capability: body.Capability,
+			AfterSeq:   afterSeq,
+		}, func(event TopicEvent) {
+			select {
+			case events <- event:
+			default:
+				closeOnce.Do(func() { close(overflow) })
+			}
+		})
+		if errors.Is(err, ErrTopicDenied) {
+			b.reject(w, env, protocol.CodeCapabilityDenied, err.Error())
+			return
+		} else if err != nil {
+			b.rejectInvalidBody(w, env, err)
+			return
+		}
+		defer b.events.Unsubscribe(sub.ID)
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprintf(w, ": subscribed as %s\n\n", env.Agent)
+	flusher.Flush()
+	log.Printf("Agent %s opened an event stream (pattern %q, results %t) from %s", env.Agent, body.Pattern, body.Results, r.RemoteAddr)
+
+	keepalive := time.NewTicker(eventStreamKeepalive)
+	defer keepalive.Stop()
+	for {
+		// Results are handed over from the outbox like collected ones, and
+		// acknowledged once written
+		var arrival <-chan struct{}
+		if body.Results {
+			arrival = b.results.Arrival(env.Agent)
+			if !b.streamResults(w, env.Agent) {
+				return
+			}
+			flusher.Flush()
+		}
+
+		select {
+		case event := <-events:
+			if err := b.writeStreamEvent(w, event); err != nil {
+				return
+			}
+		case <-arrival:
+			continue
+		case <-keepalive.C:
+			if _, err := io.WriteString(w, ": keepalive\n\n"); err != nil {
+				return
+			}
+		case <-overflow:
+			log.Printf("Closing event stream of %s: more than %d events behind", env.Agent, eventStreamBuffer)
+			return
+		case <-r.Context().Done():
+			return
+		}
+		flusher.Flush()
+	}
+}
+
+// authenticateSubscription parses the subscribe envelope authenticating an
+// event stream and checks it was recently signed by a registered agent. On
+// failure it writes the error envelope itself.
+func (b *Broker) authenticateSubscription(w http.ResponseWriter, r *http.Request, data []byte) (*protocol.GenericEnvelope, protocol.SubscribeBody, bool) {
+	env, err := protocol.ParseEnvelope(data)
+	if err != nil {
+		b.rejectMalformed(w, err)
+		return nil, protocol.SubscribeBody{}, false
+	}
+	if env.Type != protocol.EnvelopeSubscribe {
+		b.reject(w, env, protocol.CodeInvalidEnvelope, "An event stream must be opened with a subscribe envelope")
+		return nil, protocol.SubscribeBody{}, false
+	}
+	// The skew window bounds how long a leaked stream URL can be replayed
+	if !b.checkClockSkew(w, env) {
+		return nil, protocol.SubscribeBody{}, false
+	}
+	if b.IsQuarantined(env.Agent) {
+		b.rejectQuarantined(w, env)
+		return nil, protocol.SubscribeBody{}, false
+	}
+	if !b.authenticateAgent(w, r, env) {
+		return nil, protocol.SubscribeBody{}, false
+	}
+	typed, err := protocol.ParseTyped[protocol.SubscribeBody](env)
+	if err != nil {
+		b.rejectInvalidBody(w, env, err)
+		return nil, protocol.SubscribeBody{}, false
+	}
+	return env, typed.Body, true
+}
+
+// streamResults writes the results due to caller, reporting whether the
+// stream is still writable
+func (b *Broker) streamResults(w http.ResponseWriter, caller string) bool {
+	results := b.results.Collect(caller, nil, 0)
+	written := make([]string, 0, len(results))
+	defer func() { b.results.Acknowledge(caller, written...) }()
+	for _, result := range results {
+		eventType := protocol.EnvelopeToolResult
+		if result.Chunk > 0 {
+			eventType = protocol.EnvelopeToolResultChunk
+		}
+		if err := writeServerSentEvent(w, "", string(eventType), result.Envelope); err != nil {
+			return false
+		}
+		written = append(written, result.Key())
+	}
+	return true
+}
+
+// writeStreamEvent writes a topic event as its emitEvent envelope
+func (b *Broker) writeStreamEvent(w http.ResponseWriter, event TopicEvent) error {
+	data, err := b.eventEnvelope(event)
+	if err != nil {
+		log.Printf("Failed to sign event %d for an event stream: %v", event.Seq, err)
+		return nil
+	}
+	return writeServerSentEvent(w, strconv.FormatUint(event.Seq, 10), string(protocol.EnvelopeEmitEvent), data)
+}
+
+// eventEnvelope returns the emitEvent envelope that published an event, or
+// one the broker signs for events it raised
+func (b *Broker) eventEnvelope(event TopicEvent) (json.RawMessage, error) {
+	if event.envelope != nil {
+		return event.envelope, nil
+	}
+	envelope := protocol.NewTypedEnvelope(b.federation.config.LocalBrokerID, protocol.EmitEventBody{Event: event.Topic, Payload: event.Payload})
+	if err := envelope.Sign(b.IdentityKey()); err != nil {
+		return nil, err
+	}
+	return json.Marshal(envelope)
+}
+
+// writeServerSentEvent writes one message; JSON has no raw newlines, so the
+// data fits on one line
+func writeServerSentEvent(w io.Writer, id, event string, data []byte) error {
+	if id != "" {
+		if _, err := fmt.Fprintf(w, "id: %s\n", id); err != nil {
+			return err
+		}
+	}
+	_, err := fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event, data)
+	return err
+}
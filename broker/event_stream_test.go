@@ -0,0 +1,133 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/fep-fem/protocol"
+)
+
+func TestEventStreamDeliversEventsAndResults(t *testing.T) {
+	broker := NewBroker()
+	server := httptest.NewServer(broker)
+	defer server.Close()
+	pubKey, privKey, _ := protocol.GenerateKeyPair()
+	registerDiscoveryClient(broker, "dashboard", pubKey)
+	publisherKey, publisherPriv, _ := protocol.GenerateKeyPair()
+	registerDiscoveryClient(broker, "ci", publisherKey)
+
+	subscription := protocol.NewTypedEnvelope("dashboard", protocol.SubscribeBody{Pattern: "prod.*", Results: true})
+	subscription.Sign(privKey)
+	streamURL, _ := protocol.EventStreamURL(server.URL, subscription)
+	resp, err := http.Get(streamURL)
+	if err != nil {
+		t.Fatalf("Failed to open event stream: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("Content-Type") != "text/event-stream" {
+		t.Fatalf("Expected an event stream, got %d %s", resp.StatusCode, resp.Header.Get("Content-Type"))
+	}
+	reader := protocol.NewEventStreamReader(resp.Body)
+
+	event := protocol.NewTypedEnvelope("ci", protocol.EmitEventBody{Event: "prod.build", Payload: map[string]interface{}{"ok": true}})
+	event.Sign(publisherPriv)
+	postEnvelope(t, broker, event)
+	streamed, err := reader.Next()
+	if err != nil || streamed.Type != protocol.EnvelopeEmitEvent || streamed.Agent != "ci" {
+		t.Fatalf("Expected the published event, got %+v, %v", streamed, err)
+	}
+	if err := streamed.Verify(publisherKey); err != nil {
+		t.Errorf("Streamed events should carry the publisher's signature: %v", err)
+	}
+	if reader.LastEventID == "" {
+		t.Error("Streamed events should carry their sequence as the event ID")
+	}
+
+	broker.results.ExpectResult("call-1", "dashboard", protocol.DeliverAtLeastOnce)
+	result, _ := protocol.NewToolResult("worker", "call-1").Result("done").Build()
+	raw, _ := json.Marshal(result)
+	broker.results.StoreResult("call-1", raw)
+	streamed, err = reader.Next()
+	if err != nil || streamed.Type != protocol.EnvelopeToolResult || streamed.Agent != "worker" {
+		t.Fatalf("Expected the caller's result, got %+v, %v", streamed, err)
+	}
+	// Written results are acknowledged, so they are not redelivered
+	if _, results := broker.results.Pending(); results != 0 {
+		t.Errorf("Streamed results should be acknowledged, %d still held", results)
+	}
+}
+
+func TestEventStreamResumesAfterLastEventID(t *testing.T) {
+	broker := NewBroker()
+	server := httptest.NewServer(broker)
+	defer server.Close()
+	pubKey, privKey, _ := protocol.GenerateKeyPair()
+	registerDiscoveryClient(broker, "dashboard", pubKey)
+	first, _ := broker.events.publish("broker", "prod.deploy", map[string]interface{}{"version": 1})
+	broker.events.publish("broker", "prod.deploy", map[string]interface{}{"version": 2})
+
+	subscription := protocol.NewTypedEnvelope("dashboard", protocol.SubscribeBody{Pattern: "prod.*"})
+	subscription.Sign(privKey)
+	data, _ := json.Marshal(subscription)
+	req, _ := http.NewRequest(http.MethodPost, server.URL+protocol.EventStreamPath, bytes.NewReader(data))
+	req.Header.Set("Last-Event-ID", strconv.FormatUint(first.Seq, 10))
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("Failed to open event stream: %v", err)
+	}
+	defer resp.Body.Close()
+
+	streamed, err := protocol.NewEventStreamReader(resp.Body).Next()
+	if err != nil {
+		t.Fatalf("Expected a replayed event: %v", err)
+	}
+	var body protocol.EmitEventBody
+	json.Unmarshal(streamed.Body, &body)
+	if body.Payload["version"] != float64(2) {
+		t.Fatalf("Expected replay to resume after the last event seen, got %+v", body)
+	}
+	// The broker signs the events it raised itself
+	if streamed.Agent != broker.federation.config.LocalBrokerID {
+		t.Errorf("Expected a broker-signed event, got one from %s", streamed.Agent)
+	}
+}
+
+func TestEventStreamNeedsSignedSubscription(t *testing.T) {
+	broker := NewBroker()
+	pubKey, privKey, _ := protocol.GenerateKeyPair()
+	registerDiscoveryClient(broker, "dashboard", pubKey)
+	_, otherKey, _ := protocol.GenerateKeyPair()
+
+	open := func(envelope interface{}) *httptest.ResponseRecorder {
+		data, _ := json.Marshal(envelope)
+		resp := httptest.NewRecorder()
+		broker.ServeHTTP(resp, httptest.NewRequest(http.MethodPost, protocol.EventStreamPath, bytes.NewReader(data)))
+		return resp
+	}
+
+	forged := protocol.NewTypedEnvelope("dashboard", protocol.SubscribeBody{Pattern: "prod.*"})
+	forged.Sign(otherKey)
+	if resp := open(forged); resp.Code == http.StatusOK {
+		t.Error("Subscriptions with bad signatures should be refused")
+	}
+	unknown := protocol.NewTypedEnvelope("stranger", protocol.SubscribeBody{Pattern: "prod.*"})
+	unknown.Sign(otherKey)
+	if resp := open(unknown); resp.Code == http.StatusOK {
+		t.Error("Subscriptions from unregistered agents should be refused")
+	}
+	event := protocol.NewTypedEnvelope("dashboard", protocol.EmitEventBody{Event: "prod.build"})
+	event.Sign(privKey)
+	if resp := open(event); resp.Code != http.StatusBadRequest || !strings.Contains(resp.Body.String(), "subscribe envelope") {
+		t.Errorf("Streams should only open with subscribe envelopes, got %d %s", resp.Code, resp.Body.String())
+	}
+	empty := protocol.NewTypedEnvelope("dashboard", protocol.SubscribeBody{})
+	empty.Sign(privKey)
+	if resp := open(empty); resp.Code != http.StatusBadRequest {
+		t.Errorf("Subscriptions asking for nothing should be refused, got %d", resp.Code)
+	}
+}
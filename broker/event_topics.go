@@ -0,0 +1,527 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/fep-fem/protocol"
+)
+
+// Permission prefixes gating topics, e.g. "publish:prod.ci.*" or "subscribe:prod.*"
+const (
+	publishPermissionPrefix   = "publish:"
+	subscribePermissionPrefix = "subscribe:"
+)
+
+// Retention applied to topics no rule matches
+const (
+	defaultTopicMaxEvents = 100
+	defaultTopicMaxAge    = time.Hour
+)
+
+// ErrTopicDenied is returned when a caller lacks publish or subscribe rights on a topic
+var ErrTopicDenied = errors.New("topic access denied")
+
+// TopicRetention bounds the events kept for topics matching a pattern
+type TopicRetention struct {
+	Pattern   string            `json:"pattern"`
+	MaxEvents int               `json:"maxEvents"` // 0 keeps no events
+	MaxAge    protocol.Duration `json:"maxAge"`    // 0 keeps events until MaxEvents is reached
+}
+
+// TopicEvent is an event published to a topic
+type TopicEvent struct {
+	Seq       uint64                 `json:"seq"`
+	Topic     string                 `json:"topic"`
+	Publisher string                 `json:"publisher"`
+	Payload   map[string]interface{} `json:"payload,omitempty"`
+	Published protocol.Time          `json:"published"`
+	envelope  json.RawMessage        // The emitEvent envelope as its publisher signed it; nil for events the broker raised
+	relayed   bool                   // Arrived over the relay, so is not sent back to it
+}
+
+// TopicSubscription delivers events on topics matching Pattern. Subscriptions
+// declared with a subscribe envelope have no delivery yet; they record interest
+// and count the events that matched.
+type TopicSubscription struct {
+	ID         string    `json:"id"`
+	Subscriber string    `json:"subscriber"`
+	Pattern    string    `json:"pattern"`
+	Created    time.Time `json:"created"`
+	Matched    uint64    `json:"matched"` // Events published on matching topics
+	deliver    func(TopicEvent)
+}
+
+// SubscribeRequest asks for events on topics matching a pattern
+type SubscribeRequest struct {
+	Subscriber string
+	Pattern    string
+	Capability string    // Token granting subscribe rights, when topics are capability-gated
+	Since      time.Time // Replay retained events published after this time; zero replays nothing
+	AfterSeq   uint64    // Replay retained events with a higher seq, e.g. those a reconnecting client missed; 0 replays nothing
+}
+
+// TopicInfo summarizes a topic for operators
+type TopicInfo struct {
+	Topic     string         `json:"topic"`
+	Retained  int            `json:"retained"`
+	Published uint64         `json:"published"`
+	LastEvent protocol.Time  `json:"lastEvent"`
+	Retention TopicRetention `json:"retention"`
+}
+
+// topicState holds a topic's retained events
+type topicState struct {
+	events    []TopicEvent
+	published uint64
+}
+
+// EventBus routes events published to hierarchical topics to subscribers
+// whose patterns match, keeping recent events per topic for late subscribers
+type EventBus struct {
+	mu            sync.RWMutex
+	capabilities  *protocol.CapabilityManager // When set, publishing and subscribing need capability tokens
+	retention     map[string]TopicRetention
+	topics        map[string]*topicState
+	subscriptions map[string]*TopicSubscription
+	seq           uint64
+	relay         func(TopicEvent) // Passes events published here to other brokers; nil keeps them local
+}
+
+// NewEventBus creates an event bus open to every caller with default retention
+func NewEventBus() *EventBus {
+	return &EventBus{
+		retention:     make(map[string]TopicRetention),
+		topics:        make(map[string]*topicState),
+		subscriptions: make(map[string]*TopicSubscription),
+	}
+}
+
+// SetCapabilities gates publishing and subscribing on capability tokens
+// validated by cm. Tokens grant "publish:<pattern>" and "subscribe:<pattern>"
+// permissions. A nil manager opens every topic to every caller.
+func (eb *EventBus) SetCapabilities(cm *protocol.CapabilityManager) {
+	eb.mu.Lock()
+	defer eb.mu.Unlock()
+	eb.capabilities = cm
+}
+
+// SetRelay passes each event published on this bus to relay, e.g. to fan it
+// out to other brokers. Events the relay brings in are not passed back.
+func (eb *EventBus) SetRelay(relay func(TopicEvent)) {
+	eb.mu.Lock()
+	defer eb.mu.Unlock()
+	eb.relay = relay
+}
+
+// SetRetention creates or replaces the retention rule for a topic pattern
+func (eb *EventBus) SetRetention(rule TopicRetention) error {
+	if err := protocol.ValidateTopicPattern(rule.Pattern); err != nil {
+		return err
+	}
+	if rule.MaxEvents < 0 || rule.MaxAge < 0 {
+		return fmt.Errorf("retention limits must not be negative")
+	}
+
+	eb.mu.Lock()
+	defer eb.mu.Unlock()
+	eb.retention[rule.Pattern] = rule
+	for topic, state := range eb.topics {
+		state.events = trimEvents(state.events, eb.retentionForLocked(topic), time.Now())
+	}
+	return nil
+}
+
+// RemoveRetention deletes a retention rule, reporting whether it existed
+func (eb *EventBus) RemoveRetention(pattern string) bool {
+	eb.mu.Lock()
+	defer eb.mu.Unlock()
+	_, exists := eb.retention[pattern]
+	delete(eb.retention, pattern)
+	return exists
+}
+
+// Retention lists retention rules ordered by pattern
+func (eb *EventBus) Retention() []TopicRetention {
+	eb.mu.RLock()
+	defer eb.mu.RUnlock()
+	rules := make([]TopicRetention, 0, len(eb.retention))
+	for _, rule := range eb.retention {
+		rules = append(rules, rule)
+	}
+	sort.Slice(rules, func(i, j int) bool { return rules[i].Pattern < rules[j].Pattern })
+	return rules
+}
+
+// retentionForLocked returns the most specific rule matching topic. An exact
+// pattern wins; among wildcards the longest pattern wins.
+func (eb *EventBus) retentionForLocked(topic string) TopicRetention {
+	if rule, exists := eb.retention[topic]; exists {
+		return rule
+	}
+	var match *TopicRetention
+	for pattern, rule := range eb.retention {
+		if !protocol.MatchTopic(pattern, topic) {
+			continue
+		}
+		if match == nil || len(pattern) > len(match.Pattern) {
+			rule := rule
+			match = &rule
+		}
+	}
+	if match == nil {
+		return TopicRetention{Pattern: "*", MaxEvents: defaultTopicMaxEvents, MaxAge: protocol.Duration(defaultTopicMaxAge)}
+	}
+	return *match
+}
+
+// trimEvents drops events beyond the rule's count and age limits
+func trimEvents(events []TopicEvent, rule TopicRetention, now time.Time) []TopicEvent {
+	if rule.MaxAge > 0 {
+		cutoff := now.Add(-rule.MaxAge.Std())
+		drop := 0
+		for drop < len(events) && events[drop].Published.Std().Before(cutoff) {
+			drop++
+		}
+		events = events[drop:]
+	}
+	if len(events) > rule.MaxEvents {
+		events = events[len(events)-rule.MaxEvents:]
+	}
+	return append([]TopicEvent(nil), events...)
+}
+
+// authorize checks that token grants caller the permission on a topic or pattern
+func (eb *EventBus) authorize(prefix, caller, token, topic string) error {
+	eb.mu.RLock()
+	cm := eb.capabilities
+	eb.mu.RUnlock()
+	if cm == nil {
+		return nil
+	}
+
+	granted, err := capabilityScope(cm, token, caller, prefix)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrTopicDenied, err)
+	}
+	for _, pattern := range granted {
+		if protocol.MatchTopic(pattern, topic) {
+			return nil
+		}
+	}
+	return fmt.Errorf("%w: %s%s not granted", ErrTopicDenied, prefix, topic)
+}
+
+// Publish records an event on topic and delivers it to matching subscribers,
+// returning the event and the number of subscribers it was delivered to
+func (eb *EventBus) Publish(publisher, capability, topic string, payload map[string]interface{}) (TopicEvent, int, error) {
+	if err := protocol.ValidateTopic(topic); err != nil {
+		return TopicEvent{}, 0, err
+	}
+	if err := eb.authorize(publishPermissionPrefix, publisher, capability, topic); err != nil {
+		return TopicEvent{}, 0, err
+	}
+	event, delivered := eb.publish(publisher, topic, payload)
+	return event, delivered, nil
+}
+
+// PublishEnvelope publishes the event an emitEvent envelope carries, keeping
+// the envelope as signed so it can be relayed to event stream clients
+func (eb *EventBus) PublishEnvelope(env *protocol.GenericEnvelope, body protocol.EmitEventBody) (TopicEvent, int, error) {
+	if err := protocol.ValidateTopic(body.Event); err != nil {
+		return TopicEvent{}, 0, err
+	}
+	if err := eb.authorize(publishPermissionPrefix, env.Agent, body.Capability, body.Event); err != nil {
+		return TopicEvent{}, 0, err
+	}
+	raw, err := json.Marshal(env)
+	if err != nil {
+		return TopicEvent{}, 0, err
+	}
+	event, delivered := eb.record(TopicEvent{Topic: body.Event, Publisher: env.Agent, Payload: body.Payload, envelope: raw})
+	return event, delivered, nil
+}
+
+// publish records and delivers an event without checking publish rights,
+// for events the broker raises itself
+func (eb *EventBus) publish(publisher, topic string, payload map[string]interface{}) (TopicEvent, int) {
+	return eb.record(TopicEvent{Topic: topic, Publisher: publisher, Payload: payload})
+}
+
+// record numbers and timestamps an event, retains it and delivers it
+func (eb *EventBus) record(event TopicEvent) (TopicEvent, int) {
+	now := time.Now()
+	topic := event.Topic
+	eb.mu.Lock()
+	eb.seq++
+	event.Seq = eb.seq
+	event.Published = protocol.Time(now)
+	state, exists := eb.topics[topic]
+	if !exists {
+		state = &topicState{}
+		eb.topics[topic] = state
+	}
+	state.published++
+	state.events = trimEvents(append(state.events, event), eb.retentionForLocked(topic), now)
+
+	var recipients []*TopicSubscription
+	for _, sub := range eb.subscriptions {
+		if protocol.MatchTopic(sub.Pattern, topic) {
+			sub.Matched++
+			if sub.deliver != nil {
+				recipients = append(recipients, sub)
+			}
+		}
+	}
+	relay := eb.relay
+	eb.mu.Unlock()
+
+	// Deliver outside the lock so subscribers may publish or unsubscribe
+	for _, sub := range recipients {
+		sub.deliver(event)
+	}
+	if relay != nil && !event.relayed {
+		relay(event)
+	}
+	return event, len(recipients)
+}
+
+// Subscribe registers deliver for events on topics matching the request's
+// pattern. Retained events published after req.Since, or numbered after
+// req.AfterSeq, are delivered first.
+func (eb *EventBus) Subscribe(req SubscribeRequest, deliver func(TopicEvent)) (*TopicSubscription, error) {
+	if err := protocol.ValidateTopicPattern(req.Pattern); err != nil {
+		return nil, err
+	}
+	if err := eb.authorize(subscribePermissionPrefix, req.Subscriber, req.Capability, req.Pattern); err != nil {
+		return nil, err
+	}
+
+	sub := &TopicSubscription{
+		ID:         protocol.NewRandomID(),
+		Subscriber: req.Subscriber,
+		Pattern:    req.Pattern,
+		Created:    time.Now(),
+		deliver:    deliver,
+	}
+
+	eb.mu.Lock()
+	eb.subscriptions[sub.ID] = sub
+	var replay []TopicEvent
+	if !req.Since.IsZero() || req.AfterSeq > 0 {
+		for topic, state := range eb.topics {
+			if !protocol.MatchTopic(req.Pattern, topic) {
+				continue
+			}
+			for _, event := range state.events {
+				if (!req.Since.IsZero() && event.Published.Std().After(req.Since)) || (req.AfterSeq > 0 && event.Seq > req.AfterSeq) {
+					replay = append(replay, event)
+				}
+			}
+		}
+	}
+	eb.mu.Unlock()
+
+	sort.Slice(replay, func(i, j int) bool { return replay[i].Seq < replay[j].Seq })
+	for _, event := range replay {
+		deliver(event)
+	}
+	return sub, nil
+}
+
+// Declare records req.Subscriber's interest in topics matching req.Pattern
+// without delivering events, returning the subscription and whether it is new.
+// Declaring the same pattern again returns the existing subscription.
+func (eb *EventBus) Declare(req SubscribeRequest) (TopicSubscription, bool, error) {
+	if err := protocol.ValidateTopicPattern(req.Pattern); err != nil {
+		return TopicSubscription{}, false, err
+	}
+	if err := eb.authorize(subscribePermissionPrefix, req.Subscriber, req.Capability, req.Pattern); err != nil {
+		return TopicSubscription{}, false, err
+	}
+
+	eb.mu.Lock()
+	defer eb.mu.Unlock()
+	for _, sub := range eb.subscriptions {
+		if sub.deliver == nil && sub.Subscriber == req.Subscriber && sub.Pattern == req.Pattern {
+			return *sub, false, nil
+		}
+	}
+	sub := &TopicSubscription{
+		ID:         protocol.NewRandomID(),
+		Subscriber: req.Subscriber,
+		Pattern:    req.Pattern,
+		Created:    time.Now(),
+	}
+	eb.subscriptions[sub.ID] = sub
+	return *sub, true, nil
+}
+
+// RemoveSubscriptions removes subscriber's subscriptions with the given ID or
+// pattern, or all of them if both are empty, returning how many were removed
+func (eb *EventBus) RemoveSubscriptions(subscriber, id, pattern string) int {
+	eb.mu.Lock()
+	defer eb.mu.Unlock()
+	removed := 0
+	for subID, sub := range eb.subscriptions {
+		if sub.Subscriber != subscriber || (id != "" && subID != id) || (pattern != "" && sub.Pattern != pattern) {
+			continue
+		}
+		delete(eb.subscriptions, subID)
+		removed++
+	}
+	return removed
+}
+
+// Unsubscribe removes a subscription, reporting whether it existed
+func (eb *EventBus) Unsubscribe(id string) bool {
+	eb.mu.Lock()
+	defer eb.mu.Unlock()
+	_, exists := eb.subscriptions[id]
+	delete(eb.subscriptions, id)
+	return exists
+}
+
+// Subscriptions lists subscriptions ordered by creation
+func (eb *EventBus) Subscriptions() []TopicSubscription {
+	eb.mu.RLock()
+	defer eb.mu.RUnlock()
+	subs := make([]TopicSubscription, 0, len(eb.subscriptions))
+	for _, sub := range eb.subscriptions {
+		subs = append(subs, *sub)
+	}
+	sort.Slice(subs, func(i, j int) bool { return subs[i].Created.Before(subs[j].Created) })
+	return subs
+}
+
+// Topics summarizes every topic that has been published to, ordered by name
+func (eb *EventBus) Topics() []TopicInfo {
+	eb.mu.Lock()
+	defer eb.mu.Unlock()
+
+	now := time.Now()
+	topics := make([]TopicInfo, 0, len(eb.topics))
+	for topic, state := range eb.topics {
+		rule := eb.retentionForLocked(topic)
+		state.events = trimEvents(state.events, rule, now)
+		info := TopicInfo{Topic: topic, Retained: len(state.events), Published: state.published, Retention: rule}
+		if n := len(state.events); n > 0 {
+			info.LastEvent = state.events[n-1].Published
+		}
+		topics = append(topics, info)
+	}
+	sort.Slice(topics, func(i, j int) bool { return topics[i].Topic < topics[j].Topic })
+	return topics
+}
+
+// handleEmitEvent publishes an event to its topic
+func (b *Broker) handleEmitEvent(w http.ResponseWriter, env *protocol.GenericEnvelope) {
+	typed, err := protocol.ParseTyped[protocol.EmitEventBody](env)
+	if err != nil {
+		b.rejectInvalidBody(w, env, err)
+		return
+	}
+	body := typed.Body
+
+	event, delivered, err := b.events.PublishEnvelope(env, body)
+	if err != nil {
+		if errors.Is(err, ErrTopicDenied) {
+			b.reject(w, env, protocol.CodeCapabilityDenied, err.Error())
+		} else {
+			b.rejectInvalidBody(w, env, err)
+		}
+		return
+	}
+
+	b.writeAck(w, env, "emitted", map[string]interface{}{
+		"event":     event.Topic,
+		"seq":       event.Seq,
+		"delivered": delivered,
+	})
+}
+
+// handleSubscribe records the sender's interest in topics matching a pattern.
+// Only registered agents whose signature verifies may subscribe.
+func (b *Broker) handleSubscribe(w http.ResponseWriter, r *http.Request, env *protocol.GenericEnvelope) {
+	typed, err := protocol.ParseTyped[protocol.SubscribeBody](env)
+	if err != nil {
+		b.rejectInvalidBody(w, env, err)
+		return
+	}
+	if !b.authenticateAgent(w, r, env) {
+		return
+	}
+
+	sub, created, err := b.events.Declare(SubscribeRequest{
+		Subscriber: env.Agent,
+		Pattern:    typed.Body.Pattern,
+		Capability: typed.Body.Capability,
+	})
+	if err != nil {
+		if errors.Is(err, ErrTopicDenied) {
+			b.reject(w, env, protocol.CodeCapabilityDenied, err.Error())
+		} else {
+			b.rejectInvalidBody(w, env, err)
+		}
+		return
+	}
+
+	b.writeAck(w, env, "subscribed", map[string]interface{}{
+		"subscriptionId": sub.ID,
+		"pattern":        sub.Pattern,
+		"created":        created,
+	})
+}
+
+// handleUnsubscribe removes the sender's subscriptions by ID or pattern
+func (b *Broker) handleUnsubscribe(w http.ResponseWriter, r *http.Request, env *protocol.GenericEnvelope) {
+	typed, err := protocol.ParseTyped[protocol.UnsubscribeBody](env)
+	if err != nil {
+		b.rejectInvalidBody(w, env, err)
+		return
+	}
+	if !b.authenticateAgent(w, r, env) {
+		return
+	}
+
+	removed := b.events.RemoveSubscriptions(env.Agent, typed.Body.SubscriptionID, typed.Body.Pattern)
+	b.writeAck(w, env, "unsubscribed", map[string]interface{}{"removed": removed})
+}
+
+// handleAdminTopics lists topics and retention rules (GET), sets a retention
+// rule (PUT/POST) and deletes one (DELETE ?pattern=)
+func (b *Broker) handleAdminTopics(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		writeJSON(w, http.StatusOK, map[string]interface{}{
+			"topics":        b.events.Topics(),
+			"retention":     b.events.Retention(),
+			"subscriptions": b.events.Subscriptions(),
+		})
+	case http.MethodPut, http.MethodPost:
+		var rule TopicRetention
+		if err := json.NewDecoder(r.Body).Decode(&rule); err != nil {
+			http.Error(w, "Invalid retention rule", http.StatusBadRequest)
+			return
+		}
+		if err := b.events.SetRetention(rule); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]interface{}{"status": "saved", "pattern": rule.Pattern})
+	case http.MethodDelete:
+		pattern := r.URL.Query().Get("pattern")
+		if !b.events.RemoveRetention(pattern) {
+			http.Error(w, "Retention rule not found", http.StatusNotFound)
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]interface{}{"status": "deleted", "pattern": pattern})
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
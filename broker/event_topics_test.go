@@ -0,0 +1,174 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/fep-fem/protocol"
+)
+
+func TestEventBusWildcardSubscriptions(t *testing.T) {
+	bus := NewEventBus()
+	var ci, builds []string
+	bus.Subscribe(SubscribeRequest{Subscriber: "dashboard", Pattern: "prod.ci.*"}, func(e TopicEvent) { ci = append(ci, e.Topic) })
+	sub, _ := bus.Subscribe(SubscribeRequest{Subscriber: "notifier", Pattern: "*.ci.build.finished"}, func(e TopicEvent) { builds = append(builds, e.Topic) })
+
+	for _, topic := range []string{"prod.ci.build.finished", "prod.ci.test.failed", "staging.ci.build.finished", "prod.deploy"} {
+		if _, _, err := bus.Publish("runner", "", topic, nil); err != nil {
+			t.Fatalf("Publish %s failed: %v", topic, err)
+		}
+	}
+	if len(ci) != 2 || ci[0] != "prod.ci.build.finished" || ci[1] != "prod.ci.test.failed" {
+		t.Errorf("prod.ci.* received %v", ci)
+	}
+	if len(builds) != 2 {
+		t.Errorf("*.ci.build.finished received %v", builds)
+	}
+
+	if !bus.Unsubscribe(sub.ID) {
+		t.Fatal("Unsubscribe should find the subscription")
+	}
+	if _, delivered, _ := bus.Publish("runner", "", "prod.ci.build.finished", nil); delivered != 1 {
+		t.Errorf("Unsubscribed handler should not be counted, delivered to %d", delivered)
+	}
+	if _, _, err := bus.Publish("runner", "", "prod.ci.*", nil); err == nil {
+		t.Error("Publishing to a pattern should fail")
+	}
+}
+
+func TestEventBusRetention(t *testing.T) {
+	bus := NewEventBus()
+	if err := bus.SetRetention(TopicRetention{Pattern: "prod.ci.*", MaxEvents: 2}); err != nil {
+		t.Fatalf("SetRetention failed: %v", err)
+	}
+	bus.SetRetention(TopicRetention{Pattern: "prod.ci.audit", MaxEvents: 10})
+	start := time.Now().Add(-time.Second)
+	for i := 0; i < 5; i++ {
+		bus.Publish("runner", "", "prod.ci.build", map[string]interface{}{"n": i})
+		bus.Publish("runner", "", "prod.ci.audit", nil)
+	}
+
+	var replayed []TopicEvent
+	bus.Subscribe(SubscribeRequest{Subscriber: "late", Pattern: "prod.ci.build", Since: start}, func(e TopicEvent) {
+		replayed = append(replayed, e)
+	})
+	if len(replayed) != 2 || replayed[0].Payload["n"] != 3 || replayed[1].Payload["n"] != 4 {
+		t.Errorf("Expected the last two builds to be replayed, got %+v", replayed)
+	}
+
+	retained := map[string]int{}
+	for _, info := range bus.Topics() {
+		retained[info.Topic] = info.Retained
+	}
+	if retained["prod.ci.build"] != 2 || retained["prod.ci.audit"] != 5 {
+		t.Errorf("The most specific rule should apply, got %v", retained)
+	}
+
+	if err := bus.SetRetention(TopicRetention{Pattern: "prod.ci.*", MaxEvents: 2, MaxAge: protocol.Duration(time.Nanosecond)}); err != nil {
+		t.Fatalf("SetRetention failed: %v", err)
+	}
+	for _, info := range bus.Topics() {
+		if info.Topic == "prod.ci.build" && info.Retained != 0 {
+			t.Errorf("Expired events should be dropped, %d retained", info.Retained)
+		}
+	}
+}
+
+func TestEmitEventRequiresTopicRights(t *testing.T) {
+	broker := NewBroker()
+	capabilities := protocol.NewCapabilityManager([]byte("topic-key"))
+	broker.events.SetCapabilities(capabilities)
+
+	if _, err := broker.events.Subscribe(SubscribeRequest{Subscriber: "dashboard", Pattern: "prod.*"}, func(TopicEvent) {}); !errors.Is(err, ErrTopicDenied) {
+		t.Errorf("Subscribing without a token should be denied, got %v", err)
+	}
+	subToken, _ := capabilities.CreateCapability("topics", "broker", "dashboard", []string{"subscribe:prod.*"}, time.Hour)
+	if _, err := broker.events.Subscribe(SubscribeRequest{Subscriber: "dashboard", Pattern: "*", Capability: subToken}, func(TopicEvent) {}); !errors.Is(err, ErrTopicDenied) {
+		t.Errorf("Subscribing beyond the granted pattern should be denied, got %v", err)
+	}
+	var received []TopicEvent
+	if _, err := broker.events.Subscribe(SubscribeRequest{Subscriber: "dashboard", Pattern: "prod.ci.*", Capability: subToken}, func(e TopicEvent) {
+		received = append(received, e)
+	}); err != nil {
+		t.Fatalf("Subscribing within the grant failed: %v", err)
+	}
+
+	pubToken, _ := capabilities.CreateCapability("topics", "broker", "ci-runner", []string{"publish:prod.ci.*"}, time.Hour)
+	emit := func(topic, token string) *httptest.ResponseRecorder {
+		envelope := protocol.NewTypedEnvelope("ci-runner", protocol.EmitEventBody{
+			Event:      topic,
+			Payload:    map[string]interface{}{"status": "green"},
+			Capability: token,
+		})
+		data, _ := json.Marshal(envelope)
+		recorder := httptest.NewRecorder()
+		broker.ServeHTTP(recorder, httptest.NewRequest(http.MethodPost, "/", bytes.NewReader(data)))
+		return recorder
+	}
+
+	if resp := emit("prod.ci.build.finished", ""); resp.Code != http.StatusForbidden {
+		t.Errorf("Publishing without a token should be forbidden, got %d", resp.Code)
+	}
+	if resp := emit("prod.deploy.finished", pubToken); resp.Code != http.StatusForbidden {
+		t.Errorf("Publishing outside the grant should be forbidden, got %d", resp.Code)
+	}
+	resp := emit("prod.ci.build.finished", pubToken)
+	var result struct {
+		Event     string `json:"event"`
+		Delivered int    `json:"delivered"`
+	}
+	if err := readAck(t, resp.Body).ResultAs(&result); err != nil || result.Delivered != 1 {
+		t.Fatalf("Expected delivery to one subscriber, got %+v %v", result, err)
+	}
+	if len(received) != 1 || received[0].Publisher != "ci-runner" || received[0].Payload["status"] != "green" {
+		t.Errorf("Subscriber received %+v", received)
+	}
+}
+
+func TestSubscribeEnvelopesMaintainSubscriptions(t *testing.T) {
+	broker := NewBroker()
+	server := httptest.NewTLSServer(broker)
+	defer server.Close()
+
+	pubKey, privKey, _ := protocol.GenerateKeyPair()
+	registerDiscoveryClient(broker, "dashboard", pubKey)
+	client := NewMCPClient(MCPClientConfig{AgentID: "dashboard", BrokerURL: server.URL, PrivateKey: privKey, TLSInsecure: true})
+
+	id, err := client.Subscribe("prod.ci.*", "")
+	if err != nil || id == "" {
+		t.Fatalf("Subscribe failed: %q %v", id, err)
+	}
+	if again, _ := client.Subscribe("prod.ci.*", ""); again != id {
+		t.Errorf("Subscribing to the same pattern should return the existing subscription, got %s and %s", id, again)
+	}
+	if _, err := client.Subscribe("prod.**", ""); err == nil {
+		t.Error("An invalid pattern should be rejected")
+	}
+
+	broker.events.Publish("ci-runner", "", "prod.ci.build.finished", nil)
+	subs := broker.events.Subscriptions()
+	if len(subs) != 1 || subs[0].Subscriber != "dashboard" || subs[0].Matched != 1 {
+		t.Fatalf("Expected one subscription that matched one event, got %+v", subs)
+	}
+
+	// Other agents cannot remove the subscription
+	if removed := broker.events.RemoveSubscriptions("intruder", id, ""); removed != 0 {
+		t.Errorf("Only the subscriber should remove its subscription, removed %d", removed)
+	}
+	if err := client.Unsubscribe(id); err != nil {
+		t.Fatalf("Unsubscribe failed: %v", err)
+	}
+	if subs := broker.events.Subscriptions(); len(subs) != 0 {
+		t.Errorf("Subscription should be removed, got %+v", subs)
+	}
+
+	stranger := NewMCPClient(MCPClientConfig{AgentID: "stranger", BrokerURL: server.URL, PrivateKey: privKey, TLSInsecure: true})
+	if _, err := stranger.Subscribe("prod.*", ""); err == nil {
+		t.Error("Unregistered agents should not subscribe")
+	}
+}
@@ -1,7 +1,10 @@
 package main
 
 import (
+	"context"
+	"crypto/ed25519"
 	"fmt"
+	"log"
 	"sort"
 	"sync"
 	"time"
@@ -16,14 +19,36 @@ type FederationManager struct {
 	
 	// Federation topology
 	federatedBrokers map[string]*FederatedBroker
+	peersSyncedAt    time.Time // When registrations were last synced with peers
+	peerCatalogs     map[string][]protocol.DiscoveredTool
+	catalogsIssued   map[string]int64 // Issue time of the catalog feed last imported from each peer
 	routingTable     map[string]*ToolRoute
+	routesFile       string // Persists operator-defined routes when set
 	topologyMutex    sync.RWMutex
 	
 	// Load balancing and performance
 	agentMetrics     map[string]*AgentMetrics
 	loadBalancer     *LoadBalancer
 	healthChecker    *HealthChecker
+	canaries         *CanaryRunner
+	exclusions       *ExclusionList
+	standbys         *StandbyTracker
+	reservations     *ResourceLedger
+	budgets          *BudgetLedger
 	metricsMutex     sync.RWMutex
+	metricsHistory   *MetricsHistory
+	
+	// Registration ledger for split-brain reconciliation
+	registrationLedger    map[string]*RegistrationRecord
+	reconciliationLog     []ReconciliationDecision
+	revocationAuthorities map[string]ed25519.PublicKey // Signers whose revocations of any agent count
+	ledgerMutex           sync.RWMutex
+	
+	// Append-only audit log of registrations and revocations
+	transparencyLog *TransparencyLog
+
+	// Capability tokens revoked here or by trusted peers
+	capabilityRevocations *CapabilityRevocationList
 	
 	// Discovery enhancement
 	semanticIndex    *SemanticIndex
@@ -31,6 +56,10 @@ type FederationManager struct {
 	
 	// Configuration
 	config *FederationConfig
+
+	// Lifecycle of the background loops, ended by Close
+	ctx  context.Context
+	stop context.CancelFunc
 }
 
 // FederatedBroker represents a peer broker in the federation
@@ -45,6 +74,9 @@ type FederatedBroker struct {
 	ResponseTime     time.Duration
 	ToolCount        int
 	LoadScore        float64
+	TrustTier        PeerTrustTier
+	IdentityVerified bool
+	ProtocolVersion  string // Version the peer advertised when registering
 }
 
 // BrokerStatus represents the status of a federated broker
@@ -59,13 +91,18 @@ const (
 
 // ToolRoute defines how to route requests for specific tools
 type ToolRoute struct {
-	ToolPattern      string
-	PrimaryAgents    []string
-	FallbackAgents   []string
-	LoadBalanceMode  LoadBalanceMode
-	RoutingStrategy  RoutingStrategy
-	HealthThreshold  float64
-	LastUpdated      time.Time
+	ToolPattern      string          `json:"toolPattern"`
+	PrimaryAgents    []string        `json:"primaryAgents,omitempty"`
+	FallbackAgents   []string        `json:"fallbackAgents,omitempty"`
+	LoadBalanceMode  LoadBalanceMode `json:"loadBalanceMode,omitempty"`
+	RoutingStrategy  RoutingStrategy `json:"routingStrategy,omitempty"`
+	HealthThreshold  float64         `json:"healthThreshold,omitempty"`
+	Critical         bool            `json:"critical,omitempty"`     // Promote StandbyAgent while every primary agent is excluded
+	StandbyAgent     string          `json:"standbyAgent,omitempty"` // Warm standby of a critical route, not routed to until promoted
+	CallScript       []ScriptStep    `json:"callScript,omitempty"`   // Steps rewriting toolCall bodies before routing
+	ResultScript     []ScriptStep    `json:"resultScript,omitempty"` // Steps rewriting toolResult bodies
+	LastUpdated      time.Time       `json:"lastUpdated"`
+	scripts          *routeScripts   // Compiled CallScript and ResultScript
 }
 
 // LoadBalanceMode defines different load balancing strategies
@@ -77,6 +114,8 @@ const (
 	LoadBalanceWeightedRound LoadBalanceMode = "weighted_round"
 	LoadBalanceBestPerformance LoadBalanceMode = "best_performance"
 	LoadBalanceAffinityBased LoadBalanceMode = "affinity_based"
+	LoadBalanceGPUBinPack    LoadBalanceMode = "gpu_binpack"
+	LoadBalanceCostAware     LoadBalanceMode = "cost_aware"
 )
 
 // RoutingStrategy defines different routing approaches
@@ -104,13 +143,21 @@ type AgentMetrics struct {
 	LastHealthCheck      time.Time
 	HealthScore          float64
 	LoadScore            float64
+	GPUUtilization       float64 // Fraction of GPU compute in use, as last reported
 	GeographicRegion     string
+	ProbeScores          map[string]float64 // Score of each health signal from the last check
+	LastErrorAt          time.Time
+	LastErrorMessage     string
 	LastUpdated          time.Time
+	recentLatencies      []time.Duration // Ring of recent response times for percentiles
+	latencyIndex         int
 }
 
 // LoadBalancer handles intelligent load distribution
 type LoadBalancer struct {
 	strategies map[LoadBalanceMode]LoadBalanceStrategy
+	excluded   map[string]bool // Agents removed from selection by the health checker
+	recorder   *routingRecorder // Logs each selection for replay; nil if not recorded
 	mutex      sync.RWMutex
 }
 
@@ -128,6 +175,8 @@ type RequestContext struct {
 	LatencyRequirement time.Duration
 	GeographicRegion string
 	AffinityPreferences []string
+	DataRegion       string // Region the call's data must stay in; routing fails rather than leave it
+	RequestID        string // Call to reserve the selected agent's resources for, if set
 }
 
 // RequestPriority defines request priority levels
@@ -146,6 +195,8 @@ type HealthChecker struct {
 	healthThreshold  float64
 	degradedThreshold float64
 	stopChan         chan struct{}
+	weights          HealthWeights
+	probes           []registeredProbe
 	mutex            sync.RWMutex
 }
 
@@ -184,15 +235,23 @@ type UserPreferences struct {
 // FederationConfig holds configuration for the federation manager
 type FederationConfig struct {
 	// Topology management
+	LocalBrokerID        string
 	MaxBrokers           int
 	BrokerSyncInterval   time.Duration
 	TopologyUpdateInterval time.Duration
+	DefaultPeerTrustTier PeerTrustTier
+	
+	// Transparency log
+	SigningKey       protocol.Signer    // Signs tree heads; generated if nil
+	TreeHeadInterval time.Duration
 	
 	// Load balancing
 	DefaultLoadBalanceMode LoadBalanceMode
 	DefaultRoutingStrategy RoutingStrategy
 	HealthCheckInterval    time.Duration
 	HealthThreshold        float64
+	HealthRecoveryProbes   int // Consecutive healthy probes before an excluded agent is routed to again
+	HealthWeights          *HealthWeights // Weights of the built-in health signals; defaults if nil
 	
 	// Discovery enhancement
 	EnableSemanticSearch   bool
@@ -202,38 +261,76 @@ type FederationConfig struct {
 	// Performance
 	MetricsRetentionPeriod time.Duration
 	CacheUpdateInterval    time.Duration
+	ToolStalenessThreshold time.Duration // Registry entries not seen for this long are removed; 0 disables
 }
 
 // NewFederationManager creates a new federation manager
 func NewFederationManager(mcpRegistry *MCPRegistry, config *FederationConfig) *FederationManager {
 	if config == nil {
 		config = &FederationConfig{
+			LocalBrokerID:          "fem-broker",
 			MaxBrokers:             10,
 			BrokerSyncInterval:     30 * time.Second,
 			TopologyUpdateInterval: 60 * time.Second,
+			DefaultPeerTrustTier:   PeerTrustUntrusted,
 			DefaultLoadBalanceMode: LoadBalanceBestPerformance,
 			DefaultRoutingStrategy: RoutingBestFit,
 			HealthCheckInterval:    15 * time.Second,
 			HealthThreshold:        0.8,
+			HealthRecoveryProbes:   defaultRecoveryProbes,
 			EnableSemanticSearch:   true,
 			EnableRanking:          true,
 			SimilarityThreshold:    0.7,
 			MetricsRetentionPeriod: 24 * time.Hour,
 			CacheUpdateInterval:    5 * time.Minute,
+			TreeHeadInterval:       time.Minute,
 		}
 	}
 
+	signingKey := config.SigningKey
+	if signingKey == nil {
+		_, generated, _ := protocol.GenerateKeyPair()
+		signingKey = generated
+	}
+
 	fm := &FederationManager{
 		mcpRegistry:      mcpRegistry,
 		federatedBrokers: make(map[string]*FederatedBroker),
+		peerCatalogs:     make(map[string][]protocol.DiscoveredTool),
+		catalogsIssued:   make(map[string]int64),
 		routingTable:     make(map[string]*ToolRoute),
 		agentMetrics:     make(map[string]*AgentMetrics),
+		metricsHistory:   NewMetricsHistory(config.MetricsRetentionPeriod),
+		registrationLedger: make(map[string]*RegistrationRecord),
+		capabilityRevocations: NewCapabilityRevocationList(),
+		transparencyLog:  NewTransparencyLog(config.LocalBrokerID, signingKey),
 		config:           config,
 	}
+	fm.ctx, fm.stop = context.WithCancel(context.Background())
 
 	// Initialize subsystems
 	fm.loadBalancer = NewLoadBalancer()
 	fm.healthChecker = NewHealthChecker(config.HealthCheckInterval, config.HealthThreshold)
+	if config.HealthWeights != nil {
+		if err := fm.healthChecker.SetWeights(*config.HealthWeights); err != nil {
+			log.Printf("Ignoring health weights: %v", err)
+		}
+	}
+	fm.canaries = NewCanaryRunner(mcpRegistry)
+	fm.healthChecker.RegisterProbe(canaryProbeName, defaultCanaryWeight, fm.canaries)
+	fm.exclusions = NewExclusionList(config.HealthRecoveryProbes)
+	fm.exclusions.OnChange(func(agentID string, excluded bool) {
+		if excluded {
+			fm.loadBalancer.ExcludeAgent(agentID)
+		} else {
+			fm.loadBalancer.RestoreAgent(agentID)
+		}
+	})
+	fm.standbys = NewStandbyTracker()
+	fm.reservations = NewResourceLedger()
+	fm.budgets = NewBudgetLedger()
+	fm.loadBalancer.RegisterStrategy(LoadBalanceCostAware, &CostAwareStrategy{cost: fm.toolCost})
+	fm.exclusions.OnChange(func(string, bool) { fm.updateStandbys() })
 	
 	if config.EnableSemanticSearch {
 		fm.semanticIndex = NewSemanticIndex()
@@ -250,6 +347,15 @@ func NewFederationManager(mcpRegistry *MCPRegistry, config *FederationConfig) *F
 	if config.CacheUpdateInterval > 0 {
 		go fm.startMetricsCollector()
 	}
+	if config.TreeHeadInterval > 0 {
+		go fm.startTreeHeadPublisher()
+	}
+	if mcpRegistry != nil {
+		mcpRegistry.AddObserver(fm.registryObserver())
+		if config.ToolStalenessThreshold > 0 {
+			fm.StartRegistryGC(config.ToolStalenessThreshold)
+		}
+	}
 
 	return fm
 }
@@ -261,6 +367,7 @@ func (fm *FederationManager) DiscoverToolsAdvanced(query protocol.ToolQuery, con
 	if err != nil {
 		return nil, fmt.Errorf("base discovery failed: %w", err)
 	}
+	fm.annotateToolMetadata(baseTools)
 
 	result := &AdvancedDiscoveryResult{
 		BaseResults:    baseTools,
@@ -268,6 +375,9 @@ func (fm *FederationManager) DiscoverToolsAdvanced(query protocol.ToolQuery, con
 		Timestamp:      time.Now(),
 	}
 
+	// Include tools advertised by peers whose trust tier allows discovery
+	result.RemoteResults = fm.DiscoverRemoteTools(query)
+
 	// Apply semantic enhancement if enabled
 	if fm.config.EnableSemanticSearch && fm.semanticIndex != nil {
 		semanticResults := fm.enhanceWithSemanticSearch(baseTools, query)
@@ -293,6 +403,7 @@ func (fm *FederationManager) DiscoverToolsAdvanced(query protocol.ToolQuery, con
 // AdvancedDiscoveryResult contains enhanced discovery results
 type AdvancedDiscoveryResult struct {
 	BaseResults             []protocol.DiscoveredTool
+	RemoteResults           []protocol.DiscoveredTool
 	SemanticResults         []SemanticDiscoveryResult
 	RankedResults           []RankedTool
 	RoutingRecommendations  []RoutingRecommendation
@@ -350,11 +461,12 @@ type FederationStats struct {
 
 // RouteToolInvocation intelligently routes tool invocations
 func (fm *FederationManager) RouteToolInvocation(toolName string, agentID string, context *RequestContext) (*RoutingDecision, error) {
-	fm.topologyMutex.RLock()
-	route, exists := fm.routingTable[toolName]
-	fm.topologyMutex.RUnlock()
+	route := fm.matchRoute(toolName)
+	if route != nil && (len(route.PrimaryAgents) > 0 || len(route.FallbackAgents) > 0) {
+		return fm.routeByOperatorRoute(route, toolName, agentID, context)
+	}
 
-	if !exists {
+	if route == nil {
 		// Create default route
 		route = &ToolRoute{
 			ToolPattern:     toolName,
@@ -363,6 +475,10 @@ func (fm *FederationManager) RouteToolInvocation(toolName string, agentID string
 			HealthThreshold: fm.config.HealthThreshold,
 			LastUpdated:     time.Now(),
 		}
+		// GPU jobs are packed onto busy GPUs to keep others free for large jobs
+		if fm.needsGPUs(toolName) {
+			route.LoadBalanceMode = LoadBalanceGPUBinPack
+		}
 	}
 
 	// Get available agents for this tool
@@ -370,6 +486,23 @@ func (fm *FederationManager) RouteToolInvocation(toolName string, agentID string
 	if len(availableAgents) == 0 {
 		return nil, fmt.Errorf("no available agents for tool %s", toolName)
 	}
+	if context != nil {
+		var violation *ResidencyViolation
+		if availableAgents, violation = fm.filterResidency(availableAgents, toolName, context.DataRegion); violation != nil {
+			return nil, violation
+		}
+	}
+	var refusal *AdmissionRefusal
+	if availableAgents, refusal = fm.filterCapacity(availableAgents, toolName); refusal != nil {
+		return nil, refusal
+	}
+	var exceeded *BudgetExceeded
+	if availableAgents, exceeded = fm.filterBudget(availableAgents, toolName, context); exceeded != nil {
+		return nil, exceeded
+	}
+	if context != nil && context.ToolName == "" {
+		context.ToolName = toolName
+	}
 
 	// Select best agent using load balancer
 	selectedAgent, err := fm.loadBalancer.SelectAgent(availableAgents, fm.agentMetrics, context, route.LoadBalanceMode)
@@ -388,6 +521,7 @@ func (fm *FederationManager) RouteToolInvocation(toolName string, agentID string
 
 	// Update metrics
 	fm.updateRoutingMetrics(toolName, selectedAgent, context)
+	fm.reserveResources(toolName, selectedAgent, context)
 
 	return decision, nil
 }
@@ -493,7 +627,10 @@ func (fm *FederationManager) getAvailableAgentsForTool(toolName string, preferre
 		}
 	}
 
-	return agents
+	// Add agents hosted by peers whose trust tier allows routing
+	agents = append(agents, fm.routableRemoteAgents(toolName)...)
+
+	return fm.exclusions.Filter(fm.filterPreempted(agents))
 }
 
 func (fm *FederationManager) updateRoutingMetrics(toolName, agentID string, context *RequestContext) {
@@ -511,6 +648,7 @@ func (fm *FederationManager) updateRoutingMetrics(toolName, agentID string, cont
 
 	metrics.TotalRequests++
 	metrics.LastUpdated = time.Now()
+	fm.recordMetricsSample(metrics)
 }
 
 func (fm *FederationManager) getFederationStats() *FederationStats {
@@ -566,6 +704,8 @@ func (fm *FederationManager) startTopologyManager() {
 
 	for {
 		select {
+		case <-fm.ctx.Done():
+			return
 		case <-ticker.C:
 			fm.updateTopology()
 		}
@@ -578,20 +718,48 @@ func (fm *FederationManager) startMetricsCollector() {
 
 	for {
 		select {
+		case <-fm.ctx.Done():
+			return
 		case <-ticker.C:
 			fm.collectMetrics()
 		}
 	}
 }
 
+func (fm *FederationManager) startTreeHeadPublisher() {
+	ticker := time.NewTicker(fm.config.TreeHeadInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-fm.ctx.Done():
+			return
+		case <-ticker.C:
+			fm.transparencyLog.PublishTreeHead()
+		}
+	}
+}
+
+// Close stops the federation's background loops and the persistence of its
+// transparency log
+func (fm *FederationManager) Close() error {
+	fm.stop()
+	return fm.transparencyLog.Close()
+}
+
 func (fm *FederationManager) updateTopology() {
 	// Update federated broker status and topology
 	// This would typically involve pinging other brokers, updating routing tables, etc.
-	// Simplified implementation for now
+	fm.syncPeerRegistrations()
+	fm.syncPeerCapabilityRevocations()
+	fm.syncPeerCatalogs()
 }
 
 func (fm *FederationManager) collectMetrics() {
 	// Collect performance metrics from agents and brokers
 	// Update health scores, response times, etc.
 	// Simplified implementation for now
+
+	// Drop history for agents not seen within the retention period
+	fm.metricsHistory.Prune()
 }
\ No newline at end of file
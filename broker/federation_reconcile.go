@@ -0,0 +1,481 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/fep-fem/protocol"
+)
+
+// maxReconciliationLog bounds the number of reconciliation decisions kept in memory
+const maxReconciliationLog = 1000
+
+// RegistrationRecord is the latest known registration state of an agent, as
+// exchanged between federated brokers during sync. The agent's keys form a
+// chain: PubKey, and the key it replaced when the change was signed by that key.
+type RegistrationRecord struct {
+	AgentID        string          `json:"agentId"`
+	BrokerID       string          `json:"brokerId"`                 // Broker the agent is registered with
+	Revoked        bool            `json:"revoked"`                  // True if the latest update is a revocation
+	UpdatedAt      int64           `json:"updatedAt"`                // Timestamp of the signed update in milliseconds
+	PubKey         string          `json:"pubkey,omitempty"`         // Agent's key as of the update
+	PreviousPubKey string          `json:"previousPubkey,omitempty"` // Key the agent held before its latest key change
+	KeyChange      json.RawMessage `json:"keyChange,omitempty"`      // Envelope, signed by PreviousPubKey, that moved the agent to PubKey
+	Envelope       json.RawMessage `json:"envelope,omitempty"`       // Signed registerAgent, rotateKey or revoke envelope
+}
+
+// holds reports whether a key is in the record's key chain
+func (r RegistrationRecord) holds(key string) bool {
+	return key != "" && (key == r.PubKey || key == r.PreviousPubKey)
+}
+
+// ConflictKind describes how two registration records disagree
+type ConflictKind string
+
+const (
+	ConflictDuplicateRegistration ConflictKind = "duplicate_registration"
+	ConflictRevocation            ConflictKind = "conflicting_revocation"
+	ConflictKeyChange             ConflictKind = "key_change"
+)
+
+// ReconciliationDecision records how a registration conflict was resolved
+type ReconciliationDecision struct {
+	AgentID   string             `json:"agentId"`
+	Kind      ConflictKind       `json:"kind"`
+	Local     RegistrationRecord `json:"local"`
+	Remote    RegistrationRecord `json:"remote"`
+	Winner    string             `json:"winner"` // "local" or "remote"
+	Reason    string             `json:"reason"`
+	PeerID    string             `json:"peerId"`
+	Timestamp time.Time          `json:"timestamp"`
+}
+
+// RecordRegistration stores a locally accepted registerAgent envelope in the ledger
+func (fm *FederationManager) RecordRegistration(env *protocol.GenericEnvelope, pubKey string) {
+	fm.recordLocalUpdate(env.Agent, false, env, pubKey)
+}
+
+// RecordKeyRotation stores a locally accepted rotateKey envelope in the ledger
+func (fm *FederationManager) RecordKeyRotation(env *protocol.GenericEnvelope, newPubKey string) {
+	fm.recordLocalUpdate(env.Agent, false, env, newPubKey)
+}
+
+// RecordRevocation stores a locally accepted revoke envelope in the ledger.
+// The record keeps the target's key chain, so a later registration signed by
+// the agent can still be checked against it.
+func (fm *FederationManager) RecordRevocation(env *protocol.GenericEnvelope, target string) {
+	fm.recordLocalUpdate(target, true, env, "")
+}
+
+// SetRevocationAuthorities sets the keys, by signer ID, whose revocations
+// peers' records may carry besides the revoked agent's own
+func (fm *FederationManager) SetRevocationAuthorities(keys map[string]ed25519.PublicKey) {
+	fm.ledgerMutex.Lock()
+	defer fm.ledgerMutex.Unlock()
+	fm.revocationAuthorities = keys
+}
+
+func (fm *FederationManager) recordLocalUpdate(agentID string, revoked bool, env *protocol.GenericEnvelope, pubKey string) {
+	raw, err := json.Marshal(env)
+	if err != nil {
+		raw = nil
+	}
+
+	record := &RegistrationRecord{
+		AgentID:   agentID,
+		BrokerID:  fm.config.LocalBrokerID,
+		Revoked:   revoked,
+		UpdatedAt: env.TS,
+		PubKey:    pubKey,
+		Envelope:  raw,
+	}
+	fm.ledgerMutex.Lock()
+	if previous, exists := fm.registrationLedger[agentID]; exists {
+		extendKeyChain(record, *previous, env)
+	}
+	fm.registrationLedger[agentID] = record
+	fm.ledgerMutex.Unlock()
+
+	event := TransparencyRegistration
+	if revoked {
+		event = TransparencyRevocation
+	}
+	if _, err := fm.transparencyLog.Append(TransparencyEntry{
+		Event:    event,
+		AgentID:  agentID,
+		BrokerID: fm.config.LocalBrokerID,
+		LoggedAt: time.Now().UnixMilli(),
+		Envelope: raw,
+	}); err != nil {
+		log.Printf("Failed to append %s of %s to transparency log: %v", event, agentID, err)
+	}
+}
+
+// GetRegistrationRecords returns a snapshot of the ledger for sending to peers
+func (fm *FederationManager) GetRegistrationRecords() []RegistrationRecord {
+	fm.ledgerMutex.RLock()
+	defer fm.ledgerMutex.RUnlock()
+
+	records := make([]RegistrationRecord, 0, len(fm.registrationLedger))
+	for _, record := range fm.registrationLedger {
+		records = append(records, *record)
+	}
+	return records
+}
+
+// ReconcilePeerRegistrations merges registration records received from a peer
+// broker. Conflicting records are resolved deterministically so every broker in
+// the federation converges on the same state: the latest signed update wins.
+func (fm *FederationManager) ReconcilePeerRegistrations(peerID string, records []RegistrationRecord) []ReconciliationDecision {
+	decisions := make([]ReconciliationDecision, 0)
+	displaced := make([]string, 0)
+
+	fm.ledgerMutex.Lock()
+	authorities := fm.revocationAuthorities
+	for _, remote := range records {
+		local, exists := fm.registrationLedger[remote.AgentID]
+		if !exists {
+			// An agent new here is taken on the word of the broker it
+			// registered with, or of a revocation authority
+			signer, err := verifyRegistrationRecord(remote, authorities)
+			if err == nil && (signer == "" || remote.BrokerID == peerID) {
+				copied := remote
+				fm.registrationLedger[remote.AgentID] = &copied
+			}
+			continue
+		}
+
+		kind, conflicting := detectConflict(*local, remote)
+		if !conflicting {
+			// Later updates that agree with the record here are taken on
+			// quietly, so a registration signed by a rotated key reaches
+			// brokers that missed the rotation. The records of this
+			// broker's own agents only change through conflicts.
+			if local.BrokerID != fm.config.LocalBrokerID && remote.UpdatedAt > local.UpdatedAt {
+				if remoteWins, _ := resolveConflict(*local, remote, authorities); remoteWins {
+					fm.adoptRecord(*local, remote, authorities)
+				}
+			}
+			continue
+		}
+
+		remoteWins, reason := resolveConflict(*local, remote, authorities)
+		decision := ReconciliationDecision{
+			AgentID:   remote.AgentID,
+			Kind:      kind,
+			Local:     *local,
+			Remote:    remote,
+			Winner:    "local",
+			Reason:    reason,
+			PeerID:    peerID,
+			Timestamp: time.Now(),
+		}
+
+		if remoteWins {
+			decision.Winner = "remote"
+			fm.adoptRecord(*local, remote, authorities)
+
+			// The local registration is stale if the agent was revoked or moved
+			if local.BrokerID == fm.config.LocalBrokerID && !local.Revoked {
+				displaced = append(displaced, remote.AgentID)
+			}
+		}
+
+		decisions = append(decisions, decision)
+	}
+	fm.ledgerMutex.Unlock()
+
+	for _, agentID := range displaced {
+		fm.mcpRegistry.UnregisterAgent(agentID)
+	}
+
+	fm.logReconciliation(decisions)
+	return decisions
+}
+
+// adoptRecord replaces the ledger's record of an agent with a peer's. The
+// caller holds ledgerMutex.
+func (fm *FederationManager) adoptRecord(local, remote RegistrationRecord, authorities map[string]ed25519.PublicKey) {
+	if signer, _ := verifyRegistrationRecord(remote, authorities); signer == "" {
+		// A revocation by an authority vouches for none of the keys the
+		// record names; keep the chain known here
+		remote.PubKey, remote.PreviousPubKey, remote.KeyChange = local.PubKey, local.PreviousPubKey, local.KeyChange
+	}
+	fm.registrationLedger[remote.AgentID] = &remote
+}
+
+// GetReconciliationLog returns the recorded reconciliation decisions
+func (fm *FederationManager) GetReconciliationLog() []ReconciliationDecision {
+	fm.ledgerMutex.RLock()
+	defer fm.ledgerMutex.RUnlock()
+
+	decisions := make([]ReconciliationDecision, len(fm.reconciliationLog))
+	copy(decisions, fm.reconciliationLog)
+	return decisions
+}
+
+func (fm *FederationManager) logReconciliation(decisions []ReconciliationDecision) {
+	if len(decisions) == 0 {
+		return
+	}
+
+	for _, d := range decisions {
+		log.Printf("Reconciled %s for agent %s with peer %s: %s wins (%s)", d.Kind, d.AgentID, d.PeerID, d.Winner, d.Reason)
+	}
+
+	fm.ledgerMutex.Lock()
+	defer fm.ledgerMutex.Unlock()
+
+	fm.reconciliationLog = append(fm.reconciliationLog, decisions...)
+	if len(fm.reconciliationLog) > maxReconciliationLog {
+		fm.reconciliationLog = fm.reconciliationLog[len(fm.reconciliationLog)-maxReconciliationLog:]
+	}
+}
+
+// detectConflict reports whether two records for the same agent disagree
+func detectConflict(local, remote RegistrationRecord) (ConflictKind, bool) {
+	if local.Revoked != remote.Revoked {
+		return ConflictRevocation, true
+	}
+	if local.Revoked {
+		return "", false
+	}
+	if local.PubKey != remote.PubKey {
+		return ConflictKeyChange, true
+	}
+	if local.BrokerID != remote.BrokerID {
+		return ConflictDuplicateRegistration, true
+	}
+	return "", false
+}
+
+// resolveConflict applies the deterministic resolution rules and reports
+// whether the remote record wins. A remote record names its own keys, so it
+// only counts when signed by a revocation authority, by a key in the agent's
+// chain recorded here, or by a key it moved to from one: otherwise any peer
+// could forge a later registration or revocation of a local agent.
+func resolveConflict(local, remote RegistrationRecord, authorities map[string]ed25519.PublicKey) (bool, string) {
+	signer, err := verifyRegistrationRecord(remote, authorities)
+	if err != nil {
+		return false, "remote update does not carry a valid signature"
+	}
+	if signer != "" && !local.holds(signer) && !local.holds(remote.PreviousPubKey) {
+		return false, "remote update is not signed with a key recorded for the agent"
+	}
+
+	switch {
+	case !validRecord(local, authorities):
+		return true, "only the remote update carries a valid signature"
+	case local.UpdatedAt != remote.UpdatedAt:
+		return remote.UpdatedAt > local.UpdatedAt, "latest signed update wins"
+	case local.Revoked != remote.Revoked:
+		return remote.Revoked, "revocation wins a timestamp tie"
+	default:
+		return remote.BrokerID < local.BrokerID, "lowest broker ID wins a timestamp tie"
+	}
+}
+
+func validRecord(record RegistrationRecord, authorities map[string]ed25519.PublicKey) bool {
+	_, err := verifyRegistrationRecord(record, authorities)
+	return err == nil
+}
+
+// verifyRegistrationRecord checks that a record is backed by a matching signed
+// envelope and returns the agent key that signed it. Registrations and key
+// rotations are signed by the agent; revocations by the agent, or by one of
+// the authorities, for which the returned key is empty.
+func verifyRegistrationRecord(record RegistrationRecord, authorities map[string]ed25519.PublicKey) (string, error) {
+	if len(record.Envelope) == 0 {
+		return "", fmt.Errorf("record for %s is unsigned", record.AgentID)
+	}
+	if record.PreviousPubKey != "" {
+		if err := verifyKeyChange(record.KeyChange, record); err != nil {
+			return "", fmt.Errorf("key change of %s: %w", record.AgentID, err)
+		}
+	}
+
+	env, err := protocol.ParseEnvelope(record.Envelope)
+	if err != nil {
+		return "", err
+	}
+	if env.TS != record.UpdatedAt {
+		return "", fmt.Errorf("record timestamp does not match envelope")
+	}
+
+	switch env.Type {
+	case protocol.EnvelopeRegisterAgent, protocol.EnvelopeRotateKey:
+		if record.Revoked || env.Agent != record.AgentID {
+			return "", fmt.Errorf("registration envelope does not match record")
+		}
+		if verifyKeyChange(record.Envelope, record) == nil {
+			return record.PreviousPubKey, nil
+		}
+		if env.Type == protocol.EnvelopeRegisterAgent {
+			var body protocol.RegisterAgentBody
+			if err := env.GetBodyAs(&body); err != nil {
+				return "", err
+			}
+			if body.PubKey == record.PubKey {
+				return record.PubKey, verifyPeerIdentity(env, record.PubKey)
+			}
+		}
+		return "", fmt.Errorf("registration envelope does not match record")
+	case protocol.EnvelopeRevoke:
+		var body protocol.RevokeBody
+		if err := env.GetBodyAs(&body); err != nil {
+			return "", err
+		}
+		if !record.Revoked || body.Target != record.AgentID {
+			return "", fmt.Errorf("revocation envelope does not match record")
+		}
+		if env.Agent != record.AgentID {
+			authority, exists := authorities[env.Agent]
+			if !exists {
+				return "", fmt.Errorf("%s may not revoke agents", env.Agent)
+			}
+			return "", env.Verify(authority)
+		}
+		for _, key := range []string{record.PubKey, record.PreviousPubKey} {
+			if key != "" && verifyPeerIdentity(env, key) == nil {
+				return key, nil
+			}
+		}
+		return "", fmt.Errorf("revocation is not signed with the agent's key")
+	default:
+		return "", fmt.Errorf("unexpected envelope type %s", env.Type)
+	}
+}
+
+// verifyKeyChange checks that an envelope, signed by the record's previous
+// key, moved the agent to the record's key: a rotateKey envelope, or a
+// registration of the new key
+func verifyKeyChange(raw json.RawMessage, record RegistrationRecord) error {
+	if len(raw) == 0 {
+		return fmt.Errorf("no signed key change")
+	}
+	env, err := protocol.ParseEnvelope(raw)
+	if err != nil {
+		return err
+	}
+	if env.Agent != record.AgentID || record.PreviousPubKey == "" {
+		return fmt.Errorf("key change does not match record")
+	}
+	previous, err := protocol.DecodePublicKey(record.PreviousPubKey)
+	if err != nil {
+		return err
+	}
+
+	switch env.Type {
+	case protocol.EnvelopeRotateKey:
+		newKey, err := protocol.VerifyKeyRotation(env, previous)
+		if err != nil {
+			return err
+		}
+		if protocol.EncodePublicKey(newKey) != record.PubKey {
+			return fmt.Errorf("key change does not match record")
+		}
+		return nil
+	case protocol.EnvelopeRegisterAgent:
+		var body protocol.RegisterAgentBody
+		if err := env.GetBodyAs(&body); err != nil {
+			return err
+		}
+		if body.PubKey != record.PubKey {
+			return fmt.Errorf("key change does not match record")
+		}
+		return env.Verify(previous)
+	default:
+		return fmt.Errorf("unexpected envelope type %s", env.Type)
+	}
+}
+
+// extendKeyChain carries an agent's key chain from its previous record into
+// an update. A new key only joins the chain when the update moving the agent
+// to it is signed by the previous key; otherwise the chain starts afresh.
+func extendKeyChain(record *RegistrationRecord, previous RegistrationRecord, env *protocol.GenericEnvelope) {
+	switch {
+	case record.Revoked || record.PubKey == previous.PubKey:
+		record.PubKey = previous.PubKey
+		record.PreviousPubKey = previous.PreviousPubKey
+		record.KeyChange = previous.KeyChange
+	case previous.PubKey != "" && verifyPeerIdentity(env, previous.PubKey) == nil:
+		record.PreviousPubKey = previous.PubKey
+		record.KeyChange = record.Envelope
+	}
+}
+
+// syncablePeers returns the active peers trusted enough to sync state from
+func (fm *FederationManager) syncablePeers() []*FederatedBroker {
+	fm.topologyMutex.RLock()
+	defer fm.topologyMutex.RUnlock()
+	peers := make([]*FederatedBroker, 0, len(fm.federatedBrokers))
+	for _, broker := range fm.federatedBrokers {
+		if broker.TrustTier.AllowsDiscovery() && broker.Status == BrokerStatusActive {
+			peers = append(peers, broker)
+		}
+	}
+	return peers
+}
+
+// peerSyncClient returns the HTTP client used to pull state from peers
+func peerSyncClient() *http.Client {
+	return &http.Client{
+		Timeout: 10 * time.Second,
+		Transport: &http.Transport{
+			TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
+		},
+	}
+}
+
+// syncPeerRegistrations pulls registration records from trusted peers and reconciles them
+func (fm *FederationManager) syncPeerRegistrations() {
+	peers := fm.syncablePeers()
+	client := peerSyncClient()
+
+	for _, peer := range peers {
+		resp, err := client.Get(peer.Endpoint + "/federation/registrations")
+		if err != nil {
+			log.Printf("Registration sync with %s failed: %v", peer.ID, err)
+			continue
+		}
+
+		var records []RegistrationRecord
+		err = json.NewDecoder(resp.Body).Decode(&records)
+		resp.Body.Close()
+		if err != nil {
+			log.Printf("Invalid registration sync payload from %s: %v", peer.ID, err)
+			continue
+		}
+
+		fm.ReconcilePeerRegistrations(peer.ID, records)
+	}
+
+	if len(peers) > 0 {
+		fm.topologyMutex.Lock()
+		fm.peersSyncedAt = time.Now()
+		fm.topologyMutex.Unlock()
+	}
+}
+
+// peerSyncPending reports how many peers the broker must sync registrations
+// with before its view of the federation is complete. Once a sync round has
+// run, peers joining later do not make the broker unready.
+func (fm *FederationManager) peerSyncPending() int {
+	fm.topologyMutex.RLock()
+	defer fm.topologyMutex.RUnlock()
+	if !fm.peersSyncedAt.IsZero() {
+		return 0
+	}
+	pending := 0
+	for _, broker := range fm.federatedBrokers {
+		if broker.TrustTier.AllowsDiscovery() && broker.Status == BrokerStatusActive {
+			pending++
+		}
+	}
+	return pending
+}
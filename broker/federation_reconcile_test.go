@@ -0,0 +1,293 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"encoding/json"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/fep-fem/protocol"
+)
+
+// signedRegistrationRecord builds a registration record backed by a signed envelope
+func signedRegistrationRecord(t *testing.T, agentID, brokerID string, ts int64, privKey ed25519.PrivateKey) RegistrationRecord {
+	envelope := &protocol.RegisterAgentEnvelope{
+		BaseEnvelope: protocol.BaseEnvelope{
+			Type: protocol.EnvelopeRegisterAgent,
+			CommonHeaders: protocol.CommonHeaders{
+				Agent: agentID,
+				TS:    ts,
+				Nonce: brokerID,
+			},
+		},
+		Body: protocol.RegisterAgentBody{
+			PubKey:       protocol.EncodePublicKey(privKey.Public().(ed25519.PublicKey)),
+			Capabilities: []string{"math.add"},
+		},
+	}
+	if err := envelope.Sign(privKey); err != nil {
+		t.Fatalf("Failed to sign envelope: %v", err)
+	}
+	raw, err := json.Marshal(envelope)
+	if err != nil {
+		t.Fatalf("Failed to marshal envelope: %v", err)
+	}
+
+	return RegistrationRecord{
+		AgentID:   agentID,
+		BrokerID:  brokerID,
+		UpdatedAt: ts,
+		PubKey:    envelope.Body.PubKey,
+		Envelope:  raw,
+	}
+}
+
+func TestReconcileLatestSignedUpdateWins(t *testing.T) {
+	registry := NewMCPRegistry()
+	fm := NewFederationManager(registry, nil)
+
+	_, privKey, err := protocol.GenerateKeyPair()
+	if err != nil {
+		t.Fatalf("Failed to generate key pair: %v", err)
+	}
+
+	now := time.Now().UnixMilli()
+	local := signedRegistrationRecord(t, "agent-1", fm.config.LocalBrokerID, now-1000, privKey)
+	fm.registrationLedger["agent-1"] = &local
+	registry.RegisterAgent("agent-1", &MCPAgent{ID: "agent-1", Tools: []protocol.MCPTool{{Name: "math.add"}}})
+
+	remote := signedRegistrationRecord(t, "agent-1", "peer-1", now, privKey)
+	decisions := fm.ReconcilePeerRegistrations("peer-1", []RegistrationRecord{remote})
+
+	if len(decisions) != 1 {
+		t.Fatalf("Expected 1 decision, got %d", len(decisions))
+	}
+	if decisions[0].Kind != ConflictDuplicateRegistration {
+		t.Errorf("Expected duplicate registration conflict, got %s", decisions[0].Kind)
+	}
+	if decisions[0].Winner != "remote" {
+		t.Errorf("Expected newer remote record to win, got %s", decisions[0].Winner)
+	}
+	if _, exists := registry.GetAgent("agent-1"); exists {
+		t.Error("Stale local registration should have been removed")
+	}
+	if len(fm.GetReconciliationLog()) != 1 {
+		t.Error("Expected decision to be logged")
+	}
+}
+
+func TestReconcileUnsignedRecordLoses(t *testing.T) {
+	fm := NewFederationManager(NewMCPRegistry(), nil)
+
+	_, privKey, err := protocol.GenerateKeyPair()
+	if err != nil {
+		t.Fatalf("Failed to generate key pair: %v", err)
+	}
+
+	now := time.Now().UnixMilli()
+	local := signedRegistrationRecord(t, "agent-1", fm.config.LocalBrokerID, now-1000, privKey)
+	fm.registrationLedger["agent-1"] = &local
+
+	// Newer but unsigned revocation must not override a signed registration
+	remote := RegistrationRecord{AgentID: "agent-1", BrokerID: "peer-1", Revoked: true, UpdatedAt: now}
+	decisions := fm.ReconcilePeerRegistrations("peer-1", []RegistrationRecord{remote})
+
+	if len(decisions) != 1 || decisions[0].Winner != "local" {
+		t.Fatalf("Expected local record to win, got %+v", decisions)
+	}
+	if fm.registrationLedger["agent-1"].Revoked {
+		t.Error("Ledger should keep the signed registration")
+	}
+}
+
+func TestReconcileRefusesRecordsSignedWithAnotherKey(t *testing.T) {
+	registry := NewMCPRegistry()
+	fm := NewFederationManager(registry, nil)
+
+	_, agentKey, _ := protocol.GenerateKeyPair()
+	_, forgerKey, _ := protocol.GenerateKeyPair()
+
+	now := time.Now().UnixMilli()
+	local := signedRegistrationRecord(t, "agent-1", fm.config.LocalBrokerID, now-1000, agentKey)
+	fm.registrationLedger["agent-1"] = &local
+	registry.RegisterAgent("agent-1", &MCPAgent{ID: "agent-1", Tools: []protocol.MCPTool{{Name: "math.add"}}})
+
+	// A later registration elsewhere, validly signed but by a key the agent never used
+	remote := signedRegistrationRecord(t, "agent-1", "peer-1", now, forgerKey)
+	decisions := fm.ReconcilePeerRegistrations("peer-1", []RegistrationRecord{remote})
+
+	if len(decisions) != 1 || decisions[0].Winner != "local" {
+		t.Fatalf("Expected local record to win, got %+v", decisions)
+	}
+	if _, exists := registry.GetAgent("agent-1"); !exists {
+		t.Error("A record signed with another key must not displace the local agent")
+	}
+	if fm.registrationLedger["agent-1"].BrokerID != fm.config.LocalBrokerID {
+		t.Error("Ledger should keep the local registration")
+	}
+}
+
+// signedRevocationRecord builds a revocation record backed by an envelope the
+// revoker signs
+func signedRevocationRecord(t *testing.T, revoker, target, brokerID string, privKey ed25519.PrivateKey) RegistrationRecord {
+	envelope := protocol.NewTypedEnvelope(revoker, protocol.RevokeBody{Target: target})
+	if err := envelope.Sign(privKey); err != nil {
+		t.Fatalf("Failed to sign envelope: %v", err)
+	}
+	raw, err := json.Marshal(envelope)
+	if err != nil {
+		t.Fatalf("Failed to marshal envelope: %v", err)
+	}
+	return RegistrationRecord{AgentID: target, BrokerID: brokerID, Revoked: true, UpdatedAt: envelope.TS, Envelope: raw}
+}
+
+func TestReconcileSignedRevocationWins(t *testing.T) {
+	registry := NewMCPRegistry()
+	fm := NewFederationManager(registry, nil)
+	adminPub, adminKey, _ := protocol.GenerateKeyPair()
+	_, agentKey, _ := protocol.GenerateKeyPair()
+	_, otherKey, _ := protocol.GenerateKeyPair()
+	fm.SetRevocationAuthorities(map[string]ed25519.PublicKey{"admin": adminPub})
+
+	local := signedRegistrationRecord(t, "agent-1", fm.config.LocalBrokerID, time.Now().UnixMilli()-1000, agentKey)
+	fm.registrationLedger["agent-1"] = &local
+	registry.RegisterAgent("agent-1", &MCPAgent{ID: "agent-1", Tools: []protocol.MCPTool{{Name: "math.add"}}})
+
+	// Revokers that are neither the agent nor an authority are ignored
+	forged := signedRevocationRecord(t, "mallory", "agent-1", "peer-1", otherKey)
+	if decisions := fm.ReconcilePeerRegistrations("peer-1", []RegistrationRecord{forged}); len(decisions) != 1 || decisions[0].Winner != "local" {
+		t.Fatalf("Expected a revocation by another agent to lose, got %+v", decisions)
+	}
+
+	// A later revocation signed by an authority wins, whatever key it names
+	remote := signedRevocationRecord(t, "admin", "agent-1", "peer-1", adminKey)
+	remote.PubKey = protocol.EncodePublicKey(otherKey.Public().(ed25519.PublicKey))
+	decisions := fm.ReconcilePeerRegistrations("peer-1", []RegistrationRecord{remote})
+	if len(decisions) != 1 || decisions[0].Kind != ConflictRevocation || decisions[0].Winner != "remote" {
+		t.Fatalf("Expected the signed revocation to win, got %+v", decisions)
+	}
+	if _, exists := registry.GetAgent("agent-1"); exists {
+		t.Error("Revoked agent should have been removed")
+	}
+	record := fm.registrationLedger["agent-1"]
+	if !record.Revoked || record.PubKey != local.PubKey {
+		t.Errorf("Expected the revocation recorded with the agent's own key, got %+v", record)
+	}
+
+	// The agent may revoke itself
+	fm.registrationLedger["agent-1"] = &local
+	self := signedRevocationRecord(t, "agent-1", "agent-1", "peer-1", agentKey)
+	self.PubKey = local.PubKey
+	if decisions := fm.ReconcilePeerRegistrations("peer-1", []RegistrationRecord{self}); len(decisions) != 1 || decisions[0].Winner != "remote" {
+		t.Errorf("Expected the agent's own revocation to win, got %+v", decisions)
+	}
+}
+
+func TestReconcileRotatedAgent(t *testing.T) {
+	peer := NewBroker()
+	oldPub, oldKey, _ := protocol.GenerateKeyPair()
+	newPub, newKey, _ := protocol.GenerateKeyPair()
+	register := func(pub ed25519.PublicKey, signer ed25519.PrivateKey) {
+		envelope := protocol.NewTypedEnvelope("agent-1", protocol.RegisterAgentBody{PubKey: protocol.EncodePublicKey(pub)})
+		envelope.Sign(signer)
+		postEnvelope(t, peer, envelope)
+	}
+	peerID := peer.federation.config.LocalBrokerID
+	newFederation := func() *FederationManager {
+		fm := NewFederationManager(NewMCPRegistry(), nil)
+		fm.config.LocalBrokerID = "broker-b"
+		return fm
+	}
+
+	// Both brokers start out knowing the agent's first key
+	register(oldPub, oldKey)
+	registered := peer.federation.GetRegistrationRecords()
+	fm, stale := newFederation(), newFederation()
+	fm.ReconcilePeerRegistrations(peerID, registered)
+	stale.ReconcilePeerRegistrations(peerID, registered)
+	if record := fm.registrationLedger["agent-1"]; record == nil || record.PubKey != protocol.EncodePublicKey(oldPub) {
+		t.Fatalf("Expected the peer's registration taken on, got %+v", record)
+	}
+
+	// The rotation, signed by the old key, moves the agent to the new one
+	time.Sleep(2 * time.Millisecond)
+	rotation, _ := protocol.NewKeyRotation("agent-1", oldKey, newKey, "")
+	postEnvelope(t, peer, rotation)
+	decisions := fm.ReconcilePeerRegistrations(peerID, peer.federation.GetRegistrationRecords())
+	if len(decisions) != 1 || decisions[0].Kind != ConflictKeyChange || decisions[0].Winner != "remote" {
+		t.Fatalf("Expected the rotation to win, got %+v", decisions)
+	}
+	if record := fm.registrationLedger["agent-1"]; record.PubKey != protocol.EncodePublicKey(newPub) || record.PreviousPubKey != protocol.EncodePublicKey(oldPub) {
+		t.Fatalf("Expected the rotated key chain, got %+v", record)
+	}
+
+	// A registration signed by the new key still carries the rotation, so a
+	// broker that missed it reconciles too
+	time.Sleep(2 * time.Millisecond)
+	register(newPub, newKey)
+	records := peer.federation.GetRegistrationRecords()
+	for _, fm := range []*FederationManager{fm, stale} {
+		fm.ReconcilePeerRegistrations(peerID, records)
+		if record := fm.registrationLedger["agent-1"]; record.PubKey != protocol.EncodePublicKey(newPub) || record.UpdatedAt != records[0].UpdatedAt {
+			t.Errorf("Expected the registration with the new key, got %+v", record)
+		}
+	}
+}
+
+func TestResolveConflictIsDeterministic(t *testing.T) {
+	_, privKey, _ := protocol.GenerateKeyPair()
+	a := signedRegistrationRecord(t, "agent-1", "broker-a", 100, privKey)
+	b := signedRegistrationRecord(t, "agent-1", "broker-b", 100, privKey)
+
+	aWinsFromB, _ := resolveConflict(b, a, nil)
+	bWinsFromA, _ := resolveConflict(a, b, nil)
+	if !aWinsFromB || bWinsFromA {
+		t.Error("Both sides should agree that broker-a wins the tie")
+	}
+}
+
+func TestRevocationIsTimestamped(t *testing.T) {
+	tsaPub, tsaPriv, _ := protocol.GenerateKeyPair()
+	broker := NewBroker()
+	broker.SetTimestampAuthority(protocol.NewEd25519TimestampAuthority("test-tsa", tsaPriv))
+
+	env := &protocol.GenericEnvelope{
+		BaseEnvelope: protocol.BaseEnvelope{
+			Type: protocol.EnvelopeRevoke,
+			CommonHeaders: protocol.CommonHeaders{
+				Agent: "admin",
+				TS:    time.Now().UnixMilli(),
+				Nonce: "revoke-1",
+			},
+		},
+		Body: json.RawMessage(`{"target":"rogue-agent","reason":"compromised"}`),
+	}
+
+	recorder := httptest.NewRecorder()
+	broker.handleRevoke(recorder, env)
+
+	var response struct {
+		Timestamp *protocol.TimestampToken `json:"timestamp"`
+	}
+	if err := readAck(t, recorder.Body).ResultAs(&response); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if response.Timestamp == nil {
+		t.Fatal("Expected revocation response to carry a timestamp")
+	}
+
+	// The ledger keeps the timestamped envelope for peers to verify
+	var recorded *protocol.GenericEnvelope
+	for _, record := range broker.federation.GetRegistrationRecords() {
+		if record.AgentID == "rogue-agent" {
+			recorded, _ = protocol.ParseEnvelope(record.Envelope)
+		}
+	}
+	if recorded == nil {
+		t.Fatal("Expected revocation to be recorded")
+	}
+	if err := recorded.VerifyTimestamp(protocol.NewEd25519TimestampVerifier("test-tsa", tsaPub)); err != nil {
+		t.Errorf("Recorded revocation timestamp should verify: %v", err)
+	}
+}
@@ -0,0 +1,319 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/fep-fem/protocol"
+)
+
+// PeerTrustTier controls how much of a federated broker's catalog is exposed locally
+type PeerTrustTier string
+
+const (
+	// PeerTrustFull peers have their tools discoverable and routable
+	PeerTrustFull PeerTrustTier = "full"
+	// PeerTrustDiscovery peers have their tools discoverable but never routed to
+	PeerTrustDiscovery PeerTrustTier = "discovery"
+	// PeerTrustUntrusted peers have their tools hidden entirely
+	PeerTrustUntrusted PeerTrustTier = "untrusted"
+)
+
+// ParsePeerTrustTier converts a string into a known trust tier
+func ParsePeerTrustTier(value string) (PeerTrustTier, error) {
+	switch tier := PeerTrustTier(value); tier {
+	case PeerTrustFull, PeerTrustDiscovery, PeerTrustUntrusted:
+		return tier, nil
+	case "":
+		return PeerTrustUntrusted, nil
+	default:
+		return "", fmt.Errorf("unknown peer trust tier: %s", value)
+	}
+}
+
+// AllowsDiscovery reports whether tools from peers in this tier appear in discovery
+func (t PeerTrustTier) AllowsDiscovery() bool {
+	return t == PeerTrustFull || t == PeerTrustDiscovery
+}
+
+// AllowsRouting reports whether tool calls may be routed to peers in this tier
+func (t PeerTrustTier) AllowsRouting() bool {
+	return t == PeerTrustFull
+}
+
+// RequiresIdentity reports whether peers in this tier must present a verified identity
+func (t PeerTrustTier) RequiresIdentity() bool {
+	return t.AllowsDiscovery()
+}
+
+// AddFederatedBroker registers a peer broker from its signed registerBroker envelope.
+// Peers placed in a tier above untrusted must present a valid signature made with
+// the public key they advertise. The tier only applies to new peers: known peers
+// keep the tier they have, and once a peer proved its identity every update must
+// be signed with the same key.
+func (fm *FederationManager) AddFederatedBroker(env *protocol.GenericEnvelope, tier PeerTrustTier) (*FederatedBroker, error) {
+	registration, err := protocol.ParseTyped[protocol.RegisterBrokerBody](env)
+	if err != nil {
+		return nil, fmt.Errorf("invalid broker registration: %w", err)
+	}
+	body := registration.Body
+
+	brokerID := body.BrokerID
+	if brokerID == "" {
+		brokerID = env.Agent
+	}
+	if brokerID == "" {
+		return nil, fmt.Errorf("broker registration missing broker ID")
+	}
+
+	tier, err = ParsePeerTrustTier(string(tier))
+	if err != nil {
+		return nil, err
+	}
+
+	verified := verifyPeerIdentity(env, body.PubKey) == nil
+
+	fm.topologyMutex.Lock()
+	defer fm.topologyMutex.Unlock()
+
+	existing, exists := fm.federatedBrokers[brokerID]
+	if !exists && fm.config.MaxBrokers > 0 && len(fm.federatedBrokers) >= fm.config.MaxBrokers {
+		return nil, fmt.Errorf("federation limit of %d brokers reached", fm.config.MaxBrokers)
+	}
+
+	if exists {
+		// A peer that already proved its identity cannot silently swap keys,
+		// nor be updated by anyone who merely knows its public key
+		if existing.IdentityVerified && existing.PublicKey != body.PubKey {
+			return nil, fmt.Errorf("broker %s presented a different public key", brokerID)
+		}
+		if existing.IdentityVerified && !verified {
+			return nil, fmt.Errorf("broker %s must sign updates with its verified identity", brokerID)
+		}
+		tier = existing.TrustTier
+	}
+	if tier.RequiresIdentity() && !verified {
+		return nil, fmt.Errorf("broker %s must present a verifiable identity for trust tier %s", brokerID, tier)
+	}
+
+	broker := &FederatedBroker{
+		ID:               brokerID,
+		Endpoint:         body.Endpoint,
+		PublicKey:        body.PubKey,
+		LastSeen:         time.Now(),
+		Status:           BrokerStatusActive,
+		Capabilities:     body.Capabilities,
+		TrustTier:        tier,
+		IdentityVerified: verified,
+		ProtocolVersion:  env.Proto,
+	}
+	if exists {
+		broker.TrustScore = existing.TrustScore
+		broker.ResponseTime = existing.ResponseTime
+		broker.ToolCount = existing.ToolCount
+		broker.LoadScore = existing.LoadScore
+	}
+
+	fm.federatedBrokers[brokerID] = broker
+	return broker, nil
+}
+
+// SetPeerTrustTier changes the trust tier of a known peer broker
+func (fm *FederationManager) SetPeerTrustTier(brokerID string, tier PeerTrustTier) error {
+	tier, err := ParsePeerTrustTier(string(tier))
+	if err != nil {
+		return err
+	}
+
+	fm.topologyMutex.Lock()
+	defer fm.topologyMutex.Unlock()
+
+	broker, exists := fm.federatedBrokers[brokerID]
+	if !exists {
+		return fmt.Errorf("unknown broker: %s", brokerID)
+	}
+
+	if tier.RequiresIdentity() && !broker.IdentityVerified {
+		return fmt.Errorf("broker %s has no verified identity; cannot promote to %s", brokerID, tier)
+	}
+
+	broker.TrustTier = tier
+	return nil
+}
+
+// RemoveFederatedBroker drops a peer broker and the tools it advertised
+func (fm *FederationManager) RemoveFederatedBroker(brokerID string) error {
+	fm.topologyMutex.Lock()
+	defer fm.topologyMutex.Unlock()
+
+	if _, exists := fm.federatedBrokers[brokerID]; !exists {
+		return fmt.Errorf("unknown broker: %s", brokerID)
+	}
+	delete(fm.federatedBrokers, brokerID)
+	delete(fm.peerCatalogs, brokerID)
+	return nil
+}
+
+// ListFederatedBrokers returns copies of the known peer brokers
+func (fm *FederationManager) ListFederatedBrokers() []FederatedBroker {
+	fm.topologyMutex.RLock()
+	defer fm.topologyMutex.RUnlock()
+
+	peers := make([]FederatedBroker, 0, len(fm.federatedBrokers))
+	for _, broker := range fm.federatedBrokers {
+		peers = append(peers, *broker)
+	}
+	return peers
+}
+
+// UpdatePeerCatalog replaces the set of tools advertised by a peer broker
+func (fm *FederationManager) UpdatePeerCatalog(brokerID string, tools []protocol.DiscoveredTool) error {
+	fm.topologyMutex.Lock()
+	defer fm.topologyMutex.Unlock()
+
+	broker, exists := fm.federatedBrokers[brokerID]
+	if !exists {
+		return fmt.Errorf("unknown broker: %s", brokerID)
+	}
+
+	fm.peerCatalogs[brokerID] = tools
+	broker.ToolCount = 0
+	for _, tool := range tools {
+		broker.ToolCount += len(tool.MCPTools)
+	}
+	return nil
+}
+
+// DiscoverRemoteTools returns peer-advertised tools visible under the peers' trust tiers
+func (fm *FederationManager) DiscoverRemoteTools(query protocol.ToolQuery) []protocol.DiscoveredTool {
+	fm.topologyMutex.RLock()
+	defer fm.topologyMutex.RUnlock()
+
+	results := make([]protocol.DiscoveredTool, 0)
+	for brokerID, tools := range fm.peerCatalogs {
+		broker, exists := fm.federatedBrokers[brokerID]
+		if !exists || !broker.TrustTier.AllowsDiscovery() {
+			continue
+		}
+
+		for _, tool := range tools {
+			if query.EnvironmentType != "" && tool.EnvironmentType != query.EnvironmentType {
+				continue
+			}
+			if !query.Hardware.Matches(tool.Hardware) {
+				continue
+			}
+
+			matching := make([]protocol.MCPTool, 0, len(tool.MCPTools))
+			for _, mcpTool := range tool.MCPTools {
+				if fm.matchesAnyCapability(mcpTool.Name, query.Capabilities) {
+					matching = append(matching, mcpTool)
+				}
+			}
+			if len(matching) == 0 {
+				continue
+			}
+
+			remote := tool
+			remote.MCPTools = matching
+			results = append(results, remote)
+		}
+	}
+
+	return results
+}
+
+// routableRemoteAgents returns the IDs of peer-hosted agents that may serve a tool
+func (fm *FederationManager) routableRemoteAgents(toolName string) []string {
+	fm.topologyMutex.RLock()
+	defer fm.topologyMutex.RUnlock()
+
+	agents := make([]string, 0)
+	for brokerID, tools := range fm.peerCatalogs {
+		broker, exists := fm.federatedBrokers[brokerID]
+		if !exists || !broker.TrustTier.AllowsRouting() || broker.Status != BrokerStatusActive {
+			continue
+		}
+
+		for _, tool := range tools {
+			for _, mcpTool := range tool.MCPTools {
+				if mcpTool.Name == toolName {
+					agents = append(agents, tool.AgentID)
+					break
+				}
+			}
+		}
+	}
+
+	return agents
+}
+
+// matchesAnyCapability applies the registry's capability pattern rules to a tool name
+func (fm *FederationManager) matchesAnyCapability(toolName string, capabilities []string) bool {
+	if len(capabilities) == 0 {
+		return true
+	}
+	for _, pattern := range capabilities {
+		if fm.mcpRegistry.matchCapability(toolName, pattern) {
+			return true
+		}
+	}
+	return false
+}
+
+// verifyPeerIdentity checks that an envelope is signed by the advertised public key
+func verifyPeerIdentity(env *protocol.GenericEnvelope, encodedKey string) error {
+	if encodedKey == "" {
+		return fmt.Errorf("no public key presented")
+	}
+	publicKey, err := protocol.DecodePublicKey(encodedKey)
+	if err != nil {
+		return err
+	}
+	return env.Verify(publicKey)
+}
+
+// peerSummary is how the admin API lists a peer broker
+type peerSummary struct {
+	ID               string        `json:"id"`
+	Endpoint         string        `json:"endpoint"`
+	Status           BrokerStatus  `json:"status"`
+	TrustTier        PeerTrustTier `json:"trustTier"`
+	IdentityVerified bool          `json:"identityVerified"`
+	ToolCount        int           `json:"toolCount"`
+	LastSeen         time.Time     `json:"lastSeen"`
+}
+
+// handleAdminPeers lists the federation's peer brokers and removes them
+func (b *Broker) handleAdminPeers(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		peers := []peerSummary{}
+		for _, peer := range b.federation.ListFederatedBrokers() {
+			peers = append(peers, peerSummary{
+				ID:               peer.ID,
+				Endpoint:         peer.Endpoint,
+				Status:           peer.Status,
+				TrustTier:        peer.TrustTier,
+				IdentityVerified: peer.IdentityVerified,
+				ToolCount:        peer.ToolCount,
+				LastSeen:         peer.LastSeen,
+			})
+		}
+		sort.Slice(peers, func(i, j int) bool { return peers[i].ID < peers[j].ID })
+		writeJSON(w, http.StatusOK, peers)
+	case http.MethodDelete:
+		brokerID := r.URL.Query().Get("broker")
+		if err := b.federation.RemoveFederatedBroker(brokerID); err != nil {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		log.Printf("Removed federation peer %s", brokerID)
+		writeJSON(w, http.StatusOK, map[string]interface{}{"status": "removed", "broker": brokerID})
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/fep-fem/protocol"
+)
+
+// newSignedBrokerRegistration builds a registerBroker envelope signed by a fresh key
+func newSignedBrokerRegistration(t *testing.T, brokerID string) *protocol.GenericEnvelope {
+	pubKey, privKey, err := protocol.GenerateKeyPair()
+	if err != nil {
+		t.Fatalf("Failed to generate key pair: %v", err)
+	}
+
+	envelope := &protocol.RegisterBrokerEnvelope{
+		BaseEnvelope: protocol.BaseEnvelope{
+			Type: protocol.EnvelopeRegisterBroker,
+			CommonHeaders: protocol.CommonHeaders{
+				Agent: brokerID,
+				TS:    time.Now().UnixMilli(),
+				Nonce: brokerID + "-nonce",
+			},
+		},
+		Body: protocol.RegisterBrokerBody{
+			BrokerID:     brokerID,
+			Endpoint:     "https://" + brokerID + ".example.com",
+			PubKey:       protocol.EncodePublicKey(pubKey),
+			Capabilities: []string{"federation"},
+		},
+	}
+	if err := envelope.Sign(privKey); err != nil {
+		t.Fatalf("Failed to sign envelope: %v", err)
+	}
+
+	data, err := json.Marshal(envelope)
+	if err != nil {
+		t.Fatalf("Failed to marshal envelope: %v", err)
+	}
+	generic, err := protocol.ParseEnvelope(data)
+	if err != nil {
+		t.Fatalf("Failed to parse envelope: %v", err)
+	}
+	return generic
+}
+
+func TestPeerTrustTierPermissions(t *testing.T) {
+	tests := []struct {
+		tier      PeerTrustTier
+		discovery bool
+		routing   bool
+	}{
+		{PeerTrustFull, true, true},
+		{PeerTrustDiscovery, true, false},
+		{PeerTrustUntrusted, false, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(string(tt.tier), func(t *testing.T) {
+			if tt.tier.AllowsDiscovery() != tt.discovery {
+				t.Errorf("AllowsDiscovery: expected %t", tt.discovery)
+			}
+			if tt.tier.AllowsRouting() != tt.routing {
+				t.Errorf("AllowsRouting: expected %t", tt.routing)
+			}
+		})
+	}
+
+	if _, err := ParsePeerTrustTier("bogus"); err == nil {
+		t.Error("Expected error for unknown trust tier")
+	}
+}
+
+func TestAddFederatedBrokerRequiresIdentity(t *testing.T) {
+	fm := NewFederationManager(NewMCPRegistry(), nil)
+
+	env := newSignedBrokerRegistration(t, "peer-1")
+	peer, err := fm.AddFederatedBroker(env, PeerTrustFull)
+	if err != nil {
+		t.Fatalf("Expected signed peer to be accepted: %v", err)
+	}
+	if !peer.IdentityVerified {
+		t.Error("Expected peer identity to be verified")
+	}
+
+	// Tamper with the signature
+	forged := newSignedBrokerRegistration(t, "peer-2")
+	forged.Sig = newSignedBrokerRegistration(t, "peer-3").Sig
+	if _, err := fm.AddFederatedBroker(forged, PeerTrustDiscovery); err == nil {
+		t.Error("Expected unverifiable peer to be rejected for discovery tier")
+	}
+
+	// Untrusted peers are accepted but cannot be promoted
+	peer, err = fm.AddFederatedBroker(forged, PeerTrustUntrusted)
+	if err != nil {
+		t.Fatalf("Expected untrusted peer to be accepted: %v", err)
+	}
+	if peer.IdentityVerified {
+		t.Error("Forged peer should not be verified")
+	}
+	if err := fm.SetPeerTrustTier(peer.ID, PeerTrustFull); err == nil {
+		t.Error("Expected promotion of unverified peer to fail")
+	}
+}
+
+func TestVerifiedPeerUpdatesMustBeSigned(t *testing.T) {
+	fm := NewFederationManager(NewMCPRegistry(), nil)
+
+	env := newSignedBrokerRegistration(t, "peer-1")
+	if _, err := fm.AddFederatedBroker(env, PeerTrustDiscovery); err != nil {
+		t.Fatalf("Failed to add peer: %v", err)
+	}
+	if err := fm.SetPeerTrustTier("peer-1", PeerTrustFull); err != nil {
+		t.Fatal(err)
+	}
+
+	// Knowing the peer's public key is not enough to redirect or demote it
+	hijack := *env
+	hijack.Body = json.RawMessage(strings.Replace(string(env.Body), "https://peer-1.example.com", "https://attacker.example.com", 1))
+	hijack.Sig = ""
+	if _, err := fm.AddFederatedBroker(&hijack, PeerTrustUntrusted); err == nil {
+		t.Error("Expected an unsigned update of a verified peer to be refused")
+	}
+
+	// A signed re-registration keeps the tier an operator gave the peer
+	peer, err := fm.AddFederatedBroker(env, PeerTrustUntrusted)
+	if err != nil {
+		t.Fatalf("Expected a signed re-registration to be accepted: %v", err)
+	}
+	if peer.TrustTier != PeerTrustFull || !peer.IdentityVerified || peer.Endpoint != "https://peer-1.example.com" {
+		t.Errorf("Expected the peer unchanged, got %+v", peer)
+	}
+}
+
+func TestRemoteToolVisibilityByTier(t *testing.T) {
+	fm := NewFederationManager(NewMCPRegistry(), nil)
+
+	catalog := []protocol.DiscoveredTool{
+		{
+			AgentID:  "remote-agent",
+			MCPTools: []protocol.MCPTool{{Name: "math.add"}},
+		},
+	}
+
+	env := newSignedBrokerRegistration(t, "peer-1")
+	if _, err := fm.AddFederatedBroker(env, PeerTrustUntrusted); err != nil {
+		t.Fatalf("Failed to add peer: %v", err)
+	}
+	if err := fm.UpdatePeerCatalog("peer-1", catalog); err != nil {
+		t.Fatalf("Failed to update catalog: %v", err)
+	}
+
+	query := protocol.ToolQuery{Capabilities: []string{"math.*"}}
+
+	if tools := fm.DiscoverRemoteTools(query); len(tools) != 0 {
+		t.Errorf("Untrusted peer tools should be hidden, got %d", len(tools))
+	}
+
+	fm.SetPeerTrustTier("peer-1", PeerTrustDiscovery)
+	if tools := fm.DiscoverRemoteTools(query); len(tools) != 1 {
+		t.Errorf("Expected 1 discoverable remote tool, got %d", len(tools))
+	}
+	if agents := fm.routableRemoteAgents("math.add"); len(agents) != 0 {
+		t.Errorf("Discovery-only peer should not be routable, got %v", agents)
+	}
+
+	fm.SetPeerTrustTier("peer-1", PeerTrustFull)
+	if agents := fm.routableRemoteAgents("math.add"); len(agents) != 1 || agents[0] != "remote-agent" {
+		t.Errorf("Expected remote-agent to be routable, got %v", agents)
+	}
+}
@@ -0,0 +1,199 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/fep-fem/protocol"
+	"github.com/oschwald/maxminddb-golang"
+)
+
+// GeoValueKey is the EnvelopeContext value holding the sender's GeoLocation
+const GeoValueKey = "geo"
+
+// GeoLocation is where an address is, as far as the GeoIP databases know
+type GeoLocation struct {
+	Country   string `json:"country,omitempty"`   // ISO 3166-1 alpha-2 code, e.g. "DE"
+	Continent string `json:"continent,omitempty"` // Continent code, e.g. "EU"
+	City      string `json:"city,omitempty"`
+	ASN       uint   `json:"asn,omitempty"`
+	ASOrg     string `json:"asOrg,omitempty"`
+}
+
+// Region returns the routing region, e.g. "eu-de", or the continent alone
+// when the country is unknown
+func (l GeoLocation) Region() string {
+	switch {
+	case l.Continent != "" && l.Country != "":
+		return strings.ToLower(l.Continent + "-" + l.Country)
+	default:
+		return strings.ToLower(l.Continent)
+	}
+}
+
+// Empty reports whether nothing is known about the address
+func (l GeoLocation) Empty() bool {
+	return l == GeoLocation{}
+}
+
+// GeoLocator resolves addresses to locations
+type GeoLocator interface {
+	Locate(ip net.IP) (GeoLocation, error)
+}
+
+// MaxMindLocator reads offline MaxMind (GeoIP2/GeoLite2) City and ASN databases
+type MaxMindLocator struct {
+	city *maxminddb.Reader
+	asn  *maxminddb.Reader
+}
+
+// maxMindCity is the part of a City record the broker uses
+type maxMindCity struct {
+	City struct {
+		Names map[string]string `maxminddb:"names"`
+	} `maxminddb:"city"`
+	Continent struct {
+		Code string `maxminddb:"code"`
+	} `maxminddb:"continent"`
+	Country struct {
+		ISOCode string `maxminddb:"iso_code"`
+	} `maxminddb:"country"`
+}
+
+// maxMindASN is an ASN record
+type maxMindASN struct {
+	Number       uint   `maxminddb:"autonomous_system_number"`
+	Organization string `maxminddb:"autonomous_system_organization"`
+}
+
+// OpenMaxMindLocator opens a City database, an ASN database, or both; an
+// empty path skips that database
+func OpenMaxMindLocator(cityPath, asnPath string) (*MaxMindLocator, error) {
+	if cityPath == "" && asnPath == "" {
+		return nil, fmt.Errorf("no GeoIP database given")
+	}
+	locator := &MaxMindLocator{}
+	if cityPath != "" {
+		reader, err := maxminddb.Open(cityPath)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open GeoIP city database: %w", err)
+		}
+		locator.city = reader
+	}
+	if asnPath != "" {
+		reader, err := maxminddb.Open(asnPath)
+		if err != nil {
+			locator.Close()
+			return nil, fmt.Errorf("failed to open GeoIP ASN database: %w", err)
+		}
+		locator.asn = reader
+	}
+	return locator, nil
+}
+
+// Locate looks the address up in each open database
+func (m *MaxMindLocator) Locate(ip net.IP) (GeoLocation, error) {
+	var location GeoLocation
+	if m.city != nil {
+		var record maxMindCity
+		if err := m.city.Lookup(ip, &record); err != nil {
+			return GeoLocation{}, fmt.Errorf("city lookup failed: %w", err)
+		}
+		location.Country = record.Country.ISOCode
+		location.Continent = record.Continent.Code
+		location.City = record.City.Names["en"]
+	}
+	if m.asn != nil {
+		var record maxMindASN
+		if err := m.asn.Lookup(ip, &record); err != nil {
+			return GeoLocation{}, fmt.Errorf("ASN lookup failed: %w", err)
+		}
+		location.ASN = record.Number
+		location.ASOrg = record.Organization
+	}
+	return location, nil
+}
+
+// Close releases the databases
+func (m *MaxMindLocator) Close() error {
+	var firstErr error
+	for _, reader := range []*maxminddb.Reader{m.city, m.asn} {
+		if reader != nil {
+			if err := reader.Close(); err != nil && firstErr == nil {
+				firstErr = err
+			}
+		}
+	}
+	return firstErr
+}
+
+// GeoEnricher is middleware annotating envelopes with the sender's location.
+// Registrations also record the agent's location and routing region.
+type GeoEnricher struct {
+	broker  *Broker
+	locator GeoLocator
+}
+
+// NewGeoEnricher creates the enrichment middleware
+func NewGeoEnricher(broker *Broker, locator GeoLocator) *GeoEnricher {
+	return &GeoEnricher{broker: broker, locator: locator}
+}
+
+// BeforeDispatch stores the sender's location in ctx.Values[GeoValueKey]. It
+// never rejects: an address the databases do not know is simply not annotated.
+func (g *GeoEnricher) BeforeDispatch(ctx *EnvelopeContext) error {
+	ip := net.ParseIP(ctx.Transport.SourceIP)
+	if ip == nil || ip.IsLoopback() || ip.IsPrivate() || ip.IsUnspecified() {
+		return nil
+	}
+	location, err := g.locator.Locate(ip)
+	if err != nil {
+		log.Printf("GeoIP lookup for %s failed: %v", ip, err)
+		return nil
+	}
+	if !location.Empty() {
+		ctx.Values[GeoValueKey] = location
+	}
+	return nil
+}
+
+// AfterDispatch records the location of agents that registered successfully
+func (g *GeoEnricher) AfterDispatch(ctx *EnvelopeContext, result *EnvelopeResult) {
+	location, located := ctx.Values[GeoValueKey].(GeoLocation)
+	if !located || ctx.Envelope.Type != protocol.EnvelopeRegisterAgent || result.Status != http.StatusOK {
+		return
+	}
+	g.broker.recordAgentLocation(ctx.Envelope.Agent, location)
+}
+
+// recordAgentLocation stores where an agent registered from and sets its
+// routing region for geographic load balancing
+func (b *Broker) recordAgentLocation(agentID string, location GeoLocation) {
+	b.mu.Lock()
+	if agent, exists := b.agents[agentID]; exists {
+		updated := *agent
+		updated.Location = &location
+		b.agents[agentID] = &updated
+	}
+	b.mu.Unlock()
+
+	if region := location.Region(); region != "" {
+		b.federation.setAgentRegion(agentID, region)
+	}
+}
+
+// setAgentRegion sets the region the load balancer matches against requests
+func (fm *FederationManager) setAgentRegion(agentID, region string) {
+	fm.metricsMutex.Lock()
+	defer fm.metricsMutex.Unlock()
+	metrics, exists := fm.agentMetrics[agentID]
+	if !exists {
+		metrics = &AgentMetrics{AgentID: agentID, LastUpdated: time.Now()}
+		fm.agentMetrics[agentID] = metrics
+	}
+	metrics.GeographicRegion = region
+}
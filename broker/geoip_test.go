@@ -0,0 +1,74 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/fep-fem/protocol"
+)
+
+// staticLocator resolves addresses from a fixed table
+type staticLocator map[string]GeoLocation
+
+func (s staticLocator) Locate(ip net.IP) (GeoLocation, error) {
+	return s[ip.String()], nil
+}
+
+func TestGeoEnricherAnnotatesRegistrations(t *testing.T) {
+	broker := NewBroker()
+	frankfurt := GeoLocation{Country: "DE", Continent: "EU", City: "Frankfurt am Main", ASN: 64500, ASOrg: "Example Hosting"}
+	broker.Use("geoip", NewGeoEnricher(broker, staticLocator{"192.0.2.1": frankfurt}))
+	var seen interface{}
+	broker.Use("audit", &MiddlewareFuncs{
+		Before: func(ctx *EnvelopeContext) error {
+			seen = ctx.Values[GeoValueKey]
+			return nil
+		},
+	})
+
+	pubKey, privKey, _ := protocol.GenerateKeyPair()
+	envelope := protocol.NewTypedEnvelope("eu-worker", protocol.RegisterAgentBody{
+		PubKey:       protocol.EncodePublicKey(pubKey),
+		Capabilities: []string{"math.add"},
+	})
+	envelope.Sign(privKey)
+	data, _ := json.Marshal(envelope)
+	recorder := httptest.NewRecorder()
+	broker.ServeHTTP(recorder, httptest.NewRequest(http.MethodPost, "/", bytes.NewReader(data)))
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("Registration should be accepted, got %d %s", recorder.Code, recorder.Body.String())
+	}
+
+	if seen != frankfurt {
+		t.Errorf("Later middleware should see the location, got %v", seen)
+	}
+	broker.mu.RLock()
+	agent := broker.agents["eu-worker"]
+	broker.mu.RUnlock()
+	if agent.Location == nil || *agent.Location != frankfurt {
+		t.Errorf("Agent location not recorded: %+v", agent.Location)
+	}
+	broker.federation.metricsMutex.RLock()
+	region := broker.federation.agentMetrics["eu-worker"].GeographicRegion
+	broker.federation.metricsMutex.RUnlock()
+	if region != "eu-de" {
+		t.Errorf("Expected routing region eu-de, got %q", region)
+	}
+
+	// Later requests are annotated too
+	seen = nil
+	if resp, _ := sendHeartbeat(t, broker, "worker"); resp.Code != http.StatusOK || seen != frankfurt {
+		t.Errorf("Requests should be annotated, got %d %v", resp.Code, seen)
+	}
+
+	// Private addresses are never looked up
+	ctx := &EnvelopeContext{Transport: TransportMetadata{SourceIP: "10.0.0.5"}, Values: map[string]interface{}{}}
+	enricher := NewGeoEnricher(broker, staticLocator{"10.0.0.5": frankfurt})
+	if err := enricher.BeforeDispatch(ctx); err != nil || ctx.Values[GeoValueKey] != nil {
+		t.Errorf("Private address should not be annotated, got %v %v", ctx.Values, err)
+	}
+}
@@ -2,8 +2,25 @@ module fem-broker
 
 go 1.21
 
-require github.com/fep-fem/protocol v0.0.0
+require (
+	github.com/fep-fem/protocol v0.0.0
+	github.com/google/cel-go v0.21.0
+	github.com/oschwald/maxminddb-golang v1.13.1
+	google.golang.org/protobuf v1.33.0
+)
 
-require github.com/golang-jwt/jwt/v5 v5.2.0 // indirect
+require (
+	github.com/antlr4-go/antlr/v4 v4.13.0 // indirect
+	github.com/golang-jwt/jwt/v5 v5.2.0 // indirect
+	github.com/klauspost/compress v1.17.11 // indirect
+	github.com/miekg/pkcs11 v1.1.2 // indirect
+	github.com/stoewer/go-strcase v1.2.0 // indirect
+	golang.org/x/crypto v0.24.0 // indirect
+	golang.org/x/exp v0.0.0-20230515195305-f3d0a9c9a5cc // indirect
+	golang.org/x/sys v0.21.0 // indirect
+	golang.org/x/text v0.16.0 // indirect
+	google.golang.org/genproto/googleapis/api v0.0.0-20230803162519-f966b187b2e5 // indirect
+	google.golang.org/genproto/googleapis/rpc v0.0.0-20230803162519-f966b187b2e5 // indirect
+)
 
 replace github.com/fep-fem/protocol => ../protocol/go
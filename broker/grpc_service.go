@@ -0,0 +1,235 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/fep-fem/protocol"
+	"github.com/fep-fem/protocol/fempb"
+)
+
+// grpcServicePrefix starts the paths of the gRPC methods the broker serves
+const grpcServicePrefix = "/" + fempb.ServiceName + "/"
+
+// handleGRPC serves the fem.v1.Broker gRPC service. Envelopes go through the
+// same handlers as envelopes posted to the JSON API, and are answered with
+// the envelope it would have responded with, converted to protobuf.
+func (b *Broker) handleGRPC(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost || r.ProtoMajor != 2 || !strings.HasPrefix(r.Header.Get("Content-Type"), "application/grpc") {
+		http.Error(w, "gRPC calls must be POSTed over HTTP/2 as application/grpc", http.StatusUnsupportedMediaType)
+		return
+	}
+	w.Header().Set("Content-Type", fempb.ContentType)
+	if b.InMaintenance() {
+		fempb.WriteStatus(w, fempb.CodeUnavailable, "broker is in maintenance")
+		return
+	}
+
+	switch r.URL.Path {
+	case fempb.SubmitEnvelopeMethod:
+		b.grpcSubmitEnvelope(w, r)
+	case fempb.StreamEnvelopesMethod:
+		b.grpcStreamEnvelopes(w, r)
+	default:
+		fempb.WriteStatus(w, fempb.CodeUnimplemented, "unknown method "+r.URL.Path)
+	}
+}
+
+// grpcSubmitEnvelope serves SubmitEnvelope: one envelope in, its answer out
+func (b *Broker) grpcSubmitEnvelope(w http.ResponseWriter, r *http.Request) {
+	request, err := fempb.ReadMessage(r.Body, b.grpcMaxMessage())
+	var response *channelResponse
+	switch {
+	case errors.Is(err, protocol.ErrEnvelopeTooLarge):
+		response = b.tooLargeResponse(b.grpcMaxMessage())
+	case err != nil:
+		fempb.WriteStatus(w, grpcCode(err), err.Error())
+		return
+	default:
+		response = b.serveGRPCEnvelope(r, request)
+	}
+
+	answer, err := b.grpcAnswer(response)
+	if err != nil {
+		log.Printf("Failed to answer gRPC call from %s: %v", r.RemoteAddr, err)
+		fempb.WriteStatus(w, fempb.CodeInternal, "failed to answer envelope")
+		return
+	}
+	if err := fempb.WriteMessage(w, answer); err != nil {
+		return
+	}
+	fempb.WriteStatus(w, fempb.CodeOK, "")
+}
+
+// grpcStreamEnvelopes serves StreamEnvelopes. A stream opened with a heartbeat
+// is registered as the agent's channel, so calls are pushed down it as down
+// an upgraded agent channel.
+func (b *Broker) grpcStreamEnvelopes(w http.ResponseWriter, r *http.Request) {
+	stream := &grpcStream{w: w, rc: http.NewResponseController(w), body: r.Body}
+	defer stream.Close()
+	// The client waits for the response headers before it sends
+	w.WriteHeader(http.StatusOK)
+	if err := stream.rc.Flush(); err != nil {
+		return
+	}
+
+	var channel *agentChannel
+	for first := true; ; first = false {
+		request, err := fempb.ReadMessage(r.Body, b.grpcMaxMessage())
+		var response *channelResponse
+		switch {
+		case errors.Is(err, protocol.ErrEnvelopeTooLarge):
+			// The oversized envelope was skipped; the stream stays usable
+			response = b.tooLargeResponse(b.grpcMaxMessage())
+		case errors.Is(err, io.EOF):
+			stream.end(fempb.CodeOK, "")
+			return
+		case err != nil:
+			if !stream.isClosed() {
+				log.Printf("gRPC stream from %s closed: %v", r.RemoteAddr, err)
+			}
+			stream.end(grpcCode(err), err.Error())
+			return
+		case first && protocol.EnvelopeType(request.Type) == protocol.EnvelopeHeartbeat:
+			hello, err := grpcEnvelope(request)
+			if err != nil {
+				response = newChannelResponse()
+				b.reject(response, nil, protocol.CodeInvalidEnvelope, err.Error())
+				break
+			}
+			opened, ok := b.openChannel(r, stream, hello)
+			if !ok {
+				stream.end(fempb.CodePermissionDenied, "heartbeat refused")
+				return
+			}
+			channel = opened
+			defer b.channels.remove(channel)
+			log.Printf("Agent %s opened a gRPC stream from %s", channel.agentID, r.RemoteAddr)
+			continue
+		default:
+			response = b.serveGRPCEnvelope(r, request)
+		}
+
+		answer, err := b.answerEnvelope(response)
+		if err != nil {
+			log.Printf("Failed to answer on gRPC stream from %s: %v", r.RemoteAddr, err)
+			continue
+		}
+		if err := stream.WriteEnvelope(answer); err != nil {
+			return
+		}
+	}
+}
+
+// serveGRPCEnvelope handles an envelope received over gRPC as if it had been
+// posted to the JSON API
+func (b *Broker) serveGRPCEnvelope(r *http.Request, request *fempb.Envelope) *channelResponse {
+	env, err := grpcEnvelope(request)
+	if err != nil {
+		response := newChannelResponse()
+		b.reject(response, nil, protocol.CodeInvalidEnvelope, err.Error())
+		return response
+	}
+	return b.serveOnChannel(r, env)
+}
+
+// grpcAnswer converts the answer to an envelope to protobuf
+func (b *Broker) grpcAnswer(response *channelResponse) (*fempb.Envelope, error) {
+	answer, err := b.answerEnvelope(response)
+	if err != nil {
+		return nil, err
+	}
+	return fempb.FromEnvelope(&protocol.GenericEnvelope{
+		BaseEnvelope: protocol.BaseEnvelope{Type: answer.Type, CommonHeaders: answer.CommonHeaders},
+		Body:         answer.Body,
+	})
+}
+
+// grpcMaxMessage bounds the envelopes read from gRPC calls
+func (b *Broker) grpcMaxMessage() int {
+	if largest := b.SizeLimits().Largest(); largest > 0 {
+		return largest
+	}
+	return protocol.DefaultMaxEnvelopeSize
+}
+
+// grpcEnvelope converts an envelope received over gRPC back to JSON form
+func grpcEnvelope(request *fempb.Envelope) (*protocol.Envelope, error) {
+	env, err := request.Generic()
+	if err != nil {
+		return nil, err
+	}
+	return &protocol.Envelope{Type: env.Type, CommonHeaders: env.CommonHeaders, Body: env.Body}, nil
+}
+
+// grpcCode returns the status a call ends with after failing to read from it
+func grpcCode(err error) fempb.Code {
+	var status *fempb.Status
+	if errors.As(err, &status) {
+		return status.Code
+	}
+	return fempb.CodeCanceled
+}
+
+// grpcStream is a StreamEnvelopes call, written to by the handler answering
+// it and by calls pushed to the agent holding it
+type grpcStream struct {
+	w      http.ResponseWriter
+	rc     *http.ResponseController
+	body   io.Closer
+	mu     sync.Mutex
+	closed bool
+}
+
+// WriteEnvelope sends an envelope down the stream
+func (s *grpcStream) WriteEnvelope(env *protocol.Envelope) error {
+	message, err := fempb.FromEnvelope(&protocol.GenericEnvelope{
+		BaseEnvelope: protocol.BaseEnvelope{Type: env.Type, CommonHeaders: env.CommonHeaders},
+		Body:         env.Body,
+	})
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return net.ErrClosed
+	}
+	if err := fempb.WriteMessage(s.w, message); err != nil {
+		return err
+	}
+	return s.rc.Flush()
+}
+
+// SetCompression does nothing: envelopes go down the stream as protobuf,
+// which channel compression policies do not cover
+func (s *grpcStream) SetCompression(*protocol.CompressionPolicy) {}
+
+// Close ends the stream, unblocking the handler reading from it
+func (s *grpcStream) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return nil
+	}
+	s.closed = true
+	return s.body.Close()
+}
+
+func (s *grpcStream) isClosed() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.closed
+}
+
+// end sets the status the stream ends with once its handler returns
+func (s *grpcStream) end(code fempb.Code, message string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	fempb.WriteStatus(s.w, code, message)
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"io"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/fep-fem/protocol"
+	"github.com/fep-fem/protocol/fempb"
+)
+
+// grpcServer serves broker over HTTP/2, which gRPC needs
+func grpcServer(broker *Broker) (*httptest.Server, *fempb.Client) {
+	server := httptest.NewUnstartedServer(broker)
+	server.EnableHTTP2 = true
+	server.StartTLS()
+	return server, fempb.NewClient(server.URL, &tls.Config{InsecureSkipVerify: true})
+}
+
+// toProtobuf converts an envelope to protobuf as a gRPC client would
+func toProtobuf(t *testing.T, envelope interface{}) *fempb.Envelope {
+	t.Helper()
+	converted, err := fempb.FromJSON(mustJSON(envelope))
+	if err != nil {
+		t.Fatalf("Failed to convert envelope: %v", err)
+	}
+	return converted
+}
+
+// grpcAck returns the ack an answer received over gRPC carries
+func grpcAck(answer *fempb.Envelope) (*protocol.AckBody, error) {
+	data, err := answer.JSON()
+	if err != nil {
+		return nil, err
+	}
+	return protocol.ParseResponse(data)
+}
+
+func TestSubmitEnvelopeOverGRPC(t *testing.T) {
+	broker := NewBroker()
+	server, client := grpcServer(broker)
+	defer server.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	pub, priv, _ := protocol.GenerateKeyPair()
+	register := protocol.NewTypedEnvelope("worker", protocol.RegisterAgentBody{
+		PubKey:         protocol.EncodePublicKey(pub),
+		MCPEndpoint:    "http://10.0.0.7:8080/mcp",
+		BodyDefinition: &protocol.BodyDefinition{MCPTools: []protocol.MCPTool{{Name: "build.run"}}},
+	})
+	register.Sign(priv)
+	answer, err := client.SubmitEnvelope(ctx, toProtobuf(t, register))
+	if err != nil {
+		t.Fatalf("SubmitEnvelope failed: %v", err)
+	}
+	if ack, err := grpcAck(answer); err != nil || ack.Ref != register.Nonce {
+		t.Fatalf("Expected the registration acked, got %+v, %v", ack, err)
+	}
+	if _, registered := broker.agents["worker"]; !registered {
+		t.Error("Registration sent over gRPC should register the agent")
+	}
+
+	// Protocol failures are answered with error envelopes, as over HTTP
+	_, strangerKey, _ := protocol.GenerateKeyPair()
+	hello, _ := protocol.NewHeartbeat("stranger").SignWith(strangerKey)
+	rejection, err := client.SubmitEnvelope(ctx, toProtobuf(t, hello))
+	if err != nil {
+		t.Fatalf("SubmitEnvelope failed: %v", err)
+	}
+	if rejection.Error == nil || rejection.Error.Code != string(protocol.CodeUnknownAgent) {
+		t.Errorf("Expected unknown_agent, got %+v", rejection)
+	}
+}
+
+func TestToolCallsStreamedOverGRPC(t *testing.T) {
+	broker := NewBroker()
+	server, client := grpcServer(broker)
+	defer server.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	pub, priv, _ := protocol.GenerateKeyPair()
+	register := protocol.NewTypedEnvelope("worker", protocol.RegisterAgentBody{
+		PubKey:         protocol.EncodePublicKey(pub),
+		MCPEndpoint:    "http://10.0.0.7:8080/mcp",
+		BodyDefinition: &protocol.BodyDefinition{MCPTools: []protocol.MCPTool{{Name: "build.run"}}},
+	})
+	register.Sign(priv)
+	sendForAck(t, broker, register)
+
+	stream, err := client.StreamEnvelopes(ctx)
+	if err != nil {
+		t.Fatalf("Failed to open stream: %v", err)
+	}
+	defer stream.Close()
+	hello, _ := protocol.NewHeartbeat("worker").SignWith(priv)
+	stream.Send(toProtobuf(t, hello))
+	answer, err := stream.Recv()
+	if err != nil {
+		t.Fatalf("Failed to receive answer: %v", err)
+	}
+	if ack, err := grpcAck(answer); err != nil || ack.Ref != hello.Nonce {
+		t.Fatalf("Expected the heartbeat acked, got %+v, %v", ack, err)
+	}
+
+	// Calls are pushed down the stream, and results go back up it
+	call, _ := protocol.NewToolCall("caller").Tool("build.run").RequestID("build-1").Build()
+	if ack, _ := sendForAck(t, broker, call).Result.(map[string]interface{}); ack["agent"] != "worker" {
+		t.Errorf("Expected the call pushed to worker, got %+v", ack)
+	}
+	pushed, err := stream.Recv()
+	if err != nil || pushed.ToolCall == nil || pushed.Headers.Nonce != call.Nonce {
+		t.Fatalf("Expected the caller's call, got %+v, %v", pushed, err)
+	}
+	result, _ := protocol.NewToolResult("worker", "build-1").Result("ok").SignWith(priv)
+	stream.Send(toProtobuf(t, result))
+	answer, err = stream.Recv()
+	if err != nil {
+		t.Fatalf("Failed to receive answer: %v", err)
+	}
+	if ack, err := grpcAck(answer); err != nil || ack.Status != "received" {
+		t.Fatalf("Expected the result acked, got %+v, %v", ack, err)
+	}
+	if _, held := broker.results.Take("caller", "build-1"); !held {
+		t.Error("Result sent over the stream should be held for the caller")
+	}
+
+	// Closing the sending side ends the stream cleanly and stops pushes
+	stream.CloseSend()
+	if _, err := stream.Recv(); err != io.EOF {
+		t.Errorf("Expected the stream to end cleanly, got %v", err)
+	}
+	for deadline := time.Now().Add(5 * time.Second); broker.channels.connected("worker") && time.Now().Before(deadline); {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if broker.channels.connected("worker") {
+		t.Error("Ended stream should no longer take calls")
+	}
+}
@@ -7,6 +7,8 @@ import (
 	"net/http"
 	"sync"
 	"time"
+
+	"github.com/fep-fem/protocol"
 )
 
 // NewHealthChecker creates a new health checker
@@ -16,6 +18,7 @@ func NewHealthChecker(checkInterval time.Duration, healthThreshold float64) *Hea
 		healthThreshold:   healthThreshold,
 		degradedThreshold: healthThreshold * 0.7,
 		stopChan:         make(chan struct{}),
+		weights:           DefaultHealthWeights(),
 	}
 }
 
@@ -79,23 +82,8 @@ func (hc *HealthChecker) checkAgentHealth(fm *FederationManager) {
 
 // checkSingleAgent performs a health check on a single agent
 func (hc *HealthChecker) checkSingleAgent(fm *FederationManager, agentID, endpoint string) {
-	startTime := time.Now()
-	healthScore := 0.0
-	
-	// Perform basic connectivity check
-	isReachable := hc.checkAgentConnectivity(endpoint)
-	if isReachable {
-		healthScore += 0.4
-	}
-	
-	// Perform capability verification
-	capabilityScore := hc.checkAgentCapabilities(endpoint)
-	healthScore += capabilityScore * 0.3
-	
-	// Check response time
-	responseTime := time.Since(startTime)
-	timeScore := hc.calculateTimeScore(responseTime)
-	healthScore += timeScore * 0.3
+	// Composite of the built-in checks and plugin probes, weighted as configured
+	healthScore, probeScores, isReachable, responseTime := hc.scoreAgent(agentID, endpoint)
 	
 	// Update agent metrics
 	fm.metricsMutex.Lock()
@@ -108,14 +96,17 @@ func (hc *HealthChecker) checkSingleAgent(fm *FederationManager, agentID, endpoi
 	}
 	
 	metrics.HealthScore = healthScore
+	metrics.ProbeScores = probeScores
 	metrics.LastHealthCheck = time.Now()
 	metrics.LastResponseTime = responseTime
 	
 	// Update availability tracking
 	if isReachable {
 		metrics.SuccessfulRequests++
+		metrics.recordLatency(responseTime)
 	} else {
 		metrics.FailedRequests++
+		metrics.recordError("health check failed: agent unreachable")
 	}
 	
 	total := metrics.SuccessfulRequests + metrics.FailedRequests
@@ -134,7 +125,11 @@ func (hc *HealthChecker) checkSingleAgent(fm *FederationManager, agentID, endpoi
 	}
 	
 	metrics.LastUpdated = time.Now()
+	fm.recordMetricsSample(metrics)
 	fm.metricsMutex.Unlock()
+
+	// Take unhealthy agents out of routing immediately rather than at selection time
+	fm.observeAgentHealth(agentID, hc.determineAgentStatus(healthScore))
 }
 
 // checkAgentConnectivity checks if an agent endpoint is reachable
@@ -264,6 +259,12 @@ func (hc *HealthChecker) checkSingleBroker(fm *FederationManager, broker *Federa
 	broker.ResponseTime = responseTime
 	broker.LastSeen = time.Now()
 	
+	// Peers in maintenance advertise it rather than being marked degraded
+	if BrokerStatus(resp.Header.Get(brokerStatusHeader)) == BrokerStatusMaintenance {
+		broker.Status = BrokerStatusMaintenance
+		return
+	}
+	
 	if resp.StatusCode == http.StatusOK {
 		// Try to get additional broker stats
 		statsURL := broker.Endpoint + "/federation/stats"
@@ -328,12 +329,13 @@ func (hc *HealthChecker) GetAgentHealthStatus(fm *FederationManager) map[string]
 			AgentID:          agentID,
 			HealthScore:      metrics.HealthScore,
 			Status:           hc.determineAgentStatus(metrics.HealthScore),
-			LastCheck:        metrics.LastHealthCheck,
-			ResponseTime:     metrics.LastResponseTime,
+			LastCheck:        protocol.Time(metrics.LastHealthCheck),
+			ResponseTime:     protocol.Duration(metrics.LastResponseTime),
 			Availability:     metrics.Availability,
 			ErrorRate:        metrics.ErrorRate,
 			TotalRequests:    metrics.TotalRequests,
 			FailedRequests:   metrics.FailedRequests,
+			ProbeScores:      metrics.ProbeScores,
 		}
 		
 		status[agentID] = healthStatus
@@ -347,12 +349,13 @@ type AgentHealthStatus struct {
 	AgentID        string        `json:"agentId"`
 	HealthScore    float64       `json:"healthScore"`
 	Status         AgentStatus   `json:"status"`
-	LastCheck      time.Time     `json:"lastCheck"`
-	ResponseTime   time.Duration `json:"responseTime"`
+	LastCheck      protocol.Time     `json:"lastCheck"`
+	ResponseTime   protocol.Duration `json:"responseTime"`
 	Availability   float64       `json:"availability"`
 	ErrorRate      float64       `json:"errorRate"`
 	TotalRequests  int64         `json:"totalRequests"`
 	FailedRequests int64         `json:"failedRequests"`
+	ProbeScores    map[string]float64 `json:"probeScores,omitempty"`
 }
 
 // AgentStatus represents the status of an agent
@@ -390,8 +393,8 @@ func (hc *HealthChecker) GetBrokerHealthStatus(fm *FederationManager) map[string
 			BrokerID:     brokerID,
 			Endpoint:     broker.Endpoint,
 			Status:       broker.Status,
-			LastSeen:     broker.LastSeen,
-			ResponseTime: broker.ResponseTime,
+			LastSeen:     protocol.Time(broker.LastSeen),
+			ResponseTime: protocol.Duration(broker.ResponseTime),
 			TrustScore:   broker.TrustScore,
 			ToolCount:    broker.ToolCount,
 			LoadScore:    broker.LoadScore,
@@ -408,8 +411,8 @@ type BrokerHealthStatus struct {
 	BrokerID     string        `json:"brokerId"`
 	Endpoint     string        `json:"endpoint"`
 	Status       BrokerStatus  `json:"status"`
-	LastSeen     time.Time     `json:"lastSeen"`
-	ResponseTime time.Duration `json:"responseTime"`
+	LastSeen     protocol.Time     `json:"lastSeen"`
+	ResponseTime protocol.Duration `json:"responseTime"`
 	TrustScore   float64       `json:"trustScore"`
 	ToolCount    int           `json:"toolCount"`
 	LoadScore    float64       `json:"loadScore"`
@@ -7,6 +7,8 @@ import (
 	"net/http/httptest"
 	"testing"
 	"time"
+
+	"github.com/fep-fem/protocol"
 )
 
 // Test checkAgentConnectivity with various server responses
@@ -121,3 +123,23 @@ func TestDetermineAgentStatus(t *testing.T) {
 		}
 	}
 }
+
+func TestHealthStatusJSONUsesReadableTimes(t *testing.T) {
+	status := AgentHealthStatus{
+		AgentID:      "agent",
+		ResponseTime: protocol.Duration(250 * time.Millisecond),
+	}
+	data, err := json.Marshal(status)
+	if err != nil {
+		t.Fatalf("Failed to marshal status: %v", err)
+	}
+
+	var fields map[string]interface{}
+	json.Unmarshal(data, &fields)
+	if fields["responseTime"] != "250ms" {
+		t.Errorf("Expected responseTime as a duration string, got %v", fields["responseTime"])
+	}
+	if fields["lastCheck"] != nil {
+		t.Errorf("Expected unchecked agent to have null lastCheck, got %v", fields["lastCheck"])
+	}
+}
@@ -0,0 +1,298 @@
+package main
+
+import (
+	"bytes"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"time"
+)
+
+// Names of the built-in health signals, usable as weight keys in reports
+const (
+	ProbeConnectivity = "connectivity"
+	ProbeCapabilities = "capabilities"
+	ProbeLatency      = "latency"
+)
+
+// HealthWeights sets how much each built-in signal contributes to the composite
+// health score. Weights are relative: the composite is normalized by their sum
+// together with the weights of registered probes.
+type HealthWeights struct {
+	Connectivity float64 `json:"connectivity"`
+	Capabilities float64 `json:"capabilities"`
+	Latency      float64 `json:"latency"`
+}
+
+// DefaultHealthWeights reproduces the original 0.4/0.3/0.3 split
+func DefaultHealthWeights() HealthWeights {
+	return HealthWeights{Connectivity: 0.4, Capabilities: 0.3, Latency: 0.3}
+}
+
+// ErrProbeNotApplicable lets a probe skip an agent without affecting its score
+var ErrProbeNotApplicable = errors.New("probe does not apply to this agent")
+
+// HealthProbe is a pluggable health check returning a score between 0 and 1.
+// An error counts as a score of 0, except ErrProbeNotApplicable.
+type HealthProbe interface {
+	Probe(agentID, endpoint string) (float64, error)
+}
+
+// HealthProbeFunc adapts a function to the HealthProbe interface
+type HealthProbeFunc func(agentID, endpoint string) (float64, error)
+
+// Probe calls f
+func (f HealthProbeFunc) Probe(agentID, endpoint string) (float64, error) {
+	return f(agentID, endpoint)
+}
+
+// registeredProbe is a plugin probe with its weight in the composite score
+type registeredProbe struct {
+	name   string
+	weight float64
+	probe  HealthProbe
+}
+
+// SetWeights replaces the weights of the built-in signals
+func (hc *HealthChecker) SetWeights(weights HealthWeights) error {
+	if weights.Connectivity < 0 || weights.Capabilities < 0 || weights.Latency < 0 {
+		return fmt.Errorf("health weights must not be negative")
+	}
+
+	hc.mutex.Lock()
+	defer hc.mutex.Unlock()
+	if weights.Connectivity+weights.Capabilities+weights.Latency+hc.probeWeightsLocked() <= 0 {
+		return fmt.Errorf("at least one health weight must be positive")
+	}
+	hc.weights = weights
+	return nil
+}
+
+// Weights returns the weights of the built-in signals
+func (hc *HealthChecker) Weights() HealthWeights {
+	hc.mutex.RLock()
+	defer hc.mutex.RUnlock()
+	return hc.weights
+}
+
+// RegisterProbe adds a plugin probe, or replaces one with the same name
+func (hc *HealthChecker) RegisterProbe(name string, weight float64, probe HealthProbe) error {
+	if name == "" || name == ProbeConnectivity || name == ProbeCapabilities || name == ProbeLatency {
+		return fmt.Errorf("invalid probe name %q", name)
+	}
+	if weight < 0 {
+		return fmt.Errorf("probe weight must not be negative")
+	}
+
+	hc.mutex.Lock()
+	defer hc.mutex.Unlock()
+	for i := range hc.probes {
+		if hc.probes[i].name == name {
+			hc.probes[i] = registeredProbe{name: name, weight: weight, probe: probe}
+			return nil
+		}
+	}
+	hc.probes = append(hc.probes, registeredProbe{name: name, weight: weight, probe: probe})
+	return nil
+}
+
+// UnregisterProbe removes a plugin probe
+func (hc *HealthChecker) UnregisterProbe(name string) {
+	hc.mutex.Lock()
+	defer hc.mutex.Unlock()
+	for i := range hc.probes {
+		if hc.probes[i].name == name {
+			hc.probes = append(hc.probes[:i], hc.probes[i+1:]...)
+			return
+		}
+	}
+}
+
+// Probes lists registered plugin probe names
+func (hc *HealthChecker) Probes() []string {
+	hc.mutex.RLock()
+	defer hc.mutex.RUnlock()
+	names := make([]string, 0, len(hc.probes))
+	for _, p := range hc.probes {
+		names = append(names, p.name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// signalWeights returns the weight of every health signal, built-in and plugin
+func (hc *HealthChecker) signalWeights() map[string]float64 {
+	hc.mutex.RLock()
+	defer hc.mutex.RUnlock()
+	weights := map[string]float64{
+		ProbeConnectivity: hc.weights.Connectivity,
+		ProbeCapabilities: hc.weights.Capabilities,
+		ProbeLatency:      hc.weights.Latency,
+	}
+	for _, p := range hc.probes {
+		weights[p.name] = p.weight
+	}
+	return weights
+}
+
+func (hc *HealthChecker) probeWeightsLocked() float64 {
+	total := 0.0
+	for _, p := range hc.probes {
+		total += p.weight
+	}
+	return total
+}
+
+// scoreAgent runs the built-in checks and every plugin probe. It returns the
+// weighted composite score, the per-signal scores, whether the agent was
+// reachable and how long the built-in checks took.
+func (hc *HealthChecker) scoreAgent(agentID, endpoint string) (float64, map[string]float64, bool, time.Duration) {
+	hc.mutex.RLock()
+	weights := hc.weights
+	probes := append([]registeredProbe{}, hc.probes...)
+	hc.mutex.RUnlock()
+
+	scores := make(map[string]float64)
+	startTime := time.Now()
+
+	isReachable := hc.checkAgentConnectivity(endpoint)
+	if isReachable {
+		scores[ProbeConnectivity] = 1
+	} else {
+		scores[ProbeConnectivity] = 0
+	}
+	scores[ProbeCapabilities] = hc.checkAgentCapabilities(endpoint)
+
+	// Latency covers the built-in checks only, so slow plugins do not skew it
+	responseTime := time.Since(startTime)
+	scores[ProbeLatency] = hc.calculateTimeScore(responseTime)
+
+	weighted := weights.Connectivity*scores[ProbeConnectivity] +
+		weights.Capabilities*scores[ProbeCapabilities] +
+		weights.Latency*scores[ProbeLatency]
+	totalWeight := weights.Connectivity + weights.Capabilities + weights.Latency
+
+	for _, p := range probes {
+		score, err := p.probe.Probe(agentID, endpoint)
+		if errors.Is(err, ErrProbeNotApplicable) {
+			continue
+		}
+		if err != nil {
+			score = 0
+		}
+		score = clampScore(score)
+		scores[p.name] = score
+		weighted += p.weight * score
+		totalWeight += p.weight
+	}
+
+	if totalWeight <= 0 {
+		return 0, scores, isReachable, responseTime
+	}
+	return weighted / totalWeight, scores, isReachable, responseTime
+}
+
+func clampScore(score float64) float64 {
+	if score < 0 {
+		return 0
+	}
+	if score > 1 {
+		return 1
+	}
+	return score
+}
+
+// SyntheticToolCallProbe is a probe plugin that invokes a tool on the agent's MCP
+// endpoint with known arguments and checks the result
+type SyntheticToolCallProbe struct {
+	Tool      string
+	Arguments map[string]interface{}
+	// Validate scores the tool result; nil scores any successful call as 1
+	Validate func(result interface{}) float64
+	Client   *http.Client
+}
+
+// NewSyntheticToolCallProbe creates a probe calling tool with arguments
+func NewSyntheticToolCallProbe(tool string, arguments map[string]interface{}, validate func(result interface{}) float64) *SyntheticToolCallProbe {
+	return &SyntheticToolCallProbe{
+		Tool:      tool,
+		Arguments: arguments,
+		Validate:  validate,
+		Client: &http.Client{
+			Timeout: 10 * time.Second,
+			Transport: &http.Transport{
+				TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
+			},
+		},
+	}
+}
+
+// Probe sends a JSON-RPC tools/call and scores the response
+func (p *SyntheticToolCallProbe) Probe(agentID, endpoint string) (float64, error) {
+	request, err := json.Marshal(map[string]interface{}{
+		"jsonrpc": "2.0",
+		"id":      "health-probe",
+		"method":  "tools/call",
+		"params": map[string]interface{}{
+			"name":      p.Tool,
+			"arguments": p.Arguments,
+		},
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	resp, err := p.Client.Post(endpoint, "application/json", bytes.NewReader(request))
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return 0, fmt.Errorf("synthetic call to %s returned status %d", p.Tool, resp.StatusCode)
+	}
+
+	var response struct {
+		Result interface{}     `json:"result"`
+		Error  json.RawMessage `json:"error"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
+		return 0, fmt.Errorf("invalid synthetic call response: %w", err)
+	}
+	if len(response.Error) > 0 && string(response.Error) != "null" {
+		return 0, fmt.Errorf("synthetic call to %s failed: %s", p.Tool, response.Error)
+	}
+
+	if p.Validate == nil {
+		return 1, nil
+	}
+	return p.Validate(response.Result), nil
+}
+
+// handleAdminHealthWeights reports (GET) or replaces (PUT) the health scoring weights
+func (b *Broker) handleAdminHealthWeights(w http.ResponseWriter, r *http.Request) {
+	hc := b.federation.healthChecker
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPut, http.MethodPost:
+		var weights HealthWeights
+		if err := json.NewDecoder(r.Body).Decode(&weights); err != nil {
+			http.Error(w, "Invalid weights", http.StatusBadRequest)
+			return
+		}
+		if err := hc.SetWeights(weights); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"weights": hc.Weights(),
+		"probes":  hc.Probes(),
+	})
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newProbeTestAgent serves /health and JSON-RPC on the root path
+func newProbeTestAgent(t *testing.T) *httptest.Server {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/health" {
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+		var request struct {
+			Method string `json:"method"`
+			Params struct {
+				Name      string                 `json:"name"`
+				Arguments map[string]interface{} `json:"arguments"`
+			} `json:"params"`
+		}
+		json.NewDecoder(r.Body).Decode(&request)
+		if request.Method == "tools/call" {
+			a, _ := request.Params.Arguments["a"].(float64)
+			b, _ := request.Params.Arguments["b"].(float64)
+			json.NewEncoder(w).Encode(map[string]interface{}{"result": a + b})
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"result": map[string]interface{}{"tools": []string{}}})
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestHealthScoreWeights(t *testing.T) {
+	server := newProbeTestAgent(t)
+	hc := NewHealthChecker(time.Second, 0.8)
+
+	score, scores, reachable, _ := hc.scoreAgent("agent", server.URL)
+	if !reachable || score != 1 || scores[ProbeConnectivity] != 1 || scores[ProbeCapabilities] != 1 {
+		t.Fatalf("Healthy agent should score 1, got %f %v", score, scores)
+	}
+
+	// A failing plugin with equal weight to the built-ins halves the score
+	if err := hc.RegisterProbe("always-fails", 1, HealthProbeFunc(func(agentID, endpoint string) (float64, error) {
+		return 0, errors.New("boom")
+	})); err != nil {
+		t.Fatalf("RegisterProbe failed: %v", err)
+	}
+	score, scores, _, _ = hc.scoreAgent("agent", server.URL)
+	if math.Abs(score-0.5) > 1e-9 || scores["always-fails"] != 0 {
+		t.Errorf("Expected composite 0.5, got %f %v", score, scores)
+	}
+
+	// Built-in signals can be switched off entirely
+	if err := hc.SetWeights(HealthWeights{}); err != nil {
+		t.Fatalf("Zero built-in weights with a weighted probe should be allowed: %v", err)
+	}
+	if score, _, _, _ = hc.scoreAgent("agent", server.URL); score != 0 {
+		t.Errorf("Only the failing probe counts now, got %f", score)
+	}
+
+	hc.UnregisterProbe("always-fails")
+	if err := hc.SetWeights(HealthWeights{}); err == nil {
+		t.Error("All-zero weights should be rejected")
+	}
+	if err := hc.RegisterProbe(ProbeLatency, 1, nil); err == nil {
+		t.Error("Built-in probe names should be reserved")
+	}
+}
+
+func TestSyntheticToolCallProbe(t *testing.T) {
+	server := newProbeTestAgent(t)
+
+	probe := NewSyntheticToolCallProbe("math.add", map[string]interface{}{"a": 2, "b": 3}, func(result interface{}) float64 {
+		if result == float64(5) {
+			return 1
+		}
+		return 0
+	})
+	if score, err := probe.Probe("math-agent", server.URL); err != nil || score != 1 {
+		t.Errorf("Correct synthetic result should score 1, got %f, %v", score, err)
+	}
+
+	probe.Arguments = map[string]interface{}{"a": 2, "b": 2}
+	if score, _ := probe.Probe("math-agent", server.URL); score != 0 {
+		t.Errorf("Wrong synthetic result should score 0, got %f", score)
+	}
+}
+
+func TestProbeScoresRecordedInMetrics(t *testing.T) {
+	server := newProbeTestAgent(t)
+	fm := NewFederationManager(NewMCPRegistry(), nil)
+	fm.healthChecker.RegisterProbe("domain", 0.5, HealthProbeFunc(func(agentID, endpoint string) (float64, error) {
+		return 0.6, nil
+	}))
+
+	fm.healthChecker.checkSingleAgent(fm, "agent", server.URL)
+
+	status := fm.healthChecker.GetAgentHealthStatus(fm)["agent"]
+	if status == nil || status.ProbeScores["domain"] != 0.6 {
+		t.Fatalf("Probe score not recorded: %+v", status)
+	}
+	data, _ := json.Marshal(status)
+	if !strings.Contains(string(data), `"probeScores"`) {
+		t.Errorf("Probe scores missing from health status JSON: %s", data)
+	}
+}
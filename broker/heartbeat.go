@@ -0,0 +1,61 @@
+package main
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/fep-fem/protocol"
+)
+
+// handleHeartbeat records an agent's liveness and self-reported load.
+// Unknown agents are rejected with unknown_agent so they know to register again.
+func (b *Broker) handleHeartbeat(w http.ResponseWriter, env *protocol.GenericEnvelope) {
+	typed, err := protocol.ParseTyped[protocol.HeartbeatBody](env)
+	if err != nil {
+		b.rejectInvalidBody(w, env, err)
+		return
+	}
+
+	status, known := b.mcpRegistry.RecordHeartbeat(env.Agent, typed.Body)
+	if !known {
+		b.reject(w, env, protocol.CodeUnknownAgent, "Agent not registered")
+		return
+	}
+	b.federation.recordHeartbeat(env.Agent, status)
+
+	result := map[string]interface{}{
+		"agent": env.Agent,
+	}
+	// A draining instance learns when it can stop
+	instance, exists := b.mcpRegistry.Instance(env.Agent, typed.Body.InstanceID)
+	if exists && instance.Draining() {
+		result["draining"] = true
+		result["drained"] = instance.Drained()
+	}
+	// Agents taking new calls pick up checkpointed calls to resume
+	if !exists || !instance.Draining() {
+		resume := b.checkpoints.Due(env.Agent, func(toolName string) bool {
+			return b.mcpRegistry.Resumes(env.Agent, toolName)
+		})
+		if len(resume) > 0 {
+			result["resume"] = resume
+		}
+	}
+	b.writeAck(w, env, "alive", result)
+}
+
+// recordHeartbeat feeds reported load into the agent's routing metrics
+func (fm *FederationManager) recordHeartbeat(agentID string, status protocol.HeartbeatBody) {
+	fm.metricsMutex.Lock()
+	defer fm.metricsMutex.Unlock()
+
+	metrics, exists := fm.agentMetrics[agentID]
+	if !exists {
+		metrics = &AgentMetrics{AgentID: agentID}
+		fm.agentMetrics[agentID] = metrics
+	}
+	metrics.LoadScore = status.Load
+	metrics.GPUUtilization = status.GPUUtilization
+	metrics.LastUpdated = time.Now()
+	fm.recordMetricsSample(metrics)
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/fep-fem/protocol"
+)
+
+func TestHeartbeatRefreshesRegistryLiveness(t *testing.T) {
+	broker := NewBroker()
+	pubKey, privKey, _ := protocol.GenerateKeyPair()
+	registerDiscoveryClient(broker, "worker", pubKey)
+
+	send := func() *httptest.ResponseRecorder {
+		envelope, err := protocol.NewHeartbeat("worker").Load(0.75).InFlight(3).Uptime(time.Minute).SignWith(privKey)
+		if err != nil {
+			t.Fatalf("Failed to build heartbeat: %v", err)
+		}
+		data, _ := json.Marshal(envelope)
+		recorder := httptest.NewRecorder()
+		broker.ServeHTTP(recorder, httptest.NewRequest(http.MethodPost, "/", bytes.NewReader(data)))
+		return recorder
+	}
+
+	if resp := send(); resp.Code != http.StatusNotFound {
+		t.Errorf("Heartbeat from an agent missing from the registry should be 404, got %d", resp.Code)
+	}
+
+	stale := time.Now().Add(-time.Hour)
+	broker.mcpRegistry.RegisterAgent("worker", &MCPAgent{
+		ID:            "worker",
+		Tools:         []protocol.MCPTool{{Name: "math.add"}},
+		LastHeartbeat: stale,
+	})
+	for _, tool := range broker.mcpRegistry.ListTools() {
+		tool.LastSeen = stale
+	}
+
+	if resp := send(); resp.Code != http.StatusOK {
+		t.Fatalf("Heartbeat should be accepted, got %d %s", resp.Code, resp.Body.String())
+	}
+
+	agent, _ := broker.mcpRegistry.GetAgent("worker")
+	if !agent.LastHeartbeat.After(stale) {
+		t.Errorf("Heartbeat should refresh LastHeartbeat")
+	}
+	if agent.LastStatus.InFlight != 3 || agent.LastStatus.Uptime != 60 {
+		t.Errorf("Reported status not recorded: %+v", agent.LastStatus)
+	}
+	if events := broker.mcpRegistry.RemoveStale(10 * time.Minute); len(events) != 0 {
+		t.Errorf("Heartbeating agent's tools should not expire, got %+v", events)
+	}
+	broker.federation.metricsMutex.RLock()
+	metrics := broker.federation.agentMetrics["worker"]
+	broker.federation.metricsMutex.RUnlock()
+	if metrics == nil || metrics.LoadScore != 0.75 {
+		t.Errorf("Reported load should feed routing metrics, got %+v", metrics)
+	}
+}
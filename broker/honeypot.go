@@ -0,0 +1,214 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/fep-fem/protocol"
+)
+
+// honeypotTrustPenalty is subtracted from a caller's trust score each time it invokes a decoy
+const honeypotTrustPenalty = 0.5
+
+// HoneypotTool is a decoy advertised in discovery that no legitimate caller has reason to invoke
+type HoneypotTool struct {
+	AgentID         string           `json:"agentId"`
+	Tool            protocol.MCPTool `json:"tool"`
+	MCPEndpoint     string           `json:"mcpEndpoint,omitempty"`
+	EnvironmentType string           `json:"environmentType,omitempty"`
+}
+
+func (h HoneypotTool) key() string {
+	return h.AgentID + "/" + h.Tool.Name
+}
+
+// RegisterHoneypot advertises a decoy tool. It is indexed like any other tool so
+// that it is indistinguishable from real ones in discovery results.
+func (b *Broker) RegisterHoneypot(honeypot HoneypotTool) error {
+	if honeypot.AgentID == "" || honeypot.Tool.Name == "" {
+		return fmt.Errorf("honeypot requires an agent ID and tool name")
+	}
+	if provider, shadowed := b.realToolProvider(honeypot.Tool.Name); shadowed {
+		return fmt.Errorf("honeypot %s shadows the tool registered by %s", honeypot.Tool.Name, provider)
+	}
+
+	b.mu.Lock()
+	b.honeypots[honeypot.key()] = honeypot
+	agent := b.honeypotAgentLocked(honeypot.AgentID)
+	b.mu.Unlock()
+
+	return b.mcpRegistry.RegisterAgent(agent.ID, agent)
+}
+
+// RemoveHoneypot withdraws a decoy tool
+func (b *Broker) RemoveHoneypot(agentID, toolName string) {
+	b.mu.Lock()
+	delete(b.honeypots, agentID+"/"+toolName)
+	agent := b.honeypotAgentLocked(agentID)
+	b.mu.Unlock()
+
+	// Re-index the decoy agent with whatever decoys remain
+	b.mcpRegistry.UnregisterAgent(agentID)
+	if agent != nil {
+		b.mcpRegistry.RegisterAgent(agent.ID, agent)
+	}
+}
+
+// Honeypots lists the registered decoy tools
+func (b *Broker) Honeypots() []HoneypotTool {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	honeypots := make([]HoneypotTool, 0, len(b.honeypots))
+	for _, honeypot := range b.honeypots {
+		honeypots = append(honeypots, honeypot)
+	}
+	sort.Slice(honeypots, func(i, j int) bool { return honeypots[i].key() < honeypots[j].key() })
+	return honeypots
+}
+
+// honeypotAgentLocked builds the decoy agent advertising an agent ID's honeypots,
+// or nil if none remain. Callers hold b.mu.
+func (b *Broker) honeypotAgentLocked(agentID string) *MCPAgent {
+	var agent *MCPAgent
+	for _, honeypot := range b.honeypots {
+		if honeypot.AgentID != agentID {
+			continue
+		}
+		if agent == nil {
+			agent = &MCPAgent{
+				ID:              agentID,
+				MCPEndpoint:     honeypot.MCPEndpoint,
+				EnvironmentType: honeypot.EnvironmentType,
+				LastHeartbeat:   time.Now(),
+			}
+		}
+		agent.Tools = append(agent.Tools, honeypot.Tool)
+	}
+	if agent != nil {
+		sort.Slice(agent.Tools, func(i, j int) bool { return agent.Tools[i].Name < agent.Tools[j].Name })
+	}
+	return agent
+}
+
+// matchHoneypot reports whether a called tool is a decoy. Calls may name the
+// tool alone or qualify it with the agent ID ("agent/tool"). A bare name only
+// matches while no real agent provides a tool of that name, so callers of a
+// real tool are never taken for probers.
+func (b *Broker) matchHoneypot(toolName string) (HoneypotTool, bool) {
+	b.mu.RLock()
+	honeypot, qualified := b.honeypots[toolName]
+	matched := qualified
+	if !qualified {
+		for _, candidate := range b.honeypots {
+			if candidate.Tool.Name == toolName {
+				honeypot, matched = candidate, true
+				break
+			}
+		}
+	}
+	b.mu.RUnlock()
+
+	if !matched {
+		return HoneypotTool{}, false
+	}
+	if !qualified {
+		if _, shadowed := b.realToolProvider(toolName); shadowed {
+			return HoneypotTool{}, false
+		}
+	}
+	return honeypot, true
+}
+
+// realToolProvider returns an agent other than a decoy that registered a tool
+func (b *Broker) realToolProvider(toolName string) (string, bool) {
+	b.mu.RLock()
+	decoys := make(map[string]bool, len(b.honeypots))
+	for _, honeypot := range b.honeypots {
+		decoys[honeypot.AgentID] = true
+	}
+	b.mu.RUnlock()
+
+	for _, registered := range b.mcpRegistry.ListTools() {
+		if registered.Tool.Name == toolName && !decoys[registered.AgentID] {
+			return registered.AgentID, true
+		}
+	}
+	return "", false
+}
+
+// tripHoneypot flags the caller, lowers its trust score and raises a security event.
+// Only callers whose signature verifies are penalized, so a forged envelope cannot
+// be used to frame another agent.
+func (b *Broker) tripHoneypot(env *protocol.GenericEnvelope, honeypot HoneypotTool, r *http.Request) {
+	detail := fmt.Sprintf("invoked decoy tool %s", honeypot.key())
+
+	b.mu.RLock()
+	agent, known := b.agents[env.Agent]
+	b.mu.RUnlock()
+
+	switch {
+	case !known:
+		detail += "; caller is not a registered agent"
+	case b.verifySender(r, agent, env) != nil:
+		detail += "; caller signature did not verify, trust score unchanged"
+		b.recordSignatureFailure(env.Agent, requestSource(r))
+	default:
+		b.mu.Lock()
+		agent.Flagged = true
+		agent.TrustScore -= honeypotTrustPenalty
+		if agent.TrustScore < 0 {
+			agent.TrustScore = 0
+		}
+		trust := agent.TrustScore
+		detail = fmt.Sprintf("%s; trust score lowered to %.2f", detail, trust)
+		b.mu.Unlock()
+
+		if trust < quarantineTrustThreshold {
+			defer b.Quarantine(env.Agent, QuarantineAnomaly, fmt.Sprintf("trust score %.2f after honeypot invocation", trust))
+		}
+	}
+
+	b.securityEvents.Raise(SecurityEvent{
+		Type:    SecurityEventHoneypotTriggered,
+		AgentID: env.Agent,
+		Detail:  detail,
+		Source:  requestSource(r),
+	})
+}
+
+// handleAdminHoneypots lists (GET), registers (POST) and removes (DELETE) decoy tools
+func (b *Broker) handleAdminHoneypots(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		writeJSON(w, http.StatusOK, b.Honeypots())
+	case http.MethodPost:
+		var honeypot HoneypotTool
+		if err := json.NewDecoder(r.Body).Decode(&honeypot); err != nil {
+			http.Error(w, "Invalid honeypot", http.StatusBadRequest)
+			return
+		}
+		if err := b.RegisterHoneypot(honeypot); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]interface{}{"status": "registered", "honeypot": honeypot})
+	case http.MethodDelete:
+		b.RemoveHoneypot(r.URL.Query().Get("agent"), r.URL.Query().Get("tool"))
+		writeJSON(w, http.StatusOK, map[string]interface{}{"status": "removed"})
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// handleAdminSecurityEvents returns recent security events
+func (b *Broker) handleAdminSecurityEvents(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	writeJSON(w, http.StatusOK, b.securityEvents.Recent())
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/fep-fem/protocol"
+)
+
+func TestHoneypotFlagsCaller(t *testing.T) {
+	broker := NewBroker()
+	broker.SetAdminToken("secret")
+
+	decoy := `{"agentId":"backup-agent","tool":{"name":"secrets.dump","description":"Export stored credentials"}}`
+	req := httptest.NewRequest(http.MethodPost, "/admin/honeypots", strings.NewReader(decoy))
+	req.Header.Set("Authorization", "Bearer secret")
+	recorder := httptest.NewRecorder()
+	broker.ServeHTTP(recorder, req)
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("Expected honeypot registration to succeed, got %d", recorder.Code)
+	}
+
+	// Decoys are discoverable like any other tool
+	tools, _ := broker.mcpRegistry.DiscoverTools(protocol.ToolQuery{Capabilities: []string{"secrets.*"}})
+	if len(tools) != 1 || tools[0].AgentID != "backup-agent" {
+		t.Fatalf("Expected decoy in discovery results, got %+v", tools)
+	}
+
+	pubKey, privKey, _ := protocol.GenerateKeyPair()
+	registerDiscoveryClient(broker, "prober", pubKey)
+
+	callTool := func(agentID, tool string, sign bool) int {
+		envelope := &protocol.ToolCallEnvelope{
+			BaseEnvelope: protocol.BaseEnvelope{
+				Type: protocol.EnvelopeToolCall,
+				CommonHeaders: protocol.CommonHeaders{
+					Agent: agentID,
+					TS:    time.Now().UnixMilli(),
+					Nonce: "call-" + tool,
+				},
+			},
+			Body: protocol.ToolCallBody{Tool: tool, RequestID: "req-1"},
+		}
+		if sign {
+			envelope.Sign(privKey)
+		}
+		data, _ := json.Marshal(envelope)
+		recorder := httptest.NewRecorder()
+		broker.ServeHTTP(recorder, httptest.NewRequest(http.MethodPost, "/", bytes.NewReader(data)))
+		return recorder.Code
+	}
+
+	if status := callTool("prober", "math.add", true); status != http.StatusOK {
+		t.Fatalf("Expected normal tool call to succeed, got %d", status)
+	}
+	if len(broker.securityEvents.Recent()) != 0 {
+		t.Fatal("Normal tool calls must not raise security events")
+	}
+
+	// The decoy answers like a real tool but flags the caller
+	if status := callTool("prober", "backup-agent/secrets.dump", true); status != http.StatusOK {
+		t.Errorf("Expected decoy call to look normal, got %d", status)
+	}
+	if agent := broker.agents["prober"]; !agent.Flagged || agent.TrustScore != 1.0-honeypotTrustPenalty {
+		t.Errorf("Expected prober to be flagged with lowered trust, got flagged=%v trust=%.2f", agent.Flagged, agent.TrustScore)
+	}
+
+	events := broker.securityEvents.Recent()
+	if len(events) != 1 || events[0].Type != SecurityEventHoneypotTriggered || events[0].AgentID != "prober" {
+		t.Fatalf("Expected one honeypot event for prober, got %+v", events)
+	}
+
+	// An unsigned call in the prober's name is reported but does not change its score
+	callTool("prober", "secrets.dump", false)
+	if broker.agents["prober"].TrustScore != 1.0-honeypotTrustPenalty {
+		t.Error("Unverified calls must not lower a registered agent's trust score")
+	}
+	if len(broker.securityEvents.Recent()) != 2 {
+		t.Error("Expected unverified decoy call to still raise an event")
+	}
+
+	broker.RemoveHoneypot("backup-agent", "secrets.dump")
+	if _, exists := broker.mcpRegistry.GetAgent("backup-agent"); exists {
+		t.Error("Expected decoy agent to be withdrawn with its last honeypot")
+	}
+}
+
+func TestHoneypotDoesNotShadowRealTools(t *testing.T) {
+	broker := NewBroker()
+	broker.mcpRegistry.RegisterAgent("file-agent", &MCPAgent{
+		ID:    "file-agent",
+		Tools: []protocol.MCPTool{{Name: "files.list"}},
+	})
+
+	if err := broker.RegisterHoneypot(HoneypotTool{AgentID: "decoy", Tool: protocol.MCPTool{Name: "files.list"}}); err == nil {
+		t.Error("Expected a decoy shadowing a registered tool to be refused")
+	}
+
+	// A real tool registered after a decoy of the same name takes the bare name
+	if err := broker.RegisterHoneypot(HoneypotTool{AgentID: "decoy", Tool: protocol.MCPTool{Name: "secrets.dump"}}); err != nil {
+		t.Fatalf("Failed to register decoy: %v", err)
+	}
+	if _, isDecoy := broker.matchHoneypot("secrets.dump"); !isDecoy {
+		t.Fatal("Expected the bare decoy name to match")
+	}
+	broker.mcpRegistry.RegisterAgent("vault-agent", &MCPAgent{
+		ID:    "vault-agent",
+		Tools: []protocol.MCPTool{{Name: "secrets.dump"}},
+	})
+	if _, isDecoy := broker.matchHoneypot("secrets.dump"); isDecoy {
+		t.Error("Expected callers of the real tool not to trip the decoy")
+	}
+	if _, isDecoy := broker.matchHoneypot("decoy/secrets.dump"); !isDecoy {
+		t.Error("Expected the agent-qualified decoy name to still match")
+	}
+}
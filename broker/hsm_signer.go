@@ -0,0 +1,23 @@
+//go:build pkcs11
+
+package main
+
+import (
+	"github.com/fep-fem/protocol"
+	"github.com/fep-fem/protocol/pkcs11"
+)
+
+// openHSMSigner opens the broker identity key in a PKCS#11 token. The
+// returned close function logs out of the token.
+func openHSMSigner(cfg hsmConfig) (protocol.Signer, func() error, error) {
+	signer, err := pkcs11.Open(pkcs11.Config{
+		Module:     cfg.module,
+		TokenLabel: cfg.token,
+		PIN:        cfg.pin,
+		KeyLabel:   cfg.keyLabel,
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	return signer, signer.Close, nil
+}
@@ -0,0 +1,15 @@
+//go:build !pkcs11
+
+package main
+
+import (
+	"fmt"
+
+	"github.com/fep-fem/protocol"
+)
+
+// openHSMSigner fails in brokers built without PKCS#11 support, which needs
+// cgo and the pkcs11 build tag
+func openHSMSigner(cfg hsmConfig) (protocol.Signer, func() error, error) {
+	return nil, nil, fmt.Errorf("this broker was built without PKCS#11 support; rebuild with -tags pkcs11")
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/fep-fem/protocol"
+)
+
+// IdentityKey returns the key the broker signs responses and tree heads with
+func (b *Broker) IdentityKey() protocol.Signer {
+	return b.federation.SigningKey()
+}
+
+// SetIdentityKey replaces the broker's identity key. Agents that pinned the old
+// key must re-pin, so this is only meant for startup and deliberate rotations.
+func (b *Broker) SetIdentityKey(signer protocol.Signer) {
+	b.federation.SetSigningKey(signer)
+}
+
+// hsmConfig locates a broker identity key held in a PKCS#11 token
+type hsmConfig struct {
+	module   string
+	token    string
+	keyLabel string
+	pin      string
+}
+
+// pkcs11PINEnv holds the token PIN when no PIN file is given
+const pkcs11PINEnv = "FEM_PKCS11_PIN"
+
+// readPKCS11PIN reads the token PIN from file, or from FEM_PKCS11_PIN if file is empty
+func readPKCS11PIN(file string) (string, error) {
+	if file == "" {
+		return os.Getenv(pkcs11PINEnv), nil
+	}
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return "", fmt.Errorf("failed to read PIN: %w", err)
+	}
+	return strings.TrimRight(string(data), "\r\n"), nil
+}
+
+// handleIdentity publishes the broker's public identity key for out-of-band pinning
+func (b *Broker) handleIdentity(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"brokerId": b.federation.config.LocalBrokerID,
+		"pubkey":   protocol.EncodePublicKey(b.IdentityKey().Public().(ed25519.PublicKey)),
+	})
+}
+
+// signedResponseWriter buffers a response so its body can be signed before it is sent
+type signedResponseWriter struct {
+	http.ResponseWriter
+	body   bytes.Buffer
+	status int
+	pooled bool   // Set when the envelope runs on a pool worker
+	wait   func() // Deferred until the worker is released
+}
+
+func newSignedResponseWriter(w http.ResponseWriter) *signedResponseWriter {
+	return &signedResponseWriter{ResponseWriter: w, status: http.StatusOK}
+}
+
+func (s *signedResponseWriter) WriteHeader(status int) {
+	s.status = status
+}
+
+func (s *signedResponseWriter) Write(data []byte) (int, error) {
+	return s.body.Write(data)
+}
+
+// finish signs the buffered body against the request nonce and sends the
+// response. If the key cannot sign, e.g. because its HSM is unreachable, the
+// response is replaced by a 503 so clients never receive an unsigned answer.
+func (s *signedResponseWriter) finish(signer protocol.Signer, requestNonce string) {
+	signature, err := protocol.SignBrokerResponse(signer, requestNonce, s.body.Bytes())
+	if err != nil {
+		log.Printf("Failed to sign response: %v", err)
+		http.Error(s.ResponseWriter, "Broker identity key unavailable", http.StatusServiceUnavailable)
+		return
+	}
+	header := s.ResponseWriter.Header()
+	header.Set(protocol.BrokerKeyHeader, protocol.EncodePublicKey(signer.Public().(ed25519.PublicKey)))
+	header.Set(protocol.BrokerSignatureHeader, signature)
+	s.ResponseWriter.WriteHeader(s.status)
+	s.ResponseWriter.Write(s.body.Bytes())
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"errors"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/fep-fem/protocol"
+)
+
+func TestMCPClientPinsBrokerIdentity(t *testing.T) {
+	broker := NewBroker()
+	broker.SetDiscoveryPolicy(&DiscoveryPolicy{AllowAnonymous: true})
+	server := httptest.NewTLSServer(broker)
+	defer server.Close()
+
+	broker.mcpRegistry.RegisterAgent("math-agent", &MCPAgent{
+		ID:            "math-agent",
+		MCPEndpoint:   "http://localhost:8080",
+		Tools:         []protocol.MCPTool{{Name: "math.add"}},
+		LastHeartbeat: time.Now(),
+	})
+
+	_, privKey, _ := protocol.GenerateKeyPair()
+	client := NewMCPClient(MCPClientConfig{
+		AgentID:     "pinning-client",
+		BrokerURL:   server.URL,
+		PrivateKey:  privKey,
+		TLSInsecure: true,
+	})
+
+	if _, err := client.GetAvailableAgents(); err != nil {
+		t.Fatalf("First contact failed: %v", err)
+	}
+
+	// Rotate the broker key: the client must refuse until it re-pins
+	_, rotated, _ := protocol.GenerateKeyPair()
+	broker.SetIdentityKey(rotated)
+	client.RefreshCache()
+
+	if _, err := client.GetAvailableAgents(); !errors.Is(err, protocol.ErrBrokerKeyChanged) {
+		t.Fatalf("Expected ErrBrokerKeyChanged after rotation, got %v", err)
+	}
+
+	if err := client.RepinBroker(rotated.Public().(ed25519.PublicKey)); err != nil {
+		t.Fatalf("Repin failed: %v", err)
+	}
+	if _, err := client.GetAvailableAgents(); err != nil {
+		t.Fatalf("Request after re-pin failed: %v", err)
+	}
+}
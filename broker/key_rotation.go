@@ -0,0 +1,127 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/fep-fem/protocol"
+)
+
+// defaultKeyRotationGrace is how long an agent's previous key keeps verifying
+// after a rotation, so envelopes signed before the switch are not rejected
+const defaultKeyRotationGrace = time.Hour
+
+// SetKeyRotationGrace sets how long previous keys are accepted after a rotation
+func (b *Broker) SetKeyRotationGrace(grace time.Duration) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.keyRotationGrace = grace
+}
+
+// verifySignature checks an envelope against the agent's key, or against its
+// previous key while the rotation grace window is open. An envelope whose kid
+// header names one of those keys is verified with that key alone.
+func (a *Agent) verifySignature(env *protocol.GenericEnvelope) error {
+	if env.KID != "" {
+		key, found := a.keyByID(env.KID)
+		if !found {
+			return fmt.Errorf("unknown key ID %s", env.KID)
+		}
+		return verifyPeerIdentity(env, key)
+	}
+	err := verifyPeerIdentity(env, a.PubKey)
+	if err != nil && a.PreviousPubKey != "" && time.Now().Before(a.PreviousKeyExpires) {
+		if verifyPeerIdentity(env, a.PreviousPubKey) == nil {
+			return nil
+		}
+	}
+	return err
+}
+
+// keyByID returns the agent's key whose key ID is kid, among the current key
+// and the previous key while it is still accepted
+func (a *Agent) keyByID(kid string) (string, bool) {
+	keys := []string{a.PubKey}
+	if a.PreviousPubKey != "" && time.Now().Before(a.PreviousKeyExpires) {
+		keys = append(keys, a.PreviousPubKey)
+	}
+	for _, encoded := range keys {
+		publicKey, err := protocol.DecodePublicKey(encoded)
+		if err == nil && protocol.KeyID(publicKey) == kid {
+			return encoded, true
+		}
+	}
+	return "", false
+}
+
+// handleRotateKey replaces an agent's key. The envelope must be signed by the
+// current key and co-signed by the new one; the old key keeps verifying until
+// the grace window closes.
+func (b *Broker) handleRotateKey(w http.ResponseWriter, r *http.Request, env *protocol.GenericEnvelope) {
+	typed, err := protocol.ParseTyped[protocol.RotateKeyBody](env)
+	if err != nil {
+		b.rejectInvalidBody(w, env, err)
+		return
+	}
+
+	// A did:key agent's ID is its key; a new key is a new agent
+	if protocol.IsDIDKey(env.Agent) {
+		b.reject(w, env, protocol.CodeForbidden, fmt.Sprintf("Agent %s is a did:key identifier and cannot rotate keys; register the new key's did:key instead", env.Agent))
+		return
+	}
+
+	b.mu.RLock()
+	current, known := b.agents[env.Agent]
+	grace := b.keyRotationGrace
+	b.mu.RUnlock()
+	if !known {
+		b.reject(w, env, protocol.CodeUnknownAgent, fmt.Sprintf("Agent %s is not registered", env.Agent))
+		return
+	}
+
+	currentKey, err := protocol.DecodePublicKey(current.PubKey)
+	if err == nil {
+		_, err = protocol.VerifyKeyRotation(env, currentKey)
+	}
+	if err != nil {
+		b.recordSignatureFailure(env.Agent, requestSource(r))
+		b.reject(w, env, protocol.CodeInvalidSignature, fmt.Sprintf("Key rotation for %s refused: %v", env.Agent, err))
+		return
+	}
+
+	// Replace rather than modify the record, so readers holding the old one
+	// see a consistent key pair
+	rotated := *current
+	rotated.PreviousPubKey = current.PubKey
+	rotated.PreviousKeyExpires = time.Now().Add(grace)
+	rotated.PubKey = typed.Body.NewPubKey
+
+	b.mu.Lock()
+	if b.agents[env.Agent] != current {
+		b.mu.Unlock()
+		b.reject(w, env, protocol.CodeInvalidBody, fmt.Sprintf("Agent %s changed during key rotation; retry", env.Agent))
+		return
+	}
+	b.agents[env.Agent] = &rotated
+	b.mu.Unlock()
+	b.federation.RecordKeyRotation(env, typed.Body.NewPubKey)
+
+	detail := "rotated signing key"
+	if typed.Body.Reason != "" {
+		detail = fmt.Sprintf("%s (%s)", detail, typed.Body.Reason)
+	}
+	log.Printf("Agent %s %s; previous key accepted until %s", env.Agent, detail, rotated.PreviousKeyExpires.Format(time.RFC3339))
+	b.securityEvents.Raise(SecurityEvent{
+		Type:    SecurityEventKeyRotated,
+		AgentID: env.Agent,
+		Detail:  detail,
+		Time:    time.Now(),
+		Source:  requestSource(r),
+	})
+
+	b.writeAck(w, env, "rotated", map[string]interface{}{
+		"previousKeyExpires": protocol.Time(rotated.PreviousKeyExpires),
+	})
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/fep-fem/protocol"
+)
+
+func TestKeyRotationAcceptsBothKeysDuringGrace(t *testing.T) {
+	broker := NewBroker()
+	oldPub, oldPriv, _ := protocol.GenerateKeyPair()
+	newPub, newPriv, _ := protocol.GenerateKeyPair()
+	_, otherPriv, _ := protocol.GenerateKeyPair()
+	registerDiscoveryClient(broker, "agent", oldPub)
+
+	post := func(envelope interface{}) *httptest.ResponseRecorder {
+		data, _ := json.Marshal(envelope)
+		recorder := httptest.NewRecorder()
+		broker.ServeHTTP(recorder, httptest.NewRequest(http.MethodPost, "/", bytes.NewReader(data)))
+		return recorder
+	}
+	collect := func(key ed25519.PrivateKey) int {
+		envelope := protocol.NewTypedEnvelope("agent", protocol.ResultAckBody{})
+		envelope.Sign(key)
+		return post(envelope).Code
+	}
+
+	forged, _ := protocol.NewKeyRotation("agent", otherPriv, newPriv, "")
+	if resp := post(forged); resp.Code == http.StatusOK {
+		t.Fatal("Rotation not signed by the current key should be refused")
+	}
+
+	rotation, _ := protocol.NewKeyRotation("agent", oldPriv, newPriv, "scheduled")
+	if resp := post(rotation); resp.Code != http.StatusOK {
+		t.Fatalf("Rotation failed: %d %s", resp.Code, resp.Body)
+	}
+	broker.mu.RLock()
+	agent := broker.agents["agent"]
+	broker.mu.RUnlock()
+	if agent.PubKey != protocol.EncodePublicKey(newPub) || agent.PreviousPubKey != protocol.EncodePublicKey(oldPub) {
+		t.Fatalf("Agent keys not rotated: %+v", agent)
+	}
+	if events := broker.securityEvents.Recent(); len(events) != 1 || events[0].Type != SecurityEventKeyRotated {
+		t.Errorf("Rotation should raise a security event, got %+v", events)
+	}
+
+	if status := collect(newPriv); status != http.StatusOK {
+		t.Errorf("New key should verify, got %d", status)
+	}
+	if status := collect(oldPriv); status != http.StatusOK {
+		t.Errorf("Old key should verify during the grace window, got %d", status)
+	}
+
+	broker.mu.Lock()
+	broker.agents["agent"].PreviousKeyExpires = time.Now().Add(-time.Second)
+	broker.mu.Unlock()
+	if status := collect(oldPriv); status != http.StatusUnauthorized {
+		t.Errorf("Old key should be refused after the grace window, got %d", status)
+	}
+
+	// Only the current key may rotate again
+	replay, _ := protocol.NewKeyRotation("agent", oldPriv, otherPriv, "")
+	if resp := post(replay); resp.Code == http.StatusOK {
+		t.Error("Rotation signed by the previous key should be refused")
+	}
+}
+
+func TestVerifySignatureByKeyID(t *testing.T) {
+	currentPub, currentPriv, _ := protocol.GenerateKeyPair()
+	previousPub, previousPriv, _ := protocol.GenerateKeyPair()
+	_, otherPriv, _ := protocol.GenerateKeyPair()
+	agent := &Agent{
+		ID:                 "agent",
+		PubKey:             protocol.EncodePublicKey(currentPub),
+		PreviousPubKey:     protocol.EncodePublicKey(previousPub),
+		PreviousKeyExpires: time.Now().Add(time.Hour),
+	}
+	signed := func(key ed25519.PrivateKey) *protocol.GenericEnvelope {
+		typed := protocol.NewTypedEnvelope("agent", protocol.ResultAckBody{})
+		typed.Sign(key)
+		data, _ := json.Marshal(typed)
+		env, _ := protocol.ParseEnvelope(data)
+		return env
+	}
+
+	for name, key := range map[string]ed25519.PrivateKey{"current": currentPriv, "previous": previousPriv} {
+		env := signed(key)
+		if found, _ := agent.keyByID(env.KID); found == "" {
+			t.Errorf("Expected the %s key to be found by its kid", name)
+		}
+		if err := agent.verifySignature(env); err != nil {
+			t.Errorf("Expected the %s key to verify, got %v", name, err)
+		}
+	}
+
+	if err := agent.verifySignature(signed(otherPriv)); err == nil || !strings.Contains(err.Error(), "unknown key ID") {
+		t.Errorf("Expected a key the agent does not hold to be named as unknown, got %v", err)
+	}
+
+	agent.PreviousKeyExpires = time.Now().Add(-time.Second)
+	if err := agent.verifySignature(signed(previousPriv)); err == nil {
+		t.Error("Expected the previous key's kid to be refused after the grace window")
+	}
+}
+
+func TestReRegistrationCannotReplaceKey(t *testing.T) {
+	broker := NewBroker()
+	oldPub, oldPriv, _ := protocol.GenerateKeyPair()
+	newPub, newPriv, _ := protocol.GenerateKeyPair()
+	register := func(pub ed25519.PublicKey, signer ed25519.PrivateKey) int {
+		envelope := protocol.NewTypedEnvelope("agent", protocol.RegisterAgentBody{PubKey: protocol.EncodePublicKey(pub)})
+		envelope.Sign(signer)
+		data, _ := json.Marshal(envelope)
+		recorder := httptest.NewRecorder()
+		broker.ServeHTTP(recorder, httptest.NewRequest(http.MethodPost, "/", bytes.NewReader(data)))
+		return recorder.Code
+	}
+	registeredKey := func() *Agent {
+		broker.mu.RLock()
+		defer broker.mu.RUnlock()
+		return broker.agents["agent"]
+	}
+
+	if status := register(oldPub, oldPriv); status != http.StatusOK {
+		t.Fatalf("Registration failed: %d", status)
+	}
+	if status := register(newPub, newPriv); status != http.StatusForbidden {
+		t.Errorf("Re-registration with a new key signed by it should be forbidden, got %d", status)
+	}
+	if agent := registeredKey(); agent.PubKey != protocol.EncodePublicKey(oldPub) {
+		t.Fatalf("Registered key should be unchanged, got %s", agent.PubKey)
+	}
+	if status := register(oldPub, oldPriv); status != http.StatusOK {
+		t.Errorf("Re-registration with the same key should be accepted, got %d", status)
+	}
+
+	// The holder of the current key may move the agent to a new one
+	if status := register(newPub, oldPriv); status != http.StatusOK {
+		t.Fatalf("Re-registration signed by the current key should be accepted, got %d", status)
+	}
+	if agent := registeredKey(); agent.PubKey != protocol.EncodePublicKey(newPub) || agent.PreviousPubKey != protocol.EncodePublicKey(oldPub) {
+		t.Errorf("Expected the old key kept as the previous key, got %+v", agent)
+	}
+}
@@ -21,25 +21,39 @@ func NewLoadBalancer() *LoadBalancer {
 	lb.strategies[LoadBalanceWeightedRound] = &WeightedRoundRobinStrategy{}
 	lb.strategies[LoadBalanceBestPerformance] = &BestPerformanceStrategy{}
 	lb.strategies[LoadBalanceAffinityBased] = &AffinityBasedStrategy{}
+	lb.strategies[LoadBalanceGPUBinPack] = &GPUBinPackStrategy{}
 
 	return lb
 }
 
+// RegisterStrategy adds or replaces the strategy used for a load balancing mode
+func (lb *LoadBalancer) RegisterStrategy(mode LoadBalanceMode, strategy LoadBalanceStrategy) {
+	lb.mutex.Lock()
+	defer lb.mutex.Unlock()
+	lb.strategies[mode] = strategy
+}
+
 // SelectAgent selects the best agent using the specified load balancing mode
 func (lb *LoadBalancer) SelectAgent(agents []string, metrics map[string]*AgentMetrics, context *RequestContext, mode LoadBalanceMode) (string, error) {
 	lb.mutex.RLock()
 	strategy, exists := lb.strategies[mode]
+	recorder := lb.recorder
 	lb.mutex.RUnlock()
 
 	if !exists {
 		return "", fmt.Errorf("unknown load balance mode: %s", mode)
 	}
 
+	agents = lb.eligibleAgents(agents)
 	if len(agents) == 0 {
 		return "", fmt.Errorf("no agents available")
 	}
 
-	return strategy.SelectAgent(agents, metrics, context)
+	selected, err := strategy.SelectAgent(agents, metrics, context)
+	if err == nil && recorder != nil {
+		recorder.record(agents, metrics, context, mode, selected)
+	}
+	return selected, err
 }
 
 // RoundRobinStrategy implements simple round-robin load balancing
@@ -362,6 +376,53 @@ func (ab *AffinityBasedStrategy) calculateAffinityScore(agentID string, metric *
 	return math.Max(0, math.Min(1, score))
 }
 
+// binPackUtilizationCeiling is the GPU utilization above which bin packing
+// stops adding jobs to an agent while another has room
+const binPackUtilizationCeiling = 0.9
+
+// GPUBinPackStrategy packs GPU jobs onto the busiest agent that still has
+// room, by reported GPU utilization, so other agents keep whole GPUs free for
+// large jobs. Agents that have not reported utilization count as idle.
+type GPUBinPackStrategy struct{}
+
+func (bp *GPUBinPackStrategy) SelectAgent(agents []string, metrics map[string]*AgentMetrics, context *RequestContext) (string, error) {
+	if len(agents) == 0 {
+		return "", fmt.Errorf("no agents available")
+	}
+
+	type agentUtilization struct {
+		agentID     string
+		utilization float64
+		health      float64
+	}
+
+	candidates := make([]agentUtilization, 0, len(agents))
+	for _, agent := range agents {
+		candidate := agentUtilization{agentID: agent, health: 1.0}
+		if metric, exists := metrics[agent]; exists {
+			candidate.utilization = metric.GPUUtilization
+			candidate.health = metric.HealthScore
+		}
+		candidates = append(candidates, candidate)
+	}
+
+	// Agents with room come first, busiest first; full agents last, least busy first
+	sort.SliceStable(candidates, func(i, j int) bool {
+		roomI := candidates[i].utilization < binPackUtilizationCeiling
+		roomJ := candidates[j].utilization < binPackUtilizationCeiling
+		switch {
+		case roomI != roomJ:
+			return roomI
+		case candidates[i].utilization != candidates[j].utilization:
+			return (candidates[i].utilization > candidates[j].utilization) == roomI
+		default:
+			return candidates[i].health > candidates[j].health
+		}
+	})
+
+	return candidates[0].agentID, nil
+}
+
 // AdaptiveStrategy adjusts selection based on historical performance
 type AdaptiveStrategy struct {
 	performanceHistory map[string]*PerformanceHistory
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"crypto/rand"
 	"crypto/rsa"
 	"crypto/tls"
@@ -8,15 +9,19 @@ import (
 	"crypto/x509/pkix"
 	"encoding/json"
 	"encoding/pem"
+	"errors"
 	"flag"
 	"fmt"
-	"io"
 	"log"
 	"math/big"
 	"net"
 	"net/http"
 	"os"
+	"os/signal"
+	"strings"
 	"sync"
+	"sync/atomic"
+	"syscall"
 	"time"
 
 	"github.com/fep-fem/protocol"
@@ -28,6 +33,52 @@ type Broker struct {
 	mu          sync.RWMutex
 	tlsConfig   *tls.Config
 	mcpRegistry *MCPRegistry
+	events      *EventBus
+	results     *ResultOutbox
+	pushes      *resultPusher
+	deadlines   *deadlineTracker
+	transcoders *transcoderRegistry
+	channels    *channelRegistry
+	streams     *StreamOrderer
+	warmup      *warmupTracker
+	federation  *FederationManager
+	artifacts   *ArtifactStore
+	checkpoints *CheckpointLedger
+	approvals   *approvalQueue
+	requests    *RequestTracker
+	status      BrokerStatus
+	adminToken  string
+	inFlight    atomic.Int64
+	timestamps  protocol.TimestampAuthority
+	minProtocol protocol.Version // Oldest protocol version accepted; zero accepts all
+	skew        protocol.SkewPolicy
+
+	keyRotationGrace time.Duration // How long a rotated-out key keeps verifying
+	requireDIDAgents bool          // Refuse registrations from agents without did:key IDs
+
+	compressionMinBytes int                 // Smallest body compressed for agents that offer compression; 0 disables it
+	sizeLimits          protocol.SizeLimits // Largest envelopes accepted, overall and per type
+
+	discoveryPolicy *DiscoveryPolicy
+	wildcardLimiter *wildcardLimiter
+	tenantTokens    map[string]string // Tenant by token, for namespace owners' dashboards
+	rateLimiter     *protocol.RateLimiter // Per-agent and per-IP envelope limits; nil if not enforced
+	callPolicy      *ToolCallPolicy // Capability checks on tool calls; nil if not enforced
+	approvalPolicy  *ApprovalPolicy // Who approves calls to dangerous tools; nil refuses them
+	adminQuorum     *AdminQuorum    // Admin signatures destructive operations need; nil needs none
+	usedApprovals   *approvalNonces // Admin approvals already used
+
+	honeypots      map[string]HoneypotTool
+	securityEvents *SecurityEventLog
+	audit          *AuditLog
+
+	quarantined       map[string]*QuarantineRecord
+	signatureFailures map[string][]time.Time // By source IP
+
+	workers *workerPool
+
+	middleware   []namedMiddleware
+	middlewareMu sync.RWMutex
 }
 
 // Agent represents a registered agent
@@ -35,15 +86,366 @@ type Agent struct {
 	ID           string
 	Capabilities []string
 	Endpoint     string
+	PubKey       string
 	RegisteredAt time.Time
+	TrustScore   float64
+	Flagged      bool   // Set when the agent trips a honeypot
+	Proto        string // Protocol version negotiated at registration
+	// Key replaced by the last rotation, accepted until PreviousKeyExpires
+	PreviousPubKey     string
+	PreviousKeyExpires time.Time
+	Location           *GeoLocation // Where the agent registered from, if GeoIP is enabled
+	// Body compression agreed at registration; nil if bodies are sent uncompressed
+	Compression *protocol.CompressionPolicy
 }
 
 func main() {
-	var listen string
+	if len(os.Args) > 1 && os.Args[1] == "dev" {
+		os.Exit(runDev(os.Args[2:]))
+	}
+	if len(os.Args) > 1 && os.Args[1] == "simulate" {
+		os.Exit(runSimulate(os.Args[2:]))
+	}
+
+	var listen, adminToken, tsaURL, tsaCert, discoveryTokens, capabilityKey, identityKeyPath, keyPassphraseFile string
+	var routingLog string
+	var workerLanes, routesFile, minProto, stateFile, transparencyFile, geoipCityDB, geoipASNDB string
+	var piiAction, piiDetectors, piiBoundaries, clientCerts string
+	var complianceDir, complianceFormat string
+	var agentRateLimit, ipRateLimit, rateLimitOverrides string
+	var tenantBudgets, tenantTokens string
+	var natsURL, natsPrefix string
+	var directoryURL, publicEndpoint string
+	var directoryInterval time.Duration
+	var directoryPeering bool
+	var approvers, approvalWebhooks string
+	var approvalTimeout time.Duration
+	var admins, adminQuorum string
+	var highTrust float64
+	var budgetPeriod time.Duration
+	var toolPermissionsFile, pkcs11PINFile string
+	var hsm hsmConfig
+	var anonymousDiscovery, topicCapabilities, callCapabilities, eddsaCapabilities, requireDIDAgents bool
+	var toolStaleness, maxEnvelopeAge, maxFutureSkew, resultRedelivery, resultTTL, reorderGapWait time.Duration
+	var orphanTimeout time.Duration
+	var reorderWindow int
+	var stateSaveInterval, keyRotationGrace, complianceInterval time.Duration
+	var compressMinBytes, maxEnvelopeSize int
+	var envelopeSizeLimits string
+	workerConfig := DefaultWorkerPoolConfig()
 	flag.StringVar(&listen, "listen", ":4433", "Address to listen on")
+	flag.StringVar(&adminToken, "admin-token", os.Getenv("FEM_ADMIN_TOKEN"), "Bearer token for the admin API (disabled if empty)")
+	flag.StringVar(&tsaURL, "tsa-url", os.Getenv("FEM_TSA_URL"), "RFC 3161 timestamping authority for revocations (disabled if empty)")
+	flag.StringVar(&tsaCert, "tsa-cert", os.Getenv("FEM_TSA_CERT"), "PEM certificate the timestamping authority signs tokens with (required with --tsa-url)")
+	flag.StringVar(&discoveryTokens, "discovery-tokens", os.Getenv("FEM_DISCOVERY_TOKENS"), "Comma-separated caller:token pairs accepted for discovery")
+	flag.StringVar(&capabilityKey, "capability-key", os.Getenv("FEM_CAPABILITY_KEY"), "Key for capability tokens scoping discovery results (scoping disabled if empty)")
+	flag.StringVar(&identityKeyPath, "identity-key", os.Getenv("FEM_IDENTITY_KEY_FILE"), "File holding the broker identity key, created if missing (ephemeral if empty)")
+	flag.StringVar(&keyPassphraseFile, "key-passphrase-file", os.Getenv("FEM_KEY_PASSPHRASE_FILE"), "File holding the passphrase encrypting the identity key (FEM_KEY_PASSPHRASE if empty)")
+	flag.StringVar(&hsm.module, "identity-pkcs11-module", os.Getenv("FEM_PKCS11_MODULE"), "PKCS#11 module holding the broker identity key, instead of --identity-key (needs a pkcs11 build)")
+	flag.StringVar(&hsm.token, "identity-pkcs11-token", os.Getenv("FEM_PKCS11_TOKEN"), "Label of the PKCS#11 token holding the identity key")
+	flag.StringVar(&hsm.keyLabel, "identity-pkcs11-key-label", os.Getenv("FEM_PKCS11_KEY_LABEL"), "Label of the Ed25519 identity key pair in the token")
+	flag.StringVar(&pkcs11PINFile, "identity-pkcs11-pin-file", os.Getenv("FEM_PKCS11_PIN_FILE"), "File holding the token's user PIN (FEM_PKCS11_PIN if empty)")
+	flag.StringVar(&routesFile, "routes-file", os.Getenv("FEM_ROUTES_FILE"), "File persisting operator-defined tool routes (in memory only if empty)")
+	flag.StringVar(&geoipCityDB, "geoip-city-db", os.Getenv("FEM_GEOIP_CITY_DB"), "MaxMind City database annotating envelopes with the sender's location (disabled if empty)")
+	flag.StringVar(&geoipASNDB, "geoip-asn-db", os.Getenv("FEM_GEOIP_ASN_DB"), "MaxMind ASN database annotating envelopes with the sender's network (disabled if empty)")
+	flag.StringVar(&piiAction, "pii-action", os.Getenv("FEM_PII_ACTION"), "redact or block personal data in tool calls and results crossing --pii-boundaries (disabled if empty)")
+	flag.StringVar(&piiDetectors, "pii-detectors", os.Getenv("FEM_PII_DETECTORS"), "Comma-separated PII detectors: built-in classes or class=regexp pairs (all built-ins if empty)")
+	flag.StringVar(&piiBoundaries, "pii-boundaries", "namespace,federation", "Boundaries the PII guard applies to: namespace, federation or both")
+	flag.StringVar(&complianceDir, "compliance-report-dir", os.Getenv("FEM_COMPLIANCE_REPORT_DIR"), "Directory periodic compliance reports are written to (disabled if empty)")
+	flag.DurationVar(&complianceInterval, "compliance-report-interval", defaultComplianceWindow, "Period each compliance report in --compliance-report-dir covers")
+	flag.StringVar(&complianceFormat, "compliance-report-format", "csv", "Format of periodic compliance reports: csv, pdf or json")
+	flag.StringVar(&routingLog, "routing-log", os.Getenv("FEM_ROUTING_LOG"), "File each load balancing decision is appended to, for replay with fem-broker simulate (not recorded if empty)")
+	flag.StringVar(&transparencyFile, "transparency-log", os.Getenv("FEM_TRANSPARENCY_LOG"), "File the transparency log is appended to and restored from (in memory only if empty)")
+	flag.StringVar(&stateFile, "state-file", os.Getenv("FEM_STATE_FILE"), "File snapshotting agents and routing metrics, restored on startup (in memory only if empty)")
+	flag.DurationVar(&stateSaveInterval, "state-save-interval", defaultStateSaveInterval, "How often to snapshot state to --state-file")
+	flag.IntVar(&workerConfig.Workers, "workers", workerConfig.Workers, "Workers processing envelopes in the shared lane")
+	flag.IntVar(&workerConfig.QueueSize, "worker-queue", workerConfig.QueueSize, "Envelopes queued per lane before the broker answers 503")
+	flag.StringVar(&workerLanes, "worker-lanes", "", "Dedicated lanes as type=workers pairs, e.g. toolCall=8,discoverTools=4")
+	flag.DurationVar(&maxEnvelopeAge, "max-envelope-age", protocol.DefaultMaxEnvelopeAge, "Reject envelopes whose ts is older than this (disabled if 0)")
+	flag.DurationVar(&maxFutureSkew, "max-future-skew", protocol.DefaultMaxFutureSkew, "Reject envelopes whose ts is further in the future than this (disabled if 0)")
+	flag.DurationVar(&resultRedelivery, "result-redelivery", defaultRedeliveryDelay, "Redeliver unacknowledged at-least-once results after this long")
+	flag.DurationVar(&resultTTL, "result-ttl", defaultResultTTL, "Discard uncollected tool results and unanswered calls after this long")
+	flag.DurationVar(&orphanTimeout, "orphan-timeout", defaultOrphanTimeout, "Fail tool calls making no progress for this long (disabled if 0)")
+	flag.IntVar(&reorderWindow, "reorder-window", defaultReorderWindow, "Out-of-order sequenced envelopes held per sender")
+	flag.DurationVar(&reorderGapWait, "reorder-gap-wait", defaultMaxGapWait, "How long held envelopes wait for a missing sequence number")
+	flag.DurationVar(&keyRotationGrace, "key-rotation-grace", defaultKeyRotationGrace, "How long an agent's previous key is accepted after it rotates keys")
+	flag.IntVar(&maxEnvelopeSize, "max-envelope-size", defaultMaxEnvelopeSize, "Largest envelope accepted, in bytes, for types without a limit in --envelope-size-limits (unlimited if 0)")
+	flag.StringVar(&envelopeSizeLimits, "envelope-size-limits", os.Getenv("FEM_ENVELOPE_SIZE_LIMITS"), "Per-type envelope size limits as type=bytes pairs, e.g. heartbeat=4096,toolResult=33554432")
+	flag.IntVar(&compressMinBytes, "compress-min-bytes", protocol.DefaultCompressionThreshold, "Smallest envelope body compressed for agents that offer compression at registration (disabled if 0)")
+	flag.DurationVar(&toolStaleness, "tool-staleness", 0, "Remove registered tools and agents not seen for this long (disabled if 0)")
+	flag.BoolVar(&anonymousDiscovery, "allow-anonymous-discovery", false, "Allow discovery from unregistered, unsigned callers")
+	flag.BoolVar(&eddsaCapabilities, "eddsa-capabilities", false, "Sign capability tokens with the broker identity key; --capability-key tokens are still accepted")
+	flag.BoolVar(&requireDIDAgents, "require-did-agents", false, "Only register agents whose IDs are did:key identifiers of their signing keys")
+	flag.BoolVar(&topicCapabilities, "topic-capabilities", false, "Require capability tokens to publish and subscribe to event topics")
+	flag.BoolVar(&callCapabilities, "call-capabilities", false, "Require capability tokens granting call:<tool> on tool calls")
+	flag.StringVar(&toolPermissionsFile, "tool-permissions", os.Getenv("FEM_TOOL_PERMISSIONS_FILE"), "JSON file mapping tool patterns to the permission and scope calls need (call:<tool> if empty)")
+	flag.StringVar(&clientCerts, "client-certs", os.Getenv("FEM_CLIENT_CERTS"), "Ask TLS clients for certificates bound to their signing keys: request or require (one-way TLS if empty)")
+	flag.StringVar(&tenantBudgets, "tenant-budgets", os.Getenv("FEM_TENANT_BUDGETS"), "Comma-separated tenant=amount caps on what each tenant spends on priced tool calls per budget period")
+	flag.StringVar(&tenantTokens, "tenant-tokens", os.Getenv("FEM_TENANT_TOKENS"), "Comma-separated tenant:token pairs giving namespace owners their own view of /dashboard and /metrics")
+	flag.DurationVar(&budgetPeriod, "budget-period", defaultBudgetPeriod, "How long tenant spending accumulates before it resets")
+	flag.StringVar(&agentRateLimit, "agent-rate-limit", "", "Envelopes per second accepted from each agent ID, as rate/burst, e.g. 20/100 (unlimited if empty)")
+	flag.StringVar(&ipRateLimit, "ip-rate-limit", "", "Envelopes per second accepted from each source IP, as rate/burst (unlimited if empty)")
+	flag.StringVar(&rateLimitOverrides, "rate-limit-overrides", "", "Comma-separated agent-or-ip=rate/burst limits replacing the defaults for one sender; a rate of 0 is unlimited")
+	flag.StringVar(&approvers, "approvers", os.Getenv("FEM_APPROVERS"), "Comma-separated id=pubkey pairs pinning the agents allowed to approve calls to tools tagged dangerous (such calls are refused if empty)")
+	flag.StringVar(&approvalWebhooks, "approval-webhooks", os.Getenv("FEM_APPROVAL_WEBHOOKS"), "Comma-separated URLs notified of each call awaiting approval")
+	flag.DurationVar(&approvalTimeout, "approval-timeout", defaultApprovalTimeout, "Reject calls awaiting approval after this long")
+	flag.StringVar(&admins, "admins", os.Getenv("FEM_ADMINS"), "Comma-separated id=pubkey pairs pinning the admins whose signatures count towards --admin-quorum")
+	flag.StringVar(&adminQuorum, "admin-quorum", os.Getenv("FEM_ADMIN_QUORUM"), "Admin signatures each operation class needs, e.g. revoke=2,peer-removal=2,policy=2")
+	flag.Float64Var(&highTrust, "high-trust", defaultHighTrust, "Trust score from which revoking an agent needs the revoke quorum")
+	flag.StringVar(&natsURL, "nats-url", os.Getenv("FEM_NATS_URL"), "NATS server fanning events out between brokers, e.g. nats://token@nats:4222 (events stay local if empty)")
+	flag.StringVar(&natsPrefix, "nats-prefix", protocol.DefaultNATSPrefix, "First token of the NATS subjects envelopes travel on")
+	flag.StringVar(&directoryURL, "directory-url", os.Getenv("FEM_DIRECTORY_URL"), "Directory server the broker publishes its descriptor to (not listed if empty)")
+	flag.StringVar(&publicEndpoint, "public-endpoint", os.Getenv("FEM_PUBLIC_ENDPOINT"), "URL peers reach the broker at, as published to --directory-url")
+	flag.DurationVar(&directoryInterval, "directory-interval", defaultDirectoryInterval, "How often to republish the descriptor to --directory-url")
+	flag.BoolVar(&directoryPeering, "directory-peering", false, "Register the brokers listed in --directory-url as peers")
+	flag.StringVar(&minProto, "min-proto", os.Getenv("FEM_MIN_PROTOCOL_VERSION"), "Oldest protocol version accepted from agents and peers (all accepted if empty)")
 	flag.Parse()
 
 	broker := NewBroker()
+	broker.SetAdminToken(adminToken)
+
+	lanes, err := ParseTypeWorkers(workerLanes)
+	if err != nil {
+		log.Fatalf("Invalid --worker-lanes: %v", err)
+	}
+	workerConfig.TypeWorkers = lanes
+	typeSizeLimits, err := protocol.ParseSizeLimits(envelopeSizeLimits)
+	if err != nil {
+		log.Fatalf("Invalid --envelope-size-limits: %v", err)
+	}
+	broker.SetSizeLimits(protocol.SizeLimits{Max: maxEnvelopeSize, PerType: typeSizeLimits})
+	broker.SetWorkerPool(workerConfig)
+	broker.SetSkewPolicy(protocol.SkewPolicy{MaxAge: maxEnvelopeAge, MaxFuture: maxFutureSkew})
+	broker.results.Configure(resultRedelivery, resultTTL)
+	broker.requests.Configure(orphanTimeout, defaultRequestRetention)
+	if orphanTimeout > 0 {
+		broker.StartOrphanSweep(orphanTimeout)
+	}
+	budgets, err := parseTenantBudgets(tenantBudgets)
+	if err != nil {
+		log.Fatalf("Invalid --tenant-budgets: %v", err)
+	}
+	broker.federation.Budgets().Configure(budgets, budgetPeriod)
+	tenantViews, err := parseTenantTokens(tenantTokens)
+	if err != nil {
+		log.Fatalf("Invalid --tenant-tokens: %v", err)
+	}
+	broker.SetTenantTokens(tenantViews)
+	pinnedApprovers, err := parsePinnedKeys(approvers)
+	if err != nil {
+		log.Fatalf("Invalid --approvers: %v", err)
+	}
+	broker.SetApprovalPolicy(&ApprovalPolicy{
+		Approvers: pinnedApprovers,
+		Webhooks:  parseList(approvalWebhooks),
+		Timeout:   approvalTimeout,
+	})
+	if adminQuorum != "" {
+		required, err := parseAdminQuorum(adminQuorum)
+		if err != nil {
+			log.Fatalf("Invalid --admin-quorum: %v", err)
+		}
+		pinned, err := parsePinnedKeys(admins)
+		if err != nil {
+			log.Fatalf("Invalid --admins: %v", err)
+		}
+		broker.SetAdminQuorum(&AdminQuorum{Admins: pinned, Required: required, HighTrust: highTrust})
+	}
+	broker.streams.Configure(reorderWindow, reorderGapWait)
+	broker.SetKeyRotationGrace(keyRotationGrace)
+	broker.SetCompressionThreshold(compressMinBytes)
+	broker.SetRequireDIDAgents(requireDIDAgents)
+
+	if agentRateLimit != "" || ipRateLimit != "" || rateLimitOverrides != "" {
+		var rateLimits protocol.RateLimitConfig
+		if agentRateLimit != "" {
+			if rateLimits.PerAgent, err = protocol.ParseRateLimit(agentRateLimit); err != nil {
+				log.Fatalf("Invalid --agent-rate-limit: %v", err)
+			}
+		}
+		if ipRateLimit != "" {
+			if rateLimits.PerIP, err = protocol.ParseRateLimit(ipRateLimit); err != nil {
+				log.Fatalf("Invalid --ip-rate-limit: %v", err)
+			}
+		}
+		if err := parseRateLimitOverrides(rateLimitOverrides, &rateLimits); err != nil {
+			log.Fatalf("Invalid --rate-limit-overrides: %v", err)
+		}
+		broker.SetRateLimit(rateLimits)
+	}
+
+	if minProto != "" {
+		if err := broker.SetMinProtocolVersion(minProto); err != nil {
+			log.Fatalf("Invalid --min-proto: %v", err)
+		}
+	}
+
+	if hsm.module != "" {
+		if identityKeyPath != "" {
+			log.Fatalf("--identity-key and --identity-pkcs11-module are mutually exclusive")
+		}
+		if hsm.pin, err = readPKCS11PIN(pkcs11PINFile); err != nil {
+			log.Fatalf("Failed to read PKCS#11 PIN: %v", err)
+		}
+		signer, closeSigner, err := openHSMSigner(hsm)
+		if err != nil {
+			log.Fatalf("Failed to open identity key in PKCS#11 token: %v", err)
+		}
+		defer closeSigner()
+		publicKey, err := protocol.SignerPublicKey(signer)
+		if err != nil {
+			log.Fatalf("Unusable identity key in PKCS#11 token: %v", err)
+		}
+		log.Printf("Using identity key %s from PKCS#11 token %q", protocol.EncodePublicKey(publicKey), hsm.token)
+		broker.SetIdentityKey(signer)
+	} else if identityKeyPath != "" {
+		passphrase, err := protocol.ReadPassphrase(keyPassphraseFile)
+		if err != nil {
+			log.Fatalf("Failed to read key passphrase: %v", err)
+		}
+		if passphrase == nil {
+			log.Printf("No key passphrase set; the identity key is stored unencrypted")
+		}
+		identityKey, created, err := protocol.LoadOrCreatePrivateKey(identityKeyPath, passphrase)
+		if err != nil {
+			log.Fatalf("Failed to load identity key: %v", err)
+		}
+		if created {
+			log.Printf("Created identity key %s", identityKeyPath)
+		}
+		broker.SetIdentityKey(identityKey)
+	} else {
+		log.Printf("No --identity-key set; the identity key is ephemeral, so signed tree heads will not verify after a restart")
+	}
+	// Tree heads over a persisted log must stay verifiable across restarts
+	if transparencyFile != "" {
+		if hsm.module == "" && identityKeyPath == "" {
+			log.Fatalf("--transparency-log needs a persistent identity key (--identity-key or --identity-pkcs11-module)")
+		}
+		if err := broker.federation.TransparencyLog().Persist(transparencyFile); err != nil {
+			log.Fatalf("Failed to load transparency log: %v", err)
+		}
+	}
+	if routesFile != "" {
+		if err := broker.federation.LoadRoutes(routesFile); err != nil {
+			log.Fatalf("Failed to load routes: %v", err)
+		}
+	}
+	if routingLog != "" {
+		out, err := os.OpenFile(routingLog, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o600)
+		if err != nil {
+			log.Fatalf("Failed to open routing log: %v", err)
+		}
+		defer out.Close()
+		broker.federation.SetRoutingLog(out)
+	}
+	if stateFile != "" {
+		if err := broker.LoadState(stateFile); err != nil {
+			log.Fatalf("Failed to load state: %v", err)
+		}
+		broker.StartStatePersistence(stateFile, stateSaveInterval)
+	}
+	// Registered first so it sees rejections from every later middleware
+	broker.Use("audit", NewAuditRecorder(broker))
+	if complianceDir != "" {
+		if err := broker.StartComplianceReports(complianceDir, complianceInterval, complianceFormat); err != nil {
+			log.Fatalf("Invalid compliance report settings: %v", err)
+		}
+	}
+	clientCertMode, err := ParseClientCertMode(clientCerts)
+	if err != nil {
+		log.Fatalf("Invalid --client-certs: %v", err)
+	}
+	if clientCertMode != ClientCertsOff {
+		broker.Use("client-certs", NewClientCertBinding(broker, clientCertMode))
+	}
+	if geoipCityDB != "" || geoipASNDB != "" {
+		locator, err := OpenMaxMindLocator(geoipCityDB, geoipASNDB)
+		if err != nil {
+			log.Fatalf("Failed to open GeoIP databases: %v", err)
+		}
+		defer locator.Close()
+		broker.Use("geoip", NewGeoEnricher(broker, locator))
+	}
+	if piiAction != "" {
+		policy, err := parsePIIPolicy(piiAction, piiDetectors, piiBoundaries)
+		if err != nil {
+			log.Fatalf("Invalid PII guard settings: %v", err)
+		}
+		broker.Use("pii", NewPIIGuard(broker, policy))
+	}
+	if toolStaleness > 0 {
+		broker.federation.StartRegistryGC(toolStaleness)
+	}
+	if tsaURL != "" {
+		if tsaCert == "" {
+			log.Fatalf("--tsa-url requires --tsa-cert to verify the authority's tokens")
+		}
+		tsa, err := protocol.LoadRFC3161Authority(tsaURL, tsaCert)
+		if err != nil {
+			log.Fatalf("Failed to load timestamping authority certificate: %v", err)
+		}
+		broker.SetTimestampAuthority(tsa)
+	}
+	if natsURL != "" {
+		conn, err := protocol.DialNATS(protocol.NATSConfig{URL: natsURL, Name: broker.federation.config.LocalBrokerID})
+		if err != nil {
+			log.Fatalf("Failed to connect to NATS: %v", err)
+		}
+		defer conn.Close()
+		if err := broker.UseNATS(protocol.NewNATSTransport(conn, natsPrefix)); err != nil {
+			log.Fatalf("Failed to subscribe to NATS: %v", err)
+		}
+		log.Printf("Fanning events out over NATS at %s", conn.Server())
+	}
+
+	discoveryPolicy := DefaultDiscoveryPolicy()
+	discoveryPolicy.AllowAnonymous = anonymousDiscovery
+	for _, pair := range strings.Split(discoveryTokens, ",") {
+		if caller, token, ok := strings.Cut(strings.TrimSpace(pair), ":"); ok {
+			discoveryPolicy.APITokens[token] = caller
+		}
+	}
+	switch {
+	case eddsaCapabilities:
+		discoveryPolicy.Capabilities = protocol.NewEd25519CapabilityManager(broker.IdentityKey())
+		if capabilityKey != "" {
+			discoveryPolicy.Capabilities.AcceptLegacySecret([]byte(capabilityKey))
+		}
+	case capabilityKey != "":
+		discoveryPolicy.Capabilities = protocol.NewCapabilityManager([]byte(capabilityKey))
+	}
+	broker.SetDiscoveryPolicy(discoveryPolicy)
+	if topicCapabilities {
+		if discoveryPolicy.Capabilities == nil {
+			log.Fatalf("--topic-capabilities needs --capability-key or --eddsa-capabilities")
+		}
+		broker.events.SetCapabilities(discoveryPolicy.Capabilities)
+	}
+	if callCapabilities {
+		if discoveryPolicy.Capabilities == nil {
+			log.Fatalf("--call-capabilities needs --capability-key or --eddsa-capabilities")
+		}
+		callPolicy := &ToolCallPolicy{Capabilities: discoveryPolicy.Capabilities}
+		if toolPermissionsFile != "" {
+			if callPolicy.Permissions, err = LoadToolPermissions(toolPermissionsFile); err != nil {
+				log.Fatalf("Failed to load tool permissions: %v", err)
+			}
+		}
+		broker.SetToolCallPolicy(callPolicy)
+	}
+
+	if directoryURL != "" {
+		if publicEndpoint == "" {
+			log.Fatalf("--directory-url needs --public-endpoint")
+		}
+		broker.StartDirectorySync(protocol.NewDirectoryClient(directoryURL), DirectoryConfig{
+			Endpoint: publicEndpoint,
+			Interval: directoryInterval,
+			Peer:     directoryPeering,
+		})
+		log.Printf("Publishing to the directory at %s as %s", directoryURL, publicEndpoint)
+	}
 
 	// Generate self-signed certificate
 	cert, err := generateSelfSignedCert()
@@ -55,6 +457,7 @@ func main() {
 		Certificates: []tls.Certificate{cert},
 		MinVersion:   tls.VersionTLS13,
 	}
+	configureClientCerts(broker.tlsConfig, clientCertMode)
 
 	// Create HTTPS server
 	server := &http.Server{
@@ -63,51 +466,323 @@ func main() {
 		TLSConfig: broker.tlsConfig,
 	}
 
+	// Stop background work and flush the transparency log on shutdown
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	go func() {
+		<-ctx.Done()
+		shutdown, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		server.Shutdown(shutdown)
+	}()
+
 	log.Printf("FEM Broker starting on %s", listen)
-	log.Fatal(server.ListenAndServeTLS("", ""))
+	if err := server.ListenAndServeTLS("", ""); !errors.Is(err, http.ErrServerClosed) {
+		log.Fatal(err)
+	}
+	if err := broker.Close(); err != nil {
+		log.Printf("Failed to close broker: %v", err)
+	}
+	log.Printf("FEM Broker stopped")
 }
 
 // NewBroker creates a new broker instance
 func NewBroker() *Broker {
-	return &Broker{
-		agents:      make(map[string]*Agent),
-		mcpRegistry: NewMCPRegistry(),
+	mcpRegistry := NewMCPRegistry()
+	policy := DefaultDiscoveryPolicy()
+	b := &Broker{
+		agents:            make(map[string]*Agent),
+		mcpRegistry:       mcpRegistry,
+		events:            NewEventBus(),
+		results:           NewResultOutbox(),
+		pushes:            newResultPusher(),
+		deadlines:         newDeadlineTracker(),
+		transcoders:       newTranscoderRegistry(),
+		channels:          newChannelRegistry(),
+		approvals:         newApprovalQueue(),
+		requests:          NewRequestTracker(),
+		usedApprovals:     newApprovalNonces(),
+		streams:           NewStreamOrderer(),
+		warmup:            newWarmupTracker(),
+		federation:        NewFederationManager(mcpRegistry, nil),
+		status:            BrokerStatusActive,
+		discoveryPolicy:   policy,
+		wildcardLimiter:   newWildcardLimiter(policy.WildcardLimit, policy.WildcardWindow),
+		honeypots:         make(map[string]HoneypotTool),
+		securityEvents:    NewSecurityEventLog(),
+		audit:             NewAuditLog(),
+		quarantined:       make(map[string]*QuarantineRecord),
+		signatureFailures: make(map[string][]time.Time),
+		workers:           newWorkerPool(DefaultWorkerPoolConfig()),
+		skew:              protocol.DefaultSkewPolicy(),
+		keyRotationGrace:  defaultKeyRotationGrace,
+
+		compressionMinBytes: protocol.DefaultCompressionThreshold,
+		sizeLimits:          protocol.SizeLimits{Max: defaultMaxEnvelopeSize},
 	}
+	b.artifacts = NewArtifactStore()
+	b.checkpoints = NewCheckpointLedger(b.artifacts)
+	b.federation.Standbys().OnChange(b.publishStandbyChange)
+	return b
+}
+
+// Close stops the broker's background work
+func (b *Broker) Close() error {
+	return b.federation.Close()
+}
+
+// SetTimestampAuthority configures third-party timestamping of high-value envelopes
+func (b *Broker) SetTimestampAuthority(tsa protocol.TimestampAuthority) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.timestamps = tsa
 }
 
 // ServeHTTP implements the http.Handler interface
 func (b *Broker) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	// Health check endpoint
+	// Persistent channels agents open so calls can be pushed to them, the
+	// event streams of lightweight clients, and gRPC calls. They last as long as the client,
+	// so they do not count as requests in flight.
+	if r.URL.Path == protocol.AgentChannelPath {
+		b.handleAgentChannel(w, r)
+		return
+	}
+	if r.URL.Path == protocol.EventStreamPath {
+		b.handleEventStream(w, r)
+		return
+	}
+	if strings.HasPrefix(r.URL.Path, grpcServicePrefix) {
+		b.handleGRPC(w, r)
+		return
+	}
+
+	b.inFlight.Add(1)
+	defer b.inFlight.Add(-1)
+
+	// Advertise operating status to peers and clients
+	w.Header().Set(brokerStatusHeader, string(b.Status()))
+
+	// Liveness and readiness probes for orchestrators
+	if r.URL.Path == "/livez" && r.Method == http.MethodGet {
+		b.handleLivez(w, r)
+		return
+	}
+	if r.URL.Path == "/readyz" && r.Method == http.MethodGet {
+		b.handleReadyz(w, r)
+		return
+	}
+
+	// Legacy health check endpoint, kept for peers probing /health
 	if r.URL.Path == "/health" && r.Method == http.MethodGet {
+		if b.InMaintenance() {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			w.Write([]byte("MAINTENANCE"))
+			return
+		}
 		w.WriteHeader(http.StatusOK)
 		w.Write([]byte("OK"))
 		return
 	}
+
+	// Prometheus scrape endpoint, protected by the admin token or scoped to
+	// the tenant of a tenant token
+	if r.URL.Path == "/metrics" && r.Method == http.MethodGet {
+		tenant, ok := b.authorizeView(w, r)
+		if !ok {
+			return
+		}
+		b.handlePrometheusMetrics(w, r, tenant)
+		return
+	}
+
+	// Dashboards for admins and namespace owners
+	if r.URL.Path == dashboardPath || strings.HasPrefix(r.URL.Path, dashboardPath+"/") {
+		b.handleDashboard(w, r)
+		return
+	}
+
+	// Broker identity key for out-of-band pinning
+	if r.URL.Path == "/identity" && r.Method == http.MethodGet {
+		b.handleIdentity(w, r)
+		return
+	}
+
+	// Operator endpoints
+	if strings.HasPrefix(r.URL.Path, adminPathPrefix) {
+		b.handleAdmin(w, r)
+		return
+	}
+
+	// Registration ledger used by peers for split-brain reconciliation
+	if r.URL.Path == "/federation/registrations" && r.Method == http.MethodGet {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(b.federation.GetRegistrationRecords())
+		return
+	}
+
+	// Capability revocation list pulled by peers
+	if r.URL.Path == capabilityRevocationsPath && r.Method == http.MethodGet {
+		writeJSON(w, http.StatusOK, b.federation.CapabilityRevocations().Entries())
+		return
+	}
+
+	// Signed catalog feed of the tools agents chose to list
+	if r.URL.Path == protocol.ToolCatalogPath && r.Method == http.MethodGet {
+		b.handleToolCatalog(w, r)
+		return
+	}
+
+	// Public audit API for the registration transparency log
+	if strings.HasPrefix(r.URL.Path, transparencyPathPrefix) {
+		b.handleTransparency(w, r)
+		return
+	}
+
+	// Long-polls for the result of one call
+	if strings.HasPrefix(r.URL.Path, protocol.ResultsPath) {
+		b.handleResultRequest(w, r)
+		return
+	}
 	
 	if r.Method != http.MethodPost {
 		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 		return
 	}
 
-	// Read body
-	body, err := io.ReadAll(r.Body)
+	// Record how the envelope arrived for policies and audit records
+	r = captureTransport(r)
+	transport, _ := TransportFromRequest(r)
+
+	// Read body, refusing envelopes over every size limit before buffering them
+	limits := b.SizeLimits()
+	body, err := readEnvelopeBody(w, r, limits.Largest())
+	var tooLarge *protocol.PayloadTooLargeError
+	if errors.As(err, &tooLarge) {
+		b.rejectTooLarge(w, r, nil, tooLarge)
+		return
+	}
 	if err != nil {
-		http.Error(w, "Failed to read body", http.StatusBadRequest)
+		b.reject(w, nil, protocol.CodeInvalidEnvelope, "Failed to read body")
+		return
+	}
+
+	// Envelopes are signed before compression, so decode before parsing and verifying
+	if encoding := r.Header.Get("Content-Encoding"); encoding != "" {
+		body, err = protocol.DecompressBytes(body, protocol.ContentEncoding(encoding))
+		if err != nil {
+			b.reject(w, nil, protocol.CodeInvalidEnvelope, fmt.Sprintf("Invalid content encoding: %v", err))
+			return
+		}
+	}
+
+	// Compressed bodies count against the limits at their inflated size
+	if body, err = protocol.InflateBody(body); err != nil {
+		b.reject(w, nil, protocol.CodeInvalidEnvelope, fmt.Sprintf("Invalid envelope: %v", err))
 		return
 	}
-	defer r.Body.Close()
 
 	// Parse envelope
 	envelope, err := protocol.ParseEnvelope(body)
 	if err != nil {
-		http.Error(w, fmt.Sprintf("Invalid envelope: %v", err), http.StatusBadRequest)
+		b.rejectMalformed(w, err)
+		return
+	}
+	if err := limits.Check(envelope.Type, len(body)); errors.As(err, &tooLarge) {
+		b.rejectTooLarge(w, r, envelope, tooLarge)
 		return
 	}
 
 	// Log the received envelope
-	log.Printf("Received %s envelope from %s", envelope.Type, envelope.Agent)
+	log.Printf("Received %s envelope from %s via %s from %s", envelope.Type, envelope.Agent, transport.Transport, transport.SourceIP)
+
+	// Stale and future-dated envelopes are refused before any work is queued
+	if !b.checkClockSkew(w, envelope) {
+		return
+	}
+	// Senders over their rate limit are refused before they can fill the queues
+	if !b.checkRateLimit(w, envelope, transport.SourceIP) {
+		return
+	}
+
+	// Sign the response so agents can verify and pin the broker's identity
+	signed := newSignedResponseWriter(w)
+	signed.pooled = true
+	err = b.envelopeWorkers().Run(envelope.Type, func() {
+		// Skip work for clients that gave up while the envelope was queued
+		if r.Context().Err() != nil {
+			b.reject(signed, envelope, protocol.CodeOverloaded, "Request cancelled")
+			return
+		}
+		b.processEnvelope(signed, r, envelope)
+	})
+	if err != nil {
+		b.rejectForOverload(w, envelope, err)
+		return
+	}
+	// Waits for results run here, off the worker
+	signed.released()
+	signed.finish(b.IdentityKey(), envelope.Nonce)
+}
+
+// dispatchEnvelope routes a parsed envelope to its handler
+func (b *Broker) dispatchEnvelope(w http.ResponseWriter, r *http.Request, envelope *protocol.GenericEnvelope) {
+	// Refuse new registrations and hand discovery to peers during maintenance
+	if b.InMaintenance() {
+		switch envelope.Type {
+		case protocol.EnvelopeRegisterAgent, protocol.EnvelopeRegisterBroker:
+			b.rejectForMaintenance(w, envelope)
+			return
+		case protocol.EnvelopeDiscoverTools:
+			if b.redirectDiscovery(w, r) {
+				return
+			}
+		}
+	}
+
+	// Envelopes from clients older than the minimum version are refused outright
+	if _, err := b.negotiateVersion(envelope); err != nil {
+		b.reject(w, envelope, protocol.CodeUnsupportedVersion, err.Error())
+		return
+	}
+
+	// An agent DID is only usable with the key it names
+	if !b.checkDIDAgent(w, r, envelope) {
+		return
+	}
+
+	// Quarantined agents may re-register but are otherwise refused until released
+	if envelope.Type != protocol.EnvelopeRegisterAgent && b.IsQuarantined(envelope.Agent) {
+		b.rejectQuarantined(w, envelope)
+		return
+	}
+
+	// Operator scripts on the tool's route may rewrite or refuse calls and results
+	transport, _ := TransportFromRequest(r)
+	original := *envelope
+	rewritten, err := b.federation.applyRouteScripts(envelope, transport)
+	if err != nil {
+		var rejection *scriptRejection
+		if errors.As(err, &rejection) {
+			b.reject(w, envelope, protocol.CodeForbidden, rejection.reason)
+		} else {
+			b.reject(w, envelope, protocol.CodeInvalidBody, fmt.Sprintf("Route script failed: %v", err))
+		}
+		return
+	}
+	if rewritten {
+		r = b.vouchForSender(r, &original)
+	}
+
+	// Sequenced events and results reach subscribers and callers in sender order
+	if envelope.Seq != 0 && orderedEnvelopeTypes[envelope.Type] {
+		b.dispatchInOrder(w, r, envelope)
+		return
+	}
+	b.routeEnvelope(w, r, envelope)
+}
 
-	// Process based on envelope type
+// routeEnvelope hands an envelope to the handler for its type
+func (b *Broker) routeEnvelope(w http.ResponseWriter, r *http.Request, envelope *protocol.GenericEnvelope) {
 	switch envelope.Type {
 	case protocol.EnvelopeRegisterAgent:
 		b.handleRegisterAgent(w, envelope)
@@ -118,41 +793,108 @@ func (b *Broker) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	case protocol.EnvelopeRenderInstruction:
 		b.handleRenderInstruction(w, envelope)
 	case protocol.EnvelopeToolCall:
-		b.handleToolCall(w, envelope)
+		b.handleToolCall(w, r, envelope)
 	case protocol.EnvelopeToolResult:
-		b.handleToolResult(w, envelope)
+		b.handleToolResult(w, r, envelope)
+	case protocol.EnvelopeToolResultChunk:
+		b.handleToolResultChunk(w, r, envelope)
+	case protocol.EnvelopeRotateKey:
+		b.handleRotateKey(w, r, envelope)
+	case protocol.EnvelopeRevokeCapability:
+		b.handleRevokeCapability(w, r, envelope)
 	case protocol.EnvelopeRevoke:
 		b.handleRevoke(w, envelope)
 	// MCP Integration envelope types
 	case protocol.EnvelopeDiscoverTools:
-		b.handleDiscoverTools(w, envelope)
+		b.handleDiscoverTools(w, r, envelope)
 	case protocol.EnvelopeEmbodimentUpdate:
 		b.handleEmbodimentUpdate(w, envelope)
+	case protocol.EnvelopeHeartbeat:
+		b.handleHeartbeat(w, envelope)
+	case protocol.EnvelopeResultAck:
+		b.handleResultAck(w, r, envelope)
+	case protocol.EnvelopeSubscribe:
+		b.handleSubscribe(w, r, envelope)
+	case protocol.EnvelopeUnsubscribe:
+		b.handleUnsubscribe(w, r, envelope)
+	case protocol.EnvelopeBatch:
+		b.handleBatch(w, r, envelope)
+	case protocol.EnvelopeDrainInstance:
+		b.handleDrainInstance(w, r, envelope)
+	case protocol.EnvelopePreemptionNotice:
+		b.handlePreemptionNotice(w, envelope)
+	case protocol.EnvelopeCheckpoint:
+		b.handleCheckpoint(w, envelope)
+	case protocol.EnvelopeApproveCall:
+		b.handleApproveCall(w, r, envelope)
 	default:
-		http.Error(w, "Unknown envelope type", http.StatusBadRequest)
+		b.reject(w, envelope, protocol.CodeUnsupportedType, fmt.Sprintf("Unknown envelope type: %s", envelope.Type))
 		return
 	}
 }
 
 // handleRegisterAgent processes agent registration
 func (b *Broker) handleRegisterAgent(w http.ResponseWriter, env *protocol.GenericEnvelope) {
-	var body protocol.RegisterAgentBody
+	typed, err := protocol.ParseTyped[protocol.RegisterAgentBody](env)
+	if err != nil {
+		b.rejectInvalidBody(w, env, err)
+		return
+	}
+	body := typed.Body
 
-	if err := env.GetBodyAs(&body); err != nil {
-		http.Error(w, "Invalid body", http.StatusBadRequest)
+	// A did:key agent registers the key its ID names
+	if err := b.checkRegistrationKey(env, body); err != nil {
+		b.reject(w, env, protocol.CodeForbidden, fmt.Sprintf("Registration rejected: %v", err))
+		return
+	}
+
+	// Replicas of an agent share its key
+	if body.InstanceID != "" && !b.instanceKeyMatches(env.Agent, body) {
+		b.reject(w, env, protocol.CodeForbidden, "Instance key does not match the agent's other instances")
 		return
 	}
 
 	// Existing agent registration
+	proto, _ := b.negotiateVersion(env)
+	compression := b.negotiateCompression(body.Compression)
+
 	b.mu.Lock()
-	b.agents[env.Agent] = &Agent{
+	// A registered key is only replaced with its holder's signature; key
+	// changes otherwise go through rotateKey
+	existing, exists := b.agents[env.Agent]
+	rekeyed := exists && existing.PubKey != "" && body.PubKey != existing.PubKey
+	if rekeyed && verifyPeerIdentity(env, existing.PubKey) != nil {
+		b.mu.Unlock()
+		b.reject(w, env, protocol.CodeForbidden, fmt.Sprintf("Agent %s is registered with another key; sign the registration with that key or change it with rotateKey", env.Agent))
+		return
+	}
+	agent := &Agent{
 		ID:           env.Agent,
 		Capabilities: body.Capabilities,
 		Endpoint:     body.MCPEndpoint, // Use MCP endpoint if provided, fallback handled below
+		PubKey:       body.PubKey,
 		RegisteredAt: time.Now(),
+		TrustScore:   1.0,
+		Proto:        proto.String(),
+		Compression:  compression,
+	}
+	// Re-registering does not clear a flagged agent's record
+	if exists {
+		agent.TrustScore = existing.TrustScore
+		agent.Flagged = existing.Flagged
+		agent.PreviousPubKey = existing.PreviousPubKey
+		agent.PreviousKeyExpires = existing.PreviousKeyExpires
+	}
+	// A signed key change keeps the old key verifying, as a rotation does
+	if rekeyed {
+		agent.PreviousPubKey = existing.PubKey
+		agent.PreviousKeyExpires = time.Now().Add(b.keyRotationGrace)
 	}
+	b.agents[env.Agent] = agent
 	b.mu.Unlock()
 
+	b.federation.RecordRegistration(env, body.PubKey)
+
 	// New MCP registration if MCP endpoint provided
 	if body.MCPEndpoint != "" {
 		mcpAgent := &MCPAgent{
@@ -168,205 +910,331 @@ func (b *Broker) handleRegisterAgent(w http.ResponseWriter, env *protocol.Generi
 			mcpAgent.Tools = body.BodyDefinition.MCPTools
 		}
 
-		if err := b.mcpRegistry.RegisterAgent(env.Agent, mcpAgent); err != nil {
+		if body.InstanceID != "" {
+			instance := &AgentInstance{ID: body.InstanceID, MCPEndpoint: body.MCPEndpoint, LastHeartbeat: time.Now()}
+			if err := b.mcpRegistry.RegisterInstance(env.Agent, mcpAgent, instance); err != nil {
+				log.Printf("Failed to register MCP agent instance: %v", err)
+			} else {
+				log.Printf("Registered instance %s of MCP agent %s with endpoint %s", body.InstanceID, env.Agent, body.MCPEndpoint)
+			}
+		} else if err := b.mcpRegistry.RegisterAgent(env.Agent, mcpAgent); err != nil {
 			log.Printf("Failed to register MCP agent: %v", err)
 		} else {
 			log.Printf("Registered MCP agent %s with endpoint %s", env.Agent, body.MCPEndpoint)
 		}
 	}
 
+	// A re-registering agent starts its sequence over
+	b.streams.Reset(env.Agent)
+
 	log.Printf("Registered agent %s with capabilities %v", env.Agent, body.Capabilities)
 
-	response := map[string]interface{}{
-		"status": "registered",
-		"agent":  env.Agent,
+	ack := map[string]interface{}{
+		"agent": env.Agent,
+		"proto": proto.String(),
 	}
-
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(response)
+	if compression != nil {
+		ack["compression"] = compression
+	}
+	if body.InstanceID != "" {
+		ack["instance"] = body.InstanceID
+		ack["instances"] = len(b.mcpRegistry.Instances(env.Agent))
+	}
+	b.writeAck(w, env, "registered", ack)
 }
 
 // handleRegisterBroker processes broker registration
 func (b *Broker) handleRegisterBroker(w http.ResponseWriter, env *protocol.GenericEnvelope) {
-	var body struct {
-		Endpoint   string                 `json:"endpoint"`
-		Embodiment map[string]interface{} `json:"embodiment,omitempty"`
+	typed, err := protocol.ParseTyped[protocol.RegisterBrokerBody](env)
+	if err != nil {
+		b.rejectInvalidBody(w, env, err)
+		return
 	}
+	body := typed.Body
 
-	if err := json.Unmarshal(env.Body, &body); err != nil {
-		http.Error(w, "Invalid body", http.StatusBadRequest)
+	peer, err := b.federation.AddFederatedBroker(env, b.federation.config.DefaultPeerTrustTier)
+	if err != nil {
+		b.reject(w, env, protocol.CodeForbidden, fmt.Sprintf("Broker registration rejected: %v", err))
 		return
 	}
 
-	log.Printf("Broker registration from %s at %s", env.Agent, body.Endpoint)
+	log.Printf("Broker registration from %s at %s (trust tier %s, identity verified: %t)",
+		peer.ID, body.Endpoint, peer.TrustTier, peer.IdentityVerified)
+	b.trustPeerCapabilities(peer)
 
-	response := map[string]interface{}{
-		"status": "registered",
-		"broker": env.Agent,
-	}
+	proto, _ := b.negotiateVersion(env)
+	b.writeAck(w, env, "registered", map[string]interface{}{
+		"broker":    peer.ID,
+		"trustTier": peer.TrustTier,
+		"proto":     proto.String(),
+	})
+}
 
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(response)
+// rejectInvalidBody answers with the reason a body failed to parse or validate
+func (b *Broker) rejectInvalidBody(w http.ResponseWriter, env *protocol.GenericEnvelope, err error) {
+	b.reject(w, env, protocol.CodeInvalidBody, fmt.Sprintf("Invalid body: %v", err))
 }
 
-// handleEmitEvent processes event emissions
-func (b *Broker) handleEmitEvent(w http.ResponseWriter, env *protocol.GenericEnvelope) {
-	var body struct {
-		EventType string                 `json:"eventType"`
-		Data      map[string]interface{} `json:"data"`
+// handleRenderInstruction processes render instructions
+func (b *Broker) handleRenderInstruction(w http.ResponseWriter, env *protocol.GenericEnvelope) {
+	typed, err := protocol.ParseTyped[protocol.RenderInstructionBody](env)
+	if err != nil {
+		b.rejectInvalidBody(w, env, err)
+		return
 	}
+	body := typed.Body
 
-	if err := json.Unmarshal(env.Body, &body); err != nil {
-		http.Error(w, "Invalid body", http.StatusBadRequest)
+	log.Printf("Render instruction from %s: %s (locale %q, formats %v)",
+		env.Agent, body.Instruction, body.RenderLocale(env.CommonHeaders), body.RenderFormats(env.CommonHeaders))
+
+	b.writeAck(w, env, "rendered", nil)
+}
+
+// handleToolCall processes tool calls
+func (b *Broker) handleToolCall(w http.ResponseWriter, r *http.Request, env *protocol.GenericEnvelope) {
+	// End-to-end encrypted calls are routed on the members sent in the clear
+	parse := protocol.ParseTyped[protocol.ToolCallBody]
+	if env.Encrypted() {
+		parse = protocol.ParseClear[protocol.ToolCallBody]
+	}
+	typed, err := parse(env)
+	if err != nil {
+		b.rejectInvalidBody(w, env, err)
 		return
 	}
+	body := typed.Body
 
-	log.Printf("Event %s from %s: %v", body.EventType, env.Agent, body.Data)
+	log.Printf("Tool call %s (%s) from %s", body.Tool, body.RequestID, env.Agent)
 
-	// In a real implementation, this would fan out to subscribers
-	response := map[string]interface{}{
-		"status": "emitted",
-		"event":  body.EventType,
+	// Decoys get the same response as real tools so probing callers are not tipped off
+	if honeypot, isDecoy := b.matchHoneypot(body.Tool); isDecoy {
+		b.tripHoneypot(env, honeypot, r)
 	}
 
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(response)
-}
+	// Callers must hold a capability granting the tool when calls are gated
+	if !b.authorizeToolCall(w, r, env, &body) {
+		return
+	}
 
-// handleRenderInstruction processes render instructions
-func (b *Broker) handleRenderInstruction(w http.ResponseWriter, env *protocol.GenericEnvelope) {
-	var body struct {
-		Instruction string                 `json:"instruction"`
-		Context     map[string]interface{} `json:"context,omitempty"`
+	// Refuse calls whose data no provider can keep inside the caller's region
+	if body.DataRegion != "" {
+		var violation *ResidencyViolation
+		if err := b.federation.CheckResidency(body.Tool, body.DataRegion); errors.As(err, &violation) {
+			b.rejectResidency(w, env, violation)
+			return
+		}
 	}
 
-	if err := json.Unmarshal(env.Body, &body); err != nil {
-		http.Error(w, "Invalid body", http.StatusBadRequest)
+	// Refuse calls no provider has the resources free for; callers retry later
+	if !b.admitToolCall(w, env, body.Tool) {
 		return
 	}
 
-	log.Printf("Render instruction from %s: %s", env.Agent, body.Instruction)
-
-	response := map[string]interface{}{
-		"status": "rendered",
+	// Refuse calls the caller's tenant cannot afford on any provider
+	if !b.checkBudget(w, env, body.Tool) {
+		return
 	}
 
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(response)
-}
+	// Results published to a topic need the caller's right to publish there
+	if !b.checkResultDelivery(w, env, body.ResultDelivery) {
+		return
+	}
 
-// handleToolCall processes tool calls
-func (b *Broker) handleToolCall(w http.ResponseWriter, env *protocol.GenericEnvelope) {
-	var body struct {
-		Tool       string                 `json:"tool"`
-		Parameters map[string]interface{} `json:"parameters"`
+	// Calls to dangerous tools need someone to approve them
+	dangerous := b.requiresApproval(body.Tool)
+	if dangerous && !b.checkApprovers(w, env, body.Tool) {
+		return
 	}
 
-	if err := json.Unmarshal(env.Body, &body); err != nil {
-		http.Error(w, "Invalid body", http.StatusBadRequest)
+	// Hold the result for the caller once the answering agent sends it
+	if err := b.results.ExpectResult(body.RequestID, env.Agent, body.Delivery); err != nil {
+		b.reject(w, env, protocol.CodeInvalidBody, err.Error())
+		return
+	}
+	// The caller's tenant pays the answering agent's price
+	b.federation.ExpectCharge(body.RequestID, env.Agent, body.Tool)
+	// Follow the call until it is answered or orphaned
+	b.requests.Receive(body.RequestID, env.Agent, body.Tool)
+	// Answer on the agent's behalf if the caller's deadline passes first
+	b.watchDeadline(body)
+	// Convert the result if the agent answers in a type the caller does not accept
+	b.transcoders.expect(body.RequestID, body.Accept)
+
+	// Calls to dangerous tools are parked until an approver decides. Sync
+	// callers are not kept waiting; their result is held to be polled for.
+	if dangerous {
+		switch body.ResultDelivery.ModeOrDefault() {
+		case protocol.ResultDeliveryWebhook, protocol.ResultDeliveryEvent:
+			b.pushes.expect(body.RequestID, env.Agent, *body.ResultDelivery, body.Delivery)
+		}
+		b.requests.Advance(body.RequestID, RequestAwaitingApproval, "", "")
+		b.writeAck(w, env, "pending_approval", b.parkCall(env, body))
 		return
 	}
 
-	log.Printf("Tool call %s from %s", body.Tool, env.Agent)
+	// Providers holding a channel open are sent the call directly
+	pushedTo, pushed := b.pushCall(env, body.Tool)
+	if pushed {
+		b.requests.Advance(body.RequestID, RequestRouted, pushedTo, "pushed over its channel")
+	}
 
-	// In a real implementation, this would route to the appropriate tool handler
-	response := map[string]interface{}{
-		"status": "processing",
-		"tool":   body.Tool,
+	switch body.ResultDelivery.ModeOrDefault() {
+	case protocol.ResultDeliverySync:
+		b.awaitResult(w, r, env, body)
+		return
+	case protocol.ResultDeliveryWebhook, protocol.ResultDeliveryEvent:
+		b.pushes.expect(body.RequestID, env.Agent, *body.ResultDelivery, body.Delivery)
 	}
 
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(response)
+	// In a real implementation, this would route to the appropriate tool handler
+	ack := map[string]interface{}{
+		"tool":      body.Tool,
+		"requestId": body.RequestID,
+	}
+	if pushed {
+		ack["agent"] = pushedTo
+	}
+	b.writeAck(w, env, "processing", ack)
 }
 
-// handleToolResult processes tool results
-func (b *Broker) handleToolResult(w http.ResponseWriter, env *protocol.GenericEnvelope) {
+// handleToolResult processes tool results. Metrics are keyed by tool; results
+// carrying protocol.ToolResultBody's request ID are also held for the caller,
+// once checked to come from the agent the call was routed to.
+func (b *Broker) handleToolResult(w http.ResponseWriter, r *http.Request, env *protocol.GenericEnvelope) {
 	var body struct {
-		Tool   string      `json:"tool"`
-		Result interface{} `json:"result"`
-		Error  string      `json:"error,omitempty"`
+		Tool      string      `json:"tool"`
+		RequestID string      `json:"requestId,omitempty"`
+		Result    interface{} `json:"result"`
+		Error     string      `json:"error,omitempty"`
 	}
 
 	if err := json.Unmarshal(env.Body, &body); err != nil {
-		http.Error(w, "Invalid body", http.StatusBadRequest)
+		b.rejectInvalidBody(w, env, err)
+		return
+	}
+	if !b.checkResultSender(w, r, env, body.RequestID) {
 		return
 	}
 
 	log.Printf("Tool result for %s from %s", body.Tool, env.Agent)
+	if body.Error != "" {
+		b.federation.RecordToolError(env.Agent, body.Tool, body.Error)
+	}
 
-	response := map[string]interface{}{
-		"status": "received",
-		"tool":   body.Tool,
+	held := false
+	if body.RequestID != "" {
+		b.federation.Reservations().Release(body.RequestID, env.Agent)
+		b.federation.SettleCharge(body.RequestID, env.Agent)
+		b.checkpoints.Complete(body.RequestID)
+		b.deadlines.finish(body.RequestID)
+		b.requests.Finish(body.RequestID, env.Agent, body.Error)
+		raw := b.transcodeResult(env, body.RequestID)
+		if raw == nil {
+			var err error
+			if raw, err = json.Marshal(env); err != nil {
+				b.reject(w, env, protocol.CodeInternal, "Failed to store result")
+				return
+			}
+		}
+		held = b.results.StoreResult(body.RequestID, raw)
+		if held {
+			b.pushResult(body.RequestID, 0, true, raw)
+		}
 	}
 
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(response)
+	b.writeAck(w, env, "received", map[string]interface{}{
+		"tool": body.Tool,
+		"held": held,
+	})
 }
 
 // handleRevoke processes revocation
 func (b *Broker) handleRevoke(w http.ResponseWriter, env *protocol.GenericEnvelope) {
-	var body struct {
-		Target string `json:"target"`
-		Reason string `json:"reason"`
+	typed, err := protocol.ParseTyped[protocol.RevokeBody](env)
+	if err != nil {
+		b.rejectInvalidBody(w, env, err)
+		return
 	}
+	body := typed.Body
 
-	if err := json.Unmarshal(env.Body, &body); err != nil {
-		http.Error(w, "Invalid body", http.StatusBadRequest)
+	if !b.checkRevocationQuorum(w, env, body.Target) {
 		return
 	}
 
 	b.mu.Lock()
 	delete(b.agents, body.Target)
+	tsa := b.timestamps
 	b.mu.Unlock()
+	b.events.RemoveSubscriptions(body.Target, "", "")
+
+	// Attach time evidence before the revocation is recorded and shared with peers
+	if tsa != nil {
+		if err := env.TimestampEnvelope(tsa); err != nil {
+			log.Printf("Failed to timestamp revocation of %s: %v", body.Target, err)
+		}
+	}
+
+	b.federation.RecordRevocation(env, body.Target)
 
 	log.Printf("Revoked %s for reason: %s", body.Target, body.Reason)
 
-	response := map[string]interface{}{
-		"status": "revoked",
+	result := map[string]interface{}{
 		"target": body.Target,
 	}
-
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(response)
+	if env.Timestamp != nil {
+		result["timestamp"] = env.Timestamp
+	}
+	b.writeAck(w, env, "revoked", result)
 }
 
 // handleDiscoverTools processes MCP tool discovery requests
-func (b *Broker) handleDiscoverTools(w http.ResponseWriter, env *protocol.GenericEnvelope) {
-	var discoverBody protocol.DiscoverToolsBody
-	if err := env.GetBodyAs(&discoverBody); err != nil {
-		http.Error(w, "Invalid discovery request", http.StatusBadRequest)
+func (b *Broker) handleDiscoverTools(w http.ResponseWriter, r *http.Request, env *protocol.GenericEnvelope) {
+	typed, err := protocol.ParseTyped[protocol.DiscoverToolsBody](env)
+	if err != nil {
+		b.rejectInvalidBody(w, env, err)
 		return
 	}
+	discoverBody := typed.Body
 
-	log.Printf("Tool discovery request from %s: %+v", env.Agent, discoverBody.Query)
+	grant, ok := b.authorizeDiscovery(w, r, env, &discoverBody)
+	if !ok {
+		return
+	}
+
+	log.Printf("Tool discovery request from %s: %+v", grant.Caller, discoverBody.Query)
 
 	discoveredTools, err := b.mcpRegistry.DiscoverTools(discoverBody.Query)
 	if err != nil {
-		http.Error(w, "Discovery failed", http.StatusInternalServerError)
+		b.reject(w, env, protocol.CodeInternal, "Discovery failed")
 		return
 	}
+	b.federation.annotateToolMetadata(discoveredTools)
 
-	log.Printf("Found %d tools matching query", len(discoveredTools))
+	// Include tools from peers whose trust tier allows discovery
+	discoveredTools = append(discoveredTools, b.federation.DiscoverRemoteTools(discoverBody.Query)...)
+	discoveredTools = b.scopeDiscoveredTools(discoveredTools, grant.Scope)
+	discoveredTools = b.hideQuarantinedTools(discoveredTools)
+	discoveredTools = b.federation.hideStandbyTools(discoveredTools)
 
-	response := map[string]interface{}{
-		"status":       "success",
-		"requestId":    discoverBody.RequestID,
-		"tools":        discoveredTools,
-		"totalResults": len(discoveredTools),
-		"hasMore":      false,
-	}
+	log.Printf("Found %d tools matching query", len(discoveredTools))
 
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(response)
+	b.writeAck(w, env, "success", protocol.ToolsDiscoveredBody{
+		RequestID:    discoverBody.RequestID,
+		Tools:        discoveredTools,
+		TotalResults: len(discoveredTools),
+		HasMore:      false,
+		Partial:      b.warming(),
+	})
 }
 
 // handleEmbodimentUpdate processes agent embodiment changes
 func (b *Broker) handleEmbodimentUpdate(w http.ResponseWriter, env *protocol.GenericEnvelope) {
-	var updateBody protocol.EmbodimentUpdateBody
-	if err := env.GetBodyAs(&updateBody); err != nil {
-		http.Error(w, "Invalid embodiment update", http.StatusBadRequest)
+	typed, err := protocol.ParseTyped[protocol.EmbodimentUpdateBody](env)
+	if err != nil {
+		b.rejectInvalidBody(w, env, err)
 		return
 	}
+	updateBody := typed.Body
 
 	log.Printf("Embodiment update from %s: environment=%s", env.Agent, updateBody.EnvironmentType)
 
@@ -384,13 +1252,9 @@ func (b *Broker) handleEmbodimentUpdate(w http.ResponseWriter, env *protocol.Gen
 		log.Printf("Updated embodiment for agent %s", env.Agent)
 	}
 
-	response := map[string]interface{}{
-		"status": "updated",
-		"agent":  env.Agent,
-	}
-
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(response)
+	b.writeAck(w, env, "updated", map[string]interface{}{
+		"agent": env.Agent,
+	})
 }
 
 // generateSelfSignedCert generates a self-signed certificate for TLS
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"sort"
+
+	"github.com/fep-fem/protocol"
+)
+
+// brokerStatusHeader advertises the broker's status to peers and clients on every response
+const brokerStatusHeader = "X-FEM-Broker-Status"
+
+// Status returns the broker's current operating status
+func (b *Broker) Status() BrokerStatus {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	return b.status
+}
+
+// EnterMaintenance puts the broker into maintenance mode. New registrations are
+// refused and discovery is redirected to peers, while in-flight requests and
+// calls for already registered agents continue to be served.
+func (b *Broker) EnterMaintenance() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.status != BrokerStatusMaintenance {
+		log.Printf("Broker entering maintenance mode (%d requests in flight)", b.inFlight.Load())
+	}
+	b.status = BrokerStatusMaintenance
+}
+
+// ExitMaintenance reopens the broker for registrations and discovery
+func (b *Broker) ExitMaintenance() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.status == BrokerStatusMaintenance {
+		log.Printf("Broker leaving maintenance mode")
+	}
+	b.status = BrokerStatusActive
+}
+
+// InMaintenance reports whether the broker is in maintenance mode
+func (b *Broker) InMaintenance() bool {
+	return b.Status() == BrokerStatusMaintenance
+}
+
+// handleAdminMaintenance reports or changes the maintenance state
+func (b *Broker) handleAdminMaintenance(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPost:
+		var req struct {
+			Enabled bool `json:"enabled"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid body", http.StatusBadRequest)
+			return
+		}
+		if req.Enabled {
+			b.EnterMaintenance()
+		} else {
+			b.ExitMaintenance()
+		}
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"status":   b.Status(),
+		"inFlight": b.inFlight.Load(),
+	})
+}
+
+// rejectForMaintenance refuses a request that is not accepted during maintenance
+func (b *Broker) rejectForMaintenance(w http.ResponseWriter, env *protocol.GenericEnvelope) {
+	rejection := b.newError(env, protocol.CodeMaintenance, "broker is in maintenance mode")
+	rejection.Body.Details = map[string]interface{}{
+		"peers": b.federation.maintenancePeers(),
+	}
+	writeError(w, rejection)
+}
+
+// redirectDiscovery forwards a discovery request to a peer while in maintenance.
+// It returns false if no peer can take the request.
+func (b *Broker) redirectDiscovery(w http.ResponseWriter, r *http.Request) bool {
+	peers := b.federation.maintenancePeers()
+	if len(peers) == 0 {
+		return false
+	}
+
+	// 307 preserves the method and signed envelope body
+	http.Redirect(w, r, peers[0], http.StatusTemporaryRedirect)
+	return true
+}
+
+// maintenancePeers lists endpoints of active peers that can serve discovery
+// on this broker's behalf
+func (fm *FederationManager) maintenancePeers() []string {
+	fm.topologyMutex.RLock()
+	defer fm.topologyMutex.RUnlock()
+
+	endpoints := make([]string, 0)
+	for _, broker := range fm.federatedBrokers {
+		if broker.Status == BrokerStatusActive && broker.TrustTier.AllowsRouting() && broker.Endpoint != "" {
+			endpoints = append(endpoints, broker.Endpoint)
+		}
+	}
+	sort.Strings(endpoints)
+	return endpoints
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"bytes"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/fep-fem/protocol"
+)
+
+func TestBrokerMaintenanceMode(t *testing.T) {
+	broker := NewBroker()
+	broker.SetAdminToken("secret")
+	broker.SetDiscoveryPolicy(&DiscoveryPolicy{AllowAnonymous: true})
+	server := httptest.NewTLSServer(broker)
+	defer server.Close()
+
+	client := &http.Client{
+		Transport: &http.Transport{
+			TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
+		},
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+
+	setMaintenance := func(token string, enabled bool) *http.Response {
+		body := strings.NewReader(fmt.Sprintf(`{"enabled": %t}`, enabled))
+		req, _ := http.NewRequest(http.MethodPost, server.URL+"/admin/maintenance", body)
+		req.Header.Set("Authorization", "Bearer "+token)
+		resp, err := client.Do(req)
+		if err != nil {
+			t.Fatalf("Failed to call admin API: %v", err)
+		}
+		resp.Body.Close()
+		return resp
+	}
+
+	postEnvelope := func(envType protocol.EnvelopeType, body interface{}) *http.Response {
+		raw, _ := json.Marshal(body)
+		envelope := map[string]interface{}{
+			"type":  envType,
+			"agent": "test-agent",
+			"ts":    time.Now().UnixMilli(),
+			"nonce": "maintenance",
+			"body":  json.RawMessage(raw),
+		}
+		data, _ := json.Marshal(envelope)
+		resp, err := client.Post(server.URL+"/", "application/json", bytes.NewReader(data))
+		if err != nil {
+			t.Fatalf("Failed to send envelope: %v", err)
+		}
+		resp.Body.Close()
+		return resp
+	}
+
+	if resp := setMaintenance("wrong", true); resp.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("Expected 401 for bad admin token, got %d", resp.StatusCode)
+	}
+	if broker.InMaintenance() {
+		t.Fatal("Unauthorized request must not change state")
+	}
+
+	if resp := setMaintenance("secret", true); resp.StatusCode != http.StatusOK {
+		t.Fatalf("Expected 200 entering maintenance, got %d", resp.StatusCode)
+	}
+
+	resp, err := client.Get(server.URL + "/health")
+	if err != nil {
+		t.Fatalf("Health check failed: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusServiceUnavailable {
+		t.Errorf("Expected 503 health during maintenance, got %d", resp.StatusCode)
+	}
+	if resp.Header.Get(brokerStatusHeader) != string(BrokerStatusMaintenance) {
+		t.Errorf("Expected maintenance status header, got %q", resp.Header.Get(brokerStatusHeader))
+	}
+
+	register := protocol.RegisterAgentBody{PubKey: "key", Capabilities: []string{"math.add"}}
+	if resp := postEnvelope(protocol.EnvelopeRegisterAgent, register); resp.StatusCode != http.StatusServiceUnavailable {
+		t.Errorf("Expected registration to be refused, got %d", resp.StatusCode)
+	}
+
+	// Without peers, discovery is still served locally
+	discover := protocol.DiscoverToolsBody{Query: protocol.ToolQuery{Capabilities: []string{"*"}}}
+	if resp := postEnvelope(protocol.EnvelopeDiscoverTools, discover); resp.StatusCode != http.StatusOK {
+		t.Errorf("Expected local discovery without peers, got %d", resp.StatusCode)
+	}
+
+	// With a routable peer, discovery is redirected
+	broker.federation.federatedBrokers["peer-1"] = &FederatedBroker{
+		ID:        "peer-1",
+		Endpoint:  "https://peer-1.example.com",
+		Status:    BrokerStatusActive,
+		TrustTier: PeerTrustFull,
+	}
+	resp = postEnvelope(protocol.EnvelopeDiscoverTools, discover)
+	if resp.StatusCode != http.StatusTemporaryRedirect {
+		t.Errorf("Expected discovery redirect, got %d", resp.StatusCode)
+	}
+	if resp.Header.Get("Location") != "https://peer-1.example.com" {
+		t.Errorf("Unexpected redirect location %q", resp.Header.Get("Location"))
+	}
+
+	// Other envelopes keep flowing
+	call := protocol.ToolCallBody{Tool: "math.add", RequestID: "req-1"}
+	if resp := postEnvelope(protocol.EnvelopeToolCall, call); resp.StatusCode != http.StatusOK {
+		t.Errorf("Expected tool call to be served, got %d", resp.StatusCode)
+	}
+
+	setMaintenance("secret", false)
+	if resp := postEnvelope(protocol.EnvelopeRegisterAgent, register); resp.StatusCode != http.StatusOK {
+		t.Errorf("Expected registration after reopening, got %d", resp.StatusCode)
+	}
+}
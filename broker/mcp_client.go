@@ -2,10 +2,13 @@ package main
 
 import (
 	"bytes"
+	"context"
 	"crypto/ed25519"
 	"crypto/tls"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"net/http"
 	"sync"
 	"time"
@@ -13,12 +16,21 @@ import (
 	"github.com/fep-fem/protocol"
 )
 
+// Long-polling for the result of one call
+const (
+	maxResultPoll    = 30 * time.Second // Most a single poll asks the broker to hold it
+	resultPollMargin = 5 * time.Second  // Left of the request timeout for the broker to answer
+)
+
 // MCPClient provides high-level interface for discovering and using MCP tools
 type MCPClient struct {
 	agentID     string
 	brokerURL   string
 	privateKey  ed25519.PrivateKey
 	httpClient  *http.Client
+	compression protocol.ContentEncoding
+	pins        *protocol.BrokerPins
+	capability  string // Capability token presented with tool calls
 	
 	// Tool discovery cache
 	toolCache   map[string]*CachedToolResult
@@ -28,6 +40,12 @@ type MCPClient struct {
 	// Request management
 	requestID   int64
 	requestMutex sync.Mutex
+
+	// Result delivery
+	resultDelivery protocol.DeliveryGuarantee
+	pendingAcks    []string // Request IDs of at-least-once results to acknowledge
+	dedupe         *protocol.ResultDeduper
+	resultMutex    sync.Mutex
 }
 
 // CachedToolResult stores discovered tools with expiration
@@ -45,6 +63,12 @@ type MCPClientConfig struct {
 	CacheExpiry    time.Duration
 	RequestTimeout time.Duration
 	TLSInsecure    bool
+	Compression    protocol.ContentEncoding // Content encoding applied to signed requests
+	Pins           *protocol.BrokerPins     // Broker identity pins; in-memory TOFU if nil
+	ResultDelivery protocol.DeliveryGuarantee // Delivery guarantee requested for tool results; at-most-once if empty
+	Capability     string                     // Capability token presented with tool calls, for brokers that gate them
+	ClientCert     *tls.Certificate           // Identity certificate presented to brokers that ask for one
+	Proxy          protocol.ProxyFunc         // Picks the proxy requests go through; HTTPS_PROXY, HTTP_PROXY and NO_PROXY if nil
 }
 
 // NewMCPClient creates a new MCP client instance
@@ -56,17 +80,36 @@ func NewMCPClient(config MCPClientConfig) *MCPClient {
 		config.RequestTimeout = 30 * time.Second
 	}
 
-	transport := &http.Transport{}
+	if config.Pins == nil {
+		config.Pins = protocol.NewBrokerPins(nil, protocol.PinModeEnforce)
+	}
+
+	if config.Proxy == nil {
+		config.Proxy = http.ProxyFromEnvironment
+	}
+
+	transport := &http.Transport{Proxy: config.Proxy}
 	if config.TLSInsecure {
 		transport.TLSClientConfig = &tls.Config{InsecureSkipVerify: true}
 	}
+	if config.ClientCert != nil {
+		if transport.TLSClientConfig == nil {
+			transport.TLSClientConfig = &tls.Config{}
+		}
+		transport.TLSClientConfig.Certificates = []tls.Certificate{*config.ClientCert}
+	}
 
 	return &MCPClient{
 		agentID:     config.AgentID,
 		brokerURL:   config.BrokerURL,
 		privateKey:  config.PrivateKey,
+		compression: config.Compression,
+		pins:        config.Pins,
+		capability:  config.Capability,
 		toolCache:   make(map[string]*CachedToolResult),
 		cacheExpiry: config.CacheExpiry,
+		resultDelivery: config.ResultDelivery,
+		dedupe:         protocol.NewResultDeduper(0),
 		httpClient: &http.Client{
 			Transport: transport,
 			Timeout:   config.RequestTimeout,
@@ -82,28 +125,13 @@ func (c *MCPClient) DiscoverTools(query protocol.ToolQuery) ([]protocol.Discover
 		return cached.Tools, nil
 	}
 
-	// Generate request ID
-	requestID := c.generateRequestID()
-
-	// Create discovery envelope
-	envelope := &protocol.DiscoverToolsEnvelope{
-		BaseEnvelope: protocol.BaseEnvelope{
-			Type: protocol.EnvelopeDiscoverTools,
-			CommonHeaders: protocol.CommonHeaders{
-				Agent: c.agentID,
-				TS:    time.Now().UnixMilli(),
-				Nonce: c.generateNonce(),
-			},
-		},
-		Body: protocol.DiscoverToolsBody{
-			Query:     query,
-			RequestID: requestID,
-		},
-	}
-
-	// Sign the envelope
-	if err := envelope.Sign(c.privateKey); err != nil {
-		return nil, fmt.Errorf("failed to sign discovery request: %w", err)
+	// Create and sign discovery envelope
+	envelope, err := protocol.NewDiscoverTools(c.agentID).
+		Query(query).
+		RequestID(c.generateRequestID()).
+		SignWith(c.privateKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to build discovery request: %w", err)
 	}
 
 	// Send request to broker
@@ -113,26 +141,13 @@ func (c *MCPClient) DiscoverTools(query protocol.ToolQuery) ([]protocol.Discover
 	}
 
 	// Parse tools from response
-	tools, ok := response["tools"].([]interface{})
-	if !ok {
-		return nil, fmt.Errorf("invalid response format: missing tools array")
+	var discovered protocol.ToolsDiscoveredBody
+	if err := response.ResultAs(&discovered); err != nil {
+		return nil, fmt.Errorf("invalid discovery result: %w", err)
 	}
-
-	// Convert to DiscoveredTool structs
-	discoveredTools := make([]protocol.DiscoveredTool, 0, len(tools))
-	for _, toolData := range tools {
-		toolMap, ok := toolData.(map[string]interface{})
-		if !ok {
-			continue
-		}
-
-		// Convert to DiscoveredTool
-		toolBytes, _ := json.Marshal(toolMap)
-		var discoveredTool protocol.DiscoveredTool
-		if err := json.Unmarshal(toolBytes, &discoveredTool); err != nil {
-			continue
-		}
-		discoveredTools = append(discoveredTools, discoveredTool)
+	discoveredTools := discovered.Tools
+	if discoveredTools == nil {
+		discoveredTools = make([]protocol.DiscoveredTool, 0)
 	}
 
 	// Cache the result
@@ -163,28 +178,16 @@ func (c *MCPClient) FindToolsInEnvironment(environmentType string, maxResults in
 
 // CallTool invokes a specific MCP tool through its agent
 func (c *MCPClient) CallTool(agentID, toolName string, parameters map[string]interface{}) (interface{}, error) {
-	requestID := c.generateRequestID()
-
-	// Create tool call envelope
-	envelope := &protocol.ToolCallEnvelope{
-		BaseEnvelope: protocol.BaseEnvelope{
-			Type: protocol.EnvelopeToolCall,
-			CommonHeaders: protocol.CommonHeaders{
-				Agent: c.agentID,
-				TS:    time.Now().UnixMilli(),
-				Nonce: c.generateNonce(),
-			},
-		},
-		Body: protocol.ToolCallBody{
-			Tool:       fmt.Sprintf("%s/%s", agentID, toolName),
-			Parameters: parameters,
-			RequestID:  requestID,
-		},
-	}
-
-	// Sign the envelope
-	if err := envelope.Sign(c.privateKey); err != nil {
-		return nil, fmt.Errorf("failed to sign tool call: %w", err)
+	// Create and sign tool call envelope
+	envelope, err := protocol.NewToolCall(c.agentID).
+		Tool(fmt.Sprintf("%s/%s", agentID, toolName)).
+		Params(parameters).
+		RequestID(c.generateRequestID()).
+		Capability(c.capability).
+		Delivery(c.resultDelivery).
+		SignWith(c.privateKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to build tool call: %w", err)
 	}
 
 	// Send request to broker
@@ -193,13 +196,176 @@ func (c *MCPClient) CallTool(agentID, toolName string, parameters map[string]int
 		return nil, fmt.Errorf("failed to send tool call: %w", err)
 	}
 
-	// Check for success
-	if status, ok := response["status"].(string); ok && status == "processing" {
-		// In a real implementation, this would poll for results or use webhooks
+	// Check for success; the result is collected with WaitForResult, under
+	// the requestId the ack carries
+	if response.Status == "processing" {
 		return response, nil
 	}
 
-	return nil, fmt.Errorf("tool call failed: %v", response)
+	return nil, fmt.Errorf("tool call failed: %s", response.Status)
+}
+
+// WaitForResult long-polls the broker for the result of the call sent with
+// requestID until it arrives or ctx is done. The result is handed over once,
+// so CollectResults does not return it again.
+func (c *MCPClient) WaitForResult(ctx context.Context, requestID string) (*protocol.DeliveredResult, error) {
+	resultURL, err := protocol.ResultURL(c.brokerURL, requestID)
+	if err != nil {
+		return nil, err
+	}
+	for {
+		// Each poll is held no longer than the client's request timeout allows
+		wait := maxResultPoll
+		if timeout := c.httpClient.Timeout; timeout > 0 {
+			wait = min(wait, timeout-resultPollMargin)
+		}
+		if deadline, ok := ctx.Deadline(); ok {
+			wait = min(wait, time.Until(deadline))
+		}
+		envelope := protocol.NewTypedEnvelope(c.agentID, protocol.ResultAckBody{Wait: max(int64(wait/time.Second), 1)})
+		if err := envelope.Sign(c.privateKey); err != nil {
+			return nil, fmt.Errorf("failed to sign result request: %w", err)
+		}
+		header, err := protocol.EncodeResultRequest(envelope)
+		if err != nil {
+			return nil, fmt.Errorf("failed to encode result request: %w", err)
+		}
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, resultURL, nil)
+		if err != nil {
+			return nil, fmt.Errorf("failed to build HTTP request: %w", err)
+		}
+		req.Header.Set(protocol.ResultRequestHeader, header)
+
+		response, err := c.doRequest(req, envelope.Nonce)
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return nil, ctxErr
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to wait for result: %w", err)
+		}
+		if response.Status == "processing" {
+			continue
+		}
+		var result protocol.DeliveredResult
+		if err := response.ResultAs(&result); err != nil {
+			return nil, fmt.Errorf("invalid result: %w", err)
+		}
+		c.resultMutex.Lock()
+		c.dedupe.FirstDelivery(result.Key())
+		c.resultMutex.Unlock()
+		return &result, nil
+	}
+}
+
+// CollectResults acknowledges the results returned by the previous call and
+// fetches the tool results the broker holds for this client, up to maxResults
+// (0 for all). At-least-once results are redelivered until acknowledged, so
+// results already returned are filtered out and each is returned once.
+// Chunks of streamed results have Chunk set and arrive in seq order.
+func (c *MCPClient) CollectResults(maxResults int) ([]protocol.DeliveredResult, error) {
+	c.resultMutex.Lock()
+	acks := append([]string(nil), c.pendingAcks...)
+	c.resultMutex.Unlock()
+
+	envelope := protocol.NewTypedEnvelope(c.agentID, protocol.ResultAckBody{Acknowledged: acks, MaxResults: maxResults})
+	if err := envelope.Sign(c.privateKey); err != nil {
+		return nil, fmt.Errorf("failed to sign result acknowledgement: %w", err)
+	}
+	response, err := c.sendRequest(envelope)
+	if err != nil {
+		// Unsent acknowledgements are retried on the next call
+		return nil, fmt.Errorf("failed to collect results: %w", err)
+	}
+	var delivery protocol.ResultDeliveryBody
+	if err := response.ResultAs(&delivery); err != nil {
+		return nil, fmt.Errorf("invalid result delivery: %w", err)
+	}
+
+	c.resultMutex.Lock()
+	defer c.resultMutex.Unlock()
+	c.pendingAcks = c.pendingAcks[len(acks):]
+	fresh := make([]protocol.DeliveredResult, 0, len(delivery.Results))
+	for _, result := range delivery.Results {
+		if result.Delivery == protocol.DeliverAtLeastOnce {
+			c.pendingAcks = append(c.pendingAcks, result.Key())
+		}
+		if c.dedupe.FirstDelivery(result.Key()) {
+			fresh = append(fresh, result)
+		}
+	}
+	return fresh, nil
+}
+
+// Subscribe declares interest in events on topics matching pattern and
+// returns the subscription ID. Subscribing to the same pattern again returns
+// the existing subscription.
+func (c *MCPClient) Subscribe(pattern, capability string) (string, error) {
+	envelope := protocol.NewTypedEnvelope(c.agentID, protocol.SubscribeBody{Pattern: pattern, Capability: capability})
+	if err := envelope.Sign(c.privateKey); err != nil {
+		return "", fmt.Errorf("failed to sign subscription: %w", err)
+	}
+	response, err := c.sendRequest(envelope)
+	if err != nil {
+		return "", fmt.Errorf("subscribe failed: %w", err)
+	}
+	var result struct {
+		SubscriptionID string `json:"subscriptionId"`
+	}
+	if err := response.ResultAs(&result); err != nil {
+		return "", fmt.Errorf("invalid subscribe response: %w", err)
+	}
+	return result.SubscriptionID, nil
+}
+
+// Unsubscribe removes a subscription returned by Subscribe
+func (c *MCPClient) Unsubscribe(subscriptionID string) error {
+	envelope := protocol.NewTypedEnvelope(c.agentID, protocol.UnsubscribeBody{SubscriptionID: subscriptionID})
+	if err := envelope.Sign(c.privateKey); err != nil {
+		return fmt.Errorf("failed to sign unsubscribe: %w", err)
+	}
+	if _, err := c.sendRequest(envelope); err != nil {
+		return fmt.Errorf("unsubscribe failed: %w", err)
+	}
+	return nil
+}
+
+// RevokeCapability revokes a capability token this agent was issued or
+// delegated, before it expires
+func (c *MCPClient) RevokeCapability(capability, reason string) error {
+	tokenID, err := protocol.CapabilityTokenID(capability)
+	if err != nil {
+		return fmt.Errorf("invalid capability token: %w", err)
+	}
+	envelope := protocol.NewTypedEnvelope(c.agentID, protocol.RevokeCapabilityBody{TokenID: tokenID, Capability: capability, Reason: reason})
+	if err := envelope.Sign(c.privateKey); err != nil {
+		return fmt.Errorf("failed to sign capability revocation: %w", err)
+	}
+	if _, err := c.sendRequest(envelope); err != nil {
+		return fmt.Errorf("capability revocation failed: %w", err)
+	}
+	return nil
+}
+
+// SendBatch submits signed envelopes in one request. The broker processes
+// them in order; a rejected envelope is reported in its result, not as an error.
+func (c *MCPClient) SendBatch(envelopes ...interface{}) (*protocol.BatchResultBody, error) {
+	builder := protocol.NewBatch(c.agentID)
+	for _, envelope := range envelopes {
+		builder.Add(envelope)
+	}
+	batch, err := builder.SignWith(c.privateKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to build batch: %w", err)
+	}
+	response, err := c.sendRequest(batch)
+	if err != nil {
+		return nil, fmt.Errorf("batch failed: %w", err)
+	}
+	var result protocol.BatchResultBody
+	if err := response.ResultAs(&result); err != nil {
+		return nil, fmt.Errorf("invalid batch result: %w", err)
+	}
+	return &result, nil
 }
 
 // GetAvailableAgents returns a list of all agents that have MCP tools
@@ -207,6 +373,11 @@ func (c *MCPClient) GetAvailableAgents() ([]protocol.DiscoveredTool, error) {
 	return c.FindToolsByCapability([]string{"*"})
 }
 
+// RepinBroker accepts a new broker identity key after a legitimate rotation
+func (c *MCPClient) RepinBroker(publicKey ed25519.PublicKey) error {
+	return c.pins.Repin(c.brokerURL, publicKey)
+}
+
 // RefreshCache clears the tool discovery cache
 func (c *MCPClient) RefreshCache() {
 	c.cacheMutex.Lock()
@@ -214,29 +385,66 @@ func (c *MCPClient) RefreshCache() {
 	c.toolCache = make(map[string]*CachedToolResult)
 }
 
-// sendRequest sends an envelope to the broker and returns the response
-func (c *MCPClient) sendRequest(envelope interface{}) (map[string]interface{}, error) {
-	// Marshal envelope
-	data, err := json.Marshal(envelope)
+// sendRequest posts a signed envelope and returns the broker's ack. A broker
+// rejection is returned as a wrapped *protocol.ErrorBody.
+func (c *MCPClient) sendRequest(envelope interface{}) (*protocol.AckBody, error) {
+	// Marshal envelope (already signed) and apply the content encoding
+	raw, err := json.Marshal(envelope)
 	if err != nil {
 		return nil, fmt.Errorf("failed to marshal request: %w", err)
 	}
+	var headers protocol.CommonHeaders
+	if err := json.Unmarshal(raw, &headers); err != nil {
+		return nil, fmt.Errorf("failed to read request headers: %w", err)
+	}
+	data, err := protocol.CompressBytes(raw, c.compression)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode request: %w", err)
+	}
 
 	// Send HTTP POST request
-	resp, err := c.httpClient.Post(c.brokerURL, "application/json", bytes.NewReader(data))
+	req, err := http.NewRequest(http.MethodPost, c.brokerURL, bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("failed to build HTTP request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if c.compression != "" && c.compression != protocol.EncodingIdentity {
+		req.Header.Set("Content-Encoding", string(c.compression))
+	}
+	return c.doRequest(req, headers.Nonce)
+}
+
+// doRequest sends a request carrying the envelope with the given nonce and
+// returns the broker's ack, checking the broker signed it
+func (c *MCPClient) doRequest(req *http.Request, nonce string) (*protocol.AckBody, error) {
+	resp, err := c.httpClient.Do(req)
 	if err != nil {
 		return nil, fmt.Errorf("failed to send HTTP request: %w", err)
 	}
 	defer resp.Body.Close()
 
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read response: %w", err)
+	}
+
 	// Check status code
 	if resp.StatusCode != http.StatusOK {
+		var rejection *protocol.ErrorBody
+		if _, err := protocol.ParseResponse(body); errors.As(err, &rejection) {
+			return nil, fmt.Errorf("broker rejected request: %w", err)
+		}
 		return nil, fmt.Errorf("broker returned status %d", resp.StatusCode)
 	}
 
+	// Make sure we are still talking to the broker we first contacted
+	if err := c.pins.VerifyResponse(c.brokerURL, resp.Header, nonce, body); err != nil {
+		return nil, fmt.Errorf("broker identity check failed: %w", err)
+	}
+
 	// Parse response
-	var response map[string]interface{}
-	if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
+	response, err := protocol.ParseResponse(body)
+	if err != nil {
 		return nil, fmt.Errorf("failed to decode response: %w", err)
 	}
 
@@ -291,10 +499,6 @@ func (c *MCPClient) generateRequestID() string {
 	return fmt.Sprintf("%s-req-%d", c.agentID, c.requestID)
 }
 
-func (c *MCPClient) generateNonce() string {
-	return fmt.Sprintf("%s-%d", c.agentID, time.Now().UnixNano())
-}
-
 // GetCacheStats returns statistics about the tool cache
 func (c *MCPClient) GetCacheStats() map[string]interface{} {
 	c.cacheMutex.RLock()
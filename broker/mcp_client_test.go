@@ -1,7 +1,10 @@
 package main
 
 import (
+	"net/http"
 	"net/http/httptest"
+	"net/url"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -240,10 +243,11 @@ func TestMCPClientDiscoverToolsIntegration(t *testing.T) {
 	broker.mcpRegistry.RegisterAgent(testAgent.ID, testAgent)
 
 	// Create MCP client
-	_, privKey, err := protocol.GenerateKeyPair()
+	pubKey, privKey, err := protocol.GenerateKeyPair()
 	if err != nil {
 		t.Fatalf("Failed to generate key pair: %v", err)
 	}
+	registerDiscoveryClient(broker, "client-test", pubKey)
 
 	client := NewMCPClient(MCPClientConfig{
 		AgentID:     "client-test",
@@ -399,12 +403,41 @@ func TestMCPClientToolCallFormat(t *testing.T) {
 		t.Fatal("Expected result, got nil")
 	}
 
-	resultMap, ok := result.(map[string]interface{})
+	ack, ok := result.(*protocol.AckBody)
 	if !ok {
-		t.Fatal("Expected result to be a map")
+		t.Fatal("Expected result to be an ack")
+	}
+
+	if ack.Status != "processing" {
+		t.Errorf("Expected status 'processing', got %v", ack.Status)
 	}
+}
+
+func TestMCPClientThroughProxy(t *testing.T) {
+	broker := NewBroker()
+	var proxied atomic.Int32
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		proxied.Add(1)
+		broker.ServeHTTP(w, r)
+	}))
+	defer proxy.Close()
+
+	pubKey, privKey, _ := protocol.GenerateKeyPair()
+	registerDiscoveryClient(broker, "caller", pubKey)
+	broker.mcpRegistry.RegisterAgent("caller", &MCPAgent{ID: "caller"})
+	proxyURL, _ := url.Parse(proxy.URL)
+	client := NewMCPClient(MCPClientConfig{
+		AgentID:    "caller",
+		BrokerURL:  "http://broker.internal:4433",
+		PrivateKey: privKey,
+		Proxy:      http.ProxyURL(proxyURL),
+	})
 
-	if status, ok := resultMap["status"].(string); !ok || status != "processing" {
-		t.Errorf("Expected status 'processing', got %v", resultMap["status"])
+	heartbeat, _ := protocol.NewHeartbeat("caller").SignWith(privKey)
+	if _, err := client.SendBatch(heartbeat); err != nil {
+		t.Fatalf("Request through the proxy failed: %v", err)
 	}
-}
\ No newline at end of file
+	if proxied.Load() != 1 {
+		t.Errorf("Expected the request to go through the proxy, got %d", proxied.Load())
+	}
+}
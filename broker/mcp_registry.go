@@ -2,7 +2,10 @@ package main
 
 import (
 	"fmt"
+	"log"
+	"reflect"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/fep-fem/protocol"
@@ -10,9 +13,30 @@ import (
 
 // MCPRegistry manages MCP tool discovery and agent embodiment
 type MCPRegistry struct {
-	tools  map[string]*RegisteredTool
-	agents map[string]*MCPAgent
-	mu     sync.RWMutex
+	tools     map[string]*RegisteredTool
+	agents    map[string]*MCPAgent
+	observers []RegistryObserver
+	mu        sync.RWMutex
+
+	nextInstance atomic.Uint64 // Rotates callers among equally loaded instances
+}
+
+// RegistryEventType identifies a change made by registry garbage collection
+type RegistryEventType string
+
+const (
+	RegistryToolExpired     RegistryEventType = "tool_expired"
+	RegistryAgentExpired    RegistryEventType = "agent_expired"
+	RegistryInstanceExpired RegistryEventType = "instance_expired"
+)
+
+// RegistryEvent describes a tool or agent removed from the registry
+type RegistryEvent struct {
+	Type     RegistryEventType
+	AgentID  string
+	ToolName string // Empty for agent events
+	Instance string // Set for instance events
+	LastSeen time.Time
 }
 
 // RegisteredTool represents a tool that's been indexed for discovery
@@ -33,6 +57,11 @@ type MCPAgent struct {
 	EnvironmentType string
 	Tools           []protocol.MCPTool
 	LastHeartbeat   time.Time
+	LastStatus      protocol.HeartbeatBody    // Status reported in the latest heartbeat envelope, combined over instances
+	Instances       map[string]*AgentInstance // Replicas by instance ID; nil for agents registered without one
+	// Set when the agent announces it is about to be reclaimed; cleared by registering again
+	PreemptedAt        time.Time
+	PreemptionDeadline time.Time // When the agent said it stops; zero if it did not say
 }
 
 // NewMCPRegistry creates a new MCP registry instance
@@ -45,14 +74,33 @@ func NewMCPRegistry() *MCPRegistry {
 
 // RegisterAgent registers an agent and indexes its MCP tools
 func (r *MCPRegistry) RegisterAgent(agentID string, agent *MCPAgent) error {
+	return r.register(agentID, agent, nil)
+}
+
+// register indexes agent, adding instance to the instances it already has
+func (r *MCPRegistry) register(agentID string, agent *MCPAgent, instance *AgentInstance) error {
 	r.mu.Lock()
-	defer r.mu.Unlock()
 
+	if instance != nil {
+		agent.Instances = map[string]*AgentInstance{instance.ID: instance}
+		if existing, exists := r.agents[agentID]; exists {
+			for id, other := range existing.Instances {
+				if id != instance.ID {
+					agent.Instances[id] = other
+				}
+			}
+		}
+		agent.LastStatus = aggregateStatus(agent.Instances)
+	}
 	r.agents[agentID] = agent
 
 	// Index all tools for discovery
+	var added []protocol.MCPTool
 	for _, tool := range agent.Tools {
 		toolKey := fmt.Sprintf("%s/%s", agentID, tool.Name)
+		if existing, exists := r.tools[toolKey]; !exists || !reflect.DeepEqual(existing.Tool, tool) {
+			added = append(added, tool)
+		}
 		r.tools[toolKey] = &RegisteredTool{
 			AgentID:         agentID,
 			Tool:            tool,
@@ -62,7 +110,15 @@ func (r *MCPRegistry) RegisterAgent(agentID string, agent *MCPAgent) error {
 			LastSeen:        time.Now(),
 		}
 	}
+	observers := r.observersLocked()
+	r.mu.Unlock()
 
+	for _, observer := range observers {
+		observer.OnAgentRegistered(agent)
+		if len(added) > 0 {
+			observer.OnToolsChanged(agentID, added, nil)
+		}
+	}
 	return nil
 }
 
@@ -89,15 +145,28 @@ func (r *MCPRegistry) ListTools() []*RegisteredTool {
 // UnregisterAgent removes an agent and all its tools
 func (r *MCPRegistry) UnregisterAgent(agentID string) {
 	r.mu.Lock()
-	defer r.mu.Unlock()
 
 	// Remove agent
+	_, existed := r.agents[agentID]
 	delete(r.agents, agentID)
 
 	// Remove all tools for this agent
+	var removed []protocol.MCPTool
 	for toolKey, tool := range r.tools {
 		if tool.AgentID == agentID {
 			delete(r.tools, toolKey)
+			removed = append(removed, tool.Tool)
+		}
+	}
+	observers := r.observersLocked()
+	r.mu.Unlock()
+
+	for _, observer := range observers {
+		if len(removed) > 0 {
+			observer.OnToolsChanged(agentID, nil, removed)
+		}
+		if existed {
+			observer.OnAgentRemoved(agentID)
 		}
 	}
 }
@@ -113,8 +182,9 @@ func (r *MCPRegistry) DiscoverTools(query protocol.ToolQuery) ([]protocol.Discov
 	for _, tool := range r.tools {
 		// Match capabilities
 		if r.matchesCapabilities(tool, query.Capabilities) {
-			// Filter by environment if specified
-			if query.EnvironmentType == "" || tool.EnvironmentType == query.EnvironmentType {
+			// Filter by environment and hardware if specified
+			if (query.EnvironmentType == "" || tool.EnvironmentType == query.EnvironmentType) &&
+				query.Hardware.Matches(r.hardwareLocked(tool.AgentID)) {
 				matchingTools = append(matchingTools, tool)
 			}
 		}
@@ -138,23 +208,46 @@ func (r *MCPRegistry) DiscoverTools(query protocol.ToolQuery) ([]protocol.Discov
 	var discovered []protocol.DiscoveredTool
 	for agentID, tools := range agentTools {
 		info := agentInfo[agentID]
-		discovered = append(discovered, protocol.DiscoveredTool{
+		tool := protocol.DiscoveredTool{
 			AgentID:         agentID,
 			MCPEndpoint:     info.MCPEndpoint,
 			Capabilities:    r.extractCapabilities(tools),
 			EnvironmentType: info.EnvironmentType,
 			MCPTools:        tools,
+			Hardware:        r.hardwareLocked(agentID),
 			Metadata: protocol.ToolMetadata{
 				LastSeen:            info.LastSeen.UnixMilli(),
 				AverageResponseTime: 150, // Placeholder
 				TrustScore:          0.95, // Placeholder
 			},
-		})
+		}
+		agent, exists := r.agents[agentID]
+		if exists && agent.Preempted() {
+			continue // About to be reclaimed
+		}
+		// Callers of a replicated agent are spread over its instances
+		if exists && len(agent.Instances) > 0 {
+			instance := r.selectInstanceLocked(agent)
+			if instance == nil {
+				continue // Every instance is draining
+			}
+			tool.MCPEndpoint = instance.MCPEndpoint
+			tool.Metadata.Instances = activeInstances(agent)
+		}
+		discovered = append(discovered, tool)
 	}
 
 	return discovered, nil
 }
 
+// hardwareLocked returns the hardware an agent advertised, or nil
+func (r *MCPRegistry) hardwareLocked(agentID string) *protocol.HardwareCapabilities {
+	if agent, exists := r.agents[agentID]; exists && agent.BodyDefinition != nil {
+		return agent.BodyDefinition.Hardware
+	}
+	return nil
+}
+
 // matchesCapabilities checks if a tool matches any of the capability patterns
 func (r *MCPRegistry) matchesCapabilities(tool *RegisteredTool, capabilities []string) bool {
 	if len(capabilities) == 0 {
@@ -211,6 +304,135 @@ func (r *MCPRegistry) UpdateAgentHeartbeat(agentID string) {
 	}
 }
 
+// RemoveStale removes tools not seen within maxAge, then agents left without
+// tools whose last heartbeat is also older than maxAge. Each removal is logged
+// and reported to observers.
+func (r *MCPRegistry) RemoveStale(maxAge time.Duration) []RegistryEvent {
+	cutoff := time.Now().Add(-maxAge)
+
+	r.mu.Lock()
+	var events []RegistryEvent
+	removed := make(map[string][]protocol.MCPTool)
+	expiredTools := make(map[string]map[string]bool)
+	for toolKey, tool := range r.tools {
+		if !tool.LastSeen.Before(cutoff) {
+			continue
+		}
+		delete(r.tools, toolKey)
+		if expiredTools[tool.AgentID] == nil {
+			expiredTools[tool.AgentID] = make(map[string]bool)
+		}
+		expiredTools[tool.AgentID][tool.Tool.Name] = true
+		removed[tool.AgentID] = append(removed[tool.AgentID], tool.Tool)
+		events = append(events, RegistryEvent{
+			Type:     RegistryToolExpired,
+			AgentID:  tool.AgentID,
+			ToolName: tool.Tool.Name,
+			LastSeen: tool.LastSeen,
+		})
+	}
+
+	// Agents that still have indexed tools are alive
+	hasTools := make(map[string]bool)
+	for _, tool := range r.tools {
+		hasTools[tool.AgentID] = true
+	}
+	for agentID, agent := range r.agents {
+		// Replicas that stopped heartbeating leave the group
+		for id, instance := range agent.Instances {
+			if !instance.LastHeartbeat.Before(cutoff) || len(agent.Instances) == 1 {
+				continue
+			}
+			delete(agent.Instances, id)
+			agent.LastStatus = aggregateStatus(agent.Instances)
+			events = append(events, RegistryEvent{
+				Type:     RegistryInstanceExpired,
+				AgentID:  agentID,
+				Instance: id,
+				LastSeen: instance.LastHeartbeat,
+			})
+		}
+		if expired := expiredTools[agentID]; len(expired) > 0 {
+			// Keep the agent's advertised tools in step with the index
+			remaining := make([]protocol.MCPTool, 0, len(agent.Tools))
+			for _, tool := range agent.Tools {
+				if !expired[tool.Name] {
+					remaining = append(remaining, tool)
+				}
+			}
+			agent.Tools = remaining
+		}
+		if hasTools[agentID] || !agent.LastHeartbeat.Before(cutoff) {
+			continue
+		}
+		delete(r.agents, agentID)
+		events = append(events, RegistryEvent{
+			Type:     RegistryAgentExpired,
+			AgentID:  agentID,
+			LastSeen: agent.LastHeartbeat,
+		})
+	}
+	observers := r.observersLocked()
+	r.mu.Unlock()
+
+	for _, event := range events {
+		switch event.Type {
+		case RegistryToolExpired:
+			log.Printf("Removed stale tool %s of agent %s (last seen %s)", event.ToolName, event.AgentID, event.LastSeen.Format(time.RFC3339))
+		case RegistryInstanceExpired:
+			log.Printf("Removed stale instance %s of agent %s (last heartbeat %s)", event.Instance, event.AgentID, event.LastSeen.Format(time.RFC3339))
+		default:
+			log.Printf("Removed stale agent %s (last heartbeat %s)", event.AgentID, event.LastSeen.Format(time.RFC3339))
+		}
+	}
+	for _, observer := range observers {
+		for agentID, tools := range removed {
+			observer.OnToolsChanged(agentID, nil, tools)
+		}
+		for _, event := range events {
+			if event.Type == RegistryAgentExpired {
+				observer.OnAgentRemoved(event.AgentID)
+			}
+		}
+	}
+	return events
+}
+
+// RecordHeartbeat stores the status from an agent's heartbeat envelope and
+// refreshes its liveness. Heartbeats of a replicated agent must name one of
+// its instances; the agent's status then combines its instances' reports.
+// It returns the agent's status, and false for agents or instances that are
+// not registered.
+func (r *MCPRegistry) RecordHeartbeat(agentID string, status protocol.HeartbeatBody) (protocol.HeartbeatBody, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	agent, exists := r.agents[agentID]
+	if !exists {
+		return protocol.HeartbeatBody{}, false
+	}
+
+	now := time.Now()
+	if len(agent.Instances) > 0 {
+		instance, exists := agent.Instances[status.InstanceID]
+		if !exists {
+			return protocol.HeartbeatBody{}, false
+		}
+		instance.LastHeartbeat = now
+		instance.LastStatus = status
+		instance.signalDrained()
+		status = aggregateStatus(agent.Instances)
+	}
+	agent.LastHeartbeat = now
+	agent.LastStatus = status
+	for _, tool := range r.tools {
+		if tool.AgentID == agentID {
+			tool.LastSeen = now
+		}
+	}
+	return status, true
+}
+
 // GetToolCount returns the total number of registered tools
 func (r *MCPRegistry) GetToolCount() int {
 	r.mu.RLock()
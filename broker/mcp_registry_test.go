@@ -331,4 +331,46 @@ func TestMCPRegistryHeartbeat(t *testing.T) {
 	if !retrievedAgent.LastHeartbeat.After(oldHeartbeat) {
 		t.Error("Heartbeat should have been updated")
 	}
-}
\ No newline at end of file
+}
+
+func TestMCPRegistryDiscoveryByHardware(t *testing.T) {
+	registry := NewMCPRegistry()
+	for agentID, gpus := range map[string][]protocol.GPUDevice{
+		"a100-agent": {{Model: "NVIDIA A100-SXM4-80GB", VRAMMB: 81920, CUDAVersion: "12.2"}},
+		"t4-agent":   {{Model: "NVIDIA T4", VRAMMB: 15360, CUDAVersion: "11.8"}},
+		"cpu-agent":  nil,
+	} {
+		registry.RegisterAgent(agentID, &MCPAgent{
+			ID:             agentID,
+			BodyDefinition: &protocol.BodyDefinition{Hardware: &protocol.HardwareCapabilities{GPUs: gpus}},
+			Tools:          []protocol.MCPTool{{Name: "model.infer"}},
+		})
+	}
+
+	discover := func(filter *protocol.HardwareFilter) map[string]bool {
+		tools, err := registry.DiscoverTools(protocol.ToolQuery{Capabilities: []string{"model.*"}, Hardware: filter})
+		if err != nil {
+			t.Fatalf("Discovery failed: %v", err)
+		}
+		agents := make(map[string]bool)
+		for _, tool := range tools {
+			agents[tool.AgentID] = true
+		}
+		return agents
+	}
+
+	if agents := discover(nil); len(agents) != 3 {
+		t.Errorf("Without a filter every agent should be found, got %v", agents)
+	}
+	if agents := discover(&protocol.HardwareFilter{MinVRAMMB: 40000}); len(agents) != 1 || !agents["a100-agent"] {
+		t.Errorf("Only a100-agent has 40 GB of VRAM, got %v", agents)
+	}
+	if agents := discover(&protocol.HardwareFilter{MinCUDAVersion: "11.0"}); len(agents) != 2 || agents["cpu-agent"] {
+		t.Errorf("Agents without GPUs should not match, got %v", agents)
+	}
+
+	tools, _ := registry.DiscoverTools(protocol.ToolQuery{Capabilities: []string{"model.*"}, Hardware: &protocol.HardwareFilter{GPUModel: "t4"}})
+	if len(tools) != 1 || tools[0].Hardware == nil || tools[0].Hardware.GPUs[0].VRAMMB != 15360 {
+		t.Errorf("Discovered tools should carry the agent's hardware, got %+v", tools)
+	}
+}
@@ -0,0 +1,378 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fep-fem/protocol"
+)
+
+// Aggregation resolutions kept for every agent
+var metricsResolutions = []time.Duration{time.Minute, 5 * time.Minute, time.Hour}
+
+// MetricsAggregate summarizes the samples recorded for an agent during one interval
+type MetricsAggregate struct {
+	Start           time.Time         `json:"start"`
+	Samples         int               `json:"samples"`
+	HealthScore     float64           `json:"healthScore"`
+	ResponseTime    protocol.Duration `json:"responseTime"`
+	MaxResponseTime protocol.Duration `json:"maxResponseTime"`
+	ErrorRate       float64           `json:"errorRate"`
+	Availability    float64           `json:"availability"`
+	Requests        int64             `json:"requests"` // Requests routed during the interval
+}
+
+// metricsBucket accumulates samples for one interval
+type metricsBucket struct {
+	start         time.Time
+	samples       int
+	healthSum     float64
+	responseSum   time.Duration
+	maxResponse   time.Duration
+	errorRateSum  float64
+	availSum      float64
+	firstRequests int64
+	lastRequests  int64
+}
+
+func (b *metricsBucket) add(m *AgentMetrics) {
+	if b.samples == 0 {
+		b.firstRequests = m.TotalRequests
+	}
+	b.samples++
+	b.healthSum += m.HealthScore
+	b.responseSum += m.LastResponseTime
+	if m.LastResponseTime > b.maxResponse {
+		b.maxResponse = m.LastResponseTime
+	}
+	b.errorRateSum += m.ErrorRate
+	b.availSum += m.Availability
+	b.lastRequests = m.TotalRequests
+}
+
+func (b *metricsBucket) aggregate(previousRequests int64) MetricsAggregate {
+	n := float64(b.samples)
+	return MetricsAggregate{
+		Start:           b.start,
+		Samples:         b.samples,
+		HealthScore:     b.healthSum / n,
+		ResponseTime:    protocol.Duration(b.responseSum / time.Duration(b.samples)),
+		MaxResponseTime: protocol.Duration(b.maxResponse),
+		ErrorRate:       b.errorRateSum / n,
+		Availability:    b.availSum / n,
+		Requests:        b.lastRequests - previousRequests,
+	}
+}
+
+// metricsRing is a fixed-size ring buffer of buckets at one resolution
+type metricsRing struct {
+	resolution time.Duration
+	buckets    []metricsBucket
+	head       int // Index of the newest bucket
+}
+
+func newMetricsRing(resolution, retention time.Duration) *metricsRing {
+	size := int(retention / resolution)
+	if size < 1 {
+		size = 1
+	}
+	return &metricsRing{resolution: resolution, buckets: make([]metricsBucket, size), head: -1}
+}
+
+func (r *metricsRing) add(at time.Time, m *AgentMetrics) {
+	start := at.Truncate(r.resolution)
+	if r.head < 0 || !r.buckets[r.head].start.Equal(start) {
+		r.head = (r.head + 1) % len(r.buckets)
+		r.buckets[r.head] = metricsBucket{start: start}
+	}
+	r.buckets[r.head].add(m)
+}
+
+// aggregates returns buckets newer than cutoff, oldest first
+func (r *metricsRing) aggregates(cutoff time.Time) []MetricsAggregate {
+	if r.head < 0 {
+		return nil
+	}
+
+	var result []MetricsAggregate
+	var previousRequests int64
+	havePrevious := false
+	for i := 1; i <= len(r.buckets); i++ {
+		bucket := &r.buckets[(r.head+i)%len(r.buckets)]
+		if bucket.samples == 0 {
+			continue
+		}
+		if !havePrevious {
+			previousRequests = bucket.firstRequests
+			havePrevious = true
+		}
+		if !bucket.start.Before(cutoff) {
+			result = append(result, bucket.aggregate(previousRequests))
+		}
+		previousRequests = bucket.lastRequests
+	}
+	return result
+}
+
+// agentSeries holds the rings for one agent
+type agentSeries struct {
+	rings      []*metricsRing
+	lastSample time.Time
+}
+
+// MetricsHistory keeps per-agent time series of AgentMetrics snapshots
+type MetricsHistory struct {
+	retention time.Duration
+	series    map[string]*agentSeries
+	now       func() time.Time
+	mu        sync.RWMutex
+}
+
+// NewMetricsHistory creates a history that keeps samples for the retention period
+func NewMetricsHistory(retention time.Duration) *MetricsHistory {
+	if retention <= 0 {
+		retention = 24 * time.Hour
+	}
+	return &MetricsHistory{
+		retention: retention,
+		series:    make(map[string]*agentSeries),
+		now:       time.Now,
+	}
+}
+
+// Record adds a snapshot of an agent's metrics to every resolution
+func (h *MetricsHistory) Record(m *AgentMetrics) {
+	at := h.now()
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	series, exists := h.series[m.AgentID]
+	if !exists {
+		series = &agentSeries{}
+		for _, resolution := range metricsResolutions {
+			series.rings = append(series.rings, newMetricsRing(resolution, h.retention))
+		}
+		h.series[m.AgentID] = series
+	}
+	for _, ring := range series.rings {
+		ring.add(at, m)
+	}
+	series.lastSample = at
+}
+
+// History returns an agent's aggregates at the given resolution, oldest first
+func (h *MetricsHistory) History(agentID string, resolution time.Duration) ([]MetricsAggregate, error) {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	series, exists := h.series[agentID]
+	if !exists {
+		return nil, nil
+	}
+	for _, ring := range series.rings {
+		if ring.resolution == resolution {
+			return ring.aggregates(h.now().Add(-h.retention)), nil
+		}
+	}
+	return nil, fmt.Errorf("unsupported resolution %s", resolution)
+}
+
+// Latest returns each agent's most recent aggregate at the given resolution
+func (h *MetricsHistory) Latest(resolution time.Duration) map[string]MetricsAggregate {
+	latest := make(map[string]MetricsAggregate)
+	for _, agentID := range h.Agents() {
+		points, _ := h.History(agentID, resolution)
+		if len(points) > 0 {
+			latest[agentID] = points[len(points)-1]
+		}
+	}
+	return latest
+}
+
+// Agents lists agents with recorded history
+func (h *MetricsHistory) Agents() []string {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	agents := make([]string, 0, len(h.series))
+	for agentID := range h.series {
+		agents = append(agents, agentID)
+	}
+	sort.Strings(agents)
+	return agents
+}
+
+// Prune drops agents with no samples inside the retention period
+func (h *MetricsHistory) Prune() int {
+	cutoff := h.now().Add(-h.retention)
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	pruned := 0
+	for agentID, series := range h.series {
+		if series.lastSample.Before(cutoff) {
+			delete(h.series, agentID)
+			pruned++
+		}
+	}
+	return pruned
+}
+
+// MetricsHistory returns the per-agent metrics time series
+func (fm *FederationManager) MetricsHistory() *MetricsHistory {
+	return fm.metricsHistory
+}
+
+// recordMetricsSample snapshots an agent's metrics into the history. Callers hold metricsMutex.
+func (fm *FederationManager) recordMetricsSample(metrics *AgentMetrics) {
+	fm.metricsHistory.Record(metrics)
+}
+
+// handleAdminMetrics returns an agent's history (?agent=<id>&resolution=1m|5m|1h),
+// or the latest aggregate for every agent when no agent is given; ?tenant=
+// narrows it to one tenant's agents
+func (b *Broker) handleAdminMetrics(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	b.serveMetricsHistory(w, r, r.URL.Query().Get("tenant"))
+}
+
+// serveMetricsHistory answers a metrics history request with the agents of
+// tenant, or of every tenant if it is empty
+func (b *Broker) serveMetricsHistory(w http.ResponseWriter, r *http.Request, tenant string) {
+	resolution := time.Minute
+	if value := r.URL.Query().Get("resolution"); value != "" {
+		parsed, err := time.ParseDuration(value)
+		if err != nil {
+			http.Error(w, "Invalid resolution", http.StatusBadRequest)
+			return
+		}
+		resolution = parsed
+	}
+
+	history := b.federation.MetricsHistory()
+	agentID := r.URL.Query().Get("agent")
+	if agentID == "" {
+		latest := history.Latest(resolution)
+		for agentID := range latest {
+			if !inTenant(agentID, tenant) {
+				delete(latest, agentID)
+			}
+		}
+		writeJSON(w, http.StatusOK, map[string]interface{}{
+			"resolution": resolution.String(),
+			"agents":     latest,
+		})
+		return
+	}
+	if !inTenant(agentID, tenant) {
+		http.Error(w, fmt.Sprintf("Agent %s is not in tenant %s", agentID, tenant), http.StatusNotFound)
+		return
+	}
+
+	points, err := history.History(agentID, resolution)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"agentId":    agentID,
+		"resolution": resolution.String(),
+		"points":     points,
+	})
+}
+
+// handlePrometheusMetrics exposes agent and worker metrics in the Prometheus
+// text format. Scoped to a tenant, it only exposes the tenant's agents; the
+// worker lanes are shared by every tenant, so only the global view has them.
+func (b *Broker) handlePrometheusMetrics(w http.ResponseWriter, r *http.Request, tenant string) {
+	var out strings.Builder
+
+	fm := b.federation
+	fm.metricsMutex.RLock()
+	agentIDs := make([]string, 0, len(fm.agentMetrics))
+	for agentID := range fm.agentMetrics {
+		if inTenant(agentID, tenant) {
+			agentIDs = append(agentIDs, agentID)
+		}
+	}
+	sort.Strings(agentIDs)
+
+	writeMetricHeader(&out, "fem_agent_health_score", "gauge", "Latest agent health score")
+	for _, agentID := range agentIDs {
+		fmt.Fprintf(&out, "fem_agent_health_score{agent=%q} %g\n", agentID, fm.agentMetrics[agentID].HealthScore)
+	}
+	writeMetricHeader(&out, "fem_agent_response_time_seconds", "gauge", "Latest agent response time")
+	for _, agentID := range agentIDs {
+		fmt.Fprintf(&out, "fem_agent_response_time_seconds{agent=%q} %g\n", agentID, fm.agentMetrics[agentID].LastResponseTime.Seconds())
+	}
+	writeMetricHeader(&out, "fem_agent_error_rate", "gauge", "Fraction of failed agent health checks")
+	for _, agentID := range agentIDs {
+		fmt.Fprintf(&out, "fem_agent_error_rate{agent=%q} %g\n", agentID, fm.agentMetrics[agentID].ErrorRate)
+	}
+	writeMetricHeader(&out, "fem_agent_requests_total", "counter", "Requests routed to the agent")
+	for _, agentID := range agentIDs {
+		fmt.Fprintf(&out, "fem_agent_requests_total{agent=%q} %d\n", agentID, fm.agentMetrics[agentID].TotalRequests)
+	}
+	fm.metricsMutex.RUnlock()
+
+	// Windowed averages from the time series
+	writeMetricHeader(&out, "fem_agent_health_score_avg", "gauge", "Average agent health score over the latest window")
+	for _, resolution := range metricsResolutions {
+		latest := fm.MetricsHistory().Latest(resolution)
+		for _, agentID := range sortedKeys(latest) {
+			if !inTenant(agentID, tenant) {
+				continue
+			}
+			fmt.Fprintf(&out, "fem_agent_health_score_avg{agent=%q,window=%q} %g\n", agentID, formatWindow(resolution), latest[agentID].HealthScore)
+		}
+	}
+
+	if tenant != "" {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		w.Write([]byte(out.String()))
+		return
+	}
+
+	stats := b.envelopeWorkers().Stats()
+	writeMetricHeader(&out, "fem_worker_queue_depth", "gauge", "Envelopes waiting in each worker lane")
+	for _, lane := range stats {
+		fmt.Fprintf(&out, "fem_worker_queue_depth{lane=%q} %d\n", lane.Lane, lane.Queued)
+	}
+	writeMetricHeader(&out, "fem_worker_rejected_total", "counter", "Envelopes refused because a lane was full")
+	for _, lane := range stats {
+		fmt.Fprintf(&out, "fem_worker_rejected_total{lane=%q} %d\n", lane.Lane, lane.Rejected)
+	}
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	w.Write([]byte(out.String()))
+}
+
+func writeMetricHeader(out *strings.Builder, name, kind, help string) {
+	fmt.Fprintf(out, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+}
+
+// formatWindow renders a resolution as 1m, 5m or 1h
+func formatWindow(d time.Duration) string {
+	if d%time.Hour == 0 {
+		return fmt.Sprintf("%dh", d/time.Hour)
+	}
+	return fmt.Sprintf("%dm", d/time.Minute)
+}
+
+func sortedKeys(m map[string]MetricsAggregate) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
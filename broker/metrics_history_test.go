@@ -0,0 +1,116 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMetricsHistoryAggregates(t *testing.T) {
+	history := NewMetricsHistory(time.Hour)
+	clock := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+	history.now = func() time.Time { return clock }
+
+	// Two samples in the first minute, one in the next
+	history.Record(&AgentMetrics{AgentID: "a", HealthScore: 0.8, LastResponseTime: 100 * time.Millisecond, TotalRequests: 1})
+	clock = clock.Add(20 * time.Second)
+	history.Record(&AgentMetrics{AgentID: "a", HealthScore: 0.6, LastResponseTime: 300 * time.Millisecond, TotalRequests: 3})
+	clock = clock.Add(time.Minute)
+	history.Record(&AgentMetrics{AgentID: "a", HealthScore: 1.0, LastResponseTime: 50 * time.Millisecond, TotalRequests: 7})
+
+	minutes, err := history.History("a", time.Minute)
+	if err != nil {
+		t.Fatalf("History failed: %v", err)
+	}
+	if len(minutes) != 2 {
+		t.Fatalf("Expected 2 one-minute points, got %d", len(minutes))
+	}
+	first := minutes[0]
+	if first.Samples != 2 || first.HealthScore != 0.7 || first.ResponseTime.Std() != 200*time.Millisecond || first.MaxResponseTime.Std() != 300*time.Millisecond {
+		t.Errorf("Unexpected first aggregate: %+v", first)
+	}
+	if first.Requests != 2 || minutes[1].Requests != 4 {
+		t.Errorf("Unexpected request deltas: %d, %d", first.Requests, minutes[1].Requests)
+	}
+
+	hours, _ := history.History("a", time.Hour)
+	if len(hours) != 1 || hours[0].Samples != 3 {
+		t.Errorf("Expected one hourly point with 3 samples, got %+v", hours)
+	}
+
+	if _, err := history.History("a", 2*time.Minute); err == nil {
+		t.Error("Unsupported resolution should fail")
+	}
+}
+
+func TestMetricsHistoryRetention(t *testing.T) {
+	history := NewMetricsHistory(10 * time.Minute)
+	clock := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+	history.now = func() time.Time { return clock }
+
+	// The one-minute ring holds exactly the retention period
+	for i := 0; i < 15; i++ {
+		history.Record(&AgentMetrics{AgentID: "a", HealthScore: 1})
+		clock = clock.Add(time.Minute)
+	}
+	minutes, _ := history.History("a", time.Minute)
+	if len(minutes) != 10 {
+		t.Errorf("Expected 10 retained points, got %d", len(minutes))
+	}
+
+	history.Record(&AgentMetrics{AgentID: "b", HealthScore: 1})
+	clock = clock.Add(11 * time.Minute)
+	history.Record(&AgentMetrics{AgentID: "a", HealthScore: 1})
+
+	if pruned := history.Prune(); pruned != 1 {
+		t.Errorf("Expected 1 pruned agent, got %d", pruned)
+	}
+	if agents := history.Agents(); len(agents) != 1 || agents[0] != "a" {
+		t.Errorf("Unexpected agents after prune: %v", agents)
+	}
+}
+
+func TestMetricsEndpoints(t *testing.T) {
+	broker := NewBroker()
+	broker.SetAdminToken("secret")
+	broker.federation.updateRoutingMetrics("math.add", "math-agent", nil)
+
+	get := func(path string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		req.Header.Set("Authorization", "Bearer secret")
+		recorder := httptest.NewRecorder()
+		broker.ServeHTTP(recorder, req)
+		return recorder
+	}
+
+	recorder := get("/admin/metrics?agent=math-agent&resolution=5m")
+	var response struct {
+		Points []MetricsAggregate `json:"points"`
+	}
+	if err := json.NewDecoder(recorder.Body).Decode(&response); err != nil || len(response.Points) != 1 {
+		t.Fatalf("Unexpected history response (%d): %v %+v", recorder.Code, err, response)
+	}
+
+	recorder = get("/metrics")
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("Prometheus endpoint returned %d", recorder.Code)
+	}
+	for _, want := range []string{
+		`fem_agent_requests_total{agent="math-agent"} 1`,
+		`fem_agent_health_score_avg{agent="math-agent",window="1h"}`,
+		`fem_worker_queue_depth{lane="shared"} 0`,
+	} {
+		if !strings.Contains(recorder.Body.String(), want) {
+			t.Errorf("Prometheus output missing %q", want)
+		}
+	}
+
+	unauthenticated := httptest.NewRecorder()
+	broker.ServeHTTP(unauthenticated, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	if unauthenticated.Code != http.StatusUnauthorized {
+		t.Errorf("Expected 401 without admin token, got %d", unauthenticated.Code)
+	}
+}
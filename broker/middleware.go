@@ -0,0 +1,153 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/fep-fem/protocol"
+)
+
+// EnvelopeContext carries one envelope through the middleware chain
+type EnvelopeContext struct {
+	Request   *http.Request
+	Envelope  *protocol.GenericEnvelope // May be modified in place before dispatch
+	Transport TransportMetadata         // How the envelope reached the broker
+	Values    map[string]interface{}    // Scratch space shared by middleware for this envelope
+}
+
+// EnvelopeResult is the buffered response to an envelope. Post-result hooks
+// may rewrite it before the broker signs and sends it.
+type EnvelopeResult struct {
+	Status int
+	Header http.Header
+	Body   []byte
+}
+
+// Middleware hooks into envelope processing without changing the handlers.
+// BeforeDispatch hooks run in registration order before the envelope is
+// routed; an error stops the chain, skips the handler and answers the envelope
+// with an error envelope. AfterDispatch hooks run in reverse order, only for
+// middleware whose BeforeDispatch succeeded, and see the handler's response or
+// the rejection from a later middleware.
+type Middleware interface {
+	BeforeDispatch(ctx *EnvelopeContext) error
+	AfterDispatch(ctx *EnvelopeContext, result *EnvelopeResult)
+}
+
+// MiddlewareFuncs adapts optional functions to the Middleware interface
+type MiddlewareFuncs struct {
+	Before func(ctx *EnvelopeContext) error
+	After  func(ctx *EnvelopeContext, result *EnvelopeResult)
+}
+
+// BeforeDispatch calls Before if set
+func (f *MiddlewareFuncs) BeforeDispatch(ctx *EnvelopeContext) error {
+	if f.Before != nil {
+		return f.Before(ctx)
+	}
+	return nil
+}
+
+// AfterDispatch calls After if set
+func (f *MiddlewareFuncs) AfterDispatch(ctx *EnvelopeContext, result *EnvelopeResult) {
+	if f.After != nil {
+		f.After(ctx, result)
+	}
+}
+
+// namedMiddleware is a registered middleware
+type namedMiddleware struct {
+	name string
+	Middleware
+}
+
+// Use registers middleware under name. New middleware runs after the middleware
+// already registered; registering an existing name replaces it in place.
+func (b *Broker) Use(name string, middleware Middleware) {
+	b.middlewareMu.Lock()
+	defer b.middlewareMu.Unlock()
+	for i, m := range b.middleware {
+		if m.name == name {
+			b.middleware[i].Middleware = middleware
+			return
+		}
+	}
+	b.middleware = append(b.middleware, namedMiddleware{name: name, Middleware: middleware})
+}
+
+// RemoveMiddleware unregisters the named middleware, reporting whether it was registered
+func (b *Broker) RemoveMiddleware(name string) bool {
+	b.middlewareMu.Lock()
+	defer b.middlewareMu.Unlock()
+	for i, m := range b.middleware {
+		if m.name == name {
+			b.middleware = append(b.middleware[:i], b.middleware[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// MiddlewareNames lists registered middleware in the order it runs
+func (b *Broker) MiddlewareNames() []string {
+	b.middlewareMu.RLock()
+	defer b.middlewareMu.RUnlock()
+	names := make([]string, len(b.middleware))
+	for i, m := range b.middleware {
+		names[i] = m.name
+	}
+	return names
+}
+
+// processEnvelope runs the envelope through the middleware chain around dispatchEnvelope
+func (b *Broker) processEnvelope(w *signedResponseWriter, r *http.Request, envelope *protocol.GenericEnvelope) {
+	b.middlewareMu.RLock()
+	chain := append([]namedMiddleware{}, b.middleware...)
+	b.middlewareMu.RUnlock()
+	if len(chain) == 0 {
+		b.dispatchEnvelope(w, r, envelope)
+		return
+	}
+
+	transport, _ := TransportFromRequest(r)
+	ctx := &EnvelopeContext{Request: r, Envelope: envelope, Transport: transport, Values: make(map[string]interface{})}
+	ran := 0
+	for _, m := range chain {
+		if err := m.BeforeDispatch(ctx); err != nil {
+			b.rejectFromMiddleware(w, envelope, m.name, err)
+			break
+		}
+		ran++
+	}
+	if ran == len(chain) {
+		b.dispatchEnvelope(w, r, envelope)
+	}
+	if ran == 0 {
+		return
+	}
+
+	// A handler waiting for results answers once its worker is released
+	w.then(func() {
+		result := &EnvelopeResult{Status: w.status, Header: w.Header(), Body: append([]byte{}, w.body.Bytes()...)}
+		for i := ran - 1; i >= 0; i-- {
+			chain[i].AfterDispatch(ctx, result)
+		}
+		w.status = result.Status
+		w.body.Reset()
+		w.body.Write(result.Body)
+	})
+}
+
+// rejectFromMiddleware answers an envelope refused by middleware. Errors that
+// are *protocol.ErrorBody keep their code and details; others are forbidden.
+func (b *Broker) rejectFromMiddleware(w http.ResponseWriter, env *protocol.GenericEnvelope, name string, err error) {
+	var errBody *protocol.ErrorBody
+	if errors.As(err, &errBody) {
+		rejection := b.newError(env, errBody.Code, errBody.Message)
+		rejection.Body.Details = errBody.Details
+		writeError(w, rejection)
+		return
+	}
+	b.reject(w, env, protocol.CodeForbidden, fmt.Sprintf("Rejected by %s: %v", name, err))
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/fep-fem/protocol"
+)
+
+func sendHeartbeat(t *testing.T, broker *Broker, agentID string) (*httptest.ResponseRecorder, string) {
+	t.Helper()
+	pubKey, privKey, _ := protocol.GenerateKeyPair()
+	registerDiscoveryClient(broker, agentID, pubKey)
+	broker.mcpRegistry.RegisterAgent(agentID, &MCPAgent{ID: agentID})
+	envelope, err := protocol.NewHeartbeat(agentID).SignWith(privKey)
+	if err != nil {
+		t.Fatalf("Failed to build heartbeat: %v", err)
+	}
+	data, _ := json.Marshal(envelope)
+	recorder := httptest.NewRecorder()
+	broker.ServeHTTP(recorder, httptest.NewRequest(http.MethodPost, "/", bytes.NewReader(data)))
+	return recorder, envelope.Nonce
+}
+
+func TestMiddlewareRunsInOrder(t *testing.T) {
+	broker := NewBroker()
+	var calls []string
+	trace := func(name string) Middleware {
+		return &MiddlewareFuncs{
+			Before: func(ctx *EnvelopeContext) error {
+				calls = append(calls, name+".before")
+				return nil
+			},
+			After: func(ctx *EnvelopeContext, result *EnvelopeResult) {
+				calls = append(calls, name+".after")
+			},
+		}
+	}
+	broker.Use("first", trace("first"))
+	broker.Use("second", trace("second"))
+	broker.Use("third", trace("third"))
+
+	resp, _ := sendHeartbeat(t, broker, "worker")
+	if resp.Code != http.StatusOK {
+		t.Fatalf("Heartbeat should be accepted, got %d %s", resp.Code, resp.Body.String())
+	}
+	want := []string{"first.before", "second.before", "third.before", "third.after", "second.after", "first.after"}
+	if !reflect.DeepEqual(calls, want) {
+		t.Errorf("Hooks ran as %v, want %v", calls, want)
+	}
+
+	if !broker.RemoveMiddleware("second") || broker.RemoveMiddleware("second") {
+		t.Errorf("RemoveMiddleware should report whether the middleware was registered")
+	}
+	if names := broker.MiddlewareNames(); !reflect.DeepEqual(names, []string{"first", "third"}) {
+		t.Errorf("Unexpected middleware after removal: %v", names)
+	}
+}
+
+func TestMiddlewareShortCircuits(t *testing.T) {
+	broker := NewBroker()
+	var calls []string
+	broker.Use("outer", &MiddlewareFuncs{
+		After: func(ctx *EnvelopeContext, result *EnvelopeResult) {
+			calls = append(calls, "outer.after")
+			if result.Status == http.StatusOK {
+				t.Errorf("Outer middleware should see the rejection")
+			}
+		},
+	})
+	broker.Use("quota", &MiddlewareFuncs{
+		Before: func(ctx *EnvelopeContext) error {
+			return &protocol.ErrorBody{Code: protocol.CodeRateLimited, Message: "quota exceeded"}
+		},
+		After: func(ctx *EnvelopeContext, result *EnvelopeResult) {
+			calls = append(calls, "quota.after")
+		},
+	})
+	broker.Use("inner", &MiddlewareFuncs{
+		Before: func(ctx *EnvelopeContext) error {
+			calls = append(calls, "inner.before")
+			return nil
+		},
+	})
+
+	resp, _ := sendHeartbeat(t, broker, "worker")
+	_, err := protocol.ParseResponse(resp.Body.Bytes())
+	var errBody *protocol.ErrorBody
+	if !errors.As(err, &errBody) || errBody.Code != protocol.CodeRateLimited {
+		t.Fatalf("Middleware error code should reach the client, got %v", err)
+	}
+	if !reflect.DeepEqual(calls, []string{"outer.after"}) {
+		t.Errorf("Only middleware that ran before the rejection should see it, got %v", calls)
+	}
+
+	broker.Use("quota", &MiddlewareFuncs{
+		Before: func(ctx *EnvelopeContext) error { return errors.New("no") },
+	})
+	resp, _ = sendHeartbeat(t, broker, "worker")
+	if resp.Code != http.StatusForbidden {
+		t.Errorf("Plain middleware errors should be forbidden, got %d", resp.Code)
+	}
+}
+
+func TestMiddlewareRewritesSignedResult(t *testing.T) {
+	broker := NewBroker()
+	broker.Use("enrich", &MiddlewareFuncs{
+		After: func(ctx *EnvelopeContext, result *EnvelopeResult) {
+			var ack protocol.AckEnvelope
+			if err := json.Unmarshal(result.Body, &ack); err != nil {
+				t.Fatalf("Failed to decode result: %v", err)
+			}
+			ack.Body.Result = map[string]interface{}{"region": "eu-west"}
+			result.Body, _ = json.Marshal(ack)
+			result.Header.Set("X-Enriched", "true")
+		},
+	})
+
+	resp, nonce := sendHeartbeat(t, broker, "worker")
+	if resp.Header().Get("X-Enriched") != "true" {
+		t.Errorf("Middleware headers should reach the client")
+	}
+	if _, err := protocol.VerifyBrokerResponse(resp.Header(), nonce, resp.Body.Bytes()); err != nil {
+		t.Errorf("Rewritten response should be signed: %v", err)
+	}
+	var result map[string]string
+	if err := readAck(t, resp.Body).ResultAs(&result); err != nil || result["region"] != "eu-west" {
+		t.Errorf("Rewritten result not returned: %v %v", result, err)
+	}
+}
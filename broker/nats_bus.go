@@ -0,0 +1,108 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/fep-fem/protocol"
+)
+
+// natsEchoWindow is how long the broker remembers the envelopes it relayed,
+// so NATS echoing them back is not mistaken for another broker's events
+const natsEchoWindow = time.Minute
+
+// natsBus fans the broker's events out over NATS, so brokers sharing a NATS
+// server deliver each other's events to their subscribers
+type natsBus struct {
+	broker    *Broker
+	transport *protocol.NATSTransport
+	sub       *protocol.NATSSubscription
+	mu        sync.Mutex
+	sent      map[string]time.Time // Nonces of the envelopes relayed, by when
+}
+
+// UseNATS makes transport the broker's fan-out bus: events published here are
+// relayed to every topic's subject and events relayed by other brokers, or
+// published by agents straight to NATS, reach local subscribers
+func (b *Broker) UseNATS(transport *protocol.NATSTransport) error {
+	bus := &natsBus{broker: b, transport: transport, sent: make(map[string]time.Time)}
+	sub, err := transport.SubscribeTopic("*", bus.receive)
+	if err != nil {
+		return err
+	}
+	bus.sub = sub
+	b.events.SetRelay(bus.relay)
+	return nil
+}
+
+// relay publishes an event from this broker to its topic's subject
+func (n *natsBus) relay(event TopicEvent) {
+	data, err := n.broker.eventEnvelope(event)
+	if err != nil {
+		log.Printf("Failed to sign event %d for NATS: %v", event.Seq, err)
+		return
+	}
+	var headers struct {
+		Nonce string `json:"nonce"`
+	}
+	if err := json.Unmarshal(data, &headers); err != nil {
+		return
+	}
+
+	now := time.Now()
+	n.mu.Lock()
+	for nonce, sent := range n.sent {
+		if now.Sub(sent) > natsEchoWindow {
+			delete(n.sent, nonce)
+		}
+	}
+	n.sent[headers.Nonce] = now
+	n.mu.Unlock()
+
+	if err := n.transport.PublishEvent(data); err != nil {
+		log.Printf("Failed to relay event %s to NATS: %v", event.Topic, err)
+	}
+}
+
+// receive records an event that arrived over NATS. Events from agents
+// registered here must carry their signature; others are trusted as far as
+// the NATS server's permissions on the subjects.
+func (n *natsBus) receive(env *protocol.GenericEnvelope) {
+	n.mu.Lock()
+	_, echo := n.sent[env.Nonce]
+	delete(n.sent, env.Nonce)
+	n.mu.Unlock()
+	if echo {
+		return
+	}
+
+	b := n.broker
+	b.mu.RLock()
+	agent, known := b.agents[env.Agent]
+	b.mu.RUnlock()
+	if known {
+		if err := agent.verifySignature(env); err != nil {
+			b.recordSignatureFailure(env.Agent, nil)
+			log.Printf("Dropping event from %s over NATS: %v", env.Agent, err)
+			return
+		}
+	}
+	typed, err := protocol.ParseTyped[protocol.EmitEventBody](env)
+	if err != nil {
+		log.Printf("Dropping event from %s over NATS: %v", env.Agent, err)
+		return
+	}
+	raw, err := json.Marshal(env)
+	if err != nil {
+		return
+	}
+	b.events.record(TopicEvent{
+		Topic:     typed.Body.Event,
+		Publisher: env.Agent,
+		Payload:   typed.Body.Payload,
+		envelope:  raw,
+		relayed:   true,
+	})
+}
@@ -0,0 +1,186 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/fep-fem/protocol"
+)
+
+// startNATSServer runs a NATS server routing messages to every subscription
+// whose subject matches, enough for brokers to share it
+func startNATSServer(t *testing.T) string {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	var mu sync.Mutex
+	clients := make(map[net.Conn]map[string]string) // Subject of each sid, per client
+	t.Cleanup(func() {
+		listener.Close()
+		mu.Lock()
+		for client := range clients {
+			client.Close()
+		}
+		mu.Unlock()
+	})
+
+	serve := func(conn net.Conn) {
+		defer conn.Close()
+		io.WriteString(conn, "INFO {\"server_id\":\"test\",\"max_payload\":1048576}\r\n")
+		mu.Lock()
+		clients[conn] = make(map[string]string)
+		mu.Unlock()
+		reader := bufio.NewReader(conn)
+		for {
+			line, err := reader.ReadString('\n')
+			if err != nil {
+				return
+			}
+			op, args, _ := strings.Cut(strings.TrimRight(line, "\r\n"), " ")
+			fields := strings.Fields(args)
+			switch op {
+			case "PING":
+				io.WriteString(conn, "PONG\r\n")
+			case "SUB":
+				mu.Lock()
+				clients[conn][fields[len(fields)-1]] = fields[0]
+				mu.Unlock()
+			case "PUB":
+				size, _ := strconv.Atoi(fields[len(fields)-1])
+				payload := make([]byte, size+2)
+				if _, err := io.ReadFull(reader, payload); err != nil {
+					return
+				}
+				mu.Lock()
+				for client, subs := range clients {
+					for sid, subject := range subs {
+						if strings.HasSuffix(subject, ".>") && strings.HasPrefix(fields[0], strings.TrimSuffix(subject, ">")) || subject == fields[0] {
+							fmt.Fprintf(client, "MSG %s %s %d\r\n%s\r\n", fields[0], sid, size, payload[:size])
+						}
+					}
+				}
+				mu.Unlock()
+			}
+		}
+	}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go serve(conn)
+		}
+	}()
+	return "nats://" + listener.Addr().String()
+}
+
+// natsBroker is a broker fanning events out over the NATS server at url
+func natsBroker(t *testing.T, url, id string) (*Broker, *protocol.NATSConn) {
+	t.Helper()
+	broker := NewBroker()
+	broker.federation.config.LocalBrokerID = id
+	conn, err := protocol.DialNATS(protocol.NATSConfig{URL: url, Name: id})
+	if err != nil {
+		t.Fatalf("Failed to connect to NATS: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	if err := broker.UseNATS(protocol.NewNATSTransport(conn, "")); err != nil {
+		t.Fatalf("Failed to use NATS: %v", err)
+	}
+	// Once flushed, the server has the broker's subscription
+	conn.Flush()
+	return broker, conn
+}
+
+func TestNATSBusFansEventsOut(t *testing.T) {
+	url := startNATSServer(t)
+	east, eastConn := natsBroker(t, url, "broker-east")
+	west, _ := natsBroker(t, url, "broker-west")
+
+	received := make(chan TopicEvent, 4)
+	west.events.Subscribe(SubscribeRequest{Subscriber: "dashboard", Pattern: "prod.*"}, func(event TopicEvent) {
+		received <- event
+	})
+	var local []TopicEvent
+	var localMu sync.Mutex
+	east.events.Subscribe(SubscribeRequest{Subscriber: "ops", Pattern: "prod.*"}, func(event TopicEvent) {
+		localMu.Lock()
+		local = append(local, event)
+		localMu.Unlock()
+	})
+
+	pubKey, privKey, _ := protocol.GenerateKeyPair()
+	registerDiscoveryClient(east, "ci", pubKey)
+	event := protocol.NewTypedEnvelope("ci", protocol.EmitEventBody{Event: "prod.ci.build", Payload: map[string]interface{}{"status": "green"}})
+	event.Sign(privKey)
+	postEnvelope(t, east, event)
+	east.events.publish("broker", "prod.deploy", nil)
+
+	for _, want := range []string{"ci", "broker-east"} {
+		select {
+		case got := <-received:
+			if got.Publisher != want {
+				t.Errorf("Expected an event from %s, got one from %s", want, got.Publisher)
+			}
+			// Signatures survive the relay, so streams and agents can check them
+			env, _ := protocol.ParseEnvelope(got.envelope)
+			if want == "ci" && env.Verify(pubKey) != nil {
+				t.Error("Relayed events should keep their publisher's signature")
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("Event from %s did not reach the other broker", want)
+		}
+	}
+
+	// Echoes of a broker's own events are not delivered twice
+	eastConn.Flush()
+	localMu.Lock()
+	defer localMu.Unlock()
+	if len(local) != 2 {
+		t.Errorf("Expected each local event delivered once, got %d", len(local))
+	}
+}
+
+func TestNATSBusChecksKnownSigners(t *testing.T) {
+	url := startNATSServer(t)
+	broker, _ := natsBroker(t, url, "broker-east")
+	pubKey, _, _ := protocol.GenerateKeyPair()
+	registerDiscoveryClient(broker, "ci", pubKey)
+	received := make(chan TopicEvent, 2)
+	broker.events.Subscribe(SubscribeRequest{Subscriber: "dashboard", Pattern: "prod.*"}, func(event TopicEvent) {
+		received <- event
+	})
+
+	conn, err := protocol.DialNATS(protocol.NATSConfig{URL: url})
+	if err != nil {
+		t.Fatalf("Failed to connect to NATS: %v", err)
+	}
+	defer conn.Close()
+	publisher := protocol.NewNATSTransport(conn, "")
+	_, forgerKey, _ := protocol.GenerateKeyPair()
+	forged := protocol.NewTypedEnvelope("ci", protocol.EmitEventBody{Event: "prod.ci.build"})
+	forged.Sign(forgerKey)
+	publisher.PublishEvent(forged)
+	other := protocol.NewTypedEnvelope("edge-agent", protocol.EmitEventBody{Event: "prod.edge.online"})
+	other.Sign(forgerKey)
+	publisher.PublishEvent(other)
+
+	select {
+	case event := <-received:
+		if event.Publisher != "edge-agent" {
+			t.Errorf("Forged events from registered agents should be dropped, got one from %s", event.Publisher)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Event from an agent not registered here was not delivered")
+	}
+}
@@ -0,0 +1,239 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/fep-fem/protocol"
+)
+
+// orderedEnvelopeTypes are handled in the order their sender numbered them
+// when they carry a seq header, so subscribers and callers see events and
+// results in the order they were produced
+var orderedEnvelopeTypes = map[protocol.EnvelopeType]bool{
+	protocol.EnvelopeEmitEvent:  true,
+	protocol.EnvelopeToolResult: true,
+}
+
+const (
+	// defaultReorderWindow is how many out-of-order envelopes are held per sender
+	defaultReorderWindow = 64
+	// defaultMaxGapWait is how long held envelopes wait for a missing one
+	// before the broker gives up on it and moves on
+	defaultMaxGapWait = 2 * time.Second
+	// streamIdleTTL is how long a sender's sequence is remembered without traffic
+	streamIdleTTL = 10 * time.Minute
+)
+
+// heldEnvelope is an envelope waiting for the envelopes sequenced before it
+type heldEnvelope struct {
+	envelope *protocol.GenericEnvelope
+	request  *http.Request
+}
+
+// agentStream tracks one sender's sequence. Its lock is held while envelopes
+// are handled, so a sender's sequenced envelopes never run concurrently.
+type agentStream struct {
+	mu       sync.Mutex
+	next     uint64
+	held     map[uint64]heldEnvelope
+	gapTimer *time.Timer
+	gapGen   uint64    // Identifies the current gap timer, so a stale one does nothing
+	lastSeen time.Time // Guarded by the orderer's lock
+}
+
+// StreamOrderer holds each sender's out-of-order envelopes in a bounded
+// buffer until the gap before them is filled
+type StreamOrderer struct {
+	mu         sync.Mutex
+	streams    map[string]*agentStream
+	window     int
+	maxGapWait time.Duration
+	lastSweep  time.Time
+}
+
+// NewStreamOrderer creates an orderer with the default window and gap wait
+func NewStreamOrderer() *StreamOrderer {
+	return &StreamOrderer{
+		streams:    make(map[string]*agentStream),
+		window:     defaultReorderWindow,
+		maxGapWait: defaultMaxGapWait,
+	}
+}
+
+// Configure sets how many envelopes are held per sender and how long they
+// wait for a missing envelope
+func (o *StreamOrderer) Configure(window int, maxGapWait time.Duration) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.window = window
+	o.maxGapWait = maxGapWait
+}
+
+// Reset forgets a sender's sequence so it can start again at 1, e.g. after
+// re-registering following a restart
+func (o *StreamOrderer) Reset(agentID string) {
+	o.mu.Lock()
+	stream, exists := o.streams[agentID]
+	delete(o.streams, agentID)
+	o.mu.Unlock()
+	if exists {
+		stream.mu.Lock()
+		if stream.gapTimer != nil {
+			stream.gapTimer.Stop()
+		}
+		stream.mu.Unlock()
+	}
+}
+
+// stream returns a sender's sequence state, creating it on first use and
+// dropping idle senders along the way
+func (o *StreamOrderer) stream(agentID string) (*agentStream, int, time.Duration) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	now := time.Now()
+	if now.Sub(o.lastSweep) > time.Minute {
+		o.lastSweep = now
+		for id, stream := range o.streams {
+			// TryLock skips streams busy handling an envelope, which are not idle
+			if stream.mu.TryLock() {
+				if len(stream.held) == 0 && now.Sub(stream.lastSeen) > streamIdleTTL {
+					delete(o.streams, id)
+				}
+				stream.mu.Unlock()
+			}
+		}
+	}
+	stream, exists := o.streams[agentID]
+	if !exists {
+		stream = &agentStream{next: 1, held: make(map[uint64]heldEnvelope)}
+		o.streams[agentID] = stream
+	}
+	stream.lastSeen = now
+	return stream, o.window, o.maxGapWait
+}
+
+// gapWait returns how long held envelopes wait for a missing one
+func (o *StreamOrderer) gapWait() time.Duration {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return o.maxGapWait
+}
+
+// Held returns the number of envelopes waiting per sender
+func (o *StreamOrderer) Held() map[string]int {
+	o.mu.Lock()
+	streams := make(map[string]*agentStream, len(o.streams))
+	for id, stream := range o.streams {
+		streams[id] = stream
+	}
+	o.mu.Unlock()
+
+	held := make(map[string]int)
+	for id, stream := range streams {
+		stream.mu.Lock()
+		if len(stream.held) > 0 {
+			held[id] = len(stream.held)
+		}
+		stream.mu.Unlock()
+	}
+	return held
+}
+
+// dispatchInOrder handles a sequenced envelope when it is next in its sender's
+// stream, followed by any held envelopes it unblocks. Early envelopes are held
+// and acknowledged as queued; envelopes already handled are acknowledged as
+// duplicates without being handled again, so retries are safe.
+func (b *Broker) dispatchInOrder(w http.ResponseWriter, r *http.Request, env *protocol.GenericEnvelope) {
+	stream, window, maxGapWait := b.streams.stream(env.Agent)
+	stream.mu.Lock()
+	defer stream.mu.Unlock()
+
+	switch {
+	case env.Seq < stream.next:
+		b.writeAck(w, env, "duplicate", map[string]interface{}{"seq": env.Seq, "expected": stream.next})
+	case env.Seq > stream.next:
+		if _, held := stream.held[env.Seq]; !held {
+			if len(stream.held) >= window {
+				b.reject(w, env, protocol.CodeOverloaded,
+					fmt.Sprintf("Reorder buffer full waiting for seq %d; resend later", stream.next))
+				return
+			}
+			stream.held[env.Seq] = heldEnvelope{envelope: env, request: r}
+		}
+		if stream.gapTimer == nil {
+			b.startGapTimer(env.Agent, stream, maxGapWait)
+		}
+		b.writeAck(w, env, "queued", map[string]interface{}{"seq": env.Seq, "expected": stream.next})
+	default:
+		b.routeEnvelope(w, r, env)
+		stream.next++
+		b.releaseHeld(env.Agent, stream, maxGapWait)
+	}
+}
+
+// releaseHeld handles held envelopes that are now next in the stream. Their
+// senders were already answered, so outcomes are only logged. The caller holds
+// the stream's lock.
+func (b *Broker) releaseHeld(agentID string, stream *agentStream, maxGapWait time.Duration) {
+	for {
+		held, exists := stream.held[stream.next]
+		if !exists {
+			break
+		}
+		delete(stream.held, stream.next)
+		w := newSignedResponseWriter(&discardResponseWriter{header: make(http.Header)})
+		b.routeEnvelope(w, held.request, held.envelope)
+		if w.status >= http.StatusBadRequest {
+			log.Printf("Held %s envelope seq %d from %s was rejected: %s",
+				held.envelope.Type, stream.next, agentID, w.body.String())
+		}
+		stream.next++
+	}
+
+	if stream.gapTimer != nil {
+		stream.gapTimer.Stop()
+		stream.gapTimer = nil
+	}
+	if len(stream.held) > 0 {
+		// A later gap remains; give it the full wait
+		b.startGapTimer(agentID, stream, maxGapWait)
+	}
+}
+
+// startGapTimer arranges for skipGap to run once maxGapWait has passed. The
+// caller holds the stream's lock.
+func (b *Broker) startGapTimer(agentID string, stream *agentStream, maxGapWait time.Duration) {
+	stream.gapGen++
+	gen := stream.gapGen
+	stream.gapTimer = time.AfterFunc(maxGapWait, func() { b.skipGap(agentID, stream, gen) })
+}
+
+// skipGap gives up on envelopes that never arrived and releases what is held
+// after them
+func (b *Broker) skipGap(agentID string, stream *agentStream, gen uint64) {
+	maxGapWait := b.streams.gapWait()
+	stream.mu.Lock()
+	defer stream.mu.Unlock()
+	if gen != stream.gapGen || stream.gapTimer == nil {
+		// The gap was filled, or a later one started, while this timer fired
+		return
+	}
+	stream.gapTimer = nil
+	if len(stream.held) == 0 {
+		return
+	}
+
+	seqs := make([]uint64, 0, len(stream.held))
+	for seq := range stream.held {
+		seqs = append(seqs, seq)
+	}
+	sort.Slice(seqs, func(i, j int) bool { return seqs[i] < seqs[j] })
+	log.Printf("Skipping seq %d to %d from %s after waiting %s", stream.next, seqs[0]-1, agentID, maxGapWait)
+	stream.next = seqs[0]
+	b.releaseHeld(agentID, stream, maxGapWait)
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/fep-fem/protocol"
+)
+
+// orderedEmitter posts sequenced emitEvent envelopes and records the order
+// subscribers see them in
+type orderedEmitter struct {
+	t        *testing.T
+	broker   *Broker
+	mu       sync.Mutex
+	received []float64
+}
+
+func newOrderedEmitter(t *testing.T, broker *Broker) *orderedEmitter {
+	e := &orderedEmitter{t: t, broker: broker}
+	broker.events.Subscribe(SubscribeRequest{Subscriber: "watcher", Pattern: "ci.*"}, func(event TopicEvent) {
+		e.mu.Lock()
+		defer e.mu.Unlock()
+		e.received = append(e.received, event.Payload["n"].(float64))
+	})
+	return e
+}
+
+func (e *orderedEmitter) emit(seq uint64) *httptest.ResponseRecorder {
+	envelope := protocol.NewTypedEnvelope("ci-runner", protocol.EmitEventBody{
+		Event:   "ci.step",
+		Payload: map[string]interface{}{"n": seq},
+	})
+	envelope.Seq = seq
+	data, _ := json.Marshal(envelope)
+	recorder := httptest.NewRecorder()
+	e.broker.ServeHTTP(recorder, httptest.NewRequest(http.MethodPost, "/", bytes.NewReader(data)))
+	return recorder
+}
+
+func (e *orderedEmitter) status(seq uint64) string {
+	return readAck(e.t, e.emit(seq).Body).Status
+}
+
+func (e *orderedEmitter) order() []float64 {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return append([]float64(nil), e.received...)
+}
+
+func TestOrderedDeliveryReordersEvents(t *testing.T) {
+	broker := NewBroker()
+	emitter := newOrderedEmitter(t, broker)
+
+	if status := emitter.status(3); status != "queued" {
+		t.Errorf("Early envelope should be queued, got %s", status)
+	}
+	emitter.status(2)
+	if got := emitter.order(); len(got) != 0 {
+		t.Fatalf("Nothing should be delivered before seq 1, got %v", got)
+	}
+	if status := emitter.status(1); status != "emitted" {
+		t.Errorf("Next envelope should be handled, got %s", status)
+	}
+	if got := emitter.order(); len(got) != 3 || got[0] != 1 || got[1] != 2 || got[2] != 3 {
+		t.Errorf("Events should be delivered in sequence, got %v", got)
+	}
+
+	// A retried envelope is acknowledged without being delivered twice
+	if status := emitter.status(2); status != "duplicate" {
+		t.Errorf("Retried envelope should be a duplicate, got %s", status)
+	}
+	if got := emitter.order(); len(got) != 3 {
+		t.Errorf("Duplicate should not be delivered again, got %v", got)
+	}
+
+	// Unsequenced envelopes bypass ordering
+	if status := emitter.status(0); status != "emitted" {
+		t.Errorf("Unsequenced envelope should be handled at once, got %s", status)
+	}
+}
+
+func TestOrderedDeliverySkipsMissingEnvelopes(t *testing.T) {
+	broker := NewBroker()
+	broker.streams.Configure(2, 20*time.Millisecond)
+	emitter := newOrderedEmitter(t, broker)
+
+	emitter.status(3)
+	emitter.status(5)
+	resp := emitter.emit(6)
+	var rejection *protocol.ErrorBody
+	if _, err := protocol.ParseResponse(resp.Body.Bytes()); !errors.As(err, &rejection) || rejection.Code != protocol.CodeOverloaded {
+		t.Errorf("Envelope beyond the reorder window should be refused as overloaded, got %v", err)
+	}
+	if held := broker.streams.Held(); held["ci-runner"] != 2 {
+		t.Errorf("Expected 2 held envelopes, got %v", held)
+	}
+
+	// Seqs 1, 2 and 4 never arrive; held envelopes are released after the gap wait
+	deadline := time.Now().Add(time.Second)
+	for len(emitter.order()) < 2 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	if got := emitter.order(); len(got) != 2 || got[0] != 3 || got[1] != 5 {
+		t.Errorf("Held events should be released in order after the gap wait, got %v", got)
+	}
+	if status := emitter.status(4); status != "duplicate" {
+		t.Errorf("Envelope arriving after its gap was skipped should be a duplicate, got %s", status)
+	}
+
+	// Re-registering starts the sequence over
+	broker.streams.Reset("ci-runner")
+	if status := emitter.status(1); status != "emitted" {
+		t.Errorf("Sequence should restart after a reset, got %s", status)
+	}
+}
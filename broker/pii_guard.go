@@ -0,0 +1,418 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/fep-fem/protocol"
+)
+
+// PIIConstraint names the constraint cited when personal data blocks an envelope
+const PIIConstraint = "pii"
+
+// PIIAction is what the guard does with personal data found at a boundary
+type PIIAction string
+
+const (
+	// PIIRedact replaces each match with a [REDACTED:<class>] marker
+	PIIRedact PIIAction = "redact"
+	// PIIBlock refuses the envelope with a policy violation
+	PIIBlock PIIAction = "block"
+)
+
+// ParsePIIAction converts a string into a known action
+func ParsePIIAction(value string) (PIIAction, error) {
+	switch action := PIIAction(value); action {
+	case PIIRedact, PIIBlock:
+		return action, nil
+	default:
+		return "", fmt.Errorf("unknown PII action: %s", value)
+	}
+}
+
+// PIIDetector finds one class of personal data in string values
+type PIIDetector struct {
+	Class   string
+	Pattern *regexp.Regexp
+	Check   func(match string) bool // Confirms a match, e.g. a checksum; nil accepts every match
+}
+
+// builtinPIIDetectors are the detectors available by class name
+var builtinPIIDetectors = []PIIDetector{
+	{Class: "email", Pattern: regexp.MustCompile(`[A-Za-z0-9._%+-]+@[A-Za-z0-9.-]+\.[A-Za-z]{2,}`)},
+	{Class: "phone", Pattern: regexp.MustCompile(`\+[1-9]\d{7,14}\b|\(?\b\d{3}\)?[-. ]\d{3}[-. ]\d{4}\b`)},
+	{Class: "ssn", Pattern: regexp.MustCompile(`\b\d{3}-\d{2}-\d{4}\b`)},
+	{Class: "credit_card", Pattern: regexp.MustCompile(`\b(?:\d[ -]?){12,18}\d\b`), Check: luhnValid},
+	{Class: "ip_address", Pattern: regexp.MustCompile(`\b(?:\d{1,3}\.){3}\d{1,3}\b`), Check: func(match string) bool {
+		return net.ParseIP(match) != nil
+	}},
+}
+
+// DefaultPIIDetectors returns every built-in detector
+func DefaultPIIDetectors() []PIIDetector {
+	return append([]PIIDetector{}, builtinPIIDetectors...)
+}
+
+// ParsePIIDetectors reads a comma-separated detector list. Entries are
+// built-in class names (email, phone, ssn, credit_card, ip_address) or
+// class=regexp pairs for custom detectors, e.g. "email,iban=[A-Z]{2}\d{2}[A-Z0-9]+".
+// Custom patterns cannot contain commas. An empty list selects every built-in detector.
+func ParsePIIDetectors(spec string) ([]PIIDetector, error) {
+	if strings.TrimSpace(spec) == "" {
+		return DefaultPIIDetectors(), nil
+	}
+	var detectors []PIIDetector
+	for _, entry := range strings.Split(spec, ",") {
+		entry = strings.TrimSpace(entry)
+		if class, pattern, custom := strings.Cut(entry, "="); custom {
+			compiled, err := regexp.Compile(pattern)
+			if err != nil {
+				return nil, fmt.Errorf("PII detector %s: %w", class, err)
+			}
+			detectors = append(detectors, PIIDetector{Class: class, Pattern: compiled})
+			continue
+		}
+		found := false
+		for _, detector := range builtinPIIDetectors {
+			if detector.Class == entry {
+				detectors = append(detectors, detector)
+				found = true
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("unknown PII detector: %s", entry)
+		}
+	}
+	return detectors, nil
+}
+
+// luhnValid reports whether the digits in s pass the Luhn checksum
+func luhnValid(s string) bool {
+	sum, digits := 0, 0
+	for i := len(s) - 1; i >= 0; i-- {
+		c := s[i]
+		if c < '0' || c > '9' {
+			continue
+		}
+		d := int(c - '0')
+		if digits%2 == 1 {
+			d *= 2
+			if d > 9 {
+				d -= 9
+			}
+		}
+		sum += d
+		digits++
+	}
+	return digits >= 13 && sum%10 == 0
+}
+
+// PIIPolicy configures the PII guard
+type PIIPolicy struct {
+	Detectors  []PIIDetector
+	Action     PIIAction
+	Namespaces bool // Guard data moving between agent namespaces
+	Federation bool // Guard data moving to or from federated brokers
+}
+
+// parsePIIPolicy builds a policy from the broker's command-line settings
+func parsePIIPolicy(action, detectors, boundaries string) (PIIPolicy, error) {
+	var policy PIIPolicy
+	var err error
+	if policy.Action, err = ParsePIIAction(action); err != nil {
+		return policy, err
+	}
+	if policy.Detectors, err = ParsePIIDetectors(detectors); err != nil {
+		return policy, err
+	}
+	for _, boundary := range strings.Split(boundaries, ",") {
+		switch strings.TrimSpace(boundary) {
+		case "namespace":
+			policy.Namespaces = true
+		case "federation":
+			policy.Federation = true
+		default:
+			return policy, fmt.Errorf("unknown PII boundary: %s", boundary)
+		}
+	}
+	return policy, nil
+}
+
+// PIIGuard is middleware that redacts or blocks personal data in tool call
+// parameters and tool results crossing a namespace or federation boundary.
+// A namespace is the first dot-separated segment of an agent ID, so
+// "team-a.search" and "team-a.crm" share one. Classes that every provider of
+// a tool declares in its pii tags pass unchanged to and from that tool.
+type PIIGuard struct {
+	broker *Broker
+	policy PIIPolicy
+}
+
+// NewPIIGuard creates the PII middleware
+func NewPIIGuard(broker *Broker, policy PIIPolicy) *PIIGuard {
+	return &PIIGuard{broker: broker, policy: policy}
+}
+
+// piiFinding is personal data found at one path of a body
+type piiFinding struct {
+	Path  string
+	Class string
+}
+
+// BeforeDispatch scans toolCall parameters and toolResult results that cross
+// a boundary, rewriting the body in place when redacting
+func (g *PIIGuard) BeforeDispatch(ctx *EnvelopeContext) error {
+	env := ctx.Envelope
+	field := ""
+	switch env.Type {
+	case protocol.EnvelopeToolCall:
+		field = "parameters"
+	case protocol.EnvelopeToolResult:
+		field = "result"
+	default:
+		return nil
+	}
+
+	var body map[string]interface{}
+	dec := json.NewDecoder(bytes.NewReader(env.Body))
+	dec.UseNumber()
+	if err := dec.Decode(&body); err != nil || body == nil || body[field] == nil {
+		// Malformed bodies are left for the handler to reject
+		return nil
+	}
+	tool, _ := body["tool"].(string)
+	boundary := g.crossedBoundary(env, body)
+	if boundary == "" {
+		return nil
+	}
+
+	cleared := make(map[string]bool)
+	for _, class := range g.broker.federation.toolPII(tool) {
+		cleared[class] = true
+	}
+	var findings []piiFinding
+	body[field] = g.scan(body[field], field, cleared, &findings)
+	if len(findings) == 0 {
+		return nil
+	}
+
+	sort.Slice(findings, func(i, j int) bool { return findings[i].Path < findings[j].Path })
+	classes := findingClasses(findings)
+	paths := make([]string, len(findings))
+	for i, finding := range findings {
+		paths[i] = finding.Path
+	}
+	detail := fmt.Sprintf("%s %s for %s crossing %s boundary: %s at %s",
+		env.Type, field, tool, boundary, strings.Join(classes, ", "), strings.Join(paths, ", "))
+
+	// Encrypted bodies cannot be rewritten without breaking the recipient's check
+	if g.policy.Action == PIIBlock || env.Encrypted() {
+		g.raise(ctx, SecurityEventPIIBlocked, detail)
+		return &protocol.ErrorBody{
+			Code:    protocol.CodePolicyViolation,
+			Message: fmt.Sprintf("%s contains personal data (%s) that may not cross the %s boundary", field, strings.Join(classes, ", "), boundary),
+			Details: map[string]interface{}{
+				"constraint": PIIConstraint,
+				"tool":       tool,
+				"boundary":   boundary,
+				"classes":    classes,
+				"paths":      paths,
+			},
+		}
+	}
+
+	data, err := json.Marshal(body)
+	if err != nil {
+		return fmt.Errorf("failed to redact %s: %w", field, err)
+	}
+	env.Body = data
+	g.raise(ctx, SecurityEventPIIRedacted, detail)
+	return nil
+}
+
+// AfterDispatch does nothing; results are scanned when their toolResult arrives
+func (g *PIIGuard) AfterDispatch(ctx *EnvelopeContext, result *EnvelopeResult) {}
+
+// crossedBoundary names the boundary the envelope's data crosses, if any: a
+// call travels from the sender to the tool's providers, and a result from the
+// sender to the caller awaiting it
+func (g *PIIGuard) crossedBoundary(env *protocol.GenericEnvelope, body map[string]interface{}) string {
+	fm := g.broker.federation
+	var recipients []string
+	switch env.Type {
+	case protocol.EnvelopeToolCall:
+		tool, _ := body["tool"].(string)
+		recipients = fm.toolProviders(tool)
+	case protocol.EnvelopeToolResult:
+		requestID, _ := body["requestId"].(string)
+		if caller, waiting := g.broker.results.Caller(requestID); waiting {
+			recipients = []string{caller}
+		}
+	}
+
+	if g.policy.Federation {
+		if fm.isRemoteAgent(env.Agent) {
+			return "federation"
+		}
+		for _, recipient := range recipients {
+			if fm.isRemoteAgent(recipient) {
+				return "federation"
+			}
+		}
+	}
+	if g.policy.Namespaces {
+		for _, recipient := range recipients {
+			if agentNamespace(recipient) != agentNamespace(env.Agent) {
+				return "namespace"
+			}
+		}
+	}
+	return ""
+}
+
+// agentNamespace returns the first dot-separated segment of an agent ID
+func agentNamespace(agentID string) string {
+	namespace, _, _ := strings.Cut(agentID, ".")
+	return namespace
+}
+
+// scan walks a JSON value, recording personal data of classes not cleared and
+// returning the value with matches redacted
+func (g *PIIGuard) scan(value interface{}, path string, cleared map[string]bool, findings *[]piiFinding) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		for key, child := range v {
+			v[key] = g.scan(child, path+"."+key, cleared, findings)
+		}
+		return v
+	case []interface{}:
+		for i, child := range v {
+			v[i] = g.scan(child, fmt.Sprintf("%s[%d]", path, i), cleared, findings)
+		}
+		return v
+	case string:
+		return g.redact(v, path, cleared, findings, v)
+	case json.Number:
+		return g.redact(v.String(), path, cleared, findings, v)
+	default:
+		return v
+	}
+}
+
+// redact replaces detected matches in text, returning original untouched when
+// nothing was found
+func (g *PIIGuard) redact(text, path string, cleared map[string]bool, findings *[]piiFinding, original interface{}) interface{} {
+	redacted := text
+	for _, detector := range g.policy.Detectors {
+		if cleared[detector.Class] {
+			continue
+		}
+		found := false
+		redacted = detector.Pattern.ReplaceAllStringFunc(redacted, func(match string) string {
+			if detector.Check != nil && !detector.Check(match) {
+				return match
+			}
+			found = true
+			return "[REDACTED:" + detector.Class + "]"
+		})
+		if found {
+			*findings = append(*findings, piiFinding{Path: path, Class: detector.Class})
+		}
+	}
+	if redacted == text {
+		return original
+	}
+	return redacted
+}
+
+// findingClasses lists the distinct classes found, sorted
+func findingClasses(findings []piiFinding) []string {
+	seen := make(map[string]bool)
+	classes := make([]string, 0, len(findings))
+	for _, finding := range findings {
+		if !seen[finding.Class] {
+			seen[finding.Class] = true
+			classes = append(classes, finding.Class)
+		}
+	}
+	sort.Strings(classes)
+	return classes
+}
+
+// raise logs a redaction event for the sender
+func (g *PIIGuard) raise(ctx *EnvelopeContext, eventType SecurityEventType, detail string) {
+	var source *TransportMetadata
+	if ctx.Request != nil {
+		source = requestSource(ctx.Request)
+	}
+	g.broker.securityEvents.Raise(SecurityEvent{
+		Type:    eventType,
+		AgentID: ctx.Envelope.Agent,
+		Detail:  detail,
+		Source:  source,
+	})
+}
+
+// toolPII returns the PII classes every provider of a tool declares, so data
+// is only let through when whichever provider gets it is cleared for it
+func (fm *FederationManager) toolPII(toolName string) []string {
+	var declared [][]string
+	for _, tool := range fm.mcpRegistry.ListTools() {
+		if tool.Tool.Name == toolName {
+			declared = append(declared, tool.Tool.PII)
+		}
+	}
+
+	fm.topologyMutex.RLock()
+	for _, tools := range fm.peerCatalogs {
+		for _, tool := range tools {
+			for _, mcpTool := range tool.MCPTools {
+				if mcpTool.Name == toolName {
+					declared = append(declared, mcpTool.PII)
+				}
+			}
+		}
+	}
+	fm.topologyMutex.RUnlock()
+
+	if len(declared) == 0 {
+		return nil
+	}
+	classes := declared[0]
+	for _, other := range declared[1:] {
+		kept := make([]string, 0, len(classes))
+		for _, class := range classes {
+			for _, candidate := range other {
+				if candidate == class {
+					kept = append(kept, class)
+					break
+				}
+			}
+		}
+		classes = kept
+	}
+	return classes
+}
+
+// isRemoteAgent reports whether agentID is a federated broker or an agent
+// hosted by one
+func (fm *FederationManager) isRemoteAgent(agentID string) bool {
+	fm.topologyMutex.RLock()
+	defer fm.topologyMutex.RUnlock()
+	if _, isBroker := fm.federatedBrokers[agentID]; isBroker {
+		return true
+	}
+	for _, tools := range fm.peerCatalogs {
+		for _, tool := range tools {
+			if tool.AgentID == agentID {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/fep-fem/protocol"
+)
+
+func TestParsePIIDetectors(t *testing.T) {
+	detectors, err := ParsePIIDetectors("email, iban=[A-Z]{2}\\d{2}[A-Z0-9]+")
+	if err != nil {
+		t.Fatalf("Failed to parse detectors: %v", err)
+	}
+	if len(detectors) != 2 || detectors[0].Class != "email" || detectors[1].Class != "iban" {
+		t.Errorf("Unexpected detectors: %+v", detectors)
+	}
+	if all, _ := ParsePIIDetectors(""); len(all) != len(builtinPIIDetectors) {
+		t.Errorf("Expected every built-in detector by default, got %d", len(all))
+	}
+	if _, err := ParsePIIDetectors("passport"); err == nil {
+		t.Error("Expected an unknown detector to be rejected")
+	}
+	if _, err := ParsePIIDetectors("bad=("); err == nil {
+		t.Error("Expected an invalid pattern to be rejected")
+	}
+}
+
+func TestLuhnValid(t *testing.T) {
+	if !luhnValid("4111 1111 1111 1111") {
+		t.Error("Expected a test card number to pass")
+	}
+	if luhnValid("4111 1111 1111 1112") {
+		t.Error("Expected a wrong check digit to fail")
+	}
+}
+
+func piiBroker() *Broker {
+	broker := NewBroker()
+	broker.mcpRegistry.RegisterAgent("team-b.crm", &MCPAgent{
+		ID:            "team-b.crm",
+		Tools:         []protocol.MCPTool{{Name: "crm.lookup", PII: []string{"email"}}},
+		LastHeartbeat: time.Now(),
+	})
+	return broker
+}
+
+func piiEnvelope(envelopeType protocol.EnvelopeType, agent string, body map[string]interface{}) *EnvelopeContext {
+	data, _ := json.Marshal(body)
+	env := &protocol.GenericEnvelope{
+		BaseEnvelope: protocol.BaseEnvelope{Type: envelopeType, CommonHeaders: protocol.CommonHeaders{Agent: agent}},
+		Body:         data,
+	}
+	return &EnvelopeContext{Envelope: env, Values: make(map[string]interface{})}
+}
+
+func TestPIIGuardRedactsAcrossNamespaces(t *testing.T) {
+	policy := PIIPolicy{Detectors: DefaultPIIDetectors(), Action: PIIRedact, Namespaces: true}
+	broker := piiBroker()
+	guard := NewPIIGuard(broker, policy)
+
+	call := func(agent string) map[string]interface{} {
+		ctx := piiEnvelope(protocol.EnvelopeToolCall, agent, map[string]interface{}{
+			"tool":      "crm.lookup",
+			"requestId": "r1",
+			"parameters": map[string]interface{}{
+				"email": "ada@example.com",
+				"card":  "4111 1111 1111 1111",
+				"notes": []interface{}{"call 555-123-4567 after 5"},
+			},
+		})
+		if err := guard.BeforeDispatch(ctx); err != nil {
+			t.Fatalf("Redaction should not reject: %v", err)
+		}
+		var body map[string]interface{}
+		json.Unmarshal(ctx.Envelope.Body, &body)
+		return body["parameters"].(map[string]interface{})
+	}
+
+	params := call("team-a.client")
+	if params["email"] != "ada@example.com" {
+		t.Errorf("The tool is cleared for email, got %v", params["email"])
+	}
+	if params["card"] != "[REDACTED:credit_card]" {
+		t.Errorf("Expected the card number to be redacted, got %v", params["card"])
+	}
+	if notes := params["notes"].([]interface{}); notes[0] != "call [REDACTED:phone] after 5" {
+		t.Errorf("Expected the phone number to be redacted, got %v", notes[0])
+	}
+
+	events := broker.securityEvents.Recent()
+	if len(events) != 1 || events[0].Type != SecurityEventPIIRedacted || !strings.Contains(events[0].Detail, "credit_card, phone") {
+		t.Errorf("Expected one redaction event, got %+v", events)
+	}
+
+	if params := call("team-b.client"); params["card"] != "4111 1111 1111 1111" {
+		t.Errorf("Calls within a namespace should pass unchanged, got %v", params["card"])
+	}
+}
+
+func TestPIIGuardBlocks(t *testing.T) {
+	policy := PIIPolicy{Detectors: DefaultPIIDetectors(), Action: PIIBlock, Namespaces: true}
+	broker := piiBroker()
+
+	ctx := piiEnvelope(protocol.EnvelopeToolCall, "team-a.client", map[string]interface{}{
+		"tool":       "crm.lookup",
+		"requestId":  "r1",
+		"parameters": map[string]interface{}{"ssn": "123-45-6789"},
+	})
+	err := NewPIIGuard(broker, policy).BeforeDispatch(ctx)
+	var errBody *protocol.ErrorBody
+	if !errors.As(err, &errBody) || errBody.Code != protocol.CodePolicyViolation || errBody.Details["constraint"] != PIIConstraint {
+		t.Fatalf("Expected a PII policy violation, got %v", err)
+	}
+	if events := broker.securityEvents.Recent(); len(events) != 1 || events[0].Type != SecurityEventPIIBlocked {
+		t.Errorf("Expected one block event, got %+v", events)
+	}
+}
+
+func TestPIIGuardRedactsFederatedResults(t *testing.T) {
+	policy := PIIPolicy{Detectors: DefaultPIIDetectors(), Action: PIIRedact, Federation: true}
+	broker := piiBroker()
+	env := newSignedBrokerRegistration(t, "peer-1")
+	if _, err := broker.federation.AddFederatedBroker(env, PeerTrustUntrusted); err != nil {
+		t.Fatalf("Failed to add peer: %v", err)
+	}
+	broker.federation.UpdatePeerCatalog("peer-1", []protocol.DiscoveredTool{
+		{AgentID: "remote-agent", MCPTools: []protocol.MCPTool{{Name: "geo.lookup"}}},
+	})
+	broker.results.ExpectResult("r2", "local-client", "")
+
+	ctx := piiEnvelope(protocol.EnvelopeToolResult, "remote-agent", map[string]interface{}{
+		"tool":      "geo.lookup",
+		"requestId": "r2",
+		"result":    map[string]interface{}{"ip": "203.0.113.7", "contact": "ops@example.com"},
+	})
+	if err := NewPIIGuard(broker, policy).BeforeDispatch(ctx); err != nil {
+		t.Fatalf("Redaction should not reject: %v", err)
+	}
+	var body struct {
+		Result map[string]string `json:"result"`
+	}
+	json.Unmarshal(ctx.Envelope.Body, &body)
+	if body.Result["ip"] != "[REDACTED:ip_address]" || body.Result["contact"] != "[REDACTED:email]" {
+		t.Errorf("Expected the federated result to be redacted, got %v", body.Result)
+	}
+
+	// Local results stay untouched when only federation is guarded
+	broker.results.ExpectResult("r3", "other.client", "")
+	ctx = piiEnvelope(protocol.EnvelopeToolResult, "team-b.crm", map[string]interface{}{
+		"tool":      "crm.lookup",
+		"requestId": "r3",
+		"result":    map[string]interface{}{"ip": "203.0.113.7"},
+	})
+	original := string(ctx.Envelope.Body)
+	NewPIIGuard(broker, policy).BeforeDispatch(ctx)
+	if string(ctx.Envelope.Body) != original {
+		t.Errorf("Expected a local result to pass unchanged, got %s", ctx.Envelope.Body)
+	}
+}
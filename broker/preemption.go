@@ -0,0 +1,315 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/fep-fem/protocol"
+)
+
+// resumeLease is how long a resuming agent has to answer a checkpointed call
+// before the assignment is sent to it again
+const resumeLease = 2 * time.Minute
+
+// checkpointTTL bounds how long a checkpoint waits to be resumed
+const checkpointTTL = 24 * time.Hour
+
+// Preempted reports whether the agent announced it is about to be reclaimed
+func (a *MCPAgent) Preempted() bool {
+	return !a.PreemptedAt.IsZero()
+}
+
+// PreemptAgent takes an agent about to be reclaimed out of rotation, so
+// neither discovery nor routing sends it new calls. With an instance ID only
+// that replica is drained. A zero deadline means the agent did not say when
+// it stops. It returns false for agents or instances that are not registered.
+func (r *MCPRegistry) PreemptAgent(agentID, instanceID string, deadline time.Time) bool {
+	if instanceID != "" {
+		_, known := r.DrainInstance(agentID, instanceID)
+		return known
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	agent, exists := r.agents[agentID]
+	if !exists {
+		return false
+	}
+	if !agent.Preempted() {
+		agent.PreemptedAt = time.Now()
+	}
+	agent.PreemptionDeadline = deadline
+	return true
+}
+
+// Preempted reports whether agentID is registered and about to be reclaimed
+func (r *MCPRegistry) Preempted(agentID string) bool {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	agent, exists := r.agents[agentID]
+	return exists && agent.Preempted()
+}
+
+// Resumes reports whether agentID takes over checkpointed calls of toolName:
+// it declares the tool resumable and is not about to be reclaimed itself
+func (r *MCPRegistry) Resumes(agentID, toolName string) bool {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	agent, exists := r.agents[agentID]
+	if !exists || agent.Preempted() {
+		return false
+	}
+	for _, tool := range agent.Tools {
+		if tool.Name == toolName {
+			return tool.Resumable
+		}
+	}
+	return false
+}
+
+// filterPreempted drops local agents that are about to be reclaimed
+func (fm *FederationManager) filterPreempted(agents []string) []string {
+	kept := agents[:0:0]
+	for _, agentID := range agents {
+		if !fm.mcpRegistry.Preempted(agentID) {
+			kept = append(kept, agentID)
+		}
+	}
+	return kept
+}
+
+// SelectResumeAgent picks the agent to resume a checkpointed call of
+// toolName among healthy local agents that resume the tool. It returns
+// false if none does.
+func (fm *FederationManager) SelectResumeAgent(toolName string) (string, bool) {
+	var resumers []string
+	for _, tool := range fm.mcpRegistry.ListTools() {
+		if tool.Tool.Name == toolName && fm.mcpRegistry.Resumes(tool.AgentID, toolName) {
+			resumers = append(resumers, tool.AgentID)
+		}
+	}
+	candidates := fm.healthyRouteAgents(resumers, fm.config.HealthThreshold)
+	if len(candidates) == 0 {
+		return "", false
+	}
+	fm.metricsMutex.RLock()
+	defer fm.metricsMutex.RUnlock()
+	selected, err := fm.loadBalancer.SelectAgent(candidates, fm.agentMetrics, &RequestContext{ToolName: toolName}, fm.config.DefaultLoadBalanceMode)
+	return selected, err == nil
+}
+
+// Checkpoint is a call an agent could not finish, waiting to be resumed by
+// another agent from the state it saved
+type Checkpoint struct {
+	RequestID   string                 `json:"requestId"`
+	Tool        string                 `json:"tool"`
+	Parameters  map[string]interface{} `json:"parameters,omitempty"`
+	Artifact    string                 `json:"artifact"` // Digest of the saved state in the artifact store
+	From        string                 `json:"from"`
+	ResumeAgent string                 `json:"resumeAgent,omitempty"` // Empty until an agent resuming the tool is available
+	Created     time.Time              `json:"created"`
+	Delivered   time.Time              `json:"delivered"` // Zero until the resuming agent was sent the checkpoint
+}
+
+// CheckpointLedger holds checkpointed calls until the agent resuming them
+// answers. Their state is kept in the artifact store.
+type CheckpointLedger struct {
+	checkpoints map[string]*Checkpoint // By request ID
+	artifacts   *ArtifactStore
+	mu          sync.Mutex
+}
+
+// NewCheckpointLedger creates an empty ledger keeping state in artifacts
+func NewCheckpointLedger(artifacts *ArtifactStore) *CheckpointLedger {
+	return &CheckpointLedger{
+		checkpoints: make(map[string]*Checkpoint),
+		artifacts:   artifacts,
+	}
+}
+
+// Record stores a checkpoint sent by from, to be resumed by resumeAgent or,
+// if it is empty, the first agent able to. A checkpoint of a call that was
+// already checkpointed replaces the earlier one.
+func (l *CheckpointLedger) Record(from string, body protocol.CheckpointBody, resumeAgent string) Checkpoint {
+	digest := l.artifacts.Put(body.State)
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := time.Now()
+	for requestID, checkpoint := range l.checkpoints {
+		if requestID == body.RequestID || now.Sub(checkpoint.Created) > checkpointTTL {
+			l.removeLocked(requestID)
+		}
+	}
+	checkpoint := &Checkpoint{
+		RequestID:   body.RequestID,
+		Tool:        body.Tool,
+		Parameters:  body.Parameters,
+		Artifact:    digest,
+		From:        from,
+		ResumeAgent: resumeAgent,
+		Created:     now,
+	}
+	l.checkpoints[body.RequestID] = checkpoint
+	return *checkpoint
+}
+
+// Due returns the checkpoints agentID should resume now: those assigned to
+// it that it was not sent within the lease, and unassigned ones of tools it
+// resumes, which are assigned to it.
+func (l *CheckpointLedger) Due(agentID string, resumes func(toolName string) bool) []protocol.ResumeBody {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := time.Now()
+	var due []protocol.ResumeBody
+	for _, checkpoint := range l.checkpoints {
+		if checkpoint.ResumeAgent == "" && resumes(checkpoint.Tool) {
+			checkpoint.ResumeAgent = agentID
+		}
+		if checkpoint.ResumeAgent != agentID || now.Sub(checkpoint.Delivered) < resumeLease {
+			continue
+		}
+		state, exists := l.artifacts.Get(checkpoint.Artifact)
+		if !exists {
+			continue
+		}
+		checkpoint.Delivered = now
+		due = append(due, protocol.ResumeBody{
+			RequestID:  checkpoint.RequestID,
+			Tool:       checkpoint.Tool,
+			Parameters: checkpoint.Parameters,
+			State:      state,
+			Artifact:   checkpoint.Artifact,
+			From:       checkpoint.From,
+		})
+	}
+	sort.Slice(due, func(i, j int) bool { return due[i].RequestID < due[j].RequestID })
+	return due
+}
+
+// Release unassigns the checkpoints agentID was to resume, so other agents
+// take them over. It returns how many were released.
+func (l *CheckpointLedger) Release(agentID string) int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	released := 0
+	for _, checkpoint := range l.checkpoints {
+		if checkpoint.ResumeAgent == agentID {
+			checkpoint.ResumeAgent = ""
+			checkpoint.Delivered = time.Time{}
+			released++
+		}
+	}
+	return released
+}
+
+// Complete forgets a checkpoint once its call was answered, dropping its state
+func (l *CheckpointLedger) Complete(requestID string) (Checkpoint, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	checkpoint, exists := l.checkpoints[requestID]
+	if !exists {
+		return Checkpoint{}, false
+	}
+	l.removeLocked(requestID)
+	return *checkpoint, true
+}
+
+// Checkpoints lists the calls waiting to be resumed, oldest first
+func (l *CheckpointLedger) Checkpoints() []Checkpoint {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	list := make([]Checkpoint, 0, len(l.checkpoints))
+	for _, checkpoint := range l.checkpoints {
+		list = append(list, *checkpoint)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Created.Before(list[j].Created) })
+	return list
+}
+
+// removeLocked drops a checkpoint and its hold on the saved state
+func (l *CheckpointLedger) removeLocked(requestID string) {
+	if checkpoint, exists := l.checkpoints[requestID]; exists {
+		l.artifacts.Release(checkpoint.Artifact)
+		delete(l.checkpoints, requestID)
+	}
+}
+
+// handlePreemptionNotice takes an agent, or one of its replicas, out of
+// rotation ahead of it being reclaimed. Checkpoints the agent was to resume
+// are handed to others; calls it is running should be checkpointed before
+// the deadline.
+func (b *Broker) handlePreemptionNotice(w http.ResponseWriter, env *protocol.GenericEnvelope) {
+	typed, err := protocol.ParseTyped[protocol.PreemptionNoticeBody](env)
+	if err != nil {
+		b.rejectInvalidBody(w, env, err)
+		return
+	}
+	body := typed.Body
+
+	var deadline time.Time
+	if body.Deadline > 0 {
+		deadline = time.UnixMilli(body.Deadline)
+	}
+	if !b.mcpRegistry.PreemptAgent(env.Agent, body.InstanceID, deadline) {
+		b.reject(w, env, protocol.CodeUnknownAgent, "Agent not registered")
+		return
+	}
+
+	result := map[string]interface{}{
+		"agent": env.Agent,
+	}
+	if body.InstanceID != "" {
+		result["instanceId"] = body.InstanceID
+		log.Printf("Instance %s of MCP agent %s is being preempted", body.InstanceID, env.Agent)
+	} else {
+		result["reassigned"] = b.checkpoints.Release(env.Agent)
+		log.Printf("MCP agent %s is being preempted", env.Agent)
+	}
+	b.writeAck(w, env, "preempted", result)
+}
+
+// handleCheckpoint stores the state of a call its agent cannot finish and
+// assigns the call to an agent that resumes the tool. The resuming agent is
+// sent the checkpoint in its heartbeat acks and answers the original caller.
+func (b *Broker) handleCheckpoint(w http.ResponseWriter, env *protocol.GenericEnvelope) {
+	typed, err := protocol.ParseTyped[protocol.CheckpointBody](env)
+	if err != nil {
+		b.rejectInvalidBody(w, env, err)
+		return
+	}
+	body := typed.Body
+
+	if _, exists := b.mcpRegistry.GetAgent(env.Agent); !exists {
+		b.reject(w, env, protocol.CodeUnknownAgent, "Agent not registered")
+		return
+	}
+
+	resumeAgent, _ := b.federation.SelectResumeAgent(body.Tool)
+	checkpoint := b.checkpoints.Record(env.Agent, body, resumeAgent)
+	if resumeAgent == "" {
+		log.Printf("Checkpoint of %s (%s) from %s waits for an agent resuming the tool", body.RequestID, body.Tool, env.Agent)
+		b.requests.Advance(body.RequestID, RequestReceived, "", "checkpointed by "+env.Agent)
+	} else {
+		log.Printf("Checkpoint of %s (%s) from %s assigned to %s", body.RequestID, body.Tool, env.Agent, resumeAgent)
+		b.requests.Advance(body.RequestID, RequestRouted, resumeAgent, "resumes checkpoint of "+env.Agent)
+	}
+
+	b.writeAck(w, env, "checkpointed", map[string]interface{}{
+		"requestId":   checkpoint.RequestID,
+		"artifact":    checkpoint.Artifact,
+		"resumeAgent": checkpoint.ResumeAgent,
+	})
+}
+
+// handleAdminCheckpoints lists the calls waiting to be resumed
+func (b *Broker) handleAdminCheckpoints(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	writeJSON(w, http.StatusOK, b.checkpoints.Checkpoints())
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/fep-fem/protocol"
+)
+
+// spotBroker runs a transcoder on a spot agent and on an on-demand agent
+// that resumes the spot agent's checkpoints
+func spotBroker() *Broker {
+	broker := NewBroker()
+	broker.mcpRegistry.RegisterAgent("spot", &MCPAgent{
+		ID:    "spot",
+		Tools: []protocol.MCPTool{{Name: "video.transcode", Resumable: true}},
+	})
+	broker.mcpRegistry.RegisterAgent("ondemand", &MCPAgent{
+		ID:    "ondemand",
+		Tools: []protocol.MCPTool{{Name: "video.transcode", Resumable: true}},
+	})
+	broker.federation.agentMetrics["spot"] = &AgentMetrics{AgentID: "spot", HealthScore: 0.9}
+	broker.federation.agentMetrics["ondemand"] = &AgentMetrics{AgentID: "ondemand", HealthScore: 0.9}
+	return broker
+}
+
+// postEnvelope sends an envelope and returns the ack's result
+func postEnvelope(t *testing.T, broker *Broker, envelope interface{}) map[string]interface{} {
+	t.Helper()
+	data, _ := json.Marshal(envelope)
+	resp := httptest.NewRecorder()
+	broker.ServeHTTP(resp, httptest.NewRequest(http.MethodPost, "/", bytes.NewReader(data)))
+	if resp.Code != http.StatusOK {
+		t.Fatalf("Envelope should be accepted, got %d %s", resp.Code, resp.Body.String())
+	}
+	var ack protocol.AckEnvelope
+	json.Unmarshal(resp.Body.Bytes(), &ack)
+	result, _ := ack.Body.Result.(map[string]interface{})
+	return result
+}
+
+// resumeAssignments returns the checkpoints a heartbeat ack hands the agent
+func resumeAssignments(t *testing.T, broker *Broker, agentID string) []protocol.ResumeBody {
+	t.Helper()
+	result := postEnvelope(t, broker, protocol.NewTypedEnvelope(agentID, protocol.HeartbeatBody{}))
+	var resume []protocol.ResumeBody
+	data, _ := json.Marshal(result["resume"])
+	json.Unmarshal(data, &resume)
+	return resume
+}
+
+func TestPreemptedAgentLeavesRotation(t *testing.T) {
+	broker := spotBroker()
+	deadline := time.Now().Add(2 * time.Minute).UnixMilli()
+	postEnvelope(t, broker, protocol.NewTypedEnvelope("spot", protocol.PreemptionNoticeBody{Deadline: deadline}))
+
+	if agent, _ := broker.mcpRegistry.GetAgent("spot"); !agent.Preempted() || agent.PreemptionDeadline.UnixMilli() != deadline {
+		t.Fatalf("Agent should be preempted until its deadline, got %+v", agent)
+	}
+	for i := 0; i < 5; i++ {
+		decision, err := broker.federation.RouteToolInvocation("video.transcode", "", &RequestContext{})
+		if err != nil || decision.SelectedAgent != "ondemand" {
+			t.Fatalf("Calls should avoid the preempted agent, got %+v %v", decision, err)
+		}
+	}
+	tools, _ := broker.mcpRegistry.DiscoverTools(protocol.ToolQuery{Capabilities: []string{"video.*"}})
+	if len(tools) != 1 || tools[0].AgentID != "ondemand" {
+		t.Errorf("Discovery should skip the preempted agent, got %+v", tools)
+	}
+
+	// A replacement machine registering again is back in rotation
+	broker.mcpRegistry.RegisterAgent("spot", &MCPAgent{ID: "spot", Tools: []protocol.MCPTool{{Name: "video.transcode"}}})
+	if broker.mcpRegistry.Preempted("spot") {
+		t.Error("Registering again should clear the preemption")
+	}
+}
+
+func TestCheckpointResumedOnAnotherAgent(t *testing.T) {
+	broker := spotBroker()
+	call, _ := protocol.NewToolCall("caller").Tool("video.transcode").RequestID("job-7").Build()
+	postEnvelope(t, broker, call)
+	postEnvelope(t, broker, protocol.NewTypedEnvelope("spot", protocol.PreemptionNoticeBody{}))
+
+	checkpoint := protocol.CheckpointBody{
+		RequestID:  "job-7",
+		Tool:       "video.transcode",
+		Parameters: map[string]interface{}{"input": "s3://clips/7.mov"},
+		State:      []byte("frame=1200"),
+	}
+	result := postEnvelope(t, broker, protocol.NewTypedEnvelope("spot", checkpoint))
+	if result["resumeAgent"] != "ondemand" || result["artifact"] != artifactDigest(checkpoint.State) {
+		t.Fatalf("Checkpoint should be stored and assigned to the on-demand agent, got %v", result)
+	}
+
+	if resume := resumeAssignments(t, broker, "spot"); len(resume) != 0 {
+		t.Errorf("The preempted agent should not resume calls, got %+v", resume)
+	}
+	resume := resumeAssignments(t, broker, "ondemand")
+	if len(resume) != 1 || resume[0].RequestID != "job-7" || string(resume[0].State) != "frame=1200" || resume[0].From != "spot" {
+		t.Fatalf("Expected the checkpoint in the heartbeat ack, got %+v", resume)
+	}
+	if resume := resumeAssignments(t, broker, "ondemand"); len(resume) != 0 {
+		t.Errorf("The checkpoint should not be sent again within the lease, got %+v", resume)
+	}
+
+	pubKey, privKey, _ := protocol.GenerateKeyPair()
+	registerDiscoveryClient(broker, "ondemand", pubKey)
+	envelope, _ := protocol.NewToolResult("ondemand", "job-7").Result("done").SignWith(privKey)
+	postEnvelope(t, broker, envelope)
+	if checkpoints := broker.checkpoints.Checkpoints(); len(checkpoints) != 0 {
+		t.Errorf("Answered checkpoint should be forgotten, got %+v", checkpoints)
+	}
+	if artifacts := broker.artifacts.List(); len(artifacts) != 0 {
+		t.Errorf("Saved state should be dropped once resumed, got %+v", artifacts)
+	}
+}
+
+func TestCheckpointWaitsForResumingAgent(t *testing.T) {
+	broker := NewBroker()
+	broker.mcpRegistry.RegisterAgent("spot", &MCPAgent{ID: "spot", Tools: []protocol.MCPTool{{Name: "video.transcode"}}})
+	broker.mcpRegistry.RegisterAgent("legacy", &MCPAgent{ID: "legacy", Tools: []protocol.MCPTool{{Name: "video.transcode"}}})
+
+	result := postEnvelope(t, broker, protocol.NewTypedEnvelope("spot", protocol.CheckpointBody{RequestID: "job-8", Tool: "video.transcode", State: []byte("frame=40")}))
+	if result["resumeAgent"] != "" {
+		t.Fatalf("No agent resumes the tool yet, got %v", result)
+	}
+	if resume := resumeAssignments(t, broker, "legacy"); len(resume) != 0 {
+		t.Errorf("Agents that do not resume the tool should not get the checkpoint, got %+v", resume)
+	}
+
+	broker.mcpRegistry.RegisterAgent("resumer", &MCPAgent{ID: "resumer", Tools: []protocol.MCPTool{{Name: "video.transcode", Resumable: true}}})
+	if resume := resumeAssignments(t, broker, "resumer"); len(resume) != 1 || resume[0].RequestID != "job-8" {
+		t.Fatalf("A newly available resuming agent should take the checkpoint, got %+v", resume)
+	}
+
+	// If the resuming agent is preempted in turn, the checkpoint is free for others
+	result = postEnvelope(t, broker, protocol.NewTypedEnvelope("resumer", protocol.PreemptionNoticeBody{}))
+	if result["reassigned"] != 1.0 {
+		t.Errorf("Expected the checkpoint released, got %v", result)
+	}
+	if checkpoints := broker.checkpoints.Checkpoints(); len(checkpoints) != 1 || checkpoints[0].ResumeAgent != "" {
+		t.Errorf("Expected the checkpoint unassigned, got %+v", checkpoints)
+	}
+}
+
+func TestArtifactStoreSharesContent(t *testing.T) {
+	store := NewArtifactStore()
+	first := store.Put([]byte("state"))
+	second := store.Put([]byte("state"))
+	if first != second || len(store.List()) != 1 {
+		t.Fatalf("Same content should be stored once, got %s %s %+v", first, second, store.List())
+	}
+
+	store.Release(first)
+	if data, exists := store.Get(first); !exists || string(data) != "state" {
+		t.Errorf("Content should outlive one of its holders, got %q %v", data, exists)
+	}
+	store.Release(second)
+	if _, exists := store.Get(first); exists {
+		t.Error("Content should be deleted once released by every holder")
+	}
+}
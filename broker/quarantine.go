@@ -0,0 +1,203 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/fep-fem/protocol"
+)
+
+// Automatic quarantine thresholds
+const (
+	quarantineTrustThreshold   = 0.3 // Agents whose trust falls below this are quarantined
+	signatureFailureThreshold  = 5   // Failed signatures from one source within the window before a security event
+	signatureFailureWindow     = 10 * time.Minute
+	maxSignatureFailureSources = 4096 // Sources whose failures are counted at once
+)
+
+// QuarantineTrigger records why an agent was quarantined
+type QuarantineTrigger string
+
+const (
+	QuarantineOperator QuarantineTrigger = "operator"
+	QuarantineAnomaly  QuarantineTrigger = "anomaly"
+)
+
+// QuarantineRecord describes a quarantined agent. Unlike revocation the agent
+// stays registered, so releasing it restores service without re-registration.
+type QuarantineRecord struct {
+	AgentID string            `json:"agentId"`
+	Trigger QuarantineTrigger `json:"trigger"`
+	Reason  string            `json:"reason"`
+	Since   time.Time         `json:"since"`
+}
+
+// Quarantine hides an agent's tools from discovery and rejects its calls
+func (b *Broker) Quarantine(agentID string, trigger QuarantineTrigger, reason string) {
+	b.mu.Lock()
+	if _, exists := b.quarantined[agentID]; exists {
+		b.mu.Unlock()
+		return
+	}
+	b.quarantined[agentID] = &QuarantineRecord{
+		AgentID: agentID,
+		Trigger: trigger,
+		Reason:  reason,
+		Since:   time.Now(),
+	}
+	b.mu.Unlock()
+
+	b.securityEvents.Raise(SecurityEvent{
+		Type:    SecurityEventAgentQuarantined,
+		AgentID: agentID,
+		Detail:  fmt.Sprintf("quarantined (%s): %s", trigger, reason),
+	})
+}
+
+// ReleaseQuarantine returns an agent to service
+func (b *Broker) ReleaseQuarantine(agentID string) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	_, existed := b.quarantined[agentID]
+	delete(b.quarantined, agentID)
+	return existed
+}
+
+// IsQuarantined reports whether an agent is quarantined
+func (b *Broker) IsQuarantined(agentID string) bool {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	_, exists := b.quarantined[agentID]
+	return exists
+}
+
+// QuarantinedAgents lists quarantine records ordered by agent ID
+func (b *Broker) QuarantinedAgents() []QuarantineRecord {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	records := make([]QuarantineRecord, 0, len(b.quarantined))
+	for _, record := range b.quarantined {
+		records = append(records, *record)
+	}
+	sort.Slice(records, func(i, j int) bool { return records[i].AgentID < records[j].AgentID })
+	return records
+}
+
+// recordSignatureFailure counts an envelope whose signature did not verify
+// against the connection it arrived on. The agent it names never proved its
+// identity, so the agent is not penalized: a source reaching the threshold
+// within the window raises a security event instead.
+func (b *Broker) recordSignatureFailure(claimedAgent string, source *TransportMetadata) {
+	origin := ""
+	if source != nil {
+		origin = source.SourceIP
+	}
+
+	b.mu.Lock()
+	cutoff := time.Now().Add(-signatureFailureWindow)
+	if _, counted := b.signatureFailures[origin]; !counted {
+		b.pruneSignatureFailuresLocked(cutoff)
+	}
+	recent := b.signatureFailures[origin][:0]
+	for _, failure := range b.signatureFailures[origin] {
+		if failure.After(cutoff) {
+			recent = append(recent, failure)
+		}
+	}
+	recent = append(recent, time.Now())
+	b.signatureFailures[origin] = recent
+	reached := len(recent) >= signatureFailureThreshold
+	if reached {
+		delete(b.signatureFailures, origin)
+	}
+	b.mu.Unlock()
+
+	if reached {
+		if origin == "" {
+			origin = "an unknown source"
+		}
+		b.securityEvents.Raise(SecurityEvent{
+			Type:    SecurityEventSignatureFailures,
+			AgentID: claimedAgent,
+			Detail:  fmt.Sprintf("%d envelopes with invalid signatures from %s within %s, the latest claiming to be %s", len(recent), origin, signatureFailureWindow, claimedAgent),
+			Source:  source,
+		})
+	}
+}
+
+// pruneSignatureFailuresLocked drops the sources with no failure since cutoff
+// and, if the map is still full, the source whose last failure is oldest, so
+// a new source can be counted. The caller holds b.mu.
+func (b *Broker) pruneSignatureFailuresLocked(cutoff time.Time) {
+	for origin, failures := range b.signatureFailures {
+		if !failures[len(failures)-1].After(cutoff) {
+			delete(b.signatureFailures, origin)
+		}
+	}
+	if len(b.signatureFailures) < maxSignatureFailureSources {
+		return
+	}
+	oldest, oldestAt := "", time.Now()
+	for origin, failures := range b.signatureFailures {
+		if last := failures[len(failures)-1]; last.Before(oldestAt) {
+			oldest, oldestAt = origin, last
+		}
+	}
+	delete(b.signatureFailures, oldest)
+}
+
+// hideQuarantinedTools drops tools offered by quarantined agents from discovery results
+func (b *Broker) hideQuarantinedTools(tools []protocol.DiscoveredTool) []protocol.DiscoveredTool {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	if len(b.quarantined) == 0 {
+		return tools
+	}
+
+	visible := make([]protocol.DiscoveredTool, 0, len(tools))
+	for _, tool := range tools {
+		if _, quarantined := b.quarantined[tool.AgentID]; !quarantined {
+			visible = append(visible, tool)
+		}
+	}
+	return visible
+}
+
+// rejectQuarantined refuses requests from a quarantined agent
+func (b *Broker) rejectQuarantined(w http.ResponseWriter, env *protocol.GenericEnvelope) {
+	b.reject(w, env, protocol.CodeQuarantined, fmt.Sprintf("Agent %s is quarantined", env.Agent))
+}
+
+// handleAdminQuarantine lists (GET), quarantines (POST) and releases (DELETE) agents
+func (b *Broker) handleAdminQuarantine(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		writeJSON(w, http.StatusOK, b.QuarantinedAgents())
+	case http.MethodPost:
+		var request struct {
+			AgentID string `json:"agentId"`
+			Reason  string `json:"reason"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil || request.AgentID == "" {
+			http.Error(w, "Invalid quarantine request", http.StatusBadRequest)
+			return
+		}
+		b.Quarantine(request.AgentID, QuarantineOperator, request.Reason)
+		writeJSON(w, http.StatusOK, map[string]interface{}{"status": "quarantined", "agentId": request.AgentID})
+	case http.MethodDelete:
+		agentID := r.URL.Query().Get("agent")
+		if !b.ReleaseQuarantine(agentID) {
+			http.Error(w, "Agent is not quarantined", http.StatusNotFound)
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]interface{}{"status": "released", "agentId": agentID})
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
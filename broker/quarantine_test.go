@@ -0,0 +1,157 @@
+package main
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/fep-fem/protocol"
+)
+
+func TestQuarantineLifecycle(t *testing.T) {
+	broker := NewBroker()
+	broker.SetAdminToken("secret")
+	broker.mcpRegistry.RegisterAgent("math-agent", &MCPAgent{
+		ID:            "math-agent",
+		Tools:         []protocol.MCPTool{{Name: "math.add"}},
+		LastHeartbeat: time.Now(),
+	})
+
+	clientPub, clientPriv, _ := protocol.GenerateKeyPair()
+	mathPub, mathPriv, _ := protocol.GenerateKeyPair()
+	registerDiscoveryClient(broker, "client-1", clientPub)
+	registerDiscoveryClient(broker, "math-agent", mathPub)
+
+	send := func(envType protocol.EnvelopeType, agentID string, key ed25519.PrivateKey, body interface{}) *httptest.ResponseRecorder {
+		raw, _ := json.Marshal(body)
+		envelope := protocol.NewEnvelope(envType, agentID)
+		envelope.Body = raw
+		envelope.Sign(key)
+		data, _ := json.Marshal(envelope)
+		recorder := httptest.NewRecorder()
+		broker.ServeHTTP(recorder, httptest.NewRequest(http.MethodPost, "/", bytes.NewReader(data)))
+		return recorder
+	}
+	admin := func(method, path, body string) int {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer secret")
+		recorder := httptest.NewRecorder()
+		broker.ServeHTTP(recorder, req)
+		return recorder.Code
+	}
+	discoveredAgents := func() int {
+		discover := protocol.DiscoverToolsBody{Query: protocol.ToolQuery{Capabilities: []string{"math.add"}}}
+		recorder := send(protocol.EnvelopeDiscoverTools, "client-1", clientPriv, discover)
+		var response protocol.ToolsDiscoveredBody
+		readAck(t, recorder.Body).ResultAs(&response)
+		return len(response.Tools)
+	}
+
+	if discoveredAgents() != 1 {
+		t.Fatal("Expected math-agent to be discoverable")
+	}
+
+	if status := admin(http.MethodPost, "/admin/quarantine", `{"agentId":"math-agent","reason":"investigating"}`); status != http.StatusOK {
+		t.Fatalf("Expected operator quarantine to succeed, got %d", status)
+	}
+	if discoveredAgents() != 0 {
+		t.Error("Quarantined agent's tools should be hidden from discovery")
+	}
+	call := protocol.ToolCallBody{Tool: "math.add", RequestID: "req-1"}
+	if recorder := send(protocol.EnvelopeToolCall, "math-agent", mathPriv, call); recorder.Code != http.StatusForbidden {
+		t.Errorf("Expected calls from quarantined agent to be rejected, got %d", recorder.Code)
+	}
+
+	// Quarantine is not revocation: the agent stays registered and release restores it
+	if _, exists := broker.mcpRegistry.GetAgent("math-agent"); !exists {
+		t.Error("Quarantine must not unregister the agent")
+	}
+	if status := admin(http.MethodDelete, "/admin/quarantine?agent=math-agent", ""); status != http.StatusOK {
+		t.Fatalf("Expected release to succeed, got %d", status)
+	}
+	if discoveredAgents() != 1 {
+		t.Error("Released agent should be discoverable again")
+	}
+	if recorder := send(protocol.EnvelopeToolCall, "math-agent", mathPriv, call); recorder.Code != http.StatusOK {
+		t.Errorf("Expected calls after release to succeed, got %d", recorder.Code)
+	}
+	if status := admin(http.MethodDelete, "/admin/quarantine?agent=math-agent", ""); status != http.StatusNotFound {
+		t.Errorf("Expected 404 releasing an agent that is not quarantined, got %d", status)
+	}
+}
+
+func TestAutomaticQuarantine(t *testing.T) {
+	broker := NewBroker()
+	pubKey, _, _ := protocol.GenerateKeyPair()
+	_, wrongKey, _ := protocol.GenerateKeyPair()
+	registerDiscoveryClient(broker, "client-1", pubKey)
+
+	// Forged envelopes naming an agent do not quarantine it; their source is reported
+	for i := 0; i < signatureFailureThreshold; i++ {
+		envelope := protocol.NewEnvelope(protocol.EnvelopeDiscoverTools, "client-1")
+		envelope.Body = json.RawMessage(`{"query":{"capabilities":["math.add"]}}`)
+		envelope.Sign(wrongKey)
+		data, _ := json.Marshal(envelope)
+		req := httptest.NewRequest(http.MethodPost, "/", bytes.NewReader(data))
+		req.RemoteAddr = "203.0.113.9:4000"
+		broker.ServeHTTP(httptest.NewRecorder(), req)
+	}
+	if broker.IsQuarantined("client-1") {
+		t.Error("Signature failures must not quarantine the agent an envelope names")
+	}
+	events := broker.securityEvents.Recent()
+	if len(events) != 1 || events[0].Type != SecurityEventSignatureFailures || events[0].Source == nil || events[0].Source.SourceIP != "203.0.113.9" {
+		t.Errorf("Expected one signature failure event for the source, got %+v", events)
+	}
+
+	// Anomalies: trust falling below the threshold after honeypot hits
+	prober, proberKey, _ := protocol.GenerateKeyPair()
+	registerDiscoveryClient(broker, "prober", prober)
+	broker.RegisterHoneypot(HoneypotTool{AgentID: "vault", Tool: protocol.MCPTool{Name: "vault.export"}})
+	for i := 0; i < 2; i++ {
+		envelope := protocol.NewEnvelope(protocol.EnvelopeToolCall, "prober")
+		envelope.Body = json.RawMessage(`{"tool":"vault.export","requestId":"probe"}`)
+		envelope.Sign(proberKey)
+		data, _ := json.Marshal(envelope)
+		broker.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/", bytes.NewReader(data)))
+	}
+	if !broker.IsQuarantined("prober") {
+		t.Error("Expected repeated honeypot hits to quarantine the agent")
+	}
+}
+
+func TestSignatureFailureSourcesBounded(t *testing.T) {
+	broker := NewBroker()
+	stale := time.Now().Add(-2 * signatureFailureWindow)
+	broker.signatureFailures["198.51.100.1"] = []time.Time{stale}
+
+	// Sources idle past the window are dropped once a new source is counted
+	broker.recordSignatureFailure("client-1", &TransportMetadata{SourceIP: "198.51.100.2"})
+	if _, kept := broker.signatureFailures["198.51.100.1"]; kept {
+		t.Error("Expected the idle source to be dropped")
+	}
+
+	// A full map makes room by dropping the source whose last failure is oldest
+	broker.signatureFailures = make(map[string][]time.Time)
+	recent := time.Now().Add(-time.Minute)
+	for i := 0; i < maxSignatureFailureSources; i++ {
+		broker.signatureFailures[fmt.Sprintf("source-%d", i)] = []time.Time{recent}
+	}
+	broker.signatureFailures["source-0"] = []time.Time{recent.Add(-time.Minute)}
+	broker.recordSignatureFailure("client-1", &TransportMetadata{SourceIP: "198.51.100.3"})
+	if len(broker.signatureFailures) != maxSignatureFailureSources {
+		t.Errorf("Expected at most %d sources counted, got %d", maxSignatureFailureSources, len(broker.signatureFailures))
+	}
+	if _, kept := broker.signatureFailures["source-0"]; kept {
+		t.Error("Expected the oldest source to make room")
+	}
+	if _, counted := broker.signatureFailures["198.51.100.3"]; !counted {
+		t.Error("Expected the new source to be counted")
+	}
+}
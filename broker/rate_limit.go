@@ -0,0 +1,78 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/fep-fem/protocol"
+)
+
+// SetRateLimit limits the envelopes accepted from each agent ID and each
+// source IP. Throttled envelopes are refused before they are queued.
+func (b *Broker) SetRateLimit(config protocol.RateLimitConfig) {
+	limiter := protocol.NewRateLimiter(config)
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.rateLimiter = limiter
+}
+
+// checkRateLimit takes a token for the envelope's agent and source IP,
+// refusing it with rate_limited and a Retry-After header when either is
+// exhausted. Agent IDs are limited as claimed, before signatures are
+// checked, so the per-IP limit is what bounds a sender spoofing them.
+func (b *Broker) checkRateLimit(w http.ResponseWriter, env *protocol.GenericEnvelope, sourceIP string) bool {
+	b.mu.RLock()
+	limiter := b.rateLimiter
+	b.mu.RUnlock()
+	if limiter == nil {
+		return true
+	}
+
+	var throttle *protocol.ThrottleError
+	if err := limiter.Allow(env.Agent, sourceIP); !errors.As(err, &throttle) {
+		return true
+	}
+	errBody := throttle.ErrorBody()
+	rejection := b.newError(env, errBody.Code, errBody.Message)
+	rejection.Body.Details = errBody.Details
+	// Whole seconds, rounded up so the sender never retries too early
+	w.Header().Set("Retry-After", strconv.Itoa(int((throttle.RetryAfter+999_999_999)/1_000_000_000)))
+	writeError(w, rejection)
+	return false
+}
+
+// parseRateLimitOverrides parses comma-separated key=rate/burst pairs. Keys
+// that are IP addresses override the per-IP limit, others the limit of the
+// agent with that ID.
+func parseRateLimitOverrides(s string, config *protocol.RateLimitConfig) error {
+	for _, pair := range strings.Split(s, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		key, value, ok := strings.Cut(pair, "=")
+		if !ok || key == "" {
+			return fmt.Errorf("%q is not key=rate/burst", pair)
+		}
+		limit, err := protocol.ParseRateLimit(value)
+		if err != nil {
+			return err
+		}
+		if net.ParseIP(key) != nil {
+			if config.IPs == nil {
+				config.IPs = make(map[string]protocol.RateLimit)
+			}
+			config.IPs[key] = limit
+		} else {
+			if config.Agents == nil {
+				config.Agents = make(map[string]protocol.RateLimit)
+			}
+			config.Agents[key] = limit
+		}
+	}
+	return nil
+}
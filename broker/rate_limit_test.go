@@ -0,0 +1,72 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/fep-fem/protocol"
+)
+
+func TestBrokerThrottlesAgents(t *testing.T) {
+	broker := NewBroker()
+	broker.SetRateLimit(protocol.RateLimitConfig{
+		PerAgent: protocol.RateLimit{Rate: 0.01, Burst: 1},
+		Agents:   map[string]protocol.RateLimit{"trusted": {}},
+	})
+
+	heartbeat := func(agentID string) *httptest.ResponseRecorder {
+		envelope, err := protocol.NewHeartbeat(agentID).Build()
+		if err != nil {
+			t.Fatalf("Failed to build heartbeat: %v", err)
+		}
+		data, _ := json.Marshal(envelope)
+		recorder := httptest.NewRecorder()
+		broker.ServeHTTP(recorder, httptest.NewRequest(http.MethodPost, "/", bytes.NewReader(data)))
+		return recorder
+	}
+
+	if resp := heartbeat("worker"); resp.Code == http.StatusTooManyRequests {
+		t.Fatalf("First envelope should not be throttled: %s", resp.Body.String())
+	}
+	resp := heartbeat("worker")
+	if resp.Code != http.StatusTooManyRequests || resp.Header().Get("Retry-After") == "" {
+		t.Fatalf("Expected 429 with Retry-After, got %d %v", resp.Code, resp.Header())
+	}
+	_, err := protocol.ParseResponse(resp.Body.Bytes())
+	var errBody *protocol.ErrorBody
+	if !errors.As(err, &errBody) || errBody.Code != protocol.CodeRateLimited || errBody.RetryAfter() <= 0 {
+		t.Fatalf("Expected rate_limited with a retry-after, got %v", err)
+	}
+	if errBody.Details["scope"] != protocol.RateLimitScopeAgent {
+		t.Errorf("Throttle should cite the agent scope: %v", errBody.Details)
+	}
+
+	for i := 0; i < 3; i++ {
+		if resp := heartbeat("trusted"); resp.Code == http.StatusTooManyRequests {
+			t.Fatalf("Agent with an unlimited override was throttled")
+		}
+	}
+}
+
+func TestParseRateLimitOverrides(t *testing.T) {
+	var config protocol.RateLimitConfig
+	if err := parseRateLimitOverrides("batch=100/500, 10.0.0.7=5/10,trusted=0", &config); err != nil {
+		t.Fatalf("Failed to parse overrides: %v", err)
+	}
+	if config.Agents["batch"] != (protocol.RateLimit{Rate: 100, Burst: 500}) || config.Agents["trusted"] != (protocol.RateLimit{}) {
+		t.Errorf("Unexpected agent overrides %+v", config.Agents)
+	}
+	if config.IPs["10.0.0.7"] != (protocol.RateLimit{Rate: 5, Burst: 10}) {
+		t.Errorf("Unexpected address overrides %+v", config.IPs)
+	}
+
+	for _, overrides := range []string{"batch", "=5", "batch=fast"} {
+		if err := parseRateLimitOverrides(overrides, &config); err == nil {
+			t.Errorf("Overrides %q should fail", overrides)
+		}
+	}
+}
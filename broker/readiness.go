@@ -0,0 +1,82 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+)
+
+// readinessCheck is one condition the broker must meet before it takes traffic
+type readinessCheck struct {
+	name  string
+	check func() error
+}
+
+// ReadinessReport is the response of /readyz
+type ReadinessReport struct {
+	Ready  bool              `json:"ready"`
+	Checks map[string]string `json:"checks"` // "ok" or why the check fails
+}
+
+// readinessChecks lists what must hold before orchestrators route traffic to
+// the broker: restored state rebuilt, peers synced, workers running and the
+// broker out of maintenance
+func (b *Broker) readinessChecks() []readinessCheck {
+	return []readinessCheck{
+		{"registry", func() error {
+			if status := b.Warmup(); status.Phase == WarmupWarming {
+				return fmt.Errorf("restoring state: %d of %d agents done",
+					status.AgentsRestored+status.AgentsSkipped, status.AgentsTotal)
+			}
+			return nil
+		}},
+		{"federation", func() error {
+			if pending := b.federation.peerSyncPending(); pending > 0 {
+				return fmt.Errorf("awaiting registration sync with %d peers", pending)
+			}
+			return nil
+		}},
+		{"workers", func() error {
+			if workers := b.envelopeWorkers(); workers == nil || !workers.Accepting() {
+				return fmt.Errorf("worker pool not running")
+			}
+			return nil
+		}},
+		{"maintenance", func() error {
+			if b.InMaintenance() {
+				return fmt.Errorf("broker is in maintenance")
+			}
+			return nil
+		}},
+	}
+}
+
+// Readiness runs every readiness check
+func (b *Broker) Readiness() ReadinessReport {
+	report := ReadinessReport{Ready: true, Checks: make(map[string]string)}
+	for _, check := range b.readinessChecks() {
+		if err := check.check(); err != nil {
+			report.Ready = false
+			report.Checks[check.name] = err.Error()
+		} else {
+			report.Checks[check.name] = "ok"
+		}
+	}
+	return report
+}
+
+// handleLivez reports that the broker process is serving requests. It stays
+// healthy during warm-up and maintenance so orchestrators do not restart it.
+func (b *Broker) handleLivez(w http.ResponseWriter, r *http.Request) {
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte("OK"))
+}
+
+// handleReadyz reports whether the broker should receive traffic
+func (b *Broker) handleReadyz(w http.ResponseWriter, r *http.Request) {
+	report := b.Readiness()
+	status := http.StatusOK
+	if !report.Ready {
+		status = http.StatusServiceUnavailable
+	}
+	writeJSON(w, status, report)
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestReadinessSeparateFromLiveness(t *testing.T) {
+	broker := NewBroker()
+
+	probe := func(path string) (int, ReadinessReport) {
+		recorder := httptest.NewRecorder()
+		broker.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, path, nil))
+		var report ReadinessReport
+		if path == "/readyz" {
+			json.NewDecoder(recorder.Body).Decode(&report)
+		}
+		return recorder.Code, report
+	}
+
+	if status, report := probe("/readyz"); status != http.StatusOK || !report.Ready {
+		t.Fatalf("A fresh broker should be ready, got %d %+v", status, report)
+	}
+
+	broker.warmup.update(func(s *WarmupStatus) { s.Phase = WarmupWarming; s.AgentsTotal = 3 })
+	broker.federation.federatedBrokers["peer"] = &FederatedBroker{ID: "peer", Status: BrokerStatusActive, TrustTier: PeerTrustDiscovery}
+	status, report := probe("/readyz")
+	if status != http.StatusServiceUnavailable || report.Ready {
+		t.Fatalf("A warming broker should not be ready, got %d %+v", status, report)
+	}
+	if report.Checks["registry"] == "ok" || report.Checks["federation"] == "ok" || report.Checks["workers"] != "ok" {
+		t.Errorf("Unexpected checks: %+v", report.Checks)
+	}
+	if status, _ := probe("/livez"); status != http.StatusOK {
+		t.Errorf("A warming broker should still be live, got %d", status)
+	}
+
+	broker.warmup.update(func(s *WarmupStatus) { s.Phase = WarmupReady })
+	broker.federation.peersSyncedAt = time.Now()
+	broker.EnterMaintenance()
+	if _, report := probe("/readyz"); report.Ready || report.Checks["maintenance"] == "ok" {
+		t.Errorf("A broker in maintenance should not be ready: %+v", report.Checks)
+	}
+	if status, _ := probe("/livez"); status != http.StatusOK {
+		t.Errorf("A broker in maintenance should still be live, got %d", status)
+	}
+}
@@ -0,0 +1,29 @@
+package main
+
+import "time"
+
+// maxRegistryGCInterval caps how long a stale entry can outlive its threshold
+const maxRegistryGCInterval = time.Minute
+
+// StartRegistryGC periodically removes registry tools and agents not seen within maxAge
+func (fm *FederationManager) StartRegistryGC(maxAge time.Duration) {
+	interval := maxAge / 4
+	if interval > maxRegistryGCInterval {
+		interval = maxRegistryGCInterval
+	}
+	if interval < time.Second {
+		interval = time.Second
+	}
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				fm.mcpRegistry.RemoveStale(maxAge)
+			}
+		}
+	}()
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/fep-fem/protocol"
+)
+
+func TestRegistryRemovesStaleToolsAndAgents(t *testing.T) {
+	registry := NewMCPRegistry()
+	registry.RegisterAgent("live", &MCPAgent{
+		ID:            "live",
+		Tools:         []protocol.MCPTool{{Name: "math.add"}},
+		LastHeartbeat: time.Now(),
+	})
+	registry.RegisterAgent("gone", &MCPAgent{
+		ID:            "gone",
+		Tools:         []protocol.MCPTool{{Name: "math.add"}, {Name: "math.mul"}},
+		LastHeartbeat: time.Now().Add(-time.Hour),
+	})
+	for _, tool := range registry.ListTools() {
+		if tool.AgentID == "gone" {
+			tool.LastSeen = time.Now().Add(-time.Hour)
+		}
+	}
+
+	var removedTools, removedAgents []string
+	registry.AddObserver(&RegistryObserverFuncs{
+		ToolsChanged: func(agentID string, added, removed []protocol.MCPTool) {
+			for _, tool := range removed {
+				removedTools = append(removedTools, agentID+"/"+tool.Name)
+			}
+		},
+		AgentRemoved: func(agentID string) { removedAgents = append(removedAgents, agentID) },
+	})
+
+	events := registry.RemoveStale(10 * time.Minute)
+	if len(events) != 3 {
+		t.Fatalf("Expected two tool and one agent event, got %+v", events)
+	}
+	if len(removedTools) != 2 || len(removedAgents) != 1 || removedAgents[0] != "gone" {
+		t.Errorf("Observers not notified of removals: tools %v, agents %v", removedTools, removedAgents)
+	}
+	if last := events[2]; last.Type != RegistryAgentExpired || last.AgentID != "gone" {
+		t.Errorf("Agent expiry should follow its tools, got %+v", last)
+	}
+	if registry.GetToolCount() != 1 || registry.GetAgentCount() != 1 {
+		t.Errorf("Expected only the live agent to remain, got %d tools and %d agents", registry.GetToolCount(), registry.GetAgentCount())
+	}
+}
+
+func TestRegistryDropsToolsNoLongerAdvertised(t *testing.T) {
+	registry := NewMCPRegistry()
+	registry.RegisterAgent("agent", &MCPAgent{
+		ID:            "agent",
+		Tools:         []protocol.MCPTool{{Name: "old.tool"}, {Name: "kept.tool"}},
+		LastHeartbeat: time.Now(),
+	})
+	for _, tool := range registry.ListTools() {
+		tool.LastSeen = time.Now().Add(-time.Hour)
+	}
+
+	// Re-registering refreshes only the tools still offered
+	registry.RegisterAgent("agent", &MCPAgent{
+		ID:            "agent",
+		Tools:         []protocol.MCPTool{{Name: "kept.tool"}},
+		LastHeartbeat: time.Now(),
+	})
+	events := registry.RemoveStale(10 * time.Minute)
+	if len(events) != 1 || events[0].ToolName != "old.tool" {
+		t.Fatalf("Expected only old.tool to expire, got %+v", events)
+	}
+	if _, exists := registry.GetAgent("agent"); !exists {
+		t.Error("Agent with fresh tools should be kept")
+	}
+}
+
+func TestFederationForgetsExpiredAgents(t *testing.T) {
+	registry := NewMCPRegistry()
+	fm := NewFederationManager(registry, nil)
+	registry.RegisterAgent("gone", &MCPAgent{
+		ID:            "gone",
+		Tools:         []protocol.MCPTool{{Name: "math.add", Description: "add numbers"}},
+		LastHeartbeat: time.Now().Add(-time.Hour),
+	})
+	fm.agentMetrics["gone"] = &AgentMetrics{AgentID: "gone"}
+	fm.Exclusions().Observe("gone", AgentStatusUnhealthy)
+	for _, tool := range registry.ListTools() {
+		tool.LastSeen = time.Now().Add(-time.Hour)
+	}
+
+	registry.RemoveStale(time.Minute)
+
+	if _, exists := fm.agentMetrics["gone"]; exists {
+		t.Error("Metrics of an expired agent should be removed")
+	}
+	if fm.Exclusions().IsExcluded("gone") {
+		t.Error("Expired agent should leave the exclusion list")
+	}
+	if _, exists := fm.semanticIndex.toolVectors["gone/math.add"]; exists {
+		t.Error("Expired tool should leave the semantic index")
+	}
+}
@@ -0,0 +1,93 @@
+package main
+
+import "github.com/fep-fem/protocol"
+
+// RegistryObserver is notified of changes to the MCP registry. Observers are
+// called synchronously after the registry lock is released, so they may query
+// the registry but should not block.
+type RegistryObserver interface {
+	// OnAgentRegistered is called when an agent registers or re-registers
+	OnAgentRegistered(agent *MCPAgent)
+	// OnAgentRemoved is called after an agent is unregistered or expires
+	OnAgentRemoved(agentID string)
+	// OnToolsChanged reports tools newly indexed for an agent and tools removed
+	// from the index. Tool removals are reported before the agent's removal.
+	OnToolsChanged(agentID string, added, removed []protocol.MCPTool)
+}
+
+// RegistryObserverFuncs adapts optional functions to the RegistryObserver interface
+type RegistryObserverFuncs struct {
+	AgentRegistered func(agent *MCPAgent)
+	AgentRemoved    func(agentID string)
+	ToolsChanged    func(agentID string, added, removed []protocol.MCPTool)
+}
+
+// OnAgentRegistered calls AgentRegistered if set
+func (f *RegistryObserverFuncs) OnAgentRegistered(agent *MCPAgent) {
+	if f.AgentRegistered != nil {
+		f.AgentRegistered(agent)
+	}
+}
+
+// OnAgentRemoved calls AgentRemoved if set
+func (f *RegistryObserverFuncs) OnAgentRemoved(agentID string) {
+	if f.AgentRemoved != nil {
+		f.AgentRemoved(agentID)
+	}
+}
+
+// OnToolsChanged calls ToolsChanged if set
+func (f *RegistryObserverFuncs) OnToolsChanged(agentID string, added, removed []protocol.MCPTool) {
+	if f.ToolsChanged != nil {
+		f.ToolsChanged(agentID, added, removed)
+	}
+}
+
+// AddObserver subscribes an observer to registry changes
+func (r *MCPRegistry) AddObserver(observer RegistryObserver) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.observers = append(r.observers, observer)
+}
+
+// RemoveObserver unsubscribes an observer
+func (r *MCPRegistry) RemoveObserver(observer RegistryObserver) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for i, o := range r.observers {
+		if o == observer {
+			r.observers = append(r.observers[:i], r.observers[i+1:]...)
+			return
+		}
+	}
+}
+
+// observersLocked snapshots the observers so they can be called without the lock
+func (r *MCPRegistry) observersLocked() []RegistryObserver {
+	return append([]RegistryObserver{}, r.observers...)
+}
+
+// registryObserver keeps federation state in step with the registry
+func (fm *FederationManager) registryObserver() RegistryObserver {
+	return &RegistryObserverFuncs{
+		ToolsChanged: func(agentID string, added, removed []protocol.MCPTool) {
+			if fm.semanticIndex == nil {
+				return
+			}
+			for _, tool := range added {
+				fm.semanticIndex.IndexTool(agentID, tool)
+			}
+			for _, tool := range removed {
+				fm.semanticIndex.RemoveTool(agentID, tool.Name)
+			}
+		},
+		AgentRemoved: func(agentID string) {
+			fm.metricsMutex.Lock()
+			delete(fm.agentMetrics, agentID)
+			fm.metricsMutex.Unlock()
+			fm.exclusions.Forget(agentID)
+			fm.loadBalancer.RestoreAgent(agentID)
+			fm.canaries.forgetAgent(agentID)
+		},
+	}
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/fep-fem/protocol"
+)
+
+func TestRegistryObserverNotifications(t *testing.T) {
+	registry := NewMCPRegistry()
+
+	var calls []string
+	observer := &RegistryObserverFuncs{
+		AgentRegistered: func(agent *MCPAgent) { calls = append(calls, "registered:"+agent.ID) },
+		AgentRemoved:    func(agentID string) { calls = append(calls, "removed:"+agentID) },
+		ToolsChanged: func(agentID string, added, removed []protocol.MCPTool) {
+			for _, tool := range added {
+				calls = append(calls, "+"+tool.Name)
+			}
+			for _, tool := range removed {
+				calls = append(calls, "-"+tool.Name)
+			}
+		},
+	}
+	registry.AddObserver(observer)
+
+	agent := &MCPAgent{ID: "agent", Tools: []protocol.MCPTool{{Name: "math.add"}}, LastHeartbeat: time.Now()}
+	registry.RegisterAgent("agent", agent)
+
+	// Re-registering unchanged tools reports no tool changes
+	registry.RegisterAgent("agent", agent)
+
+	// An updated definition is reported as changed
+	agent.Tools = []protocol.MCPTool{{Name: "math.add", Description: "Adds numbers"}}
+	registry.RegisterAgent("agent", agent)
+
+	registry.UnregisterAgent("agent")
+
+	want := []string{
+		"registered:agent", "+math.add",
+		"registered:agent",
+		"registered:agent", "+math.add",
+		"-math.add", "removed:agent",
+	}
+	if !reflect.DeepEqual(calls, want) {
+		t.Fatalf("Unexpected notifications:\n got %v\nwant %v", calls, want)
+	}
+
+	registry.RemoveObserver(observer)
+	registry.RegisterAgent("agent", agent)
+	if len(calls) != len(want) {
+		t.Errorf("Removed observer was still notified: %v", calls[len(want):])
+	}
+}
+
+func TestFederationIndexesRegisteredTools(t *testing.T) {
+	registry := NewMCPRegistry()
+	fm := NewFederationManager(registry, nil)
+
+	registry.RegisterAgent("agent", &MCPAgent{
+		ID:            "agent",
+		Tools:         []protocol.MCPTool{{Name: "math.add", Description: "add numbers"}},
+		LastHeartbeat: time.Now(),
+	})
+	if _, indexed := fm.semanticIndex.toolVectors["agent/math.add"]; !indexed {
+		t.Fatal("Registered tool should be added to the semantic index")
+	}
+
+	fm.agentMetrics["agent"] = &AgentMetrics{AgentID: "agent"}
+	registry.UnregisterAgent("agent")
+	if _, indexed := fm.semanticIndex.toolVectors["agent/math.add"]; indexed {
+		t.Error("Unregistered tool should leave the semantic index")
+	}
+	if _, exists := fm.agentMetrics["agent"]; exists {
+		t.Error("Metrics of an unregistered agent should be removed")
+	}
+}
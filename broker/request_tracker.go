@@ -0,0 +1,249 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/fep-fem/protocol"
+)
+
+// Defaults for tracking tool calls through their lifecycle
+const (
+	defaultOrphanTimeout    = 5 * time.Minute
+	defaultRequestRetention = 15 * time.Minute
+)
+
+// RequestState is where a tool call is in its lifecycle
+type RequestState string
+
+const (
+	RequestReceived         RequestState = "received"         // Accepted from the caller
+	RequestAwaitingApproval RequestState = "awaitingApproval" // Parked until an approver decides
+	RequestRouted           RequestState = "routed"           // Sent to an agent
+	RequestExecuting        RequestState = "executing"        // The agent reported progress
+	RequestCompleted        RequestState = "completed"        // Answered with a result
+	RequestFailed           RequestState = "failed"           // Answered with an error, or timed out
+)
+
+// Finished reports whether the call was answered
+func (s RequestState) Finished() bool {
+	return s == RequestCompleted || s == RequestFailed
+}
+
+// RequestTransition is a call entering a state
+type RequestTransition struct {
+	State  RequestState  `json:"state"`
+	At     protocol.Time `json:"at"`
+	Agent  string        `json:"agent,omitempty"`
+	Detail string        `json:"detail,omitempty"`
+}
+
+// TrackedRequest is a tool call's lifecycle, correlated by its request ID
+type TrackedRequest struct {
+	RequestID string              `json:"requestId"`
+	Caller    string              `json:"caller"`
+	Tool      string              `json:"tool"`
+	Agent     string              `json:"agent,omitempty"` // Agent the call was routed to, or that answered it
+	State     RequestState        `json:"state"`
+	Error     string              `json:"error,omitempty"`
+	History   []RequestTransition `json:"history"`
+	updated   time.Time
+}
+
+// RequestTracker follows tool calls from the caller through routing and
+// execution to their result. Calls making no progress for the orphan timeout
+// are failed; answered calls stay queryable for the retention period.
+type RequestTracker struct {
+	mu            sync.Mutex
+	requests      map[string]*TrackedRequest // By request ID
+	orphanTimeout time.Duration
+	retention     time.Duration
+	now           func() time.Time
+}
+
+// NewRequestTracker creates a tracker with the default orphan timeout and retention
+func NewRequestTracker() *RequestTracker {
+	return &RequestTracker{
+		requests:      make(map[string]*TrackedRequest),
+		orphanTimeout: defaultOrphanTimeout,
+		retention:     defaultRequestRetention,
+		now:           time.Now,
+	}
+}
+
+// Configure sets how long a call may go without progress before it is failed,
+// and how long answered calls are kept
+func (t *RequestTracker) Configure(orphanTimeout, retention time.Duration) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.orphanTimeout = orphanTimeout
+	t.retention = retention
+}
+
+// Receive starts tracking a call accepted from its caller
+func (t *RequestTracker) Receive(requestID, caller, tool string) {
+	if requestID == "" {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	request := &TrackedRequest{RequestID: requestID, Caller: caller, Tool: tool}
+	t.requests[requestID] = request
+	t.transitionLocked(request, RequestReceived, "", "")
+}
+
+// Advance moves a call to a state. Answered calls stay answered, and calls
+// already in the state are left as they are.
+func (t *RequestTracker) Advance(requestID string, state RequestState, agent, detail string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	request, exists := t.requests[requestID]
+	if !exists || request.State.Finished() || request.State == state {
+		return
+	}
+	t.transitionLocked(request, state, agent, detail)
+}
+
+// Finish records a call's answer: a result, or an error if errMessage is set
+func (t *RequestTracker) Finish(requestID, agent, errMessage string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	request, exists := t.requests[requestID]
+	if !exists || request.State.Finished() {
+		return
+	}
+	state := RequestCompleted
+	if errMessage != "" {
+		state = RequestFailed
+		request.Error = errMessage
+	}
+	t.transitionLocked(request, state, agent, errMessage)
+}
+
+func (t *RequestTracker) transitionLocked(request *TrackedRequest, state RequestState, agent, detail string) {
+	now := t.now()
+	request.State = state
+	request.updated = now
+	if agent != "" {
+		request.Agent = agent
+	}
+	request.History = append(request.History, RequestTransition{State: state, At: protocol.Time(now), Agent: agent, Detail: detail})
+}
+
+// Get returns a tracked call
+func (t *RequestTracker) Get(requestID string) (TrackedRequest, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	request, exists := t.requests[requestID]
+	if !exists {
+		return TrackedRequest{}, false
+	}
+	return request.snapshot(), true
+}
+
+// List returns the tracked calls in a state, or all of them if state is
+// empty, oldest first
+func (t *RequestTracker) List(state RequestState) []TrackedRequest {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	requests := []TrackedRequest{}
+	for _, request := range t.requests {
+		if state == "" || request.State == state {
+			requests = append(requests, request.snapshot())
+		}
+	}
+	sort.Slice(requests, func(i, j int) bool {
+		return requests[i].History[0].At.Std().Before(requests[j].History[0].At.Std())
+	})
+	return requests
+}
+
+func (r *TrackedRequest) snapshot() TrackedRequest {
+	snapshot := *r
+	snapshot.History = append([]RequestTransition(nil), r.History...)
+	return snapshot
+}
+
+// Orphans returns the calls that made no progress within the orphan timeout
+// and drops answered calls older than the retention period. Calls awaiting
+// approval are left to the approval timeout.
+func (t *RequestTracker) Orphans() []string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	now := t.now()
+	var orphans []string
+	for requestID, request := range t.requests {
+		idle := now.Sub(request.updated)
+		switch {
+		case request.State.Finished():
+			if idle > t.retention {
+				delete(t.requests, requestID)
+			}
+		case request.State == RequestAwaitingApproval:
+		case t.orphanTimeout > 0 && idle > t.orphanTimeout:
+			orphans = append(orphans, requestID)
+		}
+	}
+	sort.Strings(orphans)
+	return orphans
+}
+
+// StartOrphanSweep fails calls left without progress, checking every quarter
+// of the orphan timeout
+func (b *Broker) StartOrphanSweep(orphanTimeout time.Duration) {
+	interval := orphanTimeout / 4
+	if interval < time.Second {
+		interval = time.Second
+	}
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				b.sweepOrphans()
+			}
+		}
+	}()
+}
+
+// sweepOrphans answers the calls no agent made progress on for the caller,
+// with an error, and returns how many there were
+func (b *Broker) sweepOrphans() int {
+	orphans := b.requests.Orphans()
+	for _, requestID := range orphans {
+		request, _ := b.requests.Get(requestID)
+		if request.Agent != "" {
+			b.federation.Reservations().Release(requestID, request.Agent)
+		}
+		reason := fmt.Sprintf("request orphaned: no progress since %s", request.State)
+		log.Printf("Tool call %s to %s from %s timed out while %s", requestID, request.Tool, request.Caller, request.State)
+		b.answerForAgent(requestID, reason)
+	}
+	return len(orphans)
+}
+
+// handleAdminRequests lists tracked calls, filtered by a state parameter, or
+// returns the one named by an id parameter
+func (b *Broker) handleAdminRequests(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if requestID := r.URL.Query().Get("id"); requestID != "" {
+		request, exists := b.requests.Get(requestID)
+		if !exists {
+			http.Error(w, fmt.Sprintf("No request %s is tracked", requestID), http.StatusNotFound)
+			return
+		}
+		writeJSON(w, http.StatusOK, request)
+		return
+	}
+	writeJSON(w, http.StatusOK, b.requests.List(RequestState(r.URL.Query().Get("state"))))
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/fep-fem/protocol"
+)
+
+func TestRequestTrackerLifecycle(t *testing.T) {
+	tracker := NewRequestTracker()
+	tracker.Receive("r1", "caller", "math.add")
+	tracker.Advance("r1", RequestRouted, "worker", "pushed over its channel")
+	tracker.Advance("r1", RequestExecuting, "worker", "")
+	tracker.Advance("r1", RequestExecuting, "worker", "")
+	tracker.Finish("r1", "worker", "division by zero")
+	// Answered calls stay answered
+	tracker.Advance("r1", RequestExecuting, "worker", "")
+	tracker.Finish("r1", "worker", "")
+
+	request, exists := tracker.Get("r1")
+	if !exists || request.State != RequestFailed || request.Agent != "worker" || request.Error != "division by zero" {
+		t.Fatalf("Unexpected request %+v", request)
+	}
+	var states []RequestState
+	for _, transition := range request.History {
+		states = append(states, transition.State)
+	}
+	if len(states) != 4 || states[0] != RequestReceived || states[1] != RequestRouted || states[2] != RequestExecuting || states[3] != RequestFailed {
+		t.Errorf("Unexpected history %v", states)
+	}
+
+	tracker.Receive("r2", "caller", "math.add")
+	if failed := tracker.List(RequestFailed); len(failed) != 1 || failed[0].RequestID != "r1" {
+		t.Errorf("Expected only r1 failed, got %+v", failed)
+	}
+	if all := tracker.List(""); len(all) != 2 || all[0].RequestID != "r1" {
+		t.Errorf("Expected both requests oldest first, got %+v", all)
+	}
+	// Calls never received are not tracked
+	tracker.Advance("unknown", RequestRouted, "worker", "")
+	if _, exists := tracker.Get("unknown"); exists {
+		t.Error("Expected unknown requests to be ignored")
+	}
+}
+
+func TestRequestTrackerOrphans(t *testing.T) {
+	clock := time.Now()
+	tracker := NewRequestTracker()
+	tracker.now = func() time.Time { return clock }
+	tracker.Configure(time.Minute, time.Hour)
+
+	tracker.Receive("stuck", "caller", "math.add")
+	tracker.Receive("parked", "caller", "db.drop")
+	tracker.Advance("parked", RequestAwaitingApproval, "", "")
+	tracker.Receive("done", "caller", "math.add")
+	tracker.Finish("done", "worker", "")
+	tracker.Receive("busy", "caller", "math.add")
+
+	clock = clock.Add(50 * time.Second)
+	tracker.Advance("busy", RequestExecuting, "worker", "")
+	clock = clock.Add(20 * time.Second)
+
+	if orphans := tracker.Orphans(); len(orphans) != 1 || orphans[0] != "stuck" {
+		t.Errorf("Expected only the call without progress orphaned, got %v", orphans)
+	}
+	clock = clock.Add(2 * time.Hour)
+	tracker.Orphans()
+	if _, exists := tracker.Get("done"); exists {
+		t.Error("Expected answered calls dropped after the retention period")
+	}
+}
+
+func TestToolCallsAreTracked(t *testing.T) {
+	broker := NewBroker()
+	callWith(t, broker, "tracked-1", protocol.ResultDelivery{Mode: protocol.ResultDeliveryPoll})
+	if request, _ := broker.requests.Get("tracked-1"); request.State != RequestReceived || request.Caller != "caller" || request.Tool != "video.transcode" {
+		t.Fatalf("Expected the call received, got %+v", request)
+	}
+
+	registerTranscoder(broker)
+	chunk, _ := protocol.NewToolResultChunk("transcoder", "tracked-1", 1).Data("stdout", "frame 1").SignWith(transcoderKey)
+	sendForAck(t, broker, chunk)
+	if request, _ := broker.requests.Get("tracked-1"); request.State != RequestExecuting || request.Agent != "transcoder" {
+		t.Errorf("Expected a streamed chunk to show the call executing, got %+v", request)
+	}
+	answer(t, broker, "tracked-1")
+	if request, _ := broker.requests.Get("tracked-1"); request.State != RequestCompleted {
+		t.Errorf("Expected the call completed, got %+v", request)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/admin/requests?id=tracked-1", nil)
+	req.Header.Set("Authorization", "Bearer secret")
+	broker.SetAdminToken("secret")
+	recorder := httptest.NewRecorder()
+	broker.ServeHTTP(recorder, req)
+	var status TrackedRequest
+	if err := json.NewDecoder(recorder.Body).Decode(&status); err != nil || status.State != RequestCompleted || len(status.History) != 3 {
+		t.Errorf("Unexpected status (%d): %+v %v", recorder.Code, status, err)
+	}
+}
+
+func TestOrphanedCallsAreAnswered(t *testing.T) {
+	broker := NewBroker()
+	clock := time.Now()
+	broker.requests.now = func() time.Time { return clock }
+	callWith(t, broker, "orphan-1", protocol.ResultDelivery{Mode: protocol.ResultDeliveryPoll})
+
+	if swept := broker.sweepOrphans(); swept != 0 {
+		t.Fatalf("Expected no orphans yet, got %d", swept)
+	}
+	clock = clock.Add(defaultOrphanTimeout + time.Second)
+	if swept := broker.sweepOrphans(); swept != 1 {
+		t.Fatalf("Expected the call orphaned, got %d", swept)
+	}
+
+	request, _ := broker.requests.Get("orphan-1")
+	if request.State != RequestFailed || !strings.Contains(request.Error, "orphaned") {
+		t.Errorf("Expected the call failed as orphaned, got %+v", request)
+	}
+	result, exists := broker.results.Take("caller", "orphan-1")
+	if !exists {
+		t.Fatal("Expected the caller answered with an error")
+	}
+	var envelope protocol.ToolResultEnvelope
+	if err := json.Unmarshal(result.Envelope, &envelope); err != nil || !strings.Contains(envelope.Body.Error, "orphaned") {
+		t.Errorf("Unexpected answer %s: %v", result.Envelope, err)
+	}
+}
@@ -0,0 +1,322 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fep-fem/protocol"
+)
+
+// ResourcesConstraint names the constraint cited in admission rejections
+const ResourcesConstraint = "resources"
+
+// reservationTTL bounds how long a reservation outlives a call that is never answered
+const reservationTTL = 10 * time.Minute
+
+// AdmissionRefusal is returned when no agent offering a tool has the free
+// capacity one call of it needs
+type AdmissionRefusal struct {
+	Tool    string
+	Refused map[string]string // Why each candidate agent cannot take the call
+}
+
+func (e *AdmissionRefusal) Error() string {
+	reasons := make([]string, 0, len(e.Refused))
+	for agentID, reason := range e.Refused {
+		reasons = append(reasons, agentID+": "+reason)
+	}
+	sort.Strings(reasons)
+	return fmt.Sprintf("no agent for %s has the resources free (%s)", e.Tool, strings.Join(reasons, "; "))
+}
+
+// ErrorBody converts the refusal into the retryable error sent to the caller
+func (e *AdmissionRefusal) ErrorBody() *protocol.ErrorBody {
+	return &protocol.ErrorBody{
+		Code:    protocol.CodeOverloaded,
+		Message: e.Error(),
+		Details: map[string]interface{}{
+			"constraint": ResourcesConstraint,
+			"tool":       e.Tool,
+			"refused":    e.Refused,
+		},
+	}
+}
+
+// Reservation holds resources on an agent for a call it was admitted for
+type Reservation struct {
+	RequestID string             `json:"requestId"`
+	AgentID   string             `json:"agentId"`
+	Tool      string             `json:"tool"`
+	Resources protocol.Resources `json:"resources"`
+	Since     time.Time          `json:"since"`
+}
+
+// ResourceLedger tracks resources reserved for admitted calls. Agents report
+// free capacity in heartbeats, so only reservations made since an agent's
+// last report are taken off it; a reservation is released when the call is
+// answered, or dropped after reservationTTL.
+type ResourceLedger struct {
+	reservations map[string]*Reservation // By request ID
+	mu           sync.Mutex
+}
+
+// NewResourceLedger creates a ledger with nothing reserved
+func NewResourceLedger() *ResourceLedger {
+	return &ResourceLedger{reservations: make(map[string]*Reservation)}
+}
+
+// Reserve holds resources on an agent for a call, replacing any earlier
+// reservation for the same request
+func (l *ResourceLedger) Reserve(reservation Reservation) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.pruneLocked(time.Now())
+	l.reservations[reservation.RequestID] = &reservation
+}
+
+// Release frees the resources reserved for a call answered by agentID
+func (l *ResourceLedger) Release(requestID, agentID string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if reservation, exists := l.reservations[requestID]; exists && reservation.AgentID == agentID {
+		delete(l.reservations, requestID)
+	}
+}
+
+// ReservedSince sums the resources reserved on an agent after since
+func (l *ResourceLedger) ReservedSince(agentID string, since time.Time) protocol.Resources {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	var total protocol.Resources
+	for _, reservation := range l.reservations {
+		if reservation.AgentID == agentID && reservation.Since.After(since) {
+			total = addResources(total, reservation.Resources)
+		}
+	}
+	return total
+}
+
+// Reservations lists held reservations, oldest first
+func (l *ResourceLedger) Reservations() []Reservation {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.pruneLocked(time.Now())
+	reservations := make([]Reservation, 0, len(l.reservations))
+	for _, reservation := range l.reservations {
+		reservations = append(reservations, *reservation)
+	}
+	sort.Slice(reservations, func(i, j int) bool { return reservations[i].Since.Before(reservations[j].Since) })
+	return reservations
+}
+
+// pruneLocked drops reservations older than reservationTTL
+func (l *ResourceLedger) pruneLocked(now time.Time) {
+	for requestID, reservation := range l.reservations {
+		if now.Sub(reservation.Since) > reservationTTL {
+			delete(l.reservations, requestID)
+		}
+	}
+}
+
+// addResources returns the sum of two amounts. GPU memory is per GPU, so the
+// larger requirement stands.
+func addResources(a, b protocol.Resources) protocol.Resources {
+	return protocol.Resources{
+		CPU:         a.CPU + b.CPU,
+		MemoryMB:    a.MemoryMB + b.MemoryMB,
+		GPUs:        a.GPUs + b.GPUs,
+		GPUMemoryMB: max(a.GPUMemoryMB, b.GPUMemoryMB),
+	}
+}
+
+// shortfall describes what free lacks to cover need, or returns "" if it is enough
+func shortfall(need, free protocol.Resources) string {
+	switch {
+	case need.GPUs > free.GPUs:
+		return fmt.Sprintf("needs %d GPUs, %d free", need.GPUs, free.GPUs)
+	case need.GPUs > 0 && need.GPUMemoryMB > free.GPUMemoryMB:
+		return fmt.Sprintf("needs %d MB of GPU memory, %d MB free", need.GPUMemoryMB, free.GPUMemoryMB)
+	case need.MemoryMB > free.MemoryMB:
+		return fmt.Sprintf("needs %d MB of memory, %d MB free", need.MemoryMB, free.MemoryMB)
+	case need.CPU > free.CPU:
+		return fmt.Sprintf("needs %g CPUs, %g free", need.CPU, free.CPU)
+	}
+	return ""
+}
+
+// subtractResources takes reserved off free, stopping at zero
+func subtractResources(free, reserved protocol.Resources) protocol.Resources {
+	return protocol.Resources{
+		CPU:         max(free.CPU-reserved.CPU, 0),
+		MemoryMB:    max(free.MemoryMB-reserved.MemoryMB, 0),
+		GPUs:        max(free.GPUs-reserved.GPUs, 0),
+		GPUMemoryMB: free.GPUMemoryMB,
+	}
+}
+
+// toolResources returns the resources one call of a local agent's tool needs,
+// or nil if the tool declares none
+func (fm *FederationManager) toolResources(agentID, toolName string) *protocol.Resources {
+	agent, exists := fm.mcpRegistry.GetAgent(agentID)
+	if !exists {
+		return nil
+	}
+	for _, tool := range agent.Tools {
+		if tool.Name == toolName {
+			return tool.Resources
+		}
+	}
+	return nil
+}
+
+// needsGPUs reports whether any local provider's tool declares GPUs per call
+func (fm *FederationManager) needsGPUs(toolName string) bool {
+	for _, tool := range fm.mcpRegistry.ListTools() {
+		if tool.Tool.Name == toolName && tool.Tool.Resources != nil && tool.Tool.Resources.GPUs > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// admit checks whether an agent can take one call needing need, returning
+// why not if it cannot
+func (fm *FederationManager) admit(agentID string, need protocol.Resources) string {
+	reason := "reports no free capacity"
+	for _, report := range fm.mcpRegistry.capacityReports(agentID) {
+		if report.available == nil {
+			continue
+		}
+		free := subtractResources(*report.available, fm.reservations.ReservedSince(agentID, report.at))
+		if reason = shortfall(need, free); reason == "" {
+			return ""
+		}
+	}
+	return reason
+}
+
+// capacityReport is the free capacity reported in a heartbeat
+type capacityReport struct {
+	available *protocol.Resources
+	at        time.Time
+}
+
+// capacityReports returns the latest capacity reported by an agent, or by
+// each of its instances that is not draining
+func (r *MCPRegistry) capacityReports(agentID string) []capacityReport {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	agent, exists := r.agents[agentID]
+	if !exists {
+		return nil
+	}
+	if len(agent.Instances) == 0 {
+		return []capacityReport{{agent.LastStatus.Available, agent.LastHeartbeat}}
+	}
+	reports := make([]capacityReport, 0, len(agent.Instances))
+	for _, instance := range agent.Instances {
+		if !instance.Draining() {
+			reports = append(reports, capacityReport{instance.LastStatus.Available, instance.LastHeartbeat})
+		}
+	}
+	return reports
+}
+
+// filterCapacity keeps the agents with room for one call of a tool. Tools
+// that declare no resources, and agents hosted by peers, are admitted as is;
+// a peer broker admits calls to its own agents.
+func (fm *FederationManager) filterCapacity(agents []string, toolName string) ([]string, *AdmissionRefusal) {
+	if len(agents) == 0 {
+		return agents, nil
+	}
+	allowed := make([]string, 0, len(agents))
+	refused := make(map[string]string)
+	for _, agentID := range agents {
+		need := fm.toolResources(agentID, toolName)
+		if need == nil {
+			allowed = append(allowed, agentID)
+			continue
+		}
+		if reason := fm.admit(agentID, *need); reason != "" {
+			refused[agentID] = reason
+			continue
+		}
+		allowed = append(allowed, agentID)
+	}
+	if len(allowed) == 0 {
+		return nil, &AdmissionRefusal{Tool: toolName, Refused: refused}
+	}
+	return allowed, nil
+}
+
+// mergeAdmissionRefusals combines the agents refused in two refusals, either of which may be nil
+func mergeAdmissionRefusals(refusal, more *AdmissionRefusal) *AdmissionRefusal {
+	if refusal == nil {
+		return more
+	}
+	if more != nil {
+		for agentID, reason := range more.Refused {
+			refusal.Refused[agentID] = reason
+		}
+	}
+	return refusal
+}
+
+// CheckAdmission fails with an *AdmissionRefusal if a tool is offered but no
+// provider has the resources free for a call
+func (fm *FederationManager) CheckAdmission(toolName string) error {
+	if _, refusal := fm.filterCapacity(fm.toolProviders(toolName), toolName); refusal != nil {
+		return refusal
+	}
+	return nil
+}
+
+// reserveResources holds the resources of a routed call on the selected agent
+func (fm *FederationManager) reserveResources(toolName, agentID string, context *RequestContext) {
+	if context == nil || context.RequestID == "" {
+		return
+	}
+	if need := fm.toolResources(agentID, toolName); need != nil {
+		fm.reservations.Reserve(Reservation{
+			RequestID: context.RequestID,
+			AgentID:   agentID,
+			Tool:      toolName,
+			Resources: *need,
+			Since:     time.Now(),
+		})
+	}
+}
+
+// Reservations returns the ledger of resources held for admitted calls
+func (fm *FederationManager) Reservations() *ResourceLedger {
+	return fm.reservations
+}
+
+// admitToolCall rejects a call no provider has the resources for. It reports
+// whether the call may proceed.
+func (b *Broker) admitToolCall(w http.ResponseWriter, env *protocol.GenericEnvelope, tool string) bool {
+	var refusal *AdmissionRefusal
+	if err := b.federation.CheckAdmission(tool); errors.As(err, &refusal) {
+		errBody := refusal.ErrorBody()
+		rejection := b.newError(env, errBody.Code, errBody.Message)
+		rejection.Body.Details = errBody.Details
+		writeError(w, rejection)
+		return false
+	}
+	return true
+}
+
+// handleAdminReservations lists resources held for admitted calls
+func (b *Broker) handleAdminReservations(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	writeJSON(w, http.StatusOK, b.federation.Reservations().Reservations())
+}
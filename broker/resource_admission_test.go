@@ -0,0 +1,134 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/fep-fem/protocol"
+)
+
+// gpuFederation has two agents offering a tool that needs a GPU; only one
+// reports a GPU free
+func gpuFederation() (*MCPRegistry, *FederationManager) {
+	registry := NewMCPRegistry()
+	fm := NewFederationManager(registry, nil)
+	need := &protocol.Resources{GPUs: 1, GPUMemoryMB: 16000, MemoryMB: 4096}
+	for agentID, free := range map[string]*protocol.Resources{
+		"gpu-agent": {CPU: 8, MemoryMB: 32768, GPUs: 1, GPUMemoryMB: 24000},
+		"cpu-agent": {CPU: 32, MemoryMB: 65536},
+	} {
+		registry.RegisterAgent(agentID, &MCPAgent{
+			ID:    agentID,
+			Tools: []protocol.MCPTool{{Name: "vision.segment", Resources: need}, {Name: "text.count"}},
+		})
+		registry.RecordHeartbeat(agentID, protocol.HeartbeatBody{Available: free})
+		fm.agentMetrics[agentID] = &AgentMetrics{AgentID: agentID, HealthScore: 0.9}
+	}
+	return registry, fm
+}
+
+func TestRoutingAdmitsOnlyAgentsWithResources(t *testing.T) {
+	registry, fm := gpuFederation()
+
+	decision, err := fm.RouteToolInvocation("vision.segment", "", &RequestContext{RequestID: "req-1"})
+	if err != nil || decision.SelectedAgent != "gpu-agent" || len(decision.AlternativeAgents) != 1 {
+		t.Fatalf("Only gpu-agent has a GPU free, got %+v %v", decision, err)
+	}
+	if reservations := fm.Reservations().Reservations(); len(reservations) != 1 || reservations[0].AgentID != "gpu-agent" {
+		t.Fatalf("Expected the call's resources reserved on gpu-agent, got %+v", reservations)
+	}
+
+	// The reserved GPU is not offered again until the agent reports or answers
+	_, err = fm.RouteToolInvocation("vision.segment", "", &RequestContext{RequestID: "req-2"})
+	var refusal *AdmissionRefusal
+	if !errors.As(err, &refusal) {
+		t.Fatalf("Expected an admission refusal, got %v", err)
+	}
+	if refusal.Refused["gpu-agent"] != "needs 1 GPUs, 0 free" || refusal.Refused["cpu-agent"] != "needs 1 GPUs, 0 free" {
+		t.Errorf("Refusal should cite each agent: %v", refusal.Refused)
+	}
+
+	fm.Reservations().Release("req-1", "gpu-agent")
+	if decision, err := fm.RouteToolInvocation("vision.segment", "", &RequestContext{RequestID: "req-2"}); err != nil || decision.SelectedAgent != "gpu-agent" {
+		t.Errorf("Released GPU should be offered again, got %+v %v", decision, err)
+	}
+
+	// A later report already counts the call it was reserved for
+	time.Sleep(time.Millisecond)
+	registry.RecordHeartbeat("gpu-agent", protocol.HeartbeatBody{Available: &protocol.Resources{GPUs: 1, GPUMemoryMB: 24000, MemoryMB: 8192}})
+	if _, err := fm.RouteToolInvocation("vision.segment", "", &RequestContext{}); err != nil {
+		t.Errorf("Reported GPU should be offered, got %v", err)
+	}
+
+	// Tools that declare no resources are routed as before
+	if decision, err := fm.RouteToolInvocation("text.count", "", &RequestContext{}); err != nil || len(decision.AlternativeAgents) != 2 {
+		t.Errorf("Both agents should take text.count, got %+v %v", decision, err)
+	}
+}
+
+func TestToolCallRefusedWithoutResources(t *testing.T) {
+	broker := NewBroker()
+	broker.mcpRegistry, broker.federation = gpuFederation()
+
+	call := func(tool string) *httptest.ResponseRecorder {
+		envelope, err := protocol.NewToolCall("client").Tool(tool).Build()
+		if err != nil {
+			t.Fatalf("Failed to build call: %v", err)
+		}
+		data, _ := json.Marshal(envelope)
+		recorder := httptest.NewRecorder()
+		broker.ServeHTTP(recorder, httptest.NewRequest(http.MethodPost, "/", bytes.NewReader(data)))
+		return recorder
+	}
+
+	if resp := call("vision.segment"); resp.Code != http.StatusOK {
+		t.Fatalf("Call with a GPU free should be accepted, got %d %s", resp.Code, resp.Body.String())
+	}
+
+	broker.mcpRegistry.RecordHeartbeat("gpu-agent", protocol.HeartbeatBody{Available: &protocol.Resources{CPU: 8}})
+	resp := call("vision.segment")
+	_, err := protocol.ParseResponse(resp.Body.Bytes())
+	var errBody *protocol.ErrorBody
+	if !errors.As(err, &errBody) || errBody.Code != protocol.CodeOverloaded {
+		t.Fatalf("Expected an overloaded error, got %d %v", resp.Code, err)
+	}
+	if errBody.Details["constraint"] != ResourcesConstraint {
+		t.Errorf("Rejection should cite the constraint: %v", errBody.Details)
+	}
+}
+
+func TestGPUJobsPackedByUtilization(t *testing.T) {
+	registry := NewMCPRegistry()
+	fm := NewFederationManager(registry, nil)
+	for agentID, utilization := range map[string]float64{"idle": 0, "busy": 0.6, "full": 0.95} {
+		registry.RegisterAgent(agentID, &MCPAgent{
+			ID:    agentID,
+			Tools: []protocol.MCPTool{{Name: "model.train", Resources: &protocol.Resources{GPUs: 1}}, {Name: "text.count"}},
+		})
+		status := protocol.HeartbeatBody{Available: &protocol.Resources{GPUs: 1}, GPUUtilization: utilization}
+		registry.RecordHeartbeat(agentID, status)
+		fm.agentMetrics[agentID] = &AgentMetrics{AgentID: agentID, HealthScore: 0.9}
+		fm.recordHeartbeat(agentID, status)
+	}
+
+	decision, err := fm.RouteToolInvocation("model.train", "", &RequestContext{})
+	if err != nil || decision.SelectedAgent != "busy" || decision.LoadBalanceMode != LoadBalanceGPUBinPack {
+		t.Fatalf("GPU job should be packed onto the busiest agent with room, got %+v %v", decision, err)
+	}
+
+	// Once every agent is past the ceiling, the least busy takes the job
+	fm.recordHeartbeat("busy", protocol.HeartbeatBody{GPUUtilization: 0.97})
+	fm.recordHeartbeat("idle", protocol.HeartbeatBody{GPUUtilization: 0.92})
+	if decision, err := fm.RouteToolInvocation("model.train", "", &RequestContext{}); err != nil || decision.SelectedAgent != "idle" {
+		t.Errorf("Expected the least busy full agent, got %+v %v", decision, err)
+	}
+
+	if decision, err := fm.RouteToolInvocation("text.count", "", &RequestContext{}); err != nil || decision.LoadBalanceMode == LoadBalanceGPUBinPack {
+		t.Errorf("Tools without GPUs keep the default mode, got %+v %v", decision, err)
+	}
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/fep-fem/protocol"
+)
+
+// writeAck answers an envelope with an ack carrying its outcome and any result data
+func (b *Broker) writeAck(w http.ResponseWriter, env *protocol.GenericEnvelope, status string, result interface{}) {
+	ack := protocol.NewAck(b.federation.config.LocalBrokerID, env.Nonce, status, result)
+	// Answer older clients in the version they speak
+	if proto, err := b.negotiateVersion(env); err == nil {
+		ack.Proto = proto.String()
+	}
+	b.writeEnvelope(w, http.StatusOK, env.Agent, ack)
+}
+
+// newError builds an error envelope rejecting env. env is nil when the request
+// could not be parsed as an envelope.
+func (b *Broker) newError(env *protocol.GenericEnvelope, code protocol.ErrorCode, message string) *protocol.ErrorEnvelope {
+	ref := ""
+	if env != nil {
+		ref = env.Nonce
+	}
+	return protocol.NewError(b.federation.config.LocalBrokerID, ref, code, message)
+}
+
+// writeError sends an error envelope with the HTTP status for its code
+func writeError(w http.ResponseWriter, rejection *protocol.ErrorEnvelope) {
+	writeJSON(w, rejection.Body.Code.HTTPStatus(), rejection)
+}
+
+// reject answers an envelope with an error envelope
+func (b *Broker) reject(w http.ResponseWriter, env *protocol.GenericEnvelope, code protocol.ErrorCode, message string) {
+	writeError(w, b.newError(env, code, message))
+}
+
+// rejectMalformed answers an envelope that failed to parse. Schema violations
+// are listed per field; they are invalid_body when confined to the body.
+func (b *Broker) rejectMalformed(w http.ResponseWriter, err error) {
+	var schemaErr *protocol.SchemaError
+	if !errors.As(err, &schemaErr) {
+		b.reject(w, nil, protocol.CodeInvalidEnvelope, fmt.Sprintf("Invalid envelope: %v", err))
+		return
+	}
+	code := protocol.CodeInvalidBody
+	for _, violation := range schemaErr.Violations {
+		if !strings.HasPrefix(violation.Field, "body.") {
+			code = protocol.CodeInvalidEnvelope
+			break
+		}
+	}
+	rejection := b.newError(nil, code, fmt.Sprintf("Invalid envelope: %v", err))
+	rejection.Body.Details = schemaErr.Details()
+	writeError(w, rejection)
+}
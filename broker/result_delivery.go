@@ -0,0 +1,454 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fep-fem/protocol"
+)
+
+// Defaults for holding tool results until their callers collect them
+const (
+	defaultRedeliveryDelay = 30 * time.Second
+	defaultResultTTL       = time.Hour
+)
+
+// pendingCall is a tool call whose result has not arrived yet
+type pendingCall struct {
+	caller     string
+	delivery   protocol.DeliveryGuarantee
+	created    time.Time
+	chunks     map[uint64]bool // Streamed chunks received so far
+	finalChunk uint64          // Seq of the final chunk once it arrives
+}
+
+// heldResult is a tool result waiting for its caller
+type heldResult struct {
+	requestID   string
+	chunk       uint64 // Seq of a streamed chunk; 0 for a complete result
+	delivery    protocol.DeliveryGuarantee
+	envelope    json.RawMessage
+	attempts    int
+	lastAttempt time.Time
+	stored      time.Time
+}
+
+// ResultOutbox holds tool results for their callers. At-most-once results are
+// dropped once handed out; at-least-once results are handed out again after
+// the redelivery delay until the caller acknowledges them. Calls and results
+// older than the TTL are discarded.
+type ResultOutbox struct {
+	mu              sync.Mutex
+	calls           map[string]*pendingCall  // By request ID
+	held            map[string][]*heldResult // By caller, in arrival order
+	arrivals        map[string]chan struct{} // By caller; closed when a result is next stored
+	redeliveryDelay time.Duration
+	ttl             time.Duration
+}
+
+// NewResultOutbox creates an outbox with the default redelivery delay and TTL
+func NewResultOutbox() *ResultOutbox {
+	return &ResultOutbox{
+		calls:           make(map[string]*pendingCall),
+		held:            make(map[string][]*heldResult),
+		arrivals:        make(map[string]chan struct{}),
+		redeliveryDelay: defaultRedeliveryDelay,
+		ttl:             defaultResultTTL,
+	}
+}
+
+// Configure sets how long an unacknowledged result waits before redelivery and
+// how long calls and results are kept
+func (o *ResultOutbox) Configure(redeliveryDelay, ttl time.Duration) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.redeliveryDelay = redeliveryDelay
+	o.ttl = ttl
+}
+
+// ExpectResult records that caller awaits the result of requestID. It fails
+// if another caller already awaits a result under the same request ID.
+func (o *ResultOutbox) ExpectResult(requestID, caller string, delivery protocol.DeliveryGuarantee) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.expireLocked(time.Now())
+
+	if call, exists := o.calls[requestID]; exists && call.caller != caller {
+		return fmt.Errorf("request ID %s is already in use", requestID)
+	}
+	o.calls[requestID] = &pendingCall{caller: caller, delivery: delivery.OrDefault(), created: time.Now()}
+	return nil
+}
+
+// StoreResult holds a toolResult envelope for the caller awaiting requestID,
+// reporting whether any caller was waiting for it. Duplicate results for a
+// call already answered are dropped.
+func (o *ResultOutbox) StoreResult(requestID string, envelope json.RawMessage) bool {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	now := time.Now()
+	o.expireLocked(now)
+
+	call, exists := o.calls[requestID]
+	if !exists {
+		return false
+	}
+	delete(o.calls, requestID)
+	o.held[call.caller] = append(o.held[call.caller], &heldResult{
+		requestID: requestID,
+		delivery:  call.delivery,
+		envelope:  envelope,
+		stored:    now,
+	})
+	o.signalArrivalLocked(call.caller)
+	return true
+}
+
+// StoreChunk holds a toolResultChunk envelope for the caller awaiting
+// requestID, reporting whether it was kept. Chunks are handed out in seq
+// order; duplicates and chunks after the final one are dropped. The call is
+// complete once the final chunk and every chunk before it have arrived.
+func (o *ResultOutbox) StoreChunk(requestID string, seq uint64, final bool, envelope json.RawMessage) bool {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	now := time.Now()
+	o.expireLocked(now)
+
+	call, exists := o.calls[requestID]
+	if !exists || call.chunks[seq] || (call.finalChunk != 0 && seq > call.finalChunk) {
+		return false
+	}
+	if final {
+		for received := range call.chunks {
+			if received > seq {
+				// Chunks numbered past the final one already arrived
+				return false
+			}
+		}
+		call.finalChunk = seq
+	}
+	if call.chunks == nil {
+		call.chunks = make(map[uint64]bool)
+	}
+	call.chunks[seq] = true
+	if call.finalChunk != 0 && uint64(len(call.chunks)) == call.finalChunk {
+		delete(o.calls, requestID)
+	}
+
+	held := append(o.held[call.caller], &heldResult{
+		requestID: requestID,
+		chunk:     seq,
+		delivery:  call.delivery,
+		envelope:  envelope,
+		stored:    now,
+	})
+	// Move the chunk ahead of later chunks of the same stream that overtook it
+	for i := len(held) - 1; i > 0; i-- {
+		previous := held[i-1]
+		if previous.requestID != requestID || previous.chunk < seq {
+			break
+		}
+		held[i-1], held[i] = held[i], previous
+	}
+	o.held[call.caller] = held
+	o.signalArrivalLocked(call.caller)
+	return true
+}
+
+// Arrival returns a channel closed once a result or chunk is next stored for
+// caller, so callers can wait for results instead of polling
+func (o *ResultOutbox) Arrival(caller string) <-chan struct{} {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	arrival, exists := o.arrivals[caller]
+	if !exists {
+		arrival = make(chan struct{})
+		o.arrivals[caller] = arrival
+	}
+	return arrival
+}
+
+// signalArrivalLocked wakes those waiting for caller's results
+func (o *ResultOutbox) signalArrivalLocked(caller string) {
+	if arrival, exists := o.arrivals[caller]; exists {
+		close(arrival)
+		delete(o.arrivals, caller)
+	}
+}
+
+// Take removes the complete result of requestID held for caller and returns
+// it, for results handed over other than by collection
+func (o *ResultOutbox) Take(caller, requestID string) (protocol.DeliveredResult, bool) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	held := o.held[caller]
+	for i, result := range held {
+		if result.requestID == requestID && result.chunk == 0 {
+			o.held[caller] = append(held[:i:i], held[i+1:]...)
+			if len(o.held[caller]) == 0 {
+				delete(o.held, caller)
+			}
+			return protocol.DeliveredResult{
+				RequestID: requestID,
+				Delivery:  result.delivery,
+				Attempt:   result.attempts + 1,
+				Envelope:  result.envelope,
+			}, true
+		}
+	}
+	return protocol.DeliveredResult{}, false
+}
+
+// Acknowledge drops the caller's results and chunks with the given delivery keys
+func (o *ResultOutbox) Acknowledge(caller string, keys ...string) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	acked := make(map[string]bool, len(keys))
+	for _, key := range keys {
+		acked[key] = true
+	}
+	var kept []*heldResult
+	for _, result := range o.held[caller] {
+		if !acked[protocol.ResultKey(result.requestID, result.chunk)] {
+			kept = append(kept, result)
+		}
+	}
+	if len(kept) == 0 {
+		delete(o.held, caller)
+	} else {
+		o.held[caller] = kept
+	}
+}
+
+// Collect drops the caller's acknowledged results and returns those due for
+// delivery, up to max (0 for all)
+func (o *ResultOutbox) Collect(caller string, acknowledged []string, max int) []protocol.DeliveredResult {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	now := time.Now()
+	o.expireLocked(now)
+
+	acked := make(map[string]bool, len(acknowledged))
+	for _, id := range acknowledged {
+		acked[id] = true
+	}
+
+	delivered := []protocol.DeliveredResult{}
+	var kept []*heldResult
+	for _, result := range o.held[caller] {
+		if acked[protocol.ResultKey(result.requestID, result.chunk)] {
+			continue
+		}
+		due := result.attempts == 0 || now.Sub(result.lastAttempt) >= o.redeliveryDelay
+		if !due || (max > 0 && len(delivered) >= max) {
+			kept = append(kept, result)
+			continue
+		}
+
+		result.attempts++
+		result.lastAttempt = now
+		delivered = append(delivered, protocol.DeliveredResult{
+			RequestID: result.requestID,
+			Chunk:     result.chunk,
+			Delivery:  result.delivery,
+			Attempt:   result.attempts,
+			Envelope:  result.envelope,
+		})
+		if result.delivery == protocol.DeliverAtLeastOnce {
+			kept = append(kept, result)
+		}
+	}
+
+	if len(kept) == 0 {
+		delete(o.held, caller)
+	} else {
+		o.held[caller] = kept
+	}
+	return delivered
+}
+
+// Caller returns the agent awaiting the result of requestID
+func (o *ResultOutbox) Caller(requestID string) (string, bool) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	call, exists := o.calls[requestID]
+	if !exists {
+		return "", false
+	}
+	return call.caller, true
+}
+
+// Pending reports how many calls await results and how many results await callers
+func (o *ResultOutbox) Pending() (calls, results int) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.expireLocked(time.Now())
+	for _, held := range o.held {
+		results += len(held)
+	}
+	return len(o.calls), results
+}
+
+// expireLocked discards calls and results older than the TTL
+func (o *ResultOutbox) expireLocked(now time.Time) {
+	if o.ttl <= 0 {
+		return
+	}
+	cutoff := now.Add(-o.ttl)
+	for id, call := range o.calls {
+		if call.created.Before(cutoff) {
+			delete(o.calls, id)
+		}
+	}
+	for caller, held := range o.held {
+		var kept []*heldResult
+		for _, result := range held {
+			if !result.stored.Before(cutoff) {
+				kept = append(kept, result)
+			}
+		}
+		if len(kept) == 0 {
+			delete(o.held, caller)
+		} else {
+			o.held[caller] = kept
+		}
+	}
+}
+
+// authenticateAgent checks the envelope is signed by the registered agent it names
+func (b *Broker) authenticateAgent(w http.ResponseWriter, r *http.Request, env *protocol.GenericEnvelope) bool {
+	b.mu.RLock()
+	agent, known := b.agents[env.Agent]
+	b.mu.RUnlock()
+
+	if !known {
+		b.reject(w, env, protocol.CodeUnknownAgent, fmt.Sprintf("Agent %s is not registered", env.Agent))
+		return false
+	}
+	if err := b.verifySender(r, agent, env); err != nil {
+		b.recordSignatureFailure(env.Agent, requestSource(r))
+		b.reject(w, env, protocol.CodeInvalidSignature, fmt.Sprintf("Invalid signature for agent %s: %v", env.Agent, err))
+		return false
+	}
+	return true
+}
+
+// checkResultSender refuses results and result chunks unless a registered
+// agent signed them and, for a call, is the agent the call was routed to. A
+// call not routed to any agent may be answered by an agent offering its tool.
+// On failure it writes the error envelope itself; results for another agent's
+// call also raise a security event.
+func (b *Broker) checkResultSender(w http.ResponseWriter, r *http.Request, env *protocol.GenericEnvelope, requestID string) bool {
+	if !b.authenticateAgent(w, r, env) {
+		return false
+	}
+	if requestID == "" {
+		return true
+	}
+
+	tracked, known := b.requests.Get(requestID)
+	switch {
+	case !known:
+	case tracked.Agent != "":
+		if tracked.Agent == env.Agent {
+			return true
+		}
+	case tracked.State == RequestReceived:
+		if b.offersTool(env.Agent, tracked.Tool) {
+			return true
+		}
+	}
+	detail := fmt.Sprintf("sent a result for call %s, which was not routed to it", requestID)
+	log.Printf("Agent %s %s", env.Agent, detail)
+	b.securityEvents.Raise(SecurityEvent{
+		Type:    SecurityEventForeignResult,
+		AgentID: env.Agent,
+		Detail:  detail,
+		Source:  requestSource(r),
+	})
+	b.reject(w, env, protocol.CodeForbidden, fmt.Sprintf("Call %s was not routed to %s", requestID, env.Agent))
+	return false
+}
+
+// offersTool reports whether an agent provides a tool. A tool qualified as
+// agent/tool is only provided by the agent it names.
+func (b *Broker) offersTool(agentID, tool string) bool {
+	if named, _, qualified := strings.Cut(tool, "/"); qualified {
+		return named == agentID
+	}
+	for _, registered := range b.mcpRegistry.ListTools() {
+		if registered.AgentID == agentID && registered.Tool.Name == tool {
+			return true
+		}
+	}
+	return false
+}
+
+// handleToolResultChunk holds a piece of a streamed result for the caller,
+// so long-running tools can report output before they finish
+func (b *Broker) handleToolResultChunk(w http.ResponseWriter, r *http.Request, env *protocol.GenericEnvelope) {
+	typed, err := protocol.ParseTyped[protocol.ToolResultChunkBody](env)
+	if err != nil {
+		b.rejectInvalidBody(w, env, err)
+		return
+	}
+	body := typed.Body
+	if !b.checkResultSender(w, r, env, body.RequestID) {
+		return
+	}
+
+	raw, err := json.Marshal(env)
+	if err != nil {
+		b.reject(w, env, protocol.CodeInternal, "Failed to store result chunk")
+		return
+	}
+	held := b.results.StoreChunk(body.RequestID, body.Seq, body.Final, raw)
+	if held {
+		b.pushResult(body.RequestID, body.Seq, body.Final, raw)
+	}
+	if body.Final {
+		b.deadlines.finish(body.RequestID)
+		b.requests.Finish(body.RequestID, env.Agent, body.Error)
+	} else {
+		b.deadlines.recordChunk(body.RequestID, body.Seq, body.Data)
+		b.requests.Advance(body.RequestID, RequestExecuting, env.Agent, "")
+	}
+	if body.Final {
+		log.Printf("Final result chunk %d for %s from %s", body.Seq, body.RequestID, env.Agent)
+	}
+
+	b.writeAck(w, env, "received", map[string]interface{}{
+		"requestId": body.RequestID,
+		"seq":       body.Seq,
+		"held":      held,
+	})
+}
+
+// handleResultAck drops the results a caller acknowledges and answers with
+// the results due for delivery, long-polling for up to the body's wait if
+// none is due. Only the caller may collect its results, so the envelope must
+// be signed by a registered agent.
+func (b *Broker) handleResultAck(w http.ResponseWriter, r *http.Request, env *protocol.GenericEnvelope) {
+	typed, err := protocol.ParseTyped[protocol.ResultAckBody](env)
+	if err != nil {
+		b.rejectInvalidBody(w, env, err)
+		return
+	}
+	if !b.authenticateAgent(w, r, env) {
+		return
+	}
+
+	// Long polls wait once the envelope's worker is released
+	afterRelease(w, func() {
+		results := b.collectResults(r, env.Agent, typed.Body)
+		if len(results) > 0 {
+			log.Printf("Delivering %d results to %s", len(results), env.Agent)
+		}
+		b.writeAck(w, env, "delivered", protocol.ResultDeliveryBody{Results: results})
+	})
+}
@@ -0,0 +1,298 @@
+package main
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/fep-fem/protocol"
+)
+
+// registerProvider registers an agent offering tools under a fresh key and
+// returns the key it signs its results with
+func registerProvider(broker *Broker, agentID string, tools ...string) ed25519.PrivateKey {
+	pubKey, privKey, _ := protocol.GenerateKeyPair()
+	registerDiscoveryClient(broker, agentID, pubKey)
+	if len(tools) > 0 {
+		offered := make([]protocol.MCPTool, 0, len(tools))
+		for _, tool := range tools {
+			offered = append(offered, protocol.MCPTool{Name: tool})
+		}
+		broker.mcpRegistry.RegisterAgent(agentID, &MCPAgent{ID: agentID, Tools: offered})
+	}
+	return privKey
+}
+
+func TestResultOutboxDeliveryGuarantees(t *testing.T) {
+	outbox := NewResultOutbox()
+	outbox.Configure(time.Hour, time.Hour)
+
+	outbox.ExpectResult("once", "caller", protocol.DeliverAtMostOnce)
+	outbox.ExpectResult("reliable", "caller", protocol.DeliverAtLeastOnce)
+	if err := outbox.ExpectResult("reliable", "intruder", protocol.DeliverAtMostOnce); err == nil {
+		t.Error("Another caller should not take over a pending request ID")
+	}
+	if outbox.StoreResult("unknown", json.RawMessage(`{}`)) {
+		t.Error("Results nobody is waiting for should not be held")
+	}
+	outbox.StoreResult("once", json.RawMessage(`{"n":1}`))
+	outbox.StoreResult("reliable", json.RawMessage(`{"n":2}`))
+	if outbox.StoreResult("reliable", json.RawMessage(`{"n":3}`)) {
+		t.Error("A second result for an answered call should be dropped")
+	}
+
+	if results := outbox.Collect("intruder", nil, 0); len(results) != 0 {
+		t.Errorf("Results should only reach their caller, got %+v", results)
+	}
+	first := outbox.Collect("caller", nil, 0)
+	if len(first) != 2 || first[0].RequestID != "once" || first[1].Attempt != 1 {
+		t.Fatalf("Expected both results in arrival order, got %+v", first)
+	}
+
+	// Nothing is due until the redelivery delay passes
+	if results := outbox.Collect("caller", nil, 0); len(results) != 0 {
+		t.Errorf("Results should not be redelivered early, got %+v", results)
+	}
+	outbox.Configure(0, time.Hour)
+	again := outbox.Collect("caller", nil, 0)
+	if len(again) != 1 || again[0].RequestID != "reliable" || again[0].Attempt != 2 {
+		t.Fatalf("Only the at-least-once result should be redelivered, got %+v", again)
+	}
+
+	if results := outbox.Collect("caller", []string{"reliable"}, 0); len(results) != 0 {
+		t.Errorf("Acknowledged results should not be redelivered, got %+v", results)
+	}
+	if calls, results := outbox.Pending(); calls != 0 || results != 0 {
+		t.Errorf("Outbox should be empty, got %d calls and %d results", calls, results)
+	}
+}
+
+func TestResultOutboxExpiry(t *testing.T) {
+	outbox := NewResultOutbox()
+	outbox.Configure(0, time.Hour)
+	outbox.ExpectResult("old", "caller", protocol.DeliverAtLeastOnce)
+	outbox.StoreResult("old", json.RawMessage(`{}`))
+	outbox.ExpectResult("unanswered", "caller", protocol.DeliverAtLeastOnce)
+
+	outbox.Configure(0, time.Nanosecond)
+	time.Sleep(time.Millisecond)
+	if calls, results := outbox.Pending(); calls != 0 || results != 0 {
+		t.Errorf("Expired calls and results should be dropped, got %d and %d", calls, results)
+	}
+}
+
+func TestResultOutboxStreamsChunksInOrder(t *testing.T) {
+	outbox := NewResultOutbox()
+	outbox.Configure(0, time.Hour)
+	outbox.ExpectResult("build", "caller", protocol.DeliverAtLeastOnce)
+
+	outbox.StoreChunk("build", 1, false, json.RawMessage(`{"n":1}`))
+	outbox.StoreChunk("build", 3, true, json.RawMessage(`{"n":3}`))
+	outbox.StoreChunk("build", 2, false, json.RawMessage(`{"n":2}`))
+	if outbox.StoreChunk("build", 2, false, json.RawMessage(`{}`)) {
+		t.Error("A duplicate chunk should be dropped")
+	}
+	if outbox.StoreChunk("build", 4, false, json.RawMessage(`{}`)) {
+		t.Error("Chunks after the final one should be dropped")
+	}
+	if calls, _ := outbox.Pending(); calls != 0 {
+		t.Error("The call should be complete once every chunk up to the final one arrived")
+	}
+
+	chunks := outbox.Collect("caller", nil, 0)
+	if len(chunks) != 3 {
+		t.Fatalf("Expected 3 chunks, got %+v", chunks)
+	}
+	for i, chunk := range chunks {
+		if chunk.Chunk != uint64(i+1) {
+			t.Errorf("Chunks should be delivered in seq order, got %d at %d", chunk.Chunk, i)
+		}
+	}
+
+	// Chunks are acknowledged individually by their delivery key
+	redelivered := outbox.Collect("caller", []string{"build#1", "build#3"}, 0)
+	if len(redelivered) != 1 || redelivered[0].Key() != "build#2" {
+		t.Errorf("Only the unacknowledged chunk should be redelivered, got %+v", redelivered)
+	}
+}
+
+func TestFinalChunkWaitsForMissingChunks(t *testing.T) {
+	outbox := NewResultOutbox()
+	outbox.ExpectResult("build", "caller", protocol.DeliverAtMostOnce)
+
+	outbox.StoreChunk("build", 2, true, json.RawMessage(`{}`))
+	if calls, _ := outbox.Pending(); calls != 1 {
+		t.Fatal("The call should stay open until chunk 1 arrives")
+	}
+	if !outbox.StoreChunk("build", 1, false, json.RawMessage(`{}`)) {
+		t.Error("A late chunk before the final one should be held")
+	}
+	if calls, results := outbox.Pending(); calls != 0 || results != 2 {
+		t.Errorf("Expected a complete stream of 2 chunks, got %d calls and %d results", calls, results)
+	}
+}
+
+func TestMCPClientCollectsResultsExactlyOnce(t *testing.T) {
+	broker := NewBroker()
+	broker.results.Configure(0, time.Hour)
+	server := httptest.NewTLSServer(broker)
+	defer server.Close()
+
+	pubKey, privKey, _ := protocol.GenerateKeyPair()
+	registerDiscoveryClient(broker, "caller", pubKey)
+	client := NewMCPClient(MCPClientConfig{
+		AgentID:        "caller",
+		BrokerURL:      server.URL,
+		PrivateKey:     privKey,
+		TLSInsecure:    true,
+		ResultDelivery: protocol.DeliverAtLeastOnce,
+	})
+
+	agentKey := registerProvider(broker, "math-agent", "add")
+	response, err := client.CallTool("math-agent", "add", map[string]interface{}{"a": 2, "b": 3})
+	if err != nil {
+		t.Fatalf("Tool call failed: %v", err)
+	}
+	var call map[string]string
+	response.(*protocol.AckBody).ResultAs(&call)
+
+	// The answering agent reports its result
+	result, err := protocol.NewToolResult("math-agent", call["requestId"]).Result(5).SignWith(agentKey)
+	if err != nil {
+		t.Fatalf("Failed to build result: %v", err)
+	}
+	data, _ := json.Marshal(result)
+	recorder := httptest.NewRecorder()
+	broker.ServeHTTP(recorder, httptest.NewRequest(http.MethodPost, "/", bytes.NewReader(data)))
+	var stored struct {
+		Held bool `json:"held"`
+	}
+	if err := readAck(t, recorder.Body).ResultAs(&stored); err != nil || !stored.Held {
+		t.Fatalf("Result should be held for the caller, got %+v %v", stored, err)
+	}
+
+	results, err := client.CollectResults(0)
+	if err != nil || len(results) != 1 || results[0].RequestID != call["requestId"] {
+		t.Fatalf("Expected the result, got %+v %v", results, err)
+	}
+	var answer struct {
+		Body struct {
+			Result int `json:"result"`
+		} `json:"body"`
+	}
+	json.Unmarshal(results[0].Envelope, &answer)
+	if answer.Body.Result != 5 {
+		t.Errorf("Delivered envelope should carry the agent's result, got %s", results[0].Envelope)
+	}
+
+	// Simulate a lost acknowledgement: the broker redelivers and the client drops the duplicate
+	client.pendingAcks = nil
+	if results, err := client.CollectResults(0); err != nil || len(results) != 0 {
+		t.Errorf("Redelivered result should be deduplicated, got %+v %v", results, err)
+	}
+	client.CollectResults(0)
+	if _, held := broker.results.Pending(); held != 0 {
+		t.Errorf("Acknowledged result should be released, %d still held", held)
+	}
+
+	// Unregistered callers cannot collect results
+	stranger := NewMCPClient(MCPClientConfig{AgentID: "stranger", BrokerURL: server.URL, PrivateKey: privKey, TLSInsecure: true})
+	if _, err := stranger.CollectResults(0); err == nil {
+		t.Error("Unregistered caller should be refused")
+	}
+}
+
+func TestBrokerHoldsStreamedChunks(t *testing.T) {
+	broker := NewBroker()
+	broker.results.ExpectResult("build-7", "caller", protocol.DeliverAtMostOnce)
+	broker.requests.Receive("build-7", "caller", "build.run")
+	broker.requests.Advance("build-7", RequestRouted, "builder", "")
+	key := registerProvider(broker, "builder")
+
+	send := func(builder *protocol.ToolResultChunkBuilder) bool {
+		chunk, err := builder.SignWith(key)
+		if err != nil {
+			t.Fatalf("Failed to build chunk: %v", err)
+		}
+		data, _ := json.Marshal(chunk)
+		recorder := httptest.NewRecorder()
+		broker.ServeHTTP(recorder, httptest.NewRequest(http.MethodPost, "/", bytes.NewReader(data)))
+		var stored struct {
+			Held bool `json:"held"`
+		}
+		if err := readAck(t, recorder.Body).ResultAs(&stored); err != nil {
+			t.Fatalf("Chunk was not acknowledged: %v", err)
+		}
+		return stored.Held
+	}
+
+	if !send(protocol.NewToolResultChunk("builder", "build-7", 1).Data("stdout", "compiling\n")) {
+		t.Error("First chunk should be held for the caller")
+	}
+	if !send(protocol.NewToolResultChunk("builder", "build-7", 2).Final(map[string]int{"exitCode": 0})) {
+		t.Error("Final chunk should be held for the caller")
+	}
+	if send(protocol.NewToolResultChunk("builder", "build-7", 3).Data("stdout", "late")) {
+		t.Error("Chunks after the stream ended should not be held")
+	}
+
+	chunks := broker.results.Collect("caller", nil, 0)
+	if len(chunks) != 2 {
+		t.Fatalf("Expected 2 chunks, got %+v", chunks)
+	}
+	first, err := protocol.Parse[protocol.ToolResultChunkBody](chunks[0].Envelope)
+	if err != nil || first.Body.Data != "compiling\n" {
+		t.Errorf("Delivered chunk should carry the agent's output, got %+v %v", first, err)
+	}
+}
+
+func TestResultsOnlyFromRoutedAgent(t *testing.T) {
+	broker := NewBroker()
+	workerKey := registerProvider(broker, "worker", "build.run")
+	otherKey := registerProvider(broker, "other", "build.run")
+	intruderKey := registerProvider(broker, "intruder")
+	call, _ := protocol.NewToolCall("caller").Tool("build.run").RequestID("build-1").Build()
+	sendForAck(t, broker, call)
+
+	post := func(envelope interface{}) int {
+		data, _ := json.Marshal(envelope)
+		recorder := httptest.NewRecorder()
+		broker.ServeHTTP(recorder, httptest.NewRequest(http.MethodPost, "/", bytes.NewReader(data)))
+		return recorder.Code
+	}
+
+	unsigned, _ := protocol.NewToolResult("worker", "build-1").Result("forged").Build()
+	if status := post(unsigned); status != http.StatusUnauthorized {
+		t.Errorf("Unsigned results should be refused, got %d", status)
+	}
+	foreign, _ := protocol.NewToolResult("intruder", "build-1").Result("forged").SignWith(intruderKey)
+	if status := post(foreign); status != http.StatusForbidden {
+		t.Errorf("Results from agents not offering the tool should be refused, got %d", status)
+	}
+	events := broker.securityEvents.Recent()
+	if len(events) != 1 || events[0].Type != SecurityEventForeignResult || events[0].AgentID != "intruder" {
+		t.Errorf("Expected a foreign result event, got %+v", events)
+	}
+
+	// Once the call is routed, only the agent it went to may answer
+	broker.requests.Advance("build-1", RequestRouted, "worker", "")
+	other, _ := protocol.NewToolResult("other", "build-1").Result("forged").SignWith(otherKey)
+	if status := post(other); status != http.StatusForbidden {
+		t.Errorf("Results from another provider should be refused, got %d", status)
+	}
+	if _, held := broker.results.Pending(); held != 0 {
+		t.Fatalf("Refused results should not be held, got %d", held)
+	}
+
+	result, _ := protocol.NewToolResult("worker", "build-1").Result("ok").SignWith(workerKey)
+	if status := post(result); status != http.StatusOK {
+		t.Fatalf("The routed agent's result should be accepted, got %d", status)
+	}
+	if env, body := takeResult(t, broker, "caller", "build-1"); env.Agent != "worker" || body.Result != "ok" {
+		t.Errorf("Expected the worker's result held, got %s %+v", env.Agent, body)
+	}
+}
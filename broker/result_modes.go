@@ -0,0 +1,251 @@
+package main
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/fep-fem/protocol"
+)
+
+// Limits on holding acks while results arrive
+const (
+	maxSyncWait   = 2 * time.Minute // For the ack of a sync toolCall
+	maxResultWait = time.Minute     // For a long-polling resultAck
+)
+
+// Webhook delivery of results
+const (
+	webhookTimeout    = 10 * time.Second
+	webhookAttempts   = 5           // For at-least-once results; at-most-once results are posted once
+	webhookRetryDelay = time.Second // Doubled after each failed attempt
+)
+
+// pushRoute is where the results of a call are pushed instead of waiting to
+// be collected
+type pushRoute struct {
+	caller    string
+	delivery  protocol.ResultDelivery
+	guarantee protocol.DeliveryGuarantee
+	created   time.Time
+}
+
+// resultPusher remembers the calls whose results go to a webhook or an event
+// topic until their final result arrives
+type resultPusher struct {
+	routes map[string]pushRoute // By request ID
+	client *http.Client
+	mu     sync.Mutex
+}
+
+func newResultPusher() *resultPusher {
+	return &resultPusher{
+		routes: make(map[string]pushRoute),
+		client: &http.Client{Timeout: webhookTimeout},
+	}
+}
+
+// expect records where the results of requestID are pushed
+func (p *resultPusher) expect(requestID, caller string, delivery protocol.ResultDelivery, guarantee protocol.DeliveryGuarantee) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	now := time.Now()
+	for id, route := range p.routes {
+		if now.Sub(route.created) > defaultResultTTL {
+			delete(p.routes, id)
+		}
+	}
+	p.routes[requestID] = pushRoute{caller: caller, delivery: delivery, guarantee: guarantee.OrDefault(), created: now}
+}
+
+// route returns where a result of requestID is pushed, forgetting the call
+// once its final result arrives
+func (p *resultPusher) route(requestID string, final bool) (pushRoute, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	route, exists := p.routes[requestID]
+	if exists && final {
+		delete(p.routes, requestID)
+	}
+	return route, exists
+}
+
+// checkResultDelivery refuses calls whose results could not be pushed where
+// asked: publishing to a topic needs the caller's right to publish there. It
+// reports whether the call may proceed.
+func (b *Broker) checkResultDelivery(w http.ResponseWriter, env *protocol.GenericEnvelope, delivery *protocol.ResultDelivery) bool {
+	if delivery.ModeOrDefault() != protocol.ResultDeliveryEvent {
+		return true
+	}
+	if err := b.events.authorize(publishPermissionPrefix, env.Agent, delivery.Capability, delivery.Topic); err != nil {
+		b.reject(w, env, protocol.CodeCapabilityDenied, err.Error())
+		return false
+	}
+	return true
+}
+
+// awaitResult holds the ack of a sync call until its result arrives and
+// answers with it. A result not in within the timeout stays held for the
+// caller to collect, and the call is acknowledged as processing. The wait
+// starts once the call's worker is released.
+func (b *Broker) awaitResult(w http.ResponseWriter, r *http.Request, env *protocol.GenericEnvelope, body protocol.ToolCallBody) {
+	wait := maxSyncWait
+	if timeout := body.ResultDelivery.Timeout; timeout > 0 {
+		wait = min(time.Duration(timeout)*time.Second, maxSyncWait)
+	}
+	afterRelease(w, func() {
+		if result, done := b.waitForResult(r, env.Agent, body.RequestID, wait); done {
+			b.writeAck(w, env, "completed", result)
+			return
+		}
+		b.writeAck(w, env, "processing", map[string]interface{}{
+			"tool":      body.Tool,
+			"requestId": body.RequestID,
+		})
+	})
+}
+
+// waitForResult takes the complete result of requestID held for caller,
+// waiting up to wait for it to arrive. It reports false if the wait ran out
+// or the request was abandoned first.
+func (b *Broker) waitForResult(r *http.Request, caller, requestID string, wait time.Duration) (protocol.DeliveredResult, bool) {
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	for {
+		arrival := b.results.Arrival(caller)
+		if result, exists := b.results.Take(caller, requestID); exists {
+			return result, true
+		}
+		select {
+		case <-arrival:
+		case <-timer.C:
+			return protocol.DeliveredResult{}, false
+		case <-r.Context().Done():
+			return protocol.DeliveredResult{}, false
+		}
+	}
+}
+
+// collectResults hands the caller its due results. If none is due, it waits
+// up to the body's wait for one to arrive.
+func (b *Broker) collectResults(r *http.Request, caller string, body protocol.ResultAckBody) []protocol.DeliveredResult {
+	arrival := b.results.Arrival(caller)
+	results := b.results.Collect(caller, body.Acknowledged, body.MaxResults)
+	if len(results) > 0 || body.Wait <= 0 {
+		return results
+	}
+
+	timer := time.NewTimer(min(time.Duration(body.Wait)*time.Second, maxResultWait))
+	defer timer.Stop()
+	for len(results) == 0 {
+		select {
+		case <-arrival:
+		case <-timer.C:
+			return results
+		case <-r.Context().Done():
+			return results
+		}
+		arrival = b.results.Arrival(caller)
+		results = b.results.Collect(caller, nil, body.MaxResults)
+	}
+	return results
+}
+
+// pushResult sends a stored result or chunk where its caller asked for it.
+// Pushed results are dropped from the outbox; results that could not be
+// pushed stay held for the caller to collect.
+func (b *Broker) pushResult(requestID string, chunk uint64, final bool, envelope json.RawMessage) {
+	route, exists := b.pushes.route(requestID, final)
+	if !exists {
+		return
+	}
+	result := protocol.DeliveredResult{
+		RequestID: requestID,
+		Chunk:     chunk,
+		Delivery:  route.guarantee,
+		Attempt:   1,
+		Envelope:  envelope,
+	}
+
+	switch route.delivery.Mode {
+	case protocol.ResultDeliveryWebhook:
+		go func() {
+			if err := b.postWebhook(route, result); err != nil {
+				log.Printf("Webhook delivery of %s to %s failed, holding it for %s: %v", result.Key(), route.delivery.URL, route.caller, err)
+				return
+			}
+			b.results.Acknowledge(route.caller, result.Key())
+		}()
+	case protocol.ResultDeliveryEvent:
+		var payload map[string]interface{}
+		data, _ := json.Marshal(result)
+		json.Unmarshal(data, &payload)
+		b.events.publish(route.caller, route.delivery.Topic, payload)
+		b.results.Acknowledge(route.caller, result.Key())
+	}
+}
+
+// postWebhook posts a result to the caller's webhook, signed with the broker's
+// identity key. At-least-once results are retried with backoff.
+func (b *Broker) postWebhook(route pushRoute, result protocol.DeliveredResult) error {
+	attempts := 1
+	if route.guarantee == protocol.DeliverAtLeastOnce {
+		attempts = webhookAttempts
+	}
+	delay := webhookRetryDelay
+	var err error
+	for result.Attempt = 1; result.Attempt <= attempts; result.Attempt++ {
+		if result.Attempt > 1 {
+			time.Sleep(delay)
+			delay *= 2
+		}
+		if err = b.sendWebhook(route.delivery.URL, result); err == nil {
+			return nil
+		}
+	}
+	return err
+}
+
+// sendWebhook makes one webhook request
+func (b *Broker) sendWebhook(url string, result protocol.DeliveredResult) error {
+	payload, err := json.Marshal(result)
+	if err != nil {
+		return err
+	}
+	return b.postSigned(url, payload)
+}
+
+// postSigned posts a JSON payload signed as webhooks are
+func (b *Broker) postSigned(url string, payload []byte) error {
+	signer := b.IdentityKey()
+	ts := time.Now().UnixMilli()
+	signature, err := protocol.SignWebhook(signer, ts, payload)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(protocol.BrokerKeyHeader, protocol.EncodePublicKey(signer.Public().(ed25519.PublicKey)))
+	req.Header.Set(protocol.WebhookTimestampHeader, strconv.FormatInt(ts, 10))
+	req.Header.Set(protocol.WebhookSignatureHeader, signature)
+
+	resp, err := b.pushes.client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook answered %s", resp.Status)
+	}
+	return nil
+}
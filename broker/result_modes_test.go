@@ -0,0 +1,219 @@
+package main
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/fep-fem/protocol"
+)
+
+// sendForAck posts an envelope and returns the ack answering it
+func sendForAck(t *testing.T, broker *Broker, envelope interface{}) protocol.AckBody {
+	t.Helper()
+	data, _ := json.Marshal(envelope)
+	resp := httptest.NewRecorder()
+	broker.ServeHTTP(resp, httptest.NewRequest(http.MethodPost, "/", bytes.NewReader(data)))
+	if resp.Code != http.StatusOK {
+		t.Fatalf("Envelope should be accepted, got %d %s", resp.Code, resp.Body.String())
+	}
+	var ack protocol.AckEnvelope
+	json.Unmarshal(resp.Body.Bytes(), &ack)
+	return ack.Body
+}
+
+// callWith sends a toolCall asking for its result by delivery
+func callWith(t *testing.T, broker *Broker, requestID string, delivery protocol.ResultDelivery) protocol.AckBody {
+	t.Helper()
+	envelope, err := protocol.NewToolCall("caller").Tool("video.transcode").RequestID(requestID).ResultDelivery(delivery).Build()
+	if err != nil {
+		t.Fatalf("Failed to build call: %v", err)
+	}
+	return sendForAck(t, broker, envelope)
+}
+
+// transcoderKey signs for the transcoder agent, which answers video.transcode calls
+var transcoderKey = ed25519.NewKeyFromSeed(bytes.Repeat([]byte{7}, ed25519.SeedSize))
+
+// registerTranscoder registers the transcoder agent unless it already is
+func registerTranscoder(broker *Broker) {
+	broker.mu.RLock()
+	_, registered := broker.agents["transcoder"]
+	broker.mu.RUnlock()
+	if registered {
+		return
+	}
+	registerDiscoveryClient(broker, "transcoder", transcoderKey.Public().(ed25519.PublicKey))
+	broker.mcpRegistry.RegisterAgent("transcoder", &MCPAgent{ID: "transcoder", Tools: []protocol.MCPTool{{Name: "video.transcode"}}})
+}
+
+// answer sends the transcoder's toolResult of requestID
+func answer(t *testing.T, broker *Broker, requestID string) {
+	t.Helper()
+	registerTranscoder(broker)
+	envelope, _ := protocol.NewToolResult("transcoder", requestID).Result("done").SignWith(transcoderKey)
+	sendForAck(t, broker, envelope)
+}
+
+func TestSyncCallAnsweredWithResult(t *testing.T) {
+	broker := NewBroker()
+	acks := make(chan protocol.AckBody, 1)
+	go func() {
+		acks <- callWith(t, broker, "sync-1", protocol.ResultDelivery{Mode: protocol.ResultDeliverySync, Timeout: 5})
+	}()
+
+	// Answer once the call is waiting
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		if _, waiting := broker.results.Caller("sync-1"); waiting {
+			break
+		}
+	}
+	answer(t, broker, "sync-1")
+
+	ack := <-acks
+	var result protocol.DeliveredResult
+	data, _ := json.Marshal(ack.Result)
+	json.Unmarshal(data, &result)
+	if ack.Status != "completed" || result.RequestID != "sync-1" || len(result.Envelope) == 0 {
+		t.Fatalf("Expected the result in the ack, got %+v", ack)
+	}
+	if _, results := broker.results.Pending(); results != 0 {
+		t.Errorf("A result returned in the ack should not be held too, got %d", results)
+	}
+}
+
+func TestSyncCallTimesOutToPolling(t *testing.T) {
+	broker := NewBroker()
+	if ack := callWith(t, broker, "sync-2", protocol.ResultDelivery{Mode: protocol.ResultDeliverySync, Timeout: 1}); ack.Status != "processing" {
+		t.Fatalf("Expected the call acknowledged as processing, got %+v", ack)
+	}
+	answer(t, broker, "sync-2")
+	if results := broker.results.Collect("caller", nil, 0); len(results) != 1 {
+		t.Errorf("A late result should be held for collection, got %+v", results)
+	}
+}
+
+func TestSyncCallsDoNotHoldWorkers(t *testing.T) {
+	broker := NewBroker()
+	broker.SetWorkerPool(WorkerPoolConfig{Workers: 2, QueueSize: 2})
+	requestIDs := []string{"sync-3", "sync-4", "sync-5", "sync-6"}
+	acks := make(chan protocol.AckBody, len(requestIDs))
+	for _, requestID := range requestIDs {
+		go func(requestID string) {
+			acks <- callWith(t, broker, requestID, protocol.ResultDelivery{Mode: protocol.ResultDeliverySync, Timeout: 10})
+		}(requestID)
+	}
+
+	// More calls wait than there are workers, and none holds one
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		waiting := 0
+		for _, requestID := range requestIDs {
+			if _, exists := broker.results.Caller(requestID); exists {
+				waiting++
+			}
+		}
+		if waiting == len(requestIDs) && broker.envelopeWorkers().Stats()[0].Active == 0 {
+			break
+		}
+	}
+	for _, requestID := range requestIDs {
+		answer(t, broker, requestID)
+	}
+
+	for range requestIDs {
+		if ack := <-acks; ack.Status != "completed" {
+			t.Errorf("Expected every waiting call answered with its result, got %+v", ack)
+		}
+	}
+}
+
+func TestResultAckLongPolls(t *testing.T) {
+	broker := NewBroker()
+	pubKey, privKey, _ := protocol.GenerateKeyPair()
+	registerDiscoveryClient(broker, "caller", pubKey)
+	callWith(t, broker, "poll-1", protocol.ResultDelivery{Mode: protocol.ResultDeliveryPoll})
+
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		answer(t, broker, "poll-1")
+	}()
+	envelope := protocol.NewTypedEnvelope("caller", protocol.ResultAckBody{Wait: 5})
+	envelope.Sign(privKey)
+	started := time.Now()
+	ack := sendForAck(t, broker, envelope)
+
+	var delivery protocol.ResultDeliveryBody
+	data, _ := json.Marshal(ack.Result)
+	json.Unmarshal(data, &delivery)
+	if len(delivery.Results) != 1 || delivery.Results[0].RequestID != "poll-1" {
+		t.Fatalf("The held ack should carry the result once it arrives, got %+v", delivery)
+	}
+	if waited := time.Since(started); waited > 4*time.Second {
+		t.Errorf("The ack should be answered as the result arrives, waited %s", waited)
+	}
+}
+
+func TestWebhookReceivesSignedResult(t *testing.T) {
+	broker := NewBroker()
+	brokerKey := broker.IdentityKey().Public().(ed25519.PublicKey)
+	received := make(chan protocol.DeliveredResult, 1)
+	hook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		payload, _ := io.ReadAll(r.Body)
+		if err := protocol.VerifyWebhook(r.Header, payload, brokerKey, time.Minute); err != nil {
+			t.Errorf("Webhook signature should verify: %v", err)
+		}
+		var result protocol.DeliveredResult
+		json.Unmarshal(payload, &result)
+		received <- result
+	}))
+	defer hook.Close()
+
+	ack := callWith(t, broker, "hook-1", protocol.ResultDelivery{Mode: protocol.ResultDeliveryWebhook, URL: hook.URL})
+	if ack.Status != "processing" {
+		t.Fatalf("Expected the call acknowledged at once, got %+v", ack)
+	}
+	answer(t, broker, "hook-1")
+
+	select {
+	case result := <-received:
+		if result.RequestID != "hook-1" || result.Attempt != 1 {
+			t.Errorf("Unexpected webhook payload %+v", result)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Webhook was not called")
+	}
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		if _, results := broker.results.Pending(); results == 0 {
+			return
+		}
+	}
+	t.Error("A delivered webhook result should no longer be held")
+}
+
+func TestResultPublishedToTopic(t *testing.T) {
+	broker := NewBroker()
+	events := make(chan TopicEvent, 1)
+	broker.events.Subscribe(SubscribeRequest{Subscriber: "dashboard", Pattern: "jobs.*"}, func(event TopicEvent) {
+		events <- event
+	})
+
+	callWith(t, broker, "event-1", protocol.ResultDelivery{Mode: protocol.ResultDeliveryEvent, Topic: "jobs.transcode.done"})
+	answer(t, broker, "event-1")
+
+	select {
+	case event := <-events:
+		if event.Topic != "jobs.transcode.done" || event.Publisher != "caller" || event.Payload["requestId"] != "event-1" {
+			t.Errorf("Unexpected event %+v", event)
+		}
+	default:
+		t.Fatal("Result should be published to the topic")
+	}
+	if _, results := broker.results.Pending(); results != 0 {
+		t.Errorf("A published result should no longer be held, got %d", results)
+	}
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/fep-fem/protocol"
+)
+
+// handleResultRequest serves GET /results/{requestID}, which long-polls for
+// the result of one call. The caller authenticates with a resultAck envelope
+// it signed, in the X-FEM-Result-Request header, whose wait bounds how long
+// the request is held. The result is answered as a completed ack and handed
+// over once; a call still running is answered as processing.
+func (b *Broker) handleResultRequest(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	requestID, err := url.PathUnescape(strings.TrimPrefix(r.URL.EscapedPath(), protocol.ResultsPath))
+	if err != nil || requestID == "" {
+		http.Error(w, "Invalid request ID", http.StatusBadRequest)
+		return
+	}
+	data, err := protocol.DecodeResultRequest(r.Header.Get(protocol.ResultRequestHeader))
+	if err != nil || len(data) == 0 {
+		b.reject(w, nil, protocol.CodeUnauthorized, "A result request must carry a signed resultAck in the "+protocol.ResultRequestHeader+" header")
+		return
+	}
+	// Callers retry against a peer while the broker is in maintenance
+	if b.InMaintenance() {
+		b.rejectForMaintenance(w, nil)
+		return
+	}
+
+	env, err := protocol.ParseEnvelope(data)
+	if err != nil {
+		b.rejectMalformed(w, err)
+		return
+	}
+	// Sign the answer against the resultAck's nonce, as for posted envelopes
+	signed := newSignedResponseWriter(w)
+	b.answerResultRequest(signed, r, env, requestID)
+	signed.finish(b.IdentityKey(), env.Nonce)
+}
+
+// answerResultRequest authenticates a result request and answers it with the
+// result once it arrives
+func (b *Broker) answerResultRequest(w http.ResponseWriter, r *http.Request, env *protocol.GenericEnvelope, requestID string) {
+	if env.Type != protocol.EnvelopeResultAck {
+		b.reject(w, env, protocol.CodeInvalidEnvelope, "A result request must be authenticated with a resultAck envelope")
+		return
+	}
+	// The skew window bounds how long a leaked header can be replayed
+	if !b.checkClockSkew(w, env) {
+		return
+	}
+	if b.IsQuarantined(env.Agent) {
+		b.rejectQuarantined(w, env)
+		return
+	}
+	if !b.authenticateAgent(w, r, env) {
+		return
+	}
+	typed, err := protocol.ParseTyped[protocol.ResultAckBody](env)
+	if err != nil {
+		b.rejectInvalidBody(w, env, err)
+		return
+	}
+
+	// Only the call's own caller learns whether it exists
+	if result, exists := b.results.Take(env.Agent, requestID); exists {
+		b.writeAck(w, env, "completed", result)
+		return
+	}
+	if caller, pending := b.results.Caller(requestID); !pending || caller != env.Agent {
+		b.reject(w, env, protocol.CodeUnknownRequest, fmt.Sprintf("No call of %s awaits a result under request ID %s", env.Agent, requestID))
+		return
+	}
+	wait := min(time.Duration(typed.Body.Wait)*time.Second, maxResultWait)
+	if result, done := b.waitForResult(r, env.Agent, requestID, wait); done {
+		log.Printf("Delivering result %s to %s", requestID, env.Agent)
+		b.writeAck(w, env, "completed", result)
+		return
+	}
+	progress := map[string]interface{}{"requestId": requestID}
+	if request, tracked := b.requests.Get(requestID); tracked {
+		progress["state"] = request.State
+	}
+	b.writeAck(w, env, "processing", progress)
+}
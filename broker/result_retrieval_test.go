@@ -0,0 +1,94 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/fep-fem/protocol"
+)
+
+func TestWaitForResult(t *testing.T) {
+	broker := NewBroker()
+	server := httptest.NewTLSServer(broker)
+	defer server.Close()
+
+	clientFor := func(agentID string) *MCPClient {
+		pub, priv, _ := protocol.GenerateKeyPair()
+		registerDiscoveryClient(broker, agentID, pub)
+		return NewMCPClient(MCPClientConfig{AgentID: agentID, BrokerURL: server.URL, PrivateKey: priv, TLSInsecure: true})
+	}
+	client, other := clientFor("caller"), clientFor("other")
+
+	response, err := client.CallTool("transcoder", "video.transcode", nil)
+	if err != nil {
+		t.Fatalf("Tool call failed: %v", err)
+	}
+	var call struct {
+		RequestID string `json:"requestId"`
+	}
+	ack := response.(*protocol.AckBody)
+	if err := ack.ResultAs(&call); err != nil || call.RequestID == "" {
+		t.Fatalf("Expected the ack to name the request ID, got %+v", ack)
+	}
+
+	// Only the caller may wait for the result
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	var rejection *protocol.ErrorBody
+	if _, err := other.WaitForResult(ctx, call.RequestID); !errors.As(err, &rejection) || rejection.Code != protocol.CodeUnknownRequest {
+		t.Errorf("Expected unknown_request for another agent's call, got %v", err)
+	}
+	// A call still running holds the poll until the context is done
+	short, cancelShort := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancelShort()
+	if _, err := client.WaitForResult(short, call.RequestID); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Expected the wait to run out, got %v", err)
+	}
+
+	results := make(chan *protocol.DeliveredResult, 1)
+	go func() {
+		result, err := client.WaitForResult(ctx, call.RequestID)
+		if err != nil {
+			t.Errorf("WaitForResult failed: %v", err)
+		}
+		results <- result
+	}()
+	time.Sleep(50 * time.Millisecond)
+	answer(t, broker, call.RequestID)
+	result := <-results
+	if result == nil || result.RequestID != call.RequestID || len(result.Envelope) == 0 {
+		t.Fatalf("Expected the result, got %+v", result)
+	}
+
+	// The result is handed over once
+	if _, err := client.WaitForResult(ctx, call.RequestID); !errors.As(err, &rejection) || rejection.Code != protocol.CodeUnknownRequest {
+		t.Errorf("Expected unknown_request once the result was taken, got %v", err)
+	}
+	if collected, err := client.CollectResults(0); err != nil || len(collected) != 0 {
+		t.Errorf("A result already waited for should not be collected again, got %+v, %v", collected, err)
+	}
+}
+
+func TestResultRequestNeedsSignedAck(t *testing.T) {
+	broker := NewBroker()
+	resp := httptest.NewRecorder()
+	broker.ServeHTTP(resp, httptest.NewRequest("GET", protocol.ResultsPath+"call-1", nil))
+	if resp.Code != protocol.CodeUnauthorized.HTTPStatus() {
+		t.Errorf("Result requests without a resultAck should be refused, got %d", resp.Code)
+	}
+
+	_, priv, _ := protocol.GenerateKeyPair()
+	subscribe := protocol.NewTypedEnvelope("caller", protocol.SubscribeBody{Pattern: "*"})
+	subscribe.Sign(priv)
+	header, _ := protocol.EncodeResultRequest(subscribe)
+	req := httptest.NewRequest("GET", protocol.ResultsPath+"call-1", nil)
+	req.Header.Set(protocol.ResultRequestHeader, header)
+	resp = httptest.NewRecorder()
+	broker.ServeHTTP(resp, req)
+	if resp.Code != protocol.CodeInvalidEnvelope.HTTPStatus() {
+		t.Errorf("Result requests authenticated by other envelopes should be refused, got %d", resp.Code)
+	}
+}
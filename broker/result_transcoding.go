@@ -0,0 +1,196 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"image"
+	_ "image/gif" // Decoded by image.Decode
+	"image/jpeg"
+	"image/png"
+	"io"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/fep-fem/protocol"
+)
+
+// Transcoder converts a tool result from one media type to another. Results
+// are passed as carried in toolResult bodies: any JSON value for JSON types, a
+// string for text and a base64 string for binary and image types.
+type Transcoder func(result interface{}) (interface{}, error)
+
+// transcoderRegistry holds the conversions the broker can apply to results and
+// the media types each pending call accepts
+type transcoderRegistry struct {
+	transcoders map[string]map[string]Transcoder // By source, then target media type
+	accepts     map[string][]string              // Caller's accepted ranges, by request ID
+	mu          sync.RWMutex
+}
+
+func newTranscoderRegistry() *transcoderRegistry {
+	r := &transcoderRegistry{
+		transcoders: make(map[string]map[string]Transcoder),
+		accepts:     make(map[string][]string),
+	}
+	r.register(protocol.ContentTypeJSON, protocol.ContentTypeText, jsonToText)
+	r.register(protocol.ContentTypeText, protocol.ContentTypeJSON, textToJSON)
+	r.register("image/png", "image/jpeg", reencodeImage(encodeJPEG))
+	r.register("image/gif", "image/png", reencodeImage(png.Encode))
+	r.register("image/jpeg", "image/png", reencodeImage(png.Encode))
+	return r
+}
+
+func (r *transcoderRegistry) register(from, to string, t Transcoder) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	from, to = strings.ToLower(from), strings.ToLower(to)
+	if r.transcoders[from] == nil {
+		r.transcoders[from] = make(map[string]Transcoder)
+	}
+	r.transcoders[from][to] = t
+}
+
+// expect records the media ranges the caller of a request accepts
+func (r *transcoderRegistry) expect(requestID string, accept []string) {
+	if len(accept) == 0 {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.accepts[requestID] = accept
+}
+
+// take forgets a request, returning the ranges its caller accepts
+func (r *transcoderRegistry) take(requestID string) []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	accept := r.accepts[requestID]
+	delete(r.accepts, requestID)
+	return accept
+}
+
+// find returns a transcoder from a media type to the first type the accepted
+// ranges cover, and that type
+func (r *transcoderRegistry) find(from string, accept []string) (Transcoder, string) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	mediaType, _, _ := strings.Cut(strings.ToLower(from), ";")
+	byTarget := r.transcoders[strings.TrimSpace(mediaType)]
+	targets := make([]string, 0, len(byTarget))
+	for to := range byTarget {
+		targets = append(targets, to)
+	}
+	sort.Strings(targets)
+	to := protocol.NegotiateFormat(targets, accept)
+	if to == "" {
+		return nil, ""
+	}
+	return byTarget[to], to
+}
+
+// RegisterTranscoder adds or replaces the conversion of results from one media
+// type to another, applied when the caller does not accept the type the agent
+// answered in
+func (b *Broker) RegisterTranscoder(from, to string, t Transcoder) {
+	b.transcoders.register(from, to, t)
+}
+
+// transcodeResult converts a result the caller cannot accept into a type it
+// does, returning the broker-signed result to deliver in its place. It returns
+// nil when the result should be delivered as the agent sent it: the caller
+// accepts it, it failed or is encrypted, or no transcoder applies.
+func (b *Broker) transcodeResult(env *protocol.GenericEnvelope, requestID string) []byte {
+	accept := b.transcoders.take(requestID)
+	if len(accept) == 0 || env.Encrypted() {
+		return nil
+	}
+	var body protocol.ToolResultBody
+	if err := json.Unmarshal(env.Body, &body); err != nil || !body.Success {
+		return nil
+	}
+	contentType := body.ResultContentType()
+	if (protocol.ToolCallBody{Accept: accept}).Accepts(contentType) {
+		return nil
+	}
+	transcode, to := b.transcoders.find(contentType, accept)
+	if transcode == nil {
+		log.Printf("No transcoder from %s to %v for %s; delivering as sent", contentType, accept, requestID)
+		return nil
+	}
+	result, err := transcode(body.Result)
+	if err != nil {
+		log.Printf("Failed to transcode %s from %s to %s: %v", requestID, contentType, to, err)
+		return nil
+	}
+
+	builder := protocol.NewToolResult(b.federation.config.LocalBrokerID, requestID).Result(result).ContentType(to)
+	if body.Partial {
+		builder.Partial()
+	}
+	envelope, err := builder.SignWith(b.IdentityKey())
+	if err != nil {
+		log.Printf("Failed to sign transcoded result for %s: %v", requestID, err)
+		return nil
+	}
+	raw, err := json.Marshal(envelope)
+	if err != nil {
+		return nil
+	}
+	log.Printf("Transcoded result for %s from %s to %s", requestID, contentType, to)
+	return raw
+}
+
+// jsonToText renders a JSON result as text, strings as they are
+func jsonToText(result interface{}) (interface{}, error) {
+	if text, ok := result.(string); ok {
+		return text, nil
+	}
+	data, err := json.MarshalIndent(result, "", "  ")
+	return string(data), err
+}
+
+// textToJSON reads text holding a JSON document as that document, and any
+// other text as a JSON string
+func textToJSON(result interface{}) (interface{}, error) {
+	text, ok := result.(string)
+	if !ok {
+		return nil, fmt.Errorf("text result must be a string")
+	}
+	var value interface{}
+	if err := json.Unmarshal([]byte(text), &value); err == nil {
+		return value, nil
+	}
+	return text, nil
+}
+
+// reencodeImage decodes a base64 image of any registered format and encodes it
+// with encode
+func reencodeImage(encode func(io.Writer, image.Image) error) Transcoder {
+	return func(result interface{}) (interface{}, error) {
+		encoded, ok := result.(string)
+		if !ok {
+			return nil, fmt.Errorf("image result must be a base64 string")
+		}
+		data, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			return nil, err
+		}
+		img, _, err := image.Decode(bytes.NewReader(data))
+		if err != nil {
+			return nil, err
+		}
+		var out bytes.Buffer
+		if err := encode(&out, img); err != nil {
+			return nil, err
+		}
+		return base64.StdEncoding.EncodeToString(out.Bytes()), nil
+	}
+}
+
+func encodeJPEG(w io.Writer, img image.Image) error {
+	return jpeg.Encode(w, img, nil)
+}
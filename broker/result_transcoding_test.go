@@ -0,0 +1,106 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"testing"
+
+	"github.com/fep-fem/protocol"
+)
+
+// takeResult takes the result held for caller and parses its body
+func takeResult(t *testing.T, broker *Broker, caller, requestID string) (*protocol.GenericEnvelope, protocol.ToolResultBody) {
+	t.Helper()
+	delivered, ok := broker.results.Take(caller, requestID)
+	if !ok {
+		t.Fatalf("No result held for %s", requestID)
+	}
+	env, err := protocol.ParseEnvelope(delivered.Envelope)
+	if err != nil {
+		t.Fatalf("Held result should parse: %v", err)
+	}
+	var body protocol.ToolResultBody
+	json.Unmarshal(env.Body, &body)
+	return env, body
+}
+
+func TestResultsTranscodedToAcceptedType(t *testing.T) {
+	broker := NewBroker()
+	key := registerProvider(broker, "reporter", "report.build")
+
+	call, _ := protocol.NewToolCall("caller").Tool("report.build").RequestID("report-1").Accept("text/*").Build()
+	sendForAck(t, broker, call)
+	result, _ := protocol.NewToolResult("reporter", "report-1").Result(map[string]interface{}{"rows": 3}).SignWith(key)
+	postEnvelope(t, broker, result)
+
+	env, body := takeResult(t, broker, "caller", "report-1")
+	if env.Agent != broker.federation.config.LocalBrokerID || body.ContentType != protocol.ContentTypeText {
+		t.Fatalf("Expected a broker-signed text result, got %s %+v", env.Agent, body)
+	}
+	if text, _ := body.Result.(string); text != "{\n  \"rows\": 3\n}" {
+		t.Errorf("Expected the JSON rendered as text, got %q", body.Result)
+	}
+
+	// Results the caller accepts are delivered as the agent sent them
+	call, _ = protocol.NewToolCall("caller").Tool("report.build").RequestID("report-2").Accept("application/json").Build()
+	sendForAck(t, broker, call)
+	result, _ = protocol.NewToolResult("reporter", "report-2").Result("done").SignWith(key)
+	postEnvelope(t, broker, result)
+	if env, _ := takeResult(t, broker, "caller", "report-2"); env.Agent != "reporter" {
+		t.Errorf("Accepted result should not be transcoded, got one from %s", env.Agent)
+	}
+
+	// Without a transcoder the caller gets the result as sent
+	call, _ = protocol.NewToolCall("caller").Tool("report.build").RequestID("report-3").Accept("application/pdf").Build()
+	sendForAck(t, broker, call)
+	result, _ = protocol.NewToolResult("reporter", "report-3").Result("done").SignWith(key)
+	postEnvelope(t, broker, result)
+	if env, _ := takeResult(t, broker, "caller", "report-3"); env.Agent != "reporter" {
+		t.Errorf("Result without a transcoder should be delivered as sent, got one from %s", env.Agent)
+	}
+}
+
+func TestImageResultsTranscoded(t *testing.T) {
+	broker := NewBroker()
+	img := image.NewRGBA(image.Rect(0, 0, 4, 4))
+	img.Set(1, 1, color.RGBA{R: 255, A: 255})
+	var jpg bytes.Buffer
+	jpeg.Encode(&jpg, img, nil)
+
+	key := registerProvider(broker, "charts", "chart.render")
+	call, _ := protocol.NewToolCall("caller").Tool("chart.render").RequestID("chart-1").Accept("image/png").Build()
+	sendForAck(t, broker, call)
+	result, _ := protocol.NewToolResult("charts", "chart-1").Binary("image/jpeg", jpg.Bytes()).SignWith(key)
+	postEnvelope(t, broker, result)
+
+	_, body := takeResult(t, broker, "caller", "chart-1")
+	data, err := body.Bytes()
+	if err != nil || body.ContentType != "image/png" {
+		t.Fatalf("Expected a PNG result, got %s: %v", body.ContentType, err)
+	}
+	if decoded, err := png.Decode(bytes.NewReader(data)); err != nil || decoded.Bounds() != img.Bounds() {
+		t.Errorf("Expected the image as a PNG, got %v", err)
+	}
+}
+
+func TestCustomTranscoder(t *testing.T) {
+	broker := NewBroker()
+	broker.RegisterTranscoder("text/csv", "application/json", func(result interface{}) (interface{}, error) {
+		return map[string]interface{}{"csv": result}, nil
+	})
+
+	key := registerProvider(broker, "tables", "table.export")
+	call, _ := protocol.NewToolCall("caller").Tool("table.export").RequestID("table-1").Accept("application/json").Build()
+	sendForAck(t, broker, call)
+	result, _ := protocol.NewToolResult("tables", "table-1").Result("a,b\n1,2\n").ContentType("text/csv; charset=utf-8").SignWith(key)
+	postEnvelope(t, broker, result)
+
+	_, body := takeResult(t, broker, "caller", "table-1")
+	if converted, _ := body.Result.(map[string]interface{}); body.ContentType != protocol.ContentTypeJSON || converted["csv"] != "a,b\n1,2\n" {
+		t.Errorf("Expected the registered transcoder applied, got %+v", body)
+	}
+}
@@ -0,0 +1,427 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"reflect"
+	"strings"
+	"sync"
+
+	"github.com/fep-fem/protocol"
+	"github.com/google/cel-go/cel"
+	"github.com/google/cel-go/ext"
+	"google.golang.org/protobuf/types/known/structpb"
+)
+
+// Limits on the work a route script can do per envelope
+const (
+	maxScriptSteps    = 64    // Steps in one script
+	maxScriptExprSize = 1024  // Code points in one expression
+	maxScriptCost     = 10000 // CEL cost units per evaluated expression
+)
+
+// ScriptStep is one step of a route script. Value and If are CEL expressions
+// over body, the envelope body; agent, the sender; and transport, the
+// connection the envelope arrived on, e.g. transport.sourceIp. CEL has no
+// loops, I/O or side effects, so a step only ever sees and changes the
+// envelope it runs on:
+//
+//	{"action": "default", "path": "parameters.precision", "value": "2"}
+//	{"action": "set", "path": "tool", "value": "'team-a.' + body.tool"}
+//	{"action": "delete", "path": "parameters.debug", "if": "agent == 'legacy-client'"}
+//	{"action": "reject", "value": "'use math.add'", "if": "body.parameters.mode == 'unsafe'"}
+type ScriptStep struct {
+	Action string `json:"action"`          // set, default, delete or reject
+	Path   string `json:"path,omitempty"`  // Dot-separated body field written by set, default and delete
+	Value  string `json:"value,omitempty"` // Value assigned, or the message of a rejection
+	If     string `json:"if,omitempty"`    // Condition the step runs under
+}
+
+// routeScripts are a route's compiled scripts
+type routeScripts struct {
+	call, result []scriptStep
+}
+
+// scriptStep is one compiled step
+type scriptStep struct {
+	action string
+	path   []string
+	value  cel.Program
+	cond   cel.Program
+}
+
+// scriptRejection is returned when a script rejects an envelope
+type scriptRejection struct {
+	reason string
+}
+
+func (r *scriptRejection) Error() string {
+	return r.reason
+}
+
+// routeScriptEnv declares the variables route scripts may read
+var routeScriptEnv = sync.OnceValues(func() (*cel.Env, error) {
+	return cel.NewEnv(
+		cel.Variable("body", cel.MapType(cel.StringType, cel.DynType)),
+		cel.Variable("agent", cel.StringType),
+		cel.Variable("transport", cel.MapType(cel.StringType, cel.DynType)),
+		ext.Strings(),
+		cel.ParserExpressionSizeLimit(maxScriptExprSize),
+	)
+})
+
+// compileScript compiles the steps of a script
+func compileScript(steps []ScriptStep) ([]scriptStep, error) {
+	if len(steps) > maxScriptSteps {
+		return nil, fmt.Errorf("script has %d steps, limit is %d", len(steps), maxScriptSteps)
+	}
+	compiled := make([]scriptStep, 0, len(steps))
+	for i, step := range steps {
+		stmt, err := compileStep(step)
+		if err != nil {
+			return nil, fmt.Errorf("step %d (%s): %w", i+1, step.Action, err)
+		}
+		compiled = append(compiled, stmt)
+	}
+	return compiled, nil
+}
+
+func compileStep(step ScriptStep) (scriptStep, error) {
+	compiled := scriptStep{action: step.Action}
+	var err error
+	switch step.Action {
+	case "set", "default":
+		if compiled.path, err = scriptPath(step.Path); err != nil {
+			return compiled, err
+		}
+		if step.Value == "" {
+			return compiled, fmt.Errorf("missing value")
+		}
+		if compiled.value, err = compileScriptExpr(step.Value, nil); err != nil {
+			return compiled, err
+		}
+	case "delete":
+		if compiled.path, err = scriptPath(step.Path); err != nil {
+			return compiled, err
+		}
+		if step.Value != "" {
+			return compiled, fmt.Errorf("delete takes no value")
+		}
+	case "reject":
+		if step.Path != "" {
+			return compiled, fmt.Errorf("reject takes no path")
+		}
+		if step.Value == "" {
+			return compiled, fmt.Errorf("missing rejection message")
+		}
+		if compiled.value, err = compileScriptExpr(step.Value, cel.StringType); err != nil {
+			return compiled, err
+		}
+	default:
+		return compiled, fmt.Errorf("unknown action %q", step.Action)
+	}
+
+	if step.If != "" {
+		if compiled.cond, err = compileScriptExpr(step.If, cel.BoolType); err != nil {
+			return compiled, err
+		}
+	}
+	return compiled, nil
+}
+
+// scriptPath splits a dot-separated path into the envelope body
+func scriptPath(path string) ([]string, error) {
+	if path == "" {
+		return nil, fmt.Errorf("missing path")
+	}
+	segments := strings.Split(path, ".")
+	for _, segment := range segments {
+		if segment == "" {
+			return nil, fmt.Errorf("invalid path %q", path)
+		}
+	}
+	return segments, nil
+}
+
+// compileScriptExpr compiles a CEL expression, checking that it yields want
+// when want is set. Expressions whose type is only known at run time are
+// checked when they are evaluated.
+func compileScriptExpr(expr string, want *cel.Type) (cel.Program, error) {
+	env, err := routeScriptEnv()
+	if err != nil {
+		return nil, err
+	}
+	ast, issues := env.Compile(expr)
+	if issues != nil && issues.Err() != nil {
+		return nil, fmt.Errorf("invalid expression %q: %w", expr, issues.Err())
+	}
+	if output := ast.OutputType(); want != nil && !output.IsExactType(want) && !output.IsExactType(cel.DynType) {
+		return nil, fmt.Errorf("expression %q is %s, expected %s", expr, output, want)
+	}
+	return env.Program(ast, cel.CostLimit(maxScriptCost))
+}
+
+// scriptRun is the state a script runs against
+type scriptRun struct {
+	body       map[string]interface{}
+	agent      string
+	transport  map[string]interface{}
+	activation map[string]interface{} // Rebuilt after the body changes
+}
+
+// eval evaluates a program against the current body and returns its result
+// as a JSON value
+func (s *scriptRun) eval(program cel.Program) (interface{}, error) {
+	if s.activation == nil {
+		s.activation = map[string]interface{}{
+			"body":      scriptValue(s.body),
+			"agent":     s.agent,
+			"transport": s.transport,
+		}
+	}
+	out, _, err := program.Eval(s.activation)
+	if err != nil {
+		return nil, err
+	}
+	value, err := out.ConvertToNative(reflect.TypeOf(&structpb.Value{}))
+	if err != nil {
+		return nil, fmt.Errorf("result is not a JSON value: %w", err)
+	}
+	return value.(*structpb.Value).AsInterface(), nil
+}
+
+// scriptValue copies a decoded body for CEL, turning JSON numbers into ints
+// where they are integral and doubles otherwise
+func scriptValue(v interface{}) interface{} {
+	switch value := v.(type) {
+	case json.Number:
+		if n, err := value.Int64(); err == nil {
+			return n
+		}
+		f, _ := value.Float64()
+		return f
+	case map[string]interface{}:
+		object := make(map[string]interface{}, len(value))
+		for key, member := range value {
+			object[key] = scriptValue(member)
+		}
+		return object
+	case []interface{}:
+		list := make([]interface{}, len(value))
+		for i, member := range value {
+			list[i] = scriptValue(member)
+		}
+		return list
+	default:
+		return value
+	}
+}
+
+// holds evaluates a step's condition
+func (s *scriptRun) holds(cond cel.Program) (bool, error) {
+	if cond == nil {
+		return true, nil
+	}
+	value, err := s.eval(cond)
+	if err != nil {
+		return false, err
+	}
+	holds, ok := value.(bool)
+	if !ok {
+		return false, fmt.Errorf("condition is not a bool")
+	}
+	return holds, nil
+}
+
+// assign sets the value at path, creating intermediate objects
+func (s *scriptRun) assign(path []string, value interface{}, onlyIfMissing bool) error {
+	object := s.body
+	for _, segment := range path[:len(path)-1] {
+		next, exists := object[segment]
+		if !exists || next == nil {
+			child := make(map[string]interface{})
+			object[segment] = child
+			object = child
+			continue
+		}
+		child, ok := next.(map[string]interface{})
+		if !ok {
+			return fmt.Errorf("cannot set %s: %s is not an object", strings.Join(path, "."), segment)
+		}
+		object = child
+	}
+	last := path[len(path)-1]
+	if _, exists := object[last]; exists && onlyIfMissing {
+		return nil
+	}
+	object[last] = value
+	s.activation = nil
+	return nil
+}
+
+func (s *scriptRun) remove(path []string) {
+	parent := s.body
+	for _, segment := range path[:len(path)-1] {
+		child, ok := parent[segment].(map[string]interface{})
+		if !ok {
+			return
+		}
+		parent = child
+	}
+	delete(parent, path[len(path)-1])
+	s.activation = nil
+}
+
+// run executes steps in order, stopping at the first rejection or error
+func (s *scriptRun) run(steps []scriptStep) error {
+	for i, step := range steps {
+		if err := s.runStep(step); err != nil {
+			var rejection *scriptRejection
+			if errors.As(err, &rejection) {
+				return err
+			}
+			return fmt.Errorf("step %d (%s): %w", i+1, step.action, err)
+		}
+	}
+	return nil
+}
+
+func (s *scriptRun) runStep(step scriptStep) error {
+	holds, err := s.holds(step.cond)
+	if err != nil || !holds {
+		return err
+	}
+	switch step.action {
+	case "set", "default":
+		value, err := s.eval(step.value)
+		if err != nil {
+			return err
+		}
+		return s.assign(step.path, value, step.action == "default")
+	case "delete":
+		s.remove(step.path)
+	case "reject":
+		value, err := s.eval(step.value)
+		if err != nil {
+			return err
+		}
+		reason, ok := value.(string)
+		if !ok {
+			return fmt.Errorf("rejection message is not a string")
+		}
+		return &scriptRejection{reason: reason}
+	}
+	return nil
+}
+
+// compileRouteScripts compiles a route's scripts onto the route
+func compileRouteScripts(route *ToolRoute) error {
+	call, err := compileScript(route.CallScript)
+	if err != nil {
+		return fmt.Errorf("invalid call script: %w", err)
+	}
+	result, err := compileScript(route.ResultScript)
+	if err != nil {
+		return fmt.Errorf("invalid result script: %w", err)
+	}
+	route.scripts = &routeScripts{call: call, result: result}
+	return nil
+}
+
+// applyRouteScripts runs the script of the route matching the envelope's tool:
+// the call script for toolCall envelopes before they are routed, and the
+// result script for toolResult envelopes. A body the script changes is
+// rewritten in place and the envelope re-signed with the broker identity key,
+// since the sender's signature and any body digest no longer cover it; it
+// reports whether it did so. Bodies the script leaves alone are not touched.
+func (fm *FederationManager) applyRouteScripts(env *protocol.GenericEnvelope, transport TransportMetadata) (bool, error) {
+	if env.Type != protocol.EnvelopeToolCall && env.Type != protocol.EnvelopeToolResult {
+		return false, nil
+	}
+
+	var body map[string]interface{}
+	dec := json.NewDecoder(bytes.NewReader(env.Body))
+	dec.UseNumber()
+	if err := dec.Decode(&body); err != nil || body == nil {
+		// Malformed bodies are left for the handler to reject
+		return false, nil
+	}
+	tool, _ := body["tool"].(string)
+	if tool == "" {
+		return false, nil
+	}
+	route := fm.matchRoute(tool)
+	if route == nil || route.scripts == nil {
+		return false, nil
+	}
+	steps := route.scripts.call
+	if env.Type == protocol.EnvelopeToolResult {
+		steps = route.scripts.result
+	}
+	if len(steps) == 0 {
+		return false, nil
+	}
+
+	original, _ := json.Marshal(body)
+	run := &scriptRun{body: body, agent: env.Agent, transport: transport.scriptValues()}
+	if err := run.run(steps); err != nil {
+		return false, fmt.Errorf("route %s: %w", route.ToolPattern, err)
+	}
+
+	data, err := json.Marshal(body)
+	if err != nil {
+		return false, fmt.Errorf("route %s: %w", route.ToolPattern, err)
+	}
+	if bytes.Equal(data, original) {
+		return false, nil
+	}
+	// The recipient rejects encrypted envelopes whose clear members changed
+	if env.Encrypted() {
+		return false, fmt.Errorf("route %s cannot rewrite an end-to-end encrypted body", route.ToolPattern)
+	}
+
+	signer := fm.SigningKey()
+	if signer == nil {
+		return false, fmt.Errorf("route %s rewrote the body, but the broker has no identity key to sign it", route.ToolPattern)
+	}
+	rewritten := &protocol.Envelope{Type: env.Type, CommonHeaders: env.CommonHeaders, Body: data}
+	if err := rewritten.Sign(signer); err != nil {
+		return false, fmt.Errorf("route %s: failed to sign the rewritten envelope: %w", route.ToolPattern, err)
+	}
+	env.CommonHeaders = rewritten.CommonHeaders
+	env.Body = data
+	return true, nil
+}
+
+// vouchedSenderKey marks a request whose envelope was rewritten by a route
+// script after its sender's signature was checked
+type vouchedSenderKey struct{}
+
+// vouchForSender checks the signature an envelope carried before a route script
+// rewrote it and, if the registered agent it names signed it, records that on
+// the request, so the broker's signature on the rewrite stands for the agent's
+func (b *Broker) vouchForSender(r *http.Request, original *protocol.GenericEnvelope) *http.Request {
+	b.mu.RLock()
+	agent, known := b.agents[original.Agent]
+	b.mu.RUnlock()
+	if !known || agent.verifySignature(original) != nil {
+		return r
+	}
+	return r.WithContext(context.WithValue(r.Context(), vouchedSenderKey{}, original.Agent))
+}
+
+// verifySender checks an envelope is signed by the agent it names, or was
+// re-signed by the broker after a route script rewrote an envelope that was
+func (b *Broker) verifySender(r *http.Request, agent *Agent, env *protocol.GenericEnvelope) error {
+	if r != nil {
+		if vouched, _ := r.Context().Value(vouchedSenderKey{}).(string); vouched != "" && vouched == env.Agent {
+			return env.Verify(b.IdentityKey().Public().(ed25519.PublicKey))
+		}
+	}
+	return agent.verifySignature(env)
+}
@@ -0,0 +1,251 @@
+package main
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/fep-fem/protocol"
+)
+
+func runScript(t *testing.T, body string, agent string, steps ...ScriptStep) (map[string]interface{}, error) {
+	t.Helper()
+	compiled, err := compileScript(steps)
+	if err != nil {
+		t.Fatalf("Failed to compile %+v: %v", steps, err)
+	}
+	run := &scriptRun{agent: agent}
+	dec := json.NewDecoder(bytes.NewReader([]byte(body)))
+	dec.UseNumber()
+	dec.Decode(&run.body)
+	err = run.run(compiled)
+	return run.body, err
+}
+
+func TestRouteScriptSteps(t *testing.T) {
+	body, err := runScript(t, `{"tool":"add","parameters":{"a":1,"precision":5,"debug":true,"id":9007199254740993}}`, "client",
+		ScriptStep{Action: "default", Path: "parameters.precision", Value: "2"},
+		ScriptStep{Action: "default", Path: "parameters.units", Value: "'metric'"},
+		ScriptStep{Action: "set", Path: "tool", Value: "'math.' + body.tool"},
+		ScriptStep{Action: "set", Path: "parameters.b", Value: "body.parameters.a + 41"},
+		ScriptStep{Action: "set", Path: "meta.tags", Value: "['team-a']", If: "agent == 'client'"},
+		ScriptStep{Action: "delete", Path: "parameters.debug", If: "has(body.parameters.debug) && body.parameters.debug"},
+		ScriptStep{Action: "set", Path: "parameters.skipped", Value: "true", If: "agent != 'client'"},
+	)
+	if err != nil {
+		t.Fatalf("Script failed: %v", err)
+	}
+	got, _ := json.Marshal(body)
+	want := `{"meta":{"tags":["team-a"]},"parameters":{"a":1,"b":42,"id":9007199254740993,"precision":5,"units":"metric"},"tool":"math.add"}`
+	if string(got) != want {
+		t.Errorf("Unexpected body\n got: %s\nwant: %s", got, want)
+	}
+
+	_, err = runScript(t, `{"tool":"exec","parameters":{"mode":"unsafe"}}`, "client",
+		ScriptStep{Action: "reject", Value: "'unsafe mode is disabled on ' + body.tool", If: "body.parameters.mode == 'unsafe'"},
+		ScriptStep{Action: "set", Path: "tool", Value: "'never'"},
+	)
+	var rejection *scriptRejection
+	if !errors.As(err, &rejection) || rejection.reason != "unsafe mode is disabled on exec" {
+		t.Errorf("Expected rejection, got %v", err)
+	}
+
+	if _, err := runScript(t, `{"tool":"x","parameters":"flat"}`, "client", ScriptStep{Action: "set", Path: "parameters.a", Value: "1"}); err == nil {
+		t.Error("Setting a field inside a non-object should fail")
+	}
+	if _, err := runScript(t, `{"tool":"x"}`, "client", ScriptStep{Action: "set", Path: "tool", Value: "body.missing"}); err == nil {
+		t.Error("Reading a missing field should fail")
+	}
+	if _, err := runScript(t, `{"tool":"x","parameters":{"n":[1,2,3]}}`, "client",
+		ScriptStep{Action: "reject", Value: "body.tool", If: "body.parameters.n"}); err == nil {
+		t.Error("A condition that is not a bool should fail")
+	}
+	if _, err := runScript(t, `{"tool":"x","parameters":{"n":[1,2,3,4,5,6,7,8]}}`, "client",
+		ScriptStep{Action: "set", Path: "n", Value: "body.parameters.n.map(a, body.parameters.n.map(b, body.parameters.n.map(c, body.parameters.n.map(d, a * b * c * d))))"}); err == nil {
+		t.Error("An expression over its cost limit should fail")
+	}
+
+	compiled, _ := compileScript([]ScriptStep{{Action: "reject", Value: "'plaintext from ' + transport.sourceIp", If: "transport.transport == 'http'"}})
+	run := &scriptRun{body: map[string]interface{}{}, transport: TransportMetadata{Transport: TransportHTTP, SourceIP: "10.0.0.7"}.scriptValues()}
+	if err := run.run(compiled); !errors.As(err, &rejection) || rejection.reason != "plaintext from 10.0.0.7" {
+		t.Errorf("Expected transport rejection, got %v", err)
+	}
+}
+
+func TestRouteScriptCompileErrors(t *testing.T) {
+	for _, step := range []ScriptStep{
+		{Action: "exec", Value: "'rm -rf /'"},
+		{Action: "set", Path: "tool"},
+		{Action: "set", Value: "'spoofed'"},
+		{Action: "set", Path: "parameters..a", Value: "1"},
+		{Action: "delete"},
+		{Action: "delete", Path: "tool", Value: "1"},
+		{Action: "reject", Path: "tool", Value: "'no'"},
+		{Action: "reject", Value: "42"},
+		{Action: "set", Path: "tool", Value: "env.HOME"},
+		{Action: "set", Path: "tool", Value: "'a' +"},
+		{Action: "set", Path: "tool", Value: "'a'", If: "agent"},
+	} {
+		if _, err := compileScript([]ScriptStep{step}); err == nil {
+			t.Errorf("Step %+v should not compile", step)
+		}
+	}
+
+	fm := NewFederationManager(NewMCPRegistry(), nil)
+	if err := fm.SetRoute(ToolRoute{ToolPattern: "math.*", CallScript: []ScriptStep{{Action: "launch", Value: "'missiles'"}}}); err == nil {
+		t.Error("SetRoute should reject routes whose scripts do not compile")
+	}
+}
+
+func TestRouteScriptsRewriteToolCalls(t *testing.T) {
+	broker := NewBroker()
+	if err := broker.federation.SetRoute(ToolRoute{
+		ToolPattern: "legacy.*",
+		CallScript: []ScriptStep{
+			{Action: "reject", Value: "'legacy.drop is retired'", If: "body.tool == 'legacy.drop'"},
+			{Action: "set", Path: "tool", Value: "'v2.' + body.tool"},
+		},
+	}); err != nil {
+		t.Fatalf("SetRoute failed: %v", err)
+	}
+
+	call := func(tool string) *httptest.ResponseRecorder {
+		envelope, err := protocol.NewToolCall("client").Tool(tool).Build()
+		if err != nil {
+			t.Fatalf("Failed to build call: %v", err)
+		}
+		data, _ := json.Marshal(envelope)
+		recorder := httptest.NewRecorder()
+		broker.ServeHTTP(recorder, httptest.NewRequest(http.MethodPost, "/", bytes.NewReader(data)))
+		return recorder
+	}
+
+	resp := call("legacy.add")
+	var result map[string]string
+	if err := readAck(t, resp.Body).ResultAs(&result); err != nil || result["tool"] != "v2.legacy.add" {
+		t.Errorf("Call script should rename the tool, got %v %v", result, err)
+	}
+
+	if resp := call("legacy.drop"); resp.Code != http.StatusForbidden {
+		t.Errorf("Rejected call should be forbidden, got %d %s", resp.Code, resp.Body.String())
+	}
+
+	resp = call("math.add")
+	if err := readAck(t, resp.Body).ResultAs(&result); err != nil || result["tool"] != "math.add" {
+		t.Errorf("Tools off the route should be untouched, got %v %v", result, err)
+	}
+}
+
+func TestEncryptedToolCallsRouteOnClearMembers(t *testing.T) {
+	broker := NewBroker()
+	if err := broker.federation.SetRoute(ToolRoute{
+		ToolPattern: "legacy.*",
+		CallScript:  []ScriptStep{{Action: "set", Path: "tool", Value: "'v2.' + body.tool"}},
+	}); err != nil {
+		t.Fatalf("SetRoute failed: %v", err)
+	}
+	recipient, _ := protocol.GenerateEncryptionKey()
+
+	call := func(tool string) *httptest.ResponseRecorder {
+		typed := protocol.NewTypedEnvelope("client", protocol.ToolCallBody{
+			Tool:       tool,
+			RequestID:  "req-" + tool,
+			Parameters: map[string]interface{}{"token": "s3cret"},
+		})
+		envelope, err := protocol.EncryptTyped(typed, recipient.PublicKey(), "tool", "requestId")
+		if err != nil {
+			t.Fatalf("Failed to encrypt call: %v", err)
+		}
+		data, _ := json.Marshal(envelope)
+		recorder := httptest.NewRecorder()
+		broker.ServeHTTP(recorder, httptest.NewRequest(http.MethodPost, "/", bytes.NewReader(data)))
+		return recorder
+	}
+
+	var result map[string]string
+	if err := readAck(t, call("vault.read").Body).ResultAs(&result); err != nil || result["tool"] != "vault.read" {
+		t.Errorf("Encrypted call should be routed on its clear tool, got %v %v", result, err)
+	}
+	if resp := call("legacy.add"); resp.Code == http.StatusOK {
+		t.Errorf("Route scripts must not rewrite encrypted bodies, got %d", resp.Code)
+	}
+}
+
+func TestRewrittenEnvelopesStillVerify(t *testing.T) {
+	broker := NewBroker()
+	if err := broker.federation.SetRoute(ToolRoute{
+		ToolPattern: "legacy.*",
+		CallScript:  []ScriptStep{{Action: "set", Path: "tool", Value: "'v2.' + body.tool", If: "!body.tool.startsWith('legacy.keep')"}},
+	}); err != nil {
+		t.Fatalf("SetRoute failed: %v", err)
+	}
+	pubKey, privKey, _ := protocol.GenerateKeyPair()
+	registerDiscoveryClient(broker, "client", pubKey)
+	brokerKey := broker.IdentityKey().Public().(ed25519.PublicKey)
+
+	signed := func(tool string) *protocol.GenericEnvelope {
+		envelope := &protocol.Envelope{Type: protocol.EnvelopeToolCall, Body: json.RawMessage(`{"tool":"` + tool + `","requestId":"req-1"}`)}
+		envelope.CommonHeaders = protocol.NewEnvelope(protocol.EnvelopeToolCall, "client").CommonHeaders
+		if err := envelope.SignDetached(privKey, protocol.DigestSHA256); err != nil {
+			t.Fatalf("Failed to sign call: %v", err)
+		}
+		data, _ := json.Marshal(envelope)
+		env, _ := protocol.ParseEnvelope(data)
+		return env
+	}
+
+	// A rewritten body is re-signed by the broker, dropping the sender's digest
+	env := signed("legacy.add")
+	if rewritten, err := broker.federation.applyRouteScripts(env, TransportMetadata{}); err != nil || !rewritten {
+		t.Fatalf("Expected the call rewritten, got %v %v", rewritten, err)
+	}
+	if env.Digest != "" {
+		t.Errorf("Rewritten envelope should not keep the sender's body digest, got %s", env.Digest)
+	}
+	data, _ := json.Marshal(env)
+	forwarded, err := protocol.ParseEnvelope(data)
+	if err != nil {
+		t.Fatalf("Rewritten envelope should parse: %v", err)
+	}
+	if err := forwarded.Verify(brokerKey); err != nil {
+		t.Errorf("Forwarded rewritten envelope should verify with the broker key: %v", err)
+	}
+
+	// A body the script leaves alone keeps the sender's signature and digest
+	env = signed("legacy.keep")
+	original := string(env.Body)
+	if rewritten, err := broker.federation.applyRouteScripts(env, TransportMetadata{}); err != nil || rewritten {
+		t.Fatalf("Expected the call untouched, got %v %v", rewritten, err)
+	}
+	if string(env.Body) != original || env.Digest == "" {
+		t.Errorf("Untouched envelope should keep its body and digest, got %s %q", env.Body, env.Digest)
+	}
+	if err := env.Verify(pubKey); err != nil {
+		t.Errorf("Untouched envelope should still verify with the sender key: %v", err)
+	}
+
+	// Calls that need an authenticated caller still pass once rewritten, but
+	// only if the named agent signed them before the rewrite
+	capabilities := protocol.NewEd25519CapabilityManager(broker.IdentityKey())
+	broker.SetToolCallPolicy(&ToolCallPolicy{Capabilities: capabilities})
+	token, _ := capabilities.CreateCapability("tools", "broker", "client", []string{"call:*"}, time.Hour)
+	_, otherKey, _ := protocol.GenerateKeyPair()
+	call := func(key ed25519.PrivateKey) int {
+		envelope, _ := protocol.NewToolCall("client").Tool("legacy.add").RequestID("req-2").Capability(token).SignWith(key)
+		data, _ := json.Marshal(envelope)
+		recorder := httptest.NewRecorder()
+		broker.ServeHTTP(recorder, httptest.NewRequest(http.MethodPost, "/", bytes.NewReader(data)))
+		return recorder.Code
+	}
+	if code := call(otherKey); code != http.StatusUnauthorized {
+		t.Errorf("Rewritten calls not signed by the caller should be refused, got %d", code)
+	}
+	if code := call(privKey); code != http.StatusOK {
+		t.Errorf("Rewritten calls signed by the caller should pass, got %d", code)
+	}
+}
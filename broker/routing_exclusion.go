@@ -0,0 +1,202 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+// defaultRecoveryProbes is how many consecutive healthy probes re-admit an excluded agent
+const defaultRecoveryProbes = 3
+
+// ExcludedAgent records an agent removed from routing by the health checker
+type ExcludedAgent struct {
+	AgentID       string      `json:"agentId"`
+	Status        AgentStatus `json:"status"` // Status that caused the exclusion
+	Since         time.Time   `json:"since"`
+	HealthyProbes int         `json:"healthyProbes"` // Consecutive healthy probes since exclusion
+}
+
+// ExclusionList tracks agents the health checker has taken out of routing. An
+// agent is excluded as soon as a probe finds it unhealthy and is only re-admitted
+// after a number of consecutive healthy probes, so a flapping agent stays out.
+type ExclusionList struct {
+	recoveryProbes int
+	excluded       map[string]*ExcludedAgent
+	listeners      []func(agentID string, excluded bool)
+	mu             sync.RWMutex
+}
+
+// NewExclusionList creates an exclusion list; recoveryProbes <= 0 uses the default
+func NewExclusionList(recoveryProbes int) *ExclusionList {
+	if recoveryProbes <= 0 {
+		recoveryProbes = defaultRecoveryProbes
+	}
+	return &ExclusionList{
+		recoveryProbes: recoveryProbes,
+		excluded:       make(map[string]*ExcludedAgent),
+	}
+}
+
+// OnChange registers a listener called when an agent is excluded or re-admitted
+func (l *ExclusionList) OnChange(listener func(agentID string, excluded bool)) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.listeners = append(l.listeners, listener)
+}
+
+// Observe feeds a probe result into the list and reports whether the agent's
+// routing eligibility changed
+func (l *ExclusionList) Observe(agentID string, status AgentStatus) bool {
+	unhealthy := status == AgentStatusUnhealthy || status == AgentStatusUnknown
+
+	l.mu.Lock()
+	entry, excluded := l.excluded[agentID]
+	changed := false
+	switch {
+	case !excluded && unhealthy:
+		l.excluded[agentID] = &ExcludedAgent{AgentID: agentID, Status: status, Since: time.Now()}
+		changed = true
+	case excluded && status == AgentStatusHealthy:
+		entry.HealthyProbes++
+		if entry.HealthyProbes >= l.recoveryProbes {
+			delete(l.excluded, agentID)
+			changed = true
+		}
+	case excluded:
+		// Anything short of healthy restarts the recovery count
+		entry.HealthyProbes = 0
+	}
+	listeners := append([]func(string, bool){}, l.listeners...)
+	l.mu.Unlock()
+
+	if changed {
+		nowExcluded := !excluded
+		if nowExcluded {
+			log.Printf("Excluding agent %s from routing (%s)", agentID, status)
+		} else {
+			log.Printf("Re-admitting agent %s to routing after %d healthy probes", agentID, l.recoveryProbes)
+		}
+		for _, listener := range listeners {
+			listener(agentID, nowExcluded)
+		}
+	}
+	return changed
+}
+
+// IsExcluded reports whether an agent is currently excluded from routing
+func (l *ExclusionList) IsExcluded(agentID string) bool {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	_, excluded := l.excluded[agentID]
+	return excluded
+}
+
+// Restore re-excludes an agent recorded before a restart and notifies
+// listeners. Agents already on the list are left as they are.
+func (l *ExclusionList) Restore(entry ExcludedAgent) {
+	l.mu.Lock()
+	if _, excluded := l.excluded[entry.AgentID]; excluded {
+		l.mu.Unlock()
+		return
+	}
+	l.excluded[entry.AgentID] = &entry
+	listeners := append([]func(string, bool){}, l.listeners...)
+	l.mu.Unlock()
+
+	for _, listener := range listeners {
+		listener(entry.AgentID, true)
+	}
+}
+
+// Forget drops an agent without notifying listeners, for agents that no longer exist
+func (l *ExclusionList) Forget(agentID string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	delete(l.excluded, agentID)
+}
+
+// Excluded lists excluded agents ordered by agent ID
+func (l *ExclusionList) Excluded() []ExcludedAgent {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	agents := make([]ExcludedAgent, 0, len(l.excluded))
+	for _, entry := range l.excluded {
+		agents = append(agents, *entry)
+	}
+	sort.Slice(agents, func(i, j int) bool { return agents[i].AgentID < agents[j].AgentID })
+	return agents
+}
+
+// Filter removes excluded agents from a candidate set
+func (l *ExclusionList) Filter(agents []string) []string {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	if len(l.excluded) == 0 {
+		return agents
+	}
+	eligible := make([]string, 0, len(agents))
+	for _, agentID := range agents {
+		if _, excluded := l.excluded[agentID]; !excluded {
+			eligible = append(eligible, agentID)
+		}
+	}
+	return eligible
+}
+
+// ExcludeAgent stops the load balancer from selecting an agent
+func (lb *LoadBalancer) ExcludeAgent(agentID string) {
+	lb.mutex.Lock()
+	defer lb.mutex.Unlock()
+	if lb.excluded == nil {
+		lb.excluded = make(map[string]bool)
+	}
+	lb.excluded[agentID] = true
+}
+
+// RestoreAgent makes an excluded agent selectable again
+func (lb *LoadBalancer) RestoreAgent(agentID string) {
+	lb.mutex.Lock()
+	defer lb.mutex.Unlock()
+	delete(lb.excluded, agentID)
+}
+
+// eligibleAgents drops agents the load balancer has been told to exclude
+func (lb *LoadBalancer) eligibleAgents(agents []string) []string {
+	lb.mutex.RLock()
+	defer lb.mutex.RUnlock()
+
+	if len(lb.excluded) == 0 {
+		return agents
+	}
+	eligible := make([]string, 0, len(agents))
+	for _, agentID := range agents {
+		if !lb.excluded[agentID] {
+			eligible = append(eligible, agentID)
+		}
+	}
+	return eligible
+}
+
+// Exclusions returns the health checker's routing exclusion list
+func (fm *FederationManager) Exclusions() *ExclusionList {
+	return fm.exclusions
+}
+
+// observeAgentHealth records a probe result, updating routing eligibility
+func (fm *FederationManager) observeAgentHealth(agentID string, status AgentStatus) {
+	fm.exclusions.Observe(agentID, status)
+}
+
+// handleAdminExclusions lists agents excluded from routing by the health checker
+func (b *Broker) handleAdminExclusions(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	writeJSON(w, http.StatusOK, b.federation.Exclusions().Excluded())
+}
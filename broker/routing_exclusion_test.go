@@ -0,0 +1,79 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/fep-fem/protocol"
+)
+
+func TestExclusionListRecovery(t *testing.T) {
+	list := NewExclusionList(3)
+	var events []bool
+	list.OnChange(func(agentID string, excluded bool) { events = append(events, excluded) })
+
+	if !list.Observe("a", AgentStatusUnhealthy) || !list.IsExcluded("a") {
+		t.Fatal("Unhealthy probe should exclude the agent immediately")
+	}
+
+	// A degraded probe in the middle restarts the count
+	list.Observe("a", AgentStatusHealthy)
+	list.Observe("a", AgentStatusHealthy)
+	list.Observe("a", AgentStatusDegraded)
+	list.Observe("a", AgentStatusHealthy)
+	list.Observe("a", AgentStatusHealthy)
+	if !list.IsExcluded("a") {
+		t.Fatal("Agent should stay excluded until 3 consecutive healthy probes")
+	}
+	if !list.Observe("a", AgentStatusHealthy) || list.IsExcluded("a") {
+		t.Fatal("Third consecutive healthy probe should re-admit the agent")
+	}
+
+	if len(events) != 2 || events[0] != true || events[1] != false {
+		t.Errorf("Unexpected change notifications: %v", events)
+	}
+}
+
+func TestExcludedAgentsAreNotRouted(t *testing.T) {
+	registry := NewMCPRegistry()
+	fm := NewFederationManager(registry, nil)
+	for _, agentID := range []string{"a1", "a2"} {
+		registry.RegisterAgent(agentID, &MCPAgent{
+			ID:            agentID,
+			Tools:         []protocol.MCPTool{{Name: "math.add"}},
+			LastHeartbeat: time.Now(),
+		})
+		fm.agentMetrics[agentID] = &AgentMetrics{AgentID: agentID, HealthScore: 0.9}
+	}
+
+	fm.observeAgentHealth("a1", AgentStatusUnhealthy)
+
+	// Selection ignores the excluded agent even though its last score looks healthy
+	for i := 0; i < 5; i++ {
+		decision, err := fm.RouteToolInvocation("math.add", "", &RequestContext{})
+		if err != nil {
+			t.Fatalf("Routing failed: %v", err)
+		}
+		if decision.SelectedAgent != "a2" || len(decision.AlternativeAgents) != 1 {
+			t.Fatalf("Excluded agent was a candidate: %+v", decision)
+		}
+	}
+	if _, err := fm.loadBalancer.SelectAgent([]string{"a1"}, fm.agentMetrics, nil, LoadBalanceRoundRobin); err == nil {
+		t.Error("Load balancer should not select an excluded agent")
+	}
+
+	// Operator routes respect exclusions too
+	fm.SetRoute(ToolRoute{ToolPattern: "math.add", PrimaryAgents: []string{"a1"}, FallbackAgents: []string{"a2"}})
+	decision, err := fm.RouteToolInvocation("math.add", "", &RequestContext{})
+	if err != nil || decision.SelectedAgent != "a2" {
+		t.Fatalf("Expected fallback while primary is excluded, got %+v, %v", decision, err)
+	}
+
+	for i := 0; i < defaultRecoveryProbes; i++ {
+		fm.observeAgentHealth("a1", AgentStatusHealthy)
+	}
+	decision, err = fm.RouteToolInvocation("math.add", "", &RequestContext{})
+	if err != nil || decision.SelectedAgent != "a1" {
+		t.Fatalf("Expected recovered primary, got %+v, %v", decision, err)
+	}
+}
@@ -0,0 +1,440 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fep-fem/protocol"
+)
+
+// maxRoutingRecordSize bounds the routing log lines read back
+const maxRoutingRecordSize = 4 << 20
+
+// RoutingRecord is one load balancing decision as the routing log keeps it:
+// the request, every candidate's metrics when it was made and the agent chosen
+type RoutingRecord struct {
+	Time               time.Time          `json:"time"`
+	Tool               string             `json:"tool,omitempty"`
+	Requester          string             `json:"requester,omitempty"`
+	RequestID          string             `json:"requestId,omitempty"`
+	Priority           RequestPriority    `json:"priority,omitempty"`
+	LatencyRequirement protocol.Duration  `json:"latencyRequirement,omitempty"`
+	Region             string             `json:"region,omitempty"`
+	Affinity           []string           `json:"affinity,omitempty"`
+	Mode               LoadBalanceMode    `json:"mode"`
+	HealthWeights      map[string]float64 `json:"healthWeights,omitempty"` // Weight of each health signal the scores were composed with
+	Candidates         []RoutingCandidate `json:"candidates"`
+	Selected           string             `json:"selected"`
+}
+
+// RoutingCandidate is an agent a decision chose among, with the metrics the
+// strategies saw
+type RoutingCandidate struct {
+	AgentID        string             `json:"agentId"`
+	Unmeasured     bool               `json:"unmeasured,omitempty"` // No metrics yet; the rest are zero
+	HealthScore    float64            `json:"healthScore"`
+	ProbeScores    map[string]float64 `json:"probeScores,omitempty"`
+	LoadScore      float64            `json:"loadScore"`
+	ErrorRate      float64            `json:"errorRate"`
+	Availability   float64            `json:"availability"`
+	ResponseTime   protocol.Duration  `json:"responseTime"` // Average response time
+	GPUUtilization float64            `json:"gpuUtilization,omitempty"`
+	Region         string             `json:"region,omitempty"`
+	Cost           float64            `json:"cost,omitempty"`
+}
+
+// routingRecorder appends each selection to the routing log as a JSON line
+type routingRecorder struct {
+	out     io.Writer
+	cost    func(agentID, toolName string) float64
+	weights func() map[string]float64
+	mu      sync.Mutex
+}
+
+// SetRoutingLog records every load balancing decision to out, for replay
+// against candidate configurations with fem-broker simulate; nil stops it
+func (fm *FederationManager) SetRoutingLog(out io.Writer) {
+	var recorder *routingRecorder
+	if out != nil {
+		recorder = &routingRecorder{out: out, cost: fm.toolCost, weights: fm.healthChecker.signalWeights}
+	}
+	fm.loadBalancer.mutex.Lock()
+	defer fm.loadBalancer.mutex.Unlock()
+	fm.loadBalancer.recorder = recorder
+}
+
+func (r *routingRecorder) record(agents []string, metrics map[string]*AgentMetrics, context *RequestContext, mode LoadBalanceMode, selected string) {
+	record := RoutingRecord{Time: time.Now(), Mode: mode, Selected: selected, HealthWeights: r.weights()}
+	if context != nil {
+		record.Tool = context.ToolName
+		record.Requester = context.RequesterID
+		record.RequestID = context.RequestID
+		record.Priority = context.Priority
+		record.LatencyRequirement = protocol.Duration(context.LatencyRequirement)
+		record.Region = context.GeographicRegion
+		record.Affinity = context.AffinityPreferences
+	}
+	for _, agentID := range agents {
+		candidate := RoutingCandidate{AgentID: agentID}
+		if metric, exists := metrics[agentID]; exists {
+			candidate.HealthScore = metric.HealthScore
+			candidate.ProbeScores = metric.ProbeScores
+			candidate.LoadScore = metric.LoadScore
+			candidate.ErrorRate = metric.ErrorRate
+			candidate.Availability = metric.Availability
+			candidate.ResponseTime = protocol.Duration(metric.AverageResponseTime)
+			candidate.GPUUtilization = metric.GPUUtilization
+			candidate.Region = metric.GeographicRegion
+		} else {
+			candidate.Unmeasured = true
+		}
+		if r.cost != nil && record.Tool != "" {
+			candidate.Cost = r.cost(agentID, record.Tool)
+		}
+		record.Candidates = append(record.Candidates, candidate)
+	}
+
+	data, err := json.Marshal(record)
+	if err != nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, err := r.out.Write(append(data, '\n')); err != nil {
+		log.Printf("Failed to write routing log: %v", err)
+	}
+}
+
+// ReadRoutingLog reads the decisions of a routing log, oldest first
+func ReadRoutingLog(in io.Reader) ([]RoutingRecord, error) {
+	var records []RoutingRecord
+	scanner := bufio.NewScanner(in)
+	scanner.Buffer(make([]byte, 64*1024), maxRoutingRecordSize)
+	for line := 1; scanner.Scan(); line++ {
+		if len(strings.TrimSpace(scanner.Text())) == 0 {
+			continue
+		}
+		var record RoutingRecord
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+		records = append(records, record)
+	}
+	return records, scanner.Err()
+}
+
+// SimulationConfig is the candidate configuration decisions are replayed
+// through. Zero values keep what each decision was made with.
+type SimulationConfig struct {
+	Mode          LoadBalanceMode    // Mode every decision is replayed with
+	Routes        []ToolRoute        // Routes whose load balance modes apply to the tools they match
+	HealthWeights map[string]float64 // Health signal weights health scores are recomposed with
+}
+
+// SimulationReport compares the recorded decisions with those the candidate
+// configuration would have made. Predicted latencies are the average response
+// times of the agents selected, as recorded with each decision.
+type SimulationReport struct {
+	Decisions  int                        `json:"decisions"`
+	Changed    int                        `json:"changed"`
+	Failed     int                        `json:"failed"` // Decisions the candidate configuration could not make
+	Selections map[string]*SelectionShift `json:"selections"`
+	Tools      map[string]*SelectionShift `json:"tools"` // Decisions and changes per tool
+	Before     LatencyPrediction          `json:"before"`
+	After      LatencyPrediction          `json:"after"`
+}
+
+// SelectionShift counts how often an agent was, and would have been, selected
+type SelectionShift struct {
+	Before  int `json:"before"`
+	After   int `json:"after"`
+	Changed int `json:"changed,omitempty"` // Decisions that went to another agent, for tools
+}
+
+// LatencyPrediction summarizes the response times of the agents selected
+type LatencyPrediction struct {
+	Mean protocol.Duration `json:"mean"`
+	P95  protocol.Duration `json:"p95"`
+}
+
+// SimulateRouting replays decisions, oldest first, through the candidate
+// configuration. Each decision chooses among the candidates recorded with it,
+// as they were measured then, so the replay does not model how the new
+// selections would themselves have shifted load.
+func SimulateRouting(records []RoutingRecord, config SimulationConfig) *SimulationReport {
+	report := &SimulationReport{
+		Selections: make(map[string]*SelectionShift),
+		Tools:      make(map[string]*SelectionShift),
+	}
+	shift := func(counts map[string]*SelectionShift, key string) *SelectionShift {
+		if counts[key] == nil {
+			counts[key] = &SelectionShift{}
+		}
+		return counts[key]
+	}
+
+	// Costs come from the decision being replayed
+	var costs map[string]float64
+	balancer := NewLoadBalancer()
+	balancer.RegisterStrategy(LoadBalanceCostAware, &CostAwareStrategy{cost: func(agentID, _ string) float64 { return costs[agentID] }})
+
+	var before, after []time.Duration
+	for _, record := range records {
+		if len(record.Candidates) == 0 {
+			continue
+		}
+		report.Decisions++
+		shift(report.Selections, record.Selected).Before++
+		tool := shift(report.Tools, record.Tool)
+		tool.Before++
+
+		agents := make([]string, 0, len(record.Candidates))
+		metrics := make(map[string]*AgentMetrics)
+		latencies := make(map[string]time.Duration)
+		costs = make(map[string]float64)
+		for _, candidate := range record.Candidates {
+			agents = append(agents, candidate.AgentID)
+			costs[candidate.AgentID] = candidate.Cost
+			latencies[candidate.AgentID] = candidate.ResponseTime.Std()
+			if candidate.Unmeasured {
+				continue
+			}
+			metrics[candidate.AgentID] = &AgentMetrics{
+				AgentID:             candidate.AgentID,
+				HealthScore:         recomposeHealth(candidate, record.HealthWeights, config.HealthWeights),
+				ProbeScores:         candidate.ProbeScores,
+				LoadScore:           candidate.LoadScore,
+				ErrorRate:           candidate.ErrorRate,
+				Availability:        candidate.Availability,
+				AverageResponseTime: candidate.ResponseTime.Std(),
+				GPUUtilization:      candidate.GPUUtilization,
+				GeographicRegion:    candidate.Region,
+			}
+		}
+		if latency := latencies[record.Selected]; latency > 0 {
+			before = append(before, latency)
+		}
+
+		context := &RequestContext{
+			RequesterID:         record.Requester,
+			ToolName:            record.Tool,
+			Priority:            record.Priority,
+			LatencyRequirement:  record.LatencyRequirement.Std(),
+			GeographicRegion:    record.Region,
+			AffinityPreferences: record.Affinity,
+			RequestID:           record.RequestID,
+		}
+		selected, err := balancer.SelectAgent(agents, metrics, context, config.modeFor(record))
+		if err != nil {
+			report.Failed++
+			continue
+		}
+		shift(report.Selections, selected).After++
+		tool.After++
+		if selected != record.Selected {
+			report.Changed++
+			tool.Changed++
+		}
+		if latency := latencies[selected]; latency > 0 {
+			after = append(after, latency)
+		}
+	}
+
+	report.Before = predictLatency(before)
+	report.After = predictLatency(after)
+	return report
+}
+
+// modeFor returns the load balance mode a decision is replayed with: the
+// configured mode, else that of the most specific route matching its tool,
+// else the mode it was made with
+func (c SimulationConfig) modeFor(record RoutingRecord) LoadBalanceMode {
+	if c.Mode != "" {
+		return c.Mode
+	}
+	var match *ToolRoute
+	for i, route := range c.Routes {
+		if route.LoadBalanceMode == "" || !(&MCPRegistry{}).matchCapability(record.Tool, route.ToolPattern) {
+			continue
+		}
+		if route.ToolPattern == record.Tool {
+			return route.LoadBalanceMode
+		}
+		if match == nil || len(route.ToolPattern) > len(match.ToolPattern) {
+			match = &c.Routes[i]
+		}
+	}
+	if match != nil {
+		return match.LoadBalanceMode
+	}
+	return record.Mode
+}
+
+// recomposeHealth recomputes a candidate's health score from its signal
+// scores with the recorded weights, overridden by the candidate ones. Like the
+// health checker, it weighs only the signals that scored the agent.
+func recomposeHealth(candidate RoutingCandidate, recorded, overrides map[string]float64) float64 {
+	if len(overrides) == 0 || len(candidate.ProbeScores) == 0 {
+		return candidate.HealthScore
+	}
+	var weighted, total float64
+	for signal, score := range candidate.ProbeScores {
+		weight, overridden := overrides[signal]
+		if !overridden {
+			weight = recorded[signal]
+		}
+		weighted += weight * score
+		total += weight
+	}
+	if total <= 0 {
+		return 0
+	}
+	return weighted / total
+}
+
+func predictLatency(latencies []time.Duration) LatencyPrediction {
+	if len(latencies) == 0 {
+		return LatencyPrediction{}
+	}
+	sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+	var sum time.Duration
+	for _, latency := range latencies {
+		sum += latency
+	}
+	return LatencyPrediction{
+		Mean: protocol.Duration(sum / time.Duration(len(latencies))),
+		P95:  protocol.Duration(latencies[(len(latencies)*95-1)/100]),
+	}
+}
+
+// WriteText writes the report for a terminal
+func (r *SimulationReport) WriteText(w io.Writer) {
+	changed := 0.0
+	if r.Decisions > 0 {
+		changed = 100 * float64(r.Changed) / float64(r.Decisions)
+	}
+	fmt.Fprintf(w, "Decisions replayed: %d\n", r.Decisions)
+	fmt.Fprintf(w, "Selections changed: %d (%.1f%%)\n", r.Changed, changed)
+	if r.Failed > 0 {
+		fmt.Fprintf(w, "Decisions failed:   %d\n", r.Failed)
+	}
+	fmt.Fprintf(w, "Predicted latency:  mean %s -> %s, p95 %s -> %s\n", r.Before.Mean, r.After.Mean, r.Before.P95, r.After.P95)
+
+	fmt.Fprintf(w, "\n%-32s %8s %8s\n", "AGENT", "BEFORE", "AFTER")
+	for _, agentID := range sortedShiftKeys(r.Selections) {
+		fmt.Fprintf(w, "%-32s %8d %8d\n", agentID, r.Selections[agentID].Before, r.Selections[agentID].After)
+	}
+	fmt.Fprintf(w, "\n%-32s %8s %8s\n", "TOOL", "CALLS", "CHANGED")
+	for _, tool := range sortedShiftKeys(r.Tools) {
+		fmt.Fprintf(w, "%-32s %8d %8d\n", tool, r.Tools[tool].Before, r.Tools[tool].Changed)
+	}
+}
+
+func sortedShiftKeys(shifts map[string]*SelectionShift) []string {
+	keys := make([]string, 0, len(shifts))
+	for key := range shifts {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// runSimulate runs fem-broker simulate and returns the exit code
+func runSimulate(args []string) int {
+	var logPath, mode, routesPath, weights, format string
+	flags := flag.NewFlagSet("simulate", flag.ContinueOnError)
+	flags.StringVar(&logPath, "log", "", "Routing log to replay, as written with --routing-log")
+	flags.StringVar(&mode, "mode", "", "Load balance mode to replay every decision with")
+	flags.StringVar(&routesPath, "routes", "", "Routes file whose load balance modes apply to the tools they match, as for --routes-file")
+	flags.StringVar(&weights, "health-weights", "", "Health signal weights to recompose scores with, e.g. connectivity=0.6,latency=0.4")
+	flags.StringVar(&format, "format", "text", "Report format: text or json")
+	flags.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Usage: fem-broker simulate --log routing.jsonl [flags]")
+		fmt.Fprintln(os.Stderr, "\nReplays recorded routing decisions through a candidate configuration and reports how selections and predicted latencies would change.")
+		flags.PrintDefaults()
+	}
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	if logPath == "" || (format != "text" && format != "json") {
+		flags.Usage()
+		return 2
+	}
+
+	var config SimulationConfig
+	if mode != "" {
+		config.Mode = LoadBalanceMode(mode)
+		if _, known := NewLoadBalancer().strategies[config.Mode]; !known && config.Mode != LoadBalanceCostAware {
+			log.Printf("simulate: unknown load balance mode %s", mode)
+			return 2
+		}
+	}
+	if routesPath != "" {
+		data, err := os.ReadFile(routesPath)
+		if err != nil {
+			log.Printf("simulate: %v", err)
+			return 1
+		}
+		if err := json.Unmarshal(data, &config.Routes); err != nil {
+			log.Printf("simulate: invalid routes file %s: %v", routesPath, err)
+			return 1
+		}
+	}
+	if weights != "" {
+		parsed, err := parseSignalWeights(weights)
+		if err != nil {
+			log.Printf("simulate: invalid --health-weights: %v", err)
+			return 2
+		}
+		config.HealthWeights = parsed
+	}
+
+	in, err := os.Open(logPath)
+	if err != nil {
+		log.Printf("simulate: %v", err)
+		return 1
+	}
+	defer in.Close()
+	records, err := ReadRoutingLog(in)
+	if err != nil {
+		log.Printf("simulate: %s: %v", logPath, err)
+		return 1
+	}
+
+	report := SimulateRouting(records, config)
+	if format == "json" {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		encoder.Encode(report)
+		return 0
+	}
+	report.WriteText(os.Stdout)
+	return 0
+}
+
+// parseSignalWeights reads comma-separated signal=weight pairs
+func parseSignalWeights(spec string) (map[string]float64, error) {
+	weights := make(map[string]float64)
+	for _, item := range parseList(spec) {
+		signal, value, ok := strings.Cut(item, "=")
+		if !ok {
+			return nil, fmt.Errorf("expected signal=weight, got %q", item)
+		}
+		weight, err := strconv.ParseFloat(value, 64)
+		if err != nil || weight < 0 {
+			return nil, fmt.Errorf("invalid weight %q for %s", value, signal)
+		}
+		weights[signal] = weight
+	}
+	return weights, nil
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+// recordCostRouting routes text.summarize calls through costFederation and
+// returns the routing log
+func recordCostRouting(t *testing.T, calls int) []RoutingRecord {
+	t.Helper()
+	_, fm := costFederation()
+	var routingLog bytes.Buffer
+	fm.SetRoutingLog(&routingLog)
+	for i := 0; i < calls; i++ {
+		if _, err := fm.RouteToolInvocation("text.summarize", "", &RequestContext{RequesterID: "acme.client"}); err != nil {
+			t.Fatalf("Routing failed: %v", err)
+		}
+	}
+	records, err := ReadRoutingLog(&routingLog)
+	if err != nil {
+		t.Fatalf("Failed to read routing log: %v", err)
+	}
+	if len(records) != calls {
+		t.Fatalf("Expected %d recorded decisions, got %d", calls, len(records))
+	}
+	return records
+}
+
+func TestRoutingLogRecordsDecisions(t *testing.T) {
+	records := recordCostRouting(t, 1)
+	record := records[0]
+	if record.Tool != "text.summarize" || record.Requester != "acme.client" || record.Mode != LoadBalanceCostAware || record.Selected != "local-agent" {
+		t.Errorf("Unexpected decision %+v", record)
+	}
+	if len(record.Candidates) != 2 || record.HealthWeights[ProbeConnectivity] == 0 {
+		t.Fatalf("Expected both candidates and the health weights recorded, got %+v", record)
+	}
+	for _, candidate := range record.Candidates {
+		if candidate.AgentID == "cloud-agent" && (candidate.Cost != 0.02 || candidate.ResponseTime.Std() != 100*time.Millisecond) {
+			t.Errorf("Expected the cloud agent's price and latency recorded, got %+v", candidate)
+		}
+	}
+}
+
+func TestSimulateRoutingChanges(t *testing.T) {
+	records := recordCostRouting(t, 4)
+
+	// Replaying the recorded configuration reproduces it
+	if report := SimulateRouting(records, SimulationConfig{}); report.Decisions != 4 || report.Changed != 0 {
+		t.Errorf("Expected the same selections, got %+v", report)
+	}
+	// Routes apply their load balance modes to the tools they match
+	routes := []ToolRoute{{ToolPattern: "text.*", LoadBalanceMode: LoadBalanceBestPerformance}, {ToolPattern: "text.translate", LoadBalanceMode: LoadBalanceCostAware}}
+	if report := SimulateRouting(records, SimulationConfig{Routes: routes}); report.Changed != 4 {
+		t.Errorf("Expected the route's mode to change every selection, got %+v", report)
+	}
+
+	report := SimulateRouting(records, SimulationConfig{Mode: LoadBalanceBestPerformance})
+	if report.Changed != 4 || report.Tools["text.summarize"].Changed != 4 {
+		t.Errorf("Expected every selection to move to the faster agent, got %+v", report)
+	}
+	if local, cloud := report.Selections["local-agent"], report.Selections["cloud-agent"]; local.Before != 4 || local.After != 0 || cloud.After != 4 {
+		t.Errorf("Unexpected selection shifts %+v %+v", local, cloud)
+	}
+	if report.Before.Mean.Std() != 900*time.Millisecond || report.After.P95.Std() != 100*time.Millisecond {
+		t.Errorf("Expected predicted latency to drop from 900ms to 100ms, got %+v -> %+v", report.Before, report.After)
+	}
+}
+
+func TestRecomposeHealth(t *testing.T) {
+	candidate := RoutingCandidate{
+		HealthScore: 0.7,
+		ProbeScores: map[string]float64{ProbeConnectivity: 1, ProbeLatency: 0.25},
+	}
+	recorded := map[string]float64{ProbeConnectivity: 0.4, ProbeLatency: 0.3, canaryProbeName: 1}
+	if score := recomposeHealth(candidate, recorded, nil); score != 0.7 {
+		t.Errorf("Without new weights the recorded score should stand, got %v", score)
+	}
+	// Only the signals that scored the agent count, as for the health checker
+	if score := recomposeHealth(candidate, recorded, map[string]float64{ProbeLatency: 0.4}); score != 0.625 {
+		t.Errorf("Expected 0.625, got %v", score)
+	}
+	if _, err := parseSignalWeights("latency=-1"); err == nil {
+		t.Error("Negative weights should be refused")
+	}
+}
@@ -0,0 +1,336 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// SetRoute creates or replaces an operator-defined route and persists the table
+func (fm *FederationManager) SetRoute(route ToolRoute) error {
+	if route.ToolPattern == "" {
+		return fmt.Errorf("route needs a tool pattern")
+	}
+	if route.LoadBalanceMode != "" {
+		if _, known := fm.loadBalancer.strategies[route.LoadBalanceMode]; !known {
+			return fmt.Errorf("unknown load balance mode: %s", route.LoadBalanceMode)
+		}
+	}
+	if route.HealthThreshold < 0 || route.HealthThreshold > 1 {
+		return fmt.Errorf("health threshold must be between 0 and 1")
+	}
+	if err := validateRouteStandby(route); err != nil {
+		return err
+	}
+	if err := compileRouteScripts(&route); err != nil {
+		return err
+	}
+	route.LastUpdated = time.Now()
+
+	fm.topologyMutex.Lock()
+	fm.routingTable[route.ToolPattern] = &route
+	fm.topologyMutex.Unlock()
+
+	fm.updateStandbys()
+	return fm.saveRoutes()
+}
+
+// validateRouteStandby checks a critical route names a standby that is not
+// one of its primary agents
+func validateRouteStandby(route ToolRoute) error {
+	if !route.Critical {
+		return nil
+	}
+	if route.StandbyAgent == "" {
+		return fmt.Errorf("critical route needs a standby agent")
+	}
+	if len(route.PrimaryAgents) == 0 {
+		return fmt.Errorf("critical route needs primary agents")
+	}
+	for _, agentID := range route.PrimaryAgents {
+		if agentID == route.StandbyAgent {
+			return fmt.Errorf("standby agent %s is also a primary agent", agentID)
+		}
+	}
+	return nil
+}
+
+// DeleteRoute removes an operator-defined route
+func (fm *FederationManager) DeleteRoute(pattern string) (bool, error) {
+	fm.topologyMutex.Lock()
+	_, exists := fm.routingTable[pattern]
+	delete(fm.routingTable, pattern)
+	fm.topologyMutex.Unlock()
+
+	if !exists {
+		return false, nil
+	}
+	fm.updateStandbys()
+	return true, fm.saveRoutes()
+}
+
+// Routes lists operator-defined routes ordered by pattern
+func (fm *FederationManager) Routes() []ToolRoute {
+	fm.topologyMutex.RLock()
+	defer fm.topologyMutex.RUnlock()
+
+	routes := make([]ToolRoute, 0, len(fm.routingTable))
+	for _, route := range fm.routingTable {
+		routes = append(routes, *route)
+	}
+	sort.Slice(routes, func(i, j int) bool { return routes[i].ToolPattern < routes[j].ToolPattern })
+	return routes
+}
+
+// LoadRoutes reads routes from path and persists later changes there.
+// A missing file starts an empty table.
+func (fm *FederationManager) LoadRoutes(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("failed to read routes: %w", err)
+	}
+
+	var routes []ToolRoute
+	if len(data) > 0 {
+		if err := json.Unmarshal(data, &routes); err != nil {
+			return fmt.Errorf("invalid routes file %s: %w", path, err)
+		}
+	}
+	for i := range routes {
+		route := &routes[i]
+		if err := validateRouteStandby(*route); err != nil {
+			return fmt.Errorf("invalid route %s in %s: %w", route.ToolPattern, path, err)
+		}
+		if err := compileRouteScripts(route); err != nil {
+			return fmt.Errorf("invalid route %s in %s: %w", route.ToolPattern, path, err)
+		}
+	}
+
+	fm.topologyMutex.Lock()
+	fm.routesFile = path
+	for i := range routes {
+		fm.routingTable[routes[i].ToolPattern] = &routes[i]
+	}
+	fm.topologyMutex.Unlock()
+
+	fm.updateStandbys()
+	return nil
+}
+
+// saveRoutes writes the routing table to the routes file, if one is configured
+func (fm *FederationManager) saveRoutes() error {
+	fm.topologyMutex.RLock()
+	path := fm.routesFile
+	fm.topologyMutex.RUnlock()
+	if path == "" {
+		return nil
+	}
+
+	data, err := json.MarshalIndent(fm.Routes(), "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return err
+	}
+
+	// Write atomically so a crash never leaves a truncated routing table
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		return fmt.Errorf("failed to save routes: %w", err)
+	}
+	return os.Rename(tmp, path)
+}
+
+// matchRoute returns the most specific route matching a tool, with defaults
+// applied, or nil when no route is defined. Exact patterns win over wildcards.
+func (fm *FederationManager) matchRoute(toolName string) *ToolRoute {
+	fm.topologyMutex.RLock()
+	var match *ToolRoute
+	if route, exists := fm.routingTable[toolName]; exists {
+		match = route
+	} else {
+		for pattern, route := range fm.routingTable {
+			if !fm.mcpRegistry.matchCapability(toolName, pattern) {
+				continue
+			}
+			if match == nil || len(pattern) > len(match.ToolPattern) {
+				match = route
+			}
+		}
+	}
+	fm.topologyMutex.RUnlock()
+
+	if match == nil {
+		return nil
+	}
+	route := *match
+	if route.LoadBalanceMode == "" {
+		route.LoadBalanceMode = fm.config.DefaultLoadBalanceMode
+	}
+	if route.RoutingStrategy == "" {
+		route.RoutingStrategy = fm.config.DefaultRoutingStrategy
+	}
+	if route.HealthThreshold == 0 {
+		route.HealthThreshold = fm.config.HealthThreshold
+	}
+	return &route
+}
+
+// routeByOperatorRoute selects among a route's primary agents, then its
+// standby if promoted, falling back to its fallback agents when none of those
+// is healthy. Agents without metrics yet are considered healthy since the
+// operator named them explicitly.
+func (fm *FederationManager) routeByOperatorRoute(route *ToolRoute, toolName, preferredAgent string, context *RequestContext) (*RoutingDecision, error) {
+	dataRegion := ""
+	if context != nil {
+		dataRegion = context.DataRegion
+	}
+	if context != nil && context.ToolName == "" {
+		context.ToolName = toolName
+	}
+	// Agents without room for the call, or too dear for the caller's budget,
+	// are passed over like unhealthy ones
+	var refusal *AdmissionRefusal
+	var exceeded *BudgetExceeded
+	admitted := func(agents []string) []string {
+		allowed, more := fm.filterCapacity(agents, toolName)
+		refusal = mergeAdmissionRefusals(refusal, more)
+		allowed, overBudget := fm.filterBudget(allowed, toolName, context)
+		if overBudget != nil && (exceeded == nil || overBudget.Cheapest < exceeded.Cheapest) {
+			exceeded = overBudget
+		}
+		return allowed
+	}
+
+	// Fallback agents may take data the primary agents would move out of region
+	candidates, violation := fm.filterResidency(fm.healthyRouteAgents(route.PrimaryAgents, route.HealthThreshold), toolName, dataRegion)
+	candidates = admitted(candidates)
+	tier := "primary"
+	// A promoted standby stands in for the primary agents before any fallback
+	if len(candidates) == 0 && fm.standbys.IsPromoted(route.ToolPattern, route.StandbyAgent) {
+		var standbyViolation *ResidencyViolation
+		candidates, standbyViolation = fm.filterResidency(fm.healthyRouteAgents([]string{route.StandbyAgent}, route.HealthThreshold), toolName, dataRegion)
+		candidates = admitted(candidates)
+		tier = "standby"
+		violation = mergeResidencyViolations(violation, standbyViolation)
+	}
+	if len(candidates) == 0 {
+		var fallbackViolation *ResidencyViolation
+		candidates, fallbackViolation = fm.filterResidency(fm.healthyRouteAgents(route.FallbackAgents, route.HealthThreshold), toolName, dataRegion)
+		candidates = admitted(candidates)
+		tier = "fallback"
+		violation = mergeResidencyViolations(violation, fallbackViolation)
+	}
+	if len(candidates) == 0 {
+		if violation != nil {
+			return nil, violation
+		}
+		if refusal != nil {
+			return nil, refusal
+		}
+		if exceeded != nil {
+			return nil, exceeded
+		}
+		return nil, fmt.Errorf("no healthy agents on route %s for tool %s", route.ToolPattern, toolName)
+	}
+
+	// A caller's preferred agent is honored when the route allows it
+	selectedAgent := ""
+	for _, candidate := range candidates {
+		if candidate == preferredAgent {
+			selectedAgent = candidate
+			break
+		}
+	}
+	if selectedAgent == "" {
+		fm.metricsMutex.RLock()
+		agent, err := fm.loadBalancer.SelectAgent(candidates, fm.agentMetrics, context, route.LoadBalanceMode)
+		fm.metricsMutex.RUnlock()
+		if err != nil {
+			return nil, fmt.Errorf("agent selection failed: %w", err)
+		}
+		selectedAgent = agent
+	}
+
+	decision := &RoutingDecision{
+		SelectedAgent:     selectedAgent,
+		RoutingStrategy:   route.RoutingStrategy,
+		LoadBalanceMode:   route.LoadBalanceMode,
+		AlternativeAgents: candidates,
+		Justification:     fmt.Sprintf("Selected from %s agents of route %s using %s strategy", tier, route.ToolPattern, route.LoadBalanceMode),
+		Timestamp:         time.Now(),
+	}
+
+	fm.updateRoutingMetrics(toolName, selectedAgent, context)
+	fm.reserveResources(toolName, selectedAgent, context)
+	return decision, nil
+}
+
+// mergeResidencyViolations combines the agents refused in two violations, either of which may be nil
+func mergeResidencyViolations(violation, more *ResidencyViolation) *ResidencyViolation {
+	if violation == nil {
+		return more
+	}
+	if more != nil {
+		for agentID, reason := range more.Refused {
+			violation.Refused[agentID] = reason
+		}
+	}
+	return violation
+}
+
+// healthyRouteAgents drops excluded agents and those whose health score is known to be at or below the threshold
+func (fm *FederationManager) healthyRouteAgents(agents []string, threshold float64) []string {
+	agents = fm.exclusions.Filter(fm.filterPreempted(agents))
+
+	fm.metricsMutex.RLock()
+	defer fm.metricsMutex.RUnlock()
+
+	healthy := make([]string, 0, len(agents))
+	for _, agentID := range agents {
+		if metrics, exists := fm.agentMetrics[agentID]; exists && metrics.HealthScore <= threshold {
+			continue
+		}
+		healthy = append(healthy, agentID)
+	}
+	return healthy
+}
+
+// handleAdminRoutes lists (GET), creates or replaces (PUT/POST) and deletes (DELETE ?pattern=) routes
+func (b *Broker) handleAdminRoutes(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		writeJSON(w, http.StatusOK, b.federation.Routes())
+	case http.MethodPut, http.MethodPost:
+		var route ToolRoute
+		if err := json.NewDecoder(r.Body).Decode(&route); err != nil {
+			http.Error(w, "Invalid route", http.StatusBadRequest)
+			return
+		}
+		if err := b.federation.SetRoute(route); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]interface{}{"status": "saved", "toolPattern": route.ToolPattern})
+	case http.MethodDelete:
+		pattern := r.URL.Query().Get("pattern")
+		deleted, err := b.federation.DeleteRoute(pattern)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if !deleted {
+			http.Error(w, "Route not found", http.StatusNotFound)
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]interface{}{"status": "deleted", "toolPattern": pattern})
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestOperatorRoutePrimaryAndFallback(t *testing.T) {
+	fm := NewFederationManager(NewMCPRegistry(), nil)
+	if err := fm.SetRoute(ToolRoute{
+		ToolPattern:     "math.*",
+		PrimaryAgents:   []string{"primary"},
+		FallbackAgents:  []string{"backup"},
+		LoadBalanceMode: LoadBalanceRoundRobin,
+	}); err != nil {
+		t.Fatalf("SetRoute failed: %v", err)
+	}
+
+	// Agents without metrics are eligible, so the primary is chosen
+	decision, err := fm.RouteToolInvocation("math.add", "", nil)
+	if err != nil || decision.SelectedAgent != "primary" {
+		t.Fatalf("Expected primary agent, got %+v, %v", decision, err)
+	}
+
+	// Once the primary is unhealthy the fallback takes over
+	fm.agentMetrics["primary"].HealthScore = 0.1
+	decision, err = fm.RouteToolInvocation("math.add", "", nil)
+	if err != nil || decision.SelectedAgent != "backup" {
+		t.Fatalf("Expected fallback agent, got %+v, %v", decision, err)
+	}
+
+	// Tools outside the pattern keep the default routing
+	if _, err := fm.RouteToolInvocation("text.upper", "", nil); err == nil {
+		t.Error("Unrouted tool with no agents should fail")
+	}
+}
+
+func TestOperatorRouteMostSpecificPattern(t *testing.T) {
+	fm := NewFederationManager(NewMCPRegistry(), nil)
+	fm.SetRoute(ToolRoute{ToolPattern: "*", PrimaryAgents: []string{"generic"}})
+	fm.SetRoute(ToolRoute{ToolPattern: "math.*", PrimaryAgents: []string{"math"}})
+	fm.SetRoute(ToolRoute{ToolPattern: "math.add", PrimaryAgents: []string{"adder"}})
+
+	for tool, want := range map[string]string{"math.add": "adder", "math.mul": "math", "text.upper": "generic"} {
+		decision, err := fm.RouteToolInvocation(tool, "", nil)
+		if err != nil || decision.SelectedAgent != want {
+			t.Errorf("%s: expected %s, got %+v, %v", tool, want, decision, err)
+		}
+	}
+}
+
+func TestRoutesPersist(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "routes.json")
+
+	fm := NewFederationManager(NewMCPRegistry(), nil)
+	if err := fm.LoadRoutes(path); err != nil {
+		t.Fatalf("Loading a missing routes file should succeed: %v", err)
+	}
+	fm.SetRoute(ToolRoute{ToolPattern: "math.add", PrimaryAgents: []string{"a1"}, FallbackAgents: []string{"a2"}})
+	fm.SetRoute(ToolRoute{ToolPattern: "text.*", PrimaryAgents: []string{"t1"}})
+	fm.DeleteRoute("text.*")
+
+	restarted := NewFederationManager(NewMCPRegistry(), nil)
+	if err := restarted.LoadRoutes(path); err != nil {
+		t.Fatalf("LoadRoutes failed: %v", err)
+	}
+	routes := restarted.Routes()
+	if len(routes) != 1 || routes[0].ToolPattern != "math.add" || routes[0].FallbackAgents[0] != "a2" {
+		t.Fatalf("Unexpected persisted routes: %+v", routes)
+	}
+}
+
+func TestAdminRoutesAPI(t *testing.T) {
+	broker := NewBroker()
+	broker.SetAdminToken("secret")
+
+	admin := func(method, path, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer secret")
+		recorder := httptest.NewRecorder()
+		broker.ServeHTTP(recorder, req)
+		return recorder
+	}
+
+	if code := admin(http.MethodPut, "/admin/routes", `{"toolPattern":"math.*","primaryAgents":["m1"],"loadBalanceMode":"round_robin"}`).Code; code != http.StatusOK {
+		t.Fatalf("Creating route returned %d", code)
+	}
+	if code := admin(http.MethodPut, "/admin/routes", `{"toolPattern":"bad","loadBalanceMode":"coin_flip"}`).Code; code != http.StatusBadRequest {
+		t.Errorf("Invalid load balance mode should be rejected, got %d", code)
+	}
+	if body := admin(http.MethodGet, "/admin/routes", "").Body.String(); !strings.Contains(body, `"primaryAgents":["m1"]`) {
+		t.Errorf("Route missing from listing: %s", body)
+	}
+	if code := admin(http.MethodDelete, "/admin/routes?pattern=math.*", "").Code; code != http.StatusOK {
+		t.Errorf("Deleting route returned %d", code)
+	}
+	if code := admin(http.MethodDelete, "/admin/routes?pattern=math.*", "").Code; code != http.StatusNotFound {
+		t.Errorf("Deleting a missing route should return 404, got %d", code)
+	}
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"log"
+	"sync"
+	"time"
+)
+
+// maxSecurityEvents bounds the number of security events kept in memory
+const maxSecurityEvents = 1000
+
+// SecurityEventType classifies a security event
+type SecurityEventType string
+
+const (
+	SecurityEventHoneypotTriggered SecurityEventType = "honeypot_triggered"
+	SecurityEventAgentQuarantined  SecurityEventType = "agent_quarantined"
+	SecurityEventKeyRotated        SecurityEventType = "key_rotated"
+	SecurityEventPIIRedacted       SecurityEventType = "pii_redacted"
+	SecurityEventPIIBlocked        SecurityEventType = "pii_blocked"
+	SecurityEventCapabilityRevoked SecurityEventType = "capability_revoked"
+	SecurityEventClientKeyMismatch SecurityEventType = "client_key_mismatch"
+	SecurityEventQuorumRefused     SecurityEventType = "admin_quorum_refused"
+	SecurityEventSignatureFailures SecurityEventType = "signature_failures"
+	SecurityEventForeignResult     SecurityEventType = "foreign_result"
+)
+
+// SecurityEvent records suspicious behaviour observed by the broker
+type SecurityEvent struct {
+	Type    SecurityEventType  `json:"type"`
+	AgentID string             `json:"agentId"`
+	Detail  string             `json:"detail"`
+	Time    time.Time          `json:"time"`
+	Source  *TransportMetadata `json:"source,omitempty"` // Connection of the envelope that raised the event, if any
+}
+
+// SecurityEventLog keeps recent security events and notifies listeners as they are raised
+type SecurityEventLog struct {
+	events    []SecurityEvent
+	listeners []func(SecurityEvent)
+	mu        sync.RWMutex
+}
+
+// NewSecurityEventLog creates an empty event log
+func NewSecurityEventLog() *SecurityEventLog {
+	return &SecurityEventLog{}
+}
+
+// Raise records an event and notifies listeners
+func (l *SecurityEventLog) Raise(event SecurityEvent) {
+	if event.Time.IsZero() {
+		event.Time = time.Now()
+	}
+
+	l.mu.Lock()
+	l.events = append(l.events, event)
+	if len(l.events) > maxSecurityEvents {
+		l.events = l.events[len(l.events)-maxSecurityEvents:]
+	}
+	listeners := append([]func(SecurityEvent){}, l.listeners...)
+	l.mu.Unlock()
+
+	if event.Source != nil {
+		log.Printf("SECURITY %s: agent=%s source=%s %s", event.Type, event.AgentID, event.Source.SourceIP, event.Detail)
+	} else {
+		log.Printf("SECURITY %s: agent=%s %s", event.Type, event.AgentID, event.Detail)
+	}
+	for _, listener := range listeners {
+		listener(event)
+	}
+}
+
+// OnEvent registers a listener called for every raised event
+func (l *SecurityEventLog) OnEvent(listener func(SecurityEvent)) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.listeners = append(l.listeners, listener)
+}
+
+// Recent returns recorded events, oldest first
+func (l *SecurityEventLog) Recent() []SecurityEvent {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	return append([]SecurityEvent{}, l.events...)
+}
@@ -39,6 +39,20 @@ func (si *SemanticIndex) IndexTool(agentID string, tool protocol.MCPTool) {
 	si.similarityCache = make(map[string][]SimilarityResult)
 }
 
+// RemoveTool drops a tool from the semantic index
+func (si *SemanticIndex) RemoveTool(agentID, toolName string) {
+	si.mutex.Lock()
+	defer si.mutex.Unlock()
+
+	toolKey := agentID + "/" + toolName
+	if _, exists := si.toolVectors[toolKey]; !exists {
+		return
+	}
+	delete(si.toolVectors, toolKey)
+	delete(si.categoryIndex, toolKey)
+	si.similarityCache = make(map[string][]SimilarityResult)
+}
+
 // generateSemanticVector creates a semantic vector representation of a tool
 func (si *SemanticIndex) generateSemanticVector(tool protocol.MCPTool) []float64 {
 	// This is a simplified semantic vector generation
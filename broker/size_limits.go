@@ -0,0 +1,57 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"log"
+	"net/http"
+
+	"github.com/fep-fem/protocol"
+)
+
+// defaultMaxEnvelopeSize bounds envelopes of types without their own limit,
+// leaving room for checkpoints and large tool results
+const defaultMaxEnvelopeSize = 16 << 20
+
+// SetSizeLimits sets the largest envelope accepted, overall and per type
+func (b *Broker) SetSizeLimits(limits protocol.SizeLimits) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.sizeLimits = limits
+}
+
+// SizeLimits returns the envelope size limits in force
+func (b *Broker) SizeLimits() protocol.SizeLimits {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	return b.sizeLimits
+}
+
+// readEnvelopeBody reads a request body of at most limit bytes, or any size
+// if limit is 0. Larger bodies are refused with a *protocol.PayloadTooLargeError
+// without being buffered.
+func readEnvelopeBody(w http.ResponseWriter, r *http.Request, limit int) ([]byte, error) {
+	defer r.Body.Close()
+	if limit <= 0 {
+		return io.ReadAll(r.Body)
+	}
+	if r.ContentLength > int64(limit) {
+		return nil, &protocol.PayloadTooLargeError{Size: int(r.ContentLength), Limit: limit}
+	}
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, int64(limit)))
+	var maxBytes *http.MaxBytesError
+	if errors.As(err, &maxBytes) {
+		return nil, &protocol.PayloadTooLargeError{Limit: limit}
+	}
+	return body, err
+}
+
+// rejectTooLarge answers an oversized envelope with payload_too_large. env is
+// nil when the envelope was refused before it was parsed.
+func (b *Broker) rejectTooLarge(w http.ResponseWriter, r *http.Request, env *protocol.GenericEnvelope, tooLarge *protocol.PayloadTooLargeError) {
+	log.Printf("Refusing envelope from %s: %v", r.RemoteAddr, tooLarge)
+	errBody := tooLarge.ErrorBody()
+	rejection := b.newError(env, errBody.Code, errBody.Message)
+	rejection.Body.Details = errBody.Details
+	writeError(w, rejection)
+}
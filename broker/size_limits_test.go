@@ -0,0 +1,85 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/fep-fem/protocol"
+)
+
+// postRaw posts serialized envelope bytes and returns the response
+func postRaw(broker *Broker, data []byte) *httptest.ResponseRecorder {
+	resp := httptest.NewRecorder()
+	broker.ServeHTTP(resp, httptest.NewRequest(http.MethodPost, "/", bytes.NewReader(data)))
+	return resp
+}
+
+// expectTooLarge checks for a payload_too_large rejection and returns its body
+func expectTooLarge(t *testing.T, resp *httptest.ResponseRecorder) protocol.ErrorBody {
+	t.Helper()
+	if resp.Code != http.StatusRequestEntityTooLarge {
+		t.Fatalf("Expected 413, got %d %s", resp.Code, resp.Body.String())
+	}
+	var rejection protocol.ErrorEnvelope
+	json.Unmarshal(resp.Body.Bytes(), &rejection)
+	if rejection.Body.Code != protocol.CodePayloadTooLarge {
+		t.Fatalf("Expected payload_too_large, got %+v", rejection.Body)
+	}
+	return rejection.Body
+}
+
+func TestOversizedEnvelopesRefused(t *testing.T) {
+	broker := NewBroker()
+	broker.SetSizeLimits(protocol.SizeLimits{
+		Max:     2048,
+		PerType: map[protocol.EnvelopeType]int{protocol.EnvelopeHeartbeat: 256, protocol.EnvelopeToolResult: 16384},
+	})
+
+	call, _ := protocol.NewToolCall("caller").Tool("file.write").Param("content", strings.Repeat("x", 4096)).Build()
+	data, _ := json.Marshal(call)
+	body := expectTooLarge(t, postRaw(broker, data))
+	if body.Details["limit"] != float64(2048) || body.Details["type"] != "toolCall" || body.Ref != call.Nonce {
+		t.Errorf("Expected the toolCall limit and the call's nonce, got %+v", body)
+	}
+
+	heartbeat, _ := protocol.NewHeartbeat(strings.Repeat("h", 300)).Build()
+	data, _ = json.Marshal(heartbeat)
+	if body := expectTooLarge(t, postRaw(broker, data)); body.Details["limit"] != float64(256) {
+		t.Errorf("Expected the heartbeat limit, got %v", body.Details)
+	}
+
+	// Types with a higher limit of their own are read in full
+	key := registerProvider(broker, "worker", "file.write")
+	call, _ = protocol.NewToolCall("caller").Tool("file.write").RequestID("req-1").Build()
+	sendForAck(t, broker, call)
+	result, _ := protocol.NewToolResult("worker", "req-1").Result(strings.Repeat("r", 8192)).SignWith(key)
+	data, _ = json.Marshal(result)
+	if resp := postRaw(broker, data); resp.Code != http.StatusOK {
+		t.Errorf("A toolResult under its own limit should be accepted, got %d %s", resp.Code, resp.Body.String())
+	}
+
+	// Over every limit, refused before it is parsed
+	data, _ = json.Marshal(map[string]string{"type": "toolResult", "padding": strings.Repeat("p", 20000)})
+	if body := expectTooLarge(t, postRaw(broker, data)); body.Details["limit"] != float64(16384) || body.Details["type"] != nil {
+		t.Errorf("Expected the largest limit without a type, got %v", body.Details)
+	}
+}
+
+func TestCompressedEnvelopesLimitedWhenInflated(t *testing.T) {
+	broker := NewBroker()
+	broker.SetSizeLimits(protocol.SizeLimits{Max: 4096})
+
+	result, _ := protocol.NewToolResult("worker", "req-1").Result(strings.Repeat("log line\n", 2048)).Build()
+	data, _ := json.Marshal(result)
+	compressed, _ := protocol.CompressBody(data, &protocol.CompressionPolicy{Encoding: protocol.EncodingGzip, MinBytes: 1024})
+	if len(compressed) > 4096 {
+		t.Fatalf("Compressed envelope should fit the limit, got %d bytes", len(compressed))
+	}
+	if body := expectTooLarge(t, postRaw(broker, compressed)); body.Details["size"] != float64(len(data)) {
+		t.Errorf("Expected the inflated size in the details, got %v", body.Details)
+	}
+}
@@ -0,0 +1,191 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/fep-fem/protocol"
+)
+
+// Topics the broker publishes standby changes on
+const (
+	standbyPromotedTopic = "broker.standby.promoted"
+	standbyDemotedTopic  = "broker.standby.demoted"
+)
+
+// StandbyPromotion records a warm standby serving a critical route in place
+// of its primary agents
+type StandbyPromotion struct {
+	ToolPattern  string    `json:"toolPattern"`
+	StandbyAgent string    `json:"standbyAgent"`
+	Since        time.Time `json:"since"`
+}
+
+// StandbyTracker tracks which critical routes have their standby promoted.
+// A standby is promoted once every primary agent of its route is excluded
+// from routing, and demoted as soon as one of them is re-admitted, so the
+// exclusion list's recovery probes also keep promotion from flapping.
+type StandbyTracker struct {
+	promoted  map[string]*StandbyPromotion // By route pattern
+	listeners []func(promotion StandbyPromotion, promoted bool)
+	mu        sync.RWMutex
+}
+
+// NewStandbyTracker creates a tracker with no standby promoted
+func NewStandbyTracker() *StandbyTracker {
+	return &StandbyTracker{promoted: make(map[string]*StandbyPromotion)}
+}
+
+// OnChange registers a listener called when a standby is promoted or demoted
+func (s *StandbyTracker) OnChange(listener func(promotion StandbyPromotion, promoted bool)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.listeners = append(s.listeners, listener)
+}
+
+// Update promotes the standby of every critical route whose primary agents
+// are all excluded, and demotes the others. Routes that are gone or no
+// longer critical lose their promotion.
+func (s *StandbyTracker) Update(routes []ToolRoute, excluded func(agentID string) bool) {
+	wanted := make(map[string]string)
+	for _, route := range routes {
+		if !route.Critical || route.StandbyAgent == "" || len(route.PrimaryAgents) == 0 {
+			continue
+		}
+		down := true
+		for _, agentID := range route.PrimaryAgents {
+			if !excluded(agentID) {
+				down = false
+				break
+			}
+		}
+		if down {
+			wanted[route.ToolPattern] = route.StandbyAgent
+		}
+	}
+
+	s.mu.Lock()
+	var promoted, demoted []StandbyPromotion
+	for pattern, promotion := range s.promoted {
+		if wanted[pattern] != promotion.StandbyAgent {
+			delete(s.promoted, pattern)
+			demoted = append(demoted, *promotion)
+		}
+	}
+	for pattern, standby := range wanted {
+		if _, exists := s.promoted[pattern]; !exists {
+			promotion := &StandbyPromotion{ToolPattern: pattern, StandbyAgent: standby, Since: time.Now()}
+			s.promoted[pattern] = promotion
+			promoted = append(promoted, *promotion)
+		}
+	}
+	listeners := append([]func(StandbyPromotion, bool){}, s.listeners...)
+	s.mu.Unlock()
+
+	for _, promotion := range demoted {
+		log.Printf("Demoting standby %s of route %s: a primary agent recovered", promotion.StandbyAgent, promotion.ToolPattern)
+		for _, listener := range listeners {
+			listener(promotion, false)
+		}
+	}
+	for _, promotion := range promoted {
+		log.Printf("Promoting standby %s of route %s: no primary agent is healthy", promotion.StandbyAgent, promotion.ToolPattern)
+		for _, listener := range listeners {
+			listener(promotion, true)
+		}
+	}
+}
+
+// IsPromoted reports whether agentID is the promoted standby of the route with pattern
+func (s *StandbyTracker) IsPromoted(pattern, agentID string) bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	promotion, exists := s.promoted[pattern]
+	return exists && promotion.StandbyAgent == agentID
+}
+
+// Promotions lists promoted standbys ordered by route pattern
+func (s *StandbyTracker) Promotions() []StandbyPromotion {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	promotions := make([]StandbyPromotion, 0, len(s.promoted))
+	for _, promotion := range s.promoted {
+		promotions = append(promotions, *promotion)
+	}
+	sort.Slice(promotions, func(i, j int) bool { return promotions[i].ToolPattern < promotions[j].ToolPattern })
+	return promotions
+}
+
+// Standbys returns the tracker of warm standbys on critical routes
+func (fm *FederationManager) Standbys() *StandbyTracker {
+	return fm.standbys
+}
+
+// updateStandbys re-evaluates standby promotion after routes or exclusions change
+func (fm *FederationManager) updateStandbys() {
+	fm.standbys.Update(fm.Routes(), fm.exclusions.IsExcluded)
+}
+
+// hideStandbyTools removes tools from discovery results whose agent is the
+// standby of a critical route and not promoted, so callers keep using the
+// primary agents while the standby stays warm
+func (fm *FederationManager) hideStandbyTools(tools []protocol.DiscoveredTool) []protocol.DiscoveredTool {
+	var standbyRoutes []ToolRoute
+	for _, route := range fm.Routes() {
+		if route.Critical && route.StandbyAgent != "" && !fm.standbys.IsPromoted(route.ToolPattern, route.StandbyAgent) {
+			standbyRoutes = append(standbyRoutes, route)
+		}
+	}
+	if len(standbyRoutes) == 0 {
+		return tools
+	}
+
+	visible := make([]protocol.DiscoveredTool, 0, len(tools))
+	for _, tool := range tools {
+		offered := make([]protocol.MCPTool, 0, len(tool.MCPTools))
+		for _, mcpTool := range tool.MCPTools {
+			hidden := false
+			for _, route := range standbyRoutes {
+				if route.StandbyAgent == tool.AgentID && fm.mcpRegistry.matchCapability(mcpTool.Name, route.ToolPattern) {
+					hidden = true
+					break
+				}
+			}
+			if !hidden {
+				offered = append(offered, mcpTool)
+			}
+		}
+		if len(offered) == 0 {
+			continue
+		}
+		tool.MCPTools = offered
+		visible = append(visible, tool)
+	}
+	return visible
+}
+
+// publishStandbyChange announces a promotion or demotion on the event bus
+func (b *Broker) publishStandbyChange(promotion StandbyPromotion, promoted bool) {
+	topic := standbyDemotedTopic
+	if promoted {
+		topic = standbyPromotedTopic
+	}
+	b.events.publish("broker", topic, map[string]interface{}{
+		"toolPattern":  promotion.ToolPattern,
+		"standbyAgent": promotion.StandbyAgent,
+		"since":        protocol.Time(promotion.Since),
+	})
+}
+
+// handleAdminStandbys lists promoted standbys
+func (b *Broker) handleAdminStandbys(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	writeJSON(w, http.StatusOK, b.federation.Standbys().Promotions())
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/fep-fem/protocol"
+)
+
+func TestStandbyPromotedWhilePrimaryExcluded(t *testing.T) {
+	broker := NewBroker()
+	fm := broker.federation
+	for _, agentID := range []string{"primary", "standby", "backup"} {
+		broker.mcpRegistry.RegisterAgent(agentID, &MCPAgent{
+			ID:            agentID,
+			Tools:         []protocol.MCPTool{{Name: "pay.charge"}, {Name: "pay.refund"}},
+			LastHeartbeat: time.Now(),
+		})
+		fm.agentMetrics[agentID] = &AgentMetrics{AgentID: agentID, HealthScore: 0.9}
+	}
+	if err := fm.SetRoute(ToolRoute{
+		ToolPattern:    "pay.charge",
+		PrimaryAgents:  []string{"primary"},
+		FallbackAgents: []string{"backup"},
+		Critical:       true,
+		StandbyAgent:   "standby",
+	}); err != nil {
+		t.Fatalf("SetRoute failed: %v", err)
+	}
+
+	var changes []TopicEvent
+	broker.events.Subscribe(SubscribeRequest{Subscriber: "ops", Pattern: "broker.standby.*"}, func(event TopicEvent) {
+		changes = append(changes, event)
+	})
+	discoveredBy := func(agentID string) []string {
+		tools, _ := broker.mcpRegistry.DiscoverTools(protocol.ToolQuery{Capabilities: []string{"pay.*"}})
+		var names []string
+		for _, tool := range fm.hideStandbyTools(tools) {
+			if tool.AgentID == agentID {
+				for _, mcpTool := range tool.MCPTools {
+					names = append(names, mcpTool.Name)
+				}
+			}
+		}
+		return names
+	}
+
+	// The standby is kept out of routing and discovery for the critical tool
+	decision, err := fm.RouteToolInvocation("pay.charge", "", &RequestContext{})
+	if err != nil || decision.SelectedAgent != "primary" {
+		t.Fatalf("Expected the primary agent, got %+v, %v", decision, err)
+	}
+	if names := discoveredBy("standby"); len(names) != 1 || names[0] != "pay.refund" {
+		t.Errorf("Standby should only be discovered for other tools, got %v", names)
+	}
+
+	fm.observeAgentHealth("primary", AgentStatusUnhealthy)
+	decision, err = fm.RouteToolInvocation("pay.charge", "", &RequestContext{})
+	if err != nil || decision.SelectedAgent != "standby" {
+		t.Fatalf("Expected the promoted standby ahead of the fallback, got %+v, %v", decision, err)
+	}
+	if promotions := fm.Standbys().Promotions(); len(promotions) != 1 || promotions[0].StandbyAgent != "standby" {
+		t.Errorf("Expected one promotion, got %+v", promotions)
+	}
+	if names := discoveredBy("standby"); len(names) != 2 {
+		t.Errorf("Promoted standby should be discovered for the critical tool, got %v", names)
+	}
+	if len(changes) != 1 || changes[0].Topic != standbyPromotedTopic || changes[0].Payload["standbyAgent"] != "standby" {
+		t.Fatalf("Expected a promotion event, got %+v", changes)
+	}
+
+	// The primary takes over again once it is re-admitted
+	for i := 0; i < defaultRecoveryProbes; i++ {
+		fm.observeAgentHealth("primary", AgentStatusHealthy)
+	}
+	decision, err = fm.RouteToolInvocation("pay.charge", "", &RequestContext{})
+	if err != nil || decision.SelectedAgent != "primary" {
+		t.Fatalf("Expected the recovered primary, got %+v, %v", decision, err)
+	}
+	if len(changes) != 2 || changes[1].Topic != standbyDemotedTopic {
+		t.Errorf("Expected a demotion event, got %+v", changes)
+	}
+	if len(fm.Standbys().Promotions()) != 0 {
+		t.Error("Standby should be demoted")
+	}
+}
+
+func TestCriticalRouteNeedsStandby(t *testing.T) {
+	fm := NewFederationManager(NewMCPRegistry(), nil)
+	for name, route := range map[string]ToolRoute{
+		"no standby":         {ToolPattern: "pay.*", PrimaryAgents: []string{"primary"}, Critical: true},
+		"no primary":         {ToolPattern: "pay.*", StandbyAgent: "standby", Critical: true},
+		"standby is primary": {ToolPattern: "pay.*", PrimaryAgents: []string{"primary"}, StandbyAgent: "primary", Critical: true},
+	} {
+		if err := fm.SetRoute(route); err == nil {
+			t.Errorf("%s: route should be rejected", name)
+		}
+	}
+}
@@ -0,0 +1,374 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/fep-fem/protocol"
+)
+
+// defaultStateSaveInterval is how often the broker snapshots its state to the state file
+const defaultStateSaveInterval = 30 * time.Second
+
+// BrokerState is the snapshot a broker persists so a restart does not start cold
+type BrokerState struct {
+	SavedAt    time.Time         `json:"savedAt"`
+	Agents     []PersistedAgent  `json:"agents"`
+	Metrics    []MetricsBaseline `json:"metrics,omitempty"`
+	Exclusions []ExcludedAgent   `json:"exclusions,omitempty"`
+	// Revoked capability tokens, restored before the broker serves anything
+	CapabilityRevocations []RevokedCapability `json:"capabilityRevocations,omitempty"`
+}
+
+// PersistedAgent is a registered agent and, for MCP agents, its tools
+type PersistedAgent struct {
+	ID              string                   `json:"id"`
+	PubKey          string                   `json:"pubkey"`
+	Capabilities    []string                 `json:"capabilities,omitempty"`
+	Endpoint        string                   `json:"endpoint,omitempty"`
+	RegisteredAt    time.Time                `json:"registeredAt"`
+	TrustScore      float64                  `json:"trustScore"`
+	Flagged         bool                     `json:"flagged,omitempty"`
+	Proto           string                   `json:"proto,omitempty"`
+	PreviousPubKey  string                   `json:"previousPubkey,omitempty"`
+	PreviousExpires time.Time                `json:"previousKeyExpires,omitempty"`
+	Location        *GeoLocation             `json:"location,omitempty"`
+	MCPEndpoint     string                   `json:"mcpEndpoint,omitempty"`
+	EnvironmentType string                   `json:"environmentType,omitempty"`
+	BodyDefinition  *protocol.BodyDefinition `json:"bodyDefinition,omitempty"`
+	Tools           []protocol.MCPTool       `json:"tools,omitempty"`
+	Instances       []AgentInstance          `json:"instances,omitempty"`
+}
+
+// MetricsBaseline is the part of an agent's metrics that routing decisions
+// depend on, restored so the load balancer does not treat every agent as new
+type MetricsBaseline struct {
+	AgentID             string            `json:"agentId"`
+	TotalRequests       int64             `json:"totalRequests"`
+	SuccessfulRequests  int64             `json:"successfulRequests"`
+	FailedRequests      int64             `json:"failedRequests"`
+	AverageResponseTime protocol.Duration `json:"averageResponseTime"`
+	ErrorRate           float64           `json:"errorRate"`
+	Availability        float64           `json:"availability"`
+	HealthScore         float64           `json:"healthScore"`
+	LoadScore           float64           `json:"loadScore"`
+	GeographicRegion    string            `json:"geographicRegion,omitempty"`
+	LastHealthCheck     time.Time         `json:"lastHealthCheck"`
+}
+
+// WarmupPhase describes how far a broker has rebuilt its state after starting
+type WarmupPhase string
+
+const (
+	WarmupReady   WarmupPhase = "ready"   // Nothing to restore, or restoring finished
+	WarmupWarming WarmupPhase = "warming" // Restoring agents, indexes and metrics in the background
+)
+
+// WarmupStatus reports the progress of rebuilding state after a restart
+type WarmupStatus struct {
+	Phase           WarmupPhase `json:"phase"`
+	AgentsTotal     int         `json:"agentsTotal"`
+	AgentsRestored  int         `json:"agentsRestored"`
+	AgentsSkipped   int         `json:"agentsSkipped"` // Re-registered live before their snapshot was restored
+	MetricsRestored int         `json:"metricsRestored"`
+	SnapshotAt      time.Time   `json:"snapshotAt,omitempty"`
+	StartedAt       time.Time   `json:"startedAt,omitempty"`
+	CompletedAt     time.Time   `json:"completedAt,omitempty"`
+}
+
+// warmupTracker guards a broker's WarmupStatus
+type warmupTracker struct {
+	mu     sync.RWMutex
+	status WarmupStatus
+	done   chan struct{}
+}
+
+func newWarmupTracker() *warmupTracker {
+	done := make(chan struct{})
+	close(done)
+	return &warmupTracker{status: WarmupStatus{Phase: WarmupReady}, done: done}
+}
+
+func (t *warmupTracker) update(change func(*WarmupStatus)) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	change(&t.status)
+}
+
+// Warmup reports whether the broker is still restoring state after a restart
+func (b *Broker) Warmup() WarmupStatus {
+	b.warmup.mu.RLock()
+	defer b.warmup.mu.RUnlock()
+	return b.warmup.status
+}
+
+// WarmupDone returns a channel closed once restored state is fully rebuilt
+func (b *Broker) WarmupDone() <-chan struct{} {
+	b.warmup.mu.RLock()
+	defer b.warmup.mu.RUnlock()
+	return b.warmup.done
+}
+
+// warming reports whether discovery results may still be missing restored agents
+func (b *Broker) warming() bool {
+	return b.Warmup().Phase == WarmupWarming
+}
+
+// SnapshotState captures registered agents, metrics baselines and routing exclusions
+func (b *Broker) SnapshotState() *BrokerState {
+	state := &BrokerState{SavedAt: time.Now()}
+
+	b.mu.RLock()
+	for _, agent := range b.agents {
+		persisted := PersistedAgent{
+			ID:           agent.ID,
+			PubKey:       agent.PubKey,
+			Capabilities: agent.Capabilities,
+			Endpoint:     agent.Endpoint,
+			RegisteredAt: agent.RegisteredAt,
+			TrustScore:   agent.TrustScore,
+			Flagged:      agent.Flagged,
+			Proto:        agent.Proto,
+			Location:     agent.Location,
+		}
+		if agent.PreviousPubKey != "" && time.Now().Before(agent.PreviousKeyExpires) {
+			persisted.PreviousPubKey = agent.PreviousPubKey
+			persisted.PreviousExpires = agent.PreviousKeyExpires
+		}
+		if mcpAgent, exists := b.mcpRegistry.GetAgent(agent.ID); exists {
+			persisted.MCPEndpoint = mcpAgent.MCPEndpoint
+			persisted.EnvironmentType = mcpAgent.EnvironmentType
+			persisted.BodyDefinition = mcpAgent.BodyDefinition
+			persisted.Tools = mcpAgent.Tools
+			persisted.Instances = b.mcpRegistry.Instances(agent.ID)
+		}
+		state.Agents = append(state.Agents, persisted)
+	}
+	b.mu.RUnlock()
+	sort.Slice(state.Agents, func(i, j int) bool { return state.Agents[i].ID < state.Agents[j].ID })
+
+	fm := b.federation
+	fm.metricsMutex.RLock()
+	for _, metrics := range fm.agentMetrics {
+		state.Metrics = append(state.Metrics, MetricsBaseline{
+			AgentID:             metrics.AgentID,
+			TotalRequests:       metrics.TotalRequests,
+			SuccessfulRequests:  metrics.SuccessfulRequests,
+			FailedRequests:      metrics.FailedRequests,
+			AverageResponseTime: protocol.Duration(metrics.AverageResponseTime),
+			ErrorRate:           metrics.ErrorRate,
+			Availability:        metrics.Availability,
+			HealthScore:         metrics.HealthScore,
+			LoadScore:           metrics.LoadScore,
+			GeographicRegion:    metrics.GeographicRegion,
+			LastHealthCheck:     metrics.LastHealthCheck,
+		})
+	}
+	fm.metricsMutex.RUnlock()
+	sort.Slice(state.Metrics, func(i, j int) bool { return state.Metrics[i].AgentID < state.Metrics[j].AgentID })
+
+	state.Exclusions = fm.exclusions.Excluded()
+	state.CapabilityRevocations = fm.capabilityRevocations.Entries()
+	return state
+}
+
+// SaveState writes a snapshot to path atomically
+func (b *Broker) SaveState(path string) error {
+	data, err := json.MarshalIndent(b.SnapshotState(), "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return err
+	}
+
+	// Write atomically so a crash never leaves a truncated snapshot
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		return fmt.Errorf("failed to save state: %w", err)
+	}
+	return os.Rename(tmp, path)
+}
+
+// StartStatePersistence saves a snapshot to path every interval
+func (b *Broker) StartStatePersistence(path string, interval time.Duration) {
+	if interval <= 0 {
+		return
+	}
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for range ticker.C {
+			// A snapshot taken mid-restore would drop agents not yet restored
+			if b.warming() {
+				continue
+			}
+			if err := b.SaveState(path); err != nil {
+				log.Printf("Failed to save broker state: %v", err)
+			}
+		}
+	}()
+}
+
+// LoadState reads a snapshot from path and rebuilds the broker's state from it
+// in the background. Until that finishes the broker reports itself warming and
+// marks discovery results partial. A missing file means a cold start.
+func (b *Broker) LoadState(path string) error {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read state: %w", err)
+	}
+	var state BrokerState
+	if err := json.Unmarshal(data, &state); err != nil {
+		return fmt.Errorf("invalid state file %s: %w", path, err)
+	}
+
+	// Revoked tokens must stay refused from the first request on
+	b.federation.capabilityRevocations.Merge(state.CapabilityRevocations)
+
+	done := make(chan struct{})
+	b.warmup.mu.Lock()
+	b.warmup.status = WarmupStatus{
+		Phase:       WarmupWarming,
+		AgentsTotal: len(state.Agents),
+		SnapshotAt:  state.SavedAt,
+		StartedAt:   time.Now(),
+	}
+	b.warmup.done = done
+	b.warmup.mu.Unlock()
+
+	go func() {
+		defer close(done)
+		b.backfill(&state)
+	}()
+	return nil
+}
+
+// backfill restores agents (which indexes their tools for semantic discovery),
+// then metrics baselines and routing exclusions for the load balancer. Agents
+// that re-registered while the broker was warming keep their live state.
+func (b *Broker) backfill(state *BrokerState) {
+	log.Printf("Restoring %d agents from snapshot taken %s", len(state.Agents), state.SavedAt.Format(time.RFC3339))
+
+	restored := make(map[string]bool, len(state.Agents))
+	for _, persisted := range state.Agents {
+		if b.restoreAgent(persisted) {
+			restored[persisted.ID] = true
+			b.warmup.update(func(s *WarmupStatus) { s.AgentsRestored++ })
+		} else {
+			b.warmup.update(func(s *WarmupStatus) { s.AgentsSkipped++ })
+		}
+	}
+
+	fm := b.federation
+	for _, baseline := range state.Metrics {
+		if !restored[baseline.AgentID] {
+			continue
+		}
+		fm.metricsMutex.Lock()
+		if _, live := fm.agentMetrics[baseline.AgentID]; !live {
+			fm.agentMetrics[baseline.AgentID] = &AgentMetrics{
+				AgentID:             baseline.AgentID,
+				TotalRequests:       baseline.TotalRequests,
+				SuccessfulRequests:  baseline.SuccessfulRequests,
+				FailedRequests:      baseline.FailedRequests,
+				AverageResponseTime: time.Duration(baseline.AverageResponseTime),
+				ErrorRate:           baseline.ErrorRate,
+				Availability:        baseline.Availability,
+				HealthScore:         baseline.HealthScore,
+				LoadScore:           baseline.LoadScore,
+				GeographicRegion:    baseline.GeographicRegion,
+				LastHealthCheck:     baseline.LastHealthCheck,
+				LastUpdated:         time.Now(),
+			}
+			b.warmup.update(func(s *WarmupStatus) { s.MetricsRestored++ })
+		}
+		fm.metricsMutex.Unlock()
+	}
+
+	for _, entry := range state.Exclusions {
+		if restored[entry.AgentID] {
+			fm.exclusions.Restore(entry)
+		}
+	}
+
+	b.warmup.update(func(s *WarmupStatus) {
+		s.Phase = WarmupReady
+		s.CompletedAt = time.Now()
+	})
+	status := b.Warmup()
+	log.Printf("Broker state restored: %d agents, %d skipped, %d metrics baselines in %s",
+		status.AgentsRestored, status.AgentsSkipped, status.MetricsRestored, status.CompletedAt.Sub(status.StartedAt))
+}
+
+// restoreAgent re-creates a persisted agent unless it has already registered
+// again since the broker started
+func (b *Broker) restoreAgent(persisted PersistedAgent) bool {
+	b.mu.Lock()
+	if _, live := b.agents[persisted.ID]; live {
+		b.mu.Unlock()
+		return false
+	}
+	b.agents[persisted.ID] = &Agent{
+		ID:           persisted.ID,
+		Capabilities: persisted.Capabilities,
+		Endpoint:     persisted.Endpoint,
+		PubKey:       persisted.PubKey,
+		RegisteredAt: persisted.RegisteredAt,
+		TrustScore:   persisted.TrustScore,
+		Flagged:      persisted.Flagged,
+		Proto:        persisted.Proto,
+		Location:     persisted.Location,
+
+		PreviousPubKey:     persisted.PreviousPubKey,
+		PreviousKeyExpires: persisted.PreviousExpires,
+	}
+	b.mu.Unlock()
+
+	if persisted.MCPEndpoint != "" {
+		// Registering notifies observers, which index the tools semantically
+		mcpAgent := &MCPAgent{
+			ID:              persisted.ID,
+			MCPEndpoint:     persisted.MCPEndpoint,
+			BodyDefinition:  persisted.BodyDefinition,
+			EnvironmentType: persisted.EnvironmentType,
+			Tools:           persisted.Tools,
+			LastHeartbeat:   time.Now(),
+		}
+		// Instances get a grace period to heartbeat, like the agent itself
+		if len(persisted.Instances) > 0 {
+			mcpAgent.Instances = make(map[string]*AgentInstance, len(persisted.Instances))
+			for _, instance := range persisted.Instances {
+				instance := instance
+				instance.LastHeartbeat = mcpAgent.LastHeartbeat
+				mcpAgent.Instances[instance.ID] = &instance
+			}
+			mcpAgent.LastStatus = aggregateStatus(mcpAgent.Instances)
+		}
+		err := b.mcpRegistry.RegisterAgent(persisted.ID, mcpAgent)
+		if err != nil {
+			log.Printf("Failed to restore MCP agent %s: %v", persisted.ID, err)
+		}
+	}
+	return true
+}
+
+// handleAdminWarmup reports state restoration progress
+func (b *Broker) handleAdminWarmup(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	writeJSON(w, http.StatusOK, b.Warmup())
+}
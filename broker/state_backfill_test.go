@@ -0,0 +1,121 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/fep-fem/protocol"
+)
+
+func TestStateRestoresAgentsIndexesAndMetrics(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state.json")
+
+	before := NewBroker()
+	pubKey, _, _ := protocol.GenerateKeyPair()
+	registerDiscoveryClient(before, "math", pubKey)
+	before.mcpRegistry.RegisterAgent("math", &MCPAgent{
+		ID:            "math",
+		MCPEndpoint:   "http://math:8080/mcp",
+		Tools:         []protocol.MCPTool{{Name: "math.add", Description: "add numbers"}},
+		LastHeartbeat: time.Now(),
+	})
+	registerDiscoveryClient(before, "live", pubKey)
+	before.federation.metricsMutex.Lock()
+	before.federation.agentMetrics["math"] = &AgentMetrics{AgentID: "math", TotalRequests: 42, HealthScore: 0.9}
+	before.federation.metricsMutex.Unlock()
+	before.federation.exclusions.Observe("math", AgentStatusUnhealthy)
+
+	if err := before.SaveState(path); err != nil {
+		t.Fatalf("SaveState failed: %v", err)
+	}
+
+	after := NewBroker()
+	// "live" re-registers before the snapshot is restored and keeps its live state
+	registerDiscoveryClient(after, "live", pubKey)
+	if err := after.LoadState(path); err != nil {
+		t.Fatalf("LoadState failed: %v", err)
+	}
+	select {
+	case <-after.WarmupDone():
+	case <-time.After(5 * time.Second):
+		t.Fatal("Warm-up did not finish")
+	}
+
+	status := after.Warmup()
+	if status.Phase != WarmupReady || status.AgentsTotal != 2 || status.AgentsRestored != 1 ||
+		status.AgentsSkipped != 1 || status.MetricsRestored != 1 {
+		t.Errorf("Unexpected warm-up status: %+v", status)
+	}
+
+	after.mu.RLock()
+	agent, restored := after.agents["math"]
+	after.mu.RUnlock()
+	if !restored || agent.PubKey != protocol.EncodePublicKey(pubKey) {
+		t.Fatalf("Agent was not restored with its key: %+v", agent)
+	}
+	tools, _ := after.mcpRegistry.DiscoverTools(protocol.ToolQuery{Capabilities: []string{"math.*"}})
+	if len(tools) != 1 {
+		t.Errorf("Expected the restored tool to be discoverable, got %d", len(tools))
+	}
+	if _, indexed := after.federation.semanticIndex.toolVectors["math/math.add"]; !indexed {
+		t.Error("Restored tool should be in the semantic index")
+	}
+	if metrics := after.federation.agentMetrics["math"]; metrics == nil || metrics.TotalRequests != 42 {
+		t.Errorf("Metrics baseline was not restored: %+v", metrics)
+	}
+	if !after.federation.exclusions.IsExcluded("math") {
+		t.Error("Routing exclusion was not restored")
+	}
+}
+
+func TestDiscoveryMarkedPartialWhileWarming(t *testing.T) {
+	broker := NewBroker()
+	if err := broker.LoadState(filepath.Join(t.TempDir(), "missing.json")); err != nil {
+		t.Fatalf("A missing state file should be a cold start: %v", err)
+	}
+	if broker.Warmup().Phase != WarmupReady {
+		t.Fatal("A cold start should be ready immediately")
+	}
+
+	pubKey, privKey, _ := protocol.GenerateKeyPair()
+	registerDiscoveryClient(broker, "client", pubKey)
+
+	discover := func() bool {
+		envelope := &protocol.DiscoverToolsEnvelope{
+			BaseEnvelope: protocol.BaseEnvelope{
+				Type: protocol.EnvelopeDiscoverTools,
+				CommonHeaders: protocol.CommonHeaders{
+					Agent: "client",
+					TS:    time.Now().UnixMilli(),
+					Nonce: fmt.Sprintf("discover-%d", time.Now().UnixNano()),
+				},
+			},
+			Body: protocol.DiscoverToolsBody{Query: protocol.ToolQuery{Capabilities: []string{"math.add"}}},
+		}
+		envelope.Sign(privKey)
+		data, _ := json.Marshal(envelope)
+
+		recorder := httptest.NewRecorder()
+		broker.ServeHTTP(recorder, httptest.NewRequest(http.MethodPost, "/", bytes.NewReader(data)))
+		result, _ := json.Marshal(readAck(t, recorder.Body).Result)
+		var discovered protocol.ToolsDiscoveredBody
+		if err := json.Unmarshal(result, &discovered); err != nil {
+			t.Fatalf("Failed to decode discovery result: %v", err)
+		}
+		return discovered.Partial
+	}
+
+	if discover() {
+		t.Error("Discovery should not be partial once ready")
+	}
+	broker.warmup.update(func(s *WarmupStatus) { s.Phase = WarmupWarming })
+	if !discover() {
+		t.Error("Discovery should be marked partial while warming")
+	}
+}
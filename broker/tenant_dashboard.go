@@ -0,0 +1,197 @@
+package main
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/fep-fem/protocol"
+)
+
+// dashboardPath serves the dashboard summary, and dashboardPath + "/metrics"
+// the agents' metrics history
+const dashboardPath = "/dashboard"
+
+// Dashboard is what the broker shows of the agents of one tenant, or of
+// every tenant in the global view. A tenant is the namespace of its agents'
+// IDs, as for budgets and audit records.
+type Dashboard struct {
+	Tenant      string              `json:"tenant,omitempty"` // Empty for the global view
+	GeneratedAt protocol.Time       `json:"generatedAt"`
+	Agents      []DashboardAgent    `json:"agents"`
+	Tools       []DashboardTool     `json:"tools"`
+	Usage       DashboardUsage      `json:"usage"`
+	Health      map[AgentStatus]int `json:"health"` // Agents by status
+}
+
+// DashboardAgent is one registered agent's health and tools
+type DashboardAgent struct {
+	AgentHealthStatus
+	Tools       []string `json:"tools"`
+	Quarantined bool     `json:"quarantined,omitempty"`
+}
+
+// DashboardTool is a tool and the agents providing it
+type DashboardTool struct {
+	Name      string   `json:"name"`
+	Providers []string `json:"providers"`
+}
+
+// DashboardUsage is what the agents were asked to do, and what their
+// tenants spent on calls this budget period
+type DashboardUsage struct {
+	Requests       int64          `json:"requests"`
+	FailedRequests int64          `json:"failedRequests"`
+	Spending       []TenantBudget `json:"spending"`
+}
+
+// SetTenantTokens gives namespace owners read access to the dashboard and
+// metrics of their own tenant, by bearer token
+func (b *Broker) SetTenantTokens(tokens map[string]string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.tenantTokens = tokens
+}
+
+// authorizeView returns the tenant a dashboard or metrics request may see:
+// the one named in its tenant parameter for the admin token, empty for the
+// global view, or the tenant a tenant token belongs to. On failure it writes
+// the error response itself.
+func (b *Broker) authorizeView(w http.ResponseWriter, r *http.Request) (string, bool) {
+	requested := r.URL.Query().Get("tenant")
+	if b.authorizeAdmin(r) {
+		return requested, true
+	}
+
+	presented := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	b.mu.RLock()
+	tenant := ""
+	for token, owner := range b.tenantTokens {
+		if subtle.ConstantTimeCompare([]byte(presented), []byte(token)) == 1 {
+			tenant = owner
+		}
+	}
+	b.mu.RUnlock()
+	if tenant == "" {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return "", false
+	}
+	if requested != "" && requested != tenant {
+		http.Error(w, fmt.Sprintf("Token only grants access to tenant %s", tenant), http.StatusForbidden)
+		return "", false
+	}
+	return tenant, true
+}
+
+// inTenant reports whether an agent belongs to the tenant; every agent
+// belongs to the global view
+func inTenant(agentID, tenant string) bool {
+	return tenant == "" || agentNamespace(agentID) == tenant
+}
+
+// Dashboard summarizes the registered agents of a tenant, or of every tenant
+// if tenant is empty
+func (b *Broker) Dashboard(tenant string) *Dashboard {
+	dashboard := &Dashboard{
+		Tenant:      tenant,
+		GeneratedAt: protocol.Time(time.Now()),
+		Agents:      []DashboardAgent{},
+		Tools:       []DashboardTool{},
+		Usage:       DashboardUsage{Spending: []TenantBudget{}},
+		Health:      make(map[AgentStatus]int),
+	}
+
+	b.mu.RLock()
+	agentIDs := make([]string, 0, len(b.agents))
+	for agentID := range b.agents {
+		if inTenant(agentID, tenant) {
+			agentIDs = append(agentIDs, agentID)
+		}
+	}
+	b.mu.RUnlock()
+	sort.Strings(agentIDs)
+
+	providers := make(map[string][]string)
+	toolsByAgent := make(map[string][]string)
+	for _, registered := range b.mcpRegistry.ListTools() {
+		if inTenant(registered.AgentID, tenant) {
+			providers[registered.Tool.Name] = append(providers[registered.Tool.Name], registered.AgentID)
+			toolsByAgent[registered.AgentID] = append(toolsByAgent[registered.AgentID], registered.Tool.Name)
+		}
+	}
+
+	fm := b.federation
+	health := fm.healthChecker.GetAgentHealthStatus(fm)
+	for _, agentID := range agentIDs {
+		status, checked := health[agentID]
+		if !checked {
+			status = &AgentHealthStatus{AgentID: agentID, Status: AgentStatusUnknown}
+		}
+		tools := toolsByAgent[agentID]
+		sort.Strings(tools)
+		if tools == nil {
+			tools = []string{}
+		}
+		dashboard.Agents = append(dashboard.Agents, DashboardAgent{
+			AgentHealthStatus: *status,
+			Tools:             tools,
+			Quarantined:       b.IsQuarantined(agentID),
+		})
+		dashboard.Health[status.Status]++
+		dashboard.Usage.Requests += status.TotalRequests
+		dashboard.Usage.FailedRequests += status.FailedRequests
+	}
+
+	for name, agents := range providers {
+		sort.Strings(agents)
+		dashboard.Tools = append(dashboard.Tools, DashboardTool{Name: name, Providers: agents})
+	}
+	sort.Slice(dashboard.Tools, func(i, j int) bool { return dashboard.Tools[i].Name < dashboard.Tools[j].Name })
+
+	for _, budget := range fm.Budgets().Budgets() {
+		if tenant == "" || budget.Tenant == tenant {
+			dashboard.Usage.Spending = append(dashboard.Usage.Spending, budget)
+		}
+	}
+	return dashboard
+}
+
+// handleDashboard serves the dashboard summary and metrics history to admins
+// and to namespace owners, who only see their own tenant
+func (b *Broker) handleDashboard(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	tenant, ok := b.authorizeView(w, r)
+	if !ok {
+		return
+	}
+	switch r.URL.Path {
+	case dashboardPath:
+		writeJSON(w, http.StatusOK, b.Dashboard(tenant))
+	case dashboardPath + "/metrics":
+		b.serveMetricsHistory(w, r, tenant)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// parseTenantTokens reads comma-separated tenant:token pairs into tenants by token
+func parseTenantTokens(spec string) (map[string]string, error) {
+	tokens := make(map[string]string)
+	for _, pair := range parseList(spec) {
+		tenant, token, ok := strings.Cut(pair, ":")
+		if !ok || tenant == "" || token == "" {
+			return nil, fmt.Errorf("%q is not tenant:token", pair)
+		}
+		if strings.Contains(tenant, ".") {
+			return nil, fmt.Errorf("tenant %q is not an agent ID namespace", tenant)
+		}
+		tokens[token] = tenant
+	}
+	return tokens, nil
+}
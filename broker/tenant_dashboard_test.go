@@ -0,0 +1,119 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/fep-fem/protocol"
+)
+
+func TestTenantDashboard(t *testing.T) {
+	broker := NewBroker()
+	broker.SetAdminToken("secret")
+	broker.SetTenantTokens(map[string]string{"acme-token": "acme"})
+	broker.federation.Budgets().Configure(map[string]float64{"acme": 1, "globex": 1}, time.Hour)
+	for _, agentID := range []string{"acme.worker", "globex.worker"} {
+		pubKey, _, _ := protocol.GenerateKeyPair()
+		registerDiscoveryClient(broker, agentID, pubKey)
+		broker.mcpRegistry.RegisterAgent(agentID, &MCPAgent{
+			ID:    agentID,
+			Tools: []protocol.MCPTool{{Name: "math.add"}},
+		})
+	}
+
+	get := func(path, token string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		recorder := httptest.NewRecorder()
+		broker.ServeHTTP(recorder, req)
+		return recorder
+	}
+	dashboard := func(path, token string) *Dashboard {
+		t.Helper()
+		recorder := get(path, token)
+		var dashboard Dashboard
+		if err := json.NewDecoder(recorder.Body).Decode(&dashboard); recorder.Code != http.StatusOK || err != nil {
+			t.Fatalf("Dashboard %s returned %d: %v", path, recorder.Code, err)
+		}
+		return &dashboard
+	}
+	agents := func(dashboard *Dashboard) []string {
+		var ids []string
+		for _, agent := range dashboard.Agents {
+			ids = append(ids, agent.AgentID)
+		}
+		return ids
+	}
+
+	owned := dashboard("/dashboard", "acme-token")
+	if ids := agents(owned); owned.Tenant != "acme" || len(ids) != 1 || ids[0] != "acme.worker" {
+		t.Errorf("Expected only the acme agent, got %q %v", owned.Tenant, ids)
+	}
+	if len(owned.Tools) != 1 || len(owned.Tools[0].Providers) != 1 || owned.Tools[0].Providers[0] != "acme.worker" {
+		t.Errorf("Expected math.add provided by acme.worker only, got %+v", owned.Tools)
+	}
+	if len(owned.Usage.Spending) != 1 || owned.Usage.Spending[0].Tenant != "acme" {
+		t.Errorf("Expected only acme spending, got %+v", owned.Usage.Spending)
+	}
+	if owned.Health[AgentStatusUnknown] != 1 {
+		t.Errorf("Expected the unchecked agent counted as unknown, got %v", owned.Health)
+	}
+
+	if ids := agents(dashboard("/dashboard", "secret")); len(ids) != 2 {
+		t.Errorf("Expected the global view to show both agents, got %v", ids)
+	}
+	if ids := agents(dashboard("/dashboard?tenant=globex", "secret")); len(ids) != 1 || ids[0] != "globex.worker" {
+		t.Errorf("Expected admins to view any tenant, got %v", ids)
+	}
+
+	if resp := get("/dashboard?tenant=globex", "acme-token"); resp.Code != http.StatusForbidden {
+		t.Errorf("Expected 403 for another tenant, got %d", resp.Code)
+	}
+	if resp := get("/dashboard", ""); resp.Code != http.StatusUnauthorized {
+		t.Errorf("Expected 401 without a token, got %d", resp.Code)
+	}
+	if resp := get("/dashboard/metrics?agent=globex.worker", "acme-token"); resp.Code != http.StatusNotFound {
+		t.Errorf("Expected another tenant's agent history to be hidden, got %d", resp.Code)
+	}
+}
+
+func TestTenantMetrics(t *testing.T) {
+	broker := NewBroker()
+	broker.SetAdminToken("secret")
+	broker.SetTenantTokens(map[string]string{"acme-token": "acme"})
+	broker.federation.updateRoutingMetrics("math.add", "acme.worker", nil)
+	broker.federation.updateRoutingMetrics("math.add", "globex.worker", nil)
+
+	req := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+	req.Header.Set("Authorization", "Bearer acme-token")
+	recorder := httptest.NewRecorder()
+	broker.ServeHTTP(recorder, req)
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("Prometheus endpoint returned %d", recorder.Code)
+	}
+	body := recorder.Body.String()
+	if !strings.Contains(body, `fem_agent_requests_total{agent="acme.worker"} 1`) {
+		t.Errorf("Expected the tenant's own agent, got:\n%s", body)
+	}
+	if strings.Contains(body, "globex.worker") || strings.Contains(body, "fem_worker_") {
+		t.Errorf("Expected other tenants and broker internals hidden, got:\n%s", body)
+	}
+}
+
+func TestParseTenantTokens(t *testing.T) {
+	tokens, err := parseTenantTokens("acme:a1, globex:g1")
+	if err != nil || tokens["a1"] != "acme" || tokens["g1"] != "globex" {
+		t.Errorf("Unexpected tokens %v: %v", tokens, err)
+	}
+	for _, spec := range []string{"acme", "acme:", ":a1", "acme.eu:a1"} {
+		if _, err := parseTenantTokens(spec); err == nil {
+			t.Errorf("Expected %q to be refused", spec)
+		}
+	}
+}
@@ -0,0 +1,179 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/fep-fem/protocol"
+)
+
+// maxCatalogSize bounds the catalog feeds pulled from peers
+const maxCatalogSize = 8 << 20
+
+// ToolCatalog returns the broker's signed catalog feed: the tools agents
+// marked listed, with their prices and the broker's trust metrics for the
+// agents. Quarantined and flagged agents are left out.
+func (b *Broker) ToolCatalog() (*protocol.ToolCatalog, error) {
+	signer := b.IdentityKey()
+	publicKey, err := protocol.SignerPublicKey(signer)
+	if err != nil {
+		return nil, err
+	}
+
+	byAgent := make(map[string]*protocol.DiscoveredTool)
+	for _, registered := range b.mcpRegistry.ListTools() {
+		if !registered.Tool.Listed || b.IsQuarantined(registered.AgentID) {
+			continue
+		}
+		listing, exists := byAgent[registered.AgentID]
+		if !exists {
+			b.mu.RLock()
+			agent, known := b.agents[registered.AgentID]
+			trusted := known && !agent.Flagged
+			trustScore := 0.0
+			if known {
+				trustScore = agent.TrustScore
+			}
+			b.mu.RUnlock()
+			if known && !trusted {
+				continue
+			}
+			listing = &protocol.DiscoveredTool{
+				AgentID:         registered.AgentID,
+				EnvironmentType: registered.EnvironmentType,
+				Metadata:        protocol.ToolMetadata{TrustScore: trustScore},
+			}
+			byAgent[registered.AgentID] = listing
+		}
+		listing.MCPTools = append(listing.MCPTools, registered.Tool)
+		listing.Capabilities = append(listing.Capabilities, registered.Tool.Name)
+		if lastSeen := registered.LastSeen.UnixMilli(); lastSeen > listing.Metadata.LastSeen {
+			listing.Metadata.LastSeen = lastSeen
+		}
+	}
+
+	tools := make([]protocol.DiscoveredTool, 0, len(byAgent))
+	for _, listing := range byAgent {
+		sort.Slice(listing.MCPTools, func(i, j int) bool { return listing.MCPTools[i].Name < listing.MCPTools[j].Name })
+		sort.Strings(listing.Capabilities)
+		tools = append(tools, *listing)
+	}
+	sort.Slice(tools, func(i, j int) bool { return tools[i].AgentID < tools[j].AgentID })
+	b.federation.annotateToolMetadata(tools)
+
+	catalog := &protocol.ToolCatalog{
+		Broker: b.federation.config.LocalBrokerID,
+		PubKey: protocol.EncodePublicKey(publicKey),
+		Issued: time.Now().UnixMilli(),
+		Tools:  tools,
+	}
+	if err := catalog.Sign(signer); err != nil {
+		return nil, err
+	}
+	return catalog, nil
+}
+
+// handleToolCatalog serves the signed catalog feed
+func (b *Broker) handleToolCatalog(w http.ResponseWriter, r *http.Request) {
+	catalog, err := b.ToolCatalog()
+	if err != nil {
+		log.Printf("Failed to build tool catalog: %v", err)
+		http.Error(w, "Failed to build tool catalog", http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, http.StatusOK, catalog)
+}
+
+// ImportCatalog makes a peer's listed tools discoverable, and routable as its
+// trust tier allows, as references to tools reached through the peer. The
+// catalog must be signed with the identity key the peer proved when it
+// registered, and be newer than the one imported before. It returns how
+// many tools were imported.
+func (fm *FederationManager) ImportCatalog(catalog *protocol.ToolCatalog) (int, error) {
+	fm.topologyMutex.RLock()
+	peer, exists := fm.federatedBrokers[catalog.Broker]
+	var endpoint string
+	verified := false
+	if exists {
+		endpoint = peer.Endpoint
+		verified = peer.IdentityVerified && peer.PublicKey == catalog.PubKey
+	}
+	previous := fm.catalogsIssued[catalog.Broker]
+	fm.topologyMutex.RUnlock()
+
+	if !exists {
+		return 0, fmt.Errorf("unknown broker: %s", catalog.Broker)
+	}
+	if !verified {
+		return 0, fmt.Errorf("catalog of %s is not signed with its verified identity key", catalog.Broker)
+	}
+	publicKey, err := protocol.DecodePublicKey(catalog.PubKey)
+	if err != nil {
+		return 0, err
+	}
+	if err := catalog.Verify(publicKey); err != nil {
+		return 0, err
+	}
+	if catalog.Issued <= previous {
+		return 0, fmt.Errorf("catalog of %s is not newer than the one imported", catalog.Broker)
+	}
+
+	tools := make([]protocol.DiscoveredTool, 0, len(catalog.Tools))
+	imported := 0
+	for _, listing := range catalog.Tools {
+		var listed []protocol.MCPTool
+		for _, tool := range listing.MCPTools {
+			if tool.Listed {
+				listed = append(listed, tool)
+			}
+		}
+		if len(listed) == 0 {
+			continue
+		}
+		// Callers reach the tools through the publishing broker
+		listing.MCPEndpoint = endpoint
+		listing.MCPTools = listed
+		tools = append(tools, listing)
+		imported += len(listed)
+	}
+	if err := fm.UpdatePeerCatalog(catalog.Broker, tools); err != nil {
+		return 0, err
+	}
+
+	fm.topologyMutex.Lock()
+	fm.catalogsIssued[catalog.Broker] = catalog.Issued
+	fm.topologyMutex.Unlock()
+	return imported, nil
+}
+
+// syncPeerCatalogs imports the catalog feeds of trusted peers
+func (fm *FederationManager) syncPeerCatalogs() {
+	client := peerSyncClient()
+	for _, peer := range fm.syncablePeers() {
+		resp, err := client.Get(peer.Endpoint + protocol.ToolCatalogPath)
+		if err != nil {
+			log.Printf("Catalog sync with %s failed: %v", peer.ID, err)
+			continue
+		}
+
+		var catalog protocol.ToolCatalog
+		err = json.NewDecoder(http.MaxBytesReader(nil, resp.Body, maxCatalogSize)).Decode(&catalog)
+		resp.Body.Close()
+		if err != nil {
+			log.Printf("Invalid catalog from %s: %v", peer.ID, err)
+			continue
+		}
+		if catalog.Broker != peer.ID {
+			log.Printf("Catalog from %s is published by %s", peer.ID, catalog.Broker)
+			continue
+		}
+
+		if _, err := fm.ImportCatalog(&catalog); err != nil {
+			log.Printf("Catalog of %s not imported: %v", peer.ID, err)
+		}
+	}
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/fep-fem/protocol"
+)
+
+// catalogBroker returns a broker with one listed and one unlisted tool
+func catalogBroker(brokerID string) *Broker {
+	broker := NewBroker()
+	broker.federation.config.LocalBrokerID = brokerID
+	broker.mcpRegistry.RegisterAgent("translator", &MCPAgent{
+		ID: "translator",
+		Tools: []protocol.MCPTool{
+			{Name: "text.translate", Listed: true, Cost: &protocol.ToolCost{PerCall: 0.02}},
+			{Name: "text.internal"},
+		},
+	})
+	return broker
+}
+
+// peerWith registers the publisher as a peer of the importer under a tier
+func peerWith(t *testing.T, importer, publisher *Broker, endpoint string, tier PeerTrustTier) {
+	t.Helper()
+	descriptor, err := publisher.BrokerDescriptor(endpoint)
+	if err != nil {
+		t.Fatalf("Failed to build descriptor: %v", err)
+	}
+	data, _ := json.Marshal(descriptor)
+	env, err := protocol.ParseEnvelope(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := importer.federation.AddFederatedBroker(env, tier); err != nil {
+		t.Fatalf("Failed to add peer: %v", err)
+	}
+}
+
+func TestToolCatalogListsOnlyListedTools(t *testing.T) {
+	broker := catalogBroker("broker-a")
+	broker.mcpRegistry.RegisterAgent("private", &MCPAgent{
+		ID:    "private",
+		Tools: []protocol.MCPTool{{Name: "db.query"}},
+	})
+
+	recorder := httptest.NewRecorder()
+	broker.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, protocol.ToolCatalogPath, nil))
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("Expected the catalog served, got %d", recorder.Code)
+	}
+	var catalog protocol.ToolCatalog
+	if err := json.NewDecoder(recorder.Body).Decode(&catalog); err != nil {
+		t.Fatal(err)
+	}
+
+	if catalog.Broker != "broker-a" || len(catalog.Tools) != 1 {
+		t.Fatalf("Expected one listing from broker-a, got %+v", catalog)
+	}
+	listing := catalog.Tools[0]
+	if listing.AgentID != "translator" || len(listing.MCPTools) != 1 || listing.MCPTools[0].Name != "text.translate" {
+		t.Errorf("Expected only the listed tool, got %+v", listing)
+	}
+	if listing.MCPTools[0].Cost == nil || listing.MCPTools[0].Cost.PerCall != 0.02 {
+		t.Errorf("Expected the listing priced, got %+v", listing.MCPTools[0].Cost)
+	}
+	publicKey, _ := protocol.SignerPublicKey(broker.IdentityKey())
+	if err := catalog.Verify(publicKey); err != nil {
+		t.Errorf("Expected the catalog signed with the identity key: %v", err)
+	}
+
+	broker.Quarantine("translator", QuarantineOperator, "test")
+	if catalog, _ := broker.ToolCatalog(); len(catalog.Tools) != 0 {
+		t.Errorf("Expected quarantined agents left out, got %+v", catalog.Tools)
+	}
+}
+
+func TestImportPeerCatalog(t *testing.T) {
+	publisher := catalogBroker("broker-a")
+	server := httptest.NewServer(publisher)
+	defer server.Close()
+
+	importer := NewBroker()
+	peerWith(t, importer, publisher, server.URL, PeerTrustDiscovery)
+	importer.federation.syncPeerCatalogs()
+
+	tools := importer.federation.DiscoverRemoteTools(protocol.ToolQuery{Capabilities: []string{"text.*"}})
+	if len(tools) != 1 || len(tools[0].MCPTools) != 1 || tools[0].MCPTools[0].Name != "text.translate" {
+		t.Fatalf("Expected the listed tool imported, got %+v", tools)
+	}
+	if tools[0].MCPEndpoint != server.URL {
+		t.Errorf("Expected the tool reached through its publisher, got %q", tools[0].MCPEndpoint)
+	}
+
+	// Replayed feeds are refused
+	catalog, _ := publisher.ToolCatalog()
+	catalog.Issued = 1
+	catalog.Sign(publisher.IdentityKey())
+	if _, err := importer.federation.ImportCatalog(catalog); err == nil {
+		t.Error("Expected an older catalog refused")
+	}
+}
+
+func TestImportCatalogRequiresPeerIdentity(t *testing.T) {
+	publisher := catalogBroker("broker-a")
+	importer := NewBroker()
+
+	catalog, err := publisher.ToolCatalog()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := importer.federation.ImportCatalog(catalog); err == nil {
+		t.Error("Expected a catalog from an unknown broker refused")
+	}
+
+	peerWith(t, importer, publisher, "https://a.example", PeerTrustFull)
+	tampered := *catalog
+	tampered.Tools = append([]protocol.DiscoveredTool(nil), catalog.Tools...)
+	tampered.Tools[0].Metadata.TrustScore = 1
+	if _, err := importer.federation.ImportCatalog(&tampered); err == nil {
+		t.Error("Expected a tampered catalog refused")
+	}
+
+	impostor := catalogBroker("broker-a")
+	forged, _ := impostor.ToolCatalog()
+	if _, err := importer.federation.ImportCatalog(forged); err == nil {
+		t.Error("Expected a catalog signed with another key refused")
+	}
+
+	if imported, err := importer.federation.ImportCatalog(catalog); err != nil || imported != 1 {
+		t.Errorf("Expected the genuine catalog imported, got %d: %v", imported, err)
+	}
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/fep-fem/protocol"
+)
+
+// latencyWindow is how many recent response times are kept for percentiles
+const latencyWindow = 100
+
+// recordLatency adds a response time to the percentile window
+func (m *AgentMetrics) recordLatency(d time.Duration) {
+	if len(m.recentLatencies) < latencyWindow {
+		m.recentLatencies = append(m.recentLatencies, d)
+		return
+	}
+	m.recentLatencies[m.latencyIndex] = d
+	m.latencyIndex = (m.latencyIndex + 1) % latencyWindow
+}
+
+// recordError remembers the most recent failure
+func (m *AgentMetrics) recordError(message string) {
+	m.LastErrorAt = time.Now()
+	m.LastErrorMessage = message
+}
+
+// P95ResponseTime returns the 95th percentile of recent response times
+func (m *AgentMetrics) P95ResponseTime() time.Duration {
+	if len(m.recentLatencies) == 0 {
+		return 0
+	}
+	sorted := append([]time.Duration(nil), m.recentLatencies...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	index := (len(sorted)*95+99)/100 - 1
+	return sorted[index]
+}
+
+// SuccessRate returns the fraction of checks that succeeded, or 0 before any check
+func (m *AgentMetrics) SuccessRate() float64 {
+	total := m.SuccessfulRequests + m.FailedRequests
+	if total == 0 {
+		return 0
+	}
+	return float64(m.SuccessfulRequests) / float64(total)
+}
+
+// RecordToolError records a failed tool result reported by an agent
+func (fm *FederationManager) RecordToolError(agentID, toolName, message string) {
+	fm.metricsMutex.Lock()
+	defer fm.metricsMutex.Unlock()
+
+	metrics, exists := fm.agentMetrics[agentID]
+	if !exists {
+		metrics = &AgentMetrics{AgentID: agentID}
+		fm.agentMetrics[agentID] = metrics
+	}
+	metrics.recordError(fmt.Sprintf("%s: %s", toolName, message))
+	metrics.LastUpdated = time.Now()
+}
+
+// annotateToolMetadata fills discovered tools' metadata from the broker's
+// metrics for their agents. Agents without metrics keep the registry defaults.
+func (fm *FederationManager) annotateToolMetadata(tools []protocol.DiscoveredTool) {
+	fm.metricsMutex.RLock()
+	defer fm.metricsMutex.RUnlock()
+
+	for i := range tools {
+		metrics, exists := fm.agentMetrics[tools[i].AgentID]
+		if !exists {
+			continue
+		}
+
+		metadata := &tools[i].Metadata
+		if metrics.AverageResponseTime > 0 {
+			metadata.AverageResponseTime = int(metrics.AverageResponseTime / time.Millisecond)
+		}
+		metadata.P95ResponseTime = int(metrics.P95ResponseTime() / time.Millisecond)
+		metadata.SuccessRate = metrics.SuccessRate()
+		metadata.TotalInvocations = metrics.TotalRequests
+		if !metrics.LastErrorAt.IsZero() {
+			metadata.LastErrorAt = metrics.LastErrorAt.UnixMilli()
+			metadata.LastErrorMessage = metrics.LastErrorMessage
+		}
+	}
+}
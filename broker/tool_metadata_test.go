@@ -0,0 +1,72 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/fep-fem/protocol"
+)
+
+func TestDiscoveryMetadataFromAgentMetrics(t *testing.T) {
+	registry := NewMCPRegistry()
+	registry.RegisterAgent("measured", &MCPAgent{
+		ID:            "measured",
+		Tools:         []protocol.MCPTool{{Name: "math.add"}},
+		LastHeartbeat: time.Now(),
+	})
+	registry.RegisterAgent("fresh", &MCPAgent{
+		ID:            "fresh",
+		Tools:         []protocol.MCPTool{{Name: "math.mul"}},
+		LastHeartbeat: time.Now(),
+	})
+	fm := NewFederationManager(registry, nil)
+
+	metrics := &AgentMetrics{
+		AgentID:             "measured",
+		TotalRequests:       42,
+		SuccessfulRequests:  9,
+		FailedRequests:      1,
+		AverageResponseTime: 20 * time.Millisecond,
+	}
+	for i := 1; i <= 100; i++ {
+		metrics.recordLatency(time.Duration(i) * time.Millisecond)
+	}
+	fm.agentMetrics["measured"] = metrics
+	fm.RecordToolError("measured", "math.add", "division by zero")
+
+	tools, _ := registry.DiscoverTools(protocol.ToolQuery{})
+	fm.annotateToolMetadata(tools)
+
+	for _, tool := range tools {
+		metadata := tool.Metadata
+		switch tool.AgentID {
+		case "measured":
+			if metadata.TotalInvocations != 42 || metadata.SuccessRate != 0.9 {
+				t.Errorf("Expected 42 invocations at 0.9 success, got %+v", metadata)
+			}
+			if metadata.AverageResponseTime != 20 || metadata.P95ResponseTime != 95 {
+				t.Errorf("Expected 20ms average and 95ms p95, got %+v", metadata)
+			}
+			if metadata.LastErrorAt == 0 || metadata.LastErrorMessage != "math.add: division by zero" {
+				t.Errorf("Expected last error to be reported, got %+v", metadata)
+			}
+		case "fresh":
+			if metadata.TotalInvocations != 0 || metadata.LastErrorMessage != "" {
+				t.Errorf("Agent without metrics should keep defaults, got %+v", metadata)
+			}
+		}
+	}
+}
+
+func TestLatencyWindowKeepsRecentSamples(t *testing.T) {
+	metrics := &AgentMetrics{}
+	for i := 0; i < latencyWindow; i++ {
+		metrics.recordLatency(time.Second)
+	}
+	for i := 0; i < latencyWindow; i++ {
+		metrics.recordLatency(time.Millisecond)
+	}
+	if p95 := metrics.P95ResponseTime(); p95 != time.Millisecond {
+		t.Errorf("Old samples should have been replaced, got p95 %v", p95)
+	}
+}
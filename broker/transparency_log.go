@@ -0,0 +1,388 @@
+package main
+
+import (
+	"bufio"
+	"crypto/ed25519"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fep-fem/protocol"
+)
+
+// transparencyPathPrefix is the URL prefix for the public audit API
+const transparencyPathPrefix = "/federation/transparency/"
+
+// maxTransparencyEntriesPerRequest bounds a single entries response
+const maxTransparencyEntriesPerRequest = 1000
+
+// maxTransparencyLeafSize bounds one entry read back from a persisted log
+const maxTransparencyLeafSize = 16 << 20
+
+// TransparencyEvent is the kind of event recorded in the transparency log
+type TransparencyEvent string
+
+const (
+	TransparencyRegistration TransparencyEvent = "registration"
+	TransparencyRevocation   TransparencyEvent = "revocation"
+)
+
+// TransparencyEntry is a single logged registration or revocation
+type TransparencyEntry struct {
+	Event    TransparencyEvent `json:"event"`
+	AgentID  string            `json:"agentId"`
+	BrokerID string            `json:"brokerId"`
+	LoggedAt int64             `json:"loggedAt"`
+	Envelope json.RawMessage   `json:"envelope,omitempty"`
+}
+
+// TransparencyLogEntry is an entry as served to auditors. The leaf hash is
+// protocol.MerkleLeafHash over the exact bytes of Leaf.
+type TransparencyLogEntry struct {
+	Index uint64          `json:"index"`
+	Leaf  json.RawMessage `json:"leaf"`
+}
+
+// TransparencyLog is an append-only Merkle log of registration and revocation events
+type TransparencyLog struct {
+	logID  string
+	signer protocol.Signer
+	leaves [][]byte
+	hashes [][]byte
+	latest *protocol.SignedTreeHead
+	file   *os.File // Leaves are appended here, one per line, once persisted
+	mu     sync.RWMutex
+}
+
+// NewTransparencyLog creates an empty log that signs tree heads with the given key
+func NewTransparencyLog(logID string, signer protocol.Signer) *TransparencyLog {
+	return &TransparencyLog{
+		logID:  logID,
+		signer: signer,
+	}
+}
+
+// TransparencyLog returns the broker's audit log
+func (fm *FederationManager) TransparencyLog() *TransparencyLog {
+	return fm.transparencyLog
+}
+
+// SigningKey returns the broker identity key used for tree heads
+func (fm *FederationManager) SigningKey() protocol.Signer {
+	return fm.transparencyLog.signingKey()
+}
+
+// SetSigningKey replaces the broker identity key; later tree heads are signed with it
+func (fm *FederationManager) SetSigningKey(signer protocol.Signer) {
+	fm.transparencyLog.mu.Lock()
+	defer fm.transparencyLog.mu.Unlock()
+	fm.transparencyLog.signer = signer
+	fm.transparencyLog.latest = nil
+}
+
+// PublicKey returns the key auditors use to verify signed tree heads
+func (l *TransparencyLog) PublicKey() ed25519.PublicKey {
+	return l.signingKey().Public().(ed25519.PublicKey)
+}
+
+func (l *TransparencyLog) signingKey() protocol.Signer {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	return l.signer
+}
+
+// Persist restores the log from the file at path, creating it if missing, and
+// appends every later entry to it. It must be called before entries are added.
+func (l *TransparencyLog) Persist(path string) error {
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		return err
+	}
+
+	var leaves, hashes [][]byte
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), maxTransparencyLeafSize)
+	for scanner.Scan() {
+		leaf := append([]byte(nil), scanner.Bytes()...)
+		if !json.Valid(leaf) {
+			file.Close()
+			return fmt.Errorf("%s: entry %d is not valid JSON", path, len(leaves))
+		}
+		leaves = append(leaves, leaf)
+		hashes = append(hashes, protocol.MerkleLeafHash(leaf))
+	}
+	if err := scanner.Err(); err != nil {
+		file.Close()
+		return fmt.Errorf("%s: %w", path, err)
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if len(l.leaves) > 0 || l.file != nil {
+		file.Close()
+		return fmt.Errorf("transparency log already holds entries")
+	}
+	l.leaves, l.hashes, l.file = leaves, hashes, file
+	return nil
+}
+
+// Close stops persisting the log
+func (l *TransparencyLog) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.file == nil {
+		return nil
+	}
+	err := l.file.Close()
+	l.file = nil
+	return err
+}
+
+// Append adds an entry to the log and returns its index
+func (l *TransparencyLog) Append(entry TransparencyEntry) (uint64, error) {
+	leaf, err := json.Marshal(entry)
+	if err != nil {
+		return 0, fmt.Errorf("failed to encode transparency entry: %w", err)
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	// A persisted log only grows in memory once the entry is on disk
+	if l.file != nil {
+		if _, err := l.file.Write(append(leaf, '\n')); err != nil {
+			return 0, fmt.Errorf("failed to persist transparency entry: %w", err)
+		}
+	}
+	l.leaves = append(l.leaves, leaf)
+	l.hashes = append(l.hashes, protocol.MerkleLeafHash(leaf))
+	return uint64(len(l.leaves) - 1), nil
+}
+
+// Size returns the number of entries in the log
+func (l *TransparencyLog) Size() uint64 {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	return uint64(len(l.hashes))
+}
+
+// PublishTreeHead signs a new tree head over the current log contents
+func (l *TransparencyLog) PublishTreeHead() *protocol.SignedTreeHead {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	size := uint64(len(l.hashes))
+	if l.latest != nil && l.latest.TreeSize == size {
+		return l.latest
+	}
+
+	sth := &protocol.SignedTreeHead{
+		LogID:     l.logID,
+		TreeSize:  size,
+		RootHash:  merkleRoot(l.hashes),
+		Timestamp: time.Now().UnixMilli(),
+	}
+	if err := sth.Sign(l.signer); err != nil {
+		// Served unsigned so auditors see the failure; the next call retries
+		log.Printf("Failed to sign tree head: %v", err)
+		return sth
+	}
+	l.latest = sth
+	return sth
+}
+
+// LatestTreeHead returns the most recently published tree head, publishing one if none exists
+func (l *TransparencyLog) LatestTreeHead() *protocol.SignedTreeHead {
+	l.mu.RLock()
+	latest := l.latest
+	l.mu.RUnlock()
+
+	if latest == nil {
+		return l.PublishTreeHead()
+	}
+	return latest
+}
+
+// Entries returns log entries in the range [start, end)
+func (l *TransparencyLog) Entries(start, end uint64) ([]TransparencyLogEntry, error) {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	size := uint64(len(l.leaves))
+	if end > size {
+		end = size
+	}
+	if start > end {
+		return nil, fmt.Errorf("invalid entry range %d-%d", start, end)
+	}
+	if end-start > maxTransparencyEntriesPerRequest {
+		end = start + maxTransparencyEntriesPerRequest
+	}
+
+	entries := make([]TransparencyLogEntry, 0, end-start)
+	for i := start; i < end; i++ {
+		entries = append(entries, TransparencyLogEntry{Index: i, Leaf: l.leaves[i]})
+	}
+	return entries, nil
+}
+
+// InclusionProof proves that the entry at index is included in the tree of the given size
+func (l *TransparencyLog) InclusionProof(index, size uint64) (*protocol.InclusionProof, error) {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	if size > uint64(len(l.hashes)) {
+		return nil, fmt.Errorf("tree size %d exceeds log size %d", size, len(l.hashes))
+	}
+	if index >= size {
+		return nil, fmt.Errorf("index %d outside tree of size %d", index, size)
+	}
+
+	return &protocol.InclusionProof{
+		LeafIndex: index,
+		TreeSize:  size,
+		AuditPath: merklePath(index, l.hashes[:size]),
+	}, nil
+}
+
+// ConsistencyProof proves that the tree of size second extends the tree of size first
+func (l *TransparencyLog) ConsistencyProof(first, second uint64) (*protocol.ConsistencyProof, error) {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	if second > uint64(len(l.hashes)) {
+		return nil, fmt.Errorf("tree size %d exceeds log size %d", second, len(l.hashes))
+	}
+	if first > second {
+		return nil, fmt.Errorf("first tree size %d exceeds second %d", first, second)
+	}
+
+	proof := &protocol.ConsistencyProof{First: first, Second: second, Path: [][]byte{}}
+	if first > 0 && first < second {
+		proof.Path = merkleSubproof(first, l.hashes[:second], true)
+	}
+	return proof, nil
+}
+
+// merkleRoot computes the RFC 6962 tree hash over leaf hashes
+func merkleRoot(hashes [][]byte) []byte {
+	switch len(hashes) {
+	case 0:
+		return protocol.EmptyMerkleRoot()
+	case 1:
+		return hashes[0]
+	}
+	k := splitPoint(uint64(len(hashes)))
+	return protocol.MerkleNodeHash(merkleRoot(hashes[:k]), merkleRoot(hashes[k:]))
+}
+
+// merklePath computes the RFC 6962 audit path for leaf m
+func merklePath(m uint64, hashes [][]byte) [][]byte {
+	if len(hashes) <= 1 {
+		return [][]byte{}
+	}
+	k := splitPoint(uint64(len(hashes)))
+	if m < k {
+		return append(merklePath(m, hashes[:k]), merkleRoot(hashes[k:]))
+	}
+	return append(merklePath(m-k, hashes[k:]), merkleRoot(hashes[:k]))
+}
+
+// merkleSubproof computes the RFC 6962 consistency proof for the first m leaves
+func merkleSubproof(m uint64, hashes [][]byte, complete bool) [][]byte {
+	n := uint64(len(hashes))
+	if m == n {
+		if complete {
+			return [][]byte{}
+		}
+		return [][]byte{merkleRoot(hashes)}
+	}
+	k := splitPoint(n)
+	if m <= k {
+		return append(merkleSubproof(m, hashes[:k], complete), merkleRoot(hashes[k:]))
+	}
+	return append(merkleSubproof(m-k, hashes[k:], false), merkleRoot(hashes[:k]))
+}
+
+// splitPoint returns the largest power of two smaller than n
+func splitPoint(n uint64) uint64 {
+	k := uint64(1)
+	for k<<1 < n {
+		k <<= 1
+	}
+	return k
+}
+
+// handleTransparency serves signed tree heads, entries and proofs to auditors
+func (b *Broker) handleTransparency(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	tlog := b.federation.TransparencyLog()
+	query := r.URL.Query()
+
+	switch strings.TrimPrefix(r.URL.Path, transparencyPathPrefix) {
+	case "sth":
+		writeJSON(w, http.StatusOK, map[string]interface{}{
+			"sth":    tlog.LatestTreeHead(),
+			"pubkey": protocol.EncodePublicKey(tlog.PublicKey()),
+		})
+	case "entries":
+		start, err1 := parseUintParam(query.Get("start"), 0)
+		end, err2 := parseUintParam(query.Get("end"), tlog.Size())
+		if err1 != nil || err2 != nil {
+			http.Error(w, "Invalid entry range", http.StatusBadRequest)
+			return
+		}
+		entries, err := tlog.Entries(start, end)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		writeJSON(w, http.StatusOK, entries)
+	case "proof/inclusion":
+		index, err1 := parseUintParam(query.Get("index"), 0)
+		size, err2 := parseUintParam(query.Get("size"), tlog.LatestTreeHead().TreeSize)
+		if err1 != nil || err2 != nil {
+			http.Error(w, "Invalid proof parameters", http.StatusBadRequest)
+			return
+		}
+		proof, err := tlog.InclusionProof(index, size)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		writeJSON(w, http.StatusOK, proof)
+	case "proof/consistency":
+		first, err1 := parseUintParam(query.Get("first"), 0)
+		second, err2 := parseUintParam(query.Get("second"), tlog.LatestTreeHead().TreeSize)
+		if err1 != nil || err2 != nil {
+			http.Error(w, "Invalid proof parameters", http.StatusBadRequest)
+			return
+		}
+		proof, err := tlog.ConsistencyProof(first, second)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		writeJSON(w, http.StatusOK, proof)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// parseUintParam parses an optional unsigned query parameter
+func parseUintParam(value string, fallback uint64) (uint64, error) {
+	if value == "" {
+		return fallback, nil
+	}
+	return strconv.ParseUint(value, 10, 64)
+}
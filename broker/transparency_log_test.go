@@ -0,0 +1,199 @@
+package main
+
+import (
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/fep-fem/protocol"
+)
+
+func TestTransparencyLogProofs(t *testing.T) {
+	_, signer, _ := protocol.GenerateKeyPair()
+	tlog := NewTransparencyLog("test-broker", signer)
+
+	var roots [][]byte
+	for i := 0; i < 17; i++ {
+		tlog.Append(TransparencyEntry{Event: TransparencyRegistration, AgentID: fmt.Sprintf("agent-%d", i)})
+		roots = append(roots, tlog.PublishTreeHead().RootHash)
+	}
+
+	for size := uint64(1); size <= tlog.Size(); size++ {
+		root := roots[size-1]
+		entries, _ := tlog.Entries(0, size)
+		for index := uint64(0); index < size; index++ {
+			proof, err := tlog.InclusionProof(index, size)
+			if err != nil {
+				t.Fatalf("Failed to build inclusion proof %d/%d: %v", index, size, err)
+			}
+			if err := protocol.VerifyInclusion(protocol.MerkleLeafHash(entries[index].Leaf), proof, root); err != nil {
+				t.Errorf("Inclusion proof %d/%d should verify: %v", index, size, err)
+			}
+		}
+
+		for first := uint64(0); first <= size; first++ {
+			proof, err := tlog.ConsistencyProof(first, size)
+			if err != nil {
+				t.Fatalf("Failed to build consistency proof %d/%d: %v", first, size, err)
+			}
+			firstRoot := protocol.EmptyMerkleRoot()
+			if first > 0 {
+				firstRoot = roots[first-1]
+			}
+			if err := protocol.VerifyConsistency(proof, firstRoot, root); err != nil {
+				t.Errorf("Consistency proof %d/%d should verify: %v", first, size, err)
+			}
+		}
+	}
+
+	// A rewritten entry no longer matches the published root
+	proof, _ := tlog.InclusionProof(3, 17)
+	forged, _ := json.Marshal(TransparencyEntry{Event: TransparencyRevocation, AgentID: "agent-3"})
+	if err := protocol.VerifyInclusion(protocol.MerkleLeafHash(forged), proof, roots[16]); err == nil {
+		t.Error("Expected forged entry to fail inclusion")
+	}
+
+	// A forked history is not consistent with an earlier head
+	consistency, _ := tlog.ConsistencyProof(5, 17)
+	if err := protocol.VerifyConsistency(consistency, roots[6], roots[16]); err == nil {
+		t.Error("Expected mismatched roots to fail consistency")
+	}
+
+	sth := tlog.LatestTreeHead()
+	if err := sth.Verify(tlog.PublicKey()); err != nil {
+		t.Errorf("Tree head should verify: %v", err)
+	}
+	sth.TreeSize--
+	if err := sth.Verify(tlog.PublicKey()); err == nil {
+		t.Error("Expected modified tree head to fail verification")
+	}
+}
+
+func TestTransparencyLogRecordsLedgerUpdates(t *testing.T) {
+	broker := NewBroker()
+	server := httptest.NewTLSServer(broker)
+	defer server.Close()
+
+	client := &http.Client{
+		Transport: &http.Transport{TLSClientConfig: &tls.Config{InsecureSkipVerify: true}},
+	}
+	getJSON := func(path string, out interface{}) {
+		resp, err := client.Get(server.URL + path)
+		if err != nil {
+			t.Fatalf("Failed to GET %s: %v", path, err)
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("GET %s returned %d", path, resp.StatusCode)
+		}
+		if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+			t.Fatalf("Failed to decode %s: %v", path, err)
+		}
+	}
+
+	_, privKey, _ := protocol.GenerateKeyPair()
+	record := signedRegistrationRecord(t, "agent-1", "fem-broker", 1000, privKey)
+	env, _ := protocol.ParseEnvelope(record.Envelope)
+	broker.federation.RecordRegistration(env, record.PubKey)
+	broker.federation.RecordRevocation(env, "agent-1")
+
+	var head struct {
+		STH    protocol.SignedTreeHead `json:"sth"`
+		PubKey string                  `json:"pubkey"`
+	}
+	getJSON("/federation/transparency/sth", &head)
+	pubKey, err := protocol.DecodePublicKey(head.PubKey)
+	if err != nil {
+		t.Fatalf("Failed to decode log key: %v", err)
+	}
+	if err := head.STH.Verify(pubKey); err != nil {
+		t.Fatalf("Tree head should verify: %v", err)
+	}
+	if head.STH.TreeSize != 2 {
+		t.Fatalf("Expected 2 logged events, got %d", head.STH.TreeSize)
+	}
+
+	var entries []TransparencyLogEntry
+	getJSON("/federation/transparency/entries?start=0&end=2", &entries)
+	var revocation TransparencyEntry
+	json.Unmarshal(entries[1].Leaf, &revocation)
+	if revocation.Event != TransparencyRevocation || revocation.AgentID != "agent-1" {
+		t.Errorf("Unexpected second entry: %+v", revocation)
+	}
+
+	var proof protocol.InclusionProof
+	getJSON("/federation/transparency/proof/inclusion?index=1&size=2", &proof)
+	if err := protocol.VerifyInclusion(protocol.MerkleLeafHash(entries[1].Leaf), &proof, head.STH.RootHash); err != nil {
+		t.Errorf("Served inclusion proof should verify: %v", err)
+	}
+}
+
+func TestTransparencyLogPersists(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "transparency.log")
+	_, signer, _ := protocol.GenerateKeyPair()
+
+	tlog := NewTransparencyLog("test-broker", signer)
+	if err := tlog.Persist(path); err != nil {
+		t.Fatalf("Failed to persist log: %v", err)
+	}
+	for i := 0; i < 3; i++ {
+		tlog.Append(TransparencyEntry{Event: TransparencyRegistration, AgentID: fmt.Sprintf("agent-%d", i)})
+	}
+	head := tlog.PublishTreeHead()
+	tlog.Close()
+
+	// After a restart with the same key, the earlier head still verifies against the log
+	restored := NewTransparencyLog("test-broker", signer)
+	if err := restored.Persist(path); err != nil {
+		t.Fatalf("Failed to restore log: %v", err)
+	}
+	defer restored.Close()
+	if restored.Size() != 3 {
+		t.Fatalf("Expected 3 restored entries, got %d", restored.Size())
+	}
+	if err := head.Verify(restored.PublicKey()); err != nil {
+		t.Errorf("Earlier tree head should verify with the persistent key: %v", err)
+	}
+	restored.Append(TransparencyEntry{Event: TransparencyRevocation, AgentID: "agent-1"})
+	proof, _ := restored.ConsistencyProof(head.TreeSize, restored.Size())
+	if err := protocol.VerifyConsistency(proof, head.RootHash, restored.PublishTreeHead().RootHash); err != nil {
+		t.Errorf("Restored log should extend the earlier head: %v", err)
+	}
+
+	if err := restored.Persist(path); err == nil {
+		t.Error("Expected a log holding entries to refuse being restored again")
+	}
+}
+
+func TestFederationCloseStopsTreeHeadPublisher(t *testing.T) {
+	config := &FederationConfig{LocalBrokerID: "test-broker", TreeHeadInterval: 5 * time.Millisecond}
+	fm := NewFederationManager(NewMCPRegistry(), config)
+	tlog := fm.TransparencyLog()
+	published := func() *protocol.SignedTreeHead {
+		tlog.mu.RLock()
+		defer tlog.mu.RUnlock()
+		return tlog.latest
+	}
+
+	tlog.Append(TransparencyEntry{Event: TransparencyRegistration, AgentID: "agent-1"})
+	deadline := time.Now().Add(time.Second)
+	for published() == nil && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	if published() == nil {
+		t.Fatal("Expected the publisher to sign a tree head")
+	}
+
+	fm.Close()
+	time.Sleep(20 * time.Millisecond)
+	tlog.Append(TransparencyEntry{Event: TransparencyRegistration, AgentID: "agent-2"})
+	time.Sleep(50 * time.Millisecond)
+	if head := published(); head.TreeSize != 1 {
+		t.Errorf("Expected no tree heads after Close, got one of size %d", head.TreeSize)
+	}
+}
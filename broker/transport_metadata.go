@@ -0,0 +1,101 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/hex"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/fep-fem/protocol"
+)
+
+// Transport types an envelope can arrive over
+const (
+	TransportHTTPS = "https"
+	TransportHTTP  = "http"
+)
+
+// TransportMetadata describes how an envelope reached the broker. It comes from
+// the connection, not from headers the sender controls, so policies and audit
+// records can rely on it.
+type TransportMetadata struct {
+	Transport             string    `json:"transport"`
+	SourceIP              string    `json:"sourceIp"`
+	SourcePort            string    `json:"sourcePort,omitempty"`
+	TLSVersion            string    `json:"tlsVersion,omitempty"`
+	CipherSuite           string    `json:"cipherSuite,omitempty"`
+	ServerName            string    `json:"serverName,omitempty"`            // SNI the client asked for
+	ClientCert            string    `json:"clientCert,omitempty"`            // Subject of the verified client certificate
+	ClientCertFingerprint string    `json:"clientCertFingerprint,omitempty"` // Hex SHA-256 of the client certificate
+	ClientKey             string    `json:"clientKey,omitempty"`             // Base64 Ed25519 key an identity certificate is bound to
+	ReceivedAt            time.Time `json:"receivedAt"`
+}
+
+// transportContextKey keys TransportMetadata in a request's context
+type transportContextKey struct{}
+
+// captureTransport records the request's transport metadata in its context
+func captureTransport(r *http.Request) *http.Request {
+	meta := TransportMetadata{Transport: TransportHTTP, ReceivedAt: time.Now()}
+	meta.SourceIP, meta.SourcePort, _ = net.SplitHostPort(r.RemoteAddr)
+	if meta.SourceIP == "" {
+		meta.SourceIP = r.RemoteAddr
+	}
+
+	if state := r.TLS; state != nil {
+		meta.Transport = TransportHTTPS
+		meta.TLSVersion = tls.VersionName(state.Version)
+		meta.CipherSuite = tls.CipherSuiteName(state.CipherSuite)
+		meta.ServerName = state.ServerName
+		// Only certificates that passed verification identify the client. An
+		// identity certificate is verified by the handshake proving its key.
+		if len(state.PeerCertificates) > 0 {
+			cert := state.PeerCertificates[0]
+			identityKey, err := protocol.IdentityCertificateKey(cert, meta.ReceivedAt)
+			if len(state.VerifiedChains) > 0 || err == nil {
+				fingerprint := sha256.Sum256(cert.Raw)
+				meta.ClientCert = cert.Subject.String()
+				meta.ClientCertFingerprint = hex.EncodeToString(fingerprint[:])
+			}
+			if err == nil {
+				meta.ClientKey = protocol.EncodePublicKey(identityKey)
+			}
+		}
+	}
+	return r.WithContext(context.WithValue(r.Context(), transportContextKey{}, meta))
+}
+
+// TransportFromRequest returns the transport metadata captured for a request
+func TransportFromRequest(r *http.Request) (TransportMetadata, bool) {
+	if r == nil {
+		return TransportMetadata{}, false
+	}
+	meta, ok := r.Context().Value(transportContextKey{}).(TransportMetadata)
+	return meta, ok
+}
+
+// requestSource returns the request's transport metadata for a security event,
+// or nil if none was captured
+func requestSource(r *http.Request) *TransportMetadata {
+	if meta, ok := TransportFromRequest(r); ok {
+		return &meta
+	}
+	return nil
+}
+
+// scriptValues exposes the metadata to route scripts as transport.<field>
+func (m TransportMetadata) scriptValues() map[string]interface{} {
+	return map[string]interface{}{
+		"transport":             m.Transport,
+		"sourceIp":              m.SourceIP,
+		"tlsVersion":            m.TLSVersion,
+		"cipherSuite":           m.CipherSuite,
+		"serverName":            m.ServerName,
+		"clientCert":            m.ClientCert,
+		"clientCertFingerprint": m.ClientCertFingerprint,
+		"clientKey":             m.ClientKey,
+	}
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestCaptureTransportRecordsConnection(t *testing.T) {
+	server := httptest.NewTLSServer(http.NotFoundHandler())
+	defer server.Close()
+	cert := server.Certificate()
+
+	r := httptest.NewRequest(http.MethodPost, "/", nil)
+	r.TLS = &tls.ConnectionState{
+		Version:          tls.VersionTLS13,
+		CipherSuite:      tls.TLS_AES_128_GCM_SHA256,
+		ServerName:       "broker.example",
+		PeerCertificates: []*x509.Certificate{cert},
+	}
+	meta, ok := TransportFromRequest(captureTransport(r))
+	if !ok {
+		t.Fatal("Transport metadata should be attached to the request")
+	}
+	if meta.Transport != TransportHTTPS || meta.SourceIP != "192.0.2.1" || meta.SourcePort != "1234" ||
+		meta.TLSVersion != "TLS 1.3" || meta.CipherSuite != "TLS_AES_128_GCM_SHA256" || meta.ServerName != "broker.example" {
+		t.Errorf("Unexpected transport metadata: %+v", meta)
+	}
+	if meta.ClientCert != "" {
+		t.Error("An unverified client certificate should not identify the client")
+	}
+
+	r.TLS.VerifiedChains = [][]*x509.Certificate{{cert}}
+	meta, _ = TransportFromRequest(captureTransport(r))
+	if meta.ClientCert != cert.Subject.String() || len(meta.ClientCertFingerprint) != 64 {
+		t.Errorf("Verified client certificate not recorded: %+v", meta)
+	}
+
+	if _, ok := TransportFromRequest(httptest.NewRequest(http.MethodPost, "/", nil)); ok {
+		t.Error("Requests that were not captured should carry no metadata")
+	}
+}
+
+func TestMiddlewareSeesTransport(t *testing.T) {
+	broker := NewBroker()
+	var seen TransportMetadata
+	broker.Use("audit", &MiddlewareFuncs{
+		Before: func(ctx *EnvelopeContext) error {
+			seen = ctx.Transport
+			return nil
+		},
+	})
+
+	resp, _ := sendHeartbeat(t, broker, "worker")
+	if resp.Code != http.StatusOK {
+		t.Fatalf("Heartbeat should be accepted, got %d %s", resp.Code, resp.Body.String())
+	}
+	if seen.Transport != TransportHTTP || seen.SourceIP != "192.0.2.1" || seen.ReceivedAt.IsZero() {
+		t.Errorf("Middleware did not see the transport metadata: %+v", seen)
+	}
+}
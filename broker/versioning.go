@@ -0,0 +1,29 @@
+package main
+
+import (
+	"github.com/fep-fem/protocol"
+)
+
+// SetMinProtocolVersion sets the oldest protocol version accepted from agents
+// and peers. Envelopes without a proto header count as version 0.0.0.
+func (b *Broker) SetMinProtocolVersion(version string) error {
+	minimum, err := protocol.ParseVersion(version)
+	if err != nil {
+		return err
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.minProtocol = minimum
+	return nil
+}
+
+// negotiateVersion returns the protocol version to answer an envelope in,
+// failing if the sender is older than the configured minimum
+func (b *Broker) negotiateVersion(env *protocol.GenericEnvelope) (protocol.Version, error) {
+	b.mu.RLock()
+	minimum := b.minProtocol
+	b.mu.RUnlock()
+
+	return protocol.NegotiateVersion(env.Proto, minimum)
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/fep-fem/protocol"
+)
+
+func TestBrokerNegotiatesProtocolVersion(t *testing.T) {
+	broker := NewBroker()
+	if err := broker.SetMinProtocolVersion("0.3"); err != nil {
+		t.Fatalf("Failed to set minimum version: %v", err)
+	}
+	pubKey, privKey, _ := protocol.GenerateKeyPair()
+
+	register := func(proto string) *httptest.ResponseRecorder {
+		envelope := &protocol.RegisterAgentEnvelope{
+			BaseEnvelope: protocol.BaseEnvelope{
+				Type:          protocol.EnvelopeRegisterAgent,
+				CommonHeaders: protocol.CommonHeaders{Agent: "versioned", TS: time.Now().UnixMilli(), Nonce: protocol.NewRandomID(), Proto: proto},
+			},
+			Body: protocol.RegisterAgentBody{PubKey: protocol.EncodePublicKey(pubKey), Capabilities: []string{"math.add"}},
+		}
+		envelope.Sign(privKey)
+		data, _ := json.Marshal(envelope)
+		recorder := httptest.NewRecorder()
+		broker.ServeHTTP(recorder, httptest.NewRequest(http.MethodPost, "/", bytes.NewReader(data)))
+		return recorder
+	}
+
+	for _, proto := range []string{"", "0.2.9"} {
+		resp := register(proto)
+		var rejection *protocol.ErrorBody
+		if _, err := protocol.ParseResponse(resp.Body.Bytes()); !errors.As(err, &rejection) || rejection.Code != protocol.CodeUnsupportedVersion {
+			t.Errorf("Expected proto %q to be rejected as unsupported, got %d %v", proto, resp.Code, err)
+		}
+	}
+
+	resp := register("7.0.0")
+	envelope, _ := protocol.ParseEnvelope(resp.Body.Bytes())
+	ack, err := protocol.ParseResponse(resp.Body.Bytes())
+	if err != nil {
+		t.Fatalf("Newer client should be accepted, got %v", err)
+	}
+	var result struct {
+		Proto string `json:"proto"`
+	}
+	ack.ResultAs(&result)
+	if result.Proto != protocol.ProtocolVersion || envelope.Proto != protocol.ProtocolVersion {
+		t.Errorf("Newer client should be downgraded to %s, got result %q header %q", protocol.ProtocolVersion, result.Proto, envelope.Proto)
+	}
+
+	broker.mu.RLock()
+	agent := broker.agents["versioned"]
+	broker.mu.RUnlock()
+	if agent == nil || agent.Proto != protocol.ProtocolVersion {
+		t.Errorf("Expected negotiated version to be recorded, got %+v", agent)
+	}
+}
+
+func TestBrokerRejectsInvalidMinimumVersion(t *testing.T) {
+	if err := NewBroker().SetMinProtocolVersion("latest"); err == nil {
+		t.Error("Expected an unparseable minimum version to be rejected")
+	}
+}
@@ -0,0 +1,273 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/fep-fem/protocol"
+)
+
+// sharedLane names the lane serving envelope types without a dedicated lane
+const sharedLane = "shared"
+
+// errQueueFull is returned when a lane's queue cannot accept more work
+var errQueueFull = errors.New("envelope queue is full")
+
+// WorkerPoolConfig sizes the pool that processes envelopes. Envelope types listed
+// in TypeWorkers get a dedicated lane so a burst of one type cannot starve the others.
+type WorkerPoolConfig struct {
+	Workers     int                           // Workers in the shared lane
+	QueueSize   int                           // Queued envelopes per lane before requests are refused
+	TypeWorkers map[protocol.EnvelopeType]int // Dedicated lanes and their worker counts
+}
+
+// DefaultWorkerPoolConfig sizes the shared lane from the number of CPUs
+func DefaultWorkerPoolConfig() WorkerPoolConfig {
+	return WorkerPoolConfig{
+		Workers:   4 * runtime.NumCPU(),
+		QueueSize: 1024,
+	}
+}
+
+// ParseTypeWorkers parses "type=workers" pairs separated by commas, e.g. "toolCall=8,discoverTools=4"
+func ParseTypeWorkers(spec string) (map[protocol.EnvelopeType]int, error) {
+	lanes := make(map[protocol.EnvelopeType]int)
+	for _, pair := range strings.Split(spec, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		envType, count, ok := strings.Cut(pair, "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid lane %q: expected type=workers", pair)
+		}
+		workers, err := strconv.Atoi(count)
+		if err != nil || workers <= 0 {
+			return nil, fmt.Errorf("invalid worker count for %s: %q", envType, count)
+		}
+		lanes[protocol.EnvelopeType(envType)] = workers
+	}
+	return lanes, nil
+}
+
+// WorkerLaneStats reports queue metrics for one lane
+type WorkerLaneStats struct {
+	Lane      string `json:"lane"`
+	Workers   int    `json:"workers"`
+	Queued    int    `json:"queued"`
+	Capacity  int    `json:"capacity"`
+	Active    int64  `json:"active"`
+	Processed uint64 `json:"processed"`
+	Rejected  uint64 `json:"rejected"`
+}
+
+// workerPool runs envelope handlers on a bounded set of goroutines
+type workerPool struct {
+	lanes  map[protocol.EnvelopeType]*workerLane
+	shared *workerLane
+	closed bool
+	mu     sync.RWMutex
+	wg     sync.WaitGroup
+}
+
+// workerLane is a queue with its own workers
+type workerLane struct {
+	name      string
+	workers   int
+	jobs      chan func()
+	active    atomic.Int64
+	processed atomic.Uint64
+	rejected  atomic.Uint64
+}
+
+func newWorkerPool(config WorkerPoolConfig) *workerPool {
+	if config.Workers <= 0 {
+		config.Workers = 1
+	}
+	if config.QueueSize <= 0 {
+		config.QueueSize = 1
+	}
+
+	pool := &workerPool{lanes: make(map[protocol.EnvelopeType]*workerLane)}
+	pool.shared = pool.startLane(sharedLane, config.Workers, config.QueueSize)
+	for envType, workers := range config.TypeWorkers {
+		pool.lanes[envType] = pool.startLane(string(envType), workers, config.QueueSize)
+	}
+	return pool
+}
+
+func (p *workerPool) startLane(name string, workers, queueSize int) *workerLane {
+	lane := &workerLane{
+		name:    name,
+		workers: workers,
+		jobs:    make(chan func(), queueSize),
+	}
+	for i := 0; i < workers; i++ {
+		p.wg.Add(1)
+		go func() {
+			defer p.wg.Done()
+			for job := range lane.jobs {
+				lane.active.Add(1)
+				job()
+				lane.active.Add(-1)
+				lane.processed.Add(1)
+			}
+		}()
+	}
+	return lane
+}
+
+// Submit queues a job on the lane for envType without blocking.
+// It returns errQueueFull when the lane is saturated.
+func (p *workerPool) Submit(envType protocol.EnvelopeType, job func()) error {
+	lane, exists := p.lanes[envType]
+	if !exists {
+		lane = p.shared
+	}
+
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	if p.closed {
+		return fmt.Errorf("%w (pool closed)", errQueueFull)
+	}
+
+	select {
+	case lane.jobs <- job:
+		return nil
+	default:
+		lane.rejected.Add(1)
+		return fmt.Errorf("%w (%s lane)", errQueueFull, lane.name)
+	}
+}
+
+// Run queues a job and waits for it to finish
+func (p *workerPool) Run(envType protocol.EnvelopeType, job func()) error {
+	done := make(chan struct{})
+	if err := p.Submit(envType, func() {
+		defer close(done)
+		job()
+	}); err != nil {
+		return err
+	}
+	<-done
+	return nil
+}
+
+// Stats returns queue metrics for every lane, shared lane first
+func (p *workerPool) Stats() []WorkerLaneStats {
+	stats := []WorkerLaneStats{p.shared.stats()}
+	for _, lane := range p.lanes {
+		stats = append(stats, lane.stats())
+	}
+	sort.Slice(stats[1:], func(i, j int) bool { return stats[i+1].Lane < stats[j+1].Lane })
+	return stats
+}
+
+// Accepting reports whether the pool still takes new work
+func (p *workerPool) Accepting() bool {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return !p.closed
+}
+
+// Close stops accepting work and waits for queued jobs to finish
+func (p *workerPool) Close() {
+	p.mu.Lock()
+	p.closed = true
+	p.mu.Unlock()
+
+	close(p.shared.jobs)
+	for _, lane := range p.lanes {
+		close(lane.jobs)
+	}
+	p.wg.Wait()
+}
+
+func (l *workerLane) stats() WorkerLaneStats {
+	return WorkerLaneStats{
+		Lane:      l.name,
+		Workers:   l.workers,
+		Queued:    len(l.jobs),
+		Capacity:  cap(l.jobs),
+		Active:    l.active.Load(),
+		Processed: l.processed.Load(),
+		Rejected:  l.rejected.Load(),
+	}
+}
+
+// SetWorkerPool replaces the envelope worker pool. Work queued on the old pool is drained.
+func (b *Broker) SetWorkerPool(config WorkerPoolConfig) {
+	pool := newWorkerPool(config)
+
+	b.mu.Lock()
+	old := b.workers
+	b.workers = pool
+	b.mu.Unlock()
+
+	if old != nil {
+		go old.Close()
+	}
+}
+
+// envelopeWorkers returns the pool processing envelopes
+func (b *Broker) envelopeWorkers() *workerPool {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	return b.workers
+}
+
+// afterRelease runs wait once the envelope's worker is released, so a
+// handler waiting for results never holds a worker the results need to be
+// processed. Envelopes not run on a pool worker wait at once.
+func afterRelease(w http.ResponseWriter, wait func()) {
+	signed, ok := w.(*signedResponseWriter)
+	if !ok || !signed.pooled {
+		wait()
+		return
+	}
+	signed.wait = wait
+}
+
+// then runs fn after the wait deferred until the worker is released, or at
+// once if there is none
+func (s *signedResponseWriter) then(fn func()) {
+	wait := s.wait
+	if wait == nil {
+		fn()
+		return
+	}
+	s.wait = func() {
+		wait()
+		fn()
+	}
+}
+
+// released runs the deferred wait on the request's own goroutine
+func (s *signedResponseWriter) released() {
+	if wait := s.wait; wait != nil {
+		s.wait = nil
+		wait()
+	}
+}
+
+// rejectForOverload refuses an envelope when its lane is saturated
+func (b *Broker) rejectForOverload(w http.ResponseWriter, env *protocol.GenericEnvelope, err error) {
+	w.Header().Set("Retry-After", "1")
+	b.reject(w, env, protocol.CodeOverloaded, fmt.Sprintf("Broker overloaded: %v", err))
+}
+
+// handleAdminWorkers reports worker pool queue metrics
+func (b *Broker) handleAdminWorkers(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	writeJSON(w, http.StatusOK, b.envelopeWorkers().Stats())
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/fep-fem/protocol"
+)
+
+func TestWorkerPoolRejectsWhenSaturated(t *testing.T) {
+	pool := newWorkerPool(WorkerPoolConfig{Workers: 1, QueueSize: 1})
+	defer pool.Close()
+
+	release := make(chan struct{})
+	started := make(chan struct{})
+
+	// One job occupies the only worker, a second fills the queue
+	if err := pool.Submit(protocol.EnvelopeToolCall, func() { close(started); <-release }); err != nil {
+		t.Fatalf("First submit failed: %v", err)
+	}
+	<-started
+	if err := pool.Submit(protocol.EnvelopeToolCall, func() {}); err != nil {
+		t.Fatalf("Queued submit failed: %v", err)
+	}
+
+	if err := pool.Submit(protocol.EnvelopeToolCall, func() {}); !errors.Is(err, errQueueFull) {
+		t.Fatalf("Expected errQueueFull, got %v", err)
+	}
+
+	stats := pool.Stats()[0]
+	if stats.Active != 1 || stats.Queued != 1 || stats.Rejected != 1 {
+		t.Errorf("Unexpected stats: %+v", stats)
+	}
+	close(release)
+}
+
+func TestWorkerPoolDedicatedLanes(t *testing.T) {
+	pool := newWorkerPool(WorkerPoolConfig{
+		Workers:     1,
+		QueueSize:   1,
+		TypeWorkers: map[protocol.EnvelopeType]int{protocol.EnvelopeDiscoverTools: 1},
+	})
+	defer pool.Close()
+
+	// Saturate the shared lane with tool calls
+	release := make(chan struct{})
+	started := make(chan struct{})
+	pool.Submit(protocol.EnvelopeToolCall, func() { close(started); <-release })
+	<-started
+	pool.Submit(protocol.EnvelopeToolCall, func() {})
+	defer close(release)
+
+	// Discovery runs in its own lane and is unaffected
+	ran := false
+	if err := pool.Run(protocol.EnvelopeDiscoverTools, func() { ran = true }); err != nil || !ran {
+		t.Fatalf("Dedicated lane should still serve discovery: %v", err)
+	}
+
+	stats := pool.Stats()
+	if len(stats) != 2 || stats[0].Lane != sharedLane || stats[1].Lane != string(protocol.EnvelopeDiscoverTools) {
+		t.Fatalf("Unexpected lanes: %+v", stats)
+	}
+	if stats[1].Processed != 1 {
+		t.Errorf("Expected 1 processed discovery, got %d", stats[1].Processed)
+	}
+}
+
+func TestParseTypeWorkers(t *testing.T) {
+	lanes, err := ParseTypeWorkers("toolCall=8, discoverTools=4")
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+	if lanes[protocol.EnvelopeToolCall] != 8 || lanes[protocol.EnvelopeDiscoverTools] != 4 {
+		t.Errorf("Unexpected lanes: %v", lanes)
+	}
+
+	for _, spec := range []string{"toolCall", "toolCall=0", "toolCall=x"} {
+		if _, err := ParseTypeWorkers(spec); err == nil {
+			t.Errorf("Expected error for %q", spec)
+		}
+	}
+}
@@ -0,0 +1,211 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/fep-fem/protocol"
+)
+
+// callOptions are the flags of femctl call
+type callOptions struct {
+	connectionOptions
+	params       paramFlags
+	paramsJSON   string
+	capability   string
+	dataRegion   string
+	wait         bool
+	waitTimeout  time.Duration
+	pollInterval time.Duration
+}
+
+// paramFlags collects repeated --param name=value flags. Values that parse as
+// JSON keep their type, so a=1 is a number and a='"1"' a string; anything else
+// is taken as a string.
+type paramFlags map[string]interface{}
+
+func (p paramFlags) String() string {
+	return ""
+}
+
+func (p paramFlags) Set(value string) error {
+	name, raw, found := strings.Cut(value, "=")
+	if !found || name == "" {
+		return fmt.Errorf("want name=value, got %q", value)
+	}
+	var parsed interface{}
+	if err := json.Unmarshal([]byte(raw), &parsed); err != nil {
+		parsed = raw
+	}
+	p[name] = parsed
+	return nil
+}
+
+func runCall(args []string) int {
+	opts := callOptions{params: paramFlags{}}
+	flags := flag.NewFlagSet("call", flag.ContinueOnError)
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "Usage: femctl call <agent> <tool> [flags]")
+		flags.PrintDefaults()
+	}
+	opts.register(flags, "Agent ID to call as; must match the signing key (the --identity name if empty)")
+	flags.Var(opts.params, "param", "Tool parameter as name=value, repeatable; JSON values keep their type")
+	flags.StringVar(&opts.paramsJSON, "params", "", "Tool parameters as a JSON object, overridden by --param")
+	flags.StringVar(&opts.capability, "capability", "", "Capability token authorizing the call, for brokers that require one")
+	flags.StringVar(&opts.dataRegion, "data-region", "", "Region the call's data must stay in, e.g. eu")
+	flags.BoolVar(&opts.wait, "wait", false, "Wait for the tool's result and print it")
+	flags.DurationVar(&opts.waitTimeout, "wait-timeout", time.Minute, "How long --wait waits for the result")
+	flags.DurationVar(&opts.pollInterval, "poll-interval", 500*time.Millisecond, "How often --wait asks the broker for the result")
+	positional, err := parseInterleaved(flags, args)
+	if err != nil {
+		return 2
+	}
+	if len(positional) != 2 {
+		fmt.Fprintln(os.Stderr, "femctl call: want an agent and a tool")
+		flags.Usage()
+		return 2
+	}
+	if opts.agent == "" {
+		opts.agent = opts.identity
+	}
+	if opts.agent == "" {
+		opts.agent = defaultAgent
+	}
+
+	if err := call(opts, positional[0], positional[1], os.Stdout); err != nil {
+		reportError(os.Stderr, "call", err)
+		return 1
+	}
+	return 0
+}
+
+func call(opts callOptions, agentID, toolName string, out io.Writer) error {
+	client, err := newBrokerClient(opts.connectionOptions)
+	if err != nil {
+		return err
+	}
+
+	params := map[string]interface{}{}
+	if opts.paramsJSON != "" {
+		if err := json.Unmarshal([]byte(opts.paramsJSON), &params); err != nil {
+			return fmt.Errorf("--params is not a JSON object: %w", err)
+		}
+	}
+	for name, value := range opts.params {
+		params[name] = value
+	}
+
+	requestID := protocol.NewRandomID()
+	builder := protocol.NewToolCall(client.agent).
+		Tool(fmt.Sprintf("%s/%s", agentID, toolName)).
+		Params(params).
+		RequestID(requestID).
+		Capability(opts.capability).
+		DataRegion(opts.dataRegion)
+	if opts.wait {
+		// Held until acknowledged, so a result is not lost between polls
+		builder.Delivery(protocol.DeliverAtLeastOnce)
+	}
+	envelope, err := builder.Build()
+	if err != nil {
+		return err
+	}
+	ack, err := client.request(envelope)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(out, "status:    %s\nrequestId: %s\n", ack.Status, requestID)
+	if !opts.wait {
+		return nil
+	}
+	return waitForResult(client, requestID, opts, out)
+}
+
+// waitForResult polls the broker for the result of requestID and prints it,
+// writing streamed chunks as they arrive. Results of other requests are left
+// unacknowledged so the broker delivers them again to their own caller.
+func waitForResult(client *brokerClient, requestID string, opts callOptions, out io.Writer) error {
+	deadline := time.Now().Add(opts.waitTimeout)
+	seen := map[string]bool{}
+	var acks []string
+	for {
+		envelope := protocol.NewTypedEnvelope(client.agent, protocol.ResultAckBody{Acknowledged: acks})
+		ack, err := client.request(envelope)
+		if err != nil {
+			return fmt.Errorf("failed to collect result: %w", err)
+		}
+		var delivery protocol.ResultDeliveryBody
+		if err := ack.ResultAs(&delivery); err != nil {
+			return fmt.Errorf("invalid result delivery: %w", err)
+		}
+
+		acks = nil
+		for _, result := range delivery.Results {
+			if result.RequestID != requestID {
+				continue
+			}
+			acks = append(acks, result.Key())
+			if seen[result.Key()] {
+				continue
+			}
+			seen[result.Key()] = true
+
+			done, err := printDelivered(out, result)
+			if done {
+				// Acknowledge the last deliveries so the broker stops holding them
+				client.request(protocol.NewTypedEnvelope(client.agent, protocol.ResultAckBody{Acknowledged: acks, MaxResults: 1}))
+				return err
+			}
+		}
+
+		if time.Now().After(deadline) {
+			return fmt.Errorf("no result for request %s within %s", requestID, opts.waitTimeout)
+		}
+		time.Sleep(opts.pollInterval)
+	}
+}
+
+// printDelivered prints a delivered result or chunk. done reports whether the
+// call has finished; err is set if the tool failed.
+func printDelivered(out io.Writer, result protocol.DeliveredResult) (done bool, err error) {
+	envelope, err := protocol.ParseEnvelope(result.Envelope)
+	if err != nil {
+		return true, fmt.Errorf("invalid result envelope: %w", err)
+	}
+
+	var success bool
+	var value interface{}
+	var failure string
+	if result.Chunk != 0 {
+		chunk, err := protocol.ParseTyped[protocol.ToolResultChunkBody](envelope)
+		if err != nil {
+			return true, fmt.Errorf("invalid result chunk: %w", err)
+		}
+		fmt.Fprint(out, chunk.Body.Data)
+		if !chunk.Body.Final {
+			return false, nil
+		}
+		success, value, failure = chunk.Body.Success, chunk.Body.Result, chunk.Body.Error
+	} else {
+		typed, err := protocol.ParseTyped[protocol.ToolResultBody](envelope)
+		if err != nil {
+			return true, fmt.Errorf("invalid result: %w", err)
+		}
+		success, value, failure = typed.Body.Success, typed.Body.Result, typed.Body.Error
+	}
+
+	if !success {
+		return true, fmt.Errorf("tool failed: %s", failure)
+	}
+	fmt.Fprintf(out, "result from %s:\n", envelope.Agent)
+	data, err := json.Marshal(value)
+	if err != nil {
+		return true, err
+	}
+	return true, printJSON(out, data)
+}
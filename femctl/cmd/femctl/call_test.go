@@ -0,0 +1,128 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"io"
+	"net/http"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/fep-fem/protocol"
+)
+
+// resultBroker acks tool calls and hands out results over two polls: a chunk
+// of the call's output with another caller's result, then the final result
+type resultBroker struct {
+	mu     sync.Mutex
+	call   protocol.ToolCallBody
+	polls  int
+	acked  [][]string
+	failed bool // Whether the final result reports a failure
+}
+
+func (b *resultBroker) respond(envelope *protocol.GenericEnvelope) (interface{}, int) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	deliver := func(requestID string, chunk uint64, body protocol.EnvelopeBody) protocol.DeliveredResult {
+		var raw []byte
+		switch body := body.(type) {
+		case protocol.ToolResultChunkBody:
+			raw, _ = json.Marshal(protocol.NewTypedEnvelope("calc-agent", body))
+		case protocol.ToolResultBody:
+			raw, _ = json.Marshal(protocol.NewTypedEnvelope("calc-agent", body))
+		}
+		return protocol.DeliveredResult{RequestID: requestID, Delivery: protocol.DeliverAtLeastOnce, Chunk: chunk, Attempt: 1, Envelope: raw}
+	}
+
+	switch envelope.Type {
+	case protocol.EnvelopeToolCall:
+		typed, _ := protocol.ParseTyped[protocol.ToolCallBody](envelope)
+		b.call = typed.Body
+		return protocol.NewAck("broker-1", envelope.Nonce, "processing", nil), http.StatusOK
+	case protocol.EnvelopeResultAck:
+		typed, _ := protocol.ParseTyped[protocol.ResultAckBody](envelope)
+		b.acked = append(b.acked, typed.Body.Acknowledged)
+		b.polls++
+		var results []protocol.DeliveredResult
+		switch b.polls {
+		case 1:
+			results = append(results,
+				deliver("someone-else", 0, protocol.ToolResultBody{RequestID: "someone-else", Success: true}),
+				deliver(b.call.RequestID, 1, protocol.ToolResultChunkBody{RequestID: b.call.RequestID, Seq: 1, Data: "adding...\n"}))
+		case 2:
+			results = append(results, deliver(b.call.RequestID, 0, protocol.ToolResultBody{
+				RequestID: b.call.RequestID, Success: !b.failed, Result: map[string]int{"sum": 3}, Error: "overflow"}))
+		}
+		return protocol.NewAck("broker-1", envelope.Nonce, "delivered", protocol.ResultDeliveryBody{Results: results}), http.StatusOK
+	}
+	return protocol.NewError("broker-1", envelope.Nonce, protocol.CodeInvalidBody, "unexpected envelope"), http.StatusBadRequest
+}
+
+func TestCallWaitsForResult(t *testing.T) {
+	broker := &resultBroker{}
+	server := respondingBroker(t, broker.respond)
+	defer server.Close()
+
+	opts := callOptions{connectionOptions: testConnection(t, server.URL, ""), params: paramFlags{"a": 1.0, "b": 2.0},
+		paramsJSON: `{"a":0,"mode":"fast"}`, capability: "tok", wait: true, waitTimeout: time.Second, pollInterval: time.Millisecond}
+	opts.agent = defaultAgent
+	var out bytes.Buffer
+	if err := call(opts, "calc-agent", "math.add", &out); err != nil {
+		t.Fatalf("Call failed: %v\n%s", err, out.String())
+	}
+
+	if broker.call.Tool != "calc-agent/math.add" || broker.call.Capability != "tok" || broker.call.Delivery != protocol.DeliverAtLeastOnce {
+		t.Errorf("Unexpected tool call: %+v", broker.call)
+	}
+	if want := map[string]interface{}{"a": 1.0, "b": 2.0, "mode": "fast"}; !reflect.DeepEqual(broker.call.Parameters, want) {
+		t.Errorf("Expected parameters %v, got %v", want, broker.call.Parameters)
+	}
+	for _, want := range []string{"status:    processing", "adding...\n", "result from calc-agent:", `"sum": 3`} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("Expected output to contain %q, got:\n%s", want, out.String())
+		}
+	}
+
+	// Only this call's deliveries are acknowledged
+	chunkKey := protocol.ResultKey(broker.call.RequestID, 1)
+	if len(broker.acked) != 3 || !reflect.DeepEqual(broker.acked[1], []string{chunkKey}) || !reflect.DeepEqual(broker.acked[2], []string{broker.call.RequestID}) {
+		t.Errorf("Unexpected acknowledgements: %v", broker.acked)
+	}
+}
+
+func TestCallReportsToolFailure(t *testing.T) {
+	broker := &resultBroker{failed: true}
+	server := respondingBroker(t, broker.respond)
+	defer server.Close()
+
+	opts := callOptions{connectionOptions: testConnection(t, server.URL, ""), params: paramFlags{},
+		wait: true, waitTimeout: time.Second, pollInterval: time.Millisecond}
+	opts.agent = defaultAgent
+	if err := call(opts, "calc-agent", "math.add", io.Discard); err == nil || !strings.Contains(err.Error(), "tool failed: overflow") {
+		t.Errorf("Expected the tool's failure, got %v", err)
+	}
+}
+
+func TestCallArguments(t *testing.T) {
+	opts := callOptions{params: paramFlags{}}
+	flags := flag.NewFlagSet("call", flag.ContinueOnError)
+	flags.Var(opts.params, "param", "")
+	flags.BoolVar(&opts.wait, "wait", false, "")
+
+	positional, err := parseInterleaved(flags, []string{"calc-agent", "--param", "a=1", "math.add", "--param", `b="1"`, "--param", "c=hello world", "--wait"})
+	if err != nil || !reflect.DeepEqual(positional, []string{"calc-agent", "math.add"}) || !opts.wait {
+		t.Fatalf("Unexpected parse: %v %v wait=%v", positional, err, opts.wait)
+	}
+	if want := (paramFlags{"a": 1.0, "b": "1", "c": "hello world"}); !reflect.DeepEqual(opts.params, want) {
+		t.Errorf("Expected %v, got %v", want, opts.params)
+	}
+	if err := opts.params.Set("novalue"); err == nil {
+		t.Error("Expected a parameter without = to be rejected")
+	}
+}
@@ -0,0 +1,181 @@
+package main
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/fep-fem/protocol"
+)
+
+// connectionOptions are the flags shared by commands that talk to a broker
+type connectionOptions struct {
+	keystoreOptions
+	brokerURL string
+	signKey   string
+	identity  string
+	agent     string
+	pinFile   string
+	pinMode   string
+	timeout   time.Duration
+}
+
+// register adds the connection flags to flags. agentUsage describes --agent,
+// whose meaning differs between commands.
+func (o *connectionOptions) register(flags *flag.FlagSet, agentUsage string) {
+	flags.StringVar(&o.brokerURL, "broker", "https://localhost:4433", "Broker URL to post envelopes to")
+	flags.StringVar(&o.signKey, "sign-key", "", "Ed25519 private key to sign with, as PKCS#8 PEM or base64 (unsigned if empty)")
+	flags.StringVar(&o.identity, "identity", "", "Named identity from femctl keygen to sign with, instead of --sign-key")
+	o.keystoreOptions.register(flags)
+	flags.StringVar(&o.agent, "agent", "", agentUsage)
+	flags.StringVar(&o.pinFile, "pin-file", defaultPinFile(), "File recording pinned broker identity keys")
+	flags.StringVar(&o.pinMode, "pin-mode", string(protocol.PinModeEnforce), "Action on broker key change: enforce or warn")
+	flags.DurationVar(&o.timeout, "timeout", 10*time.Second, "How long to wait for each broker response")
+}
+
+// brokerClient posts envelopes to a broker and checks its identity on every response
+type brokerClient struct {
+	brokerURL string
+	agent     string
+	key       ed25519.PrivateKey // nil sends envelopes unsigned
+	http      *http.Client
+	pins      *protocol.BrokerPins
+}
+
+func newBrokerClient(opts connectionOptions) (*brokerClient, error) {
+	client := &brokerClient{
+		brokerURL: strings.TrimSuffix(opts.brokerURL, "/"),
+		agent:     opts.agent,
+		http: &http.Client{
+			Transport: &http.Transport{
+				TLSClientConfig: &tls.Config{
+					InsecureSkipVerify: true, // Brokers use self-signed certs; identity is checked by pinning
+				},
+			},
+			Timeout: opts.timeout,
+		},
+		pins: protocol.NewBrokerPins(protocol.NewFilePinStore(opts.pinFile), protocol.PinMode(opts.pinMode)),
+	}
+	switch {
+	case opts.signKey != "" && opts.identity != "":
+		return nil, fmt.Errorf("--sign-key and --identity are mutually exclusive")
+	case opts.signKey != "":
+		key, err := loadSigningKey(opts.signKey)
+		if err != nil {
+			return nil, err
+		}
+		client.key = key
+	case opts.identity != "":
+		store, err := opts.keystoreOptions.open()
+		if err != nil {
+			return nil, err
+		}
+		if client.key, err = store.load(opts.identity); err != nil {
+			return nil, fmt.Errorf("failed to load identity: %w", err)
+		}
+	}
+	return client, nil
+}
+
+// brokerResponse is a response as received from the broker
+type brokerResponse struct {
+	status string // HTTP status line, e.g. "200 OK"
+	ok     bool
+	body   []byte
+}
+
+// post sends an encoded envelope carrying requestNonce and returns the response
+// once the broker's signature over it checks out against the pinned key
+func (c *brokerClient) post(payload []byte, requestNonce string) (*brokerResponse, error) {
+	resp, err := c.http.Post(c.brokerURL+"/", "application/json", bytes.NewReader(payload))
+	if err != nil {
+		return nil, fmt.Errorf("failed to send envelope: %w", err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read response: %w", err)
+	}
+
+	// Responses to parsed envelopes are signed with the broker's identity key
+	if resp.Header.Get(protocol.BrokerKeyHeader) != "" {
+		if err := c.pins.VerifyResponse(c.brokerURL, resp.Header, requestNonce, body); err != nil {
+			return nil, fmt.Errorf("broker identity check failed: %w", err)
+		}
+	}
+	return &brokerResponse{status: resp.Status, ok: resp.StatusCode == http.StatusOK, body: body}, nil
+}
+
+// signable is an envelope the client can sign before sending
+type signable interface {
+	Sign(signer protocol.Signer) error
+}
+
+// request signs envelope, if the client has a key, and returns the broker's
+// ack. A rejection is returned as a wrapped *protocol.ErrorBody.
+func (c *brokerClient) request(envelope signable) (*protocol.AckBody, error) {
+	if c.key != nil {
+		if err := envelope.Sign(c.key); err != nil {
+			return nil, fmt.Errorf("failed to sign envelope: %w", err)
+		}
+	}
+	payload, err := json.Marshal(envelope)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal envelope: %w", err)
+	}
+	var headers protocol.CommonHeaders
+	if err := json.Unmarshal(payload, &headers); err != nil {
+		return nil, fmt.Errorf("failed to read envelope headers: %w", err)
+	}
+
+	resp, err := c.post(payload, headers.Nonce)
+	if err != nil {
+		return nil, err
+	}
+	ack, err := protocol.ParseResponse(resp.body)
+	var rejection *protocol.ErrorBody
+	switch {
+	case errors.As(err, &rejection):
+		return nil, fmt.Errorf("broker rejected request: %w", err)
+	case err != nil:
+		return nil, fmt.Errorf("broker returned %s: %s", resp.status, strings.TrimSpace(string(resp.body)))
+	}
+	return ack, nil
+}
+
+// reportError prints err for a command, followed by the details of a broker
+// rejection, which often name what the caller was missing
+func reportError(out io.Writer, command string, err error) {
+	fmt.Fprintf(out, "femctl %s: %v\n", command, err)
+	var rejection *protocol.ErrorBody
+	if errors.As(err, &rejection) && len(rejection.Details) > 0 {
+		details, _ := json.Marshal(rejection.Details)
+		fmt.Fprint(out, "details: ")
+		printJSON(out, details)
+	}
+}
+
+// parseInterleaved parses flags that may appear before, between or after
+// positional arguments, as in "femctl call agent tool --param a=1", and
+// returns the positional arguments
+func parseInterleaved(flags *flag.FlagSet, args []string) ([]string, error) {
+	var positional []string
+	for {
+		if err := flags.Parse(args); err != nil {
+			return nil, err
+		}
+		if flags.NArg() == 0 {
+			return positional, nil
+		}
+		positional = append(positional, flags.Arg(0))
+		args = flags.Args()[1:]
+	}
+}
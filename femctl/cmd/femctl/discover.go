@@ -0,0 +1,114 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/fep-fem/protocol"
+)
+
+// defaultAgent identifies femctl to the broker when --agent is not given
+const defaultAgent = "femctl"
+
+// discoverOptions are the flags of femctl discover
+type discoverOptions struct {
+	connectionOptions
+	caps        string
+	environment string
+	maxResults  int
+	capability  string
+	json        bool
+}
+
+func runDiscover(args []string) int {
+	var opts discoverOptions
+	flags := flag.NewFlagSet("discover", flag.ContinueOnError)
+	opts.register(flags, "Agent ID to query as; must match the signing key on brokers that authenticate discovery (the --identity name if empty)")
+	flags.StringVar(&opts.caps, "caps", "*", "Comma-separated tool patterns to look for, e.g. \"math.*,file.read\"")
+	flags.StringVar(&opts.environment, "env", "", "Only list agents in this environment type")
+	flags.IntVar(&opts.maxResults, "max", 0, "Largest number of agents to list (broker default if 0)")
+	flags.StringVar(&opts.capability, "capability", "", "Capability token presented with the query, for brokers that scope discovery")
+	flags.BoolVar(&opts.json, "json", false, "Print the discovered agents as JSON")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	if opts.agent == "" {
+		opts.agent = opts.identity
+	}
+	if opts.agent == "" {
+		opts.agent = defaultAgent
+	}
+
+	if err := discover(opts, os.Stdout); err != nil {
+		reportError(os.Stderr, "discover", err)
+		return 1
+	}
+	return 0
+}
+
+func discover(opts discoverOptions, out io.Writer) error {
+	client, err := newBrokerClient(opts.connectionOptions)
+	if err != nil {
+		return err
+	}
+
+	var patterns []string
+	for _, pattern := range strings.Split(opts.caps, ",") {
+		if pattern = strings.TrimSpace(pattern); pattern != "" {
+			patterns = append(patterns, pattern)
+		}
+	}
+	envelope, err := protocol.NewDiscoverTools(client.agent).
+		Query(protocol.ToolQuery{
+			Capabilities:    patterns,
+			EnvironmentType: opts.environment,
+			MaxResults:      opts.maxResults,
+			IncludeMetadata: true,
+		}).
+		RequestID(protocol.NewRandomID()).
+		Capability(opts.capability).
+		Build()
+	if err != nil {
+		return err
+	}
+	ack, err := client.request(envelope)
+	if err != nil {
+		return err
+	}
+	var discovered protocol.ToolsDiscoveredBody
+	if err := ack.ResultAs(&discovered); err != nil {
+		return fmt.Errorf("invalid discovery result: %w", err)
+	}
+
+	if opts.json {
+		data, err := json.Marshal(discovered.Tools)
+		if err != nil {
+			return err
+		}
+		return printJSON(out, data)
+	}
+	printTools(out, discovered.Tools)
+	return nil
+}
+
+// printTools lists each discovered tool on its own line under its agent
+func printTools(out io.Writer, agents []protocol.DiscoveredTool) {
+	if len(agents) == 0 {
+		fmt.Fprintln(out, "No matching tools")
+		return
+	}
+	table := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(table, "AGENT\tENVIRONMENT\tTRUST\tTOOL\tDESCRIPTION")
+	for _, agent := range agents {
+		for _, tool := range agent.MCPTools {
+			fmt.Fprintf(table, "%s\t%s\t%.2f\t%s\t%s\n",
+				agent.AgentID, agent.EnvironmentType, agent.Metadata.TrustScore, tool.Name, tool.Description)
+		}
+	}
+	table.Flush()
+}
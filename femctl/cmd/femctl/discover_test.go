@@ -0,0 +1,76 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/fep-fem/protocol"
+)
+
+func TestDiscoverPrintsTools(t *testing.T) {
+	var query protocol.DiscoverToolsBody
+	server := respondingBroker(t, func(envelope *protocol.GenericEnvelope) (interface{}, int) {
+		typed, err := protocol.ParseTyped[protocol.DiscoverToolsBody](envelope)
+		if err != nil {
+			t.Errorf("Expected a discoverTools envelope: %v", err)
+		}
+		query = typed.Body
+		return protocol.NewAck("broker-1", envelope.Nonce, "discovered", protocol.ToolsDiscoveredBody{
+			RequestID: query.RequestID,
+			Tools: []protocol.DiscoveredTool{{
+				AgentID:         "calc-agent",
+				EnvironmentType: "local",
+				MCPTools:        []protocol.MCPTool{{Name: "math.add", Description: "Add two numbers"}, {Name: "math.mul"}},
+				Metadata:        protocol.ToolMetadata{TrustScore: 0.9},
+			}},
+		}), http.StatusOK
+	})
+	defer server.Close()
+
+	opts := discoverOptions{connectionOptions: testConnection(t, server.URL, ""), caps: "math.*, file.read", capability: "tok"}
+	opts.agent = defaultAgent
+	var out bytes.Buffer
+	if err := discover(opts, &out); err != nil {
+		t.Fatalf("Discover failed: %v", err)
+	}
+	if !reflect.DeepEqual(query.Query.Capabilities, []string{"math.*", "file.read"}) || query.Capability != "tok" {
+		t.Errorf("Unexpected query: %+v", query)
+	}
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	if len(lines) != 3 || !strings.Contains(lines[1], "calc-agent") || !strings.Contains(lines[1], "0.90") || !strings.Contains(lines[1], "Add two numbers") {
+		t.Errorf("Unexpected table:\n%s", out.String())
+	}
+
+	out.Reset()
+	opts.json = true
+	if err := discover(opts, &out); err != nil || !strings.Contains(out.String(), `"agentId": "calc-agent"`) {
+		t.Errorf("Expected JSON output, got %v\n%s", err, out.String())
+	}
+}
+
+func TestDiscoverReportsRejection(t *testing.T) {
+	server := respondingBroker(t, func(envelope *protocol.GenericEnvelope) (interface{}, int) {
+		rejection := protocol.NewError("broker-1", envelope.Nonce, protocol.CodeUnauthorized, "Discovery requires a registered agent")
+		rejection.Body.Details = map[string]interface{}{"agent": envelope.Agent}
+		return rejection, http.StatusUnauthorized
+	})
+	defer server.Close()
+
+	opts := discoverOptions{connectionOptions: testConnection(t, server.URL, ""), caps: "*"}
+	opts.agent = "nobody"
+	err := discover(opts, &bytes.Buffer{})
+	var rejection *protocol.ErrorBody
+	if !errors.As(err, &rejection) || rejection.Code != protocol.CodeUnauthorized {
+		t.Fatalf("Expected the rejection, got %v", err)
+	}
+
+	var out bytes.Buffer
+	reportError(&out, "discover", err)
+	if !strings.Contains(out.String(), "unauthorized: Discovery requires") || !strings.Contains(out.String(), `"agent": "nobody"`) {
+		t.Errorf("Expected the rejection and its details, got:\n%s", out.String())
+	}
+}
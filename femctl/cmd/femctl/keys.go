@@ -0,0 +1,275 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/fep-fem/protocol"
+)
+
+// keygenOptions are the flags of femctl keygen
+type keygenOptions struct {
+	keystoreOptions
+	force bool
+}
+
+func runKeygen(args []string) int {
+	var opts keygenOptions
+	flags := flag.NewFlagSet("keygen", flag.ContinueOnError)
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "Usage: femctl keygen <name> [flags]")
+		flags.PrintDefaults()
+	}
+	opts.register(flags)
+	flags.BoolVar(&opts.force, "force", false, "Replace an existing identity of the same name")
+	positional, err := parseInterleaved(flags, args)
+	if err != nil {
+		return 2
+	}
+	if len(positional) != 1 {
+		fmt.Fprintln(os.Stderr, "femctl keygen: want an identity name")
+		flags.Usage()
+		return 2
+	}
+
+	if err := keygen(opts, positional[0], os.Stdout, os.Stderr); err != nil {
+		fmt.Fprintf(os.Stderr, "femctl keygen: %v\n", err)
+		return 1
+	}
+	return 0
+}
+
+func keygen(opts keygenOptions, name string, out, warnings io.Writer) error {
+	store, err := opts.open()
+	if err != nil {
+		return err
+	}
+	if err := checkIdentityName(name); err != nil {
+		return err
+	}
+	exists, err := store.exists(name)
+	if err != nil {
+		return err
+	}
+	if exists && !opts.force {
+		return fmt.Errorf("identity %s already exists in %s; use --force to replace it, or femctl key rotate to rotate it with a broker", name, store.location(name))
+	}
+
+	_, key, err := protocol.GenerateKeyPair()
+	if err != nil {
+		return err
+	}
+	if err := store.save(name, key); err != nil {
+		return err
+	}
+	if !store.keychain && store.passphrase == nil {
+		fmt.Fprintln(warnings, "femctl keygen: no key passphrase set; the key is stored unencrypted")
+	}
+	printIdentity(out, name, key.Public().(ed25519.PublicKey), store.location(name))
+	return nil
+}
+
+// printIdentity prints an identity's public key, its did:key form for use as
+// an agent ID, and where it is stored
+func printIdentity(out io.Writer, name string, publicKey ed25519.PublicKey, location string) {
+	fmt.Fprintf(out, "identity: %s\npubkey:   %s\ndid:      %s\nstored:   %s\n",
+		name, protocol.EncodePublicKey(publicKey), protocol.DIDKey(publicKey), location)
+}
+
+// keySubcommands are the subcommands of femctl key
+var keySubcommands = map[string]command{
+	"show":   {"Print an identity's public key", runKeyShow},
+	"rotate": {"Replace an identity's key and register the new key with a broker", runKeyRotate},
+}
+
+func runKey(args []string) int {
+	if len(args) == 0 {
+		keyUsage()
+		return 2
+	}
+	cmd, exists := keySubcommands[args[0]]
+	if !exists {
+		fmt.Fprintf(os.Stderr, "femctl key: unknown command %q\n", args[0])
+		keyUsage()
+		return 2
+	}
+	return cmd.run(args[1:])
+}
+
+func keyUsage() {
+	fmt.Fprintln(os.Stderr, "Usage: femctl key <command> <name> [flags]")
+	fmt.Fprintln(os.Stderr, "\nCommands:")
+	for _, name := range []string{"show", "rotate"} {
+		fmt.Fprintf(os.Stderr, "  %-10s %s\n", name, keySubcommands[name].summary)
+	}
+}
+
+// keyShowOptions are the flags of femctl key show
+type keyShowOptions struct {
+	keystoreOptions
+	json bool
+}
+
+func runKeyShow(args []string) int {
+	var opts keyShowOptions
+	flags := flag.NewFlagSet("key show", flag.ContinueOnError)
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "Usage: femctl key show <name> [flags]")
+		flags.PrintDefaults()
+	}
+	opts.register(flags)
+	flags.BoolVar(&opts.json, "json", false, "Print the identity as JSON")
+	positional, err := parseInterleaved(flags, args)
+	if err != nil {
+		return 2
+	}
+	if len(positional) != 1 {
+		fmt.Fprintln(os.Stderr, "femctl key show: want an identity name")
+		flags.Usage()
+		return 2
+	}
+
+	if err := showKey(opts, positional[0], os.Stdout); err != nil {
+		fmt.Fprintf(os.Stderr, "femctl key show: %v\n", err)
+		return 1
+	}
+	return 0
+}
+
+func showKey(opts keyShowOptions, name string, out io.Writer) error {
+	store, err := opts.open()
+	if err != nil {
+		return err
+	}
+	publicKey, err := store.publicKey(name)
+	if err != nil {
+		return err
+	}
+	encrypted, err := store.encrypted(name)
+	if err != nil {
+		return err
+	}
+
+	if opts.json {
+		data, err := json.Marshal(map[string]interface{}{
+			"identity":  name,
+			"pubkey":    protocol.EncodePublicKey(publicKey),
+			"did":       protocol.DIDKey(publicKey),
+			"stored":    store.location(name),
+			"encrypted": encrypted,
+		})
+		if err != nil {
+			return err
+		}
+		return printJSON(out, data)
+	}
+	printIdentity(out, name, publicKey, store.location(name))
+	fmt.Fprintf(out, "encrypted: %t\n", encrypted)
+	return nil
+}
+
+// keyRotateOptions are the flags of femctl key rotate
+type keyRotateOptions struct {
+	connectionOptions
+	reason string
+}
+
+func runKeyRotate(args []string) int {
+	var opts keyRotateOptions
+	flags := flag.NewFlagSet("key rotate", flag.ContinueOnError)
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "Usage: femctl key rotate <name> [flags]")
+		flags.PrintDefaults()
+	}
+	opts.register(flags, "Agent ID the identity is registered as (the identity name if empty)")
+	flags.StringVar(&opts.reason, "reason", "", "Reason for the rotation, recorded by the broker")
+	positional, err := parseInterleaved(flags, args)
+	if err != nil {
+		return 2
+	}
+	if len(positional) != 1 {
+		fmt.Fprintln(os.Stderr, "femctl key rotate: want an identity name")
+		flags.Usage()
+		return 2
+	}
+
+	if err := rotateKey(opts, positional[0], os.Stdout); err != nil {
+		reportError(os.Stderr, "key rotate", err)
+		return 1
+	}
+	return 0
+}
+
+// rotateKey replaces the key of identity name with a new one once the broker
+// accepts the rotation. The new key is saved as <name>.new before the broker
+// is asked, so it is not lost if the broker accepts and saving it fails, and
+// the replaced key is kept as <name>.old.
+func rotateKey(opts keyRotateOptions, name string, out io.Writer) error {
+	if opts.signKey != "" {
+		return fmt.Errorf("--sign-key cannot be used with key rotate; the identity's key signs the rotation")
+	}
+	opts.identity = name
+	if opts.agent == "" {
+		opts.agent = name
+	}
+	client, err := newBrokerClient(opts.connectionOptions)
+	if err != nil {
+		return err
+	}
+	store, err := opts.keystoreOptions.open()
+	if err != nil {
+		return err
+	}
+
+	pending := name + ".new"
+	exists, err := store.exists(pending)
+	if err != nil {
+		return err
+	}
+	if exists {
+		return fmt.Errorf("an earlier rotation left a new key in %s; if the broker accepted it, make it the identity's key, otherwise remove it", store.location(pending))
+	}
+	_, newKey, err := protocol.GenerateKeyPair()
+	if err != nil {
+		return err
+	}
+	if err := store.save(pending, newKey); err != nil {
+		return err
+	}
+
+	envelope, err := protocol.NewKeyRotation(client.agent, client.key, newKey, opts.reason)
+	if err != nil {
+		store.remove(pending)
+		return err
+	}
+	ack, err := client.request(envelope)
+	if err != nil {
+		store.remove(pending)
+		return err
+	}
+
+	if err := store.save(name+".old", client.key); err != nil {
+		return fmt.Errorf("broker accepted the new key, but saving the old one failed (new key kept in %s): %w", store.location(pending), err)
+	}
+	if err := store.save(name, newKey); err != nil {
+		return fmt.Errorf("broker accepted the new key, but saving it failed (new key kept in %s): %w", store.location(pending), err)
+	}
+	store.remove(pending)
+
+	var rotated struct {
+		PreviousKeyExpires protocol.Time `json:"previousKeyExpires"`
+	}
+	ack.ResultAs(&rotated)
+	fmt.Fprintf(out, "status:   %s\n", ack.Status)
+	printIdentity(out, name, newKey.Public().(ed25519.PublicKey), store.location(name))
+	fmt.Fprintf(out, "previous: %s, kept in %s", protocol.EncodePublicKey(client.key.Public().(ed25519.PublicKey)), store.location(name+".old"))
+	if !rotated.PreviousKeyExpires.IsZero() {
+		fmt.Fprintf(out, ", accepted by the broker until %s", rotated.PreviousKeyExpires)
+	}
+	fmt.Fprintln(out)
+	return nil
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"errors"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/fep-fem/protocol"
+	"github.com/zalando/go-keyring"
+)
+
+// testKeystore returns keystore flags for a fresh directory whose identities
+// are encrypted with a passphrase
+func testKeystore(t *testing.T) keystoreOptions {
+	dir := t.TempDir()
+	passphraseFile := filepath.Join(dir, "passphrase")
+	if err := os.WriteFile(passphraseFile, []byte("correct horse\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return keystoreOptions{dir: filepath.Join(dir, "keys"), passphraseFile: passphraseFile}
+}
+
+func TestKeygenAndShow(t *testing.T) {
+	store := testKeystore(t)
+	var out, warnings bytes.Buffer
+	if err := keygen(keygenOptions{keystoreOptions: store}, "calc-agent", &out, &warnings); err != nil {
+		t.Fatalf("Keygen failed: %v", err)
+	}
+	if warnings.Len() != 0 || !strings.Contains(out.String(), filepath.Join(store.dir, "calc-agent.key")) {
+		t.Errorf("Unexpected keygen output:\n%s%s", out.String(), warnings.String())
+	}
+	if err := keygen(keygenOptions{keystoreOptions: store}, "calc-agent", &out, &warnings); err == nil || !strings.Contains(err.Error(), "already exists") {
+		t.Errorf("Expected an existing identity to be kept, got %v", err)
+	}
+	if err := keygen(keygenOptions{keystoreOptions: store}, "../escape", &out, &warnings); err == nil {
+		t.Error("Expected a name with a path separator to be rejected")
+	}
+
+	// Showing an identity needs no passphrase
+	key, _ := mustOpen(t, store).load("calc-agent")
+	var shown bytes.Buffer
+	opts := keyShowOptions{keystoreOptions: keystoreOptions{dir: store.dir}, json: true}
+	if err := showKey(opts, "calc-agent", &shown); err != nil {
+		t.Fatalf("Show failed: %v", err)
+	}
+	pubkey := protocol.EncodePublicKey(key.Public().(ed25519.PublicKey))
+	did := protocol.DIDKey(key.Public().(ed25519.PublicKey))
+	if !strings.Contains(shown.String(), pubkey) || !strings.Contains(shown.String(), did) || !strings.Contains(shown.String(), `"encrypted": true`) {
+		t.Errorf("Expected the public key of an encrypted identity, got:\n%s", shown.String())
+	}
+	if err := showKey(opts, "missing", &shown); !errors.Is(err, errNoIdentity) {
+		t.Errorf("Expected errNoIdentity, got %v", err)
+	}
+}
+
+func TestKeychainIdentity(t *testing.T) {
+	keyring.MockInit()
+	store := keystoreOptions{keychain: true}
+	if err := keygen(keygenOptions{keystoreOptions: store}, "cli", &bytes.Buffer{}, &bytes.Buffer{}); err != nil {
+		t.Fatalf("Keygen failed: %v", err)
+	}
+	key, err := mustOpen(t, store).load("cli")
+	if err != nil {
+		t.Fatalf("Expected the identity in the keychain: %v", err)
+	}
+
+	// Commands sign with --identity
+	server := fakeBroker(t, key.Public().(ed25519.PublicKey))
+	defer server.Close()
+	opts := discoverOptions{connectionOptions: testConnection(t, server.URL, ""), caps: "*"}
+	opts.identity, opts.agent, opts.keystoreOptions = "cli", "cli", store
+	client, err := newBrokerClient(opts.connectionOptions)
+	if err != nil || !client.key.Equal(key) {
+		t.Fatalf("Expected the client to sign with the identity, got %v", err)
+	}
+	opts.signKey = "other.pem"
+	if _, err := newBrokerClient(opts.connectionOptions); err == nil {
+		t.Error("Expected --sign-key and --identity to be refused together")
+	}
+}
+
+func TestKeyRotate(t *testing.T) {
+	store := testKeystore(t)
+	if err := keygen(keygenOptions{keystoreOptions: store}, "calc-agent", &bytes.Buffer{}, &bytes.Buffer{}); err != nil {
+		t.Fatal(err)
+	}
+	keys := mustOpen(t, store)
+	oldKey, _ := keys.load("calc-agent")
+
+	var reject bool
+	server := respondingBroker(t, func(envelope *protocol.GenericEnvelope) (interface{}, int) {
+		if _, err := protocol.VerifyKeyRotation(envelope, oldKey.Public().(ed25519.PublicKey)); err != nil || reject {
+			return protocol.NewError("broker-1", envelope.Nonce, protocol.CodeInvalidSignature, "Key rotation refused"), http.StatusUnauthorized
+		}
+		return protocol.NewAck("broker-1", envelope.Nonce, "rotated", nil), http.StatusOK
+	})
+	defer server.Close()
+
+	opts := keyRotateOptions{connectionOptions: testConnection(t, server.URL, ""), reason: "scheduled"}
+	opts.keystoreOptions = store
+
+	// A refused rotation leaves the identity as it was
+	reject = true
+	if err := rotateKey(opts, "calc-agent", &bytes.Buffer{}); err == nil {
+		t.Fatal("Expected the broker's refusal")
+	}
+	if key, _ := keys.load("calc-agent"); !key.Equal(oldKey) {
+		t.Error("Expected the key to be kept after a refused rotation")
+	}
+	if exists, _ := keys.exists("calc-agent.new"); exists {
+		t.Error("Expected the pending key to be removed")
+	}
+
+	reject = false
+	var out bytes.Buffer
+	if err := rotateKey(opts, "calc-agent", &out); err != nil {
+		t.Fatalf("Rotate failed: %v", err)
+	}
+	newKey, _ := keys.load("calc-agent")
+	if newKey.Equal(oldKey) || !strings.Contains(out.String(), protocol.EncodePublicKey(newKey.Public().(ed25519.PublicKey))) {
+		t.Errorf("Expected a new key, got:\n%s", out.String())
+	}
+	if kept, _ := keys.load("calc-agent.old"); !kept.Equal(oldKey) {
+		t.Error("Expected the old key to be kept")
+	}
+	if exists, _ := keys.exists("calc-agent.new"); exists {
+		t.Error("Expected the pending key to be removed")
+	}
+}
+
+func mustOpen(t *testing.T, opts keystoreOptions) *keystore {
+	store, err := opts.open()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return store
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+
+	"github.com/fep-fem/protocol"
+	"github.com/zalando/go-keyring"
+)
+
+// keychainService is the service name identities are filed under in the OS keychain
+const keychainService = "fem-identity"
+
+// errNoIdentity is returned when a named identity does not exist
+var errNoIdentity = errors.New("no such identity")
+
+// identityName restricts names to ones that are safe as file names and
+// keychain accounts; they are usually agent IDs
+var identityName = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._@-]*$`)
+
+// keystoreOptions are the flags locating named identities
+type keystoreOptions struct {
+	dir            string
+	keychain       bool
+	passphraseFile string
+}
+
+func (o *keystoreOptions) register(flags *flag.FlagSet) {
+	flags.StringVar(&o.dir, "keystore", defaultKeystoreDir(), "Directory holding named identities, shared with fem-coder")
+	flags.BoolVar(&o.keychain, "keychain", false, "Keep identities in the OS keychain instead of --keystore")
+	flags.StringVar(&o.passphraseFile, "key-passphrase-file", os.Getenv("FEM_KEY_PASSPHRASE_FILE"), "File holding the passphrase encrypting identity files (FEM_KEY_PASSPHRASE if empty)")
+}
+
+// open returns the keystore the options describe
+func (o keystoreOptions) open() (*keystore, error) {
+	if o.keychain {
+		return &keystore{keychain: true}, nil
+	}
+	passphrase, err := protocol.ReadPassphrase(o.passphraseFile)
+	if err != nil {
+		return nil, err
+	}
+	return &keystore{dir: o.dir, passphrase: passphrase}, nil
+}
+
+// defaultKeystoreDir is where fem-coder keeps its key by default, so
+// "femctl keygen <agent>" creates the key that agent will use
+func defaultKeystoreDir() string {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "keys"
+	}
+	return filepath.Join(home, ".fem", "keys")
+}
+
+// keystore holds Ed25519 identities by name, as key files in dir or as
+// entries in the OS keychain
+type keystore struct {
+	dir        string
+	passphrase []byte // Encrypts key files; nil stores them as base64
+	keychain   bool
+}
+
+// path returns the key file of the identity name
+func (k *keystore) path(name string) string {
+	return filepath.Join(k.dir, name+".key")
+}
+
+// location describes where the identity name is stored
+func (k *keystore) location(name string) string {
+	if k.keychain {
+		return fmt.Sprintf("OS keychain (%s/%s)", keychainService, name)
+	}
+	return k.path(name)
+}
+
+func checkIdentityName(name string) error {
+	if !identityName.MatchString(name) {
+		return fmt.Errorf("invalid identity name %q: use letters, digits, '.', '_', '@' and '-'", name)
+	}
+	return nil
+}
+
+// exists reports whether the identity name is stored
+func (k *keystore) exists(name string) (bool, error) {
+	_, err := k.publicKey(name)
+	if errors.Is(err, errNoIdentity) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// load returns the private key of the identity name
+func (k *keystore) load(name string) (ed25519.PrivateKey, error) {
+	if err := checkIdentityName(name); err != nil {
+		return nil, err
+	}
+	if k.keychain {
+		secret, err := keyring.Get(keychainService, name)
+		if errors.Is(err, keyring.ErrNotFound) {
+			return nil, fmt.Errorf("%w: %s", errNoIdentity, name)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read keychain: %w", err)
+		}
+		return protocol.DecodePrivateKey(secret)
+	}
+	key, err := protocol.LoadPrivateKey(k.path(name), k.passphrase)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("%w: %s", errNoIdentity, name)
+	}
+	return key, err
+}
+
+// publicKey returns the public key of the identity name. Key files are not
+// decrypted, so this works without the passphrase.
+func (k *keystore) publicKey(name string) (ed25519.PublicKey, error) {
+	if k.keychain {
+		key, err := k.load(name)
+		if err != nil {
+			return nil, err
+		}
+		return key.Public().(ed25519.PublicKey), nil
+	}
+	if err := checkIdentityName(name); err != nil {
+		return nil, err
+	}
+	publicKey, _, err := protocol.LoadPublicKey(k.path(name))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("%w: %s", errNoIdentity, name)
+	}
+	return publicKey, err
+}
+
+// encrypted reports whether the identity name is protected at rest, by a
+// passphrase or by the keychain
+func (k *keystore) encrypted(name string) (bool, error) {
+	if k.keychain {
+		return true, nil
+	}
+	_, encrypted, err := protocol.LoadPublicKey(k.path(name))
+	return encrypted, err
+}
+
+// save stores key as the identity name, replacing any existing one
+func (k *keystore) save(name string, key ed25519.PrivateKey) error {
+	if err := checkIdentityName(name); err != nil {
+		return err
+	}
+	if k.keychain {
+		if err := keyring.Set(keychainService, name, protocol.EncodePrivateKey(key)); err != nil {
+			return fmt.Errorf("failed to write keychain: %w", err)
+		}
+		return nil
+	}
+	return protocol.SavePrivateKey(k.path(name), key, k.passphrase)
+}
+
+// remove deletes the identity name, if it exists
+func (k *keystore) remove(name string) error {
+	if k.keychain {
+		if err := keyring.Delete(keychainService, name); err != nil && !errors.Is(err, keyring.ErrNotFound) {
+			return fmt.Errorf("failed to write keychain: %w", err)
+		}
+		return nil
+	}
+	if err := os.Remove(k.path(name)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return nil
+}
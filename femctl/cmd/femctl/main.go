@@ -0,0 +1,60 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// command is a femctl subcommand. run receives the arguments after its name
+// and returns the process exit code.
+type command struct {
+	summary string
+	run     func(args []string) int
+}
+
+var commands = map[string]command{
+	"send":     {"Sign an envelope from a file, post it to a broker and print the response", runSend},
+	"discover": {"List the tools agents offer, by capability pattern", runDiscover},
+	"call":     {"Call an agent's tool and optionally wait for its result", runCall},
+	"keygen":   {"Create a named Ed25519 identity in the local keystore", runKeygen},
+	"key":      {"Show or rotate a named identity", runKey},
+}
+
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+		os.Exit(2)
+	}
+	cmd, exists := commands[os.Args[1]]
+	if !exists {
+		fmt.Fprintf(os.Stderr, "femctl: unknown command %q\n", os.Args[1])
+		usage()
+		os.Exit(2)
+	}
+	os.Exit(cmd.run(os.Args[2:]))
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "Usage: femctl <command> [flags]")
+	fmt.Fprintln(os.Stderr, "\nCommands:")
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintf(os.Stderr, "  %-10s %s\n", name, commands[name].summary)
+	}
+	fmt.Fprintln(os.Stderr, "\nRun 'femctl <command> -h' for the command's flags.")
+}
+
+// defaultPinFile keeps broker pins in the user's home directory, shared with fem-coder
+func defaultPinFile() string {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "fem-known-brokers.json"
+	}
+	return filepath.Join(home, ".fem", "known_brokers.json")
+}
@@ -0,0 +1,219 @@
+package main
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/fep-fem/protocol"
+)
+
+// sendOptions are the flags of femctl send
+type sendOptions struct {
+	connectionOptions
+	file        string
+	keepHeaders bool
+	validate    bool
+	dryRun      bool
+	raw         bool
+}
+
+func runSend(args []string) int {
+	var opts sendOptions
+	flags := flag.NewFlagSet("send", flag.ContinueOnError)
+	opts.register(flags, "Override the envelope's agent header")
+	flags.StringVar(&opts.file, "file", "", "File holding the envelope as JSON, - for stdin")
+	flags.BoolVar(&opts.keepHeaders, "keep-headers", false, "Send ts and nonce as in the file instead of fresh ones")
+	flags.BoolVar(&opts.validate, "validate", true, "Check the envelope against its schema before sending")
+	flags.BoolVar(&opts.dryRun, "dry-run", false, "Print the signed envelope instead of sending it")
+	flags.BoolVar(&opts.raw, "raw", false, "Print the response body exactly as received")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	if opts.file == "" {
+		fmt.Fprintln(os.Stderr, "femctl send: --file is required")
+		flags.Usage()
+		return 2
+	}
+
+	if err := send(opts, os.Stdin, os.Stdout); err != nil {
+		fmt.Fprintf(os.Stderr, "femctl send: %v\n", err)
+		return 1
+	}
+	return 0
+}
+
+// errRejected is returned when the broker answers with an error envelope, which
+// has already been printed
+var errRejected = errors.New("broker rejected the envelope")
+
+func send(opts sendOptions, stdin io.Reader, out io.Writer) error {
+	var data []byte
+	var err error
+	if opts.file == "-" {
+		data, err = io.ReadAll(stdin)
+	} else {
+		data, err = os.ReadFile(opts.file)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read envelope: %w", err)
+	}
+
+	client, err := newBrokerClient(opts.connectionOptions)
+	if err != nil {
+		return err
+	}
+	envelope, err := prepareEnvelope(data, opts.agent, opts.keepHeaders, client.key)
+	if err != nil {
+		return err
+	}
+	payload, err := json.Marshal(envelope)
+	if err != nil {
+		return fmt.Errorf("failed to marshal envelope: %w", err)
+	}
+	if opts.validate {
+		if err := protocol.ValidateEnvelope(payload); err != nil {
+			return fmt.Errorf("envelope is invalid (send anyway with --validate=false): %w", err)
+		}
+	}
+	if opts.dryRun {
+		return printJSON(out, payload)
+	}
+
+	resp, err := client.post(payload, envelope.Nonce)
+	if err != nil {
+		return err
+	}
+	if opts.raw {
+		out.Write(resp.body)
+		if !resp.ok {
+			return errRejected
+		}
+		return nil
+	}
+	return printResponse(out, resp.status, resp.body)
+}
+
+// loadSigningKey reads an Ed25519 private key stored as PKCS#8 PEM, as written
+// by openssl genpkey -algorithm ed25519, or base64 as in broker identity key files
+func loadSigningKey(path string) (ed25519.PrivateKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read signing key: %w", err)
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		key, err := protocol.DecodePrivateKey(strings.TrimSpace(string(data)))
+		if err != nil {
+			return nil, fmt.Errorf("signing key is neither PEM nor base64: %w", err)
+		}
+		return key, nil
+	}
+	parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse signing key: %w", err)
+	}
+	key, ok := parsed.(ed25519.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("signing key is %T, not Ed25519", parsed)
+	}
+	return key, nil
+}
+
+// prepareEnvelope fills in the headers an envelope file may leave out and
+// signs it. Unless keepHeaders is set, ts and nonce are refreshed so the same
+// file can be sent repeatedly without tripping replay protection.
+func prepareEnvelope(data []byte, agent string, keepHeaders bool, key ed25519.PrivateKey) (*protocol.Envelope, error) {
+	var envelope protocol.Envelope
+	if err := json.Unmarshal(data, &envelope); err != nil {
+		return nil, fmt.Errorf("failed to parse envelope: %w", err)
+	}
+	if envelope.Type == "" {
+		return nil, fmt.Errorf("envelope has no type")
+	}
+	if agent != "" {
+		envelope.Agent = agent
+	}
+	if len(envelope.Body) == 0 {
+		envelope.Body = json.RawMessage("{}")
+	}
+
+	fresh := protocol.NewEnvelope(envelope.Type, envelope.Agent)
+	if !keepHeaders || envelope.TS == 0 {
+		envelope.TS = fresh.TS
+	}
+	if !keepHeaders || envelope.Nonce == "" {
+		envelope.Nonce = fresh.Nonce
+	}
+	if envelope.Proto == "" {
+		envelope.Proto = fresh.Proto
+	}
+
+	if key != nil {
+		if err := envelope.Sign(key); err != nil {
+			return nil, fmt.Errorf("failed to sign envelope: %w", err)
+		}
+	}
+	return &envelope, nil
+}
+
+// printResponse pretty-prints the broker's answer: the envelope type and
+// sender, then the ack's status and result or the error's code, message and
+// details. Error envelopes and other failures return errRejected.
+func printResponse(out io.Writer, status string, body []byte) error {
+	envelope, err := protocol.ParseEnvelope(body)
+	if err != nil {
+		// Not an envelope, e.g. a plain text HTTP error
+		fmt.Fprintf(out, "%s\n%s\n", status, strings.TrimSpace(string(body)))
+		return errRejected
+	}
+	fmt.Fprintf(out, "%s  %s from %s\n", status, envelope.Type, envelope.Agent)
+
+	switch envelope.Type {
+	case protocol.EnvelopeAck:
+		ack, err := protocol.ParseTyped[protocol.AckBody](envelope)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(out, "ref:     %s\nstatus:  %s\n", ack.Body.Ref, ack.Body.Status)
+		if ack.Body.Result != nil {
+			result, _ := json.Marshal(ack.Body.Result)
+			fmt.Fprint(out, "result:  ")
+			return printJSON(out, result)
+		}
+		return nil
+	case protocol.EnvelopeError:
+		rejection, err := protocol.ParseTyped[protocol.ErrorBody](envelope)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(out, "ref:     %s\ncode:    %s\nmessage: %s\n", rejection.Body.Ref, rejection.Body.Code, rejection.Body.Message)
+		if len(rejection.Body.Details) > 0 {
+			details, _ := json.Marshal(rejection.Body.Details)
+			fmt.Fprint(out, "details: ")
+			printJSON(out, details)
+		}
+		return errRejected
+	default:
+		return printJSON(out, envelope.Body)
+	}
+}
+
+// printJSON writes data indented
+func printJSON(out io.Writer, data []byte) error {
+	var indented bytes.Buffer
+	if err := json.Indent(&indented, data, "", "  "); err != nil {
+		return err
+	}
+	indented.WriteByte('\n')
+	_, err := out.Write(indented.Bytes())
+	return err
+}
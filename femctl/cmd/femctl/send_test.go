@@ -0,0 +1,135 @@
+package main
+
+import (
+	"bytes"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/fep-fem/protocol"
+)
+
+// fakeBroker verifies envelopes against agentKey and answers like a broker
+func fakeBroker(t *testing.T, agentKey []byte) *httptest.Server {
+	return respondingBroker(t, func(envelope *protocol.GenericEnvelope) (interface{}, int) {
+		if envelope.Verify(agentKey) != nil {
+			rejection := protocol.NewError("broker-1", envelope.Nonce, protocol.CodeInvalidSignature, "Signature does not verify")
+			rejection.Body.Details = map[string]interface{}{"agent": envelope.Agent}
+			return rejection, http.StatusUnauthorized
+		}
+		return protocol.NewAck("broker-1", envelope.Nonce, "processing", map[string]string{"tool": "math.add"}), http.StatusOK
+	})
+}
+
+// respondingBroker answers each envelope with respond's response, signed with
+// a broker identity key
+func respondingBroker(t *testing.T, respond func(envelope *protocol.GenericEnvelope) (interface{}, int)) *httptest.Server {
+	brokerPub, brokerKey, _ := protocol.GenerateKeyPair()
+	return httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		data, _ := io.ReadAll(r.Body)
+		envelope, err := protocol.ParseEnvelope(data)
+		if err != nil {
+			t.Errorf("Broker received an invalid envelope: %v", err)
+			return
+		}
+
+		response, status := respond(envelope)
+		body, _ := json.Marshal(response)
+		signature, _ := protocol.SignBrokerResponse(brokerKey, envelope.Nonce, body)
+		w.Header().Set(protocol.BrokerKeyHeader, protocol.EncodePublicKey(brokerPub))
+		w.Header().Set(protocol.BrokerSignatureHeader, signature)
+		w.WriteHeader(status)
+		w.Write(body)
+	}))
+}
+
+// testConnection connects to brokerURL with a fresh pin file
+func testConnection(t *testing.T, brokerURL, signKey string) connectionOptions {
+	return connectionOptions{brokerURL: brokerURL, signKey: signKey,
+		pinFile: filepath.Join(t.TempDir(), "pins.json"), pinMode: string(protocol.PinModeEnforce)}
+}
+
+func writeEnvelopeFile(t *testing.T) string {
+	path := filepath.Join(t.TempDir(), "envelope.json")
+	envelope := `{"type":"toolCall","agent":"cli","ts":1,"nonce":"fixed","body":{"tool":"math.add","requestId":"r1","parameters":{"a":1}}}`
+	if err := os.WriteFile(path, []byte(envelope), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestSendSignsAndPrintsAck(t *testing.T) {
+	pubKey, privKey, _ := protocol.GenerateKeyPair()
+	server := fakeBroker(t, pubKey)
+	defer server.Close()
+
+	// Keys written by openssl genpkey are PKCS#8 PEM
+	der, _ := x509.MarshalPKCS8PrivateKey(privKey)
+	keyPath := filepath.Join(t.TempDir(), "key.pem")
+	os.WriteFile(keyPath, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), 0o600)
+
+	opts := sendOptions{connectionOptions: testConnection(t, server.URL, keyPath), file: writeEnvelopeFile(t), validate: true}
+	var out bytes.Buffer
+	if err := send(opts, nil, &out); err != nil {
+		t.Fatalf("Send failed: %v\n%s", err, out.String())
+	}
+	for _, want := range []string{"200 OK  ack from broker-1", "status:  processing", `"tool": "math.add"`} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("Expected output to contain %q, got:\n%s", want, out.String())
+		}
+	}
+
+	// Sending the same file again uses a fresh nonce
+	out.Reset()
+	if err := send(opts, nil, &out); err != nil || strings.Contains(out.String(), "ref:     fixed") {
+		t.Errorf("Expected a fresh nonce on resend, got %v\n%s", err, out.String())
+	}
+}
+
+func TestSendPrintsRejection(t *testing.T) {
+	pubKey, _, _ := protocol.GenerateKeyPair()
+	_, otherKey, _ := protocol.GenerateKeyPair()
+	server := fakeBroker(t, pubKey)
+	defer server.Close()
+
+	keyPath := filepath.Join(t.TempDir(), "key")
+	os.WriteFile(keyPath, []byte(protocol.EncodePrivateKey(otherKey)+"\n"), 0o600)
+
+	opts := sendOptions{connectionOptions: testConnection(t, server.URL, keyPath), file: "-", keepHeaders: true, validate: true}
+	opts.agent = "other"
+	data, _ := os.ReadFile(writeEnvelopeFile(t))
+	var out bytes.Buffer
+	err := send(opts, bytes.NewReader(data), &out)
+	if !errors.Is(err, errRejected) {
+		t.Fatalf("Expected the rejection to be reported, got %v", err)
+	}
+	for _, want := range []string{"401 Unauthorized  error from broker-1", "ref:     fixed", "code:    invalid_signature", `"agent": "other"`} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("Expected output to contain %q, got:\n%s", want, out.String())
+		}
+	}
+}
+
+func TestSendValidatesBeforeSending(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "envelope.json")
+	os.WriteFile(path, []byte(`{"type":"toolCall","agent":"cli","body":{"requestId":"r1"}}`), 0o600)
+
+	opts := sendOptions{connectionOptions: connectionOptions{brokerURL: "https://127.0.0.1:1"}, file: path, validate: true}
+	if err := send(opts, nil, io.Discard); err == nil || !strings.Contains(err.Error(), "--validate=false") {
+		t.Errorf("Expected a schema error, got %v", err)
+	}
+
+	opts.validate, opts.dryRun = false, true
+	var out bytes.Buffer
+	if err := send(opts, nil, &out); err != nil || !strings.Contains(out.String(), `"nonce":`) {
+		t.Errorf("Expected the prepared envelope on a dry run, got %v\n%s", err, out.String())
+	}
+}
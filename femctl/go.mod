@@ -0,0 +1,20 @@
+module femctl
+
+go 1.21
+
+require (
+	github.com/fep-fem/protocol v0.0.0
+	github.com/zalando/go-keyring v0.2.3
+)
+
+require (
+	github.com/alessio/shellescape v1.4.1 // indirect
+	github.com/danieljoos/wincred v1.2.0 // indirect
+	github.com/godbus/dbus/v5 v5.1.0 // indirect
+	github.com/golang-jwt/jwt/v5 v5.2.0 // indirect
+	github.com/klauspost/compress v1.17.11 // indirect
+	golang.org/x/crypto v0.24.0 // indirect
+	golang.org/x/sys v0.21.0 // indirect
+)
+
+replace github.com/fep-fem/protocol => ../protocol/go
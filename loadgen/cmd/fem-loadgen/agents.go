@@ -0,0 +1,242 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/rand"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fep-fem/protocol"
+)
+
+// registerWorkers bounds the registrations sent at once
+const registerWorkers = 32
+
+// identity is a synthetic agent or client and its signing key
+type identity struct {
+	id  string
+	key ed25519.PrivateKey
+}
+
+// newIdentities creates count identities named <prefix>-<n>
+func newIdentities(prefix string, count int) ([]identity, error) {
+	identities := make([]identity, count)
+	for i := range identities {
+		_, key, err := protocol.GenerateKeyPair()
+		if err != nil {
+			return nil, err
+		}
+		identities[i] = identity{id: fmt.Sprintf("%s-%d", prefix, i+1), key: key}
+	}
+	return identities, nil
+}
+
+// registerAll registers identities with the broker, recording each
+// registration's latency, and returns the ones the broker accepted
+func registerAll(conn *brokerConn, identities []identity, body func(identity) protocol.RegisterAgentBody, stats *recorder) []identity {
+	accepted := make([]bool, len(identities))
+	work := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < registerWorkers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range work {
+				registration := body(identities[i])
+				registration.PubKey = protocol.EncodePublicKey(identities[i].key.Public().(ed25519.PublicKey))
+				start := time.Now()
+				_, err := conn.send(protocol.NewTypedEnvelope(identities[i].id, registration), identities[i].key)
+				stats.record(time.Since(start), err)
+				accepted[i] = err == nil
+			}
+		}()
+	}
+	for i := range identities {
+		work <- i
+	}
+	close(work)
+	wg.Wait()
+
+	var registered []identity
+	for i, ok := range accepted {
+		if ok {
+			registered = append(registered, identities[i])
+		}
+	}
+	return registered
+}
+
+// agentPool runs the synthetic agents: a shared MCP server answering their
+// tools with the scenario's latencies and, in direct dispatch, the answers
+// they post to the broker
+type agentPool struct {
+	scenario *Scenario
+	conn     *brokerConn
+	agents   map[string]identity // Every agent of the scenario; read-only once serving
+	ids      []string            // Agents the broker accepted, for clients to call
+	server   *http.Server
+	baseURL  string
+	answers  *recorder
+	pending  sync.WaitGroup // Answers not yet posted
+
+	randMu sync.Mutex
+	rand   *rand.Rand
+}
+
+// startAgentPool starts the MCP server of the scenario's agents
+func startAgentPool(scenario *Scenario, conn *brokerConn, answers *recorder) (*agentPool, error) {
+	identities, err := newIdentities(scenario.Agents.IDPrefix, scenario.Agents.Count)
+	if err != nil {
+		return nil, err
+	}
+	listener, err := net.Listen("tcp", scenario.Agents.Listen)
+	if err != nil {
+		return nil, fmt.Errorf("failed to listen for MCP calls: %w", err)
+	}
+	pool := &agentPool{
+		scenario: scenario,
+		conn:     conn,
+		agents:   make(map[string]identity, len(identities)),
+		baseURL:  "http://" + listener.Addr().String(),
+		answers:  answers,
+		rand:     rand.New(rand.NewSource(time.Now().UnixNano())),
+	}
+	for _, agent := range identities {
+		pool.agents[agent.id] = agent
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/agents/", pool.handleMCPCall)
+	pool.server = &http.Server{Handler: mux}
+	go pool.server.Serve(listener)
+	return pool, nil
+}
+
+// register registers the scenario's agents, keeping those the broker
+// accepted for clients to call
+func (p *agentPool) register(stats *recorder) {
+	identities := make([]identity, 0, len(p.agents))
+	for _, agent := range p.agents {
+		identities = append(identities, agent)
+	}
+	for _, agent := range registerAll(p.conn, identities, p.registration, stats) {
+		p.ids = append(p.ids, agent.id)
+	}
+}
+
+// registration describes an agent offering every tool of the scenario
+func (p *agentPool) registration(agent identity) protocol.RegisterAgentBody {
+	tools := make([]protocol.MCPTool, len(p.scenario.Agents.Tools))
+	capabilities := make([]string, len(tools))
+	for i, tool := range p.scenario.Agents.Tools {
+		tools[i] = protocol.MCPTool{
+			Name:        tool.Name,
+			Description: fmt.Sprintf("Synthetic tool answering in about %s", tool.Latency),
+			InputSchema: map[string]interface{}{"type": "object"},
+		}
+		capabilities[i] = tool.Name
+	}
+	return protocol.RegisterAgentBody{
+		Capabilities: capabilities,
+		MCPEndpoint:  fmt.Sprintf("%s/agents/%s/mcp", p.baseURL, agent.id),
+		BodyDefinition: &protocol.BodyDefinition{
+			Name:         "fem-loadgen",
+			Environment:  "load-test",
+			Capabilities: capabilities,
+			MCPTools:     tools,
+		},
+		EnvironmentType: "load-test",
+	}
+}
+
+// execute runs a synthetic tool: it waits the tool's latency and fails at
+// its error rate
+func (p *agentPool) execute(agentID, toolName string, arguments map[string]interface{}) (interface{}, error) {
+	tool, exists := p.scenario.tool(toolName)
+	if !exists {
+		return nil, fmt.Errorf("unknown tool %s", toolName)
+	}
+	p.randMu.Lock()
+	delay := tool.Latency
+	if tool.Jitter > 0 {
+		delay += time.Duration(p.rand.Int63n(int64(2*tool.Jitter)+1)) - tool.Jitter
+	}
+	fail := p.rand.Float64() < tool.ErrorRate
+	p.randMu.Unlock()
+
+	if delay > 0 {
+		time.Sleep(delay)
+	}
+	if fail {
+		return nil, errors.New("injected failure")
+	}
+	return map[string]interface{}{"agent": agentID, "tool": toolName, "arguments": arguments}, nil
+}
+
+// handleMCPCall answers a JSON-RPC tools/call to /agents/<id>/mcp
+func (p *agentPool) handleMCPCall(w http.ResponseWriter, r *http.Request) {
+	agentID, found := strings.CutSuffix(strings.TrimPrefix(r.URL.Path, "/agents/"), "/mcp")
+	if _, exists := p.agents[agentID]; !found || !exists || r.Method != http.MethodPost {
+		http.NotFound(w, r)
+		return
+	}
+	var request struct {
+		ID     interface{} `json:"id"`
+		Method string      `json:"method"`
+		Params struct {
+			Name      string                 `json:"name"`
+			Arguments map[string]interface{} `json:"arguments"`
+		} `json:"params"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil || request.Method != "tools/call" {
+		http.Error(w, "Expected a JSON-RPC tools/call", http.StatusBadRequest)
+		return
+	}
+
+	response := map[string]interface{}{"jsonrpc": "2.0", "id": request.ID}
+	if result, err := p.execute(agentID, request.Params.Name, request.Params.Arguments); err != nil {
+		response["error"] = map[string]interface{}{"code": -32603, "message": err.Error()}
+	} else {
+		response["result"] = result
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// dispatch hands an accepted call to its agent, which answers the broker with
+// a toolResult once the tool has run
+func (p *agentPool) dispatch(agentID, toolName, requestID string, arguments map[string]interface{}) {
+	agent, exists := p.agents[agentID]
+	if !exists {
+		return
+	}
+	p.pending.Add(1)
+	go func() {
+		defer p.pending.Done()
+		builder := protocol.NewToolResult(agent.id, requestID)
+		if result, err := p.execute(agent.id, toolName, arguments); err != nil {
+			builder.Error(err.Error())
+		} else {
+			builder.Result(result)
+		}
+		envelope, err := builder.Build()
+		if err != nil {
+			p.answers.record(0, err)
+			return
+		}
+		start := time.Now()
+		_, err = p.conn.send(envelope, agent.key)
+		p.answers.record(time.Since(start), err)
+	}()
+}
+
+// close waits for outstanding answers and stops the MCP server
+func (p *agentPool) close() {
+	p.pending.Wait()
+	p.server.Close()
+}
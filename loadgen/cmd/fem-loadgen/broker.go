@@ -0,0 +1,95 @@
+package main
+
+import (
+	"bytes"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/fep-fem/protocol"
+)
+
+// signable is an envelope fem-loadgen can sign and send
+type signable interface {
+	Sign(signer protocol.Signer) error
+}
+
+// brokerConn posts signed envelopes to the broker under test and checks its
+// answers against the pinned broker key
+type brokerConn struct {
+	url  string
+	http *http.Client
+	pins *protocol.BrokerPins
+}
+
+// newBrokerConn keeps up to connections idle connections to the broker, so
+// concurrent clients do not measure TLS handshakes
+func newBrokerConn(url string, pins *protocol.BrokerPins, connections int, timeout time.Duration) *brokerConn {
+	return &brokerConn{
+		url: url,
+		http: &http.Client{
+			Transport: &http.Transport{
+				TLSClientConfig: &tls.Config{
+					InsecureSkipVerify: true, // Brokers use self-signed certs; identity is checked by pinning
+				},
+				MaxIdleConns:        connections,
+				MaxIdleConnsPerHost: connections,
+			},
+			Timeout: timeout,
+		},
+		pins: pins,
+	}
+}
+
+// send signs envelope with key, posts it and returns the broker's ack. A
+// rejection is returned as an error wrapping the *protocol.ErrorBody.
+func (c *brokerConn) send(envelope signable, key protocol.Signer) (*protocol.AckBody, error) {
+	if err := envelope.Sign(key); err != nil {
+		return nil, fmt.Errorf("failed to sign envelope: %w", err)
+	}
+	payload, err := json.Marshal(envelope)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal envelope: %w", err)
+	}
+	var headers protocol.CommonHeaders
+	if err := json.Unmarshal(payload, &headers); err != nil {
+		return nil, fmt.Errorf("failed to read envelope headers: %w", err)
+	}
+
+	resp, err := c.http.Post(c.url, "application/json", bytes.NewReader(payload))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read response: %w", err)
+	}
+	if err := c.pins.VerifyResponse(c.url, resp.Header, headers.Nonce, body); err != nil {
+		return nil, fmt.Errorf("broker identity check failed: %w", err)
+	}
+
+	ack, err := protocol.ParseResponse(body)
+	var rejection *protocol.ErrorBody
+	switch {
+	case errors.As(err, &rejection):
+		return nil, fmt.Errorf("broker rejected %s: %s: %s", rejectedType(payload), rejection.Code, rejection.Message)
+	case err != nil:
+		return nil, fmt.Errorf("broker returned %s: %s", resp.Status, strings.TrimSpace(string(body)))
+	}
+	return ack, nil
+}
+
+// rejectedType names the type of an envelope for error messages
+func rejectedType(payload []byte) string {
+	var envelope struct {
+		Type protocol.EnvelopeType `json:"type"`
+	}
+	json.Unmarshal(payload, &envelope)
+	return string(envelope.Type)
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"time"
+
+	"github.com/fep-fem/protocol"
+)
+
+// errResultTimeout is recorded for calls whose result never arrived
+var errResultTimeout = errors.New("timed out waiting for result")
+
+// loadClient drives discovery queries and tool calls from one identity
+type loadClient struct {
+	identity
+	scenario *Scenario
+	conn     *brokerConn
+	pool     *agentPool
+	stats    map[string]*recorder
+	rand     *rand.Rand
+	acks     []string // Delivery keys to acknowledge with the next collection
+}
+
+// run issues operations until ctx is done, pacing them to the scenario's rate
+func (c *loadClient) run(ctx context.Context) {
+	var interval time.Duration
+	if c.scenario.Clients.Rate > 0 {
+		interval = time.Duration(float64(time.Second) / c.scenario.Clients.Rate)
+	}
+	next := time.Now()
+	for ctx.Err() == nil {
+		if interval > 0 {
+			if wait := time.Until(next); wait > 0 {
+				select {
+				case <-ctx.Done():
+					return
+				case <-time.After(wait):
+				}
+			}
+			next = next.Add(interval)
+		}
+		mix := c.scenario.Clients.Mix
+		if c.rand.Intn(mix.Discover+mix.Call) < mix.Discover {
+			c.discover()
+		} else {
+			c.call()
+		}
+	}
+}
+
+// discover runs one discovery query
+func (c *loadClient) discover() {
+	envelope, err := protocol.NewDiscoverTools(c.id).Capabilities(c.scenario.Clients.DiscoverCaps...).Build()
+	if err != nil {
+		c.stats[opDiscover].record(0, err)
+		return
+	}
+	start := time.Now()
+	_, err = c.conn.send(envelope, c.key)
+	c.stats[opDiscover].record(time.Since(start), err)
+}
+
+// call calls a random tool of a random agent and, unless the scenario skips
+// results, waits for the result
+func (c *loadClient) call() {
+	agentID := c.pool.ids[c.rand.Intn(len(c.pool.ids))]
+	tool := c.scenario.Agents.Tools[c.rand.Intn(len(c.scenario.Agents.Tools))].Name
+	arguments := map[string]interface{}{"client": c.id}
+	envelope, err := protocol.NewToolCall(c.id).
+		Tool(agentID + "/" + tool).
+		Params(arguments).
+		Build()
+	if err != nil {
+		c.stats[opCall].record(0, err)
+		return
+	}
+	start := time.Now()
+	_, err = c.conn.send(envelope, c.key)
+	c.stats[opCall].record(time.Since(start), err)
+	if err != nil {
+		return
+	}
+	if c.scenario.Agents.Dispatch == dispatchDirect {
+		c.pool.dispatch(agentID, tool, envelope.Body.RequestID, arguments)
+	}
+	if c.scenario.Clients.SkipResults {
+		return
+	}
+	err = c.awaitResult(envelope.Body.RequestID)
+	c.stats[opResult].record(time.Since(start), err)
+}
+
+// awaitResult polls the broker until the result of requestID arrives or the
+// scenario's result timeout passes, even once the run has ended
+func (c *loadClient) awaitResult(requestID string) error {
+	deadline := time.Now().Add(c.scenario.Clients.ResultTimeout)
+	for {
+		result, err := c.collect(requestID)
+		switch {
+		case err != nil:
+			return err
+		case result != nil && !result.Success:
+			return fmt.Errorf("tool failed: %s", result.Error)
+		case result != nil:
+			return nil
+		case time.Now().After(deadline):
+			return errResultTimeout
+		}
+		time.Sleep(c.scenario.Clients.PollInterval)
+	}
+}
+
+// collect acknowledges earlier deliveries, fetches pending results and
+// returns the one for requestID, if it has arrived
+func (c *loadClient) collect(requestID string) (*protocol.ToolResultBody, error) {
+	envelope := protocol.NewTypedEnvelope(c.id, protocol.ResultAckBody{Acknowledged: c.acks})
+	ack, err := c.conn.send(envelope, c.key)
+	if err != nil {
+		return nil, err
+	}
+	c.acks = nil
+	var delivery protocol.ResultDeliveryBody
+	if err := ack.ResultAs(&delivery); err != nil {
+		return nil, fmt.Errorf("invalid result delivery: %w", err)
+	}
+
+	var found *protocol.ToolResultBody
+	for _, result := range delivery.Results {
+		if result.Delivery == protocol.DeliverAtLeastOnce {
+			c.acks = append(c.acks, result.Key())
+		}
+		if result.RequestID != requestID || result.Chunk != 0 {
+			continue
+		}
+		generic, err := protocol.ParseEnvelope(result.Envelope)
+		if err != nil {
+			return nil, fmt.Errorf("invalid result envelope: %w", err)
+		}
+		typed, err := protocol.ParseTyped[protocol.ToolResultBody](generic)
+		if err != nil {
+			return nil, fmt.Errorf("invalid result envelope: %w", err)
+		}
+		found = &typed.Body
+	}
+	return found, nil
+}
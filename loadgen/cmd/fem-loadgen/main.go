@@ -0,0 +1,132 @@
+// fem-loadgen load-tests a FEM broker. It registers synthetic agents whose
+// tools answer with configurable latencies, drives concurrent discovery and
+// tool-call clients against the broker as a YAML scenario describes, and
+// reports throughput and latency percentiles per operation.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"math/rand"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/fep-fem/protocol"
+)
+
+func main() {
+	scenarioFile := flag.String("scenario", "", "YAML scenario to run (- reads standard input)")
+	brokerURL := flag.String("broker", "", "Broker URL, overriding the scenario's")
+	duration := flag.Duration("duration", 0, "How long clients run, overriding the scenario's")
+	pinFile := flag.String("pin-file", "", "File recording pinned broker identity keys (trust on first use for this run if empty)")
+	jsonOutput := flag.Bool("json", false, "Print the report as JSON")
+	requestTimeout := flag.Duration("request-timeout", 30*time.Second, "Timeout of each request to the broker")
+	flag.Parse()
+
+	if *scenarioFile == "" {
+		fmt.Fprintln(os.Stderr, "Usage: fem-loadgen --scenario <file> [flags]")
+		flag.PrintDefaults()
+		os.Exit(2)
+	}
+	scenario, err := loadScenario(*scenarioFile)
+	if err != nil {
+		log.Fatalf("Failed to load scenario: %v", err)
+	}
+	if *brokerURL != "" {
+		scenario.Broker = *brokerURL
+	}
+	if *duration > 0 {
+		scenario.Duration = *duration
+	}
+	if err := scenario.validate(); err != nil {
+		log.Fatalf("Invalid scenario: %v", err)
+	}
+
+	var store protocol.PinStore = protocol.NewMemoryPinStore()
+	if *pinFile != "" {
+		store = protocol.NewFilePinStore(*pinFile)
+	}
+	pins := protocol.NewBrokerPins(store, protocol.PinModeEnforce)
+	conn := newBrokerConn(scenario.Broker, pins, scenario.Clients.Count+registerWorkers, *requestTimeout)
+
+	report, err := run(scenario, conn)
+	if err != nil {
+		log.Fatalf("Load test failed: %v", err)
+	}
+	if *jsonOutput {
+		if err := report.printJSON(os.Stdout); err != nil {
+			log.Fatalf("Failed to print report: %v", err)
+		}
+		return
+	}
+	report.printText(os.Stdout)
+}
+
+// run registers the scenario's agents and clients, runs the clients for the
+// scenario's duration and reports what they measured
+func run(scenario *Scenario, conn *brokerConn) (*Report, error) {
+	stats := make(map[string]*recorder, len(reportedOps))
+	for _, op := range reportedOps {
+		stats[op] = newRecorder()
+	}
+
+	pool, err := startAgentPool(scenario, conn, stats[opAnswer])
+	if err != nil {
+		return nil, err
+	}
+
+	log.Printf("Registering %d agents and %d clients with %s", scenario.Agents.Count, scenario.Clients.Count, scenario.Broker)
+	registerStart := time.Now()
+	pool.register(stats[opRegister])
+	identities, err := newIdentities(scenario.Clients.IDPrefix, scenario.Clients.Count)
+	if err != nil {
+		pool.close()
+		return nil, err
+	}
+	clients := registerAll(conn, identities, func(identity) protocol.RegisterAgentBody {
+		return protocol.RegisterAgentBody{Capabilities: []string{"tool.call"}, EnvironmentType: "load-test"}
+	}, stats[opRegister])
+	registerElapsed := time.Since(registerStart)
+	if len(pool.ids) == 0 || len(clients) == 0 {
+		report := &Report{Broker: scenario.Broker, Operations: []OpReport{stats[opRegister].summarise(opRegister, registerElapsed)}}
+		report.printText(os.Stderr)
+		pool.close()
+		return nil, fmt.Errorf("the broker accepted %d of %d agents and %d of %d clients", len(pool.ids), scenario.Agents.Count, len(clients), scenario.Clients.Count)
+	}
+
+	log.Printf("Running %d clients against %d agents for %s", len(clients), len(pool.ids), scenario.Duration)
+	ctx, cancel := context.WithTimeout(context.Background(), scenario.Duration)
+	defer cancel()
+	runStart := time.Now()
+	var wg sync.WaitGroup
+	for i, client := range clients {
+		wg.Add(1)
+		go func(seed int64, client identity) {
+			defer wg.Done()
+			(&loadClient{
+				identity: client,
+				scenario: scenario,
+				conn:     conn,
+				pool:     pool,
+				stats:    stats,
+				rand:     rand.New(rand.NewSource(seed)),
+			}).run(ctx)
+		}(runStart.UnixNano()+int64(i), client)
+	}
+	wg.Wait()
+	pool.close() // Answers still being posted count towards the run
+	runElapsed := time.Since(runStart)
+
+	report := &Report{Broker: scenario.Broker, Agents: len(pool.ids), Clients: len(clients), Duration: runElapsed}
+	for _, op := range reportedOps {
+		elapsed := runElapsed
+		if op == opRegister {
+			elapsed = registerElapsed
+		}
+		report.Operations = append(report.Operations, stats[op].summarise(op, elapsed))
+	}
+	return report, nil
+}
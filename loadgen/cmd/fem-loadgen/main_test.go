@@ -0,0 +1,110 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/fep-fem/protocol"
+)
+
+// queueBroker acks every envelope and queues tool results for their callers
+type queueBroker struct {
+	mu      sync.Mutex
+	callers map[string]string // Request ID to caller
+	queued  map[string][]protocol.DeliveredResult
+}
+
+func (b *queueBroker) respond(envelope *protocol.GenericEnvelope, data []byte) interface{} {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	switch envelope.Type {
+	case protocol.EnvelopeToolCall:
+		typed, _ := protocol.ParseTyped[protocol.ToolCallBody](envelope)
+		b.callers[typed.Body.RequestID] = envelope.Agent
+		return protocol.NewAck("broker-1", envelope.Nonce, "processing", nil)
+	case protocol.EnvelopeToolResult:
+		typed, _ := protocol.ParseTyped[protocol.ToolResultBody](envelope)
+		caller := b.callers[typed.Body.RequestID]
+		b.queued[caller] = append(b.queued[caller], protocol.DeliveredResult{
+			RequestID: typed.Body.RequestID, Delivery: protocol.DeliverAtMostOnce, Attempt: 1, Envelope: data})
+		return protocol.NewAck("broker-1", envelope.Nonce, "stored", nil)
+	case protocol.EnvelopeResultAck:
+		results := b.queued[envelope.Agent]
+		delete(b.queued, envelope.Agent)
+		return protocol.NewAck("broker-1", envelope.Nonce, "delivered", protocol.ResultDeliveryBody{Results: results})
+	default:
+		return protocol.NewAck("broker-1", envelope.Nonce, "ok", nil)
+	}
+}
+
+func TestRunReportsEveryOperation(t *testing.T) {
+	broker := &queueBroker{callers: map[string]string{}, queued: map[string][]protocol.DeliveredResult{}}
+	brokerPub, brokerKey, _ := protocol.GenerateKeyPair()
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		data, _ := io.ReadAll(r.Body)
+		envelope, err := protocol.ParseEnvelope(data)
+		if err != nil {
+			t.Errorf("Broker received an invalid envelope: %v", err)
+			return
+		}
+		body, _ := json.Marshal(broker.respond(envelope, data))
+		signature, _ := protocol.SignBrokerResponse(brokerKey, envelope.Nonce, body)
+		w.Header().Set(protocol.BrokerKeyHeader, protocol.EncodePublicKey(brokerPub))
+		w.Header().Set(protocol.BrokerSignatureHeader, signature)
+		w.Write(body)
+	}))
+	defer server.Close()
+
+	scenario, err := parseScenario([]byte(`
+duration: 300ms
+agents:
+  count: 3
+  tools:
+    - name: load.fast
+      latency: 5ms
+    - name: load.broken
+      errorRate: 1
+clients:
+  count: 4
+  pollInterval: 1ms
+`))
+	if err != nil {
+		t.Fatalf("Failed to parse scenario: %v", err)
+	}
+	scenario.Broker = server.URL
+	pins := protocol.NewBrokerPins(protocol.NewMemoryPinStore(), protocol.PinModeEnforce)
+	report, err := run(scenario, newBrokerConn(server.URL, pins, 8, 5*time.Second))
+	if err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+
+	if report.Agents != 3 || report.Clients != 4 {
+		t.Errorf("Expected 3 agents and 4 clients, got %d and %d", report.Agents, report.Clients)
+	}
+	ops := map[string]OpReport{}
+	for _, op := range report.Operations {
+		ops[op.Operation] = op
+	}
+	if ops[opRegister].Count != 7 || ops[opRegister].Errors != 0 {
+		t.Errorf("Expected 7 registrations, got %+v", ops[opRegister])
+	}
+	for _, op := range []string{opDiscover, opCall, opResult, opAnswer} {
+		if ops[op].Count == 0 {
+			t.Errorf("Expected successful %s operations, got %+v", op, ops[op])
+		}
+	}
+	if ops[opResult].Max < 5*time.Millisecond {
+		t.Errorf("Expected results to take at least the tool's latency, got %s", ops[opResult].Max)
+	}
+	if ops[opResult].ErrorKinds["tool failed: injected failure"] == 0 {
+		t.Errorf("Expected calls to load.broken to report failed results, got %+v", ops[opResult])
+	}
+	if ops[opResult].Count+ops[opResult].Errors != ops[opCall].Count {
+		t.Errorf("Expected a result for every accepted call, got %d results for %d calls", ops[opResult].Count+ops[opResult].Errors, ops[opCall].Count)
+	}
+}
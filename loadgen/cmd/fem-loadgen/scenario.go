@@ -0,0 +1,185 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Ways calls reach the synthetic agents
+const (
+	// dispatchDirect hands each accepted call to its agent inside fem-loadgen,
+	// since brokers queue results but do not push calls to agents
+	dispatchDirect = "direct"
+	// dispatchBroker leaves calling the agents' MCP endpoints to the broker,
+	// as fem-broker dev up does
+	dispatchBroker = "broker"
+)
+
+// Scenario describes a load test: the synthetic agents to register and the
+// clients driving discovery and tool calls against them
+type Scenario struct {
+	Broker   string        `yaml:"broker"`
+	Duration time.Duration `yaml:"duration"` // How long clients run after every agent is registered
+	Agents   AgentsConfig  `yaml:"agents"`
+	Clients  ClientsConfig `yaml:"clients"`
+}
+
+// AgentsConfig describes the synthetic agents. Every agent offers every tool.
+type AgentsConfig struct {
+	Count    int          `yaml:"count"`
+	IDPrefix string       `yaml:"idPrefix"` // Agents are named <idPrefix>-<n>
+	Listen   string       `yaml:"listen"`   // Address of the agents' shared MCP server
+	Dispatch string       `yaml:"dispatch"` // direct or broker
+	Tools    []ToolConfig `yaml:"tools"`
+}
+
+// ToolConfig describes a synthetic tool and how it behaves
+type ToolConfig struct {
+	Name      string        `yaml:"name"`
+	Latency   time.Duration `yaml:"latency"`   // Time the tool takes to answer
+	Jitter    time.Duration `yaml:"jitter"`    // Uniform random time added to or taken from latency
+	ErrorRate float64       `yaml:"errorRate"` // Fraction of calls that fail, 0 to 1
+}
+
+// ClientsConfig describes the clients and the operations they run
+type ClientsConfig struct {
+	Count    int       `yaml:"count"`
+	IDPrefix string    `yaml:"idPrefix"` // Clients are named <idPrefix>-<n>
+	Rate     float64   `yaml:"rate"`     // Operations per second per client; 0 runs them back to back
+	Mix      MixConfig `yaml:"mix"`
+	// Discovery queries list these tool patterns
+	DiscoverCaps []string `yaml:"discoverCaps"`
+	// A call counts as complete when its result is collected, unless skipResults is set
+	SkipResults   bool          `yaml:"skipResults"`
+	ResultTimeout time.Duration `yaml:"resultTimeout"`
+	PollInterval  time.Duration `yaml:"pollInterval"` // How often clients ask the broker for results
+}
+
+// MixConfig weighs the operations clients choose between
+type MixConfig struct {
+	Discover int `yaml:"discover"`
+	Call     int `yaml:"call"`
+}
+
+// loadScenario reads a YAML scenario, fills in defaults and validates it
+func loadScenario(path string) (*Scenario, error) {
+	var data []byte
+	var err error
+	if path == "-" {
+		data, err = io.ReadAll(os.Stdin)
+	} else {
+		data, err = os.ReadFile(path)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return parseScenario(data)
+}
+
+func parseScenario(data []byte) (*Scenario, error) {
+	var scenario Scenario
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	decoder.KnownFields(true)
+	if err := decoder.Decode(&scenario); err != nil && !errors.Is(err, io.EOF) {
+		return nil, fmt.Errorf("invalid scenario: %w", err)
+	}
+	scenario.applyDefaults()
+	if err := scenario.validate(); err != nil {
+		return nil, fmt.Errorf("invalid scenario: %w", err)
+	}
+	return &scenario, nil
+}
+
+func (s *Scenario) applyDefaults() {
+	if s.Broker == "" {
+		s.Broker = "https://localhost:4433"
+	}
+	s.Broker = strings.TrimSuffix(s.Broker, "/")
+	if s.Duration == 0 {
+		s.Duration = 30 * time.Second
+	}
+	if s.Agents.Count == 0 {
+		s.Agents.Count = 10
+	}
+	if s.Agents.IDPrefix == "" {
+		s.Agents.IDPrefix = "load-agent"
+	}
+	if s.Agents.Listen == "" {
+		s.Agents.Listen = "127.0.0.1:0"
+	}
+	if s.Agents.Dispatch == "" {
+		s.Agents.Dispatch = dispatchDirect
+	}
+	if len(s.Agents.Tools) == 0 {
+		s.Agents.Tools = []ToolConfig{{Name: "load.echo", Latency: 10 * time.Millisecond}}
+	}
+	if s.Clients.Count == 0 {
+		s.Clients.Count = 10
+	}
+	if s.Clients.IDPrefix == "" {
+		s.Clients.IDPrefix = "load-client"
+	}
+	if s.Clients.Mix == (MixConfig{}) {
+		s.Clients.Mix = MixConfig{Discover: 1, Call: 4}
+	}
+	if len(s.Clients.DiscoverCaps) == 0 {
+		s.Clients.DiscoverCaps = []string{"*"}
+	}
+	if s.Clients.ResultTimeout == 0 {
+		s.Clients.ResultTimeout = 10 * time.Second
+	}
+	if s.Clients.PollInterval == 0 {
+		s.Clients.PollInterval = 10 * time.Millisecond
+	}
+}
+
+func (s *Scenario) validate() error {
+	if !strings.HasPrefix(s.Broker, "https://") && !strings.HasPrefix(s.Broker, "http://") {
+		return fmt.Errorf("broker must be an http or https URL, got %q", s.Broker)
+	}
+	switch {
+	case s.Duration < 0:
+		return fmt.Errorf("duration must be positive")
+	case s.Agents.Count < 0 || s.Clients.Count < 0:
+		return fmt.Errorf("agent and client counts must be positive")
+	case s.Clients.Rate < 0:
+		return fmt.Errorf("clients.rate must not be negative")
+	case s.Clients.Mix.Discover < 0 || s.Clients.Mix.Call < 0:
+		return fmt.Errorf("clients.mix weights must not be negative")
+	}
+	if s.Agents.Dispatch != dispatchDirect && s.Agents.Dispatch != dispatchBroker {
+		return fmt.Errorf("agents.dispatch must be %s or %s, got %q", dispatchDirect, dispatchBroker, s.Agents.Dispatch)
+	}
+	seen := map[string]bool{}
+	for _, tool := range s.Agents.Tools {
+		switch {
+		case tool.Name == "":
+			return fmt.Errorf("every tool needs a name")
+		case seen[tool.Name]:
+			return fmt.Errorf("tool %s is listed twice", tool.Name)
+		case tool.Latency < 0 || tool.Jitter < 0:
+			return fmt.Errorf("tool %s: latency and jitter must not be negative", tool.Name)
+		case tool.ErrorRate < 0 || tool.ErrorRate > 1:
+			return fmt.Errorf("tool %s: errorRate must be between 0 and 1", tool.Name)
+		}
+		seen[tool.Name] = true
+	}
+	return nil
+}
+
+// tool returns the configuration of the tool called name
+func (s *Scenario) tool(name string) (ToolConfig, bool) {
+	for _, tool := range s.Agents.Tools {
+		if tool.Name == name {
+			return tool, true
+		}
+	}
+	return ToolConfig{}, false
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseScenarioAppliesDefaults(t *testing.T) {
+	scenario, err := parseScenario([]byte(`
+broker: https://broker.test:4433/
+agents:
+  count: 50
+  tools:
+    - name: slow.search
+      latency: 200ms
+      jitter: 50ms
+      errorRate: 0.1
+clients:
+  count: 20
+  rate: 5
+`))
+	if err != nil {
+		t.Fatalf("Failed to parse scenario: %v", err)
+	}
+	if scenario.Broker != "https://broker.test:4433" || scenario.Duration != 30*time.Second {
+		t.Errorf("Expected the broker without a trailing slash and a 30s default run, got %s for %s", scenario.Broker, scenario.Duration)
+	}
+	tool, exists := scenario.tool("slow.search")
+	if !exists || tool.Latency != 200*time.Millisecond || tool.Jitter != 50*time.Millisecond || tool.ErrorRate != 0.1 {
+		t.Errorf("Unexpected tool configuration %+v", tool)
+	}
+	if scenario.Agents.Count != 50 || scenario.Agents.Dispatch != dispatchDirect || scenario.Clients.Count != 20 {
+		t.Errorf("Unexpected agents %+v and clients %+v", scenario.Agents, scenario.Clients)
+	}
+	if scenario.Clients.Mix != (MixConfig{Discover: 1, Call: 4}) || scenario.Clients.DiscoverCaps[0] != "*" {
+		t.Errorf("Expected the default operation mix, got %+v", scenario.Clients)
+	}
+}
+
+func TestParseScenarioRejectsInvalidScenarios(t *testing.T) {
+	for name, tc := range map[string]struct {
+		yaml string
+		err  string
+	}{
+		"unknown field":  {"clients:\n  concurrency: 5\n", "concurrency"},
+		"broker scheme":  {"broker: localhost:4433\n", "http or https"},
+		"dispatch":       {"agents:\n  dispatch: push\n", "agents.dispatch"},
+		"error rate":     {"agents:\n  tools:\n    - name: t\n      errorRate: 2\n", "errorRate"},
+		"duplicate tool": {"agents:\n  tools:\n    - name: t\n    - name: t\n", "listed twice"},
+		"negative rate":  {"clients:\n  rate: -1\n", "clients.rate"},
+	} {
+		t.Run(name, func(t *testing.T) {
+			_, err := parseScenario([]byte(tc.yaml))
+			if err == nil || !strings.Contains(err.Error(), tc.err) {
+				t.Errorf("Expected an error mentioning %q, got %v", tc.err, err)
+			}
+		})
+	}
+}
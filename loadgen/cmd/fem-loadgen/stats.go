@@ -0,0 +1,177 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+)
+
+// Operations measured by a run
+const (
+	opRegister = "register"
+	opDiscover = "discover"
+	opCall     = "call"   // Until the broker accepts the call
+	opResult   = "result" // Until the caller has collected the result
+	opAnswer   = "answer" // An agent posting a result, in direct dispatch
+)
+
+var reportedOps = []string{opRegister, opDiscover, opCall, opResult, opAnswer}
+
+// maxErrorKinds bounds the distinct error messages kept per operation
+const maxErrorKinds = 20
+
+// recorder collects the latencies and errors of one operation
+type recorder struct {
+	mu        sync.Mutex
+	latencies []time.Duration
+	errors    map[string]int
+	failed    int
+}
+
+func newRecorder() *recorder {
+	return &recorder{errors: make(map[string]int)}
+}
+
+// record adds the outcome of one operation that took latency
+func (r *recorder) record(latency time.Duration, err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if err == nil {
+		r.latencies = append(r.latencies, latency)
+		return
+	}
+	r.failed++
+	message := err.Error()
+	if _, exists := r.errors[message]; exists || len(r.errors) < maxErrorKinds {
+		r.errors[message]++
+	}
+}
+
+// OpReport summarises one operation of a run
+type OpReport struct {
+	Operation  string  `json:"operation"`
+	Count      int     `json:"count"`
+	Errors     int     `json:"errors"`
+	Throughput float64 `json:"throughput"` // Successful operations per second
+	// Latency percentiles, reported in milliseconds by printJSON
+	P50        time.Duration  `json:"-"`
+	P90        time.Duration  `json:"-"`
+	P99        time.Duration  `json:"-"`
+	Max        time.Duration  `json:"-"`
+	ErrorKinds map[string]int `json:"errorKinds,omitempty"`
+}
+
+// summarise reports the operation over a phase lasting elapsed
+func (r *recorder) summarise(operation string, elapsed time.Duration) OpReport {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	sorted := append([]time.Duration(nil), r.latencies...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+
+	report := OpReport{Operation: operation, Count: len(sorted), Errors: r.failed}
+	if len(r.errors) > 0 {
+		report.ErrorKinds = make(map[string]int, len(r.errors))
+		for message, count := range r.errors {
+			report.ErrorKinds[message] = count
+		}
+	}
+	if elapsed > 0 {
+		report.Throughput = float64(len(sorted)) / elapsed.Seconds()
+	}
+	if len(sorted) > 0 {
+		report.P50 = percentile(sorted, 50)
+		report.P90 = percentile(sorted, 90)
+		report.P99 = percentile(sorted, 99)
+		report.Max = sorted[len(sorted)-1]
+	}
+	return report
+}
+
+// percentile returns the nearest-rank percentile p of sorted latencies
+func percentile(sorted []time.Duration, p float64) time.Duration {
+	rank := int(math.Ceil(p/100*float64(len(sorted)))) - 1
+	if rank < 0 {
+		rank = 0
+	}
+	return sorted[rank]
+}
+
+// Report is the outcome of a run
+type Report struct {
+	Broker     string
+	Agents     int
+	Clients    int
+	Duration   time.Duration // How long the clients ran
+	Operations []OpReport
+}
+
+// printText writes the report as a table followed by the errors seen
+func (r *Report) printText(out io.Writer) {
+	fmt.Fprintf(out, "broker: %s, %d agents, %d clients, %s\n\n", r.Broker, r.Agents, r.Clients, r.Duration.Round(time.Millisecond))
+	table := tabwriter.NewWriter(out, 0, 0, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(table, "OPERATION\tCOUNT\tERRORS\tOPS/S\tP50\tP90\tP99\tMAX\t")
+	for _, op := range r.Operations {
+		fmt.Fprintf(table, "%s\t%d\t%d\t%.1f\t%s\t%s\t%s\t%s\t\n", op.Operation, op.Count, op.Errors, op.Throughput,
+			formatLatency(op.P50), formatLatency(op.P90), formatLatency(op.P99), formatLatency(op.Max))
+	}
+	table.Flush()
+
+	for _, op := range r.Operations {
+		if len(op.ErrorKinds) == 0 {
+			continue
+		}
+		fmt.Fprintf(out, "\n%s errors:\n", op.Operation)
+		messages := make([]string, 0, len(op.ErrorKinds))
+		for message := range op.ErrorKinds {
+			messages = append(messages, message)
+		}
+		sort.Slice(messages, func(i, j int) bool { return op.ErrorKinds[messages[i]] > op.ErrorKinds[messages[j]] })
+		for _, message := range messages {
+			fmt.Fprintf(out, "  %6d  %s\n", op.ErrorKinds[message], strings.TrimSpace(message))
+		}
+	}
+}
+
+// printJSON writes the report as indented JSON with latencies in milliseconds
+func (r *Report) printJSON(out io.Writer) error {
+	type opJSON struct {
+		OpReport
+		P50 float64 `json:"p50Ms"`
+		P90 float64 `json:"p90Ms"`
+		P99 float64 `json:"p99Ms"`
+		Max float64 `json:"maxMs"`
+	}
+	ops := make([]opJSON, len(r.Operations))
+	for i, op := range r.Operations {
+		ops[i] = opJSON{OpReport: op, P50: milliseconds(op.P50), P90: milliseconds(op.P90), P99: milliseconds(op.P99), Max: milliseconds(op.Max)}
+	}
+	data, err := json.MarshalIndent(map[string]interface{}{
+		"broker":     r.Broker,
+		"agents":     r.Agents,
+		"clients":    r.Clients,
+		"durationMs": milliseconds(r.Duration),
+		"operations": ops,
+	}, "", "  ")
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintln(out, string(data))
+	return err
+}
+
+func formatLatency(d time.Duration) string {
+	if d == 0 {
+		return "-"
+	}
+	return fmt.Sprintf("%.1fms", milliseconds(d))
+}
+
+func milliseconds(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
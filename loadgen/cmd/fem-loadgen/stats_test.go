@@ -0,0 +1,31 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRecorderSummarisesLatencies(t *testing.T) {
+	stats := newRecorder()
+	for i := 100; i >= 1; i-- {
+		stats.record(time.Duration(i)*time.Millisecond, nil)
+	}
+	stats.record(time.Second, errors.New("broker rejected toolCall: RATE_LIMITED: slow down"))
+
+	report := stats.summarise(opCall, 10*time.Second)
+	if report.Count != 100 || report.Errors != 1 || report.Throughput != 10 {
+		t.Errorf("Expected 100 calls, 1 error and 10 calls/s, got %+v", report)
+	}
+	if report.P50 != 50*time.Millisecond || report.P90 != 90*time.Millisecond || report.P99 != 99*time.Millisecond || report.Max != 100*time.Millisecond {
+		t.Errorf("Unexpected percentiles p50=%s p90=%s p99=%s max=%s", report.P50, report.P90, report.P99, report.Max)
+	}
+
+	var out bytes.Buffer
+	(&Report{Broker: "https://broker.test", Operations: []OpReport{report}}).printText(&out)
+	if !strings.Contains(out.String(), "50.0ms") || !strings.Contains(out.String(), "RATE_LIMITED: slow down") {
+		t.Errorf("Expected the report to list percentiles and errors, got:\n%s", out.String())
+	}
+}
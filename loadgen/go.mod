@@ -0,0 +1,17 @@
+module fem-loadgen
+
+go 1.21
+
+require (
+	github.com/fep-fem/protocol v0.0.0
+	gopkg.in/yaml.v3 v3.0.1
+)
+
+require (
+	github.com/golang-jwt/jwt/v5 v5.2.0 // indirect
+	github.com/klauspost/compress v1.17.11 // indirect
+	golang.org/x/crypto v0.24.0 // indirect
+	golang.org/x/sys v0.21.0 // indirect
+)
+
+replace github.com/fep-fem/protocol => ../protocol/go
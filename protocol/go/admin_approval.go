@@ -0,0 +1,70 @@
+package protocol
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// AdminApprovalHeader carries the co-signed adminApproval envelope
+// authorizing an admin API request under the two-person rule
+const AdminApprovalHeader = "X-FEM-Admin-Approval"
+
+// NewAdminApproval returns an approval of an admin API request for the admins
+// to sign and co-sign. The path includes the query, e.g. /admin/peers?id=b2.
+func NewAdminApproval(admin, operation, method, path string, body []byte) (*TypedEnvelope[AdminApprovalBody], error) {
+	approval := AdminApprovalBody{Operation: operation, Method: strings.ToUpper(method), Path: path}
+	if len(body) > 0 {
+		digest, err := BodyDigest(body, DigestSHA256)
+		if err != nil {
+			return nil, err
+		}
+		approval.Digest = digest
+	}
+	if err := approval.Validate(); err != nil {
+		return nil, err
+	}
+	return NewTypedEnvelope(admin, approval), nil
+}
+
+// EncodeAdminApproval encodes a signed approval for the AdminApprovalHeader
+func EncodeAdminApproval(approval interface{}) (string, error) {
+	data, err := json.Marshal(approval)
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(data), nil
+}
+
+// DecodeAdminApproval parses the approval carried in an AdminApprovalHeader
+func DecodeAdminApproval(value string) (*TypedEnvelope[AdminApprovalBody], error) {
+	data, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(value, "="))
+	if err != nil {
+		return nil, fmt.Errorf("invalid admin approval encoding: %w", err)
+	}
+	return Parse[AdminApprovalBody](data)
+}
+
+// Authorizes checks the approval names the request with the given method,
+// path and body
+func (b AdminApprovalBody) Authorizes(method, path string, body []byte) error {
+	if !strings.EqualFold(b.Method, method) || b.Path != path {
+		return fmt.Errorf("approval is for %s %s, not %s %s", b.Method, b.Path, method, path)
+	}
+	if b.Digest == "" {
+		if len(body) > 0 {
+			return fmt.Errorf("approval has no digest of the request body")
+		}
+		return nil
+	}
+	algorithm, _, _ := strings.Cut(b.Digest, "=")
+	digest, err := BodyDigest(body, algorithm)
+	if err != nil {
+		return err
+	}
+	if digest != b.Digest {
+		return fmt.Errorf("approval is for a different request body")
+	}
+	return nil
+}
@@ -0,0 +1,57 @@
+package protocol
+
+import (
+	"crypto/ed25519"
+	"testing"
+)
+
+func TestAdminApprovalRoundTrip(t *testing.T) {
+	alicePub, alicePriv, _ := GenerateKeyPair()
+	bobPub, bobPriv, _ := GenerateKeyPair()
+	body := []byte(`{"pattern":"prod.*","maxEvents":10}`)
+
+	approval, err := NewAdminApproval("alice", "policy", "put", "/admin/topics", body)
+	if err != nil {
+		t.Fatalf("NewAdminApproval failed: %v", err)
+	}
+	if err := approval.Sign(alicePriv); err != nil {
+		t.Fatalf("Sign failed: %v", err)
+	}
+	if err := approval.CoSign("bob", bobPriv); err != nil {
+		t.Fatalf("CoSign failed: %v", err)
+	}
+	header, err := EncodeAdminApproval(approval)
+	if err != nil {
+		t.Fatalf("EncodeAdminApproval failed: %v", err)
+	}
+
+	decoded, err := DecodeAdminApproval(header)
+	if err != nil {
+		t.Fatalf("DecodeAdminApproval failed: %v", err)
+	}
+	admins := map[string]ed25519.PublicKey{"alice": alicePub, "bob": bobPub}
+	if err := decoded.VerifyPolicy(Threshold(2, admins)); err != nil {
+		t.Errorf("Both admins' signatures should count: %v", err)
+	}
+	if err := decoded.Body.Authorizes("PUT", "/admin/topics", body); err != nil {
+		t.Errorf("Approval should authorize the request it names: %v", err)
+	}
+	if err := decoded.Body.Authorizes("PUT", "/admin/topics", []byte(`{"pattern":"*"}`)); err == nil {
+		t.Error("Approval should not authorize a different body")
+	}
+	if err := decoded.Body.Authorizes("DELETE", "/admin/topics", body); err == nil {
+		t.Error("Approval should not authorize a different method")
+	}
+
+	unsigned, _ := NewAdminApproval("alice", "peer-removal", "DELETE", "/admin/peers?id=b2", nil)
+	unsigned.Sign(alicePriv)
+	if err := unsigned.VerifyPolicy(Threshold(2, admins)); err == nil {
+		t.Error("One admin's signature should not satisfy a two-person rule")
+	}
+	if err := unsigned.Body.Authorizes("DELETE", "/admin/peers?id=b2", nil); err != nil {
+		t.Errorf("Approval without a body should authorize a request without one: %v", err)
+	}
+	if _, err := DecodeAdminApproval("not base64!"); err == nil {
+		t.Error("Expected an invalid encoding to be refused")
+	}
+}
@@ -0,0 +1,206 @@
+package protocol
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// AgentChannelPath is where agents open their channel to a broker
+const AgentChannelPath = "/agent/channel"
+
+// AgentChannel is a persistent stream between an agent and its broker,
+// opened by the agent with an HTTP upgrade so it works from behind NAT. The
+// broker pushes toolCall envelopes down it and the agent answers with
+// toolResult envelopes; each envelope the agent sends is answered with an
+// ack or error envelope, as over HTTP.
+type AgentChannel struct {
+	*Stream
+	conn net.Conn
+}
+
+// Close closes the channel, ending any ReadEnvelope in progress
+func (c *AgentChannel) Close() error {
+	return c.conn.Close()
+}
+
+// Send writes any envelope, such as a typed one from a builder, to the channel
+func (c *AgentChannel) Send(envelope interface{}) error {
+	data, err := json.Marshal(envelope)
+	if err != nil {
+		return err
+	}
+	var generic Envelope
+	if err := json.Unmarshal(data, &generic); err != nil {
+		return err
+	}
+	return c.WriteEnvelope(&generic)
+}
+
+// SetReadDeadline bounds the wait for the next envelope; a zero time waits forever
+func (c *AgentChannel) SetReadDeadline(t time.Time) error {
+	return c.conn.SetReadDeadline(t)
+}
+
+// DialAgentChannel opens a channel to the broker at brokerURL, through the
+// proxy proxy picks for it; a nil proxy honors the environment. Framing is
+// agreed in the upgrade, preferring length-prefixed frames. A broker that
+// refuses the upgrade with an error envelope returns it as an *ErrorBody.
+func DialAgentChannel(ctx context.Context, brokerURL string, proxy ProxyFunc, config *tls.Config) (*AgentChannel, error) {
+	target, err := url.Parse(brokerURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid broker URL: %w", err)
+	}
+	endpoint := target.Host
+	if target.Port() == "" {
+		port := "443"
+		if target.Scheme == "http" {
+			port = "80"
+		}
+		endpoint = net.JoinHostPort(target.Hostname(), port)
+	}
+
+	proxyURL, err := ProxyForEndpoint(proxy, endpoint)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := DialProxy(ctx, &net.Dialer{}, proxyURL, endpoint)
+	if err != nil {
+		return nil, err
+	}
+	if target.Scheme != "http" {
+		// The upgrade needs HTTP/1.1; HTTP/2 connections cannot be taken over
+		if config == nil {
+			config = &tls.Config{}
+		}
+		config = config.Clone()
+		config.NextProtos = []string{"http/1.1"}
+		if config.ServerName == "" {
+			config.ServerName = target.Hostname()
+		}
+		tlsConn := tls.Client(conn, config)
+		if err := tlsConn.HandshakeContext(ctx); err != nil {
+			conn.Close()
+			return nil, err
+		}
+		conn = tlsConn
+	}
+
+	channel, err := upgradeAgentChannel(ctx, conn, target)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return channel, nil
+}
+
+// upgradeAgentChannel asks the broker to switch conn to a channel
+func upgradeAgentChannel(ctx context.Context, conn net.Conn, target *url.URL) (*AgentChannel, error) {
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+		defer conn.SetDeadline(time.Time{})
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, target.Scheme+"://"+target.Host+AgentChannelPath, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Connection", "Upgrade")
+	req.Header.Set("Upgrade", strings.Join(StreamProtocols(), ", "))
+	if err := req.Write(conn); err != nil {
+		return nil, err
+	}
+
+	reader := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(reader, req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+		if _, err := ParseResponse(body); err != nil {
+			return nil, err
+		}
+		return nil, fmt.Errorf("broker refused channel: %s", resp.Status)
+	}
+	framing, ok := channelFraming(resp.Header.Get("Upgrade"))
+	if !ok {
+		return nil, fmt.Errorf("broker upgraded channel to unsupported protocol %q", resp.Header.Get("Upgrade"))
+	}
+	return newAgentChannel(conn, reader, framing), nil
+}
+
+// AcceptAgentChannel takes over an agent's request to open a channel,
+// answering it with 101 Switching Protocols. Requests that are not a channel
+// upgrade are answered with 426 Upgrade Required and an error is returned.
+func AcceptAgentChannel(w http.ResponseWriter, r *http.Request) (*AgentChannel, error) {
+	framing, ok := Framing(""), false
+	for _, offered := range strings.Split(r.Header.Get("Upgrade"), ",") {
+		if framing, ok = channelFraming(offered); ok {
+			break
+		}
+	}
+	if r.Method != http.MethodGet || !headerHasToken(r.Header, "Connection", "upgrade") || !ok {
+		w.Header().Set("Upgrade", strings.Join(StreamProtocols(), ", "))
+		http.Error(w, "Channel must be opened with an upgrade to "+strings.Join(StreamProtocols(), " or "), http.StatusUpgradeRequired)
+		return nil, fmt.Errorf("not a channel upgrade")
+	}
+	hijacker, ok := w.(http.Hijacker)
+	if !ok {
+		http.Error(w, "Channels need HTTP/1.1", http.StatusHTTPVersionNotSupported)
+		return nil, fmt.Errorf("connection cannot be upgraded")
+	}
+	conn, buffered, err := hijacker.Hijack()
+	if err != nil {
+		return nil, err
+	}
+	fmt.Fprintf(buffered, "HTTP/1.1 101 Switching Protocols\r\nConnection: Upgrade\r\nUpgrade: %s\r\n\r\n", framing)
+	if err := buffered.Flush(); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return newAgentChannel(conn, buffered.Reader, framing), nil
+}
+
+func newAgentChannel(conn net.Conn, reader *bufio.Reader, framing Framing) *AgentChannel {
+	return &AgentChannel{
+		Stream: &Stream{
+			reader:          reader,
+			writer:          conn,
+			framing:         framing,
+			maxEnvelopeSize: DefaultMaxEnvelopeSize,
+		},
+		conn: conn,
+	}
+}
+
+// channelFraming returns the framing an Upgrade protocol token names
+func channelFraming(token string) (Framing, bool) {
+	token = strings.TrimSpace(token)
+	for _, protocol := range StreamProtocols() {
+		if strings.EqualFold(token, protocol) {
+			return Framing(protocol), true
+		}
+	}
+	return "", false
+}
+
+// headerHasToken reports whether a comma-separated header lists token
+func headerHasToken(header http.Header, name, token string) bool {
+	for _, value := range header.Values(name) {
+		for _, field := range strings.Split(value, ",") {
+			if strings.EqualFold(strings.TrimSpace(field), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,80 @@
+package protocol
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+)
+
+func noProxy(*http.Request) (*url.URL, error) { return nil, nil }
+
+func TestAgentChannelUpgrade(t *testing.T) {
+	accepted := make(chan *AgentChannel, 1)
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		channel, err := AcceptAgentChannel(w, r)
+		if err != nil {
+			return
+		}
+		accepted <- channel
+	}))
+	defer server.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	agent, err := DialAgentChannel(ctx, server.URL, noProxy, &tls.Config{InsecureSkipVerify: true})
+	if err != nil {
+		t.Fatalf("Failed to open channel: %v", err)
+	}
+	defer agent.Close()
+	broker := <-accepted
+	defer broker.Close()
+	if agent.framing != FramingLengthPrefixed || broker.framing != FramingLengthPrefixed {
+		t.Errorf("Expected length-prefixed frames agreed, got %s and %s", agent.framing, broker.framing)
+	}
+
+	// Envelopes flow both ways once upgraded
+	heartbeat, _ := NewHeartbeat("worker").Load(0.5).Build()
+	if err := agent.Send(heartbeat); err != nil {
+		t.Fatalf("Failed to send heartbeat: %v", err)
+	}
+	received, err := broker.ReadEnvelope()
+	if err != nil || received.Type != EnvelopeHeartbeat || received.Agent != "worker" {
+		t.Fatalf("Expected the heartbeat, got %+v, %v", received, err)
+	}
+	call, _ := NewToolCall("caller").Tool("build.run").Build()
+	broker.Send(call)
+	if pushed, err := agent.ReadEnvelope(); err != nil || pushed.Type != EnvelopeToolCall {
+		t.Fatalf("Expected the pushed call, got %+v, %v", pushed, err)
+	}
+
+	// Closing one end ends reads at the other
+	broker.Close()
+	if _, err := agent.ReadEnvelope(); err == nil {
+		t.Error("Read should fail once the broker closes the channel")
+	}
+}
+
+func TestAgentChannelRefused(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusUnauthorized)
+		w.Write([]byte(`{"type":"error","agent":"broker","ts":1,"nonce":"n","body":{"code":"unauthorized","message":"not allowed"}}`))
+	}))
+	defer server.Close()
+
+	_, err := DialAgentChannel(context.Background(), server.URL, noProxy, &tls.Config{InsecureSkipVerify: true})
+	var errBody *ErrorBody
+	if !errors.As(err, &errBody) || errBody.Code != CodeUnauthorized {
+		t.Errorf("Expected the broker's error, got %v", err)
+	}
+
+	// Plain requests to the channel path are told to upgrade
+	resp := httptest.NewRecorder()
+	if _, err := AcceptAgentChannel(resp, httptest.NewRequest(http.MethodGet, AgentChannelPath, nil)); err == nil || resp.Code != http.StatusUpgradeRequired {
+		t.Errorf("Expected 426 for a request without an upgrade, got %d", resp.Code)
+	}
+}
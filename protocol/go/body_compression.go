@@ -0,0 +1,112 @@
+package protocol
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+)
+
+// CompressionHeader is the envelope member naming the encoding of a
+// compressed body. It is not signed: signatures cover the body before
+// compression, so receivers inflate the body before verifying.
+const CompressionHeader = "zip"
+
+// DefaultCompressionThreshold is the smallest body, in bytes, compressed when
+// compression is negotiated without a threshold
+const DefaultCompressionThreshold = 16 << 10
+
+// CompressionPolicy is agreed when an agent registers. Envelope bodies of at
+// least MinBytes are compressed with Encoding in both directions.
+type CompressionPolicy struct {
+	Encoding ContentEncoding `json:"encoding"`
+	MinBytes int             `json:"minBytes"`
+}
+
+// NegotiateCompression picks the first of the offered encodings this process
+// supports, or returns nil when there is none. A minBytes of 0 or less uses
+// DefaultCompressionThreshold.
+func NegotiateCompression(offered []ContentEncoding, minBytes int) *CompressionPolicy {
+	if minBytes <= 0 {
+		minBytes = DefaultCompressionThreshold
+	}
+	for _, encoding := range offered {
+		if SupportsEncoding(encoding) {
+			return &CompressionPolicy{Encoding: encoding, MinBytes: minBytes}
+		}
+	}
+	return nil
+}
+
+// CompressBody compresses the body of a serialized, signed envelope under the
+// policy. The body is replaced by the base64 of its compressed bytes and the
+// zip header names the encoding. Envelopes are returned unchanged when the
+// policy is nil, the body is under the threshold or encrypted, or compressing
+// would not make it smaller.
+func CompressBody(data []byte, policy *CompressionPolicy) ([]byte, error) {
+	if policy == nil || policy.Encoding == "" || policy.Encoding == EncodingIdentity {
+		return data, nil
+	}
+	var members map[string]json.RawMessage
+	if err := json.Unmarshal(data, &members); err != nil {
+		return nil, fmt.Errorf("failed to parse envelope: %w", err)
+	}
+	body := members["body"]
+	if len(body) < policy.MinBytes || members["enc"] != nil || members[CompressionHeader] != nil {
+		return data, nil
+	}
+
+	compressed, err := CompressBytes(body, policy.Encoding)
+	if err != nil {
+		return nil, err
+	}
+	packed, _ := json.Marshal(base64.StdEncoding.EncodeToString(compressed))
+	if len(packed) >= len(body) {
+		return data, nil
+	}
+	members["body"] = packed
+	members[CompressionHeader], _ = json.Marshal(policy.Encoding)
+	return json.Marshal(members)
+}
+
+// InflateBody restores the compressed body of a serialized envelope and drops
+// its zip header. Envelopes without the header are returned unchanged.
+func InflateBody(data []byte) ([]byte, error) {
+	// Most envelopes are not compressed; skip decoding them
+	if !bytes.Contains(data, []byte(`"`+CompressionHeader+`"`)) {
+		return data, nil
+	}
+	var members map[string]json.RawMessage
+	if err := json.Unmarshal(data, &members); err != nil {
+		// Left for the envelope parser to report
+		return data, nil
+	}
+	header, compressed := members[CompressionHeader]
+	if !compressed {
+		return data, nil
+	}
+
+	var encoding ContentEncoding
+	if err := json.Unmarshal(header, &encoding); err != nil {
+		return nil, fmt.Errorf("invalid %s header: %w", CompressionHeader, err)
+	}
+	var packed string
+	if err := json.Unmarshal(members["body"], &packed); err != nil {
+		return nil, fmt.Errorf("compressed body must be a base64 string")
+	}
+	raw, err := base64.StdEncoding.DecodeString(packed)
+	if err != nil {
+		return nil, fmt.Errorf("invalid compressed body: %w", err)
+	}
+	body, err := DecompressBytes(raw, encoding)
+	if err != nil {
+		return nil, fmt.Errorf("invalid compressed body: %w", err)
+	}
+	if !json.Valid(body) {
+		return nil, fmt.Errorf("compressed body is not JSON")
+	}
+
+	members["body"] = body
+	delete(members, CompressionHeader)
+	return json.Marshal(members)
+}
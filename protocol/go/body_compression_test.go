@@ -0,0 +1,169 @@
+package protocol
+
+import (
+	"bytes"
+	"compress/flate"
+	"crypto/ed25519"
+	"encoding/json"
+	"io"
+	"net"
+	"strings"
+	"testing"
+)
+
+// largeResult returns a signed tool result whose body is about size bytes
+func largeResult(t *testing.T, size int) (*ToolResultEnvelope, ed25519.PublicKey) {
+	t.Helper()
+	pub, priv, err := GenerateKeyPair()
+	if err != nil {
+		t.Fatalf("Failed to generate key pair: %v", err)
+	}
+	result, err := NewToolResult("coder", "req-1").
+		Result(map[string]interface{}{"output": strings.Repeat("build step ok\n", size/14)}).
+		SignWith(priv)
+	if err != nil {
+		t.Fatalf("Failed to sign result: %v", err)
+	}
+	return result, pub
+}
+
+func TestCompressedBodySurvivesParsing(t *testing.T) {
+	result, pub := largeResult(t, 64<<10)
+	data, _ := json.Marshal(result)
+
+	compressed, err := CompressBody(data, &CompressionPolicy{Encoding: EncodingGzip, MinBytes: 1024})
+	if err != nil {
+		t.Fatalf("Failed to compress: %v", err)
+	}
+	if len(compressed) >= len(data)/4 {
+		t.Errorf("Expected a much smaller envelope, got %d of %d bytes", len(compressed), len(data))
+	}
+	var members map[string]json.RawMessage
+	json.Unmarshal(compressed, &members)
+	if string(members[CompressionHeader]) != `"gzip"` || members["body"][0] != '"' {
+		t.Fatalf("Expected a gzip header and a packed body, got %s", compressed[:80])
+	}
+
+	env, err := ParseEnvelope(compressed)
+	if err != nil {
+		t.Fatalf("Compressed envelope should parse: %v", err)
+	}
+	if err := env.Verify(pub); err != nil {
+		t.Errorf("Signature should verify after inflating: %v", err)
+	}
+	typed, err := ParseTyped[ToolResultBody](env)
+	if err != nil || typed.Body.RequestID != "req-1" {
+		t.Errorf("Body should be restored, got %+v, %v", typed, err)
+	}
+}
+
+func TestSmallAndEncryptedBodiesNotCompressed(t *testing.T) {
+	policy := &CompressionPolicy{Encoding: EncodingGzip, MinBytes: DefaultCompressionThreshold}
+	result, _ := largeResult(t, 1024)
+	data, _ := json.Marshal(result)
+	if out, _ := CompressBody(data, policy); !bytes.Equal(out, data) {
+		t.Error("A body under the threshold should be left alone")
+	}
+	if out, _ := CompressBody(data, nil); !bytes.Equal(out, data) {
+		t.Error("Without a policy envelopes should be left alone")
+	}
+
+	recipient, _ := GenerateEncryptionKey()
+	large, _ := largeResult(t, 64<<10)
+	encrypted, err := EncryptTyped(&TypedEnvelope[ToolResultBody]{BaseEnvelope: large.BaseEnvelope, Body: large.Body}, recipient.PublicKey())
+	if err != nil {
+		t.Fatalf("Failed to encrypt: %v", err)
+	}
+	data, _ = json.Marshal(encrypted)
+	if out, _ := CompressBody(data, policy); !bytes.Equal(out, data) {
+		t.Error("Encrypted bodies do not compress and should be left alone")
+	}
+}
+
+func TestInflateRejectsBadBodies(t *testing.T) {
+	for name, data := range map[string]string{
+		"unsupported encoding": `{"type":"ack","agent":"b","ts":1,"nonce":"n","zip":"br","body":"AAAA"}`,
+		"object body":          `{"type":"ack","agent":"b","ts":1,"nonce":"n","zip":"gzip","body":{}}`,
+		"not base64":           `{"type":"ack","agent":"b","ts":1,"nonce":"n","zip":"gzip","body":"%%%"}`,
+		"not gzip":             `{"type":"ack","agent":"b","ts":1,"nonce":"n","zip":"gzip","body":"AAAA"}`,
+	} {
+		if _, err := ParseEnvelope([]byte(data)); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+
+	// A body merely mentioning "zip" is not touched
+	plain := []byte(`{"type":"ack","agent":"b","ts":1,"nonce":"n","body":{"status":"zip"}}`)
+	if out, err := InflateBody(plain); err != nil || !bytes.Equal(out, plain) {
+		t.Errorf("Uncompressed envelope changed: %s, %v", out, err)
+	}
+}
+
+// flateCodec stands in for a registered encoding
+type flateCodec struct{}
+
+func (flateCodec) Compress(data []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	w, _ := flate.NewWriter(&buf, flate.BestSpeed)
+	w.Write(data)
+	w.Close()
+	return buf.Bytes(), nil
+}
+
+func (flateCodec) Decompress(data []byte, maxSize int) ([]byte, error) {
+	return io.ReadAll(io.LimitReader(flate.NewReader(bytes.NewReader(data)), int64(maxSize)))
+}
+
+func TestNegotiateCompression(t *testing.T) {
+	if policy := NegotiateCompression([]ContentEncoding{EncodingZstd, EncodingGzip}, 0); policy == nil ||
+		policy.Encoding != EncodingZstd || policy.MinBytes != DefaultCompressionThreshold {
+		t.Errorf("Expected the first offered encoding with the default threshold, got %+v", policy)
+	}
+	if policy := NegotiateCompression([]ContentEncoding{"br", EncodingGzip}, 0); policy == nil || policy.Encoding != EncodingGzip {
+		t.Errorf("Expected unsupported offers skipped, got %+v", policy)
+	}
+	if policy := NegotiateCompression([]ContentEncoding{"br"}, 0); policy != nil {
+		t.Errorf("Expected no compression without a shared encoding, got %+v", policy)
+	}
+
+	RegisterEncoding("x-flate", flateCodec{})
+	policy := NegotiateCompression([]ContentEncoding{"x-flate", EncodingGzip}, 512)
+	if policy == nil || policy.Encoding != "x-flate" || policy.MinBytes != 512 {
+		t.Fatalf("Expected the registered encoding to be preferred, got %+v", policy)
+	}
+
+	result, pub := largeResult(t, 4096)
+	data, _ := json.Marshal(result)
+	compressed, err := CompressBody(data, policy)
+	if err != nil || bytes.Equal(compressed, data) {
+		t.Fatalf("Expected the body compressed with the registered codec: %v", err)
+	}
+	env, err := ParseEnvelope(compressed)
+	if err != nil || env.Verify(pub) != nil {
+		t.Errorf("Envelope compressed with a registered codec should parse and verify: %v", err)
+	}
+}
+
+func TestStreamCompressesLargeBodies(t *testing.T) {
+	clientConn, serverConn := net.Pipe()
+	defer clientConn.Close()
+	defer serverConn.Close()
+
+	writer := NewStream(clientConn)
+	writer.SetCompression(&CompressionPolicy{Encoding: EncodingGzip, MinBytes: 1024})
+	writer.SetMaxEnvelopeSize(16 << 10)
+	reader := NewStream(serverConn)
+
+	// Only fits under the writer's limit once compressed
+	result, pub := largeResult(t, 256<<10)
+	body, _ := json.Marshal(result.Body)
+	go writer.WriteEnvelope(&Envelope{Type: result.Type, CommonHeaders: result.CommonHeaders, Body: body})
+
+	envelope, err := reader.ReadEnvelope()
+	if err != nil {
+		t.Fatalf("Failed to read compressed envelope: %v", err)
+	}
+	if err := envelope.Verify(pub); err != nil {
+		t.Errorf("Signature should verify after the stream inflates the body: %v", err)
+	}
+}
@@ -0,0 +1,483 @@
+package protocol
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// ErrMissingField is returned by builders when a required field was not set
+var ErrMissingField = errors.New("missing required field")
+
+// NewRandomID returns a random 128-bit hex identifier, used for nonces and request IDs
+func NewRandomID() string {
+	var id [16]byte
+	if _, err := rand.Read(id[:]); err != nil {
+		// crypto/rand does not fail on supported platforms; fall back to a unique value
+		return fmt.Sprintf("%x", time.Now().UnixNano())
+	}
+	return hex.EncodeToString(id[:])
+}
+
+// newHeaders fills the common headers for an envelope sent by agent
+func newHeaders(agent string) CommonHeaders {
+	return CommonHeaders{
+		Agent: agent,
+		TS:    time.Now().UnixMilli(),
+		Nonce: NewRandomID(),
+		Proto: ProtocolVersion,
+	}
+}
+
+// ToolCallBuilder constructs toolCall envelopes
+type ToolCallBuilder struct {
+	envelope ToolCallEnvelope
+}
+
+// NewToolCall starts a toolCall envelope from agent with a fresh nonce and request ID
+func NewToolCall(agent string) *ToolCallBuilder {
+	b := &ToolCallBuilder{}
+	b.envelope.Type = EnvelopeToolCall
+	b.envelope.CommonHeaders = newHeaders(agent)
+	b.envelope.Body.RequestID = NewRandomID()
+	return b
+}
+
+// Tool sets the tool to invoke
+func (b *ToolCallBuilder) Tool(name string) *ToolCallBuilder {
+	b.envelope.Body.Tool = name
+	return b
+}
+
+// Params replaces the call parameters
+func (b *ToolCallBuilder) Params(params map[string]interface{}) *ToolCallBuilder {
+	b.envelope.Body.Parameters = params
+	return b
+}
+
+// Param sets a single call parameter
+func (b *ToolCallBuilder) Param(name string, value interface{}) *ToolCallBuilder {
+	if b.envelope.Body.Parameters == nil {
+		b.envelope.Body.Parameters = make(map[string]interface{})
+	}
+	b.envelope.Body.Parameters[name] = value
+	return b
+}
+
+// RequestID overrides the generated request ID
+func (b *ToolCallBuilder) RequestID(id string) *ToolCallBuilder {
+	b.envelope.Body.RequestID = id
+	return b
+}
+
+// Capability attaches a capability token authorizing the call
+func (b *ToolCallBuilder) Capability(token string) *ToolCallBuilder {
+	b.envelope.Body.Capability = token
+	return b
+}
+
+// Delivery sets how the result is delivered back to the caller
+func (b *ToolCallBuilder) Delivery(guarantee DeliveryGuarantee) *ToolCallBuilder {
+	b.envelope.Body.Delivery = guarantee
+	return b
+}
+
+// ResultDelivery sets how the result comes back to the caller
+func (b *ToolCallBuilder) ResultDelivery(delivery ResultDelivery) *ToolCallBuilder {
+	b.envelope.Body.ResultDelivery = &delivery
+	return b
+}
+
+// Deadline sets when the caller needs the result by. With acceptPartial,
+// output streamed before the deadline is returned as a partial result
+// instead of an error.
+func (b *ToolCallBuilder) Deadline(deadline time.Time, acceptPartial bool) *ToolCallBuilder {
+	b.envelope.Body.Deadline = deadline.UnixMilli()
+	b.envelope.Body.AcceptPartial = acceptPartial
+	return b
+}
+
+// Accept lists the media ranges the caller takes the result in, in preference order
+func (b *ToolCallBuilder) Accept(ranges ...string) *ToolCallBuilder {
+	b.envelope.Body.Accept = ranges
+	return b
+}
+
+// DataRegion requires the call's data to stay in region
+func (b *ToolCallBuilder) DataRegion(region string) *ToolCallBuilder {
+	b.envelope.Body.DataRegion = region
+	return b
+}
+
+// Build validates the envelope and returns it unsigned
+func (b *ToolCallBuilder) Build() (*ToolCallEnvelope, error) {
+	if err := validateHeaders(b.envelope.CommonHeaders); err != nil {
+		return nil, err
+	}
+	if b.envelope.Body.Tool == "" {
+		return nil, fmt.Errorf("%w: tool", ErrMissingField)
+	}
+	if b.envelope.Body.RequestID == "" {
+		return nil, fmt.Errorf("%w: requestId", ErrMissingField)
+	}
+	if b.envelope.Body.Parameters == nil {
+		b.envelope.Body.Parameters = map[string]interface{}{}
+	}
+	envelope := b.envelope
+	return &envelope, nil
+}
+
+// SignWith validates the envelope and signs it with signer
+func (b *ToolCallBuilder) SignWith(signer Signer) (*ToolCallEnvelope, error) {
+	envelope, err := b.Build()
+	if err != nil {
+		return nil, err
+	}
+	if err := envelope.Sign(signer); err != nil {
+		return nil, err
+	}
+	return envelope, nil
+}
+
+// ToolResultBuilder constructs toolResult envelopes
+type ToolResultBuilder struct {
+	envelope ToolResultEnvelope
+}
+
+// NewToolResult starts a successful toolResult envelope answering requestID
+func NewToolResult(agent, requestID string) *ToolResultBuilder {
+	b := &ToolResultBuilder{}
+	b.envelope.Type = EnvelopeToolResult
+	b.envelope.CommonHeaders = newHeaders(agent)
+	b.envelope.Body.RequestID = requestID
+	b.envelope.Body.Success = true
+	return b
+}
+
+// Result sets the tool's result
+func (b *ToolResultBuilder) Result(result interface{}) *ToolResultBuilder {
+	b.envelope.Body.Result = result
+	return b
+}
+
+// ContentType sets the media type of the result
+func (b *ToolResultBuilder) ContentType(contentType string) *ToolResultBuilder {
+	b.envelope.Body.ContentType = contentType
+	return b
+}
+
+// Binary sets a binary or image result of the given media type, carried as base64
+func (b *ToolResultBuilder) Binary(contentType string, data []byte) *ToolResultBuilder {
+	b.envelope.Body.Result = base64.StdEncoding.EncodeToString(data)
+	b.envelope.Body.ContentType = contentType
+	return b
+}
+
+// Error marks the call as failed with a message
+func (b *ToolResultBuilder) Error(message string) *ToolResultBuilder {
+	b.envelope.Body.Success = false
+	b.envelope.Body.Error = message
+	return b
+}
+
+// Partial marks the result as the output of a call that did not finish
+func (b *ToolResultBuilder) Partial() *ToolResultBuilder {
+	b.envelope.Body.Partial = true
+	return b
+}
+
+// Build validates the envelope and returns it unsigned
+func (b *ToolResultBuilder) Build() (*ToolResultEnvelope, error) {
+	if err := validateHeaders(b.envelope.CommonHeaders); err != nil {
+		return nil, err
+	}
+	if b.envelope.Body.RequestID == "" {
+		return nil, fmt.Errorf("%w: requestId", ErrMissingField)
+	}
+	envelope := b.envelope
+	return &envelope, nil
+}
+
+// SignWith validates the envelope and signs it with signer
+func (b *ToolResultBuilder) SignWith(signer Signer) (*ToolResultEnvelope, error) {
+	envelope, err := b.Build()
+	if err != nil {
+		return nil, err
+	}
+	if err := envelope.Sign(signer); err != nil {
+		return nil, err
+	}
+	return envelope, nil
+}
+
+// ToolResultChunkBuilder constructs toolResultChunk envelopes
+type ToolResultChunkBuilder struct {
+	envelope ToolResultChunkEnvelope
+}
+
+// NewToolResultChunk starts chunk seq of the streamed result answering requestID
+func NewToolResultChunk(agent, requestID string, seq uint64) *ToolResultChunkBuilder {
+	b := &ToolResultChunkBuilder{}
+	b.envelope.Type = EnvelopeToolResultChunk
+	b.envelope.CommonHeaders = newHeaders(agent)
+	b.envelope.Body.RequestID = requestID
+	b.envelope.Body.Seq = seq
+	return b
+}
+
+// Data sets the output carried by the chunk and the stream it came from
+func (b *ToolResultChunkBuilder) Data(stream, data string) *ToolResultChunkBuilder {
+	b.envelope.Body.Stream = stream
+	b.envelope.Body.Data = data
+	return b
+}
+
+// Final marks the chunk as the last one of a successful result
+func (b *ToolResultChunkBuilder) Final(result interface{}) *ToolResultChunkBuilder {
+	b.envelope.Body.Final = true
+	b.envelope.Body.Success = true
+	b.envelope.Body.Result = result
+	return b
+}
+
+// Fail marks the chunk as the last one of a failed result
+func (b *ToolResultChunkBuilder) Fail(message string) *ToolResultChunkBuilder {
+	b.envelope.Body.Final = true
+	b.envelope.Body.Success = false
+	b.envelope.Body.Error = message
+	return b
+}
+
+// Build validates the envelope and returns it unsigned
+func (b *ToolResultChunkBuilder) Build() (*ToolResultChunkEnvelope, error) {
+	if err := validateHeaders(b.envelope.CommonHeaders); err != nil {
+		return nil, err
+	}
+	if b.envelope.Body.RequestID == "" {
+		return nil, fmt.Errorf("%w: requestId", ErrMissingField)
+	}
+	if err := b.envelope.Body.Validate(); err != nil {
+		return nil, err
+	}
+	envelope := b.envelope
+	return &envelope, nil
+}
+
+// SignWith validates the envelope and signs it with signer
+func (b *ToolResultChunkBuilder) SignWith(signer Signer) (*ToolResultChunkEnvelope, error) {
+	envelope, err := b.Build()
+	if err != nil {
+		return nil, err
+	}
+	if err := envelope.Sign(signer); err != nil {
+		return nil, err
+	}
+	return envelope, nil
+}
+
+// DiscoverToolsBuilder constructs discoverTools envelopes
+type DiscoverToolsBuilder struct {
+	envelope DiscoverToolsEnvelope
+}
+
+// NewDiscoverTools starts a discoverTools envelope from agent with a fresh nonce and request ID
+func NewDiscoverTools(agent string) *DiscoverToolsBuilder {
+	b := &DiscoverToolsBuilder{}
+	b.envelope.Type = EnvelopeDiscoverTools
+	b.envelope.CommonHeaders = newHeaders(agent)
+	b.envelope.Body.RequestID = NewRandomID()
+	return b
+}
+
+// Capabilities sets the capability patterns to search for, e.g. "math.*". No patterns match every tool.
+func (b *DiscoverToolsBuilder) Capabilities(patterns ...string) *DiscoverToolsBuilder {
+	b.envelope.Body.Query.Capabilities = patterns
+	return b
+}
+
+// Query replaces the whole query
+func (b *DiscoverToolsBuilder) Query(query ToolQuery) *DiscoverToolsBuilder {
+	b.envelope.Body.Query = query
+	return b
+}
+
+// Environment restricts results to an environment type
+func (b *DiscoverToolsBuilder) Environment(environmentType string) *DiscoverToolsBuilder {
+	b.envelope.Body.Query.EnvironmentType = environmentType
+	return b
+}
+
+// MaxResults limits the number of results
+func (b *DiscoverToolsBuilder) MaxResults(n int) *DiscoverToolsBuilder {
+	b.envelope.Body.Query.MaxResults = n
+	return b
+}
+
+// IncludeMetadata asks for tool metadata in the results
+func (b *DiscoverToolsBuilder) IncludeMetadata() *DiscoverToolsBuilder {
+	b.envelope.Body.Query.IncludeMetadata = true
+	return b
+}
+
+// RequestID overrides the generated request ID
+func (b *DiscoverToolsBuilder) RequestID(id string) *DiscoverToolsBuilder {
+	b.envelope.Body.RequestID = id
+	return b
+}
+
+// Capability attaches a capability token scoping the results
+func (b *DiscoverToolsBuilder) Capability(token string) *DiscoverToolsBuilder {
+	b.envelope.Body.Capability = token
+	return b
+}
+
+// Build validates the envelope and returns it unsigned
+func (b *DiscoverToolsBuilder) Build() (*DiscoverToolsEnvelope, error) {
+	if err := validateHeaders(b.envelope.CommonHeaders); err != nil {
+		return nil, err
+	}
+	if b.envelope.Body.RequestID == "" {
+		return nil, fmt.Errorf("%w: requestId", ErrMissingField)
+	}
+	envelope := b.envelope
+	return &envelope, nil
+}
+
+// SignWith validates the envelope and signs it with signer
+func (b *DiscoverToolsBuilder) SignWith(signer Signer) (*DiscoverToolsEnvelope, error) {
+	envelope, err := b.Build()
+	if err != nil {
+		return nil, err
+	}
+	if err := envelope.Sign(signer); err != nil {
+		return nil, err
+	}
+	return envelope, nil
+}
+
+// HeartbeatBuilder constructs heartbeat envelopes
+type HeartbeatBuilder struct {
+	envelope HeartbeatEnvelope
+}
+
+// NewHeartbeat starts a heartbeat envelope from agent with a fresh nonce
+func NewHeartbeat(agent string) *HeartbeatBuilder {
+	b := &HeartbeatBuilder{}
+	b.envelope.Type = EnvelopeHeartbeat
+	b.envelope.CommonHeaders = newHeaders(agent)
+	return b
+}
+
+// Load sets the fraction of capacity in use, from 0 to 1
+func (b *HeartbeatBuilder) Load(load float64) *HeartbeatBuilder {
+	b.envelope.Body.Load = load
+	return b
+}
+
+// InFlight sets the number of requests being processed
+func (b *HeartbeatBuilder) InFlight(n int) *HeartbeatBuilder {
+	b.envelope.Body.InFlight = n
+	return b
+}
+
+// Uptime sets how long the agent has been running
+func (b *HeartbeatBuilder) Uptime(uptime time.Duration) *HeartbeatBuilder {
+	b.envelope.Body.Uptime = int64(uptime / time.Second)
+	return b
+}
+
+// Instance sets the replica reporting, for agents registered with an instance ID
+func (b *HeartbeatBuilder) Instance(id string) *HeartbeatBuilder {
+	b.envelope.Body.InstanceID = id
+	return b
+}
+
+// Build validates the envelope and returns it unsigned
+func (b *HeartbeatBuilder) Build() (*HeartbeatEnvelope, error) {
+	if err := validateHeaders(b.envelope.CommonHeaders); err != nil {
+		return nil, err
+	}
+	if err := b.envelope.Body.Validate(); err != nil {
+		return nil, err
+	}
+	envelope := b.envelope
+	return &envelope, nil
+}
+
+// SignWith validates the envelope and signs it with signer
+func (b *HeartbeatBuilder) SignWith(signer Signer) (*HeartbeatEnvelope, error) {
+	envelope, err := b.Build()
+	if err != nil {
+		return nil, err
+	}
+	if err := envelope.Sign(signer); err != nil {
+		return nil, err
+	}
+	return envelope, nil
+}
+
+// BatchBuilder constructs batch envelopes
+type BatchBuilder struct {
+	envelope BatchEnvelope
+	err      error
+}
+
+// NewBatch starts an empty batch envelope from agent with a fresh nonce
+func NewBatch(agent string) *BatchBuilder {
+	b := &BatchBuilder{}
+	b.envelope.Type = EnvelopeBatch
+	b.envelope.CommonHeaders = newHeaders(agent)
+	return b
+}
+
+// Add appends a signed envelope to the batch
+func (b *BatchBuilder) Add(envelope interface{}) *BatchBuilder {
+	data, err := json.Marshal(envelope)
+	if err != nil {
+		if b.err == nil {
+			b.err = fmt.Errorf("failed to encode envelope %d: %w", len(b.envelope.Body.Envelopes), err)
+		}
+		return b
+	}
+	b.envelope.Body.Envelopes = append(b.envelope.Body.Envelopes, data)
+	return b
+}
+
+// Build validates the envelope and returns it unsigned
+func (b *BatchBuilder) Build() (*BatchEnvelope, error) {
+	if b.err != nil {
+		return nil, b.err
+	}
+	if err := validateHeaders(b.envelope.CommonHeaders); err != nil {
+		return nil, err
+	}
+	if err := b.envelope.Body.Validate(); err != nil {
+		return nil, err
+	}
+	envelope := b.envelope
+	return &envelope, nil
+}
+
+// SignWith validates the envelope and signs it with signer
+func (b *BatchBuilder) SignWith(signer Signer) (*BatchEnvelope, error) {
+	envelope, err := b.Build()
+	if err != nil {
+		return nil, err
+	}
+	if err := envelope.Sign(signer); err != nil {
+		return nil, err
+	}
+	return envelope, nil
+}
+
+// validateHeaders checks the headers every envelope needs
+func validateHeaders(headers CommonHeaders) error {
+	if headers.Agent == "" {
+		return fmt.Errorf("%w: agent", ErrMissingField)
+	}
+	return nil
+}
@@ -0,0 +1,115 @@
+package protocol
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestToolCallBuilder(t *testing.T) {
+	pub, priv, _ := GenerateKeyPair()
+
+	envelope, err := NewToolCall("caller").
+		Tool("math.add").
+		Param("a", 1).
+		Param("b", 2).
+		Capability("token").
+		SignWith(priv)
+	if err != nil {
+		t.Fatalf("SignWith failed: %v", err)
+	}
+	if envelope.Type != EnvelopeToolCall || envelope.Agent != "caller" || envelope.TS == 0 {
+		t.Errorf("Headers not filled: %+v", envelope.BaseEnvelope)
+	}
+	if envelope.Nonce == "" || envelope.Body.RequestID == "" || envelope.Body.Capability != "token" {
+		t.Errorf("Generated fields missing: %+v", envelope)
+	}
+	if err := verifyEnvelope(envelope.Type, envelope.CommonHeaders, envelope.Body, pub); err != nil {
+		t.Errorf("Built envelope should verify: %v", err)
+	}
+
+	other, _ := NewToolCall("caller").Tool("math.add").Build()
+	if other.Nonce == envelope.Nonce || other.Body.RequestID == envelope.Body.RequestID {
+		t.Error("Each envelope should get a fresh nonce and request ID")
+	}
+}
+
+func TestBuildersValidateRequiredFields(t *testing.T) {
+	if _, err := NewToolCall("caller").Build(); !errors.Is(err, ErrMissingField) {
+		t.Errorf("Tool call without a tool should fail, got %v", err)
+	}
+	if _, err := NewToolCall("").Tool("math.add").Build(); !errors.Is(err, ErrMissingField) {
+		t.Errorf("Tool call without an agent should fail, got %v", err)
+	}
+	if _, err := NewDiscoverTools("caller").RequestID("").Build(); !errors.Is(err, ErrMissingField) {
+		t.Errorf("Discovery without a request ID should fail, got %v", err)
+	}
+	if _, err := NewToolResult("agent", "").Result(3).Build(); !errors.Is(err, ErrMissingField) {
+		t.Errorf("Result without a request ID should fail, got %v", err)
+	}
+
+	result, err := NewToolResult("agent", "req-1").Error("division by zero").Build()
+	if err != nil || result.Body.Success || result.Body.Error != "division by zero" {
+		t.Errorf("Failed result not built correctly: %+v, %v", result, err)
+	}
+}
+
+func TestBatchBuilder(t *testing.T) {
+	pub, priv, _ := GenerateKeyPair()
+	call, _ := NewToolCall("caller").Tool("math.add").SignWith(priv)
+	heartbeat, _ := NewHeartbeat("caller").Load(0.5).SignWith(priv)
+
+	batch, err := NewBatch("caller").Add(call).Add(heartbeat).SignWith(priv)
+	if err != nil {
+		t.Fatalf("Failed to build batch: %v", err)
+	}
+	if batch.Type != EnvelopeBatch || len(batch.Body.Envelopes) != 2 {
+		t.Fatalf("Unexpected batch: %+v", batch)
+	}
+	if err := verifyEnvelope(batch.Type, batch.CommonHeaders, batch.Body, pub); err != nil {
+		t.Errorf("Batch signature does not verify: %v", err)
+	}
+	inner, err := Parse[ToolCallBody](batch.Body.Envelopes[0])
+	if err != nil || inner.Verify(pub) != nil {
+		t.Errorf("Batched envelope should keep its own signature, got %v", err)
+	}
+
+	if _, err := NewBatch("caller").Build(); err == nil {
+		t.Error("Empty batch should be rejected")
+	}
+	oversized := NewBatch("caller")
+	for i := 0; i <= MaxBatchSize; i++ {
+		oversized.Add(heartbeat)
+	}
+	if _, err := oversized.Build(); err == nil {
+		t.Error("Batch over MaxBatchSize should be rejected")
+	}
+	if _, err := NewBatch("caller").Add(func() {}).Build(); err == nil {
+		t.Error("Unencodable envelope should be reported")
+	}
+}
+
+func TestToolResultChunkBuilder(t *testing.T) {
+	pub, priv, _ := GenerateKeyPair()
+
+	chunk, err := NewToolResultChunk("builder", "build-1", 1).Data("stdout", "compiling\n").SignWith(priv)
+	if err != nil {
+		t.Fatalf("Failed to build chunk: %v", err)
+	}
+	if chunk.Type != EnvelopeToolResultChunk || chunk.Body.Final || chunk.Body.Data != "compiling\n" {
+		t.Errorf("Unexpected chunk: %+v", chunk)
+	}
+	if err := verifyEnvelope(chunk.Type, chunk.CommonHeaders, chunk.Body, pub); err != nil {
+		t.Errorf("Chunk signature does not verify: %v", err)
+	}
+
+	final, err := NewToolResultChunk("builder", "build-1", 2).Fail("exit status 1").Build()
+	if err != nil || !final.Body.Final || final.Body.Success {
+		t.Errorf("Failed final chunk not built correctly: %+v, %v", final, err)
+	}
+	if _, err := NewToolResultChunk("builder", "build-1", 0).Build(); err == nil {
+		t.Error("Chunk numbered 0 should be rejected")
+	}
+	if _, err := NewToolResultChunk("builder", "", 1).Build(); !errors.Is(err, ErrMissingField) {
+		t.Errorf("Chunk without a request ID should fail, got %v", err)
+	}
+}
@@ -1,7 +1,10 @@
 package protocol
 
 import (
+	"crypto/ed25519"
+	"errors"
 	"fmt"
+	"sync"
 	"time"
 
 	"github.com/golang-jwt/jwt/v5"
@@ -14,27 +17,109 @@ type Capability struct {
 	Permissions []string `json:"permissions"`
 	Issuer      string   `json:"iss"`
 	Subject     string   `json:"sub"`
+	Holder      string   `json:"holder,omitempty"` // Subject's public key, required to delegate the capability
+	Parent      string   `json:"parent,omitempty"` // Token this one was delegated from
 }
 
-// CapabilityManager handles capability token creation and validation
+// Errors ValidateCapability wraps for tokens that were valid once
+var (
+	ErrCapabilityExpired = errors.New("capability token has expired")
+	ErrCapabilityRevoked = errors.New("capability token has been revoked")
+)
+
+// CapabilityManager handles capability token creation and validation. Tokens
+// are signed with Ed25519 (EdDSA) when the manager has a private key, so
+// anyone holding the public key can verify them offline but not mint them.
+// The legacy HS256 mode shares one secret between issuers and verifiers.
 type CapabilityManager struct {
-	signingKey []byte
+	signingKey []byte             // HS256 secret; HMAC tokens are refused if empty
+	signer     Signer             // Signs EdDSA tokens when set
+	issuerKeys []ed25519.PublicKey
+	revoked    func(tokenID string) bool // Reports tokens revoked before they expire
+	mu         sync.RWMutex
 }
 
-// NewCapabilityManager creates a new capability manager
+// NewCapabilityManager creates a capability manager signing and verifying
+// HS256 tokens with a shared secret
 func NewCapabilityManager(signingKey []byte) *CapabilityManager {
 	return &CapabilityManager{
 		signingKey: signingKey,
 	}
 }
 
+// NewEd25519CapabilityManager creates a capability manager signing EdDSA
+// tokens with signer and accepting tokens signed by it
+func NewEd25519CapabilityManager(signer Signer) *CapabilityManager {
+	return &CapabilityManager{
+		signer:     signer,
+		issuerKeys: []ed25519.PublicKey{signer.Public().(ed25519.PublicKey)},
+	}
+}
+
+// NewCapabilityVerifier creates a manager that only verifies EdDSA tokens
+// from the given issuers, e.g. an agent holding its broker's public key
+func NewCapabilityVerifier(issuerKeys ...ed25519.PublicKey) *CapabilityManager {
+	return &CapabilityManager{issuerKeys: issuerKeys}
+}
+
+// TrustIssuer also accepts EdDSA tokens signed by publicKey, e.g. a federated
+// peer broker's identity key
+func (cm *CapabilityManager) TrustIssuer(publicKey ed25519.PublicKey) {
+	cm.mu.Lock()
+	defer cm.mu.Unlock()
+	for _, key := range cm.issuerKeys {
+		if key.Equal(publicKey) {
+			return
+		}
+	}
+	cm.issuerKeys = append(cm.issuerKeys, publicKey)
+}
+
+// AcceptLegacySecret also accepts HS256 tokens made with signingKey, so
+// tokens issued before switching to EdDSA stay valid until they expire
+func (cm *CapabilityManager) AcceptLegacySecret(signingKey []byte) {
+	cm.mu.Lock()
+	defer cm.mu.Unlock()
+	cm.signingKey = signingKey
+}
+
+// SetRevocationCheck makes validation refuse tokens for which revoked returns
+// true. Every link of a delegation chain is checked, so revoking a token also
+// revokes the tokens delegated from it.
+func (cm *CapabilityManager) SetRevocationCheck(revoked func(tokenID string) bool) {
+	cm.mu.Lock()
+	defer cm.mu.Unlock()
+	cm.revoked = revoked
+}
+
+// PublicKey returns the key verifiers need for tokens this manager signs, or
+// nil if it does not sign EdDSA tokens
+func (cm *CapabilityManager) PublicKey() ed25519.PublicKey {
+	if cm.signer == nil {
+		return nil
+	}
+	return cm.signer.Public().(ed25519.PublicKey)
+}
+
 // CreateCapability creates a new capability token
 func (cm *CapabilityManager) CreateCapability(scope, issuer, subject string, permissions []string, duration time.Duration) (string, error) {
-	now := time.Now()
-	claims := Capability{
+	return cm.sign(newCapabilityClaims(scope, issuer, subject, permissions, time.Now().Add(duration)))
+}
+
+// CreateDelegableCapability creates a capability token bound to the subject's
+// public key, which the subject can narrow and hand on with DelegateCapability
+func (cm *CapabilityManager) CreateDelegableCapability(scope, issuer, subject string, holderKey ed25519.PublicKey, permissions []string, duration time.Duration) (string, error) {
+	claims := newCapabilityClaims(scope, issuer, subject, permissions, time.Now().Add(duration))
+	claims.Holder = EncodePublicKey(holderKey)
+	return cm.sign(claims)
+}
+
+// newCapabilityClaims fills the claims common to every capability token
+func newCapabilityClaims(scope, issuer, subject string, permissions []string, expires time.Time) Capability {
+	return Capability{
 		RegisteredClaims: jwt.RegisteredClaims{
-			IssuedAt:  jwt.NewNumericDate(now),
-			ExpiresAt: jwt.NewNumericDate(now.Add(duration)),
+			IssuedAt:  jwt.NewNumericDate(time.Now()),
+			ExpiresAt: jwt.NewNumericDate(expires),
 			ID:        generateNonce(),
 		},
 		Scope:       scope,
@@ -42,23 +127,74 @@ func (cm *CapabilityManager) CreateCapability(scope, issuer, subject string, per
 		Issuer:      issuer,
 		Subject:     subject,
 	}
+}
 
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	return token.SignedString(cm.signingKey)
+// sign signs claims with the manager's private key or legacy secret
+func (cm *CapabilityManager) sign(claims Capability) (string, error) {
+	switch {
+	case cm.signer != nil:
+		token := jwt.NewWithClaims(jwt.SigningMethodEdDSA, claims)
+		token.Header["kid"] = KeyID(cm.PublicKey())
+		return token.SignedString(cm.signer)
+	case len(cm.signingKey) > 0:
+		token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+		return token.SignedString(cm.signingKey)
+	default:
+		return "", fmt.Errorf("capability manager has no signing key")
+	}
 }
 
-// ValidateCapability validates a capability token
+// ValidateCapability validates a capability token. EdDSA tokens must be
+// signed by a trusted issuer; HS256 tokens are only accepted with a secret.
+// Delegated tokens are checked link by link back to a trusted root.
 func (cm *CapabilityManager) ValidateCapability(tokenString string) (*Capability, error) {
+	return cm.validate(tokenString, 0)
+}
+
+// validate checks a token found depth delegations below the one presented
+func (cm *CapabilityManager) validate(tokenString string, depth int) (*Capability, error) {
+	var parent *Capability
 	token, err := jwt.ParseWithClaims(tokenString, &Capability{}, func(token *jwt.Token) (interface{}, error) {
-		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
+		if claims := token.Claims.(*Capability); claims.Parent != "" {
+			var err error
+			parent, err = cm.validate(claims.Parent, depth+1)
+			if err != nil {
+				return nil, fmt.Errorf("invalid parent capability: %w", err)
+			}
+			return delegatorKey(token, parent, depth)
+		}
+		switch token.Method.(type) {
+		case *jwt.SigningMethodEd25519:
+			return cm.issuerKeySet(token), nil
+		case *jwt.SigningMethodHMAC:
+			cm.mu.RLock()
+			defer cm.mu.RUnlock()
+			if len(cm.signingKey) == 0 {
+				return nil, fmt.Errorf("HMAC capability tokens are not accepted")
+			}
+			return cm.signingKey, nil
+		default:
 			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
 		}
-		return cm.signingKey, nil
 	})
 
+	if errors.Is(err, jwt.ErrTokenExpired) {
+		return nil, fmt.Errorf("%w: %v", ErrCapabilityExpired, err)
+	}
 	if err != nil {
 		return nil, err
 	}
+	if parent != nil {
+		if err := checkAttenuation(parent, token.Claims.(*Capability)); err != nil {
+			return nil, err
+		}
+	}
+	cm.mu.RLock()
+	revoked := cm.revoked
+	cm.mu.RUnlock()
+	if claims := token.Claims.(*Capability); revoked != nil && claims.ID != "" && revoked(claims.ID) {
+		return nil, fmt.Errorf("%w: %s", ErrCapabilityRevoked, claims.ID)
+	}
 
 	if claims, ok := token.Claims.(*Capability); ok && token.Valid {
 		return claims, nil
@@ -67,6 +203,35 @@ func (cm *CapabilityManager) ValidateCapability(tokenString string) (*Capability
 	return nil, fmt.Errorf("invalid token")
 }
 
+// CapabilityTokenID returns a token's jti claim without verifying it, e.g. to
+// name the token in a revokeCapability envelope
+func CapabilityTokenID(tokenString string) (string, error) {
+	claims := &Capability{}
+	if _, _, err := jwt.NewParser().ParseUnverified(tokenString, claims); err != nil {
+		return "", err
+	}
+	if claims.ID == "" {
+		return "", fmt.Errorf("capability token has no jti")
+	}
+	return claims.ID, nil
+}
+
+// issuerKeySet returns the trusted keys a token may be signed by, narrowed to
+// the one its kid header names when that key is trusted
+func (cm *CapabilityManager) issuerKeySet(token *jwt.Token) jwt.VerificationKeySet {
+	cm.mu.RLock()
+	defer cm.mu.RUnlock()
+	set := jwt.VerificationKeySet{}
+	kid, _ := token.Header["kid"].(string)
+	for _, key := range cm.issuerKeys {
+		if kid != "" && KeyID(key) == kid {
+			return jwt.VerificationKeySet{Keys: []jwt.VerificationKey{key}}
+		}
+		set.Keys = append(set.Keys, key)
+	}
+	return set
+}
+
 // HasPermission checks if the capability has a specific permission
 func (c *Capability) HasPermission(permission string) bool {
 	for _, p := range c.Permissions {
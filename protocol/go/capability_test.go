@@ -1,6 +1,8 @@
 package protocol
 
 import (
+	"crypto/ed25519"
+	"errors"
 	"testing"
 	"time"
 	
@@ -246,8 +248,8 @@ func TestCapabilityExpiration(t *testing.T) {
 
 	// Should fail to parse because JWT library validates expiration
 	_, err = cm.ValidateCapability(token)
-	if err == nil {
-		t.Error("Expected validation to fail for expired capability")
+	if !errors.Is(err, ErrCapabilityExpired) {
+		t.Errorf("Expected validation to fail for expired capability, got %v", err)
 	}
 }
 
@@ -335,4 +337,101 @@ func splitString(s, sep string) []string {
 	result = append(result, s[start:])
 	
 	return result
-}
\ No newline at end of file
+}
+func TestEd25519CapabilityVerifiedOffline(t *testing.T) {
+	_, brokerKey, _ := GenerateKeyPair()
+	issuer := NewEd25519CapabilityManager(brokerKey)
+
+	token, err := issuer.CreateCapability("discovery", "broker", "agent-1", []string{"discover:math.*"}, time.Hour)
+	if err != nil {
+		t.Fatalf("Failed to create capability: %v", err)
+	}
+
+	// An agent holding only the public key can verify but not mint tokens
+	verifier := NewCapabilityVerifier(issuer.PublicKey())
+	capability, err := verifier.ValidateCapability(token)
+	if err != nil {
+		t.Fatalf("Expected offline verification to succeed: %v", err)
+	}
+	if capability.Subject != "agent-1" {
+		t.Errorf("Expected subject agent-1, got %s", capability.Subject)
+	}
+	if _, err := verifier.CreateCapability("discovery", "agent-1", "agent-1", []string{"*"}, time.Hour); err == nil {
+		t.Error("Expected a verifier without a private key to refuse to mint tokens")
+	}
+
+	_, otherKey, _ := GenerateKeyPair()
+	if _, err := NewCapabilityVerifier(otherKey.Public().(ed25519.PublicKey)).ValidateCapability(token); err == nil {
+		t.Error("Expected a token from an untrusted issuer to be rejected")
+	}
+}
+
+func TestTrustIssuerAcceptsFederatedTokens(t *testing.T) {
+	_, localKey, _ := GenerateKeyPair()
+	_, peerKey, _ := GenerateKeyPair()
+	local := NewEd25519CapabilityManager(localKey)
+	peer := NewEd25519CapabilityManager(peerKey)
+
+	token, _ := peer.CreateCapability("discovery", "peer-broker", "agent-1", []string{"discover:*"}, time.Hour)
+	if _, err := local.ValidateCapability(token); err == nil {
+		t.Fatal("Expected a peer token to be rejected before trusting the peer")
+	}
+
+	local.TrustIssuer(peer.PublicKey())
+	if _, err := local.ValidateCapability(token); err != nil {
+		t.Errorf("Expected a trusted peer token to be accepted: %v", err)
+	}
+}
+
+func TestEd25519ManagerRejectsHMACUnlessLegacySecretAccepted(t *testing.T) {
+	_, brokerKey, _ := GenerateKeyPair()
+	cm := NewEd25519CapabilityManager(brokerKey)
+	legacy := NewCapabilityManager([]byte("broker-secret"))
+
+	token, _ := legacy.CreateCapability("discovery", "broker", "agent-1", []string{"discover:*"}, time.Hour)
+	if _, err := cm.ValidateCapability(token); err == nil {
+		t.Fatal("Expected an HS256 token to be rejected by an EdDSA manager")
+	}
+
+	cm.AcceptLegacySecret([]byte("broker-secret"))
+	if _, err := cm.ValidateCapability(token); err != nil {
+		t.Errorf("Expected a legacy HS256 token to be accepted: %v", err)
+	}
+
+	// New tokens are still signed with EdDSA
+	fresh, _ := cm.CreateCapability("discovery", "broker", "agent-1", []string{"discover:*"}, time.Hour)
+	parsed, _, err := jwt.NewParser().ParseUnverified(fresh, &Capability{})
+	if err != nil {
+		t.Fatalf("Failed to parse token: %v", err)
+	}
+	if parsed.Method.Alg() != "EdDSA" {
+		t.Errorf("Expected EdDSA, got %s", parsed.Method.Alg())
+	}
+}
+
+func TestRevokedCapabilityRejected(t *testing.T) {
+	_, brokerKey, _ := GenerateKeyPair()
+	cm := NewEd25519CapabilityManager(brokerKey)
+	agentPub, agentKey, _ := GenerateKeyPair()
+
+	root, _ := cm.CreateDelegableCapability("tools", "broker", "agent-1", agentPub, []string{"call:*"}, time.Hour)
+	child, _ := DelegateCapability(root, agentKey, "sub-agent", nil, []string{"call:math.add"}, time.Hour)
+	capability, err := cm.ValidateCapability(root)
+	if err != nil {
+		t.Fatalf("Failed to validate capability: %v", err)
+	}
+
+	revoked := map[string]bool{}
+	cm.SetRevocationCheck(func(tokenID string) bool { return revoked[tokenID] })
+	if _, err := cm.ValidateCapability(child); err != nil {
+		t.Fatalf("Expected the delegated capability to validate before revocation: %v", err)
+	}
+
+	revoked[capability.ID] = true
+	if _, err := cm.ValidateCapability(root); !errors.Is(err, ErrCapabilityRevoked) {
+		t.Errorf("Expected a revoked capability to be rejected, got %v", err)
+	}
+	if _, err := cm.ValidateCapability(child); !errors.Is(err, ErrCapabilityRevoked) {
+		t.Errorf("Expected capabilities delegated from a revoked one to be rejected, got %v", err)
+	}
+}
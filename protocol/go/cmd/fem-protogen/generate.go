@@ -0,0 +1,515 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"go/ast"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/fep-fem/protocol/cmd/internal/gosource"
+)
+
+// header starts the generated files
+const header = `Code generated by fem-protogen from the Go protocol package. DO NOT EDIT.
+Run "make proto" after changing envelope or body types.`
+
+// extraRoots are types carried inside ack results rather than as envelope bodies
+var extraRoots = []string{"ResultDeliveryBody", "BatchResultBody", "DrainStatusBody", "ResumeBody"}
+
+// scalarGoTypes are the Go types of the protobuf scalar types
+var scalarGoTypes = map[string]string{
+	"string": "string",
+	"bool":   "bool",
+	"int32":  "int32",
+	"int64":  "int64",
+	"uint32": "uint32",
+	"uint64": "uint64",
+	"float":  "float32",
+	"double": "float64",
+	"bytes":  "[]byte",
+}
+
+// goScalars map Go basic types to protobuf scalar types
+var goScalars = map[string]string{
+	"string":  "string",
+	"bool":    "bool",
+	"int":     "int64",
+	"int8":    "int32",
+	"int16":   "int32",
+	"int32":   "int32",
+	"int64":   "int64",
+	"uint":    "uint64",
+	"uint8":   "uint32",
+	"uint16":  "uint32",
+	"uint32":  "uint32",
+	"uint64":  "uint64",
+	"float32": "float",
+	"float64": "double",
+}
+
+// specialTypes map protocol types with custom JSON encodings to the scalar
+// carrying their JSON form
+var specialTypes = map[string]string{
+	"Duration": "string", // Go duration string, e.g. "1.5s"
+	"Time":     "string", // RFC 3339 with milliseconds
+}
+
+// valueType is the protobuf type of a value
+type valueType struct {
+	scalar  string // Protobuf scalar type, for scalars
+	message string // Message name, for messages
+	json    bool   // Arbitrary JSON, carried as its text in bytes
+}
+
+// label says how many values a field holds
+type label int
+
+const (
+	single   label = iota
+	optional       // Scalar with explicit presence, from a Go pointer
+	repeated
+	mapped
+)
+
+// field is a message field
+type field struct {
+	goName   string
+	jsonName string
+	name     string // Protobuf field name
+	number   int
+	doc      string
+	label    label
+	key      string // Scalar key type of maps
+	value    valueType
+	envType  string // Envelope type whose body the field carries, in the envelope's body oneof
+}
+
+// message is a protobuf message derived from a Go struct
+type message struct {
+	name     string
+	doc      string
+	fields   []*field
+	reserved []int // Numbers of removed fields
+}
+
+// schema is the protobuf form of the protocol package
+type schema struct {
+	src      *gosource.Package
+	envelope *message
+	messages []*message // Messages of the protocol types, in the order first reached
+	queued   map[string]bool
+	inline   map[string]*ast.StructType // Anonymous structs, by the message name given to them
+	queue    []string
+	err      error
+}
+
+// buildSchema derives the messages of the protocol package's envelope types.
+// Field numbers already published in previous are kept, and numbers of
+// fields since removed stay reserved, so the wire format stays compatible.
+func buildSchema(src *gosource.Package, previous []byte) (*schema, error) {
+	s := &schema{src: src, queued: make(map[string]bool), inline: make(map[string]*ast.StructType)}
+
+	envTypes := make([]string, 0, len(src.Bodies))
+	for envType := range src.Bodies {
+		envTypes = append(envTypes, envType)
+	}
+	sort.Strings(envTypes)
+
+	s.require("CommonHeaders")
+	for _, envType := range envTypes {
+		s.require(src.Bodies[envType])
+	}
+	for _, name := range extraRoots {
+		s.require(name)
+	}
+	for len(s.queue) > 0 {
+		name := s.queue[0]
+		s.queue = s.queue[1:]
+		s.build(name)
+	}
+	if s.err != nil {
+		return nil, s.err
+	}
+
+	s.envelope = &message{
+		name: "Envelope",
+		doc:  "Envelope is an FEP envelope. The body is set in the field of its type, or as JSON\nfor envelope types without a message and for encrypted bodies.",
+		fields: []*field{
+			{goName: "Type", name: "type", doc: "Envelope type, e.g. \"toolCall\"", value: valueType{scalar: "string"}},
+			{goName: "Headers", name: "headers", value: valueType{message: "CommonHeaders"}},
+			{goName: "JSONBody", name: "json_body", value: valueType{json: true}},
+		},
+	}
+	for _, envType := range envTypes {
+		s.envelope.fields = append(s.envelope.fields, &field{
+			goName:  exportName(envType),
+			name:    snakeCase(envType),
+			value:   valueType{message: src.Bodies[envType]},
+			envType: envType,
+		})
+	}
+	for _, f := range s.envelope.fields {
+		f.jsonName = protoJSONName(f.name)
+	}
+
+	published := parseNumbers(previous)
+	for _, m := range append([]*message{s.envelope}, s.messages...) {
+		if m != s.envelope && m.name == s.envelope.name {
+			return nil, fmt.Errorf("protocol type %s clashes with the envelope message", m.name)
+		}
+		numbers := published[m.name]
+		names := make([]string, len(m.fields))
+		seen := make(map[string]bool, len(m.fields))
+		for i, f := range m.fields {
+			if seen[f.name] {
+				return nil, fmt.Errorf("message %s has two fields named %s", m.name, f.name)
+			}
+			seen[f.name] = true
+			names[i] = f.name
+		}
+		numbers.assign(names)
+		for _, f := range m.fields {
+			f.number = numbers.fields[f.name]
+		}
+		m.reserved = numbers.reserved
+		sort.SliceStable(m.fields, func(i, j int) bool { return m.fields[i].number < m.fields[j].number })
+	}
+	return s, nil
+}
+
+// require queues a named type for conversion to a message
+func (s *schema) require(name string) {
+	if s.queued[name] {
+		return
+	}
+	s.queued[name] = true
+	s.queue = append(s.queue, name)
+}
+
+func (s *schema) fail(format string, args ...interface{}) {
+	if s.err == nil {
+		s.err = fmt.Errorf(format, args...)
+	}
+}
+
+// build converts the struct type name to a message
+func (s *schema) build(name string) {
+	m := &message{name: name, doc: docText(s.src.Docs[name])}
+	typ, isInline := s.inline[name]
+	if !isInline {
+		spec, exists := s.src.Types[name]
+		if !exists {
+			s.fail("type %s is not declared in the protocol package", name)
+			return
+		}
+		structType, ok := spec.Type.(*ast.StructType)
+		if !ok {
+			s.fail("type %s is not a struct", name)
+			return
+		}
+		typ = structType
+	}
+	s.fields(m, typ)
+	s.messages = append(s.messages, m)
+}
+
+// fields adds the JSON members of a struct to m, flattening embedded structs
+// the way encoding/json does
+func (s *schema) fields(m *message, typ *ast.StructType) {
+	for _, f := range typ.Fields.List {
+		tag := ""
+		if f.Tag != nil {
+			unquoted, _ := strconv.Unquote(f.Tag.Value)
+			tag = reflect.StructTag(unquoted).Get("json")
+		}
+		if tag == "-" {
+			continue
+		}
+		if len(f.Names) == 0 {
+			ident, ok := f.Type.(*ast.Ident)
+			if !ok {
+				s.fail("%s embeds unsupported type %T", m.name, f.Type)
+				continue
+			}
+			var embedded *ast.StructType
+			if spec, exists := s.src.Types[ident.Name]; exists {
+				embedded, _ = spec.Type.(*ast.StructType)
+			}
+			if embedded == nil {
+				s.fail("%s embeds %s, which is not a struct", m.name, ident.Name)
+				continue
+			}
+			s.fields(m, embedded)
+			continue
+		}
+
+		jsonName, _, _ := strings.Cut(tag, ",")
+		for _, ident := range f.Names {
+			if !ident.IsExported() {
+				continue
+			}
+			name := jsonName
+			if name == "" {
+				name = ident.Name
+			}
+			doc := f.Doc
+			if doc == nil {
+				doc = f.Comment
+			}
+			out := &field{goName: ident.Name, jsonName: name, name: snakeCase(name), doc: docText(doc)}
+			s.typeOf(out, f.Type, m.name+ident.Name)
+			m.fields = append(m.fields, out)
+		}
+	}
+}
+
+// typeOf sets the label and value type of a field of Go type expr. Anonymous
+// structs become messages named inlineName.
+func (s *schema) typeOf(f *field, expr ast.Expr, inlineName string) {
+	switch expr := expr.(type) {
+	case *ast.StarExpr:
+		f.value = s.valueOf(expr.X, inlineName)
+		if f.value.scalar != "" && f.value.scalar != "bytes" {
+			f.label = optional
+		}
+	case *ast.ArrayType:
+		if isByte(expr.Elt) {
+			f.value = valueType{scalar: "bytes"}
+			return
+		}
+		f.label = repeated
+		f.value = s.valueOf(expr.Elt, inlineName)
+	case *ast.MapType:
+		if _, isAny := expr.Value.(*ast.InterfaceType); isAny || isIdent(expr.Value, "any") {
+			f.value = valueType{json: true} // A JSON object
+			return
+		}
+		f.label = mapped
+		key := s.valueOf(expr.Key, inlineName)
+		if key.scalar == "" || key.scalar == "bytes" || key.scalar == "bool" || key.scalar == "float" || key.scalar == "double" {
+			s.fail("field %s has map keys with no protobuf mapping", f.jsonName)
+		}
+		f.key = key.scalar
+		f.value = s.valueOf(expr.Value, inlineName)
+	case *ast.Ident:
+		if spec, exists := s.src.Types[expr.Name]; exists && !isStructSpec(spec) && specialTypes[expr.Name] == "" {
+			// Named non-struct types, e.g. string enums or slices, take their underlying type
+			s.typeOf(f, spec.Type, inlineName)
+			return
+		}
+		f.value = s.valueOf(expr, inlineName)
+	default:
+		f.value = s.valueOf(expr, inlineName)
+	}
+}
+
+// valueOf maps a Go type expression that is not a slice or map to a
+// protobuf value type
+func (s *schema) valueOf(expr ast.Expr, inlineName string) valueType {
+	switch expr := expr.(type) {
+	case *ast.Ident:
+		if scalar, exists := goScalars[expr.Name]; exists {
+			return valueType{scalar: scalar}
+		}
+		if expr.Name == "any" {
+			return valueType{json: true}
+		}
+		if scalar, exists := specialTypes[expr.Name]; exists {
+			return valueType{scalar: scalar}
+		}
+		spec, exists := s.src.Types[expr.Name]
+		if !exists {
+			s.fail("type %s is not declared in the protocol package", expr.Name)
+			return valueType{}
+		}
+		if !isStructSpec(spec) {
+			return s.valueOf(spec.Type, inlineName)
+		}
+		s.require(expr.Name)
+		return valueType{message: expr.Name}
+	case *ast.StarExpr:
+		return s.valueOf(expr.X, inlineName)
+	case *ast.InterfaceType:
+		return valueType{json: true}
+	case *ast.SelectorExpr:
+		switch fmt.Sprintf("%s.%s", expr.X, expr.Sel) {
+		case "json.RawMessage":
+			return valueType{json: true}
+		case "time.Time":
+			return valueType{scalar: "string"}
+		case "time.Duration":
+			return valueType{scalar: "int64"}
+		}
+		s.fail("no protobuf mapping for %s.%s", expr.X, expr.Sel)
+	case *ast.StructType:
+		s.inline[inlineName] = expr
+		s.require(inlineName)
+		return valueType{message: inlineName}
+	case *ast.ArrayType:
+		if isByte(expr.Elt) {
+			return valueType{scalar: "bytes"}
+		}
+		s.fail("no protobuf mapping for nested list in %s", inlineName)
+	default:
+		s.fail("no protobuf mapping for %T in %s", expr, inlineName)
+	}
+	return valueType{}
+}
+
+// docText returns the text of a doc comment without its comment markers
+func docText(doc *ast.CommentGroup) string {
+	if doc == nil {
+		return ""
+	}
+	return strings.TrimSpace(doc.Text())
+}
+
+func isStructSpec(spec *ast.TypeSpec) bool {
+	_, ok := spec.Type.(*ast.StructType)
+	return ok
+}
+
+func isByte(expr ast.Expr) bool {
+	return isIdent(expr, "byte")
+}
+
+func isIdent(expr ast.Expr, name string) bool {
+	ident, ok := expr.(*ast.Ident)
+	return ok && ident.Name == name
+}
+
+// snakeCase converts a JSON member name to a protobuf field name, e.g.
+// "mcpEndpoint" to "mcp_endpoint" and "sessionTTL" to "session_ttl"
+func snakeCase(name string) string {
+	runes := []rune(name)
+	var out strings.Builder
+	for i, r := range runes {
+		if unicode.IsUpper(r) && i > 0 {
+			previous := runes[i-1]
+			nextLower := i+1 < len(runes) && unicode.IsLower(runes[i+1])
+			if unicode.IsLower(previous) || unicode.IsDigit(previous) || (unicode.IsUpper(previous) && nextLower) {
+				out.WriteByte('_')
+			}
+		}
+		if r == '-' || r == '.' {
+			r = '_'
+		}
+		out.WriteRune(unicode.ToLower(r))
+	}
+	return out.String()
+}
+
+// protoJSONName is the JSON name protobuf derives from a field name
+func protoJSONName(name string) string {
+	var out strings.Builder
+	upper := false
+	for _, r := range name {
+		if r == '_' {
+			upper = true
+			continue
+		}
+		if upper {
+			r = unicode.ToUpper(r)
+			upper = false
+		}
+		out.WriteRune(r)
+	}
+	return out.String()
+}
+
+// exportName converts a JSON member name to an exported Go identifier
+func exportName(name string) string {
+	var out strings.Builder
+	for _, part := range strings.Split(snakeCase(name), "_") {
+		if part == "" {
+			continue
+		}
+		switch part {
+		case "id", "url", "ttl", "json", "mcp", "tls", "ip", "uri", "did", "kid":
+			out.WriteString(strings.ToUpper(part))
+		default:
+			out.WriteString(strings.ToUpper(part[:1]) + part[1:])
+		}
+	}
+	return out.String()
+}
+
+// numbering is the field numbers of one message
+type numbering struct {
+	fields   map[string]int // By field name
+	reserved []int          // Numbers of removed fields
+}
+
+// assign numbers the fields named names, keeping published numbers and
+// reserving the numbers of published fields no longer present
+func (n *numbering) assign(names []string) {
+	if n.fields == nil {
+		n.fields = make(map[string]int)
+	}
+	highest := 0
+	for _, number := range n.fields {
+		highest = max(highest, number)
+	}
+	for _, number := range n.reserved {
+		highest = max(highest, number)
+	}
+
+	present := make(map[string]bool, len(names))
+	for _, name := range names {
+		present[name] = true
+		if _, published := n.fields[name]; !published {
+			highest++
+			n.fields[name] = highest
+		}
+	}
+	for name, number := range n.fields {
+		if !present[name] {
+			n.reserved = append(n.reserved, number)
+			delete(n.fields, name)
+		}
+	}
+	sort.Ints(n.reserved)
+}
+
+var (
+	messageLine  = regexp.MustCompile(`^message (\w+) \{`)
+	fieldLine    = regexp.MustCompile(`^\s+(?:repeated |optional )?(?:map<[^>]+>|[\w.]+) (\w+) = (\d+)`)
+	reservedLine = regexp.MustCompile(`^\s+reserved ([\d, ]+);`)
+)
+
+// parseNumbers reads the field numbers of each message in a .proto file
+// written by this generator
+func parseNumbers(proto []byte) map[string]numbering {
+	published := make(map[string]numbering)
+	current := ""
+	scanner := bufio.NewScanner(bytes.NewReader(proto))
+	for scanner.Scan() {
+		line := scanner.Text()
+		if match := messageLine.FindStringSubmatch(line); match != nil {
+			current = match[1]
+			published[current] = numbering{fields: make(map[string]int)}
+			continue
+		}
+		if current == "" {
+			continue
+		}
+		numbers := published[current]
+		if match := fieldLine.FindStringSubmatch(line); match != nil {
+			numbers.fields[match[1]], _ = strconv.Atoi(match[2])
+		} else if match := reservedLine.FindStringSubmatch(line); match != nil {
+			for _, number := range strings.Split(match[1], ",") {
+				if n, err := strconv.Atoi(strings.TrimSpace(number)); err == nil {
+					numbers.reserved = append(numbers.reserved, n)
+				}
+			}
+		}
+		published[current] = numbers
+	}
+	return published
+}
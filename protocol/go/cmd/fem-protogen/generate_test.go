@@ -0,0 +1,112 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/fep-fem/protocol/cmd/internal/gosource"
+)
+
+var update = flag.Bool("update", false, "Regenerate the shipped protobuf definition and Go package")
+
+// Generated files published with the protocol package
+const (
+	shippedProto = "../../../proto/fem.proto"
+	shippedGo    = "../../fempb/fem.pb.go"
+)
+
+// generateFrom builds the schema against previously published field numbers
+func generateFrom(t *testing.T, previous []byte) *schema {
+	t.Helper()
+	parsed, err := gosource.Parse("../..")
+	if err != nil {
+		t.Fatalf("Failed to read protocol package: %v", err)
+	}
+	s, err := buildSchema(parsed, previous)
+	if err != nil {
+		t.Fatalf("Failed to map protocol types: %v", err)
+	}
+	return s
+}
+
+func TestShippedFilesAreCurrent(t *testing.T) {
+	published, err := os.ReadFile(shippedProto)
+	if err != nil {
+		t.Fatalf("Failed to read shipped definition: %v", err)
+	}
+	s := generateFrom(t, published)
+	proto := generateProto(s)
+	generated, err := generateGo(s)
+	if err != nil {
+		t.Fatalf("Failed to generate Go: %v", err)
+	}
+	if *update {
+		if err := os.WriteFile(shippedProto, proto, 0644); err != nil {
+			t.Fatalf("Failed to write definition: %v", err)
+		}
+		if err := os.WriteFile(shippedGo, generated, 0644); err != nil {
+			t.Fatalf("Failed to write Go: %v", err)
+		}
+	}
+
+	for path, want := range map[string][]byte{shippedProto: proto, shippedGo: generated} {
+		shipped, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatalf("Failed to read %s: %v", path, err)
+		}
+		if !bytes.Equal(shipped, want) {
+			t.Errorf("%s differs from the generated file; run go test ./cmd/fem-protogen -update if the change is intended", path)
+		}
+	}
+}
+
+func TestPublishedFieldNumbersKept(t *testing.T) {
+	previous := []byte(`message ToolCallBody {
+  reserved 3;
+  string tool = 7;
+  string legacy_hint = 9;
+}
+`)
+	s := generateFrom(t, previous)
+	var call *message
+	for _, m := range s.messages {
+		if m.name == "ToolCallBody" {
+			call = m
+		}
+	}
+
+	numbers := make(map[string]int)
+	for _, f := range call.fields {
+		numbers[f.name] = f.number
+	}
+	if numbers["tool"] != 7 {
+		t.Errorf("Published field should keep its number, got %d", numbers["tool"])
+	}
+	if numbers["parameters"] != 10 || numbers["request_id"] != 11 {
+		t.Errorf("New fields should be numbered after every published number, got %v", numbers)
+	}
+	if len(call.reserved) != 2 || call.reserved[0] != 3 || call.reserved[1] != 9 {
+		t.Errorf("Removed fields should stay reserved, got %v", call.reserved)
+	}
+	if proto := string(generateProto(s)); !strings.Contains(proto, "message ToolCallBody {\n  reserved 3, 9;\n") {
+		t.Errorf("Reserved numbers not declared in the definition")
+	}
+}
+
+func TestSnakeCase(t *testing.T) {
+	for name, want := range map[string]string{
+		"tool":        "tool",
+		"mcpEndpoint": "mcp_endpoint",
+		"sessionTTL":  "session_ttl",
+		"gpuMemoryMb": "gpu_memory_mb",
+		"toolCall":    "tool_call",
+		"kid":         "kid",
+	} {
+		if got := snakeCase(name); got != want {
+			t.Errorf("snakeCase(%q) = %q, want %q", name, got, want)
+		}
+	}
+}
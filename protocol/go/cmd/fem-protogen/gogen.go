@@ -0,0 +1,266 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"go/format"
+)
+
+// goHeader starts the generated Go file
+const goHeader = `
+package fempb
+
+import (
+	"encoding/json"
+	"math"
+
+	"github.com/fep-fem/protocol"
+)
+`
+
+// generateGo returns the Go types of the schema's messages, with their
+// protobuf encoding and the conversion of envelope bodies from and to JSON
+func generateGo(s *schema) ([]byte, error) {
+	var out bytes.Buffer
+	writeComment(&out, "", header)
+	out.WriteString(goHeader)
+
+	envTypeConstants := make(map[string]string)
+	for _, c := range s.src.Constants["EnvelopeType"] {
+		envTypeConstants[c.Value] = c.Name
+	}
+
+	for _, m := range append([]*message{s.envelope}, s.messages...) {
+		out.WriteString("\n")
+		writeComment(&out, "", m.doc)
+		fmt.Fprintf(&out, "type %s struct {\n", m.name)
+		for _, f := range m.fields {
+			writeComment(&out, "\t", f.doc)
+			fmt.Fprintf(&out, "\t%s %s", f.goName, goFieldType(f))
+			if m != s.envelope {
+				fmt.Fprintf(&out, " `json:\"%s,omitempty\"`", f.jsonName)
+			}
+			out.WriteString("\n")
+		}
+		out.WriteString("}\n")
+		writeMarshal(&out, m)
+		writeUnmarshal(&out, m)
+	}
+
+	out.WriteString(`
+// setBody decodes a JSON body into the field of the envelope's type,
+// reporting whether the type has a message
+func (e *Envelope) setBody(body json.RawMessage) (bool, error) {
+	switch protocol.EnvelopeType(e.Type) {
+`)
+	for _, f := range s.envelope.fields {
+		if f.envType == "" {
+			continue
+		}
+		constant, exists := envTypeConstants[f.envType]
+		if !exists {
+			return nil, fmt.Errorf("no EnvelopeType constant for %s", f.envType)
+		}
+		fmt.Fprintf(&out, "\tcase protocol.%s:\n\t\te.%s = new(%s)\n\t\treturn true, fromJSON[protocol.%s](body, e.%s)\n",
+			constant, f.goName, f.value.message, f.value.message, f.goName)
+	}
+	out.WriteString(`	}
+	return false, nil
+}
+
+// typedBody returns the JSON of the body set in the field of the envelope's
+// type, reporting whether that field is set
+func (e *Envelope) typedBody() (json.RawMessage, bool, error) {
+	switch protocol.EnvelopeType(e.Type) {
+`)
+	for _, f := range s.envelope.fields {
+		if f.envType == "" {
+			continue
+		}
+		fmt.Fprintf(&out, "\tcase protocol.%s:\n\t\tif e.%s != nil {\n\t\t\tbody, err := toJSON[protocol.%s](e.%s)\n\t\t\treturn body, true, err\n\t\t}\n",
+			envTypeConstants[f.envType], f.goName, f.value.message, f.goName)
+	}
+	out.WriteString("\t}\n\treturn nil, false, nil\n}\n")
+
+	formatted, err := format.Source(out.Bytes())
+	if err != nil {
+		return nil, fmt.Errorf("generated Go does not parse: %w", err)
+	}
+	return formatted, nil
+}
+
+// goValueType returns the Go type of one value
+func goValueType(v valueType) string {
+	switch {
+	case v.json:
+		return "json.RawMessage"
+	case v.message != "":
+		return "*" + v.message
+	}
+	return scalarGoTypes[v.scalar]
+}
+
+func goFieldType(f *field) string {
+	value := goValueType(f.value)
+	switch f.label {
+	case optional:
+		return "*" + value
+	case repeated:
+		return "[]" + value
+	case mapped:
+		return fmt.Sprintf("map[%s]%s", scalarGoTypes[f.key], value)
+	}
+	return value
+}
+
+// packable reports whether repeated values of v are packed
+func packable(v valueType) bool {
+	return v.message == "" && !v.json && v.scalar != "string" && v.scalar != "bytes"
+}
+
+// encodeValue returns the statement appending field num holding expr to buf
+func encodeValue(buf string, num int, v valueType, expr string) string {
+	var call string
+	switch {
+	case v.json || v.scalar == "bytes":
+		call = fmt.Sprintf("appendBytes(%s, %d, %s)", buf, num, expr)
+	case v.message != "":
+		call = fmt.Sprintf("appendBytes(%s, %d, %s.MarshalProto())", buf, num, expr)
+	case v.scalar == "string":
+		call = fmt.Sprintf("appendString(%s, %d, %s)", buf, num, expr)
+	case v.scalar == "double":
+		call = fmt.Sprintf("appendFixed64(%s, %d, math.Float64bits(%s))", buf, num, expr)
+	case v.scalar == "float":
+		call = fmt.Sprintf("appendFixed32(%s, %d, math.Float32bits(%s))", buf, num, expr)
+	default:
+		call = fmt.Sprintf("appendUvarint(%s, %d, %s)", buf, num, varintOf(v, expr))
+	}
+	return fmt.Sprintf("%s = %s", buf, call)
+}
+
+// varintOf converts expr of a varint scalar type to uint64
+func varintOf(v valueType, expr string) string {
+	switch v.scalar {
+	case "bool":
+		return fmt.Sprintf("boolVarint(%s)", expr)
+	case "uint64":
+		return expr
+	}
+	return fmt.Sprintf("uint64(%s)", expr)
+}
+
+// nonZero returns the condition under which a single field is encoded
+func nonZero(v valueType, expr string) string {
+	switch {
+	case v.json || v.scalar == "bytes":
+		return fmt.Sprintf("len(%s) > 0", expr)
+	case v.message != "":
+		return expr + " != nil"
+	case v.scalar == "string":
+		return expr + ` != ""`
+	case v.scalar == "bool":
+		return expr
+	}
+	return expr + " != 0"
+}
+
+func writeMarshal(out *bytes.Buffer, m *message) {
+	fmt.Fprintf(out, "\n// MarshalProto returns the protobuf encoding of the message\nfunc (m *%s) MarshalProto() []byte {\n\tif m == nil {\n\t\treturn nil\n\t}\n\tvar b []byte\n", m.name)
+	for _, f := range m.fields {
+		expr := "m." + f.goName
+		switch f.label {
+		case single:
+			fmt.Fprintf(out, "\tif %s {\n\t\t%s\n\t}\n", nonZero(f.value, expr), encodeValue("b", f.number, f.value, expr))
+		case optional:
+			fmt.Fprintf(out, "\tif %s != nil {\n\t\t%s\n\t}\n", expr, encodeValue("b", f.number, f.value, "*"+expr))
+		case repeated:
+			if !packable(f.value) {
+				fmt.Fprintf(out, "\tfor _, v := range %s {\n\t\t%s\n\t}\n", expr, encodeValue("b", f.number, f.value, "v"))
+				continue
+			}
+			var element string
+			switch f.value.scalar {
+			case "double":
+				element = "packed = appendLittleEndian(packed, math.Float64bits(v), 8)"
+			case "float":
+				element = "packed = appendLittleEndian(packed, uint64(math.Float32bits(v)), 4)"
+			default:
+				element = fmt.Sprintf("packed = appendVarint(packed, %s)", varintOf(f.value, "v"))
+			}
+			fmt.Fprintf(out, "\tif len(%s) > 0 {\n\t\tvar packed []byte\n\t\tfor _, v := range %s {\n\t\t\t%s\n\t\t}\n\t\tb = appendBytes(b, %d, packed)\n\t}\n",
+				expr, expr, element, f.number)
+		case mapped:
+			key := valueType{scalar: f.key}
+			fmt.Fprintf(out, "\tfor _, k := range sortedKeys(%s) {\n\t\tvar entry []byte\n\t\t%s\n\t\t%s\n\t\tb = appendBytes(b, %d, entry)\n\t}\n",
+				expr, encodeValue("entry", 1, key, "k"), encodeValue("entry", 2, f.value, expr+"[k]"), f.number)
+		}
+	}
+	out.WriteString("\treturn b\n}\n")
+}
+
+// decodeValue returns the expression reading a scalar of type v from d
+func decodeValue(d string, v valueType) string {
+	switch {
+	case v.json || v.scalar == "bytes":
+		return d + ".bytes(typ)"
+	case v.scalar == "string":
+		return d + ".string(typ)"
+	case v.scalar == "double":
+		return fmt.Sprintf("math.Float64frombits(%s.fixed64(typ))", d)
+	case v.scalar == "float":
+		return fmt.Sprintf("math.Float32frombits(%s.fixed32(typ))", d)
+	}
+	return fromVarint(v, d+".varint(typ)")
+}
+
+// fromVarint converts a uint64 varint expr to the Go type of v
+func fromVarint(v valueType, expr string) string {
+	switch v.scalar {
+	case "bool":
+		return expr + " != 0"
+	case "uint64":
+		return expr
+	}
+	return fmt.Sprintf("%s(%s)", scalarGoTypes[v.scalar], expr)
+}
+
+func writeUnmarshal(out *bytes.Buffer, m *message) {
+	fmt.Fprintf(out, "\n// UnmarshalProto decodes the protobuf encoding of the message into m.\n// Fields m does not declare are skipped.\nfunc (m *%s) UnmarshalProto(data []byte) error {\n\td := decoder{b: data}\n\tfor num, typ, ok := d.next(); ok; num, typ, ok = d.next() {\n\t\tswitch num {\n", m.name)
+	for _, f := range m.fields {
+		expr := "m." + f.goName
+		fmt.Fprintf(out, "\t\tcase %d:\n", f.number)
+		switch {
+		case f.label == single && f.value.message != "":
+			fmt.Fprintf(out, "\t\t\tif %s == nil {\n\t\t\t\t%s = new(%s)\n\t\t\t}\n\t\t\td.message(typ, %s)\n", expr, expr, f.value.message, expr)
+		case f.label == single:
+			fmt.Fprintf(out, "\t\t\t%s = %s\n", expr, decodeValue("d", f.value))
+		case f.label == optional:
+			fmt.Fprintf(out, "\t\t\tv := %s\n\t\t\t%s = &v\n", decodeValue("d", f.value), expr)
+		case f.label == repeated && f.value.message != "":
+			fmt.Fprintf(out, "\t\t\tv := new(%s)\n\t\t\td.message(typ, v)\n\t\t\t%s = append(%s, v)\n", f.value.message, expr, expr)
+		case f.label == repeated && !packable(f.value):
+			fmt.Fprintf(out, "\t\t\t%s = append(%s, %s)\n", expr, expr, decodeValue("d", f.value))
+		case f.label == repeated && f.value.scalar == "double":
+			fmt.Fprintf(out, "\t\t\td.fixeds(typ, 8, func(v uint64) { %s = append(%s, math.Float64frombits(v)) })\n", expr, expr)
+		case f.label == repeated && f.value.scalar == "float":
+			fmt.Fprintf(out, "\t\t\td.fixeds(typ, 4, func(v uint64) { %s = append(%s, math.Float32frombits(uint32(v))) })\n", expr, expr)
+		case f.label == repeated:
+			fmt.Fprintf(out, "\t\t\td.varints(typ, func(v uint64) { %s = append(%s, %s) })\n", expr, expr, fromVarint(f.value, "v"))
+		case f.label == mapped:
+			key := valueType{scalar: f.key}
+			fmt.Fprintf(out, "\t\t\tvar key %s\n\t\t\tvar value %s\n", scalarGoTypes[f.key], goValueType(f.value))
+			fmt.Fprintf(out, "\t\t\td.entry(typ, func(e *decoder, num int, typ wireType) {\n\t\t\t\tswitch num {\n\t\t\t\tcase 1:\n\t\t\t\t\tkey = %s\n\t\t\t\tcase 2:\n", decodeValue("e", key))
+			if f.value.message != "" {
+				fmt.Fprintf(out, "\t\t\t\t\tvalue = new(%s)\n\t\t\t\t\te.message(typ, value)\n", f.value.message)
+			} else {
+				fmt.Fprintf(out, "\t\t\t\t\tvalue = %s\n", decodeValue("e", f.value))
+			}
+			out.WriteString("\t\t\t\tdefault:\n\t\t\t\t\te.skip(typ)\n\t\t\t\t}\n\t\t\t})\n")
+			if f.value.message != "" {
+				fmt.Fprintf(out, "\t\t\tif value == nil {\n\t\t\t\tvalue = new(%s)\n\t\t\t}\n", f.value.message)
+			}
+			fmt.Fprintf(out, "\t\t\tif %s == nil {\n\t\t\t\t%s = make(%s)\n\t\t\t}\n\t\t\t%s[key] = value\n", expr, expr, goFieldType(f), expr)
+		}
+	}
+	out.WriteString("\t\tdefault:\n\t\t\td.skip(typ)\n\t\t}\n\t}\n\treturn d.err\n}\n")
+}
@@ -0,0 +1,46 @@
+// fem-protogen generates the protobuf definition of the FEP envelope and body
+// types from the Go protocol package, along with the Go package fempb that
+// encodes them, so gRPC integrations and agents in other languages can speak
+// FEP without hand-maintaining their own structs. Field numbers published in
+// the existing .proto file are kept across regenerations.
+package main
+
+import (
+	"errors"
+	"flag"
+	"io/fs"
+	"log"
+	"os"
+
+	"github.com/fep-fem/protocol/cmd/internal/gosource"
+)
+
+func main() {
+	src := flag.String("src", ".", "Directory of the Go protocol package")
+	protoOut := flag.String("proto", "../proto/fem.proto", "Protobuf definition to write")
+	goOut := flag.String("go", "fempb/fem.pb.go", "Go file to write")
+	flag.Parse()
+
+	parsed, err := gosource.Parse(*src)
+	if err != nil {
+		log.Fatalf("Failed to read protocol package: %v", err)
+	}
+	previous, err := os.ReadFile(*protoOut)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		log.Fatalf("Failed to read published field numbers: %v", err)
+	}
+	s, err := buildSchema(parsed, previous)
+	if err != nil {
+		log.Fatalf("Failed to map protocol types to protobuf: %v", err)
+	}
+	generated, err := generateGo(s)
+	if err != nil {
+		log.Fatalf("Failed to generate Go: %v", err)
+	}
+	if err := os.WriteFile(*protoOut, generateProto(s), 0644); err != nil {
+		log.Fatalf("Failed to write %s: %v", *protoOut, err)
+	}
+	if err := os.WriteFile(*goOut, generated, 0644); err != nil {
+		log.Fatalf("Failed to write %s: %v", *goOut, err)
+	}
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// protoPackage is the package of the generated .proto file
+const protoPackage = "fem.v1"
+
+// goPackage is the import path of the generated Go package
+const goPackage = "github.com/fep-fem/protocol/fempb"
+
+// generateProto returns the .proto definition of the schema
+func generateProto(s *schema) []byte {
+	var out bytes.Buffer
+	writeComment(&out, "", header)
+	fmt.Fprintf(&out, "\nsyntax = \"proto3\";\n\npackage %s;\n\noption go_package = %q;\n", protoPackage, goPackage)
+
+	for _, m := range append([]*message{s.envelope}, s.messages...) {
+		out.WriteString("\n")
+		writeComment(&out, "", m.doc)
+		fmt.Fprintf(&out, "message %s {\n", m.name)
+		if len(m.reserved) > 0 {
+			numbers := make([]string, len(m.reserved))
+			for i, number := range m.reserved {
+				numbers[i] = strconv.Itoa(number)
+			}
+			fmt.Fprintf(&out, "  reserved %s;\n", strings.Join(numbers, ", "))
+		}
+
+		inOneof := false
+		for _, f := range m.fields {
+			oneof := m == s.envelope && (f.envType != "" || f.name == "json_body")
+			indent := "  "
+			if oneof && !inOneof {
+				out.WriteString("  oneof body {\n")
+			} else if !oneof && inOneof {
+				out.WriteString("  }\n")
+			}
+			inOneof = oneof
+			if oneof {
+				indent = "    "
+			}
+			writeComment(&out, indent, f.doc)
+			fmt.Fprintf(&out, "%s%s;", indent, protoField(f))
+			if f.value.json {
+				out.WriteString(" // JSON")
+			}
+			out.WriteString("\n")
+		}
+		if inOneof {
+			out.WriteString("  }\n")
+		}
+		out.WriteString("}\n")
+	}
+	out.WriteString(service)
+	return out.Bytes()
+}
+
+// service declares the gRPC service brokers serve, which fempb implements
+const service = `
+// Broker is served by FEP brokers over HTTP/2 next to the JSON API. Envelopes
+// are handled as if posted, and answered with the envelope the JSON API would
+// respond with; error envelopes report protocol failures.
+service Broker {
+  // SubmitEnvelope sends one envelope and returns the broker's answer
+  rpc SubmitEnvelope(Envelope) returns (Envelope);
+  // StreamEnvelopes answers every envelope sent, in order. A stream opened
+  // with a heartbeat also carries the tool calls pushed to the agent, whose
+  // results it sends back on the stream.
+  rpc StreamEnvelopes(stream Envelope) returns (stream Envelope);
+}
+`
+
+// protoField returns the declaration of a field, without its semicolon
+func protoField(f *field) string {
+	typ := protoType(f.value)
+	switch f.label {
+	case optional:
+		typ = "optional " + typ
+	case repeated:
+		typ = "repeated " + typ
+	case mapped:
+		typ = fmt.Sprintf("map<%s, %s>", f.key, typ)
+	}
+	decl := fmt.Sprintf("%s %s = %d", typ, f.name, f.number)
+	if protoJSONName(f.name) != f.jsonName {
+		decl += fmt.Sprintf(" [json_name = %q]", f.jsonName)
+	}
+	return decl
+}
+
+func protoType(v valueType) string {
+	switch {
+	case v.json:
+		return "bytes"
+	case v.message != "":
+		return v.message
+	}
+	return v.scalar
+}
+
+// writeComment writes text as // comment lines
+func writeComment(out *bytes.Buffer, indent, text string) {
+	if text == "" {
+		return
+	}
+	for _, line := range strings.Split(text, "\n") {
+		fmt.Fprintf(out, "%s%s\n", indent, strings.TrimRight("// "+line, " "))
+	}
+}
@@ -0,0 +1,292 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"go/ast"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/fep-fem/protocol/cmd/internal/gosource"
+)
+
+// header starts the generated file
+const header = `// Code generated by fem-tsgen from the Go protocol package. DO NOT EDIT.
+// Run "make typescript" after changing envelope or body types.
+
+`
+
+// extraRoots are types carried inside ack or tool results rather than as envelope bodies
+var extraRoots = []string{"ResultDeliveryBody", "BatchResultBody", "DrainStatusBody", "ResumeBody", "CompressionPolicy", "DiffResult", "PendingApprovalBody"}
+
+// specialTypes map protocol types with custom JSON encodings to TypeScript
+var specialTypes = map[string]string{
+	"Duration": "string", // Go duration string, e.g. "1.5s"
+	"Time":     "string", // RFC 3339 with milliseconds, or null for the zero time
+}
+
+// generator emits TypeScript for the types reachable from the envelope bodies
+type generator struct {
+	src     *gosource.Package
+	emitted map[string]bool
+	queue   []string
+	out     bytes.Buffer
+	err     error
+}
+
+// generate returns the TypeScript declarations of the protocol types
+func generate(src *gosource.Package) ([]byte, error) {
+	g := &generator{src: src, emitted: make(map[string]bool)}
+	g.out.WriteString(header)
+
+	for _, name := range []string{"ProtocolVersion", "CanonicalSigningVersion"} {
+		if value, exists := src.Version[name]; exists {
+			fmt.Fprintf(&g.out, "export const %s = %q;\n", name, value)
+		}
+	}
+	g.out.WriteString("\n")
+
+	envTypes := make([]string, 0, len(src.Bodies))
+	for envType := range src.Bodies {
+		envTypes = append(envTypes, envType)
+	}
+	sort.Strings(envTypes)
+
+	g.require("CommonHeaders")
+	g.require("EnvelopeType")
+	for _, envType := range envTypes {
+		g.require(src.Bodies[envType])
+	}
+	for _, name := range extraRoots {
+		g.require(name)
+	}
+	for len(g.queue) > 0 {
+		name := g.queue[0]
+		g.queue = g.queue[1:]
+		g.emit(name)
+	}
+
+	g.out.WriteString("/** Body type carried by each envelope type */\nexport interface EnvelopeBodies {\n")
+	for _, envType := range envTypes {
+		fmt.Fprintf(&g.out, "  %s: %s;\n", envType, src.Bodies[envType])
+	}
+	g.out.WriteString(`}
+
+/** An envelope whose body type follows from its type */
+export type TypedEnvelope<T extends keyof EnvelopeBodies> = CommonHeaders & {
+  type: T;
+  body: EnvelopeBodies[T];
+};
+
+/** Any envelope with a known body type */
+export type AnyEnvelope = { [T in keyof EnvelopeBodies]: TypedEnvelope<T> }[keyof EnvelopeBodies];
+
+/** An envelope of any type, e.g. one not yet checked */
+export type Envelope = CommonHeaders & {
+  type: EnvelopeType;
+  body: unknown;
+};
+`)
+	return g.out.Bytes(), g.err
+}
+
+// require queues a named type for emission
+func (g *generator) require(name string) {
+	if g.emitted[name] {
+		return
+	}
+	g.emitted[name] = true
+	g.queue = append(g.queue, name)
+}
+
+func (g *generator) fail(format string, args ...interface{}) {
+	if g.err == nil {
+		g.err = fmt.Errorf(format, args...)
+	}
+}
+
+// emit writes the declaration of a named type
+func (g *generator) emit(name string) {
+	spec, exists := g.src.Types[name]
+	if !exists {
+		g.fail("type %s is not declared in the protocol package", name)
+		return
+	}
+	writeDoc(&g.out, "", g.src.Docs[name])
+
+	switch typ := spec.Type.(type) {
+	case *ast.StructType:
+		var extends []string
+		for _, field := range typ.Fields.List {
+			if len(field.Names) == 0 {
+				if ident, ok := field.Type.(*ast.Ident); ok {
+					extends = append(extends, ident.Name)
+					g.require(ident.Name)
+				}
+			}
+		}
+		fmt.Fprintf(&g.out, "export interface %s", name)
+		if len(extends) > 0 {
+			fmt.Fprintf(&g.out, " extends %s", strings.Join(extends, ", "))
+		}
+		g.out.WriteString(" {\n")
+		g.fields(&g.out, typ, "  ")
+		g.out.WriteString("}\n\n")
+	case *ast.Ident:
+		constants := g.src.Constants[name]
+		if typ.Name != "string" || len(constants) == 0 {
+			fmt.Fprintf(&g.out, "export type %s = %s;\n\n", name, g.typeOf(typ))
+			return
+		}
+		values := make([]string, len(constants))
+		for i, c := range constants {
+			values[i] = strconv.Quote(c.Value)
+		}
+		fmt.Fprintf(&g.out, "export type %s =\n  | %s;\n\n", name, strings.Join(values, "\n  | "))
+		for _, c := range constants {
+			writeDoc(&g.out, "", c.Doc)
+			fmt.Fprintf(&g.out, "export const %s = %q;\n", c.Name, c.Value)
+		}
+		g.out.WriteString("\n")
+	default:
+		g.fail("type %s has no TypeScript mapping", name)
+	}
+}
+
+// fields writes the JSON members of a struct
+func (g *generator) fields(out *bytes.Buffer, typ *ast.StructType, indent string) {
+	for _, field := range typ.Fields.List {
+		if len(field.Names) == 0 {
+			continue // Embedded, emitted as extends
+		}
+		tag := ""
+		if field.Tag != nil {
+			unquoted, _ := strconv.Unquote(field.Tag.Value)
+			tag = reflect.StructTag(unquoted).Get("json")
+		}
+		if tag == "-" {
+			continue
+		}
+		jsonName, options, _ := strings.Cut(tag, ",")
+		optional := strings.Contains(","+options+",", ",omitempty,")
+		for _, ident := range field.Names {
+			if !ident.IsExported() {
+				continue
+			}
+			name := jsonName
+			if name == "" {
+				name = ident.Name
+			}
+			doc := field.Doc
+			if doc == nil {
+				doc = field.Comment
+			}
+			writeDoc(out, indent, doc)
+			tsType := g.typeOf(field.Type)
+			if !optional && tsType != "unknown" && nullable(field.Type) {
+				tsType += " | null"
+			}
+			marker := ""
+			if optional {
+				marker = "?"
+			}
+			fmt.Fprintf(out, "%s%s%s: %s;\n", indent, name, marker, tsType)
+		}
+	}
+}
+
+// nullable reports whether Go encodes a zero value of expr as null
+func nullable(expr ast.Expr) bool {
+	switch expr := expr.(type) {
+	case *ast.StarExpr, *ast.MapType, *ast.InterfaceType:
+		return true
+	case *ast.ArrayType:
+		if ident, ok := expr.Elt.(*ast.Ident); ok && ident.Name == "byte" {
+			return false
+		}
+		return expr.Len == nil
+	case *ast.Ident:
+		return expr.Name == "Time"
+	case *ast.SelectorExpr:
+		return expr.Sel.Name == "RawMessage"
+	}
+	return false
+}
+
+// typeOf maps a Go type expression to TypeScript
+func (g *generator) typeOf(expr ast.Expr) string {
+	switch expr := expr.(type) {
+	case *ast.Ident:
+		switch expr.Name {
+		case "string":
+			return "string"
+		case "bool":
+			return "boolean"
+		case "int", "int8", "int16", "int32", "int64", "uint", "uint8", "uint16", "uint32", "uint64", "float32", "float64":
+			return "number"
+		case "any":
+			return "unknown"
+		}
+		if mapped, exists := specialTypes[expr.Name]; exists {
+			return mapped
+		}
+		g.require(expr.Name)
+		return expr.Name
+	case *ast.StarExpr:
+		return g.typeOf(expr.X)
+	case *ast.ArrayType:
+		if ident, ok := expr.Elt.(*ast.Ident); ok && ident.Name == "byte" {
+			return "string" // Base64
+		}
+		element := g.typeOf(expr.Elt)
+		if strings.ContainsAny(element, " |") {
+			element = "(" + element + ")"
+		}
+		return element + "[]"
+	case *ast.MapType:
+		return fmt.Sprintf("Record<%s, %s>", g.typeOf(expr.Key), g.typeOf(expr.Value))
+	case *ast.InterfaceType:
+		return "unknown"
+	case *ast.SelectorExpr:
+		switch fmt.Sprintf("%s.%s", expr.X, expr.Sel) {
+		case "json.RawMessage":
+			return "unknown"
+		case "time.Time":
+			return "string"
+		case "time.Duration":
+			return "number"
+		}
+		g.fail("no TypeScript mapping for %s.%s", expr.X, expr.Sel)
+	case *ast.StructType:
+		var inline bytes.Buffer
+		g.fields(&inline, expr, "    ")
+		return "{\n" + inline.String() + "  }"
+	default:
+		g.fail("no TypeScript mapping for %T", expr)
+	}
+	return "unknown"
+}
+
+// writeDoc writes a Go comment as a JSDoc comment
+func writeDoc(out *bytes.Buffer, indent string, doc *ast.CommentGroup) {
+	if doc == nil {
+		return
+	}
+	text := strings.TrimSpace(doc.Text())
+	if text == "" {
+		return
+	}
+	text = strings.ReplaceAll(text, "*/", "*\\/")
+	lines := strings.Split(text, "\n")
+	if len(lines) == 1 {
+		fmt.Fprintf(out, "%s/** %s */\n", indent, lines[0])
+		return
+	}
+	fmt.Fprintf(out, "%s/**\n", indent)
+	for _, line := range lines {
+		fmt.Fprintf(out, "%s *%s\n", indent, strings.TrimRight(" "+line, " "))
+	}
+	fmt.Fprintf(out, "%s */\n", indent)
+}
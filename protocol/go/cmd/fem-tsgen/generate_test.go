@@ -0,0 +1,66 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/fep-fem/protocol/cmd/internal/gosource"
+)
+
+var update = flag.Bool("update", false, "Regenerate the shipped TypeScript types")
+
+// shippedTypes is the generated file published in the TypeScript package
+const shippedTypes = "../../../typescript/src/types.ts"
+
+func TestShippedTypesAreCurrent(t *testing.T) {
+	parsed, err := gosource.Parse("../..")
+	if err != nil {
+		t.Fatalf("Failed to read protocol package: %v", err)
+	}
+	generated, err := generate(parsed)
+	if err != nil {
+		t.Fatalf("Failed to generate TypeScript: %v", err)
+	}
+	if *update {
+		if err := os.WriteFile(shippedTypes, generated, 0644); err != nil {
+			t.Fatalf("Failed to write types: %v", err)
+		}
+	}
+
+	shipped, err := os.ReadFile(shippedTypes)
+	if err != nil {
+		t.Fatalf("Failed to read shipped types: %v", err)
+	}
+	if !bytes.Equal(shipped, generated) {
+		t.Errorf("types.ts differs from the generated types; run go test ./cmd/fem-tsgen -update if the change is intended")
+	}
+}
+
+func TestGenerateCoversEnvelopeBodies(t *testing.T) {
+	parsed, err := gosource.Parse("../..")
+	if err != nil {
+		t.Fatalf("Failed to read protocol package: %v", err)
+	}
+	generated, err := generate(parsed)
+	if err != nil {
+		t.Fatalf("Failed to generate TypeScript: %v", err)
+	}
+
+	ts := string(generated)
+	for _, want := range []string{
+		"  toolCall: ToolCallBody;\n",
+		"export interface ToolCallBody {\n",
+		// omitempty fields are optional; nil slices and maps encode as null
+		"  capability?: string;\n",
+		"  capabilities: string[] | null;\n",
+		"export const CodeRateLimited = \"rate_limited\";\n",
+		"export interface BatchResultBody {\n",
+	} {
+		if !strings.Contains(ts, want) {
+			t.Errorf("Generated TypeScript lacks %q", want)
+		}
+	}
+}
@@ -0,0 +1,30 @@
+// fem-tsgen generates the TypeScript declarations of the FEM envelope and
+// body types from the Go protocol package, so the TypeScript bindings in
+// protocol/typescript follow every change to the Go types.
+package main
+
+import (
+	"flag"
+	"log"
+	"os"
+
+	"github.com/fep-fem/protocol/cmd/internal/gosource"
+)
+
+func main() {
+	src := flag.String("src", ".", "Directory of the Go protocol package")
+	out := flag.String("out", "../typescript/src/types.ts", "TypeScript file to write")
+	flag.Parse()
+
+	parsed, err := gosource.Parse(*src)
+	if err != nil {
+		log.Fatalf("Failed to read protocol package: %v", err)
+	}
+	generated, err := generate(parsed)
+	if err != nil {
+		log.Fatalf("Failed to generate TypeScript: %v", err)
+	}
+	if err := os.WriteFile(*out, generated, 0644); err != nil {
+		log.Fatalf("Failed to write %s: %v", *out, err)
+	}
+}
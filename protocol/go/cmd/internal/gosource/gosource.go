@@ -0,0 +1,133 @@
+// Package gosource reads the envelope and body types of the Go protocol
+// package for the generators deriving other bindings from them.
+package gosource
+
+import (
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// Package is the parsed protocol package
+type Package struct {
+	Types     map[string]*ast.TypeSpec
+	Docs      map[string]*ast.CommentGroup // Doc comments of types
+	Constants map[string][]Constant        // String constants by their named type
+	Bodies    map[string]string            // Envelope type value to body type name
+	Version   map[string]string            // Version constants
+}
+
+// Constant is a typed string constant, such as an envelope type or error code
+type Constant struct {
+	Name, Value string
+	Doc         *ast.CommentGroup
+}
+
+// Parse reads the non-test Go files of the protocol package in dir
+func Parse(dir string) (*Package, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	fset := token.NewFileSet()
+	var files []*ast.File
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || !strings.HasSuffix(name, ".go") || strings.HasSuffix(name, "_test.go") {
+			continue
+		}
+		file, err := parser.ParseFile(fset, filepath.Join(dir, name), nil, parser.ParseComments)
+		if err != nil {
+			return nil, err
+		}
+		if file.Name.Name == "protocol" {
+			files = append(files, file)
+		}
+	}
+	if len(files) == 0 {
+		return nil, fmt.Errorf("no protocol package in %s", dir)
+	}
+
+	src := &Package{
+		Types:     make(map[string]*ast.TypeSpec),
+		Docs:      make(map[string]*ast.CommentGroup),
+		Constants: make(map[string][]Constant),
+		Bodies:    make(map[string]string),
+		Version:   make(map[string]string),
+	}
+	var envelopeMethods []*ast.FuncDecl
+	for _, file := range files {
+		for _, decl := range file.Decls {
+			switch decl := decl.(type) {
+			case *ast.GenDecl:
+				src.collect(decl)
+			case *ast.FuncDecl:
+				if decl.Recv != nil && decl.Name.Name == "EnvelopeType" {
+					envelopeMethods = append(envelopeMethods, decl)
+				}
+			}
+		}
+	}
+
+	// Each body's EnvelopeType method returns the constant naming its envelope type
+	values := make(map[string]string)
+	for _, c := range src.Constants["EnvelopeType"] {
+		values[c.Name] = c.Value
+	}
+	for _, method := range envelopeMethods {
+		receiver, ok := method.Recv.List[0].Type.(*ast.Ident)
+		if !ok || len(method.Body.List) != 1 {
+			continue
+		}
+		ret, ok := method.Body.List[0].(*ast.ReturnStmt)
+		if !ok || len(ret.Results) != 1 {
+			continue
+		}
+		if ident, ok := ret.Results[0].(*ast.Ident); ok && values[ident.Name] != "" {
+			src.Bodies[values[ident.Name]] = receiver.Name
+		}
+	}
+	if len(src.Bodies) == 0 {
+		return nil, fmt.Errorf("no envelope body types found in %s", dir)
+	}
+	return src, nil
+}
+
+// collect records the type and constant declarations of decl
+func (s *Package) collect(decl *ast.GenDecl) {
+	for _, spec := range decl.Specs {
+		switch spec := spec.(type) {
+		case *ast.TypeSpec:
+			s.Types[spec.Name.Name] = spec
+			if spec.Doc != nil {
+				s.Docs[spec.Name.Name] = spec.Doc
+			} else if len(decl.Specs) == 1 {
+				s.Docs[spec.Name.Name] = decl.Doc
+			}
+		case *ast.ValueSpec:
+			if decl.Tok != token.CONST || len(spec.Names) != 1 || len(spec.Values) != 1 {
+				continue
+			}
+			literal, ok := spec.Values[0].(*ast.BasicLit)
+			if !ok || literal.Kind != token.STRING {
+				continue
+			}
+			value, _ := strconv.Unquote(literal.Value)
+			name := spec.Names[0].Name
+			if typeName, ok := spec.Type.(*ast.Ident); ok {
+				doc := spec.Doc
+				if doc == nil {
+					doc = spec.Comment
+				}
+				s.Constants[typeName.Name] = append(s.Constants[typeName.Name], Constant{Name: name, Value: value, Doc: doc})
+			} else if spec.Type == nil && strings.HasSuffix(name, "Version") {
+				s.Version[name] = value
+			}
+		}
+	}
+}
@@ -0,0 +1,263 @@
+package protocol
+
+import (
+	"crypto/tls"
+	"io"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// PoolConfig bounds the connections Transport.Send keeps to each endpoint
+type PoolConfig struct {
+	MaxIdlePerEndpoint  int           // Idle connections kept per endpoint; 0 keeps none
+	MaxConnsPerEndpoint int           // Connections open at once per endpoint, busy or idle; 0 is unlimited
+	IdleTimeout         time.Duration // Idle connections are closed after this long; 0 never closes them
+	DialTimeout         time.Duration // Limit on connecting and the TLS handshake; 0 is no limit
+}
+
+// DefaultPoolConfig returns the pool settings a new Transport uses
+func DefaultPoolConfig() PoolConfig {
+	return PoolConfig{
+		MaxIdlePerEndpoint: 2,
+		IdleTimeout:        90 * time.Second,
+		DialTimeout:        10 * time.Second,
+	}
+}
+
+// connPool keeps TLS connections to endpoints open between sends
+type connPool struct {
+	config PoolConfig
+	proxy  ProxyFunc // Picks the proxy new connections go through; the environment's if nil
+	idle   map[string][]*pooledConn
+	open   map[string]int // Busy and idle connections per endpoint
+	timer  *time.Timer    // Closes expired idle connections while there are any
+	mu     sync.Mutex
+	freed  *sync.Cond // Signalled when a connection is returned or closed
+}
+
+// pooledConn is a connection and when it was last returned to the pool
+type pooledConn struct {
+	net.Conn
+	framing   Framing // Negotiated when the connection was opened
+	idleSince time.Time
+	broken    atomic.Bool // Set once reading from the connection failed
+}
+
+func newConnPool(config PoolConfig) *connPool {
+	p := &connPool{
+		config: config,
+		idle:   make(map[string][]*pooledConn),
+		open:   make(map[string]int),
+	}
+	p.freed = sync.NewCond(&p.mu)
+	return p
+}
+
+// setConfig replaces the pool settings, closing idle connections over the
+// new idle limit
+func (p *connPool) setConfig(config PoolConfig) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.config = config
+	for endpoint, conns := range p.idle {
+		keep := max(config.MaxIdlePerEndpoint, 0)
+		for len(conns) > keep {
+			conns[0].Close()
+			p.forgetLocked(endpoint)
+			conns = conns[1:]
+		}
+		if len(conns) == 0 {
+			delete(p.idle, endpoint)
+		} else {
+			p.idle[endpoint] = conns
+		}
+	}
+	p.freed.Broadcast()
+}
+
+// setProxy changes the proxy new connections go through
+func (p *connPool) setProxy(proxy ProxyFunc) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.proxy = proxy
+}
+
+// send writes an envelope's JSON on a pooled connection to endpoint, framed
+// as negotiated on that connection. A reused connection that fails is
+// dropped and the envelope is sent once more on a new connection.
+func (p *connPool) send(endpoint string, data []byte) error {
+	for {
+		conn, reused, err := p.get(endpoint)
+		if err != nil {
+			return err
+		}
+		frame, err := conn.framing.encode(data)
+		if err != nil {
+			p.put(endpoint, conn)
+			return err
+		}
+		if _, err := conn.Write(frame); err != nil {
+			p.discard(endpoint, conn)
+			if reused {
+				continue
+			}
+			return err
+		}
+		p.put(endpoint, conn)
+		return nil
+	}
+}
+
+// get returns an idle connection to endpoint, or dials a new one once
+// the endpoint is below its connection limit
+func (p *connPool) get(endpoint string) (*pooledConn, bool, error) {
+	p.mu.Lock()
+	for {
+		if conn := p.popIdleLocked(endpoint); conn != nil {
+			p.mu.Unlock()
+			return conn, true, nil
+		}
+		if p.config.MaxConnsPerEndpoint <= 0 || p.open[endpoint] < p.config.MaxConnsPerEndpoint {
+			break
+		}
+		p.freed.Wait()
+	}
+	p.open[endpoint]++
+	dialTimeout := p.config.DialTimeout
+	proxy := p.proxy
+	p.mu.Unlock()
+
+	conn, err := dialStream(proxy, endpoint, dialTimeout, &tls.Config{
+		InsecureSkipVerify: true, // In production, verify certificates
+		MinVersion:         tls.VersionTLS13,
+		NextProtos:         StreamProtocols(),
+	})
+	if err != nil {
+		p.release(endpoint)
+		return nil, false, err
+	}
+	pooled := &pooledConn{Conn: conn, framing: negotiatedFraming(conn.ConnectionState())}
+	go pooled.watch()
+	return pooled, false, nil
+}
+
+// popIdleLocked takes the most recently used idle connection to endpoint,
+// closing any that have been idle too long or were closed by the peer
+func (p *connPool) popIdleLocked(endpoint string) *pooledConn {
+	conns := p.idle[endpoint]
+	for len(conns) > 0 {
+		conn := conns[len(conns)-1]
+		conns = conns[:len(conns)-1]
+		if !conn.broken.Load() && !p.expiredLocked(conn, time.Now()) {
+			p.idle[endpoint] = conns
+			return conn
+		}
+		conn.Close()
+		p.forgetLocked(endpoint)
+	}
+	delete(p.idle, endpoint)
+	return nil
+}
+
+// put returns a connection to the idle list, or closes it if the list is full
+func (p *connPool) put(endpoint string, conn *pooledConn) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if len(p.idle[endpoint]) >= p.config.MaxIdlePerEndpoint {
+		conn.Close()
+		p.forgetLocked(endpoint)
+		return
+	}
+	conn.idleSince = time.Now()
+	p.idle[endpoint] = append(p.idle[endpoint], conn)
+	p.freed.Broadcast()
+	if p.timer == nil && p.config.IdleTimeout > 0 {
+		p.timer = time.AfterFunc(p.config.IdleTimeout, p.closeExpired)
+	}
+}
+
+// discard closes a connection that is broken or unwanted
+func (p *connPool) discard(endpoint string, conn *pooledConn) {
+	conn.Close()
+	p.release(endpoint)
+}
+
+// release frees the slot of a connection that was closed or never opened
+func (p *connPool) release(endpoint string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.forgetLocked(endpoint)
+}
+
+// forgetLocked stops counting a closed connection against endpoint's limit
+func (p *connPool) forgetLocked(endpoint string) {
+	p.open[endpoint]--
+	if p.open[endpoint] <= 0 {
+		delete(p.open, endpoint)
+	}
+	p.freed.Broadcast()
+}
+
+// expiredLocked reports whether conn has been idle longer than the idle timeout
+func (p *connPool) expiredLocked(conn *pooledConn, now time.Time) bool {
+	return p.config.IdleTimeout > 0 && now.Sub(conn.idleSince) >= p.config.IdleTimeout
+}
+
+// closeExpired closes idle connections past the idle timeout, and runs again
+// when the oldest remaining one expires
+func (p *connPool) closeExpired() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	now := time.Now()
+	var oldest time.Time
+	for endpoint, conns := range p.idle {
+		kept := conns[:0]
+		for _, conn := range conns {
+			if conn.broken.Load() || p.expiredLocked(conn, now) {
+				conn.Close()
+				p.forgetLocked(endpoint)
+				continue
+			}
+			if oldest.IsZero() || conn.idleSince.Before(oldest) {
+				oldest = conn.idleSince
+			}
+			kept = append(kept, conn)
+		}
+		if len(kept) == 0 {
+			delete(p.idle, endpoint)
+		} else {
+			p.idle[endpoint] = kept
+		}
+	}
+
+	p.timer = nil
+	if !oldest.IsZero() && p.config.IdleTimeout > 0 {
+		p.timer = time.AfterFunc(oldest.Add(p.config.IdleTimeout).Sub(now), p.closeExpired)
+	}
+}
+
+// closeIdle closes every idle connection
+func (p *connPool) closeIdle() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for endpoint, conns := range p.idle {
+		for _, conn := range conns {
+			conn.Close()
+			p.forgetLocked(endpoint)
+		}
+		delete(p.idle, endpoint)
+	}
+}
+
+// watch reads from the connection until it fails, so a connection the peer
+// closed is noticed before it is reused. Send does not wait for replies;
+// anything a handler writes back is discarded.
+func (c *pooledConn) watch() {
+	io.Copy(io.Discard, c.Conn)
+	c.broken.Store(true)
+}
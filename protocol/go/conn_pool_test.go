@@ -0,0 +1,138 @@
+package protocol
+
+import (
+	"crypto/tls"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// poolTestServer accepts stream connections, counting them and the
+// heartbeats received
+type poolTestServer struct {
+	listener   net.Listener
+	accepted   atomic.Int32
+	heartbeats chan struct{}
+}
+
+func newPoolTestServer(t *testing.T) *poolTestServer {
+	t.Helper()
+	server, _ := NewTransport(nil)
+	if err := server.GenerateSelfSignedCert(); err != nil {
+		t.Fatalf("Failed to generate certificate: %v", err)
+	}
+	listener, err := tls.Listen("tcp", "127.0.0.1:0", server.tlsConfig)
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	s := &poolTestServer{listener: listener, heartbeats: make(chan struct{}, 100)}
+	server.RegisterHandler(EnvelopeHeartbeat, func(*Envelope, net.Conn) error {
+		s.heartbeats <- struct{}{}
+		return nil
+	})
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			s.accepted.Add(1)
+			go server.handleConnection(conn)
+		}
+	}()
+	t.Cleanup(func() { listener.Close() })
+	return s
+}
+
+// sendHeartbeats sends n heartbeats and waits for the server to receive them
+func (s *poolTestServer) sendHeartbeats(t *testing.T, transport *Transport, n int) {
+	t.Helper()
+	for i := 0; i < n; i++ {
+		envelope := &Envelope{Type: EnvelopeHeartbeat, CommonHeaders: newHeaders("worker"), Body: []byte(`{"load":0,"inFlight":0,"uptime":0}`)}
+		if err := transport.Send(s.listener.Addr().String(), envelope); err != nil {
+			t.Fatalf("Send failed: %v", err)
+		}
+	}
+	for i := 0; i < n; i++ {
+		select {
+		case <-s.heartbeats:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("Server received %d of %d heartbeats", i, n)
+		}
+	}
+}
+
+func TestSendReusesConnections(t *testing.T) {
+	server := newPoolTestServer(t)
+	client, _ := NewTransport(nil)
+
+	server.sendHeartbeats(t, client, 5)
+	if accepted := server.accepted.Load(); accepted != 1 {
+		t.Errorf("Sequential sends should share one connection, got %d", accepted)
+	}
+
+	// A connection the pool no longer holds is replaced
+	client.CloseIdleConnections()
+	server.sendHeartbeats(t, client, 1)
+	if accepted := server.accepted.Load(); accepted != 2 {
+		t.Errorf("Expected a new connection after closing idle ones, got %d", accepted)
+	}
+
+	// Without idle connections every envelope gets its own connection
+	client.SetPoolConfig(PoolConfig{MaxIdlePerEndpoint: 0})
+	server.sendHeartbeats(t, client, 2)
+	if accepted := server.accepted.Load(); accepted != 4 {
+		t.Errorf("Expected a connection per envelope without pooling, got %d", accepted)
+	}
+}
+
+func TestSendReplacesClosedConnections(t *testing.T) {
+	server := newPoolTestServer(t)
+	client, _ := NewTransport(nil)
+	client.SetPoolConfig(PoolConfig{MaxIdlePerEndpoint: 1, IdleTimeout: 50 * time.Millisecond})
+
+	server.sendHeartbeats(t, client, 1)
+	time.Sleep(150 * time.Millisecond)
+	client.pool.mu.Lock()
+	idle := len(client.pool.idle)
+	client.pool.mu.Unlock()
+	if idle != 0 {
+		t.Errorf("Idle connection should be closed after the idle timeout")
+	}
+	server.sendHeartbeats(t, client, 1)
+	if accepted := server.accepted.Load(); accepted != 2 {
+		t.Errorf("Expired connection should be replaced, got %d connections", accepted)
+	}
+}
+
+func TestSendLimitsConnectionsPerEndpoint(t *testing.T) {
+	pool := newConnPool(PoolConfig{MaxIdlePerEndpoint: 1, MaxConnsPerEndpoint: 1})
+	server := newPoolTestServer(t)
+	endpoint := server.listener.Addr().String()
+
+	conn, _, err := pool.get(endpoint)
+	if err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	got := make(chan *pooledConn)
+	go func() {
+		second, _, _ := pool.get(endpoint)
+		got <- second
+	}()
+	select {
+	case <-got:
+		t.Fatal("Second connection should wait while the endpoint is at its limit")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	pool.put(endpoint, conn)
+	select {
+	case second := <-got:
+		if second != conn {
+			t.Error("Waiting sender should reuse the returned connection")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Returned connection should wake the waiting sender")
+	}
+}
@@ -0,0 +1,97 @@
+package protocol
+
+import (
+	"crypto/ed25519"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// MaxDelegationDepth bounds how many times a capability can be re-delegated
+const MaxDelegationDepth = 4
+
+// DelegateCapability derives a narrower capability from parentToken and hands
+// it to subject. The holder signs it with the key the parent is bound to, so
+// no broker round trip is needed. Permissions must fall within the parent's,
+// e.g. "discover:math.add" within "discover:math.*", and the expiry is capped
+// at the parent's. subjectKey may be nil, in which case the subject cannot
+// delegate any further.
+func DelegateCapability(parentToken string, holderKey Signer, subject string, subjectKey ed25519.PublicKey, permissions []string, duration time.Duration) (string, error) {
+	parent := &Capability{}
+	if _, _, err := jwt.NewParser().ParseUnverified(parentToken, parent); err != nil {
+		return "", fmt.Errorf("invalid parent capability: %w", err)
+	}
+	holderPublic, err := SignerPublicKey(holderKey)
+	if err != nil {
+		return "", err
+	}
+	if parent.Holder != EncodePublicKey(holderPublic) {
+		return "", fmt.Errorf("parent capability is not bound to this key")
+	}
+
+	expires := time.Now().Add(duration)
+	if parent.ExpiresAt != nil && expires.After(parent.ExpiresAt.Time) {
+		expires = parent.ExpiresAt.Time
+	}
+	claims := newCapabilityClaims(parent.Scope, parent.Subject, subject, permissions, expires)
+	claims.Parent = parentToken
+	if subjectKey != nil {
+		claims.Holder = EncodePublicKey(subjectKey)
+	}
+	if err := checkAttenuation(parent, &claims); err != nil {
+		return "", err
+	}
+
+	token := jwt.NewWithClaims(jwt.SigningMethodEdDSA, claims)
+	return token.SignedString(holderKey)
+}
+
+// delegatorKey returns the key a delegated token must be signed with: the
+// holder key its already validated parent is bound to
+func delegatorKey(token *jwt.Token, parent *Capability, depth int) (interface{}, error) {
+	if depth >= MaxDelegationDepth {
+		return nil, fmt.Errorf("delegation chain longer than %d", MaxDelegationDepth)
+	}
+	if _, ok := token.Method.(*jwt.SigningMethodEd25519); !ok {
+		return nil, fmt.Errorf("delegated capabilities must be signed with EdDSA")
+	}
+	if parent.Holder == "" {
+		return nil, fmt.Errorf("parent capability is not delegable")
+	}
+	return DecodePublicKey(parent.Holder)
+}
+
+// checkAttenuation ensures a delegated capability grants no more than its parent
+func checkAttenuation(parent, child *Capability) error {
+	if child.Issuer != parent.Subject {
+		return fmt.Errorf("capability delegated by %s, but parent was issued to %s", child.Issuer, parent.Subject)
+	}
+	if child.Scope != parent.Scope {
+		return fmt.Errorf("delegated scope %s differs from parent scope %s", child.Scope, parent.Scope)
+	}
+	if parent.ExpiresAt != nil && (child.ExpiresAt == nil || child.ExpiresAt.After(parent.ExpiresAt.Time)) {
+		return fmt.Errorf("delegated capability outlives its parent")
+	}
+	for _, permission := range child.Permissions {
+		if !parent.CoversPermission(permission) {
+			return fmt.Errorf("permission %s not granted by parent capability", permission)
+		}
+	}
+	return nil
+}
+
+// CoversPermission reports whether one of the capability's permissions
+// includes permission, treating a trailing "*" as a prefix wildcard
+func (c *Capability) CoversPermission(permission string) bool {
+	for _, granted := range c.Permissions {
+		if granted == "*" || granted == permission {
+			return true
+		}
+		if prefix, wildcard := strings.CutSuffix(granted, "*"); wildcard && strings.HasPrefix(permission, prefix) {
+			return true
+		}
+	}
+	return false
+}
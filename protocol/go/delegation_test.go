@@ -0,0 +1,107 @@
+package protocol
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDelegatedCapabilityChain(t *testing.T) {
+	_, brokerKey, _ := GenerateKeyPair()
+	broker := NewEd25519CapabilityManager(brokerKey)
+	agentPub, agentKey, _ := GenerateKeyPair()
+	subPub, subKey, _ := GenerateKeyPair()
+
+	root, err := broker.CreateDelegableCapability("tools", "broker", "agent-1", agentPub, []string{"call:math.*", "discover:*"}, time.Hour)
+	if err != nil {
+		t.Fatalf("Failed to create capability: %v", err)
+	}
+
+	child, err := DelegateCapability(root, agentKey, "sub-agent", subPub, []string{"call:math.add"}, 2*time.Hour)
+	if err != nil {
+		t.Fatalf("Failed to delegate capability: %v", err)
+	}
+
+	capability, err := NewCapabilityVerifier(broker.PublicKey()).ValidateCapability(child)
+	if err != nil {
+		t.Fatalf("Expected delegated capability to validate: %v", err)
+	}
+	if capability.Subject != "sub-agent" || capability.Issuer != "agent-1" {
+		t.Errorf("Expected agent-1 -> sub-agent, got %s -> %s", capability.Issuer, capability.Subject)
+	}
+	if !capability.HasPermission("call:math.add") || capability.HasPermission("discover:*") {
+		t.Errorf("Expected only call:math.add, got %v", capability.Permissions)
+	}
+	if capability.ExpiresAt.After(time.Now().Add(time.Hour + time.Second)) {
+		t.Error("Expected expiry to be capped at the parent's")
+	}
+
+	grandchild, err := DelegateCapability(child, subKey, "worker", nil, []string{"call:math.add"}, time.Minute)
+	if err != nil {
+		t.Fatalf("Failed to re-delegate capability: %v", err)
+	}
+	if _, err := broker.ValidateCapability(grandchild); err != nil {
+		t.Errorf("Expected two-link chain to validate: %v", err)
+	}
+
+	// The worker received no holder key, so it cannot delegate further
+	_, workerKey, _ := GenerateKeyPair()
+	if _, err := DelegateCapability(grandchild, workerKey, "other", nil, []string{"call:math.add"}, time.Minute); err == nil {
+		t.Error("Expected delegation from a non-delegable capability to fail")
+	}
+}
+
+func TestDelegationCannotWiden(t *testing.T) {
+	_, brokerKey, _ := GenerateKeyPair()
+	broker := NewEd25519CapabilityManager(brokerKey)
+	agentPub, agentKey, _ := GenerateKeyPair()
+
+	root, _ := broker.CreateDelegableCapability("tools", "broker", "agent-1", agentPub, []string{"call:math.*"}, time.Hour)
+	if _, err := DelegateCapability(root, agentKey, "sub-agent", nil, []string{"call:shell.exec"}, time.Hour); err == nil {
+		t.Error("Expected delegation of an ungranted permission to fail")
+	}
+
+	_, strangerKey, _ := GenerateKeyPair()
+	if _, err := DelegateCapability(root, strangerKey, "sub-agent", nil, []string{"call:math.add"}, time.Hour); err == nil {
+		t.Error("Expected delegation by a key the capability is not bound to to fail")
+	}
+
+	// A forged child skipping DelegateCapability's checks is still rejected
+	claims := newCapabilityClaims("tools", "agent-1", "sub-agent", []string{"*"}, time.Now().Add(time.Hour))
+	claims.Parent = root
+	forged, _ := NewEd25519CapabilityManager(agentKey).sign(claims)
+	_, err := broker.ValidateCapability(forged)
+	if err == nil || !strings.Contains(err.Error(), "not granted") {
+		t.Errorf("Expected widened permissions to be rejected, got %v", err)
+	}
+}
+
+func TestDelegationRequiresDelegableParent(t *testing.T) {
+	_, brokerKey, _ := GenerateKeyPair()
+	broker := NewEd25519CapabilityManager(brokerKey)
+	_, agentKey, _ := GenerateKeyPair()
+
+	root, _ := broker.CreateCapability("tools", "broker", "agent-1", []string{"call:*"}, time.Hour)
+	claims := newCapabilityClaims("tools", "agent-1", "sub-agent", []string{"call:math.add"}, time.Now().Add(time.Minute))
+	claims.Parent = root
+	child, _ := NewEd25519CapabilityManager(agentKey).sign(claims)
+
+	if _, err := broker.ValidateCapability(child); err == nil {
+		t.Error("Expected delegation from a capability without a holder key to fail")
+	}
+}
+
+func TestCoversPermission(t *testing.T) {
+	capability := &Capability{Permissions: []string{"discover:math.*", "call:shell.exec"}}
+	for permission, covered := range map[string]bool{
+		"discover:math.add": true,
+		"discover:math.*":   true,
+		"discover:*":        false,
+		"call:shell.exec":   true,
+		"call:shell.*":      false,
+	} {
+		if got := capability.CoversPermission(permission); got != covered {
+			t.Errorf("CoversPermission(%q) = %v, want %v", permission, got, covered)
+		}
+	}
+}
@@ -0,0 +1,130 @@
+package protocol
+
+import (
+	"container/list"
+	"sync"
+)
+
+// DeliveryGuarantee selects how the broker delivers a tool result to its caller
+type DeliveryGuarantee string
+
+const (
+	// DeliverAtMostOnce hands the result out once; a caller that misses it loses it
+	DeliverAtMostOnce DeliveryGuarantee = "at-most-once"
+	// DeliverAtLeastOnce redelivers the result until the caller acknowledges it.
+	// Callers deduplicate by request ID to process each result exactly once.
+	DeliverAtLeastOnce DeliveryGuarantee = "at-least-once"
+)
+
+// ResultDeliveryMode selects how the result of a call comes back to the caller
+type ResultDeliveryMode string
+
+const (
+	// ResultDeliverySync answers the toolCall itself with the result, waiting
+	// up to a timeout; results not in by then are held for polling
+	ResultDeliverySync ResultDeliveryMode = "sync"
+	// ResultDeliveryPoll holds the result until the caller collects it with
+	// resultAck, optionally long-polling. It is the default.
+	ResultDeliveryPoll ResultDeliveryMode = "poll"
+	// ResultDeliveryWebhook POSTs the result, signed by the broker, to a URL
+	ResultDeliveryWebhook ResultDeliveryMode = "webhook"
+	// ResultDeliveryEvent publishes the result to an event topic
+	ResultDeliveryEvent ResultDeliveryMode = "event"
+)
+
+// ResultDelivery is how a caller wants the result of a call returned
+type ResultDelivery struct {
+	Mode       ResultDeliveryMode `json:"mode"`
+	URL        string             `json:"url,omitempty"`        // Webhook endpoint, http or https
+	Topic      string             `json:"topic,omitempty"`      // Event topic the result is published to
+	Capability string             `json:"capability,omitempty"` // Token granting publish on the topic, if publishing is gated
+	Timeout    int64              `json:"timeout,omitempty"`    // Seconds a sync call waits for its result; 0 waits as long as the broker allows
+}
+
+// DefaultDedupeSize is the number of request IDs a ResultDeduper remembers by default
+const DefaultDedupeSize = 10000
+
+// Validate checks the guarantee is known; empty means at-most-once
+func (g DeliveryGuarantee) Validate() error {
+	switch g {
+	case "", DeliverAtMostOnce, DeliverAtLeastOnce:
+		return nil
+	default:
+		return invalid("delivery", "unknown delivery guarantee %q", g)
+	}
+}
+
+// OrDefault returns the guarantee, or at-most-once if empty
+func (g DeliveryGuarantee) OrDefault() DeliveryGuarantee {
+	if g == "" {
+		return DeliverAtMostOnce
+	}
+	return g
+}
+
+// Validate checks the mode is known and names where push modes deliver to
+func (d *ResultDelivery) Validate() error {
+	if d == nil {
+		return nil
+	}
+	switch d.Mode {
+	case ResultDeliverySync, ResultDeliveryPoll:
+	case ResultDeliveryWebhook:
+		if err := validateEndpoint("resultDelivery.url", d.URL); err != nil {
+			return err
+		}
+	case ResultDeliveryEvent:
+		if err := ValidateTopic(d.Topic); err != nil {
+			return invalid("resultDelivery.topic", "%v", err)
+		}
+	default:
+		return invalid("resultDelivery.mode", "unknown result delivery mode %q", d.Mode)
+	}
+	if d.Timeout < 0 {
+		return invalid("resultDelivery.timeout", "must not be negative")
+	}
+	return nil
+}
+
+// ModeOrDefault returns the delivery mode, or poll if none was chosen
+func (d *ResultDelivery) ModeOrDefault() ResultDeliveryMode {
+	if d == nil || d.Mode == "" {
+		return ResultDeliveryPoll
+	}
+	return d.Mode
+}
+
+// ResultDeduper remembers the request IDs of recently processed results so
+// that redelivered results are processed once. It forgets the oldest IDs
+// beyond its capacity.
+type ResultDeduper struct {
+	mu       sync.Mutex
+	capacity int
+	order    *list.List
+	seen     map[string]*list.Element
+}
+
+// NewResultDeduper creates a deduper remembering up to capacity request IDs,
+// or DefaultDedupeSize if capacity is not positive
+func NewResultDeduper(capacity int) *ResultDeduper {
+	if capacity <= 0 {
+		capacity = DefaultDedupeSize
+	}
+	return &ResultDeduper{capacity: capacity, order: list.New(), seen: make(map[string]*list.Element)}
+}
+
+// FirstDelivery records requestID and reports whether it had not been seen before
+func (d *ResultDeduper) FirstDelivery(requestID string) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if _, seen := d.seen[requestID]; seen {
+		return false
+	}
+	d.seen[requestID] = d.order.PushBack(requestID)
+	if d.order.Len() > d.capacity {
+		oldest := d.order.Front()
+		d.order.Remove(oldest)
+		delete(d.seen, oldest.Value.(string))
+	}
+	return true
+}
@@ -0,0 +1,40 @@
+package protocol
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestDeliveryGuaranteeValidate(t *testing.T) {
+	for _, guarantee := range []DeliveryGuarantee{"", DeliverAtMostOnce, DeliverAtLeastOnce} {
+		if err := guarantee.Validate(); err != nil {
+			t.Errorf("%q should be valid: %v", guarantee, err)
+		}
+	}
+	var validationErr *ValidationError
+	if err := DeliveryGuarantee("exactly-once").Validate(); !errors.As(err, &validationErr) {
+		t.Errorf("Unknown guarantee should be rejected, got %v", err)
+	}
+	if DeliveryGuarantee("").OrDefault() != DeliverAtMostOnce {
+		t.Error("Empty guarantee should default to at-most-once")
+	}
+}
+
+func TestResultDeduper(t *testing.T) {
+	deduper := NewResultDeduper(2)
+	if !deduper.FirstDelivery("a") || !deduper.FirstDelivery("b") {
+		t.Fatal("New request IDs should be first deliveries")
+	}
+	if deduper.FirstDelivery("a") {
+		t.Error("Repeated request ID should be a duplicate")
+	}
+
+	// "c" pushes out the oldest ID
+	deduper.FirstDelivery("c")
+	if !deduper.FirstDelivery("a") {
+		t.Error("Evicted request ID should be forgotten")
+	}
+	if deduper.FirstDelivery("c") {
+		t.Error("Recent request ID should still be remembered")
+	}
+}
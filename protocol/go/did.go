@@ -0,0 +1,137 @@
+package protocol
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+)
+
+// DIDKeyPrefix starts agent IDs that are did:key identifiers. Such an ID is
+// derived from the agent's Ed25519 public key, so it can only be used by the
+// holder of that key.
+const DIDKeyPrefix = "did:key:"
+
+// multibaseBase58BTC is the multibase prefix of base58btc-encoded data
+const multibaseBase58BTC = 'z'
+
+// maxDIDKeyLength bounds the identifier decoded, well above the 48
+// characters of an Ed25519 key
+const maxDIDKeyLength = 128
+
+// multicodecEd25519Pub is the multicodec ed25519-pub, as an unsigned varint
+var multicodecEd25519Pub = []byte{0xed, 0x01}
+
+// ErrInvalidDID is returned for identifiers that are not Ed25519 did:key DIDs
+var ErrInvalidDID = errors.New("invalid did:key identifier")
+
+// ErrDIDKeyMismatch is returned when a did:key names a different key
+var ErrDIDKeyMismatch = errors.New("did:key does not match the public key")
+
+// DIDKey returns the did:key identifier of an Ed25519 public key, e.g.
+// did:key:z6MkhaXgBZDvotDkL5257faiztiGiC2QtKLGpbnnEGta2doK
+func DIDKey(publicKey ed25519.PublicKey) string {
+	data := append(append([]byte{}, multicodecEd25519Pub...), publicKey...)
+	return DIDKeyPrefix + string(multibaseBase58BTC) + encodeBase58(data)
+}
+
+// IsDIDKey reports whether an agent ID is a did:key identifier. It does not
+// check the identifier is well formed; ResolveDIDKey does.
+func IsDIDKey(id string) bool {
+	return strings.HasPrefix(id, DIDKeyPrefix)
+}
+
+// ResolveDIDKey returns the Ed25519 public key a did:key identifier names
+func ResolveDIDKey(did string) (ed25519.PublicKey, error) {
+	if !IsDIDKey(did) {
+		return nil, fmt.Errorf("%w: %q does not start with %s", ErrInvalidDID, did, DIDKeyPrefix)
+	}
+	identifier := strings.TrimPrefix(did, DIDKeyPrefix)
+	if len(identifier) > maxDIDKeyLength {
+		return nil, fmt.Errorf("%w: too long", ErrInvalidDID)
+	}
+	if identifier == "" || identifier[0] != multibaseBase58BTC {
+		return nil, fmt.Errorf("%w: only base58btc (z) encoding is supported", ErrInvalidDID)
+	}
+	data, err := decodeBase58(identifier[1:])
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidDID, err)
+	}
+	if !bytes.HasPrefix(data, multicodecEd25519Pub) {
+		return nil, fmt.Errorf("%w: not an Ed25519 key", ErrInvalidDID)
+	}
+	key := data[len(multicodecEd25519Pub):]
+	if len(key) != ed25519.PublicKeySize {
+		return nil, fmt.Errorf("%w: key is %d bytes, want %d", ErrInvalidDID, len(key), ed25519.PublicKeySize)
+	}
+	return ed25519.PublicKey(key), nil
+}
+
+// VerifyDIDKey checks that did is the did:key identifier of publicKey
+func VerifyDIDKey(did string, publicKey ed25519.PublicKey) error {
+	named, err := ResolveDIDKey(did)
+	if err != nil {
+		return err
+	}
+	if !named.Equal(publicKey) {
+		return ErrDIDKeyMismatch
+	}
+	return nil
+}
+
+// VerifyDIDAgent checks the envelope is signed by the key its did:key agent
+// header names, so no registration is needed to authenticate the sender
+func (g *GenericEnvelope) VerifyDIDAgent() error {
+	publicKey, err := ResolveDIDKey(g.Agent)
+	if err != nil {
+		return err
+	}
+	return g.Verify(publicKey)
+}
+
+const base58Alphabet = "123456789ABCDEFGHJKLMNPQRSTUVWXYZabcdefghijkmnopqrstuvwxyz"
+
+var base58Radix = big.NewInt(58)
+
+// encodeBase58 encodes data in the Bitcoin base58 alphabet, with a leading '1'
+// for each leading zero byte
+func encodeBase58(data []byte) string {
+	zeros := 0
+	for zeros < len(data) && data[zeros] == 0 {
+		zeros++
+	}
+	n := new(big.Int).SetBytes(data)
+	var encoded []byte
+	mod := new(big.Int)
+	for n.Sign() > 0 {
+		n.DivMod(n, base58Radix, mod)
+		encoded = append(encoded, base58Alphabet[mod.Int64()])
+	}
+	for i := 0; i < zeros; i++ {
+		encoded = append(encoded, base58Alphabet[0])
+	}
+	for i, j := 0, len(encoded)-1; i < j; i, j = i+1, j-1 {
+		encoded[i], encoded[j] = encoded[j], encoded[i]
+	}
+	return string(encoded)
+}
+
+// decodeBase58 reverses encodeBase58
+func decodeBase58(encoded string) ([]byte, error) {
+	zeros := 0
+	for zeros < len(encoded) && encoded[zeros] == base58Alphabet[0] {
+		zeros++
+	}
+	n := new(big.Int)
+	for _, c := range []byte(encoded) {
+		digit := strings.IndexByte(base58Alphabet, c)
+		if digit < 0 {
+			return nil, fmt.Errorf("invalid base58 character %q", c)
+		}
+		n.Mul(n, base58Radix)
+		n.Add(n, big.NewInt(int64(digit)))
+	}
+	return append(make([]byte, zeros), n.Bytes()...), nil
+}
@@ -0,0 +1,83 @@
+package protocol
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestDIDKeyVector(t *testing.T) {
+	// From the did:key method specification's Ed25519 test vectors
+	did := "did:key:z6MkiTBz1ymuepAQ4HEHYSF1H8quG5GLVVQR3djdX3mDooWp"
+	key, err := ResolveDIDKey(did)
+	if err != nil {
+		t.Fatalf("Failed to resolve %s: %v", did, err)
+	}
+	if encoded := encodeBase58(key); encoded != "4zvwRjXUKGfvwnParsHAS3HuSVzV5cA4McphgmoCtajS" {
+		t.Errorf("Unexpected key %s", encoded)
+	}
+	if DIDKey(key) != did {
+		t.Errorf("Expected %s back, got %s", did, DIDKey(key))
+	}
+}
+
+func TestDIDKeyRoundTrip(t *testing.T) {
+	publicKey, _, _ := GenerateKeyPair()
+	did := DIDKey(publicKey)
+	if !strings.HasPrefix(did, "did:key:z6Mk") || !IsDIDKey(did) {
+		t.Errorf("Unexpected did:key %s", did)
+	}
+	if err := VerifyDIDKey(did, publicKey); err != nil {
+		t.Errorf("Expected the DID to match its key: %v", err)
+	}
+	otherKey, _, _ := GenerateKeyPair()
+	if err := VerifyDIDKey(did, otherKey); !errors.Is(err, ErrDIDKeyMismatch) {
+		t.Errorf("Expected ErrDIDKeyMismatch, got %v", err)
+	}
+
+	// Leading zero bytes survive base58
+	data := []byte{0, 0, 1, 2}
+	if decoded, err := decodeBase58(encodeBase58(data)); err != nil || !bytes.Equal(decoded, data) {
+		t.Errorf("Expected %v back, got %v %v", data, decoded, err)
+	}
+
+	for _, invalid := range []string{
+		"calc-agent",
+		"did:web:example.com",
+		"did:key:",
+		"did:key:f6Mk",  // Not base58btc
+		"did:key:z0OIl", // Not base58
+		"did:key:zDnaerDaTF5BXEavCrfRZEk316dpbLsfPDZ3WJ5hRTPFU2169", // P-256
+		"did:key:z" + strings.Repeat("1", 200),
+		did[:len(did)-2],
+	} {
+		if _, err := ResolveDIDKey(invalid); !errors.Is(err, ErrInvalidDID) {
+			t.Errorf("Expected %q to be rejected, got %v", invalid, err)
+		}
+	}
+}
+
+func TestVerifyDIDAgent(t *testing.T) {
+	publicKey, privateKey, _ := GenerateKeyPair()
+	envelope := NewTypedEnvelope(DIDKey(publicKey), ToolCallBody{Tool: "math.add", RequestID: "req-1"})
+	parse := func() *GenericEnvelope {
+		data, _ := json.Marshal(envelope)
+		generic, err := ParseEnvelope(data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return generic
+	}
+	envelope.Sign(privateKey)
+	if err := parse().VerifyDIDAgent(); err != nil {
+		t.Errorf("Expected the envelope to verify against its agent DID: %v", err)
+	}
+
+	_, otherKey, _ := GenerateKeyPair()
+	envelope.Sign(otherKey)
+	if err := parse().VerifyDIDAgent(); err == nil {
+		t.Error("Expected a signature by another key to be rejected")
+	}
+}
@@ -0,0 +1,246 @@
+package protocol
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// ContentTypeDiff is the media type of DiffResult tool results
+const ContentTypeDiff = "application/vnd.fem.diff+json"
+
+// FileChange is how a diff changes a file
+type FileChange string
+
+const (
+	FileAdded    FileChange = "added"
+	FileModified FileChange = "modified"
+	FileDeleted  FileChange = "deleted"
+	FileRenamed  FileChange = "renamed"
+)
+
+// DiffResult is the standard result of tools that edit or compare files, so
+// orchestrators and dashboards render changes alike whichever agent made them
+type DiffResult struct {
+	Diff  string     `json:"diff"` // Unified diff of every change, as git diff prints it
+	Files []FileDiff `json:"files"`
+	Stats DiffStats  `json:"stats"`
+}
+
+// FileDiff summarizes the changes to one file
+type FileDiff struct {
+	Path      string     `json:"path"`
+	OldPath   string     `json:"oldPath,omitempty"` // Path before a rename
+	Change    FileChange `json:"change"`
+	Additions int        `json:"additions"`
+	Deletions int        `json:"deletions"`
+	Binary    bool       `json:"binary,omitempty"` // Contents are not shown in the diff
+}
+
+// DiffStats totals the changes of a diff
+type DiffStats struct {
+	FilesChanged int `json:"filesChanged"`
+	Additions    int `json:"additions"`
+	Deletions    int `json:"deletions"`
+}
+
+// ParseUnifiedDiff builds a DiffResult from a unified diff, in git's extended
+// format or the plain one diff -u prints
+func ParseUnifiedDiff(diff string) (*DiffResult, error) {
+	result := &DiffResult{Diff: diff, Files: []FileDiff{}}
+	var file *FileDiff
+	oldLeft, newLeft := 0, 0 // Lines of the current hunk still to come
+	start := func() {
+		result.Files = append(result.Files, FileDiff{Change: FileModified})
+		file = &result.Files[len(result.Files)-1]
+	}
+
+	for i, line := range strings.Split(diff, "\n") {
+		if oldLeft > 0 || newLeft > 0 {
+			switch {
+			case strings.HasPrefix(line, "+"):
+				file.Additions++
+				newLeft--
+			case strings.HasPrefix(line, "-"):
+				file.Deletions++
+				oldLeft--
+			case strings.HasPrefix(line, `\`):
+				// "\ No newline at end of file"
+			default:
+				oldLeft--
+				newLeft--
+			}
+			continue
+		}
+
+		switch {
+		case strings.HasPrefix(line, "diff --git "):
+			start()
+			oldPath, newPath, ok := splitGitPaths(strings.TrimPrefix(line, "diff --git "))
+			if !ok {
+				return nil, fmt.Errorf("line %d: cannot read paths from %q", i+1, line)
+			}
+			file.OldPath, file.Path = oldPath, newPath
+		case strings.HasPrefix(line, "--- "):
+			// Plain diffs start each file at its header
+			if file == nil || file.Additions+file.Deletions > 0 {
+				start()
+			}
+			if path := headerPath(line[4:]); path != "" {
+				file.OldPath = path
+			} else {
+				file.Change = FileAdded
+			}
+		case file == nil:
+			// Text before the first file, e.g. a commit message
+		case strings.HasPrefix(line, "+++ "):
+			if path := headerPath(line[4:]); path != "" {
+				file.Path = path
+			} else {
+				file.Change = FileDeleted
+			}
+		case strings.HasPrefix(line, "@@ "):
+			var err error
+			if oldLeft, newLeft, err = hunkLengths(line); err != nil {
+				return nil, fmt.Errorf("line %d: %w", i+1, err)
+			}
+		case strings.HasPrefix(line, "new file mode"):
+			file.Change = FileAdded
+		case strings.HasPrefix(line, "deleted file mode"):
+			file.Change = FileDeleted
+		case strings.HasPrefix(line, "rename from "):
+			file.Change, file.OldPath = FileRenamed, strings.TrimPrefix(line, "rename from ")
+		case strings.HasPrefix(line, "rename to "):
+			file.Change, file.Path = FileRenamed, strings.TrimPrefix(line, "rename to ")
+		case strings.HasPrefix(line, "Binary files ") || line == "GIT binary patch":
+			file.Binary = true
+		}
+	}
+	if oldLeft > 0 || newLeft > 0 {
+		return nil, fmt.Errorf("diff ends inside a hunk")
+	}
+
+	for i := range result.Files {
+		file := &result.Files[i]
+		switch file.Change {
+		case FileDeleted:
+			file.Path, file.OldPath = file.OldPath, ""
+		case FileRenamed:
+		default:
+			file.OldPath = ""
+		}
+		result.Stats.Additions += file.Additions
+		result.Stats.Deletions += file.Deletions
+	}
+	result.Stats.FilesChanged = len(result.Files)
+	return result, nil
+}
+
+// splitGitPaths reads the a/ and b/ paths of a "diff --git" line. Paths
+// containing " b/" are split where both halves name the same file.
+func splitGitPaths(paths string) (string, string, bool) {
+	if !strings.HasPrefix(paths, "a/") {
+		return "", "", false
+	}
+	candidates := strings.Split(paths, " b/")
+	if len(candidates) == 2 {
+		return candidates[0][2:], candidates[1], true
+	}
+	half := (len(paths) - 1) / 2
+	if len(paths)%2 == 1 && paths[half] == ' ' && paths[2:half] == paths[half+3:] {
+		return paths[2:half], paths[half+3:], true
+	}
+	return "", "", false
+}
+
+// hunkLengths reads the old and new line counts of a "@@ -1,5 +1,6 @@" hunk header
+func hunkLengths(header string) (int, int, error) {
+	fields := strings.Fields(header)
+	if len(fields) < 4 || fields[3] != "@@" || !strings.HasPrefix(fields[1], "-") || !strings.HasPrefix(fields[2], "+") {
+		return 0, 0, fmt.Errorf("invalid hunk header %q", header)
+	}
+	oldLength, err := rangeLength(fields[1][1:])
+	if err != nil {
+		return 0, 0, err
+	}
+	newLength, err := rangeLength(fields[2][1:])
+	return oldLength, newLength, err
+}
+
+// rangeLength reads the length of a hunk range "start,length"; a range
+// without one is a single line
+func rangeLength(hunkRange string) (int, error) {
+	_, length, found := strings.Cut(hunkRange, ",")
+	if !found {
+		return 1, nil
+	}
+	n, err := strconv.Atoi(length)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid hunk range %q", hunkRange)
+	}
+	return n, nil
+}
+
+// headerPath returns the path of a ---/+++ header without its a/ or b/
+// prefix and timestamp, or "" for /dev/null
+func headerPath(header string) string {
+	path, _, _ := strings.Cut(header, "\t")
+	if path == "/dev/null" {
+		return ""
+	}
+	if strings.HasPrefix(path, "a/") || strings.HasPrefix(path, "b/") {
+		return path[2:]
+	}
+	return path
+}
+
+// Validate checks that the file list accounts for the stats
+func (d *DiffResult) Validate() error {
+	var stats DiffStats
+	for i, file := range d.Files {
+		if file.Path == "" {
+			return &ValidationError{Field: fmt.Sprintf("files[%d].path", i), Message: "is required"}
+		}
+		switch file.Change {
+		case FileAdded, FileModified, FileDeleted:
+		case FileRenamed:
+			if file.OldPath == "" {
+				return &ValidationError{Field: fmt.Sprintf("files[%d].oldPath", i), Message: "is required for renamed files"}
+			}
+		default:
+			return &ValidationError{Field: fmt.Sprintf("files[%d].change", i), Message: fmt.Sprintf("unknown change %q", file.Change)}
+		}
+		stats.Additions += file.Additions
+		stats.Deletions += file.Deletions
+	}
+	stats.FilesChanged = len(d.Files)
+	if stats != d.Stats {
+		return &ValidationError{Field: "stats", Message: fmt.Sprintf("do not match the files: want %+v", stats)}
+	}
+	return nil
+}
+
+// Diff sets a diff as the result
+func (b *ToolResultBuilder) Diff(diff *DiffResult) *ToolResultBuilder {
+	return b.Result(diff).ContentType(ContentTypeDiff)
+}
+
+// Diff reads a DiffResult result
+func (b ToolResultBody) Diff() (*DiffResult, error) {
+	if mediaTypeOf(b.ContentType) != ContentTypeDiff {
+		return nil, fmt.Errorf("%s result is not a diff", b.ResultContentType())
+	}
+	data, err := json.Marshal(b.Result)
+	if err != nil {
+		return nil, err
+	}
+	var diff DiffResult
+	if err := json.Unmarshal(data, &diff); err != nil {
+		return nil, fmt.Errorf("invalid diff result: %w", err)
+	}
+	if err := diff.Validate(); err != nil {
+		return nil, err
+	}
+	return &diff, nil
+}
@@ -0,0 +1,109 @@
+package protocol
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+)
+
+const gitDiff = `diff --git a/main.go b/main.go
+index 3b18e51..a6c2f4d 100644
+--- a/main.go
++++ b/main.go
+@@ -1,4 +1,5 @@
+ package main
+ 
+-// old comment
++// new comment
++// second line
+ func main() {}
+diff --git a/docs/new.md b/docs/new.md
+new file mode 100644
+index 0000000..e69de29
+--- /dev/null
++++ b/docs/new.md
+@@ -0,0 +1,2 @@
++# New
++--- not a header
+diff --git a/legacy.txt b/legacy.txt
+deleted file mode 100644
+index 8baef1b..0000000
+--- a/legacy.txt
++++ /dev/null
+@@ -1 +0,0 @@
+-gone
+diff --git a/old name.go b/new name.go
+similarity index 100%
+rename from old name.go
+rename to new name.go
+diff --git a/logo.png b/logo.png
+index 1111111..2222222 100644
+Binary files a/logo.png and b/logo.png differ
+`
+
+func TestParseUnifiedDiff(t *testing.T) {
+	result, err := ParseUnifiedDiff(gitDiff)
+	if err != nil {
+		t.Fatalf("Failed to parse diff: %v", err)
+	}
+	want := []FileDiff{
+		{Path: "main.go", Change: FileModified, Additions: 2, Deletions: 1},
+		{Path: "docs/new.md", Change: FileAdded, Additions: 2},
+		{Path: "legacy.txt", Change: FileDeleted, Deletions: 1},
+		{Path: "new name.go", OldPath: "old name.go", Change: FileRenamed},
+		{Path: "logo.png", Change: FileModified, Binary: true},
+	}
+	if len(result.Files) != len(want) {
+		t.Fatalf("Expected %d files, got %+v", len(want), result.Files)
+	}
+	for i, file := range result.Files {
+		if file != want[i] {
+			t.Errorf("File %d: expected %+v, got %+v", i, want[i], file)
+		}
+	}
+	if result.Stats != (DiffStats{FilesChanged: 5, Additions: 4, Deletions: 2}) {
+		t.Errorf("Unexpected stats %+v", result.Stats)
+	}
+	if err := result.Validate(); err != nil {
+		t.Errorf("Parsed diff should validate: %v", err)
+	}
+
+	// diff -u output has no git headers
+	plain, err := ParseUnifiedDiff("--- config.yaml\t2026-01-01 10:00:00\n+++ config.yaml\t2026-01-02 10:00:00\n@@ -1 +1 @@\n-debug: true\n+debug: false\n")
+	if err != nil || len(plain.Files) != 1 || plain.Files[0].Path != "config.yaml" || plain.Stats.Additions != 1 {
+		t.Errorf("Unexpected plain diff %+v, %v", plain, err)
+	}
+
+	if _, err := ParseUnifiedDiff("--- a/x\n+++ b/x\n@@ -1,3 +1,3 @@\n-only\n"); err == nil {
+		t.Error("A truncated hunk should be rejected")
+	}
+}
+
+func TestDiffResults(t *testing.T) {
+	diff, _ := ParseUnifiedDiff(gitDiff)
+	result, err := NewToolResult("coder", "req-1").Diff(diff).Build()
+	if err != nil {
+		t.Fatalf("Failed to build result: %v", err)
+	}
+	if result.Body.ContentType != ContentTypeDiff || KindOf(ContentTypeDiff) != ResultJSON {
+		t.Errorf("Diffs should be JSON results of their own type, got %q", result.Body.ContentType)
+	}
+
+	// Results arriving as decoded JSON read back as diffs
+	var body ToolResultBody
+	data, _ := json.Marshal(result.Body)
+	json.Unmarshal(data, &body)
+	read, err := body.Diff()
+	if err != nil || read.Stats != diff.Stats || len(read.Files) != 5 {
+		t.Fatalf("Expected the diff back, got %+v, %v", read, err)
+	}
+
+	read.Stats.Additions++
+	var invalid *ValidationError
+	if err := read.Validate(); !errors.As(err, &invalid) || invalid.Field != "stats" {
+		t.Errorf("Stats not matching the files should be rejected, got %v", err)
+	}
+	if _, err := (ToolResultBody{Result: "text"}).Diff(); err == nil {
+		t.Error("JSON results are not diffs")
+	}
+}
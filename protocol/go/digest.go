@@ -0,0 +1,116 @@
+package protocol
+
+import (
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"hash"
+	"strings"
+)
+
+// Digest algorithms supported for detached signatures
+const (
+	DigestSHA256 = "sha-256"
+	DigestSHA512 = "sha-512"
+)
+
+// DetachedSignatureThreshold is the body size above which detached signatures are recommended
+const DetachedSignatureThreshold = 1 << 20
+
+// BodyDigest computes the digest header value ("<alg>=<base64>") for encoded body bytes
+func BodyDigest(body []byte, algorithm string) (string, error) {
+	h, err := newDigestHash(algorithm)
+	if err != nil {
+		return "", err
+	}
+	h.Write(body)
+	return algorithm + "=" + base64.StdEncoding.EncodeToString(h.Sum(nil)), nil
+}
+
+// SignDetached signs a digest of the envelope body instead of the body itself.
+// The digest covers the body bytes exactly as they are carried on the wire, so
+// receivers can verify without re-marshaling the body.
+func (e *Envelope) SignDetached(signer Signer, algorithm string) error {
+	return signDetached(e.Type, &e.CommonHeaders, e.Body, signer, algorithm)
+}
+
+// SignDetached signs a digest of the result body; intended for very large results
+func (e *ToolResultEnvelope) SignDetached(signer Signer, algorithm string) error {
+	body, err := json.Marshal(e.Body)
+	if err != nil {
+		return err
+	}
+	return signDetached(e.Type, &e.CommonHeaders, body, signer, algorithm)
+}
+
+// signDetached sets the body digest header and signs the digest-bearing headers
+func signDetached(envType EnvelopeType, headers *CommonHeaders, body []byte, signer Signer, algorithm string) error {
+	headers.Sig = ""
+	if err := setKeyID(headers, signer); err != nil {
+		return err
+	}
+
+	digest, err := BodyDigest(body, algorithm)
+	if err != nil {
+		return err
+	}
+	headers.Digest = digest
+
+	data, err := SigningBytes(envType, *headers, nil)
+	if err != nil {
+		return err
+	}
+
+	signature, err := signMessage(signer, data)
+	if err != nil {
+		return err
+	}
+	headers.Sig = base64.StdEncoding.EncodeToString(signature)
+	return nil
+}
+
+// verifyBodyDigest checks a digest header against the body bytes
+func verifyBodyDigest(digest string, body interface{}) error {
+	algorithm, _, found := strings.Cut(digest, "=")
+	if !found {
+		return fmt.Errorf("malformed body digest")
+	}
+
+	var data []byte
+	switch b := body.(type) {
+	case json.RawMessage:
+		data = b
+	case []byte:
+		data = b
+	default:
+		encoded, err := json.Marshal(b)
+		if err != nil {
+			return fmt.Errorf("failed to encode body for digest: %w", err)
+		}
+		data = encoded
+	}
+
+	expected, err := BodyDigest(data, algorithm)
+	if err != nil {
+		return err
+	}
+	if subtle.ConstantTimeCompare([]byte(expected), []byte(digest)) != 1 {
+		return fmt.Errorf("body digest mismatch")
+	}
+	return nil
+}
+
+// newDigestHash returns the hash implementation for a digest algorithm
+func newDigestHash(algorithm string) (hash.Hash, error) {
+	switch algorithm {
+	case DigestSHA256:
+		return sha256.New(), nil
+	case DigestSHA512:
+		return sha512.New(), nil
+	default:
+		return nil, fmt.Errorf("unsupported digest algorithm: %s", algorithm)
+	}
+}
@@ -0,0 +1,268 @@
+package protocol
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DirectoryBrokersPath is where a directory server takes and lists broker
+// descriptors. A descriptor is the broker's signed registerBroker envelope,
+// so it can be handed to peers as is.
+const DirectoryBrokersPath = "/brokers"
+
+// DefaultDescriptorTTL is how long a published descriptor stays listed;
+// brokers republish well within it
+const DefaultDescriptorTTL = 10 * time.Minute
+
+// maxDescriptorSize bounds descriptors and directory listings read
+const maxDescriptorSize = 64 << 10
+
+// VerifyBrokerDescriptor checks that a descriptor names its broker and is
+// signed with the public key it advertises, and returns its body
+func VerifyBrokerDescriptor(env *GenericEnvelope) (*RegisterBrokerBody, error) {
+	registration, err := ParseTyped[RegisterBrokerBody](env)
+	if err != nil {
+		return nil, err
+	}
+	body := registration.Body
+	if err := required("brokerId", body.BrokerID); err != nil {
+		return nil, err
+	}
+	publicKey, err := DecodePublicKey(body.PubKey)
+	if err != nil {
+		return nil, fmt.Errorf("invalid descriptor public key: %w", err)
+	}
+	if err := env.Verify(publicKey); err != nil {
+		return nil, err
+	}
+	return &body, nil
+}
+
+// DirectoryClient publishes a broker's descriptor to a directory server and
+// looks up the brokers it lists
+type DirectoryClient struct {
+	URL        string
+	HTTPClient *http.Client
+	MaxAge     time.Duration // Listed descriptors signed longer ago are skipped; DefaultDescriptorTTL if 0
+}
+
+// NewDirectoryClient creates a client for the directory server at url
+func NewDirectoryClient(url string) *DirectoryClient {
+	return &DirectoryClient{
+		URL:        strings.TrimSuffix(url, "/"),
+		HTTPClient: &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+// Publish lists the broker described by a signed registerBroker envelope
+func (c *DirectoryClient) Publish(ctx context.Context, descriptor interface{}) error {
+	data, err := json.Marshal(descriptor)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.URL+DirectoryBrokersPath, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := c.HTTPClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("directory publish failed: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		message, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("directory refused descriptor (%d): %s", resp.StatusCode, strings.TrimSpace(string(message)))
+	}
+	return nil
+}
+
+// Query returns the descriptors of the brokers the directory lists with the
+// capability, or every broker if capability is empty. Descriptors that are
+// stale or not signed by the broker they describe are skipped, so a
+// directory cannot speak for brokers.
+func (c *DirectoryClient) Query(ctx context.Context, capability string) ([]*GenericEnvelope, error) {
+	query := ""
+	if capability != "" {
+		query = "?capability=" + url.QueryEscape(capability)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.URL+DirectoryBrokersPath+query, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := c.HTTPClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("directory query failed: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("directory returned status %d", resp.StatusCode)
+	}
+
+	var listed []json.RawMessage
+	if err := json.NewDecoder(io.LimitReader(resp.Body, maxDescriptorSize*64)).Decode(&listed); err != nil {
+		return nil, fmt.Errorf("invalid directory listing: %w", err)
+	}
+	oldest := time.Now().Add(-c.maxAge()).UnixMilli()
+	descriptors := make([]*GenericEnvelope, 0, len(listed))
+	for _, data := range listed {
+		env, err := ParseEnvelope(data)
+		if err != nil || env.TS < oldest {
+			continue
+		}
+		if _, err := VerifyBrokerDescriptor(env); err != nil {
+			continue
+		}
+		descriptors = append(descriptors, env)
+	}
+	return descriptors, nil
+}
+
+func (c *DirectoryClient) maxAge() time.Duration {
+	if c.MaxAge > 0 {
+		return c.MaxAge
+	}
+	return DefaultDescriptorTTL
+}
+
+// Directory is a directory server: an http.Handler serving
+// DirectoryBrokersPath that lists the descriptors brokers publish to it. A
+// broker ID stays bound to the key that first published it until its
+// descriptor expires.
+type Directory struct {
+	mu          sync.Mutex
+	ttl         time.Duration
+	descriptors map[string]*directoryEntry
+}
+
+type directoryEntry struct {
+	env    *GenericEnvelope
+	body   *RegisterBrokerBody
+	listed time.Time
+}
+
+// NewDirectory creates a directory listing descriptors for ttl after they
+// were published, DefaultDescriptorTTL if ttl is 0
+func NewDirectory(ttl time.Duration) *Directory {
+	if ttl <= 0 {
+		ttl = DefaultDescriptorTTL
+	}
+	return &Directory{ttl: ttl, descriptors: make(map[string]*directoryEntry)}
+}
+
+// ServeHTTP takes descriptors POSTed to DirectoryBrokersPath and lists them
+// on GET, filtered by an optional capability parameter
+func (d *Directory) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != DirectoryBrokersPath {
+		http.NotFound(w, r)
+		return
+	}
+	switch r.Method {
+	case http.MethodPost:
+		d.publish(w, r)
+	case http.MethodGet:
+		listing, _ := json.Marshal(d.List(r.URL.Query().Get("capability")))
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(listing)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func (d *Directory) publish(w http.ResponseWriter, r *http.Request) {
+	data, err := io.ReadAll(io.LimitReader(r.Body, maxDescriptorSize+1))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if len(data) > maxDescriptorSize {
+		http.Error(w, "Descriptor too large", http.StatusRequestEntityTooLarge)
+		return
+	}
+	env, err := ParseEnvelope(data)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := d.Add(env); err != nil {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write([]byte(`{"status":"listed"}`))
+}
+
+// Add lists a broker's descriptor, replacing the one it published before
+func (d *Directory) Add(env *GenericEnvelope) error {
+	body, err := VerifyBrokerDescriptor(env)
+	if err != nil {
+		return fmt.Errorf("invalid descriptor: %w", err)
+	}
+	now := time.Now()
+	if signed := time.UnixMilli(env.TS); signed.Before(now.Add(-d.ttl)) || signed.After(now.Add(d.ttl)) {
+		return fmt.Errorf("descriptor signed at %s is not current", signed.UTC().Format(time.RFC3339))
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.expire(now)
+	if listed, exists := d.descriptors[body.BrokerID]; exists {
+		if listed.body.PubKey != body.PubKey {
+			return fmt.Errorf("broker %s is listed with another key", body.BrokerID)
+		}
+		if env.TS <= listed.env.TS {
+			return fmt.Errorf("descriptor is older than the one listed")
+		}
+	}
+	d.descriptors[body.BrokerID] = &directoryEntry{env: env, body: body, listed: now}
+	return nil
+}
+
+// List returns the current descriptors of brokers with the capability, or of
+// every broker if capability is empty, ordered by broker ID
+func (d *Directory) List(capability string) []*GenericEnvelope {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.expire(time.Now())
+	ids := make([]string, 0, len(d.descriptors))
+	for id, entry := range d.descriptors {
+		if capability == "" || offersCapability(entry.body.Capabilities, capability) {
+			ids = append(ids, id)
+		}
+	}
+	sort.Strings(ids)
+	listing := make([]*GenericEnvelope, len(ids))
+	for i, id := range ids {
+		listing[i] = d.descriptors[id].env
+	}
+	return listing
+}
+
+// expire drops descriptors not republished within the TTL
+func (d *Directory) expire(now time.Time) {
+	for id, entry := range d.descriptors {
+		if now.Sub(entry.listed) > d.ttl {
+			delete(d.descriptors, id)
+		}
+	}
+}
+
+// offersCapability reports whether any of a broker's capability patterns
+// covers the capability
+func offersCapability(patterns []string, capability string) bool {
+	for _, pattern := range patterns {
+		if MatchTopic(pattern, capability) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,88 @@
+package protocol
+
+import (
+	"context"
+	"crypto/ed25519"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// signedDescriptor returns a broker descriptor signed with key
+func signedDescriptor(t *testing.T, brokerID string, pubKey ed25519.PublicKey, key ed25519.PrivateKey, capabilities ...string) *TypedEnvelope[RegisterBrokerBody] {
+	t.Helper()
+	descriptor := NewTypedEnvelope(brokerID, RegisterBrokerBody{
+		BrokerID:     brokerID,
+		Endpoint:     "https://" + brokerID + ".example:4433",
+		PubKey:       EncodePublicKey(pubKey),
+		Capabilities: capabilities,
+		Policy:       &BrokerPolicy{PeerTrustTier: "discovery", CallCapabilities: true},
+	})
+	if err := descriptor.Sign(key); err != nil {
+		t.Fatalf("Failed to sign descriptor: %v", err)
+	}
+	return descriptor
+}
+
+func TestDirectoryPublishAndQuery(t *testing.T) {
+	server := httptest.NewServer(NewDirectory(time.Minute))
+	defer server.Close()
+	client := NewDirectoryClient(server.URL)
+	ctx := context.Background()
+
+	pubA, keyA, _ := GenerateKeyPair()
+	pubB, keyB, _ := GenerateKeyPair()
+	if err := client.Publish(ctx, signedDescriptor(t, "broker-a", pubA, keyA, "math.*")); err != nil {
+		t.Fatalf("Publish failed: %v", err)
+	}
+	if err := client.Publish(ctx, signedDescriptor(t, "broker-b", pubB, keyB, "text.summarize")); err != nil {
+		t.Fatalf("Publish failed: %v", err)
+	}
+
+	all, err := client.Query(ctx, "")
+	if err != nil || len(all) != 2 {
+		t.Fatalf("Expected both brokers listed, got %d: %v", len(all), err)
+	}
+	math, err := client.Query(ctx, "math.add")
+	if err != nil || len(math) != 1 || math[0].Agent != "broker-a" {
+		t.Fatalf("Expected only broker-a to offer math.add, got %v: %v", math, err)
+	}
+	body, err := VerifyBrokerDescriptor(math[0])
+	if err != nil || body.Policy == nil || !body.Policy.CallCapabilities {
+		t.Errorf("Expected the policy summary to survive the directory, got %+v: %v", body, err)
+	}
+
+	// Another key cannot take over a listed broker ID
+	if err := client.Publish(ctx, signedDescriptor(t, "broker-a", pubB, keyB, "math.*")); err == nil {
+		t.Error("Expected a descriptor under another key to be refused")
+	}
+	// Nor can a descriptor signed by someone other than the key it advertises be listed
+	pubC, _, _ := GenerateKeyPair()
+	if err := client.Publish(ctx, signedDescriptor(t, "broker-c", pubC, keyA)); err == nil {
+		t.Error("Expected a descriptor not signed by its own key to be refused")
+	}
+}
+
+func TestDirectoryQuerySkipsUnverifiedDescriptors(t *testing.T) {
+	pub, key, _ := GenerateKeyPair()
+	valid := signedDescriptor(t, "broker-a", pub, key)
+	tampered := signedDescriptor(t, "broker-b", pub, key)
+	tampered.Body.Endpoint = "https://attacker.example"
+	stale := signedDescriptor(t, "broker-c", pub, key)
+	stale.TS = time.Now().Add(-time.Hour).UnixMilli()
+	stale.Sign(key)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode([]interface{}{valid, tampered, stale})
+	}))
+	defer server.Close()
+
+	client := NewDirectoryClient(server.URL)
+	client.MaxAge = time.Minute
+	descriptors, err := client.Query(context.Background(), "")
+	if err != nil || len(descriptors) != 1 || descriptors[0].Agent != "broker-a" {
+		t.Fatalf("Expected only the valid descriptor, got %d: %v", len(descriptors), err)
+	}
+}
@@ -0,0 +1,149 @@
+package protocol
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"slices"
+	"sync"
+)
+
+// ContentEncoding identifies how a serialized envelope is compressed on the wire
+type ContentEncoding string
+
+const (
+	EncodingIdentity ContentEncoding = "identity"
+	EncodingGzip     ContentEncoding = "gzip"
+	EncodingZstd     ContentEncoding = "zstd"
+)
+
+// MaxDecodedEnvelopeSize bounds the size of a decompressed envelope
+const MaxDecodedEnvelopeSize = 32 << 20
+
+// Codec implements a content encoding other than gzip, which is built in.
+// zstd is registered as a Codec by this package.
+type Codec interface {
+	Compress(data []byte) ([]byte, error)
+	// Decompress fails rather than return more than maxSize bytes
+	Decompress(data []byte, maxSize int) ([]byte, error)
+}
+
+var (
+	codecsMu sync.RWMutex
+	codecs   = map[ContentEncoding]Codec{}
+)
+
+// RegisterEncoding makes a content encoding available to CompressBytes,
+// DecompressBytes and body compression, replacing any codec registered for it
+func RegisterEncoding(encoding ContentEncoding, codec Codec) {
+	codecsMu.Lock()
+	defer codecsMu.Unlock()
+	codecs[encoding] = codec
+}
+
+// SupportedEncodings lists the content encodings this process can decode,
+// gzip first and then registered encodings in name order
+func SupportedEncodings() []ContentEncoding {
+	codecsMu.RLock()
+	defer codecsMu.RUnlock()
+	registered := make([]ContentEncoding, 0, len(codecs))
+	for encoding := range codecs {
+		if encoding != EncodingGzip {
+			registered = append(registered, encoding)
+		}
+	}
+	slices.Sort(registered)
+	return append([]ContentEncoding{EncodingGzip}, registered...)
+}
+
+// SupportsEncoding reports whether this process can decode an encoding
+func SupportsEncoding(encoding ContentEncoding) bool {
+	return slices.Contains(SupportedEncodings(), encoding)
+}
+
+func registeredCodec(encoding ContentEncoding) (Codec, error) {
+	codecsMu.RLock()
+	defer codecsMu.RUnlock()
+	codec, exists := codecs[encoding]
+	if !exists {
+		return nil, fmt.Errorf("unsupported content encoding: %s", encoding)
+	}
+	return codec, nil
+}
+
+// EncodeEnvelope serializes a signed envelope and then applies the content encoding.
+// Envelopes are always signed before compression (sign-then-compress): the signature
+// covers the canonical uncompressed bytes, so every transport verifies the same data
+// regardless of how it was carried.
+func EncodeEnvelope(envelope interface{}, encoding ContentEncoding) ([]byte, error) {
+	data, err := json.Marshal(envelope)
+	if err != nil {
+		return nil, err
+	}
+	return CompressBytes(data, encoding)
+}
+
+// DecodeEnvelope reverses the content encoding and parses the envelope.
+// Signatures must be verified after decoding.
+func DecodeEnvelope(data []byte, encoding ContentEncoding) (*GenericEnvelope, error) {
+	decoded, err := DecompressBytes(data, encoding)
+	if err != nil {
+		return nil, err
+	}
+	return ParseEnvelope(decoded)
+}
+
+// CompressBytes applies a content encoding to serialized envelope bytes
+func CompressBytes(data []byte, encoding ContentEncoding) ([]byte, error) {
+	switch encoding {
+	case "", EncodingIdentity:
+		return data, nil
+	case EncodingGzip:
+		var buf bytes.Buffer
+		writer := gzip.NewWriter(&buf)
+		if _, err := writer.Write(data); err != nil {
+			return nil, err
+		}
+		if err := writer.Close(); err != nil {
+			return nil, err
+		}
+		return buf.Bytes(), nil
+	default:
+		codec, err := registeredCodec(encoding)
+		if err != nil {
+			return nil, err
+		}
+		return codec.Compress(data)
+	}
+}
+
+// DecompressBytes removes a content encoding from serialized envelope bytes
+func DecompressBytes(data []byte, encoding ContentEncoding) ([]byte, error) {
+	switch encoding {
+	case "", EncodingIdentity:
+		return data, nil
+	case EncodingGzip:
+		reader, err := gzip.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return nil, fmt.Errorf("invalid gzip data: %w", err)
+		}
+		defer reader.Close()
+
+		decoded, err := io.ReadAll(io.LimitReader(reader, MaxDecodedEnvelopeSize+1))
+		if err != nil {
+			return nil, fmt.Errorf("invalid gzip data: %w", err)
+		}
+		if len(decoded) > MaxDecodedEnvelopeSize {
+			return nil, fmt.Errorf("decoded envelope exceeds %d bytes", MaxDecodedEnvelopeSize)
+		}
+		return decoded, nil
+	default:
+		codec, err := registeredCodec(encoding)
+		if err != nil {
+			return nil, err
+		}
+		return codec.Decompress(data, MaxDecodedEnvelopeSize)
+	}
+}
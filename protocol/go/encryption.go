@@ -0,0 +1,318 @@
+package protocol
+
+import (
+	"bytes"
+	"crypto/cipher"
+	"crypto/ecdh"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+
+	"golang.org/x/crypto/chacha20poly1305"
+	"golang.org/x/crypto/hkdf"
+)
+
+// BodyEncryptionAlg is the only body encryption scheme: an ephemeral X25519
+// key agreement with the recipient's key, HKDF-SHA256, and ChaCha20-Poly1305
+const BodyEncryptionAlg = "X25519-ChaCha20-Poly1305"
+
+// CiphertextField is the body member holding an encrypted body
+const CiphertextField = "ciphertext"
+
+// bodyEncryptionInfo separates body encryption keys from other HKDF uses
+const bodyEncryptionInfo = "fep body encryption v1"
+
+// ErrEncryptedBody is returned when an encrypted body is read without decrypting it
+var ErrEncryptedBody = errors.New("envelope body is encrypted")
+
+// ErrNoDecryptionKey is returned when none of the receiver's keys matches an envelope
+var ErrNoDecryptionKey = errors.New("no key for encrypted body")
+
+// Encryption describes how an envelope body was encrypted. It travels in the
+// headers so it is covered by the sender's signature.
+type Encryption struct {
+	Alg   string `json:"alg"`
+	KID   string `json:"kid"`   // Key ID of the recipient's encryption key
+	EPK   string `json:"epk"`   // Base64 ephemeral X25519 public key of the sender
+	Nonce string `json:"nonce"` // Base64 AEAD nonce
+}
+
+// EncryptionKey is an X25519 key pair an agent publishes so others can send
+// it bodies the broker cannot read. It is separate from the Ed25519 signing key.
+type EncryptionKey struct {
+	private *ecdh.PrivateKey
+}
+
+// GenerateEncryptionKey generates a new X25519 encryption key
+func GenerateEncryptionKey() (*EncryptionKey, error) {
+	private, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate encryption key: %w", err)
+	}
+	return &EncryptionKey{private: private}, nil
+}
+
+// DecodeEncryptionKey decodes a base64 X25519 private key
+func DecodeEncryptionKey(encoded string) (*EncryptionKey, error) {
+	data, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, fmt.Errorf("invalid encryption key encoding: %w", err)
+	}
+	private, err := ecdh.X25519().NewPrivateKey(data)
+	if err != nil {
+		return nil, fmt.Errorf("invalid encryption key: %w", err)
+	}
+	return &EncryptionKey{private: private}, nil
+}
+
+// Encode encodes the private key to base64
+func (k *EncryptionKey) Encode() string {
+	return base64.StdEncoding.EncodeToString(k.private.Bytes())
+}
+
+// PublicKey returns the base64 public key senders encrypt to
+func (k *EncryptionKey) PublicKey() string {
+	return base64.StdEncoding.EncodeToString(k.private.PublicKey().Bytes())
+}
+
+// KeyID returns the ID senders put in the kid header
+func (k *EncryptionKey) KeyID() string {
+	return encryptionKeyID(k.private.PublicKey())
+}
+
+// EncryptionKeyID returns the key ID of a base64 X25519 public key
+func EncryptionKeyID(publicKey string) (string, error) {
+	key, err := decodeEncryptionPublicKey(publicKey)
+	if err != nil {
+		return "", err
+	}
+	return encryptionKeyID(key), nil
+}
+
+func encryptionKeyID(key *ecdh.PublicKey) string {
+	sum := sha256.Sum256(key.Bytes())
+	return hex.EncodeToString(sum[:8])
+}
+
+func decodeEncryptionPublicKey(encoded string) (*ecdh.PublicKey, error) {
+	data, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, fmt.Errorf("invalid encryption public key encoding: %w", err)
+	}
+	key, err := ecdh.X25519().NewPublicKey(data)
+	if err != nil {
+		return nil, fmt.Errorf("invalid encryption public key: %w", err)
+	}
+	return key, nil
+}
+
+// Encrypt replaces the body with a ciphertext only the holder of recipientKey
+// can open. clearFields are top-level body members copied outside the
+// ciphertext, e.g. tool and requestId, so the broker can still route the
+// envelope. Sign the envelope after encrypting it.
+func (e *Envelope) Encrypt(recipientKey string, clearFields ...string) error {
+	return encryptBody(e.Type, &e.CommonHeaders, &e.Body, recipientKey, clearFields)
+}
+
+// EncryptTyped encodes a typed envelope and encrypts its body, returning the
+// envelope to sign and send
+func EncryptTyped[T EnvelopeBody](env *TypedEnvelope[T], recipientKey string, clearFields ...string) (*Envelope, error) {
+	body, err := json.Marshal(env.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode %s body: %w", env.Type, err)
+	}
+	encrypted := &Envelope{Type: env.Type, CommonHeaders: env.CommonHeaders, Body: body}
+	if err := encrypted.Encrypt(recipientKey, clearFields...); err != nil {
+		return nil, err
+	}
+	return encrypted, nil
+}
+
+// Encrypted reports whether the envelope body is encrypted
+func (g *GenericEnvelope) Encrypted() bool {
+	return g.Enc != nil
+}
+
+// Decrypt restores an encrypted body in place using whichever of keys the
+// sender encrypted to. Verify the signature first: it covers the ciphertext,
+// not the decrypted body. Envelopes that are not encrypted are left alone.
+func (g *GenericEnvelope) Decrypt(keys ...*EncryptionKey) error {
+	if g.Enc == nil {
+		return nil
+	}
+	body, err := decryptBody(g.Type, g.CommonHeaders, g.Body, keys)
+	if err != nil {
+		return err
+	}
+	g.Body = body
+	g.Enc = nil
+	return nil
+}
+
+// ParseClear decodes the members an encrypted envelope carries in the clear,
+// for intermediaries that route envelopes without reading them
+func ParseClear[T EnvelopeBody](g *GenericEnvelope) (*TypedEnvelope[T], error) {
+	clear := *g
+	clear.Enc = nil
+	return ParseTyped[T](&clear)
+}
+
+// bodyAAD binds a ciphertext to the envelope it was sent in
+func bodyAAD(envType EnvelopeType, agent, kid string) []byte {
+	return []byte(string(envType) + "\x00" + agent + "\x00" + kid)
+}
+
+// bodyCipher derives the AEAD for a shared secret between the ephemeral and recipient keys
+func bodyCipher(shared []byte, ephemeral, recipient *ecdh.PublicKey) (cipher.AEAD, error) {
+	salt := append(append([]byte{}, ephemeral.Bytes()...), recipient.Bytes()...)
+	key := make([]byte, chacha20poly1305.KeySize)
+	if _, err := io.ReadFull(hkdf.New(sha256.New, shared, salt, []byte(bodyEncryptionInfo)), key); err != nil {
+		return nil, fmt.Errorf("failed to derive body key: %w", err)
+	}
+	return chacha20poly1305.New(key)
+}
+
+func encryptBody(envType EnvelopeType, headers *CommonHeaders, body *json.RawMessage, recipientKey string, clearFields []string) error {
+	if headers.Enc != nil {
+		return fmt.Errorf("envelope body is already encrypted")
+	}
+	if headers.Agent == "" {
+		return fmt.Errorf("%w: agent", ErrMissingField)
+	}
+	var members map[string]json.RawMessage
+	if err := json.Unmarshal(*body, &members); err != nil {
+		return fmt.Errorf("only object bodies can be encrypted: %w", err)
+	}
+	if _, exists := members[CiphertextField]; exists {
+		return fmt.Errorf("body already has a %s member", CiphertextField)
+	}
+	recipient, err := decodeEncryptionPublicKey(recipientKey)
+	if err != nil {
+		return err
+	}
+
+	ephemeral, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		return fmt.Errorf("failed to generate ephemeral key: %w", err)
+	}
+	shared, err := ephemeral.ECDH(recipient)
+	if err != nil {
+		return fmt.Errorf("key agreement failed: %w", err)
+	}
+	aead, err := bodyCipher(shared, ephemeral.PublicKey(), recipient)
+	if err != nil {
+		return err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return fmt.Errorf("failed to generate nonce: %w", err)
+	}
+
+	kid := encryptionKeyID(recipient)
+	sealed := map[string]json.RawMessage{}
+	for _, field := range clearFields {
+		if value, exists := members[field]; exists {
+			sealed[field] = value
+		}
+	}
+	ciphertext := aead.Seal(nil, nonce, *body, bodyAAD(envType, headers.Agent, kid))
+	sealed[CiphertextField], _ = json.Marshal(base64.StdEncoding.EncodeToString(ciphertext))
+	encoded, err := json.Marshal(sealed)
+	if err != nil {
+		return fmt.Errorf("failed to encode encrypted body: %w", err)
+	}
+
+	*body = encoded
+	headers.Enc = &Encryption{
+		Alg:   BodyEncryptionAlg,
+		KID:   kid,
+		EPK:   base64.StdEncoding.EncodeToString(ephemeral.PublicKey().Bytes()),
+		Nonce: base64.StdEncoding.EncodeToString(nonce),
+	}
+	return nil
+}
+
+func decryptBody(envType EnvelopeType, headers CommonHeaders, body json.RawMessage, keys []*EncryptionKey) (json.RawMessage, error) {
+	enc := headers.Enc
+	if enc.Alg != BodyEncryptionAlg {
+		return nil, fmt.Errorf("unsupported body encryption %q", enc.Alg)
+	}
+	var key *EncryptionKey
+	for _, candidate := range keys {
+		if candidate != nil && candidate.KeyID() == enc.KID {
+			key = candidate
+			break
+		}
+	}
+	if key == nil {
+		return nil, fmt.Errorf("%w: kid %s", ErrNoDecryptionKey, enc.KID)
+	}
+
+	ephemeral, err := decodeEncryptionPublicKey(enc.EPK)
+	if err != nil {
+		return nil, err
+	}
+	nonce, err := base64.StdEncoding.DecodeString(enc.Nonce)
+	if err != nil {
+		return nil, fmt.Errorf("invalid encryption nonce: %w", err)
+	}
+	var members map[string]json.RawMessage
+	if err := json.Unmarshal(body, &members); err != nil {
+		return nil, fmt.Errorf("invalid encrypted body: %w", err)
+	}
+	var encoded string
+	if err := json.Unmarshal(members[CiphertextField], &encoded); err != nil {
+		return nil, fmt.Errorf("invalid encrypted body: missing %s", CiphertextField)
+	}
+	ciphertext, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, fmt.Errorf("invalid ciphertext encoding: %w", err)
+	}
+
+	shared, err := key.private.ECDH(ephemeral)
+	if err != nil {
+		return nil, fmt.Errorf("key agreement failed: %w", err)
+	}
+	aead, err := bodyCipher(shared, ephemeral, key.private.PublicKey())
+	if err != nil {
+		return nil, err
+	}
+	if len(nonce) != aead.NonceSize() {
+		return nil, fmt.Errorf("invalid encryption nonce size: %d", len(nonce))
+	}
+	plaintext, err := aead.Open(nil, nonce, ciphertext, bodyAAD(envType, headers.Agent, enc.KID))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt body: %w", err)
+	}
+
+	// Members in the clear must match the sealed body so nobody on the path can
+	// change what the broker routed on
+	var opened map[string]json.RawMessage
+	if err := json.Unmarshal(plaintext, &opened); err != nil {
+		return nil, fmt.Errorf("invalid decrypted body: %w", err)
+	}
+	for field, value := range members {
+		if field == CiphertextField {
+			continue
+		}
+		if !sameJSON(value, opened[field]) {
+			return nil, fmt.Errorf("clear body member %s does not match the encrypted body", field)
+		}
+	}
+	return plaintext, nil
+}
+
+// sameJSON reports whether two JSON values are equal once canonicalized
+func sameJSON(a, b json.RawMessage) bool {
+	if len(a) == 0 || len(b) == 0 {
+		return len(a) == len(b)
+	}
+	ca, errA := Canonicalize(a)
+	cb, errB := Canonicalize(b)
+	return errA == nil && errB == nil && bytes.Equal(ca, cb)
+}
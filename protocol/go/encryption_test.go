@@ -0,0 +1,106 @@
+package protocol
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func encryptedToolCall(t *testing.T, recipient *EncryptionKey) (*GenericEnvelope, []byte) {
+	t.Helper()
+	pubKey, privKey, _ := GenerateKeyPair()
+	call := NewTypedEnvelope("client", ToolCallBody{
+		Tool:       "vault.read",
+		RequestID:  "req-1",
+		Parameters: map[string]interface{}{"token": "s3cret"},
+	})
+	envelope, err := EncryptTyped(call, recipient.PublicKey(), "tool", "requestId")
+	if err != nil {
+		t.Fatalf("EncryptTyped failed: %v", err)
+	}
+	if err := envelope.Sign(privKey); err != nil {
+		t.Fatalf("Sign failed: %v", err)
+	}
+	data, _ := json.Marshal(envelope)
+	if strings.Contains(string(data), "s3cret") {
+		t.Fatal("Encrypted envelope should not contain the parameters")
+	}
+	generic, err := ParseEnvelope(data)
+	if err != nil {
+		t.Fatalf("Encrypted envelope should pass schema validation: %v", err)
+	}
+	if err := generic.Verify(pubKey); err != nil {
+		t.Fatalf("Signature should cover the ciphertext: %v", err)
+	}
+	return generic, data
+}
+
+func TestEncryptedBodyRoundTrip(t *testing.T) {
+	recipient, _ := GenerateEncryptionKey()
+	other, _ := GenerateEncryptionKey()
+	envelope, data := encryptedToolCall(t, recipient)
+
+	if envelope.Enc.KID != recipient.KeyID() || envelope.Enc.Alg != BodyEncryptionAlg {
+		t.Errorf("Unexpected encryption header: %+v", envelope.Enc)
+	}
+	if _, err := ParseTyped[ToolCallBody](envelope); !errors.Is(err, ErrEncryptedBody) {
+		t.Errorf("Parsing an encrypted body should fail, got %v", err)
+	}
+	clear, err := ParseClear[ToolCallBody](envelope)
+	if err != nil || clear.Body.Tool != "vault.read" || clear.Body.Parameters != nil {
+		t.Errorf("Only the clear members should be readable, got %+v %v", clear, err)
+	}
+
+	if err := envelope.Decrypt(other); !errors.Is(err, ErrNoDecryptionKey) {
+		t.Errorf("Decrypting with the wrong key should fail, got %v", err)
+	}
+	if err := envelope.Decrypt(other, recipient); err != nil {
+		t.Fatalf("Decrypt failed: %v", err)
+	}
+	call, err := ParseTyped[ToolCallBody](envelope)
+	if err != nil || call.Body.Parameters["token"] != "s3cret" {
+		t.Errorf("Decrypted body not restored: %+v %v", call, err)
+	}
+
+	// Changing a clear member breaks decryption
+	tampered, _ := ParseEnvelope(data)
+	tampered.Body = json.RawMessage(strings.Replace(string(tampered.Body), "vault.read", "vault.write", 1))
+	if err := tampered.Decrypt(recipient); err == nil {
+		t.Error("A rewritten clear member should be detected")
+	}
+	// The ciphertext is bound to the envelope type and sender
+	moved, _ := ParseEnvelope(data)
+	moved.Agent = "mallory"
+	if err := moved.Decrypt(recipient); err == nil {
+		t.Error("A ciphertext moved to another sender should not decrypt")
+	}
+}
+
+func TestEnvelopeMuxDecrypts(t *testing.T) {
+	recipient, _ := GenerateEncryptionKey()
+	envelope, _ := encryptedToolCall(t, recipient)
+
+	mux := NewEnvelopeMux()
+	var token interface{}
+	RegisterHandler(mux, func(ctx context.Context, env *TypedEnvelope[ToolCallBody]) error {
+		token = env.Body.Parameters["token"]
+		return nil
+	})
+	if err := mux.Dispatch(context.Background(), envelope); !errors.Is(err, ErrNoDecryptionKey) {
+		t.Errorf("Dispatch without keys should fail, got %v", err)
+	}
+
+	restored, err := DecodeEncryptionKey(recipient.Encode())
+	if err != nil {
+		t.Fatalf("DecodeEncryptionKey failed: %v", err)
+	}
+	mux.SetDecryptionKeys(restored)
+	if err := mux.Dispatch(context.Background(), envelope); err != nil || token != "s3cret" {
+		t.Errorf("Dispatch should decrypt transparently, got %v %v", token, err)
+	}
+	if !envelope.Encrypted() {
+		t.Error("Dispatch should not modify the caller's envelope")
+	}
+}
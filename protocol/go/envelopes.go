@@ -2,7 +2,6 @@ package protocol
 
 import (
 	"crypto/ed25519"
-	"encoding/base64"
 	"encoding/json"
 	"fmt"
 	"time"
@@ -18,19 +17,53 @@ const (
 	EnvelopeRenderInstruction  EnvelopeType = "renderInstruction"
 	EnvelopeToolCall           EnvelopeType = "toolCall"
 	EnvelopeToolResult         EnvelopeType = "toolResult"
+	EnvelopeToolResultChunk    EnvelopeType = "toolResultChunk"
 	EnvelopeRevoke             EnvelopeType = "revoke"
+	EnvelopeRotateKey          EnvelopeType = "rotateKey"
+	EnvelopeRevokeCapability   EnvelopeType = "revokeCapability"
 	// MCP Integration envelope types
 	EnvelopeDiscoverTools      EnvelopeType = "discoverTools"
 	EnvelopeToolsDiscovered    EnvelopeType = "toolsDiscovered"
 	EnvelopeEmbodimentUpdate   EnvelopeType = "embodimentUpdate"
+	// Liveness
+	EnvelopeHeartbeat          EnvelopeType = "heartbeat"
+	// Result delivery
+	EnvelopeResultAck          EnvelopeType = "resultAck"
+	// Event routing
+	EnvelopeSubscribe          EnvelopeType = "subscribe"
+	EnvelopeUnsubscribe        EnvelopeType = "unsubscribe"
+	// Bulk submission
+	EnvelopeBatch              EnvelopeType = "batch"
+	// Replicas
+	EnvelopeDrainInstance      EnvelopeType = "drainInstance"
+	// Spot capacity
+	EnvelopePreemptionNotice   EnvelopeType = "preemptionNotice"
+	EnvelopeCheckpoint         EnvelopeType = "checkpoint"
+	// Human approval
+	EnvelopeApproveCall        EnvelopeType = "approveCall"
+	EnvelopeAdminApproval      EnvelopeType = "adminApproval"
+	// Responses
+	EnvelopeAck                EnvelopeType = "ack"
+	EnvelopeError              EnvelopeType = "error"
 )
 
 // CommonHeaders contains headers present in all FEP envelopes
 type CommonHeaders struct {
-	Agent string `json:"agent"`           // UTF-8 agent identifier
-	TS    int64  `json:"ts"`              // Unix timestamp in milliseconds
-	Nonce string `json:"nonce"`           // Replay guard
-	Sig   string `json:"sig,omitempty"`   // Base64(Ed25519(body))
+	Agent  string      `json:"agent"`            // UTF-8 agent identifier
+	TS     int64       `json:"ts"`               // Unix timestamp in milliseconds
+	Nonce  string      `json:"nonce"`            // Replay guard
+	Seq    uint64      `json:"seq,omitempty"`    // Per-sender sequence number for ordered delivery; 0 if unordered
+	Proto  string      `json:"proto,omitempty"`  // Protocol version the sender speaks, e.g. "0.4.0"
+	Digest string      `json:"digest,omitempty"` // "<alg>=<base64>" body digest for detached signatures
+	KID    string      `json:"kid,omitempty"`    // Key ID of the signing key, see KeyID
+	Sig    string      `json:"sig,omitempty"`    // Base64(Ed25519(body))
+	Sigs   []Signature `json:"sigs,omitempty"`   // Additional co-signatures over the same bytes
+	Enc    *Encryption `json:"enc,omitempty"`    // Set when the body is encrypted for one recipient
+	// Third-party time evidence over the signing bytes
+	Timestamp *TimestampToken `json:"timestamp,omitempty"`
+	// Presentation hints for output meant for the sender
+	Locale string   `json:"locale,omitempty"` // BCP 47 language tag, e.g. "de-CH"
+	Accept []string `json:"accept,omitempty"` // Media types the sender can display, in preference order
 }
 
 // BaseEnvelope is the base structure for all FEP envelopes
@@ -53,6 +86,9 @@ type RegisterAgentBody struct {
 	MCPEndpoint     string                 `json:"mcpEndpoint,omitempty"`    // HTTP URL for MCP server
 	BodyDefinition  *BodyDefinition        `json:"bodyDefinition,omitempty"` // Environment-specific tool definitions
 	EnvironmentType string                 `json:"environmentType,omitempty"`// Environment type (e.g., "local", "cloud")
+	InstanceID      string                 `json:"instanceId,omitempty"`     // Replica of an agent whose instances share its ID and key
+	// Content encodings the agent reads compressed bodies in, in preference order
+	Compression []ContentEncoding `json:"compression,omitempty"`
 }
 
 // RegisterBrokerEnvelope registers a broker node
@@ -66,6 +102,19 @@ type RegisterBrokerBody struct {
 	Endpoint     string   `json:"endpoint"`      // TLS endpoint
 	PubKey       string   `json:"pubkey"`        // Base64 Ed25519 public key
 	Capabilities []string `json:"capabilities"`
+	// What the broker asks of agents and peers, as published to directories
+	Policy *BrokerPolicy `json:"policy,omitempty"`
+}
+
+// BrokerPolicy summarizes a broker's admission policy, so brokers found in a
+// directory can tell whether to peer before registering
+type BrokerPolicy struct {
+	MinProto           string   `json:"minProto,omitempty"`           // Oldest protocol version accepted
+	PeerTrustTier      string   `json:"peerTrustTier,omitempty"`      // Trust tier new peers are placed in
+	AnonymousDiscovery bool     `json:"anonymousDiscovery,omitempty"` // Discovery without a signed identity
+	CallCapabilities   bool     `json:"callCapabilities,omitempty"`   // Tool calls need capability tokens
+	MaxEnvelopeSize    int      `json:"maxEnvelopeSize,omitempty"`    // Largest envelope accepted, in bytes
+	DataResidency      []string `json:"dataResidency,omitempty"`      // Regions call data is kept in
 }
 
 // EmitEventEnvelope emits events from agents
@@ -75,8 +124,32 @@ type EmitEventEnvelope struct {
 }
 
 type EmitEventBody struct {
-	Event   string                 `json:"event"`
-	Payload map[string]interface{} `json:"payload"`
+	Event      string                 `json:"event"` // Topic the event is published to, e.g. "prod.ci.build.finished"
+	Payload    map[string]interface{} `json:"payload"`
+	Capability string                 `json:"capability,omitempty"` // Token granting publish rights on the topic
+}
+
+// SubscribeEnvelope declares an agent's interest in events on matching topics
+type SubscribeEnvelope struct {
+	BaseEnvelope
+	Body SubscribeBody `json:"body"`
+}
+
+type SubscribeBody struct {
+	Pattern    string `json:"pattern,omitempty"`    // Topic pattern, e.g. "prod.ci.*"
+	Capability string `json:"capability,omitempty"` // Token granting subscribe rights on the pattern
+	Results    bool   `json:"results,omitempty"`    // On an event stream, also send the results of the sender's calls
+}
+
+// UnsubscribeEnvelope withdraws a subscription
+type UnsubscribeEnvelope struct {
+	BaseEnvelope
+	Body UnsubscribeBody `json:"body"`
+}
+
+type UnsubscribeBody struct {
+	SubscriptionID string `json:"subscriptionId,omitempty"` // Subscription to remove
+	Pattern        string `json:"pattern,omitempty"`        // Or remove the sender's subscription to this pattern
 }
 
 // RenderInstructionEnvelope sends rendering instructions
@@ -86,8 +159,17 @@ type RenderInstructionEnvelope struct {
 }
 
 type RenderInstructionBody struct {
-	Instruction string                 `json:"instruction"`
-	Parameters  map[string]interface{} `json:"parameters,omitempty"`
+	Instruction  string                 `json:"instruction"`
+	Parameters   map[string]interface{} `json:"parameters,omitempty"`
+	Locale       string                 `json:"locale,omitempty"`       // Overrides the header locale for this output
+	Presentation *PresentationHints     `json:"presentation,omitempty"` // What the target display can show
+}
+
+// PresentationHints restrict the format of rendered output
+type PresentationHints struct {
+	Formats  []string `json:"formats,omitempty"`  // Acceptable media types in preference order, e.g. "text/markdown"
+	MaxWidth int      `json:"maxWidth,omitempty"` // Display width in characters
+	NoColor  bool     `json:"noColor,omitempty"`  // Display cannot show color
 }
 
 // ToolCallEnvelope requests tool execution
@@ -100,6 +182,17 @@ type ToolCallBody struct {
 	Tool       string                 `json:"tool"`
 	Parameters map[string]interface{} `json:"parameters"`
 	RequestID  string                 `json:"requestId"`
+	Capability string                 `json:"capability,omitempty"` // Capability token authorizing the call
+	Delivery   DeliveryGuarantee      `json:"delivery,omitempty"`   // How the result is delivered to the caller; at-most-once if empty
+	DataRegion string                 `json:"dataRegion,omitempty"` // Region the call's data must stay in, e.g. "eu"
+	// How the result comes back: sync, poll, webhook or event; polled if nil
+	ResultDelivery *ResultDelivery `json:"resultDelivery,omitempty"`
+	// Unix milliseconds by which the caller needs the result; 0 for none
+	Deadline int64 `json:"deadline,omitempty"`
+	// At the deadline, take the output streamed so far as a partial result rather than an error
+	AcceptPartial bool `json:"acceptPartial,omitempty"`
+	// Media ranges the caller takes the result in, in preference order, e.g. ["image/webp", "image/*"]
+	Accept []string `json:"accept,omitempty"`
 }
 
 // ToolResultEnvelope returns tool execution results
@@ -113,6 +206,28 @@ type ToolResultBody struct {
 	Success   bool                   `json:"success"`
 	Result    interface{}            `json:"result,omitempty"`
 	Error     string                 `json:"error,omitempty"`
+	Partial   bool                   `json:"partial,omitempty"` // The call did not finish; the result holds the output so far
+	// Media type of the result; application/json if empty. Binary and image results are base64 strings.
+	ContentType string `json:"contentType,omitempty"`
+}
+
+// ToolResultChunkEnvelope streams part of a tool's output before it finishes
+type ToolResultChunkEnvelope struct {
+	BaseEnvelope
+	Body ToolResultChunkBody `json:"body"`
+}
+
+// ToolResultChunkBody is one piece of a streamed result. Chunks are numbered
+// from 1; the chunk with Final set ends the stream and reports the outcome.
+type ToolResultChunkBody struct {
+	RequestID string      `json:"requestId"`
+	Seq       uint64      `json:"seq"`                // Position in the stream, starting at 1
+	Stream    string      `json:"stream,omitempty"`   // Output channel, e.g. "stdout" or "stderr"
+	Data      string      `json:"data,omitempty"`     // Output produced since the previous chunk
+	Final     bool        `json:"final,omitempty"`    // Set on the last chunk
+	Success   bool        `json:"success,omitempty"`  // Outcome, on the final chunk
+	Result    interface{} `json:"result,omitempty"`   // Structured result, on the final chunk
+	Error     string      `json:"error,omitempty"`    // Failure message, on the final chunk
 }
 
 // RevokeEnvelope revokes registrations/capabilities
@@ -126,6 +241,30 @@ type RevokeBody struct {
 	Reason string `json:"reason,omitempty"`
 }
 
+// RotateKeyEnvelope replaces an agent's signing key. It is signed by the
+// current key and co-signed by the new one to prove possession.
+type RotateKeyEnvelope struct {
+	BaseEnvelope
+	Body RotateKeyBody `json:"body"`
+}
+
+type RotateKeyBody struct {
+	NewPubKey string `json:"newPubkey"`        // Base64 Ed25519 public key replacing the current one
+	Reason    string `json:"reason,omitempty"` // e.g. "scheduled" or "compromised"
+}
+
+// RevokeCapabilityEnvelope revokes one capability token before it expires
+type RevokeCapabilityEnvelope struct {
+	BaseEnvelope
+	Body RevokeCapabilityBody `json:"body"`
+}
+
+type RevokeCapabilityBody struct {
+	TokenID    string `json:"jti"`                  // jti claim of the token to revoke
+	Capability string `json:"capability,omitempty"` // The token itself, proving the sender was issued or delegated it
+	Reason     string `json:"reason,omitempty"`
+}
+
 // MCP Integration envelope types
 
 // DiscoverToolsEnvelope requests MCP tool discovery
@@ -135,8 +274,9 @@ type DiscoverToolsEnvelope struct {
 }
 
 type DiscoverToolsBody struct {
-	Query     ToolQuery `json:"query"`
-	RequestID string    `json:"requestId"`
+	Query      ToolQuery `json:"query"`
+	RequestID  string    `json:"requestId"`
+	Capability string    `json:"capability,omitempty"` // Capability token scoping the results
 }
 
 type ToolQuery struct {
@@ -144,6 +284,16 @@ type ToolQuery struct {
 	EnvironmentType string   `json:"environmentType,omitempty"`
 	MaxResults      int      `json:"maxResults,omitempty"`
 	IncludeMetadata bool     `json:"includeMetadata,omitempty"`
+	// Only tools of agents with matching hardware, see HardwareFilter.Matches
+	Hardware *HardwareFilter `json:"hardware,omitempty"`
+}
+
+// HardwareFilter selects agents by the GPUs they advertise
+type HardwareFilter struct {
+	GPUModel       string `json:"gpuModel,omitempty"`       // Case-insensitive substring of the model, e.g. "a100"
+	MinGPUs        int    `json:"minGpus,omitempty"`        // GPUs that must match; 1 if zero and another GPU filter is set
+	MinVRAMMB      int64  `json:"minVramMb,omitempty"`      // Per GPU
+	MinCUDAVersion string `json:"minCudaVersion,omitempty"` // e.g. "12.0"
 }
 
 // ToolsDiscoveredEnvelope returns discovered MCP tools
@@ -157,6 +307,7 @@ type ToolsDiscoveredBody struct {
 	Tools        []DiscoveredTool `json:"tools"`
 	TotalResults int              `json:"totalResults"`
 	HasMore      bool             `json:"hasMore"`
+	Partial      bool             `json:"partial,omitempty"` // Results may be incomplete, e.g. while the broker restores state after a restart
 }
 
 type DiscoveredTool struct {
@@ -166,18 +317,55 @@ type DiscoveredTool struct {
 	EnvironmentType string       `json:"environmentType"`
 	MCPTools        []MCPTool    `json:"mcpTools"`
 	Metadata        ToolMetadata `json:"metadata,omitempty"`
+	// Hardware the agent advertised in its body definition
+	Hardware *HardwareCapabilities `json:"hardware,omitempty"`
 }
 
 type MCPTool struct {
 	Name        string                 `json:"name"`
 	Description string                 `json:"description"`
 	InputSchema map[string]interface{} `json:"inputSchema"`
+	// Regions the tool keeps call data in, overriding the body definition's
+	DataResidency []string `json:"dataResidency,omitempty"`
+	// Personal data classes the tool is cleared to exchange, e.g. ["email"]
+	PII []string `json:"pii,omitempty"`
+	// Resources one call needs; calls are only routed to agents reporting that much free
+	Resources *Resources `json:"resources,omitempty"`
+	// Price of one call; tools without one cost nothing, as local agents usually do
+	Cost *ToolCost `json:"cost,omitempty"`
+	// Whether the agent can resume calls of the tool from another agent's checkpoint
+	Resumable bool `json:"resumable,omitempty"`
+	// Media types the tool can return its result as, in preference order; application/json if empty
+	ResultTypes []string `json:"resultTypes,omitempty"`
+	// Whether calls wait for an approver's signed approval before they are delivered
+	Dangerous bool `json:"dangerous,omitempty"`
+	// Whether the broker may publish the tool in its public catalog feed
+	Listed bool `json:"listed,omitempty"`
+}
+
+// ToolCost is what a tool charges, in the federation's billing currency
+type ToolCost struct {
+	PerCall float64 `json:"perCall"`
+}
+
+// Resources are amounts of compute, either needed by a tool call or free on an agent
+type Resources struct {
+	CPU         float64 `json:"cpu,omitempty"`         // Cores
+	MemoryMB    int64   `json:"memoryMb,omitempty"`    // Mebibytes of memory
+	GPUs        int     `json:"gpus,omitempty"`        // Whole GPUs
+	GPUMemoryMB int64   `json:"gpuMemoryMb,omitempty"` // Mebibytes of GPU memory, per GPU
 }
 
 type ToolMetadata struct {
 	LastSeen            int64   `json:"lastSeen"`
-	AverageResponseTime int     `json:"averageResponseTime"`
+	AverageResponseTime int     `json:"averageResponseTime"`          // Milliseconds
+	P95ResponseTime     int     `json:"p95ResponseTime,omitempty"`    // Milliseconds, over recent checks
 	TrustScore          float64 `json:"trustScore"`
+	SuccessRate         float64 `json:"successRate,omitempty"`        // Fraction of checks that succeeded
+	TotalInvocations    int64   `json:"totalInvocations,omitempty"`   // Calls routed to the agent
+	LastErrorAt         int64   `json:"lastErrorAt,omitempty"`        // Unix milliseconds
+	LastErrorMessage    string  `json:"lastErrorMessage,omitempty"`
+	Instances           int     `json:"instances,omitempty"`          // Live replicas behind the agent ID
 }
 
 // EmbodimentUpdateEnvelope notifies of environment changes
@@ -193,6 +381,212 @@ type EmbodimentUpdateBody struct {
 	UpdatedTools    []string       `json:"updatedTools"`
 }
 
+// HeartbeatEnvelope tells the broker an agent is alive and how busy it is
+type HeartbeatEnvelope struct {
+	BaseEnvelope
+	Body HeartbeatBody `json:"body"`
+}
+
+type HeartbeatBody struct {
+	Load     float64 `json:"load"`     // Fraction of capacity in use, 0 to 1
+	InFlight int     `json:"inFlight"` // Requests currently being processed
+	Uptime   int64   `json:"uptime"`   // Seconds since the agent started
+	// Replica reporting, for agents registered with an instance ID
+	InstanceID string `json:"instanceId,omitempty"`
+	// Capacity free for new calls, for admission of tools declaring resources
+	Available *Resources `json:"available,omitempty"`
+	// Fraction of GPU compute in use over the agent's GPUs, 0 to 1
+	GPUUtilization float64 `json:"gpuUtilization,omitempty"`
+}
+
+// ResultAckEnvelope acknowledges delivered results and collects pending ones
+type ResultAckEnvelope struct {
+	BaseEnvelope
+	Body ResultAckBody `json:"body"`
+}
+
+type ResultAckBody struct {
+	Acknowledged []string `json:"acknowledged,omitempty"` // Delivery keys of results and chunks received
+	MaxResults   int      `json:"maxResults,omitempty"`   // Limit on results returned; 0 returns all pending
+	Wait         int64    `json:"wait,omitempty"`         // Seconds the broker may hold the ack until a result is due; 0 answers at once
+}
+
+// ResultDeliveryBody is the result of a resultAck: tool results awaiting the caller
+type ResultDeliveryBody struct {
+	Results []DeliveredResult `json:"results"`
+}
+
+// DeliveredResult is a tool result held by the broker for a caller
+type DeliveredResult struct {
+	RequestID string            `json:"requestId"`
+	Delivery  DeliveryGuarantee `json:"delivery"`
+	Chunk     uint64            `json:"chunk,omitempty"` // Seq of a toolResultChunk; 0 for a complete result
+	Attempt   int               `json:"attempt"`         // 1 on first delivery; higher on redelivery
+	Envelope  json.RawMessage   `json:"envelope"`        // The envelope as signed by the answering agent
+}
+
+// Key identifies the delivery for acknowledgement and deduplication: the
+// request ID for a result, or "<requestId>#<chunk>" for a chunk
+func (r DeliveredResult) Key() string {
+	return ResultKey(r.RequestID, r.Chunk)
+}
+
+// ResultKey returns the delivery key of a result (chunk 0) or chunk
+func ResultKey(requestID string, chunk uint64) string {
+	if chunk == 0 {
+		return requestID
+	}
+	return fmt.Sprintf("%s#%d", requestID, chunk)
+}
+
+// MaxBatchSize is the most envelopes a batch may carry
+const MaxBatchSize = 100
+
+// BatchEnvelope carries several signed envelopes in one request
+type BatchEnvelope struct {
+	BaseEnvelope
+	Body BatchBody `json:"body"`
+}
+
+type BatchBody struct {
+	Envelopes []json.RawMessage `json:"envelopes"` // Complete signed envelopes, processed in order
+}
+
+// BatchResultBody is the result of a batch: the outcome of each envelope
+type BatchResultBody struct {
+	Results   []BatchItemResult `json:"results"`
+	Succeeded int               `json:"succeeded"`
+	Failed    int               `json:"failed"`
+}
+
+// BatchItemResult is the response to one envelope of a batch
+type BatchItemResult struct {
+	Index    int             `json:"index"`           // Position of the envelope in the batch
+	Type     EnvelopeType    `json:"type,omitempty"`  // Empty if the envelope could not be parsed
+	Nonce    string          `json:"nonce,omitempty"`
+	Status   int             `json:"status"`   // HTTP status the envelope would have received on its own
+	Response json.RawMessage `json:"response"` // The ack or error envelope answering it
+}
+
+// Succeeded reports whether the envelope was acknowledged
+func (r BatchItemResult) Succeeded() bool {
+	return r.Status >= 200 && r.Status < 300
+}
+
+// DrainInstanceEnvelope takes one replica of an agent out of rotation
+type DrainInstanceEnvelope struct {
+	BaseEnvelope
+	Body DrainInstanceBody `json:"body"`
+}
+
+type DrainInstanceBody struct {
+	InstanceID string `json:"instanceId"`
+	Wait       int64  `json:"wait,omitempty"` // Seconds the broker may hold the ack until the instance is drained; 0 answers at once
+}
+
+// DrainStatusBody is the result of a drainInstance: whether the instance may be stopped
+type DrainStatusBody struct {
+	InstanceID string `json:"instanceId"`
+	InFlight   int    `json:"inFlight"` // Requests in flight in the instance's latest heartbeat
+	Drained    bool   `json:"drained"`  // A heartbeat since draining began reported nothing in flight
+}
+
+// PreemptionNoticeEnvelope announces that an agent, or one of its replicas,
+// is about to be reclaimed, as spot and preemptible machines are
+type PreemptionNoticeEnvelope struct {
+	BaseEnvelope
+	Body PreemptionNoticeBody `json:"body"`
+}
+
+type PreemptionNoticeBody struct {
+	InstanceID string `json:"instanceId,omitempty"` // Replica being reclaimed; empty for the whole agent
+	Deadline   int64  `json:"deadline,omitempty"`   // Unix milliseconds at which the agent stops; 0 if unknown
+}
+
+// CheckpointEnvelope hands a call the sender cannot finish to an agent that
+// resumes it from the saved state
+type CheckpointEnvelope struct {
+	BaseEnvelope
+	Body CheckpointBody `json:"body"`
+}
+
+type CheckpointBody struct {
+	RequestID  string                 `json:"requestId"`
+	Tool       string                 `json:"tool"`
+	Parameters map[string]interface{} `json:"parameters,omitempty"` // Arguments of the original call
+	State      []byte                 `json:"state"`                // Opaque progress the resuming agent continues from
+}
+
+// ResumeBody assigns a checkpointed call to the agent that resumes it. It is
+// delivered in heartbeat acks; the agent answers the caller with a toolResult
+// for the same request ID.
+type ResumeBody struct {
+	RequestID  string                 `json:"requestId"`
+	Tool       string                 `json:"tool"`
+	Parameters map[string]interface{} `json:"parameters,omitempty"`
+	State      []byte                 `json:"state"`
+	Artifact   string                 `json:"artifact"` // Digest the state is stored under
+	From       string                 `json:"from"`     // Agent that checkpointed the call
+}
+
+// ApproveCallEnvelope carries an approver's decision on a call to a
+// dangerous tool the broker parked
+type ApproveCallEnvelope struct {
+	BaseEnvelope
+	Body ApproveCallBody `json:"body"`
+}
+
+type ApproveCallBody struct {
+	RequestID string `json:"requestId"`
+	Approved  bool   `json:"approved"`         // False rejects the call
+	Reason    string `json:"reason,omitempty"` // Passed to the caller when the call is rejected
+}
+
+// PendingApprovalBody describes a call parked until an approver decides on
+// it. Approvers are notified with it and callers get it in the ack.
+type PendingApprovalBody struct {
+	RequestID  string                 `json:"requestId"`
+	Caller     string                 `json:"caller"`
+	Tool       string                 `json:"tool"`
+	Parameters map[string]interface{} `json:"parameters,omitempty"` // Absent from encrypted calls
+	Expires    int64                  `json:"expires"`              // Unix milliseconds at which the call is rejected undecided
+}
+
+// AdminApprovalBody authorizes one admin API request under the two-person
+// rule. Admins co-sign it and the broker checks it names the request made.
+type AdminApprovalBody struct {
+	Operation string `json:"operation"`        // Operation class the request falls in, e.g. "policy"
+	Method    string `json:"method"`           // HTTP method of the request
+	Path      string `json:"path"`             // Request path and query, e.g. /admin/peers?id=broker-b
+	Digest    string `json:"digest,omitempty"` // BodyDigest of the request body; empty for requests without one
+	Reason    string `json:"reason,omitempty"`
+}
+
+// AckEnvelope acknowledges that an envelope was processed
+type AckEnvelope struct {
+	BaseEnvelope
+	Body AckBody `json:"body"`
+}
+
+type AckBody struct {
+	Ref    string      `json:"ref,omitempty"`    // Nonce of the acknowledged envelope
+	Status string      `json:"status"`           // Outcome, e.g. "registered"
+	Result interface{} `json:"result,omitempty"` // Handler-specific response data
+}
+
+// ErrorEnvelope reports that an envelope was rejected
+type ErrorEnvelope struct {
+	BaseEnvelope
+	Body ErrorBody `json:"body"`
+}
+
+type ErrorBody struct {
+	Ref     string                 `json:"ref,omitempty"` // Nonce of the rejected envelope
+	Code    ErrorCode              `json:"code"`
+	Message string                 `json:"message"`
+	Details map[string]interface{} `json:"details,omitempty"`
+}
+
 type BodyDefinition struct {
 	Name         string                 `json:"name"`
 	Environment  string                 `json:"environment"`
@@ -200,6 +594,22 @@ type BodyDefinition struct {
 	MCPTools     []MCPTool             `json:"mcpTools"`
 	Constraints  map[string]interface{} `json:"constraints,omitempty"`
 	Metadata     map[string]interface{} `json:"metadata,omitempty"`
+	// Regions the agent keeps call data in, e.g. ["eu-de"]
+	DataResidency []string `json:"dataResidency,omitempty"`
+	// Hardware the agent runs tools on, matched by discovery hardware filters
+	Hardware *HardwareCapabilities `json:"hardware,omitempty"`
+}
+
+// HardwareCapabilities describes the accelerators an agent offers
+type HardwareCapabilities struct {
+	GPUs []GPUDevice `json:"gpus,omitempty"`
+}
+
+// GPUDevice is one GPU an agent can run tools on
+type GPUDevice struct {
+	Model       string `json:"model"`                 // e.g. "NVIDIA A100-SXM4-80GB"
+	VRAMMB      int64  `json:"vramMb"`                // Mebibytes of memory on the device
+	CUDAVersion string `json:"cudaVersion,omitempty"` // Highest CUDA version the driver supports, e.g. "12.2"
 }
 
 // Envelope is a generic envelope that can hold any envelope type
@@ -210,138 +620,92 @@ type Envelope struct {
 }
 
 // Sign signs the envelope with the given private key
-func (e *Envelope) Sign(privateKey ed25519.PrivateKey) error {
-	// Remove existing signature
-	e.Sig = ""
-	
-	// Marshal the envelope without signature
-	data, err := json.Marshal(e)
-	if err != nil {
-		return err
-	}
-	
-	// Sign the data
-	signature := ed25519.Sign(privateKey, data)
-	e.Sig = base64.StdEncoding.EncodeToString(signature)
-	
-	return nil
+func (e *Envelope) Sign(signer Signer) error {
+	return signEnvelope(e.Type, &e.CommonHeaders, e.Body, signer)
 }
 
 // Sign methods for specific envelope types
-func (e *RegisterAgentEnvelope) Sign(privateKey ed25519.PrivateKey) error {
-	// Remove existing signature
-	e.Sig = ""
-	
-	// Marshal the envelope without signature
-	data, err := json.Marshal(e)
-	if err != nil {
-		return err
-	}
-	
-	// Sign the data
-	signature := ed25519.Sign(privateKey, data)
-	e.Sig = base64.StdEncoding.EncodeToString(signature)
-	
-	return nil
-}
-
-func (e *RegisterBrokerEnvelope) Sign(privateKey ed25519.PrivateKey) error {
-	e.Sig = ""
-	data, err := json.Marshal(e)
-	if err != nil {
-		return err
-	}
-	signature := ed25519.Sign(privateKey, data)
-	e.Sig = base64.StdEncoding.EncodeToString(signature)
-	return nil
+func (e *RegisterAgentEnvelope) Sign(signer Signer) error {
+	return signEnvelope(e.Type, &e.CommonHeaders, e.Body, signer)
 }
 
-func (e *ToolCallEnvelope) Sign(privateKey ed25519.PrivateKey) error {
-	e.Sig = ""
-	data, err := json.Marshal(e)
-	if err != nil {
-		return err
-	}
-	signature := ed25519.Sign(privateKey, data)
-	e.Sig = base64.StdEncoding.EncodeToString(signature)
-	return nil
+func (e *RegisterBrokerEnvelope) Sign(signer Signer) error {
+	return signEnvelope(e.Type, &e.CommonHeaders, e.Body, signer)
 }
 
-func (e *ToolResultEnvelope) Sign(privateKey ed25519.PrivateKey) error {
-	e.Sig = ""
-	data, err := json.Marshal(e)
-	if err != nil {
-		return err
-	}
-	signature := ed25519.Sign(privateKey, data)
-	e.Sig = base64.StdEncoding.EncodeToString(signature)
-	return nil
+func (e *SubscribeEnvelope) Sign(signer Signer) error {
+	return signEnvelope(e.Type, &e.CommonHeaders, e.Body, signer)
+}
+
+func (e *UnsubscribeEnvelope) Sign(signer Signer) error {
+	return signEnvelope(e.Type, &e.CommonHeaders, e.Body, signer)
+}
+
+func (e *ToolCallEnvelope) Sign(signer Signer) error {
+	return signEnvelope(e.Type, &e.CommonHeaders, e.Body, signer)
+}
+
+func (e *ToolResultEnvelope) Sign(signer Signer) error {
+	return signEnvelope(e.Type, &e.CommonHeaders, e.Body, signer)
+}
+
+func (e *ToolResultChunkEnvelope) Sign(signer Signer) error {
+	return signEnvelope(e.Type, &e.CommonHeaders, e.Body, signer)
 }
 
 // MCP Integration envelope signing methods
 
-func (e *DiscoverToolsEnvelope) Sign(privateKey ed25519.PrivateKey) error {
-	e.Sig = ""
-	data, err := json.Marshal(e)
-	if err != nil {
-		return err
-	}
-	signature := ed25519.Sign(privateKey, data)
-	e.Sig = base64.StdEncoding.EncodeToString(signature)
-	return nil
+func (e *DiscoverToolsEnvelope) Sign(signer Signer) error {
+	return signEnvelope(e.Type, &e.CommonHeaders, e.Body, signer)
 }
 
-func (e *ToolsDiscoveredEnvelope) Sign(privateKey ed25519.PrivateKey) error {
-	e.Sig = ""
-	data, err := json.Marshal(e)
-	if err != nil {
-		return err
-	}
-	signature := ed25519.Sign(privateKey, data)
-	e.Sig = base64.StdEncoding.EncodeToString(signature)
-	return nil
+func (e *ToolsDiscoveredEnvelope) Sign(signer Signer) error {
+	return signEnvelope(e.Type, &e.CommonHeaders, e.Body, signer)
 }
 
-func (e *EmbodimentUpdateEnvelope) Sign(privateKey ed25519.PrivateKey) error {
-	e.Sig = ""
-	data, err := json.Marshal(e)
-	if err != nil {
-		return err
-	}
-	signature := ed25519.Sign(privateKey, data)
-	e.Sig = base64.StdEncoding.EncodeToString(signature)
-	return nil
+func (e *EmbodimentUpdateEnvelope) Sign(signer Signer) error {
+	return signEnvelope(e.Type, &e.CommonHeaders, e.Body, signer)
+}
+
+func (e *HeartbeatEnvelope) Sign(signer Signer) error {
+	return signEnvelope(e.Type, &e.CommonHeaders, e.Body, signer)
+}
+
+func (e *ResultAckEnvelope) Sign(signer Signer) error {
+	return signEnvelope(e.Type, &e.CommonHeaders, e.Body, signer)
+}
+
+func (e *BatchEnvelope) Sign(signer Signer) error {
+	return signEnvelope(e.Type, &e.CommonHeaders, e.Body, signer)
+}
+
+func (e *DrainInstanceEnvelope) Sign(signer Signer) error {
+	return signEnvelope(e.Type, &e.CommonHeaders, e.Body, signer)
+}
+
+func (e *PreemptionNoticeEnvelope) Sign(signer Signer) error {
+	return signEnvelope(e.Type, &e.CommonHeaders, e.Body, signer)
+}
+
+func (e *CheckpointEnvelope) Sign(signer Signer) error {
+	return signEnvelope(e.Type, &e.CommonHeaders, e.Body, signer)
+}
+
+func (e *ApproveCallEnvelope) Sign(signer Signer) error {
+	return signEnvelope(e.Type, &e.CommonHeaders, e.Body, signer)
+}
+
+func (e *AckEnvelope) Sign(signer Signer) error {
+	return signEnvelope(e.Type, &e.CommonHeaders, e.Body, signer)
+}
+
+func (e *ErrorEnvelope) Sign(signer Signer) error {
+	return signEnvelope(e.Type, &e.CommonHeaders, e.Body, signer)
 }
 
 // Verify verifies the envelope signature with the given public key
 func (e *Envelope) Verify(publicKey ed25519.PublicKey) error {
-	if e.Sig == "" {
-		return fmt.Errorf("envelope has no signature")
-	}
-	
-	// Decode signature
-	signature, err := base64.StdEncoding.DecodeString(e.Sig)
-	if err != nil {
-		return fmt.Errorf("invalid signature encoding: %w", err)
-	}
-	
-	// Store and remove signature
-	sig := e.Sig
-	e.Sig = ""
-	defer func() { e.Sig = sig }()
-	
-	// Marshal envelope without signature
-	data, err := json.Marshal(e)
-	if err != nil {
-		return err
-	}
-	
-	// Verify signature
-	if !ed25519.Verify(publicKey, data, signature) {
-		return fmt.Errorf("signature verification failed")
-	}
-	
-	return nil
+	return verifyEnvelope(e.Type, e.CommonHeaders, e.Body, publicKey)
 }
 
 // NewEnvelope creates a new envelope with common headers
@@ -352,6 +716,7 @@ func NewEnvelope(envType EnvelopeType, agent string) *Envelope {
 			Agent: agent,
 			TS:    time.Now().UnixMilli(),
 			Nonce: generateNonce(),
+			Proto: ProtocolVersion,
 		},
 	}
 }
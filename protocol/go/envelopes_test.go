@@ -20,6 +20,9 @@ func TestEnvelopeTypes(t *testing.T) {
 		{"ToolCall", EnvelopeToolCall, "toolCall"},
 		{"ToolResult", EnvelopeToolResult, "toolResult"},
 		{"Revoke", EnvelopeRevoke, "revoke"},
+		{"Heartbeat", EnvelopeHeartbeat, "heartbeat"},
+		{"Ack", EnvelopeAck, "ack"},
+		{"Error", EnvelopeError, "error"},
 	}
 
 	for _, tt := range tests {
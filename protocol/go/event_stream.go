@@ -0,0 +1,97 @@
+package protocol
+
+import (
+	"bufio"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/url"
+	"strings"
+)
+
+// EventStreamPath is where clients open a Server-Sent Events stream of the
+// events and results their subscription matches
+const EventStreamPath = "/events"
+
+// SubscriptionParam is the query parameter carrying the signed subscribe
+// envelope of an event stream opened with GET, as EventSource sends no body
+const SubscriptionParam = "subscription"
+
+// EventStreamURL returns the URL of a broker's event stream authenticated by
+// a signed subscribe envelope, for clients such as EventSource that can only
+// open a stream with GET
+func EventStreamURL(brokerURL string, subscription interface{}) (string, error) {
+	data, err := json.Marshal(subscription)
+	if err != nil {
+		return "", err
+	}
+	target, err := url.Parse(brokerURL)
+	if err != nil {
+		return "", fmt.Errorf("invalid broker URL: %w", err)
+	}
+	target.Path = strings.TrimSuffix(target.Path, "/") + EventStreamPath
+	query := target.Query()
+	query.Set(SubscriptionParam, base64.RawURLEncoding.EncodeToString(data))
+	target.RawQuery = query.Encode()
+	return target.String(), nil
+}
+
+// DecodeSubscription returns the envelope JSON carried in a subscription
+// query parameter
+func DecodeSubscription(value string) ([]byte, error) {
+	data, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(value, "="))
+	if err != nil {
+		return nil, fmt.Errorf("invalid subscription encoding: %w", err)
+	}
+	return data, nil
+}
+
+// EventStreamReader reads the envelopes a broker sends on an event stream.
+// Each message's event name is the envelope type and its data the envelope.
+type EventStreamReader struct {
+	reader      *bufio.Reader
+	LastEventID string // ID of the last event read, sent as Last-Event-ID to resume after it
+}
+
+// NewEventStreamReader reads an event stream from r
+func NewEventStreamReader(r io.Reader) *EventStreamReader {
+	return &EventStreamReader{reader: bufio.NewReader(r)}
+}
+
+// Next returns the next envelope, skipping comments such as keepalives
+func (s *EventStreamReader) Next() (*Envelope, error) {
+	var data strings.Builder
+	for {
+		line, err := s.reader.ReadString('\n')
+		if err != nil && (line == "" || err != io.EOF) {
+			return nil, err
+		}
+		line = strings.TrimRight(line, "\r\n")
+
+		if line == "" {
+			if data.Len() == 0 {
+				continue
+			}
+			var envelope Envelope
+			if err := json.Unmarshal([]byte(data.String()), &envelope); err != nil {
+				return nil, fmt.Errorf("invalid envelope in event stream: %w", err)
+			}
+			return &envelope, nil
+		}
+		if strings.HasPrefix(line, ":") {
+			continue
+		}
+		field, value, _ := strings.Cut(line, ":")
+		value = strings.TrimPrefix(value, " ")
+		switch field {
+		case "data":
+			if data.Len() > 0 {
+				data.WriteByte('\n')
+			}
+			data.WriteString(value)
+		case "id":
+			s.LastEventID = value
+		}
+	}
+}
@@ -0,0 +1,64 @@
+package protocol
+
+import (
+	"io"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestEventStreamURL(t *testing.T) {
+	pubKey, privKey, _ := GenerateKeyPair()
+	subscription := NewTypedEnvelope("dashboard", SubscribeBody{Pattern: "prod.*", Results: true})
+	if err := subscription.Sign(privKey); err != nil {
+		t.Fatalf("Failed to sign subscription: %v", err)
+	}
+
+	streamURL, err := EventStreamURL("https://broker.example:4433/", subscription)
+	if err != nil {
+		t.Fatalf("EventStreamURL failed: %v", err)
+	}
+	parsed, _ := url.Parse(streamURL)
+	if parsed.Path != EventStreamPath {
+		t.Errorf("Expected the stream path, got %s", parsed.Path)
+	}
+	data, err := DecodeSubscription(parsed.Query().Get(SubscriptionParam))
+	if err != nil {
+		t.Fatalf("Subscription should decode: %v", err)
+	}
+	decoded, err := Parse[SubscribeBody](data)
+	if err != nil || decoded.Body.Pattern != "prod.*" || !decoded.Body.Results {
+		t.Fatalf("Expected the subscription back, got %+v, %v", decoded, err)
+	}
+	if err := decoded.Verify(pubKey); err != nil {
+		t.Errorf("Decoded subscription should verify: %v", err)
+	}
+
+	if _, err := DecodeSubscription("not base64!"); err == nil {
+		t.Error("Expected an invalid encoding to be refused")
+	}
+}
+
+func TestEventStreamReader(t *testing.T) {
+	stream := ": subscribed\n\n" +
+		"id: 7\nevent: emitEvent\ndata: {\"type\":\"emitEvent\",\"agent\":\"ci\",\"body\":{\"event\":\"prod.build\"}}\n\n" +
+		": keepalive\n\n" +
+		"event: toolResult\r\ndata: {\"type\":\"toolResult\",\r\ndata: \"agent\":\"worker\",\"body\":{}}\r\n\r\n" +
+		"data: {\"type\":\"emitEvent\""
+
+	reader := NewEventStreamReader(strings.NewReader(stream))
+	event, err := reader.Next()
+	if err != nil || event.Type != EnvelopeEmitEvent || event.Agent != "ci" || reader.LastEventID != "7" {
+		t.Fatalf("Expected the event, got %+v (id %q), %v", event, reader.LastEventID, err)
+	}
+	result, err := reader.Next()
+	if err != nil || result.Type != EnvelopeToolResult || result.Agent != "worker" {
+		t.Fatalf("Expected the result split over two data lines, got %+v, %v", result, err)
+	}
+	if reader.LastEventID != "7" {
+		t.Errorf("Messages without an id should keep the last one, got %q", reader.LastEventID)
+	}
+	if _, err := reader.Next(); err != io.EOF {
+		t.Errorf("An unterminated message should be dropped at EOF, got %v", err)
+	}
+}
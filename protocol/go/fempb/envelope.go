@@ -0,0 +1,110 @@
+// Package fempb carries FEP envelopes in protobuf form, for gRPC integrations
+// and agents in languages with protobuf bindings. The message types are
+// generated from the Go protocol package along with protocol/proto/fem.proto;
+// run "make proto" after changing envelope or body types.
+//
+// Signatures always cover the JSON form of an envelope. Converting an
+// envelope to protobuf and back re-encodes its body the way the protocol
+// package's body types encode it, so the signatures of envelopes signed from
+// typed bodies still verify. Protobuf cannot tell empty lists and maps from
+// missing ones; bodies signed with empty non-nil lists should be sent as
+// JSON.
+package fempb
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/fep-fem/protocol"
+)
+
+// FromEnvelope converts an envelope to protobuf. Bodies of envelope types
+// without a message, and encrypted bodies, are carried as JSON.
+func FromEnvelope(env *protocol.GenericEnvelope) (*Envelope, error) {
+	e := &Envelope{Type: string(env.Type), Headers: new(CommonHeaders)}
+	if err := convert(env.CommonHeaders, e.Headers); err != nil {
+		return nil, fmt.Errorf("fempb: headers: %w", err)
+	}
+	if env.Encrypted() {
+		e.JSONBody = env.Body
+		return e, nil
+	}
+	typed, err := e.setBody(env.Body)
+	if err != nil {
+		return nil, fmt.Errorf("fempb: %s body: %w", env.Type, err)
+	}
+	if !typed {
+		e.JSONBody = env.Body
+	}
+	return e, nil
+}
+
+// FromJSON parses a JSON envelope and converts it to protobuf
+func FromJSON(data []byte) (*Envelope, error) {
+	env, err := protocol.ParseEnvelope(data)
+	if err != nil {
+		return nil, err
+	}
+	return FromEnvelope(env)
+}
+
+// Generic converts the envelope back to the protocol package's form
+func (e *Envelope) Generic() (*protocol.GenericEnvelope, error) {
+	env := &protocol.GenericEnvelope{}
+	env.Type = protocol.EnvelopeType(e.Type)
+	if e.Headers != nil {
+		if err := convert(e.Headers, &env.CommonHeaders); err != nil {
+			return nil, fmt.Errorf("fempb: headers: %w", err)
+		}
+	}
+
+	body, typed, err := e.typedBody()
+	switch {
+	case err != nil:
+		return nil, fmt.Errorf("fempb: %s body: %w", e.Type, err)
+	case typed:
+		env.Body = body
+	case len(e.JSONBody) > 0:
+		env.Body = e.JSONBody
+	default:
+		return nil, fmt.Errorf("fempb: %s envelope has no body", e.Type)
+	}
+	return env, nil
+}
+
+// JSON returns the JSON encoding of the envelope
+func (e *Envelope) JSON() ([]byte, error) {
+	env, err := e.Generic()
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(env)
+}
+
+// fromJSON decodes a JSON body into m, by way of the protocol body type T
+// so the body is read as the broker reads it
+func fromJSON[T any](body json.RawMessage, m interface{}) error {
+	var typed T
+	if err := json.Unmarshal(body, &typed); err != nil {
+		return err
+	}
+	return convert(typed, m)
+}
+
+// toJSON encodes m as the protocol body type T encodes it
+func toJSON[T any](m interface{}) (json.RawMessage, error) {
+	var typed T
+	if err := convert(m, &typed); err != nil {
+		return nil, err
+	}
+	return json.Marshal(typed)
+}
+
+// convert copies from into to through their shared JSON form
+func convert(from, to interface{}) error {
+	data, err := json.Marshal(from)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, to)
+}
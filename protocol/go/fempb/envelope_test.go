@@ -0,0 +1,160 @@
+package fempb
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/fep-fem/protocol"
+)
+
+// throughProtobuf converts a JSON envelope to protobuf, encodes and decodes
+// it, and converts it back to JSON
+func throughProtobuf(t *testing.T, data []byte) (*Envelope, []byte) {
+	t.Helper()
+	envelope, err := FromJSON(data)
+	if err != nil {
+		t.Fatalf("Failed to convert envelope: %v", err)
+	}
+	var decoded Envelope
+	if err := decoded.UnmarshalProto(envelope.MarshalProto()); err != nil {
+		t.Fatalf("Failed to decode envelope: %v", err)
+	}
+	out, err := decoded.JSON()
+	if err != nil {
+		t.Fatalf("Failed to convert envelope back: %v", err)
+	}
+	return &decoded, out
+}
+
+func TestSignedToolCallSurvivesProtobuf(t *testing.T) {
+	pub, priv, _ := protocol.GenerateKeyPair()
+	call, err := protocol.NewToolCall("caller").
+		Tool("video.transcode").
+		Param("input", "s3://clips/7.mov").
+		Param("bitrates", []int{800, 1600}).
+		ResultDelivery(protocol.ResultDelivery{Mode: protocol.ResultDeliveryWebhook, URL: "https://ci.example/results"}).
+		Deadline(time.Now().Add(time.Minute), true).
+		SignWith(priv)
+	if err != nil {
+		t.Fatalf("Failed to build call: %v", err)
+	}
+	data, _ := json.Marshal(call)
+
+	decoded, out := throughProtobuf(t, data)
+	if decoded.ToolCall == nil || decoded.ToolCall.Tool != "video.transcode" || decoded.ToolCall.ResultDelivery.URL != "https://ci.example/results" {
+		t.Fatalf("Expected the body in the toolCall field, got %+v", decoded)
+	}
+	if len(decoded.JSONBody) != 0 {
+		t.Errorf("A typed body should not also be carried as JSON")
+	}
+
+	typed, err := protocol.Parse[protocol.ToolCallBody](out)
+	if err != nil {
+		t.Fatalf("Converted envelope should parse: %v", err)
+	}
+	if err := typed.Verify(pub); err != nil {
+		t.Errorf("Signature should survive the round trip: %v", err)
+	}
+	if !typed.Body.AcceptPartial || typed.Body.Deadline != call.Body.Deadline {
+		t.Errorf("Body fields lost: %+v", typed.Body)
+	}
+}
+
+func TestNumbersListsAndBytesSurviveProtobuf(t *testing.T) {
+	pub, priv, _ := protocol.GenerateKeyPair()
+	heartbeat := protocol.NewTypedEnvelope("gpu-1", protocol.HeartbeatBody{
+		Load:           0.625,
+		InFlight:       3,
+		Uptime:         86400,
+		GPUUtilization: 0.5,
+		Available:      &protocol.Resources{GPUs: 2, MemoryMB: 16384},
+	})
+	heartbeat.Sign(priv)
+	checkpoint := protocol.NewTypedEnvelope("spot", protocol.CheckpointBody{RequestID: "job-7", Tool: "video.transcode", State: []byte{0, 1, 254, 255}})
+	checkpoint.Sign(priv)
+
+	data, _ := json.Marshal(heartbeat)
+	decoded, out := throughProtobuf(t, data)
+	if decoded.Heartbeat.Load != 0.625 || decoded.Heartbeat.InFlight != 3 || decoded.Heartbeat.Available.GPUs != 2 {
+		t.Errorf("Heartbeat fields lost: %+v", decoded.Heartbeat)
+	}
+	if typed, err := protocol.Parse[protocol.HeartbeatBody](out); err != nil || typed.Verify(pub) != nil {
+		t.Errorf("Heartbeat should survive the round trip: %v", err)
+	}
+
+	data, _ = json.Marshal(checkpoint)
+	decoded, out = throughProtobuf(t, data)
+	if string(decoded.Checkpoint.State) != "\x00\x01\xfe\xff" {
+		t.Errorf("Checkpoint state should be carried as bytes, got %q", decoded.Checkpoint.State)
+	}
+	if typed, err := protocol.Parse[protocol.CheckpointBody](out); err != nil || typed.Verify(pub) != nil {
+		t.Errorf("Checkpoint should survive the round trip: %v", err)
+	}
+}
+
+func TestUnknownEnvelopeTypeCarriedAsJSON(t *testing.T) {
+	env := &protocol.GenericEnvelope{Body: json.RawMessage(`{"shape":"circle"}`)}
+	env.Type = "drawShape"
+	env.Agent = "artist"
+
+	envelope, err := FromEnvelope(env)
+	if err != nil {
+		t.Fatalf("Failed to convert envelope: %v", err)
+	}
+	if string(envelope.JSONBody) != `{"shape":"circle"}` {
+		t.Fatalf("Expected the body carried as JSON, got %q", envelope.JSONBody)
+	}
+	back, err := envelope.Generic()
+	if err != nil || string(back.Body) != `{"shape":"circle"}` || back.Agent != "artist" {
+		t.Errorf("Expected the envelope back unchanged, got %+v %v", back, err)
+	}
+}
+
+func TestUnmarshalSkipsUnknownFields(t *testing.T) {
+	body := &ToolResultChunkBody{RequestID: "req-1", Seq: 4, Data: "out"}
+	data := body.MarshalProto()
+	// Fields a newer sender might add
+	data = appendUvarint(data, 900, 7)
+	data = appendString(data, 901, "later")
+	data = appendFixed64(data, 902, 1)
+
+	var decoded ToolResultChunkBody
+	if err := decoded.UnmarshalProto(data); err != nil {
+		t.Fatalf("Unknown fields should be skipped: %v", err)
+	}
+	if decoded.RequestID != "req-1" || decoded.Seq != 4 || decoded.Data != "out" {
+		t.Errorf("Known fields lost: %+v", decoded)
+	}
+}
+
+func TestUnmarshalRejectsMalformedMessages(t *testing.T) {
+	data := (&ToolCallBody{Tool: "video.transcode"}).MarshalProto()
+	var decoded ToolCallBody
+	if err := decoded.UnmarshalProto(data[:len(data)-3]); !errors.Is(err, errTruncated) {
+		t.Errorf("Truncated message should fail, got %v", err)
+	}
+
+	// The tool field sent as a varint
+	if err := decoded.UnmarshalProto(appendUvarint(nil, 1, 5)); err == nil {
+		t.Error("Field with the wrong wire type should fail")
+	}
+}
+
+func TestPackedAndUnpackedListsDecode(t *testing.T) {
+	var packed, unpacked []byte
+	packed = appendBytes(packed, 1, appendVarint(appendVarint(nil, 3), 300))
+	unpacked = appendUvarint(appendUvarint(unpacked, 1, 3), 1, 300)
+
+	for name, data := range map[string][]byte{"packed": packed, "unpacked": unpacked} {
+		var values []uint64
+		d := decoder{b: data}
+		for _, typ, ok := d.next(); ok; _, typ, ok = d.next() {
+			d.varints(typ, func(v uint64) { values = append(values, v) })
+		}
+		if d.err != nil || len(values) != 2 || values[0] != 3 || values[1] != 300 {
+			t.Errorf("%s list decoded as %v, %v", name, values, d.err)
+		}
+	}
+}
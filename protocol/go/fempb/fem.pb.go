@@ -0,0 +1,3219 @@
+// Code generated by fem-protogen from the Go protocol package. DO NOT EDIT.
+// Run "make proto" after changing envelope or body types.
+
+package fempb
+
+import (
+	"encoding/json"
+	"math"
+
+	"github.com/fep-fem/protocol"
+)
+
+// Envelope is an FEP envelope. The body is set in the field of its type, or as JSON
+// for envelope types without a message and for encrypted bodies.
+type Envelope struct {
+	// Envelope type, e.g. "toolCall"
+	Type              string
+	Headers           *CommonHeaders
+	JSONBody          json.RawMessage
+	Ack               *AckBody
+	Batch             *BatchBody
+	Checkpoint        *CheckpointBody
+	DiscoverTools     *DiscoverToolsBody
+	DrainInstance     *DrainInstanceBody
+	EmbodimentUpdate  *EmbodimentUpdateBody
+	EmitEvent         *EmitEventBody
+	Error             *ErrorBody
+	Heartbeat         *HeartbeatBody
+	PreemptionNotice  *PreemptionNoticeBody
+	RegisterAgent     *RegisterAgentBody
+	RegisterBroker    *RegisterBrokerBody
+	RenderInstruction *RenderInstructionBody
+	ResultAck         *ResultAckBody
+	Revoke            *RevokeBody
+	RevokeCapability  *RevokeCapabilityBody
+	RotateKey         *RotateKeyBody
+	Subscribe         *SubscribeBody
+	ToolCall          *ToolCallBody
+	ToolResult        *ToolResultBody
+	ToolResultChunk   *ToolResultChunkBody
+	ToolsDiscovered   *ToolsDiscoveredBody
+	Unsubscribe       *UnsubscribeBody
+	ApproveCall       *ApproveCallBody
+	AdminApproval     *AdminApprovalBody
+}
+
+// MarshalProto returns the protobuf encoding of the message
+func (m *Envelope) MarshalProto() []byte {
+	if m == nil {
+		return nil
+	}
+	var b []byte
+	if m.Type != "" {
+		b = appendString(b, 1, m.Type)
+	}
+	if m.Headers != nil {
+		b = appendBytes(b, 2, m.Headers.MarshalProto())
+	}
+	if len(m.JSONBody) > 0 {
+		b = appendBytes(b, 3, m.JSONBody)
+	}
+	if m.Ack != nil {
+		b = appendBytes(b, 4, m.Ack.MarshalProto())
+	}
+	if m.Batch != nil {
+		b = appendBytes(b, 5, m.Batch.MarshalProto())
+	}
+	if m.Checkpoint != nil {
+		b = appendBytes(b, 6, m.Checkpoint.MarshalProto())
+	}
+	if m.DiscoverTools != nil {
+		b = appendBytes(b, 7, m.DiscoverTools.MarshalProto())
+	}
+	if m.DrainInstance != nil {
+		b = appendBytes(b, 8, m.DrainInstance.MarshalProto())
+	}
+	if m.EmbodimentUpdate != nil {
+		b = appendBytes(b, 9, m.EmbodimentUpdate.MarshalProto())
+	}
+	if m.EmitEvent != nil {
+		b = appendBytes(b, 10, m.EmitEvent.MarshalProto())
+	}
+	if m.Error != nil {
+		b = appendBytes(b, 11, m.Error.MarshalProto())
+	}
+	if m.Heartbeat != nil {
+		b = appendBytes(b, 12, m.Heartbeat.MarshalProto())
+	}
+	if m.PreemptionNotice != nil {
+		b = appendBytes(b, 13, m.PreemptionNotice.MarshalProto())
+	}
+	if m.RegisterAgent != nil {
+		b = appendBytes(b, 14, m.RegisterAgent.MarshalProto())
+	}
+	if m.RegisterBroker != nil {
+		b = appendBytes(b, 15, m.RegisterBroker.MarshalProto())
+	}
+	if m.RenderInstruction != nil {
+		b = appendBytes(b, 16, m.RenderInstruction.MarshalProto())
+	}
+	if m.ResultAck != nil {
+		b = appendBytes(b, 17, m.ResultAck.MarshalProto())
+	}
+	if m.Revoke != nil {
+		b = appendBytes(b, 18, m.Revoke.MarshalProto())
+	}
+	if m.RevokeCapability != nil {
+		b = appendBytes(b, 19, m.RevokeCapability.MarshalProto())
+	}
+	if m.RotateKey != nil {
+		b = appendBytes(b, 20, m.RotateKey.MarshalProto())
+	}
+	if m.Subscribe != nil {
+		b = appendBytes(b, 21, m.Subscribe.MarshalProto())
+	}
+	if m.ToolCall != nil {
+		b = appendBytes(b, 22, m.ToolCall.MarshalProto())
+	}
+	if m.ToolResult != nil {
+		b = appendBytes(b, 23, m.ToolResult.MarshalProto())
+	}
+	if m.ToolResultChunk != nil {
+		b = appendBytes(b, 24, m.ToolResultChunk.MarshalProto())
+	}
+	if m.ToolsDiscovered != nil {
+		b = appendBytes(b, 25, m.ToolsDiscovered.MarshalProto())
+	}
+	if m.Unsubscribe != nil {
+		b = appendBytes(b, 26, m.Unsubscribe.MarshalProto())
+	}
+	if m.ApproveCall != nil {
+		b = appendBytes(b, 27, m.ApproveCall.MarshalProto())
+	}
+	if m.AdminApproval != nil {
+		b = appendBytes(b, 28, m.AdminApproval.MarshalProto())
+	}
+	return b
+}
+
+// UnmarshalProto decodes the protobuf encoding of the message into m.
+// Fields m does not declare are skipped.
+func (m *Envelope) UnmarshalProto(data []byte) error {
+	d := decoder{b: data}
+	for num, typ, ok := d.next(); ok; num, typ, ok = d.next() {
+		switch num {
+		case 1:
+			m.Type = d.string(typ)
+		case 2:
+			if m.Headers == nil {
+				m.Headers = new(CommonHeaders)
+			}
+			d.message(typ, m.Headers)
+		case 3:
+			m.JSONBody = d.bytes(typ)
+		case 4:
+			if m.Ack == nil {
+				m.Ack = new(AckBody)
+			}
+			d.message(typ, m.Ack)
+		case 5:
+			if m.Batch == nil {
+				m.Batch = new(BatchBody)
+			}
+			d.message(typ, m.Batch)
+		case 6:
+			if m.Checkpoint == nil {
+				m.Checkpoint = new(CheckpointBody)
+			}
+			d.message(typ, m.Checkpoint)
+		case 7:
+			if m.DiscoverTools == nil {
+				m.DiscoverTools = new(DiscoverToolsBody)
+			}
+			d.message(typ, m.DiscoverTools)
+		case 8:
+			if m.DrainInstance == nil {
+				m.DrainInstance = new(DrainInstanceBody)
+			}
+			d.message(typ, m.DrainInstance)
+		case 9:
+			if m.EmbodimentUpdate == nil {
+				m.EmbodimentUpdate = new(EmbodimentUpdateBody)
+			}
+			d.message(typ, m.EmbodimentUpdate)
+		case 10:
+			if m.EmitEvent == nil {
+				m.EmitEvent = new(EmitEventBody)
+			}
+			d.message(typ, m.EmitEvent)
+		case 11:
+			if m.Error == nil {
+				m.Error = new(ErrorBody)
+			}
+			d.message(typ, m.Error)
+		case 12:
+			if m.Heartbeat == nil {
+				m.Heartbeat = new(HeartbeatBody)
+			}
+			d.message(typ, m.Heartbeat)
+		case 13:
+			if m.PreemptionNotice == nil {
+				m.PreemptionNotice = new(PreemptionNoticeBody)
+			}
+			d.message(typ, m.PreemptionNotice)
+		case 14:
+			if m.RegisterAgent == nil {
+				m.RegisterAgent = new(RegisterAgentBody)
+			}
+			d.message(typ, m.RegisterAgent)
+		case 15:
+			if m.RegisterBroker == nil {
+				m.RegisterBroker = new(RegisterBrokerBody)
+			}
+			d.message(typ, m.RegisterBroker)
+		case 16:
+			if m.RenderInstruction == nil {
+				m.RenderInstruction = new(RenderInstructionBody)
+			}
+			d.message(typ, m.RenderInstruction)
+		case 17:
+			if m.ResultAck == nil {
+				m.ResultAck = new(ResultAckBody)
+			}
+			d.message(typ, m.ResultAck)
+		case 18:
+			if m.Revoke == nil {
+				m.Revoke = new(RevokeBody)
+			}
+			d.message(typ, m.Revoke)
+		case 19:
+			if m.RevokeCapability == nil {
+				m.RevokeCapability = new(RevokeCapabilityBody)
+			}
+			d.message(typ, m.RevokeCapability)
+		case 20:
+			if m.RotateKey == nil {
+				m.RotateKey = new(RotateKeyBody)
+			}
+			d.message(typ, m.RotateKey)
+		case 21:
+			if m.Subscribe == nil {
+				m.Subscribe = new(SubscribeBody)
+			}
+			d.message(typ, m.Subscribe)
+		case 22:
+			if m.ToolCall == nil {
+				m.ToolCall = new(ToolCallBody)
+			}
+			d.message(typ, m.ToolCall)
+		case 23:
+			if m.ToolResult == nil {
+				m.ToolResult = new(ToolResultBody)
+			}
+			d.message(typ, m.ToolResult)
+		case 24:
+			if m.ToolResultChunk == nil {
+				m.ToolResultChunk = new(ToolResultChunkBody)
+			}
+			d.message(typ, m.ToolResultChunk)
+		case 25:
+			if m.ToolsDiscovered == nil {
+				m.ToolsDiscovered = new(ToolsDiscoveredBody)
+			}
+			d.message(typ, m.ToolsDiscovered)
+		case 26:
+			if m.Unsubscribe == nil {
+				m.Unsubscribe = new(UnsubscribeBody)
+			}
+			d.message(typ, m.Unsubscribe)
+		case 27:
+			if m.ApproveCall == nil {
+				m.ApproveCall = new(ApproveCallBody)
+			}
+			d.message(typ, m.ApproveCall)
+		case 28:
+			if m.AdminApproval == nil {
+				m.AdminApproval = new(AdminApprovalBody)
+			}
+			d.message(typ, m.AdminApproval)
+		default:
+			d.skip(typ)
+		}
+	}
+	return d.err
+}
+
+// CommonHeaders contains headers present in all FEP envelopes
+type CommonHeaders struct {
+	// UTF-8 agent identifier
+	Agent string `json:"agent,omitempty"`
+	// Unix timestamp in milliseconds
+	TS int64 `json:"ts,omitempty"`
+	// Replay guard
+	Nonce string `json:"nonce,omitempty"`
+	// Per-sender sequence number for ordered delivery; 0 if unordered
+	Seq uint64 `json:"seq,omitempty"`
+	// Protocol version the sender speaks, e.g. "0.4.0"
+	Proto string `json:"proto,omitempty"`
+	// "<alg>=<base64>" body digest for detached signatures
+	Digest string `json:"digest,omitempty"`
+	// Key ID of the signing key, see KeyID
+	KID string `json:"kid,omitempty"`
+	// Base64(Ed25519(body))
+	Sig string `json:"sig,omitempty"`
+	// Additional co-signatures over the same bytes
+	Sigs []*Signature `json:"sigs,omitempty"`
+	// Set when the body is encrypted for one recipient
+	Enc *Encryption `json:"enc,omitempty"`
+	// Third-party time evidence over the signing bytes
+	Timestamp *TimestampToken `json:"timestamp,omitempty"`
+	// Presentation hints for output meant for the sender
+	Locale string `json:"locale,omitempty"`
+	// Media types the sender can display, in preference order
+	Accept []string `json:"accept,omitempty"`
+}
+
+// MarshalProto returns the protobuf encoding of the message
+func (m *CommonHeaders) MarshalProto() []byte {
+	if m == nil {
+		return nil
+	}
+	var b []byte
+	if m.Agent != "" {
+		b = appendString(b, 1, m.Agent)
+	}
+	if m.TS != 0 {
+		b = appendUvarint(b, 2, uint64(m.TS))
+	}
+	if m.Nonce != "" {
+		b = appendString(b, 3, m.Nonce)
+	}
+	if m.Seq != 0 {
+		b = appendUvarint(b, 4, m.Seq)
+	}
+	if m.Proto != "" {
+		b = appendString(b, 5, m.Proto)
+	}
+	if m.Digest != "" {
+		b = appendString(b, 6, m.Digest)
+	}
+	if m.KID != "" {
+		b = appendString(b, 7, m.KID)
+	}
+	if m.Sig != "" {
+		b = appendString(b, 8, m.Sig)
+	}
+	for _, v := range m.Sigs {
+		b = appendBytes(b, 9, v.MarshalProto())
+	}
+	if m.Enc != nil {
+		b = appendBytes(b, 10, m.Enc.MarshalProto())
+	}
+	if m.Timestamp != nil {
+		b = appendBytes(b, 11, m.Timestamp.MarshalProto())
+	}
+	if m.Locale != "" {
+		b = appendString(b, 12, m.Locale)
+	}
+	for _, v := range m.Accept {
+		b = appendString(b, 13, v)
+	}
+	return b
+}
+
+// UnmarshalProto decodes the protobuf encoding of the message into m.
+// Fields m does not declare are skipped.
+func (m *CommonHeaders) UnmarshalProto(data []byte) error {
+	d := decoder{b: data}
+	for num, typ, ok := d.next(); ok; num, typ, ok = d.next() {
+		switch num {
+		case 1:
+			m.Agent = d.string(typ)
+		case 2:
+			m.TS = int64(d.varint(typ))
+		case 3:
+			m.Nonce = d.string(typ)
+		case 4:
+			m.Seq = d.varint(typ)
+		case 5:
+			m.Proto = d.string(typ)
+		case 6:
+			m.Digest = d.string(typ)
+		case 7:
+			m.KID = d.string(typ)
+		case 8:
+			m.Sig = d.string(typ)
+		case 9:
+			v := new(Signature)
+			d.message(typ, v)
+			m.Sigs = append(m.Sigs, v)
+		case 10:
+			if m.Enc == nil {
+				m.Enc = new(Encryption)
+			}
+			d.message(typ, m.Enc)
+		case 11:
+			if m.Timestamp == nil {
+				m.Timestamp = new(TimestampToken)
+			}
+			d.message(typ, m.Timestamp)
+		case 12:
+			m.Locale = d.string(typ)
+		case 13:
+			m.Accept = append(m.Accept, d.string(typ))
+		default:
+			d.skip(typ)
+		}
+	}
+	return d.err
+}
+
+type AckBody struct {
+	// Nonce of the acknowledged envelope
+	Ref string `json:"ref,omitempty"`
+	// Outcome, e.g. "registered"
+	Status string `json:"status,omitempty"`
+	// Handler-specific response data
+	Result json.RawMessage `json:"result,omitempty"`
+}
+
+// MarshalProto returns the protobuf encoding of the message
+func (m *AckBody) MarshalProto() []byte {
+	if m == nil {
+		return nil
+	}
+	var b []byte
+	if m.Ref != "" {
+		b = appendString(b, 1, m.Ref)
+	}
+	if m.Status != "" {
+		b = appendString(b, 2, m.Status)
+	}
+	if len(m.Result) > 0 {
+		b = appendBytes(b, 3, m.Result)
+	}
+	return b
+}
+
+// UnmarshalProto decodes the protobuf encoding of the message into m.
+// Fields m does not declare are skipped.
+func (m *AckBody) UnmarshalProto(data []byte) error {
+	d := decoder{b: data}
+	for num, typ, ok := d.next(); ok; num, typ, ok = d.next() {
+		switch num {
+		case 1:
+			m.Ref = d.string(typ)
+		case 2:
+			m.Status = d.string(typ)
+		case 3:
+			m.Result = d.bytes(typ)
+		default:
+			d.skip(typ)
+		}
+	}
+	return d.err
+}
+
+// AdminApprovalBody authorizes one admin API request under the two-person
+// rule. Admins co-sign it and the broker checks it names the request made.
+type AdminApprovalBody struct {
+	// Operation class the request falls in, e.g. "policy"
+	Operation string `json:"operation,omitempty"`
+	// HTTP method of the request
+	Method string `json:"method,omitempty"`
+	// Request path and query, e.g. /admin/peers?id=broker-b
+	Path string `json:"path,omitempty"`
+	// BodyDigest of the request body; empty for requests without one
+	Digest string `json:"digest,omitempty"`
+	Reason string `json:"reason,omitempty"`
+}
+
+// MarshalProto returns the protobuf encoding of the message
+func (m *AdminApprovalBody) MarshalProto() []byte {
+	if m == nil {
+		return nil
+	}
+	var b []byte
+	if m.Operation != "" {
+		b = appendString(b, 1, m.Operation)
+	}
+	if m.Method != "" {
+		b = appendString(b, 2, m.Method)
+	}
+	if m.Path != "" {
+		b = appendString(b, 3, m.Path)
+	}
+	if m.Digest != "" {
+		b = appendString(b, 4, m.Digest)
+	}
+	if m.Reason != "" {
+		b = appendString(b, 5, m.Reason)
+	}
+	return b
+}
+
+// UnmarshalProto decodes the protobuf encoding of the message into m.
+// Fields m does not declare are skipped.
+func (m *AdminApprovalBody) UnmarshalProto(data []byte) error {
+	d := decoder{b: data}
+	for num, typ, ok := d.next(); ok; num, typ, ok = d.next() {
+		switch num {
+		case 1:
+			m.Operation = d.string(typ)
+		case 2:
+			m.Method = d.string(typ)
+		case 3:
+			m.Path = d.string(typ)
+		case 4:
+			m.Digest = d.string(typ)
+		case 5:
+			m.Reason = d.string(typ)
+		default:
+			d.skip(typ)
+		}
+	}
+	return d.err
+}
+
+type ApproveCallBody struct {
+	RequestID string `json:"requestId,omitempty"`
+	// False rejects the call
+	Approved bool `json:"approved,omitempty"`
+	// Passed to the caller when the call is rejected
+	Reason string `json:"reason,omitempty"`
+}
+
+// MarshalProto returns the protobuf encoding of the message
+func (m *ApproveCallBody) MarshalProto() []byte {
+	if m == nil {
+		return nil
+	}
+	var b []byte
+	if m.RequestID != "" {
+		b = appendString(b, 1, m.RequestID)
+	}
+	if m.Approved {
+		b = appendUvarint(b, 2, boolVarint(m.Approved))
+	}
+	if m.Reason != "" {
+		b = appendString(b, 3, m.Reason)
+	}
+	return b
+}
+
+// UnmarshalProto decodes the protobuf encoding of the message into m.
+// Fields m does not declare are skipped.
+func (m *ApproveCallBody) UnmarshalProto(data []byte) error {
+	d := decoder{b: data}
+	for num, typ, ok := d.next(); ok; num, typ, ok = d.next() {
+		switch num {
+		case 1:
+			m.RequestID = d.string(typ)
+		case 2:
+			m.Approved = d.varint(typ) != 0
+		case 3:
+			m.Reason = d.string(typ)
+		default:
+			d.skip(typ)
+		}
+	}
+	return d.err
+}
+
+type BatchBody struct {
+	// Complete signed envelopes, processed in order
+	Envelopes []json.RawMessage `json:"envelopes,omitempty"`
+}
+
+// MarshalProto returns the protobuf encoding of the message
+func (m *BatchBody) MarshalProto() []byte {
+	if m == nil {
+		return nil
+	}
+	var b []byte
+	for _, v := range m.Envelopes {
+		b = appendBytes(b, 1, v)
+	}
+	return b
+}
+
+// UnmarshalProto decodes the protobuf encoding of the message into m.
+// Fields m does not declare are skipped.
+func (m *BatchBody) UnmarshalProto(data []byte) error {
+	d := decoder{b: data}
+	for num, typ, ok := d.next(); ok; num, typ, ok = d.next() {
+		switch num {
+		case 1:
+			m.Envelopes = append(m.Envelopes, d.bytes(typ))
+		default:
+			d.skip(typ)
+		}
+	}
+	return d.err
+}
+
+type CheckpointBody struct {
+	RequestID string `json:"requestId,omitempty"`
+	Tool      string `json:"tool,omitempty"`
+	// Arguments of the original call
+	Parameters json.RawMessage `json:"parameters,omitempty"`
+	// Opaque progress the resuming agent continues from
+	State []byte `json:"state,omitempty"`
+}
+
+// MarshalProto returns the protobuf encoding of the message
+func (m *CheckpointBody) MarshalProto() []byte {
+	if m == nil {
+		return nil
+	}
+	var b []byte
+	if m.RequestID != "" {
+		b = appendString(b, 1, m.RequestID)
+	}
+	if m.Tool != "" {
+		b = appendString(b, 2, m.Tool)
+	}
+	if len(m.Parameters) > 0 {
+		b = appendBytes(b, 3, m.Parameters)
+	}
+	if len(m.State) > 0 {
+		b = appendBytes(b, 4, m.State)
+	}
+	return b
+}
+
+// UnmarshalProto decodes the protobuf encoding of the message into m.
+// Fields m does not declare are skipped.
+func (m *CheckpointBody) UnmarshalProto(data []byte) error {
+	d := decoder{b: data}
+	for num, typ, ok := d.next(); ok; num, typ, ok = d.next() {
+		switch num {
+		case 1:
+			m.RequestID = d.string(typ)
+		case 2:
+			m.Tool = d.string(typ)
+		case 3:
+			m.Parameters = d.bytes(typ)
+		case 4:
+			m.State = d.bytes(typ)
+		default:
+			d.skip(typ)
+		}
+	}
+	return d.err
+}
+
+type DiscoverToolsBody struct {
+	Query     *ToolQuery `json:"query,omitempty"`
+	RequestID string     `json:"requestId,omitempty"`
+	// Capability token scoping the results
+	Capability string `json:"capability,omitempty"`
+}
+
+// MarshalProto returns the protobuf encoding of the message
+func (m *DiscoverToolsBody) MarshalProto() []byte {
+	if m == nil {
+		return nil
+	}
+	var b []byte
+	if m.Query != nil {
+		b = appendBytes(b, 1, m.Query.MarshalProto())
+	}
+	if m.RequestID != "" {
+		b = appendString(b, 2, m.RequestID)
+	}
+	if m.Capability != "" {
+		b = appendString(b, 3, m.Capability)
+	}
+	return b
+}
+
+// UnmarshalProto decodes the protobuf encoding of the message into m.
+// Fields m does not declare are skipped.
+func (m *DiscoverToolsBody) UnmarshalProto(data []byte) error {
+	d := decoder{b: data}
+	for num, typ, ok := d.next(); ok; num, typ, ok = d.next() {
+		switch num {
+		case 1:
+			if m.Query == nil {
+				m.Query = new(ToolQuery)
+			}
+			d.message(typ, m.Query)
+		case 2:
+			m.RequestID = d.string(typ)
+		case 3:
+			m.Capability = d.string(typ)
+		default:
+			d.skip(typ)
+		}
+	}
+	return d.err
+}
+
+type DrainInstanceBody struct {
+	InstanceID string `json:"instanceId,omitempty"`
+	// Seconds the broker may hold the ack until the instance is drained; 0 answers at once
+	Wait int64 `json:"wait,omitempty"`
+}
+
+// MarshalProto returns the protobuf encoding of the message
+func (m *DrainInstanceBody) MarshalProto() []byte {
+	if m == nil {
+		return nil
+	}
+	var b []byte
+	if m.InstanceID != "" {
+		b = appendString(b, 1, m.InstanceID)
+	}
+	if m.Wait != 0 {
+		b = appendUvarint(b, 2, uint64(m.Wait))
+	}
+	return b
+}
+
+// UnmarshalProto decodes the protobuf encoding of the message into m.
+// Fields m does not declare are skipped.
+func (m *DrainInstanceBody) UnmarshalProto(data []byte) error {
+	d := decoder{b: data}
+	for num, typ, ok := d.next(); ok; num, typ, ok = d.next() {
+		switch num {
+		case 1:
+			m.InstanceID = d.string(typ)
+		case 2:
+			m.Wait = int64(d.varint(typ))
+		default:
+			d.skip(typ)
+		}
+	}
+	return d.err
+}
+
+type EmbodimentUpdateBody struct {
+	EnvironmentType string          `json:"environmentType,omitempty"`
+	BodyDefinition  *BodyDefinition `json:"bodyDefinition,omitempty"`
+	MCPEndpoint     string          `json:"mcpEndpoint,omitempty"`
+	UpdatedTools    []string        `json:"updatedTools,omitempty"`
+}
+
+// MarshalProto returns the protobuf encoding of the message
+func (m *EmbodimentUpdateBody) MarshalProto() []byte {
+	if m == nil {
+		return nil
+	}
+	var b []byte
+	if m.EnvironmentType != "" {
+		b = appendString(b, 1, m.EnvironmentType)
+	}
+	if m.BodyDefinition != nil {
+		b = appendBytes(b, 2, m.BodyDefinition.MarshalProto())
+	}
+	if m.MCPEndpoint != "" {
+		b = appendString(b, 3, m.MCPEndpoint)
+	}
+	for _, v := range m.UpdatedTools {
+		b = appendString(b, 4, v)
+	}
+	return b
+}
+
+// UnmarshalProto decodes the protobuf encoding of the message into m.
+// Fields m does not declare are skipped.
+func (m *EmbodimentUpdateBody) UnmarshalProto(data []byte) error {
+	d := decoder{b: data}
+	for num, typ, ok := d.next(); ok; num, typ, ok = d.next() {
+		switch num {
+		case 1:
+			m.EnvironmentType = d.string(typ)
+		case 2:
+			if m.BodyDefinition == nil {
+				m.BodyDefinition = new(BodyDefinition)
+			}
+			d.message(typ, m.BodyDefinition)
+		case 3:
+			m.MCPEndpoint = d.string(typ)
+		case 4:
+			m.UpdatedTools = append(m.UpdatedTools, d.string(typ))
+		default:
+			d.skip(typ)
+		}
+	}
+	return d.err
+}
+
+type EmitEventBody struct {
+	// Topic the event is published to, e.g. "prod.ci.build.finished"
+	Event   string          `json:"event,omitempty"`
+	Payload json.RawMessage `json:"payload,omitempty"`
+	// Token granting publish rights on the topic
+	Capability string `json:"capability,omitempty"`
+}
+
+// MarshalProto returns the protobuf encoding of the message
+func (m *EmitEventBody) MarshalProto() []byte {
+	if m == nil {
+		return nil
+	}
+	var b []byte
+	if m.Event != "" {
+		b = appendString(b, 1, m.Event)
+	}
+	if len(m.Payload) > 0 {
+		b = appendBytes(b, 2, m.Payload)
+	}
+	if m.Capability != "" {
+		b = appendString(b, 3, m.Capability)
+	}
+	return b
+}
+
+// UnmarshalProto decodes the protobuf encoding of the message into m.
+// Fields m does not declare are skipped.
+func (m *EmitEventBody) UnmarshalProto(data []byte) error {
+	d := decoder{b: data}
+	for num, typ, ok := d.next(); ok; num, typ, ok = d.next() {
+		switch num {
+		case 1:
+			m.Event = d.string(typ)
+		case 2:
+			m.Payload = d.bytes(typ)
+		case 3:
+			m.Capability = d.string(typ)
+		default:
+			d.skip(typ)
+		}
+	}
+	return d.err
+}
+
+type ErrorBody struct {
+	// Nonce of the rejected envelope
+	Ref     string          `json:"ref,omitempty"`
+	Code    string          `json:"code,omitempty"`
+	Message string          `json:"message,omitempty"`
+	Details json.RawMessage `json:"details,omitempty"`
+}
+
+// MarshalProto returns the protobuf encoding of the message
+func (m *ErrorBody) MarshalProto() []byte {
+	if m == nil {
+		return nil
+	}
+	var b []byte
+	if m.Ref != "" {
+		b = appendString(b, 1, m.Ref)
+	}
+	if m.Code != "" {
+		b = appendString(b, 2, m.Code)
+	}
+	if m.Message != "" {
+		b = appendString(b, 3, m.Message)
+	}
+	if len(m.Details) > 0 {
+		b = appendBytes(b, 4, m.Details)
+	}
+	return b
+}
+
+// UnmarshalProto decodes the protobuf encoding of the message into m.
+// Fields m does not declare are skipped.
+func (m *ErrorBody) UnmarshalProto(data []byte) error {
+	d := decoder{b: data}
+	for num, typ, ok := d.next(); ok; num, typ, ok = d.next() {
+		switch num {
+		case 1:
+			m.Ref = d.string(typ)
+		case 2:
+			m.Code = d.string(typ)
+		case 3:
+			m.Message = d.string(typ)
+		case 4:
+			m.Details = d.bytes(typ)
+		default:
+			d.skip(typ)
+		}
+	}
+	return d.err
+}
+
+type HeartbeatBody struct {
+	// Fraction of capacity in use, 0 to 1
+	Load float64 `json:"load,omitempty"`
+	// Requests currently being processed
+	InFlight int64 `json:"inFlight,omitempty"`
+	// Seconds since the agent started
+	Uptime int64 `json:"uptime,omitempty"`
+	// Replica reporting, for agents registered with an instance ID
+	InstanceID string `json:"instanceId,omitempty"`
+	// Capacity free for new calls, for admission of tools declaring resources
+	Available *Resources `json:"available,omitempty"`
+	// Fraction of GPU compute in use over the agent's GPUs, 0 to 1
+	GPUUtilization float64 `json:"gpuUtilization,omitempty"`
+}
+
+// MarshalProto returns the protobuf encoding of the message
+func (m *HeartbeatBody) MarshalProto() []byte {
+	if m == nil {
+		return nil
+	}
+	var b []byte
+	if m.Load != 0 {
+		b = appendFixed64(b, 1, math.Float64bits(m.Load))
+	}
+	if m.InFlight != 0 {
+		b = appendUvarint(b, 2, uint64(m.InFlight))
+	}
+	if m.Uptime != 0 {
+		b = appendUvarint(b, 3, uint64(m.Uptime))
+	}
+	if m.InstanceID != "" {
+		b = appendString(b, 4, m.InstanceID)
+	}
+	if m.Available != nil {
+		b = appendBytes(b, 5, m.Available.MarshalProto())
+	}
+	if m.GPUUtilization != 0 {
+		b = appendFixed64(b, 6, math.Float64bits(m.GPUUtilization))
+	}
+	return b
+}
+
+// UnmarshalProto decodes the protobuf encoding of the message into m.
+// Fields m does not declare are skipped.
+func (m *HeartbeatBody) UnmarshalProto(data []byte) error {
+	d := decoder{b: data}
+	for num, typ, ok := d.next(); ok; num, typ, ok = d.next() {
+		switch num {
+		case 1:
+			m.Load = math.Float64frombits(d.fixed64(typ))
+		case 2:
+			m.InFlight = int64(d.varint(typ))
+		case 3:
+			m.Uptime = int64(d.varint(typ))
+		case 4:
+			m.InstanceID = d.string(typ)
+		case 5:
+			if m.Available == nil {
+				m.Available = new(Resources)
+			}
+			d.message(typ, m.Available)
+		case 6:
+			m.GPUUtilization = math.Float64frombits(d.fixed64(typ))
+		default:
+			d.skip(typ)
+		}
+	}
+	return d.err
+}
+
+type PreemptionNoticeBody struct {
+	// Replica being reclaimed; empty for the whole agent
+	InstanceID string `json:"instanceId,omitempty"`
+	// Unix milliseconds at which the agent stops; 0 if unknown
+	Deadline int64 `json:"deadline,omitempty"`
+}
+
+// MarshalProto returns the protobuf encoding of the message
+func (m *PreemptionNoticeBody) MarshalProto() []byte {
+	if m == nil {
+		return nil
+	}
+	var b []byte
+	if m.InstanceID != "" {
+		b = appendString(b, 1, m.InstanceID)
+	}
+	if m.Deadline != 0 {
+		b = appendUvarint(b, 2, uint64(m.Deadline))
+	}
+	return b
+}
+
+// UnmarshalProto decodes the protobuf encoding of the message into m.
+// Fields m does not declare are skipped.
+func (m *PreemptionNoticeBody) UnmarshalProto(data []byte) error {
+	d := decoder{b: data}
+	for num, typ, ok := d.next(); ok; num, typ, ok = d.next() {
+		switch num {
+		case 1:
+			m.InstanceID = d.string(typ)
+		case 2:
+			m.Deadline = int64(d.varint(typ))
+		default:
+			d.skip(typ)
+		}
+	}
+	return d.err
+}
+
+type RegisterAgentBody struct {
+	// Base64 Ed25519 public key
+	PubKey string `json:"pubkey,omitempty"`
+	// List of capabilities
+	Capabilities []string        `json:"capabilities,omitempty"`
+	Metadata     json.RawMessage `json:"metadata,omitempty"`
+	// MCP integration fields
+	MCPEndpoint string `json:"mcpEndpoint,omitempty"`
+	// Environment-specific tool definitions
+	BodyDefinition *BodyDefinition `json:"bodyDefinition,omitempty"`
+	// Environment type (e.g., "local", "cloud")
+	EnvironmentType string `json:"environmentType,omitempty"`
+	// Replica of an agent whose instances share its ID and key
+	InstanceID string `json:"instanceId,omitempty"`
+	// Content encodings the agent reads compressed bodies in, in preference order
+	Compression []string `json:"compression,omitempty"`
+}
+
+// MarshalProto returns the protobuf encoding of the message
+func (m *RegisterAgentBody) MarshalProto() []byte {
+	if m == nil {
+		return nil
+	}
+	var b []byte
+	if m.PubKey != "" {
+		b = appendString(b, 1, m.PubKey)
+	}
+	for _, v := range m.Capabilities {
+		b = appendString(b, 2, v)
+	}
+	if len(m.Metadata) > 0 {
+		b = appendBytes(b, 3, m.Metadata)
+	}
+	if m.MCPEndpoint != "" {
+		b = appendString(b, 4, m.MCPEndpoint)
+	}
+	if m.BodyDefinition != nil {
+		b = appendBytes(b, 5, m.BodyDefinition.MarshalProto())
+	}
+	if m.EnvironmentType != "" {
+		b = appendString(b, 6, m.EnvironmentType)
+	}
+	if m.InstanceID != "" {
+		b = appendString(b, 7, m.InstanceID)
+	}
+	for _, v := range m.Compression {
+		b = appendString(b, 8, v)
+	}
+	return b
+}
+
+// UnmarshalProto decodes the protobuf encoding of the message into m.
+// Fields m does not declare are skipped.
+func (m *RegisterAgentBody) UnmarshalProto(data []byte) error {
+	d := decoder{b: data}
+	for num, typ, ok := d.next(); ok; num, typ, ok = d.next() {
+		switch num {
+		case 1:
+			m.PubKey = d.string(typ)
+		case 2:
+			m.Capabilities = append(m.Capabilities, d.string(typ))
+		case 3:
+			m.Metadata = d.bytes(typ)
+		case 4:
+			m.MCPEndpoint = d.string(typ)
+		case 5:
+			if m.BodyDefinition == nil {
+				m.BodyDefinition = new(BodyDefinition)
+			}
+			d.message(typ, m.BodyDefinition)
+		case 6:
+			m.EnvironmentType = d.string(typ)
+		case 7:
+			m.InstanceID = d.string(typ)
+		case 8:
+			m.Compression = append(m.Compression, d.string(typ))
+		default:
+			d.skip(typ)
+		}
+	}
+	return d.err
+}
+
+type RegisterBrokerBody struct {
+	BrokerID string `json:"brokerId,omitempty"`
+	// TLS endpoint
+	Endpoint string `json:"endpoint,omitempty"`
+	// Base64 Ed25519 public key
+	PubKey       string   `json:"pubkey,omitempty"`
+	Capabilities []string `json:"capabilities,omitempty"`
+	// What the broker asks of agents and peers, as published to directories
+	Policy *BrokerPolicy `json:"policy,omitempty"`
+}
+
+// MarshalProto returns the protobuf encoding of the message
+func (m *RegisterBrokerBody) MarshalProto() []byte {
+	if m == nil {
+		return nil
+	}
+	var b []byte
+	if m.BrokerID != "" {
+		b = appendString(b, 1, m.BrokerID)
+	}
+	if m.Endpoint != "" {
+		b = appendString(b, 2, m.Endpoint)
+	}
+	if m.PubKey != "" {
+		b = appendString(b, 3, m.PubKey)
+	}
+	for _, v := range m.Capabilities {
+		b = appendString(b, 4, v)
+	}
+	if m.Policy != nil {
+		b = appendBytes(b, 5, m.Policy.MarshalProto())
+	}
+	return b
+}
+
+// UnmarshalProto decodes the protobuf encoding of the message into m.
+// Fields m does not declare are skipped.
+func (m *RegisterBrokerBody) UnmarshalProto(data []byte) error {
+	d := decoder{b: data}
+	for num, typ, ok := d.next(); ok; num, typ, ok = d.next() {
+		switch num {
+		case 1:
+			m.BrokerID = d.string(typ)
+		case 2:
+			m.Endpoint = d.string(typ)
+		case 3:
+			m.PubKey = d.string(typ)
+		case 4:
+			m.Capabilities = append(m.Capabilities, d.string(typ))
+		case 5:
+			if m.Policy == nil {
+				m.Policy = new(BrokerPolicy)
+			}
+			d.message(typ, m.Policy)
+		default:
+			d.skip(typ)
+		}
+	}
+	return d.err
+}
+
+type RenderInstructionBody struct {
+	Instruction string          `json:"instruction,omitempty"`
+	Parameters  json.RawMessage `json:"parameters,omitempty"`
+	// Overrides the header locale for this output
+	Locale string `json:"locale,omitempty"`
+	// What the target display can show
+	Presentation *PresentationHints `json:"presentation,omitempty"`
+}
+
+// MarshalProto returns the protobuf encoding of the message
+func (m *RenderInstructionBody) MarshalProto() []byte {
+	if m == nil {
+		return nil
+	}
+	var b []byte
+	if m.Instruction != "" {
+		b = appendString(b, 1, m.Instruction)
+	}
+	if len(m.Parameters) > 0 {
+		b = appendBytes(b, 2, m.Parameters)
+	}
+	if m.Locale != "" {
+		b = appendString(b, 3, m.Locale)
+	}
+	if m.Presentation != nil {
+		b = appendBytes(b, 4, m.Presentation.MarshalProto())
+	}
+	return b
+}
+
+// UnmarshalProto decodes the protobuf encoding of the message into m.
+// Fields m does not declare are skipped.
+func (m *RenderInstructionBody) UnmarshalProto(data []byte) error {
+	d := decoder{b: data}
+	for num, typ, ok := d.next(); ok; num, typ, ok = d.next() {
+		switch num {
+		case 1:
+			m.Instruction = d.string(typ)
+		case 2:
+			m.Parameters = d.bytes(typ)
+		case 3:
+			m.Locale = d.string(typ)
+		case 4:
+			if m.Presentation == nil {
+				m.Presentation = new(PresentationHints)
+			}
+			d.message(typ, m.Presentation)
+		default:
+			d.skip(typ)
+		}
+	}
+	return d.err
+}
+
+type ResultAckBody struct {
+	// Delivery keys of results and chunks received
+	Acknowledged []string `json:"acknowledged,omitempty"`
+	// Limit on results returned; 0 returns all pending
+	MaxResults int64 `json:"maxResults,omitempty"`
+	// Seconds the broker may hold the ack until a result is due; 0 answers at once
+	Wait int64 `json:"wait,omitempty"`
+}
+
+// MarshalProto returns the protobuf encoding of the message
+func (m *ResultAckBody) MarshalProto() []byte {
+	if m == nil {
+		return nil
+	}
+	var b []byte
+	for _, v := range m.Acknowledged {
+		b = appendString(b, 1, v)
+	}
+	if m.MaxResults != 0 {
+		b = appendUvarint(b, 2, uint64(m.MaxResults))
+	}
+	if m.Wait != 0 {
+		b = appendUvarint(b, 3, uint64(m.Wait))
+	}
+	return b
+}
+
+// UnmarshalProto decodes the protobuf encoding of the message into m.
+// Fields m does not declare are skipped.
+func (m *ResultAckBody) UnmarshalProto(data []byte) error {
+	d := decoder{b: data}
+	for num, typ, ok := d.next(); ok; num, typ, ok = d.next() {
+		switch num {
+		case 1:
+			m.Acknowledged = append(m.Acknowledged, d.string(typ))
+		case 2:
+			m.MaxResults = int64(d.varint(typ))
+		case 3:
+			m.Wait = int64(d.varint(typ))
+		default:
+			d.skip(typ)
+		}
+	}
+	return d.err
+}
+
+type RevokeBody struct {
+	// Agent or broker ID to revoke
+	Target string `json:"target,omitempty"`
+	Reason string `json:"reason,omitempty"`
+}
+
+// MarshalProto returns the protobuf encoding of the message
+func (m *RevokeBody) MarshalProto() []byte {
+	if m == nil {
+		return nil
+	}
+	var b []byte
+	if m.Target != "" {
+		b = appendString(b, 1, m.Target)
+	}
+	if m.Reason != "" {
+		b = appendString(b, 2, m.Reason)
+	}
+	return b
+}
+
+// UnmarshalProto decodes the protobuf encoding of the message into m.
+// Fields m does not declare are skipped.
+func (m *RevokeBody) UnmarshalProto(data []byte) error {
+	d := decoder{b: data}
+	for num, typ, ok := d.next(); ok; num, typ, ok = d.next() {
+		switch num {
+		case 1:
+			m.Target = d.string(typ)
+		case 2:
+			m.Reason = d.string(typ)
+		default:
+			d.skip(typ)
+		}
+	}
+	return d.err
+}
+
+type RevokeCapabilityBody struct {
+	// jti claim of the token to revoke
+	TokenID string `json:"jti,omitempty"`
+	// The token itself, proving the sender was issued or delegated it
+	Capability string `json:"capability,omitempty"`
+	Reason     string `json:"reason,omitempty"`
+}
+
+// MarshalProto returns the protobuf encoding of the message
+func (m *RevokeCapabilityBody) MarshalProto() []byte {
+	if m == nil {
+		return nil
+	}
+	var b []byte
+	if m.TokenID != "" {
+		b = appendString(b, 1, m.TokenID)
+	}
+	if m.Capability != "" {
+		b = appendString(b, 2, m.Capability)
+	}
+	if m.Reason != "" {
+		b = appendString(b, 3, m.Reason)
+	}
+	return b
+}
+
+// UnmarshalProto decodes the protobuf encoding of the message into m.
+// Fields m does not declare are skipped.
+func (m *RevokeCapabilityBody) UnmarshalProto(data []byte) error {
+	d := decoder{b: data}
+	for num, typ, ok := d.next(); ok; num, typ, ok = d.next() {
+		switch num {
+		case 1:
+			m.TokenID = d.string(typ)
+		case 2:
+			m.Capability = d.string(typ)
+		case 3:
+			m.Reason = d.string(typ)
+		default:
+			d.skip(typ)
+		}
+	}
+	return d.err
+}
+
+type RotateKeyBody struct {
+	// Base64 Ed25519 public key replacing the current one
+	NewPubKey string `json:"newPubkey,omitempty"`
+	// e.g. "scheduled" or "compromised"
+	Reason string `json:"reason,omitempty"`
+}
+
+// MarshalProto returns the protobuf encoding of the message
+func (m *RotateKeyBody) MarshalProto() []byte {
+	if m == nil {
+		return nil
+	}
+	var b []byte
+	if m.NewPubKey != "" {
+		b = appendString(b, 1, m.NewPubKey)
+	}
+	if m.Reason != "" {
+		b = appendString(b, 2, m.Reason)
+	}
+	return b
+}
+
+// UnmarshalProto decodes the protobuf encoding of the message into m.
+// Fields m does not declare are skipped.
+func (m *RotateKeyBody) UnmarshalProto(data []byte) error {
+	d := decoder{b: data}
+	for num, typ, ok := d.next(); ok; num, typ, ok = d.next() {
+		switch num {
+		case 1:
+			m.NewPubKey = d.string(typ)
+		case 2:
+			m.Reason = d.string(typ)
+		default:
+			d.skip(typ)
+		}
+	}
+	return d.err
+}
+
+type SubscribeBody struct {
+	// Topic pattern, e.g. "prod.ci.*"
+	Pattern string `json:"pattern,omitempty"`
+	// Token granting subscribe rights on the pattern
+	Capability string `json:"capability,omitempty"`
+	// On an event stream, also send the results of the sender's calls
+	Results bool `json:"results,omitempty"`
+}
+
+// MarshalProto returns the protobuf encoding of the message
+func (m *SubscribeBody) MarshalProto() []byte {
+	if m == nil {
+		return nil
+	}
+	var b []byte
+	if m.Pattern != "" {
+		b = appendString(b, 1, m.Pattern)
+	}
+	if m.Capability != "" {
+		b = appendString(b, 2, m.Capability)
+	}
+	if m.Results {
+		b = appendUvarint(b, 3, boolVarint(m.Results))
+	}
+	return b
+}
+
+// UnmarshalProto decodes the protobuf encoding of the message into m.
+// Fields m does not declare are skipped.
+func (m *SubscribeBody) UnmarshalProto(data []byte) error {
+	d := decoder{b: data}
+	for num, typ, ok := d.next(); ok; num, typ, ok = d.next() {
+		switch num {
+		case 1:
+			m.Pattern = d.string(typ)
+		case 2:
+			m.Capability = d.string(typ)
+		case 3:
+			m.Results = d.varint(typ) != 0
+		default:
+			d.skip(typ)
+		}
+	}
+	return d.err
+}
+
+type ToolCallBody struct {
+	Tool       string          `json:"tool,omitempty"`
+	Parameters json.RawMessage `json:"parameters,omitempty"`
+	RequestID  string          `json:"requestId,omitempty"`
+	// Capability token authorizing the call
+	Capability string `json:"capability,omitempty"`
+	// How the result is delivered to the caller; at-most-once if empty
+	Delivery string `json:"delivery,omitempty"`
+	// Region the call's data must stay in, e.g. "eu"
+	DataRegion string `json:"dataRegion,omitempty"`
+	// How the result comes back: sync, poll, webhook or event; polled if nil
+	ResultDelivery *ResultDelivery `json:"resultDelivery,omitempty"`
+	// Unix milliseconds by which the caller needs the result; 0 for none
+	Deadline int64 `json:"deadline,omitempty"`
+	// At the deadline, take the output streamed so far as a partial result rather than an error
+	AcceptPartial bool `json:"acceptPartial,omitempty"`
+	// Media ranges the caller takes the result in, in preference order, e.g. ["image/webp", "image/*"]
+	Accept []string `json:"accept,omitempty"`
+}
+
+// MarshalProto returns the protobuf encoding of the message
+func (m *ToolCallBody) MarshalProto() []byte {
+	if m == nil {
+		return nil
+	}
+	var b []byte
+	if m.Tool != "" {
+		b = appendString(b, 1, m.Tool)
+	}
+	if len(m.Parameters) > 0 {
+		b = appendBytes(b, 2, m.Parameters)
+	}
+	if m.RequestID != "" {
+		b = appendString(b, 3, m.RequestID)
+	}
+	if m.Capability != "" {
+		b = appendString(b, 4, m.Capability)
+	}
+	if m.Delivery != "" {
+		b = appendString(b, 5, m.Delivery)
+	}
+	if m.DataRegion != "" {
+		b = appendString(b, 6, m.DataRegion)
+	}
+	if m.ResultDelivery != nil {
+		b = appendBytes(b, 7, m.ResultDelivery.MarshalProto())
+	}
+	if m.Deadline != 0 {
+		b = appendUvarint(b, 8, uint64(m.Deadline))
+	}
+	if m.AcceptPartial {
+		b = appendUvarint(b, 9, boolVarint(m.AcceptPartial))
+	}
+	for _, v := range m.Accept {
+		b = appendString(b, 10, v)
+	}
+	return b
+}
+
+// UnmarshalProto decodes the protobuf encoding of the message into m.
+// Fields m does not declare are skipped.
+func (m *ToolCallBody) UnmarshalProto(data []byte) error {
+	d := decoder{b: data}
+	for num, typ, ok := d.next(); ok; num, typ, ok = d.next() {
+		switch num {
+		case 1:
+			m.Tool = d.string(typ)
+		case 2:
+			m.Parameters = d.bytes(typ)
+		case 3:
+			m.RequestID = d.string(typ)
+		case 4:
+			m.Capability = d.string(typ)
+		case 5:
+			m.Delivery = d.string(typ)
+		case 6:
+			m.DataRegion = d.string(typ)
+		case 7:
+			if m.ResultDelivery == nil {
+				m.ResultDelivery = new(ResultDelivery)
+			}
+			d.message(typ, m.ResultDelivery)
+		case 8:
+			m.Deadline = int64(d.varint(typ))
+		case 9:
+			m.AcceptPartial = d.varint(typ) != 0
+		case 10:
+			m.Accept = append(m.Accept, d.string(typ))
+		default:
+			d.skip(typ)
+		}
+	}
+	return d.err
+}
+
+type ToolResultBody struct {
+	RequestID string          `json:"requestId,omitempty"`
+	Success   bool            `json:"success,omitempty"`
+	Result    json.RawMessage `json:"result,omitempty"`
+	Error     string          `json:"error,omitempty"`
+	// The call did not finish; the result holds the output so far
+	Partial bool `json:"partial,omitempty"`
+	// Media type of the result; application/json if empty. Binary and image results are base64 strings.
+	ContentType string `json:"contentType,omitempty"`
+}
+
+// MarshalProto returns the protobuf encoding of the message
+func (m *ToolResultBody) MarshalProto() []byte {
+	if m == nil {
+		return nil
+	}
+	var b []byte
+	if m.RequestID != "" {
+		b = appendString(b, 1, m.RequestID)
+	}
+	if m.Success {
+		b = appendUvarint(b, 2, boolVarint(m.Success))
+	}
+	if len(m.Result) > 0 {
+		b = appendBytes(b, 3, m.Result)
+	}
+	if m.Error != "" {
+		b = appendString(b, 4, m.Error)
+	}
+	if m.Partial {
+		b = appendUvarint(b, 5, boolVarint(m.Partial))
+	}
+	if m.ContentType != "" {
+		b = appendString(b, 6, m.ContentType)
+	}
+	return b
+}
+
+// UnmarshalProto decodes the protobuf encoding of the message into m.
+// Fields m does not declare are skipped.
+func (m *ToolResultBody) UnmarshalProto(data []byte) error {
+	d := decoder{b: data}
+	for num, typ, ok := d.next(); ok; num, typ, ok = d.next() {
+		switch num {
+		case 1:
+			m.RequestID = d.string(typ)
+		case 2:
+			m.Success = d.varint(typ) != 0
+		case 3:
+			m.Result = d.bytes(typ)
+		case 4:
+			m.Error = d.string(typ)
+		case 5:
+			m.Partial = d.varint(typ) != 0
+		case 6:
+			m.ContentType = d.string(typ)
+		default:
+			d.skip(typ)
+		}
+	}
+	return d.err
+}
+
+// ToolResultChunkBody is one piece of a streamed result. Chunks are numbered
+// from 1; the chunk with Final set ends the stream and reports the outcome.
+type ToolResultChunkBody struct {
+	RequestID string `json:"requestId,omitempty"`
+	// Position in the stream, starting at 1
+	Seq uint64 `json:"seq,omitempty"`
+	// Output channel, e.g. "stdout" or "stderr"
+	Stream string `json:"stream,omitempty"`
+	// Output produced since the previous chunk
+	Data string `json:"data,omitempty"`
+	// Set on the last chunk
+	Final bool `json:"final,omitempty"`
+	// Outcome, on the final chunk
+	Success bool `json:"success,omitempty"`
+	// Structured result, on the final chunk
+	Result json.RawMessage `json:"result,omitempty"`
+	// Failure message, on the final chunk
+	Error string `json:"error,omitempty"`
+}
+
+// MarshalProto returns the protobuf encoding of the message
+func (m *ToolResultChunkBody) MarshalProto() []byte {
+	if m == nil {
+		return nil
+	}
+	var b []byte
+	if m.RequestID != "" {
+		b = appendString(b, 1, m.RequestID)
+	}
+	if m.Seq != 0 {
+		b = appendUvarint(b, 2, m.Seq)
+	}
+	if m.Stream != "" {
+		b = appendString(b, 3, m.Stream)
+	}
+	if m.Data != "" {
+		b = appendString(b, 4, m.Data)
+	}
+	if m.Final {
+		b = appendUvarint(b, 5, boolVarint(m.Final))
+	}
+	if m.Success {
+		b = appendUvarint(b, 6, boolVarint(m.Success))
+	}
+	if len(m.Result) > 0 {
+		b = appendBytes(b, 7, m.Result)
+	}
+	if m.Error != "" {
+		b = appendString(b, 8, m.Error)
+	}
+	return b
+}
+
+// UnmarshalProto decodes the protobuf encoding of the message into m.
+// Fields m does not declare are skipped.
+func (m *ToolResultChunkBody) UnmarshalProto(data []byte) error {
+	d := decoder{b: data}
+	for num, typ, ok := d.next(); ok; num, typ, ok = d.next() {
+		switch num {
+		case 1:
+			m.RequestID = d.string(typ)
+		case 2:
+			m.Seq = d.varint(typ)
+		case 3:
+			m.Stream = d.string(typ)
+		case 4:
+			m.Data = d.string(typ)
+		case 5:
+			m.Final = d.varint(typ) != 0
+		case 6:
+			m.Success = d.varint(typ) != 0
+		case 7:
+			m.Result = d.bytes(typ)
+		case 8:
+			m.Error = d.string(typ)
+		default:
+			d.skip(typ)
+		}
+	}
+	return d.err
+}
+
+type ToolsDiscoveredBody struct {
+	RequestID    string            `json:"requestId,omitempty"`
+	Tools        []*DiscoveredTool `json:"tools,omitempty"`
+	TotalResults int64             `json:"totalResults,omitempty"`
+	HasMore      bool              `json:"hasMore,omitempty"`
+	// Results may be incomplete, e.g. while the broker restores state after a restart
+	Partial bool `json:"partial,omitempty"`
+}
+
+// MarshalProto returns the protobuf encoding of the message
+func (m *ToolsDiscoveredBody) MarshalProto() []byte {
+	if m == nil {
+		return nil
+	}
+	var b []byte
+	if m.RequestID != "" {
+		b = appendString(b, 1, m.RequestID)
+	}
+	for _, v := range m.Tools {
+		b = appendBytes(b, 2, v.MarshalProto())
+	}
+	if m.TotalResults != 0 {
+		b = appendUvarint(b, 3, uint64(m.TotalResults))
+	}
+	if m.HasMore {
+		b = appendUvarint(b, 4, boolVarint(m.HasMore))
+	}
+	if m.Partial {
+		b = appendUvarint(b, 5, boolVarint(m.Partial))
+	}
+	return b
+}
+
+// UnmarshalProto decodes the protobuf encoding of the message into m.
+// Fields m does not declare are skipped.
+func (m *ToolsDiscoveredBody) UnmarshalProto(data []byte) error {
+	d := decoder{b: data}
+	for num, typ, ok := d.next(); ok; num, typ, ok = d.next() {
+		switch num {
+		case 1:
+			m.RequestID = d.string(typ)
+		case 2:
+			v := new(DiscoveredTool)
+			d.message(typ, v)
+			m.Tools = append(m.Tools, v)
+		case 3:
+			m.TotalResults = int64(d.varint(typ))
+		case 4:
+			m.HasMore = d.varint(typ) != 0
+		case 5:
+			m.Partial = d.varint(typ) != 0
+		default:
+			d.skip(typ)
+		}
+	}
+	return d.err
+}
+
+type UnsubscribeBody struct {
+	// Subscription to remove
+	SubscriptionID string `json:"subscriptionId,omitempty"`
+	// Or remove the sender's subscription to this pattern
+	Pattern string `json:"pattern,omitempty"`
+}
+
+// MarshalProto returns the protobuf encoding of the message
+func (m *UnsubscribeBody) MarshalProto() []byte {
+	if m == nil {
+		return nil
+	}
+	var b []byte
+	if m.SubscriptionID != "" {
+		b = appendString(b, 1, m.SubscriptionID)
+	}
+	if m.Pattern != "" {
+		b = appendString(b, 2, m.Pattern)
+	}
+	return b
+}
+
+// UnmarshalProto decodes the protobuf encoding of the message into m.
+// Fields m does not declare are skipped.
+func (m *UnsubscribeBody) UnmarshalProto(data []byte) error {
+	d := decoder{b: data}
+	for num, typ, ok := d.next(); ok; num, typ, ok = d.next() {
+		switch num {
+		case 1:
+			m.SubscriptionID = d.string(typ)
+		case 2:
+			m.Pattern = d.string(typ)
+		default:
+			d.skip(typ)
+		}
+	}
+	return d.err
+}
+
+// ResultDeliveryBody is the result of a resultAck: tool results awaiting the caller
+type ResultDeliveryBody struct {
+	Results []*DeliveredResult `json:"results,omitempty"`
+}
+
+// MarshalProto returns the protobuf encoding of the message
+func (m *ResultDeliveryBody) MarshalProto() []byte {
+	if m == nil {
+		return nil
+	}
+	var b []byte
+	for _, v := range m.Results {
+		b = appendBytes(b, 1, v.MarshalProto())
+	}
+	return b
+}
+
+// UnmarshalProto decodes the protobuf encoding of the message into m.
+// Fields m does not declare are skipped.
+func (m *ResultDeliveryBody) UnmarshalProto(data []byte) error {
+	d := decoder{b: data}
+	for num, typ, ok := d.next(); ok; num, typ, ok = d.next() {
+		switch num {
+		case 1:
+			v := new(DeliveredResult)
+			d.message(typ, v)
+			m.Results = append(m.Results, v)
+		default:
+			d.skip(typ)
+		}
+	}
+	return d.err
+}
+
+// BatchResultBody is the result of a batch: the outcome of each envelope
+type BatchResultBody struct {
+	Results   []*BatchItemResult `json:"results,omitempty"`
+	Succeeded int64              `json:"succeeded,omitempty"`
+	Failed    int64              `json:"failed,omitempty"`
+}
+
+// MarshalProto returns the protobuf encoding of the message
+func (m *BatchResultBody) MarshalProto() []byte {
+	if m == nil {
+		return nil
+	}
+	var b []byte
+	for _, v := range m.Results {
+		b = appendBytes(b, 1, v.MarshalProto())
+	}
+	if m.Succeeded != 0 {
+		b = appendUvarint(b, 2, uint64(m.Succeeded))
+	}
+	if m.Failed != 0 {
+		b = appendUvarint(b, 3, uint64(m.Failed))
+	}
+	return b
+}
+
+// UnmarshalProto decodes the protobuf encoding of the message into m.
+// Fields m does not declare are skipped.
+func (m *BatchResultBody) UnmarshalProto(data []byte) error {
+	d := decoder{b: data}
+	for num, typ, ok := d.next(); ok; num, typ, ok = d.next() {
+		switch num {
+		case 1:
+			v := new(BatchItemResult)
+			d.message(typ, v)
+			m.Results = append(m.Results, v)
+		case 2:
+			m.Succeeded = int64(d.varint(typ))
+		case 3:
+			m.Failed = int64(d.varint(typ))
+		default:
+			d.skip(typ)
+		}
+	}
+	return d.err
+}
+
+// DrainStatusBody is the result of a drainInstance: whether the instance may be stopped
+type DrainStatusBody struct {
+	InstanceID string `json:"instanceId,omitempty"`
+	// Requests in flight in the instance's latest heartbeat
+	InFlight int64 `json:"inFlight,omitempty"`
+	// A heartbeat since draining began reported nothing in flight
+	Drained bool `json:"drained,omitempty"`
+}
+
+// MarshalProto returns the protobuf encoding of the message
+func (m *DrainStatusBody) MarshalProto() []byte {
+	if m == nil {
+		return nil
+	}
+	var b []byte
+	if m.InstanceID != "" {
+		b = appendString(b, 1, m.InstanceID)
+	}
+	if m.InFlight != 0 {
+		b = appendUvarint(b, 2, uint64(m.InFlight))
+	}
+	if m.Drained {
+		b = appendUvarint(b, 3, boolVarint(m.Drained))
+	}
+	return b
+}
+
+// UnmarshalProto decodes the protobuf encoding of the message into m.
+// Fields m does not declare are skipped.
+func (m *DrainStatusBody) UnmarshalProto(data []byte) error {
+	d := decoder{b: data}
+	for num, typ, ok := d.next(); ok; num, typ, ok = d.next() {
+		switch num {
+		case 1:
+			m.InstanceID = d.string(typ)
+		case 2:
+			m.InFlight = int64(d.varint(typ))
+		case 3:
+			m.Drained = d.varint(typ) != 0
+		default:
+			d.skip(typ)
+		}
+	}
+	return d.err
+}
+
+// ResumeBody assigns a checkpointed call to the agent that resumes it. It is
+// delivered in heartbeat acks; the agent answers the caller with a toolResult
+// for the same request ID.
+type ResumeBody struct {
+	RequestID  string          `json:"requestId,omitempty"`
+	Tool       string          `json:"tool,omitempty"`
+	Parameters json.RawMessage `json:"parameters,omitempty"`
+	State      []byte          `json:"state,omitempty"`
+	// Digest the state is stored under
+	Artifact string `json:"artifact,omitempty"`
+	// Agent that checkpointed the call
+	From string `json:"from,omitempty"`
+}
+
+// MarshalProto returns the protobuf encoding of the message
+func (m *ResumeBody) MarshalProto() []byte {
+	if m == nil {
+		return nil
+	}
+	var b []byte
+	if m.RequestID != "" {
+		b = appendString(b, 1, m.RequestID)
+	}
+	if m.Tool != "" {
+		b = appendString(b, 2, m.Tool)
+	}
+	if len(m.Parameters) > 0 {
+		b = appendBytes(b, 3, m.Parameters)
+	}
+	if len(m.State) > 0 {
+		b = appendBytes(b, 4, m.State)
+	}
+	if m.Artifact != "" {
+		b = appendString(b, 5, m.Artifact)
+	}
+	if m.From != "" {
+		b = appendString(b, 6, m.From)
+	}
+	return b
+}
+
+// UnmarshalProto decodes the protobuf encoding of the message into m.
+// Fields m does not declare are skipped.
+func (m *ResumeBody) UnmarshalProto(data []byte) error {
+	d := decoder{b: data}
+	for num, typ, ok := d.next(); ok; num, typ, ok = d.next() {
+		switch num {
+		case 1:
+			m.RequestID = d.string(typ)
+		case 2:
+			m.Tool = d.string(typ)
+		case 3:
+			m.Parameters = d.bytes(typ)
+		case 4:
+			m.State = d.bytes(typ)
+		case 5:
+			m.Artifact = d.string(typ)
+		case 6:
+			m.From = d.string(typ)
+		default:
+			d.skip(typ)
+		}
+	}
+	return d.err
+}
+
+// Signature is one co-signature on an envelope
+type Signature struct {
+	// Identifier of the co-signing agent or broker
+	Signer string `json:"signer,omitempty"`
+	// Base64(Ed25519(signing bytes))
+	Sig string `json:"sig,omitempty"`
+}
+
+// MarshalProto returns the protobuf encoding of the message
+func (m *Signature) MarshalProto() []byte {
+	if m == nil {
+		return nil
+	}
+	var b []byte
+	if m.Signer != "" {
+		b = appendString(b, 1, m.Signer)
+	}
+	if m.Sig != "" {
+		b = appendString(b, 2, m.Sig)
+	}
+	return b
+}
+
+// UnmarshalProto decodes the protobuf encoding of the message into m.
+// Fields m does not declare are skipped.
+func (m *Signature) UnmarshalProto(data []byte) error {
+	d := decoder{b: data}
+	for num, typ, ok := d.next(); ok; num, typ, ok = d.next() {
+		switch num {
+		case 1:
+			m.Signer = d.string(typ)
+		case 2:
+			m.Sig = d.string(typ)
+		default:
+			d.skip(typ)
+		}
+	}
+	return d.err
+}
+
+// Encryption describes how an envelope body was encrypted. It travels in the
+// headers so it is covered by the sender's signature.
+type Encryption struct {
+	Alg string `json:"alg,omitempty"`
+	// Key ID of the recipient's encryption key
+	KID string `json:"kid,omitempty"`
+	// Base64 ephemeral X25519 public key of the sender
+	EPK string `json:"epk,omitempty"`
+	// Base64 AEAD nonce
+	Nonce string `json:"nonce,omitempty"`
+}
+
+// MarshalProto returns the protobuf encoding of the message
+func (m *Encryption) MarshalProto() []byte {
+	if m == nil {
+		return nil
+	}
+	var b []byte
+	if m.Alg != "" {
+		b = appendString(b, 1, m.Alg)
+	}
+	if m.KID != "" {
+		b = appendString(b, 2, m.KID)
+	}
+	if m.EPK != "" {
+		b = appendString(b, 3, m.EPK)
+	}
+	if m.Nonce != "" {
+		b = appendString(b, 4, m.Nonce)
+	}
+	return b
+}
+
+// UnmarshalProto decodes the protobuf encoding of the message into m.
+// Fields m does not declare are skipped.
+func (m *Encryption) UnmarshalProto(data []byte) error {
+	d := decoder{b: data}
+	for num, typ, ok := d.next(); ok; num, typ, ok = d.next() {
+		switch num {
+		case 1:
+			m.Alg = d.string(typ)
+		case 2:
+			m.KID = d.string(typ)
+		case 3:
+			m.EPK = d.string(typ)
+		case 4:
+			m.Nonce = d.string(typ)
+		default:
+			d.skip(typ)
+		}
+	}
+	return d.err
+}
+
+// TimestampToken is third-party evidence that an envelope existed at a point in time
+type TimestampToken struct {
+	// Identifier or URL of the timestamping authority
+	Authority string `json:"authority,omitempty"`
+	// Evidence format, e.g. "ed25519" or "rfc3161"
+	Kind string `json:"kind,omitempty"`
+	// "<alg>=<base64>" digest that was timestamped
+	Digest string `json:"digest,omitempty"`
+	// Asserted time in Unix milliseconds
+	Time int64 `json:"time,omitempty"`
+	// Base64 authority-specific evidence
+	Token string `json:"token,omitempty"`
+}
+
+// MarshalProto returns the protobuf encoding of the message
+func (m *TimestampToken) MarshalProto() []byte {
+	if m == nil {
+		return nil
+	}
+	var b []byte
+	if m.Authority != "" {
+		b = appendString(b, 1, m.Authority)
+	}
+	if m.Kind != "" {
+		b = appendString(b, 2, m.Kind)
+	}
+	if m.Digest != "" {
+		b = appendString(b, 3, m.Digest)
+	}
+	if m.Time != 0 {
+		b = appendUvarint(b, 4, uint64(m.Time))
+	}
+	if m.Token != "" {
+		b = appendString(b, 5, m.Token)
+	}
+	return b
+}
+
+// UnmarshalProto decodes the protobuf encoding of the message into m.
+// Fields m does not declare are skipped.
+func (m *TimestampToken) UnmarshalProto(data []byte) error {
+	d := decoder{b: data}
+	for num, typ, ok := d.next(); ok; num, typ, ok = d.next() {
+		switch num {
+		case 1:
+			m.Authority = d.string(typ)
+		case 2:
+			m.Kind = d.string(typ)
+		case 3:
+			m.Digest = d.string(typ)
+		case 4:
+			m.Time = int64(d.varint(typ))
+		case 5:
+			m.Token = d.string(typ)
+		default:
+			d.skip(typ)
+		}
+	}
+	return d.err
+}
+
+type ToolQuery struct {
+	Capabilities    []string `json:"capabilities,omitempty"`
+	EnvironmentType string   `json:"environmentType,omitempty"`
+	MaxResults      int64    `json:"maxResults,omitempty"`
+	IncludeMetadata bool     `json:"includeMetadata,omitempty"`
+	// Only tools of agents with matching hardware, see HardwareFilter.Matches
+	Hardware *HardwareFilter `json:"hardware,omitempty"`
+}
+
+// MarshalProto returns the protobuf encoding of the message
+func (m *ToolQuery) MarshalProto() []byte {
+	if m == nil {
+		return nil
+	}
+	var b []byte
+	for _, v := range m.Capabilities {
+		b = appendString(b, 1, v)
+	}
+	if m.EnvironmentType != "" {
+		b = appendString(b, 2, m.EnvironmentType)
+	}
+	if m.MaxResults != 0 {
+		b = appendUvarint(b, 3, uint64(m.MaxResults))
+	}
+	if m.IncludeMetadata {
+		b = appendUvarint(b, 4, boolVarint(m.IncludeMetadata))
+	}
+	if m.Hardware != nil {
+		b = appendBytes(b, 5, m.Hardware.MarshalProto())
+	}
+	return b
+}
+
+// UnmarshalProto decodes the protobuf encoding of the message into m.
+// Fields m does not declare are skipped.
+func (m *ToolQuery) UnmarshalProto(data []byte) error {
+	d := decoder{b: data}
+	for num, typ, ok := d.next(); ok; num, typ, ok = d.next() {
+		switch num {
+		case 1:
+			m.Capabilities = append(m.Capabilities, d.string(typ))
+		case 2:
+			m.EnvironmentType = d.string(typ)
+		case 3:
+			m.MaxResults = int64(d.varint(typ))
+		case 4:
+			m.IncludeMetadata = d.varint(typ) != 0
+		case 5:
+			if m.Hardware == nil {
+				m.Hardware = new(HardwareFilter)
+			}
+			d.message(typ, m.Hardware)
+		default:
+			d.skip(typ)
+		}
+	}
+	return d.err
+}
+
+type BodyDefinition struct {
+	Name         string          `json:"name,omitempty"`
+	Environment  string          `json:"environment,omitempty"`
+	Capabilities []string        `json:"capabilities,omitempty"`
+	MCPTools     []*MCPTool      `json:"mcpTools,omitempty"`
+	Constraints  json.RawMessage `json:"constraints,omitempty"`
+	Metadata     json.RawMessage `json:"metadata,omitempty"`
+	// Regions the agent keeps call data in, e.g. ["eu-de"]
+	DataResidency []string `json:"dataResidency,omitempty"`
+	// Hardware the agent runs tools on, matched by discovery hardware filters
+	Hardware *HardwareCapabilities `json:"hardware,omitempty"`
+}
+
+// MarshalProto returns the protobuf encoding of the message
+func (m *BodyDefinition) MarshalProto() []byte {
+	if m == nil {
+		return nil
+	}
+	var b []byte
+	if m.Name != "" {
+		b = appendString(b, 1, m.Name)
+	}
+	if m.Environment != "" {
+		b = appendString(b, 2, m.Environment)
+	}
+	for _, v := range m.Capabilities {
+		b = appendString(b, 3, v)
+	}
+	for _, v := range m.MCPTools {
+		b = appendBytes(b, 4, v.MarshalProto())
+	}
+	if len(m.Constraints) > 0 {
+		b = appendBytes(b, 5, m.Constraints)
+	}
+	if len(m.Metadata) > 0 {
+		b = appendBytes(b, 6, m.Metadata)
+	}
+	for _, v := range m.DataResidency {
+		b = appendString(b, 7, v)
+	}
+	if m.Hardware != nil {
+		b = appendBytes(b, 8, m.Hardware.MarshalProto())
+	}
+	return b
+}
+
+// UnmarshalProto decodes the protobuf encoding of the message into m.
+// Fields m does not declare are skipped.
+func (m *BodyDefinition) UnmarshalProto(data []byte) error {
+	d := decoder{b: data}
+	for num, typ, ok := d.next(); ok; num, typ, ok = d.next() {
+		switch num {
+		case 1:
+			m.Name = d.string(typ)
+		case 2:
+			m.Environment = d.string(typ)
+		case 3:
+			m.Capabilities = append(m.Capabilities, d.string(typ))
+		case 4:
+			v := new(MCPTool)
+			d.message(typ, v)
+			m.MCPTools = append(m.MCPTools, v)
+		case 5:
+			m.Constraints = d.bytes(typ)
+		case 6:
+			m.Metadata = d.bytes(typ)
+		case 7:
+			m.DataResidency = append(m.DataResidency, d.string(typ))
+		case 8:
+			if m.Hardware == nil {
+				m.Hardware = new(HardwareCapabilities)
+			}
+			d.message(typ, m.Hardware)
+		default:
+			d.skip(typ)
+		}
+	}
+	return d.err
+}
+
+// Resources are amounts of compute, either needed by a tool call or free on an agent
+type Resources struct {
+	// Cores
+	CPU float64 `json:"cpu,omitempty"`
+	// Mebibytes of memory
+	MemoryMB int64 `json:"memoryMb,omitempty"`
+	// Whole GPUs
+	GPUs int64 `json:"gpus,omitempty"`
+	// Mebibytes of GPU memory, per GPU
+	GPUMemoryMB int64 `json:"gpuMemoryMb,omitempty"`
+}
+
+// MarshalProto returns the protobuf encoding of the message
+func (m *Resources) MarshalProto() []byte {
+	if m == nil {
+		return nil
+	}
+	var b []byte
+	if m.CPU != 0 {
+		b = appendFixed64(b, 1, math.Float64bits(m.CPU))
+	}
+	if m.MemoryMB != 0 {
+		b = appendUvarint(b, 2, uint64(m.MemoryMB))
+	}
+	if m.GPUs != 0 {
+		b = appendUvarint(b, 3, uint64(m.GPUs))
+	}
+	if m.GPUMemoryMB != 0 {
+		b = appendUvarint(b, 4, uint64(m.GPUMemoryMB))
+	}
+	return b
+}
+
+// UnmarshalProto decodes the protobuf encoding of the message into m.
+// Fields m does not declare are skipped.
+func (m *Resources) UnmarshalProto(data []byte) error {
+	d := decoder{b: data}
+	for num, typ, ok := d.next(); ok; num, typ, ok = d.next() {
+		switch num {
+		case 1:
+			m.CPU = math.Float64frombits(d.fixed64(typ))
+		case 2:
+			m.MemoryMB = int64(d.varint(typ))
+		case 3:
+			m.GPUs = int64(d.varint(typ))
+		case 4:
+			m.GPUMemoryMB = int64(d.varint(typ))
+		default:
+			d.skip(typ)
+		}
+	}
+	return d.err
+}
+
+// BrokerPolicy summarizes a broker's admission policy, so brokers found in a
+// directory can tell whether to peer before registering
+type BrokerPolicy struct {
+	// Oldest protocol version accepted
+	MinProto string `json:"minProto,omitempty"`
+	// Trust tier new peers are placed in
+	PeerTrustTier string `json:"peerTrustTier,omitempty"`
+	// Discovery without a signed identity
+	AnonymousDiscovery bool `json:"anonymousDiscovery,omitempty"`
+	// Tool calls need capability tokens
+	CallCapabilities bool `json:"callCapabilities,omitempty"`
+	// Largest envelope accepted, in bytes
+	MaxEnvelopeSize int64 `json:"maxEnvelopeSize,omitempty"`
+	// Regions call data is kept in
+	DataResidency []string `json:"dataResidency,omitempty"`
+}
+
+// MarshalProto returns the protobuf encoding of the message
+func (m *BrokerPolicy) MarshalProto() []byte {
+	if m == nil {
+		return nil
+	}
+	var b []byte
+	if m.MinProto != "" {
+		b = appendString(b, 1, m.MinProto)
+	}
+	if m.PeerTrustTier != "" {
+		b = appendString(b, 2, m.PeerTrustTier)
+	}
+	if m.AnonymousDiscovery {
+		b = appendUvarint(b, 3, boolVarint(m.AnonymousDiscovery))
+	}
+	if m.CallCapabilities {
+		b = appendUvarint(b, 4, boolVarint(m.CallCapabilities))
+	}
+	if m.MaxEnvelopeSize != 0 {
+		b = appendUvarint(b, 5, uint64(m.MaxEnvelopeSize))
+	}
+	for _, v := range m.DataResidency {
+		b = appendString(b, 6, v)
+	}
+	return b
+}
+
+// UnmarshalProto decodes the protobuf encoding of the message into m.
+// Fields m does not declare are skipped.
+func (m *BrokerPolicy) UnmarshalProto(data []byte) error {
+	d := decoder{b: data}
+	for num, typ, ok := d.next(); ok; num, typ, ok = d.next() {
+		switch num {
+		case 1:
+			m.MinProto = d.string(typ)
+		case 2:
+			m.PeerTrustTier = d.string(typ)
+		case 3:
+			m.AnonymousDiscovery = d.varint(typ) != 0
+		case 4:
+			m.CallCapabilities = d.varint(typ) != 0
+		case 5:
+			m.MaxEnvelopeSize = int64(d.varint(typ))
+		case 6:
+			m.DataResidency = append(m.DataResidency, d.string(typ))
+		default:
+			d.skip(typ)
+		}
+	}
+	return d.err
+}
+
+// PresentationHints restrict the format of rendered output
+type PresentationHints struct {
+	// Acceptable media types in preference order, e.g. "text/markdown"
+	Formats []string `json:"formats,omitempty"`
+	// Display width in characters
+	MaxWidth int64 `json:"maxWidth,omitempty"`
+	// Display cannot show color
+	NoColor bool `json:"noColor,omitempty"`
+}
+
+// MarshalProto returns the protobuf encoding of the message
+func (m *PresentationHints) MarshalProto() []byte {
+	if m == nil {
+		return nil
+	}
+	var b []byte
+	for _, v := range m.Formats {
+		b = appendString(b, 1, v)
+	}
+	if m.MaxWidth != 0 {
+		b = appendUvarint(b, 2, uint64(m.MaxWidth))
+	}
+	if m.NoColor {
+		b = appendUvarint(b, 3, boolVarint(m.NoColor))
+	}
+	return b
+}
+
+// UnmarshalProto decodes the protobuf encoding of the message into m.
+// Fields m does not declare are skipped.
+func (m *PresentationHints) UnmarshalProto(data []byte) error {
+	d := decoder{b: data}
+	for num, typ, ok := d.next(); ok; num, typ, ok = d.next() {
+		switch num {
+		case 1:
+			m.Formats = append(m.Formats, d.string(typ))
+		case 2:
+			m.MaxWidth = int64(d.varint(typ))
+		case 3:
+			m.NoColor = d.varint(typ) != 0
+		default:
+			d.skip(typ)
+		}
+	}
+	return d.err
+}
+
+// ResultDelivery is how a caller wants the result of a call returned
+type ResultDelivery struct {
+	Mode string `json:"mode,omitempty"`
+	// Webhook endpoint, http or https
+	URL string `json:"url,omitempty"`
+	// Event topic the result is published to
+	Topic string `json:"topic,omitempty"`
+	// Token granting publish on the topic, if publishing is gated
+	Capability string `json:"capability,omitempty"`
+	// Seconds a sync call waits for its result; 0 waits as long as the broker allows
+	Timeout int64 `json:"timeout,omitempty"`
+}
+
+// MarshalProto returns the protobuf encoding of the message
+func (m *ResultDelivery) MarshalProto() []byte {
+	if m == nil {
+		return nil
+	}
+	var b []byte
+	if m.Mode != "" {
+		b = appendString(b, 1, m.Mode)
+	}
+	if m.URL != "" {
+		b = appendString(b, 2, m.URL)
+	}
+	if m.Topic != "" {
+		b = appendString(b, 3, m.Topic)
+	}
+	if m.Capability != "" {
+		b = appendString(b, 4, m.Capability)
+	}
+	if m.Timeout != 0 {
+		b = appendUvarint(b, 5, uint64(m.Timeout))
+	}
+	return b
+}
+
+// UnmarshalProto decodes the protobuf encoding of the message into m.
+// Fields m does not declare are skipped.
+func (m *ResultDelivery) UnmarshalProto(data []byte) error {
+	d := decoder{b: data}
+	for num, typ, ok := d.next(); ok; num, typ, ok = d.next() {
+		switch num {
+		case 1:
+			m.Mode = d.string(typ)
+		case 2:
+			m.URL = d.string(typ)
+		case 3:
+			m.Topic = d.string(typ)
+		case 4:
+			m.Capability = d.string(typ)
+		case 5:
+			m.Timeout = int64(d.varint(typ))
+		default:
+			d.skip(typ)
+		}
+	}
+	return d.err
+}
+
+type DiscoveredTool struct {
+	AgentID         string        `json:"agentId,omitempty"`
+	MCPEndpoint     string        `json:"mcpEndpoint,omitempty"`
+	Capabilities    []string      `json:"capabilities,omitempty"`
+	EnvironmentType string        `json:"environmentType,omitempty"`
+	MCPTools        []*MCPTool    `json:"mcpTools,omitempty"`
+	Metadata        *ToolMetadata `json:"metadata,omitempty"`
+	// Hardware the agent advertised in its body definition
+	Hardware *HardwareCapabilities `json:"hardware,omitempty"`
+}
+
+// MarshalProto returns the protobuf encoding of the message
+func (m *DiscoveredTool) MarshalProto() []byte {
+	if m == nil {
+		return nil
+	}
+	var b []byte
+	if m.AgentID != "" {
+		b = appendString(b, 1, m.AgentID)
+	}
+	if m.MCPEndpoint != "" {
+		b = appendString(b, 2, m.MCPEndpoint)
+	}
+	for _, v := range m.Capabilities {
+		b = appendString(b, 3, v)
+	}
+	if m.EnvironmentType != "" {
+		b = appendString(b, 4, m.EnvironmentType)
+	}
+	for _, v := range m.MCPTools {
+		b = appendBytes(b, 5, v.MarshalProto())
+	}
+	if m.Metadata != nil {
+		b = appendBytes(b, 6, m.Metadata.MarshalProto())
+	}
+	if m.Hardware != nil {
+		b = appendBytes(b, 7, m.Hardware.MarshalProto())
+	}
+	return b
+}
+
+// UnmarshalProto decodes the protobuf encoding of the message into m.
+// Fields m does not declare are skipped.
+func (m *DiscoveredTool) UnmarshalProto(data []byte) error {
+	d := decoder{b: data}
+	for num, typ, ok := d.next(); ok; num, typ, ok = d.next() {
+		switch num {
+		case 1:
+			m.AgentID = d.string(typ)
+		case 2:
+			m.MCPEndpoint = d.string(typ)
+		case 3:
+			m.Capabilities = append(m.Capabilities, d.string(typ))
+		case 4:
+			m.EnvironmentType = d.string(typ)
+		case 5:
+			v := new(MCPTool)
+			d.message(typ, v)
+			m.MCPTools = append(m.MCPTools, v)
+		case 6:
+			if m.Metadata == nil {
+				m.Metadata = new(ToolMetadata)
+			}
+			d.message(typ, m.Metadata)
+		case 7:
+			if m.Hardware == nil {
+				m.Hardware = new(HardwareCapabilities)
+			}
+			d.message(typ, m.Hardware)
+		default:
+			d.skip(typ)
+		}
+	}
+	return d.err
+}
+
+// DeliveredResult is a tool result held by the broker for a caller
+type DeliveredResult struct {
+	RequestID string `json:"requestId,omitempty"`
+	Delivery  string `json:"delivery,omitempty"`
+	// Seq of a toolResultChunk; 0 for a complete result
+	Chunk uint64 `json:"chunk,omitempty"`
+	// 1 on first delivery; higher on redelivery
+	Attempt int64 `json:"attempt,omitempty"`
+	// The envelope as signed by the answering agent
+	Envelope json.RawMessage `json:"envelope,omitempty"`
+}
+
+// MarshalProto returns the protobuf encoding of the message
+func (m *DeliveredResult) MarshalProto() []byte {
+	if m == nil {
+		return nil
+	}
+	var b []byte
+	if m.RequestID != "" {
+		b = appendString(b, 1, m.RequestID)
+	}
+	if m.Delivery != "" {
+		b = appendString(b, 2, m.Delivery)
+	}
+	if m.Chunk != 0 {
+		b = appendUvarint(b, 3, m.Chunk)
+	}
+	if m.Attempt != 0 {
+		b = appendUvarint(b, 4, uint64(m.Attempt))
+	}
+	if len(m.Envelope) > 0 {
+		b = appendBytes(b, 5, m.Envelope)
+	}
+	return b
+}
+
+// UnmarshalProto decodes the protobuf encoding of the message into m.
+// Fields m does not declare are skipped.
+func (m *DeliveredResult) UnmarshalProto(data []byte) error {
+	d := decoder{b: data}
+	for num, typ, ok := d.next(); ok; num, typ, ok = d.next() {
+		switch num {
+		case 1:
+			m.RequestID = d.string(typ)
+		case 2:
+			m.Delivery = d.string(typ)
+		case 3:
+			m.Chunk = d.varint(typ)
+		case 4:
+			m.Attempt = int64(d.varint(typ))
+		case 5:
+			m.Envelope = d.bytes(typ)
+		default:
+			d.skip(typ)
+		}
+	}
+	return d.err
+}
+
+// BatchItemResult is the response to one envelope of a batch
+type BatchItemResult struct {
+	// Position of the envelope in the batch
+	Index int64 `json:"index,omitempty"`
+	// Empty if the envelope could not be parsed
+	Type  string `json:"type,omitempty"`
+	Nonce string `json:"nonce,omitempty"`
+	// HTTP status the envelope would have received on its own
+	Status int64 `json:"status,omitempty"`
+	// The ack or error envelope answering it
+	Response json.RawMessage `json:"response,omitempty"`
+}
+
+// MarshalProto returns the protobuf encoding of the message
+func (m *BatchItemResult) MarshalProto() []byte {
+	if m == nil {
+		return nil
+	}
+	var b []byte
+	if m.Index != 0 {
+		b = appendUvarint(b, 1, uint64(m.Index))
+	}
+	if m.Type != "" {
+		b = appendString(b, 2, m.Type)
+	}
+	if m.Nonce != "" {
+		b = appendString(b, 3, m.Nonce)
+	}
+	if m.Status != 0 {
+		b = appendUvarint(b, 4, uint64(m.Status))
+	}
+	if len(m.Response) > 0 {
+		b = appendBytes(b, 5, m.Response)
+	}
+	return b
+}
+
+// UnmarshalProto decodes the protobuf encoding of the message into m.
+// Fields m does not declare are skipped.
+func (m *BatchItemResult) UnmarshalProto(data []byte) error {
+	d := decoder{b: data}
+	for num, typ, ok := d.next(); ok; num, typ, ok = d.next() {
+		switch num {
+		case 1:
+			m.Index = int64(d.varint(typ))
+		case 2:
+			m.Type = d.string(typ)
+		case 3:
+			m.Nonce = d.string(typ)
+		case 4:
+			m.Status = int64(d.varint(typ))
+		case 5:
+			m.Response = d.bytes(typ)
+		default:
+			d.skip(typ)
+		}
+	}
+	return d.err
+}
+
+// HardwareFilter selects agents by the GPUs they advertise
+type HardwareFilter struct {
+	// Case-insensitive substring of the model, e.g. "a100"
+	GPUModel string `json:"gpuModel,omitempty"`
+	// GPUs that must match; 1 if zero and another GPU filter is set
+	MinGPUs int64 `json:"minGpus,omitempty"`
+	// Per GPU
+	MinVRAMMB int64 `json:"minVramMb,omitempty"`
+	// e.g. "12.0"
+	MinCUDAVersion string `json:"minCudaVersion,omitempty"`
+}
+
+// MarshalProto returns the protobuf encoding of the message
+func (m *HardwareFilter) MarshalProto() []byte {
+	if m == nil {
+		return nil
+	}
+	var b []byte
+	if m.GPUModel != "" {
+		b = appendString(b, 1, m.GPUModel)
+	}
+	if m.MinGPUs != 0 {
+		b = appendUvarint(b, 2, uint64(m.MinGPUs))
+	}
+	if m.MinVRAMMB != 0 {
+		b = appendUvarint(b, 3, uint64(m.MinVRAMMB))
+	}
+	if m.MinCUDAVersion != "" {
+		b = appendString(b, 4, m.MinCUDAVersion)
+	}
+	return b
+}
+
+// UnmarshalProto decodes the protobuf encoding of the message into m.
+// Fields m does not declare are skipped.
+func (m *HardwareFilter) UnmarshalProto(data []byte) error {
+	d := decoder{b: data}
+	for num, typ, ok := d.next(); ok; num, typ, ok = d.next() {
+		switch num {
+		case 1:
+			m.GPUModel = d.string(typ)
+		case 2:
+			m.MinGPUs = int64(d.varint(typ))
+		case 3:
+			m.MinVRAMMB = int64(d.varint(typ))
+		case 4:
+			m.MinCUDAVersion = d.string(typ)
+		default:
+			d.skip(typ)
+		}
+	}
+	return d.err
+}
+
+type MCPTool struct {
+	Name        string          `json:"name,omitempty"`
+	Description string          `json:"description,omitempty"`
+	InputSchema json.RawMessage `json:"inputSchema,omitempty"`
+	// Regions the tool keeps call data in, overriding the body definition's
+	DataResidency []string `json:"dataResidency,omitempty"`
+	// Personal data classes the tool is cleared to exchange, e.g. ["email"]
+	PII []string `json:"pii,omitempty"`
+	// Resources one call needs; calls are only routed to agents reporting that much free
+	Resources *Resources `json:"resources,omitempty"`
+	// Price of one call; tools without one cost nothing, as local agents usually do
+	Cost *ToolCost `json:"cost,omitempty"`
+	// Whether the agent can resume calls of the tool from another agent's checkpoint
+	Resumable bool `json:"resumable,omitempty"`
+	// Media types the tool can return its result as, in preference order; application/json if empty
+	ResultTypes []string `json:"resultTypes,omitempty"`
+	// Whether calls wait for an approver's signed approval before they are delivered
+	Dangerous bool `json:"dangerous,omitempty"`
+	// Whether the broker may publish the tool in its public catalog feed
+	Listed bool `json:"listed,omitempty"`
+}
+
+// MarshalProto returns the protobuf encoding of the message
+func (m *MCPTool) MarshalProto() []byte {
+	if m == nil {
+		return nil
+	}
+	var b []byte
+	if m.Name != "" {
+		b = appendString(b, 1, m.Name)
+	}
+	if m.Description != "" {
+		b = appendString(b, 2, m.Description)
+	}
+	if len(m.InputSchema) > 0 {
+		b = appendBytes(b, 3, m.InputSchema)
+	}
+	for _, v := range m.DataResidency {
+		b = appendString(b, 4, v)
+	}
+	for _, v := range m.PII {
+		b = appendString(b, 5, v)
+	}
+	if m.Resources != nil {
+		b = appendBytes(b, 6, m.Resources.MarshalProto())
+	}
+	if m.Cost != nil {
+		b = appendBytes(b, 7, m.Cost.MarshalProto())
+	}
+	if m.Resumable {
+		b = appendUvarint(b, 8, boolVarint(m.Resumable))
+	}
+	for _, v := range m.ResultTypes {
+		b = appendString(b, 9, v)
+	}
+	if m.Dangerous {
+		b = appendUvarint(b, 10, boolVarint(m.Dangerous))
+	}
+	if m.Listed {
+		b = appendUvarint(b, 11, boolVarint(m.Listed))
+	}
+	return b
+}
+
+// UnmarshalProto decodes the protobuf encoding of the message into m.
+// Fields m does not declare are skipped.
+func (m *MCPTool) UnmarshalProto(data []byte) error {
+	d := decoder{b: data}
+	for num, typ, ok := d.next(); ok; num, typ, ok = d.next() {
+		switch num {
+		case 1:
+			m.Name = d.string(typ)
+		case 2:
+			m.Description = d.string(typ)
+		case 3:
+			m.InputSchema = d.bytes(typ)
+		case 4:
+			m.DataResidency = append(m.DataResidency, d.string(typ))
+		case 5:
+			m.PII = append(m.PII, d.string(typ))
+		case 6:
+			if m.Resources == nil {
+				m.Resources = new(Resources)
+			}
+			d.message(typ, m.Resources)
+		case 7:
+			if m.Cost == nil {
+				m.Cost = new(ToolCost)
+			}
+			d.message(typ, m.Cost)
+		case 8:
+			m.Resumable = d.varint(typ) != 0
+		case 9:
+			m.ResultTypes = append(m.ResultTypes, d.string(typ))
+		case 10:
+			m.Dangerous = d.varint(typ) != 0
+		case 11:
+			m.Listed = d.varint(typ) != 0
+		default:
+			d.skip(typ)
+		}
+	}
+	return d.err
+}
+
+// HardwareCapabilities describes the accelerators an agent offers
+type HardwareCapabilities struct {
+	GPUs []*GPUDevice `json:"gpus,omitempty"`
+}
+
+// MarshalProto returns the protobuf encoding of the message
+func (m *HardwareCapabilities) MarshalProto() []byte {
+	if m == nil {
+		return nil
+	}
+	var b []byte
+	for _, v := range m.GPUs {
+		b = appendBytes(b, 1, v.MarshalProto())
+	}
+	return b
+}
+
+// UnmarshalProto decodes the protobuf encoding of the message into m.
+// Fields m does not declare are skipped.
+func (m *HardwareCapabilities) UnmarshalProto(data []byte) error {
+	d := decoder{b: data}
+	for num, typ, ok := d.next(); ok; num, typ, ok = d.next() {
+		switch num {
+		case 1:
+			v := new(GPUDevice)
+			d.message(typ, v)
+			m.GPUs = append(m.GPUs, v)
+		default:
+			d.skip(typ)
+		}
+	}
+	return d.err
+}
+
+type ToolMetadata struct {
+	LastSeen int64 `json:"lastSeen,omitempty"`
+	// Milliseconds
+	AverageResponseTime int64 `json:"averageResponseTime,omitempty"`
+	// Milliseconds, over recent checks
+	P95ResponseTime int64   `json:"p95ResponseTime,omitempty"`
+	TrustScore      float64 `json:"trustScore,omitempty"`
+	// Fraction of checks that succeeded
+	SuccessRate float64 `json:"successRate,omitempty"`
+	// Calls routed to the agent
+	TotalInvocations int64 `json:"totalInvocations,omitempty"`
+	// Unix milliseconds
+	LastErrorAt      int64  `json:"lastErrorAt,omitempty"`
+	LastErrorMessage string `json:"lastErrorMessage,omitempty"`
+	// Live replicas behind the agent ID
+	Instances int64 `json:"instances,omitempty"`
+}
+
+// MarshalProto returns the protobuf encoding of the message
+func (m *ToolMetadata) MarshalProto() []byte {
+	if m == nil {
+		return nil
+	}
+	var b []byte
+	if m.LastSeen != 0 {
+		b = appendUvarint(b, 1, uint64(m.LastSeen))
+	}
+	if m.AverageResponseTime != 0 {
+		b = appendUvarint(b, 2, uint64(m.AverageResponseTime))
+	}
+	if m.P95ResponseTime != 0 {
+		b = appendUvarint(b, 3, uint64(m.P95ResponseTime))
+	}
+	if m.TrustScore != 0 {
+		b = appendFixed64(b, 4, math.Float64bits(m.TrustScore))
+	}
+	if m.SuccessRate != 0 {
+		b = appendFixed64(b, 5, math.Float64bits(m.SuccessRate))
+	}
+	if m.TotalInvocations != 0 {
+		b = appendUvarint(b, 6, uint64(m.TotalInvocations))
+	}
+	if m.LastErrorAt != 0 {
+		b = appendUvarint(b, 7, uint64(m.LastErrorAt))
+	}
+	if m.LastErrorMessage != "" {
+		b = appendString(b, 8, m.LastErrorMessage)
+	}
+	if m.Instances != 0 {
+		b = appendUvarint(b, 9, uint64(m.Instances))
+	}
+	return b
+}
+
+// UnmarshalProto decodes the protobuf encoding of the message into m.
+// Fields m does not declare are skipped.
+func (m *ToolMetadata) UnmarshalProto(data []byte) error {
+	d := decoder{b: data}
+	for num, typ, ok := d.next(); ok; num, typ, ok = d.next() {
+		switch num {
+		case 1:
+			m.LastSeen = int64(d.varint(typ))
+		case 2:
+			m.AverageResponseTime = int64(d.varint(typ))
+		case 3:
+			m.P95ResponseTime = int64(d.varint(typ))
+		case 4:
+			m.TrustScore = math.Float64frombits(d.fixed64(typ))
+		case 5:
+			m.SuccessRate = math.Float64frombits(d.fixed64(typ))
+		case 6:
+			m.TotalInvocations = int64(d.varint(typ))
+		case 7:
+			m.LastErrorAt = int64(d.varint(typ))
+		case 8:
+			m.LastErrorMessage = d.string(typ)
+		case 9:
+			m.Instances = int64(d.varint(typ))
+		default:
+			d.skip(typ)
+		}
+	}
+	return d.err
+}
+
+// ToolCost is what a tool charges, in the federation's billing currency
+type ToolCost struct {
+	PerCall float64 `json:"perCall,omitempty"`
+}
+
+// MarshalProto returns the protobuf encoding of the message
+func (m *ToolCost) MarshalProto() []byte {
+	if m == nil {
+		return nil
+	}
+	var b []byte
+	if m.PerCall != 0 {
+		b = appendFixed64(b, 1, math.Float64bits(m.PerCall))
+	}
+	return b
+}
+
+// UnmarshalProto decodes the protobuf encoding of the message into m.
+// Fields m does not declare are skipped.
+func (m *ToolCost) UnmarshalProto(data []byte) error {
+	d := decoder{b: data}
+	for num, typ, ok := d.next(); ok; num, typ, ok = d.next() {
+		switch num {
+		case 1:
+			m.PerCall = math.Float64frombits(d.fixed64(typ))
+		default:
+			d.skip(typ)
+		}
+	}
+	return d.err
+}
+
+// GPUDevice is one GPU an agent can run tools on
+type GPUDevice struct {
+	// e.g. "NVIDIA A100-SXM4-80GB"
+	Model string `json:"model,omitempty"`
+	// Mebibytes of memory on the device
+	VRAMMB int64 `json:"vramMb,omitempty"`
+	// Highest CUDA version the driver supports, e.g. "12.2"
+	CUDAVersion string `json:"cudaVersion,omitempty"`
+}
+
+// MarshalProto returns the protobuf encoding of the message
+func (m *GPUDevice) MarshalProto() []byte {
+	if m == nil {
+		return nil
+	}
+	var b []byte
+	if m.Model != "" {
+		b = appendString(b, 1, m.Model)
+	}
+	if m.VRAMMB != 0 {
+		b = appendUvarint(b, 2, uint64(m.VRAMMB))
+	}
+	if m.CUDAVersion != "" {
+		b = appendString(b, 3, m.CUDAVersion)
+	}
+	return b
+}
+
+// UnmarshalProto decodes the protobuf encoding of the message into m.
+// Fields m does not declare are skipped.
+func (m *GPUDevice) UnmarshalProto(data []byte) error {
+	d := decoder{b: data}
+	for num, typ, ok := d.next(); ok; num, typ, ok = d.next() {
+		switch num {
+		case 1:
+			m.Model = d.string(typ)
+		case 2:
+			m.VRAMMB = int64(d.varint(typ))
+		case 3:
+			m.CUDAVersion = d.string(typ)
+		default:
+			d.skip(typ)
+		}
+	}
+	return d.err
+}
+
+// setBody decodes a JSON body into the field of the envelope's type,
+// reporting whether the type has a message
+func (e *Envelope) setBody(body json.RawMessage) (bool, error) {
+	switch protocol.EnvelopeType(e.Type) {
+	case protocol.EnvelopeAck:
+		e.Ack = new(AckBody)
+		return true, fromJSON[protocol.AckBody](body, e.Ack)
+	case protocol.EnvelopeBatch:
+		e.Batch = new(BatchBody)
+		return true, fromJSON[protocol.BatchBody](body, e.Batch)
+	case protocol.EnvelopeCheckpoint:
+		e.Checkpoint = new(CheckpointBody)
+		return true, fromJSON[protocol.CheckpointBody](body, e.Checkpoint)
+	case protocol.EnvelopeDiscoverTools:
+		e.DiscoverTools = new(DiscoverToolsBody)
+		return true, fromJSON[protocol.DiscoverToolsBody](body, e.DiscoverTools)
+	case protocol.EnvelopeDrainInstance:
+		e.DrainInstance = new(DrainInstanceBody)
+		return true, fromJSON[protocol.DrainInstanceBody](body, e.DrainInstance)
+	case protocol.EnvelopeEmbodimentUpdate:
+		e.EmbodimentUpdate = new(EmbodimentUpdateBody)
+		return true, fromJSON[protocol.EmbodimentUpdateBody](body, e.EmbodimentUpdate)
+	case protocol.EnvelopeEmitEvent:
+		e.EmitEvent = new(EmitEventBody)
+		return true, fromJSON[protocol.EmitEventBody](body, e.EmitEvent)
+	case protocol.EnvelopeError:
+		e.Error = new(ErrorBody)
+		return true, fromJSON[protocol.ErrorBody](body, e.Error)
+	case protocol.EnvelopeHeartbeat:
+		e.Heartbeat = new(HeartbeatBody)
+		return true, fromJSON[protocol.HeartbeatBody](body, e.Heartbeat)
+	case protocol.EnvelopePreemptionNotice:
+		e.PreemptionNotice = new(PreemptionNoticeBody)
+		return true, fromJSON[protocol.PreemptionNoticeBody](body, e.PreemptionNotice)
+	case protocol.EnvelopeRegisterAgent:
+		e.RegisterAgent = new(RegisterAgentBody)
+		return true, fromJSON[protocol.RegisterAgentBody](body, e.RegisterAgent)
+	case protocol.EnvelopeRegisterBroker:
+		e.RegisterBroker = new(RegisterBrokerBody)
+		return true, fromJSON[protocol.RegisterBrokerBody](body, e.RegisterBroker)
+	case protocol.EnvelopeRenderInstruction:
+		e.RenderInstruction = new(RenderInstructionBody)
+		return true, fromJSON[protocol.RenderInstructionBody](body, e.RenderInstruction)
+	case protocol.EnvelopeResultAck:
+		e.ResultAck = new(ResultAckBody)
+		return true, fromJSON[protocol.ResultAckBody](body, e.ResultAck)
+	case protocol.EnvelopeRevoke:
+		e.Revoke = new(RevokeBody)
+		return true, fromJSON[protocol.RevokeBody](body, e.Revoke)
+	case protocol.EnvelopeRevokeCapability:
+		e.RevokeCapability = new(RevokeCapabilityBody)
+		return true, fromJSON[protocol.RevokeCapabilityBody](body, e.RevokeCapability)
+	case protocol.EnvelopeRotateKey:
+		e.RotateKey = new(RotateKeyBody)
+		return true, fromJSON[protocol.RotateKeyBody](body, e.RotateKey)
+	case protocol.EnvelopeSubscribe:
+		e.Subscribe = new(SubscribeBody)
+		return true, fromJSON[protocol.SubscribeBody](body, e.Subscribe)
+	case protocol.EnvelopeToolCall:
+		e.ToolCall = new(ToolCallBody)
+		return true, fromJSON[protocol.ToolCallBody](body, e.ToolCall)
+	case protocol.EnvelopeToolResult:
+		e.ToolResult = new(ToolResultBody)
+		return true, fromJSON[protocol.ToolResultBody](body, e.ToolResult)
+	case protocol.EnvelopeToolResultChunk:
+		e.ToolResultChunk = new(ToolResultChunkBody)
+		return true, fromJSON[protocol.ToolResultChunkBody](body, e.ToolResultChunk)
+	case protocol.EnvelopeToolsDiscovered:
+		e.ToolsDiscovered = new(ToolsDiscoveredBody)
+		return true, fromJSON[protocol.ToolsDiscoveredBody](body, e.ToolsDiscovered)
+	case protocol.EnvelopeUnsubscribe:
+		e.Unsubscribe = new(UnsubscribeBody)
+		return true, fromJSON[protocol.UnsubscribeBody](body, e.Unsubscribe)
+	case protocol.EnvelopeApproveCall:
+		e.ApproveCall = new(ApproveCallBody)
+		return true, fromJSON[protocol.ApproveCallBody](body, e.ApproveCall)
+	case protocol.EnvelopeAdminApproval:
+		e.AdminApproval = new(AdminApprovalBody)
+		return true, fromJSON[protocol.AdminApprovalBody](body, e.AdminApproval)
+	}
+	return false, nil
+}
+
+// typedBody returns the JSON of the body set in the field of the envelope's
+// type, reporting whether that field is set
+func (e *Envelope) typedBody() (json.RawMessage, bool, error) {
+	switch protocol.EnvelopeType(e.Type) {
+	case protocol.EnvelopeAck:
+		if e.Ack != nil {
+			body, err := toJSON[protocol.AckBody](e.Ack)
+			return body, true, err
+		}
+	case protocol.EnvelopeBatch:
+		if e.Batch != nil {
+			body, err := toJSON[protocol.BatchBody](e.Batch)
+			return body, true, err
+		}
+	case protocol.EnvelopeCheckpoint:
+		if e.Checkpoint != nil {
+			body, err := toJSON[protocol.CheckpointBody](e.Checkpoint)
+			return body, true, err
+		}
+	case protocol.EnvelopeDiscoverTools:
+		if e.DiscoverTools != nil {
+			body, err := toJSON[protocol.DiscoverToolsBody](e.DiscoverTools)
+			return body, true, err
+		}
+	case protocol.EnvelopeDrainInstance:
+		if e.DrainInstance != nil {
+			body, err := toJSON[protocol.DrainInstanceBody](e.DrainInstance)
+			return body, true, err
+		}
+	case protocol.EnvelopeEmbodimentUpdate:
+		if e.EmbodimentUpdate != nil {
+			body, err := toJSON[protocol.EmbodimentUpdateBody](e.EmbodimentUpdate)
+			return body, true, err
+		}
+	case protocol.EnvelopeEmitEvent:
+		if e.EmitEvent != nil {
+			body, err := toJSON[protocol.EmitEventBody](e.EmitEvent)
+			return body, true, err
+		}
+	case protocol.EnvelopeError:
+		if e.Error != nil {
+			body, err := toJSON[protocol.ErrorBody](e.Error)
+			return body, true, err
+		}
+	case protocol.EnvelopeHeartbeat:
+		if e.Heartbeat != nil {
+			body, err := toJSON[protocol.HeartbeatBody](e.Heartbeat)
+			return body, true, err
+		}
+	case protocol.EnvelopePreemptionNotice:
+		if e.PreemptionNotice != nil {
+			body, err := toJSON[protocol.PreemptionNoticeBody](e.PreemptionNotice)
+			return body, true, err
+		}
+	case protocol.EnvelopeRegisterAgent:
+		if e.RegisterAgent != nil {
+			body, err := toJSON[protocol.RegisterAgentBody](e.RegisterAgent)
+			return body, true, err
+		}
+	case protocol.EnvelopeRegisterBroker:
+		if e.RegisterBroker != nil {
+			body, err := toJSON[protocol.RegisterBrokerBody](e.RegisterBroker)
+			return body, true, err
+		}
+	case protocol.EnvelopeRenderInstruction:
+		if e.RenderInstruction != nil {
+			body, err := toJSON[protocol.RenderInstructionBody](e.RenderInstruction)
+			return body, true, err
+		}
+	case protocol.EnvelopeResultAck:
+		if e.ResultAck != nil {
+			body, err := toJSON[protocol.ResultAckBody](e.ResultAck)
+			return body, true, err
+		}
+	case protocol.EnvelopeRevoke:
+		if e.Revoke != nil {
+			body, err := toJSON[protocol.RevokeBody](e.Revoke)
+			return body, true, err
+		}
+	case protocol.EnvelopeRevokeCapability:
+		if e.RevokeCapability != nil {
+			body, err := toJSON[protocol.RevokeCapabilityBody](e.RevokeCapability)
+			return body, true, err
+		}
+	case protocol.EnvelopeRotateKey:
+		if e.RotateKey != nil {
+			body, err := toJSON[protocol.RotateKeyBody](e.RotateKey)
+			return body, true, err
+		}
+	case protocol.EnvelopeSubscribe:
+		if e.Subscribe != nil {
+			body, err := toJSON[protocol.SubscribeBody](e.Subscribe)
+			return body, true, err
+		}
+	case protocol.EnvelopeToolCall:
+		if e.ToolCall != nil {
+			body, err := toJSON[protocol.ToolCallBody](e.ToolCall)
+			return body, true, err
+		}
+	case protocol.EnvelopeToolResult:
+		if e.ToolResult != nil {
+			body, err := toJSON[protocol.ToolResultBody](e.ToolResult)
+			return body, true, err
+		}
+	case protocol.EnvelopeToolResultChunk:
+		if e.ToolResultChunk != nil {
+			body, err := toJSON[protocol.ToolResultChunkBody](e.ToolResultChunk)
+			return body, true, err
+		}
+	case protocol.EnvelopeToolsDiscovered:
+		if e.ToolsDiscovered != nil {
+			body, err := toJSON[protocol.ToolsDiscoveredBody](e.ToolsDiscovered)
+			return body, true, err
+		}
+	case protocol.EnvelopeUnsubscribe:
+		if e.Unsubscribe != nil {
+			body, err := toJSON[protocol.UnsubscribeBody](e.Unsubscribe)
+			return body, true, err
+		}
+	case protocol.EnvelopeApproveCall:
+		if e.ApproveCall != nil {
+			body, err := toJSON[protocol.ApproveCallBody](e.ApproveCall)
+			return body, true, err
+		}
+	case protocol.EnvelopeAdminApproval:
+		if e.AdminApproval != nil {
+			body, err := toJSON[protocol.AdminApprovalBody](e.AdminApproval)
+			return body, true, err
+		}
+	}
+	return nil, false, nil
+}
@@ -0,0 +1,298 @@
+package fempb
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/fep-fem/protocol"
+)
+
+// The Broker gRPC service, as declared in fem.proto. Brokers serve it over
+// HTTP/2 next to the JSON API, so the method paths are also URL paths.
+const (
+	ServiceName           = "fem.v1.Broker"
+	SubmitEnvelopeMethod  = "/" + ServiceName + "/SubmitEnvelope"
+	StreamEnvelopesMethod = "/" + ServiceName + "/StreamEnvelopes"
+)
+
+// ContentType is the content type of gRPC requests and responses
+const ContentType = "application/grpc+proto"
+
+// grpcHeaderSize is the compressed flag and big-endian length before each
+// gRPC message
+const grpcHeaderSize = 5
+
+// Code is a gRPC status code. Protocol failures are answered with error
+// envelopes as over HTTP; status codes report failures of the call itself.
+type Code uint32
+
+const (
+	CodeOK                Code = 0
+	CodeCanceled          Code = 1
+	CodeUnknown           Code = 2
+	CodeInvalidArgument   Code = 3
+	CodePermissionDenied  Code = 7
+	CodeResourceExhausted Code = 8
+	CodeUnimplemented     Code = 12
+	CodeInternal          Code = 13
+	CodeUnavailable       Code = 14
+)
+
+// Status is a call that ended with a code other than CodeOK
+type Status struct {
+	Code    Code
+	Message string
+}
+
+func (s *Status) Error() string {
+	return fmt.Sprintf("fempb: grpc status %d: %s", s.Code, s.Message)
+}
+
+// WriteStatus sets the trailers that end a gRPC response, after its last message
+func WriteStatus(w http.ResponseWriter, code Code, message string) {
+	w.Header().Set(http.TrailerPrefix+"Grpc-Status", strconv.FormatUint(uint64(code), 10))
+	if message != "" {
+		w.Header().Set(http.TrailerPrefix+"Grpc-Message", encodeGRPCMessage(message))
+	}
+}
+
+// WriteMessage writes an envelope as one uncompressed gRPC message
+func WriteMessage(w io.Writer, e *Envelope) error {
+	payload := e.MarshalProto()
+	if uint64(len(payload)) > math.MaxUint32 {
+		return fmt.Errorf("%w: %d bytes does not fit in a message", protocol.ErrEnvelopeTooLarge, len(payload))
+	}
+	message := make([]byte, grpcHeaderSize, grpcHeaderSize+len(payload))
+	binary.BigEndian.PutUint32(message[1:], uint32(len(payload)))
+	_, err := w.Write(append(message, payload...))
+	return err
+}
+
+// ReadMessage reads one gRPC message of at most maxSize bytes. A longer
+// message is consumed without being buffered and protocol.ErrEnvelopeTooLarge
+// is returned, so the reader stays aligned on the next one. io.EOF is
+// returned only between messages.
+func ReadMessage(r io.Reader, maxSize int) (*Envelope, error) {
+	var header [grpcHeaderSize]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return nil, err
+	}
+	size := int64(binary.BigEndian.Uint32(header[1:]))
+	if size > int64(maxSize) {
+		if _, err := io.CopyN(io.Discard, r, size); err != nil {
+			return nil, unexpectedEOF(err)
+		}
+		return nil, fmt.Errorf("%w: %d bytes exceeds limit of %d", protocol.ErrEnvelopeTooLarge, size, maxSize)
+	}
+	payload := make([]byte, size)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return nil, unexpectedEOF(err)
+	}
+	// No grpc-encoding is negotiated, so peers must not compress
+	if header[0] != 0 {
+		return nil, &Status{Code: CodeUnimplemented, Message: "compressed messages are not supported"}
+	}
+	e := new(Envelope)
+	if err := e.UnmarshalProto(payload); err != nil {
+		return nil, &Status{Code: CodeInvalidArgument, Message: err.Error()}
+	}
+	return e, nil
+}
+
+// unexpectedEOF reports an EOF inside a message as io.ErrUnexpectedEOF
+func unexpectedEOF(err error) error {
+	if errors.Is(err, io.EOF) {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+// Client calls a broker's gRPC service
+type Client struct {
+	brokerURL  string
+	httpClient *http.Client
+	maxSize    int
+}
+
+// NewClient returns a client of the broker at brokerURL. gRPC needs HTTP/2,
+// which the broker only negotiates over TLS.
+func NewClient(brokerURL string, config *tls.Config) *Client {
+	return &Client{
+		brokerURL: strings.TrimSuffix(brokerURL, "/"),
+		httpClient: &http.Client{Transport: &http.Transport{
+			TLSClientConfig:   config,
+			ForceAttemptHTTP2: true,
+		}},
+		maxSize: protocol.DefaultMaxEnvelopeSize,
+	}
+}
+
+// SetMaxEnvelopeSize bounds the envelopes received, which keep
+// protocol.DefaultMaxEnvelopeSize otherwise
+func (c *Client) SetMaxEnvelopeSize(size int) {
+	c.maxSize = size
+}
+
+// SubmitEnvelope sends one envelope and returns the broker's answer, the
+// envelope the JSON API would have responded with
+func (c *Client) SubmitEnvelope(ctx context.Context, e *Envelope) (*Envelope, error) {
+	var body bytes.Buffer
+	if err := WriteMessage(&body, e); err != nil {
+		return nil, err
+	}
+	resp, err := c.call(ctx, SubmitEnvelopeMethod, &body)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	answer, err := ReadMessage(resp.Body, c.maxSize)
+	if errors.Is(err, io.EOF) {
+		if err := callStatus(resp); err != nil {
+			return nil, err
+		}
+		return nil, &Status{Code: CodeInternal, Message: "broker sent no answer"}
+	}
+	if err != nil {
+		return nil, err
+	}
+	io.Copy(io.Discard, resp.Body)
+	if err := callStatus(resp); err != nil {
+		return nil, err
+	}
+	return answer, nil
+}
+
+// StreamEnvelopes opens a stream on which every envelope sent is answered in
+// order. A stream opened with a heartbeat also takes the tool calls the
+// broker pushes to the agent, as an agent channel does.
+func (c *Client) StreamEnvelopes(ctx context.Context) (*EnvelopeStream, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	reader, writer := io.Pipe()
+	resp, err := c.call(ctx, StreamEnvelopesMethod, reader)
+	if err != nil {
+		cancel()
+		writer.Close()
+		return nil, err
+	}
+	return &EnvelopeStream{resp: resp, send: writer, cancel: cancel, maxSize: c.maxSize}, nil
+}
+
+// call starts a call, returning once the broker sent its response headers
+func (c *Client) call(ctx context.Context, method string, body io.Reader) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.brokerURL+method, body)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", ContentType)
+	req.Header.Set("TE", "trailers")
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.ProtoMajor != 2 {
+		resp.Body.Close()
+		return nil, fmt.Errorf("fempb: broker answered over %s; gRPC needs HTTP/2", resp.Proto)
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, &Status{Code: CodeUnavailable, Message: fmt.Sprintf("broker answered %s", resp.Status)}
+	}
+	return resp, nil
+}
+
+// callStatus returns the status a finished call ended with, nil for CodeOK
+func callStatus(resp *http.Response) error {
+	status, message := resp.Trailer.Get("Grpc-Status"), resp.Trailer.Get("Grpc-Message")
+	if status == "" {
+		// A call refused outright ends with the status in its headers
+		status, message = resp.Header.Get("Grpc-Status"), resp.Header.Get("Grpc-Message")
+	}
+	if status == "" {
+		return &Status{Code: CodeInternal, Message: "call ended without a status"}
+	}
+	code, err := strconv.ParseUint(status, 10, 32)
+	if err != nil {
+		return &Status{Code: CodeUnknown, Message: fmt.Sprintf("invalid status %q", status)}
+	}
+	if Code(code) == CodeOK {
+		return nil
+	}
+	return &Status{Code: Code(code), Message: decodeGRPCMessage(message)}
+}
+
+// EnvelopeStream is an open StreamEnvelopes call
+type EnvelopeStream struct {
+	resp    *http.Response
+	send    *io.PipeWriter
+	sendMu  sync.Mutex
+	cancel  context.CancelFunc
+	maxSize int
+}
+
+// Send sends an envelope to the broker
+func (s *EnvelopeStream) Send(e *Envelope) error {
+	s.sendMu.Lock()
+	defer s.sendMu.Unlock()
+	return WriteMessage(s.send, e)
+}
+
+// Recv returns the next envelope from the broker: an answer, or a call pushed
+// to the agent. It returns io.EOF once the broker ended the stream cleanly.
+func (s *EnvelopeStream) Recv() (*Envelope, error) {
+	e, err := ReadMessage(s.resp.Body, s.maxSize)
+	if errors.Is(err, io.EOF) {
+		if err := callStatus(s.resp); err != nil {
+			return nil, err
+		}
+		return nil, io.EOF
+	}
+	return e, err
+}
+
+// CloseSend tells the broker no more envelopes will be sent; answers to
+// those sent can still be received
+func (s *EnvelopeStream) CloseSend() error {
+	return s.send.Close()
+}
+
+// Close abandons the stream
+func (s *EnvelopeStream) Close() error {
+	s.cancel()
+	s.send.Close()
+	return s.resp.Body.Close()
+}
+
+// encodeGRPCMessage percent-encodes a status message as gRPC requires
+func encodeGRPCMessage(message string) string {
+	var out strings.Builder
+	for i := 0; i < len(message); i++ {
+		c := message[i]
+		if c < ' ' || c > '~' || c == '%' {
+			fmt.Fprintf(&out, "%%%02X", c)
+			continue
+		}
+		out.WriteByte(c)
+	}
+	return out.String()
+}
+
+// decodeGRPCMessage reverses encodeGRPCMessage, keeping messages that are
+// not validly encoded as they are
+func decodeGRPCMessage(message string) string {
+	if decoded, err := url.PathUnescape(message); err == nil {
+		return decoded
+	}
+	return message
+}
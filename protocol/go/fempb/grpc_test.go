@@ -0,0 +1,60 @@
+package fempb
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"testing"
+
+	"github.com/fep-fem/protocol"
+)
+
+func TestGRPCMessagesStayAligned(t *testing.T) {
+	_, priv, _ := protocol.GenerateKeyPair()
+	heartbeat, _ := protocol.NewHeartbeat("worker").SignWith(priv)
+	data, _ := json.Marshal(heartbeat)
+	small, err := FromJSON(data)
+	if err != nil {
+		t.Fatalf("Failed to convert heartbeat: %v", err)
+	}
+	large := &Envelope{Type: string(protocol.EnvelopeToolResult), JSONBody: bytes.Repeat([]byte("x"), 4096)}
+
+	var stream bytes.Buffer
+	for _, e := range []*Envelope{small, large, small} {
+		if err := WriteMessage(&stream, e); err != nil {
+			t.Fatalf("Failed to write message: %v", err)
+		}
+	}
+	if e, err := ReadMessage(&stream, 1024); err != nil || e.Headers.Nonce != heartbeat.Nonce {
+		t.Fatalf("Expected the heartbeat, got %+v, %v", e, err)
+	}
+	// The oversized message is skipped, leaving the next one readable
+	if _, err := ReadMessage(&stream, 1024); !errors.Is(err, protocol.ErrEnvelopeTooLarge) {
+		t.Fatalf("Expected ErrEnvelopeTooLarge, got %v", err)
+	}
+	if e, err := ReadMessage(&stream, 1024); err != nil || e.Headers.Nonce != heartbeat.Nonce {
+		t.Fatalf("Expected the heartbeat after the skipped message, got %+v, %v", e, err)
+	}
+	if _, err := ReadMessage(&stream, 1024); err != io.EOF {
+		t.Errorf("Expected io.EOF between messages, got %v", err)
+	}
+
+	// Compressed messages were not negotiated
+	compressed := []byte{1, 0, 0, 0, 0}
+	var status *Status
+	if _, err := ReadMessage(bytes.NewReader(compressed), 1024); !errors.As(err, &status) || status.Code != CodeUnimplemented {
+		t.Errorf("Expected compressed messages refused, got %v", err)
+	}
+}
+
+func TestGRPCMessageEncoding(t *testing.T) {
+	message := "100% refused: naïve\n"
+	encoded := encodeGRPCMessage(message)
+	if encoded != "100%25 refused: na%C3%AFve%0A" {
+		t.Errorf("Unexpected encoding %q", encoded)
+	}
+	if decoded := decodeGRPCMessage(encoded); decoded != message {
+		t.Errorf("Expected %q back, got %q", message, decoded)
+	}
+}
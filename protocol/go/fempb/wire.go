@@ -0,0 +1,283 @@
+package fempb
+
+import (
+	"cmp"
+	"errors"
+	"fmt"
+	"slices"
+)
+
+// wireType is the protobuf encoding of a field value
+type wireType uint8
+
+const (
+	wireVarint  wireType = 0
+	wireFixed64 wireType = 1
+	wireBytes   wireType = 2
+	wireFixed32 wireType = 5
+)
+
+// maxFieldNumber is the largest field number protobuf allows
+const maxFieldNumber = 1<<29 - 1
+
+var errTruncated = errors.New("fempb: truncated message")
+
+func appendTag(b []byte, num int, typ wireType) []byte {
+	return appendVarint(b, uint64(num)<<3|uint64(typ))
+}
+
+func appendVarint(b []byte, v uint64) []byte {
+	for v >= 0x80 {
+		b = append(b, byte(v)|0x80)
+		v >>= 7
+	}
+	return append(b, byte(v))
+}
+
+// appendString writes a length-delimited string field
+func appendString(b []byte, num int, v string) []byte {
+	b = appendTag(b, num, wireBytes)
+	b = appendVarint(b, uint64(len(v)))
+	return append(b, v...)
+}
+
+// appendBytes writes a length-delimited field, such as bytes, an embedded
+// message or a packed repeated field
+func appendBytes(b []byte, num int, v []byte) []byte {
+	b = appendTag(b, num, wireBytes)
+	b = appendVarint(b, uint64(len(v)))
+	return append(b, v...)
+}
+
+func appendUvarint(b []byte, num int, v uint64) []byte {
+	return appendVarint(appendTag(b, num, wireVarint), v)
+}
+
+func appendFixed64(b []byte, num int, v uint64) []byte {
+	return appendLittleEndian(appendTag(b, num, wireFixed64), v, 8)
+}
+
+func appendFixed32(b []byte, num int, v uint32) []byte {
+	return appendLittleEndian(appendTag(b, num, wireFixed32), uint64(v), 4)
+}
+
+// appendLittleEndian writes the low size bytes of v, as fixed-size values are encoded
+func appendLittleEndian(b []byte, v uint64, size int) []byte {
+	for i := 0; i < size; i++ {
+		b = append(b, byte(v>>(8*i)))
+	}
+	return b
+}
+
+func boolVarint(v bool) uint64 {
+	if v {
+		return 1
+	}
+	return 0
+}
+
+// sortedKeys orders map entries so the same message always encodes to the
+// same bytes
+func sortedKeys[K cmp.Ordered, V any](m map[K]V) []K {
+	keys := make([]K, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	slices.Sort(keys)
+	return keys
+}
+
+// unmarshaler is implemented by every message
+type unmarshaler interface {
+	UnmarshalProto(data []byte) error
+}
+
+// decoder reads the fields of one message. The first error stops decoding
+// and is kept in err.
+type decoder struct {
+	b   []byte
+	err error
+}
+
+// next reads the tag of the next field, reporting false at the end of the
+// message or after an error
+func (d *decoder) next() (int, wireType, bool) {
+	if d.err != nil || len(d.b) == 0 {
+		return 0, 0, false
+	}
+	tag := d.rawVarint()
+	num := tag >> 3
+	if d.err == nil && (num == 0 || num > maxFieldNumber) {
+		d.err = fmt.Errorf("fempb: invalid field number %d", num)
+	}
+	return int(num), wireType(tag & 7), d.err == nil
+}
+
+func (d *decoder) rawVarint() uint64 {
+	var v uint64
+	for shift := uint(0); shift < 64; shift += 7 {
+		if len(d.b) == 0 {
+			break
+		}
+		c := d.b[0]
+		d.b = d.b[1:]
+		v |= uint64(c&0x7f) << shift
+		if c < 0x80 {
+			return v
+		}
+	}
+	d.fail(errTruncated)
+	return 0
+}
+
+func (d *decoder) rawBytes() []byte {
+	n := d.rawVarint()
+	if d.err != nil {
+		return nil
+	}
+	if n > uint64(len(d.b)) {
+		d.fail(errTruncated)
+		return nil
+	}
+	v := d.b[:n]
+	d.b = d.b[n:]
+	return v
+}
+
+func (d *decoder) rawFixed(size int) uint64 {
+	if len(d.b) < size {
+		d.fail(errTruncated)
+		return 0
+	}
+	var v uint64
+	for i := size - 1; i >= 0; i-- {
+		v = v<<8 | uint64(d.b[i])
+	}
+	d.b = d.b[size:]
+	return v
+}
+
+func (d *decoder) fail(err error) {
+	if d.err == nil {
+		d.err = err
+	}
+}
+
+// expect checks a field's wire type against the one its declared type uses
+func (d *decoder) expect(got, want wireType) bool {
+	if got != want {
+		d.fail(fmt.Errorf("fempb: field has wire type %d, want %d", got, want))
+		return false
+	}
+	return true
+}
+
+func (d *decoder) varint(typ wireType) uint64 {
+	if !d.expect(typ, wireVarint) {
+		return 0
+	}
+	return d.rawVarint()
+}
+
+func (d *decoder) fixed64(typ wireType) uint64 {
+	if !d.expect(typ, wireFixed64) {
+		return 0
+	}
+	return d.rawFixed(8)
+}
+
+func (d *decoder) fixed32(typ wireType) uint32 {
+	if !d.expect(typ, wireFixed32) {
+		return 0
+	}
+	return uint32(d.rawFixed(4))
+}
+
+func (d *decoder) string(typ wireType) string {
+	if !d.expect(typ, wireBytes) {
+		return ""
+	}
+	return string(d.rawBytes())
+}
+
+// bytes returns a copy, so decoded messages do not alias the input
+func (d *decoder) bytes(typ wireType) []byte {
+	if !d.expect(typ, wireBytes) {
+		return nil
+	}
+	return append([]byte(nil), d.rawBytes()...)
+}
+
+func (d *decoder) message(typ wireType, m unmarshaler) {
+	if !d.expect(typ, wireBytes) {
+		return
+	}
+	data := d.rawBytes()
+	if d.err == nil {
+		d.fail(m.UnmarshalProto(data))
+	}
+}
+
+// varints reads a repeated varint field, packed or not
+func (d *decoder) varints(typ wireType, add func(uint64)) {
+	if typ != wireBytes {
+		add(d.varint(typ))
+		return
+	}
+	packed := decoder{b: d.rawBytes()}
+	for d.err == nil && packed.err == nil && len(packed.b) > 0 {
+		add(packed.rawVarint())
+	}
+	d.fail(packed.err)
+}
+
+// fixeds reads a repeated fixed-size field, packed or not
+func (d *decoder) fixeds(typ wireType, size int, add func(uint64)) {
+	single := wireFixed64
+	if size == 4 {
+		single = wireFixed32
+	}
+	if typ != wireBytes {
+		if d.expect(typ, single) {
+			add(d.rawFixed(size))
+		}
+		return
+	}
+	packed := decoder{b: d.rawBytes()}
+	for d.err == nil && packed.err == nil && len(packed.b) > 0 {
+		add(packed.rawFixed(size))
+	}
+	d.fail(packed.err)
+}
+
+// entry reads a map entry, calling field for its key and value
+func (d *decoder) entry(typ wireType, field func(e *decoder, num int, typ wireType)) {
+	if !d.expect(typ, wireBytes) {
+		return
+	}
+	e := decoder{b: d.rawBytes()}
+	if d.err != nil {
+		return
+	}
+	for num, typ, ok := e.next(); ok; num, typ, ok = e.next() {
+		field(&e, num, typ)
+	}
+	d.fail(e.err)
+}
+
+// skip passes over a field the message does not declare, so messages from
+// newer senders still decode
+func (d *decoder) skip(typ wireType) {
+	switch typ {
+	case wireVarint:
+		d.rawVarint()
+	case wireFixed64:
+		d.rawFixed(8)
+	case wireBytes:
+		d.rawBytes()
+	case wireFixed32:
+		d.rawFixed(4)
+	default:
+		d.fail(fmt.Errorf("fempb: unsupported wire type %d", typ))
+	}
+}
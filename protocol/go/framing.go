@@ -0,0 +1,130 @@
+package protocol
+
+import (
+	"bufio"
+	"crypto/tls"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net"
+)
+
+// Framing is how envelopes are delimited on a stream connection. It is
+// negotiated with TLS ALPN during the handshake.
+type Framing string
+
+const (
+	FramingNDJSON         Framing = "fem-ndjson"  // One JSON envelope per line
+	FramingLengthPrefixed Framing = "fem-frame/1" // Version byte, 4-byte big-endian length, payload
+)
+
+// FrameVersion is the version byte leading every length-prefixed frame
+const FrameVersion byte = 1
+
+// frameHeaderSize is the version byte and the payload length
+const frameHeaderSize = 5
+
+// ErrUnsupportedFrameVersion is returned for a frame with an unknown version
+// byte. The frame's length cannot be trusted, so the connection is unusable.
+var ErrUnsupportedFrameVersion = errors.New("unsupported frame version")
+
+// StreamProtocols returns the ALPN protocols stream transports offer, most
+// preferred first. Peers predating framing offer none and get NDJSON.
+func StreamProtocols() []string {
+	return []string{string(FramingLengthPrefixed), string(FramingNDJSON)}
+}
+
+// NegotiateFraming completes the TLS handshake on conn if needed and returns
+// the framing both sides agreed on. Connections without TLS, or whose peer
+// did not offer length-prefixed frames, use NDJSON.
+func NegotiateFraming(conn net.Conn) (Framing, error) {
+	tlsConn, ok := conn.(*tls.Conn)
+	if !ok {
+		return FramingNDJSON, nil
+	}
+	if err := tlsConn.Handshake(); err != nil {
+		return "", err
+	}
+	return negotiatedFraming(tlsConn.ConnectionState()), nil
+}
+
+// negotiatedFraming returns the framing selected in a completed handshake
+func negotiatedFraming(state tls.ConnectionState) Framing {
+	if state.NegotiatedProtocol == string(FramingLengthPrefixed) {
+		return FramingLengthPrefixed
+	}
+	return FramingNDJSON
+}
+
+// WriteFrame writes payload as one length-prefixed frame
+func WriteFrame(w io.Writer, payload []byte) error {
+	frame, err := appendFrame(nil, payload)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(frame)
+	return err
+}
+
+// ReadFrame reads one length-prefixed frame of at most maxSize bytes and
+// returns its payload. A longer payload is consumed without being buffered
+// and ErrEnvelopeTooLarge is returned, so the reader stays aligned on the
+// next frame. io.EOF is returned only between frames.
+func ReadFrame(reader io.Reader, maxSize int) ([]byte, error) {
+	var header [frameHeaderSize]byte
+	if _, err := io.ReadFull(reader, header[:]); err != nil {
+		return nil, err
+	}
+	if header[0] != FrameVersion {
+		return nil, fmt.Errorf("%w: %d", ErrUnsupportedFrameVersion, header[0])
+	}
+
+	size := int64(binary.BigEndian.Uint32(header[1:]))
+	if size > int64(maxSize) {
+		if _, err := io.CopyN(io.Discard, reader, size); err != nil {
+			return nil, unexpectedEOF(err)
+		}
+		return nil, fmt.Errorf("%w: %d bytes exceeds limit of %d", ErrEnvelopeTooLarge, size, maxSize)
+	}
+	payload := make([]byte, size)
+	if _, err := io.ReadFull(reader, payload); err != nil {
+		return nil, unexpectedEOF(err)
+	}
+	return payload, nil
+}
+
+// appendFrame appends payload to dst as one length-prefixed frame
+func appendFrame(dst, payload []byte) ([]byte, error) {
+	if uint64(len(payload)) > math.MaxUint32 {
+		return nil, fmt.Errorf("%w: %d bytes does not fit in a frame", ErrEnvelopeTooLarge, len(payload))
+	}
+	dst = append(dst, FrameVersion)
+	dst = binary.BigEndian.AppendUint32(dst, uint32(len(payload)))
+	return append(dst, payload...), nil
+}
+
+// unexpectedEOF reports an EOF inside a frame as io.ErrUnexpectedEOF
+func unexpectedEOF(err error) error {
+	if errors.Is(err, io.EOF) {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+// encode delimits one envelope's JSON for sending with this framing
+func (f Framing) encode(data []byte) ([]byte, error) {
+	if f == FramingLengthPrefixed {
+		return appendFrame(nil, data)
+	}
+	return append(data[:len(data):len(data)], '\n'), nil
+}
+
+// read reads one envelope's JSON delimited with this framing
+func (f Framing) read(reader *bufio.Reader, maxSize int) ([]byte, error) {
+	if f == FramingLengthPrefixed {
+		return ReadFrame(reader, maxSize)
+	}
+	return ReadEnvelopeLine(reader, maxSize)
+}
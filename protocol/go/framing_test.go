@@ -0,0 +1,146 @@
+package protocol
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestFrameRoundTrip(t *testing.T) {
+	var buf bytes.Buffer
+	payloads := [][]byte{[]byte("{\"text\":\"line one\nline two\"}"), {}, bytes.Repeat([]byte("x"), 64)}
+	for _, payload := range payloads {
+		if err := WriteFrame(&buf, payload); err != nil {
+			t.Fatalf("WriteFrame failed: %v", err)
+		}
+	}
+
+	for _, want := range payloads {
+		got, err := ReadFrame(&buf, 1024)
+		if err != nil {
+			t.Fatalf("ReadFrame failed: %v", err)
+		}
+		if !bytes.Equal(got, want) {
+			t.Errorf("Expected %q, got %q", want, got)
+		}
+	}
+	if _, err := ReadFrame(&buf, 1024); err == nil {
+		t.Error("Expected EOF after the last frame")
+	}
+}
+
+func TestReadFrameSkipsOversizedFrame(t *testing.T) {
+	var buf bytes.Buffer
+	WriteFrame(&buf, bytes.Repeat([]byte("x"), 100))
+	WriteFrame(&buf, []byte(`{"ok":true}`))
+
+	if _, err := ReadFrame(&buf, 50); !errors.Is(err, ErrEnvelopeTooLarge) {
+		t.Fatalf("Expected ErrEnvelopeTooLarge, got %v", err)
+	}
+	payload, err := ReadFrame(&buf, 50)
+	if err != nil || string(payload) != `{"ok":true}` {
+		t.Errorf("Reader should stay aligned on the next frame, got %q, %v", payload, err)
+	}
+}
+
+func TestReadFrameRejectsUnknownVersion(t *testing.T) {
+	frame := []byte{FrameVersion + 1, 0, 0, 0, 2, '{', '}'}
+	if _, err := ReadFrame(bytes.NewReader(frame), 1024); !errors.Is(err, ErrUnsupportedFrameVersion) {
+		t.Errorf("Expected ErrUnsupportedFrameVersion, got %v", err)
+	}
+
+	truncated := []byte{FrameVersion, 0, 0, 0, 10, '{'}
+	if _, err := ReadFrame(bytes.NewReader(truncated), 1024); !errors.Is(err, io.ErrUnexpectedEOF) {
+		t.Errorf("Expected an unexpected EOF, got %v", err)
+	}
+}
+
+func TestStreamConnectionsNegotiateFraming(t *testing.T) {
+	server, _ := NewTransport(nil)
+	received := make(chan string, 2)
+	server.RegisterHandler(EnvelopeHeartbeat, func(envelope *Envelope, _ net.Conn) error {
+		received <- envelope.Agent
+		return nil
+	})
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go server.Serve(ctx, listener)
+	address := listener.Addr().String()
+
+	heartbeat := func(agent string) []byte {
+		envelope := &Envelope{Type: EnvelopeHeartbeat, CommonHeaders: newHeaders(agent), Body: []byte(`{"load":0,"inFlight":0,"uptime":0}`)}
+		data, err := json.Marshal(envelope)
+		if err != nil {
+			t.Fatalf("Failed to marshal: %v", err)
+		}
+		return data
+	}
+	expect := func(agent string) {
+		t.Helper()
+		select {
+		case got := <-received:
+			if got != agent {
+				t.Errorf("Expected a heartbeat from %s, got %s", agent, got)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("Heartbeat from %s was not handled", agent)
+		}
+	}
+
+	// Current peers agree on length-prefixed frames
+	framed, err := tls.Dial("tcp", address, &tls.Config{InsecureSkipVerify: true, NextProtos: StreamProtocols()})
+	if err != nil {
+		t.Fatalf("Failed to dial: %v", err)
+	}
+	defer framed.Close()
+	if framing, _ := NegotiateFraming(framed); framing != FramingLengthPrefixed {
+		t.Fatalf("Expected length-prefixed framing, got %q", framing)
+	}
+	if err := WriteFrame(framed, heartbeat("framed")); err != nil {
+		t.Fatalf("WriteFrame failed: %v", err)
+	}
+	expect("framed")
+
+	// Peers that offer no protocol keep using NDJSON
+	legacy, err := tls.Dial("tcp", address, &tls.Config{InsecureSkipVerify: true})
+	if err != nil {
+		t.Fatalf("Failed to dial: %v", err)
+	}
+	defer legacy.Close()
+	if framing, _ := NegotiateFraming(legacy); framing != FramingNDJSON {
+		t.Fatalf("Expected NDJSON framing, got %q", framing)
+	}
+	legacy.Write(append(heartbeat("legacy"), '\n'))
+	expect("legacy")
+}
+
+func TestStreamUsesNegotiatedFraming(t *testing.T) {
+	left, right := net.Pipe()
+	defer left.Close()
+	defer right.Close()
+
+	writer := NewStream(left)
+	writer.SetFraming(FramingLengthPrefixed)
+	envelope := &Envelope{Type: EnvelopeHeartbeat, CommonHeaders: newHeaders("worker"), Body: []byte("{\"note\":\"a\\nb\"}")}
+	go writer.WriteEnvelope(envelope)
+
+	reader := bufio.NewReader(right)
+	payload, err := ReadFrame(reader, DefaultMaxEnvelopeSize)
+	if err != nil {
+		t.Fatalf("Expected a frame, got %v", err)
+	}
+	if !bytes.Contains(payload, []byte(`"heartbeat"`)) {
+		t.Errorf("Unexpected frame payload %s", payload)
+	}
+}
@@ -2,4 +2,11 @@ module github.com/fep-fem/protocol
 
 go 1.21
 
-require github.com/golang-jwt/jwt/v5 v5.2.0
+require (
+	github.com/golang-jwt/jwt/v5 v5.2.0
+	github.com/klauspost/compress v1.17.11
+	github.com/miekg/pkcs11 v1.1.2
+	golang.org/x/crypto v0.24.0
+)
+
+require golang.org/x/sys v0.21.0 // indirect
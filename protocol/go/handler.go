@@ -1,6 +1,7 @@
 package protocol
 
 import (
+	"crypto/ed25519"
 	"encoding/json"
 	"fmt"
 )
@@ -11,8 +12,17 @@ type GenericEnvelope struct {
 	Body json.RawMessage `json:"body"`
 }
 
-// ParseEnvelope parses a generic envelope from JSON bytes
+// ParseEnvelope parses a generic envelope from JSON bytes. Envelopes that do
+// not match their schema are rejected with a *SchemaError listing each field.
+// A compressed body is inflated first, see InflateBody.
 func ParseEnvelope(data []byte) (*GenericEnvelope, error) {
+	data, err := InflateBody(data)
+	if err != nil {
+		return nil, err
+	}
+	if err := ValidateEnvelope(data); err != nil {
+		return nil, err
+	}
 	var envelope GenericEnvelope
 	if err := json.Unmarshal(data, &envelope); err != nil {
 		return nil, fmt.Errorf("failed to parse envelope: %w", err)
@@ -21,6 +31,8 @@ func ParseEnvelope(data []byte) (*GenericEnvelope, error) {
 }
 
 // ParseTypedEnvelope parses a generic envelope into a specific typed envelope
+//
+// Deprecated: use ParseTyped or an EnvelopeMux, which check body types at compile time.
 func (g *GenericEnvelope) ParseTypedEnvelope() (interface{}, error) {
 	switch g.Type {
 	case EnvelopeRegisterAgent:
@@ -87,4 +99,14 @@ func (g *GenericEnvelope) ParseTypedEnvelope() (interface{}, error) {
 // GetBodyAs unmarshals the envelope body into the provided struct
 func (g *GenericEnvelope) GetBodyAs(v interface{}) error {
 	return json.Unmarshal(g.Body, v)
-}
\ No newline at end of file
+}
+
+// Verify verifies the envelope signature with the given public key
+func (g *GenericEnvelope) Verify(publicKey ed25519.PublicKey) error {
+	envelope := &Envelope{
+		Type:          g.Type,
+		CommonHeaders: g.CommonHeaders,
+		Body:          g.Body,
+	}
+	return envelope.Verify(publicKey)
+}
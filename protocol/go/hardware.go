@@ -0,0 +1,95 @@
+package protocol
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Matches reports whether hardware satisfies the filter: at least MinGPUs of
+// its GPUs each match the model, VRAM and CUDA version asked for. A nil or
+// empty filter matches any agent, including one that advertises no hardware.
+func (f *HardwareFilter) Matches(hardware *HardwareCapabilities) bool {
+	if f == nil {
+		return true
+	}
+	needed := f.MinGPUs
+	if needed == 0 && (f.GPUModel != "" || f.MinVRAMMB > 0 || f.MinCUDAVersion != "") {
+		needed = 1
+	}
+	if needed == 0 {
+		return true
+	}
+	if hardware == nil {
+		return false
+	}
+
+	matching := 0
+	for _, gpu := range hardware.GPUs {
+		if f.matchesGPU(gpu) {
+			matching++
+		}
+	}
+	return matching >= needed
+}
+
+// matchesGPU checks one device against the model, VRAM and CUDA filters
+func (f *HardwareFilter) matchesGPU(gpu GPUDevice) bool {
+	if f.GPUModel != "" && !strings.Contains(strings.ToLower(gpu.Model), strings.ToLower(f.GPUModel)) {
+		return false
+	}
+	if gpu.VRAMMB < f.MinVRAMMB {
+		return false
+	}
+	if f.MinCUDAVersion != "" {
+		minimum, err := ParseVersion(f.MinCUDAVersion)
+		if err != nil {
+			return false
+		}
+		version, err := ParseVersion(gpu.CUDAVersion)
+		if err != nil || version.Compare(minimum) < 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// validateHardware checks every GPU is named with a sane VRAM and CUDA version
+func validateHardware(field string, hardware *HardwareCapabilities) error {
+	if hardware == nil {
+		return nil
+	}
+	for i, gpu := range hardware.GPUs {
+		gpuField := fmt.Sprintf("%s.gpus[%d]", field, i)
+		if err := required(gpuField+".model", gpu.Model); err != nil {
+			return err
+		}
+		if gpu.VRAMMB < 0 {
+			return invalid(gpuField+".vramMb", "must not be negative")
+		}
+		if gpu.CUDAVersion != "" {
+			if _, err := ParseVersion(gpu.CUDAVersion); err != nil {
+				return invalid(gpuField+".cudaVersion", "%v", err)
+			}
+		}
+	}
+	return nil
+}
+
+// validateHardwareFilter checks the filter's minimums
+func validateHardwareFilter(field string, filter *HardwareFilter) error {
+	if filter == nil {
+		return nil
+	}
+	if filter.MinGPUs < 0 {
+		return invalid(field+".minGpus", "must not be negative")
+	}
+	if filter.MinVRAMMB < 0 {
+		return invalid(field+".minVramMb", "must not be negative")
+	}
+	if filter.MinCUDAVersion != "" {
+		if _, err := ParseVersion(filter.MinCUDAVersion); err != nil {
+			return invalid(field+".minCudaVersion", "%v", err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,38 @@
+package protocol
+
+import "testing"
+
+func TestHardwareFilterMatches(t *testing.T) {
+	hardware := &HardwareCapabilities{GPUs: []GPUDevice{
+		{Model: "NVIDIA A100-SXM4-80GB", VRAMMB: 81920, CUDAVersion: "12.2"},
+		{Model: "NVIDIA A100-SXM4-80GB", VRAMMB: 81920, CUDAVersion: "12.2"},
+		{Model: "NVIDIA T4", VRAMMB: 15360, CUDAVersion: "11.8"},
+	}}
+
+	for _, tt := range []struct {
+		name    string
+		filter  *HardwareFilter
+		matches bool
+	}{
+		{"no filter", nil, true},
+		{"empty filter", &HardwareFilter{}, true},
+		{"model substring", &HardwareFilter{GPUModel: "a100"}, true},
+		{"missing model", &HardwareFilter{GPUModel: "H100"}, false},
+		{"enough matching gpus", &HardwareFilter{GPUModel: "A100", MinGPUs: 2}, true},
+		{"too few matching gpus", &HardwareFilter{MinVRAMMB: 40000, MinGPUs: 3}, false},
+		{"any gpu count", &HardwareFilter{MinGPUs: 3}, true},
+		{"cuda version", &HardwareFilter{MinCUDAVersion: "12"}, true},
+		{"cuda too old", &HardwareFilter{GPUModel: "T4", MinCUDAVersion: "12.0"}, false},
+	} {
+		if got := tt.filter.Matches(hardware); got != tt.matches {
+			t.Errorf("%s: Matches = %v, want %v", tt.name, got, tt.matches)
+		}
+	}
+
+	if (&HardwareFilter{GPUModel: "A100"}).Matches(nil) {
+		t.Error("Agents without hardware should not match a GPU filter")
+	}
+	if !(&HardwareFilter{}).Matches(nil) {
+		t.Error("An empty filter should match agents without hardware")
+	}
+}
@@ -0,0 +1,219 @@
+package protocol
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf16"
+)
+
+// CanonicalSigningVersion is the first protocol version whose envelope
+// signatures cover RFC 8785 canonical JSON. Envelopes from older senders are
+// signed over the Go encoding of the signing input.
+const CanonicalSigningVersion = "0.4.0"
+
+// Canonicalize rewrites a JSON document in the JSON Canonicalization Scheme
+// (RFC 8785): object members sorted by UTF-16 code units, no insignificant
+// whitespace, minimal string escaping and ECMAScript number formatting. Any
+// implementation that parses and re-serializes the document produces the same
+// canonical bytes.
+func Canonicalize(data []byte) ([]byte, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+
+	var buf bytes.Buffer
+	if err := canonicalValue(dec, &buf); err != nil {
+		return nil, err
+	}
+	if _, err := dec.Token(); err != io.EOF {
+		return nil, fmt.Errorf("canonicalize: unexpected data after JSON value")
+	}
+	return buf.Bytes(), nil
+}
+
+// MarshalCanonical encodes v as RFC 8785 canonical JSON
+func MarshalCanonical(v interface{}) ([]byte, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	return Canonicalize(data)
+}
+
+// canonicalValue reads the next value from dec and writes its canonical form
+func canonicalValue(dec *json.Decoder, buf *bytes.Buffer) error {
+	tok, err := dec.Token()
+	if err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return fmt.Errorf("canonicalize: %w", err)
+	}
+
+	switch t := tok.(type) {
+	case json.Delim:
+		if t == '{' {
+			return canonicalObject(dec, buf)
+		}
+		return canonicalArray(dec, buf)
+	case string:
+		writeCanonicalString(buf, t)
+	case json.Number:
+		number, err := canonicalNumber(t)
+		if err != nil {
+			return err
+		}
+		buf.WriteString(number)
+	case bool:
+		buf.WriteString(strconv.FormatBool(t))
+	case nil:
+		buf.WriteString("null")
+	}
+	return nil
+}
+
+// canonicalObject writes an object's members sorted by key. Duplicate keys are
+// rejected, since parsers disagree on which one wins.
+func canonicalObject(dec *json.Decoder, buf *bytes.Buffer) error {
+	members := make(map[string][]byte)
+	var keys []string
+	for dec.More() {
+		tok, err := dec.Token()
+		if err != nil {
+			return fmt.Errorf("canonicalize: %w", err)
+		}
+		key := tok.(string)
+		if _, exists := members[key]; exists {
+			return fmt.Errorf("canonicalize: duplicate key %q", key)
+		}
+
+		var value bytes.Buffer
+		if err := canonicalValue(dec, &value); err != nil {
+			return err
+		}
+		members[key] = value.Bytes()
+		keys = append(keys, key)
+	}
+	if _, err := dec.Token(); err != nil {
+		return fmt.Errorf("canonicalize: %w", err)
+	}
+
+	sort.Slice(keys, func(i, j int) bool { return lessUTF16(keys[i], keys[j]) })
+	buf.WriteByte('{')
+	for i, key := range keys {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		writeCanonicalString(buf, key)
+		buf.WriteByte(':')
+		buf.Write(members[key])
+	}
+	buf.WriteByte('}')
+	return nil
+}
+
+// canonicalArray writes an array's elements in order
+func canonicalArray(dec *json.Decoder, buf *bytes.Buffer) error {
+	buf.WriteByte('[')
+	for i := 0; dec.More(); i++ {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		if err := canonicalValue(dec, buf); err != nil {
+			return err
+		}
+	}
+	if _, err := dec.Token(); err != nil {
+		return fmt.Errorf("canonicalize: %w", err)
+	}
+	buf.WriteByte(']')
+	return nil
+}
+
+// lessUTF16 orders strings by their UTF-16 code units, as RFC 8785 requires
+func lessUTF16(a, b string) bool {
+	ua, ub := utf16.Encode([]rune(a)), utf16.Encode([]rune(b))
+	for i := 0; i < len(ua) && i < len(ub); i++ {
+		if ua[i] != ub[i] {
+			return ua[i] < ub[i]
+		}
+	}
+	return len(ua) < len(ub)
+}
+
+// writeCanonicalString escapes only quotes, backslashes and control characters
+func writeCanonicalString(buf *bytes.Buffer, s string) {
+	buf.WriteByte('"')
+	for _, r := range s {
+		switch r {
+		case '"':
+			buf.WriteString(`\"`)
+		case '\\':
+			buf.WriteString(`\\`)
+		case '\b':
+			buf.WriteString(`\b`)
+		case '\f':
+			buf.WriteString(`\f`)
+		case '\n':
+			buf.WriteString(`\n`)
+		case '\r':
+			buf.WriteString(`\r`)
+		case '\t':
+			buf.WriteString(`\t`)
+		default:
+			if r < 0x20 {
+				fmt.Fprintf(buf, `\u%04x`, r)
+			} else {
+				buf.WriteRune(r)
+			}
+		}
+	}
+	buf.WriteByte('"')
+}
+
+// canonicalNumber formats a number as ECMAScript's Number.prototype.toString does
+func canonicalNumber(n json.Number) (string, error) {
+	f, err := strconv.ParseFloat(string(n), 64)
+	if err != nil {
+		if errors.Is(err, strconv.ErrRange) {
+			return "", fmt.Errorf("canonicalize: number %s is out of range", n)
+		}
+		return "", fmt.Errorf("canonicalize: invalid number %s", n)
+	}
+	if math.IsNaN(f) || math.IsInf(f, 0) {
+		return "", fmt.Errorf("canonicalize: number %s is not finite", n)
+	}
+	if f == 0 {
+		return "0", nil
+	}
+
+	sign := ""
+	if f < 0 {
+		sign, f = "-", -f
+	}
+	format := byte('e')
+	if f >= 1e-6 && f < 1e21 {
+		format = 'f'
+	}
+	formatted := strconv.FormatFloat(f, format, -1, 64)
+	// Go pads exponents to two digits ("1e+07"); ECMAScript does not ("1e+7")
+	if i := strings.IndexByte(formatted, 'e'); i > 0 && formatted[i+2] == '0' {
+		formatted = formatted[:i+2] + formatted[i+3:]
+	}
+	return sign + formatted, nil
+}
+
+// usesCanonicalSigning reports whether envelopes declaring proto are signed over canonical JSON
+func usesCanonicalSigning(proto string) bool {
+	if proto == "" {
+		return false
+	}
+	cmp, err := CompareVersions(proto, CanonicalSigningVersion)
+	return err == nil && cmp >= 0
+}
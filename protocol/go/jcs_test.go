@@ -0,0 +1,88 @@
+package protocol
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestCanonicalize(t *testing.T) {
+	tests := []struct {
+		in, want string
+	}{
+		// Members sorted by UTF-16 code units, whitespace removed
+		{`{ "b": 1, "a": [true, false, null], "c": {"z": "", "y": {}} }`, `{"a":[true,false,null],"b":1,"c":{"y":{},"z":""}}`},
+		// Example from RFC 8785 section 3.2.3: U+1F600 sorts before U+FB33 in UTF-16
+		{`{"\u20ac":"Euro Sign","\r":"Carriage Return","\ufb33":"Hebrew Letter Dalet With Dagesh","1":"One","\ud83d\ude00":"Emoji: Grinning Face","\u0080":"Control","\u00f6":"Latin Small Letter O With Diaeresis"}`,
+			"{\"\\r\":\"Carriage Return\",\"1\":\"One\",\"\u0080\":\"Control\",\"\u00f6\":\"Latin Small Letter O With Diaeresis\",\"\u20ac\":\"Euro Sign\",\"\U0001f600\":\"Emoji: Grinning Face\",\"\ufb33\":\"Hebrew Letter Dalet With Dagesh\"}"},
+		// Only quotes, backslashes and control characters are escaped
+		{`"<a href=\"x\">&\u001f\u2028\/"`, "\"<a href=\\\"x\\\">&\\u001f\u2028/\""},
+		// ECMAScript number formatting
+		{`[1.0, -0, 1e21, 1e20, 0.000001, 1e-7, 333333333.33333329, 1E30, 4.50, 2e-3, 9007199254740993]`,
+			`[1,0,1e+21,100000000000000000000,0.000001,1e-7,333333333.3333333,1e+30,4.5,0.002,9007199254740992]`},
+	}
+	for _, tt := range tests {
+		got, err := Canonicalize([]byte(tt.in))
+		if err != nil {
+			t.Errorf("Canonicalize(%s) failed: %v", tt.in, err)
+			continue
+		}
+		if string(got) != tt.want {
+			t.Errorf("Canonicalize(%s)\n got: %s\nwant: %s", tt.in, got, tt.want)
+		}
+	}
+
+	for _, bad := range []string{`{"a":1,"a":2}`, `[1e400]`, `{"a":1} {}`, `{"a":`} {
+		if _, err := Canonicalize([]byte(bad)); err == nil {
+			t.Errorf("Canonicalize(%s) should fail", bad)
+		}
+	}
+}
+
+func TestCanonicalSignatureSurvivesReserialization(t *testing.T) {
+	pubKey, privKey, _ := GenerateKeyPair()
+	envelope, err := NewToolCall("test-agent").
+		Tool("html.render").
+		Param("markup", "<b>&</b>").
+		Param("scale", 1.5).
+		Param("options", map[string]interface{}{"width": 80, "color": true}).
+		SignWith(privKey)
+	if err != nil {
+		t.Fatalf("Failed to sign: %v", err)
+	}
+
+	// Another implementation might reorder keys, skip HTML escaping and format numbers differently
+	body := `{"requestId":"` + envelope.Body.RequestID + `","parameters":{"scale":15e-1,"options":{"color":true,"width":80.0},"markup":"<b>&</b>"},"tool":"html.render"}`
+	data, _ := json.Marshal(envelope)
+	var fields map[string]json.RawMessage
+	json.Unmarshal(data, &fields)
+	fields["body"] = json.RawMessage(body)
+	data, _ = json.MarshalIndent(fields, "", "    ")
+
+	generic, err := ParseEnvelope([]byte(strings.ReplaceAll(string(data), `\u003c`, "<")))
+	if err != nil {
+		t.Fatalf("Failed to parse: %v", err)
+	}
+	if err := generic.Verify(pubKey); err != nil {
+		t.Errorf("Re-serialized envelope should verify: %v", err)
+	}
+
+	generic.Body = json.RawMessage(strings.Replace(body, "html.render", "html.evil", 1))
+	if err := generic.Verify(pubKey); err == nil {
+		t.Error("Modified body should not verify")
+	}
+}
+
+func TestLegacySigningForOlderSenders(t *testing.T) {
+	headers := CommonHeaders{Agent: "old-agent", TS: 1, Nonce: "n", Proto: "0.3.0"}
+	legacy, _ := SigningBytes(EnvelopeEmitEvent, headers, EmitEventBody{Event: "<x>"})
+	if !strings.Contains(string(legacy), `\u003cx\u003e`) {
+		t.Errorf("Senders before %s should keep the Go encoding, got %s", CanonicalSigningVersion, legacy)
+	}
+
+	headers.Proto = CanonicalSigningVersion
+	canonical, _ := SigningBytes(EnvelopeEmitEvent, headers, EmitEventBody{Event: "<x>"})
+	if want := `{"agent":"old-agent","body":{"event":"<x>","payload":null},"nonce":"n","proto":"0.4.0","ts":1,"type":"emitEvent"}`; string(canonical) != want {
+		t.Errorf("Canonical signing bytes\n got: %s\nwant: %s", canonical, want)
+	}
+}
@@ -0,0 +1,71 @@
+package protocol
+
+import (
+	"crypto/ed25519"
+	"fmt"
+)
+
+// RotationCoSigner names the co-signature by the new key on a rotateKey envelope
+const RotationCoSigner = "rotateKey:newKey"
+
+// Sign signs the rotation with the agent's current key
+func (e *RotateKeyEnvelope) Sign(signer Signer) error {
+	return signEnvelope(e.Type, &e.CommonHeaders, e.Body, signer)
+}
+
+// CoSign adds the new key's proof of possession
+func (e *RotateKeyEnvelope) CoSign(signer string, key Signer) error {
+	return coSign(e.Type, &e.CommonHeaders, e.Body, signer, key)
+}
+
+// NewKeyRotation builds a rotateKey envelope replacing currentKey with newKey,
+// signed by the current key and co-signed by the new one
+func NewKeyRotation(agent string, currentKey, newKey Signer, reason string) (*RotateKeyEnvelope, error) {
+	if agent == "" {
+		return nil, fmt.Errorf("%w: agent", ErrMissingField)
+	}
+	currentPublic, err := SignerPublicKey(currentKey)
+	if err != nil {
+		return nil, err
+	}
+	newPublic, err := SignerPublicKey(newKey)
+	if err != nil {
+		return nil, err
+	}
+	if newPublic.Equal(currentPublic) {
+		return nil, fmt.Errorf("new key must differ from the current key")
+	}
+
+	envelope := &RotateKeyEnvelope{
+		BaseEnvelope: BaseEnvelope{Type: EnvelopeRotateKey, CommonHeaders: newHeaders(agent)},
+		Body:         RotateKeyBody{NewPubKey: EncodePublicKey(newPublic), Reason: reason},
+	}
+	if err := envelope.Sign(currentKey); err != nil {
+		return nil, err
+	}
+	if err := envelope.CoSign(RotationCoSigner, newKey); err != nil {
+		return nil, err
+	}
+	return envelope, nil
+}
+
+// VerifyKeyRotation checks a rotateKey envelope is signed by the agent's
+// current key and co-signed by the key it introduces, returning the new key
+func VerifyKeyRotation(env *GenericEnvelope, currentKey ed25519.PublicKey) (ed25519.PublicKey, error) {
+	typed, err := ParseTyped[RotateKeyBody](env)
+	if err != nil {
+		return nil, err
+	}
+	newKey, err := DecodePublicKey(typed.Body.NewPubKey)
+	if err != nil {
+		return nil, err
+	}
+	if newKey.Equal(currentKey) {
+		return nil, fmt.Errorf("new key must differ from the current key")
+	}
+	policy := AllOf(map[string]ed25519.PublicKey{env.Agent: currentKey, RotationCoSigner: newKey})
+	if err := env.VerifyPolicy(policy); err != nil {
+		return nil, fmt.Errorf("key rotation not signed by both keys: %w", err)
+	}
+	return newKey, nil
+}
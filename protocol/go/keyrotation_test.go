@@ -0,0 +1,46 @@
+package protocol
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestKeyRotationNeedsBothKeys(t *testing.T) {
+	oldPub, oldPriv, _ := GenerateKeyPair()
+	newPub, newPriv, _ := GenerateKeyPair()
+	_, otherPriv, _ := GenerateKeyPair()
+
+	parse := func(envelope *RotateKeyEnvelope) *GenericEnvelope {
+		data, _ := json.Marshal(envelope)
+		env, err := ParseEnvelope(data)
+		if err != nil {
+			t.Fatalf("Failed to parse rotation: %v", err)
+		}
+		return env
+	}
+
+	rotation, err := NewKeyRotation("agent", oldPriv, newPriv, "scheduled")
+	if err != nil {
+		t.Fatalf("NewKeyRotation failed: %v", err)
+	}
+	got, err := VerifyKeyRotation(parse(rotation), oldPub)
+	if err != nil || !got.Equal(newPub) {
+		t.Fatalf("Rotation should verify and return the new key, got %v %v", got, err)
+	}
+
+	// Claiming a key the sender does not hold fails the proof of possession
+	forged, _ := NewKeyRotation("agent", oldPriv, otherPriv, "")
+	forged.Body.NewPubKey = EncodePublicKey(newPub)
+	forged.Sign(oldPriv)
+	if _, err := VerifyKeyRotation(parse(forged), oldPub); err == nil {
+		t.Error("Rotation co-signed by a different key should be rejected")
+	}
+
+	// A rotation not signed by the current key is rejected
+	if _, err := VerifyKeyRotation(parse(rotation), newPub); err == nil {
+		t.Error("Rotation should be checked against the current key")
+	}
+	if _, err := NewKeyRotation("agent", oldPriv, oldPriv, ""); err == nil {
+		t.Error("Rotating to the same key should fail")
+	}
+}
@@ -0,0 +1,263 @@
+package protocol
+
+import (
+	"crypto/cipher"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/crypto/chacha20poly1305"
+	"golang.org/x/crypto/scrypt"
+)
+
+// PassphraseEnv names the environment variable key passphrases are read from
+// when no passphrase file is given
+const PassphraseEnv = "FEM_KEY_PASSPHRASE"
+
+// keystoreVersion is the version of the encrypted key file format
+const keystoreVersion = 1
+
+// Key derivation and cipher identifiers recorded in key files
+const (
+	keystoreKDF    = "scrypt"
+	keystoreCipher = "chacha20-poly1305"
+)
+
+// scrypt cost parameters for new key files. Existing files keep the parameters
+// they were written with.
+const (
+	keystoreScryptN = 1 << 15
+	keystoreScryptR = 8
+	keystoreScryptP = 1
+
+	maxKeystoreScryptN  = 1 << 20
+	maxKeystoreScryptRP = 64
+)
+
+// ErrWrongPassphrase is returned when an encrypted key file does not decrypt,
+// because the passphrase is wrong or the file was altered
+var ErrWrongPassphrase = errors.New("wrong passphrase or corrupted key file")
+
+// ErrPassphraseRequired is returned when an encrypted key file is loaded
+// without a passphrase
+var ErrPassphraseRequired = errors.New("key file is encrypted; passphrase required")
+
+// encryptedKeyFile is the on-disk form of a passphrase-protected Ed25519 key.
+// The key's seed is encrypted with a key derived from the passphrase, and the
+// public key is authenticated with it so the file cannot be relabelled.
+type encryptedKeyFile struct {
+	Version    int    `json:"version"`
+	PublicKey  string `json:"pubkey"`
+	KDF        string `json:"kdf"`
+	N          int    `json:"n"`
+	R          int    `json:"r"`
+	P          int    `json:"p"`
+	Salt       string `json:"salt"`
+	Cipher     string `json:"cipher"`
+	Nonce      string `json:"nonce"`
+	Ciphertext string `json:"ciphertext"`
+}
+
+// EncryptPrivateKey seals key with passphrase in the keystore file format
+func EncryptPrivateKey(key ed25519.PrivateKey, passphrase []byte) ([]byte, error) {
+	if len(passphrase) == 0 {
+		return nil, ErrPassphraseRequired
+	}
+	salt := make([]byte, 16)
+	if _, err := io.ReadFull(rand.Reader, salt); err != nil {
+		return nil, fmt.Errorf("failed to generate salt: %w", err)
+	}
+	file := encryptedKeyFile{
+		Version:   keystoreVersion,
+		PublicKey: EncodePublicKey(key.Public().(ed25519.PublicKey)),
+		KDF:       keystoreKDF,
+		N:         keystoreScryptN,
+		R:         keystoreScryptR,
+		P:         keystoreScryptP,
+		Salt:      base64.StdEncoding.EncodeToString(salt),
+		Cipher:    keystoreCipher,
+	}
+
+	aead, err := file.aead(passphrase, salt)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, fmt.Errorf("failed to generate nonce: %w", err)
+	}
+	file.Nonce = base64.StdEncoding.EncodeToString(nonce)
+	file.Ciphertext = base64.StdEncoding.EncodeToString(aead.Seal(nil, nonce, key.Seed(), []byte(file.PublicKey)))
+	return json.MarshalIndent(file, "", "  ")
+}
+
+// DecryptPrivateKey opens a key sealed by EncryptPrivateKey
+func DecryptPrivateKey(data, passphrase []byte) (ed25519.PrivateKey, error) {
+	var file encryptedKeyFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("invalid key file: %w", err)
+	}
+	if file.Version != keystoreVersion || file.KDF != keystoreKDF || file.Cipher != keystoreCipher {
+		return nil, fmt.Errorf("unsupported key file: version %d, %s, %s", file.Version, file.KDF, file.Cipher)
+	}
+	if len(passphrase) == 0 {
+		return nil, ErrPassphraseRequired
+	}
+	salt, err := base64.StdEncoding.DecodeString(file.Salt)
+	if err != nil {
+		return nil, fmt.Errorf("invalid key file salt: %w", err)
+	}
+	nonce, err := base64.StdEncoding.DecodeString(file.Nonce)
+	if err != nil {
+		return nil, fmt.Errorf("invalid key file nonce: %w", err)
+	}
+	ciphertext, err := base64.StdEncoding.DecodeString(file.Ciphertext)
+	if err != nil {
+		return nil, fmt.Errorf("invalid key file ciphertext: %w", err)
+	}
+
+	aead, err := file.aead(passphrase, salt)
+	if err != nil {
+		return nil, err
+	}
+	if len(nonce) != aead.NonceSize() {
+		return nil, fmt.Errorf("invalid key file nonce size: %d", len(nonce))
+	}
+	seed, err := aead.Open(nil, nonce, ciphertext, []byte(file.PublicKey))
+	if err != nil || len(seed) != ed25519.SeedSize {
+		return nil, ErrWrongPassphrase
+	}
+	return ed25519.NewKeyFromSeed(seed), nil
+}
+
+// aead derives the file's encryption key from passphrase
+func (f *encryptedKeyFile) aead(passphrase, salt []byte) (cipher.AEAD, error) {
+	// Refuse costs a tampered file could use to stall the loader
+	if f.N > maxKeystoreScryptN || f.R*f.P > maxKeystoreScryptRP {
+		return nil, fmt.Errorf("key file scrypt parameters too large: N=%d r=%d p=%d", f.N, f.R, f.P)
+	}
+	key, err := scrypt.Key(passphrase, salt, f.N, f.R, f.P, chacha20poly1305.KeySize)
+	if err != nil {
+		return nil, fmt.Errorf("failed to derive key: %w", err)
+	}
+	return chacha20poly1305.New(key)
+}
+
+// SavePrivateKey writes key to path, encrypted with passphrase. Without a
+// passphrase the key is written as plain base64, which is only suitable for
+// development. The file is created with mode 0600, replacing any existing one.
+func SavePrivateKey(path string, key ed25519.PrivateKey, passphrase []byte) error {
+	data := []byte(EncodePrivateKey(key) + "\n")
+	if len(passphrase) > 0 {
+		var err error
+		if data, err = EncryptPrivateKey(key, passphrase); err != nil {
+			return err
+		}
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return fmt.Errorf("failed to create key directory: %w", err)
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		return fmt.Errorf("failed to save key: %w", err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("failed to save key: %w", err)
+	}
+	return nil
+}
+
+// LoadPrivateKey reads a key written by SavePrivateKey. Plain base64 key files
+// load regardless of passphrase, so existing keys keep working.
+func LoadPrivateKey(path string, passphrase []byte) (ed25519.PrivateKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	trimmed := strings.TrimSpace(string(data))
+	if !strings.HasPrefix(trimmed, "{") {
+		return DecodePrivateKey(trimmed)
+	}
+	key, err := DecryptPrivateKey(data, passphrase)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return key, nil
+}
+
+// LoadPublicKey returns the public key of the key file at path and whether the
+// file is encrypted. Encrypted files are not decrypted, so no passphrase is
+// needed; the public key is only authenticated once the file is decrypted.
+func LoadPublicKey(path string) (publicKey ed25519.PublicKey, encrypted bool, err error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, false, err
+	}
+	trimmed := strings.TrimSpace(string(data))
+	if !strings.HasPrefix(trimmed, "{") {
+		key, err := DecodePrivateKey(trimmed)
+		if err != nil {
+			return nil, false, fmt.Errorf("%s: %w", path, err)
+		}
+		return key.Public().(ed25519.PublicKey), false, nil
+	}
+	var file encryptedKeyFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, true, fmt.Errorf("%s: invalid key file: %w", path, err)
+	}
+	publicKey, err = DecodePublicKey(file.PublicKey)
+	if err != nil {
+		return nil, true, fmt.Errorf("%s: %w", path, err)
+	}
+	return publicKey, true, nil
+}
+
+// LoadOrCreatePrivateKey loads the key at path, generating and saving a new one
+// if the file does not exist. created reports whether the key is new.
+func LoadOrCreatePrivateKey(path string, passphrase []byte) (key ed25519.PrivateKey, created bool, err error) {
+	key, err = LoadPrivateKey(path, passphrase)
+	if err == nil {
+		return key, false, nil
+	}
+	if !errors.Is(err, os.ErrNotExist) {
+		return nil, false, fmt.Errorf("failed to load key: %w", err)
+	}
+
+	if _, key, err = GenerateKeyPair(); err != nil {
+		return nil, false, err
+	}
+	if err := SavePrivateKey(path, key, passphrase); err != nil {
+		return nil, false, err
+	}
+	return key, true, nil
+}
+
+// ReadPassphrase returns the passphrase stored in file, without its trailing
+// newline, or the value of PassphraseEnv if file is empty. The result is nil
+// if neither is set.
+func ReadPassphrase(file string) ([]byte, error) {
+	if file == "" {
+		if passphrase := os.Getenv(PassphraseEnv); passphrase != "" {
+			return []byte(passphrase), nil
+		}
+		return nil, nil
+	}
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read passphrase: %w", err)
+	}
+	passphrase := []byte(strings.TrimRight(string(data), "\r\n"))
+	if len(passphrase) == 0 {
+		return nil, fmt.Errorf("passphrase file %s is empty", file)
+	}
+	return passphrase, nil
+}
@@ -0,0 +1,112 @@
+package protocol
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestKeystoreRoundTrip(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "keys", "agent.key")
+	passphrase := []byte("correct horse")
+
+	key, created, err := LoadOrCreatePrivateKey(path, passphrase)
+	if err != nil || !created {
+		t.Fatalf("Expected a new key, got created=%v err=%v", created, err)
+	}
+	info, _ := os.Stat(path)
+	if info.Mode().Perm() != 0o600 {
+		t.Errorf("Expected mode 0600, got %v", info.Mode().Perm())
+	}
+	data, _ := os.ReadFile(path)
+	if bytes.Contains(data, []byte(EncodePrivateKey(key))) || !bytes.Contains(data, []byte(`"kdf": "scrypt"`)) {
+		t.Errorf("Expected the key to be stored encrypted, got:\n%s", data)
+	}
+
+	loaded, created, err := LoadOrCreatePrivateKey(path, passphrase)
+	if err != nil || created || !loaded.Equal(key) {
+		t.Fatalf("Expected the same key back, got created=%v err=%v", created, err)
+	}
+
+	// The public key is readable without the passphrase
+	if public, encrypted, err := LoadPublicKey(path); err != nil || !encrypted || !public.Equal(key.Public()) {
+		t.Errorf("Expected the encrypted file's public key, got encrypted=%v err=%v", encrypted, err)
+	}
+
+	if _, err := LoadPrivateKey(path, []byte("wrong")); !errors.Is(err, ErrWrongPassphrase) {
+		t.Errorf("Expected ErrWrongPassphrase, got %v", err)
+	}
+	if _, _, err := LoadOrCreatePrivateKey(path, nil); !errors.Is(err, ErrPassphraseRequired) {
+		t.Errorf("Expected ErrPassphraseRequired, got %v", err)
+	}
+}
+
+func TestKeystoreRejectsTampering(t *testing.T) {
+	_, key, _ := GenerateKeyPair()
+	data, err := EncryptPrivateKey(key, []byte("secret"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Relabelling the file with another public key breaks authentication
+	var file encryptedKeyFile
+	json.Unmarshal(data, &file)
+	otherPub, _, _ := GenerateKeyPair()
+	file.PublicKey = EncodePublicKey(otherPub)
+	relabelled, _ := json.Marshal(file)
+	if _, err := DecryptPrivateKey(relabelled, []byte("secret")); !errors.Is(err, ErrWrongPassphrase) {
+		t.Errorf("Expected a relabelled file to be rejected, got %v", err)
+	}
+
+	json.Unmarshal(data, &file)
+	file.N = 1 << 30
+	costly, _ := json.Marshal(file)
+	if _, err := DecryptPrivateKey(costly, []byte("secret")); err == nil || !strings.Contains(err.Error(), "too large") {
+		t.Errorf("Expected excessive scrypt parameters to be refused, got %v", err)
+	}
+}
+
+func TestKeystorePlainKeys(t *testing.T) {
+	dir := t.TempDir()
+
+	// Without a passphrase keys are stored as base64, as identity key files were
+	path := filepath.Join(dir, "plain.key")
+	key, _, err := LoadOrCreatePrivateKey(path, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, _ := os.ReadFile(path)
+	if strings.TrimSpace(string(data)) != EncodePrivateKey(key) {
+		t.Errorf("Expected a base64 key file, got %q", data)
+	}
+
+	// Existing base64 files still load once a passphrase is configured
+	loaded, err := LoadPrivateKey(path, []byte("secret"))
+	if err != nil || !loaded.Equal(key) {
+		t.Errorf("Expected the plain key to load, got %v", err)
+	}
+	if public, encrypted, err := LoadPublicKey(path); err != nil || encrypted || !public.Equal(key.Public()) {
+		t.Errorf("Expected the plain file's public key, got encrypted=%v err=%v", encrypted, err)
+	}
+}
+
+func TestReadPassphrase(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "passphrase")
+	os.WriteFile(path, []byte("from file\n"), 0o600)
+	t.Setenv(PassphraseEnv, "from env")
+
+	if passphrase, err := ReadPassphrase(path); err != nil || string(passphrase) != "from file" {
+		t.Errorf("Expected the file's passphrase, got %q %v", passphrase, err)
+	}
+	if passphrase, _ := ReadPassphrase(""); string(passphrase) != "from env" {
+		t.Errorf("Expected the environment's passphrase, got %q", passphrase)
+	}
+	t.Setenv(PassphraseEnv, "")
+	if passphrase, err := ReadPassphrase(""); passphrase != nil || err != nil {
+		t.Errorf("Expected no passphrase, got %q %v", passphrase, err)
+	}
+}
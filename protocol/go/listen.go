@@ -0,0 +1,208 @@
+package protocol
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"log"
+	"net"
+	"time"
+)
+
+// ErrTransportClosed is returned by Listen and Serve after Shutdown
+var ErrTransportClosed = errors.New("transport closed")
+
+// maxAcceptBackoff caps the wait between retries of a failing Accept
+const maxAcceptBackoff = time.Second
+
+// Listen accepts FEP connections on address until ctx is done or the
+// transport is shut down; see Serve
+func (t *Transport) Listen(ctx context.Context, address string) error {
+	listener, err := net.Listen("tcp", address)
+	if err != nil {
+		return err
+	}
+	return t.Serve(ctx, listener)
+}
+
+// Serve accepts FEP connections on listener, adding TLS, and serves each on
+// its own goroutine; framing is negotiated per connection with ALPN, see
+// StreamProtocols. When ctx is done, Serve shuts the transport down as
+// Shutdown does, waits for envelopes being handled, and returns ctx.Err().
+// After Shutdown it returns ErrTransportClosed. Other Accept errors are
+// returned, except temporary ones, which are retried with backoff.
+func (t *Transport) Serve(ctx context.Context, listener net.Listener) error {
+	if t.tlsConfig == nil {
+		if err := t.GenerateSelfSignedCert(); err != nil {
+			listener.Close()
+			return err
+		}
+	}
+	config := t.tlsConfig
+	if len(config.NextProtos) == 0 {
+		config = config.Clone()
+		config.NextProtos = StreamProtocols()
+	}
+	listener = tls.NewListener(listener, config)
+	if !t.trackListener(listener) {
+		listener.Close()
+		return ErrTransportClosed
+	}
+	defer t.untrackListener(listener)
+
+	// Cancelling ctx unblocks Accept
+	stop := context.AfterFunc(ctx, func() { listener.Close() })
+	defer stop()
+
+	t.mu.RLock()
+	var slots chan struct{}
+	if t.maxConnections > 0 {
+		slots = make(chan struct{}, t.maxConnections)
+	}
+	t.mu.RUnlock()
+	release := func() {
+		if slots != nil {
+			<-slots
+		}
+	}
+
+	var backoff time.Duration
+	for {
+		if slots != nil {
+			select {
+			case slots <- struct{}{}:
+			case <-ctx.Done():
+			case <-t.done:
+			}
+		}
+		if ctx.Err() != nil {
+			t.Shutdown(context.Background())
+			return ctx.Err()
+		}
+
+		conn, err := listener.Accept()
+		if err != nil {
+			release()
+			if ctx.Err() != nil {
+				t.Shutdown(context.Background())
+				return ctx.Err()
+			}
+			if t.isClosed() {
+				return ErrTransportClosed
+			}
+			// Running out of file descriptors and the like is temporary
+			var netErr net.Error
+			if errors.As(err, &netErr) && netErr.Temporary() {
+				backoff = min(max(2*backoff, 5*time.Millisecond), maxAcceptBackoff)
+				log.Printf("Accept failed, retrying in %v: %v", backoff, err)
+				time.Sleep(backoff)
+				continue
+			}
+			return err
+		}
+		backoff = 0
+
+		if !t.trackConn(conn) {
+			conn.Close()
+			release()
+			return ErrTransportClosed
+		}
+		go func() {
+			defer release()
+			defer t.untrackConn(conn)
+			t.handleConnection(conn)
+		}()
+	}
+}
+
+// Shutdown stops every Serve and Listen call: listeners are closed and
+// connections stop reading new envelopes, while envelopes already being
+// handled finish. Connections still open when ctx is done are closed and
+// ctx.Err() is returned. A transport that was shut down cannot serve again;
+// Send is not affected.
+func (t *Transport) Shutdown(ctx context.Context) error {
+	t.mu.Lock()
+	if !t.closed {
+		t.closed = true
+		close(t.done)
+	}
+	for listener := range t.listeners {
+		listener.Close()
+	}
+	// An expired read deadline ends each connection after its current envelope
+	for conn := range t.conns {
+		conn.SetReadDeadline(time.Now())
+	}
+	t.mu.Unlock()
+
+	finished := make(chan struct{})
+	go func() {
+		t.serving.Wait()
+		close(finished)
+	}()
+	select {
+	case <-finished:
+		return nil
+	case <-ctx.Done():
+		t.mu.Lock()
+		for conn := range t.conns {
+			conn.Close()
+		}
+		t.mu.Unlock()
+		return ctx.Err()
+	}
+}
+
+// isClosed reports whether Shutdown was called
+func (t *Transport) isClosed() bool {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	return t.closed
+}
+
+// trackListener records a listener for Shutdown to close. It returns false
+// once the transport is shut down.
+func (t *Transport) trackListener(listener net.Listener) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.closed {
+		return false
+	}
+	if t.listeners == nil {
+		t.listeners = make(map[net.Listener]struct{})
+	}
+	t.listeners[listener] = struct{}{}
+	return true
+}
+
+// untrackListener closes a listener and forgets it
+func (t *Transport) untrackListener(listener net.Listener) {
+	listener.Close()
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	delete(t.listeners, listener)
+}
+
+// trackConn records a connection being served, for Shutdown to wait for.
+// It returns false once the transport is shut down.
+func (t *Transport) trackConn(conn net.Conn) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.closed {
+		return false
+	}
+	if t.conns == nil {
+		t.conns = make(map[net.Conn]struct{})
+	}
+	t.conns[conn] = struct{}{}
+	t.serving.Add(1)
+	return true
+}
+
+// untrackConn forgets a connection that is no longer served
+func (t *Transport) untrackConn(conn net.Conn) {
+	t.mu.Lock()
+	delete(t.conns, conn)
+	t.mu.Unlock()
+	t.serving.Done()
+}
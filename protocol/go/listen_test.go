@@ -0,0 +1,128 @@
+package protocol
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+)
+
+// blockingServer serves heartbeats with a handler that waits to be released
+type blockingServer struct {
+	transport *Transport
+	address   string
+	started   chan struct{}
+	release   chan struct{}
+	served    chan error
+}
+
+func newBlockingServer(t *testing.T, ctx context.Context) *blockingServer {
+	t.Helper()
+	transport, _ := NewTransport(nil)
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	s := &blockingServer{
+		transport: transport,
+		address:   listener.Addr().String(),
+		started:   make(chan struct{}, 10),
+		release:   make(chan struct{}),
+		served:    make(chan error, 1),
+	}
+	transport.RegisterHandler(EnvelopeHeartbeat, func(*Envelope, net.Conn) error {
+		s.started <- struct{}{}
+		<-s.release
+		return nil
+	})
+	go func() { s.served <- transport.Serve(ctx, listener) }()
+	return s
+}
+
+// handleOne sends a heartbeat and waits until its handler is running
+func (s *blockingServer) handleOne(t *testing.T) {
+	t.Helper()
+	client, _ := NewTransport(nil)
+	envelope := &Envelope{Type: EnvelopeHeartbeat, CommonHeaders: newHeaders("worker"), Body: []byte(`{"load":0,"inFlight":0,"uptime":0}`)}
+	if err := client.Send(s.address, envelope); err != nil {
+		t.Fatalf("Send failed: %v", err)
+	}
+	select {
+	case <-s.started:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Handler did not start")
+	}
+}
+
+func TestServeStopsWhenContextDone(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	server := newBlockingServer(t, ctx)
+	server.handleOne(t)
+
+	// Serve waits for the envelope being handled
+	cancel()
+	select {
+	case err := <-server.served:
+		t.Fatalf("Serve returned with a handler running: %v", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+	if conn, err := net.DialTimeout("tcp", server.address, time.Second); err == nil {
+		conn.Close()
+		t.Error("Listener should be closed once the context is done")
+	}
+
+	close(server.release)
+	select {
+	case err := <-server.served:
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("Serve should return the context's error, got %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Serve should return once the handler finished")
+	}
+	if err := server.transport.Listen(context.Background(), "127.0.0.1:0"); !errors.Is(err, ErrTransportClosed) {
+		t.Errorf("Listening again should fail with ErrTransportClosed, got %v", err)
+	}
+}
+
+func TestShutdownDeadline(t *testing.T) {
+	server := newBlockingServer(t, context.Background())
+	defer close(server.release)
+	server.handleOne(t)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := server.transport.Shutdown(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Shutdown should give up on the running handler, got %v", err)
+	}
+	select {
+	case err := <-server.served:
+		if !errors.Is(err, ErrTransportClosed) {
+			t.Errorf("Serve should return ErrTransportClosed, got %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Serve should return after Shutdown")
+	}
+}
+
+// failingListener fails every Accept with err
+type failingListener struct {
+	net.Listener
+	err error
+}
+
+func (l failingListener) Accept() (net.Conn, error) {
+	return nil, l.err
+}
+
+func TestServeReturnsAcceptErrors(t *testing.T) {
+	listener, _ := net.Listen("tcp", "127.0.0.1:0")
+	defer listener.Close()
+	broken := errors.New("listener broken")
+
+	transport, _ := NewTransport(nil)
+	if err := transport.Serve(context.Background(), failingListener{listener, broken}); !errors.Is(err, broken) {
+		t.Errorf("Serve should return the Accept error, got %v", err)
+	}
+}
@@ -0,0 +1,127 @@
+package protocol
+
+import (
+	"fmt"
+	"strings"
+)
+
+// ValidateLocale checks that tag is syntactically a BCP 47 language tag:
+// a 2-3 letter language followed by 1-8 character alphanumeric subtags
+func ValidateLocale(tag string) error {
+	subtags := strings.Split(tag, "-")
+	if len(subtags[0]) < 2 || len(subtags[0]) > 3 || !isAlpha(subtags[0]) {
+		return fmt.Errorf("locale %q must start with a 2-3 letter language code", tag)
+	}
+	for _, subtag := range subtags[1:] {
+		if len(subtag) == 0 || len(subtag) > 8 || !isAlphanumeric(subtag) {
+			return fmt.Errorf("locale %q has malformed subtag %q", tag, subtag)
+		}
+	}
+	return nil
+}
+
+// validateMediaRange checks a media type or range such as "text/markdown" or "text/*"
+func validateMediaRange(format string) error {
+	kind, subtype, ok := strings.Cut(format, "/")
+	if !ok || kind == "" || subtype == "" || strings.ContainsAny(format, " \t;,") {
+		return fmt.Errorf("%q is not a media type", format)
+	}
+	if kind == "*" && subtype != "*" {
+		return fmt.Errorf("%q is not a valid media range", format)
+	}
+	return nil
+}
+
+// validateMediaType checks a concrete media type such as "image/png"
+func validateMediaType(format string) error {
+	if err := validateMediaRange(format); err != nil {
+		return err
+	}
+	if strings.Contains(format, "*") {
+		return fmt.Errorf("%q is a media range, not a media type", format)
+	}
+	return nil
+}
+
+func isAlpha(s string) bool {
+	return strings.IndexFunc(s, func(r rune) bool { return !isLetter(r) }) < 0
+}
+
+func isAlphanumeric(s string) bool {
+	return strings.IndexFunc(s, func(r rune) bool { return !isLetter(r) && (r < '0' || r > '9') }) < 0
+}
+
+func isLetter(r rune) bool {
+	return (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z')
+}
+
+// RenderLocale returns the locale output should use: the instruction's own
+// locale if set, otherwise the sender's header locale
+func (b RenderInstructionBody) RenderLocale(headers CommonHeaders) string {
+	if b.Locale != "" {
+		return b.Locale
+	}
+	return headers.Locale
+}
+
+// RenderFormats returns the acceptable output formats: the instruction's
+// presentation formats if set, otherwise the sender's accept header
+func (b RenderInstructionBody) RenderFormats(headers CommonHeaders) []string {
+	if b.Presentation != nil && len(b.Presentation.Formats) > 0 {
+		return b.Presentation.Formats
+	}
+	return headers.Accept
+}
+
+// NegotiateLocale picks the best of the supported locales for a requested one.
+// An exact match wins, then progressively shorter prefixes of the request
+// ("de-CH-1996" falls back to "de-CH", then "de"). Matching ignores case.
+// It returns "" if nothing matches.
+func NegotiateLocale(supported []string, requested string) string {
+	for candidate := requested; candidate != ""; {
+		for _, locale := range supported {
+			if strings.EqualFold(locale, candidate) {
+				return locale
+			}
+		}
+		i := strings.LastIndex(candidate, "-")
+		if i < 0 {
+			break
+		}
+		candidate = candidate[:i]
+	}
+	return ""
+}
+
+// NegotiateFormat picks the first accepted media range that one of the
+// offered formats satisfies, returning that offered format. Ranges may use
+// wildcards such as "text/*" or "*/*". With no accepted ranges the first
+// offered format is returned; if nothing matches it returns "".
+func NegotiateFormat(offered, accepted []string) string {
+	if len(accepted) == 0 {
+		if len(offered) == 0 {
+			return ""
+		}
+		return offered[0]
+	}
+	for _, accept := range accepted {
+		for _, format := range offered {
+			if mediaMatches(accept, format) {
+				return format
+			}
+		}
+	}
+	return ""
+}
+
+// mediaMatches reports whether format falls within the media range
+func mediaMatches(mediaRange, format string) bool {
+	if mediaRange == "*/*" || strings.EqualFold(mediaRange, format) {
+		return true
+	}
+	if kind, ok := strings.CutSuffix(mediaRange, "/*"); ok {
+		formatKind, _, _ := strings.Cut(format, "/")
+		return strings.EqualFold(kind, formatKind)
+	}
+	return false
+}
@@ -0,0 +1,90 @@
+package protocol
+
+import (
+	"testing"
+)
+
+func TestValidateLocale(t *testing.T) {
+	for _, tag := range []string{"en", "de-CH", "zh-Hant-TW", "sr-Latn", "de-CH-1996"} {
+		if err := ValidateLocale(tag); err != nil {
+			t.Errorf("Expected %q to be valid: %v", tag, err)
+		}
+	}
+	for _, tag := range []string{"", "e", "english", "en_US", "en-", "de-toolongsubtag", "12"} {
+		if err := ValidateLocale(tag); err == nil {
+			t.Errorf("Expected %q to be rejected", tag)
+		}
+	}
+}
+
+func TestNegotiateLocale(t *testing.T) {
+	supported := []string{"en", "de", "fr-CA"}
+	tests := []struct {
+		requested string
+		want      string
+	}{
+		{"de", "de"},
+		{"de-CH", "de"},
+		{"FR-ca", "fr-CA"},
+		{"fr-FR", ""},
+		{"", ""},
+	}
+	for _, tt := range tests {
+		if got := NegotiateLocale(supported, tt.requested); got != tt.want {
+			t.Errorf("NegotiateLocale(%q) = %q, want %q", tt.requested, got, tt.want)
+		}
+	}
+}
+
+func TestNegotiateFormat(t *testing.T) {
+	offered := []string{"text/html", "text/markdown", "text/plain"}
+	tests := []struct {
+		accepted []string
+		want     string
+	}{
+		{nil, "text/html"},
+		{[]string{"text/plain"}, "text/plain"},
+		{[]string{"application/json", "text/markdown"}, "text/markdown"},
+		{[]string{"text/*"}, "text/html"},
+		{[]string{"*/*"}, "text/html"},
+		{[]string{"image/png"}, ""},
+	}
+	for _, tt := range tests {
+		if got := NegotiateFormat(offered, tt.accepted); got != tt.want {
+			t.Errorf("NegotiateFormat(%v) = %q, want %q", tt.accepted, got, tt.want)
+		}
+	}
+}
+
+func TestRenderHintsFallBackToHeaders(t *testing.T) {
+	headers := CommonHeaders{Locale: "de-CH", Accept: []string{"text/plain"}}
+
+	body := RenderInstructionBody{Instruction: "show"}
+	if body.RenderLocale(headers) != "de-CH" || body.RenderFormats(headers)[0] != "text/plain" {
+		t.Errorf("Expected header hints, got %q %v", body.RenderLocale(headers), body.RenderFormats(headers))
+	}
+
+	body.Locale = "fr"
+	body.Presentation = &PresentationHints{Formats: []string{"text/markdown"}}
+	if body.RenderLocale(headers) != "fr" || body.RenderFormats(headers)[0] != "text/markdown" {
+		t.Errorf("Expected body hints to win, got %q %v", body.RenderLocale(headers), body.RenderFormats(headers))
+	}
+}
+
+func TestLocaleHintsAreSigned(t *testing.T) {
+	pubKey, privKey, _ := GenerateKeyPair()
+	envelope := &RenderInstructionEnvelope{
+		BaseEnvelope: BaseEnvelope{Type: EnvelopeRenderInstruction, CommonHeaders: newHeaders("ui-agent")},
+		Body:         RenderInstructionBody{Instruction: "show"},
+	}
+	envelope.Locale = "ja"
+	envelope.Accept = []string{"text/plain"}
+	if err := signEnvelope(envelope.Type, &envelope.CommonHeaders, envelope.Body, privKey); err != nil {
+		t.Fatalf("Failed to sign: %v", err)
+	}
+
+	envelope.Locale = "en"
+	if err := verifyEnvelope(envelope.Type, envelope.CommonHeaders, envelope.Body, pubKey); err == nil {
+		t.Error("Changing the locale hint should invalidate the signature")
+	}
+}
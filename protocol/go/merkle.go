@@ -0,0 +1,187 @@
+package protocol
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+)
+
+// Merkle tree hashing follows RFC 6962 so standard transparency-log tooling can audit FEM logs.
+
+// SignedTreeHead is a log's signed commitment to its contents at a given size
+type SignedTreeHead struct {
+	LogID     string `json:"logId"`     // Identifier of the log (usually the broker ID)
+	TreeSize  uint64 `json:"treeSize"`  // Number of entries covered
+	RootHash  []byte `json:"rootHash"`  // Merkle root over the first TreeSize entries
+	Timestamp int64  `json:"timestamp"` // Unix milliseconds when the head was signed
+	Sig       string `json:"sig"`       // Base64 Ed25519 signature by the log
+}
+
+// InclusionProof proves that a leaf is part of a tree of the given size
+type InclusionProof struct {
+	LeafIndex uint64   `json:"leafIndex"`
+	TreeSize  uint64   `json:"treeSize"`
+	AuditPath [][]byte `json:"auditPath"`
+}
+
+// ConsistencyProof proves that a tree of size Second extends a tree of size First
+type ConsistencyProof struct {
+	First  uint64   `json:"first"`
+	Second uint64   `json:"second"`
+	Path   [][]byte `json:"path"`
+}
+
+// MerkleLeafHash hashes a log entry as a leaf
+func MerkleLeafHash(data []byte) []byte {
+	h := sha256.New()
+	h.Write([]byte{0x00})
+	h.Write(data)
+	return h.Sum(nil)
+}
+
+// MerkleNodeHash hashes two child hashes into their parent
+func MerkleNodeHash(left, right []byte) []byte {
+	h := sha256.New()
+	h.Write([]byte{0x01})
+	h.Write(left)
+	h.Write(right)
+	return h.Sum(nil)
+}
+
+// EmptyMerkleRoot is the root hash of a tree with no entries
+func EmptyMerkleRoot() []byte {
+	sum := sha256.Sum256(nil)
+	return sum[:]
+}
+
+// Sign signs the tree head with the log's key
+func (sth *SignedTreeHead) Sign(signer Signer) error {
+	signature, err := signMessage(signer, sth.signingBytes())
+	if err != nil {
+		return err
+	}
+	sth.Sig = base64.StdEncoding.EncodeToString(signature)
+	return nil
+}
+
+// Verify checks the tree head signature against the log's public key
+func (sth *SignedTreeHead) Verify(publicKey ed25519.PublicKey) error {
+	signature, err := base64.StdEncoding.DecodeString(sth.Sig)
+	if err != nil {
+		return fmt.Errorf("invalid tree head signature encoding: %w", err)
+	}
+	if !ed25519.Verify(publicKey, sth.signingBytes(), signature) {
+		return fmt.Errorf("tree head signature verification failed")
+	}
+	return nil
+}
+
+func (sth *SignedTreeHead) signingBytes() []byte {
+	msg := make([]byte, 0, 64+len(sth.LogID)+len(sth.RootHash))
+	msg = append(msg, "fem-sth-v1"...)
+	msg = binary.BigEndian.AppendUint16(msg, uint16(len(sth.LogID)))
+	msg = append(msg, sth.LogID...)
+	msg = binary.BigEndian.AppendUint64(msg, sth.TreeSize)
+	msg = binary.BigEndian.AppendUint64(msg, uint64(sth.Timestamp))
+	return append(msg, sth.RootHash...)
+}
+
+// VerifyInclusion checks that leafHash is at proof.LeafIndex in the tree with the given root
+func VerifyInclusion(leafHash []byte, proof *InclusionProof, root []byte) error {
+	if proof.LeafIndex >= proof.TreeSize {
+		return fmt.Errorf("leaf index %d outside tree of size %d", proof.LeafIndex, proof.TreeSize)
+	}
+
+	fn, sn := proof.LeafIndex, proof.TreeSize-1
+	r := leafHash
+	for _, p := range proof.AuditPath {
+		if sn == 0 {
+			return fmt.Errorf("inclusion proof too long")
+		}
+		if fn&1 == 1 || fn == sn {
+			r = MerkleNodeHash(p, r)
+			for fn&1 == 0 && fn != 0 {
+				fn >>= 1
+				sn >>= 1
+			}
+		} else {
+			r = MerkleNodeHash(r, p)
+		}
+		fn >>= 1
+		sn >>= 1
+	}
+
+	if sn != 0 {
+		return fmt.Errorf("inclusion proof too short")
+	}
+	if !bytes.Equal(r, root) {
+		return fmt.Errorf("inclusion proof does not match root")
+	}
+	return nil
+}
+
+// VerifyConsistency checks that the tree with secondRoot is an append-only extension of the tree with firstRoot
+func VerifyConsistency(proof *ConsistencyProof, firstRoot, secondRoot []byte) error {
+	first, second := proof.First, proof.Second
+	if first > second {
+		return fmt.Errorf("first tree size %d exceeds second %d", first, second)
+	}
+	if first == second {
+		if len(proof.Path) != 0 || !bytes.Equal(firstRoot, secondRoot) {
+			return fmt.Errorf("trees of equal size must have equal roots and an empty proof")
+		}
+		return nil
+	}
+	if first == 0 {
+		// The empty tree is a prefix of every tree
+		if len(proof.Path) != 0 {
+			return fmt.Errorf("consistency proof from empty tree must be empty")
+		}
+		return nil
+	}
+	if len(proof.Path) == 0 {
+		return fmt.Errorf("consistency proof is empty")
+	}
+
+	path := proof.Path
+	if first&(first-1) == 0 {
+		// The first tree is a complete subtree, so its root is the starting node
+		path = append([][]byte{firstRoot}, path...)
+	}
+
+	fn, sn := first-1, second-1
+	for fn&1 == 1 {
+		fn >>= 1
+		sn >>= 1
+	}
+
+	fr, sr := path[0], path[0]
+	for _, c := range path[1:] {
+		if sn == 0 {
+			return fmt.Errorf("consistency proof too long")
+		}
+		if fn&1 == 1 || fn == sn {
+			fr = MerkleNodeHash(c, fr)
+			sr = MerkleNodeHash(c, sr)
+			for fn&1 == 0 && fn != 0 {
+				fn >>= 1
+				sn >>= 1
+			}
+		} else {
+			sr = MerkleNodeHash(sr, c)
+		}
+		fn >>= 1
+		sn >>= 1
+	}
+
+	if sn != 0 {
+		return fmt.Errorf("consistency proof too short")
+	}
+	if !bytes.Equal(fr, firstRoot) || !bytes.Equal(sr, secondRoot) {
+		return fmt.Errorf("consistency proof does not match roots")
+	}
+	return nil
+}
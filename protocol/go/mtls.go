@@ -0,0 +1,90 @@
+package protocol
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"fmt"
+	"math/big"
+	"time"
+)
+
+// DefaultIdentityCertificateValidity is how long identity certificates are valid
+const DefaultIdentityCertificateValidity = 24 * time.Hour
+
+// ErrNotIdentityCertificate is returned for TLS certificates that are not bound to an Ed25519 identity key
+var ErrNotIdentityCertificate = errors.New("not an identity certificate")
+
+// NewIdentityCertificate returns a self-signed TLS certificate for the
+// Ed25519 key of signer, with id as its common name. Presenting it as a TLS
+// client certificate proves possession of the key that signs the client's
+// envelopes, so a broker can check the connection and the envelopes come from
+// the same agent. A validity of 0 uses DefaultIdentityCertificateValidity.
+func NewIdentityCertificate(signer Signer, id string, validity time.Duration) (tls.Certificate, error) {
+	publicKey, err := SignerPublicKey(signer)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	if validity == 0 {
+		validity = DefaultIdentityCertificateValidity
+	}
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+
+	now := time.Now()
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: id},
+		NotBefore:    now.Add(-5 * time.Minute), // Tolerate clock skew between agent and broker
+		NotAfter:     now.Add(validity),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, publicKey, crypto.Signer(signer))
+	if err != nil {
+		return tls.Certificate{}, fmt.Errorf("failed to create identity certificate: %w", err)
+	}
+	leaf, err := x509.ParseCertificate(der)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: crypto.Signer(signer), Leaf: leaf}, nil
+}
+
+// IdentityCertificateKey returns the Ed25519 key an identity certificate is
+// bound to. The certificate must be self-signed by that key and valid at now.
+func IdentityCertificateKey(cert *x509.Certificate, now time.Time) (ed25519.PublicKey, error) {
+	publicKey, ok := cert.PublicKey.(ed25519.PublicKey)
+	if !ok {
+		return nil, fmt.Errorf("%w: key is %T, want Ed25519", ErrNotIdentityCertificate, cert.PublicKey)
+	}
+	if err := cert.CheckSignature(cert.SignatureAlgorithm, cert.RawTBSCertificate, cert.Signature); err != nil {
+		return nil, fmt.Errorf("%w: not self-signed by its key: %v", ErrNotIdentityCertificate, err)
+	}
+	if now.Before(cert.NotBefore) || now.After(cert.NotAfter) {
+		return nil, fmt.Errorf("identity certificate is not valid at %s", now.UTC().Format(time.RFC3339))
+	}
+	return publicKey, nil
+}
+
+// VerifyIdentityCertificate accepts a TLS peer presenting an identity
+// certificate. It is meant for tls.Config.VerifyPeerCertificate together with
+// tls.RequestClientCert or tls.RequireAnyClientCert; the handshake itself
+// proves the peer holds the certificate's key.
+func VerifyIdentityCertificate(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+	if len(rawCerts) == 0 {
+		return nil // tls.RequireAnyClientCert refuses missing certificates itself
+	}
+	cert, err := x509.ParseCertificate(rawCerts[0])
+	if err != nil {
+		return fmt.Errorf("invalid client certificate: %w", err)
+	}
+	_, err = IdentityCertificateKey(cert, time.Now())
+	return err
+}
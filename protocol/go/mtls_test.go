@@ -0,0 +1,87 @@
+package protocol
+
+import (
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestIdentityCertificateBindsKey(t *testing.T) {
+	publicKey, privateKey, _ := GenerateKeyPair()
+	cert, err := NewIdentityCertificate(privateKey, "calc-agent", 0)
+	if err != nil {
+		t.Fatalf("Failed to create identity certificate: %v", err)
+	}
+	if cert.Leaf.Subject.CommonName != "calc-agent" {
+		t.Errorf("Common name = %q, want calc-agent", cert.Leaf.Subject.CommonName)
+	}
+
+	bound, err := IdentityCertificateKey(cert.Leaf, time.Now())
+	if err != nil {
+		t.Fatalf("Identity certificate rejected: %v", err)
+	}
+	if !bound.Equal(publicKey) {
+		t.Error("Identity certificate should carry the signer's key")
+	}
+	if _, err := IdentityCertificateKey(cert.Leaf, time.Now().Add(48*time.Hour)); err == nil {
+		t.Error("Expired identity certificate should be rejected")
+	}
+
+	// A certificate for one key signed by another is not bound to either
+	otherPub, _, _ := GenerateKeyPair()
+	forged, err := x509.CreateCertificate(rand.Reader, cert.Leaf, cert.Leaf, otherPub, privateKey)
+	if err != nil {
+		t.Fatalf("Failed to create certificate: %v", err)
+	}
+	parsed, _ := x509.ParseCertificate(forged)
+	if _, err := IdentityCertificateKey(parsed, time.Now()); !errors.Is(err, ErrNotIdentityCertificate) {
+		t.Errorf("Certificate not signed by its own key should be rejected, got %v", err)
+	}
+}
+
+func TestIdentityCertificateTLSHandshake(t *testing.T) {
+	publicKey, privateKey, _ := GenerateKeyPair()
+	var peerKey string
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if len(r.TLS.PeerCertificates) > 0 {
+			key, _ := IdentityCertificateKey(r.TLS.PeerCertificates[0], time.Now())
+			peerKey = EncodePublicKey(key)
+		}
+	}))
+	server.TLS = &tls.Config{ClientAuth: tls.RequireAnyClientCert, VerifyPeerCertificate: VerifyIdentityCertificate}
+	server.StartTLS()
+	defer server.Close()
+
+	get := func(certs ...tls.Certificate) error {
+		transport := server.Client().Transport.(*http.Transport).Clone()
+		transport.TLSClientConfig.Certificates = certs
+		resp, err := (&http.Client{Transport: transport}).Get(server.URL)
+		if err != nil {
+			return err
+		}
+		io.Copy(io.Discard, resp.Body)
+		return resp.Body.Close()
+	}
+
+	cert, _ := NewIdentityCertificate(privateKey, "calc-agent", time.Hour)
+	if err := get(cert); err != nil {
+		t.Fatalf("Handshake with identity certificate failed: %v", err)
+	}
+	if peerKey != EncodePublicKey(publicKey) {
+		t.Errorf("Server saw key %q, want the agent's key", peerKey)
+	}
+
+	if err := get(); err == nil {
+		t.Error("Handshake without a client certificate should fail when one is required")
+	}
+	rsaCert := server.TLS.Certificates[0]
+	if err := get(rsaCert); err == nil {
+		t.Error("Handshake with a certificate not bound to an Ed25519 key should fail")
+	}
+}
@@ -0,0 +1,149 @@
+package protocol
+
+import (
+	"crypto/ed25519"
+	"encoding/base64"
+	"fmt"
+)
+
+// Signature is one co-signature on an envelope
+type Signature struct {
+	Signer string `json:"signer"` // Identifier of the co-signing agent or broker
+	Sig    string `json:"sig"`    // Base64(Ed25519(signing bytes))
+}
+
+// SignaturePolicy describes which signers must have signed an envelope.
+// Required is the number of valid signatures by distinct keys needed (k-of-n);
+// zero means every listed signer must sign (all-of). Signers listed under
+// several names with one key count as one.
+type SignaturePolicy struct {
+	Required int
+	Signers  map[string]ed25519.PublicKey
+}
+
+// AllOf returns a policy requiring a signature from every listed signer
+func AllOf(signers map[string]ed25519.PublicKey) SignaturePolicy {
+	return SignaturePolicy{Signers: signers}
+}
+
+// Threshold returns a policy requiring k of the listed signers
+func Threshold(k int, signers map[string]ed25519.PublicKey) SignaturePolicy {
+	return SignaturePolicy{Required: k, Signers: signers}
+}
+
+// CoSign adds a co-signature from the given signer to the envelope
+func (e *Envelope) CoSign(signer string, key Signer) error {
+	return coSign(e.Type, &e.CommonHeaders, e.Body, signer, key)
+}
+
+// CoSign adds a co-signature from the given signer, e.g. a second administrator
+func (e *RevokeEnvelope) CoSign(signer string, key Signer) error {
+	return coSign(e.Type, &e.CommonHeaders, e.Body, signer, key)
+}
+
+// CoSign adds a co-signature from the given signer
+func (e *TypedEnvelope[T]) CoSign(signer string, key Signer) error {
+	return coSign(e.Type, &e.CommonHeaders, e.Body, signer, key)
+}
+
+// VerifyPolicy checks the envelope's signatures against a signature policy
+func (e *Envelope) VerifyPolicy(policy SignaturePolicy) error {
+	return verifyPolicy(e.Type, e.CommonHeaders, e.Body, policy)
+}
+
+// VerifyPolicy checks the envelope's signatures against a signature policy
+func (e *TypedEnvelope[T]) VerifyPolicy(policy SignaturePolicy) error {
+	return verifyPolicy(e.Type, e.CommonHeaders, e.Body, policy)
+}
+
+// VerifyPolicy checks the envelope's signatures against a signature policy
+func (g *GenericEnvelope) VerifyPolicy(policy SignaturePolicy) error {
+	return verifyPolicy(g.Type, g.CommonHeaders, g.Body, policy)
+}
+
+// coSign signs the canonical bytes and records the signature under the signer's name,
+// replacing any earlier signature by the same signer
+func coSign(envType EnvelopeType, headers *CommonHeaders, body interface{}, signer string, key Signer) error {
+	if signer == "" {
+		return fmt.Errorf("co-signer identifier is required")
+	}
+
+	data, err := SigningBytes(envType, *headers, body)
+	if err != nil {
+		return err
+	}
+
+	sig, err := signMessage(key, data)
+	if err != nil {
+		return err
+	}
+	signature := Signature{
+		Signer: signer,
+		Sig:    base64.StdEncoding.EncodeToString(sig),
+	}
+
+	for i, existing := range headers.Sigs {
+		if existing.Signer == signer {
+			headers.Sigs[i] = signature
+			return nil
+		}
+	}
+	headers.Sigs = append(headers.Sigs, signature)
+	return nil
+}
+
+// verifyPolicy counts distinct signer keys with valid signatures, so names
+// sharing a key count once. The primary sig header counts as a signature by
+// the envelope's agent.
+func verifyPolicy(envType EnvelopeType, headers CommonHeaders, body interface{}, policy SignaturePolicy) error {
+	required := policy.Required
+	if required <= 0 {
+		required = len(policy.Signers)
+	}
+	if required == 0 {
+		return fmt.Errorf("signature policy has no signers")
+	}
+	distinct := make(map[string]bool, len(policy.Signers))
+	for _, publicKey := range policy.Signers {
+		distinct[string(publicKey)] = true
+	}
+	if required > len(distinct) {
+		return fmt.Errorf("signature policy requires %d of only %d distinct signer keys", required, len(distinct))
+	}
+
+	if headers.Digest != "" {
+		if err := verifyBodyDigest(headers.Digest, body); err != nil {
+			return err
+		}
+	}
+
+	data, err := SigningBytes(envType, headers, body)
+	if err != nil {
+		return err
+	}
+
+	signatures := headers.Sigs
+	if headers.Sig != "" {
+		signatures = append([]Signature{{Signer: headers.Agent, Sig: headers.Sig}}, signatures...)
+	}
+
+	valid := make(map[string]bool) // By signer key
+	for _, signature := range signatures {
+		publicKey, known := policy.Signers[signature.Signer]
+		if !known || valid[string(publicKey)] {
+			continue
+		}
+		raw, err := base64.StdEncoding.DecodeString(signature.Sig)
+		if err != nil {
+			continue
+		}
+		if ed25519.Verify(publicKey, data, raw) {
+			valid[string(publicKey)] = true
+		}
+	}
+
+	if len(valid) < required {
+		return fmt.Errorf("signature policy not satisfied: %d of %d required signatures", len(valid), required)
+	}
+	return nil
+}
@@ -0,0 +1,133 @@
+package protocol
+
+import (
+	"crypto/ed25519"
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func TestMultiSignaturePolicies(t *testing.T) {
+	keys := make(map[string]ed25519.PrivateKey)
+	signers := make(map[string]ed25519.PublicKey)
+	for _, name := range []string{"admin-1", "admin-2", "admin-3"} {
+		pub, priv, err := GenerateKeyPair()
+		if err != nil {
+			t.Fatalf("Failed to generate key pair: %v", err)
+		}
+		keys[name] = priv
+		signers[name] = pub
+	}
+
+	envelope := &RevokeEnvelope{
+		BaseEnvelope: BaseEnvelope{
+			Type: EnvelopeRevoke,
+			CommonHeaders: CommonHeaders{
+				Agent: "admin-1",
+				TS:    time.Now().UnixMilli(),
+				Nonce: "revoke-1",
+			},
+		},
+		Body: RevokeBody{Target: "rogue-agent", Reason: "compromised"},
+	}
+
+	if err := envelope.CoSign("admin-1", keys["admin-1"]); err != nil {
+		t.Fatalf("Failed to co-sign: %v", err)
+	}
+
+	parse := func() *GenericEnvelope {
+		data, err := json.Marshal(envelope)
+		if err != nil {
+			t.Fatalf("Failed to marshal: %v", err)
+		}
+		generic, err := ParseEnvelope(data)
+		if err != nil {
+			t.Fatalf("Failed to parse: %v", err)
+		}
+		return generic
+	}
+
+	if err := parse().VerifyPolicy(Threshold(2, signers)); err == nil {
+		t.Error("One signature should not satisfy a 2-of-3 policy")
+	}
+
+	// Signing twice as the same signer does not count twice
+	envelope.CoSign("admin-1", keys["admin-1"])
+	if len(envelope.Sigs) != 1 {
+		t.Errorf("Expected re-signing to replace the signature, got %d", len(envelope.Sigs))
+	}
+
+	envelope.CoSign("admin-2", keys["admin-2"])
+	if err := parse().VerifyPolicy(Threshold(2, signers)); err != nil {
+		t.Errorf("Two signatures should satisfy a 2-of-3 policy: %v", err)
+	}
+	if err := parse().VerifyPolicy(AllOf(signers)); err == nil {
+		t.Error("Two signatures should not satisfy an all-of policy")
+	}
+
+	envelope.CoSign("admin-3", keys["admin-3"])
+	if err := parse().VerifyPolicy(AllOf(signers)); err != nil {
+		t.Errorf("Three signatures should satisfy an all-of policy: %v", err)
+	}
+
+	// A signature under the wrong name is not counted
+	forged := parse()
+	forged.Sigs[1].Signer = "admin-3"
+	forged.Sigs = forged.Sigs[:2]
+	if err := forged.VerifyPolicy(Threshold(2, signers)); err == nil {
+		t.Error("Mislabeled signature should not count toward the policy")
+	}
+
+	// Tampering invalidates every co-signature
+	tampered := parse()
+	tampered.Body = json.RawMessage(`{"target":"someone-else"}`)
+	if err := tampered.VerifyPolicy(Threshold(1, signers)); err == nil {
+		t.Error("Expected tampered envelope to fail verification")
+	}
+}
+
+func TestMultiSignatureCountsPrimarySignature(t *testing.T) {
+	agentPub, agentPriv, _ := GenerateKeyPair()
+	brokerPub, brokerPriv, _ := GenerateKeyPair()
+
+	envelope := NewEnvelope(EnvelopeRegisterAgent, "agent-1")
+	envelope.Body = json.RawMessage(`{"pubkey":"key","capabilities":[]}`)
+	if err := envelope.Sign(agentPriv); err != nil {
+		t.Fatalf("Failed to sign: %v", err)
+	}
+	if err := envelope.CoSign("broker-1", brokerPriv); err != nil {
+		t.Fatalf("Failed to co-sign: %v", err)
+	}
+
+	policy := AllOf(map[string]ed25519.PublicKey{"agent-1": agentPub, "broker-1": brokerPub})
+	if err := envelope.VerifyPolicy(policy); err != nil {
+		t.Errorf("Agent and sponsoring broker signatures should satisfy policy: %v", err)
+	}
+	if err := envelope.Verify(agentPub); err != nil {
+		t.Errorf("Co-signatures should not break the primary signature: %v", err)
+	}
+}
+
+func TestMultiSignatureCountsKeysOnce(t *testing.T) {
+	alicePub, alicePriv, _ := GenerateKeyPair()
+	bobPub, bobPriv, _ := GenerateKeyPair()
+
+	envelope := NewEnvelope(EnvelopeRevoke, "admin")
+	envelope.Body = json.RawMessage(`{"target":"agent-1"}`)
+	envelope.CoSign("alice", alicePriv)
+	envelope.CoSign("alice-laptop", alicePriv)
+
+	// One key listed under two names is one signer
+	signers := map[string]ed25519.PublicKey{"alice": alicePub, "alice-laptop": alicePub, "bob": bobPub}
+	if err := envelope.VerifyPolicy(Threshold(2, signers)); err == nil {
+		t.Error("Two signatures by the same key should not satisfy a 2-of-n policy")
+	}
+	if err := envelope.VerifyPolicy(AllOf(signers)); err == nil {
+		t.Error("An all-of policy listing a key twice can never be satisfied")
+	}
+
+	envelope.CoSign("bob", bobPriv)
+	if err := envelope.VerifyPolicy(Threshold(2, signers)); err != nil {
+		t.Errorf("Signatures by two distinct keys should satisfy a 2-of-n policy: %v", err)
+	}
+}
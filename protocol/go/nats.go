@@ -0,0 +1,567 @@
+package protocol
+
+import (
+	"bufio"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultNATSPort is where NATS servers listen when the URL names no port
+const DefaultNATSPort = "4222"
+
+// defaultNATSTimeout bounds dialing, the handshake, writes and Flush when the
+// config sets no timeout
+const defaultNATSTimeout = 5 * time.Second
+
+// NATSConfig configures a connection to a NATS server. Only the core NATS
+// protocol is spoken, so no JetStream or headers.
+type NATSConfig struct {
+	URL       string          // nats://host:port, or tls://host:port to require TLS; user:password@ or token@ set credentials
+	Name      string          // Client name shown in the server's monitoring
+	Token     string          // Auth token, overriding one in the URL
+	User      string          // User name, overriding one in the URL
+	Password  string          // Password, overriding one in the URL
+	TLS       *tls.Config     // Used when the URL or server asks for TLS; the system roots if nil
+	Timeout   time.Duration   // Bounds dialing, the handshake, writes and Flush; defaultNATSTimeout if 0
+	Reconnect ReconnectPolicy // How to redial after losing the server; DefaultReconnectPolicy if zero. Subscriptions are restored.
+}
+
+// NATSMsgHandler receives the messages of a subscription. Handlers run on the
+// connection's reader, one at a time and in order, so they must not block or
+// call Flush.
+type NATSMsgHandler func(subject string, data []byte)
+
+// NATSSubscription is interest in subjects matching Subject
+type NATSSubscription struct {
+	Subject string
+	Queue   string // Queue group; each message goes to one member of the group
+	sid     uint64
+	handler NATSMsgHandler
+	conn    *NATSConn
+}
+
+// Unsubscribe stops the subscription's messages
+func (s *NATSSubscription) Unsubscribe() error {
+	return s.conn.unsubscribe(s)
+}
+
+// natsInfo is the part of the server's INFO the client uses
+type natsInfo struct {
+	ServerID     string `json:"server_id"`
+	MaxPayload   int64  `json:"max_payload"`
+	TLSRequired  bool   `json:"tls_required"`
+	AuthRequired bool   `json:"auth_required"`
+}
+
+// natsConnect is the client's CONNECT
+type natsConnect struct {
+	Verbose     bool   `json:"verbose"`
+	Pedantic    bool   `json:"pedantic"`
+	TLSRequired bool   `json:"tls_required"`
+	Name        string `json:"name,omitempty"`
+	Lang        string `json:"lang"`
+	Version     string `json:"version"`
+	Protocol    int    `json:"protocol"`
+	Token       string `json:"auth_token,omitempty"`
+	User        string `json:"user,omitempty"`
+	Password    string `json:"pass,omitempty"`
+}
+
+// NATSConn is a connection to a NATS server
+type NATSConn struct {
+	config NATSConfig
+	target *url.URL
+	mu     sync.Mutex
+	conn   net.Conn // Nil while disconnected
+	writer *bufio.Writer
+	info   natsInfo
+	subs   map[uint64]*NATSSubscription
+	sid    uint64
+	pongs  []chan error // Flush calls waiting for their PONG, in order
+	state  ConnectionState
+	closed chan struct{}
+}
+
+// DialNATS connects to a NATS server
+func DialNATS(config NATSConfig) (*NATSConn, error) {
+	target, err := parseNATSURL(config.URL)
+	if err != nil {
+		return nil, err
+	}
+	if config.Timeout <= 0 {
+		config.Timeout = defaultNATSTimeout
+	}
+	if config.Reconnect == (ReconnectPolicy{}) {
+		config.Reconnect = DefaultReconnectPolicy()
+	}
+	c := &NATSConn{
+		config: config,
+		target: target,
+		subs:   make(map[uint64]*NATSSubscription),
+		state:  StateConnecting,
+		closed: make(chan struct{}),
+	}
+	if err := c.connect(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+func parseNATSURL(raw string) (*url.URL, error) {
+	if !strings.Contains(raw, "://") {
+		raw = "nats://" + raw
+	}
+	target, err := url.Parse(raw)
+	if err != nil {
+		return nil, fmt.Errorf("invalid NATS URL: %w", err)
+	}
+	if target.Scheme != "nats" && target.Scheme != "tls" {
+		return nil, fmt.Errorf("unsupported NATS URL scheme %q", target.Scheme)
+	}
+	if target.Hostname() == "" {
+		return nil, fmt.Errorf("NATS URL %q names no host", raw)
+	}
+	if target.Port() == "" {
+		target.Host = net.JoinHostPort(target.Hostname(), DefaultNATSPort)
+	}
+	return target, nil
+}
+
+// connect dials the server, completes the handshake, restores the
+// subscriptions and starts reading
+func (c *NATSConn) connect() error {
+	deadline := time.Now().Add(c.config.Timeout)
+	conn, err := net.DialTimeout("tcp", c.target.Host, c.config.Timeout)
+	if err != nil {
+		return err
+	}
+	conn.SetDeadline(deadline)
+	reader := bufio.NewReader(conn)
+
+	// The server speaks first, saying whether it needs TLS
+	line, err := readNATSLine(reader)
+	if err != nil {
+		conn.Close()
+		return fmt.Errorf("failed to read NATS server info: %w", err)
+	}
+	op, args, _ := strings.Cut(line, " ")
+	if !strings.EqualFold(op, "INFO") {
+		conn.Close()
+		return fmt.Errorf("expected INFO from NATS server, got %q", line)
+	}
+	var info natsInfo
+	if err := json.Unmarshal([]byte(args), &info); err != nil {
+		conn.Close()
+		return fmt.Errorf("invalid NATS server info: %w", err)
+	}
+	useTLS := c.target.Scheme == "tls" || info.TLSRequired || c.config.TLS != nil
+	if useTLS {
+		config := &tls.Config{}
+		if c.config.TLS != nil {
+			config = c.config.TLS.Clone()
+		}
+		if config.ServerName == "" {
+			config.ServerName = c.target.Hostname()
+		}
+		tlsConn := tls.Client(conn, config)
+		if err := tlsConn.Handshake(); err != nil {
+			conn.Close()
+			return fmt.Errorf("NATS TLS handshake failed: %w", err)
+		}
+		conn = tlsConn
+		reader = bufio.NewReader(conn)
+	}
+
+	connect := natsConnect{
+		TLSRequired: useTLS,
+		Name:        c.config.Name,
+		Lang:        "go",
+		Version:     ProtocolVersion,
+		Protocol:    1,
+		Token:       c.config.Token,
+		User:        c.config.User,
+		Password:    c.config.Password,
+	}
+	if user := c.target.User; user != nil {
+		password, hasPassword := user.Password()
+		switch {
+		case hasPassword && connect.User == "":
+			connect.User, connect.Password = user.Username(), password
+		case !hasPassword && connect.Token == "":
+			connect.Token = user.Username()
+		}
+	}
+	data, err := json.Marshal(connect)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	// A PING after CONNECT is answered with PONG once the server accepted
+	// the connection, or -ERR if it refused it
+	if _, err := fmt.Fprintf(conn, "CONNECT %s\r\nPING\r\n", data); err != nil {
+		conn.Close()
+		return err
+	}
+	for {
+		line, err := readNATSLine(reader)
+		if err != nil {
+			conn.Close()
+			return fmt.Errorf("NATS handshake failed: %w", err)
+		}
+		if strings.HasPrefix(strings.ToUpper(line), "-ERR") {
+			conn.Close()
+			return fmt.Errorf("NATS server refused the connection: %s", natsError(line))
+		}
+		if strings.EqualFold(line, "PONG") {
+			break
+		}
+	}
+	conn.SetDeadline(time.Time{})
+
+	c.mu.Lock()
+	if c.state == StateClosed {
+		c.mu.Unlock()
+		conn.Close()
+		return ErrNotConnected
+	}
+	c.conn = conn
+	c.writer = bufio.NewWriter(conn)
+	c.info = info
+	for _, sub := range c.subs {
+		c.writeSubLocked(sub)
+	}
+	if err := c.flushLocked(); err != nil {
+		c.conn, c.writer = nil, nil
+		c.mu.Unlock()
+		conn.Close()
+		return err
+	}
+	c.state = StateConnected
+	c.mu.Unlock()
+
+	go c.readLoop(conn, reader)
+	return nil
+}
+
+// readLoop handles what the server sends until the connection fails
+func (c *NATSConn) readLoop(conn net.Conn, reader *bufio.Reader) {
+	for {
+		line, err := readNATSLine(reader)
+		if err != nil {
+			c.connectionLost(conn, err)
+			return
+		}
+		op, args, _ := strings.Cut(line, " ")
+		switch strings.ToUpper(op) {
+		case "MSG":
+			if err := c.readMsg(reader, args); err != nil {
+				c.connectionLost(conn, err)
+				return
+			}
+		case "PING":
+			c.mu.Lock()
+			if c.conn == conn {
+				c.writer.WriteString("PONG\r\n")
+				c.flushLocked()
+			}
+			c.mu.Unlock()
+		case "PONG":
+			c.mu.Lock()
+			if len(c.pongs) > 0 {
+				c.pongs[0] <- nil
+				c.pongs = c.pongs[1:]
+			}
+			c.mu.Unlock()
+		case "-ERR":
+			log.Printf("NATS server error: %s", natsError(line))
+		}
+	}
+}
+
+// readMsg reads a message's payload and hands it to its subscription.
+// The arguments are subject, sid, an optional reply subject and the size.
+func (c *NATSConn) readMsg(reader *bufio.Reader, args string) error {
+	fields := strings.Fields(args)
+	if len(fields) < 3 || len(fields) > 4 {
+		return fmt.Errorf("malformed NATS message %q", args)
+	}
+	sid, err := strconv.ParseUint(fields[1], 10, 64)
+	if err != nil {
+		return fmt.Errorf("malformed NATS message %q", args)
+	}
+	size, err := strconv.Atoi(fields[len(fields)-1])
+	if err != nil || size < 0 || int64(size) > c.maxPayload() {
+		return fmt.Errorf("malformed NATS message size %q", fields[len(fields)-1])
+	}
+	payload := make([]byte, size+2)
+	if _, err := io.ReadFull(reader, payload); err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	sub := c.subs[sid]
+	c.mu.Unlock()
+	if sub != nil {
+		sub.handler(fields[0], payload[:size])
+	}
+	return nil
+}
+
+// maxPayload bounds the messages read, trusting the server's limit when it
+// announced one
+func (c *NATSConn) maxPayload() int64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.info.MaxPayload > 0 {
+		return c.info.MaxPayload
+	}
+	return DefaultMaxEnvelopeSize
+}
+
+// connectionLost drops a failed connection and starts reconnecting, unless
+// the connection was already replaced or closed
+func (c *NATSConn) connectionLost(conn net.Conn, cause error) {
+	conn.Close()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.conn != conn {
+		return
+	}
+	c.conn = nil
+	c.writer = nil
+	c.failPongsLocked(ErrNotConnected)
+	if c.state == StateClosed {
+		return
+	}
+	if c.config.Reconnect.Disabled {
+		c.state = StateDisconnected
+		log.Printf("Lost NATS server %s: %v", c.target.Host, cause)
+		return
+	}
+	c.state = StateReconnecting
+	log.Printf("Lost NATS server %s, reconnecting: %v", c.target.Host, cause)
+	go c.reconnect()
+}
+
+// reconnect redials with backoff until connected, closed or out of attempts
+func (c *NATSConn) reconnect() {
+	for attempt := 1; ; attempt++ {
+		policy := c.config.Reconnect
+		if policy.MaxAttempts > 0 && attempt > policy.MaxAttempts {
+			c.mu.Lock()
+			if c.state == StateReconnecting {
+				c.state = StateDisconnected
+			}
+			c.mu.Unlock()
+			log.Printf("Gave up reconnecting to NATS server %s", c.target.Host)
+			return
+		}
+		select {
+		case <-time.After(policy.Backoff(attempt)):
+		case <-c.closed:
+			return
+		}
+		if err := c.connect(); err == nil {
+			log.Printf("Reconnected to NATS server %s", c.target.Host)
+			return
+		}
+	}
+}
+
+// State returns where the connection is in its lifecycle
+func (c *NATSConn) State() ConnectionState {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.state
+}
+
+// Server returns the address of the server, without credentials
+func (c *NATSConn) Server() string {
+	return c.target.Host
+}
+
+// Publish sends data to subscribers of subject
+func (c *NATSConn) Publish(subject string, data []byte) error {
+	if err := validateNATSSubject(subject, false); err != nil {
+		return err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.conn == nil {
+		return ErrNotConnected
+	}
+	if c.info.MaxPayload > 0 && int64(len(data)) > c.info.MaxPayload {
+		return fmt.Errorf("%w: %d bytes exceed the NATS server's %d byte limit", ErrEnvelopeTooLarge, len(data), c.info.MaxPayload)
+	}
+	fmt.Fprintf(c.writer, "PUB %s %d\r\n", subject, len(data))
+	c.writer.Write(data)
+	c.writer.WriteString("\r\n")
+	return c.flushLocked()
+}
+
+// Subscribe calls handler with the messages on subjects matching subject,
+// which may use the NATS wildcards * and >
+func (c *NATSConn) Subscribe(subject string, handler NATSMsgHandler) (*NATSSubscription, error) {
+	return c.QueueSubscribe(subject, "", handler)
+}
+
+// QueueSubscribe is Subscribe sharing the messages among the members of a
+// queue group, each message going to one of them
+func (c *NATSConn) QueueSubscribe(subject, queue string, handler NATSMsgHandler) (*NATSSubscription, error) {
+	if err := validateNATSSubject(subject, true); err != nil {
+		return nil, err
+	}
+	if strings.ContainsAny(queue, " \t\r\n") {
+		return nil, fmt.Errorf("NATS queue group %q contains whitespace", queue)
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.state == StateClosed {
+		return nil, ErrNotConnected
+	}
+	c.sid++
+	sub := &NATSSubscription{Subject: subject, Queue: queue, sid: c.sid, handler: handler, conn: c}
+	c.subs[sub.sid] = sub
+	// While disconnected, the subscription is sent on reconnecting
+	if c.conn != nil {
+		c.writeSubLocked(sub)
+		if err := c.flushLocked(); err != nil {
+			return nil, err
+		}
+	}
+	return sub, nil
+}
+
+func (c *NATSConn) writeSubLocked(sub *NATSSubscription) {
+	if sub.Queue != "" {
+		fmt.Fprintf(c.writer, "SUB %s %s %d\r\n", sub.Subject, sub.Queue, sub.sid)
+	} else {
+		fmt.Fprintf(c.writer, "SUB %s %d\r\n", sub.Subject, sub.sid)
+	}
+}
+
+func (c *NATSConn) unsubscribe(sub *NATSSubscription) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, exists := c.subs[sub.sid]; !exists {
+		return nil
+	}
+	delete(c.subs, sub.sid)
+	if c.conn == nil {
+		return nil
+	}
+	fmt.Fprintf(c.writer, "UNSUB %d\r\n", sub.sid)
+	return c.flushLocked()
+}
+
+// Flush waits until the server has processed everything sent before it
+func (c *NATSConn) Flush() error {
+	pong := make(chan error, 1)
+	c.mu.Lock()
+	if c.conn == nil {
+		c.mu.Unlock()
+		return ErrNotConnected
+	}
+	c.pongs = append(c.pongs, pong)
+	c.writer.WriteString("PING\r\n")
+	err := c.flushLocked()
+	c.mu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	timer := time.NewTimer(c.config.Timeout)
+	defer timer.Stop()
+	select {
+	case err := <-pong:
+		return err
+	case <-timer.C:
+		return fmt.Errorf("NATS flush timed out after %s", c.config.Timeout)
+	}
+}
+
+// Close disconnects from the server; the connection cannot be reused
+func (c *NATSConn) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.state == StateClosed {
+		return nil
+	}
+	c.state = StateClosed
+	close(c.closed)
+	c.failPongsLocked(ErrNotConnected)
+	if c.conn == nil {
+		return nil
+	}
+	c.writer.Flush()
+	err := c.conn.Close()
+	c.conn = nil
+	c.writer = nil
+	return err
+}
+
+// flushLocked writes what is buffered, bounded by the timeout so a stalled
+// server cannot hold the connection's lock
+func (c *NATSConn) flushLocked() error {
+	c.conn.SetWriteDeadline(time.Now().Add(c.config.Timeout))
+	err := c.writer.Flush()
+	c.conn.SetWriteDeadline(time.Time{})
+	return err
+}
+
+func (c *NATSConn) failPongsLocked(err error) {
+	for _, pong := range c.pongs {
+		pong <- err
+	}
+	c.pongs = nil
+}
+
+// readNATSLine reads a protocol line without its CRLF
+func readNATSLine(reader *bufio.Reader) (string, error) {
+	line, err := reader.ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(line, "\r\n"), nil
+}
+
+// natsError returns the message of an -ERR line
+func natsError(line string) string {
+	return strings.Trim(strings.TrimSpace(line[len("-ERR"):]), "'")
+}
+
+// validateNATSSubject checks subject is a dot-separated list of non-empty
+// tokens, which may only be the wildcards * or > when wildcards is set
+func validateNATSSubject(subject string, wildcards bool) error {
+	if subject == "" {
+		return errors.New("empty NATS subject")
+	}
+	if strings.ContainsAny(subject, " \t\r\n") {
+		return fmt.Errorf("NATS subject %q contains whitespace", subject)
+	}
+	tokens := strings.Split(subject, ".")
+	for i, token := range tokens {
+		switch {
+		case token == "":
+			return fmt.Errorf("NATS subject %q has an empty token", subject)
+		case token == "*" || token == ">":
+			if !wildcards {
+				return fmt.Errorf("NATS subject %q may not use wildcards", subject)
+			}
+			if token == ">" && i != len(tokens)-1 {
+				return fmt.Errorf("NATS subject %q may only use > as its last token", subject)
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,313 @@
+package protocol
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// natsTestServer speaks enough of the NATS protocol to route messages
+// between its clients
+type natsTestServer struct {
+	listener net.Listener
+	token    string // Auth token clients must send, if set
+	mu       sync.Mutex
+	clients  map[net.Conn]map[string]string // Subject of each sid, per client
+	connects []natsConnect
+}
+
+func newNATSTestServer(t *testing.T, token string) *natsTestServer {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	s := &natsTestServer{listener: listener, token: token, clients: make(map[net.Conn]map[string]string)}
+	t.Cleanup(func() {
+		listener.Close()
+		s.dropClients()
+	})
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go s.serve(conn)
+		}
+	}()
+	return s
+}
+
+func (s *natsTestServer) URL() string {
+	return "nats://" + s.listener.Addr().String()
+}
+
+func (s *natsTestServer) serve(conn net.Conn) {
+	defer func() {
+		s.mu.Lock()
+		delete(s.clients, conn)
+		s.mu.Unlock()
+		conn.Close()
+	}()
+	fmt.Fprintf(conn, "INFO {\"server_id\":\"test\",\"max_payload\":1048576,\"auth_required\":%t}\r\n", s.token != "")
+	s.mu.Lock()
+	s.clients[conn] = make(map[string]string)
+	s.mu.Unlock()
+
+	reader := bufio.NewReader(conn)
+	for {
+		line, err := readNATSLine(reader)
+		if err != nil {
+			return
+		}
+		op, args, _ := strings.Cut(line, " ")
+		fields := strings.Fields(args)
+		switch op {
+		case "CONNECT":
+			var connect natsConnect
+			json.Unmarshal([]byte(args), &connect)
+			s.mu.Lock()
+			s.connects = append(s.connects, connect)
+			s.mu.Unlock()
+			if s.token != "" && connect.Token != s.token {
+				io.WriteString(conn, "-ERR 'Authorization Violation'\r\n")
+				return
+			}
+		case "PING":
+			io.WriteString(conn, "PONG\r\n")
+		case "SUB":
+			s.mu.Lock()
+			s.clients[conn][fields[len(fields)-1]] = fields[0]
+			s.mu.Unlock()
+		case "UNSUB":
+			s.mu.Lock()
+			delete(s.clients[conn], fields[0])
+			s.mu.Unlock()
+		case "PUB":
+			size, _ := strconv.Atoi(fields[len(fields)-1])
+			payload := make([]byte, size+2)
+			if _, err := io.ReadFull(reader, payload); err != nil {
+				return
+			}
+			s.route(fields[0], payload[:size])
+		}
+	}
+}
+
+func (s *natsTestServer) route(subject string, payload []byte) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for client, subs := range s.clients {
+		for sid, pattern := range subs {
+			if natsSubjectMatches(pattern, subject) {
+				fmt.Fprintf(client, "MSG %s %s %d\r\n%s\r\n", subject, sid, len(payload), payload)
+			}
+		}
+	}
+}
+
+// dropClients disconnects every client, as a restarting server would
+func (s *natsTestServer) dropClients() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for client := range s.clients {
+		client.Close()
+	}
+}
+
+func natsSubjectMatches(pattern, subject string) bool {
+	patternTokens, subjectTokens := strings.Split(pattern, "."), strings.Split(subject, ".")
+	for i, token := range patternTokens {
+		if token == ">" {
+			return len(subjectTokens) > i
+		}
+		if i >= len(subjectTokens) || (token != "*" && token != subjectTokens[i]) {
+			return false
+		}
+	}
+	return len(patternTokens) == len(subjectTokens)
+}
+
+// natsReceiver collects a subscription's messages
+func natsReceiver() (NATSMsgHandler, chan string) {
+	received := make(chan string, 16)
+	return func(subject string, data []byte) { received <- subject + " " + string(data) }, received
+}
+
+func expectNATSMessage(t *testing.T, received chan string, want string) {
+	t.Helper()
+	select {
+	case got := <-received:
+		if got != want {
+			t.Errorf("Expected %q, got %q", want, got)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Expected %q, got nothing", want)
+	}
+}
+
+func TestNATSPublishSubscribe(t *testing.T) {
+	server := newNATSTestServer(t, "s3cret")
+	conn, err := DialNATS(NATSConfig{URL: strings.Replace(server.URL(), "://", "://s3cret@", 1), Name: "broker-1"})
+	if err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	defer conn.Close()
+	server.mu.Lock()
+	connect := server.connects[0]
+	server.mu.Unlock()
+	if connect.Token != "s3cret" || connect.Name != "broker-1" || connect.Verbose {
+		t.Errorf("Unexpected CONNECT %+v", connect)
+	}
+
+	handler, received := natsReceiver()
+	sub, err := conn.Subscribe("fem.topic.prod.>", handler)
+	if err != nil {
+		t.Fatalf("Failed to subscribe: %v", err)
+	}
+	conn.Publish("fem.topic.dev.build", []byte("skipped"))
+	conn.Publish("fem.topic.prod.ci.build", []byte("hello\r\nworld"))
+	expectNATSMessage(t, received, "fem.topic.prod.ci.build hello\r\nworld")
+
+	sub.Unsubscribe()
+	conn.Publish("fem.topic.prod.ci.build", []byte("late"))
+	if err := conn.Flush(); err != nil {
+		t.Fatalf("Flush failed: %v", err)
+	}
+	select {
+	case got := <-received:
+		t.Errorf("Expected nothing after unsubscribing, got %q", got)
+	default:
+	}
+
+	if err := conn.Publish("fem.topic.*", nil); err == nil {
+		t.Error("Publishing to a wildcard subject should be refused")
+	}
+	if _, err := conn.Subscribe("fem.>.topic", handler); err == nil {
+		t.Error("> should only be allowed as the last token")
+	}
+	conn.Close()
+	if err := conn.Publish("fem.topic.prod", nil); err != ErrNotConnected {
+		t.Errorf("Expected ErrNotConnected after Close, got %v", err)
+	}
+}
+
+func TestNATSRefusedConnection(t *testing.T) {
+	server := newNATSTestServer(t, "s3cret")
+	if _, err := DialNATS(NATSConfig{URL: server.URL(), Token: "wrong"}); err == nil || !strings.Contains(err.Error(), "Authorization Violation") {
+		t.Errorf("Expected the server's refusal, got %v", err)
+	}
+	if _, err := DialNATS(NATSConfig{URL: "http://" + server.listener.Addr().String()}); err == nil {
+		t.Error("Expected non-NATS URL schemes to be refused")
+	}
+}
+
+func TestNATSReconnectRestoresSubscriptions(t *testing.T) {
+	server := newNATSTestServer(t, "")
+	conn, err := DialNATS(NATSConfig{URL: server.URL(), Reconnect: ReconnectPolicy{InitialBackoff: 10 * time.Millisecond}})
+	if err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	defer conn.Close()
+	handler, received := natsReceiver()
+	conn.Subscribe("fem.agent.worker", handler)
+
+	server.dropClients()
+	for deadline := time.Now().Add(5 * time.Second); ; time.Sleep(10 * time.Millisecond) {
+		server.mu.Lock()
+		connects := len(server.connects)
+		server.mu.Unlock()
+		if connects == 2 && conn.State() == StateConnected {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Did not reconnect, state %s", conn.State())
+		}
+	}
+	// Flushing guarantees the restored SUB reached the server first
+	if err := conn.Flush(); err != nil {
+		t.Fatalf("Flush failed: %v", err)
+	}
+	conn.Publish("fem.agent.worker", []byte("again"))
+	expectNATSMessage(t, received, "fem.agent.worker again")
+}
+
+func TestNATSTransportRoutesEnvelopes(t *testing.T) {
+	server := newNATSTestServer(t, "")
+	conn, err := DialNATS(NATSConfig{URL: server.URL()})
+	if err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	defer conn.Close()
+	transport := NewNATSTransport(conn, "")
+	pubKey, privKey, _ := GenerateKeyPair()
+
+	envelopes := make(chan *GenericEnvelope, 4)
+	if _, err := transport.ReceiveAgent("worker", func(env *GenericEnvelope) { envelopes <- env }); err != nil {
+		t.Fatalf("Failed to receive: %v", err)
+	}
+	events := make(chan *GenericEnvelope, 4)
+	if _, err := transport.SubscribeTopic("prod.*", func(env *GenericEnvelope) { events <- env }); err != nil {
+		t.Fatalf("Failed to subscribe: %v", err)
+	}
+
+	call, _ := NewToolCall("caller").Tool("shell.run").RequestID("call-1").SignWith(privKey)
+	if err := transport.SendToAgent("worker", call); err != nil {
+		t.Fatalf("Failed to send: %v", err)
+	}
+	stale := NewTypedEnvelope("ci", EmitEventBody{Event: "prod.ci.build"})
+	stale.TS = time.Now().Add(-time.Hour).UnixMilli()
+	stale.Sign(privKey)
+	transport.PublishEvent(stale)
+	for _, topic := range []string{"dev.ci.build", "prod.ci.build"} {
+		event := NewTypedEnvelope("ci", EmitEventBody{Event: topic})
+		event.Sign(privKey)
+		if err := transport.PublishEvent(event); err != nil {
+			t.Fatalf("Failed to publish: %v", err)
+		}
+	}
+
+	select {
+	case env := <-envelopes:
+		if env.Type != EnvelopeToolCall || env.Agent != "caller" {
+			t.Errorf("Expected the call, got %+v", env)
+		}
+		if err := env.Verify(pubKey); err != nil {
+			t.Errorf("Received envelopes should keep their signatures: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Call was not delivered")
+	}
+	select {
+	case env := <-events:
+		if event, _ := ParseTyped[EmitEventBody](env); event.Body.Event != "prod.ci.build" || event.TS < time.Now().Add(-time.Minute).UnixMilli() {
+			t.Errorf("Expected only the fresh prod event, got %+v", event)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Event was not delivered")
+	}
+	if err := conn.Flush(); err != nil {
+		t.Fatalf("Flush failed: %v", err)
+	}
+	if len(events) != 0 {
+		t.Errorf("Expected no other events, got %d", len(events))
+	}
+
+	if err := transport.PublishEvent(call); err == nil {
+		t.Error("Only emitEvent envelopes should be published to topics")
+	}
+	if _, err := transport.AgentSubject("bad agent"); err == nil {
+		t.Error("Agent IDs with whitespace should not become subjects")
+	}
+	if subject, _ := transport.TopicPatternSubject("prod.*.build"); subject != "fem.topic.prod.*.build" {
+		t.Errorf("A * inside a pattern should match one token, got %s", subject)
+	}
+}
@@ -0,0 +1,212 @@
+package protocol
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultNATSPrefix is the first token of the subjects envelopes travel on
+const DefaultNATSPrefix = "fem"
+
+// NATSTransport exchanges signed envelopes over NATS. Each agent receives on
+// <prefix>.agent.<agentID> and each event topic maps onto
+// <prefix>.topic.<topic>, so NATS routes events by their topic hierarchy.
+// Envelopes are sent as signed; receivers still verify signatures.
+type NATSTransport struct {
+	conn        *NATSConn
+	prefix      string
+	skew        SkewPolicy
+	limits      SizeLimits
+	compression *CompressionPolicy // Applied to envelopes sent; nil sends bodies uncompressed
+	mu          sync.RWMutex
+}
+
+// NewNATSTransport exchanges envelopes over conn on subjects under prefix,
+// DefaultNATSPrefix if empty
+func NewNATSTransport(conn *NATSConn, prefix string) *NATSTransport {
+	if prefix == "" {
+		prefix = DefaultNATSPrefix
+	}
+	return &NATSTransport{
+		conn:   conn,
+		prefix: prefix,
+		skew:   DefaultSkewPolicy(),
+		limits: SizeLimits{Max: DefaultMaxEnvelopeSize},
+	}
+}
+
+// Conn returns the NATS connection envelopes travel over
+func (t *NATSTransport) Conn() *NATSConn {
+	return t.conn
+}
+
+// SetSkewPolicy bounds how old or future-dated a received envelope's ts may
+// be. Envelopes outside the window are dropped before reaching handlers.
+func (t *NATSTransport) SetSkewPolicy(policy SkewPolicy) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.skew = policy
+}
+
+// SetSizeLimits sets the largest envelope received, overall and for
+// individual types. Larger envelopes are dropped before reaching handlers.
+func (t *NATSTransport) SetSizeLimits(limits SizeLimits) {
+	if limits.Max <= 0 {
+		limits.Max = DefaultMaxEnvelopeSize
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.limits = limits
+}
+
+// SetCompression compresses the bodies of envelopes sent; nil turns
+// compression off. Compressed envelopes are inflated on receipt whatever the
+// policy.
+func (t *NATSTransport) SetCompression(policy *CompressionPolicy) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.compression = policy
+}
+
+// AgentSubject returns the subject an agent receives envelopes on
+func (t *NATSTransport) AgentSubject(agentID string) (string, error) {
+	subject := t.prefix + ".agent." + agentID
+	if err := validateNATSSubject(subject, false); err != nil {
+		return "", fmt.Errorf("agent ID %q cannot be a NATS subject: %w", agentID, err)
+	}
+	return subject, nil
+}
+
+// TopicSubject returns the subject events on a topic are published to
+func (t *NATSTransport) TopicSubject(topic string) (string, error) {
+	if err := validateTopicSegments(topic); err != nil {
+		return "", err
+	}
+	subject := t.prefix + ".topic." + topic
+	if err := validateNATSSubject(subject, false); err != nil {
+		return "", fmt.Errorf("topic %q cannot be a NATS subject: %w", topic, err)
+	}
+	return subject, nil
+}
+
+// TopicPatternSubject returns the subject matching the topics a pattern
+// matches. A * ending a pattern matches one or more segments, as NATS's >
+// does; elsewhere it matches one, as NATS's * does.
+func (t *NATSTransport) TopicPatternSubject(pattern string) (string, error) {
+	if err := ValidateTopicPattern(pattern); err != nil {
+		return "", err
+	}
+	segments := strings.Split(pattern, ".")
+	if segments[len(segments)-1] == "*" {
+		segments[len(segments)-1] = ">"
+	}
+	subject := t.prefix + ".topic." + strings.Join(segments, ".")
+	if err := validateNATSSubject(subject, true); err != nil {
+		return "", fmt.Errorf("pattern %q cannot be a NATS subject: %w", pattern, err)
+	}
+	return subject, nil
+}
+
+// SendToAgent publishes a signed envelope to an agent's subject
+func (t *NATSTransport) SendToAgent(agentID string, envelope interface{}) error {
+	subject, err := t.AgentSubject(agentID)
+	if err != nil {
+		return err
+	}
+	data, err := t.encode(envelope)
+	if err != nil {
+		return err
+	}
+	return t.conn.Publish(subject, data)
+}
+
+// PublishEvent publishes a signed emitEvent envelope to its topic's subject
+func (t *NATSTransport) PublishEvent(envelope interface{}) error {
+	data, err := json.Marshal(envelope)
+	if err != nil {
+		return err
+	}
+	env, err := ParseEnvelope(data)
+	if err != nil {
+		return err
+	}
+	event, err := ParseTyped[EmitEventBody](env)
+	if err != nil {
+		return fmt.Errorf("only emitEvent envelopes can be published to topics: %w", err)
+	}
+	subject, err := t.TopicSubject(event.Body.Event)
+	if err != nil {
+		return err
+	}
+	if data, err = t.encode(json.RawMessage(data)); err != nil {
+		return err
+	}
+	return t.conn.Publish(subject, data)
+}
+
+// ReceiveAgent calls handler with the envelopes sent to an agent
+func (t *NATSTransport) ReceiveAgent(agentID string, handler func(*GenericEnvelope)) (*NATSSubscription, error) {
+	subject, err := t.AgentSubject(agentID)
+	if err != nil {
+		return nil, err
+	}
+	return t.conn.Subscribe(subject, t.receive(handler))
+}
+
+// SubscribeTopic calls handler with the emitEvent envelopes published on
+// topics matching pattern
+func (t *NATSTransport) SubscribeTopic(pattern string, handler func(*GenericEnvelope)) (*NATSSubscription, error) {
+	subject, err := t.TopicPatternSubject(pattern)
+	if err != nil {
+		return nil, err
+	}
+	return t.conn.Subscribe(subject, t.receive(func(env *GenericEnvelope) {
+		if env.Type == EnvelopeEmitEvent {
+			handler(env)
+		}
+	}))
+}
+
+func (t *NATSTransport) encode(envelope interface{}) ([]byte, error) {
+	data, err := json.Marshal(envelope)
+	if err != nil {
+		return nil, err
+	}
+	t.mu.RLock()
+	policy := t.compression
+	t.mu.RUnlock()
+	return CompressBody(data, policy)
+}
+
+// receive parses the envelopes of a subscription, dropping those that are
+// malformed, too large or outside the skew window
+func (t *NATSTransport) receive(handler func(*GenericEnvelope)) NATSMsgHandler {
+	return func(subject string, data []byte) {
+		t.mu.RLock()
+		limits, skew := t.limits, t.skew
+		t.mu.RUnlock()
+
+		if len(data) > limits.Largest() {
+			log.Printf("Dropping %d byte envelope on %s: too large", len(data), subject)
+			return
+		}
+		env, err := ParseEnvelope(data)
+		if err != nil {
+			log.Printf("Dropping malformed envelope on %s: %v", subject, err)
+			return
+		}
+		if err := limits.Check(env.Type, len(data)); err != nil {
+			log.Printf("Dropping envelope on %s: %v", subject, err)
+			return
+		}
+		if err := skew.Check(env.TS, time.Now()); err != nil {
+			log.Printf("Dropping envelope from %s on %s: %v", env.Agent, subject, err)
+			return
+		}
+		handler(env)
+	}
+}
@@ -0,0 +1,256 @@
+package protocol
+
+import (
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// Response headers carrying the broker's identity proof
+const (
+	BrokerKeyHeader       = "X-FEM-Broker-Key"       // Base64 Ed25519 public key of the broker
+	BrokerSignatureHeader = "X-FEM-Broker-Signature" // Signature over the request nonce and response body
+)
+
+// ErrBrokerKeyChanged is returned when a broker presents a key different from the pinned one
+var ErrBrokerKeyChanged = errors.New("broker identity key changed")
+
+// SignBrokerResponse signs a response body bound to the nonce of the request it answers
+func SignBrokerResponse(signer Signer, requestNonce string, body []byte) (string, error) {
+	signature, err := signMessage(signer, brokerResponseMessage(requestNonce, body))
+	if err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(signature), nil
+}
+
+// VerifyBrokerResponse checks a response's identity headers and returns the broker's key
+func VerifyBrokerResponse(header http.Header, requestNonce string, body []byte) (ed25519.PublicKey, error) {
+	encodedKey := header.Get(BrokerKeyHeader)
+	encodedSig := header.Get(BrokerSignatureHeader)
+	if encodedKey == "" || encodedSig == "" {
+		return nil, fmt.Errorf("broker response is not signed")
+	}
+
+	publicKey, err := DecodePublicKey(encodedKey)
+	if err != nil {
+		return nil, err
+	}
+	signature, err := base64.StdEncoding.DecodeString(encodedSig)
+	if err != nil {
+		return nil, fmt.Errorf("invalid broker signature encoding: %w", err)
+	}
+	if !ed25519.Verify(publicKey, brokerResponseMessage(requestNonce, body), signature) {
+		return nil, fmt.Errorf("broker response signature verification failed")
+	}
+	return publicKey, nil
+}
+
+func brokerResponseMessage(requestNonce string, body []byte) []byte {
+	digest := sha256.Sum256(body)
+	msg := make([]byte, 0, len("fem-broker-response-v1")+len(requestNonce)+2+len(digest))
+	msg = append(msg, "fem-broker-response-v1"...)
+	msg = append(msg, 0)
+	msg = append(msg, requestNonce...)
+	msg = append(msg, 0)
+	return append(msg, digest[:]...)
+}
+
+// PinStore persists pinned broker identity keys
+type PinStore interface {
+	Get(brokerURL string) (string, bool, error)
+	Set(brokerURL, encodedKey string) error
+	Delete(brokerURL string) error
+}
+
+// PinMode controls what happens when a broker's key does not match its pin
+type PinMode string
+
+const (
+	PinModeEnforce PinMode = "enforce" // Refuse to talk to the broker
+	PinModeWarn    PinMode = "warn"    // Log a warning and continue
+)
+
+// BrokerPins implements trust-on-first-use pinning of broker identity keys
+type BrokerPins struct {
+	Store PinStore
+	Mode  PinMode
+}
+
+// NewBrokerPins creates a pinner; a nil store keeps pins in memory only
+func NewBrokerPins(store PinStore, mode PinMode) *BrokerPins {
+	if store == nil {
+		store = NewMemoryPinStore()
+	}
+	if mode == "" {
+		mode = PinModeEnforce
+	}
+	return &BrokerPins{Store: store, Mode: mode}
+}
+
+// Check pins the key on first contact and verifies it on every later contact
+func (p *BrokerPins) Check(brokerURL string, publicKey ed25519.PublicKey) error {
+	presented := EncodePublicKey(publicKey)
+	pinned, exists, err := p.Store.Get(brokerURL)
+	if err != nil {
+		return fmt.Errorf("failed to read pin for %s: %w", brokerURL, err)
+	}
+
+	if !exists {
+		return p.Store.Set(brokerURL, presented)
+	}
+	if pinned == presented {
+		return nil
+	}
+
+	if p.Mode == PinModeWarn {
+		log.Printf("WARNING: %v for %s (pinned %s, presented %s)", ErrBrokerKeyChanged, brokerURL, pinned, presented)
+		return nil
+	}
+	return fmt.Errorf("%w for %s: pinned %s, presented %s", ErrBrokerKeyChanged, brokerURL, pinned, presented)
+}
+
+// VerifyResponse verifies a broker response's signature and checks the key against the pin
+func (p *BrokerPins) VerifyResponse(brokerURL string, header http.Header, requestNonce string, body []byte) error {
+	publicKey, err := VerifyBrokerResponse(header, requestNonce, body)
+	if err != nil {
+		if p.Mode == PinModeWarn {
+			log.Printf("WARNING: could not verify identity of %s: %v", brokerURL, err)
+			return nil
+		}
+		return err
+	}
+	return p.Check(brokerURL, publicKey)
+}
+
+// Repin replaces the pinned key after a legitimate rotation. The new key should
+// be obtained out of band from the broker operator.
+func (p *BrokerPins) Repin(brokerURL string, publicKey ed25519.PublicKey) error {
+	return p.Store.Set(brokerURL, EncodePublicKey(publicKey))
+}
+
+// Forget removes a pin so the next contact is trusted on first use again
+func (p *BrokerPins) Forget(brokerURL string) error {
+	return p.Store.Delete(brokerURL)
+}
+
+// MemoryPinStore keeps pins for the lifetime of the process
+type MemoryPinStore struct {
+	pins map[string]string
+	mu   sync.RWMutex
+}
+
+// NewMemoryPinStore creates an empty in-memory pin store
+func NewMemoryPinStore() *MemoryPinStore {
+	return &MemoryPinStore{pins: make(map[string]string)}
+}
+
+func (s *MemoryPinStore) Get(brokerURL string) (string, bool, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	key, exists := s.pins[brokerURL]
+	return key, exists, nil
+}
+
+func (s *MemoryPinStore) Set(brokerURL, encodedKey string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.pins[brokerURL] = encodedKey
+	return nil
+}
+
+func (s *MemoryPinStore) Delete(brokerURL string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.pins, brokerURL)
+	return nil
+}
+
+// FilePinStore keeps pins in a JSON file mapping broker URLs to keys
+type FilePinStore struct {
+	path string
+	mu   sync.Mutex
+}
+
+// NewFilePinStore creates a pin store backed by the file at path
+func NewFilePinStore(path string) *FilePinStore {
+	return &FilePinStore{path: path}
+}
+
+func (s *FilePinStore) Get(brokerURL string) (string, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	pins, err := s.load()
+	if err != nil {
+		return "", false, err
+	}
+	key, exists := pins[brokerURL]
+	return key, exists, nil
+}
+
+func (s *FilePinStore) Set(brokerURL, encodedKey string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	pins, err := s.load()
+	if err != nil {
+		return err
+	}
+	pins[brokerURL] = encodedKey
+	return s.save(pins)
+}
+
+func (s *FilePinStore) Delete(brokerURL string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	pins, err := s.load()
+	if err != nil {
+		return err
+	}
+	delete(pins, brokerURL)
+	return s.save(pins)
+}
+
+func (s *FilePinStore) load() (map[string]string, error) {
+	pins := make(map[string]string)
+	data, err := os.ReadFile(s.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return pins, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &pins); err != nil {
+		return nil, fmt.Errorf("invalid pin file %s: %w", s.path, err)
+	}
+	return pins, nil
+}
+
+func (s *FilePinStore) save(pins map[string]string) error {
+	data, err := json.MarshalIndent(pins, "", "  ")
+	if err != nil {
+		return err
+	}
+	if dir := filepath.Dir(s.path); dir != "" {
+		if err := os.MkdirAll(dir, 0o700); err != nil {
+			return err
+		}
+	}
+
+	// Write atomically so a crash never leaves a truncated pin file
+	tmp := s.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, s.path)
+}
@@ -0,0 +1,96 @@
+package protocol
+
+import (
+	"errors"
+	"net/http"
+	"path/filepath"
+	"testing"
+)
+
+func TestBrokerPinsTrustOnFirstUse(t *testing.T) {
+	pubKey, _, _ := GenerateKeyPair()
+	otherKey, _, _ := GenerateKeyPair()
+	pins := NewBrokerPins(nil, "")
+
+	if err := pins.Check("https://broker", pubKey); err != nil {
+		t.Fatalf("First contact should pin the key: %v", err)
+	}
+	if err := pins.Check("https://broker", pubKey); err != nil {
+		t.Fatalf("Pinned key should verify: %v", err)
+	}
+	if err := pins.Check("https://broker", otherKey); !errors.Is(err, ErrBrokerKeyChanged) {
+		t.Fatalf("Expected ErrBrokerKeyChanged, got %v", err)
+	}
+
+	if err := pins.Repin("https://broker", otherKey); err != nil {
+		t.Fatalf("Repin failed: %v", err)
+	}
+	if err := pins.Check("https://broker", otherKey); err != nil {
+		t.Fatalf("Re-pinned key should verify: %v", err)
+	}
+}
+
+func TestBrokerPinsWarnMode(t *testing.T) {
+	pubKey, _, _ := GenerateKeyPair()
+	otherKey, _, _ := GenerateKeyPair()
+	pins := NewBrokerPins(nil, PinModeWarn)
+
+	pins.Check("https://broker", pubKey)
+	if err := pins.Check("https://broker", otherKey); err != nil {
+		t.Fatalf("Warn mode should not refuse a changed key: %v", err)
+	}
+	// The original pin is kept until an explicit re-pin
+	if pinned, _, _ := pins.Store.Get("https://broker"); pinned != EncodePublicKey(pubKey) {
+		t.Error("Warn mode should not replace the pinned key")
+	}
+}
+
+func TestBrokerResponseSignature(t *testing.T) {
+	pubKey, privKey, _ := GenerateKeyPair()
+	body := []byte(`{"status":"ok"}`)
+
+	header := http.Header{}
+	header.Set(BrokerKeyHeader, EncodePublicKey(pubKey))
+	signature, _ := SignBrokerResponse(privKey, "nonce-1", body)
+	header.Set(BrokerSignatureHeader, signature)
+
+	presented, err := VerifyBrokerResponse(header, "nonce-1", body)
+	if err != nil {
+		t.Fatalf("Valid response failed verification: %v", err)
+	}
+	if !presented.Equal(pubKey) {
+		t.Error("Verified key does not match the signing key")
+	}
+
+	if _, err := VerifyBrokerResponse(header, "nonce-2", body); err == nil {
+		t.Error("Signature replayed against another request should fail")
+	}
+	if _, err := VerifyBrokerResponse(header, "nonce-1", []byte(`{"status":"forged"}`)); err == nil {
+		t.Error("Tampered body should fail verification")
+	}
+	if _, err := VerifyBrokerResponse(http.Header{}, "nonce-1", body); err == nil {
+		t.Error("Unsigned response should fail verification")
+	}
+}
+
+func TestFilePinStorePersists(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "pins", "known_brokers.json")
+	pubKey, _, _ := GenerateKeyPair()
+
+	if err := NewBrokerPins(NewFilePinStore(path), PinModeEnforce).Check("https://broker", pubKey); err != nil {
+		t.Fatalf("Failed to pin: %v", err)
+	}
+
+	reopened := NewFilePinStore(path)
+	pinned, exists, err := reopened.Get("https://broker")
+	if err != nil || !exists || pinned != EncodePublicKey(pubKey) {
+		t.Fatalf("Pin not persisted: %q %v %v", pinned, exists, err)
+	}
+
+	if err := reopened.Delete("https://broker"); err != nil {
+		t.Fatalf("Delete failed: %v", err)
+	}
+	if _, exists, _ := NewFilePinStore(path).Get("https://broker"); exists {
+		t.Error("Pin should be removed")
+	}
+}
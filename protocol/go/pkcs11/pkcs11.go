@@ -0,0 +1,210 @@
+// Package pkcs11 signs FEM envelopes with an Ed25519 key held in a PKCS#11
+// token, such as an HSM, a TPM exposed through a PKCS#11 module, or SoftHSM.
+// The private key never leaves the token; a Signer only asks the token to
+// sign. It needs cgo, so it lives outside the protocol package.
+package pkcs11
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"encoding/asn1"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+
+	"github.com/fep-fem/protocol"
+	"github.com/miekg/pkcs11"
+)
+
+// Ed25519 identifiers from PKCS#11 v3.0, which github.com/miekg/pkcs11 does
+// not define yet
+const (
+	ckkECEdwards = 0x00000040
+	ckmEdDSA     = 0x00001057
+)
+
+// Config locates a key in a PKCS#11 token
+type Config struct {
+	Module     string // Path to the PKCS#11 module, e.g. /usr/lib/softhsm/libsofthsm2.so
+	TokenLabel string // Label of the token holding the key
+	PIN        string // User PIN of the token
+	KeyLabel   string // CKA_LABEL of the key pair
+}
+
+// Signer signs with an Ed25519 private key in a PKCS#11 token. It implements
+// protocol.Signer and crypto.Signer and is safe for concurrent use; calls are
+// serialised over a single session.
+type Signer struct {
+	mu        sync.Mutex
+	ctx       *pkcs11.Ctx
+	session   pkcs11.SessionHandle
+	key       pkcs11.ObjectHandle
+	publicKey ed25519.PublicKey
+}
+
+var _ protocol.Signer = (*Signer)(nil)
+
+// Open loads the PKCS#11 module, logs in to the token labelled
+// cfg.TokenLabel and finds the Ed25519 key pair labelled cfg.KeyLabel
+func Open(cfg Config) (*Signer, error) {
+	if cfg.Module == "" || cfg.TokenLabel == "" || cfg.KeyLabel == "" {
+		return nil, fmt.Errorf("pkcs11: module, token label and key label are required")
+	}
+	ctx := pkcs11.New(cfg.Module)
+	if ctx == nil {
+		return nil, fmt.Errorf("pkcs11: failed to load module %s", cfg.Module)
+	}
+	if err := ctx.Initialize(); err != nil {
+		ctx.Destroy()
+		return nil, fmt.Errorf("pkcs11: failed to initialize %s: %w", cfg.Module, err)
+	}
+
+	signer := &Signer{ctx: ctx}
+	if err := signer.open(cfg); err != nil {
+		signer.Close()
+		return nil, err
+	}
+	return signer, nil
+}
+
+func (s *Signer) open(cfg Config) error {
+	slot, err := findSlot(s.ctx, cfg.TokenLabel)
+	if err != nil {
+		return err
+	}
+	s.session, err = s.ctx.OpenSession(slot, pkcs11.CKF_SERIAL_SESSION)
+	if err != nil {
+		return fmt.Errorf("pkcs11: failed to open session: %w", err)
+	}
+	if err := s.ctx.Login(s.session, pkcs11.CKU_USER, cfg.PIN); err != nil && !errors.Is(err, pkcs11.Error(pkcs11.CKR_USER_ALREADY_LOGGED_IN)) {
+		return fmt.Errorf("pkcs11: failed to log in to token %q: %w", cfg.TokenLabel, err)
+	}
+
+	s.key, err = findObject(s.ctx, s.session, pkcs11.CKO_PRIVATE_KEY, cfg.KeyLabel)
+	if err != nil {
+		return err
+	}
+	public, err := findObject(s.ctx, s.session, pkcs11.CKO_PUBLIC_KEY, cfg.KeyLabel)
+	if err != nil {
+		return err
+	}
+	attributes, err := s.ctx.GetAttributeValue(s.session, public, []*pkcs11.Attribute{pkcs11.NewAttribute(pkcs11.CKA_EC_POINT, nil)})
+	if err != nil || len(attributes) != 1 {
+		return fmt.Errorf("pkcs11: failed to read public key %q: %w", cfg.KeyLabel, err)
+	}
+	s.publicKey, err = parseECPoint(attributes[0].Value)
+	if err != nil {
+		return fmt.Errorf("pkcs11: public key %q: %w", cfg.KeyLabel, err)
+	}
+	return nil
+}
+
+// findSlot returns the slot holding the token labelled label
+func findSlot(ctx *pkcs11.Ctx, label string) (uint, error) {
+	slots, err := ctx.GetSlotList(true)
+	if err != nil {
+		return 0, fmt.Errorf("pkcs11: failed to list slots: %w", err)
+	}
+	for _, slot := range slots {
+		info, err := ctx.GetTokenInfo(slot)
+		if err != nil {
+			continue
+		}
+		if info.Label == label {
+			return slot, nil
+		}
+	}
+	return 0, fmt.Errorf("pkcs11: no token labelled %q", label)
+}
+
+// findObject returns the single Ed25519 key of class labelled label
+func findObject(ctx *pkcs11.Ctx, session pkcs11.SessionHandle, class uint, label string) (pkcs11.ObjectHandle, error) {
+	template := []*pkcs11.Attribute{
+		pkcs11.NewAttribute(pkcs11.CKA_CLASS, class),
+		pkcs11.NewAttribute(pkcs11.CKA_KEY_TYPE, ckkECEdwards),
+		pkcs11.NewAttribute(pkcs11.CKA_LABEL, label),
+	}
+	if err := ctx.FindObjectsInit(session, template); err != nil {
+		return 0, fmt.Errorf("pkcs11: failed to search for key %q: %w", label, err)
+	}
+	objects, _, err := ctx.FindObjects(session, 2)
+	ctx.FindObjectsFinal(session)
+	if err != nil {
+		return 0, fmt.Errorf("pkcs11: failed to search for key %q: %w", label, err)
+	}
+	kind := "private"
+	if class == pkcs11.CKO_PUBLIC_KEY {
+		kind = "public"
+	}
+	switch len(objects) {
+	case 0:
+		return 0, fmt.Errorf("pkcs11: no Ed25519 %s key labelled %q", kind, label)
+	case 1:
+		return objects[0], nil
+	default:
+		return 0, fmt.Errorf("pkcs11: more than one Ed25519 %s key labelled %q", kind, label)
+	}
+}
+
+// parseECPoint decodes CKA_EC_POINT of an Ed25519 public key. PKCS#11 v3.0
+// wraps the 32 byte key in a DER OCTET STRING; some modules return it bare.
+func parseECPoint(point []byte) (ed25519.PublicKey, error) {
+	if len(point) == ed25519.PublicKeySize {
+		return ed25519.PublicKey(point), nil
+	}
+	var raw []byte
+	rest, err := asn1.Unmarshal(point, &raw)
+	if err != nil || len(rest) != 0 {
+		return nil, fmt.Errorf("invalid EC point")
+	}
+	if len(raw) != ed25519.PublicKeySize {
+		return nil, fmt.Errorf("invalid Ed25519 public key size: got %d, want %d", len(raw), ed25519.PublicKeySize)
+	}
+	return ed25519.PublicKey(raw), nil
+}
+
+// Public returns the Ed25519 public key of the token's key pair
+func (s *Signer) Public() crypto.PublicKey {
+	return s.publicKey
+}
+
+// Sign signs message with pure Ed25519 in the token. As with
+// ed25519.PrivateKey, opts must not request a pre-hashed message and rand is
+// ignored.
+func (s *Signer) Sign(rand io.Reader, message []byte, opts crypto.SignerOpts) ([]byte, error) {
+	if opts != nil && opts.HashFunc() != crypto.Hash(0) {
+		return nil, fmt.Errorf("pkcs11: Ed25519 cannot sign a pre-hashed message")
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.ctx == nil {
+		return nil, fmt.Errorf("pkcs11: signer is closed")
+	}
+	if err := s.ctx.SignInit(s.session, []*pkcs11.Mechanism{pkcs11.NewMechanism(ckmEdDSA, nil)}, s.key); err != nil {
+		return nil, fmt.Errorf("pkcs11: failed to start signing: %w", err)
+	}
+	signature, err := s.ctx.Sign(s.session, message)
+	if err != nil {
+		return nil, fmt.Errorf("pkcs11: failed to sign: %w", err)
+	}
+	return signature, nil
+}
+
+// Close logs out of the token and unloads the module
+func (s *Signer) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.ctx == nil {
+		return nil
+	}
+	if s.session != 0 {
+		s.ctx.Logout(s.session)
+		s.ctx.CloseSession(s.session)
+	}
+	err := s.ctx.Finalize()
+	s.ctx.Destroy()
+	s.ctx = nil
+	return err
+}
@@ -0,0 +1,39 @@
+package pkcs11
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestParseECPoint(t *testing.T) {
+	key := bytes.Repeat([]byte{7}, 32)
+
+	for name, point := range map[string][]byte{
+		"der": append([]byte{0x04, 0x20}, key...),
+		"raw": key,
+	} {
+		parsed, err := parseECPoint(point)
+		if err != nil || !bytes.Equal(parsed, key) {
+			t.Errorf("%s: expected the key, got %x %v", name, parsed, err)
+		}
+	}
+
+	for name, point := range map[string][]byte{
+		"short":    append([]byte{0x04, 0x1f}, key[:31]...),
+		"trailing": append(append([]byte{0x04, 0x20}, key...), 0),
+		"not der":  {0x30, 0x01},
+	} {
+		if _, err := parseECPoint(point); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
+
+func TestOpenRequiresConfig(t *testing.T) {
+	if _, err := Open(Config{Module: "/nonexistent/libpkcs11.so", TokenLabel: "fem"}); err == nil {
+		t.Error("Expected an error without a key label")
+	}
+	if _, err := Open(Config{Module: "/nonexistent/libpkcs11.so", TokenLabel: "fem", KeyLabel: "broker"}); err == nil {
+		t.Error("Expected an error for a missing module")
+	}
+}
@@ -0,0 +1,315 @@
+package protocol
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ProxyDirect is the proxy setting that connects to brokers directly,
+// ignoring the environment
+const ProxyDirect = "direct"
+
+// ProxyFunc picks the proxy for a request, as http.Transport.Proxy does. A
+// nil URL connects directly.
+type ProxyFunc func(*http.Request) (*url.URL, error)
+
+// ParseProxy returns the proxy selector for a setting. An empty setting
+// honors HTTPS_PROXY, HTTP_PROXY and NO_PROXY; ProxyDirect connects directly;
+// anything else is the URL of an HTTP CONNECT (http, https) or SOCKS5
+// (socks5, socks5h) proxy, with optional user:password credentials.
+func ParseProxy(setting string) (ProxyFunc, error) {
+	switch setting {
+	case "":
+		return http.ProxyFromEnvironment, nil
+	case ProxyDirect:
+		return func(*http.Request) (*url.URL, error) { return nil, nil }, nil
+	}
+	proxyURL, err := url.Parse(setting)
+	if err != nil {
+		return nil, fmt.Errorf("invalid proxy %q: %w", setting, err)
+	}
+	if _, err := proxyPort(proxyURL); err != nil {
+		return nil, err
+	}
+	if proxyURL.Hostname() == "" {
+		return nil, fmt.Errorf("invalid proxy %q: no host", setting)
+	}
+	return http.ProxyURL(proxyURL), nil
+}
+
+// proxyPort returns the proxy's port, defaulting by scheme
+func proxyPort(proxyURL *url.URL) (string, error) {
+	if port := proxyURL.Port(); port != "" {
+		return port, nil
+	}
+	switch proxyURL.Scheme {
+	case "http":
+		return "80", nil
+	case "https":
+		return "443", nil
+	case "socks5", "socks5h":
+		return "1080", nil
+	}
+	return "", fmt.Errorf("unsupported proxy scheme %q: use http, https, socks5 or socks5h", proxyURL.Scheme)
+}
+
+// ProxyForEndpoint returns the proxy proxy picks for a stream connection to
+// a host:port endpoint, treating it like an https URL, or nil to connect
+// directly. A nil proxy honors the environment.
+func ProxyForEndpoint(proxy ProxyFunc, endpoint string) (*url.URL, error) {
+	if proxy == nil {
+		proxy = http.ProxyFromEnvironment
+	}
+	return proxy(&http.Request{URL: &url.URL{Scheme: "https", Host: endpoint}})
+}
+
+// DialProxy connects to addr through a proxy: HTTP proxies are asked to
+// CONNECT, SOCKS5 proxies to open a stream. Host names are resolved by the
+// proxy. A nil proxyURL dials addr directly.
+func DialProxy(ctx context.Context, dialer *net.Dialer, proxyURL *url.URL, addr string) (net.Conn, error) {
+	if dialer == nil {
+		dialer = &net.Dialer{}
+	}
+	if proxyURL == nil {
+		return dialer.DialContext(ctx, "tcp", addr)
+	}
+	port, err := proxyPort(proxyURL)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := dialer.DialContext(ctx, "tcp", net.JoinHostPort(proxyURL.Hostname(), port))
+	if err != nil {
+		return nil, fmt.Errorf("proxy %s: %w", proxyURL.Redacted(), err)
+	}
+
+	// The handshake with the proxy is bounded by the context's deadline
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+	if proxyURL.Scheme == "https" {
+		tlsConn := tls.Client(conn, &tls.Config{ServerName: proxyURL.Hostname()})
+		if err = tlsConn.HandshakeContext(ctx); err == nil {
+			conn = tlsConn
+		}
+	}
+	if err == nil {
+		if strings.HasPrefix(proxyURL.Scheme, "socks5") {
+			err = socks5Connect(conn, proxyURL.User, addr)
+		} else {
+			err = httpConnect(conn, proxyURL.User, addr)
+		}
+	}
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("proxy %s: %w", proxyURL.Redacted(), err)
+	}
+	conn.SetDeadline(time.Time{})
+	return conn, nil
+}
+
+// dialStream opens a TLS stream connection to endpoint, through the proxy
+// proxy picks for it. A zero timeout is no limit.
+func dialStream(proxy ProxyFunc, endpoint string, timeout time.Duration, config *tls.Config) (*tls.Conn, error) {
+	ctx := context.Background()
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+	proxyURL, err := ProxyForEndpoint(proxy, endpoint)
+	if err != nil {
+		return nil, err
+	}
+	raw, err := DialProxy(ctx, &net.Dialer{}, proxyURL, endpoint)
+	if err != nil {
+		return nil, err
+	}
+	if config.ServerName == "" {
+		config = config.Clone()
+		config.ServerName, _, _ = net.SplitHostPort(endpoint)
+	}
+	conn := tls.Client(raw, config)
+	if err := conn.HandshakeContext(ctx); err != nil {
+		raw.Close()
+		return nil, err
+	}
+	return conn, nil
+}
+
+// httpConnect asks an HTTP proxy to tunnel to addr
+func httpConnect(conn net.Conn, user *url.Userinfo, addr string) error {
+	request := &http.Request{
+		Method: http.MethodConnect,
+		URL:    &url.URL{Opaque: addr},
+		Host:   addr,
+		Header: make(http.Header),
+	}
+	if user != nil {
+		password, _ := user.Password()
+		credentials := base64.StdEncoding.EncodeToString([]byte(user.Username() + ":" + password))
+		request.Header.Set("Proxy-Authorization", "Basic "+credentials)
+	}
+	if err := request.Write(conn); err != nil {
+		return err
+	}
+
+	reader := bufio.NewReader(conn)
+	response, err := http.ReadResponse(reader, request)
+	if err != nil {
+		return err
+	}
+	response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		return fmt.Errorf("CONNECT %s refused: %s", addr, response.Status)
+	}
+	// The broker speaks first only after the TLS handshake, so nothing may follow the response yet
+	if reader.Buffered() > 0 {
+		return errors.New("proxy sent data before the tunnel opened")
+	}
+	return nil
+}
+
+// SOCKS5 protocol values, see RFC 1928 and RFC 1929
+const (
+	socks5Version      = 5
+	socks5NoAuth       = 0
+	socks5PasswordAuth = 2
+	socks5NoMethod     = 0xff
+	socks5CmdConnect   = 1
+	socks5IPv4         = 1
+	socks5Domain       = 3
+	socks5IPv6         = 4
+)
+
+// socks5Replies explains the failure replies a SOCKS5 proxy can send
+var socks5Replies = map[byte]string{
+	1: "general failure",
+	2: "connection not allowed by ruleset",
+	3: "network unreachable",
+	4: "host unreachable",
+	5: "connection refused",
+	6: "TTL expired",
+	7: "command not supported",
+	8: "address type not supported",
+}
+
+// socks5Connect asks a SOCKS5 proxy to open a stream to addr
+func socks5Connect(conn net.Conn, user *url.Userinfo, addr string) error {
+	host, portText, err := net.SplitHostPort(addr)
+	if err != nil {
+		return err
+	}
+	port, err := strconv.ParseUint(portText, 10, 16)
+	if err != nil {
+		return fmt.Errorf("invalid port in %q", addr)
+	}
+
+	methods := []byte{socks5NoAuth}
+	if user != nil {
+		methods = []byte{socks5NoAuth, socks5PasswordAuth}
+	}
+	if _, err := conn.Write(append([]byte{socks5Version, byte(len(methods))}, methods...)); err != nil {
+		return err
+	}
+	reply := make([]byte, 2)
+	if _, err := io.ReadFull(conn, reply); err != nil {
+		return err
+	}
+	if reply[0] != socks5Version {
+		return fmt.Errorf("not a SOCKS5 proxy (version %d)", reply[0])
+	}
+	switch reply[1] {
+	case socks5NoAuth:
+	case socks5PasswordAuth:
+		if user == nil {
+			return errors.New("SOCKS5 proxy requires a user name and password")
+		}
+		if err := socks5Authenticate(conn, user); err != nil {
+			return err
+		}
+	case socks5NoMethod:
+		return errors.New("SOCKS5 proxy accepted no authentication method offered")
+	default:
+		return fmt.Errorf("SOCKS5 proxy chose unsupported authentication method %d", reply[1])
+	}
+
+	request := []byte{socks5Version, socks5CmdConnect, 0}
+	if ip := net.ParseIP(host); ip != nil && ip.To4() != nil {
+		request = append(append(request, socks5IPv4), ip.To4()...)
+	} else if ip != nil {
+		request = append(append(request, socks5IPv6), ip.To16()...)
+	} else {
+		if len(host) > 255 {
+			return fmt.Errorf("host name %q is too long for SOCKS5", host)
+		}
+		request = append(append(request, socks5Domain, byte(len(host))), host...)
+	}
+	request = binary.BigEndian.AppendUint16(request, uint16(port))
+	if _, err := conn.Write(request); err != nil {
+		return err
+	}
+
+	header := make([]byte, 4)
+	if _, err := io.ReadFull(conn, header); err != nil {
+		return err
+	}
+	if header[1] != 0 {
+		reason, known := socks5Replies[header[1]]
+		if !known {
+			reason = fmt.Sprintf("reply %d", header[1])
+		}
+		return fmt.Errorf("SOCKS5 connect to %s failed: %s", addr, reason)
+	}
+	// Skip the address the proxy bound, then its port
+	var boundLength int
+	switch header[3] {
+	case socks5IPv4:
+		boundLength = net.IPv4len
+	case socks5IPv6:
+		boundLength = net.IPv6len
+	case socks5Domain:
+		length := make([]byte, 1)
+		if _, err := io.ReadFull(conn, length); err != nil {
+			return err
+		}
+		boundLength = int(length[0])
+	default:
+		return fmt.Errorf("SOCKS5 proxy replied with address type %d", header[3])
+	}
+	_, err = io.ReadFull(conn, make([]byte, boundLength+2))
+	return err
+}
+
+// socks5Authenticate sends a user name and password to a SOCKS5 proxy
+func socks5Authenticate(conn net.Conn, user *url.Userinfo) error {
+	username := user.Username()
+	password, _ := user.Password()
+	if len(username) > 255 || len(password) > 255 {
+		return errors.New("SOCKS5 credentials are limited to 255 bytes each")
+	}
+	request := append([]byte{1, byte(len(username))}, username...)
+	request = append(append(request, byte(len(password))), password...)
+	if _, err := conn.Write(request); err != nil {
+		return err
+	}
+	reply := make([]byte, 2)
+	if _, err := io.ReadFull(conn, reply); err != nil {
+		return err
+	}
+	if reply[1] != 0 {
+		return errors.New("SOCKS5 proxy rejected the credentials")
+	}
+	return nil
+}
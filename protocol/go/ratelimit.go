@@ -0,0 +1,198 @@
+package protocol
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Rate limit scopes named in throttle errors
+const (
+	RateLimitScopeAgent = "agent"
+	RateLimitScopeIP    = "ip"
+)
+
+// rateLimitPruneInterval is how often buckets that have refilled are forgotten
+const rateLimitPruneInterval = time.Minute
+
+// RateLimit is a token bucket: Rate envelopes per second on average, in
+// bursts of up to Burst. A zero Rate means unlimited.
+type RateLimit struct {
+	Rate  float64 `json:"rate"`
+	Burst int     `json:"burst"`
+}
+
+// ParseRateLimit parses "rate/burst", e.g. "10/50", or a bare rate, whose
+// burst is the rate rounded up
+func ParseRateLimit(s string) (RateLimit, error) {
+	rateText, burstText, hasBurst := strings.Cut(strings.TrimSpace(s), "/")
+	rate, err := strconv.ParseFloat(rateText, 64)
+	if err != nil || rate < 0 || math.IsInf(rate, 0) {
+		return RateLimit{}, fmt.Errorf("invalid rate limit %q: rate must be a non-negative number", s)
+	}
+	limit := RateLimit{Rate: rate, Burst: int(math.Ceil(rate))}
+	if hasBurst {
+		if limit.Burst, err = strconv.Atoi(burstText); err != nil || limit.Burst < 1 {
+			return RateLimit{}, fmt.Errorf("invalid rate limit %q: burst must be a positive integer", s)
+		}
+	}
+	return limit, nil
+}
+
+// RateLimitConfig sets the limits a RateLimiter applies to each agent ID and
+// each source IP. Overrides replace the default for one agent or address.
+type RateLimitConfig struct {
+	PerAgent RateLimit            `json:"perAgent"`
+	PerIP    RateLimit            `json:"perIp"`
+	Agents   map[string]RateLimit `json:"agents,omitempty"`
+	IPs      map[string]RateLimit `json:"ips,omitempty"`
+}
+
+// ThrottleError reports an envelope refused by a rate limit
+type ThrottleError struct {
+	Scope      string        // RateLimitScopeAgent or RateLimitScopeIP
+	Key        string        // The agent ID or address that ran out
+	RetryAfter time.Duration // Until a token is available again
+}
+
+func (e *ThrottleError) Error() string {
+	return fmt.Sprintf("rate limit for %s %s exceeded, retry in %v", e.Scope, e.Key, e.RetryAfter.Round(time.Millisecond))
+}
+
+// ErrorBody converts the throttle into the rate_limited error sent to the sender
+func (e *ThrottleError) ErrorBody() *ErrorBody {
+	return &ErrorBody{
+		Code:    CodeRateLimited,
+		Message: e.Error(),
+		Details: map[string]interface{}{
+			"scope":        e.Scope,
+			"retryAfterMs": e.RetryAfter.Milliseconds(),
+		},
+	}
+}
+
+// RetryAfter returns how long a rate_limited sender should wait before
+// sending again, as given in the error's details, or zero if not given
+func (b *ErrorBody) RetryAfter() time.Duration {
+	switch ms := b.Details["retryAfterMs"].(type) {
+	case float64: // Decoded from JSON
+		return time.Duration(ms) * time.Millisecond
+	case int64:
+		return time.Duration(ms) * time.Millisecond
+	}
+	return 0
+}
+
+// RateLimiter keeps a token bucket per agent ID and per source IP
+type RateLimiter struct {
+	config    RateLimitConfig
+	agents    map[string]*tokenBucket
+	ips       map[string]*tokenBucket
+	lastPrune time.Time
+	mu        sync.Mutex
+}
+
+// tokenBucket holds the tokens left for one key as of updated
+type tokenBucket struct {
+	limit   RateLimit
+	tokens  float64
+	updated time.Time
+}
+
+// NewRateLimiter creates a limiter applying config
+func NewRateLimiter(config RateLimitConfig) *RateLimiter {
+	return &RateLimiter{
+		config:    config,
+		agents:    make(map[string]*tokenBucket),
+		ips:       make(map[string]*tokenBucket),
+		lastPrune: time.Now(),
+	}
+}
+
+// Config returns the limits the limiter applies
+func (l *RateLimiter) Config() RateLimitConfig {
+	return l.config
+}
+
+// Allow takes a token for agentID and one for ip; empty keys are not
+// limited. If either bucket is empty, no token is taken and a *ThrottleError
+// says when to retry.
+func (l *RateLimiter) Allow(agentID, ip string) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	if now.Sub(l.lastPrune) > rateLimitPruneInterval {
+		pruneBuckets(l.agents, now)
+		pruneBuckets(l.ips, now)
+		l.lastPrune = now
+	}
+
+	ipBucket := l.bucket(l.ips, ip, l.config.PerIP, l.config.IPs, now)
+	agentBucket := l.bucket(l.agents, agentID, l.config.PerAgent, l.config.Agents, now)
+	if wait := ipBucket.wait(); wait > 0 {
+		return &ThrottleError{Scope: RateLimitScopeIP, Key: ip, RetryAfter: wait}
+	}
+	if wait := agentBucket.wait(); wait > 0 {
+		return &ThrottleError{Scope: RateLimitScopeAgent, Key: agentID, RetryAfter: wait}
+	}
+	ipBucket.take()
+	agentBucket.take()
+	return nil
+}
+
+// bucket returns the refilled bucket for key, or nil if key is not limited
+func (l *RateLimiter) bucket(buckets map[string]*tokenBucket, key string, limit RateLimit, overrides map[string]RateLimit, now time.Time) *tokenBucket {
+	if key == "" {
+		return nil
+	}
+	if override, exists := overrides[key]; exists {
+		limit = override
+	}
+	if limit.Rate <= 0 {
+		return nil
+	}
+
+	b, exists := buckets[key]
+	if !exists {
+		b = &tokenBucket{limit: limit, tokens: float64(max(limit.Burst, 1)), updated: now}
+		buckets[key] = b
+	}
+	b.refill(now)
+	return b
+}
+
+// refill adds the tokens earned since the last update, up to the burst
+func (b *tokenBucket) refill(now time.Time) {
+	burst := float64(max(b.limit.Burst, 1))
+	b.tokens = math.Min(burst, b.tokens+now.Sub(b.updated).Seconds()*b.limit.Rate)
+	b.updated = now
+}
+
+// wait returns how long until the bucket has a token, zero if it has one now
+func (b *tokenBucket) wait() time.Duration {
+	if b == nil || b.tokens >= 1 {
+		return 0
+	}
+	return time.Duration((1 - b.tokens) / b.limit.Rate * float64(time.Second))
+}
+
+// take spends a token
+func (b *tokenBucket) take() {
+	if b != nil {
+		b.tokens--
+	}
+}
+
+// pruneBuckets forgets buckets that would be full by now, which behave like new ones
+func pruneBuckets(buckets map[string]*tokenBucket, now time.Time) {
+	for key, b := range buckets {
+		b.refill(now)
+		if b.tokens >= float64(max(b.limit.Burst, 1)) {
+			delete(buckets, key)
+		}
+	}
+}
@@ -0,0 +1,124 @@
+package protocol
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestParseRateLimit(t *testing.T) {
+	for input, want := range map[string]RateLimit{
+		"10/50": {Rate: 10, Burst: 50},
+		"2.5":   {Rate: 2.5, Burst: 3},
+		"0":     {},
+	} {
+		if got, err := ParseRateLimit(input); err != nil || got != want {
+			t.Errorf("ParseRateLimit(%q) = %+v, %v, want %+v", input, got, err, want)
+		}
+	}
+	for _, input := range []string{"", "fast", "-1", "10/0", "10/x"} {
+		if _, err := ParseRateLimit(input); err == nil {
+			t.Errorf("ParseRateLimit(%q) should fail", input)
+		}
+	}
+}
+
+func TestRateLimiterBuckets(t *testing.T) {
+	limiter := NewRateLimiter(RateLimitConfig{
+		PerAgent: RateLimit{Rate: 1, Burst: 2},
+		PerIP:    RateLimit{Rate: 1, Burst: 3},
+		Agents:   map[string]RateLimit{"trusted": {}},
+	})
+
+	for i := 0; i < 2; i++ {
+		if err := limiter.Allow("worker", "192.0.2.1"); err != nil {
+			t.Fatalf("Envelope %d within the burst was throttled: %v", i, err)
+		}
+	}
+	var throttle *ThrottleError
+	if err := limiter.Allow("worker", "192.0.2.1"); !errors.As(err, &throttle) || throttle.Scope != RateLimitScopeAgent {
+		t.Fatalf("Expected the agent to be throttled, got %v", err)
+	}
+	if throttle.RetryAfter <= 0 || throttle.RetryAfter > time.Second {
+		t.Errorf("Retry should come within a second, got %v", throttle.RetryAfter)
+	}
+
+	// The refused envelope took no token from the address
+	if err := limiter.Allow("other", "192.0.2.1"); err != nil {
+		t.Fatalf("Address should have a token left, got %v", err)
+	}
+	if err := limiter.Allow("trusted", "192.0.2.1"); !errors.As(err, &throttle) || throttle.Scope != RateLimitScopeIP {
+		t.Errorf("Expected the address to be throttled, got %v", err)
+	}
+	if err := limiter.Allow("trusted", "192.0.2.2"); err != nil {
+		t.Errorf("Agents with an unlimited override should pass, got %v", err)
+	}
+}
+
+func TestThrottleErrorRetryAfter(t *testing.T) {
+	throttle := &ThrottleError{Scope: RateLimitScopeAgent, Key: "worker", RetryAfter: 1500 * time.Millisecond}
+	errBody := throttle.ErrorBody()
+	if errBody.Code != CodeRateLimited || errBody.RetryAfter() != 1500*time.Millisecond {
+		t.Errorf("Unexpected error body %+v", errBody)
+	}
+
+	data, _ := json.Marshal(errBody)
+	var decoded ErrorBody
+	if err := json.Unmarshal(data, &decoded); err != nil || decoded.RetryAfter() != 1500*time.Millisecond {
+		t.Errorf("Retry-after should survive encoding, got %v, %v", decoded.RetryAfter(), err)
+	}
+}
+
+func TestTransportThrottlesAgents(t *testing.T) {
+	server, _ := NewTransport(nil)
+	server.SetRateLimit(RateLimitConfig{PerAgent: RateLimit{Rate: 0.01, Burst: 1}})
+	handled := make(chan struct{}, 2)
+	server.RegisterHandler(EnvelopeHeartbeat, func(*Envelope, net.Conn) error {
+		handled <- struct{}{}
+		return nil
+	})
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go server.Serve(ctx, listener)
+
+	conn, err := tls.Dial("tcp", listener.Addr().String(), &tls.Config{InsecureSkipVerify: true})
+	if err != nil {
+		t.Fatalf("Failed to dial: %v", err)
+	}
+	defer conn.Close()
+	for i := 0; i < 2; i++ {
+		envelope := &Envelope{Type: EnvelopeHeartbeat, CommonHeaders: newHeaders("worker"), Body: []byte(`{"load":0,"inFlight":0,"uptime":0}`)}
+		data, _ := json.Marshal(envelope)
+		conn.Write(append(data, '\n'))
+	}
+
+	select {
+	case <-handled:
+	case <-time.After(5 * time.Second):
+		t.Fatal("First heartbeat was not handled")
+	}
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	line, err := bufio.NewReader(conn).ReadBytes('\n')
+	if err != nil {
+		t.Fatalf("Expected a throttle error, got %v", err)
+	}
+	_, err = ParseResponse(line)
+	var errBody *ErrorBody
+	if !errors.As(err, &errBody) || errBody.Code != CodeRateLimited || errBody.RetryAfter() <= 0 {
+		t.Fatalf("Expected rate_limited with a retry-after, got %v", err)
+	}
+	select {
+	case <-handled:
+		t.Error("Throttled heartbeat reached its handler")
+	default:
+	}
+}
@@ -0,0 +1,278 @@
+package protocol
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"math/rand"
+	"net"
+	"time"
+)
+
+// ErrNotConnected is returned by Client calls made while it has no connection
+var ErrNotConnected = errors.New("not connected")
+
+// ConnectionState is where a Client is in its connection lifecycle
+type ConnectionState string
+
+const (
+	StateDisconnected ConnectionState = "disconnected" // Not connected and not trying to be
+	StateConnecting   ConnectionState = "connecting"   // First connection attempt
+	StateConnected    ConnectionState = "connected"
+	StateReconnecting ConnectionState = "reconnecting" // Connection lost; retrying with backoff
+	StateClosed       ConnectionState = "closed"       // Close was called
+)
+
+// ReconnectPolicy controls how a Client retries after losing its connection.
+// Attempt n waits InitialBackoff * Multiplier^(n-1), capped at MaxBackoff and
+// spread by up to Jitter of itself either way.
+type ReconnectPolicy struct {
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+	Multiplier     float64
+	Jitter         float64 // Fraction of the backoff to randomize, 0 to 1
+	MaxAttempts    int     // Attempts before giving up; 0 retries forever
+	Disabled       bool    // Do not reconnect at all
+}
+
+// DefaultReconnectPolicy returns the policy a new Client uses
+func DefaultReconnectPolicy() ReconnectPolicy {
+	return ReconnectPolicy{
+		InitialBackoff: 500 * time.Millisecond,
+		MaxBackoff:     30 * time.Second,
+		Multiplier:     2,
+		Jitter:         0.2,
+	}
+}
+
+// Backoff returns how long to wait before reconnect attempt n, counting from 1
+func (p ReconnectPolicy) Backoff(attempt int) time.Duration {
+	multiplier := p.Multiplier
+	if multiplier < 1 {
+		multiplier = 1
+	}
+	backoff := float64(p.InitialBackoff) * math.Pow(multiplier, float64(attempt-1))
+	if p.MaxBackoff > 0 && backoff > float64(p.MaxBackoff) {
+		backoff = float64(p.MaxBackoff)
+	}
+	if p.Jitter > 0 {
+		backoff *= 1 - p.Jitter + 2*p.Jitter*rand.Float64()
+	}
+	return time.Duration(backoff)
+}
+
+// SetReconnectPolicy changes how the client retries after losing its connection
+func (c *Client) SetReconnectPolicy(policy ReconnectPolicy) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.policy = policy
+}
+
+// OnStateChange sets a callback run on every connection state change, with
+// the error that caused it, if any. It must not block.
+func (c *Client) OnStateChange(callback func(state ConnectionState, err error)) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.onState = callback
+}
+
+// SetProxy picks the proxy the client connects through; nil honors
+// HTTPS_PROXY and NO_PROXY, see ParseProxy
+func (c *Client) SetProxy(proxy ProxyFunc) {
+	c.transport.SetProxy(proxy)
+}
+
+// SetRegistration sets an envelope, usually registerAgent, that the client
+// sends first on every connection, so a restarted server learns about the
+// client again. It is sent with a fresh timestamp and nonce each time.
+func (c *Client) SetRegistration(envelope *Envelope) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.registration = envelope
+}
+
+// State returns the client's connection state
+func (c *Client) State() ConnectionState {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.state
+}
+
+// WaitConnected waits until the client is connected. It fails when ctx is
+// done, the client is closed, or it gave up reconnecting.
+func (c *Client) WaitConnected(ctx context.Context) error {
+	for {
+		c.mu.Lock()
+		ready, state := c.ready, c.state
+		c.mu.Unlock()
+
+		switch state {
+		case StateConnected:
+			return nil
+		case StateClosed, StateDisconnected:
+			return ErrNotConnected
+		}
+		select {
+		case <-ready:
+		case <-c.done:
+			return ErrNotConnected
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// current returns the connection, its reader and framing, or nil while disconnected
+func (c *Client) current() (net.Conn, *bufio.Reader, Framing) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.conn, c.reader, c.framing
+}
+
+// dial connects to the server and sends the registration envelope
+func (c *Client) dial() error {
+	conn, err := dialStream(c.transport.dialProxy(), c.endpoint, 0, &tls.Config{
+		InsecureSkipVerify: true,
+		MinVersion:         tls.VersionTLS13,
+		NextProtos:         StreamProtocols(),
+	})
+	if err != nil {
+		return err
+	}
+	framing := negotiatedFraming(conn.ConnectionState())
+
+	c.mu.Lock()
+	registration := c.registration
+	c.mu.Unlock()
+	if registration != nil {
+		envelope := *registration
+		envelope.TS = time.Now().UnixMilli()
+		envelope.Nonce = NewRandomID()
+		if err := c.writeRegistration(conn, framing, &envelope); err != nil {
+			conn.Close()
+			return fmt.Errorf("failed to register: %w", err)
+		}
+	}
+
+	c.mu.Lock()
+	if c.closed {
+		c.mu.Unlock()
+		conn.Close()
+		return ErrNotConnected
+	}
+	if c.conn != nil {
+		// Connect and reconnecting raced; keep the connection already in use
+		c.mu.Unlock()
+		conn.Close()
+		return nil
+	}
+	c.conn = conn
+	c.reader = bufio.NewReader(conn)
+	c.framing = framing
+	c.state = StateConnected
+	callback := c.onState
+	close(c.ready)
+	c.mu.Unlock()
+
+	if callback != nil {
+		callback(StateConnected, nil)
+	}
+	return nil
+}
+
+// writeRegistration signs and sends the registration on a new connection
+func (c *Client) writeRegistration(conn net.Conn, framing Framing, envelope *Envelope) error {
+	if err := envelope.Sign(c.transport.privateKey); err != nil {
+		return err
+	}
+	data, err := json.Marshal(envelope)
+	if err != nil {
+		return err
+	}
+	if data, err = framing.encode(data); err != nil {
+		return err
+	}
+	_, err = conn.Write(data)
+	return err
+}
+
+// connectionLost drops a failed connection and starts reconnecting, unless
+// the connection was already replaced or the client is closed
+func (c *Client) connectionLost(conn net.Conn, cause error) {
+	c.mu.Lock()
+	if c.conn != conn || c.closed {
+		c.mu.Unlock()
+		return
+	}
+	conn.Close()
+	c.conn = nil
+	c.reader = nil
+	c.ready = make(chan struct{})
+	if c.policy.Disabled {
+		c.mu.Unlock()
+		c.setState(StateDisconnected, cause)
+		return
+	}
+	start := !c.reconnecting
+	c.reconnecting = true
+	c.mu.Unlock()
+
+	c.setState(StateReconnecting, cause)
+	if start {
+		go c.reconnect()
+	}
+}
+
+// reconnect retries connecting with backoff until it succeeds, the client is
+// closed, or the policy's attempts run out
+func (c *Client) reconnect() {
+	defer func() {
+		c.mu.Lock()
+		c.reconnecting = false
+		c.mu.Unlock()
+	}()
+
+	for attempt := 1; ; attempt++ {
+		c.mu.Lock()
+		policy := c.policy
+		c.mu.Unlock()
+
+		timer := time.NewTimer(policy.Backoff(attempt))
+		select {
+		case <-timer.C:
+		case <-c.done:
+			timer.Stop()
+			return
+		}
+
+		err := c.dial()
+		if err == nil || errors.Is(err, ErrNotConnected) {
+			return
+		}
+		if policy.MaxAttempts > 0 && attempt >= policy.MaxAttempts {
+			c.setState(StateDisconnected, fmt.Errorf("gave up after %d attempts: %w", attempt, err))
+			return
+		}
+		c.setState(StateReconnecting, err)
+	}
+}
+
+// setState records a state change and reports it to the callback
+func (c *Client) setState(state ConnectionState, err error) {
+	c.mu.Lock()
+	if c.state == StateClosed && state != StateClosed {
+		c.mu.Unlock()
+		return
+	}
+	c.state = state
+	callback := c.onState
+	c.mu.Unlock()
+
+	if callback != nil {
+		callback(state, err)
+	}
+}
@@ -0,0 +1,149 @@
+package protocol
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestReconnectBackoff(t *testing.T) {
+	policy := ReconnectPolicy{InitialBackoff: 100 * time.Millisecond, MaxBackoff: time.Second, Multiplier: 2}
+	for attempt, want := range map[int]time.Duration{1: 100 * time.Millisecond, 3: 400 * time.Millisecond, 10: time.Second} {
+		if got := policy.Backoff(attempt); got != want {
+			t.Errorf("Attempt %d: backoff %v, want %v", attempt, got, want)
+		}
+	}
+
+	policy.Jitter = 0.5
+	for i := 0; i < 100; i++ {
+		if got := policy.Backoff(2); got < 100*time.Millisecond || got > 300*time.Millisecond {
+			t.Fatalf("Jittered backoff %v outside 100ms to 300ms", got)
+		}
+	}
+}
+
+func TestClientReconnectsAndRegistersAgain(t *testing.T) {
+	server, _ := NewTransport(nil)
+	server.GenerateSelfSignedCert()
+	listener, err := tls.Listen("tcp", "127.0.0.1:0", server.tlsConfig)
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	defer listener.Close()
+
+	// The server remembers its connections and the nonce of each first envelope
+	var mu sync.Mutex
+	var conns []net.Conn
+	registrations := make(chan string, 10)
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			mu.Lock()
+			conns = append(conns, conn)
+			mu.Unlock()
+			go func() {
+				line, err := bufio.NewReader(conn).ReadBytes('\n')
+				if err != nil {
+					return
+				}
+				var envelope Envelope
+				json.Unmarshal(line, &envelope)
+				registrations <- envelope.Nonce
+			}()
+		}
+	}()
+
+	_, privKey, _ := GenerateKeyPair()
+	client, _ := NewClient(listener.Addr().String(), privKey)
+	defer client.Close()
+	client.SetReconnectPolicy(ReconnectPolicy{InitialBackoff: 10 * time.Millisecond, MaxBackoff: 50 * time.Millisecond, Multiplier: 2})
+	client.SetRegistration(&Envelope{Type: EnvelopeRegisterAgent, CommonHeaders: newHeaders("worker"), Body: []byte(`{"pubkey":"key"}`)})
+	states := make(chan ConnectionState, 10)
+	client.OnStateChange(func(state ConnectionState, err error) { states <- state })
+
+	if err := client.Connect(); err != nil {
+		t.Fatalf("Connect failed: %v", err)
+	}
+	first := <-registrations
+
+	// The server drops the connection, as a restarting broker would
+	mu.Lock()
+	conns[0].Close()
+	mu.Unlock()
+	if _, err := client.ReadEnvelope(); err == nil {
+		t.Fatal("Reading from a closed connection should fail")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := client.WaitConnected(ctx); err != nil {
+		t.Fatalf("Client should reconnect: %v", err)
+	}
+	select {
+	case second := <-registrations:
+		if second == first {
+			t.Error("Registration should be sent again with a fresh nonce")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Registration not sent after reconnecting")
+	}
+
+	for _, want := range []ConnectionState{StateConnecting, StateConnected, StateReconnecting, StateConnected} {
+		select {
+		case state := <-states:
+			if state != want {
+				t.Fatalf("State changed to %s, want %s", state, want)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("No change to state %s", want)
+		}
+	}
+}
+
+func TestClientGivesUpReconnecting(t *testing.T) {
+	server, _ := NewTransport(nil)
+	server.GenerateSelfSignedCert()
+	listener, _ := tls.Listen("tcp", "127.0.0.1:0", server.tlsConfig)
+	accepted := make(chan net.Conn, 1)
+	go func() {
+		conn, err := listener.Accept()
+		if err == nil {
+			conn.(*tls.Conn).Handshake()
+			accepted <- conn
+		}
+	}()
+
+	_, privKey, _ := GenerateKeyPair()
+	client, _ := NewClient(listener.Addr().String(), privKey)
+	defer client.Close()
+	client.SetReconnectPolicy(ReconnectPolicy{InitialBackoff: time.Millisecond, MaxAttempts: 3})
+	if err := client.Connect(); err != nil {
+		t.Fatalf("Connect failed: %v", err)
+	}
+
+	// The server goes away for good
+	listener.Close()
+	(<-accepted).Close()
+	client.ReadEnvelope()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	for client.State() != StateDisconnected {
+		if ctx.Err() != nil {
+			t.Fatalf("Client should give up after 3 attempts, state %s", client.State())
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	if err := client.WaitConnected(ctx); !errors.Is(err, ErrNotConnected) {
+		t.Errorf("Waiting after giving up should fail with ErrNotConnected, got %v", err)
+	}
+}
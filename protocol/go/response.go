@@ -0,0 +1,119 @@
+package protocol
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+)
+
+// ErrorCode is a machine-readable reason an envelope was rejected
+type ErrorCode string
+
+const (
+	CodeInvalidEnvelope    ErrorCode = "invalid_envelope"    // Malformed or undecodable envelope
+	CodeInvalidBody        ErrorCode = "invalid_body"        // Body failed to parse or validate
+	CodeUnsupportedType    ErrorCode = "unsupported_type"    // Envelope type not handled by the receiver
+	CodeUnsupportedVersion ErrorCode = "unsupported_version" // Sender's protocol version is below the receiver's minimum
+	CodeInvalidSignature   ErrorCode = "invalid_signature"   // Signature does not verify
+	CodeClockSkew          ErrorCode = "clock_skew"          // ts is too old or too far in the future
+	CodeUnauthorized       ErrorCode = "unauthorized"        // Sender could not be authenticated
+	CodeUnknownAgent       ErrorCode = "unknown_agent"       // Sender or target is not registered
+	CodeUnknownRequest     ErrorCode = "unknown_request"     // No call of the sender's awaits a result under the request ID
+	CodeCapabilityDenied   ErrorCode = "capability_denied"   // Capability token missing or insufficient
+	CodeForbidden          ErrorCode = "forbidden"           // Refused by policy
+	CodePolicyViolation    ErrorCode = "policy_violation"    // Would break a data handling constraint such as residency; details cite it
+	CodeQuarantined        ErrorCode = "quarantined"         // Sender is quarantined
+	CodeRateLimited        ErrorCode = "rate_limited"        // Too many requests; retry later
+	CodePayloadTooLarge    ErrorCode = "payload_too_large"   // Envelope exceeds the receiver's size limit; details give the limit
+	CodeOverloaded         ErrorCode = "overloaded"          // Receiver is saturated; retry later
+	CodeMaintenance        ErrorCode = "maintenance"         // Receiver is in maintenance mode
+	CodeInternal           ErrorCode = "internal_error"      // Receiver failed to process the envelope
+)
+
+// HTTPStatus returns the HTTP status used when sending this error over HTTPS
+func (c ErrorCode) HTTPStatus() int {
+	switch c {
+	case CodeInvalidEnvelope, CodeInvalidBody, CodeUnsupportedType, CodeUnsupportedVersion, CodeClockSkew:
+		return http.StatusBadRequest
+	case CodeInvalidSignature, CodeUnauthorized:
+		return http.StatusUnauthorized
+	case CodeCapabilityDenied, CodeForbidden, CodePolicyViolation, CodeQuarantined:
+		return http.StatusForbidden
+	case CodeUnknownAgent, CodeUnknownRequest:
+		return http.StatusNotFound
+	case CodeRateLimited:
+		return http.StatusTooManyRequests
+	case CodePayloadTooLarge:
+		return http.StatusRequestEntityTooLarge
+	case CodeOverloaded, CodeMaintenance:
+		return http.StatusServiceUnavailable
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+// Retryable reports whether the same envelope may succeed if sent again later
+func (c ErrorCode) Retryable() bool {
+	switch c {
+	case CodeRateLimited, CodeOverloaded, CodeMaintenance, CodeInternal:
+		return true
+	default:
+		return false
+	}
+}
+
+// Error lets a received error body be returned as a Go error
+func (b *ErrorBody) Error() string {
+	return fmt.Sprintf("%s: %s", b.Code, b.Message)
+}
+
+// NewAck creates an acknowledgement of the envelope with nonce ref
+func NewAck(agent, ref, status string, result interface{}) *AckEnvelope {
+	return &AckEnvelope{
+		BaseEnvelope: BaseEnvelope{Type: EnvelopeAck, CommonHeaders: newHeaders(agent)},
+		Body:         AckBody{Ref: ref, Status: status, Result: result},
+	}
+}
+
+// NewError creates a rejection of the envelope with nonce ref
+func NewError(agent, ref string, code ErrorCode, message string) *ErrorEnvelope {
+	return &ErrorEnvelope{
+		BaseEnvelope: BaseEnvelope{Type: EnvelopeError, CommonHeaders: newHeaders(agent)},
+		Body:         ErrorBody{Ref: ref, Code: code, Message: message},
+	}
+}
+
+// ParseResponse decodes a response envelope. An error envelope is returned as
+// a *ErrorBody error, so callers can inspect its code with errors.As.
+func ParseResponse(data []byte) (*AckBody, error) {
+	envelope, err := ParseEnvelope(data)
+	if err != nil {
+		return nil, err
+	}
+
+	switch envelope.Type {
+	case EnvelopeAck:
+		ack, err := ParseTyped[AckBody](envelope)
+		if err != nil {
+			return nil, err
+		}
+		return &ack.Body, nil
+	case EnvelopeError:
+		rejection, err := ParseTyped[ErrorBody](envelope)
+		if err != nil {
+			return nil, err
+		}
+		return nil, &rejection.Body
+	default:
+		return nil, fmt.Errorf("%w: got %s, want %s or %s", ErrEnvelopeTypeMismatch, envelope.Type, EnvelopeAck, EnvelopeError)
+	}
+}
+
+// ResultAs decodes the acknowledgement's result into v
+func (b *AckBody) ResultAs(v interface{}) error {
+	data, err := json.Marshal(b.Result)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
@@ -0,0 +1,79 @@
+package protocol
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"testing"
+)
+
+func TestParseResponseAck(t *testing.T) {
+	ack := NewAck("broker", "nonce-1", "success", map[string]interface{}{"totalResults": 2})
+	data, _ := json.Marshal(ack)
+
+	body, err := ParseResponse(data)
+	if err != nil {
+		t.Fatalf("Failed to parse ack: %v", err)
+	}
+	if body.Ref != "nonce-1" || body.Status != "success" {
+		t.Errorf("Unexpected ack body: %+v", body)
+	}
+
+	var result struct {
+		TotalResults int `json:"totalResults"`
+	}
+	if err := body.ResultAs(&result); err != nil || result.TotalResults != 2 {
+		t.Errorf("Failed to decode result: %+v, %v", result, err)
+	}
+}
+
+func TestParseResponseError(t *testing.T) {
+	data, _ := json.Marshal(NewError("broker", "nonce-2", CodeUnknownAgent, "agent not registered"))
+
+	_, err := ParseResponse(data)
+	var rejection *ErrorBody
+	if !errors.As(err, &rejection) {
+		t.Fatalf("Expected an *ErrorBody error, got %v", err)
+	}
+	if rejection.Code != CodeUnknownAgent || rejection.Ref != "nonce-2" {
+		t.Errorf("Unexpected error body: %+v", rejection)
+	}
+	if rejection.Code.HTTPStatus() != http.StatusNotFound || rejection.Code.Retryable() {
+		t.Errorf("Unexpected classification for %s", rejection.Code)
+	}
+}
+
+func TestParseResponseRejectsOtherTypes(t *testing.T) {
+	envelope := NewEnvelope(EnvelopeToolCall, "agent")
+	envelope.Body = json.RawMessage(`{"tool":"math.add","requestId":"req-1"}`)
+	data, _ := json.Marshal(envelope)
+
+	if _, err := ParseResponse(data); !errors.Is(err, ErrEnvelopeTypeMismatch) {
+		t.Errorf("Expected type mismatch, got %v", err)
+	}
+	if _, err := ParseResponse([]byte(`{"type":"error","body":{"message":"no code"}}`)); err == nil {
+		t.Error("Expected error body without a code to be rejected")
+	} else if errors.As(err, new(*ErrorBody)) {
+		t.Error("Invalid error body should not be returned as a protocol error")
+	}
+}
+
+func TestErrorCodeClassification(t *testing.T) {
+	tests := []struct {
+		code      ErrorCode
+		status    int
+		retryable bool
+	}{
+		{CodeInvalidBody, http.StatusBadRequest, false},
+		{CodeInvalidSignature, http.StatusUnauthorized, false},
+		{CodeCapabilityDenied, http.StatusForbidden, false},
+		{CodeRateLimited, http.StatusTooManyRequests, true},
+		{CodeMaintenance, http.StatusServiceUnavailable, true},
+		{ErrorCode("something_new"), http.StatusInternalServerError, false},
+	}
+	for _, tt := range tests {
+		if tt.code.HTTPStatus() != tt.status || tt.code.Retryable() != tt.retryable {
+			t.Errorf("%s: got status %d retryable %t", tt.code, tt.code.HTTPStatus(), tt.code.Retryable())
+		}
+	}
+}
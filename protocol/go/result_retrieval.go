@@ -0,0 +1,48 @@
+package protocol
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// ResultsPath prefixes the URL a caller long-polls for the result of one
+// call, ResultsPath followed by the call's request ID
+const ResultsPath = "/results/"
+
+// ResultRequestHeader carries the resultAck envelope, signed by the caller and
+// base64url-encoded, that authenticates a GET of a call's result. The body's
+// wait is how long the broker may hold the request for the result to arrive.
+const ResultRequestHeader = "X-FEM-Result-Request"
+
+// ResultURL returns the URL of the result of requestID at a broker
+func ResultURL(brokerURL, requestID string) (string, error) {
+	target, err := url.Parse(brokerURL)
+	if err != nil {
+		return "", fmt.Errorf("invalid broker URL: %w", err)
+	}
+	// Request IDs may hold slashes, which must not split the path
+	target.RawPath = strings.TrimSuffix(target.EscapedPath(), "/") + ResultsPath + url.PathEscape(requestID)
+	target.Path = strings.TrimSuffix(target.Path, "/") + ResultsPath + requestID
+	return target.String(), nil
+}
+
+// EncodeResultRequest encodes a signed resultAck envelope for ResultRequestHeader
+func EncodeResultRequest(ack interface{}) (string, error) {
+	data, err := json.Marshal(ack)
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(data), nil
+}
+
+// DecodeResultRequest returns the envelope JSON carried in ResultRequestHeader
+func DecodeResultRequest(value string) ([]byte, error) {
+	data, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(value, "="))
+	if err != nil {
+		return nil, fmt.Errorf("invalid result request encoding: %w", err)
+	}
+	return data, nil
+}
@@ -0,0 +1,34 @@
+package protocol
+
+import "testing"
+
+func TestResultRequest(t *testing.T) {
+	resultURL, err := ResultURL("https://broker.example:4433/", "build 7/1")
+	if err != nil || resultURL != "https://broker.example:4433/results/build%207%2F1" {
+		t.Errorf("Expected the request ID escaped into the results path, got %s, %v", resultURL, err)
+	}
+
+	pubKey, privKey, _ := GenerateKeyPair()
+	ack := NewTypedEnvelope("caller", ResultAckBody{Wait: 20})
+	if err := ack.Sign(privKey); err != nil {
+		t.Fatalf("Failed to sign result request: %v", err)
+	}
+	header, err := EncodeResultRequest(ack)
+	if err != nil {
+		t.Fatalf("EncodeResultRequest failed: %v", err)
+	}
+	data, err := DecodeResultRequest(header)
+	if err != nil {
+		t.Fatalf("Result request should decode: %v", err)
+	}
+	decoded, err := Parse[ResultAckBody](data)
+	if err != nil || decoded.Body.Wait != 20 {
+		t.Fatalf("Expected the resultAck back, got %+v, %v", decoded, err)
+	}
+	if err := decoded.Verify(pubKey); err != nil {
+		t.Errorf("Decoded result request should verify: %v", err)
+	}
+	if _, err := DecodeResultRequest("not base64!"); err == nil {
+		t.Error("Invalid encodings should be refused")
+	}
+}
@@ -0,0 +1,91 @@
+package protocol
+
+import (
+	"encoding/base64"
+	"fmt"
+	"strings"
+)
+
+// Media types of common tool results
+const (
+	ContentTypeJSON   = "application/json"
+	ContentTypeText   = "text/plain"
+	ContentTypeBinary = "application/octet-stream"
+)
+
+// ResultKind groups result media types by how the result is carried
+type ResultKind string
+
+const (
+	ResultJSON   ResultKind = "json"   // Any JSON value: application/json and +json types
+	ResultText   ResultKind = "text"   // A string: text/* types
+	ResultImage  ResultKind = "image"  // A base64 string: image/* types
+	ResultBinary ResultKind = "binary" // A base64 string: every other type
+)
+
+// KindOf returns how results of a media type are carried. Parameters such as
+// "; charset=utf-8" are ignored.
+func KindOf(contentType string) ResultKind {
+	mediaType := strings.ToLower(mediaTypeOf(contentType))
+	switch {
+	case mediaType == "" || mediaType == ContentTypeJSON || strings.HasSuffix(mediaType, "+json"):
+		return ResultJSON
+	case strings.HasPrefix(mediaType, "text/"):
+		return ResultText
+	case strings.HasPrefix(mediaType, "image/"):
+		return ResultImage
+	default:
+		return ResultBinary
+	}
+}
+
+// mediaTypeOf strips the parameters from a content type
+func mediaTypeOf(contentType string) string {
+	mediaType, _, _ := strings.Cut(contentType, ";")
+	return strings.TrimSpace(mediaType)
+}
+
+// OfferedResultTypes returns the media types the tool can return, in
+// preference order
+func (t MCPTool) OfferedResultTypes() []string {
+	if len(t.ResultTypes) == 0 {
+		return []string{ContentTypeJSON}
+	}
+	return t.ResultTypes
+}
+
+// ResultType picks the media type to answer the call in from the ones a tool
+// offers: the first of the caller's accepted ranges an offered type satisfies,
+// or the tool's preferred type if the caller accepts anything. It returns ""
+// if no offered type is acceptable.
+func (b ToolCallBody) ResultType(offered []string) string {
+	return NegotiateFormat(offered, b.Accept)
+}
+
+// Accepts reports whether the caller takes a result of the media type
+func (b ToolCallBody) Accepts(contentType string) bool {
+	if len(b.Accept) == 0 {
+		return true
+	}
+	return NegotiateFormat([]string{mediaTypeOf(contentType)}, b.Accept) != ""
+}
+
+// ResultContentType returns the media type of the result, application/json if unset
+func (b ToolResultBody) ResultContentType() string {
+	if b.ContentType == "" {
+		return ContentTypeJSON
+	}
+	return b.ContentType
+}
+
+// Bytes decodes a binary or image result from its base64 string
+func (b ToolResultBody) Bytes() ([]byte, error) {
+	if kind := KindOf(b.ContentType); kind != ResultBinary && kind != ResultImage {
+		return nil, fmt.Errorf("%s result is not binary", b.ResultContentType())
+	}
+	encoded, ok := b.Result.(string)
+	if !ok {
+		return nil, fmt.Errorf("%s result must be a base64 string", b.ContentType)
+	}
+	return base64.StdEncoding.DecodeString(encoded)
+}
@@ -0,0 +1,80 @@
+package protocol
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+func TestKindOf(t *testing.T) {
+	for contentType, want := range map[string]ResultKind{
+		"":                          ResultJSON,
+		"application/json":          ResultJSON,
+		"application/geo+json":      ResultJSON,
+		"text/plain; charset=utf-8": ResultText,
+		"text/markdown":             ResultText,
+		"image/png":                 ResultImage,
+		"IMAGE/JPEG":                ResultImage,
+		"application/pdf":           ResultBinary,
+		"application/octet-stream":  ResultBinary,
+	} {
+		if got := KindOf(contentType); got != want {
+			t.Errorf("KindOf(%q) = %s, want %s", contentType, got, want)
+		}
+	}
+}
+
+func TestResultTypeNegotiation(t *testing.T) {
+	tool := MCPTool{Name: "chart.render", ResultTypes: []string{"image/png", "image/svg+xml", ContentTypeJSON}}
+	call := ToolCallBody{Tool: "chart.render", Accept: []string{"image/webp", "image/*"}}
+	if got := call.ResultType(tool.OfferedResultTypes()); got != "image/png" {
+		t.Errorf("Expected the tool's first image type, got %q", got)
+	}
+	if got := (ToolCallBody{}).ResultType(tool.OfferedResultTypes()); got != "image/png" {
+		t.Errorf("Without accept ranges the tool's preferred type should win, got %q", got)
+	}
+	if got := (ToolCallBody{Accept: []string{"text/*"}}).ResultType((MCPTool{}).OfferedResultTypes()); got != "" {
+		t.Errorf("A JSON-only tool cannot answer in text, got %q", got)
+	}
+
+	if !call.Accepts("image/png; q=1") || call.Accepts(ContentTypeJSON) {
+		t.Error("Accepts should match the content type against the ranges")
+	}
+	if !(ToolCallBody{}).Accepts("application/pdf") {
+		t.Error("A call without accept ranges takes any result")
+	}
+}
+
+func TestBinaryResults(t *testing.T) {
+	png := []byte{0x89, 'P', 'N', 'G', 0, 1, 2}
+	result, err := NewToolResult("charts", "req-1").Binary("image/png", png).Build()
+	if err != nil {
+		t.Fatalf("Failed to build result: %v", err)
+	}
+	data, err := result.Body.Bytes()
+	if err != nil || !bytes.Equal(data, png) {
+		t.Errorf("Expected the image bytes back, got %v, %v", data, err)
+	}
+	if _, err := (ToolResultBody{Result: "aGk="}).Bytes(); err == nil {
+		t.Error("JSON results should not decode as bytes")
+	}
+	if (ToolResultBody{}).ResultContentType() != ContentTypeJSON {
+		t.Error("Results without a content type are JSON")
+	}
+}
+
+func TestResultTypeValidation(t *testing.T) {
+	var invalidErr *ValidationError
+	call := ToolCallBody{Tool: "chart.render", RequestID: "req-1", Accept: []string{"image/*", "png"}}
+	if err := call.Validate(); !errors.As(err, &invalidErr) || invalidErr.Field != "accept[1]" {
+		t.Errorf("Expected accept[1] rejected, got %v", err)
+	}
+	result := ToolResultBody{RequestID: "req-1", Success: true, ContentType: "image/*"}
+	if err := result.Validate(); !errors.As(err, &invalidErr) || invalidErr.Field != "contentType" {
+		t.Errorf("A result's content type cannot be a range, got %v", err)
+	}
+	definition := BodyDefinition{MCPTools: []MCPTool{{Name: "chart.render", ResultTypes: []string{"*/*"}}}}
+	if err := definition.Validate(); !errors.As(err, &invalidErr) || invalidErr.Field != "bodyDefinition.mcpTools[0].resultTypes[0]" {
+		t.Errorf("Expected the tool's result type rejected, got %v", err)
+	}
+}
@@ -0,0 +1,434 @@
+package protocol
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	_ "crypto/sha256"
+	_ "crypto/sha512"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"os"
+	"time"
+)
+
+var (
+	oidSHA256        = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 2, 1}
+	oidSHA384        = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 2, 2}
+	oidSHA512        = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 2, 3}
+	oidSignedData    = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 2}
+	oidTSTInfo       = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 16, 1, 4}
+	oidContentType   = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 3}
+	oidMessageDigest = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 4}
+	oidRSASSAPSS     = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 1, 10}
+	errNoTSTInfo     = fmt.Errorf("timestamp token carries no TSTInfo")
+	maxTSAResponseSz = int64(1 << 20)
+)
+
+// RFC 3161 ASN.1 structures (only the parts needed to request and read tokens)
+
+type messageImprint struct {
+	HashAlgorithm pkix.AlgorithmIdentifier
+	HashedMessage []byte
+}
+
+type timeStampReq struct {
+	Version        int
+	MessageImprint messageImprint
+	Nonce          *big.Int `asn1:"optional"`
+	CertReq        bool     `asn1:"optional,default:false"`
+}
+
+type pkiStatusInfo struct {
+	Status       int
+	StatusString []string       `asn1:"optional,utf8"`
+	FailInfo     asn1.BitString `asn1:"optional"`
+}
+
+type timeStampResp struct {
+	Status         pkiStatusInfo
+	TimeStampToken asn1.RawValue `asn1:"optional"`
+}
+
+type contentInfo struct {
+	ContentType asn1.ObjectIdentifier
+	Content     asn1.RawValue `asn1:"explicit,tag:0"`
+}
+
+type encapsulatedContentInfo struct {
+	EContentType asn1.ObjectIdentifier
+	EContent     []byte `asn1:"explicit,tag:0"`
+}
+
+type cmsAttribute struct {
+	Type   asn1.ObjectIdentifier
+	Values asn1.RawValue // SET OF values
+}
+
+type tstAccuracy struct {
+	Seconds int `asn1:"optional"`
+	Millis  int `asn1:"optional,tag:0"`
+	Micros  int `asn1:"optional,tag:1"`
+}
+
+type tstInfo struct {
+	Version        int
+	Policy         asn1.ObjectIdentifier
+	MessageImprint messageImprint
+	SerialNumber   *big.Int
+	GenTime        time.Time   `asn1:"generalized"`
+	Accuracy       tstAccuracy `asn1:"optional"`
+	Ordering       bool        `asn1:"optional,default:false"`
+	Nonce          *big.Int    `asn1:"optional"`
+}
+
+// RFC3161Authority obtains timestamps from an RFC 3161 timestamping authority over HTTP.
+//
+// Tokens are checked for status, message imprint and nonce, and their CMS
+// signature is verified against the authority's certificate: the signer's
+// signed attributes must name the TSTInfo content and carry its digest.
+type RFC3161Authority struct {
+	URL         string
+	Certificate *x509.Certificate // Timestamping certificate the authority signs tokens with
+	HTTPClient  *http.Client
+}
+
+// NewRFC3161Authority creates a client for the timestamping authority at url
+// whose tokens are signed with cert
+func NewRFC3161Authority(url string, cert *x509.Certificate) *RFC3161Authority {
+	return &RFC3161Authority{
+		URL:         url,
+		Certificate: cert,
+		HTTPClient:  &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+// LoadRFC3161Authority creates a client for the timestamping authority at url
+// with the PEM certificate at certPath
+func LoadRFC3161Authority(url, certPath string) (*RFC3161Authority, error) {
+	data, err := os.ReadFile(certPath)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(data)
+	if block == nil || block.Type != "CERTIFICATE" {
+		return nil, fmt.Errorf("%s holds no PEM certificate", certPath)
+	}
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	return NewRFC3161Authority(url, cert), nil
+}
+
+// Timestamp requests a timestamp token for a SHA-256 digest
+func (a *RFC3161Authority) Timestamp(digest []byte) (*TimestampToken, error) {
+	nonce, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 64))
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := asn1.Marshal(timeStampReq{
+		Version: 1,
+		MessageImprint: messageImprint{
+			HashAlgorithm: pkix.AlgorithmIdentifier{Algorithm: oidSHA256, Parameters: asn1.NullRawValue},
+			HashedMessage: digest,
+		},
+		Nonce:   nonce,
+		CertReq: true,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := a.HTTPClient.Post(a.URL, "application/timestamp-query", bytes.NewReader(req))
+	if err != nil {
+		return nil, fmt.Errorf("timestamp request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("timestamp authority returned status %d", resp.StatusCode)
+	}
+
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxTSAResponseSz))
+	if err != nil {
+		return nil, err
+	}
+
+	var tsResp timeStampResp
+	if _, err := asn1.Unmarshal(data, &tsResp); err != nil {
+		return nil, fmt.Errorf("invalid timestamp response: %w", err)
+	}
+	// 0 = granted, 1 = granted with modifications
+	if tsResp.Status.Status > 1 {
+		return nil, fmt.Errorf("timestamp request rejected with status %d", tsResp.Status.Status)
+	}
+	if len(tsResp.TimeStampToken.FullBytes) == 0 {
+		return nil, fmt.Errorf("timestamp response carries no token")
+	}
+
+	parsed, err := parseTimestampToken(tsResp.TimeStampToken.FullBytes)
+	if err != nil {
+		return nil, err
+	}
+	if err := parsed.verifySignature(a.Certificate); err != nil {
+		return nil, err
+	}
+	info := parsed.info
+	if info.Nonce == nil || info.Nonce.Cmp(nonce) != 0 {
+		return nil, fmt.Errorf("timestamp nonce mismatch")
+	}
+	if !bytes.Equal(info.MessageImprint.HashedMessage, digest) {
+		return nil, fmt.Errorf("timestamp message imprint mismatch")
+	}
+
+	return &TimestampToken{
+		Authority: a.URL,
+		Kind:      TimestampKindRFC3161,
+		Digest:    DigestSHA256 + "=" + base64.StdEncoding.EncodeToString(digest),
+		Time:      info.GenTime.UnixMilli(),
+		Token:     base64.StdEncoding.EncodeToString(tsResp.TimeStampToken.FullBytes),
+	}, nil
+}
+
+// VerifyTimestamp checks that the stored token is signed by the authority and
+// covers the digest at the claimed time
+func (a *RFC3161Authority) VerifyTimestamp(token *TimestampToken, digest []byte) error {
+	if token.Kind != TimestampKindRFC3161 {
+		return fmt.Errorf("not an RFC 3161 timestamp")
+	}
+
+	der, err := base64.StdEncoding.DecodeString(token.Token)
+	if err != nil {
+		return fmt.Errorf("invalid timestamp token encoding: %w", err)
+	}
+	parsed, err := parseTimestampToken(der)
+	if err != nil {
+		return err
+	}
+	if err := parsed.verifySignature(a.Certificate); err != nil {
+		return err
+	}
+	info := parsed.info
+
+	if !info.MessageImprint.HashAlgorithm.Algorithm.Equal(oidSHA256) ||
+		!bytes.Equal(info.MessageImprint.HashedMessage, digest) {
+		return fmt.Errorf("timestamp message imprint mismatch")
+	}
+	if info.GenTime.UnixMilli() != token.Time {
+		return fmt.Errorf("timestamp time does not match token")
+	}
+	return nil
+}
+
+// timestampToken is a CMS SignedData timestamp token
+type timestampToken struct {
+	info        tstInfo
+	eContent    []byte          // DER TSTInfo the signature covers
+	signerInfos []asn1.RawValue // SignerInfo elements
+}
+
+// parseTimestampToken extracts the TSTInfo and signer infos from a CMS
+// SignedData timestamp token. The optional certificates and CRLs make the
+// SignedData sequence irregular, so its elements are walked by hand.
+func parseTimestampToken(der []byte) (*timestampToken, error) {
+	var ci contentInfo
+	if _, err := asn1.Unmarshal(der, &ci); err != nil {
+		return nil, fmt.Errorf("invalid timestamp token: %w", err)
+	}
+	if !ci.ContentType.Equal(oidSignedData) {
+		return nil, fmt.Errorf("timestamp token is not CMS SignedData")
+	}
+
+	elements, err := asn1Elements(ci.Content.Bytes)
+	if err != nil || len(elements) != 1 {
+		return nil, fmt.Errorf("invalid timestamp signed data")
+	}
+	// version, digestAlgorithms, encapContentInfo, [0] certificates, [1] crls, signerInfos
+	fields, err := asn1Elements(elements[0].Bytes)
+	if err != nil || len(fields) < 4 {
+		return nil, fmt.Errorf("invalid timestamp signed data")
+	}
+
+	var encap encapsulatedContentInfo
+	if _, err := asn1.Unmarshal(fields[2].FullBytes, &encap); err != nil {
+		return nil, fmt.Errorf("invalid timestamp signed data: %w", err)
+	}
+	if !encap.EContentType.Equal(oidTSTInfo) || len(encap.EContent) == 0 {
+		return nil, errNoTSTInfo
+	}
+
+	token := &timestampToken{eContent: encap.EContent}
+	if _, err := asn1.Unmarshal(encap.EContent, &token.info); err != nil {
+		return nil, fmt.Errorf("invalid TSTInfo: %w", err)
+	}
+
+	signerInfos := fields[len(fields)-1]
+	if signerInfos.Class != asn1.ClassUniversal || signerInfos.Tag != asn1.TagSet {
+		return nil, fmt.Errorf("timestamp token carries no signer infos")
+	}
+	if token.signerInfos, err = asn1Elements(signerInfos.Bytes); err != nil {
+		return nil, fmt.Errorf("invalid timestamp signer infos: %w", err)
+	}
+	return token, nil
+}
+
+// verifySignature checks that one of the token's signers is the authority's
+// certificate, signing attributes that bind the TSTInfo content
+func (t *timestampToken) verifySignature(cert *x509.Certificate) error {
+	if cert == nil {
+		return fmt.Errorf("no timestamping authority certificate configured")
+	}
+	timestamping := false
+	for _, usage := range cert.ExtKeyUsage {
+		timestamping = timestamping || usage == x509.ExtKeyUsageTimeStamping
+	}
+	if !timestamping {
+		return fmt.Errorf("certificate %s is not a timestamping certificate", cert.Subject)
+	}
+	if t.info.GenTime.Before(cert.NotBefore) || t.info.GenTime.After(cert.NotAfter) {
+		return fmt.Errorf("timestamp time is outside the authority certificate's validity")
+	}
+
+	err := fmt.Errorf("timestamp token has no signers")
+	for _, signer := range t.signerInfos {
+		if err = t.verifySigner(signer, cert); err == nil {
+			return nil
+		}
+	}
+	return fmt.Errorf("timestamp signature verification failed: %w", err)
+}
+
+// verifySigner checks one SignerInfo: version, sid, digestAlgorithm,
+// [0] signedAttrs, signatureAlgorithm, signature, [1] unsignedAttrs
+func (t *timestampToken) verifySigner(signer asn1.RawValue, cert *x509.Certificate) error {
+	fields, err := asn1Elements(signer.Bytes)
+	if err != nil || len(fields) < 6 {
+		return fmt.Errorf("invalid signer info")
+	}
+	signedAttrs := fields[3]
+	if signedAttrs.Class != asn1.ClassContextSpecific || signedAttrs.Tag != 0 {
+		return fmt.Errorf("signer info carries no signed attributes")
+	}
+
+	var digestAlgorithm, signatureAlgorithm pkix.AlgorithmIdentifier
+	var signature []byte
+	if _, err := asn1.Unmarshal(fields[2].FullBytes, &digestAlgorithm); err != nil {
+		return fmt.Errorf("invalid signer digest algorithm: %w", err)
+	}
+	if _, err := asn1.Unmarshal(fields[4].FullBytes, &signatureAlgorithm); err != nil {
+		return fmt.Errorf("invalid signer signature algorithm: %w", err)
+	}
+	if _, err := asn1.Unmarshal(fields[5].FullBytes, &signature); err != nil {
+		return fmt.Errorf("invalid signer signature: %w", err)
+	}
+	hash, err := cmsDigestHash(digestAlgorithm.Algorithm)
+	if err != nil {
+		return err
+	}
+
+	// The signed attributes must name the TSTInfo and carry its digest
+	attrs, err := asn1Elements(signedAttrs.Bytes)
+	if err != nil {
+		return fmt.Errorf("invalid signed attributes: %w", err)
+	}
+	contentTypeBound, digestBound := false, false
+	for _, raw := range attrs {
+		var attr cmsAttribute
+		if _, err := asn1.Unmarshal(raw.FullBytes, &attr); err != nil {
+			return fmt.Errorf("invalid signed attribute: %w", err)
+		}
+		values, err := asn1Elements(attr.Values.Bytes)
+		if err != nil || len(values) != 1 {
+			return fmt.Errorf("signed attribute %v must carry one value", attr.Type)
+		}
+		switch {
+		case attr.Type.Equal(oidContentType):
+			var contentType asn1.ObjectIdentifier
+			if _, err := asn1.Unmarshal(values[0].FullBytes, &contentType); err != nil || !contentType.Equal(oidTSTInfo) {
+				return fmt.Errorf("signed content type is not TSTInfo")
+			}
+			contentTypeBound = true
+		case attr.Type.Equal(oidMessageDigest):
+			var messageDigest []byte
+			if _, err := asn1.Unmarshal(values[0].FullBytes, &messageDigest); err != nil {
+				return fmt.Errorf("invalid message digest attribute: %w", err)
+			}
+			h := hash.New()
+			h.Write(t.eContent)
+			if !bytes.Equal(messageDigest, h.Sum(nil)) {
+				return fmt.Errorf("signed message digest does not match TSTInfo")
+			}
+			digestBound = true
+		}
+	}
+	if !contentTypeBound || !digestBound {
+		return fmt.Errorf("signed attributes must include content type and message digest")
+	}
+
+	// The signature covers the attributes DER-encoded as a SET, not as [0]
+	signed := append([]byte{0x31}, signedAttrs.FullBytes[1:]...)
+	if signatureAlgorithm.Algorithm.Equal(oidRSASSAPSS) {
+		return fmt.Errorf("RSASSA-PSS timestamp signatures are not supported")
+	}
+	switch publicKey := cert.PublicKey.(type) {
+	case ed25519.PublicKey:
+		if !ed25519.Verify(publicKey, signed, signature) {
+			return fmt.Errorf("signature does not verify")
+		}
+		return nil
+	case *ecdsa.PublicKey:
+		h := hash.New()
+		h.Write(signed)
+		if !ecdsa.VerifyASN1(publicKey, h.Sum(nil), signature) {
+			return fmt.Errorf("signature does not verify")
+		}
+		return nil
+	case *rsa.PublicKey:
+		h := hash.New()
+		h.Write(signed)
+		return rsa.VerifyPKCS1v15(publicKey, hash, h.Sum(nil), signature)
+	default:
+		return fmt.Errorf("unsupported timestamping key type %T", cert.PublicKey)
+	}
+}
+
+// cmsDigestHash maps a CMS digest algorithm to its hash
+func cmsDigestHash(algorithm asn1.ObjectIdentifier) (crypto.Hash, error) {
+	switch {
+	case algorithm.Equal(oidSHA256):
+		return crypto.SHA256, nil
+	case algorithm.Equal(oidSHA384):
+		return crypto.SHA384, nil
+	case algorithm.Equal(oidSHA512):
+		return crypto.SHA512, nil
+	default:
+		return 0, fmt.Errorf("unsupported timestamp digest algorithm %v", algorithm)
+	}
+}
+
+// asn1Elements splits DER contents into their elements
+func asn1Elements(der []byte) ([]asn1.RawValue, error) {
+	var elements []asn1.RawValue
+	for len(der) > 0 {
+		var element asn1.RawValue
+		rest, err := asn1.Unmarshal(der, &element)
+		if err != nil {
+			return nil, err
+		}
+		elements = append(elements, element)
+		der = rest
+	}
+	return elements, nil
+}
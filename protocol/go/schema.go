@@ -0,0 +1,344 @@
+package protocol
+
+import (
+	"bytes"
+	"embed"
+	"encoding/json"
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"unicode/utf8"
+)
+
+// schemaFiles holds a JSON Schema for the envelope headers and one per body type,
+// named after the envelope type, plus shared definitions
+//
+//go:embed schemas/*.json
+var schemaFiles embed.FS
+
+const (
+	envelopeSchemaFile    = "envelope.json"
+	definitionsSchemaFile = "definitions.json"
+)
+
+// SchemaError lists every field of an envelope that does not match its schema
+type SchemaError struct {
+	Type       EnvelopeType
+	Violations []*ValidationError
+}
+
+func (e *SchemaError) Error() string {
+	messages := make([]string, len(e.Violations))
+	for i, violation := range e.Violations {
+		messages[i] = violation.Error()
+	}
+	return fmt.Sprintf("envelope does not match schema: %s", strings.Join(messages, "; "))
+}
+
+// Details lists the violations for an error envelope, one entry per field
+func (e *SchemaError) Details() map[string]interface{} {
+	violations := make([]map[string]interface{}, len(e.Violations))
+	for i, violation := range e.Violations {
+		violations[i] = map[string]interface{}{"field": violation.Field, "message": violation.Message}
+	}
+	return map[string]interface{}{"violations": violations}
+}
+
+// jsonSchema is the subset of JSON Schema the embedded schemas use
+type jsonSchema struct {
+	Ref        string                 `json:"$ref"`
+	Defs       map[string]*jsonSchema `json:"$defs"`
+	Type       schemaTypes            `json:"type"`
+	Properties map[string]*jsonSchema `json:"properties"`
+	Required   []string               `json:"required"`
+	Items      *jsonSchema            `json:"items"`
+	Enum       []interface{}          `json:"enum"`
+	Minimum    *float64               `json:"minimum"`
+	Maximum    *float64               `json:"maximum"`
+	MinLength  *int                   `json:"minLength"`
+}
+
+// schemaTypes is a schema's "type", which may be a single name or a list
+type schemaTypes []string
+
+func (t *schemaTypes) UnmarshalJSON(data []byte) error {
+	var single string
+	if err := json.Unmarshal(data, &single); err == nil {
+		*t = schemaTypes{single}
+		return nil
+	}
+	var list []string
+	if err := json.Unmarshal(data, &list); err != nil {
+		return err
+	}
+	*t = list
+	return nil
+}
+
+var (
+	schemasOnce sync.Once
+	schemas     map[string]*jsonSchema
+)
+
+// loadSchemas parses the embedded schemas once. They ship with the package, so
+// a schema that fails to parse or resolve is a programming error.
+func loadSchemas() map[string]*jsonSchema {
+	schemasOnce.Do(func() {
+		entries, err := schemaFiles.ReadDir("schemas")
+		if err != nil {
+			panic(fmt.Sprintf("protocol: reading embedded schemas: %v", err))
+		}
+		schemas = make(map[string]*jsonSchema, len(entries))
+		for _, entry := range entries {
+			data, err := schemaFiles.ReadFile(path.Join("schemas", entry.Name()))
+			if err != nil {
+				panic(fmt.Sprintf("protocol: reading schema %s: %v", entry.Name(), err))
+			}
+			var schema jsonSchema
+			if err := json.Unmarshal(data, &schema); err != nil {
+				panic(fmt.Sprintf("protocol: parsing schema %s: %v", entry.Name(), err))
+			}
+			schemas[entry.Name()] = &schema
+		}
+		for file, schema := range schemas {
+			if err := checkRefs(schema, file); err != nil {
+				panic(fmt.Sprintf("protocol: schema %s: %v", file, err))
+			}
+		}
+	})
+	return schemas
+}
+
+// checkRefs makes sure every reference in a schema resolves
+func checkRefs(schema *jsonSchema, file string) error {
+	if schema == nil {
+		return nil
+	}
+	if schema.Ref != "" {
+		if _, _, err := resolveRef(schema.Ref, file); err != nil {
+			return err
+		}
+	}
+	children := []*jsonSchema{schema.Items}
+	for _, def := range schema.Defs {
+		children = append(children, def)
+	}
+	for _, property := range schema.Properties {
+		children = append(children, property)
+	}
+	for _, child := range children {
+		if err := checkRefs(child, file); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// resolveRef finds the schema a "$ref" of the form "[file]#/$defs/name" points
+// to, relative to the file containing the reference
+func resolveRef(ref, file string) (*jsonSchema, string, error) {
+	target, fragment, _ := strings.Cut(ref, "#")
+	if target == "" {
+		target = file
+	}
+	schema, exists := schemas[target]
+	if !exists {
+		return nil, "", fmt.Errorf("unknown schema file in reference %q", ref)
+	}
+	if fragment == "" {
+		return schema, target, nil
+	}
+	name, ok := strings.CutPrefix(fragment, "/$defs/")
+	if !ok || schema.Defs[name] == nil {
+		return nil, "", fmt.Errorf("unresolvable reference %q", ref)
+	}
+	return schema.Defs[name], target, nil
+}
+
+// EnvelopeSchema returns the JSON Schema for an envelope type's body, so
+// agents in other languages can validate against the same definitions
+func EnvelopeSchema(envType EnvelopeType) ([]byte, bool) {
+	file := string(envType) + ".json"
+	if file == envelopeSchemaFile || file == definitionsSchemaFile {
+		return nil, false
+	}
+	data, err := schemaFiles.ReadFile(path.Join("schemas", file))
+	if err != nil {
+		return nil, false
+	}
+	return data, true
+}
+
+// ValidateEnvelope checks raw envelope JSON against the embedded schemas: the
+// common headers for every envelope, and the body for known envelope types.
+// Every mismatching field is reported in a *SchemaError. Semantic checks such
+// as capability pattern syntax are left to the body's Validate method.
+// Encrypted bodies only carry a ciphertext and the members sent in the clear,
+// so they are checked once decrypted.
+func ValidateEnvelope(data []byte) error {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	var document interface{}
+	if err := decoder.Decode(&document); err != nil {
+		return fmt.Errorf("failed to parse envelope: %w", err)
+	}
+
+	all := loadSchemas()
+	v := &schemaValidator{}
+	v.validate(all[envelopeSchemaFile], envelopeSchemaFile, "", document)
+
+	var envType EnvelopeType
+	if fields, ok := document.(map[string]interface{}); ok {
+		if name, ok := fields["type"].(string); ok {
+			envType = EnvelopeType(name)
+		}
+		file := string(envType) + ".json"
+		body, isObject := fields["body"].(map[string]interface{})
+		_, encrypted := fields["enc"]
+		if schema, exists := all[file]; exists && isObject && !encrypted && file != envelopeSchemaFile && file != definitionsSchemaFile {
+			v.validate(schema, file, "body", body)
+		}
+	}
+
+	if len(v.violations) > 0 {
+		return &SchemaError{Type: envType, Violations: v.violations}
+	}
+	return nil
+}
+
+// schemaValidator walks a decoded document, collecting violations
+type schemaValidator struct {
+	violations []*ValidationError
+}
+
+func (v *schemaValidator) fail(field, format string, args ...interface{}) {
+	if field == "" {
+		field = "envelope"
+	}
+	v.violations = append(v.violations, &ValidationError{Field: field, Message: fmt.Sprintf(format, args...)})
+}
+
+func (v *schemaValidator) validate(schema *jsonSchema, file, field string, value interface{}) {
+	if schema.Ref != "" {
+		// References were checked when the schemas were loaded
+		target, targetFile, _ := resolveRef(schema.Ref, file)
+		v.validate(target, targetFile, field, value)
+		return
+	}
+
+	if len(schema.Type) > 0 && !matchesType(schema.Type, value) {
+		v.fail(field, "must be %s, got %s", strings.Join(schema.Type, " or "), jsonTypeOf(value))
+		return
+	}
+	if len(schema.Enum) > 0 && !inEnum(schema.Enum, value) {
+		v.fail(field, "must be one of %s", formatEnum(schema.Enum))
+		return
+	}
+
+	switch value := value.(type) {
+	case string:
+		if schema.MinLength != nil && utf8.RuneCountInString(value) < *schema.MinLength {
+			if *schema.MinLength == 1 {
+				v.fail(field, "must not be empty")
+			} else {
+				v.fail(field, "must be at least %d characters", *schema.MinLength)
+			}
+		}
+	case json.Number:
+		n, _ := value.Float64()
+		if schema.Minimum != nil && n < *schema.Minimum {
+			v.fail(field, "must be at least %v", *schema.Minimum)
+		}
+		if schema.Maximum != nil && n > *schema.Maximum {
+			v.fail(field, "must be at most %v", *schema.Maximum)
+		}
+	case []interface{}:
+		if schema.Items != nil {
+			for i, item := range value {
+				v.validate(schema.Items, file, fmt.Sprintf("%s[%d]", field, i), item)
+			}
+		}
+	case map[string]interface{}:
+		for _, name := range schema.Required {
+			if _, present := value[name]; !present {
+				v.fail(joinField(field, name), "required")
+			}
+		}
+		names := make([]string, 0, len(schema.Properties))
+		for name := range schema.Properties {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			if property, present := value[name]; present {
+				v.validate(schema.Properties[name], file, joinField(field, name), property)
+			}
+		}
+	}
+}
+
+func joinField(parent, name string) string {
+	if parent == "" {
+		return name
+	}
+	return parent + "." + name
+}
+
+// jsonTypeOf names the JSON type of a value decoded with UseNumber
+func jsonTypeOf(value interface{}) string {
+	switch value := value.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case string:
+		return "string"
+	case json.Number:
+		if isInteger(value) {
+			return "integer"
+		}
+		return "number"
+	case []interface{}:
+		return "array"
+	default:
+		return "object"
+	}
+}
+
+// isInteger reports whether a number decodes into a Go integer, so "1.0" is
+// not an integer here even though JSON Schema would accept it
+func isInteger(n json.Number) bool {
+	_, err := n.Int64()
+	return err == nil
+}
+
+func matchesType(types schemaTypes, value interface{}) bool {
+	actual := jsonTypeOf(value)
+	for _, want := range types {
+		if want == actual || (want == "number" && actual == "integer") {
+			return true
+		}
+	}
+	return false
+}
+
+func inEnum(enum []interface{}, value interface{}) bool {
+	encoded, _ := json.Marshal(value)
+	for _, allowed := range enum {
+		if candidate, _ := json.Marshal(allowed); bytes.Equal(candidate, encoded) {
+			return true
+		}
+	}
+	return false
+}
+
+func formatEnum(enum []interface{}) string {
+	values := make([]string, len(enum))
+	for i, allowed := range enum {
+		encoded, _ := json.Marshal(allowed)
+		values[i] = string(encoded)
+	}
+	return strings.Join(values, ", ")
+}
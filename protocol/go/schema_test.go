@@ -0,0 +1,99 @@
+package protocol
+
+import (
+	"encoding/json"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestSchemasAcceptWellFormedEnvelopes(t *testing.T) {
+	bodies := []EnvelopeBody{
+		RegisterAgentBody{PubKey: "key", Capabilities: []string{"math.*"}, BodyDefinition: &BodyDefinition{MCPTools: []MCPTool{{Name: "math.add"}}}},
+		RegisterBrokerBody{Endpoint: "https://broker"},
+		EmitEventBody{Event: "ci.build"},
+		RenderInstructionBody{Instruction: "show", Presentation: &PresentationHints{MaxWidth: 80}},
+		ToolCallBody{Tool: "math.add", RequestID: "r1", Delivery: DeliverAtLeastOnce, ResultDelivery: &ResultDelivery{Mode: ResultDeliveryWebhook, URL: "https://hooks.example/fem"}},
+		ToolResultBody{RequestID: "r1", Success: true, Result: 5},
+		RevokeBody{Target: "agent"},
+		DiscoverToolsBody{Query: ToolQuery{Capabilities: []string{"math.*"}, MaxResults: 5}},
+		ToolsDiscoveredBody{RequestID: "r1", Tools: []DiscoveredTool{{AgentID: "agent"}}},
+		EmbodimentUpdateBody{},
+		HeartbeatBody{Load: 0.5, InFlight: 2},
+		ResultAckBody{Acknowledged: []string{"r1"}, Wait: 20},
+		DrainInstanceBody{InstanceID: "replica-a", Wait: 30},
+		PreemptionNoticeBody{Deadline: 1700000000000},
+		CheckpointBody{RequestID: "r1", Tool: "video.transcode", State: []byte("frame=120")},
+		ApproveCallBody{RequestID: "r1", Approved: true},
+		AdminApprovalBody{Operation: "policy", Method: "PUT", Path: "/admin/routes"},
+		AckBody{Status: "ok"},
+		ErrorBody{Code: CodeForbidden},
+	}
+	for _, body := range bodies {
+		if _, exists := EnvelopeSchema(body.EnvelopeType()); !exists {
+			t.Errorf("No schema for %s", body.EnvelopeType())
+		}
+		data, _ := json.Marshal(NewTypedEnvelope("agent", body))
+		if err := ValidateEnvelope(data); err != nil {
+			t.Errorf("Well-formed %s rejected: %v", body.EnvelopeType(), err)
+		}
+	}
+	if _, exists := EnvelopeSchema("envelope"); exists {
+		t.Error("Header schema should not be returned as a body schema")
+	}
+}
+
+func TestValidateEnvelopeReportsEachField(t *testing.T) {
+	data := []byte(`{"type":"toolCall","agent":"a","ts":"now","nonce":"n","body":{"tool":5,"parameters":[],"delivery":"eventually"}}`)
+
+	err := ValidateEnvelope(data)
+	var schemaErr *SchemaError
+	if !errors.As(err, &schemaErr) {
+		t.Fatalf("Expected a *SchemaError, got %v", err)
+	}
+	fields := make([]string, len(schemaErr.Violations))
+	for i, violation := range schemaErr.Violations {
+		fields[i] = violation.Field
+	}
+	want := []string{"ts", "body.requestId", "body.delivery", "body.parameters", "body.tool"}
+	if !reflect.DeepEqual(fields, want) {
+		t.Errorf("Expected violations for %v, got %v", want, fields)
+	}
+	if schemaErr.Type != EnvelopeToolCall {
+		t.Errorf("Expected the envelope type to be reported, got %q", schemaErr.Type)
+	}
+	if details := schemaErr.Details(); len(details["violations"].([]map[string]interface{})) != len(want) {
+		t.Errorf("Details should list every violation, got %v", details)
+	}
+
+	if _, err := ParseEnvelope(data); !errors.As(err, &schemaErr) {
+		t.Errorf("ParseEnvelope should reject envelopes that do not match their schema, got %v", err)
+	}
+}
+
+func TestValidateEnvelopeNumbers(t *testing.T) {
+	cases := map[string]bool{
+		`{"load":0.5,"inFlight":3}`: true,
+		`{"load":1.5}`:              false,
+		`{"inFlight":1.5}`:          false,
+		`{"uptime":-1}`:             false,
+		`{"available":{"gpus":1}}`:  true,
+		`{"available":{"cpu":-2}}`:  false,
+	}
+	for body, valid := range cases {
+		data := []byte(`{"type":"heartbeat","agent":"a","ts":1,"nonce":"n","body":` + body + `}`)
+		if err := ValidateEnvelope(data); (err == nil) != valid {
+			t.Errorf("Heartbeat body %s: valid=%v, got %v", body, valid, err)
+		}
+	}
+}
+
+func TestValidateEnvelopeUnknownType(t *testing.T) {
+	data := []byte(`{"type":"custom","agent":"a","ts":1,"nonce":"n","body":{"anything":true}}`)
+	if err := ValidateEnvelope(data); err != nil {
+		t.Errorf("Unknown types should only have their headers checked, got %v", err)
+	}
+	if err := ValidateEnvelope([]byte(`{"type":"custom"`)); err == nil || errors.As(err, new(*SchemaError)) {
+		t.Errorf("Truncated JSON should fail to parse, got %v", err)
+	}
+}
@@ -0,0 +1,20 @@
+package protocol
+
+import "sync/atomic"
+
+// Sequencer numbers the envelopes an agent sends so a receiver can deliver
+// them in order. Numbers start at 1; a sender that restarts begins again at 1
+// after re-registering.
+type Sequencer struct {
+	last atomic.Uint64
+}
+
+// Next returns the next sequence number
+func (s *Sequencer) Next() uint64 {
+	return s.last.Add(1)
+}
+
+// Stamp sets the next sequence number on headers; sign the envelope afterwards
+func (s *Sequencer) Stamp(headers *CommonHeaders) {
+	headers.Seq = s.Next()
+}
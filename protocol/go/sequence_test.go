@@ -0,0 +1,22 @@
+package protocol
+
+import "testing"
+
+func TestSequencerStampsSignedHeader(t *testing.T) {
+	pub, priv, _ := GenerateKeyPair()
+	var sequencer Sequencer
+
+	first := NewTypedEnvelope("agent", EmitEventBody{Event: "ci.build"})
+	sequencer.Stamp(&first.CommonHeaders)
+	second := NewTypedEnvelope("agent", EmitEventBody{Event: "ci.build"})
+	sequencer.Stamp(&second.CommonHeaders)
+	if first.Seq != 1 || second.Seq != 2 {
+		t.Fatalf("Expected sequence 1, 2, got %d, %d", first.Seq, second.Seq)
+	}
+
+	second.Sign(priv)
+	second.Seq = 1
+	if err := second.Verify(pub); err == nil {
+		t.Error("Changing seq should invalidate the signature")
+	}
+}
@@ -0,0 +1,77 @@
+package protocol
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// Signer signs with an Ed25519 key that need not be held in memory, such as
+// one kept in an HSM or TPM. It has the shape of crypto.Signer, so an
+// ed25519.PrivateKey is a Signer and so is any crypto.Signer wrapping an
+// Ed25519 key.
+type Signer interface {
+	Public() crypto.PublicKey
+	Sign(rand io.Reader, message []byte, opts crypto.SignerOpts) ([]byte, error)
+}
+
+// ErrNotEd25519 is returned when a signer's key is not an Ed25519 key
+var ErrNotEd25519 = errors.New("signer does not hold an Ed25519 key")
+
+// SignerPublicKey returns the Ed25519 public key of signer
+func SignerPublicKey(signer Signer) (ed25519.PublicKey, error) {
+	if signer == nil {
+		return nil, fmt.Errorf("no signing key")
+	}
+	// A nil or truncated key would panic in Public
+	if key, isKey := signer.(ed25519.PrivateKey); isKey && len(key) != ed25519.PrivateKeySize {
+		return nil, fmt.Errorf("invalid private key size: got %d, want %d", len(key), ed25519.PrivateKeySize)
+	}
+	publicKey, ok := signer.Public().(ed25519.PublicKey)
+	if !ok {
+		return nil, fmt.Errorf("%w: got %T", ErrNotEd25519, signer.Public())
+	}
+	return publicKey, nil
+}
+
+// ErrKeyIDMismatch is returned when an envelope's kid header names a different
+// key than the one verifying it
+var ErrKeyIDMismatch = errors.New("envelope key ID does not match the verifying key")
+
+// KeyID returns the fingerprint of an Ed25519 public key carried in the kid
+// header of envelopes it signs: the hex of the first 8 bytes of its SHA-256.
+// Verifiers holding several keys for an agent use it to pick the right one.
+func KeyID(publicKey ed25519.PublicKey) string {
+	sum := sha256.Sum256(publicKey)
+	return hex.EncodeToString(sum[:8])
+}
+
+// setKeyID sets the kid header to the ID of signer's key
+func setKeyID(headers *CommonHeaders, signer Signer) error {
+	publicKey, err := SignerPublicKey(signer)
+	if err != nil {
+		return err
+	}
+	headers.KID = KeyID(publicKey)
+	return nil
+}
+
+// signMessage signs message with pure Ed25519, as ed25519.Sign does
+func signMessage(signer Signer, message []byte) ([]byte, error) {
+	if _, err := SignerPublicKey(signer); err != nil {
+		return nil, err
+	}
+	signature, err := signer.Sign(rand.Reader, message, crypto.Hash(0))
+	if err != nil {
+		return nil, fmt.Errorf("failed to sign: %w", err)
+	}
+	if len(signature) != ed25519.SignatureSize {
+		return nil, fmt.Errorf("signer returned a %d byte signature, want %d", len(signature), ed25519.SignatureSize)
+	}
+	return signature, nil
+}
@@ -0,0 +1,63 @@
+package protocol
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"errors"
+	"io"
+	"testing"
+)
+
+// countingSigner holds its key out of reach, as an HSM would
+type countingSigner struct {
+	key   ed25519.PrivateKey
+	calls int
+}
+
+func (s *countingSigner) Public() crypto.PublicKey {
+	return s.key.Public()
+}
+
+func (s *countingSigner) Sign(rand io.Reader, message []byte, opts crypto.SignerOpts) ([]byte, error) {
+	s.calls++
+	return s.key.Sign(rand, message, opts)
+}
+
+func TestSignWithSigner(t *testing.T) {
+	publicKey, privateKey, _ := GenerateKeyPair()
+	signer := &countingSigner{key: privateKey}
+
+	envelope := NewTypedEnvelope("hsm-agent", ToolCallBody{Tool: "math.add", RequestID: "req-1"})
+	if err := envelope.Sign(signer); err != nil {
+		t.Fatalf("Failed to sign with a Signer: %v", err)
+	}
+	if signer.calls != 1 {
+		t.Errorf("Expected one call to the signer, got %d", signer.calls)
+	}
+	if err := envelope.Verify(publicKey); err != nil {
+		t.Errorf("Signature from a Signer should verify: %v", err)
+	}
+
+	// An ed25519.PrivateKey is itself a Signer and signs identically
+	signature := envelope.Sig
+	if err := envelope.Sign(privateKey); err != nil || envelope.Sig != signature {
+		t.Errorf("Expected the same signature from the raw key, got %v", err)
+	}
+}
+
+func TestSignRejectsUnusableSigners(t *testing.T) {
+	ecKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	envelope := NewTypedEnvelope("agent", ToolCallBody{Tool: "math.add", RequestID: "req-1"})
+	if err := envelope.Sign(ecKey); !errors.Is(err, ErrNotEd25519) {
+		t.Errorf("Expected ErrNotEd25519 for an ECDSA key, got %v", err)
+	}
+	if err := envelope.Sign(nil); err == nil {
+		t.Error("Expected an error without a key")
+	}
+	if err := envelope.Sign(ed25519.PrivateKey(make([]byte, 10))); err == nil {
+		t.Error("Expected an error for a truncated key")
+	}
+}
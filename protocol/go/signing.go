@@ -0,0 +1,141 @@
+package protocol
+
+import (
+	"crypto/ed25519"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+)
+
+// signingInput is the form of an envelope covered by its signature. For
+// senders older than CanonicalSigningVersion, field order and encoding match
+// json.Marshal of an Envelope with the sig field omitted, so typed and generic
+// envelopes produce identical bytes.
+type signingInput struct {
+	Type   EnvelopeType    `json:"type"`
+	Agent  string          `json:"agent"`
+	TS     int64           `json:"ts"`
+	Nonce  string          `json:"nonce"`
+	Seq    uint64          `json:"seq,omitempty"`
+	Proto  string          `json:"proto,omitempty"`
+	Digest string          `json:"digest,omitempty"`
+	KID    string          `json:"kid,omitempty"`
+	Locale string          `json:"locale,omitempty"`
+	Accept []string        `json:"accept,omitempty"`
+	Enc    *Encryption     `json:"enc,omitempty"`
+	Body   json.RawMessage `json:"body,omitempty"`
+}
+
+// SigningBytes returns the canonical bytes covered by an envelope signature.
+// The body may be a typed body struct or already-encoded JSON; either way it is
+// compacted so that whitespace and transport encoding never affect the signature.
+// Envelopes declaring CanonicalSigningVersion or later are signed over RFC 8785
+// canonical JSON, so signatures survive re-serialization by other languages and
+// JSON libraries.
+//
+// When the headers carry a body digest (a detached signature), the digest is
+// signed in place of the body and the body itself is not serialized.
+func SigningBytes(envType EnvelopeType, headers CommonHeaders, body interface{}) ([]byte, error) {
+	input := signingInput{
+		Type:   envType,
+		Agent:  headers.Agent,
+		TS:     headers.TS,
+		Nonce:  headers.Nonce,
+		Seq:    headers.Seq,
+		Proto:  headers.Proto,
+		Digest: headers.Digest,
+		KID:    headers.KID,
+		Locale: headers.Locale,
+		Accept: headers.Accept,
+		Enc:    headers.Enc,
+	}
+	if headers.Digest == "" {
+		raw, err := canonicalBody(body)
+		if err != nil {
+			return nil, err
+		}
+		input.Body = raw
+	}
+
+	if usesCanonicalSigning(headers.Proto) {
+		return MarshalCanonical(input)
+	}
+	return json.Marshal(input)
+}
+
+// canonicalBody converts an envelope body into JSON suitable for signing
+func canonicalBody(body interface{}) (json.RawMessage, error) {
+	switch b := body.(type) {
+	case json.RawMessage:
+		if len(b) == 0 {
+			return json.RawMessage("null"), nil
+		}
+		return b, nil
+	case []byte:
+		if len(b) == 0 {
+			return json.RawMessage("null"), nil
+		}
+		return json.RawMessage(b), nil
+	default:
+		data, err := json.Marshal(b)
+		if err != nil {
+			return nil, fmt.Errorf("failed to encode body for signing: %w", err)
+		}
+		return data, nil
+	}
+}
+
+// signEnvelope signs the canonical bytes of an envelope and stores the signature in its headers
+func signEnvelope(envType EnvelopeType, headers *CommonHeaders, body interface{}, signer Signer) error {
+	// Remove existing signature and any detached body digest
+	headers.Sig = ""
+	headers.Digest = ""
+	if err := setKeyID(headers, signer); err != nil {
+		return err
+	}
+
+	data, err := SigningBytes(envType, *headers, body)
+	if err != nil {
+		return err
+	}
+
+	signature, err := signMessage(signer, data)
+	if err != nil {
+		return err
+	}
+	headers.Sig = base64.StdEncoding.EncodeToString(signature)
+	return nil
+}
+
+// verifyEnvelope checks an envelope signature against its canonical bytes
+func verifyEnvelope(envType EnvelopeType, headers CommonHeaders, body interface{}, publicKey ed25519.PublicKey) error {
+	if headers.Sig == "" {
+		return fmt.Errorf("envelope has no signature")
+	}
+	if headers.KID != "" && headers.KID != KeyID(publicKey) {
+		return fmt.Errorf("%w: signed by key %s, verifying with %s", ErrKeyIDMismatch, headers.KID, KeyID(publicKey))
+	}
+
+	signature, err := base64.StdEncoding.DecodeString(headers.Sig)
+	if err != nil {
+		return fmt.Errorf("invalid signature encoding: %w", err)
+	}
+
+	// Detached signatures cover a digest of the body exactly as carried
+	if headers.Digest != "" {
+		if err := verifyBodyDigest(headers.Digest, body); err != nil {
+			return err
+		}
+	}
+
+	data, err := SigningBytes(envType, headers, body)
+	if err != nil {
+		return err
+	}
+
+	if !ed25519.Verify(publicKey, data, signature) {
+		return fmt.Errorf("signature verification failed")
+	}
+
+	return nil
+}
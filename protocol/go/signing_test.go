@@ -0,0 +1,202 @@
+package protocol
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestSigningBytesTypedAndGenericMatch(t *testing.T) {
+	headers := CommonHeaders{Agent: "test-agent", TS: time.Now().UnixMilli(), Nonce: "n-1"}
+	body := ToolCallBody{
+		Tool:       "math.add",
+		Parameters: map[string]interface{}{"a": 1, "b": 2},
+		RequestID:  "req-1",
+	}
+
+	typed, err := SigningBytes(EnvelopeToolCall, headers, body)
+	if err != nil {
+		t.Fatalf("Failed to build typed signing bytes: %v", err)
+	}
+
+	// The same body with extra whitespace, as a different transport might carry it
+	raw := json.RawMessage("{ \"tool\": \"math.add\",\n \"parameters\": {\"a\": 1, \"b\": 2}, \"requestId\": \"req-1\" }")
+	generic, err := SigningBytes(EnvelopeToolCall, headers, raw)
+	if err != nil {
+		t.Fatalf("Failed to build generic signing bytes: %v", err)
+	}
+
+	if !bytes.Equal(typed, generic) {
+		t.Errorf("Signing bytes differ:\n typed:   %s\n generic: %s", typed, generic)
+	}
+
+	// The signature itself is never part of the signed bytes
+	headers.Sig = "ignored"
+	withSig, _ := SigningBytes(EnvelopeToolCall, headers, body)
+	if !bytes.Equal(typed, withSig) {
+		t.Error("Signing bytes should not depend on the sig header")
+	}
+}
+
+func TestSignThenCompressRoundTrip(t *testing.T) {
+	pubKey, privKey, err := GenerateKeyPair()
+	if err != nil {
+		t.Fatalf("Failed to generate key pair: %v", err)
+	}
+
+	envelope := &ToolResultEnvelope{
+		BaseEnvelope: BaseEnvelope{
+			Type: EnvelopeToolResult,
+			CommonHeaders: CommonHeaders{
+				Agent: "test-agent",
+				TS:    time.Now().UnixMilli(),
+				Nonce: "compress-test",
+			},
+		},
+		Body: ToolResultBody{
+			RequestID: "req-1",
+			Success:   true,
+			Result:    map[string]interface{}{"output": string(bytes.Repeat([]byte("data "), 1000))},
+		},
+	}
+	if err := envelope.Sign(privKey); err != nil {
+		t.Fatalf("Failed to sign: %v", err)
+	}
+
+	plain, err := EncodeEnvelope(envelope, EncodingIdentity)
+	if err != nil {
+		t.Fatalf("Failed to encode: %v", err)
+	}
+	for _, encoding := range []ContentEncoding{EncodingGzip, EncodingZstd} {
+		compressed, err := EncodeEnvelope(envelope, encoding)
+		if err != nil {
+			t.Fatalf("Failed to compress with %s: %v", encoding, err)
+		}
+		if len(compressed) >= len(plain) {
+			t.Errorf("Expected %s to shrink envelope: %d >= %d", encoding, len(compressed), len(plain))
+		}
+
+		decoded, err := DecodeEnvelope(compressed, encoding)
+		if err != nil {
+			t.Fatalf("Failed to decode %s: %v", encoding, err)
+		}
+		if err := decoded.Verify(pubKey); err != nil {
+			t.Errorf("Signature should verify after %s decompression: %v", encoding, err)
+		}
+	}
+
+	if _, err := DecodeEnvelope(plain, ContentEncoding("br")); err == nil {
+		t.Error("Expected error for unsupported encoding")
+	}
+}
+
+func TestZstdDecompressionIsBounded(t *testing.T) {
+	codec := newZstdCodec()
+	compressed, _ := codec.Compress(make([]byte, 4096))
+	if _, err := codec.Decompress(compressed, 1024); err == nil {
+		t.Error("Expected data inflating past the limit refused")
+	}
+	if decoded, err := codec.Decompress(compressed, 4096); err != nil || len(decoded) != 4096 {
+		t.Errorf("Expected data within the limit decoded, got %d bytes: %v", len(decoded), err)
+	}
+	if _, err := DecompressBytes([]byte("not zstd"), EncodingZstd); err == nil {
+		t.Error("Expected invalid zstd data refused")
+	}
+}
+
+func TestDetachedSignature(t *testing.T) {
+	pubKey, privKey, err := GenerateKeyPair()
+	if err != nil {
+		t.Fatalf("Failed to generate key pair: %v", err)
+	}
+
+	envelope := &ToolResultEnvelope{
+		BaseEnvelope: BaseEnvelope{
+			Type: EnvelopeToolResult,
+			CommonHeaders: CommonHeaders{
+				Agent: "test-agent",
+				TS:    time.Now().UnixMilli(),
+				Nonce: "detached-test",
+			},
+		},
+		Body: ToolResultBody{
+			RequestID: "req-1",
+			Success:   true,
+			Result:    string(bytes.Repeat([]byte("x"), 4096)),
+		},
+	}
+
+	if err := envelope.SignDetached(privKey, DigestSHA256); err != nil {
+		t.Fatalf("Failed to sign detached: %v", err)
+	}
+	if envelope.Digest == "" {
+		t.Fatal("Expected digest header to be set")
+	}
+
+	data, err := json.Marshal(envelope)
+	if err != nil {
+		t.Fatalf("Failed to marshal: %v", err)
+	}
+	generic, err := ParseEnvelope(data)
+	if err != nil {
+		t.Fatalf("Failed to parse: %v", err)
+	}
+	if err := generic.Verify(pubKey); err != nil {
+		t.Errorf("Detached signature should verify: %v", err)
+	}
+
+	// Tampering with the body breaks the digest
+	generic.Body = json.RawMessage(`{"requestId":"req-1","success":false}`)
+	if err := generic.Verify(pubKey); err == nil {
+		t.Error("Expected verification to fail for a modified body")
+	}
+
+	// A regular signature clears the digest
+	if err := envelope.Sign(privKey); err != nil {
+		t.Fatalf("Failed to sign: %v", err)
+	}
+	if envelope.Digest != "" {
+		t.Error("Regular signing should clear the digest header")
+	}
+
+	if _, err := BodyDigest([]byte("{}"), "md5"); err == nil {
+		t.Error("Expected error for unsupported digest algorithm")
+	}
+}
+
+func TestKeyIDHeader(t *testing.T) {
+	publicKey, privateKey, _ := GenerateKeyPair()
+	otherKey, _, _ := GenerateKeyPair()
+	envelope := NewTypedEnvelope("test-agent", HeartbeatBody{Load: 0.5})
+	if err := envelope.Sign(privateKey); err != nil {
+		t.Fatal(err)
+	}
+	if envelope.KID != KeyID(publicKey) || len(envelope.KID) != 16 {
+		t.Fatalf("Expected kid %s, got %q", KeyID(publicKey), envelope.KID)
+	}
+	if err := envelope.Verify(publicKey); err != nil {
+		t.Fatalf("Verify failed: %v", err)
+	}
+	if err := envelope.Verify(otherKey); !errors.Is(err, ErrKeyIDMismatch) {
+		t.Errorf("Expected ErrKeyIDMismatch for another key, got %v", err)
+	}
+
+	// The kid is signed, so it cannot be swapped to point at another key
+	envelope.KID = KeyID(otherKey)
+	if err := envelope.Verify(otherKey); err == nil || errors.Is(err, ErrKeyIDMismatch) {
+		t.Errorf("Expected a rewritten kid to break the signature, got %v", err)
+	}
+
+	// Envelopes from senders that predate kid still verify
+	envelope.KID = ""
+	envelope.Sig = ""
+	data, _ := SigningBytes(envelope.Type, envelope.CommonHeaders, envelope.Body)
+	envelope.Sig = base64.StdEncoding.EncodeToString(ed25519.Sign(privateKey, data))
+	if err := envelope.Verify(publicKey); err != nil {
+		t.Errorf("Expected an envelope without kid to verify, got %v", err)
+	}
+}
@@ -0,0 +1,113 @@
+package protocol
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// SizeLimits bound the envelopes a receiver accepts, in bytes. PerType
+// replaces Max for individual types, e.g. a small limit for heartbeats or a
+// larger one for toolResult. Reads are cut off at Largest before the type is
+// known; the type's limit is then checked with any compressed body inflated.
+type SizeLimits struct {
+	Max     int                  // Limit for types without their own; 0 for no limit
+	PerType map[EnvelopeType]int // Limits for individual envelope types; only positive limits apply
+}
+
+// Limit returns the largest envelope of a type accepted; 0 for no limit
+func (l SizeLimits) Limit(envType EnvelopeType) int {
+	if limit := l.PerType[envType]; limit > 0 {
+		return limit
+	}
+	return l.Max
+}
+
+// Largest returns the most bytes any envelope may have, which bounds reads
+// before the type is known; 0 for no limit
+func (l SizeLimits) Largest() int {
+	largest := l.Max
+	if largest <= 0 {
+		return 0
+	}
+	for _, limit := range l.PerType {
+		largest = max(largest, limit)
+	}
+	return largest
+}
+
+// Check returns a *PayloadTooLargeError if an envelope of the type and size
+// exceeds its limit
+func (l SizeLimits) Check(envType EnvelopeType, size int) error {
+	if limit := l.Limit(envType); limit > 0 && size > limit {
+		return &PayloadTooLargeError{Type: envType, Size: size, Limit: limit}
+	}
+	return nil
+}
+
+// ParseSizeLimits reads per-type limits written as comma-separated
+// type=bytes pairs, e.g. "heartbeat=4096,toolResult=33554432"
+func ParseSizeLimits(spec string) (map[EnvelopeType]int, error) {
+	limits := make(map[EnvelopeType]int)
+	for _, pair := range strings.Split(spec, ",") {
+		if pair = strings.TrimSpace(pair); pair == "" {
+			continue
+		}
+		name, value, found := strings.Cut(pair, "=")
+		if !found || name == "" {
+			return nil, fmt.Errorf("invalid size limit %q: want type=bytes", pair)
+		}
+		size, err := strconv.Atoi(value)
+		if err != nil || size <= 0 {
+			return nil, fmt.Errorf("invalid size limit %q: want a positive byte count", pair)
+		}
+		limits[EnvelopeType(name)] = size
+	}
+	return limits, nil
+}
+
+// String lists the per-type limits in the form ParseSizeLimits reads
+func (l SizeLimits) String() string {
+	pairs := make([]string, 0, len(l.PerType))
+	for envType, limit := range l.PerType {
+		pairs = append(pairs, fmt.Sprintf("%s=%d", envType, limit))
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, ",")
+}
+
+// PayloadTooLargeError reports an envelope over its size limit. It wraps
+// ErrEnvelopeTooLarge.
+type PayloadTooLargeError struct {
+	Type  EnvelopeType // Empty if the envelope was refused before its type was read
+	Size  int          // Bytes received; 0 if reading stopped at the limit
+	Limit int
+}
+
+func (e *PayloadTooLargeError) Error() string {
+	what := "envelope"
+	if e.Type != "" {
+		what = string(e.Type) + " envelope"
+	}
+	if e.Size > 0 {
+		return fmt.Sprintf("%s of %d bytes exceeds limit of %d", what, e.Size, e.Limit)
+	}
+	return fmt.Sprintf("%s exceeds limit of %d bytes", what, e.Limit)
+}
+
+func (e *PayloadTooLargeError) Unwrap() error {
+	return ErrEnvelopeTooLarge
+}
+
+// ErrorBody converts the error into the payload_too_large error sent to the sender
+func (e *PayloadTooLargeError) ErrorBody() *ErrorBody {
+	details := map[string]interface{}{"limit": e.Limit}
+	if e.Type != "" {
+		details["type"] = string(e.Type)
+	}
+	if e.Size > 0 {
+		details["size"] = e.Size
+	}
+	return &ErrorBody{Code: CodePayloadTooLarge, Message: e.Error(), Details: details}
+}
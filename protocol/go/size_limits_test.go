@@ -0,0 +1,122 @@
+package protocol
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSizeLimitsPerType(t *testing.T) {
+	limits := SizeLimits{Max: 1024, PerType: map[EnvelopeType]int{EnvelopeHeartbeat: 256, EnvelopeToolResult: 4096}}
+	if limits.Largest() != 4096 {
+		t.Errorf("Reads should allow the largest type limit, got %d", limits.Largest())
+	}
+	if err := limits.Check(EnvelopeToolResult, 2048); err != nil {
+		t.Errorf("A toolResult under its own limit should pass: %v", err)
+	}
+	if err := limits.Check(EnvelopeToolCall, 2048); !errors.Is(err, ErrEnvelopeTooLarge) {
+		t.Errorf("A toolCall over the default limit should fail, got %v", err)
+	}
+
+	var tooLarge *PayloadTooLargeError
+	if err := limits.Check(EnvelopeHeartbeat, 300); !errors.As(err, &tooLarge) {
+		t.Fatalf("A heartbeat over its limit should fail, got %v", err)
+	}
+	body := tooLarge.ErrorBody()
+	if body.Code != CodePayloadTooLarge || body.Code.HTTPStatus() != http.StatusRequestEntityTooLarge || body.Code.Retryable() {
+		t.Errorf("Expected a non-retryable 413 payload_too_large, got %+v", body)
+	}
+	if body.Details["limit"] != 256 || body.Details["size"] != 300 || body.Details["type"] != "heartbeat" {
+		t.Errorf("Details should give the limit, size and type, got %v", body.Details)
+	}
+
+	if (SizeLimits{}).Check(EnvelopeToolResult, 1<<30) != nil || (SizeLimits{}).Largest() != 0 {
+		t.Error("Zero limits should not bound envelopes")
+	}
+}
+
+func TestParseSizeLimits(t *testing.T) {
+	limits, err := ParseSizeLimits("heartbeat=4096, toolResult=33554432")
+	if err != nil || limits[EnvelopeHeartbeat] != 4096 || limits[EnvelopeToolResult] != 33554432 {
+		t.Fatalf("Unexpected limits %v, %v", limits, err)
+	}
+	if got := (SizeLimits{PerType: limits}).String(); got != "heartbeat=4096,toolResult=33554432" {
+		t.Errorf("Limits should print as they are parsed, got %q", got)
+	}
+	for _, spec := range []string{"heartbeat", "heartbeat=big", "heartbeat=0", "=10"} {
+		if _, err := ParseSizeLimits(spec); err == nil {
+			t.Errorf("%q should be rejected", spec)
+		}
+	}
+}
+
+func TestTransportRejectsOversizedTypes(t *testing.T) {
+	server, _ := NewTransport(nil)
+	server.SetSizeLimits(SizeLimits{Max: 4096, PerType: map[EnvelopeType]int{EnvelopeHeartbeat: 200}})
+	handled := make(chan *Envelope, 2)
+	server.RegisterHandler(EnvelopeHeartbeat, func(envelope *Envelope, _ net.Conn) error {
+		handled <- envelope
+		return nil
+	})
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go server.Serve(ctx, listener)
+
+	conn, err := tls.Dial("tcp", listener.Addr().String(), &tls.Config{InsecureSkipVerify: true})
+	if err != nil {
+		t.Fatalf("Failed to dial: %v", err)
+	}
+	defer conn.Close()
+	reader := bufio.NewReader(conn)
+	send := func(agent string) {
+		envelope := &Envelope{Type: EnvelopeHeartbeat, CommonHeaders: newHeaders(agent), Body: []byte(`{"load":0,"inFlight":0,"uptime":0}`)}
+		data, _ := json.Marshal(envelope)
+		conn.Write(append(data, '\n'))
+	}
+	expectRejection := func(what string) *ErrorBody {
+		t.Helper()
+		conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+		line, err := reader.ReadBytes('\n')
+		if err != nil {
+			t.Fatalf("%s: expected an error, got %v", what, err)
+		}
+		_, err = ParseResponse(line)
+		var errBody *ErrorBody
+		if !errors.As(err, &errBody) || errBody.Code != CodePayloadTooLarge {
+			t.Fatalf("%s: expected payload_too_large, got %v", what, err)
+		}
+		return errBody
+	}
+
+	// Over the heartbeat limit, though under the overall one
+	send(strings.Repeat("w", 300))
+	if body := expectRejection("Oversized heartbeat"); body.Details["limit"] != float64(200) || body.Details["type"] != "heartbeat" {
+		t.Errorf("Expected the heartbeat limit in the details, got %v", body.Details)
+	}
+	// Over every limit, refused before its type is read
+	send(strings.Repeat("w", 5000))
+	if body := expectRejection("Oversized envelope"); body.Ref != "" || body.Details["limit"] != float64(4096) {
+		t.Errorf("Expected the overall limit without a ref, got %+v", body)
+	}
+
+	send("worker")
+	select {
+	case envelope := <-handled:
+		if envelope.Agent != "worker" {
+			t.Errorf("Oversized heartbeat reached its handler")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Heartbeat under the limit was not handled")
+	}
+}
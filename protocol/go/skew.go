@@ -0,0 +1,68 @@
+package protocol
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Default bounds on how far an envelope's ts may be from the receiver's clock
+const (
+	DefaultMaxEnvelopeAge = 5 * time.Minute
+	DefaultMaxFutureSkew  = 30 * time.Second
+)
+
+// ErrClockSkew is returned when an envelope's ts is too old or too far in the future
+var ErrClockSkew = errors.New("envelope timestamp outside accepted window")
+
+// SkewPolicy bounds the age of incoming envelopes so that captured envelopes
+// cannot be replayed indefinitely and future-dated ones cannot outlive nonce
+// caches. A zero bound disables that side of the check.
+type SkewPolicy struct {
+	MaxAge    time.Duration // How far in the past ts may be
+	MaxFuture time.Duration // How far in the future ts may be
+}
+
+// DefaultSkewPolicy accepts envelopes up to five minutes old and thirty seconds ahead
+func DefaultSkewPolicy() SkewPolicy {
+	return SkewPolicy{MaxAge: DefaultMaxEnvelopeAge, MaxFuture: DefaultMaxFutureSkew}
+}
+
+// ClockSkewError reports an envelope rejected for its timestamp
+type ClockSkewError struct {
+	TS         int64         // The envelope's ts, in Unix milliseconds
+	ServerTime int64         // The receiver's clock, in Unix milliseconds
+	Skew       time.Duration // ts minus the receiver's clock; negative for old envelopes
+	Policy     SkewPolicy
+}
+
+func (e *ClockSkewError) Error() string {
+	if e.Skew < 0 {
+		return fmt.Sprintf("%v: ts is %v old, limit is %v", ErrClockSkew, -e.Skew, e.Policy.MaxAge)
+	}
+	return fmt.Sprintf("%v: ts is %v in the future, limit is %v", ErrClockSkew, e.Skew, e.Policy.MaxFuture)
+}
+
+// Unwrap lets errors.Is match ErrClockSkew
+func (e *ClockSkewError) Unwrap() error {
+	return ErrClockSkew
+}
+
+// Details describes the rejection for an error envelope, so senders can correct their clocks
+func (e *ClockSkewError) Details() map[string]interface{} {
+	return map[string]interface{}{
+		"ts":         e.TS,
+		"serverTime": e.ServerTime,
+		"maxAge":     Duration(e.Policy.MaxAge),
+		"maxFuture":  Duration(e.Policy.MaxFuture),
+	}
+}
+
+// Check returns a *ClockSkewError when ts, in Unix milliseconds, is outside the policy's window around now
+func (p SkewPolicy) Check(ts int64, now time.Time) error {
+	skew := time.UnixMilli(ts).Sub(now)
+	if (p.MaxAge > 0 && skew < -p.MaxAge) || (p.MaxFuture > 0 && skew > p.MaxFuture) {
+		return &ClockSkewError{TS: ts, ServerTime: now.UnixMilli(), Skew: skew, Policy: p}
+	}
+	return nil
+}
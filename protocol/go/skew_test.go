@@ -0,0 +1,71 @@
+package protocol
+
+import (
+	"encoding/json"
+	"errors"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestSkewPolicyCheck(t *testing.T) {
+	now := time.Now()
+	policy := DefaultSkewPolicy()
+
+	if err := policy.Check(now.Add(-4*time.Minute).UnixMilli(), now); err != nil {
+		t.Errorf("Envelope within the age limit rejected: %v", err)
+	}
+	if err := policy.Check(now.Add(20*time.Second).UnixMilli(), now); err != nil {
+		t.Errorf("Envelope within the future limit rejected: %v", err)
+	}
+
+	err := policy.Check(now.Add(-10*time.Minute).UnixMilli(), now)
+	var skewErr *ClockSkewError
+	if !errors.Is(err, ErrClockSkew) || !errors.As(err, &skewErr) || skewErr.Skew > -10*time.Minute+time.Millisecond {
+		t.Fatalf("Stale envelope should be rejected, got %v", err)
+	}
+	if details := skewErr.Details(); details["serverTime"] != now.UnixMilli() {
+		t.Errorf("Details should report the receiver's clock, got %v", details)
+	}
+	if err := policy.Check(now.Add(time.Minute).UnixMilli(), now); !errors.Is(err, ErrClockSkew) {
+		t.Errorf("Future-dated envelope should be rejected, got %v", err)
+	}
+
+	if err := (SkewPolicy{}).Check(0, now); err != nil {
+		t.Errorf("A zero policy should accept everything, got %v", err)
+	}
+}
+
+func TestTransportDropsSkewedEnvelopes(t *testing.T) {
+	transport, err := NewTransport(nil)
+	if err != nil {
+		t.Fatalf("Failed to create transport: %v", err)
+	}
+	received := make(chan string, 2)
+	transport.RegisterHandler(EnvelopeEmitEvent, func(envelope *Envelope, conn net.Conn) error {
+		received <- envelope.Nonce
+		return nil
+	})
+
+	clientConn, serverConn := net.Pipe()
+	defer clientConn.Close()
+	go transport.handleConnection(serverConn)
+
+	for nonce, ts := range map[string]time.Time{"stale": time.Now().Add(-time.Hour), "future": time.Now().Add(time.Hour)} {
+		envelope := &Envelope{Type: EnvelopeEmitEvent, CommonHeaders: CommonHeaders{Agent: "a", TS: ts.UnixMilli(), Nonce: nonce}, Body: json.RawMessage(`{"event":"ci.build"}`)}
+		data, _ := json.Marshal(envelope)
+		clientConn.Write(append(data, '\n'))
+	}
+	fresh := &Envelope{Type: EnvelopeEmitEvent, CommonHeaders: CommonHeaders{Agent: "a", TS: time.Now().UnixMilli(), Nonce: "fresh"}, Body: json.RawMessage(`{"event":"ci.build"}`)}
+	data, _ := json.Marshal(fresh)
+	clientConn.Write(append(data, '\n'))
+
+	select {
+	case nonce := <-received:
+		if nonce != "fresh" {
+			t.Errorf("Skewed envelope %q reached the handler", nonce)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Fresh envelope was not delivered")
+	}
+}
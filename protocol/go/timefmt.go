@@ -0,0 +1,132 @@
+package protocol
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// Duration is a time.Duration that encodes in JSON as a human-readable string
+// such as "1.5s". It also decodes plain numbers as nanoseconds, the encoding
+// of a bare time.Duration.
+type Duration time.Duration
+
+// Std returns the duration as a time.Duration
+func (d Duration) Std() time.Duration {
+	return time.Duration(d)
+}
+
+func (d Duration) String() string {
+	return time.Duration(d).String()
+}
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	if len(data) > 0 && data[0] == '"' {
+		var s string
+		if err := json.Unmarshal(data, &s); err != nil {
+			return err
+		}
+		parsed, err := time.ParseDuration(s)
+		if err != nil {
+			return fmt.Errorf("invalid duration %q: %w", s, err)
+		}
+		*d = Duration(parsed)
+		return nil
+	}
+
+	var nanos int64
+	if err := json.Unmarshal(data, &nanos); err != nil {
+		return fmt.Errorf("invalid duration %s: %w", data, err)
+	}
+	*d = Duration(nanos)
+	return nil
+}
+
+// Time is a time.Time that encodes in JSON as an RFC 3339 string in UTC with
+// millisecond precision, and the zero time as null. It also decodes plain
+// numbers as Unix milliseconds, the encoding used by envelope headers.
+type Time time.Time
+
+// Std returns the time as a time.Time
+func (t Time) Std() time.Time {
+	return time.Time(t)
+}
+
+// IsZero reports whether t is the zero time
+func (t Time) IsZero() bool {
+	return time.Time(t).IsZero()
+}
+
+func (t Time) String() string {
+	return time.Time(t).String()
+}
+
+// timeLayout is RFC 3339 with exactly three fractional digits
+const timeLayout = "2006-01-02T15:04:05.000Z07:00"
+
+func (t Time) MarshalJSON() ([]byte, error) {
+	if t.IsZero() {
+		return []byte("null"), nil
+	}
+	return json.Marshal(time.Time(t).UTC().Format(timeLayout))
+}
+
+func (t *Time) UnmarshalJSON(data []byte) error {
+	if bytes.Equal(data, []byte("null")) {
+		*t = Time{}
+		return nil
+	}
+	if len(data) > 0 && data[0] == '"' {
+		var s string
+		if err := json.Unmarshal(data, &s); err != nil {
+			return err
+		}
+		parsed, err := time.Parse(time.RFC3339Nano, s)
+		if err != nil {
+			return fmt.Errorf("invalid time %q: %w", s, err)
+		}
+		*t = Time(parsed)
+		return nil
+	}
+
+	var millis int64
+	if err := json.Unmarshal(data, &millis); err != nil {
+		return fmt.Errorf("invalid time %s: %w", data, err)
+	}
+	*t = Time(UnixMilliTime(millis))
+	return nil
+}
+
+// UnixMilliTime converts a Unix millisecond timestamp to a time.Time, mapping
+// 0 to the zero time
+func UnixMilliTime(millis int64) time.Time {
+	if millis == 0 {
+		return time.Time{}
+	}
+	return time.UnixMilli(millis)
+}
+
+// LastSeenTime returns LastSeen as a time.Time
+func (m ToolMetadata) LastSeenTime() time.Time {
+	return UnixMilliTime(m.LastSeen)
+}
+
+// LastErrorTime returns LastErrorAt as a time.Time
+func (m ToolMetadata) LastErrorTime() time.Time {
+	return UnixMilliTime(m.LastErrorAt)
+}
+
+// AverageResponse returns AverageResponseTime as a time.Duration
+func (m ToolMetadata) AverageResponse() time.Duration {
+	return time.Duration(m.AverageResponseTime) * time.Millisecond
+}
+
+// P95Response returns P95ResponseTime as a time.Duration
+func (m ToolMetadata) P95Response() time.Duration {
+	return time.Duration(m.P95ResponseTime) * time.Millisecond
+}
@@ -0,0 +1,69 @@
+package protocol
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func TestDurationJSON(t *testing.T) {
+	data, err := json.Marshal(struct {
+		Latency Duration `json:"latency"`
+	}{Duration(1500 * time.Millisecond)})
+	if err != nil {
+		t.Fatalf("Failed to marshal: %v", err)
+	}
+	if string(data) != `{"latency":"1.5s"}` {
+		t.Errorf("Expected human-readable duration, got %s", data)
+	}
+
+	for input, want := range map[string]time.Duration{
+		`"150ms"`:    150 * time.Millisecond,
+		`"2m0s"`:     2 * time.Minute,
+		`1000000000`: time.Second, // Legacy nanosecond encoding
+	} {
+		var d Duration
+		if err := json.Unmarshal([]byte(input), &d); err != nil {
+			t.Errorf("Failed to unmarshal %s: %v", input, err)
+		} else if d.Std() != want {
+			t.Errorf("Unmarshal %s: expected %v, got %v", input, want, d)
+		}
+	}
+
+	var d Duration
+	if err := json.Unmarshal([]byte(`"soon"`), &d); err == nil {
+		t.Error("Expected error for unparseable duration")
+	}
+}
+
+func TestTimeJSON(t *testing.T) {
+	at := time.Date(2024, 3, 1, 12, 30, 0, 123456789, time.FixedZone("CET", 3600))
+	data, _ := json.Marshal(Time(at))
+	if string(data) != `"2024-03-01T11:30:00.123Z"` {
+		t.Errorf("Expected UTC RFC 3339 with milliseconds, got %s", data)
+	}
+	if data, _ := json.Marshal(Time{}); string(data) != "null" {
+		t.Errorf("Expected zero time as null, got %s", data)
+	}
+
+	var parsed Time
+	if err := json.Unmarshal([]byte(`"2024-03-01T11:30:00.123Z"`), &parsed); err != nil || !parsed.Std().Equal(at.Truncate(time.Millisecond)) {
+		t.Errorf("Round trip failed: %v, %v", parsed, err)
+	}
+	if err := json.Unmarshal([]byte(`1709292600123`), &parsed); err != nil || !parsed.Std().Equal(at.Truncate(time.Millisecond)) {
+		t.Errorf("Unix milliseconds not accepted: %v, %v", parsed, err)
+	}
+	if err := json.Unmarshal([]byte(`null`), &parsed); err != nil || !parsed.IsZero() {
+		t.Errorf("Expected null to decode as zero time, got %v, %v", parsed, err)
+	}
+}
+
+func TestToolMetadataAccessors(t *testing.T) {
+	metadata := ToolMetadata{LastSeen: 1709292600123, AverageResponseTime: 150, P95ResponseTime: 400}
+	if metadata.AverageResponse() != 150*time.Millisecond || metadata.P95Response() != 400*time.Millisecond {
+		t.Errorf("Unexpected durations: %v, %v", metadata.AverageResponse(), metadata.P95Response())
+	}
+	if metadata.LastSeenTime().UnixMilli() != 1709292600123 || !metadata.LastErrorTime().IsZero() {
+		t.Errorf("Unexpected times: %v, %v", metadata.LastSeenTime(), metadata.LastErrorTime())
+	}
+}
@@ -0,0 +1,155 @@
+package protocol
+
+import (
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"time"
+)
+
+// TimestampToken is third-party evidence that an envelope existed at a point in time
+type TimestampToken struct {
+	Authority string `json:"authority"` // Identifier or URL of the timestamping authority
+	Kind      string `json:"kind"`      // Evidence format, e.g. "ed25519" or "rfc3161"
+	Digest    string `json:"digest"`    // "<alg>=<base64>" digest that was timestamped
+	Time      int64  `json:"time"`      // Asserted time in Unix milliseconds
+	Token     string `json:"token"`     // Base64 authority-specific evidence
+}
+
+// TimestampAuthority issues and verifies timestamp tokens over digests
+type TimestampAuthority interface {
+	Timestamp(digest []byte) (*TimestampToken, error)
+	VerifyTimestamp(token *TimestampToken, digest []byte) error
+}
+
+// Timestamp kinds
+const (
+	TimestampKindEd25519 = "ed25519"
+	TimestampKindRFC3161 = "rfc3161"
+)
+
+// TimestampEnvelope obtains a timestamp over the envelope's signing bytes and
+// attaches it to the envelope. The envelope should already be signed.
+func (e *Envelope) TimestampEnvelope(tsa TimestampAuthority) error {
+	return timestampHeaders(e.Type, &e.CommonHeaders, e.Body, tsa)
+}
+
+// TimestampEnvelope obtains a timestamp over the envelope's signing bytes
+func (g *GenericEnvelope) TimestampEnvelope(tsa TimestampAuthority) error {
+	return timestampHeaders(g.Type, &g.CommonHeaders, g.Body, tsa)
+}
+
+// VerifyTimestamp checks the attached timestamp against the envelope contents
+func (g *GenericEnvelope) VerifyTimestamp(tsa TimestampAuthority) error {
+	if g.Timestamp == nil {
+		return fmt.Errorf("envelope has no timestamp")
+	}
+	digest, err := envelopeDigest(g.Type, g.CommonHeaders, g.Body)
+	if err != nil {
+		return err
+	}
+	return tsa.VerifyTimestamp(g.Timestamp, digest)
+}
+
+// TimestampCapability obtains a timestamp over an issued capability token
+func TimestampCapability(token string, tsa TimestampAuthority) (*TimestampToken, error) {
+	digest := sha256.Sum256([]byte(token))
+	return tsa.Timestamp(digest[:])
+}
+
+func timestampHeaders(envType EnvelopeType, headers *CommonHeaders, body interface{}, tsa TimestampAuthority) error {
+	digest, err := envelopeDigest(envType, *headers, body)
+	if err != nil {
+		return err
+	}
+	token, err := tsa.Timestamp(digest)
+	if err != nil {
+		return fmt.Errorf("timestamping failed: %w", err)
+	}
+	headers.Timestamp = token
+	return nil
+}
+
+// envelopeDigest hashes the canonical signing bytes of an envelope
+func envelopeDigest(envType EnvelopeType, headers CommonHeaders, body interface{}) ([]byte, error) {
+	data, err := SigningBytes(envType, headers, body)
+	if err != nil {
+		return nil, err
+	}
+	digest := sha256.Sum256(data)
+	return digest[:], nil
+}
+
+// Ed25519TimestampAuthority is a timestamping authority that signs digests
+// with its own Ed25519 key. Run by an independent party, its tokens can be
+// verified by anyone holding its public key.
+type Ed25519TimestampAuthority struct {
+	ID        string
+	PublicKey ed25519.PublicKey
+	signer    Signer
+	now       func() time.Time
+}
+
+// NewEd25519TimestampAuthority creates an authority that can issue timestamps
+func NewEd25519TimestampAuthority(id string, signer Signer) *Ed25519TimestampAuthority {
+	return &Ed25519TimestampAuthority{
+		ID:        id,
+		PublicKey: signer.Public().(ed25519.PublicKey),
+		signer:    signer,
+		now:       time.Now,
+	}
+}
+
+// NewEd25519TimestampVerifier creates a verify-only authority from its public key
+func NewEd25519TimestampVerifier(id string, publicKey ed25519.PublicKey) *Ed25519TimestampAuthority {
+	return &Ed25519TimestampAuthority{ID: id, PublicKey: publicKey, now: time.Now}
+}
+
+// Timestamp signs the digest together with the current time
+func (a *Ed25519TimestampAuthority) Timestamp(digest []byte) (*TimestampToken, error) {
+	if a.signer == nil {
+		return nil, fmt.Errorf("timestamp authority %s cannot issue tokens", a.ID)
+	}
+
+	ts := a.now().UnixMilli()
+	signature, err := signMessage(a.signer, ed25519TimestampMessage(digest, ts))
+	if err != nil {
+		return nil, err
+	}
+	return &TimestampToken{
+		Authority: a.ID,
+		Kind:      TimestampKindEd25519,
+		Digest:    DigestSHA256 + "=" + base64.StdEncoding.EncodeToString(digest),
+		Time:      ts,
+		Token:     base64.StdEncoding.EncodeToString(signature),
+	}, nil
+}
+
+// VerifyTimestamp checks that the token was issued by this authority over the digest
+func (a *Ed25519TimestampAuthority) VerifyTimestamp(token *TimestampToken, digest []byte) error {
+	if token.Kind != TimestampKindEd25519 || token.Authority != a.ID {
+		return fmt.Errorf("timestamp was not issued by %s", a.ID)
+	}
+	if token.Digest != DigestSHA256+"="+base64.StdEncoding.EncodeToString(digest) {
+		return fmt.Errorf("timestamp digest mismatch")
+	}
+
+	signature, err := base64.StdEncoding.DecodeString(token.Token)
+	if err != nil {
+		return fmt.Errorf("invalid timestamp token encoding: %w", err)
+	}
+	if !ed25519.Verify(a.PublicKey, ed25519TimestampMessage(digest, token.Time), signature) {
+		return fmt.Errorf("timestamp signature verification failed")
+	}
+	return nil
+}
+
+// ed25519TimestampMessage is the byte string signed by an Ed25519 authority
+func ed25519TimestampMessage(digest []byte, ts int64) []byte {
+	msg := make([]byte, 0, len("fem-tsa-v1")+len(digest)+8)
+	msg = append(msg, "fem-tsa-v1"...)
+	msg = append(msg, digest...)
+	return binary.BigEndian.AppendUint64(msg, uint64(ts))
+}
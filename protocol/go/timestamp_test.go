@@ -0,0 +1,277 @@
+package protocol
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestEd25519TimestampAuthority(t *testing.T) {
+	_, agentPriv, _ := GenerateKeyPair()
+	tsaPub, tsaPriv, err := GenerateKeyPair()
+	if err != nil {
+		t.Fatalf("Failed to generate key pair: %v", err)
+	}
+	tsa := NewEd25519TimestampAuthority("tsa.example", tsaPriv)
+
+	envelope := &RevokeEnvelope{
+		BaseEnvelope: BaseEnvelope{
+			Type: EnvelopeRevoke,
+			CommonHeaders: CommonHeaders{
+				Agent: "admin",
+				TS:    time.Now().UnixMilli(),
+				Nonce: "revoke-ts",
+			},
+		},
+		Body: RevokeBody{Target: "rogue-agent", Reason: "compromised"},
+	}
+	if err := envelope.CoSign("admin", agentPriv); err != nil {
+		t.Fatalf("Failed to sign: %v", err)
+	}
+
+	generic, err := ParseEnvelope(mustMarshal(t, envelope))
+	if err != nil {
+		t.Fatalf("Failed to parse: %v", err)
+	}
+	if err := generic.TimestampEnvelope(tsa); err != nil {
+		t.Fatalf("Failed to timestamp: %v", err)
+	}
+
+	// Timestamps survive a round trip and verify with only the public key
+	stamped, err := ParseEnvelope(mustMarshal(t, generic))
+	if err != nil {
+		t.Fatalf("Failed to parse: %v", err)
+	}
+	verifier := NewEd25519TimestampVerifier("tsa.example", tsaPub)
+	if err := stamped.VerifyTimestamp(verifier); err != nil {
+		t.Errorf("Timestamp should verify: %v", err)
+	}
+	if _, err := verifier.Timestamp([]byte("digest")); err == nil {
+		t.Error("Verify-only authority should not issue timestamps")
+	}
+
+	// Shifting the asserted time breaks the token
+	stamped.Timestamp.Time -= 60000
+	if err := stamped.VerifyTimestamp(verifier); err == nil {
+		t.Error("Expected backdated timestamp to fail verification")
+	}
+
+	// The timestamp covers the envelope contents
+	tampered, _ := ParseEnvelope(mustMarshal(t, generic))
+	tampered.Body = json.RawMessage(`{"target":"someone-else"}`)
+	if err := tampered.VerifyTimestamp(verifier); err == nil {
+		t.Error("Expected timestamp to fail for a modified body")
+	}
+}
+
+func TestRFC3161Authority(t *testing.T) {
+	genTime := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	authority, impostor := newTestTSA(t), newTestTSA(t)
+	signer := authority
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Content-Type") != "application/timestamp-query" {
+			http.Error(w, "bad content type", http.StatusBadRequest)
+			return
+		}
+		data, _ := io.ReadAll(r.Body)
+		var req timeStampReq
+		if _, err := asn1.Unmarshal(data, &req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		w.Header().Set("Content-Type", "application/timestamp-reply")
+		w.Write(signer.response(t, req, genTime))
+	}))
+	defer server.Close()
+
+	tsa := NewRFC3161Authority(server.URL, authority.cert)
+
+	envelope := NewEnvelope(EnvelopeRevoke, "admin")
+	envelope.Body = json.RawMessage(`{"target":"rogue-agent"}`)
+	if err := envelope.TimestampEnvelope(tsa); err != nil {
+		t.Fatalf("Failed to timestamp: %v", err)
+	}
+	if envelope.Timestamp.Kind != TimestampKindRFC3161 || envelope.Timestamp.Time != genTime.UnixMilli() {
+		t.Errorf("Unexpected token: %+v", envelope.Timestamp)
+	}
+
+	generic, err := ParseEnvelope(mustMarshal(t, envelope))
+	if err != nil {
+		t.Fatalf("Failed to parse: %v", err)
+	}
+	if err := generic.VerifyTimestamp(tsa); err != nil {
+		t.Errorf("Timestamp should verify: %v", err)
+	}
+
+	generic.Body = json.RawMessage(`{"target":"someone-else"}`)
+	if err := generic.VerifyTimestamp(tsa); err == nil {
+		t.Error("Expected timestamp to fail for a modified body")
+	}
+
+	// Tokens must be signed by the configured authority
+	original, _ := ParseEnvelope(mustMarshal(t, envelope))
+	if err := original.VerifyTimestamp(NewRFC3161Authority(server.URL, impostor.cert)); err == nil {
+		t.Error("Expected a token to fail verification against another authority's certificate")
+	}
+	if err := original.VerifyTimestamp(NewRFC3161Authority(server.URL, nil)); err == nil {
+		t.Error("Expected verification without a certificate to fail")
+	}
+	signer = impostor
+	if err := NewEnvelope(EnvelopeRevoke, "admin").TimestampEnvelope(tsa); err == nil {
+		t.Error("Expected a token signed by another key to be refused")
+	}
+
+	// A made-up TSTInfo cannot reuse a genuine signature
+	forged := *envelope.Timestamp
+	der, _ := base64.StdEncoding.DecodeString(forged.Token)
+	stamp := []byte(genTime.Format("20060102150405Z"))
+	moved := []byte(genTime.Add(-24 * time.Hour).Format("20060102150405Z"))
+	forged.Token = base64.StdEncoding.EncodeToString(bytes.Replace(der, stamp, moved, 1))
+	forged.Time = genTime.Add(-24 * time.Hour).UnixMilli()
+	digest, _ := envelopeDigest(envelope.Type, envelope.CommonHeaders, envelope.Body)
+	if err := tsa.VerifyTimestamp(&forged, digest); err == nil {
+		t.Error("Expected a backdated TSTInfo to fail verification")
+	}
+}
+
+func TestRFC3161AuthorityRejection(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		resp, _ := asn1.Marshal(timeStampResp{Status: pkiStatusInfo{Status: 2}})
+		w.Write(resp)
+	}))
+	defer server.Close()
+
+	if _, err := NewRFC3161Authority(server.URL, nil).Timestamp(make([]byte, 32)); err == nil {
+		t.Error("Expected rejected request to return an error")
+	}
+}
+
+// testTSA is a timestamping authority's certificate and key
+type testTSA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+}
+
+func newTestTSA(t *testing.T) testTSA {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed to generate TSA key: %v", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(7),
+		Subject:      pkix.Name{CommonName: "test-tsa"},
+		NotBefore:    time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC),
+		NotAfter:     time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageTimeStamping},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("Failed to create TSA certificate: %v", err)
+	}
+	cert, _ := x509.ParseCertificate(der)
+	return testTSA{cert: cert, key: key}
+}
+
+// response builds a TimeStampResp whose token is signed as RFC 3161 requires
+func (tsa testTSA) response(t *testing.T, req timeStampReq, genTime time.Time) []byte {
+	t.Helper()
+
+	info, err := asn1.Marshal(tstInfo{
+		Version:        1,
+		Policy:         asn1.ObjectIdentifier{1, 2, 3, 4},
+		MessageImprint: req.MessageImprint,
+		SerialNumber:   big.NewInt(42),
+		GenTime:        genTime,
+		Nonce:          req.Nonce,
+	})
+	if err != nil {
+		t.Fatalf("Failed to marshal TSTInfo: %v", err)
+	}
+
+	// Signed attributes bind the content type and digest of the TSTInfo
+	contentDigest := sha256.Sum256(info)
+	contentType, _ := asn1.Marshal(oidTSTInfo)
+	messageDigest, _ := asn1.Marshal(contentDigest[:])
+	attrs := append(
+		derElement(asn1.ClassUniversal, asn1.TagSequence, mustASN1(t, oidContentType), derElement(asn1.ClassUniversal, asn1.TagSet, contentType)),
+		derElement(asn1.ClassUniversal, asn1.TagSequence, mustASN1(t, oidMessageDigest), derElement(asn1.ClassUniversal, asn1.TagSet, messageDigest))...,
+	)
+	signedDigest := sha256.Sum256(derElement(asn1.ClassUniversal, asn1.TagSet, attrs))
+	signature, err := ecdsa.SignASN1(rand.Reader, tsa.key, signedDigest[:])
+	if err != nil {
+		t.Fatalf("Failed to sign: %v", err)
+	}
+
+	sid := derElement(asn1.ClassUniversal, asn1.TagSequence, tsa.cert.RawIssuer, mustASN1(t, tsa.cert.SerialNumber))
+	signerInfo := derElement(asn1.ClassUniversal, asn1.TagSequence,
+		mustASN1(t, 1),
+		sid,
+		mustASN1(t, pkix.AlgorithmIdentifier{Algorithm: oidSHA256}),
+		derElement(asn1.ClassContextSpecific, 0, attrs),
+		mustASN1(t, pkix.AlgorithmIdentifier{Algorithm: asn1.ObjectIdentifier{1, 2, 840, 10045, 4, 3, 2}}),
+		mustASN1(t, signature),
+	)
+	sd := derElement(asn1.ClassUniversal, asn1.TagSequence,
+		mustASN1(t, 3),
+		derElement(asn1.ClassUniversal, asn1.TagSet, mustASN1(t, pkix.AlgorithmIdentifier{Algorithm: oidSHA256})),
+		mustASN1(t, encapsulatedContentInfo{EContentType: oidTSTInfo, EContent: info}),
+		derElement(asn1.ClassContextSpecific, 0, tsa.cert.Raw),
+		derElement(asn1.ClassUniversal, asn1.TagSet, signerInfo),
+	)
+
+	token, err := asn1.Marshal(contentInfo{
+		ContentType: oidSignedData,
+		Content:     asn1.RawValue{FullBytes: derElement(asn1.ClassContextSpecific, 0, sd)},
+	})
+	if err != nil {
+		t.Fatalf("Failed to marshal ContentInfo: %v", err)
+	}
+
+	resp, err := asn1.Marshal(timeStampResp{
+		Status:         pkiStatusInfo{Status: 0},
+		TimeStampToken: asn1.RawValue{FullBytes: token},
+	})
+	if err != nil {
+		t.Fatalf("Failed to marshal TimeStampResp: %v", err)
+	}
+	return resp
+}
+
+// derElement encodes a constructed element around already encoded contents
+func derElement(class, tag int, contents ...[]byte) []byte {
+	der, _ := asn1.Marshal(asn1.RawValue{Class: class, Tag: tag, IsCompound: true, Bytes: bytes.Join(contents, nil)})
+	return der
+}
+
+func mustASN1(t *testing.T, v interface{}) []byte {
+	t.Helper()
+	der, err := asn1.Marshal(v)
+	if err != nil {
+		t.Fatalf("Failed to marshal %T: %v", v, err)
+	}
+	return der
+}
+
+func mustMarshal(t *testing.T, v interface{}) []byte {
+	t.Helper()
+	data, err := json.Marshal(v)
+	if err != nil {
+		t.Fatalf("Failed to marshal: %v", err)
+	}
+	return data
+}
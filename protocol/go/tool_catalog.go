@@ -0,0 +1,63 @@
+package protocol
+
+import (
+	"crypto/ed25519"
+	"encoding/base64"
+	"fmt"
+)
+
+// ToolCatalogPath is where brokers serve their signed catalog feed
+const ToolCatalogPath = "/federation/catalog"
+
+// ToolCatalog is a broker's marketplace feed: the tools its agents chose to
+// list publicly, with their price in each tool's cost and the broker's trust
+// metrics for their agents in the metadata. Other federations import the
+// listings as references to tools reached through the publishing broker.
+type ToolCatalog struct {
+	Broker string           `json:"broker"` // ID of the publishing broker
+	PubKey string           `json:"pubkey"` // Base64 Ed25519 identity key the feed is signed with
+	Issued int64            `json:"issued"` // Unix milliseconds
+	Tools  []DiscoveredTool `json:"tools"`  // Listed tools, by providing agent
+	Sig    string           `json:"sig"`    // Base64 Ed25519 signature by the broker
+}
+
+// Sign signs the catalog with the publishing broker's key
+func (c *ToolCatalog) Sign(signer Signer) error {
+	message, err := c.signingBytes()
+	if err != nil {
+		return err
+	}
+	signature, err := signMessage(signer, message)
+	if err != nil {
+		return err
+	}
+	c.Sig = base64.StdEncoding.EncodeToString(signature)
+	return nil
+}
+
+// Verify checks the catalog signature against the publishing broker's key
+func (c *ToolCatalog) Verify(publicKey ed25519.PublicKey) error {
+	signature, err := base64.StdEncoding.DecodeString(c.Sig)
+	if err != nil {
+		return fmt.Errorf("invalid catalog signature encoding: %w", err)
+	}
+	message, err := c.signingBytes()
+	if err != nil {
+		return err
+	}
+	if !ed25519.Verify(publicKey, message, signature) {
+		return fmt.Errorf("catalog signature verification failed")
+	}
+	return nil
+}
+
+// signingBytes is the canonical JSON of the catalog without its signature
+func (c *ToolCatalog) signingBytes() ([]byte, error) {
+	unsigned := *c
+	unsigned.Sig = ""
+	canonical, err := MarshalCanonical(unsigned)
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte("fem-catalog-v1\n"), canonical...), nil
+}
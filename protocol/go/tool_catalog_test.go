@@ -0,0 +1,42 @@
+package protocol
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestToolCatalogSignature(t *testing.T) {
+	pubKey, privKey, _ := GenerateKeyPair()
+	catalog := &ToolCatalog{
+		Broker: "broker-a",
+		PubKey: EncodePublicKey(pubKey),
+		Issued: 1700000000000,
+		Tools: []DiscoveredTool{{
+			AgentID:  "acme.translator",
+			MCPTools: []MCPTool{{Name: "text.translate", Listed: true, Cost: &ToolCost{PerCall: 0.01}}},
+			Metadata: ToolMetadata{TrustScore: 0.9, SuccessRate: 0.99},
+		}},
+	}
+	if err := catalog.Sign(privKey); err != nil {
+		t.Fatalf("Failed to sign catalog: %v", err)
+	}
+
+	// The signature survives a round trip through JSON
+	data, _ := json.Marshal(catalog)
+	var received ToolCatalog
+	if err := json.Unmarshal(data, &received); err != nil {
+		t.Fatal(err)
+	}
+	if err := received.Verify(pubKey); err != nil {
+		t.Fatalf("Expected the catalog to verify: %v", err)
+	}
+
+	received.Tools[0].MCPTools[0].Cost.PerCall = 0
+	if err := received.Verify(pubKey); err == nil {
+		t.Error("Expected a repriced listing to fail verification")
+	}
+	otherKey, _, _ := GenerateKeyPair()
+	if err := catalog.Verify(otherKey); err == nil {
+		t.Error("Expected another key to fail verification")
+	}
+}
@@ -0,0 +1,70 @@
+package protocol
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Event topics are dot-separated hierarchical names such as
+// "prod.ci.build.finished". Topic patterns may use "*" as a whole segment: in
+// the middle of a pattern it matches exactly one segment, and at the end it
+// matches one or more, so "prod.ci.*" matches "prod.ci.build.finished" and
+// "prod.*.build.finished" matches "prod.ci.build.finished".
+
+// ValidateTopic checks a topic events are published to
+func ValidateTopic(topic string) error {
+	if err := validateTopicSegments(topic); err != nil {
+		return err
+	}
+	if strings.Contains(topic, "*") {
+		return fmt.Errorf("topic %q contains a wildcard", topic)
+	}
+	return nil
+}
+
+// ValidateTopicPattern checks a topic pattern used to subscribe or grant rights
+func ValidateTopicPattern(pattern string) error {
+	if err := validateTopicSegments(pattern); err != nil {
+		return err
+	}
+	for _, segment := range strings.Split(pattern, ".") {
+		if segment != "*" && strings.Contains(segment, "*") {
+			return fmt.Errorf("pattern %q may only use * as a whole segment", pattern)
+		}
+	}
+	return nil
+}
+
+func validateTopicSegments(topic string) error {
+	if topic == "" {
+		return fmt.Errorf("empty topic")
+	}
+	if strings.ContainsAny(topic, " \t\r\n") {
+		return fmt.Errorf("topic %q contains whitespace", topic)
+	}
+	for _, segment := range strings.Split(topic, ".") {
+		if segment == "" {
+			return fmt.Errorf("topic %q has an empty segment", topic)
+		}
+	}
+	return nil
+}
+
+// MatchTopic reports whether topic matches pattern. A pattern also matches
+// narrower patterns, so MatchTopic("prod.*", "prod.ci.*") is true.
+func MatchTopic(pattern, topic string) bool {
+	patternSegments := strings.Split(pattern, ".")
+	topicSegments := strings.Split(topic, ".")
+	for i, segment := range patternSegments {
+		if i >= len(topicSegments) {
+			return false
+		}
+		if segment == "*" && i == len(patternSegments)-1 {
+			return true
+		}
+		if segment != "*" && segment != topicSegments[i] {
+			return false
+		}
+	}
+	return len(patternSegments) == len(topicSegments)
+}
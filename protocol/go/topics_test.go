@@ -0,0 +1,42 @@
+package protocol
+
+import "testing"
+
+func TestMatchTopic(t *testing.T) {
+	tests := []struct {
+		pattern, topic string
+		want           bool
+	}{
+		{"prod.ci.build.finished", "prod.ci.build.finished", true},
+		{"prod.ci.*", "prod.ci.build.finished", true},
+		{"prod.ci.*", "prod.ci.build", true},
+		{"prod.ci.*", "prod.ci", false},
+		{"prod.*.build.finished", "prod.ci.build.finished", true},
+		{"prod.*.build.finished", "prod.ci.nightly.build.finished", false},
+		{"*", "anything.at.all", true},
+		{"prod.ci", "prod.ci.build", false},
+		{"prod.*", "prod.ci.*", true},
+		{"prod.ci.build", "prod.ci.*", false},
+	}
+	for _, tt := range tests {
+		if got := MatchTopic(tt.pattern, tt.topic); got != tt.want {
+			t.Errorf("MatchTopic(%q, %q) = %v, want %v", tt.pattern, tt.topic, got, tt.want)
+		}
+	}
+}
+
+func TestValidateTopics(t *testing.T) {
+	for _, topic := range []string{"", "prod..ci", "prod.ci.*", "prod ci", ".prod"} {
+		if err := ValidateTopic(topic); err == nil {
+			t.Errorf("ValidateTopic(%q) should fail", topic)
+		}
+	}
+	for _, pattern := range []string{"prod.ci*", "prod.*x.build", "prod..*"} {
+		if err := ValidateTopicPattern(pattern); err == nil {
+			t.Errorf("ValidateTopicPattern(%q) should fail", pattern)
+		}
+	}
+	if err := ValidateTopicPattern("prod.*.build.*"); err != nil {
+		t.Errorf("Valid pattern rejected: %v", err)
+	}
+}
@@ -8,21 +8,44 @@ import (
 	"crypto/x509"
 	"crypto/x509/pkix"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"log"
 	"math/big"
 	"net"
 	"sync"
 	"time"
 )
 
+// DefaultMaxEnvelopeSize bounds a single newline-delimited envelope on stream transports
+const DefaultMaxEnvelopeSize = 4 << 20
+
+// ErrEnvelopeTooLarge is returned when an envelope exceeds the configured size limit
+var ErrEnvelopeTooLarge = errors.New("envelope too large")
+
 // Transport handles FEP protocol communication
 type Transport struct {
-	privateKey ed25519.PrivateKey
-	publicKey  ed25519.PublicKey
-	tlsConfig  *tls.Config
-	handlers   map[EnvelopeType]EnvelopeHandler
-	mu         sync.RWMutex
+	privateKey      ed25519.PrivateKey
+	publicKey       ed25519.PublicKey
+	tlsConfig       *tls.Config
+	handlers        map[EnvelopeType]EnvelopeHandler
+	maxEnvelopeSize int
+	typeLimits      map[EnvelopeType]int // Size limits replacing maxEnvelopeSize for some types
+	maxConnections  int
+	skew            SkewPolicy
+	limiter         *RateLimiter       // Nil unless SetRateLimit was called
+	pool            *connPool          // Connections Send keeps open between envelopes
+	proxy           ProxyFunc          // Picks the proxy outgoing connections go through; the environment's if nil
+	compression     *CompressionPolicy // Applied to envelopes sent; nil sends bodies uncompressed
+	mu              sync.RWMutex
+
+	// Serving state, see Serve and Shutdown
+	listeners map[net.Listener]struct{}
+	conns     map[net.Conn]struct{}
+	serving   sync.WaitGroup // Connections being served
+	done      chan struct{}  // Closed by Shutdown
+	closed    bool
 }
 
 // EnvelopeHandler processes incoming envelopes
@@ -37,19 +60,135 @@ func NewTransport(privateKey ed25519.PrivateKey) (*Transport, error) {
 			return nil, err
 		}
 		return &Transport{
-			privateKey: privateKey,
-			publicKey:  publicKey,
-			handlers:   make(map[EnvelopeType]EnvelopeHandler),
+			privateKey:      privateKey,
+			publicKey:       publicKey,
+			handlers:        make(map[EnvelopeType]EnvelopeHandler),
+			maxEnvelopeSize: DefaultMaxEnvelopeSize,
+			skew:            DefaultSkewPolicy(),
+			pool:            newConnPool(DefaultPoolConfig()),
+			done:            make(chan struct{}),
 		}, nil
 	}
 
 	return &Transport{
-		privateKey: privateKey,
-		publicKey:  privateKey.Public().(ed25519.PublicKey),
-		handlers:   make(map[EnvelopeType]EnvelopeHandler),
+		privateKey:      privateKey,
+		publicKey:       privateKey.Public().(ed25519.PublicKey),
+		handlers:        make(map[EnvelopeType]EnvelopeHandler),
+		maxEnvelopeSize: DefaultMaxEnvelopeSize,
+		skew:            DefaultSkewPolicy(),
+		pool:            newConnPool(DefaultPoolConfig()),
+		done:            make(chan struct{}),
 	}, nil
 }
 
+// SetMaxEnvelopeSize sets the largest envelope, in bytes, accepted on a connection
+func (t *Transport) SetMaxEnvelopeSize(size int) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.maxEnvelopeSize = size
+}
+
+// SetSizeLimits sets the largest envelope accepted on a connection, overall
+// and for individual types. Envelopes over their type's limit are answered
+// with a payload_too_large error instead of reaching handlers. A Max of 0
+// keeps DefaultMaxEnvelopeSize, since reads are always bounded.
+func (t *Transport) SetSizeLimits(limits SizeLimits) {
+	if limits.Max <= 0 {
+		limits.Max = DefaultMaxEnvelopeSize
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.maxEnvelopeSize = limits.Max
+	t.typeLimits = limits.PerType
+}
+
+// SizeLimits returns the limits set by SetMaxEnvelopeSize and SetSizeLimits
+func (t *Transport) SizeLimits() SizeLimits {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	return SizeLimits{Max: t.maxEnvelopeSize, PerType: t.typeLimits}
+}
+
+// MaxEnvelopeSize returns the largest envelope of any type accepted on a connection
+func (t *Transport) MaxEnvelopeSize() int {
+	return t.SizeLimits().Largest()
+}
+
+// SetMaxConnections bounds the connections served concurrently by Serve (0 = unlimited).
+// When the limit is reached, new connections wait in the listen backlog.
+func (t *Transport) SetMaxConnections(limit int) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.maxConnections = limit
+}
+
+// SetSkewPolicy bounds how old or future-dated an incoming envelope's ts may
+// be. Envelopes outside the window are dropped before reaching handlers.
+func (t *Transport) SetSkewPolicy(policy SkewPolicy) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.skew = policy
+}
+
+// SetRateLimit limits the envelopes accepted from each agent ID and each
+// source IP, over all their connections. Throttled envelopes are answered
+// with a rate_limited error instead of reaching handlers.
+func (t *Transport) SetRateLimit(config RateLimitConfig) {
+	limiter := NewRateLimiter(config)
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.limiter = limiter
+}
+
+// SetCompression compresses the bodies of envelopes sent by Send and by
+// clients using this transport, usually with the policy the broker returned
+// at registration; nil turns compression off. Compressed envelopes are
+// inflated on receipt whatever the policy.
+func (t *Transport) SetCompression(policy *CompressionPolicy) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.compression = policy
+}
+
+// encodeEnvelope serializes a signed envelope for sending
+func (t *Transport) encodeEnvelope(envelope interface{}) ([]byte, error) {
+	data, err := json.Marshal(envelope)
+	if err != nil {
+		return nil, err
+	}
+	t.mu.RLock()
+	policy := t.compression
+	t.mu.RUnlock()
+	return CompressBody(data, policy)
+}
+
+// SetPoolConfig changes how many connections Send keeps open to each
+// endpoint and for how long. Idle connections over the new limit are closed.
+func (t *Transport) SetPoolConfig(config PoolConfig) {
+	t.pool.setConfig(config)
+}
+
+// SetProxy picks the proxy that Send and clients using this transport
+// connect through; nil honors HTTPS_PROXY and NO_PROXY, see ParseProxy
+func (t *Transport) SetProxy(proxy ProxyFunc) {
+	t.mu.Lock()
+	t.proxy = proxy
+	t.mu.Unlock()
+	t.pool.setProxy(proxy)
+}
+
+// dialProxy returns the proxy selector outgoing connections use
+func (t *Transport) dialProxy() ProxyFunc {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	return t.proxy
+}
+
+// CloseIdleConnections closes the connections Send keeps open that are not in use
+func (t *Transport) CloseIdleConnections() {
+	t.pool.closeIdle()
+}
+
 // GenerateSelfSignedCert generates a self-signed certificate for TLS
 func (t *Transport) GenerateSelfSignedCert() error {
 	template := x509.Certificate{
@@ -88,45 +227,75 @@ func (t *Transport) GenerateSelfSignedCert() error {
 	return nil
 }
 
-// Listen starts listening for FEP connections
-func (t *Transport) Listen(address string) error {
-	if t.tlsConfig == nil {
-		if err := t.GenerateSelfSignedCert(); err != nil {
-			return err
-		}
-	}
+// handleConnection handles an incoming connection
+func (t *Transport) handleConnection(conn net.Conn) {
+	defer conn.Close()
 
-	listener, err := tls.Listen("tcp", address, t.tlsConfig)
+	framing, err := NegotiateFraming(conn)
 	if err != nil {
-		return err
+		return
 	}
-	defer listener.Close()
-
+	reader := bufio.NewReader(conn)
+	limits := t.SizeLimits()
+	maxSize := limits.Largest()
 	for {
-		conn, err := listener.Accept()
-		if err != nil {
+		line, err := framing.read(reader, maxSize)
+		if errors.Is(err, ErrEnvelopeTooLarge) {
+			// The oversized line has been discarded, so the next envelope can still be read
+			log.Printf("Dropping envelope from %s: %v", conn.RemoteAddr(), err)
+			tooLarge := &PayloadTooLargeError{Limit: maxSize}
+			if err := t.writeRejection(conn, framing, "", tooLarge.ErrorBody()); err != nil {
+				return
+			}
 			continue
 		}
-		go t.handleConnection(conn)
-	}
-}
-
-// handleConnection handles an incoming connection
-func (t *Transport) handleConnection(conn net.Conn) {
-	defer conn.Close()
+		if err != nil {
+			return
+		}
 
-	scanner := bufio.NewScanner(conn)
-	for scanner.Scan() {
+		if line, err = InflateBody(line); err != nil {
+			log.Printf("Dropping envelope from %s: %v", conn.RemoteAddr(), err)
+			continue
+		}
+		if err := ValidateEnvelope(line); err != nil {
+			log.Printf("Dropping envelope from %s: %v", conn.RemoteAddr(), err)
+			continue
+		}
 		var envelope Envelope
-		if err := json.Unmarshal(scanner.Bytes(), &envelope); err != nil {
+		if err := json.Unmarshal(line, &envelope); err != nil {
+			continue
+		}
+		var tooLarge *PayloadTooLargeError
+		if err := limits.Check(envelope.Type, len(line)); errors.As(err, &tooLarge) {
+			log.Printf("Dropping envelope from %s: %v", conn.RemoteAddr(), err)
+			if err := t.writeRejection(conn, framing, envelope.Nonce, tooLarge.ErrorBody()); err != nil {
+				return
+			}
 			continue
 		}
 
 		// Handle envelope
 		t.mu.RLock()
 		handler, exists := t.handlers[envelope.Type]
+		skew := t.skew
+		limiter := t.limiter
 		t.mu.RUnlock()
 
+		if err := skew.Check(envelope.TS, time.Now()); err != nil {
+			log.Printf("Dropping %s envelope from %s: %v", envelope.Type, conn.RemoteAddr(), err)
+			continue
+		}
+		if limiter != nil {
+			var throttle *ThrottleError
+			if err := limiter.Allow(envelope.Agent, remoteIP(conn)); errors.As(err, &throttle) {
+				log.Printf("Throttling %s envelope from %s: %v", envelope.Type, conn.RemoteAddr(), err)
+				if err := t.writeRejection(conn, framing, envelope.Nonce, throttle.ErrorBody()); err != nil {
+					return
+				}
+				continue
+			}
+		}
+
 		if exists {
 			if err := handler(&envelope, conn); err != nil {
 				// Log error
@@ -136,6 +305,33 @@ func (t *Transport) handleConnection(conn net.Conn) {
 	}
 }
 
+// writeRejection answers the envelope with nonce ref with a signed error
+func (t *Transport) writeRejection(conn net.Conn, framing Framing, ref string, errBody *ErrorBody) error {
+	rejection := NewError(DIDKey(t.publicKey), ref, errBody.Code, errBody.Message)
+	rejection.Body.Details = errBody.Details
+	if err := rejection.Sign(t.privateKey); err != nil {
+		return err
+	}
+	data, err := json.Marshal(rejection)
+	if err != nil {
+		return err
+	}
+	if data, err = framing.encode(data); err != nil {
+		return err
+	}
+	_, err = conn.Write(data)
+	return err
+}
+
+// remoteIP returns the address a connection comes from, without its port
+func remoteIP(conn net.Conn) string {
+	host, _, err := net.SplitHostPort(conn.RemoteAddr().String())
+	if err != nil {
+		return conn.RemoteAddr().String()
+	}
+	return host
+}
+
 // RegisterHandler registers a handler for an envelope type
 func (t *Transport) RegisterHandler(envType EnvelopeType, handler EnvelopeHandler) {
 	t.mu.Lock()
@@ -143,39 +339,41 @@ func (t *Transport) RegisterHandler(envType EnvelopeType, handler EnvelopeHandle
 	t.handlers[envType] = handler
 }
 
-// Send sends an envelope to a remote endpoint
+// Send sends an envelope to a remote endpoint. Connections are kept open
+// and reused for later envelopes to the same endpoint, as set by SetPoolConfig.
 func (t *Transport) Send(endpoint string, envelope *Envelope) error {
 	// Sign the envelope
 	if err := envelope.Sign(t.privateKey); err != nil {
 		return err
 	}
 
-	// Connect to endpoint
-	conn, err := tls.Dial("tcp", endpoint, &tls.Config{
-		InsecureSkipVerify: true, // In production, verify certificates
-		MinVersion:         tls.VersionTLS13,
-	})
-	if err != nil {
-		return err
-	}
-	defer conn.Close()
-
 	// Send envelope
-	data, err := json.Marshal(envelope)
+	data, err := t.encodeEnvelope(envelope)
 	if err != nil {
 		return err
 	}
 
-	_, err = conn.Write(append(data, '\n'))
-	return err
+	return t.pool.send(endpoint, data)
 }
 
-// Client represents a FEP client connection
+// Client represents a FEP client connection. Once connected, it reconnects
+// on its own when the connection is lost; see SetReconnectPolicy.
 type Client struct {
-	transport *Transport
-	endpoint  string
-	conn      net.Conn
-	mu        sync.Mutex
+	transport    *Transport
+	endpoint     string
+	conn         net.Conn
+	reader       *bufio.Reader
+	framing      Framing // Negotiated on the current connection
+	policy       ReconnectPolicy
+	registration *Envelope                              // Sent again after every connection
+	onState      func(state ConnectionState, err error) // Called on every state change
+	state        ConnectionState
+	ready        chan struct{} // Closed while connected
+	done         chan struct{} // Closed by Close
+	reconnecting bool
+	closed       bool
+	mu           sync.Mutex
+	writeMu      sync.Mutex // Serializes envelope writes
 }
 
 // NewClient creates a new FEP client
@@ -188,30 +386,33 @@ func NewClient(endpoint string, privateKey ed25519.PrivateKey) (*Client, error)
 	return &Client{
 		transport: transport,
 		endpoint:  endpoint,
+		policy:    DefaultReconnectPolicy(),
+		state:     StateDisconnected,
+		ready:     make(chan struct{}),
+		done:      make(chan struct{}),
 	}, nil
 }
 
-// Connect establishes a connection to the server
+// Connect establishes a connection to the server. It tries once; the client
+// only reconnects by itself after a connection was established.
 func (c *Client) Connect() error {
-	conn, err := tls.Dial("tcp", c.endpoint, &tls.Config{
-		InsecureSkipVerify: true,
-		MinVersion:         tls.VersionTLS13,
-	})
-	if err != nil {
+	if conn, _, _ := c.current(); conn != nil {
+		return nil
+	}
+	c.setState(StateConnecting, nil)
+	if err := c.dial(); err != nil {
+		c.setState(StateDisconnected, err)
 		return err
 	}
-
-	c.conn = conn
 	return nil
 }
 
-// SendEnvelope sends an envelope to the server
+// SendEnvelope sends an envelope to the server. A failed write drops the
+// connection and starts reconnecting; the error is returned either way.
 func (c *Client) SendEnvelope(envelope *Envelope) error {
-	c.mu.Lock()
-	defer c.mu.Unlock()
-
-	if c.conn == nil {
-		return fmt.Errorf("not connected")
+	conn, _, _ := c.current()
+	if conn == nil {
+		return ErrNotConnected
 	}
 
 	// Sign the envelope
@@ -220,24 +421,41 @@ func (c *Client) SendEnvelope(envelope *Envelope) error {
 	}
 
 	// Send envelope
-	data, err := json.Marshal(envelope)
+	data, err := c.transport.encodeEnvelope(envelope)
 	if err != nil {
 		return err
 	}
 
-	_, err = c.conn.Write(append(data, '\n'))
+	data, err = c.framing.encode(data)
+	if err != nil {
+		return err
+	}
+
+	c.writeMu.Lock()
+	_, err = conn.Write(data)
+	c.writeMu.Unlock()
+	if err != nil {
+		c.connectionLost(conn, err)
+	}
 	return err
 }
 
-// ReadEnvelope reads an envelope from the server
+// ReadEnvelope reads an envelope from the server. A connection that fails
+// is dropped and the client starts reconnecting; WaitConnected waits for it.
 func (c *Client) ReadEnvelope() (*Envelope, error) {
-	if c.conn == nil {
-		return nil, fmt.Errorf("not connected")
+	conn, reader, framing := c.current()
+	if conn == nil {
+		return nil, ErrNotConnected
 	}
 
-	reader := bufio.NewReader(c.conn)
-	line, err := reader.ReadBytes('\n')
+	line, err := framing.read(reader, c.transport.MaxEnvelopeSize())
 	if err != nil {
+		if !errors.Is(err, ErrEnvelopeTooLarge) {
+			c.connectionLost(conn, err)
+		}
+		return nil, err
+	}
+	if line, err = InflateBody(line); err != nil {
 		return nil, err
 	}
 
@@ -249,35 +467,79 @@ func (c *Client) ReadEnvelope() (*Envelope, error) {
 	return &envelope, nil
 }
 
-// Close closes the client connection
+// Close closes the client connection and stops reconnecting
 func (c *Client) Close() error {
-	if c.conn != nil {
-		return c.conn.Close()
+	c.mu.Lock()
+	if c.closed {
+		c.mu.Unlock()
+		return nil
+	}
+	c.closed = true
+	close(c.done)
+	conn := c.conn
+	c.conn = nil
+	c.mu.Unlock()
+
+	c.setState(StateClosed, nil)
+	if conn != nil {
+		return conn.Close()
 	}
 	return nil
 }
 
 // Stream represents a bidirectional FEP stream
 type Stream struct {
-	reader *bufio.Reader
-	writer io.Writer
-	mu     sync.Mutex
+	reader          *bufio.Reader
+	writer          io.Writer
+	framing         Framing
+	maxEnvelopeSize int
+	compression     *CompressionPolicy
+	mu              sync.Mutex
 }
 
-// NewStream creates a new FEP stream
+// NewStream creates a new FEP stream. A TLS connection whose handshake is
+// complete uses the framing negotiated in it; others start with NDJSON,
+// see SetFraming and NegotiateFraming.
 func NewStream(conn net.Conn) *Stream {
+	framing := FramingNDJSON
+	if tlsConn, ok := conn.(*tls.Conn); ok && tlsConn.ConnectionState().HandshakeComplete {
+		framing = negotiatedFraming(tlsConn.ConnectionState())
+	}
 	return &Stream{
-		reader: bufio.NewReader(conn),
-		writer: conn,
+		reader:          bufio.NewReader(conn),
+		writer:          conn,
+		framing:         framing,
+		maxEnvelopeSize: DefaultMaxEnvelopeSize,
 	}
 }
 
-// ReadEnvelope reads an envelope from the stream
+// SetMaxEnvelopeSize sets the largest envelope, in bytes, the stream will read
+func (s *Stream) SetMaxEnvelopeSize(size int) {
+	s.maxEnvelopeSize = size
+}
+
+// SetFraming sets how the stream delimits envelopes. Both ends must agree.
+func (s *Stream) SetFraming(framing Framing) {
+	s.framing = framing
+}
+
+// SetCompression compresses the bodies of envelopes written to the stream;
+// nil turns compression off. Both ends must read compressed envelopes, as
+// Stream and Client do.
+func (s *Stream) SetCompression(policy *CompressionPolicy) {
+	s.compression = policy
+}
+
+// ReadEnvelope reads an envelope from the stream. An oversized envelope is
+// discarded and reported as ErrEnvelopeTooLarge; the stream stays usable.
 func (s *Stream) ReadEnvelope() (*Envelope, error) {
-	line, err := s.reader.ReadBytes('\n')
+	line, err := s.framing.read(s.reader, s.maxEnvelopeSize)
 	if err != nil {
 		return nil, err
 	}
+	if line, err = InflateBody(line); err != nil {
+		return nil, err
+	}
 
 	var envelope Envelope
 	if err := json.Unmarshal(line, &envelope); err != nil {
@@ -296,7 +558,50 @@ func (s *Stream) WriteEnvelope(envelope *Envelope) error {
 	if err != nil {
 		return err
 	}
+	if data, err = CompressBody(data, s.compression); err != nil {
+		return err
+	}
+	if len(data) > s.maxEnvelopeSize {
+		return fmt.Errorf("%w: %d bytes exceeds limit of %d", ErrEnvelopeTooLarge, len(data), s.maxEnvelopeSize)
+	}
+	if data, err = s.framing.encode(data); err != nil {
+		return err
+	}
 
-	_, err = s.writer.Write(append(data, '\n'))
+	_, err = s.writer.Write(data)
 	return err
-}
\ No newline at end of file
+}
+
+// ReadEnvelopeLine reads one newline-delimited envelope of at most maxSize bytes.
+// Longer lines are consumed without being buffered and ErrEnvelopeTooLarge is
+// returned, so memory use stays bounded and the reader stays aligned on the next envelope.
+func ReadEnvelopeLine(reader *bufio.Reader, maxSize int) ([]byte, error) {
+	var line []byte
+	size := 0
+	for {
+		chunk, err := reader.ReadSlice('\n')
+		size += len(chunk)
+		if size > maxSize+1 { // +1 allows for the delimiter
+			line = nil
+		} else {
+			line = append(line, chunk...)
+		}
+
+		switch {
+		case err == nil:
+			if size > maxSize+1 {
+				return nil, fmt.Errorf("%w: %d bytes exceeds limit of %d", ErrEnvelopeTooLarge, size-1, maxSize)
+			}
+			return line, nil
+		case errors.Is(err, bufio.ErrBufferFull):
+			continue
+		case errors.Is(err, io.EOF) && size > 0 && size <= maxSize:
+			// Final envelope without a trailing newline
+			return line, nil
+		case errors.Is(err, io.EOF) && size > maxSize:
+			return nil, fmt.Errorf("%w: %d bytes exceeds limit of %d", ErrEnvelopeTooLarge, size, maxSize)
+		default:
+			return nil, err
+		}
+	}
+}
@@ -0,0 +1,81 @@
+package protocol
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"net"
+	"strings"
+	"testing"
+)
+
+func TestReadEnvelopeLineLimit(t *testing.T) {
+	input := `{"small":1}` + "\n" + strings.Repeat("x", 10000) + "\n" + `{"after":2}` + "\n"
+	// A small buffer forces the oversized line to be read in several chunks
+	reader := bufio.NewReaderSize(strings.NewReader(input), 16)
+
+	line, err := ReadEnvelopeLine(reader, 1024)
+	if err != nil || strings.TrimSpace(string(line)) != `{"small":1}` {
+		t.Fatalf("First line: %q, %v", line, err)
+	}
+
+	if _, err := ReadEnvelopeLine(reader, 1024); !errors.Is(err, ErrEnvelopeTooLarge) {
+		t.Fatalf("Expected ErrEnvelopeTooLarge, got %v", err)
+	}
+
+	// The reader resynchronizes on the envelope after the oversized one
+	line, err = ReadEnvelopeLine(reader, 1024)
+	if err != nil || strings.TrimSpace(string(line)) != `{"after":2}` {
+		t.Fatalf("Line after oversized envelope: %q, %v", line, err)
+	}
+
+	if _, err := ReadEnvelopeLine(reader, 1024); !errors.Is(err, io.EOF) {
+		t.Fatalf("Expected EOF, got %v", err)
+	}
+}
+
+func TestReadEnvelopeLineExactLimit(t *testing.T) {
+	payload := strings.Repeat("y", 64)
+	reader := bufio.NewReader(strings.NewReader(payload + "\n" + payload))
+
+	for i := 0; i < 2; i++ {
+		line, err := ReadEnvelopeLine(reader, len(payload))
+		if err != nil || strings.TrimSpace(string(line)) != payload {
+			t.Fatalf("Line %d at exactly the limit: %v", i, err)
+		}
+	}
+}
+
+func TestStreamEnvelopeSizeLimit(t *testing.T) {
+	clientConn, serverConn := net.Pipe()
+	defer clientConn.Close()
+	defer serverConn.Close()
+
+	writer := NewStream(clientConn)
+	reader := NewStream(serverConn)
+	reader.SetMaxEnvelopeSize(512)
+
+	large := &Envelope{Type: EnvelopeToolCall, CommonHeaders: CommonHeaders{Agent: strings.Repeat("a", 2048)}}
+	small := &Envelope{Type: EnvelopeToolCall, CommonHeaders: CommonHeaders{Agent: "small"}}
+
+	go func() {
+		writer.WriteEnvelope(large)
+		writer.WriteEnvelope(small)
+	}()
+
+	if _, err := reader.ReadEnvelope(); !errors.Is(err, ErrEnvelopeTooLarge) {
+		t.Fatalf("Expected ErrEnvelopeTooLarge, got %v", err)
+	}
+	envelope, err := reader.ReadEnvelope()
+	if err != nil {
+		t.Fatalf("Stream should remain usable: %v", err)
+	}
+	if envelope.Agent != "small" {
+		t.Errorf("Unexpected envelope from %s", envelope.Agent)
+	}
+
+	writer.SetMaxEnvelopeSize(512)
+	if err := writer.WriteEnvelope(large); !errors.Is(err, ErrEnvelopeTooLarge) {
+		t.Errorf("Writing an oversized envelope should fail, got %v", err)
+	}
+}
@@ -0,0 +1,176 @@
+package protocol
+
+import (
+	"context"
+	"crypto/ed25519"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// EnvelopeBody is implemented by every envelope body type. It names the
+// envelope type that carries it and checks its own fields.
+type EnvelopeBody interface {
+	EnvelopeType() EnvelopeType
+	Validate() error
+}
+
+// EnvelopeType methods tie each body to its envelope type
+func (RegisterAgentBody) EnvelopeType() EnvelopeType     { return EnvelopeRegisterAgent }
+func (RegisterBrokerBody) EnvelopeType() EnvelopeType    { return EnvelopeRegisterBroker }
+func (EmitEventBody) EnvelopeType() EnvelopeType         { return EnvelopeEmitEvent }
+func (SubscribeBody) EnvelopeType() EnvelopeType         { return EnvelopeSubscribe }
+func (UnsubscribeBody) EnvelopeType() EnvelopeType       { return EnvelopeUnsubscribe }
+func (RenderInstructionBody) EnvelopeType() EnvelopeType { return EnvelopeRenderInstruction }
+func (ToolCallBody) EnvelopeType() EnvelopeType          { return EnvelopeToolCall }
+func (ToolResultBody) EnvelopeType() EnvelopeType        { return EnvelopeToolResult }
+func (ToolResultChunkBody) EnvelopeType() EnvelopeType   { return EnvelopeToolResultChunk }
+func (RevokeBody) EnvelopeType() EnvelopeType            { return EnvelopeRevoke }
+func (RotateKeyBody) EnvelopeType() EnvelopeType         { return EnvelopeRotateKey }
+func (RevokeCapabilityBody) EnvelopeType() EnvelopeType  { return EnvelopeRevokeCapability }
+func (DiscoverToolsBody) EnvelopeType() EnvelopeType     { return EnvelopeDiscoverTools }
+func (ToolsDiscoveredBody) EnvelopeType() EnvelopeType   { return EnvelopeToolsDiscovered }
+func (EmbodimentUpdateBody) EnvelopeType() EnvelopeType  { return EnvelopeEmbodimentUpdate }
+func (HeartbeatBody) EnvelopeType() EnvelopeType         { return EnvelopeHeartbeat }
+func (ResultAckBody) EnvelopeType() EnvelopeType         { return EnvelopeResultAck }
+func (BatchBody) EnvelopeType() EnvelopeType             { return EnvelopeBatch }
+func (DrainInstanceBody) EnvelopeType() EnvelopeType     { return EnvelopeDrainInstance }
+func (PreemptionNoticeBody) EnvelopeType() EnvelopeType  { return EnvelopePreemptionNotice }
+func (CheckpointBody) EnvelopeType() EnvelopeType        { return EnvelopeCheckpoint }
+func (ApproveCallBody) EnvelopeType() EnvelopeType       { return EnvelopeApproveCall }
+func (AdminApprovalBody) EnvelopeType() EnvelopeType     { return EnvelopeAdminApproval }
+func (AckBody) EnvelopeType() EnvelopeType               { return EnvelopeAck }
+func (ErrorBody) EnvelopeType() EnvelopeType             { return EnvelopeError }
+
+// ErrEnvelopeTypeMismatch is returned when an envelope is parsed as the wrong body type
+var ErrEnvelopeTypeMismatch = errors.New("envelope type does not match body type")
+
+// ErrNoHandler is returned when no handler is registered for an envelope type
+var ErrNoHandler = errors.New("no handler for envelope type")
+
+// TypedEnvelope is an envelope whose body type is known at compile time
+type TypedEnvelope[T EnvelopeBody] struct {
+	BaseEnvelope
+	Body T `json:"body"`
+}
+
+// NewTypedEnvelope creates an envelope carrying body, with the type taken from the body
+func NewTypedEnvelope[T EnvelopeBody](agent string, body T) *TypedEnvelope[T] {
+	return &TypedEnvelope[T]{
+		BaseEnvelope: BaseEnvelope{
+			Type: body.EnvelopeType(),
+			CommonHeaders: CommonHeaders{
+				Agent: agent,
+				TS:    time.Now().UnixMilli(),
+				Nonce: generateNonce(),
+				Proto: ProtocolVersion,
+			},
+		},
+		Body: body,
+	}
+}
+
+// Sign signs the envelope with the given private key
+func (e *TypedEnvelope[T]) Sign(signer Signer) error {
+	return signEnvelope(e.Type, &e.CommonHeaders, e.Body, signer)
+}
+
+// Verify verifies the envelope signature with the given public key
+func (e *TypedEnvelope[T]) Verify(publicKey ed25519.PublicKey) error {
+	return verifyEnvelope(e.Type, e.CommonHeaders, e.Body, publicKey)
+}
+
+// Parse parses JSON bytes into an envelope with body type T, failing if the
+// envelope's type does not carry T
+func Parse[T EnvelopeBody](data []byte) (*TypedEnvelope[T], error) {
+	generic, err := ParseEnvelope(data)
+	if err != nil {
+		return nil, err
+	}
+	return ParseTyped[T](generic)
+}
+
+// ParseTyped decodes a generic envelope's body as T and validates it
+func ParseTyped[T EnvelopeBody](g *GenericEnvelope) (*TypedEnvelope[T], error) {
+	var zero T
+	if g.Type != zero.EnvelopeType() {
+		return nil, fmt.Errorf("%w: got %s, want %s", ErrEnvelopeTypeMismatch, g.Type, zero.EnvelopeType())
+	}
+	if g.Enc != nil {
+		return nil, fmt.Errorf("%w: decrypt %s before parsing it", ErrEncryptedBody, g.Type)
+	}
+
+	envelope := &TypedEnvelope[T]{BaseEnvelope: g.BaseEnvelope}
+	if err := g.GetBodyAs(&envelope.Body); err != nil {
+		return nil, fmt.Errorf("invalid %s body: %w", g.Type, err)
+	}
+	if err := envelope.Body.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid %s body: %w", g.Type, err)
+	}
+	return envelope, nil
+}
+
+// EnvelopeMux dispatches generic envelopes to typed handlers by envelope type
+type EnvelopeMux struct {
+	handlers map[EnvelopeType]func(context.Context, *GenericEnvelope) error
+	keys     []*EncryptionKey
+	mu       sync.RWMutex
+}
+
+// NewEnvelopeMux creates a mux with no handlers
+func NewEnvelopeMux() *EnvelopeMux {
+	return &EnvelopeMux{handlers: make(map[EnvelopeType]func(context.Context, *GenericEnvelope) error)}
+}
+
+// RegisterHandler routes envelopes carrying body type T to handler, replacing
+// any handler already registered for that type
+func RegisterHandler[T EnvelopeBody](mux *EnvelopeMux, handler func(ctx context.Context, env *TypedEnvelope[T]) error) {
+	var zero T
+	mux.mu.Lock()
+	defer mux.mu.Unlock()
+	mux.handlers[zero.EnvelopeType()] = func(ctx context.Context, g *GenericEnvelope) error {
+		envelope, err := ParseTyped[T](g)
+		if err != nil {
+			return err
+		}
+		return handler(ctx, envelope)
+	}
+}
+
+// Handles reports whether a handler is registered for an envelope type
+func (m *EnvelopeMux) Handles(envType EnvelopeType) bool {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	_, exists := m.handlers[envType]
+	return exists
+}
+
+// SetDecryptionKeys sets the keys Dispatch uses to decrypt encrypted bodies
+// before handing them to typed handlers
+func (m *EnvelopeMux) SetDecryptionKeys(keys ...*EncryptionKey) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.keys = keys
+}
+
+// Dispatch decodes an envelope and calls the handler registered for its type.
+// Encrypted bodies are decrypted with the mux's keys; the caller's envelope is
+// not modified.
+func (m *EnvelopeMux) Dispatch(ctx context.Context, g *GenericEnvelope) error {
+	m.mu.RLock()
+	handler, exists := m.handlers[g.Type]
+	keys := m.keys
+	m.mu.RUnlock()
+	if !exists {
+		return fmt.Errorf("%w: %s", ErrNoHandler, g.Type)
+	}
+	if g.Encrypted() {
+		decrypted := *g
+		if err := decrypted.Decrypt(keys...); err != nil {
+			return err
+		}
+		g = &decrypted
+	}
+	return handler(ctx, g)
+}
@@ -0,0 +1,69 @@
+package protocol
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+)
+
+func TestParseTypedEnvelope(t *testing.T) {
+	pub, priv, _ := GenerateKeyPair()
+	envelope := NewTypedEnvelope("caller", ToolCallBody{Tool: "math.add", Parameters: map[string]interface{}{"a": 1.0}, RequestID: "r1"})
+	if envelope.Type != EnvelopeToolCall {
+		t.Fatalf("Envelope type should come from the body, got %s", envelope.Type)
+	}
+	if err := envelope.Sign(priv); err != nil {
+		t.Fatalf("Sign failed: %v", err)
+	}
+	data, _ := json.Marshal(envelope)
+
+	parsed, err := Parse[ToolCallBody](data)
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+	if parsed.Body.Tool != "math.add" || parsed.Body.RequestID != "r1" || parsed.Agent != "caller" {
+		t.Errorf("Unexpected parsed envelope: %+v", parsed)
+	}
+	if err := parsed.Verify(pub); err != nil {
+		t.Errorf("Parsed envelope should verify: %v", err)
+	}
+
+	if _, err := Parse[ToolResultBody](data); !errors.Is(err, ErrEnvelopeTypeMismatch) {
+		t.Errorf("Parsing as the wrong body type should fail, got %v", err)
+	}
+}
+
+func TestEnvelopeMuxDispatch(t *testing.T) {
+	mux := NewEnvelopeMux()
+	var called string
+	RegisterHandler(mux, func(ctx context.Context, env *TypedEnvelope[ToolCallBody]) error {
+		called = env.Body.Tool
+		return nil
+	})
+	RegisterHandler(mux, func(ctx context.Context, env *TypedEnvelope[RevokeBody]) error {
+		return errors.New("revocation refused")
+	})
+
+	dispatch := func(body EnvelopeBody) error {
+		data, _ := json.Marshal(NewTypedEnvelope("caller", body))
+		generic, err := ParseEnvelope(data)
+		if err != nil {
+			t.Fatalf("ParseEnvelope failed: %v", err)
+		}
+		return mux.Dispatch(context.Background(), generic)
+	}
+
+	if err := dispatch(ToolCallBody{Tool: "math.add", RequestID: "r1"}); err != nil || called != "math.add" {
+		t.Errorf("Tool call not dispatched: %q, %v", called, err)
+	}
+	if err := dispatch(RevokeBody{Target: "agent"}); err == nil || err.Error() != "revocation refused" {
+		t.Errorf("Handler error should be returned, got %v", err)
+	}
+	if err := dispatch(EmitEventBody{Event: "ping"}); !errors.Is(err, ErrNoHandler) {
+		t.Errorf("Unhandled type should return ErrNoHandler, got %v", err)
+	}
+	if !mux.Handles(EnvelopeToolCall) || mux.Handles(EnvelopeEmitEvent) {
+		t.Error("Handles reports the wrong envelope types")
+	}
+}
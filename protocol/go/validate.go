@@ -0,0 +1,503 @@
+package protocol
+
+import (
+	"fmt"
+	"math"
+	"net/url"
+	"strings"
+)
+
+// ValidationError reports a body field that is missing or malformed
+type ValidationError struct {
+	Field   string
+	Message string
+}
+
+func (e *ValidationError) Error() string {
+	return fmt.Sprintf("invalid %s: %s", e.Field, e.Message)
+}
+
+func invalid(field, format string, args ...interface{}) error {
+	return &ValidationError{Field: field, Message: fmt.Sprintf(format, args...)}
+}
+
+func required(field, value string) error {
+	if strings.TrimSpace(value) == "" {
+		return invalid(field, "required")
+	}
+	return nil
+}
+
+// ValidateCapabilityPattern checks a tool name or capability pattern. Patterns
+// are dot-separated names that may end in a single "*" wildcard, e.g. "file.*".
+func ValidateCapabilityPattern(pattern string) error {
+	if pattern == "" {
+		return fmt.Errorf("empty pattern")
+	}
+	if strings.ContainsAny(pattern, " \t\r\n") {
+		return fmt.Errorf("pattern %q contains whitespace", pattern)
+	}
+	if i := strings.Index(pattern, "*"); i >= 0 && i != len(pattern)-1 {
+		return fmt.Errorf("pattern %q may only end in a wildcard", pattern)
+	}
+	return nil
+}
+
+func validatePatterns(field string, patterns []string) error {
+	for i, pattern := range patterns {
+		if err := ValidateCapabilityPattern(pattern); err != nil {
+			return invalid(fmt.Sprintf("%s[%d]", field, i), "%v", err)
+		}
+	}
+	return nil
+}
+
+func validateToolName(field, name string) error {
+	if err := required(field, name); err != nil {
+		return err
+	}
+	if strings.ContainsAny(name, " \t\r\n*") {
+		return invalid(field, "tool name %q contains whitespace or wildcards", name)
+	}
+	return nil
+}
+
+// ValidateRegion checks a data residency region: lowercase segments of
+// letters and digits joined by '-', from coarse to fine, e.g. "eu" or "eu-de"
+func ValidateRegion(field, region string) error {
+	if err := required(field, region); err != nil {
+		return err
+	}
+	for _, segment := range strings.Split(region, "-") {
+		if segment == "" {
+			return invalid(field, "region %q has an empty segment", region)
+		}
+		for _, c := range segment {
+			if (c < 'a' || c > 'z') && (c < '0' || c > '9') {
+				return invalid(field, "region %q must be lowercase letters and digits joined by '-'", region)
+			}
+		}
+	}
+	return nil
+}
+
+func validateRegions(field string, regions []string) error {
+	for i, region := range regions {
+		if err := ValidateRegion(fmt.Sprintf("%s[%d]", field, i), region); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// validateEndpoint checks that an endpoint is an absolute http(s) URL
+func validateEndpoint(field, endpoint string) error {
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return invalid(field, "%v", err)
+	}
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return invalid(field, "%q is not an absolute http(s) URL", endpoint)
+	}
+	return nil
+}
+
+// Validate checks the registration carries a key, well-formed capabilities
+// and, when given, a well-formed MCP endpoint, which an instance ID requires
+func (b RegisterAgentBody) Validate() error {
+	if err := required("pubkey", b.PubKey); err != nil {
+		return err
+	}
+	if err := validatePatterns("capabilities", b.Capabilities); err != nil {
+		return err
+	}
+	if b.MCPEndpoint != "" {
+		if err := validateEndpoint("mcpEndpoint", b.MCPEndpoint); err != nil {
+			return err
+		}
+	} else if b.InstanceID != "" {
+		return invalid("instanceId", "requires an mcpEndpoint for the instance")
+	}
+	for i, encoding := range b.Compression {
+		if encoding == "" || encoding == EncodingIdentity {
+			return invalid(fmt.Sprintf("compression[%d]", i), "must name a compression encoding")
+		}
+	}
+	if b.BodyDefinition != nil {
+		return b.BodyDefinition.Validate()
+	}
+	return nil
+}
+
+// Validate checks the broker's endpoint and capabilities
+func (b RegisterBrokerBody) Validate() error {
+	if err := required("endpoint", b.Endpoint); err != nil {
+		return err
+	}
+	if err := validateEndpoint("endpoint", b.Endpoint); err != nil {
+		return err
+	}
+	return validatePatterns("capabilities", b.Capabilities)
+}
+
+// Validate checks the event names a topic
+func (b EmitEventBody) Validate() error {
+	if err := required("event", b.Event); err != nil {
+		return err
+	}
+	if err := ValidateTopic(b.Event); err != nil {
+		return invalid("event", "%v", err)
+	}
+	return nil
+}
+
+// Validate checks the subscription names a well-formed topic pattern, unless
+// it only asks for results
+func (b SubscribeBody) Validate() error {
+	if b.Pattern == "" && b.Results {
+		// An event stream of results only
+		return nil
+	}
+	if err := required("pattern", b.Pattern); err != nil {
+		return err
+	}
+	if err := ValidateTopicPattern(b.Pattern); err != nil {
+		return invalid("pattern", "%v", err)
+	}
+	return nil
+}
+
+// Validate checks the request names the subscription to remove
+func (b UnsubscribeBody) Validate() error {
+	if b.SubscriptionID == "" && b.Pattern == "" {
+		return invalid("subscriptionId", "subscriptionId or pattern is required")
+	}
+	return nil
+}
+
+// Validate checks the instruction is present and any locale and formats are well formed
+func (b RenderInstructionBody) Validate() error {
+	if err := required("instruction", b.Instruction); err != nil {
+		return err
+	}
+	if b.Locale != "" {
+		if err := ValidateLocale(b.Locale); err != nil {
+			return invalid("locale", "%v", err)
+		}
+	}
+	if b.Presentation != nil {
+		for i, format := range b.Presentation.Formats {
+			if err := validateMediaRange(format); err != nil {
+				return invalid(fmt.Sprintf("presentation.formats[%d]", i), "%v", err)
+			}
+		}
+		if b.Presentation.MaxWidth < 0 {
+			return invalid("presentation.maxWidth", "must not be negative")
+		}
+	}
+	return nil
+}
+
+// Validate checks the call names a tool and can be correlated with its result
+func (b ToolCallBody) Validate() error {
+	if err := validateToolName("tool", b.Tool); err != nil {
+		return err
+	}
+	if err := required("requestId", b.RequestID); err != nil {
+		return err
+	}
+	if b.DataRegion != "" {
+		if err := ValidateRegion("dataRegion", b.DataRegion); err != nil {
+			return err
+		}
+	}
+	if err := b.ResultDelivery.Validate(); err != nil {
+		return err
+	}
+	if b.Deadline < 0 {
+		return invalid("deadline", "must not be negative")
+	}
+	if b.AcceptPartial && b.Deadline == 0 {
+		return invalid("acceptPartial", "requires a deadline")
+	}
+	for i, accept := range b.Accept {
+		if err := validateMediaRange(accept); err != nil {
+			return invalid(fmt.Sprintf("accept[%d]", i), "%v", err)
+		}
+	}
+	return b.Delivery.Validate()
+}
+
+// Validate checks the result is correlated and failures carry an error
+func (b ToolResultBody) Validate() error {
+	if err := required("requestId", b.RequestID); err != nil {
+		return err
+	}
+	if !b.Success && b.Error == "" {
+		return invalid("error", "required when success is false")
+	}
+	if b.ContentType != "" {
+		if err := validateMediaType(b.ContentType); err != nil {
+			return invalid("contentType", "%v", err)
+		}
+	}
+	return nil
+}
+
+// Validate checks the chunk is correlated and numbered, and that a failed
+// final chunk carries an error
+func (b ToolResultChunkBody) Validate() error {
+	if err := required("requestId", b.RequestID); err != nil {
+		return err
+	}
+	if b.Seq == 0 {
+		return invalid("seq", "must be at least 1")
+	}
+	if !b.Final && (b.Success || b.Result != nil || b.Error != "") {
+		return invalid("final", "outcome fields are only allowed on the final chunk")
+	}
+	if b.Final && !b.Success && b.Error == "" {
+		return invalid("error", "required when the final chunk reports failure")
+	}
+	return nil
+}
+
+// Validate checks the revocation names its target
+func (b RevokeBody) Validate() error {
+	return required("target", b.Target)
+}
+
+// Validate checks the new key decodes
+func (b RotateKeyBody) Validate() error {
+	if err := required("newPubkey", b.NewPubKey); err != nil {
+		return err
+	}
+	if _, err := DecodePublicKey(b.NewPubKey); err != nil {
+		return invalid("newPubkey", "%v", err)
+	}
+	return nil
+}
+
+// Validate checks the revocation names a token
+func (b RevokeCapabilityBody) Validate() error {
+	return required("jti", b.TokenID)
+}
+
+// Validate checks the query
+func (b DiscoverToolsBody) Validate() error {
+	return b.Query.Validate()
+}
+
+// Validate checks capability patterns and the result limit
+func (q ToolQuery) Validate() error {
+	if err := validatePatterns("query.capabilities", q.Capabilities); err != nil {
+		return err
+	}
+	if q.MaxResults < 0 {
+		return invalid("query.maxResults", "must not be negative")
+	}
+	return validateHardwareFilter("query.hardware", q.Hardware)
+}
+
+// Validate checks the response is correlated and each tool is attributed
+func (b ToolsDiscoveredBody) Validate() error {
+	if err := required("requestId", b.RequestID); err != nil {
+		return err
+	}
+	if b.TotalResults < 0 {
+		return invalid("totalResults", "must not be negative")
+	}
+	for i, tool := range b.Tools {
+		field := fmt.Sprintf("tools[%d]", i)
+		if err := required(field+".agentId", tool.AgentID); err != nil {
+			return err
+		}
+		if tool.MCPEndpoint != "" {
+			if err := validateEndpoint(field+".mcpEndpoint", tool.MCPEndpoint); err != nil {
+				return err
+			}
+		}
+		for j, mcpTool := range tool.MCPTools {
+			if err := validateToolName(fmt.Sprintf("%s.mcpTools[%d].name", field, j), mcpTool.Name); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Validate checks the new endpoint and tool definitions
+func (b EmbodimentUpdateBody) Validate() error {
+	if b.MCPEndpoint != "" {
+		if err := validateEndpoint("mcpEndpoint", b.MCPEndpoint); err != nil {
+			return err
+		}
+	}
+	return b.BodyDefinition.Validate()
+}
+
+// Validate checks the reported status is in range
+func (b HeartbeatBody) Validate() error {
+	if b.Load < 0 || b.Load > 1 {
+		return invalid("load", "must be between 0 and 1")
+	}
+	if b.InFlight < 0 {
+		return invalid("inFlight", "must not be negative")
+	}
+	if b.Uptime < 0 {
+		return invalid("uptime", "must not be negative")
+	}
+	if b.GPUUtilization < 0 || b.GPUUtilization > 1 {
+		return invalid("gpuUtilization", "must be between 0 and 1")
+	}
+	return validateResources("available", b.Available)
+}
+
+// Validate checks acknowledged request IDs are present and the limit is in range
+func (b ResultAckBody) Validate() error {
+	for i, id := range b.Acknowledged {
+		if err := required(fmt.Sprintf("acknowledged[%d]", i), id); err != nil {
+			return err
+		}
+	}
+	if b.MaxResults < 0 {
+		return invalid("maxResults", "must not be negative")
+	}
+	if b.Wait < 0 {
+		return invalid("wait", "must not be negative")
+	}
+	return nil
+}
+
+// Validate checks the batch is non-empty and within MaxBatchSize
+func (b BatchBody) Validate() error {
+	if len(b.Envelopes) == 0 {
+		return invalid("envelopes", "required")
+	}
+	if len(b.Envelopes) > MaxBatchSize {
+		return invalid("envelopes", "at most %d envelopes per batch, got %d", MaxBatchSize, len(b.Envelopes))
+	}
+	return nil
+}
+
+// Validate checks the instance is named and the wait is not negative
+func (b DrainInstanceBody) Validate() error {
+	if err := required("instanceId", b.InstanceID); err != nil {
+		return err
+	}
+	if b.Wait < 0 {
+		return invalid("wait", "must not be negative")
+	}
+	return nil
+}
+
+// Validate checks the deadline is not negative
+func (b PreemptionNoticeBody) Validate() error {
+	if b.Deadline < 0 {
+		return invalid("deadline", "must not be negative")
+	}
+	return nil
+}
+
+// Validate checks the checkpoint names its call and carries state to resume from
+func (b CheckpointBody) Validate() error {
+	if err := required("requestId", b.RequestID); err != nil {
+		return err
+	}
+	if err := required("tool", b.Tool); err != nil {
+		return err
+	}
+	if len(b.State) == 0 {
+		return invalid("state", "required")
+	}
+	return nil
+}
+
+// Validate checks the decision names the call it decides
+func (b ApproveCallBody) Validate() error {
+	return required("requestId", b.RequestID)
+}
+
+// Validate checks the approval names the operation and request it authorizes
+func (b AdminApprovalBody) Validate() error {
+	if err := required("operation", b.Operation); err != nil {
+		return err
+	}
+	if err := required("method", b.Method); err != nil {
+		return err
+	}
+	if !strings.HasPrefix(b.Path, "/") {
+		return invalid("path", "must be an absolute request path")
+	}
+	return nil
+}
+
+// Validate checks the acknowledgement has an outcome
+func (b AckBody) Validate() error {
+	return required("status", b.Status)
+}
+
+// Validate checks the error carries a code
+func (b ErrorBody) Validate() error {
+	return required("code", string(b.Code))
+}
+
+// Validate checks every offered tool is named
+func (d BodyDefinition) Validate() error {
+	for i, tool := range d.MCPTools {
+		if err := validateToolName(fmt.Sprintf("bodyDefinition.mcpTools[%d].name", i), tool.Name); err != nil {
+			return err
+		}
+		if err := validateRegions(fmt.Sprintf("bodyDefinition.mcpTools[%d].dataResidency", i), tool.DataResidency); err != nil {
+			return err
+		}
+		for j, class := range tool.PII {
+			if err := required(fmt.Sprintf("bodyDefinition.mcpTools[%d].pii[%d]", i, j), class); err != nil {
+				return err
+			}
+		}
+		if err := validateResources(fmt.Sprintf("bodyDefinition.mcpTools[%d].resources", i), tool.Resources); err != nil {
+			return err
+		}
+		if err := validateToolCost(fmt.Sprintf("bodyDefinition.mcpTools[%d].cost", i), tool.Cost); err != nil {
+			return err
+		}
+		for j, resultType := range tool.ResultTypes {
+			if err := validateMediaType(resultType); err != nil {
+				return invalid(fmt.Sprintf("bodyDefinition.mcpTools[%d].resultTypes[%d]", i, j), "%v", err)
+			}
+		}
+	}
+	if err := validateRegions("bodyDefinition.dataResidency", d.DataResidency); err != nil {
+		return err
+	}
+	return validateHardware("bodyDefinition.hardware", d.Hardware)
+}
+
+// validateToolCost checks the price is a non-negative amount
+func validateToolCost(field string, c *ToolCost) error {
+	if c != nil && (!(c.PerCall >= 0) || math.IsInf(c.PerCall, 0)) {
+		return invalid(field+".perCall", "must be a non-negative amount")
+	}
+	return nil
+}
+
+// validateResources checks no amount is negative
+func validateResources(field string, r *Resources) error {
+	if r == nil {
+		return nil
+	}
+	if r.CPU < 0 {
+		return invalid(field+".cpu", "must not be negative")
+	}
+	if r.MemoryMB < 0 {
+		return invalid(field+".memoryMb", "must not be negative")
+	}
+	if r.GPUs < 0 {
+		return invalid(field+".gpus", "must not be negative")
+	}
+	if r.GPUMemoryMB < 0 {
+		return invalid(field+".gpuMemoryMb", "must not be negative")
+	}
+	return nil
+}
@@ -0,0 +1,81 @@
+package protocol
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestBodyValidation(t *testing.T) {
+	tests := []struct {
+		name  string
+		body  EnvelopeBody
+		field string // Empty when the body is valid
+	}{
+		{"valid registration", RegisterAgentBody{PubKey: "key", Capabilities: []string{"math.*"}, MCPEndpoint: "https://agent:8080/mcp"}, ""},
+		{"zero registration", RegisterAgentBody{}, "pubkey"},
+		{"bad capability pattern", RegisterAgentBody{PubKey: "key", Capabilities: []string{"math.*.add"}}, "capabilities[0]"},
+		{"relative endpoint", RegisterAgentBody{PubKey: "key", MCPEndpoint: "localhost:8080"}, "mcpEndpoint"},
+		{"unnamed offered tool", RegisterAgentBody{PubKey: "key", BodyDefinition: &BodyDefinition{MCPTools: []MCPTool{{}}}}, "bodyDefinition.mcpTools[0].name"},
+		{"instance without endpoint", RegisterAgentBody{PubKey: "key", InstanceID: "replica-1"}, "instanceId"},
+		{"broker without endpoint", RegisterBrokerBody{BrokerID: "b"}, "endpoint"},
+		{"valid tool call", ToolCallBody{Tool: "math.add", RequestID: "r1"}, ""},
+		{"tool call with wildcard", ToolCallBody{Tool: "math.*", RequestID: "r1"}, "tool"},
+		{"tool call without request", ToolCallBody{Tool: "math.add"}, "requestId"},
+		{"tool call with data region", ToolCallBody{Tool: "math.add", RequestID: "r1", DataRegion: "eu-de"}, ""},
+		{"malformed data region", ToolCallBody{Tool: "math.add", RequestID: "r1", DataRegion: "EU"}, "dataRegion"},
+		{"malformed tool residency", RegisterAgentBody{PubKey: "key", BodyDefinition: &BodyDefinition{MCPTools: []MCPTool{{Name: "a", DataResidency: []string{"eu-"}}}}}, "bodyDefinition.mcpTools[0].dataResidency[0]"},
+		{"blank pii class", RegisterAgentBody{PubKey: "key", BodyDefinition: &BodyDefinition{MCPTools: []MCPTool{{Name: "a", PII: []string{"email", " "}}}}}, "bodyDefinition.mcpTools[0].pii[1]"},
+		{"malformed agent residency", RegisterAgentBody{PubKey: "key", BodyDefinition: &BodyDefinition{DataResidency: []string{"eu de"}}}, "bodyDefinition.dataResidency[0]"},
+		{"failed result without error", ToolResultBody{RequestID: "r1"}, "error"},
+		{"valid failed result", ToolResultBody{RequestID: "r1", Error: "boom"}, ""},
+		{"revoke without target", RevokeBody{}, "target"},
+		{"negative max results", DiscoverToolsBody{Query: ToolQuery{MaxResults: -1}}, "query.maxResults"},
+		{"empty discovery matches all", DiscoverToolsBody{}, ""},
+		{"unattributed discovered tool", ToolsDiscoveredBody{RequestID: "r1", Tools: []DiscoveredTool{{}}}, "tools[0].agentId"},
+		{"unnamed event", EmitEventBody{}, "event"},
+		{"empty instruction", RenderInstructionBody{}, "instruction"},
+		{"bad render locale", RenderInstructionBody{Instruction: "show", Locale: "en_US"}, "locale"},
+		{"bad render format", RenderInstructionBody{Instruction: "show", Presentation: &PresentationHints{Formats: []string{"markdown"}}}, "presentation.formats[0]"},
+		{"bad update endpoint", EmbodimentUpdateBody{MCPEndpoint: "ftp://agent"}, "mcpEndpoint"},
+		{"valid heartbeat", HeartbeatBody{Load: 0.5, InFlight: 2, Uptime: 60}, ""},
+		{"drain without instance", DrainInstanceBody{Wait: 30}, "instanceId"},
+		{"negative drain wait", DrainInstanceBody{InstanceID: "replica-1", Wait: -1}, "wait"},
+		{"unknown result delivery", ToolCallBody{Tool: "math.add", RequestID: "r1", ResultDelivery: &ResultDelivery{Mode: "carrier-pigeon"}}, "resultDelivery.mode"},
+		{"webhook without url", ToolCallBody{Tool: "math.add", RequestID: "r1", ResultDelivery: &ResultDelivery{Mode: ResultDeliveryWebhook}}, "resultDelivery.url"},
+		{"event on wildcard topic", ToolCallBody{Tool: "math.add", RequestID: "r1", ResultDelivery: &ResultDelivery{Mode: ResultDeliveryEvent, Topic: "results.*"}}, "resultDelivery.topic"},
+		{"valid sync call", ToolCallBody{Tool: "math.add", RequestID: "r1", ResultDelivery: &ResultDelivery{Mode: ResultDeliverySync, Timeout: 30}}, ""},
+		{"partial without deadline", ToolCallBody{Tool: "math.add", RequestID: "r1", AcceptPartial: true}, "acceptPartial"},
+		{"negative result wait", ResultAckBody{Wait: -1}, "wait"},
+		{"negative preemption deadline", PreemptionNoticeBody{Deadline: -1}, "deadline"},
+		{"checkpoint without state", CheckpointBody{RequestID: "r1", Tool: "video.transcode"}, "state"},
+		{"approval without request", ApproveCallBody{Approved: true}, "requestId"},
+		{"admin approval without operation", AdminApprovalBody{Method: "PUT", Path: "/admin/routes"}, "operation"},
+		{"admin approval for relative path", AdminApprovalBody{Operation: "policy", Method: "PUT", Path: "admin/routes"}, "path"},
+		{"subscription without pattern", SubscribeBody{}, "pattern"},
+		{"results-only subscription", SubscribeBody{Results: true}, ""},
+		{"overloaded heartbeat", HeartbeatBody{Load: 1.5}, "load"},
+		{"unnamed gpu", RegisterAgentBody{PubKey: "key", BodyDefinition: &BodyDefinition{Hardware: &HardwareCapabilities{GPUs: []GPUDevice{{VRAMMB: 1024}}}}}, "bodyDefinition.hardware.gpus[0].model"},
+		{"bad cuda version", RegisterAgentBody{PubKey: "key", BodyDefinition: &BodyDefinition{Hardware: &HardwareCapabilities{GPUs: []GPUDevice{{Model: "A100", CUDAVersion: "twelve"}}}}}, "bodyDefinition.hardware.gpus[0].cudaVersion"},
+		{"negative vram filter", DiscoverToolsBody{Query: ToolQuery{Hardware: &HardwareFilter{MinVRAMMB: -1}}}, "query.hardware.minVramMb"},
+		{"gpu utilization over 1", HeartbeatBody{GPUUtilization: 1.2}, "gpuUtilization"},
+		{"negative free gpus", HeartbeatBody{Available: &Resources{GPUs: -1}}, "available.gpus"},
+		{"negative tool cost", RegisterAgentBody{PubKey: "key", BodyDefinition: &BodyDefinition{MCPTools: []MCPTool{{Name: "a", Cost: &ToolCost{PerCall: -0.5}}}}}, "bodyDefinition.mcpTools[0].cost.perCall"},
+		{"negative tool memory", RegisterAgentBody{PubKey: "key", BodyDefinition: &BodyDefinition{MCPTools: []MCPTool{{Name: "a", Resources: &Resources{MemoryMB: -1}}}}}, "bodyDefinition.mcpTools[0].resources.memoryMb"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.body.Validate()
+			if tt.field == "" {
+				if err != nil {
+					t.Errorf("Expected valid body, got %v", err)
+				}
+				return
+			}
+			var validationErr *ValidationError
+			if !errors.As(err, &validationErr) || validationErr.Field != tt.field {
+				t.Errorf("Expected error on %s, got %v", tt.field, err)
+			}
+		})
+	}
+}
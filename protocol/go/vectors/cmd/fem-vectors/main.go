@@ -0,0 +1,43 @@
+// fem-vectors generates the protocol's golden test vectors and verifies a
+// vector directory against the Go implementation.
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/fep-fem/protocol/vectors"
+)
+
+func main() {
+	if len(os.Args) != 3 || (os.Args[1] != "generate" && os.Args[1] != "verify") {
+		fmt.Fprintln(os.Stderr, "Usage: fem-vectors generate|verify <dir>")
+		os.Exit(2)
+	}
+	dir := os.Args[2]
+
+	if os.Args[1] == "generate" {
+		set, err := vectors.Generate()
+		if err == nil {
+			err = set.Write(dir)
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to generate vectors: %v\n", err)
+			os.Exit(1)
+		}
+		fmt.Printf("Wrote %d keys, %d envelopes, %d broker responses and %d capability tokens to %s\n",
+			len(set.Keys), len(set.Envelopes), len(set.BrokerResponses), len(set.Capabilities), dir)
+		return
+	}
+
+	set, err := vectors.Load(dir)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to load vectors: %v\n", err)
+		os.Exit(1)
+	}
+	if err := vectors.Verify(set); err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		os.Exit(1)
+	}
+	fmt.Printf("All %d vectors in %s verify\n", len(set.Keys)+len(set.Envelopes)+len(set.BrokerResponses)+len(set.Capabilities), dir)
+}
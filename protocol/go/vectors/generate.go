@@ -0,0 +1,272 @@
+package vectors
+
+import (
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/fep-fem/protocol"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// Fixed inputs of the generated vectors
+const (
+	vectorTS     int64 = 1700000000000 // 2023-11-14T22:13:20Z
+	vectorSecret       = "fem-test-vector-secret"
+)
+
+// Capability token lifetimes: roots never expire in practice, the expired
+// token lapsed long ago
+var (
+	capabilityIssued  = time.Unix(1700000000, 0)
+	capabilityExpires = time.Unix(4102444800, 0) // 2100-01-01
+	capabilityLapsed  = time.Unix(1700003600, 0)
+)
+
+// keyNames are the vector keys; each seed is the SHA-256 of
+// "fem test vector key: <name>"
+var keyNames = []string{"agent", "helper", "broker", "other"}
+
+// Generate builds the vector set from the fixed keys and inputs. The result
+// is identical on every run.
+func Generate() (*Set, error) {
+	set := &Set{}
+	for _, name := range keyNames {
+		seed := sha256.Sum256([]byte("fem test vector key: " + name))
+		public := ed25519.NewKeyFromSeed(seed[:]).Public().(ed25519.PublicKey)
+		set.Keys = append(set.Keys, Key{
+			Name:      name,
+			Seed:      hex.EncodeToString(seed[:]),
+			PublicKey: protocol.EncodePublicKey(public),
+			KeyID:     protocol.KeyID(public),
+			DID:       protocol.DIDKey(public),
+		})
+	}
+
+	g := &generator{set: set}
+	g.envelopes()
+	g.brokerResponses()
+	g.capabilities()
+	if g.err != nil {
+		return nil, g.err
+	}
+	return set, nil
+}
+
+// generator accumulates vectors, keeping the first error
+type generator struct {
+	set *Set
+	err error
+}
+
+func (g *generator) fail(vector string, err error) {
+	if g.err == nil && err != nil {
+		g.err = fmt.Errorf("%s: %w", vector, err)
+	}
+}
+
+// mustKey returns a fixed key; the names are known to exist
+func (g *generator) mustKey(name string) (ed25519.PrivateKey, ed25519.PublicKey) {
+	private, public, err := g.set.key(name)
+	g.fail(name, err)
+	return private, public
+}
+
+// headers returns the fixed headers of an envelope from agent
+func headers(agent, nonce, proto string) protocol.CommonHeaders {
+	return protocol.CommonHeaders{Agent: agent, TS: vectorTS, Nonce: nonce, Proto: proto}
+}
+
+func (g *generator) envelopes() {
+	agentKey, agentPublic := g.mustKey("agent")
+	_, otherPublic := g.mustKey("other")
+	call := `{"tool":"calc-agent/math.add","parameters":{"b":2,"a":1},"requestId":"req-1"}`
+
+	// Pre-0.4.0 senders did not set kid, so the legacy vectors are signed by hand
+	legacy := func(name, description, body string) *protocol.Envelope {
+		envelope := &protocol.Envelope{Type: protocol.EnvelopeToolCall, CommonHeaders: headers("vector-agent", name, ""), Body: json.RawMessage(body)}
+		data, err := protocol.SigningBytes(envelope.Type, envelope.CommonHeaders, envelope.Body)
+		g.fail(name, err)
+		envelope.Sig = base64.StdEncoding.EncodeToString(ed25519.Sign(agentKey, data))
+		g.addEnvelope(name, description, "agent", envelope, true)
+		return envelope
+	}
+	legacyCall := legacy("legacy-tool-call",
+		"Sender without proto: legacy signing bytes keep Go struct order for headers and the body's own member order",
+		call)
+	legacy("legacy-html-escaping",
+		"Legacy signing bytes escape <, > and & in strings as \\u003c, \\u003e and \\u0026, like Go's encoding/json",
+		`{"tool":"calc-agent/math.compare","parameters":{"expr":"a<b && c>d"},"requestId":"req-2"}`)
+
+	canonical := &protocol.Envelope{
+		Type:          protocol.EnvelopeToolCall,
+		CommonHeaders: headers("vector-agent", "canonical-tool-call", protocol.CanonicalSigningVersion),
+		Body:          json.RawMessage(`{"tool":"calc-agent/math.add","parameters":{"b":2.50,"a":1e21,"note":"café 😀 a<b","z":-0,"nested":{"y":[true,null],"x":"\u000f"}},"requestId":"req-3"}`),
+	}
+	g.fail("canonical-tool-call", canonical.Sign(agentKey))
+	g.addEnvelope("canonical-tool-call",
+		"Sender at 0.4.0: RFC 8785 signing bytes sort members, reformat numbers and leave non-ASCII and <, > and & unescaped",
+		"agent", canonical, true)
+
+	registration := protocol.RegisterAgentBody{
+		PubKey:       protocol.EncodePublicKey(agentPublic),
+		Capabilities: []string{"math.add", "math.subtract"},
+		MCPEndpoint:  "https://agent.example/mcp",
+	}
+	body, err := json.Marshal(registration)
+	g.fail("register-agent-did-key", err)
+	register := &protocol.Envelope{Type: protocol.EnvelopeRegisterAgent, CommonHeaders: headers(protocol.DIDKey(agentPublic), "register-agent-did-key", protocol.ProtocolVersion), Body: body}
+	register.Seq = 7
+	register.Locale = "de-CH"
+	register.Accept = []string{"text/markdown", "text/plain"}
+	g.fail("register-agent-did-key", register.Sign(agentKey))
+	g.addEnvelope("register-agent-did-key",
+		"did:key agent with seq, locale and accept headers, all covered by the signature",
+		"agent", register, true)
+
+	detached := &protocol.Envelope{
+		Type:          protocol.EnvelopeToolResult,
+		CommonHeaders: headers("vector-agent", "detached-tool-result", protocol.ProtocolVersion),
+		Body:          json.RawMessage(`{"requestId":"req-1","success":true,"result":{"sum":3}}`),
+	}
+	g.fail("detached-tool-result", detached.SignDetached(agentKey, protocol.DigestSHA256))
+	g.addEnvelope("detached-tool-result",
+		"Detached signature: the digest header holds the SHA-256 of the body bytes as carried, and the signing bytes carry no body",
+		"agent", detached, true)
+
+	tampered := *canonical
+	tampered.Body = json.RawMessage(`{"tool":"calc-agent/math.add","parameters":{"a":1,"b":3},"requestId":"req-3"}`)
+	g.addEnvelope("tampered-body", "canonical-tool-call with its body changed after signing", "agent", &tampered, false)
+
+	tamperedDetached := *detached
+	tamperedDetached.Body = json.RawMessage(`{"requestId":"req-1","success":true,"result":{"sum":4}}`)
+	g.addEnvelope("tampered-detached-body", "detached-tool-result with a body that no longer matches its digest", "agent", &tamperedDetached, false)
+
+	g.addEnvelope("wrong-key", "legacy-tool-call, which has no kid, verified with a key other than the signer's", "other", legacyCall, false)
+
+	// A kid naming another key is signed over but must still be refused
+	mislabelled := &protocol.Envelope{Type: protocol.EnvelopeToolCall, CommonHeaders: headers("vector-agent", "kid-mismatch", protocol.ProtocolVersion), Body: json.RawMessage(call)}
+	mislabelled.KID = protocol.KeyID(otherPublic)
+	data, err := protocol.SigningBytes(mislabelled.Type, mislabelled.CommonHeaders, mislabelled.Body)
+	g.fail("kid-mismatch", err)
+	mislabelled.Sig = base64.StdEncoding.EncodeToString(ed25519.Sign(agentKey, data))
+	g.addEnvelope("kid-mismatch", "Correct signature, but the kid header names a different key than the one verifying", "agent", mislabelled, false)
+}
+
+// addEnvelope records envelope as it is sent on the wire
+func (g *generator) addEnvelope(name, description, key string, envelope *protocol.Envelope, valid bool) {
+	wire, err := json.Marshal(envelope)
+	g.fail(name, err)
+	input, err := protocol.SigningBytes(envelope.Type, envelope.CommonHeaders, envelope.Body)
+	g.fail(name, err)
+	g.set.Envelopes = append(g.set.Envelopes, EnvelopeVector{
+		Name:         name,
+		Description:  description,
+		Key:          key,
+		Envelope:     string(wire),
+		SigningInput: string(input),
+		Signature:    envelope.Sig,
+		Valid:        valid,
+	})
+}
+
+// brokerResponseMessage is the message a broker signs for a response:
+// "fem-broker-response-v1" || 0x00 || request nonce || 0x00 || SHA-256(body)
+func brokerResponseMessage(requestNonce string, body []byte) []byte {
+	digest := sha256.Sum256(body)
+	msg := append([]byte("fem-broker-response-v1\x00"), requestNonce...)
+	msg = append(msg, 0)
+	return append(msg, digest[:]...)
+}
+
+func (g *generator) brokerResponses() {
+	brokerKey, _ := g.mustKey("broker")
+	body := `{"type":"ack","agent":"broker-1","ts":1700000000000,"nonce":"ack-1","body":{"status":"registered","ref":"register-agent-did-key"}}`
+	signature, err := protocol.SignBrokerResponse(brokerKey, "register-agent-did-key", []byte(body))
+	g.fail("broker-ack", err)
+
+	add := func(name, description, nonce string, valid bool) {
+		g.set.BrokerResponses = append(g.set.BrokerResponses, BrokerResponseVector{
+			Name:         name,
+			Description:  description,
+			Key:          "broker",
+			RequestNonce: nonce,
+			Body:         body,
+			Message:      hex.EncodeToString(brokerResponseMessage(nonce, []byte(body))),
+			Signature:    signature,
+			Valid:        valid,
+		})
+	}
+	add("broker-ack", "Broker ack answering the register-agent-did-key envelope", "register-agent-did-key", true)
+	add("replayed-response", "The same response replayed for another request, so the signed nonce does not match", "canonical-tool-call", false)
+}
+
+func (g *generator) capabilities() {
+	brokerKey, _ := g.mustKey("broker")
+	agentKey, agentPublic := g.mustKey("agent")
+	_, helperPublic := g.mustKey("helper")
+	otherKey, _ := g.mustKey("other")
+
+	claims := func(id, issuer, subject string, permissions []string, expires time.Time) protocol.Capability {
+		return protocol.Capability{
+			RegisteredClaims: jwt.RegisteredClaims{
+				IssuedAt:  jwt.NewNumericDate(capabilityIssued),
+				ExpiresAt: jwt.NewNumericDate(expires),
+				ID:        id,
+			},
+			Scope:       "tools",
+			Permissions: permissions,
+			Issuer:      issuer,
+			Subject:     subject,
+		}
+	}
+	eddsa := func(capability protocol.Capability, key ed25519.PrivateKey, kid bool) string {
+		token := jwt.NewWithClaims(jwt.SigningMethodEdDSA, capability)
+		if kid {
+			token.Header["kid"] = protocol.KeyID(key.Public().(ed25519.PublicKey))
+		}
+		signed, err := token.SignedString(key)
+		g.fail(capability.ID, err)
+		return signed
+	}
+	add := func(name, description, issuer, secret, token string, capability protocol.Capability, valid bool) {
+		encoded, err := json.Marshal(capability)
+		g.fail(name, err)
+		g.set.Capabilities = append(g.set.Capabilities, CapabilityVector{
+			Name: name, Description: description, Issuer: issuer, Secret: secret, Token: token, Claims: encoded, Valid: valid,
+		})
+	}
+
+	root := claims("cap-root", "broker-1", "vector-agent", []string{"call:math.*", "discover:math.*"}, capabilityExpires)
+	root.Holder = protocol.EncodePublicKey(agentPublic)
+	rootToken := eddsa(root, brokerKey, true)
+	add("eddsa-root", "EdDSA token from the broker, bound to the agent's key so it can be delegated, with the issuer's kid",
+		"broker", "", rootToken, root, true)
+
+	delegated := claims("cap-delegated", "vector-agent", "helper-agent", []string{"call:math.add"}, capabilityExpires)
+	delegated.Holder = protocol.EncodePublicKey(helperPublic)
+	delegated.Parent = rootToken
+	add("delegated", "eddsa-root narrowed to call:math.add and signed by the agent for helper-agent; parent carries the whole root token",
+		"broker", "", eddsa(delegated, agentKey, false), delegated, true)
+
+	widened := claims("cap-widened", "vector-agent", "helper-agent", []string{"call:*"}, capabilityExpires)
+	widened.Parent = rootToken
+	add("delegation-widens", "Delegation granting call:*, more than the parent's call:math.*",
+		"broker", "", eddsa(widened, agentKey, false), widened, false)
+
+	legacy := claims("cap-hs256", "broker-1", "vector-agent", []string{"call:math.add"}, capabilityExpires)
+	hmac, err := jwt.NewWithClaims(jwt.SigningMethodHS256, legacy).SignedString([]byte(vectorSecret))
+	g.fail("hs256-legacy", err)
+	add("hs256-legacy", "Legacy HS256 token made with a secret shared by issuer and verifier", "", vectorSecret, hmac, legacy, true)
+
+	expired := claims("cap-expired", "broker-1", "vector-agent", []string{"call:math.add"}, capabilityLapsed)
+	add("expired", "EdDSA token that expired an hour after it was issued", "broker", "", eddsa(expired, brokerKey, true), expired, false)
+
+	untrusted := claims("cap-untrusted", "broker-1", "vector-agent", []string{"call:math.add"}, capabilityExpires)
+	add("untrusted-issuer", "EdDSA token claiming the broker as issuer but signed by another key", "broker", "",
+		eddsa(untrusted, otherKey, true), untrusted, false)
+}
@@ -0,0 +1,152 @@
+// Package vectors generates and verifies the protocol's golden test vectors:
+// fixed keys with the envelopes, broker responses and capability tokens they
+// sign, shipped as JSON in protocol/testvectors. Ed25519 signatures are
+// deterministic, so an implementation in any language that signs the same
+// input with the same key must reproduce the shipped bytes exactly.
+package vectors
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// Key is a fixed signing key used by the vectors
+type Key struct {
+	Name      string `json:"name"`
+	Seed      string `json:"seed"`      // Hex Ed25519 private key seed (RFC 8032)
+	PublicKey string `json:"publicKey"` // Base64, as carried in pubKey fields
+	KeyID     string `json:"kid"`       // Value of the kid header, see protocol.KeyID
+	DID       string `json:"did"`       // did:key identifier bound to the key
+}
+
+// privateKey returns the key's Ed25519 private key
+func (k Key) privateKey() (ed25519.PrivateKey, error) {
+	seed, err := hex.DecodeString(k.Seed)
+	if err != nil || len(seed) != ed25519.SeedSize {
+		return nil, fmt.Errorf("key %s: seed must be %d hex bytes", k.Name, ed25519.SeedSize)
+	}
+	return ed25519.NewKeyFromSeed(seed), nil
+}
+
+// EnvelopeVector is a signed envelope as carried on the wire. Verifying the
+// envelope with the named key must succeed exactly when Valid is set, and the
+// signing bytes computed from it must equal SigningInput either way.
+type EnvelopeVector struct {
+	Name         string `json:"name"`
+	Description  string `json:"description"`
+	Key          string `json:"key"`          // Key to verify with
+	Envelope     string `json:"envelope"`     // Wire bytes; detached digests cover the body exactly as written
+	SigningInput string `json:"signingInput"` // Bytes the signature covers
+	Signature    string `json:"signature"`    // Base64 sig header
+	Valid        bool   `json:"valid"`
+}
+
+// BrokerResponseVector is a broker's signature over a response body bound to
+// the nonce of the request it answers
+type BrokerResponseVector struct {
+	Name         string `json:"name"`
+	Description  string `json:"description"`
+	Key          string `json:"key"`          // Broker key presented in X-FEM-Broker-Key
+	RequestNonce string `json:"requestNonce"` // Nonce of the request the response answers
+	Body         string `json:"body"`         // Response body bytes
+	Message      string `json:"message"`      // Hex of the bytes signed for this nonce and body
+	Signature    string `json:"signature"`    // Base64 X-FEM-Broker-Signature header
+	Valid        bool   `json:"valid"`
+}
+
+// CapabilityVector is a capability token and whether a verifier trusting
+// the named issuer key, or holding the shared secret, accepts it
+type CapabilityVector struct {
+	Name        string          `json:"name"`
+	Description string          `json:"description"`
+	Issuer      string          `json:"issuer,omitempty"` // Key trusted to issue EdDSA root tokens
+	Secret      string          `json:"secret,omitempty"` // Shared secret of legacy HS256 tokens
+	Token       string          `json:"token"`
+	Claims      json.RawMessage `json:"claims"` // Claims the token carries
+	Valid       bool            `json:"valid"`
+}
+
+// Set is every vector, stored as one JSON file per field
+type Set struct {
+	Keys            []Key
+	Envelopes       []EnvelopeVector
+	BrokerResponses []BrokerResponseVector
+	Capabilities    []CapabilityVector
+}
+
+// files maps each file of a vector directory to the field it holds
+func (s *Set) files() map[string]interface{} {
+	return map[string]interface{}{
+		"keys.json":             &s.Keys,
+		"envelopes.json":        &s.Envelopes,
+		"broker-responses.json": &s.BrokerResponses,
+		"capabilities.json":     &s.Capabilities,
+	}
+}
+
+// Load reads a vector set from dir
+func Load(dir string) (*Set, error) {
+	set := &Set{}
+	for name, field := range set.files() {
+		data, err := os.ReadFile(filepath.Join(dir, name))
+		if err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal(data, field); err != nil {
+			return nil, fmt.Errorf("invalid %s: %w", name, err)
+		}
+	}
+	return set, nil
+}
+
+// Encode returns the contents of each file of the set
+func (s *Set) Encode() (map[string][]byte, error) {
+	encoded := make(map[string][]byte)
+	for name, field := range s.files() {
+		var buf bytes.Buffer
+		encoder := json.NewEncoder(&buf)
+		encoder.SetEscapeHTML(false)
+		encoder.SetIndent("", "  ")
+		if err := encoder.Encode(field); err != nil {
+			return nil, fmt.Errorf("failed to encode %s: %w", name, err)
+		}
+		encoded[name] = buf.Bytes()
+	}
+	return encoded, nil
+}
+
+// Write stores the set in dir, replacing any vectors there
+func (s *Set) Write(dir string) error {
+	encoded, err := s.Encode()
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	for name, data := range encoded {
+		if err := os.WriteFile(filepath.Join(dir, name), data, 0644); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// key returns the private and public key called name
+func (s *Set) key(name string) (ed25519.PrivateKey, ed25519.PublicKey, error) {
+	for _, key := range s.Keys {
+		if key.Name == name {
+			private, err := key.privateKey()
+			if err != nil {
+				return nil, nil, err
+			}
+			return private, private.Public().(ed25519.PublicKey), nil
+		}
+	}
+	return nil, nil, fmt.Errorf("unknown key %s", name)
+}
@@ -0,0 +1,72 @@
+package vectors
+
+import (
+	"bytes"
+	"flag"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+var update = flag.Bool("update", false, "Regenerate the shipped test vectors")
+
+// shippedDir holds the vectors other implementations test against
+const shippedDir = "../../testvectors"
+
+func TestShippedVectorsAreCurrent(t *testing.T) {
+	set, err := Generate()
+	if err != nil {
+		t.Fatalf("Failed to generate vectors: %v", err)
+	}
+	if *update {
+		if err := set.Write(shippedDir); err != nil {
+			t.Fatalf("Failed to write vectors: %v", err)
+		}
+	}
+
+	encoded, err := set.Encode()
+	if err != nil {
+		t.Fatalf("Failed to encode vectors: %v", err)
+	}
+	for name, data := range encoded {
+		shipped, err := os.ReadFile(filepath.Join(shippedDir, name))
+		if err != nil {
+			t.Fatalf("Failed to read shipped vectors: %v", err)
+		}
+		if !bytes.Equal(shipped, data) {
+			t.Errorf("%s differs from the generated vectors; run go test ./vectors -update if the change is intended", name)
+		}
+	}
+}
+
+func TestShippedVectorsVerify(t *testing.T) {
+	set, err := Load(shippedDir)
+	if err != nil {
+		t.Fatalf("Failed to load vectors: %v", err)
+	}
+	if err := Verify(set); err != nil {
+		t.Errorf("Shipped vectors do not verify:\n%v", err)
+	}
+	if len(set.Envelopes) == 0 || len(set.BrokerResponses) == 0 || len(set.Capabilities) == 0 {
+		t.Errorf("Expected vectors of every kind, got %d envelopes, %d broker responses and %d capabilities",
+			len(set.Envelopes), len(set.BrokerResponses), len(set.Capabilities))
+	}
+}
+
+func TestVerifyReportsMismatches(t *testing.T) {
+	set, err := Generate()
+	if err != nil {
+		t.Fatalf("Failed to generate vectors: %v", err)
+	}
+	set.Envelopes[0].SigningInput = strings.Replace(set.Envelopes[0].SigningInput, `"b":2`, `"b":3`, 1)
+	set.Envelopes[1].Valid = false
+	set.Capabilities[0].Valid = false
+
+	err = Verify(set)
+	for _, want := range []string{"envelope legacy-tool-call: signing bytes differ", "envelope legacy-html-escaping: verified, but the vector is invalid", "capability eddsa-root"} {
+		if err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("Expected an error containing %q, got %v", want, err)
+		}
+	}
+}
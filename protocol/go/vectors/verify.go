@@ -0,0 +1,148 @@
+package vectors
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/fep-fem/protocol"
+)
+
+// Verify checks every vector of set against this implementation: keys
+// derive the listed public keys, IDs and DIDs, signing bytes and signatures
+// match, and exactly the vectors marked valid verify. It returns every
+// mismatch found.
+func Verify(set *Set) error {
+	var errs []error
+	check := func(kind, name string, err error) {
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s %s: %w", kind, name, err))
+		}
+	}
+	for _, key := range set.Keys {
+		check("key", key.Name, verifyKey(key))
+	}
+	for _, vector := range set.Envelopes {
+		check("envelope", vector.Name, set.verifyEnvelope(vector))
+	}
+	for _, vector := range set.BrokerResponses {
+		check("broker response", vector.Name, set.verifyBrokerResponse(vector))
+	}
+	for _, vector := range set.Capabilities {
+		check("capability", vector.Name, set.verifyCapability(vector))
+	}
+	return errors.Join(errs...)
+}
+
+func verifyKey(key Key) error {
+	private, err := key.privateKey()
+	if err != nil {
+		return err
+	}
+	public := private.Public().(ed25519.PublicKey)
+	switch {
+	case protocol.EncodePublicKey(public) != key.PublicKey:
+		return fmt.Errorf("seed derives public key %s, vector lists %s", protocol.EncodePublicKey(public), key.PublicKey)
+	case protocol.KeyID(public) != key.KeyID:
+		return fmt.Errorf("key ID is %s, vector lists %s", protocol.KeyID(public), key.KeyID)
+	case protocol.DIDKey(public) != key.DID:
+		return fmt.Errorf("did:key is %s, vector lists %s", protocol.DIDKey(public), key.DID)
+	}
+	return nil
+}
+
+func (s *Set) verifyEnvelope(vector EnvelopeVector) error {
+	_, public, err := s.key(vector.Key)
+	if err != nil {
+		return err
+	}
+	envelope, err := protocol.ParseEnvelope([]byte(vector.Envelope))
+	if err != nil {
+		return err
+	}
+	input, err := protocol.SigningBytes(envelope.Type, envelope.CommonHeaders, envelope.Body)
+	if err != nil {
+		return err
+	}
+	if string(input) != vector.SigningInput {
+		return fmt.Errorf("signing bytes differ:\n got  %s\n want %s", input, vector.SigningInput)
+	}
+	if envelope.Sig != vector.Signature {
+		return fmt.Errorf("envelope carries sig %s, vector lists %s", envelope.Sig, vector.Signature)
+	}
+	return expectValid(vector.Valid, envelope.Verify(public))
+}
+
+func (s *Set) verifyBrokerResponse(vector BrokerResponseVector) error {
+	_, public, err := s.key(vector.Key)
+	if err != nil {
+		return err
+	}
+	if message := hex.EncodeToString(brokerResponseMessage(vector.RequestNonce, []byte(vector.Body))); message != vector.Message {
+		return fmt.Errorf("signed message is %s, vector lists %s", message, vector.Message)
+	}
+	header := http.Header{}
+	header.Set(protocol.BrokerKeyHeader, protocol.EncodePublicKey(public))
+	header.Set(protocol.BrokerSignatureHeader, vector.Signature)
+	_, err = protocol.VerifyBrokerResponse(header, vector.RequestNonce, []byte(vector.Body))
+	return expectValid(vector.Valid, err)
+}
+
+func (s *Set) verifyCapability(vector CapabilityVector) error {
+	parts := strings.Split(vector.Token, ".")
+	if len(parts) != 3 {
+		return fmt.Errorf("token is not a JWT")
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return fmt.Errorf("invalid token payload: %w", err)
+	}
+	if err := sameJSON(payload, vector.Claims); err != nil {
+		return fmt.Errorf("token claims differ from the vector's: %w", err)
+	}
+
+	var manager *protocol.CapabilityManager
+	if vector.Secret != "" {
+		manager = protocol.NewCapabilityManager([]byte(vector.Secret))
+	} else {
+		_, public, err := s.key(vector.Issuer)
+		if err != nil {
+			return err
+		}
+		manager = protocol.NewCapabilityVerifier(public)
+	}
+	_, err = manager.ValidateCapability(vector.Token)
+	return expectValid(vector.Valid, err)
+}
+
+// expectValid reports a verification outcome that differs from the vector's
+func expectValid(valid bool, err error) error {
+	switch {
+	case valid && err != nil:
+		return fmt.Errorf("expected to verify: %w", err)
+	case !valid && err == nil:
+		return fmt.Errorf("verified, but the vector is invalid")
+	}
+	return nil
+}
+
+// sameJSON compares two JSON documents ignoring formatting
+func sameJSON(a, b []byte) error {
+	canonicalA, err := protocol.Canonicalize(a)
+	if err != nil {
+		return err
+	}
+	canonicalB, err := protocol.Canonicalize(b)
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(canonicalA, canonicalB) {
+		return fmt.Errorf("%s != %s", canonicalA, canonicalB)
+	}
+	return nil
+}
@@ -0,0 +1,105 @@
+package protocol
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// ProtocolVersion is the protocol version this package speaks
+const ProtocolVersion = "0.4.0"
+
+// ErrUnsupportedVersion is returned when a peer's protocol version is below the accepted minimum
+var ErrUnsupportedVersion = errors.New("unsupported protocol version")
+
+// Version is a major.minor.patch protocol version
+type Version struct {
+	Major int
+	Minor int
+	Patch int
+}
+
+// ParseVersion parses "1", "1.2" or "1.2.3", with an optional leading "v".
+// Missing components are zero.
+func ParseVersion(s string) (Version, error) {
+	parts := strings.Split(strings.TrimPrefix(s, "v"), ".")
+	if len(parts) > 3 {
+		return Version{}, fmt.Errorf("invalid version %q", s)
+	}
+
+	var numbers [3]int
+	for i, part := range parts {
+		n, err := strconv.Atoi(part)
+		if err != nil || n < 0 {
+			return Version{}, fmt.Errorf("invalid version %q", s)
+		}
+		numbers[i] = n
+	}
+	return Version{Major: numbers[0], Minor: numbers[1], Patch: numbers[2]}, nil
+}
+
+func (v Version) String() string {
+	return fmt.Sprintf("%d.%d.%d", v.Major, v.Minor, v.Patch)
+}
+
+// Compare returns -1, 0 or 1 as v is older than, equal to or newer than other
+func (v Version) Compare(other Version) int {
+	switch {
+	case v.Major != other.Major:
+		return compareInts(v.Major, other.Major)
+	case v.Minor != other.Minor:
+		return compareInts(v.Minor, other.Minor)
+	default:
+		return compareInts(v.Patch, other.Patch)
+	}
+}
+
+func compareInts(a, b int) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	default:
+		return 0
+	}
+}
+
+// CompareVersions parses and compares two version strings
+func CompareVersions(a, b string) (int, error) {
+	va, err := ParseVersion(a)
+	if err != nil {
+		return 0, err
+	}
+	vb, err := ParseVersion(b)
+	if err != nil {
+		return 0, err
+	}
+	return va.Compare(vb), nil
+}
+
+// NegotiateVersion picks the version to use with a peer that offered the
+// given proto header. Peers newer than this package are downgraded to
+// ProtocolVersion; older peers are answered in their own version unless it is
+// below minimum. An empty offer comes from a peer that predates version
+// negotiation and is treated as 0.0.0.
+func NegotiateVersion(offered string, minimum Version) (Version, error) {
+	peer := Version{}
+	if offered != "" {
+		var err error
+		if peer, err = ParseVersion(offered); err != nil {
+			return Version{}, fmt.Errorf("%w: %v", ErrUnsupportedVersion, err)
+		}
+	}
+
+	if peer.Compare(minimum) < 0 {
+		return Version{}, fmt.Errorf("%w: %s is below minimum %s", ErrUnsupportedVersion, peer, minimum)
+	}
+
+	current, _ := ParseVersion(ProtocolVersion)
+	if peer.Compare(current) > 0 {
+		return current, nil
+	}
+	return peer, nil
+}
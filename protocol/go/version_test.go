@@ -0,0 +1,90 @@
+package protocol
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestParseVersion(t *testing.T) {
+	tests := []struct {
+		input string
+		want  Version
+	}{
+		{"0.3.0", Version{0, 3, 0}},
+		{"v1.2.3", Version{1, 2, 3}},
+		{"2", Version{2, 0, 0}},
+		{"1.4", Version{1, 4, 0}},
+	}
+	for _, tt := range tests {
+		got, err := ParseVersion(tt.input)
+		if err != nil || got != tt.want {
+			t.Errorf("ParseVersion(%q) = %v, %v; want %v", tt.input, got, err, tt.want)
+		}
+	}
+
+	for _, input := range []string{"", "1.2.3.4", "one", "1.-2", "1..2"} {
+		if _, err := ParseVersion(input); err == nil {
+			t.Errorf("Expected %q to be rejected", input)
+		}
+	}
+}
+
+func TestCompareVersions(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want int
+	}{
+		{"0.3.0", "0.3.0", 0},
+		{"0.3", "0.3.0", 0},
+		{"0.2.9", "0.3.0", -1},
+		{"1.0.0", "0.9.9", 1},
+		{"0.3.10", "0.3.9", 1},
+	}
+	for _, tt := range tests {
+		if got, err := CompareVersions(tt.a, tt.b); err != nil || got != tt.want {
+			t.Errorf("CompareVersions(%q, %q) = %d, %v; want %d", tt.a, tt.b, got, err, tt.want)
+		}
+	}
+}
+
+func TestNegotiateVersion(t *testing.T) {
+	minimum := Version{0, 2, 0}
+
+	if v, err := NegotiateVersion(ProtocolVersion, minimum); err != nil || v.String() != ProtocolVersion {
+		t.Errorf("Same version should be kept, got %v, %v", v, err)
+	}
+	if v, err := NegotiateVersion("0.2.5", minimum); err != nil || v != (Version{0, 2, 5}) {
+		t.Errorf("Older supported peer should be answered in its version, got %v, %v", v, err)
+	}
+	if v, err := NegotiateVersion("9.0.0", minimum); err != nil || v.String() != ProtocolVersion {
+		t.Errorf("Newer peer should be downgraded to %s, got %v, %v", ProtocolVersion, v, err)
+	}
+	if _, err := NegotiateVersion("0.1.0", minimum); !errors.Is(err, ErrUnsupportedVersion) {
+		t.Errorf("Peer below minimum should be rejected, got %v", err)
+	}
+	if _, err := NegotiateVersion("", minimum); !errors.Is(err, ErrUnsupportedVersion) {
+		t.Errorf("Unversioned peer should be rejected when a minimum is set, got %v", err)
+	}
+	if v, err := NegotiateVersion("", Version{}); err != nil || v != (Version{}) {
+		t.Errorf("Unversioned peer should be accepted without a minimum, got %v, %v", v, err)
+	}
+	if _, err := NegotiateVersion("garbage", Version{}); !errors.Is(err, ErrUnsupportedVersion) {
+		t.Errorf("Unparseable version should be rejected, got %v", err)
+	}
+}
+
+func TestProtoHeaderIsSigned(t *testing.T) {
+	pubKey, privKey, _ := GenerateKeyPair()
+	envelope, err := NewHeartbeat("agent").SignWith(privKey)
+	if err != nil {
+		t.Fatalf("Failed to build heartbeat: %v", err)
+	}
+	if envelope.Proto != ProtocolVersion {
+		t.Errorf("Builders should stamp the protocol version, got %q", envelope.Proto)
+	}
+
+	envelope.Proto = "0.1.0"
+	if err := verifyEnvelope(envelope.Type, envelope.CommonHeaders, envelope.Body, pubKey); err == nil {
+		t.Error("Changing the proto header should invalidate the signature")
+	}
+}
@@ -0,0 +1,60 @@
+package protocol
+
+import (
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// Headers of webhook requests the broker sends results in. The broker's key
+// travels in BrokerKeyHeader.
+const (
+	WebhookTimestampHeader = "X-FEM-Webhook-Timestamp" // Unix milliseconds the payload was signed at
+	WebhookSignatureHeader = "X-FEM-Webhook-Signature" // Signature over the timestamp and payload
+)
+
+// SignWebhook signs a webhook payload bound to the time it is sent, so a
+// captured request cannot be replayed later
+func SignWebhook(signer Signer, ts int64, payload []byte) (string, error) {
+	signature, err := signMessage(signer, webhookMessage(ts, payload))
+	if err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(signature), nil
+}
+
+// VerifyWebhook checks a webhook request's signature against the broker's
+// pinned key and that it was signed within maxAge of now. A zero maxAge
+// skips the age check.
+func VerifyWebhook(header http.Header, payload []byte, brokerKey ed25519.PublicKey, maxAge time.Duration) error {
+	encodedSig := header.Get(WebhookSignatureHeader)
+	ts, err := strconv.ParseInt(header.Get(WebhookTimestampHeader), 10, 64)
+	if encodedSig == "" || err != nil {
+		return fmt.Errorf("webhook is not signed")
+	}
+	signature, err := base64.StdEncoding.DecodeString(encodedSig)
+	if err != nil {
+		return fmt.Errorf("invalid webhook signature encoding: %w", err)
+	}
+	if !ed25519.Verify(brokerKey, webhookMessage(ts, payload), signature) {
+		return fmt.Errorf("webhook signature verification failed")
+	}
+	if age := time.Since(time.UnixMilli(ts)); maxAge > 0 && (age > maxAge || age < -maxAge) {
+		return fmt.Errorf("webhook signed %s ago, outside %s", age.Round(time.Second), maxAge)
+	}
+	return nil
+}
+
+func webhookMessage(ts int64, payload []byte) []byte {
+	digest := sha256.Sum256(payload)
+	msg := make([]byte, 0, len("fem-webhook-v1")+22+len(digest))
+	msg = append(msg, "fem-webhook-v1"...)
+	msg = append(msg, 0)
+	msg = strconv.AppendInt(msg, ts, 10)
+	msg = append(msg, 0)
+	return append(msg, digest[:]...)
+}
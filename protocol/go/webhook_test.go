@@ -0,0 +1,47 @@
+package protocol
+
+import (
+	"net/http"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestWebhookSignature(t *testing.T) {
+	pubKey, privKey, _ := GenerateKeyPair()
+	payload := []byte(`{"requestId":"r1"}`)
+	sign := func(ts int64) http.Header {
+		signature, err := SignWebhook(privKey, ts, payload)
+		if err != nil {
+			t.Fatalf("Failed to sign webhook: %v", err)
+		}
+		header := http.Header{}
+		header.Set(WebhookTimestampHeader, strconv.FormatInt(ts, 10))
+		header.Set(WebhookSignatureHeader, signature)
+		return header
+	}
+
+	header := sign(time.Now().UnixMilli())
+	if err := VerifyWebhook(header, payload, pubKey, time.Minute); err != nil {
+		t.Fatalf("Valid webhook failed verification: %v", err)
+	}
+	if err := VerifyWebhook(header, []byte(`{"requestId":"r2"}`), pubKey, time.Minute); err == nil {
+		t.Error("Tampered payload should fail verification")
+	}
+	otherKey, _, _ := GenerateKeyPair()
+	if err := VerifyWebhook(header, payload, otherKey, time.Minute); err == nil {
+		t.Error("Webhook signed by another key should fail verification")
+	}
+
+	// Moving the timestamp breaks the signature; an old signature is too old
+	header.Set(WebhookTimestampHeader, strconv.FormatInt(time.Now().UnixMilli()+1, 10))
+	if err := VerifyWebhook(header, payload, pubKey, time.Minute); err == nil {
+		t.Error("Signature should cover the timestamp")
+	}
+	if err := VerifyWebhook(sign(time.Now().Add(-time.Hour).UnixMilli()), payload, pubKey, time.Minute); err == nil {
+		t.Error("Replayed webhook should be rejected as too old")
+	}
+	if err := VerifyWebhook(http.Header{}, payload, pubKey, 0); err == nil {
+		t.Error("Unsigned webhook should fail verification")
+	}
+}
@@ -0,0 +1,51 @@
+package protocol
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+func init() {
+	RegisterEncoding(EncodingZstd, newZstdCodec())
+}
+
+// zstdCodec is the built-in zstd encoding. Its encoder is shared, since
+// EncodeAll is safe for concurrent use; decoders are made per call so each
+// can stop at the caller's size limit.
+type zstdCodec struct {
+	encoder *zstd.Encoder
+}
+
+func newZstdCodec() *zstdCodec {
+	encoder, err := zstd.NewWriter(nil, zstd.WithEncoderConcurrency(1))
+	if err != nil {
+		panic(fmt.Sprintf("zstd encoder: %v", err))
+	}
+	return &zstdCodec{encoder: encoder}
+}
+
+func (c *zstdCodec) Compress(data []byte) ([]byte, error) {
+	return c.encoder.EncodeAll(data, nil), nil
+}
+
+func (c *zstdCodec) Decompress(data []byte, maxSize int) ([]byte, error) {
+	decoder, err := zstd.NewReader(bytes.NewReader(data),
+		zstd.WithDecoderConcurrency(1),
+		zstd.WithDecoderMaxMemory(uint64(maxSize)+1))
+	if err != nil {
+		return nil, fmt.Errorf("invalid zstd data: %w", err)
+	}
+	defer decoder.Close()
+
+	decoded, err := io.ReadAll(io.LimitReader(decoder, int64(maxSize)+1))
+	if err != nil {
+		return nil, fmt.Errorf("invalid zstd data: %w", err)
+	}
+	if len(decoded) > maxSize {
+		return nil, fmt.Errorf("decoded envelope exceeds %d bytes", maxSize)
+	}
+	return decoded, nil
+}
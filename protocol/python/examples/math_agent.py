@@ -0,0 +1,65 @@
+"""A math agent serving add and divide tools, like the Go dev-mode math agent.
+
+    python examples/math_agent.py --broker https://localhost:4433 --insecure-tls
+"""
+
+import argparse
+import logging
+import os
+
+from fem import Agent, KeyPair, ToolError
+
+NUMBERS = {
+    "type": "object",
+    "properties": {"a": {"type": "number"}, "b": {"type": "number"}},
+    "required": ["a", "b"],
+}
+
+
+def operands(arguments):
+    a, b = arguments.get("a"), arguments.get("b")
+    if not all(isinstance(x, (int, float)) and not isinstance(x, bool) for x in (a, b)):
+        raise ToolError("parameters a and b must be numbers")
+    return a, b
+
+
+def main():
+    parser = argparse.ArgumentParser()
+    parser.add_argument("--broker", default="https://localhost:4433", help="Broker URL to connect to")
+    parser.add_argument("--agent", default="py-math-agent", help="Agent identifier")
+    parser.add_argument("--port", type=int, default=0, help="Port for the MCP server (0 picks a free port)")
+    parser.add_argument("--key-file", default="", help="File holding the signing key (default ~/.fem/keys/<agent>.py.key)")
+    parser.add_argument("--insecure-tls", action="store_true", help="Accept the broker's self-signed certificate")
+    args = parser.parse_args()
+    logging.basicConfig(level=logging.INFO)
+
+    key_file = args.key_file or os.path.join(os.path.expanduser("~"), ".fem", "keys", args.agent + ".py.key")
+    agent = Agent(
+        args.agent,
+        args.broker,
+        key=KeyPair.load_or_create(key_file),
+        environment="local-dev",
+        port=args.port,
+        insecure_tls=args.insecure_tls,
+    )
+
+    @agent.tool("math.add", "Adds the numbers a and b", NUMBERS)
+    def add(arguments):
+        a, b = operands(arguments)
+        return a + b
+
+    @agent.tool("math.divide", "Divides the number a by b", NUMBERS)
+    def divide(arguments):
+        a, b = operands(arguments)
+        if b == 0:
+            raise ToolError("division by zero")
+        return a / b
+
+    try:
+        agent.run()
+    except KeyboardInterrupt:
+        agent.stop()
+
+
+if __name__ == "__main__":
+    main()
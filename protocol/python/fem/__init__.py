@@ -0,0 +1,54 @@
+"""Python SDK for the FEM protocol.
+
+Signs and verifies envelopes byte-for-byte like the Go implementation and
+runs agents that register bodies with a broker and serve their tools over MCP.
+"""
+
+from .agent import Agent, BrokerError, Tool, ToolError
+from .canonical import canonicalize
+from .signing import (
+    CANONICAL_SIGNING_VERSION,
+    DIGEST_SHA256,
+    DIGEST_SHA512,
+    PROTOCOL_VERSION,
+    KeyPair,
+    SignatureError,
+    body_digest,
+    broker_response_message,
+    decode_public_key,
+    encode_public_key,
+    key_id,
+    new_envelope,
+    sign_detached,
+    sign_envelope,
+    signing_bytes,
+    uses_canonical_signing,
+    verify_broker_response,
+    verify_envelope,
+)
+
+__all__ = [
+    "Agent",
+    "BrokerError",
+    "CANONICAL_SIGNING_VERSION",
+    "DIGEST_SHA256",
+    "DIGEST_SHA512",
+    "KeyPair",
+    "PROTOCOL_VERSION",
+    "SignatureError",
+    "Tool",
+    "ToolError",
+    "body_digest",
+    "broker_response_message",
+    "canonicalize",
+    "decode_public_key",
+    "encode_public_key",
+    "key_id",
+    "new_envelope",
+    "sign_detached",
+    "sign_envelope",
+    "signing_bytes",
+    "uses_canonical_signing",
+    "verify_broker_response",
+    "verify_envelope",
+]
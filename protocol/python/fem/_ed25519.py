@@ -0,0 +1,150 @@
+"""Ed25519 signatures (RFC 8032).
+
+Uses the ``cryptography`` package when it is installed and falls back to the
+pure-Python reference algorithm from RFC 8032 section 6 otherwise, so the SDK
+has no required dependencies. The fallback is slow (milliseconds per
+operation) and not constant-time; install ``cryptography`` for production
+agents.
+"""
+
+import hashlib
+
+try:
+    from cryptography.exceptions import InvalidSignature
+    from cryptography.hazmat.primitives import serialization
+    from cryptography.hazmat.primitives.asymmetric.ed25519 import (
+        Ed25519PrivateKey,
+        Ed25519PublicKey,
+    )
+except ImportError:  # pragma: no cover - depends on the environment
+    Ed25519PrivateKey = None
+
+
+def public_key(seed: bytes) -> bytes:
+    """Return the 32-byte public key for a 32-byte seed."""
+    if len(seed) != 32:
+        raise ValueError("invalid Ed25519 seed length %d" % len(seed))
+    if Ed25519PrivateKey is not None:
+        key = Ed25519PrivateKey.from_private_bytes(seed)
+        return key.public_key().public_bytes(
+            serialization.Encoding.Raw, serialization.PublicFormat.Raw
+        )
+    a, _ = _expand(seed)
+    return _compress(_mul(a, _G))
+
+
+def sign(seed: bytes, message: bytes) -> bytes:
+    """Return the 64-byte signature of message."""
+    if Ed25519PrivateKey is not None:
+        return Ed25519PrivateKey.from_private_bytes(seed).sign(message)
+    a, prefix = _expand(seed)
+    pub = _compress(_mul(a, _G))
+    r = _hint(prefix + message) % _L
+    big_r = _compress(_mul(r, _G))
+    k = _hint(big_r + pub + message) % _L
+    s = (r + k * a) % _L
+    return big_r + s.to_bytes(32, "little")
+
+
+def verify(public: bytes, message: bytes, signature: bytes) -> bool:
+    """Report whether signature is a valid signature of message by public."""
+    if len(public) != 32 or len(signature) != 64:
+        return False
+    if Ed25519PrivateKey is not None:
+        try:
+            Ed25519PublicKey.from_public_bytes(public).verify(signature, message)
+            return True
+        except (InvalidSignature, ValueError):
+            return False
+    point_a = _decompress(public)
+    point_r = _decompress(signature[:32])
+    if point_a is None or point_r is None:
+        return False
+    s = int.from_bytes(signature[32:], "little")
+    if s >= _L:
+        return False
+    k = _hint(signature[:32] + public + message) % _L
+    return _equal(_mul(s, _G), _add(point_r, _mul(k, point_a)))
+
+
+# Reference implementation, RFC 8032 section 6
+
+_P = 2**255 - 19
+_L = 2**252 + 27742317777372353535851937790883648493
+_D = -121665 * pow(121666, _P - 2, _P) % _P
+_SQRT_M1 = pow(2, (_P - 1) // 4, _P)
+
+
+def _hint(data: bytes) -> int:
+    return int.from_bytes(hashlib.sha512(data).digest(), "little")
+
+
+def _add(p, q):
+    a = (p[1] - p[0]) * (q[1] - q[0]) % _P
+    b = (p[1] + p[0]) * (q[1] + q[0]) % _P
+    c = 2 * p[3] * q[3] * _D % _P
+    d = 2 * p[2] * q[2] % _P
+    e, f, g, h = b - a, d - c, d + c, b + a
+    return (e * f % _P, g * h % _P, f * g % _P, e * h % _P)
+
+
+def _mul(s, p):
+    q = (0, 1, 1, 0)
+    while s > 0:
+        if s & 1:
+            q = _add(q, p)
+        p = _add(p, p)
+        s >>= 1
+    return q
+
+
+def _equal(p, q):
+    if (p[0] * q[2] - q[0] * p[2]) % _P != 0:
+        return False
+    return (p[1] * q[2] - q[1] * p[2]) % _P == 0
+
+
+def _recover_x(y, sign_bit):
+    if y >= _P:
+        return None
+    x2 = (y * y - 1) * pow(_D * y * y + 1, _P - 2, _P)
+    if x2 == 0:
+        return None if sign_bit else 0
+    x = pow(x2, (_P + 3) // 8, _P)
+    if (x * x - x2) % _P != 0:
+        x = x * _SQRT_M1 % _P
+    if (x * x - x2) % _P != 0:
+        return None
+    if (x & 1) != sign_bit:
+        x = _P - x
+    return x
+
+
+_G_Y = 4 * pow(5, _P - 2, _P) % _P
+_G_X = _recover_x(_G_Y, 0)
+_G = (_G_X, _G_Y, 1, _G_X * _G_Y % _P)
+
+
+def _compress(p):
+    z_inv = pow(p[2], _P - 2, _P)
+    x = p[0] * z_inv % _P
+    y = p[1] * z_inv % _P
+    return (y | ((x & 1) << 255)).to_bytes(32, "little")
+
+
+def _decompress(data):
+    y = int.from_bytes(data, "little")
+    sign_bit = y >> 255
+    y &= (1 << 255) - 1
+    x = _recover_x(y, sign_bit)
+    if x is None:
+        return None
+    return (x, y, 1, x * y % _P)
+
+
+def _expand(seed):
+    h = hashlib.sha512(seed).digest()
+    a = int.from_bytes(h[:32], "little")
+    a &= (1 << 254) - 8
+    a |= 1 << 254
+    return a, h[32:]
@@ -0,0 +1,328 @@
+"""A FEM agent: registers a body with a broker and serves its tools over MCP.
+
+The agent speaks the same HTTP interfaces as the Go agents: signed envelopes
+are POSTed to the broker, and the broker calls tools with JSON-RPC
+``tools/call`` requests on the agent's MCP endpoint.
+"""
+
+import json
+import logging
+import ssl
+import threading
+import time
+import urllib.error
+import urllib.request
+from http.server import BaseHTTPRequestHandler, ThreadingHTTPServer
+
+from .signing import (
+    KeyPair,
+    SignatureError,
+    decode_public_key,
+    new_envelope,
+    new_random_id,
+    sign_envelope,
+    verify_broker_response,
+)
+
+log = logging.getLogger("fem.agent")
+
+# Error code the broker sends for envelopes from agents it does not know
+CODE_UNKNOWN_AGENT = "unknown_agent"
+
+# JSON-RPC error codes
+_METHOD_NOT_FOUND = -32601
+_INVALID_PARAMS = -32602
+_INTERNAL_ERROR = -32603
+
+
+class BrokerError(Exception):
+    """The broker rejected an envelope; code is its protocol error code, if it sent one."""
+
+    def __init__(self, message, code="", status=0):
+        super().__init__(message)
+        self.code = code
+        self.status = status
+
+
+class ToolError(Exception):
+    """Raised by a tool handler to report invalid arguments to the caller."""
+
+
+class Tool:
+    def __init__(self, name, handler, description="", input_schema=None):
+        self.name = name
+        self.handler = handler
+        self.description = description
+        self.input_schema = input_schema or {"type": "object"}
+
+    def definition(self) -> dict:
+        """The MCPTool advertised in the agent's body definition."""
+        return {"name": self.name, "description": self.description, "inputSchema": self.input_schema}
+
+
+class Agent:
+    """An agent embodying one body definition.
+
+    Tools are registered with the tool decorator or add_tool before serve.
+    The broker's identity key is pinned on first contact, like the Go agents'
+    in-memory pins, unless broker_key pins it up front.
+    """
+
+    def __init__(
+        self,
+        agent_id,
+        broker_url,
+        key=None,
+        environment="local",
+        body_name=None,
+        host="127.0.0.1",
+        port=0,
+        advertise_url=None,
+        insecure_tls=False,
+        broker_key=None,
+        timeout=10.0,
+    ):
+        self.agent_id = agent_id
+        self.broker_url = broker_url.rstrip("/") + "/"
+        self.key = key or KeyPair.generate()
+        self.environment = environment
+        self.body_name = body_name or agent_id + "-body"
+        self.host = host
+        self.port = port
+        self.advertise_url = advertise_url
+        if isinstance(broker_key, str):
+            broker_key = decode_public_key(broker_key)
+        self.broker_key = broker_key
+        self.timeout = timeout
+        self.tools = {}
+        self.registered = threading.Event()
+        self._in_flight = 0
+        self._lock = threading.Lock()
+        self._started_at = time.monotonic()
+        self._server = None
+        self._stop = threading.Event()
+        self._ssl_context = None
+        if insecure_tls:
+            self._ssl_context = ssl.create_default_context()
+            self._ssl_context.check_hostname = False
+            self._ssl_context.verify_mode = ssl.CERT_NONE
+
+    def tool(self, name, description="", input_schema=None):
+        """Decorator registering a handler taking the call's arguments dict."""
+
+        def decorator(handler):
+            self.add_tool(name, handler, description, input_schema)
+            return handler
+
+        return decorator
+
+    def add_tool(self, name, handler, description="", input_schema=None):
+        self.tools[name] = Tool(name, handler, description, input_schema)
+
+    def body_definition(self) -> dict:
+        names = sorted(self.tools)
+        return {
+            "name": self.body_name,
+            "environment": self.environment,
+            "capabilities": names,
+            "mcpTools": [self.tools[name].definition() for name in names],
+        }
+
+    @property
+    def mcp_endpoint(self) -> str:
+        if self.advertise_url:
+            return self.advertise_url
+        host, port = self._server.server_address[:2]
+        return "http://%s:%d/mcp" % (host, port)
+
+    def serve(self) -> str:
+        """Start the MCP server in a background thread and return its endpoint."""
+        handler = type("Handler", (_MCPHandler,), {"agent": self})
+        self._server = ThreadingHTTPServer((self.host, self.port), handler)
+        self._server.daemon_threads = True
+        threading.Thread(target=self._server.serve_forever, daemon=True).start()
+        log.info("Agent %s serving MCP on %s", self.agent_id, self.mcp_endpoint)
+        return self.mcp_endpoint
+
+    def register(self) -> dict:
+        """Register the body definition with the broker and return the ack body."""
+        body = {
+            "pubkey": self.key.encoded_public_key,
+            "capabilities": sorted(self.tools),
+            "mcpEndpoint": self.mcp_endpoint,
+            "bodyDefinition": self.body_definition(),
+            "environmentType": self.environment,
+        }
+        ack = self.send(new_envelope("registerAgent", self.agent_id, body))
+        self.registered.set()
+        log.info("Agent %s registered with broker %s", self.agent_id, self.broker_url)
+        return ack
+
+    def heartbeat(self) -> dict:
+        body = {
+            "load": 0,
+            "inFlight": self._in_flight,
+            "uptime": int(time.monotonic() - self._started_at),
+        }
+        return self.send(new_envelope("heartbeat", self.agent_id, body))
+
+    def discover_tools(self, capabilities, environment_type="", max_results=0) -> list:
+        """Find tools of other agents matching capability patterns."""
+        query = {"capabilities": list(capabilities), "includeMetadata": True}
+        if environment_type:
+            query["environmentType"] = environment_type
+        if max_results:
+            query["maxResults"] = max_results
+        ack = self.send(new_envelope("discoverTools", self.agent_id, {"query": query, "requestId": new_random_id()}))
+        return (ack.get("result") or {}).get("tools") or []
+
+    def call_tool(self, agent_id, tool, parameters, capability="") -> dict:
+        """Send a toolCall for agent_id's tool and return the broker's ack body."""
+        body = {"tool": "%s/%s" % (agent_id, tool), "parameters": parameters, "requestId": new_random_id()}
+        if capability:
+            body["capability"] = capability
+        return self.send(new_envelope("toolCall", self.agent_id, body))
+
+    def send(self, envelope: dict) -> dict:
+        """Sign and POST an envelope to the broker and return the ack body.
+
+        Raises BrokerError when the broker rejects the envelope and
+        SignatureError when its response is not signed by the pinned key.
+        """
+        sign_envelope(envelope, self.key)
+        data = json.dumps(envelope, separators=(",", ":")).encode("utf-8")
+        request = urllib.request.Request(
+            self.broker_url, data=data, method="POST", headers={"Content-Type": "application/json"}
+        )
+        try:
+            with urllib.request.urlopen(request, timeout=self.timeout, context=self._ssl_context) as response:
+                status, headers, body = response.status, response.headers, response.read()
+        except urllib.error.HTTPError as e:
+            raise _rejection(e.code, e.read())
+
+        if status != 200:
+            raise _rejection(status, body)
+        # Make sure we are still talking to the broker we first contacted
+        broker_key = verify_broker_response(headers, envelope["nonce"], body)
+        if self.broker_key is None:
+            self.broker_key = broker_key
+        elif broker_key != self.broker_key:
+            raise SignatureError("broker identity key changed")
+
+        response = json.loads(body)
+        if response.get("type") != "ack":
+            raise _rejection(status, body)
+        return response.get("body") or {}
+
+    def run(self, heartbeat_interval=30.0):
+        """Serve, register and send heartbeats until stop is called.
+
+        The agent registers again when the broker no longer knows it.
+        """
+        if self._server is None:
+            self.serve()
+        self.register()
+        while not self._stop.wait(heartbeat_interval):
+            try:
+                self.heartbeat()
+            except BrokerError as e:
+                if e.code != CODE_UNKNOWN_AGENT:
+                    log.warning("Heartbeat failed: %s", e)
+                    continue
+                self.registered.clear()
+                log.info("Broker no longer knows agent %s, registering again", self.agent_id)
+                try:
+                    self.register()
+                except (BrokerError, OSError) as e:
+                    log.warning("Registration failed: %s", e)
+            except (SignatureError, OSError) as e:
+                log.warning("Heartbeat failed: %s", e)
+
+    def stop(self):
+        self._stop.set()
+        if self._server is not None:
+            self._server.shutdown()
+            self._server.server_close()
+
+    def call_local(self, name, arguments) -> dict:
+        """Run a tool and return the JSON-RPC response members for its outcome."""
+        tool = self.tools.get(name)
+        if tool is None:
+            return {"error": {"code": _METHOD_NOT_FOUND, "message": "Tool '%s' not found" % name}}
+        with self._lock:
+            self._in_flight += 1
+        try:
+            return {"result": tool.handler(arguments or {})}
+        except ToolError as e:
+            return {"error": {"code": _INVALID_PARAMS, "message": str(e)}}
+        except Exception as e:
+            log.exception("Tool %s failed", name)
+            return {"error": {"code": _INTERNAL_ERROR, "message": str(e)}}
+        finally:
+            with self._lock:
+                self._in_flight -= 1
+
+
+def _rejection(status, body) -> BrokerError:
+    """Turn a failed broker response into an error, keeping the broker's error code."""
+    try:
+        response = json.loads(body)
+        if response.get("type") == "error":
+            rejection = response.get("body") or {}
+            return BrokerError(
+                "broker rejected request: %s: %s" % (rejection.get("code"), rejection.get("message")),
+                code=rejection.get("code", ""),
+                status=status,
+            )
+    except (ValueError, AttributeError):
+        pass
+    return BrokerError("broker returned status %d" % status, status=status)
+
+
+class _MCPHandler(BaseHTTPRequestHandler):
+    """Serves /mcp JSON-RPC requests and the /livez and /readyz probes."""
+
+    agent = None
+
+    def do_GET(self):
+        if self.path == "/livez":
+            self._reply(200, b"OK", "text/plain")
+        elif self.path == "/readyz":
+            if self.agent.registered.is_set():
+                self._reply(200, b"OK", "text/plain")
+            else:
+                self._reply(503, b"NOT REGISTERED", "text/plain")
+        else:
+            self._reply(404, b"Not found", "text/plain")
+
+    def do_POST(self):
+        if self.path != "/mcp":
+            self._reply(404, b"Not found", "text/plain")
+            return
+        try:
+            length = int(self.headers.get("Content-Length", 0))
+            request = json.loads(self.rfile.read(length))
+            method = request["method"]
+        except (ValueError, KeyError, TypeError):
+            self._reply(400, b"Expected a JSON-RPC request", "text/plain")
+            return
+
+        response = {"jsonrpc": "2.0", "id": request.get("id")}
+        params = request.get("params") or {}
+        if method == "tools/call":
+            response.update(self.agent.call_local(params.get("name"), params.get("arguments")))
+        elif method == "tools/list":
+            response["result"] = {"tools": [tool.definition() for tool in self.agent.tools.values()]}
+        else:
+            response["error"] = {"code": _METHOD_NOT_FOUND, "message": "Unsupported method %s" % method}
+        self._reply(200, json.dumps(response).encode("utf-8"), "application/json")
+
+    def _reply(self, status, body, content_type):
+        self.send_response(status)
+        self.send_header("Content-Type", content_type)
+        self.send_header("Content-Length", str(len(body)))
+        self.end_headers()
+        self.wfile.write(body)
+
+    def log_message(self, format, *args):
+        log.debug("MCP %s", format % args)
@@ -0,0 +1,103 @@
+"""JSON Canonicalization Scheme (RFC 8785), matching protocol.Canonicalize in Go."""
+
+import json
+import math
+
+# Integers beyond this lose precision as IEEE 754 doubles, as they do in Go
+_MAX_SAFE_INTEGER = 2**53
+
+
+def canonicalize(value) -> bytes:
+    """Encode a JSON value as RFC 8785 canonical JSON.
+
+    Object members are sorted by their UTF-16 code units, there is no
+    insignificant whitespace, strings escape only quotes, backslashes and
+    control characters, and numbers use ECMAScript formatting.
+    """
+    parts = []
+    _encode(value, parts)
+    return "".join(parts).encode("utf-8")
+
+
+def loads_canonical(data) -> bytes:
+    """Parse a JSON document and return its canonical form, rejecting duplicate keys."""
+    return canonicalize(json.loads(data, object_pairs_hook=_reject_duplicates))
+
+
+def _reject_duplicates(pairs):
+    result = {}
+    for key, value in pairs:
+        if key in result:
+            raise ValueError("canonicalize: duplicate key %r" % key)
+        result[key] = value
+    return result
+
+
+def _encode(value, parts):
+    if value is None:
+        parts.append("null")
+    elif value is True:
+        parts.append("true")
+    elif value is False:
+        parts.append("false")
+    elif isinstance(value, str):
+        parts.append(json.dumps(value, ensure_ascii=False))
+    elif isinstance(value, (int, float)):
+        parts.append(format_number(value))
+    elif isinstance(value, (list, tuple)):
+        parts.append("[")
+        for i, element in enumerate(value):
+            if i > 0:
+                parts.append(",")
+            _encode(element, parts)
+        parts.append("]")
+    elif isinstance(value, dict):
+        parts.append("{")
+        for i, key in enumerate(sorted(value, key=_utf16_key)):
+            if not isinstance(key, str):
+                raise TypeError("canonicalize: object key %r is not a string" % (key,))
+            if i > 0:
+                parts.append(",")
+            parts.append(json.dumps(key, ensure_ascii=False))
+            parts.append(":")
+            _encode(value[key], parts)
+        parts.append("}")
+    else:
+        raise TypeError("canonicalize: cannot encode %s" % type(value).__name__)
+
+
+def _utf16_key(key):
+    return key.encode("utf-16-be", "surrogatepass")
+
+
+def format_number(number) -> str:
+    """Format a number as ECMAScript's Number.prototype.toString does."""
+    if isinstance(number, int) and abs(number) < _MAX_SAFE_INTEGER:
+        return str(number)
+    f = float(number)
+    if math.isnan(f) or math.isinf(f):
+        raise ValueError("canonicalize: number %s is not finite" % number)
+    if f == 0:
+        return "0"
+
+    sign = "-" if f < 0 else ""
+    # repr gives the shortest digits that round-trip, as ECMAScript requires
+    mantissa, _, exponent = repr(abs(f)).partition("e")
+    whole, _, fraction = mantissa.partition(".")
+    raw = whole + fraction
+    leading = len(raw) - len(raw.lstrip("0"))
+    digits = raw[leading:].rstrip("0")
+    # The value is 0.<digits> times 10**n, with n as in ECMAScript
+    n = len(whole) - leading + int(exponent or 0)
+    k = len(digits)
+
+    if k <= n <= 21:
+        text = digits + "0" * (n - k)
+    elif 0 < n <= 21:
+        text = digits[:n] + "." + digits[n:]
+    elif -6 < n <= 0:
+        text = "0." + "0" * -n + digits
+    else:
+        e = n - 1
+        text = digits[0] + ("." + digits[1:] if k > 1 else "") + "e" + ("+" if e >= 0 else "-") + str(abs(e))
+    return sign + text
@@ -0,0 +1,232 @@
+"""Envelope signing and verification, matching protocol/go/signing.go.
+
+Envelopes are plain dicts in their wire form. Only envelopes signed over RFC
+8785 canonical JSON (proto 0.4.0 and later) are supported; the legacy signing
+bytes of older senders depend on Go's JSON encoder.
+"""
+
+import base64
+import hashlib
+import hmac
+import os
+import secrets
+import time
+
+from . import _ed25519
+from .canonical import canonicalize
+
+PROTOCOL_VERSION = "0.4.0"
+CANONICAL_SIGNING_VERSION = "0.4.0"
+
+# Digest algorithms supported for detached signatures
+DIGEST_SHA256 = "sha-256"
+DIGEST_SHA512 = "sha-512"
+
+# Headers carrying a broker's identity on its HTTP responses
+BROKER_KEY_HEADER = "X-FEM-Broker-Key"
+BROKER_SIGNATURE_HEADER = "X-FEM-Broker-Signature"
+
+
+class SignatureError(Exception):
+    """An envelope or broker response signature does not verify."""
+
+
+class KeyPair:
+    """An Ed25519 signing key, held as its 32-byte seed."""
+
+    def __init__(self, seed: bytes):
+        self.seed = bytes(seed)
+        self.public_key = _ed25519.public_key(self.seed)
+
+    @classmethod
+    def generate(cls) -> "KeyPair":
+        return cls(os.urandom(32))
+
+    @classmethod
+    def load_or_create(cls, path: str) -> "KeyPair":
+        """Load a hex seed from path, creating the file on first use so the agent keeps its identity."""
+        try:
+            with open(path) as f:
+                return cls(bytes.fromhex(f.read().strip()))
+        except FileNotFoundError:
+            pass
+        key = cls.generate()
+        os.makedirs(os.path.dirname(path) or ".", exist_ok=True)
+        fd = os.open(path, os.O_WRONLY | os.O_CREAT | os.O_EXCL, 0o600)
+        with os.fdopen(fd, "w") as f:
+            f.write(key.seed.hex() + "\n")
+        return key
+
+    @property
+    def kid(self) -> str:
+        return key_id(self.public_key)
+
+    @property
+    def encoded_public_key(self) -> str:
+        """Base64 public key, as carried in pubkey fields."""
+        return encode_public_key(self.public_key)
+
+    def sign(self, message: bytes) -> bytes:
+        return _ed25519.sign(self.seed, message)
+
+
+def encode_public_key(public_key: bytes) -> str:
+    return base64.b64encode(public_key).decode("ascii")
+
+
+def decode_public_key(encoded: str) -> bytes:
+    data = base64.b64decode(encoded, validate=True)
+    if len(data) != 32:
+        raise ValueError("invalid public key size: got %d, want 32" % len(data))
+    return data
+
+
+def key_id(public_key: bytes) -> str:
+    """Fingerprint of a public key carried in the kid header."""
+    return hashlib.sha256(public_key).digest()[:8].hex()
+
+
+def new_random_id() -> str:
+    return secrets.token_hex(16)
+
+
+def new_envelope(envelope_type: str, agent: str, body) -> dict:
+    """Return an unsigned envelope with fresh ts and nonce headers."""
+    return {
+        "type": envelope_type,
+        "agent": agent,
+        "ts": int(time.time() * 1000),
+        "nonce": new_random_id(),
+        "proto": PROTOCOL_VERSION,
+        "body": body,
+    }
+
+
+def compare_versions(a: str, b: str) -> int:
+    """Return -1, 0 or 1 as a is older than, equal to or newer than b."""
+    va, vb = _parse_version(a), _parse_version(b)
+    return (va > vb) - (va < vb)
+
+
+def _parse_version(s: str):
+    parts = s[1:].split(".") if s.startswith("v") else s.split(".")
+    if len(parts) > 3 or not all(part.isdigit() for part in parts):
+        raise ValueError("invalid version %r" % s)
+    numbers = [int(part) for part in parts]
+    return tuple(numbers + [0] * (3 - len(numbers)))
+
+
+def uses_canonical_signing(proto) -> bool:
+    if not proto:
+        return False
+    try:
+        return compare_versions(proto, CANONICAL_SIGNING_VERSION) >= 0
+    except ValueError:
+        return False
+
+
+def signing_bytes(envelope: dict) -> bytes:
+    """Return the canonical bytes covered by an envelope signature.
+
+    When the headers carry a body digest (a detached signature), the digest
+    is signed in place of the body.
+    """
+    proto = envelope.get("proto")
+    if not uses_canonical_signing(proto):
+        raise ValueError("envelopes with proto %r use legacy signing bytes, which are not supported" % (proto or ""))
+    signed = {name: envelope[name] for name in ("type", "agent", "ts", "nonce", "proto")}
+    # Optional headers are omitted when empty, as Go's omitempty does
+    for name in ("seq", "digest", "kid", "locale", "accept", "enc"):
+        if envelope.get(name):
+            signed[name] = envelope[name]
+    if not envelope.get("digest"):
+        signed["body"] = envelope.get("body")
+    return canonicalize(signed)
+
+
+def sign_envelope(envelope: dict, key: KeyPair) -> dict:
+    """Sign an envelope in place, setting its kid and sig headers."""
+    envelope.setdefault("proto", PROTOCOL_VERSION)
+    envelope.pop("sig", None)
+    envelope.pop("digest", None)
+    envelope["kid"] = key.kid
+    envelope["sig"] = base64.b64encode(key.sign(signing_bytes(envelope))).decode("ascii")
+    return envelope
+
+
+def sign_detached(envelope: dict, key: KeyPair, raw_body: bytes, algorithm: str = DIGEST_SHA256) -> dict:
+    """Sign a digest of the body instead of the body itself.
+
+    The digest covers raw_body exactly as it is carried on the wire, so the
+    envelope must be sent with those body bytes.
+    """
+    envelope.setdefault("proto", PROTOCOL_VERSION)
+    envelope.pop("sig", None)
+    envelope["kid"] = key.kid
+    envelope["digest"] = body_digest(raw_body, algorithm)
+    envelope["sig"] = base64.b64encode(key.sign(signing_bytes(envelope))).decode("ascii")
+    return envelope
+
+
+def verify_envelope(envelope: dict, public_key: bytes, raw_body: bytes = None) -> None:
+    """Check an envelope signature, raising SignatureError if it does not verify.
+
+    raw_body, the body bytes exactly as carried, is required for detached
+    signatures.
+    """
+    sig = envelope.get("sig")
+    if not sig:
+        raise SignatureError("envelope has no signature")
+    kid = key_id(public_key)
+    if envelope.get("kid") and envelope["kid"] != kid:
+        raise SignatureError(
+            "envelope key ID does not match the verifying key: signed by key %s, verifying with %s" % (envelope["kid"], kid)
+        )
+
+    # Detached signatures cover a digest of the body exactly as carried
+    digest = envelope.get("digest")
+    if digest:
+        if raw_body is None:
+            raise SignatureError("envelope has a detached signature; verifying it needs the raw body")
+        algorithm = digest.split("=", 1)[0]
+        if not hmac.compare_digest(body_digest(raw_body, algorithm), digest):
+            raise SignatureError("body digest mismatch")
+
+    try:
+        signature = base64.b64decode(sig, validate=True)
+    except ValueError as e:
+        raise SignatureError("invalid signature encoding: %s" % e)
+    if not _ed25519.verify(public_key, signing_bytes(envelope), signature):
+        raise SignatureError("signature verification failed")
+
+
+def body_digest(body: bytes, algorithm: str = DIGEST_SHA256) -> str:
+    """Digest header value ("<alg>=<base64>") for encoded body bytes."""
+    if algorithm == DIGEST_SHA256:
+        h = hashlib.sha256(body)
+    elif algorithm == DIGEST_SHA512:
+        h = hashlib.sha512(body)
+    else:
+        raise ValueError("unsupported digest algorithm: %s" % algorithm)
+    return algorithm + "=" + base64.b64encode(h.digest()).decode("ascii")
+
+
+def broker_response_message(request_nonce: str, body: bytes) -> bytes:
+    """The bytes a broker signs for a response body answering request_nonce."""
+    return b"fem-broker-response-v1\x00" + request_nonce.encode("utf-8") + b"\x00" + hashlib.sha256(body).digest()
+
+
+def verify_broker_response(headers, request_nonce: str, body: bytes) -> bytes:
+    """Check a broker response's identity headers and return the broker's public key."""
+    encoded_key = headers.get(BROKER_KEY_HEADER)
+    encoded_sig = headers.get(BROKER_SIGNATURE_HEADER)
+    if not encoded_key or not encoded_sig:
+        raise SignatureError("broker response is not signed")
+    public_key = decode_public_key(encoded_key)
+    try:
+        signature = base64.b64decode(encoded_sig, validate=True)
+    except ValueError as e:
+        raise SignatureError("invalid broker signature encoding: %s" % e)
+    if not _ed25519.verify(public_key, broker_response_message(request_nonce, body), signature):
+        raise SignatureError("broker response signature verification failed")
+    return public_key
@@ -0,0 +1,117 @@
+"""Runs an agent against a stub broker that answers like the Go broker."""
+
+import base64
+import json
+import threading
+import unittest
+import urllib.request
+from http.server import BaseHTTPRequestHandler, ThreadingHTTPServer
+
+from fem import Agent, BrokerError, KeyPair, SignatureError, ToolError, broker_response_message, verify_envelope
+
+
+class StubBroker:
+    """Verifies envelopes against the pubkey they registered and signs its responses."""
+
+    def __init__(self):
+        self.key = KeyPair.generate()
+        self.agents = {}
+        self.received = []
+        broker = self
+
+        class Handler(BaseHTTPRequestHandler):
+            def do_POST(self):
+                envelope = json.loads(self.rfile.read(int(self.headers["Content-Length"])))
+                status, response = broker.handle(envelope)
+                body = json.dumps(response).encode("utf-8")
+                signature = broker.key.sign(broker_response_message(envelope["nonce"], body))
+                self.send_response(status)
+                self.send_header("X-FEM-Broker-Key", broker.key.encoded_public_key)
+                self.send_header("X-FEM-Broker-Signature", base64.b64encode(signature).decode("ascii"))
+                self.send_header("Content-Length", str(len(body)))
+                self.end_headers()
+                self.wfile.write(body)
+
+            def log_message(self, format, *args):
+                pass
+
+        self.server = ThreadingHTTPServer(("127.0.0.1", 0), Handler)
+        threading.Thread(target=self.server.serve_forever, daemon=True).start()
+        self.url = "http://127.0.0.1:%d" % self.server.server_address[1]
+
+    def handle(self, envelope):
+        self.received.append(envelope)
+        if envelope["type"] == "registerAgent":
+            self.agents[envelope["agent"]] = base64.b64decode(envelope["body"]["pubkey"])
+        public_key = self.agents.get(envelope["agent"])
+        if public_key is None:
+            return 404, self.reply(envelope, "error", {"code": "unknown_agent", "message": "agent is not registered"})
+        verify_envelope(envelope, public_key)
+        return 200, self.reply(envelope, "ack", {"ref": envelope["nonce"], "status": "ok"})
+
+    def reply(self, envelope, envelope_type, body):
+        return {"type": envelope_type, "agent": "broker", "ts": envelope["ts"], "nonce": "r-" + envelope["nonce"], "body": body}
+
+    def close(self):
+        self.server.shutdown()
+        self.server.server_close()
+
+
+class AgentTest(unittest.TestCase):
+    def setUp(self):
+        self.broker = StubBroker()
+        self.addCleanup(self.broker.close)
+        self.agent = Agent("py-test-agent", self.broker.url, environment="test")
+        self.addCleanup(self.agent.stop)
+
+        @self.agent.tool("math.divide", "Divides a by b")
+        def divide(arguments):
+            if arguments["b"] == 0:
+                raise ToolError("division by zero")
+            return arguments["a"] / arguments["b"]
+
+    def mcp(self, request):
+        data = json.dumps(request).encode("utf-8")
+        with urllib.request.urlopen(self.agent.mcp_endpoint, data) as response:
+            return json.loads(response.read())
+
+    def test_register_and_heartbeat(self):
+        endpoint = self.agent.serve()
+        self.assertEqual(self.agent.register()["status"], "ok")
+        self.agent.heartbeat()
+
+        registration = self.broker.received[0]
+        self.assertEqual(registration["body"]["mcpEndpoint"], endpoint)
+        self.assertEqual(registration["body"]["bodyDefinition"]["mcpTools"][0]["name"], "math.divide")
+        self.assertEqual(registration["kid"], self.agent.key.kid)
+        self.assertEqual(self.agent.broker_key, self.broker.key.public_key)
+
+    def test_rejection_carries_error_code(self):
+        self.agent.serve()
+        with self.assertRaises(BrokerError) as raised:
+            self.agent.heartbeat()
+        self.assertEqual(raised.exception.code, "unknown_agent")
+
+    def test_changed_broker_key_is_refused(self):
+        self.agent.serve()
+        self.agent.broker_key = KeyPair.generate().public_key
+        with self.assertRaises(SignatureError):
+            self.agent.register()
+
+    def test_mcp_tool_calls(self):
+        self.agent.serve()
+        call = {"jsonrpc": "2.0", "id": 7, "method": "tools/call", "params": {"name": "math.divide", "arguments": {"a": 6, "b": 3}}}
+        self.assertEqual(self.mcp(call), {"jsonrpc": "2.0", "id": 7, "result": 2})
+
+        call["params"]["arguments"]["b"] = 0
+        self.assertEqual(self.mcp(call)["error"]["message"], "division by zero")
+
+        call["params"]["name"] = "math.missing"
+        self.assertEqual(self.mcp(call)["error"]["code"], -32601)
+
+        tools = self.mcp({"jsonrpc": "2.0", "id": 8, "method": "tools/list"})["result"]["tools"]
+        self.assertEqual([tool["name"] for tool in tools], ["math.divide"])
+
+
+if __name__ == "__main__":
+    unittest.main()
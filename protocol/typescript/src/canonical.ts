@@ -0,0 +1,45 @@
+// JSON Canonicalization Scheme (RFC 8785), matching protocol.Canonicalize in Go.
+// RFC 8785 is defined in terms of ECMAScript serialization, so JSON.stringify
+// already formats strings and numbers canonically; only member order and
+// non-finite numbers need handling here.
+
+/**
+ * canonicalize encodes a JSON value as RFC 8785 canonical JSON: object
+ * members sorted by UTF-16 code units, no insignificant whitespace, minimal
+ * string escaping and ECMAScript number formatting. Members whose value is
+ * undefined are dropped, as JSON.stringify does.
+ */
+export function canonicalize(value: unknown): string {
+  if (value === null) {
+    return "null";
+  }
+  switch (typeof value) {
+    case "boolean":
+    case "string":
+      return JSON.stringify(value);
+    case "number":
+      if (!Number.isFinite(value)) {
+        throw new Error(`canonicalize: number ${value} is not finite`);
+      }
+      return JSON.stringify(value);
+    case "object":
+      break;
+    default:
+      throw new Error(`canonicalize: cannot encode ${typeof value}`);
+  }
+
+  if (Array.isArray(value)) {
+    const elements = value.map((element) => (element === undefined ? "null" : canonicalize(element)));
+    return `[${elements.join(",")}]`;
+  }
+  const record = value as Record<string, unknown>;
+  if (typeof (record as { toJSON?: unknown }).toJSON === "function") {
+    return canonicalize(JSON.parse(JSON.stringify(record)));
+  }
+  // The default sort compares UTF-16 code units, as RFC 8785 requires
+  const keys = Object.keys(record)
+    .filter((key) => record[key] !== undefined)
+    .sort();
+  const members = keys.map((key) => `${JSON.stringify(key)}:${canonicalize(record[key])}`);
+  return `{${members.join(",")}}`;
+}
@@ -0,0 +1,6 @@
+// TypeScript bindings for the FEM protocol. The envelope and body types in
+// types.ts are generated from the Go protocol package by fem-tsgen.
+
+export * from "./types.js";
+export * from "./canonical.js";
+export * from "./signing.js";
@@ -0,0 +1,223 @@
+// Envelope signing and verification, matching protocol/go/signing.go for
+// envelopes signed over RFC 8785 canonical JSON (proto 0.4.0 and later).
+// Keys are Web Crypto Ed25519 keys, so the helpers run in Node.js 20+ and
+// current browsers.
+
+import { canonicalize } from "./canonical.js";
+import { CanonicalSigningVersion, ProtocolVersion, type Envelope } from "./types.js";
+
+/** Digest algorithms supported for detached signatures */
+export const DigestSHA256 = "sha-256";
+export const DigestSHA512 = "sha-512";
+
+/** An Ed25519 key pair; the public key is raw 32 bytes as carried in pubkey fields */
+export interface KeyPair {
+  privateKey: CryptoKey;
+  publicKey: Uint8Array;
+}
+
+/** Options for verifyEnvelope */
+export interface VerifyOptions {
+  /** Body bytes exactly as carried on the wire; required for detached signatures */
+  rawBody?: Uint8Array | string;
+}
+
+// pkcs8Prefix wraps a 32-byte Ed25519 seed as a PKCS #8 private key
+const pkcs8Prefix = [0x30, 0x2e, 0x02, 0x01, 0x00, 0x30, 0x05, 0x06, 0x03, 0x2b, 0x65, 0x70, 0x04, 0x22, 0x04, 0x20];
+
+const encoder = new TextEncoder();
+
+/** generateKeyPair creates a new Ed25519 signing key */
+export async function generateKeyPair(): Promise<KeyPair> {
+  const pair = (await crypto.subtle.generateKey({ name: "Ed25519" }, true, ["sign", "verify"])) as CryptoKeyPair;
+  const publicKey = new Uint8Array(await crypto.subtle.exportKey("raw", pair.publicKey));
+  return { privateKey: pair.privateKey, publicKey };
+}
+
+/** keyPairFromSeed derives the key pair for a 32-byte Ed25519 seed, as ed25519.NewKeyFromSeed does */
+export async function keyPairFromSeed(seed: Uint8Array): Promise<KeyPair> {
+  if (seed.length !== 32) {
+    throw new Error(`invalid Ed25519 seed length ${seed.length}`);
+  }
+  const pkcs8 = new Uint8Array(pkcs8Prefix.length + seed.length);
+  pkcs8.set(pkcs8Prefix);
+  pkcs8.set(seed, pkcs8Prefix.length);
+  const privateKey = await crypto.subtle.importKey("pkcs8", pkcs8, { name: "Ed25519" }, true, ["sign"]);
+  // Web Crypto only derives the public key through the JWK export
+  const jwk = await crypto.subtle.exportKey("jwk", privateKey);
+  return { privateKey, publicKey: decodeBase64Url(jwk.x ?? "") };
+}
+
+/** keyId returns the fingerprint of a public key carried in the kid header: hex of the first 8 bytes of its SHA-256 */
+export async function keyId(publicKey: Uint8Array): Promise<string> {
+  const sum = new Uint8Array(await crypto.subtle.digest("SHA-256", publicKey));
+  return Array.from(sum.subarray(0, 8), (b) => b.toString(16).padStart(2, "0")).join("");
+}
+
+/** compareVersions returns -1, 0 or 1 as a is older than, equal to or newer than b */
+export function compareVersions(a: string, b: string): number {
+  const va = parseVersion(a);
+  const vb = parseVersion(b);
+  for (let i = 0; i < 3; i++) {
+    if (va[i] !== vb[i]) {
+      return va[i] < vb[i] ? -1 : 1;
+    }
+  }
+  return 0;
+}
+
+// parseVersion parses "1", "1.2" or "1.2.3", with an optional leading "v"
+function parseVersion(s: string): number[] {
+  const parts = s.replace(/^v/, "").split(".");
+  if (parts.length > 3 || parts.some((part) => !/^\d+$/.test(part))) {
+    throw new Error(`invalid version "${s}"`);
+  }
+  const numbers = [0, 0, 0];
+  parts.forEach((part, i) => (numbers[i] = Number(part)));
+  return numbers;
+}
+
+/** usesCanonicalSigning reports whether envelopes declaring proto are signed over canonical JSON */
+export function usesCanonicalSigning(proto: string | undefined): boolean {
+  if (!proto) {
+    return false;
+  }
+  try {
+    return compareVersions(proto, CanonicalSigningVersion) >= 0;
+  } catch {
+    return false;
+  }
+}
+
+/**
+ * signingBytes returns the canonical bytes covered by an envelope signature.
+ * When the headers carry a body digest (a detached signature), the digest is
+ * signed in place of the body. Envelopes from senders older than
+ * CanonicalSigningVersion use the Go encoding of the envelope and are not
+ * supported.
+ */
+export function signingBytes(envelope: Envelope): Uint8Array {
+  if (!usesCanonicalSigning(envelope.proto)) {
+    throw new Error(`envelopes with proto "${envelope.proto ?? ""}" use legacy signing bytes, which are not supported`);
+  }
+  const input: Record<string, unknown> = {
+    type: envelope.type,
+    agent: envelope.agent,
+    ts: envelope.ts,
+    nonce: envelope.nonce,
+    proto: envelope.proto,
+  };
+  // Optional headers are omitted when empty, as Go's omitempty does
+  if (envelope.seq) input.seq = envelope.seq;
+  if (envelope.digest) input.digest = envelope.digest;
+  if (envelope.kid) input.kid = envelope.kid;
+  if (envelope.locale) input.locale = envelope.locale;
+  if (envelope.accept && envelope.accept.length > 0) input.accept = envelope.accept;
+  if (envelope.enc) input.enc = envelope.enc;
+  if (!envelope.digest) input.body = envelope.body ?? null;
+  return encoder.encode(canonicalize(input));
+}
+
+/**
+ * signEnvelope signs an envelope and stores the signature and key ID in its
+ * headers. Envelopes without a proto header are stamped with ProtocolVersion.
+ */
+export async function signEnvelope<E extends Envelope>(envelope: E, key: KeyPair): Promise<E> {
+  envelope.proto ||= ProtocolVersion;
+  delete envelope.sig;
+  delete envelope.digest;
+  envelope.kid = await keyId(key.publicKey);
+  envelope.sig = await sign(key.privateKey, signingBytes(envelope));
+  return envelope;
+}
+
+/**
+ * signDetached signs a digest of the body instead of the body itself. The
+ * digest covers rawBody exactly as it is carried on the wire, so the envelope
+ * must be sent with those body bytes.
+ */
+export async function signDetached<E extends Envelope>(
+  envelope: E,
+  key: KeyPair,
+  rawBody: Uint8Array | string,
+  algorithm: string = DigestSHA256,
+): Promise<E> {
+  envelope.proto ||= ProtocolVersion;
+  delete envelope.sig;
+  envelope.kid = await keyId(key.publicKey);
+  envelope.digest = await bodyDigest(rawBody, algorithm);
+  envelope.sig = await sign(key.privateKey, signingBytes(envelope));
+  return envelope;
+}
+
+/** verifyEnvelope checks an envelope signature with the sender's raw Ed25519 public key and throws if it does not verify */
+export async function verifyEnvelope(envelope: Envelope, publicKey: Uint8Array, options: VerifyOptions = {}): Promise<void> {
+  if (!envelope.sig) {
+    throw new Error("envelope has no signature");
+  }
+  const kid = await keyId(publicKey);
+  if (envelope.kid && envelope.kid !== kid) {
+    throw new Error(`envelope key ID does not match the verifying key: signed by key ${envelope.kid}, verifying with ${kid}`);
+  }
+
+  // Detached signatures cover a digest of the body exactly as carried
+  if (envelope.digest) {
+    if (options.rawBody === undefined) {
+      throw new Error("envelope has a detached signature; verifying it needs the raw body");
+    }
+    const [algorithm] = envelope.digest.split("=", 1);
+    if ((await bodyDigest(options.rawBody, algorithm)) !== envelope.digest) {
+      throw new Error("body digest mismatch");
+    }
+  }
+
+  const key = await crypto.subtle.importKey("raw", publicKey, { name: "Ed25519" }, false, ["verify"]);
+  const signature = decodeBase64(envelope.sig);
+  if (!(await crypto.subtle.verify({ name: "Ed25519" }, key, signature, signingBytes(envelope)))) {
+    throw new Error("signature verification failed");
+  }
+}
+
+/** bodyDigest computes the digest header value ("<alg>=<base64>") for encoded body bytes */
+export async function bodyDigest(body: Uint8Array | string, algorithm: string = DigestSHA256): Promise<string> {
+  let hash: string;
+  switch (algorithm) {
+    case DigestSHA256:
+      hash = "SHA-256";
+      break;
+    case DigestSHA512:
+      hash = "SHA-512";
+      break;
+    default:
+      throw new Error(`unsupported digest algorithm: ${algorithm}`);
+  }
+  const data = typeof body === "string" ? encoder.encode(body) : body;
+  const sum = new Uint8Array(await crypto.subtle.digest(hash, data));
+  return `${algorithm}=${encodeBase64(sum)}`;
+}
+
+// sign returns the base64 Ed25519 signature of data
+async function sign(privateKey: CryptoKey, data: Uint8Array): Promise<string> {
+  const signature = await crypto.subtle.sign({ name: "Ed25519" }, privateKey, data);
+  return encodeBase64(new Uint8Array(signature));
+}
+
+/** encodeBase64 encodes bytes as standard padded base64 */
+export function encodeBase64(data: Uint8Array): string {
+  let binary = "";
+  for (const b of data) {
+    binary += String.fromCharCode(b);
+  }
+  return btoa(binary);
+}
+
+/** decodeBase64 decodes standard padded base64 */
+export function decodeBase64(s: string): Uint8Array {
+  return Uint8Array.from(atob(s), (c) => c.charCodeAt(0));
+}
+
+// decodeBase64Url decodes unpadded base64url, as used in JWKs
+function decodeBase64Url(s: string): Uint8Array {
+  const padded = s.replace(/-/g, "+").replace(/_/g, "/") + "=".repeat((4 - (s.length % 4)) % 4);
+  return decodeBase64(padded);
+}
@@ -0,0 +1,818 @@
+// Code generated by fem-tsgen from the Go protocol package. DO NOT EDIT.
+// Run "make typescript" after changing envelope or body types.
+
+export const ProtocolVersion = "0.4.0";
+export const CanonicalSigningVersion = "0.4.0";
+
+/** CommonHeaders contains headers present in all FEP envelopes */
+export interface CommonHeaders {
+  /** UTF-8 agent identifier */
+  agent: string;
+  /** Unix timestamp in milliseconds */
+  ts: number;
+  /** Replay guard */
+  nonce: string;
+  /** Per-sender sequence number for ordered delivery; 0 if unordered */
+  seq?: number;
+  /** Protocol version the sender speaks, e.g. "0.4.0" */
+  proto?: string;
+  /** "<alg>=<base64>" body digest for detached signatures */
+  digest?: string;
+  /** Key ID of the signing key, see KeyID */
+  kid?: string;
+  /** Base64(Ed25519(body)) */
+  sig?: string;
+  /** Additional co-signatures over the same bytes */
+  sigs?: Signature[];
+  /** Set when the body is encrypted for one recipient */
+  enc?: Encryption;
+  /** Third-party time evidence over the signing bytes */
+  timestamp?: TimestampToken;
+  /** Presentation hints for output meant for the sender */
+  locale?: string;
+  /** Media types the sender can display, in preference order */
+  accept?: string[];
+}
+
+/** EnvelopeType represents the type of FEP envelope */
+export type EnvelopeType =
+  | "registerAgent"
+  | "registerBroker"
+  | "emitEvent"
+  | "renderInstruction"
+  | "toolCall"
+  | "toolResult"
+  | "toolResultChunk"
+  | "revoke"
+  | "rotateKey"
+  | "revokeCapability"
+  | "discoverTools"
+  | "toolsDiscovered"
+  | "embodimentUpdate"
+  | "heartbeat"
+  | "resultAck"
+  | "subscribe"
+  | "unsubscribe"
+  | "batch"
+  | "drainInstance"
+  | "preemptionNotice"
+  | "checkpoint"
+  | "approveCall"
+  | "adminApproval"
+  | "ack"
+  | "error";
+
+export const EnvelopeRegisterAgent = "registerAgent";
+export const EnvelopeRegisterBroker = "registerBroker";
+export const EnvelopeEmitEvent = "emitEvent";
+export const EnvelopeRenderInstruction = "renderInstruction";
+export const EnvelopeToolCall = "toolCall";
+export const EnvelopeToolResult = "toolResult";
+export const EnvelopeToolResultChunk = "toolResultChunk";
+export const EnvelopeRevoke = "revoke";
+export const EnvelopeRotateKey = "rotateKey";
+export const EnvelopeRevokeCapability = "revokeCapability";
+/** MCP Integration envelope types */
+export const EnvelopeDiscoverTools = "discoverTools";
+export const EnvelopeToolsDiscovered = "toolsDiscovered";
+export const EnvelopeEmbodimentUpdate = "embodimentUpdate";
+/** Liveness */
+export const EnvelopeHeartbeat = "heartbeat";
+/** Result delivery */
+export const EnvelopeResultAck = "resultAck";
+/** Event routing */
+export const EnvelopeSubscribe = "subscribe";
+export const EnvelopeUnsubscribe = "unsubscribe";
+/** Bulk submission */
+export const EnvelopeBatch = "batch";
+/** Replicas */
+export const EnvelopeDrainInstance = "drainInstance";
+/** Spot capacity */
+export const EnvelopePreemptionNotice = "preemptionNotice";
+export const EnvelopeCheckpoint = "checkpoint";
+/** Human approval */
+export const EnvelopeApproveCall = "approveCall";
+export const EnvelopeAdminApproval = "adminApproval";
+/** Responses */
+export const EnvelopeAck = "ack";
+export const EnvelopeError = "error";
+
+export interface AckBody {
+  /** Nonce of the acknowledged envelope */
+  ref?: string;
+  /** Outcome, e.g. "registered" */
+  status: string;
+  /** Handler-specific response data */
+  result?: unknown;
+}
+
+/**
+ * AdminApprovalBody authorizes one admin API request under the two-person
+ * rule. Admins co-sign it and the broker checks it names the request made.
+ */
+export interface AdminApprovalBody {
+  /** Operation class the request falls in, e.g. "policy" */
+  operation: string;
+  /** HTTP method of the request */
+  method: string;
+  /** Request path and query, e.g. /admin/peers?id=broker-b */
+  path: string;
+  /** BodyDigest of the request body; empty for requests without one */
+  digest?: string;
+  reason?: string;
+}
+
+export interface ApproveCallBody {
+  requestId: string;
+  /** False rejects the call */
+  approved: boolean;
+  /** Passed to the caller when the call is rejected */
+  reason?: string;
+}
+
+export interface BatchBody {
+  /** Complete signed envelopes, processed in order */
+  envelopes: unknown[] | null;
+}
+
+export interface CheckpointBody {
+  requestId: string;
+  tool: string;
+  /** Arguments of the original call */
+  parameters?: Record<string, unknown>;
+  /** Opaque progress the resuming agent continues from */
+  state: string;
+}
+
+export interface DiscoverToolsBody {
+  query: ToolQuery;
+  requestId: string;
+  /** Capability token scoping the results */
+  capability?: string;
+}
+
+export interface DrainInstanceBody {
+  instanceId: string;
+  /** Seconds the broker may hold the ack until the instance is drained; 0 answers at once */
+  wait?: number;
+}
+
+export interface EmbodimentUpdateBody {
+  environmentType: string;
+  bodyDefinition: BodyDefinition;
+  mcpEndpoint: string;
+  updatedTools: string[] | null;
+}
+
+export interface EmitEventBody {
+  /** Topic the event is published to, e.g. "prod.ci.build.finished" */
+  event: string;
+  payload: Record<string, unknown> | null;
+  /** Token granting publish rights on the topic */
+  capability?: string;
+}
+
+export interface ErrorBody {
+  /** Nonce of the rejected envelope */
+  ref?: string;
+  code: ErrorCode;
+  message: string;
+  details?: Record<string, unknown>;
+}
+
+export interface HeartbeatBody {
+  /** Fraction of capacity in use, 0 to 1 */
+  load: number;
+  /** Requests currently being processed */
+  inFlight: number;
+  /** Seconds since the agent started */
+  uptime: number;
+  /** Replica reporting, for agents registered with an instance ID */
+  instanceId?: string;
+  /** Capacity free for new calls, for admission of tools declaring resources */
+  available?: Resources;
+  /** Fraction of GPU compute in use over the agent's GPUs, 0 to 1 */
+  gpuUtilization?: number;
+}
+
+export interface PreemptionNoticeBody {
+  /** Replica being reclaimed; empty for the whole agent */
+  instanceId?: string;
+  /** Unix milliseconds at which the agent stops; 0 if unknown */
+  deadline?: number;
+}
+
+export interface RegisterAgentBody {
+  /** Base64 Ed25519 public key */
+  pubkey: string;
+  /** List of capabilities */
+  capabilities: string[] | null;
+  metadata?: Record<string, unknown>;
+  /** MCP integration fields */
+  mcpEndpoint?: string;
+  /** Environment-specific tool definitions */
+  bodyDefinition?: BodyDefinition;
+  /** Environment type (e.g., "local", "cloud") */
+  environmentType?: string;
+  /** Replica of an agent whose instances share its ID and key */
+  instanceId?: string;
+  /** Content encodings the agent reads compressed bodies in, in preference order */
+  compression?: ContentEncoding[];
+}
+
+export interface RegisterBrokerBody {
+  brokerId: string;
+  /** TLS endpoint */
+  endpoint: string;
+  /** Base64 Ed25519 public key */
+  pubkey: string;
+  capabilities: string[] | null;
+  /** What the broker asks of agents and peers, as published to directories */
+  policy?: BrokerPolicy;
+}
+
+export interface RenderInstructionBody {
+  instruction: string;
+  parameters?: Record<string, unknown>;
+  /** Overrides the header locale for this output */
+  locale?: string;
+  /** What the target display can show */
+  presentation?: PresentationHints;
+}
+
+export interface ResultAckBody {
+  /** Delivery keys of results and chunks received */
+  acknowledged?: string[];
+  /** Limit on results returned; 0 returns all pending */
+  maxResults?: number;
+  /** Seconds the broker may hold the ack until a result is due; 0 answers at once */
+  wait?: number;
+}
+
+export interface RevokeBody {
+  /** Agent or broker ID to revoke */
+  target: string;
+  reason?: string;
+}
+
+export interface RevokeCapabilityBody {
+  /** jti claim of the token to revoke */
+  jti: string;
+  /** The token itself, proving the sender was issued or delegated it */
+  capability?: string;
+  reason?: string;
+}
+
+export interface RotateKeyBody {
+  /** Base64 Ed25519 public key replacing the current one */
+  newPubkey: string;
+  /** e.g. "scheduled" or "compromised" */
+  reason?: string;
+}
+
+export interface SubscribeBody {
+  /** Topic pattern, e.g. "prod.ci.*" */
+  pattern?: string;
+  /** Token granting subscribe rights on the pattern */
+  capability?: string;
+  /** On an event stream, also send the results of the sender's calls */
+  results?: boolean;
+}
+
+export interface ToolCallBody {
+  tool: string;
+  parameters: Record<string, unknown> | null;
+  requestId: string;
+  /** Capability token authorizing the call */
+  capability?: string;
+  /** How the result is delivered to the caller; at-most-once if empty */
+  delivery?: DeliveryGuarantee;
+  /** Region the call's data must stay in, e.g. "eu" */
+  dataRegion?: string;
+  /** How the result comes back: sync, poll, webhook or event; polled if nil */
+  resultDelivery?: ResultDelivery;
+  /** Unix milliseconds by which the caller needs the result; 0 for none */
+  deadline?: number;
+  /** At the deadline, take the output streamed so far as a partial result rather than an error */
+  acceptPartial?: boolean;
+  /** Media ranges the caller takes the result in, in preference order, e.g. ["image/webp", "image/*"] */
+  accept?: string[];
+}
+
+export interface ToolResultBody {
+  requestId: string;
+  success: boolean;
+  result?: unknown;
+  error?: string;
+  /** The call did not finish; the result holds the output so far */
+  partial?: boolean;
+  /** Media type of the result; application/json if empty. Binary and image results are base64 strings. */
+  contentType?: string;
+}
+
+/**
+ * ToolResultChunkBody is one piece of a streamed result. Chunks are numbered
+ * from 1; the chunk with Final set ends the stream and reports the outcome.
+ */
+export interface ToolResultChunkBody {
+  requestId: string;
+  /** Position in the stream, starting at 1 */
+  seq: number;
+  /** Output channel, e.g. "stdout" or "stderr" */
+  stream?: string;
+  /** Output produced since the previous chunk */
+  data?: string;
+  /** Set on the last chunk */
+  final?: boolean;
+  /** Outcome, on the final chunk */
+  success?: boolean;
+  /** Structured result, on the final chunk */
+  result?: unknown;
+  /** Failure message, on the final chunk */
+  error?: string;
+}
+
+export interface ToolsDiscoveredBody {
+  requestId: string;
+  tools: DiscoveredTool[] | null;
+  totalResults: number;
+  hasMore: boolean;
+  /** Results may be incomplete, e.g. while the broker restores state after a restart */
+  partial?: boolean;
+}
+
+export interface UnsubscribeBody {
+  /** Subscription to remove */
+  subscriptionId?: string;
+  /** Or remove the sender's subscription to this pattern */
+  pattern?: string;
+}
+
+/** ResultDeliveryBody is the result of a resultAck: tool results awaiting the caller */
+export interface ResultDeliveryBody {
+  results: DeliveredResult[] | null;
+}
+
+/** BatchResultBody is the result of a batch: the outcome of each envelope */
+export interface BatchResultBody {
+  results: BatchItemResult[] | null;
+  succeeded: number;
+  failed: number;
+}
+
+/** DrainStatusBody is the result of a drainInstance: whether the instance may be stopped */
+export interface DrainStatusBody {
+  instanceId: string;
+  /** Requests in flight in the instance's latest heartbeat */
+  inFlight: number;
+  /** A heartbeat since draining began reported nothing in flight */
+  drained: boolean;
+}
+
+/**
+ * ResumeBody assigns a checkpointed call to the agent that resumes it. It is
+ * delivered in heartbeat acks; the agent answers the caller with a toolResult
+ * for the same request ID.
+ */
+export interface ResumeBody {
+  requestId: string;
+  tool: string;
+  parameters?: Record<string, unknown>;
+  state: string;
+  /** Digest the state is stored under */
+  artifact: string;
+  /** Agent that checkpointed the call */
+  from: string;
+}
+
+/**
+ * CompressionPolicy is agreed when an agent registers. Envelope bodies of at
+ * least MinBytes are compressed with Encoding in both directions.
+ */
+export interface CompressionPolicy {
+  encoding: ContentEncoding;
+  minBytes: number;
+}
+
+/**
+ * DiffResult is the standard result of tools that edit or compare files, so
+ * orchestrators and dashboards render changes alike whichever agent made them
+ */
+export interface DiffResult {
+  /** Unified diff of every change, as git diff prints it */
+  diff: string;
+  files: FileDiff[] | null;
+  stats: DiffStats;
+}
+
+/**
+ * PendingApprovalBody describes a call parked until an approver decides on
+ * it. Approvers are notified with it and callers get it in the ack.
+ */
+export interface PendingApprovalBody {
+  requestId: string;
+  caller: string;
+  tool: string;
+  /** Absent from encrypted calls */
+  parameters?: Record<string, unknown>;
+  /** Unix milliseconds at which the call is rejected undecided */
+  expires: number;
+}
+
+/** Signature is one co-signature on an envelope */
+export interface Signature {
+  /** Identifier of the co-signing agent or broker */
+  signer: string;
+  /** Base64(Ed25519(signing bytes)) */
+  sig: string;
+}
+
+/**
+ * Encryption describes how an envelope body was encrypted. It travels in the
+ * headers so it is covered by the sender's signature.
+ */
+export interface Encryption {
+  alg: string;
+  /** Key ID of the recipient's encryption key */
+  kid: string;
+  /** Base64 ephemeral X25519 public key of the sender */
+  epk: string;
+  /** Base64 AEAD nonce */
+  nonce: string;
+}
+
+/** TimestampToken is third-party evidence that an envelope existed at a point in time */
+export interface TimestampToken {
+  /** Identifier or URL of the timestamping authority */
+  authority: string;
+  /** Evidence format, e.g. "ed25519" or "rfc3161" */
+  kind: string;
+  /** "<alg>=<base64>" digest that was timestamped */
+  digest: string;
+  /** Asserted time in Unix milliseconds */
+  time: number;
+  /** Base64 authority-specific evidence */
+  token: string;
+}
+
+export interface ToolQuery {
+  capabilities: string[] | null;
+  environmentType?: string;
+  maxResults?: number;
+  includeMetadata?: boolean;
+  /** Only tools of agents with matching hardware, see HardwareFilter.Matches */
+  hardware?: HardwareFilter;
+}
+
+export interface BodyDefinition {
+  name: string;
+  environment: string;
+  capabilities: string[] | null;
+  mcpTools: MCPTool[] | null;
+  constraints?: Record<string, unknown>;
+  metadata?: Record<string, unknown>;
+  /** Regions the agent keeps call data in, e.g. ["eu-de"] */
+  dataResidency?: string[];
+  /** Hardware the agent runs tools on, matched by discovery hardware filters */
+  hardware?: HardwareCapabilities;
+}
+
+/** ErrorCode is a machine-readable reason an envelope was rejected */
+export type ErrorCode =
+  | "invalid_envelope"
+  | "invalid_body"
+  | "unsupported_type"
+  | "unsupported_version"
+  | "invalid_signature"
+  | "clock_skew"
+  | "unauthorized"
+  | "unknown_agent"
+  | "unknown_request"
+  | "capability_denied"
+  | "forbidden"
+  | "policy_violation"
+  | "quarantined"
+  | "rate_limited"
+  | "payload_too_large"
+  | "overloaded"
+  | "maintenance"
+  | "internal_error";
+
+/** Malformed or undecodable envelope */
+export const CodeInvalidEnvelope = "invalid_envelope";
+/** Body failed to parse or validate */
+export const CodeInvalidBody = "invalid_body";
+/** Envelope type not handled by the receiver */
+export const CodeUnsupportedType = "unsupported_type";
+/** Sender's protocol version is below the receiver's minimum */
+export const CodeUnsupportedVersion = "unsupported_version";
+/** Signature does not verify */
+export const CodeInvalidSignature = "invalid_signature";
+/** ts is too old or too far in the future */
+export const CodeClockSkew = "clock_skew";
+/** Sender could not be authenticated */
+export const CodeUnauthorized = "unauthorized";
+/** Sender or target is not registered */
+export const CodeUnknownAgent = "unknown_agent";
+/** No call of the sender's awaits a result under the request ID */
+export const CodeUnknownRequest = "unknown_request";
+/** Capability token missing or insufficient */
+export const CodeCapabilityDenied = "capability_denied";
+/** Refused by policy */
+export const CodeForbidden = "forbidden";
+/** Would break a data handling constraint such as residency; details cite it */
+export const CodePolicyViolation = "policy_violation";
+/** Sender is quarantined */
+export const CodeQuarantined = "quarantined";
+/** Too many requests; retry later */
+export const CodeRateLimited = "rate_limited";
+/** Envelope exceeds the receiver's size limit; details give the limit */
+export const CodePayloadTooLarge = "payload_too_large";
+/** Receiver is saturated; retry later */
+export const CodeOverloaded = "overloaded";
+/** Receiver is in maintenance mode */
+export const CodeMaintenance = "maintenance";
+/** Receiver failed to process the envelope */
+export const CodeInternal = "internal_error";
+
+/** Resources are amounts of compute, either needed by a tool call or free on an agent */
+export interface Resources {
+  /** Cores */
+  cpu?: number;
+  /** Mebibytes of memory */
+  memoryMb?: number;
+  /** Whole GPUs */
+  gpus?: number;
+  /** Mebibytes of GPU memory, per GPU */
+  gpuMemoryMb?: number;
+}
+
+/** ContentEncoding identifies how a serialized envelope is compressed on the wire */
+export type ContentEncoding =
+  | "identity"
+  | "gzip"
+  | "zstd";
+
+export const EncodingIdentity = "identity";
+export const EncodingGzip = "gzip";
+export const EncodingZstd = "zstd";
+
+/**
+ * BrokerPolicy summarizes a broker's admission policy, so brokers found in a
+ * directory can tell whether to peer before registering
+ */
+export interface BrokerPolicy {
+  /** Oldest protocol version accepted */
+  minProto?: string;
+  /** Trust tier new peers are placed in */
+  peerTrustTier?: string;
+  /** Discovery without a signed identity */
+  anonymousDiscovery?: boolean;
+  /** Tool calls need capability tokens */
+  callCapabilities?: boolean;
+  /** Largest envelope accepted, in bytes */
+  maxEnvelopeSize?: number;
+  /** Regions call data is kept in */
+  dataResidency?: string[];
+}
+
+/** PresentationHints restrict the format of rendered output */
+export interface PresentationHints {
+  /** Acceptable media types in preference order, e.g. "text/markdown" */
+  formats?: string[];
+  /** Display width in characters */
+  maxWidth?: number;
+  /** Display cannot show color */
+  noColor?: boolean;
+}
+
+/** DeliveryGuarantee selects how the broker delivers a tool result to its caller */
+export type DeliveryGuarantee =
+  | "at-most-once"
+  | "at-least-once";
+
+/** DeliverAtMostOnce hands the result out once; a caller that misses it loses it */
+export const DeliverAtMostOnce = "at-most-once";
+/**
+ * DeliverAtLeastOnce redelivers the result until the caller acknowledges it.
+ * Callers deduplicate by request ID to process each result exactly once.
+ */
+export const DeliverAtLeastOnce = "at-least-once";
+
+/** ResultDelivery is how a caller wants the result of a call returned */
+export interface ResultDelivery {
+  mode: ResultDeliveryMode;
+  /** Webhook endpoint, http or https */
+  url?: string;
+  /** Event topic the result is published to */
+  topic?: string;
+  /** Token granting publish on the topic, if publishing is gated */
+  capability?: string;
+  /** Seconds a sync call waits for its result; 0 waits as long as the broker allows */
+  timeout?: number;
+}
+
+export interface DiscoveredTool {
+  agentId: string;
+  mcpEndpoint: string;
+  capabilities: string[] | null;
+  environmentType: string;
+  mcpTools: MCPTool[] | null;
+  metadata?: ToolMetadata;
+  /** Hardware the agent advertised in its body definition */
+  hardware?: HardwareCapabilities;
+}
+
+/** DeliveredResult is a tool result held by the broker for a caller */
+export interface DeliveredResult {
+  requestId: string;
+  delivery: DeliveryGuarantee;
+  /** Seq of a toolResultChunk; 0 for a complete result */
+  chunk?: number;
+  /** 1 on first delivery; higher on redelivery */
+  attempt: number;
+  /** The envelope as signed by the answering agent */
+  envelope: unknown;
+}
+
+/** BatchItemResult is the response to one envelope of a batch */
+export interface BatchItemResult {
+  /** Position of the envelope in the batch */
+  index: number;
+  /** Empty if the envelope could not be parsed */
+  type?: EnvelopeType;
+  nonce?: string;
+  /** HTTP status the envelope would have received on its own */
+  status: number;
+  /** The ack or error envelope answering it */
+  response: unknown;
+}
+
+/** FileDiff summarizes the changes to one file */
+export interface FileDiff {
+  path: string;
+  /** Path before a rename */
+  oldPath?: string;
+  change: FileChange;
+  additions: number;
+  deletions: number;
+  /** Contents are not shown in the diff */
+  binary?: boolean;
+}
+
+/** DiffStats totals the changes of a diff */
+export interface DiffStats {
+  filesChanged: number;
+  additions: number;
+  deletions: number;
+}
+
+/** HardwareFilter selects agents by the GPUs they advertise */
+export interface HardwareFilter {
+  /** Case-insensitive substring of the model, e.g. "a100" */
+  gpuModel?: string;
+  /** GPUs that must match; 1 if zero and another GPU filter is set */
+  minGpus?: number;
+  /** Per GPU */
+  minVramMb?: number;
+  /** e.g. "12.0" */
+  minCudaVersion?: string;
+}
+
+export interface MCPTool {
+  name: string;
+  description: string;
+  inputSchema: Record<string, unknown> | null;
+  /** Regions the tool keeps call data in, overriding the body definition's */
+  dataResidency?: string[];
+  /** Personal data classes the tool is cleared to exchange, e.g. ["email"] */
+  pii?: string[];
+  /** Resources one call needs; calls are only routed to agents reporting that much free */
+  resources?: Resources;
+  /** Price of one call; tools without one cost nothing, as local agents usually do */
+  cost?: ToolCost;
+  /** Whether the agent can resume calls of the tool from another agent's checkpoint */
+  resumable?: boolean;
+  /** Media types the tool can return its result as, in preference order; application/json if empty */
+  resultTypes?: string[];
+  /** Whether calls wait for an approver's signed approval before they are delivered */
+  dangerous?: boolean;
+  /** Whether the broker may publish the tool in its public catalog feed */
+  listed?: boolean;
+}
+
+/** HardwareCapabilities describes the accelerators an agent offers */
+export interface HardwareCapabilities {
+  gpus?: GPUDevice[];
+}
+
+/** ResultDeliveryMode selects how the result of a call comes back to the caller */
+export type ResultDeliveryMode =
+  | "sync"
+  | "poll"
+  | "webhook"
+  | "event";
+
+/**
+ * ResultDeliverySync answers the toolCall itself with the result, waiting
+ * up to a timeout; results not in by then are held for polling
+ */
+export const ResultDeliverySync = "sync";
+/**
+ * ResultDeliveryPoll holds the result until the caller collects it with
+ * resultAck, optionally long-polling. It is the default.
+ */
+export const ResultDeliveryPoll = "poll";
+/** ResultDeliveryWebhook POSTs the result, signed by the broker, to a URL */
+export const ResultDeliveryWebhook = "webhook";
+/** ResultDeliveryEvent publishes the result to an event topic */
+export const ResultDeliveryEvent = "event";
+
+export interface ToolMetadata {
+  lastSeen: number;
+  /** Milliseconds */
+  averageResponseTime: number;
+  /** Milliseconds, over recent checks */
+  p95ResponseTime?: number;
+  trustScore: number;
+  /** Fraction of checks that succeeded */
+  successRate?: number;
+  /** Calls routed to the agent */
+  totalInvocations?: number;
+  /** Unix milliseconds */
+  lastErrorAt?: number;
+  lastErrorMessage?: string;
+  /** Live replicas behind the agent ID */
+  instances?: number;
+}
+
+/** FileChange is how a diff changes a file */
+export type FileChange =
+  | "added"
+  | "modified"
+  | "deleted"
+  | "renamed";
+
+export const FileAdded = "added";
+export const FileModified = "modified";
+export const FileDeleted = "deleted";
+export const FileRenamed = "renamed";
+
+/** ToolCost is what a tool charges, in the federation's billing currency */
+export interface ToolCost {
+  perCall: number;
+}
+
+/** GPUDevice is one GPU an agent can run tools on */
+export interface GPUDevice {
+  /** e.g. "NVIDIA A100-SXM4-80GB" */
+  model: string;
+  /** Mebibytes of memory on the device */
+  vramMb: number;
+  /** Highest CUDA version the driver supports, e.g. "12.2" */
+  cudaVersion?: string;
+}
+
+/** Body type carried by each envelope type */
+export interface EnvelopeBodies {
+  ack: AckBody;
+  adminApproval: AdminApprovalBody;
+  approveCall: ApproveCallBody;
+  batch: BatchBody;
+  checkpoint: CheckpointBody;
+  discoverTools: DiscoverToolsBody;
+  drainInstance: DrainInstanceBody;
+  embodimentUpdate: EmbodimentUpdateBody;
+  emitEvent: EmitEventBody;
+  error: ErrorBody;
+  heartbeat: HeartbeatBody;
+  preemptionNotice: PreemptionNoticeBody;
+  registerAgent: RegisterAgentBody;
+  registerBroker: RegisterBrokerBody;
+  renderInstruction: RenderInstructionBody;
+  resultAck: ResultAckBody;
+  revoke: RevokeBody;
+  revokeCapability: RevokeCapabilityBody;
+  rotateKey: RotateKeyBody;
+  subscribe: SubscribeBody;
+  toolCall: ToolCallBody;
+  toolResult: ToolResultBody;
+  toolResultChunk: ToolResultChunkBody;
+  toolsDiscovered: ToolsDiscoveredBody;
+  unsubscribe: UnsubscribeBody;
+}
+
+/** An envelope whose body type follows from its type */
+export type TypedEnvelope<T extends keyof EnvelopeBodies> = CommonHeaders & {
+  type: T;
+  body: EnvelopeBodies[T];
+};
+
+/** Any envelope with a known body type */
+export type AnyEnvelope = { [T in keyof EnvelopeBodies]: TypedEnvelope<T> }[keyof EnvelopeBodies];
+
+/** An envelope of any type, e.g. one not yet checked */
+export type Envelope = CommonHeaders & {
+  type: EnvelopeType;
+  body: unknown;
+};
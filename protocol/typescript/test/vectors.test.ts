@@ -0,0 +1,127 @@
+// Checks the signing helpers against the golden vectors in protocol/testvectors.
+
+import assert from "node:assert/strict";
+import { readFileSync } from "node:fs";
+import { test } from "node:test";
+
+import {
+  canonicalize,
+  decodeBase64,
+  encodeBase64,
+  generateKeyPair,
+  keyId,
+  keyPairFromSeed,
+  signDetached,
+  signEnvelope,
+  signingBytes,
+  usesCanonicalSigning,
+  verifyEnvelope,
+  type Envelope,
+  type TypedEnvelope,
+} from "../src/index.js";
+
+// Compiled to dist/test, three levels below protocol/
+const vectorsDir = new URL("../../../testvectors/", import.meta.url);
+
+interface KeyVector {
+  name: string;
+  seed: string;
+  publicKey: string;
+  kid: string;
+}
+
+interface EnvelopeVector {
+  name: string;
+  key: string;
+  envelope: string;
+  signingInput: string;
+  signature: string;
+  valid: boolean;
+}
+
+function load<T>(name: string): T {
+  return JSON.parse(readFileSync(new URL(name, vectorsDir), "utf8")) as T;
+}
+
+function fromHex(hex: string): Uint8Array {
+  return Uint8Array.from(hex.match(/../g) ?? [], (b) => parseInt(b, 16));
+}
+
+// rawBody returns the body bytes as carried; Go encodes body as the last member
+function rawBody(envelope: string): string {
+  return envelope.slice(envelope.indexOf(`"body":`) + `"body":`.length, -1);
+}
+
+const keys = load<KeyVector[]>("keys.json");
+const envelopes = load<EnvelopeVector[]>("envelopes.json");
+
+test("keys derive the listed public keys and key IDs", async () => {
+  for (const vector of keys) {
+    const key = await keyPairFromSeed(fromHex(vector.seed));
+    assert.equal(encodeBase64(key.publicKey), vector.publicKey, vector.name);
+    assert.equal(await keyId(key.publicKey), vector.kid, vector.name);
+  }
+});
+
+test("canonical envelopes sign and verify like Go", async () => {
+  for (const vector of envelopes) {
+    const envelope = JSON.parse(vector.envelope) as Envelope;
+    if (!usesCanonicalSigning(envelope.proto)) {
+      continue; // Legacy signing bytes are Go-specific
+    }
+    const seed = keys.find((key) => key.name === vector.key)?.seed ?? "";
+    const key = await keyPairFromSeed(fromHex(seed));
+
+    assert.equal(new TextDecoder().decode(signingBytes(envelope)), vector.signingInput, vector.name);
+    const verified = await verifyEnvelope(envelope, key.publicKey, { rawBody: rawBody(vector.envelope) }).then(
+      () => true,
+      () => false,
+    );
+    assert.equal(verified, vector.valid, vector.name);
+
+    if (vector.valid) {
+      const signature = await crypto.subtle.sign({ name: "Ed25519" }, key.privateKey, new TextEncoder().encode(vector.signingInput));
+      assert.equal(encodeBase64(new Uint8Array(signature)), vector.signature, vector.name);
+    }
+  }
+});
+
+test("signed envelopes round-trip through JSON", async () => {
+  const key = await generateKeyPair();
+  const envelope: TypedEnvelope<"toolCall"> = {
+    type: "toolCall",
+    agent: "ts-agent",
+    ts: 1700000000000,
+    nonce: "n-1",
+    body: { tool: "calc-agent/math.add", parameters: { b: 2.5, a: 1 }, requestId: "req-1" },
+  };
+  await signEnvelope(envelope, key);
+  assert.equal(envelope.kid, await keyId(key.publicKey));
+
+  const received = JSON.parse(JSON.stringify(envelope)) as Envelope;
+  await verifyEnvelope(received, key.publicKey);
+
+  (received.body as { parameters: Record<string, unknown> }).parameters.a = 2;
+  await assert.rejects(verifyEnvelope(received, key.publicKey), /signature verification failed/);
+
+  const other = await generateKeyPair();
+  await assert.rejects(verifyEnvelope(envelope, other.publicKey), /key ID does not match/);
+});
+
+test("detached signatures check the raw body", async () => {
+  const key = await generateKeyPair();
+  const body = `{"requestId":"req-1","success":true,"result":{"sum":3}}`;
+  const envelope: Envelope = { type: "toolResult", agent: "ts-agent", ts: 1700000000000, nonce: "n-2", body: JSON.parse(body) };
+  await signDetached(envelope, key, body);
+
+  assert.match(envelope.digest ?? "", /^sha-256=/);
+  await verifyEnvelope(envelope, key.publicKey, { rawBody: body });
+  await assert.rejects(verifyEnvelope(envelope, key.publicKey), /needs the raw body/);
+  await assert.rejects(verifyEnvelope(envelope, key.publicKey, { rawBody: body.replace("3", "4") }), /digest mismatch/);
+});
+
+test("canonicalize matches RFC 8785", () => {
+  assert.equal(canonicalize({ b: 2.5, a: 1e21, c: [true, null, -0], "€": "x", "\r": "<" }), `{"\\r":"<","a":1e+21,"b":2.5,"c":[true,null,0],"€":"x"}`);
+  assert.throws(() => canonicalize({ n: Number.NaN }), /not finite/);
+  assert.deepEqual(decodeBase64(encodeBase64(new Uint8Array([0, 255, 7]))), new Uint8Array([0, 255, 7]));
+});
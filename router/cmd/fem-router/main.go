@@ -2,6 +2,7 @@ package main
 
 import (
 	"bufio"
+	"crypto"
 	"crypto/rand"
 	"crypto/rsa"
 	"crypto/tls"
@@ -9,20 +10,52 @@ import (
 	"crypto/x509/pkix"
 	"encoding/json"
 	"encoding/pem"
+	"errors"
 	"flag"
+	"fmt"
+	"io"
 	"log"
 	"math/big"
 	"net"
+	"os"
 	"time"
+
+	"github.com/fep-fem/protocol"
 )
 
+// errEnvelopeTooLarge reports a line longer than the configured envelope limit
+var errEnvelopeTooLarge = errors.New("envelope too large")
+
 func main() {
 	// Parse command line flags
 	listenAddr := flag.String("listen", ":4433", "Address to listen on")
+	maxEnvelopeSize := flag.Int("max-envelope-size", 4<<20, "Largest envelope in bytes accepted on a connection")
+	identityKeyPath := flag.String("identity-key", os.Getenv("FEM_IDENTITY_KEY_FILE"), "File holding the router's Ed25519 TLS key, created if missing (ephemeral RSA key if empty)")
+	keyPassphraseFile := flag.String("key-passphrase-file", os.Getenv("FEM_KEY_PASSPHRASE_FILE"), "File holding the passphrase encrypting the identity key (FEM_KEY_PASSPHRASE if empty)")
 	flag.Parse()
 
+	// A persistent identity key keeps the certificate's public key stable across restarts
+	var key crypto.Signer
+	if *identityKeyPath != "" {
+		passphrase, err := protocol.ReadPassphrase(*keyPassphraseFile)
+		if err != nil {
+			log.Fatalf("Failed to read key passphrase: %v", err)
+		}
+		if passphrase == nil {
+			log.Printf("No key passphrase set; the identity key is stored unencrypted")
+		}
+		identityKey, created, err := protocol.LoadOrCreatePrivateKey(*identityKeyPath, passphrase)
+		if err != nil {
+			log.Fatalf("Failed to load identity key: %v", err)
+		}
+		if created {
+			log.Printf("Created identity key %s", *identityKeyPath)
+		}
+		key = identityKey
+	}
+
 	// Generate self-signed certificate
-	cert, err := generateSelfSignedCert()
+	cert, err := generateSelfSignedCert(key)
 	if err != nil {
 		log.Fatalf("Failed to generate certificate: %v", err)
 	}
@@ -30,6 +63,7 @@ func main() {
 	// Create TLS configuration
 	tlsConfig := &tls.Config{
 		Certificates: []tls.Certificate{cert},
+		NextProtos:   protocol.StreamProtocols(),
 	}
 
 	// Start TLS listener
@@ -50,18 +84,58 @@ func main() {
 		}
 
 		// Handle each connection in a goroutine
-		go handleConnection(conn)
+		go handleConnection(conn, *maxEnvelopeSize)
 	}
 }
 
-func handleConnection(conn net.Conn) {
+func handleConnection(conn net.Conn, maxEnvelopeSize int) {
 	defer conn.Close()
 
-	scanner := bufio.NewScanner(conn)
+	framing, err := protocol.NegotiateFraming(conn)
+	if err != nil {
+		log.Printf("Handshake with %s failed: %v", conn.RemoteAddr(), err)
+		return
+	}
+
+	reader := bufio.NewReader(conn)
 	writer := bufio.NewWriter(conn)
 
-	for scanner.Scan() {
-		line := scanner.Bytes()
+	// Replies use the framing the peer negotiated
+	read := func() ([]byte, error) { return readLine(reader, maxEnvelopeSize) }
+	write := func(data []byte) error {
+		if _, err := writer.Write(data); err != nil {
+			return err
+		}
+		return writer.WriteByte('\n')
+	}
+	if framing == protocol.FramingLengthPrefixed {
+		read = func() ([]byte, error) { return protocol.ReadFrame(reader, maxEnvelopeSize) }
+		write = func(data []byte) error { return protocol.WriteFrame(writer, data) }
+	}
+
+	for {
+		line, err := read()
+		if errors.Is(err, errEnvelopeTooLarge) || errors.Is(err, protocol.ErrEnvelopeTooLarge) {
+			// The oversized envelope was discarded; tell the sender and keep serving
+			log.Printf("Dropping envelope from %s: %v", conn.RemoteAddr(), err)
+			reply, _ := json.Marshal(map[string]string{"error": err.Error()})
+			if err := write(reply); err != nil {
+				log.Printf("Failed to write response: %v", err)
+				return
+			}
+			if err := writer.Flush(); err != nil {
+				log.Printf("Failed to flush: %v", err)
+				return
+			}
+			continue
+		}
+		if err == io.EOF {
+			return
+		}
+		if err != nil {
+			log.Printf("Read error: %v", err)
+			return
+		}
 
 		// Try to parse as JSON to validate
 		var jsonData map[string]interface{}
@@ -70,15 +144,11 @@ func handleConnection(conn net.Conn) {
 			continue
 		}
 
-		// Echo the line back
-		if _, err := writer.Write(line); err != nil {
+		// Echo the envelope back
+		if err := write(line); err != nil {
 			log.Printf("Failed to write response: %v", err)
 			return
 		}
-		if err := writer.WriteByte('\n'); err != nil {
-			log.Printf("Failed to write newline: %v", err)
-			return
-		}
 		if err := writer.Flush(); err != nil {
 			log.Printf("Failed to flush: %v", err)
 			return
@@ -86,17 +156,50 @@ func handleConnection(conn net.Conn) {
 
 		log.Printf("Echoed JSON: %s", string(line))
 	}
+}
+
+// readLine reads one newline-terminated line of at most maxSize bytes, without
+// the newline. Longer lines are consumed without being buffered and reported
+// as errEnvelopeTooLarge, so a hostile peer cannot exhaust memory.
+func readLine(reader *bufio.Reader, maxSize int) ([]byte, error) {
+	var line []byte
+	size := 0
+	for {
+		chunk, err := reader.ReadSlice('\n')
+		if err == nil {
+			chunk = chunk[:len(chunk)-1]
+		}
+		size += len(chunk)
+		if size <= maxSize {
+			line = append(line, chunk...)
+		}
+
+		if errors.Is(err, bufio.ErrBufferFull) {
+			continue
+		}
+		if err != nil && (err != io.EOF || size == 0) {
+			return nil, err
+		}
+		break
+	}
 
-	if err := scanner.Err(); err != nil {
-		log.Printf("Scanner error: %v", err)
+	if size > maxSize {
+		return nil, fmt.Errorf("%w: %d bytes exceeds limit of %d", errEnvelopeTooLarge, size, maxSize)
 	}
+	return line, nil
 }
 
-func generateSelfSignedCert() (tls.Certificate, error) {
-	// Generate RSA key
-	priv, err := rsa.GenerateKey(rand.Reader, 2048)
-	if err != nil {
-		return tls.Certificate{}, err
+// generateSelfSignedCert creates a certificate for key, or for a fresh RSA key if key is nil
+func generateSelfSignedCert(key crypto.Signer) (tls.Certificate, error) {
+	keyUsage := x509.KeyUsageDigitalSignature
+	if key == nil {
+		// Generate RSA key
+		priv, err := rsa.GenerateKey(rand.Reader, 2048)
+		if err != nil {
+			return tls.Certificate{}, err
+		}
+		key = priv
+		keyUsage |= x509.KeyUsageKeyEncipherment
 	}
 
 	// Create certificate template
@@ -107,7 +210,7 @@ func generateSelfSignedCert() (tls.Certificate, error) {
 		},
 		NotBefore:             time.Now(),
 		NotAfter:              time.Now().Add(365 * 24 * time.Hour),
-		KeyUsage:              x509.KeyUsageKeyEncipherment | x509.KeyUsageDigitalSignature,
+		KeyUsage:              keyUsage,
 		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
 		BasicConstraintsValid: true,
 		IPAddresses:           []net.IP{net.IPv4(127, 0, 0, 1)},
@@ -115,14 +218,18 @@ func generateSelfSignedCert() (tls.Certificate, error) {
 	}
 
 	// Generate certificate
-	certDER, err := x509.CreateCertificate(rand.Reader, &template, &template, &priv.PublicKey, priv)
+	certDER, err := x509.CreateCertificate(rand.Reader, &template, &template, key.Public(), key)
 	if err != nil {
 		return tls.Certificate{}, err
 	}
 
 	// Encode certificate and key
+	keyDER, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
 	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: certDER})
-	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(priv)})
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER})
 
 	// Create TLS certificate
 	return tls.X509KeyPair(certPEM, keyPEM)